@@ -67,13 +67,17 @@ func main() {
 
 	level.Info(util.Logger).Log("msg", "Starting Cortex", "version", version.Info())
 
-	if err := t.Run(); err != nil {
-		level.Error(util.Logger).Log("msg", "error running Cortex", "err", err)
+	runErr := t.Run()
+	if runErr != nil {
+		level.Error(util.Logger).Log("msg", "error running Cortex", "err", runErr)
 	}
 
 	runtime.KeepAlive(ballast)
 	err = t.Stop()
 	util.CheckFatal("initializing cortex", err)
+	if runErr != nil {
+		os.Exit(1)
+	}
 }
 
 // LoadConfig read YAML-formatted config from filename into cfg.
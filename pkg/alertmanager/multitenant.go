@@ -302,6 +302,10 @@ func (am *MultitenantAlertmanager) addNewConfigs(cfgs map[string]configs.View) {
 	for userID, config := range cfgs {
 		if config.IsDeleted() {
 			am.deleteUser(userID)
+			// The configurations of the deleted tenants are emptied.
+			if config.Config.AlertmanagerConfig == "" {
+				am.deleteUserState(userID)
+			}
 			continue
 		}
 		err := am.setConfig(userID, config.Config)
@@ -440,6 +444,20 @@ func (am *MultitenantAlertmanager) deleteUser(userID string) {
 	am.alertmanagersMtx.Unlock()
 }
 
+// deleteUserState removes the notification log, silences and templates of a
+// user from the data directory.
+func (am *MultitenantAlertmanager) deleteUserState(userID string) {
+	for _, path := range []string{
+		filepath.Join(am.cfg.DataDir, fmt.Sprintf("nflog:%s", userID)),
+		filepath.Join(am.cfg.DataDir, fmt.Sprintf("silences:%s", userID)),
+		filepath.Join(am.cfg.DataDir, "templates", userID),
+	} {
+		if err := os.RemoveAll(path); err != nil {
+			level.Warn(util.Logger).Log("msg", "MultitenantAlertmanager: error removing the state of a deleted user", "user", userID, "path", path, "err", err)
+		}
+	}
+}
+
 func (am *MultitenantAlertmanager) newAlertmanager(userID string, amConfig *amconfig.Config) (*Alertmanager, error) {
 	newAM, err := New(&Config{
 		UserID:      userID,
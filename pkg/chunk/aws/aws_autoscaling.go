@@ -16,7 +16,29 @@ import (
 )
 
 const (
-	autoScalingPolicyNamePrefix = "DynamoScalingPolicy_cortex_"
+	autoScalingPolicyNamePrefix     = "DynamoScalingPolicy_cortex_"
+	readAutoScalingPolicyNamePrefix = "DynamoReadScalingPolicy_cortex_"
+)
+
+// scalingDimension is a capacity of the tables scaled by Application Auto
+// Scaling.
+type scalingDimension struct {
+	dimension        string
+	metricType       string
+	policyNamePrefix string
+}
+
+var (
+	writeDimension = scalingDimension{
+		dimension:        "dynamodb:table:WriteCapacityUnits",
+		metricType:       "DynamoDBWriteCapacityUtilization",
+		policyNamePrefix: autoScalingPolicyNamePrefix,
+	}
+	readDimension = scalingDimension{
+		dimension:        "dynamodb:table:ReadCapacityUnits",
+		metricType:       "DynamoDBReadCapacityUtilization",
+		policyNamePrefix: readAutoScalingPolicyNamePrefix,
+	}
 )
 
 var applicationAutoScalingRequestDuration = instrument.NewHistogramCollector(prometheus.NewHistogramVec(prometheus.HistogramOpts{
@@ -51,17 +73,29 @@ func newAWSAutoscale(cfg DynamoDBConfig, callManager callManager) (*awsAutoscale
 
 func (a *awsAutoscale) PostCreateTable(ctx context.Context, desc chunk.TableDesc) error {
 	if desc.WriteScale.Enabled {
-		return a.enableAutoScaling(ctx, desc)
+		if err := a.enableAutoScaling(ctx, desc.Name, writeDimension, desc.WriteScale); err != nil {
+			return err
+		}
+	}
+	if desc.ReadScale.Enabled {
+		return a.enableAutoScaling(ctx, desc.Name, readDimension, desc.ReadScale)
 	}
 	return nil
 }
 
 func (a *awsAutoscale) DescribeTable(ctx context.Context, desc *chunk.TableDesc) error {
+	if err := a.describeAutoScaling(ctx, desc.Name, writeDimension, &desc.WriteScale); err != nil {
+		return err
+	}
+	return a.describeAutoScaling(ctx, desc.Name, readDimension, &desc.ReadScale)
+}
+
+func (a *awsAutoscale) describeAutoScaling(ctx context.Context, tableName string, dim scalingDimension, scale *chunk.AutoScalingConfig) error {
 	err := a.call.backoffAndRetry(ctx, func(ctx context.Context) error {
 		return instrument.CollectedRequest(ctx, "ApplicationAutoScaling.DescribeScalableTargetsWithContext", applicationAutoScalingRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 			out, err := a.ApplicationAutoScaling.DescribeScalableTargetsWithContext(ctx, &applicationautoscaling.DescribeScalableTargetsInput{
-				ResourceIds:       []*string{aws.String("table/" + desc.Name)},
-				ScalableDimension: aws.String("dynamodb:table:WriteCapacityUnits"),
+				ResourceIds:       []*string{aws.String("table/" + tableName)},
+				ScalableDimension: aws.String(dim.dimension),
 				ServiceNamespace:  aws.String("dynamodb"),
 			})
 			if err != nil {
@@ -71,16 +105,16 @@ func (a *awsAutoscale) DescribeTable(ctx context.Context, desc *chunk.TableDesc)
 			case 0:
 				return err
 			case 1:
-				desc.WriteScale.Enabled = true
+				scale.Enabled = true
 				if target := out.ScalableTargets[0]; target != nil {
 					if target.RoleARN != nil {
-						desc.WriteScale.RoleARN = *target.RoleARN
+						scale.RoleARN = *target.RoleARN
 					}
 					if target.MinCapacity != nil {
-						desc.WriteScale.MinCapacity = *target.MinCapacity
+						scale.MinCapacity = *target.MinCapacity
 					}
 					if target.MaxCapacity != nil {
-						desc.WriteScale.MaxCapacity = *target.MaxCapacity
+						scale.MaxCapacity = *target.MaxCapacity
 					}
 				}
 				return err
@@ -96,9 +130,9 @@ func (a *awsAutoscale) DescribeTable(ctx context.Context, desc *chunk.TableDesc)
 	err = a.call.backoffAndRetry(ctx, func(ctx context.Context) error {
 		return instrument.CollectedRequest(ctx, "ApplicationAutoScaling.DescribeScalingPoliciesWithContext", applicationAutoScalingRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 			out, err := a.ApplicationAutoScaling.DescribeScalingPoliciesWithContext(ctx, &applicationautoscaling.DescribeScalingPoliciesInput{
-				PolicyNames:       []*string{aws.String(autoScalingPolicyNamePrefix + desc.Name)},
-				ResourceId:        aws.String("table/" + desc.Name),
-				ScalableDimension: aws.String("dynamodb:table:WriteCapacityUnits"),
+				PolicyNames:       []*string{aws.String(dim.policyNamePrefix + tableName)},
+				ResourceId:        aws.String("table/" + tableName),
+				ScalableDimension: aws.String(dim.dimension),
 				ServiceNamespace:  aws.String("dynamodb"),
 			})
 			if err != nil {
@@ -111,13 +145,13 @@ func (a *awsAutoscale) DescribeTable(ctx context.Context, desc *chunk.TableDesc)
 				config := out.ScalingPolicies[0].TargetTrackingScalingPolicyConfiguration
 				if config != nil {
 					if config.ScaleInCooldown != nil {
-						desc.WriteScale.InCooldown = *config.ScaleInCooldown
+						scale.InCooldown = *config.ScaleInCooldown
 					}
 					if config.ScaleOutCooldown != nil {
-						desc.WriteScale.OutCooldown = *config.ScaleOutCooldown
+						scale.OutCooldown = *config.ScaleOutCooldown
 					}
 					if config.TargetValue != nil {
-						desc.WriteScale.TargetValue = *config.TargetValue
+						scale.TargetValue = *config.TargetValue
 					}
 				}
 				return err
@@ -130,34 +164,41 @@ func (a *awsAutoscale) DescribeTable(ctx context.Context, desc *chunk.TableDesc)
 }
 
 func (a *awsAutoscale) UpdateTable(ctx context.Context, current chunk.TableDesc, expected *chunk.TableDesc) error {
+	if err := a.updateAutoScaling(ctx, expected.Name, writeDimension, current.WriteScale, expected.WriteScale); err != nil {
+		return err
+	}
+	return a.updateAutoScaling(ctx, expected.Name, readDimension, current.ReadScale, expected.ReadScale)
+}
+
+func (a *awsAutoscale) updateAutoScaling(ctx context.Context, tableName string, dim scalingDimension, current, expected chunk.AutoScalingConfig) error {
 	var err error
-	if !current.WriteScale.Enabled {
-		if expected.WriteScale.Enabled {
-			level.Info(util.Logger).Log("msg", "enabling autoscaling on table", "table")
-			err = a.enableAutoScaling(ctx, *expected)
+	if !current.Enabled {
+		if expected.Enabled {
+			level.Info(util.Logger).Log("msg", "enabling autoscaling on table", "table", tableName, "dimension", dim.dimension)
+			err = a.enableAutoScaling(ctx, tableName, dim, expected)
 		}
 	} else {
-		if !expected.WriteScale.Enabled {
-			level.Info(util.Logger).Log("msg", "disabling autoscaling on table", "table")
-			err = a.disableAutoScaling(ctx, *expected)
-		} else if current.WriteScale != expected.WriteScale {
-			level.Info(util.Logger).Log("msg", "enabling autoscaling on table", "table")
-			err = a.enableAutoScaling(ctx, *expected)
+		if !expected.Enabled {
+			level.Info(util.Logger).Log("msg", "disabling autoscaling on table", "table", tableName, "dimension", dim.dimension)
+			err = a.disableAutoScaling(ctx, tableName, dim)
+		} else if current != expected {
+			level.Info(util.Logger).Log("msg", "enabling autoscaling on table", "table", tableName, "dimension", dim.dimension)
+			err = a.enableAutoScaling(ctx, tableName, dim, expected)
 		}
 	}
 	return err
 }
 
-func (a *awsAutoscale) enableAutoScaling(ctx context.Context, desc chunk.TableDesc) error {
+func (a *awsAutoscale) enableAutoScaling(ctx context.Context, tableName string, dim scalingDimension, scale chunk.AutoScalingConfig) error {
 	// Registers or updates a scalable target
 	if err := a.call.backoffAndRetry(ctx, func(ctx context.Context) error {
 		return instrument.CollectedRequest(ctx, "ApplicationAutoScaling.RegisterScalableTarget", applicationAutoScalingRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 			input := &applicationautoscaling.RegisterScalableTargetInput{
-				MinCapacity:       aws.Int64(desc.WriteScale.MinCapacity),
-				MaxCapacity:       aws.Int64(desc.WriteScale.MaxCapacity),
-				ResourceId:        aws.String("table/" + desc.Name),
-				RoleARN:           aws.String(desc.WriteScale.RoleARN),
-				ScalableDimension: aws.String("dynamodb:table:WriteCapacityUnits"),
+				MinCapacity:       aws.Int64(scale.MinCapacity),
+				MaxCapacity:       aws.Int64(scale.MaxCapacity),
+				ResourceId:        aws.String("table/" + tableName),
+				RoleARN:           aws.String(scale.RoleARN),
+				ScalableDimension: aws.String(dim.dimension),
 				ServiceNamespace:  aws.String("dynamodb"),
 			}
 			_, err := a.ApplicationAutoScaling.RegisterScalableTarget(input)
@@ -174,18 +215,18 @@ func (a *awsAutoscale) enableAutoScaling(ctx context.Context, desc chunk.TableDe
 	return a.call.backoffAndRetry(ctx, func(ctx context.Context) error {
 		return instrument.CollectedRequest(ctx, "ApplicationAutoScaling.PutScalingPolicy", applicationAutoScalingRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 			input := &applicationautoscaling.PutScalingPolicyInput{
-				PolicyName:        aws.String(autoScalingPolicyNamePrefix + desc.Name),
+				PolicyName:        aws.String(dim.policyNamePrefix + tableName),
 				PolicyType:        aws.String("TargetTrackingScaling"),
-				ResourceId:        aws.String("table/" + desc.Name),
-				ScalableDimension: aws.String("dynamodb:table:WriteCapacityUnits"),
+				ResourceId:        aws.String("table/" + tableName),
+				ScalableDimension: aws.String(dim.dimension),
 				ServiceNamespace:  aws.String("dynamodb"),
 				TargetTrackingScalingPolicyConfiguration: &applicationautoscaling.TargetTrackingScalingPolicyConfiguration{
 					PredefinedMetricSpecification: &applicationautoscaling.PredefinedMetricSpecification{
-						PredefinedMetricType: aws.String("DynamoDBWriteCapacityUtilization"),
+						PredefinedMetricType: aws.String(dim.metricType),
 					},
-					ScaleInCooldown:  aws.Int64(desc.WriteScale.InCooldown),
-					ScaleOutCooldown: aws.Int64(desc.WriteScale.OutCooldown),
-					TargetValue:      aws.Float64(desc.WriteScale.TargetValue),
+					ScaleInCooldown:  aws.Int64(scale.InCooldown),
+					ScaleOutCooldown: aws.Int64(scale.OutCooldown),
+					TargetValue:      aws.Float64(scale.TargetValue),
 				},
 			}
 			_, err := a.ApplicationAutoScaling.PutScalingPolicy(input)
@@ -194,13 +235,13 @@ func (a *awsAutoscale) enableAutoScaling(ctx context.Context, desc chunk.TableDe
 	})
 }
 
-func (a *awsAutoscale) disableAutoScaling(ctx context.Context, desc chunk.TableDesc) error {
+func (a *awsAutoscale) disableAutoScaling(ctx context.Context, tableName string, dim scalingDimension) error {
 	// Deregister scalable target
 	if err := a.call.backoffAndRetry(ctx, func(ctx context.Context) error {
 		return instrument.CollectedRequest(ctx, "ApplicationAutoScaling.DeregisterScalableTarget", applicationAutoScalingRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 			input := &applicationautoscaling.DeregisterScalableTargetInput{
-				ResourceId:        aws.String("table/" + desc.Name),
-				ScalableDimension: aws.String("dynamodb:table:WriteCapacityUnits"),
+				ResourceId:        aws.String("table/" + tableName),
+				ScalableDimension: aws.String(dim.dimension),
 				ServiceNamespace:  aws.String("dynamodb"),
 			}
 			_, err := a.ApplicationAutoScaling.DeregisterScalableTarget(input)
@@ -214,9 +255,9 @@ func (a *awsAutoscale) disableAutoScaling(ctx context.Context, desc chunk.TableD
 	return a.call.backoffAndRetry(ctx, func(ctx context.Context) error {
 		return instrument.CollectedRequest(ctx, "ApplicationAutoScaling.DeleteScalingPolicy", applicationAutoScalingRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 			input := &applicationautoscaling.DeleteScalingPolicyInput{
-				PolicyName:        aws.String(autoScalingPolicyNamePrefix + desc.Name),
-				ResourceId:        aws.String("table/" + desc.Name),
-				ScalableDimension: aws.String("dynamodb:table:WriteCapacityUnits"),
+				PolicyName:        aws.String(dim.policyNamePrefix + tableName),
+				ResourceId:        aws.String("table/" + tableName),
+				ScalableDimension: aws.String(dim.dimension),
 				ServiceNamespace:  aws.String("dynamodb"),
 			}
 			_, err := a.ApplicationAutoScaling.DeleteScalingPolicy(input)
@@ -104,6 +104,7 @@ type DynamoDBConfig struct {
 	Metrics                MetricsAutoScalingConfig
 	ChunkGangSize          int
 	ChunkGetMaxParallelism int
+	ChunkFetch             chunk_util.FetchConfig
 	backoffConfig          util.BackoffConfig
 }
 
@@ -116,6 +117,7 @@ func (cfg *DynamoDBConfig) RegisterFlags(f *flag.FlagSet) {
 	f.Var(&cfg.ApplicationAutoScaling, "applicationautoscaling.url", "ApplicationAutoscaling endpoint URL with escaped Key and Secret encoded.")
 	f.IntVar(&cfg.ChunkGangSize, "dynamodb.chunk.gang.size", 10, "Number of chunks to group together to parallelise fetches (zero to disable)")
 	f.IntVar(&cfg.ChunkGetMaxParallelism, "dynamodb.chunk.get.max.parallelism", 32, "Max number of chunk-get operations to start in parallel")
+	cfg.ChunkFetch.RegisterMaxParallelismFlagWithPrefix("dynamodb", f)
 	f.DurationVar(&cfg.backoffConfig.MinBackoff, "dynamodb.min-backoff", 100*time.Millisecond, "Minimum backoff time")
 	f.DurationVar(&cfg.backoffConfig.MaxBackoff, "dynamodb.max-backoff", 50*time.Second, "Maximum backoff time")
 	f.IntVar(&cfg.backoffConfig.MaxRetries, "dynamodb.max-retries", 20, "Maximum number of times to retry an operation")
@@ -128,6 +130,10 @@ type StorageConfig struct {
 	S3               flagext.URLValue
 	BucketNames      string
 	S3ForcePathStyle bool
+	S3WriteOptions
+	S3ChunkFetch chunk_util.FetchConfig
+	S3Backoff    util.BackoffConfig
+	S3Hedging    chunk_util.HedgingConfig
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet
@@ -138,6 +144,10 @@ func (cfg *StorageConfig) RegisterFlags(f *flag.FlagSet) {
 		"If only region is specified as a host, proper endpoint will be deduced. Use inmemory:///<bucket-name> to use a mock in-memory implementation.")
 	f.BoolVar(&cfg.S3ForcePathStyle, "s3.force-path-style", false, "Set this to `true` to force the request to use path-style addressing.")
 	f.StringVar(&cfg.BucketNames, "s3.buckets", "", "Comma separated list of bucket names to evenly distribute chunks over. Overrides any buckets specified in s3.url flag")
+	cfg.S3WriteOptions.RegisterFlags(f)
+	cfg.S3ChunkFetch.RegisterFlagsWithPrefix("s3", f)
+	cfg.S3Backoff.RegisterFlags("s3", f)
+	cfg.S3Hedging.RegisterFlagsWithPrefix("s3", f)
 }
 
 type dynamoDBStorageClient struct {
@@ -145,6 +155,8 @@ type dynamoDBStorageClient struct {
 	schemaCfg chunk.SchemaConfig
 
 	DynamoDB dynamodbiface.DynamoDBAPI
+	// The fetcher limits the chunk requests in flight across all the queries.
+	fetcher *chunk_util.ParallelFetcher
 	// These rate-limiters let us slow down when DynamoDB signals provision limits.
 	writeThrottle *rate.Limiter
 
@@ -176,6 +188,7 @@ func newDynamoDBStorageClient(cfg DynamoDBConfig, schemaCfg chunk.SchemaConfig)
 		cfg:           cfg,
 		schemaCfg:     schemaCfg,
 		DynamoDB:      dynamoDB,
+		fetcher:       chunk_util.NewParallelFetcher("dynamodb", cfg.ChunkFetch),
 		writeThrottle: rate.NewLimiter(rate.Limit(cfg.ThrottleLimit), dynamoDBMaxWriteBatchSize),
 	}
 	client.queryRequestFn = client.queryRequest
@@ -197,7 +210,12 @@ func logWriteRetry(ctx context.Context, unprocessed dynamoDBWriteBatch) {
 	for table, reqs := range unprocessed {
 		dynamoThrottled.WithLabelValues("DynamoDB.BatchWriteItem", table).Add(float64(len(reqs)))
 		for _, req := range reqs {
-			item := req.PutRequest.Item
+			var item map[string]*dynamodb.AttributeValue
+			if req.PutRequest != nil {
+				item = req.PutRequest.Item
+			} else {
+				item = req.DeleteRequest.Key
+			}
 			var hash, rnge string
 			if hashAttr, ok := item[hashKey]; ok {
 				if hashAttr.S != nil {
@@ -288,6 +306,63 @@ func (a dynamoDBStorageClient) BatchWrite(ctx context.Context, input chunk.Write
 	return backoff.Err()
 }
 
+// DeleteEntries implements chunk.IndexDeleter.
+func (a dynamoDBStorageClient) DeleteEntries(ctx context.Context, entries []chunk.IndexEntry) error {
+	deletes := dynamoDBWriteBatch{}
+	for _, entry := range entries {
+		deletes.delete(entry.TableName, entry.HashValue, entry.RangeValue)
+	}
+	return a.BatchWrite(ctx, deletes)
+}
+
+// ScanTable implements chunk.IndexScanner.
+func (a dynamoDBStorageClient) ScanTable(ctx context.Context, tableName string, callback func(chunk.IndexEntry) bool) error {
+	input := &dynamodb.ScanInput{
+		TableName:              aws.String(tableName),
+		ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+	}
+	return instrument.CollectedRequest(ctx, "DynamoDB.Scan", dynamoRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		err := a.DynamoDB.ScanPagesWithContext(ctx, input, func(output *dynamodb.ScanOutput, _ bool) bool {
+			if cc := output.ConsumedCapacity; cc != nil {
+				dynamoConsumedCapacity.WithLabelValues("DynamoDB.Scan", tableName).
+					Add(float64(*cc.CapacityUnits))
+			}
+
+			for _, item := range output.Items {
+				entry := chunk.IndexEntry{
+					TableName:  tableName,
+					HashValue:  aws.StringValue(item[hashKey].S),
+					RangeValue: item[rangeKey].B,
+				}
+				if value, ok := item[valueKey]; ok {
+					entry.Value = value.B
+				}
+				if !callback(entry) {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			recordDynamoError(tableName, err, "DynamoDB.Scan")
+			return fmt.Errorf("Scan error: table=%v, err=%v", tableName, err)
+		}
+		return nil
+	})
+}
+
+// DeleteChunk implements chunk.ObjectDeleter.
+func (a dynamoDBStorageClient) DeleteChunk(ctx context.Context, c chunk.Chunk) error {
+	table, err := a.schemaCfg.ChunkTableFor(c.From)
+	if err != nil {
+		return err
+	}
+
+	deletes := dynamoDBWriteBatch{}
+	deletes.delete(table, c.ExternalKey(), placeholder)
+	return a.BatchWrite(ctx, deletes)
+}
+
 // QueryPages implements chunk.IndexClient.
 func (a dynamoDBStorageClient) QueryPages(ctx context.Context, queries []chunk.IndexQuery, callback func(chunk.IndexQuery, chunk.ReadBatch) bool) error {
 	return chunk_util.DoParallelQueries(ctx, a.query, queries, callback)
@@ -546,9 +621,14 @@ func (a dynamoDBStorageClient) getDynamoDBChunks(ctx context.Context, chunks []c
 			ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
 		})
 
-		err := instrument.CollectedRequest(ctx, "DynamoDB.BatchGetItemPages", dynamoRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		release, err := a.fetcher.Acquire(ctx)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		err = instrument.CollectedRequest(ctx, "DynamoDB.BatchGetItemPages", dynamoRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 			return request.Send()
 		})
+		release()
 		response := request.Data().(*dynamodb.BatchGetItemOutput)
 
 		for _, cc := range response.ConsumedCapacity {
@@ -755,6 +835,17 @@ func (b dynamoDBWriteBatch) Add(tableName, hashValue string, rangeValue []byte,
 	})
 }
 
+func (b dynamoDBWriteBatch) delete(tableName, hashValue string, rangeValue []byte) {
+	b[tableName] = append(b[tableName], &dynamodb.WriteRequest{
+		DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				hashKey:  {S: aws.String(hashValue)},
+				rangeKey: {B: rangeValue},
+			},
+		},
+	})
+}
+
 // Fill 'b' with WriteRequests from 'from' until 'b' has at most max requests. Remove those requests from 'from'.
 func (b dynamoDBWriteBatch) TakeReqs(from dynamoDBWriteBatch, max int) {
 	outLen, inLen := b.Len(), from.Len()
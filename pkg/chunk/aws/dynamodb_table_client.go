@@ -151,10 +151,14 @@ func (d dynamoTableClient) CreateTable(ctx context.Context, desc chunk.TableDesc
 						KeyType:       aws.String(dynamodb.KeyTypeRange),
 					},
 				},
-				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			}
+			if desc.UseOnDemandIOMode {
+				input.BillingMode = aws.String(dynamodb.BillingModePayPerRequest)
+			} else {
+				input.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
 					ReadCapacityUnits:  aws.Int64(desc.ProvisionedRead),
 					WriteCapacityUnits: aws.Int64(desc.ProvisionedWrite),
-				},
+				}
 			}
 			output, err := d.DynamoDB.CreateTableWithContext(ctx, input)
 			if err != nil {
@@ -169,7 +173,9 @@ func (d dynamoTableClient) CreateTable(ctx context.Context, desc chunk.TableDesc
 		return err
 	}
 
-	if d.autoscale != nil {
+	// The capacity of the tables in on-demand mode isn't provisioned, so they
+	// can't be autoscaled.
+	if d.autoscale != nil && !desc.UseOnDemandIOMode {
 		err := d.autoscale.PostCreateTable(ctx, desc)
 		if err != nil {
 			return err
@@ -282,6 +288,16 @@ func relevantError(err error) bool {
 }
 
 func (d dynamoTableClient) UpdateTable(ctx context.Context, current, expected chunk.TableDesc) error {
+	// The tables in on-demand mode have to be provisioned before being
+	// autoscaled.
+	if current.UseOnDemandIOMode && !expected.UseOnDemandIOMode && (expected.WriteScale.Enabled || expected.ReadScale.Enabled) {
+		if err := d.updateProvisionedThroughput(ctx, current, expected); err != nil {
+			return err
+		}
+		current.UseOnDemandIOMode = false
+		current.ProvisionedRead, current.ProvisionedWrite = expected.ProvisionedRead, expected.ProvisionedWrite
+	}
+
 	if d.autoscale != nil {
 		err := d.autoscale.UpdateTable(ctx, current, &expected)
 		if err != nil {
@@ -298,34 +314,8 @@ func (d dynamoTableClient) UpdateTable(ctx context.Context, current, expected ch
 	if (current.ProvisionedRead != expected.ProvisionedRead ||
 		current.ProvisionedWrite != expected.ProvisionedWrite) &&
 		!expected.UseOnDemandIOMode {
-		level.Info(util.Logger).Log("msg", "updating provisioned throughput on table", "table", expected.Name, "old_read", current.ProvisionedRead, "old_write", current.ProvisionedWrite, "new_read", expected.ProvisionedRead, "new_write", expected.ProvisionedWrite)
-		if err := d.backoffAndRetry(ctx, func(ctx context.Context) error {
-			return instrument.CollectedRequest(ctx, "DynamoDB.UpdateTable", dynamoRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
-				var dynamoBillingMode string
-				updateTableInput := &dynamodb.UpdateTableInput{TableName: aws.String(expected.Name),
-					ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-						ReadCapacityUnits:  aws.Int64(expected.ProvisionedRead),
-						WriteCapacityUnits: aws.Int64(expected.ProvisionedWrite),
-					},
-				}
-				// we need this to be a separate check for the billing mode, as aws returns
-				// an error if we set a table to the billing mode it is currently on.
-				if current.UseOnDemandIOMode != expected.UseOnDemandIOMode {
-					dynamoBillingMode = dynamodb.BillingModeProvisioned
-					level.Info(util.Logger).Log("msg", "updating billing mode on table", "table", expected.Name, "old_mode", current.UseOnDemandIOMode, "new_mode", expected.UseOnDemandIOMode)
-					updateTableInput.BillingMode = aws.String(dynamoBillingMode)
-				}
-
-				_, err := d.DynamoDB.UpdateTableWithContext(ctx, updateTableInput)
-				return err
-			})
-		}); err != nil {
-			recordDynamoError(expected.Name, err, "DynamoDB.UpdateTable")
-			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "LimitExceededException" {
-				level.Warn(util.Logger).Log("msg", "update limit exceeded", "err", err)
-			} else {
-				return err
-			}
+		if err := d.updateProvisionedThroughput(ctx, current, expected); err != nil {
+			return err
 		}
 	} else if expected.UseOnDemandIOMode && current.UseOnDemandIOMode != expected.UseOnDemandIOMode {
 		// moved the enabling of OnDemand mode to it's own block to reduce complexities & interactions with the various
@@ -381,3 +371,38 @@ func (d dynamoTableClient) UpdateTable(ctx context.Context, current, expected ch
 	}
 	return nil
 }
+
+// updateProvisionedThroughput provisions the expected throughput of a table,
+// switching it to provisioned mode if it's in on-demand mode.
+func (d dynamoTableClient) updateProvisionedThroughput(ctx context.Context, current, expected chunk.TableDesc) error {
+	level.Info(util.Logger).Log("msg", "updating provisioned throughput on table", "table", expected.Name, "old_read", current.ProvisionedRead, "old_write", current.ProvisionedWrite, "new_read", expected.ProvisionedRead, "new_write", expected.ProvisionedWrite)
+	if err := d.backoffAndRetry(ctx, func(ctx context.Context) error {
+		return instrument.CollectedRequest(ctx, "DynamoDB.UpdateTable", dynamoRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+			var dynamoBillingMode string
+			updateTableInput := &dynamodb.UpdateTableInput{TableName: aws.String(expected.Name),
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(expected.ProvisionedRead),
+					WriteCapacityUnits: aws.Int64(expected.ProvisionedWrite),
+				},
+			}
+			// we need this to be a separate check for the billing mode, as aws returns
+			// an error if we set a table to the billing mode it is currently on.
+			if current.UseOnDemandIOMode != expected.UseOnDemandIOMode {
+				dynamoBillingMode = dynamodb.BillingModeProvisioned
+				level.Info(util.Logger).Log("msg", "updating billing mode on table", "table", expected.Name, "old_mode", current.UseOnDemandIOMode, "new_mode", expected.UseOnDemandIOMode)
+				updateTableInput.BillingMode = aws.String(dynamoBillingMode)
+			}
+
+			_, err := d.DynamoDB.UpdateTableWithContext(ctx, updateTableInput)
+			return err
+		})
+	}); err != nil {
+		recordDynamoError(expected.Name, err, "DynamoDB.UpdateTable")
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "LimitExceededException" {
+			level.Warn(util.Logger).Log("msg", "update limit exceeded", "err", err)
+		} else {
+			return err
+		}
+	}
+	return nil
+}
@@ -167,7 +167,7 @@ func TestTableManagerAutoScaling(t *testing.T) {
 
 	// Check tables are created with autoscale
 	{
-		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil)
+		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -186,7 +186,7 @@ func TestTableManagerAutoScaling(t *testing.T) {
 		tbm.IndexTables.WriteScale.OutCooldown = 200
 		tbm.ChunkTables.WriteScale.TargetValue = 90.0
 
-		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil)
+		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -205,7 +205,7 @@ func TestTableManagerAutoScaling(t *testing.T) {
 		tbm.IndexTables.WriteScale.OutCooldown = 200
 		tbm.ChunkTables.WriteScale.TargetValue = 90.0
 
-		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil)
+		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -225,7 +225,7 @@ func TestTableManagerAutoScaling(t *testing.T) {
 		tbm.IndexTables.WriteScale.Enabled = false
 		tbm.ChunkTables.WriteScale.Enabled = false
 
-		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil)
+		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -270,7 +270,7 @@ func TestTableManagerInactiveAutoScaling(t *testing.T) {
 
 	// Check legacy and latest tables do not autoscale with inactive autoscale enabled.
 	{
-		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil)
+		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -286,7 +286,7 @@ func TestTableManagerInactiveAutoScaling(t *testing.T) {
 
 	// Check inactive tables are autoscaled even if there are less than the limit.
 	{
-		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil)
+		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -303,7 +303,7 @@ func TestTableManagerInactiveAutoScaling(t *testing.T) {
 
 	// Check inactive tables past the limit do not autoscale but the latest N do.
 	{
-		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil)
+		tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -321,6 +321,137 @@ func TestTableManagerInactiveAutoScaling(t *testing.T) {
 	}
 }
 
+func TestTableManagerReadAutoScaling(t *testing.T) {
+	dynamoDB := newMockDynamoDB(0, 0)
+	applicationAutoScaling := newMockApplicationAutoScaling()
+	client := dynamoTableClient{
+		DynamoDB:  dynamoDB,
+		autoscale: &awsAutoscale{ApplicationAutoScaling: applicationAutoScaling},
+	}
+
+	cfg := chunk.SchemaConfig{
+		Configs: []chunk.PeriodConfig{
+			{
+				IndexType: "aws-dynamo",
+			},
+			{
+				IndexType:   "aws-dynamo",
+				From:        chunk.DayTime{Time: model.TimeFromUnix(0)},
+				IndexTables: fixturePeriodicTableConfig(tablePrefix),
+				ChunkTables: fixturePeriodicTableConfig(chunkTablePrefix),
+			}},
+	}
+	tbm := chunk.TableManagerConfig{
+		CreationGracePeriod: gracePeriod,
+		IndexTables:         fixtureReadProvisionConfig(fixtureReadScale(), chunk.AutoScalingConfig{}),
+		ChunkTables:         fixtureReadProvisionConfig(fixtureReadScale(), chunk.AutoScalingConfig{}),
+	}
+	readScaledTable := func(i int, provisionedRead, provisionedWrite int64) []chunk.TableDesc {
+		tables := staticTable(i, provisionedRead, provisionedWrite, provisionedRead, provisionedWrite)
+		for j := range tables {
+			tables[j].ReadScale = fixtureReadScale()
+		}
+		return tables
+	}
+
+	tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
+	require.NoError(t, err)
+
+	// Check tables are created with read autoscaling
+	test(t, client,
+		tableManager,
+		"Create tables",
+		time.Unix(0, 0).Add(maxChunkAge).Add(gracePeriod),
+		append(baseTable("", inactiveRead, inactiveWrite),
+			readScaledTable(0, read, write)...),
+	)
+	require.Contains(t, applicationAutoScaling.scalableTargets, "table/"+tablePrefix+"0"+readDimension.dimension)
+	require.NotContains(t, applicationAutoScaling.scalableTargets, "table/"+tablePrefix+"0"+writeDimension.dimension)
+
+	// Check read autoscaling is disabled for inactive tables
+	test(t, client,
+		tableManager,
+		"Inactive tables",
+		time.Unix(0, 0).Add(tablePeriod).Add(maxChunkAge).Add(gracePeriod),
+		append(append(baseTable("", inactiveRead, inactiveWrite),
+			staticTable(0, inactiveRead, inactiveWrite, inactiveRead, inactiveWrite)...),
+			readScaledTable(1, read, write)...),
+	)
+	require.NotContains(t, applicationAutoScaling.scalableTargets, "table/"+tablePrefix+"0"+readDimension.dimension)
+}
+
+func TestTableManagerOnDemandMode(t *testing.T) {
+	dynamoDB := newMockDynamoDB(0, 0)
+	applicationAutoScaling := newMockApplicationAutoScaling()
+	client := dynamoTableClient{
+		DynamoDB:  dynamoDB,
+		autoscale: &awsAutoscale{ApplicationAutoScaling: applicationAutoScaling},
+	}
+
+	cfg := chunk.SchemaConfig{
+		Configs: []chunk.PeriodConfig{
+			{
+				IndexType: "aws-dynamo",
+			},
+			{
+				IndexType:   "aws-dynamo",
+				From:        chunk.DayTime{Time: model.TimeFromUnix(0)},
+				IndexTables: fixturePeriodicTableConfig(tablePrefix),
+				ChunkTables: fixturePeriodicTableConfig(chunkTablePrefix),
+			}},
+	}
+	tbm := chunk.TableManagerConfig{
+		CreationGracePeriod: gracePeriod,
+		IndexTables:         fixtureProvisionConfig(1, chunk.AutoScalingConfig{}, fixtureWriteScale()),
+		ChunkTables:         fixtureProvisionConfig(0, chunk.AutoScalingConfig{}, chunk.AutoScalingConfig{}),
+	}
+	tbm.IndexTables.ProvisionedThroughputOnDemandMode = true
+	tbm.ChunkTables.ProvisionedThroughputOnDemandMode = true
+	onDemandTable := func(i int) []chunk.TableDesc {
+		return []chunk.TableDesc{
+			{Name: tablePrefix + fmt.Sprint(i), UseOnDemandIOMode: true},
+			{Name: chunkTablePrefix + fmt.Sprint(i), UseOnDemandIOMode: true},
+		}
+	}
+
+	tableManager, err := chunk.NewTableManager(tbm, cfg, maxChunkAge, client, nil, nil, nil)
+	require.NoError(t, err)
+
+	// Check the active tables are created in on-demand mode
+	test(t, client,
+		tableManager,
+		"Create tables",
+		time.Unix(0, 0).Add(maxChunkAge).Add(gracePeriod),
+		append(baseTable("", inactiveRead, inactiveWrite),
+			onDemandTable(0)...),
+	)
+	require.True(t, dynamoDB.tables[tablePrefix+"0"].onDemand)
+
+	// Check the inactive tables are provisioned, and autoscaled for the index
+	test(t, client,
+		tableManager,
+		"Inactive tables",
+		time.Unix(0, 0).Add(tablePeriod).Add(maxChunkAge).Add(gracePeriod),
+		append(append(baseTable("", inactiveRead, inactiveWrite),
+			chunk.TableDesc{Name: tablePrefix + "0", ProvisionedRead: inactiveRead, ProvisionedWrite: inactiveWrite, WriteScale: fixtureWriteScale()},
+			chunk.TableDesc{Name: chunkTablePrefix + "0", ProvisionedRead: inactiveRead, ProvisionedWrite: inactiveWrite}),
+			onDemandTable(1)...),
+	)
+	require.False(t, dynamoDB.tables[tablePrefix+"0"].onDemand)
+	require.False(t, dynamoDB.tables[chunkTablePrefix+"0"].onDemand)
+
+	// Check the tables are left alone once in the expected mode
+	test(t, client,
+		tableManager,
+		"Tables in the expected mode",
+		time.Unix(0, 0).Add(tablePeriod).Add(maxChunkAge).Add(gracePeriod),
+		append(append(baseTable("", inactiveRead, inactiveWrite),
+			chunk.TableDesc{Name: tablePrefix + "0", ProvisionedRead: inactiveRead, ProvisionedWrite: inactiveWrite, WriteScale: fixtureWriteScale()},
+			chunk.TableDesc{Name: chunkTablePrefix + "0", ProvisionedRead: inactiveRead, ProvisionedWrite: inactiveWrite}),
+			onDemandTable(1)...),
+	)
+}
+
 type mockApplicationAutoScalingClient struct {
 	applicationautoscalingiface.ApplicationAutoScalingAPI
 
@@ -348,7 +479,7 @@ func newMockApplicationAutoScaling() *mockApplicationAutoScalingClient {
 }
 
 func (m *mockApplicationAutoScalingClient) RegisterScalableTarget(input *applicationautoscaling.RegisterScalableTargetInput) (*applicationautoscaling.RegisterScalableTargetOutput, error) {
-	m.scalableTargets[*input.ResourceId] = mockScalableTarget{
+	m.scalableTargets[*input.ResourceId+*input.ScalableDimension] = mockScalableTarget{
 		RoleARN:     *input.RoleARN,
 		MinCapacity: *input.MinCapacity,
 		MaxCapacity: *input.MaxCapacity,
@@ -357,12 +488,12 @@ func (m *mockApplicationAutoScalingClient) RegisterScalableTarget(input *applica
 }
 
 func (m *mockApplicationAutoScalingClient) DeregisterScalableTarget(input *applicationautoscaling.DeregisterScalableTargetInput) (*applicationautoscaling.DeregisterScalableTargetOutput, error) {
-	delete(m.scalableTargets, *input.ResourceId)
+	delete(m.scalableTargets, *input.ResourceId+*input.ScalableDimension)
 	return &applicationautoscaling.DeregisterScalableTargetOutput{}, nil
 }
 
 func (m *mockApplicationAutoScalingClient) DescribeScalableTargetsWithContext(ctx aws.Context, input *applicationautoscaling.DescribeScalableTargetsInput, options ...request.Option) (*applicationautoscaling.DescribeScalableTargetsOutput, error) {
-	scalableTarget, ok := m.scalableTargets[*input.ResourceIds[0]]
+	scalableTarget, ok := m.scalableTargets[*input.ResourceIds[0]+*input.ScalableDimension]
 	if !ok {
 		return &applicationautoscaling.DescribeScalableTargetsOutput{}, nil
 	}
@@ -378,7 +509,7 @@ func (m *mockApplicationAutoScalingClient) DescribeScalableTargetsWithContext(ct
 }
 
 func (m *mockApplicationAutoScalingClient) PutScalingPolicy(input *applicationautoscaling.PutScalingPolicyInput) (*applicationautoscaling.PutScalingPolicyOutput, error) {
-	m.scalingPolicies[*input.ResourceId] = mockScalingPolicy{
+	m.scalingPolicies[*input.ResourceId+*input.ScalableDimension] = mockScalingPolicy{
 		ScaleInCooldown:  *input.TargetTrackingScalingPolicyConfiguration.ScaleInCooldown,
 		ScaleOutCooldown: *input.TargetTrackingScalingPolicyConfiguration.ScaleOutCooldown,
 		TargetValue:      *input.TargetTrackingScalingPolicyConfiguration.TargetValue,
@@ -387,12 +518,12 @@ func (m *mockApplicationAutoScalingClient) PutScalingPolicy(input *applicationau
 }
 
 func (m *mockApplicationAutoScalingClient) DeleteScalingPolicy(input *applicationautoscaling.DeleteScalingPolicyInput) (*applicationautoscaling.DeleteScalingPolicyOutput, error) {
-	delete(m.scalingPolicies, *input.ResourceId)
+	delete(m.scalingPolicies, *input.ResourceId+*input.ScalableDimension)
 	return &applicationautoscaling.DeleteScalingPolicyOutput{}, nil
 }
 
 func (m *mockApplicationAutoScalingClient) DescribeScalingPoliciesWithContext(ctx aws.Context, input *applicationautoscaling.DescribeScalingPoliciesInput, options ...request.Option) (*applicationautoscaling.DescribeScalingPoliciesOutput, error) {
-	scalingPolicy, ok := m.scalingPolicies[*input.ResourceId]
+	scalingPolicy, ok := m.scalingPolicies[*input.ResourceId+*input.ScalableDimension]
 	if !ok {
 		return &applicationautoscaling.DescribeScalingPoliciesOutput{}, nil
 	}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/chunk/testutils"
+	chunk_util "github.com/cortexproject/cortex/pkg/chunk/util"
 	"github.com/cortexproject/cortex/pkg/util"
 )
 
@@ -46,7 +47,9 @@ var Fixtures = []testutils.Fixture{
 				schemaCfg:               schemaConfig,
 			}
 			object := &s3ObjectClient{
-				S3: newMockS3(),
+				S3:      newMockS3(),
+				fetcher: chunk_util.NewParallelFetcher("s3", chunk_util.FetchConfig{}),
+				backoff: util.BackoffConfig{MaxRetries: 1},
 			}
 			return index, object, table, schemaConfig, nil
 		},
@@ -77,6 +80,7 @@ func dynamoDBFixture(provisionedErr, gangsize, maxParallelism int) testutils.Fix
 					},
 				},
 				DynamoDB:                dynamoDB,
+				fetcher:                 chunk_util.NewParallelFetcher("dynamodb", chunk_util.FetchConfig{}),
 				writeThrottle:           rate.NewLimiter(10, dynamoDBMaxWriteBatchSize),
 				queryRequestFn:          dynamoDB.queryRequest,
 				batchGetItemRequestFn:   dynamoDB.batchGetItemRequest,
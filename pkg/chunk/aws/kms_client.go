@@ -0,0 +1,130 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	awscommon "github.com/weaveworks/common/aws"
+	"github.com/weaveworks/common/instrument"
+
+	"github.com/cortexproject/cortex/pkg/chunk/encryption"
+)
+
+var kmsRequestDuration = instrument.NewHistogramCollector(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "cortex",
+	Name:      "kms_request_duration_seconds",
+	Help:      "Time spent doing AWS KMS requests.",
+	Buckets:   []float64{.025, .05, .1, .25, .5, 1, 2},
+}, []string{"operation", "status_code"}))
+
+func init() {
+	kmsRequestDuration.Register()
+}
+
+// The data keys are generated and decrypted with the tenant in their
+// encryption context, so that the key policies can restrict the tenants.
+const kmsTenantContextKey = "cortex_tenant"
+
+// kmsClient is a client of the GenerateDataKey and Decrypt operations of AWS
+// KMS, the only ones needed for the envelope encryption of the chunks.
+type kmsClient struct {
+	*client.Client
+}
+
+type kmsGenerateDataKeyInput struct {
+	KeyId             *string            `type:"string"`
+	KeySpec           *string            `type:"string"`
+	EncryptionContext map[string]*string `type:"map"`
+}
+
+type kmsGenerateDataKeyOutput struct {
+	CiphertextBlob []byte  `type:"blob"`
+	Plaintext      []byte  `type:"blob" sensitive:"true"`
+	KeyId          *string `type:"string"`
+}
+
+type kmsDecryptInput struct {
+	CiphertextBlob    []byte             `type:"blob"`
+	EncryptionContext map[string]*string `type:"map"`
+}
+
+type kmsDecryptOutput struct {
+	Plaintext []byte  `type:"blob" sensitive:"true"`
+	KeyId     *string `type:"string"`
+}
+
+// NewKMS makes an encryption.KMS of AWS KMS, e.g. kms://us-east-1.
+func NewKMS(kmsURL *url.URL) (encryption.KMS, error) {
+	if kmsURL == nil {
+		return nil, fmt.Errorf("no URL specified for AWS KMS")
+	}
+	config, err := awscommon.ConfigFromURL(kmsURL)
+	if err != nil {
+		return nil, err
+	}
+	c := session.New(config).ClientConfig("kms")
+	kms := &kmsClient{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "kms",
+				ServiceID:     "KMS",
+				SigningName:   c.SigningName,
+				SigningRegion: c.SigningRegion,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2014-11-01",
+				JSONVersion:   "1.1",
+				TargetPrefix:  "TrentService",
+			},
+			c.Handlers,
+		),
+	}
+	kms.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	kms.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	kms.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	kms.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	kms.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+	return kms, nil
+}
+
+func (k *kmsClient) do(ctx context.Context, operation string, input, output interface{}) error {
+	return instrument.CollectedRequest(ctx, "KMS."+operation, kmsRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		req := k.NewRequest(&request.Operation{Name: operation, HTTPMethod: "POST", HTTPPath: "/"}, input, output)
+		req.SetContext(ctx)
+		return req.Send()
+	})
+}
+
+func (k *kmsClient) GenerateDataKey(ctx context.Context, keyID, userID string) ([]byte, []byte, error) {
+	keySpec := "AES_256"
+	var output kmsGenerateDataKeyOutput
+	err := k.do(ctx, "GenerateDataKey", &kmsGenerateDataKeyInput{
+		KeyId:             &keyID,
+		KeySpec:           &keySpec,
+		EncryptionContext: map[string]*string{kmsTenantContextKey: &userID},
+	}, &output)
+	if err != nil {
+		return nil, nil, err
+	}
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+func (k *kmsClient) Decrypt(ctx context.Context, userID string, wrapped []byte) ([]byte, error) {
+	var output kmsDecryptOutput
+	err := k.do(ctx, "Decrypt", &kmsDecryptInput{
+		CiphertextBlob:    wrapped,
+		EncryptionContext: map[string]*string{kmsTenantContextKey: &userID},
+	}, &output)
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
@@ -0,0 +1,57 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKMS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+		require.Equal(t, map[string]interface{}{kmsTenantContextKey: "user1"}, input["EncryptionContext"])
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GenerateDataKey":
+			require.Equal(t, "alias/cortex", input["KeyId"])
+			require.Equal(t, "AES_256", input["KeySpec"])
+			// "wrapped" and "plaintext", base64 encoded.
+			w.Write([]byte(`{"CiphertextBlob":"d3JhcHBlZA==","Plaintext":"cGxhaW50ZXh0","KeyId":"arn:aws:kms:key"}`))
+		case "TrentService.Decrypt":
+			if input["CiphertextBlob"] != "d3JhcHBlZA==" {
+				w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"InvalidCiphertextException","message":"invalid ciphertext"}`))
+				return
+			}
+			w.Write([]byte(`{"Plaintext":"cGxhaW50ZXh0","KeyId":"arn:aws:kms:key"}`))
+		default:
+			t.Errorf("unexpected target %s", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	u.User = url.UserPassword("key", "secret")
+	kms, err := NewKMS(u)
+	require.NoError(t, err)
+
+	plaintext, wrapped, err := kms.GenerateDataKey(context.Background(), "alias/cortex", "user1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("plaintext"), plaintext)
+	require.Equal(t, []byte("wrapped"), wrapped)
+
+	plaintext, err = kms.Decrypt(context.Background(), "user1", wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("plaintext"), plaintext)
+
+	_, err = kms.Decrypt(context.Background(), "user1", []byte("other"))
+	require.Error(t, err)
+}
@@ -35,6 +35,7 @@ type mockDynamoDBClient struct {
 type mockDynamoDBTable struct {
 	items       map[string][]mockDynamoDBItem
 	read, write int64
+	onDemand    bool
 	tags        []*dynamodb.Tag
 }
 
@@ -97,6 +98,19 @@ func (m *mockDynamoDBClient) batchWriteItemRequest(_ context.Context, input *dyn
 				continue
 			}
 
+			if writeRequest.DeleteRequest != nil {
+				hashValue := *writeRequest.DeleteRequest.Key[hashKey].S
+				rangeValue := writeRequest.DeleteRequest.Key[rangeKey].B
+				items := table.items[hashValue]
+				for i := range items {
+					if bytes.Equal(items[i][rangeKey].B, rangeValue) {
+						table.items[hashValue] = append(items[:i], items[i+1:]...)
+						break
+					}
+				}
+				continue
+			}
+
 			hashValue := *writeRequest.PutRequest.Item[hashKey].S
 			rangeValue := writeRequest.PutRequest.Item[rangeKey].B
 
@@ -245,6 +259,25 @@ func (m *mockDynamoDBClient) queryRequest(_ context.Context, input *dynamodb.Que
 	}
 }
 
+func (m *mockDynamoDBClient) ScanPagesWithContext(_ aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, _ ...request.Option) error {
+	m.mtx.RLock()
+	table, ok := m.tables[*input.TableName]
+	if !ok {
+		m.mtx.RUnlock()
+		return fmt.Errorf("table not found")
+	}
+	result := &dynamodb.ScanOutput{}
+	for _, items := range table.items {
+		for _, item := range items {
+			result.Items = append(result.Items, item)
+		}
+	}
+	m.mtx.RUnlock()
+
+	fn(result, true)
+	return nil
+}
+
 type dynamoDBMockRequest struct {
 	result interface{}
 	err    error
@@ -295,11 +328,16 @@ func (m *mockDynamoDBClient) CreateTableWithContext(_ aws.Context, input *dynamo
 		return nil, fmt.Errorf("table already exists")
 	}
 
-	m.tables[*input.TableName] = &mockDynamoDBTable{
+	table := &mockDynamoDBTable{
 		items: map[string][]mockDynamoDBItem{},
-		write: *input.ProvisionedThroughput.WriteCapacityUnits,
-		read:  *input.ProvisionedThroughput.ReadCapacityUnits,
 	}
+	if input.BillingMode != nil && *input.BillingMode == dynamodb.BillingModePayPerRequest {
+		table.onDemand = true
+	} else {
+		table.write = *input.ProvisionedThroughput.WriteCapacityUnits
+		table.read = *input.ProvisionedThroughput.ReadCapacityUnits
+	}
+	m.tables[*input.TableName] = table
 
 	return &dynamodb.CreateTableOutput{
 		TableDescription: &dynamodb.TableDescription{
@@ -318,6 +356,10 @@ func (m *mockDynamoDBClient) DescribeTableWithContext(_ aws.Context, input *dyna
 		return nil, fmt.Errorf("not found")
 	}
 
+	billingMode := dynamodb.BillingModeProvisioned
+	if table.onDemand {
+		billingMode = dynamodb.BillingModePayPerRequest
+	}
 	return &dynamodb.DescribeTableOutput{
 		Table: &dynamodb.TableDescription{
 			TableName:   input.TableName,
@@ -326,6 +368,9 @@ func (m *mockDynamoDBClient) DescribeTableWithContext(_ aws.Context, input *dyna
 				ReadCapacityUnits:  aws.Int64(table.read),
 				WriteCapacityUnits: aws.Int64(table.write),
 			},
+			BillingModeSummary: &dynamodb.BillingModeSummary{
+				BillingMode: aws.String(billingMode),
+			},
 			TableArn: aws.String(arnPrefix + *input.TableName),
 		},
 	}, nil
@@ -341,8 +386,19 @@ func (m *mockDynamoDBClient) UpdateTableWithContext(_ aws.Context, input *dynamo
 		return nil, fmt.Errorf("not found")
 	}
 
-	table.read = *input.ProvisionedThroughput.ReadCapacityUnits
-	table.write = *input.ProvisionedThroughput.WriteCapacityUnits
+	// The throughput of the tables in on-demand mode isn't provisioned.
+	if input.BillingMode != nil {
+		if table.onDemand == (*input.BillingMode == dynamodb.BillingModePayPerRequest) {
+			return nil, fmt.Errorf("table is already in billing mode %s", *input.BillingMode)
+		}
+		table.onDemand = *input.BillingMode == dynamodb.BillingModePayPerRequest
+	}
+	if table.onDemand {
+		table.read, table.write = 0, 0
+	} else {
+		table.read = *input.ProvisionedThroughput.ReadCapacityUnits
+		table.write = *input.ProvisionedThroughput.WriteCapacityUnits
+	}
 
 	return &dynamodb.UpdateTableOutput{
 		TableDescription: &dynamodb.TableDescription{
@@ -390,12 +446,17 @@ func (m *mockDynamoDBClient) ListTagsOfResourceWithContext(_ aws.Context, input
 	}, nil
 }
 
+// mockS3 keeps the objects by bucket and key.
 type mockS3 struct {
 	s3iface.S3API
 	sync.RWMutex
 	objects map[string][]byte
 }
 
+func mockS3Key(bucket, key *string) string {
+	return aws.StringValue(bucket) + "/" + aws.StringValue(key)
+}
+
 func newMockS3() *mockS3 {
 	return &mockS3{
 		objects: map[string][]byte{},
@@ -411,7 +472,7 @@ func (m *mockS3) PutObjectWithContext(_ aws.Context, req *s3.PutObjectInput, _ .
 		return nil, err
 	}
 
-	m.objects[*req.Key] = buf
+	m.objects[mockS3Key(req.Bucket, req.Key)] = buf
 	return &s3.PutObjectOutput{}, nil
 }
 
@@ -419,12 +480,35 @@ func (m *mockS3) GetObjectWithContext(_ aws.Context, req *s3.GetObjectInput, _ .
 	m.RLock()
 	defer m.RUnlock()
 
-	buf, ok := m.objects[*req.Key]
+	buf, ok := m.objects[mockS3Key(req.Bucket, req.Key)]
 	if !ok {
-		return nil, fmt.Errorf("Not found")
+		return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "Not found", nil), 404, "")
 	}
 
 	return &s3.GetObjectOutput{
 		Body: ioutil.NopCloser(bytes.NewReader(buf)),
 	}, nil
 }
+
+func (m *mockS3) DeleteObjectWithContext(_ aws.Context, req *s3.DeleteObjectInput, _ ...request.Option) (*s3.DeleteObjectOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.objects, mockS3Key(req.Bucket, req.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3) ListObjectsV2PagesWithContext(_ aws.Context, req *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	m.RLock()
+	defer m.RUnlock()
+
+	output := &s3.ListObjectsV2Output{}
+	prefix := mockS3Key(req.Bucket, req.Prefix)
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			output.Contents = append(output.Contents, &s3.Object{Key: aws.String(strings.TrimPrefix(key, aws.StringValue(req.Bucket)+"/"))})
+		}
+	}
+	fn(output, true)
+	return nil
+}
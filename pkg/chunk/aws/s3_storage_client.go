@@ -3,12 +3,17 @@ package aws
 import (
 	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"io/ioutil"
+	"net/url"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -16,6 +21,8 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/chunk/util"
+	pkgUtil "github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 	awscommon "github.com/weaveworks/common/aws"
 	"github.com/weaveworks/common/instrument"
 )
@@ -33,16 +40,102 @@ func init() {
 	s3RequestDuration.Register()
 }
 
+// The server-side encryption types of the chunks.
+const (
+	SSES3  = validation.S3SSES3
+	SSEKMS = validation.S3SSEKMS
+)
+
+// The storage classes the chunks can be written with: the archive classes
+// aren't, as their objects can't be read without being restored first.
+var s3StorageClasses = []string{
+	s3.StorageClassStandard,
+	s3.StorageClassReducedRedundancy,
+	s3.StorageClassStandardIa,
+	s3.StorageClassOnezoneIa,
+	s3.StorageClassIntelligentTiering,
+}
+
+var s3CannedACLs = []string{
+	s3.ObjectCannedACLPrivate,
+	s3.ObjectCannedACLPublicRead,
+	s3.ObjectCannedACLPublicReadWrite,
+	s3.ObjectCannedACLAuthenticatedRead,
+	s3.ObjectCannedACLAwsExecRead,
+	s3.ObjectCannedACLBucketOwnerRead,
+	s3.ObjectCannedACLBucketOwnerFullControl,
+}
+
+// S3WriteOptions are the options of the chunks written to S3, so that the
+// lifecycle and billing policies of the buckets can apply to them.
+type S3WriteOptions struct {
+	SSEType      string
+	SSEKMSKeyID  string
+	CannedACL    string
+	StorageClass string
+	TenantTagKey string
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *S3WriteOptions) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.SSEType, "s3.sse.type", "", "Server-side encryption of the chunks written to S3: SSE-S3, SSE-KMS, or empty for the default encryption of the bucket.")
+	f.StringVar(&cfg.SSEKMSKeyID, "s3.sse.kms-key-id", "", "ID of the KMS key encrypting the chunks with SSE-KMS; empty for the AWS managed key.")
+	f.StringVar(&cfg.CannedACL, "s3.canned-acl", "", "Canned ACL of the chunks written to S3, e.g. bucket-owner-full-control; empty for the default ACL.")
+	f.StringVar(&cfg.StorageClass, "s3.storage-class", "", "Storage class of the chunks written to S3: STANDARD, REDUCED_REDUNDANCY, STANDARD_IA, ONEZONE_IA or INTELLIGENT_TIERING; empty for STANDARD.")
+	f.StringVar(&cfg.TenantTagKey, "s3.tenant-tag-key", "", "Key of the tag of the chunks written to S3 whose value is their tenant ID, e.g. for the lifecycle or billing policies of the tenants; empty to not tag them.")
+}
+
+// Validate the options.
+func (cfg *S3WriteOptions) Validate() error {
+	if err := validation.ValidateS3SSEType(cfg.SSEType); err != nil {
+		return err
+	}
+	if cfg.CannedACL != "" && !contains(s3CannedACLs, cfg.CannedACL) {
+		return fmt.Errorf("invalid S3 canned ACL %q, expected one of %s", cfg.CannedACL, strings.Join(s3CannedACLs, ", "))
+	}
+	if cfg.StorageClass != "" && !contains(s3StorageClasses, cfg.StorageClass) {
+		return fmt.Errorf("invalid S3 storage class %q, expected one of %s", cfg.StorageClass, strings.Join(s3StorageClasses, ", "))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// S3Limits are the per-tenant overrides of the server-side encryption of the
+// chunks, empty to use the S3WriteOptions, and of their bucket.
+type S3Limits interface {
+	S3SSEType(userID string) string
+	S3SSEKMSKeyID(userID string) string
+	util.BucketLimits
+}
+
 type s3ObjectClient struct {
 	bucketNames []string
 	S3          s3iface.S3API
+
+	options S3WriteOptions
+	limits  S3Limits
+	fetcher *util.ParallelFetcher
+	backoff pkgUtil.BackoffConfig
+	hedger  *util.Hedger
 }
 
-// NewS3ObjectClient makes a new S3-backed ObjectClient.
-func NewS3ObjectClient(cfg StorageConfig, schemaCfg chunk.SchemaConfig) (chunk.ObjectClient, error) {
+// NewS3ObjectClient makes a new S3-backed ObjectClient. The server-side
+// encryption of the chunks is overridden per tenant by limits if not nil.
+func NewS3ObjectClient(cfg StorageConfig, schemaCfg chunk.SchemaConfig, limits S3Limits) (chunk.ObjectClient, error) {
 	if cfg.S3.URL == nil {
 		return nil, fmt.Errorf("no URL specified for S3")
 	}
+	if err := cfg.S3WriteOptions.Validate(); err != nil {
+		return nil, err
+	}
 	s3Config, err := awscommon.ConfigFromURL(cfg.S3.URL)
 	if err != nil {
 		return nil, err
@@ -59,6 +152,11 @@ func NewS3ObjectClient(cfg StorageConfig, schemaCfg chunk.SchemaConfig) (chunk.O
 	client := s3ObjectClient{
 		S3:          s3Client,
 		bucketNames: bucketNames,
+		options:     cfg.S3WriteOptions,
+		limits:      limits,
+		fetcher:     util.NewParallelFetcher("s3", cfg.S3ChunkFetch),
+		backoff:     cfg.S3Backoff,
+		hedger:      util.NewHedger("s3", "S3.GetObject", cfg.S3Hedging),
 	}
 	return client, nil
 }
@@ -67,29 +165,38 @@ func (a s3ObjectClient) Stop() {
 }
 
 func (a s3ObjectClient) GetChunks(ctx context.Context, chunks []chunk.Chunk) ([]chunk.Chunk, error) {
-	return util.GetParallelChunks(ctx, chunks, a.getChunk)
+	return a.fetcher.GetChunks(ctx, chunks, a.getChunk)
 }
 
 func (a s3ObjectClient) getChunk(ctx context.Context, decodeContext *chunk.DecodeContext, c chunk.Chunk) (chunk.Chunk, error) {
-	var resp *s3.GetObjectOutput
-
 	// Map the key into a bucket
 	key := c.ExternalKey()
-	bucket := a.bucketFromKey(key)
 
-	err := instrument.CollectedRequest(ctx, "S3.GetObject", s3RequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
-		var err error
-		resp, err = a.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+	var (
+		buf []byte
+		err error
+	)
+	for _, bucket := range a.bucketsFor(c.UserID, key) {
+		buf, err = a.hedger.Get(ctx, func(ctx context.Context) ([]byte, error) {
+			var buf []byte
+			err := a.retry(ctx, "S3.GetObject", func(ctx context.Context) error {
+				resp, err := a.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+					Bucket: aws.String(bucket),
+					Key:    aws.String(key),
+				})
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				buf, err = ioutil.ReadAll(resp.Body)
+				return err
+			})
+			return buf, err
 		})
-		return err
-	})
-	if err != nil {
-		return chunk.Chunk{}, err
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != s3.ErrCodeNoSuchKey {
+			break
+		}
 	}
-	defer resp.Body.Close()
-	buf, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return chunk.Chunk{}, err
 	}
@@ -101,8 +208,9 @@ func (a s3ObjectClient) getChunk(ctx context.Context, decodeContext *chunk.Decod
 
 func (a s3ObjectClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) error {
 	var (
-		s3ChunkKeys []string
-		s3ChunkBufs [][]byte
+		s3ChunkKeys  []string
+		s3ChunkBufs  [][]byte
+		s3ChunkUsers []string
 	)
 
 	for i := range chunks {
@@ -114,12 +222,13 @@ func (a s3ObjectClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) err
 
 		s3ChunkKeys = append(s3ChunkKeys, key)
 		s3ChunkBufs = append(s3ChunkBufs, buf)
+		s3ChunkUsers = append(s3ChunkUsers, chunks[i].UserID)
 	}
 
 	incomingErrors := make(chan error)
 	for i := range s3ChunkBufs {
 		go func(i int) {
-			incomingErrors <- a.putS3Chunk(ctx, s3ChunkKeys[i], s3ChunkBufs[i])
+			incomingErrors <- a.putS3Chunk(ctx, s3ChunkUsers[i], s3ChunkKeys[i], s3ChunkBufs[i])
 		}(i)
 	}
 
@@ -133,17 +242,142 @@ func (a s3ObjectClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) err
 	return lastErr
 }
 
-func (a s3ObjectClient) putS3Chunk(ctx context.Context, key string, buf []byte) error {
-	return instrument.CollectedRequest(ctx, "S3.PutObject", s3RequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
-		_, err := a.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
-			Body:   bytes.NewReader(buf),
-			Bucket: aws.String(a.bucketFromKey(key)),
-			Key:    aws.String(key),
-		})
+func (a s3ObjectClient) putS3Chunk(ctx context.Context, userID, key string, buf []byte) error {
+	input := a.putObjectInput(userID)
+	input.Body = bytes.NewReader(buf)
+	input.Bucket = aws.String(a.bucketsFor(userID, key)[0])
+	input.Key = aws.String(key)
+
+	return a.retry(ctx, "S3.PutObject", func(ctx context.Context) error {
+		// The body is read again by the retries.
+		if _, err := input.Body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := a.S3.PutObjectWithContext(ctx, input)
 		return err
 	})
 }
 
+// DeleteChunk implements chunk.ObjectDeleter.
+func (a s3ObjectClient) DeleteChunk(ctx context.Context, c chunk.Chunk) error {
+	chunkID := c.ExternalKey()
+	for _, bucket := range a.bucketsFor(c.UserID, chunkID) {
+		err := a.retry(ctx, "S3.DeleteObject", func(ctx context.Context) error {
+			_, err := a.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(chunkID),
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListChunks implements chunk.ObjectLister, listing the chunks of the tenant
+// in its bucket and in all the buckets of the config.
+func (a s3ObjectClient) ListChunks(ctx context.Context, userID string) ([]string, error) {
+	buckets := a.bucketNames
+	if bucket := util.TenantBucket(a.limits, userID); bucket != "" {
+		buckets = append([]string{bucket}, buckets...)
+	}
+
+	var (
+		keys []string
+		seen = map[string]bool{}
+	)
+	for _, bucket := range buckets {
+		var bucketKeys []string
+		err := a.retry(ctx, "S3.ListObjects", func(ctx context.Context) error {
+			bucketKeys = bucketKeys[:0]
+			return a.S3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+				Bucket: aws.String(bucket),
+				Prefix: aws.String(userID + "/"),
+			}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+				for _, object := range output.Contents {
+					bucketKeys = append(bucketKeys, *object.Key)
+				}
+				return true
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range bucketKeys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// retry does an instrumented request with the retry policy of the client.
+func (a s3ObjectClient) retry(ctx context.Context, operation string, f func(context.Context) error) error {
+	return util.Retry(ctx, "s3", operation, a.backoff, s3Retryable, func(ctx context.Context) error {
+		return instrument.CollectedRequest(ctx, operation, s3RequestDuration, instrument.ErrorCode, f)
+	})
+}
+
+// s3Retryable returns whether a request failing with err may succeed if tried
+// again: the client errors won't, except for the throttled requests.
+func s3Retryable(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
+		return false
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		code := rerr.StatusCode()
+		return code == 429 || code >= 500
+	}
+	return true
+}
+
+// putObjectInput returns the input of the PutObject of a chunk of a user,
+// with the write options and the server-side encryption of the user.
+func (a s3ObjectClient) putObjectInput(userID string) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{}
+
+	sseType, kmsKeyID := a.options.SSEType, a.options.SSEKMSKeyID
+	if a.limits != nil {
+		if t := a.limits.S3SSEType(userID); t != "" {
+			sseType, kmsKeyID = t, a.limits.S3SSEKMSKeyID(userID)
+		}
+	}
+	switch sseType {
+	case SSES3:
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAes256)
+	case SSEKMS:
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		if kmsKeyID != "" {
+			input.SSEKMSKeyId = aws.String(kmsKeyID)
+		}
+	}
+
+	if a.options.CannedACL != "" {
+		input.ACL = aws.String(a.options.CannedACL)
+	}
+	if a.options.StorageClass != "" {
+		input.StorageClass = aws.String(a.options.StorageClass)
+	}
+	if a.options.TenantTagKey != "" {
+		input.Tagging = aws.String(url.Values{a.options.TenantTagKey: []string{userID}}.Encode())
+	}
+	return input
+}
+
+// bucketsFor returns the buckets a chunk of a user may be in: the bucket of
+// the user, if any, where it's written, then the bucket of its key.
+func (a s3ObjectClient) bucketsFor(userID, key string) []string {
+	bucket := a.bucketFromKey(key)
+	if tenantBucket := util.TenantBucket(a.limits, userID); tenantBucket != "" && tenantBucket != bucket {
+		return []string{tenantBucket, bucket}
+	}
+	return []string{bucket}
+}
+
 // bucketFromKey maps a key to a bucket name
 func (a s3ObjectClient) bucketFromKey(key string) string {
 	if len(a.bucketNames) == 0 {
@@ -0,0 +1,164 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/chunk/util"
+	pkgUtil "github.com/cortexproject/cortex/pkg/util"
+)
+
+type fakeS3Limits map[string][3]string
+
+func (l fakeS3Limits) S3SSEType(userID string) string {
+	return l[userID][0]
+}
+
+func (l fakeS3Limits) S3SSEKMSKeyID(userID string) string {
+	return l[userID][1]
+}
+
+func (l fakeS3Limits) ObjectStoreBucketName(userID string) string {
+	return l[userID][2]
+}
+
+func TestS3PutObjectInput(t *testing.T) {
+	client := s3ObjectClient{
+		options: S3WriteOptions{
+			SSEType:      SSEKMS,
+			SSEKMSKeyID:  "key",
+			CannedACL:    s3.ObjectCannedACLBucketOwnerFullControl,
+			StorageClass: s3.StorageClassStandardIa,
+			TenantTagKey: "tenant",
+		},
+		limits: fakeS3Limits{
+			"sse-s3":  {SSES3, ""},
+			"sse-kms": {SSEKMS, "tenant-key"},
+		},
+	}
+
+	for _, tc := range []struct {
+		userID   string
+		expected *s3.PutObjectInput
+	}{
+		{
+			userID: "default",
+			expected: &s3.PutObjectInput{
+				ServerSideEncryption: aws.String(s3.ServerSideEncryptionAwsKms),
+				SSEKMSKeyId:          aws.String("key"),
+				ACL:                  aws.String(s3.ObjectCannedACLBucketOwnerFullControl),
+				StorageClass:         aws.String(s3.StorageClassStandardIa),
+				Tagging:              aws.String("tenant=default"),
+			},
+		},
+		{
+			userID: "sse-s3",
+			expected: &s3.PutObjectInput{
+				ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+				ACL:                  aws.String(s3.ObjectCannedACLBucketOwnerFullControl),
+				StorageClass:         aws.String(s3.StorageClassStandardIa),
+				Tagging:              aws.String("tenant=sse-s3"),
+			},
+		},
+		{
+			userID: "sse-kms",
+			expected: &s3.PutObjectInput{
+				ServerSideEncryption: aws.String(s3.ServerSideEncryptionAwsKms),
+				SSEKMSKeyId:          aws.String("tenant-key"),
+				ACL:                  aws.String(s3.ObjectCannedACLBucketOwnerFullControl),
+				StorageClass:         aws.String(s3.StorageClassStandardIa),
+				Tagging:              aws.String("tenant=sse-kms"),
+			},
+		},
+	} {
+		t.Run(tc.userID, func(t *testing.T) {
+			require.Equal(t, tc.expected, client.putObjectInput(tc.userID))
+		})
+	}
+
+	// Nothing is set by default.
+	require.Equal(t, &s3.PutObjectInput{}, s3ObjectClient{}.putObjectInput("1"))
+}
+
+func TestS3WriteOptionsValidate(t *testing.T) {
+	require.NoError(t, (&S3WriteOptions{}).Validate())
+	require.NoError(t, (&S3WriteOptions{SSEType: SSES3, CannedACL: "private", StorageClass: "INTELLIGENT_TIERING"}).Validate())
+	require.Error(t, (&S3WriteOptions{SSEType: "AES256"}).Validate())
+	require.Error(t, (&S3WriteOptions{CannedACL: "everyone"}).Validate())
+	require.Error(t, (&S3WriteOptions{StorageClass: s3.StorageClassGlacier}).Validate())
+}
+
+func TestS3Retryable(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{fmt.Errorf("connection reset by peer"), true},
+		{awserr.NewRequestFailure(awserr.New("SlowDown", "", nil), 503, ""), true},
+		{awserr.NewRequestFailure(awserr.New("Throttling", "", nil), 429, ""), true},
+		{awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "", nil), 404, ""), false},
+		{awserr.New(request.CanceledErrorCode, "", context.Canceled), false},
+	} {
+		require.Equal(t, tc.retryable, s3Retryable(tc.err), tc.err.Error())
+	}
+}
+
+func TestS3TenantBucket(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockS3()
+	client := s3ObjectClient{
+		S3:          mock,
+		bucketNames: []string{"chunks"},
+		limits:      fakeS3Limits{},
+		fetcher:     util.NewParallelFetcher("s3", util.FetchConfig{}),
+		backoff:     pkgUtil.BackoffConfig{MaxRetries: 1},
+	}
+
+	// The chunks written before the tenant had its own bucket are still read.
+	before := dummyChunk(t, "tenant", 0)
+	require.NoError(t, client.PutChunks(ctx, []chunk.Chunk{before}))
+
+	client.limits = fakeS3Limits{"tenant": {"", "", "tenant-chunks"}}
+	after := dummyChunk(t, "tenant", model.TimeFromUnix(3600))
+	other := dummyChunk(t, "other", 0)
+	require.NoError(t, client.PutChunks(ctx, []chunk.Chunk{after, other}))
+	require.Contains(t, mock.objects, "chunks/"+before.ExternalKey())
+	require.Contains(t, mock.objects, "tenant-chunks/"+after.ExternalKey())
+	require.Contains(t, mock.objects, "chunks/"+other.ExternalKey())
+
+	fetched, err := client.GetChunks(ctx, []chunk.Chunk{before, after, other})
+	require.NoError(t, err)
+	require.Len(t, fetched, 3)
+
+	keys, err := client.ListChunks(ctx, "tenant")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{before.ExternalKey(), after.ExternalKey()}, keys)
+
+	require.NoError(t, client.DeleteChunk(ctx, before))
+	require.NoError(t, client.DeleteChunk(ctx, after))
+	keys, err = client.ListChunks(ctx, "tenant")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func dummyChunk(t *testing.T, userID string, from model.Time) chunk.Chunk {
+	metric := labels.Labels{{Name: labels.MetricName, Value: "foo"}}
+	pc := encoding.New()
+	pcs, err := pc.Add(model.SamplePair{Timestamp: from, Value: 1})
+	require.NoError(t, err)
+	c := chunk.NewChunk(userID, model.Fingerprint(metric.Hash()), metric, pcs[0], from, from.Add(time.Minute))
+	require.NoError(t, c.Encode())
+	return c
+}
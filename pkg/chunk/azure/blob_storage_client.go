@@ -0,0 +1,259 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/common/instrument"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	chunk_util "github.com/cortexproject/cortex/pkg/chunk/util"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// storageResource is the resource of the tokens of the managed identities
+// accessing Azure Storage.
+const storageResource = "https://storage.azure.com/"
+
+var blobRequestDuration = instrument.NewHistogramCollector(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "cortex",
+	Name:      "azure_blob_request_duration_seconds",
+	Help:      "Time spent doing Azure Blob Storage requests.",
+	Buckets:   []float64{.025, .05, .1, .25, .5, 1, 2},
+}, []string{"operation", "status_code"}))
+
+func init() {
+	blobRequestDuration.Register()
+}
+
+// BlobStorageConfig is config for the Azure Blob Storage Chunk Client.
+type BlobStorageConfig struct {
+	ContainerName      string `yaml:"container_name"`
+	AccountName        string `yaml:"account_name"`
+	AccountKey         string `yaml:"account_key"`
+	UseManagedIdentity bool   `yaml:"use_managed_identity"`
+	UserAssignedID     string `yaml:"user_assigned_id"`
+	EndpointSuffix     string `yaml:"endpoint_suffix"`
+
+	UploadBlockSize   int           `yaml:"upload_block_size"`
+	UploadParallelism int           `yaml:"upload_parallelism"`
+	RequestTimeout    time.Duration `yaml:"request_timeout"`
+	MaxRetries        int           `yaml:"max_retries"`
+	MinRetryDelay     time.Duration `yaml:"min_retry_delay"`
+	MaxRetryDelay     time.Duration `yaml:"max_retry_delay"`
+
+	ChunkFetch chunk_util.FetchConfig `yaml:"chunk_fetch"`
+}
+
+// RegisterFlags registers flags.
+func (cfg *BlobStorageConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.ContainerName, "azure.container-name", "cortex", "Name of the Azure Blob Storage container to put chunks in.")
+	f.StringVar(&cfg.AccountName, "azure.account-name", "", "Name of the Azure Storage account.")
+	f.StringVar(&cfg.AccountKey, "azure.account-key", "", "Shared key of the Azure Storage account; unused with a managed identity.")
+	f.BoolVar(&cfg.UseManagedIdentity, "azure.use-managed-identity", false, "Authenticate with the managed identity of the Azure VM instead of the account key.")
+	f.StringVar(&cfg.UserAssignedID, "azure.user-assigned-id", "", "Client ID of the user-assigned managed identity to authenticate with; empty for the system-assigned identity.")
+	f.StringVar(&cfg.EndpointSuffix, "azure.endpoint-suffix", "blob.core.windows.net", "Suffix of the Blob Storage endpoint of the account, e.g. blob.core.chinacloudapi.cn for Azure China.")
+	f.IntVar(&cfg.UploadBlockSize, "azure.upload-block-size", 4*1024*1024, "Size of the blocks the chunks are uploaded in; the chunks up to 256MB smaller than it are uploaded in a single request.")
+	f.IntVar(&cfg.UploadParallelism, "azure.upload-parallelism", 4, "Number of blocks of a chunk uploaded in parallel.")
+	f.DurationVar(&cfg.RequestTimeout, "azure.request-timeout", 30*time.Second, "Timeout of each try of the Blob Storage requests.")
+	f.IntVar(&cfg.MaxRetries, "azure.max-retries", 5, "Number of tries of the failed Blob Storage requests, backing off exponentially.")
+	f.DurationVar(&cfg.MinRetryDelay, "azure.min-retry-delay", 100*time.Millisecond, "Delay before the first retry of a failed Blob Storage request.")
+	f.DurationVar(&cfg.MaxRetryDelay, "azure.max-retry-delay", 10*time.Second, "Maximum delay before retrying a failed Blob Storage request.")
+	cfg.ChunkFetch.RegisterFlagsWithPrefix("azure", f)
+}
+
+// Validate the config.
+func (cfg *BlobStorageConfig) Validate() error {
+	if cfg.AccountName == "" {
+		return errors.New("no Azure Storage account name specified")
+	}
+	if !cfg.UseManagedIdentity && cfg.AccountKey == "" {
+		return errors.New("no Azure Storage account key specified, nor to use a managed identity")
+	}
+	if cfg.MinRetryDelay > cfg.MaxRetryDelay {
+		return fmt.Errorf("the minimum retry delay %s is longer than the maximum %s", cfg.MinRetryDelay, cfg.MaxRetryDelay)
+	}
+	return nil
+}
+
+type blobStorageClient struct {
+	cfg       BlobStorageConfig
+	container azblob.ContainerURL
+	fetcher   *chunk_util.ParallelFetcher
+}
+
+// NewBlobStorage makes a new chunk.ObjectClient that writes chunks to a
+// container of Azure Blob Storage.
+func NewBlobStorage(cfg BlobStorageConfig, schemaCfg chunk.SchemaConfig) (chunk.ObjectClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	credential, err := newCredential(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(fmt.Sprintf("https://%s.%s/%s", cfg.AccountName, cfg.EndpointSuffix, cfg.ContainerName))
+	if err != nil {
+		return nil, err
+	}
+	return newBlobStorageClient(cfg, *u, credential), nil
+}
+
+func newBlobStorageClient(cfg BlobStorageConfig, u url.URL, credential azblob.Credential) *blobStorageClient {
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{
+			Policy:        azblob.RetryPolicyExponential,
+			MaxTries:      int32(cfg.MaxRetries),
+			TryTimeout:    cfg.RequestTimeout,
+			RetryDelay:    cfg.MinRetryDelay,
+			MaxRetryDelay: cfg.MaxRetryDelay,
+		},
+	})
+	return &blobStorageClient{
+		cfg:       cfg,
+		container: azblob.NewContainerURL(u, pipeline),
+		fetcher:   chunk_util.NewParallelFetcher("azure", cfg.ChunkFetch),
+	}
+}
+
+// newCredential returns the shared key credential of the account, or a token
+// credential of the managed identity of the VM, refreshed before it expires.
+func newCredential(cfg BlobStorageConfig) (azblob.Credential, error) {
+	if !cfg.UseManagedIdentity {
+		return azblob.NewSharedKeyCredential(cfg.AccountName, cfg.AccountKey)
+	}
+
+	endpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	var token *adal.ServicePrincipalToken
+	if cfg.UserAssignedID != "" {
+		token, err = adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(endpoint, storageResource, cfg.UserAssignedID)
+	} else {
+		token, err = adal.NewServicePrincipalTokenFromMSI(endpoint, storageResource)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := token.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return azblob.NewTokenCredential(token.OAuthToken(), func(credential azblob.TokenCredential) time.Duration {
+		if err := token.Refresh(); err != nil {
+			level.Error(util.Logger).Log("msg", "failed to refresh the managed identity token, retrying in a minute", "err", err)
+			return time.Minute
+		}
+		credential.SetToken(token.OAuthToken())
+		return refreshDelay(token.Token().Expires(), time.Now())
+	}), nil
+}
+
+// refreshDelay is the delay until refreshing a token expiring at expires: 5
+// minutes before it does, and at least a minute.
+func refreshDelay(expires, now time.Time) time.Duration {
+	delay := expires.Sub(now) - 5*time.Minute
+	if delay < time.Minute {
+		return time.Minute
+	}
+	return delay
+}
+
+func (b *blobStorageClient) Stop() {
+}
+
+func (b *blobStorageClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) error {
+	for _, c := range chunks {
+		buf, err := c.Encoded()
+		if err != nil {
+			return err
+		}
+
+		blob := b.container.NewBlockBlobURL(c.ExternalKey())
+		err = instrument.CollectedRequest(ctx, "Azure.Upload", blobRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+			// The chunks larger than a block are uploaded in blocks, committed
+			// together.
+			_, err := azblob.UploadBufferToBlockBlob(ctx, buf, blob, azblob.UploadToBlockBlobOptions{
+				BlockSize:   int64(b.cfg.UploadBlockSize),
+				Parallelism: uint16(b.cfg.UploadParallelism),
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *blobStorageClient) GetChunks(ctx context.Context, input []chunk.Chunk) ([]chunk.Chunk, error) {
+	return b.fetcher.GetChunks(ctx, input, b.getChunk)
+}
+
+func (b *blobStorageClient) getChunk(ctx context.Context, decodeContext *chunk.DecodeContext, input chunk.Chunk) (chunk.Chunk, error) {
+	var buf []byte
+	err := instrument.CollectedRequest(ctx, "Azure.Download", blobRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		resp, err := b.container.NewBlobURL(input.ExternalKey()).Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+		if err != nil {
+			return err
+		}
+
+		// The body is read again from where it failed, up to the retries.
+		body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: b.cfg.MaxRetries})
+		defer body.Close()
+		buf, err = ioutil.ReadAll(body)
+		return err
+	})
+	if err != nil {
+		return chunk.Chunk{}, err
+	}
+
+	if err := input.Decode(decodeContext, buf); err != nil {
+		return chunk.Chunk{}, err
+	}
+	return input, nil
+}
+
+// ListChunks implements chunk.ObjectLister.
+func (b *blobStorageClient) ListChunks(ctx context.Context, userID string) ([]string, error) {
+	var keys []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		var resp *azblob.ListBlobsFlatSegmentResponse
+		err := instrument.CollectedRequest(ctx, "Azure.List", blobRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+			var err error
+			resp, err = b.container.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: userID + "/"})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range resp.Segment.BlobItems {
+			keys = append(keys, blob.Name)
+		}
+		marker = resp.NextMarker
+	}
+	return keys, nil
+}
+
+// DeleteChunk implements chunk.ObjectDeleter.
+func (b *blobStorageClient) DeleteChunk(ctx context.Context, c chunk.Chunk) error {
+	chunkID := c.ExternalKey()
+	return instrument.CollectedRequest(ctx, "Azure.Delete", blobRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		_, err := b.container.NewBlobURL(chunkID).Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+		if serr, ok := err.(azblob.StorageError); ok && serr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil
+		}
+		return err
+	})
+}
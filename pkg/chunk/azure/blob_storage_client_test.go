@@ -0,0 +1,97 @@
+package azure
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk/testutils"
+)
+
+// fakeBlobStorage stores the blobs of a container, failing the first request
+// to each of them to exercise the retries.
+type fakeBlobStorage struct {
+	mtx    sync.Mutex
+	blobs  map[string][]byte
+	failed map[string]bool
+}
+
+func (f *fakeBlobStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/cortex/")
+	if !f.failed[r.Method+key] {
+		f.failed[r.Method+key] = true
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		buf, _ := ioutil.ReadAll(r.Body)
+		f.blobs[key] = buf
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		buf, ok := f.blobs[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(buf)
+	}
+}
+
+func TestBlobStorageClient(t *testing.T) {
+	fake := &fakeBlobStorage{blobs: map[string][]byte{}, failed: map[string]bool{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL + "/cortex")
+	require.NoError(t, err)
+	client := newBlobStorageClient(BlobStorageConfig{
+		UploadBlockSize:   1024 * 1024,
+		UploadParallelism: 1,
+		MaxRetries:        3,
+		MinRetryDelay:     time.Millisecond,
+		MaxRetryDelay:     time.Millisecond,
+	}, *u, azblob.NewAnonymousCredential())
+
+	ctx := context.Background()
+	_, chunks, err := testutils.CreateChunks(0, 2, model.Now())
+	require.NoError(t, err)
+	require.NoError(t, client.PutChunks(ctx, chunks))
+	require.Len(t, fake.blobs, 2)
+
+	got, err := client.GetChunks(ctx, chunks)
+	require.NoError(t, err)
+	require.Equal(t, len(chunks), len(got))
+	for _, c := range got {
+		require.Contains(t, []string{chunks[0].ExternalKey(), chunks[1].ExternalKey()}, c.ExternalKey())
+	}
+}
+
+func TestBlobStorageConfigValidate(t *testing.T) {
+	require.NoError(t, (&BlobStorageConfig{AccountName: "account", AccountKey: "key"}).Validate())
+	require.NoError(t, (&BlobStorageConfig{AccountName: "account", UseManagedIdentity: true}).Validate())
+	require.Error(t, (&BlobStorageConfig{AccountKey: "key"}).Validate())
+	require.Error(t, (&BlobStorageConfig{AccountName: "account"}).Validate())
+	require.Error(t, (&BlobStorageConfig{AccountName: "account", AccountKey: "key", MinRetryDelay: time.Second}).Validate())
+}
+
+func TestRefreshDelay(t *testing.T) {
+	now := time.Now()
+	require.Equal(t, 55*time.Minute, refreshDelay(now.Add(time.Hour), now))
+	require.Equal(t, time.Minute, refreshDelay(now.Add(5*time.Minute), now))
+	require.Equal(t, time.Minute, refreshDelay(now.Add(-time.Minute), now))
+}
@@ -2,10 +2,14 @@ package chunk
 
 import (
 	"context"
-	"time"
+
+	"github.com/prometheus/common/model"
 )
 
 // BucketClient is used to enforce retention on chunk buckets.
 type BucketClient interface {
-	DeleteChunksBefore(ctx context.Context, ts time.Time) error
+	// DeleteExpiredChunks deletes the chunks for which expired returns true,
+	// given their tenant and end time, or only finds them if dryRun is set.
+	// It returns the number of chunks expired per tenant.
+	DeleteExpiredChunks(ctx context.Context, expired func(userID string, through model.Time) bool, dryRun bool) (map[string]int, error)
 }
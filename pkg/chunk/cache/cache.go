@@ -28,6 +28,7 @@ type Config struct {
 	Background     BackgroundConfig      `yaml:"background,omitempty"`
 	Memcache       MemcachedConfig       `yaml:"memcached,omitempty"`
 	MemcacheClient MemcachedClientConfig `yaml:"memcached_client,omitempty"`
+	Redis          RedisConfig           `yaml:"redis,omitempty"`
 	Fifocache      FifoCacheConfig       `yaml:"fifocache,omitempty"`
 
 	// This is to name the cache metrics properly.
@@ -42,6 +43,7 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, description string, f
 	cfg.Background.RegisterFlagsWithPrefix(prefix, description, f)
 	cfg.Memcache.RegisterFlagsWithPrefix(prefix, description, f)
 	cfg.MemcacheClient.RegisterFlagsWithPrefix(prefix, description, f)
+	cfg.Redis.RegisterFlagsWithPrefix(prefix, description, f)
 	cfg.Fifocache.RegisterFlagsWithPrefix(prefix, description, f)
 
 	f.BoolVar(&cfg.EnableFifoCache, prefix+"cache.enable-fifocache", false, description+"Enable in-memory cache.")
@@ -50,6 +52,40 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, description string, f
 	cfg.Prefix = prefix
 }
 
+// IsEnabled returns whether a cache is configured.
+func (cfg *Config) IsEnabled() bool {
+	return cfg.Cache != nil || cfg.EnableFifoCache || cfg.MemcacheClient.Host != "" || cfg.Redis.Endpoint != ""
+}
+
+// Validity returns how long the entries of the configured caches are kept at
+// most, 0 if those of some of them never expire.
+func (cfg *Config) Validity() time.Duration {
+	var validities []time.Duration
+	if cfg.EnableFifoCache {
+		validities = append(validities, cfg.Fifocache.Validity)
+	}
+	if cfg.MemcacheClient.Host != "" {
+		validities = append(validities, cfg.Memcache.Expiration)
+	}
+	if cfg.Redis.Endpoint != "" {
+		validities = append(validities, cfg.Redis.Expiration)
+	}
+
+	var max time.Duration
+	for _, validity := range validities {
+		if validity == 0 {
+			validity = cfg.DefaultValidity
+		}
+		if validity == 0 {
+			return 0
+		}
+		if validity > max {
+			max = validity
+		}
+	}
+	return max
+}
+
 // New creates a new Cache using Config.
 func New(cfg Config) (Cache, error) {
 	if cfg.Cache != nil {
@@ -79,6 +115,16 @@ func New(cfg Config) (Cache, error) {
 		caches = append(caches, NewBackground(cacheName, cfg.Background, Instrument(cacheName, cache)))
 	}
 
+	if cfg.Redis.Endpoint != "" {
+		if cfg.Redis.Expiration == 0 && cfg.DefaultValidity != 0 {
+			cfg.Redis.Expiration = cfg.DefaultValidity
+		}
+
+		cacheName := cfg.Prefix + "redis"
+		cache := NewRedisCache(cacheName, NewRedisClient(&cfg.Redis))
+		caches = append(caches, NewBackground(cacheName, cfg.Background, Instrument(cacheName, cache)))
+	}
+
 	cache := NewTiered(caches)
 	if len(caches) > 1 {
 		cache = Instrument(cfg.Prefix+"tiered", cache)
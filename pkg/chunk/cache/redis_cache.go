@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-redis/redis"
+	opentracing "github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	instr "github.com/weaveworks/common/instrument"
+)
+
+var (
+	redisRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cortex",
+		Name:      "redis_request_duration_seconds",
+		Help:      "Total time spent in seconds doing redis requests.",
+		// Redis requests are very quick: smallest bucket is 16us, biggest is 1s
+		Buckets: prometheus.ExponentialBuckets(0.000016, 4, 8),
+	}, []string{"method", "status_code", "name"})
+)
+
+// RedisCache type caches chunks in redis
+type RedisCache struct {
+	name            string
+	redis           *RedisClient
+	requestDuration observableVecCollector
+}
+
+// NewRedisCache creates a new RedisCache
+func NewRedisCache(name string, redisClient *RedisClient) *RedisCache {
+	c := &RedisCache{
+		name:  name,
+		redis: redisClient,
+		requestDuration: observableVecCollector{
+			v: redisRequestDuration.MustCurryWith(prometheus.Labels{
+				"name": name,
+			}),
+		},
+	}
+	if err := c.redis.Ping(); err != nil {
+		level.Error(util.Logger).Log("msg", "error connecting to redis", "name", name, "err", err)
+	}
+	return c
+}
+
+func redisStatusCode(err error) string {
+	switch err {
+	case nil:
+		return "200"
+	case redis.Nil:
+		return "404"
+	default:
+		return "500"
+	}
+}
+
+// Fetch gets keys from the cache. The keys that are found must be in the order of the keys requested.
+func (c *RedisCache) Fetch(ctx context.Context, keys []string) (found []string, bufs [][]byte, missed []string) {
+	var items [][]byte
+	err := instr.CollectedRequest(ctx, "Redis.MGet", c.requestDuration, redisStatusCode, func(ctx context.Context) error {
+		sp := opentracing.SpanFromContext(ctx)
+		sp.LogFields(otlog.Int("keys requested", len(keys)))
+
+		var err error
+		items, err = c.redis.MGet(keys)
+		if err != nil {
+			sp.LogFields(otlog.Error(err))
+			return err
+		}
+
+		sp.LogFields(otlog.Int("keys found", countNonNil(items)))
+		return nil
+	})
+	if err != nil {
+		level.Error(util.Logger).Log("msg", "failed to get from redis", "name", c.name, "err", err)
+		return nil, nil, keys
+	}
+
+	for i, key := range keys {
+		if items[i] != nil {
+			found = append(found, key)
+			bufs = append(bufs, items[i])
+		} else {
+			missed = append(missed, key)
+		}
+	}
+	return
+}
+
+// Store stores the key in the cache.
+func (c *RedisCache) Store(ctx context.Context, keys []string, bufs [][]byte) {
+	err := instr.CollectedRequest(ctx, "Redis.MSet", c.requestDuration, redisStatusCode, func(_ context.Context) error {
+		return c.redis.MSet(keys, bufs)
+	})
+	if err != nil {
+		level.Error(util.Logger).Log("msg", "failed to put to redis", "name", c.name, "err", err)
+	}
+}
+
+// Stop stops the redis client.
+func (c *RedisCache) Stop() error {
+	return c.redis.Close()
+}
+
+func countNonNil(items [][]byte) int {
+	n := 0
+	for _, item := range items {
+		if item != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,67 @@
+package cache_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisCache(t *testing.T) {
+	server, err := miniredis.Run()
+	require.NoError(t, err)
+	defer server.Close()
+	server.RequireAuth("secret")
+
+	client := cache.NewRedisClient(&cache.RedisConfig{
+		Endpoint:   server.Addr(),
+		Timeout:    time.Second,
+		Expiration: time.Minute,
+		Password:   "secret",
+	})
+	c := cache.NewRedisCache("test", client)
+	defer c.Stop()
+
+	ctx := context.Background()
+	keysIncMissing := make([]string, 0, 100)
+	keys := make([]string, 0, 100)
+	bufs := make([][]byte, 0, 100)
+
+	// Insert 100 keys skipping all multiples of 5.
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		keysIncMissing = append(keysIncMissing, key)
+		if i%5 == 0 {
+			continue
+		}
+		keys = append(keys, key)
+		bufs = append(bufs, []byte(key))
+	}
+	c.Store(ctx, keys, bufs)
+	require.Equal(t, time.Minute, server.TTL("1"))
+
+	found, foundBufs, missing := c.Fetch(ctx, keysIncMissing)
+	require.Equal(t, keys, found)
+	require.Equal(t, bufs, foundBufs)
+	require.Len(t, missing, 20)
+	for i, key := range missing {
+		require.Equal(t, strconv.Itoa(i*5), key)
+	}
+
+	// The keys expire.
+	server.FastForward(time.Minute)
+	found, _, missing = c.Fetch(ctx, keys)
+	require.Empty(t, found)
+	require.Equal(t, keys, missing)
+
+	// All the keys are missing if redis fails.
+	c.Store(ctx, keys, bufs)
+	server.Close()
+	found, _, missing = c.Fetch(ctx, keys)
+	require.Empty(t, found)
+	require.Equal(t, keys, missing)
+}
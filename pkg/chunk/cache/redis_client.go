@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"crypto/tls"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisConfig defines how a RedisClient should be constructed.
+type RedisConfig struct {
+	Endpoint           string        `yaml:"endpoint,omitempty"`
+	MasterName         string        `yaml:"master_name,omitempty"`
+	Cluster            bool          `yaml:"cluster,omitempty"`
+	Timeout            time.Duration `yaml:"timeout,omitempty"`
+	Expiration         time.Duration `yaml:"expiration,omitempty"`
+	DB                 int           `yaml:"db,omitempty"`
+	PoolSize           int           `yaml:"pool_size,omitempty"`
+	Password           string        `yaml:"password,omitempty"`
+	EnableTLS          bool          `yaml:"enable_tls,omitempty"`
+	InsecureSkipVerify bool          `yaml:"tls_insecure_skip_verify,omitempty"`
+	IdleTimeout        time.Duration `yaml:"idle_timeout,omitempty"`
+	MaxConnAge         time.Duration `yaml:"max_connection_age,omitempty"`
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given FlagSet
+func (cfg *RedisConfig) RegisterFlagsWithPrefix(prefix, description string, f *flag.FlagSet) {
+	f.StringVar(&cfg.Endpoint, prefix+"redis.endpoint", "", description+"Redis server endpoint to use for caching, host:port, or a comma-separated list of the nodes of a Redis cluster or of the Redis Sentinels. If empty, no redis will be used.")
+	f.StringVar(&cfg.MasterName, prefix+"redis.master-name", "", description+"Name of the master monitored by the Redis Sentinels of the endpoint. If empty, Redis Sentinel is not used.")
+	f.BoolVar(&cfg.Cluster, prefix+"redis.cluster", false, description+"Use Redis cluster with a single endpoint, e.g. the configuration endpoint of the cluster. Redis cluster is always used with several endpoints without master name.")
+	f.DurationVar(&cfg.Timeout, prefix+"redis.timeout", 100*time.Millisecond, description+"Maximum time to wait before giving up on redis requests.")
+	f.DurationVar(&cfg.Expiration, prefix+"redis.expiration", 0, description+"How long keys stay in the redis.")
+	f.IntVar(&cfg.DB, prefix+"redis.db", 0, description+"Database index, not supported by Redis cluster.")
+	f.IntVar(&cfg.PoolSize, prefix+"redis.pool-size", 0, description+"Maximum number of connections in the pool of each Redis server; 0 for 10 per CPU.")
+	f.StringVar(&cfg.Password, prefix+"redis.password", "", description+"Password to use when connecting to redis.")
+	f.BoolVar(&cfg.EnableTLS, prefix+"redis.enable-tls", false, description+"Enable connecting to redis with TLS.")
+	f.BoolVar(&cfg.InsecureSkipVerify, prefix+"redis.tls-insecure-skip-verify", false, description+"Skip validating the certificate of the redis servers.")
+	f.DurationVar(&cfg.IdleTimeout, prefix+"redis.idle-timeout", 0, description+"Close the connections idle for longer than this duration; 0 to disable.")
+	f.DurationVar(&cfg.MaxConnAge, prefix+"redis.max-connection-age", 0, description+"Close the connections older than this duration; 0 to disable.")
+}
+
+// RedisClient is a redis client for a single server, a Redis cluster or the
+// master monitored by Redis Sentinels, sending the commands of the keys of a
+// request in a pipeline.
+type RedisClient struct {
+	expiration time.Duration
+	rdb        redis.UniversalClient
+}
+
+// NewRedisClient creates a new RedisClient.
+func NewRedisClient(cfg *RedisConfig) *RedisClient {
+	opt := &redis.UniversalOptions{
+		Addrs:        strings.Split(cfg.Endpoint, ","),
+		MasterName:   cfg.MasterName,
+		DB:           cfg.DB,
+		Password:     cfg.Password,
+		DialTimeout:  cfg.Timeout,
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+		PoolSize:     cfg.PoolSize,
+		IdleTimeout:  cfg.IdleTimeout,
+		MaxConnAge:   cfg.MaxConnAge,
+	}
+	if cfg.EnableTLS {
+		opt.TLSConfig = &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	}
+
+	var rdb redis.UniversalClient
+	if cfg.Cluster && cfg.MasterName == "" {
+		rdb = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        opt.Addrs,
+			Password:     opt.Password,
+			DialTimeout:  opt.DialTimeout,
+			ReadTimeout:  opt.ReadTimeout,
+			WriteTimeout: opt.WriteTimeout,
+			PoolSize:     opt.PoolSize,
+			IdleTimeout:  opt.IdleTimeout,
+			MaxConnAge:   opt.MaxConnAge,
+			TLSConfig:    opt.TLSConfig,
+		})
+	} else {
+		rdb = redis.NewUniversalClient(opt)
+	}
+
+	return &RedisClient{
+		expiration: cfg.Expiration,
+		rdb:        rdb,
+	}
+}
+
+// Ping checks the connection to redis.
+func (c *RedisClient) Ping() error {
+	return c.rdb.Ping().Err()
+}
+
+// MSet sets the values of the keys, with the expiration of the client.
+func (c *RedisClient) MSet(keys []string, values [][]byte) error {
+	_, err := c.rdb.Pipelined(func(pipe redis.Pipeliner) error {
+		for i := range keys {
+			pipe.Set(keys[i], values[i], c.expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+// MGet returns the values of the keys, in order; nil for the missing keys.
+// The keys can be in different slots of a Redis cluster, unlike with MGET.
+func (c *RedisClient) MGet(keys []string) ([][]byte, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, err := c.rdb.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(key)
+		}
+		return nil
+	})
+	// The pipeline returns the first error of its commands, including
+	// redis.Nil for the missing keys.
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Bytes()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Close closes the connections to redis.
+func (c *RedisClient) Close() error {
+	return c.rdb.Close()
+}
@@ -41,10 +41,11 @@ func Fixtures() ([]testutils.Fixture, error) {
 	}
 
 	cfg := Config{
-		Addresses:         addresses,
-		Keyspace:          "test",
-		Consistency:       "QUORUM",
-		ReplicationFactor: 1,
+		Addresses:           addresses,
+		Keyspace:            "test",
+		Consistency:         "QUORUM",
+		HostSelectionPolicy: HostSelectionRoundRobin,
+		ReplicationFactor:   1,
 	}
 
 	// Get a SchemaConfig with the defaults.
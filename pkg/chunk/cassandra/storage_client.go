@@ -4,11 +4,13 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"time"
 
 	"github.com/gocql/gocql"
 	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/chunk/util"
@@ -18,22 +20,40 @@ const (
 	maxRowReads = 100
 )
 
+// The host selection policies.
+const (
+	HostSelectionTokenAware = "token-aware"
+	HostSelectionRoundRobin = "round-robin"
+)
+
 // Config for a StorageClient
 type Config struct {
-	Addresses                string        `yaml:"addresses,omitempty"`
-	Port                     int           `yaml:"port,omitempty"`
-	Keyspace                 string        `yaml:"keyspace,omitempty"`
-	Consistency              string        `yaml:"consistency,omitempty"`
-	ReplicationFactor        int           `yaml:"replication_factor,omitempty"`
-	DisableInitialHostLookup bool          `yaml:"disable_initial_host_lookup,omitempty"`
-	SSL                      bool          `yaml:"SSL,omitempty"`
-	HostVerification         bool          `yaml:"host_verification,omitempty"`
-	CAPath                   string        `yaml:"CA_path,omitempty"`
-	Auth                     bool          `yaml:"auth,omitempty"`
-	Username                 string        `yaml:"username,omitempty"`
-	Password                 string        `yaml:"password,omitempty"`
-	Timeout                  time.Duration `yaml:"timeout,omitempty"`
-	ConnectTimeout           time.Duration `yaml:"connect_timeout,omitempty"`
+	Addresses                string           `yaml:"addresses,omitempty"`
+	Port                     int              `yaml:"port,omitempty"`
+	Keyspace                 string           `yaml:"keyspace,omitempty"`
+	Consistency              string           `yaml:"consistency,omitempty"`
+	ReadConsistency          string           `yaml:"read_consistency,omitempty"`
+	WriteConsistency         string           `yaml:"write_consistency,omitempty"`
+	ReplicationFactor        int              `yaml:"replication_factor,omitempty"`
+	DisableInitialHostLookup bool             `yaml:"disable_initial_host_lookup,omitempty"`
+	HostSelectionPolicy      string           `yaml:"host_selection_policy,omitempty"`
+	LocalDC                  string           `yaml:"local_dc,omitempty"`
+	SSL                      bool             `yaml:"SSL,omitempty"`
+	HostVerification         bool             `yaml:"host_verification,omitempty"`
+	CAPath                   string           `yaml:"CA_path,omitempty"`
+	CertPath                 string           `yaml:"cert_path,omitempty"`
+	KeyPath                  string           `yaml:"key_path,omitempty"`
+	Auth                     bool             `yaml:"auth,omitempty"`
+	Username                 string           `yaml:"username,omitempty"`
+	Password                 string           `yaml:"password,omitempty"`
+	PasswordFile             string           `yaml:"password_file,omitempty"`
+	Timeout                  time.Duration    `yaml:"timeout,omitempty"`
+	ConnectTimeout           time.Duration    `yaml:"connect_timeout,omitempty"`
+	NumConnections           int              `yaml:"num_connections,omitempty"`
+	MaxRetries               int              `yaml:"max_retries,omitempty"`
+	MinBackoff               time.Duration    `yaml:"retry_min_backoff,omitempty"`
+	MaxBackoff               time.Duration    `yaml:"retry_max_backoff,omitempty"`
+	ChunkFetch               util.FetchConfig `yaml:"chunk_fetch,omitempty"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet
@@ -42,16 +62,66 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&cfg.Port, "cassandra.port", 9042, "Port that Cassandra is running on")
 	f.StringVar(&cfg.Keyspace, "cassandra.keyspace", "", "Keyspace to use in Cassandra.")
 	f.StringVar(&cfg.Consistency, "cassandra.consistency", "QUORUM", "Consistency level for Cassandra.")
+	f.StringVar(&cfg.ReadConsistency, "cassandra.read-consistency", "", "Consistency level of the reads, -cassandra.consistency if empty.")
+	f.StringVar(&cfg.WriteConsistency, "cassandra.write-consistency", "", "Consistency level of the writes and deletes, -cassandra.consistency if empty.")
 	f.IntVar(&cfg.ReplicationFactor, "cassandra.replication-factor", 1, "Replication factor to use in Cassandra.")
 	f.BoolVar(&cfg.DisableInitialHostLookup, "cassandra.disable-initial-host-lookup", false, "Instruct the cassandra driver to not attempt to get host info from the system.peers table.")
+	f.StringVar(&cfg.HostSelectionPolicy, "cassandra.host-selection-policy", HostSelectionRoundRobin, "Policy selecting the hosts of the queries: round-robin, or token-aware, to query the replicas of the rows first.")
+	f.StringVar(&cfg.LocalDC, "cassandra.local-dc", "", "If set, only the hosts of this datacenter are queried, but when none of them is up.")
 	f.BoolVar(&cfg.SSL, "cassandra.ssl", false, "Use SSL when connecting to cassandra instances.")
 	f.BoolVar(&cfg.HostVerification, "cassandra.host-verification", true, "Require SSL certificate validation.")
 	f.StringVar(&cfg.CAPath, "cassandra.ca-path", "", "Path to certificate file to verify the peer.")
+	f.StringVar(&cfg.CertPath, "cassandra.tls-cert-path", "", "Path to the client certificate file, to authenticate with it when using SSL.")
+	f.StringVar(&cfg.KeyPath, "cassandra.tls-key-path", "", "Path to the key file of the client certificate.")
 	f.BoolVar(&cfg.Auth, "cassandra.auth", false, "Enable password authentication when connecting to cassandra.")
 	f.StringVar(&cfg.Username, "cassandra.username", "", "Username to use when connecting to cassandra.")
 	f.StringVar(&cfg.Password, "cassandra.password", "", "Password to use when connecting to cassandra.")
+	f.StringVar(&cfg.PasswordFile, "cassandra.password-file", "", "File containing the password to use when connecting to cassandra, instead of -cassandra.password.")
 	f.DurationVar(&cfg.Timeout, "cassandra.timeout", 600*time.Millisecond, "Timeout when connecting to cassandra.")
 	f.DurationVar(&cfg.ConnectTimeout, "cassandra.connect-timeout", 600*time.Millisecond, "Initial connection timeout, used during initial dial to server.")
+	f.IntVar(&cfg.NumConnections, "cassandra.num-connections", 2, "Number of connections per host.")
+	f.IntVar(&cfg.MaxRetries, "cassandra.max-retries", 0, "Number of times to retry the failed queries, with exponential backoff.")
+	f.DurationVar(&cfg.MinBackoff, "cassandra.retry-min-backoff", 100*time.Millisecond, "Minimum time to wait before retrying a failed query.")
+	f.DurationVar(&cfg.MaxBackoff, "cassandra.retry-max-backoff", 10*time.Second, "Maximum time to wait before retrying a failed query.")
+	cfg.ChunkFetch.RegisterFlagsWithPrefix("cassandra", f)
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	for _, c := range []string{cfg.Consistency, cfg.ReadConsistency, cfg.WriteConsistency} {
+		if c == "" {
+			continue
+		}
+		if _, err := gocql.ParseConsistencyWrapper(c); err != nil {
+			return err
+		}
+	}
+	if cfg.HostSelectionPolicy != HostSelectionTokenAware && cfg.HostSelectionPolicy != HostSelectionRoundRobin {
+		return fmt.Errorf("unknown host selection policy %q", cfg.HostSelectionPolicy)
+	}
+	if (cfg.CertPath == "") != (cfg.KeyPath == "") {
+		return errors.New("both the client certificate and key paths have to be set")
+	}
+	if cfg.Password != "" && cfg.PasswordFile != "" {
+		return errors.New("the password and the password file can't both be set")
+	}
+	return nil
+}
+
+// consistencies returns the consistency levels of the reads and writes.
+func (cfg *Config) consistencies() (read, write gocql.Consistency, err error) {
+	parse := func(c string) (gocql.Consistency, error) {
+		if c == "" {
+			c = cfg.Consistency
+		}
+		consistency, err := gocql.ParseConsistencyWrapper(c)
+		return consistency, errors.WithStack(err)
+	}
+	if read, err = parse(cfg.ReadConsistency); err != nil {
+		return
+	}
+	write, err = parse(cfg.WriteConsistency)
+	return
 }
 
 func (cfg *Config) session() (*gocql.Session, error) {
@@ -59,6 +129,9 @@ func (cfg *Config) session() (*gocql.Session, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	if err := cfg.createKeyspace(); err != nil {
 		return nil, errors.WithStack(err)
@@ -72,27 +145,63 @@ func (cfg *Config) session() (*gocql.Session, error) {
 	cluster.QueryObserver = observer{}
 	cluster.Timeout = cfg.Timeout
 	cluster.ConnectTimeout = cfg.ConnectTimeout
-	cfg.setClusterConfig(cluster)
+	if cfg.NumConnections > 0 {
+		cluster.NumConns = cfg.NumConnections
+	}
+	if cfg.MaxRetries > 0 {
+		cluster.RetryPolicy = &gocql.ExponentialBackoffRetryPolicy{
+			NumRetries: cfg.MaxRetries,
+			Min:        cfg.MinBackoff,
+			Max:        cfg.MaxBackoff,
+		}
+	}
+	if err := cfg.setClusterConfig(cluster); err != nil {
+		return nil, err
+	}
 
 	return cluster.CreateSession()
 }
 
 // apply config settings to a cassandra ClusterConfig
-func (cfg *Config) setClusterConfig(cluster *gocql.ClusterConfig) {
+func (cfg *Config) setClusterConfig(cluster *gocql.ClusterConfig) error {
 	cluster.DisableInitialHostLookup = cfg.DisableInitialHostLookup
 
+	// The DC-aware policy queries the hosts of the other datacenters only if
+	// none of the local ones is up.
+	var policy gocql.HostSelectionPolicy
+	if cfg.LocalDC != "" {
+		policy = gocql.DCAwareRoundRobinPolicy(cfg.LocalDC)
+	} else {
+		policy = gocql.RoundRobinHostPolicy()
+	}
+	if cfg.HostSelectionPolicy == HostSelectionTokenAware {
+		policy = gocql.TokenAwareHostPolicy(policy)
+	}
+	cluster.PoolConfig.HostSelectionPolicy = policy
+
 	if cfg.SSL {
 		cluster.SslOpts = &gocql.SslOptions{
 			CaPath:                 cfg.CAPath,
+			CertPath:               cfg.CertPath,
+			KeyPath:                cfg.KeyPath,
 			EnableHostVerification: cfg.HostVerification,
 		}
 	}
 	if cfg.Auth {
+		password := cfg.Password
+		if cfg.PasswordFile != "" {
+			buf, err := ioutil.ReadFile(cfg.PasswordFile)
+			if err != nil {
+				return errors.Wrap(err, "reading the password file")
+			}
+			password = strings.TrimSpace(string(buf))
+		}
 		cluster.Authenticator = gocql.PasswordAuthenticator{
 			Username: cfg.Username,
-			Password: cfg.Password,
+			Password: password,
 		}
 	}
+	return nil
 }
 
 // createKeyspace will create the desired keyspace if it doesn't exist.
@@ -103,7 +212,9 @@ func (cfg *Config) createKeyspace() error {
 	cluster.Timeout = 20 * time.Second
 	cluster.ConnectTimeout = 20 * time.Second
 
-	cfg.setClusterConfig(cluster)
+	if err := cfg.setClusterConfig(cluster); err != nil {
+		return err
+	}
 
 	session, err := cluster.CreateSession()
 	if err != nil {
@@ -126,19 +237,30 @@ type StorageClient struct {
 	cfg       Config
 	schemaCfg chunk.SchemaConfig
 	session   *gocql.Session
+
+	readConsistency, writeConsistency gocql.Consistency
+	fetcher                           *util.ParallelFetcher
 }
 
 // NewStorageClient returns a new StorageClient.
 func NewStorageClient(cfg Config, schemaCfg chunk.SchemaConfig) (*StorageClient, error) {
+	readConsistency, writeConsistency, err := cfg.consistencies()
+	if err != nil {
+		return nil, err
+	}
+
 	session, err := cfg.session()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	client := &StorageClient{
-		cfg:       cfg,
-		schemaCfg: schemaCfg,
-		session:   session,
+		cfg:              cfg,
+		schemaCfg:        schemaCfg,
+		session:          session,
+		readConsistency:  readConsistency,
+		writeConsistency: writeConsistency,
+		fetcher:          util.NewParallelFetcher("cassandra", cfg.ChunkFetch),
 	}
 	return client, nil
 }
@@ -174,7 +296,7 @@ func (s *StorageClient) BatchWrite(ctx context.Context, batch chunk.WriteBatch)
 
 	for _, entry := range b.entries {
 		err := s.session.Query(fmt.Sprintf("INSERT INTO %s (hash, range, value) VALUES (?, ?, ?)",
-			entry.TableName), entry.HashValue, entry.RangeValue, entry.Value).WithContext(ctx).Exec()
+			entry.TableName), entry.HashValue, entry.RangeValue, entry.Value).Consistency(s.writeConsistency).WithContext(ctx).Exec()
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -183,6 +305,37 @@ func (s *StorageClient) BatchWrite(ctx context.Context, batch chunk.WriteBatch)
 	return nil
 }
 
+// DeleteEntries implements chunk.IndexDeleter.
+func (s *StorageClient) DeleteEntries(ctx context.Context, entries []chunk.IndexEntry) error {
+	for _, entry := range entries {
+		err := s.session.Query(fmt.Sprintf("DELETE FROM %s WHERE hash = ? AND range = ?",
+			entry.TableName), entry.HashValue, entry.RangeValue).Consistency(s.writeConsistency).WithContext(ctx).Exec()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// ScanTable implements chunk.IndexScanner.
+func (s *StorageClient) ScanTable(ctx context.Context, tableName string, callback func(chunk.IndexEntry) bool) error {
+	iter := s.session.Query(fmt.Sprintf("SELECT hash, range, value FROM %s", tableName)).
+		Consistency(s.readConsistency).WithContext(ctx).Iter()
+	defer iter.Close()
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		entry := chunk.IndexEntry{TableName: tableName}
+		if err := scanner.Scan(&entry.HashValue, &entry.RangeValue, &entry.Value); err != nil {
+			return errors.WithStack(err)
+		}
+		if !callback(entry) {
+			return nil
+		}
+	}
+	return errors.WithStack(scanner.Err())
+}
+
 // QueryPages implement chunk.IndexClient.
 func (s *StorageClient) QueryPages(ctx context.Context, queries []chunk.IndexQuery, callback func(chunk.IndexQuery, chunk.ReadBatch) bool) error {
 	return util.DoParallelQueries(ctx, s.query, queries, callback)
@@ -217,7 +370,7 @@ func (s *StorageClient) query(ctx context.Context, query chunk.IndexQuery, callb
 			query.TableName), query.HashValue, query.ValueEqual)
 	}
 
-	iter := q.WithContext(ctx).Iter()
+	iter := q.Consistency(s.readConsistency).WithContext(ctx).Iter()
 	defer iter.Close()
 	scanner := iter.Scanner()
 	for scanner.Next() {
@@ -282,7 +435,7 @@ func (s *StorageClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) err
 		// Must provide a range key, even though its not useds - hence 0x00.
 		q := s.session.Query(fmt.Sprintf("INSERT INTO %s (hash, range, value) VALUES (?, 0x00, ?)",
 			tableName), key, buf)
-		if err := q.WithContext(ctx).Exec(); err != nil {
+		if err := q.Consistency(s.writeConsistency).WithContext(ctx).Exec(); err != nil {
 			return errors.WithStack(err)
 		}
 	}
@@ -290,9 +443,55 @@ func (s *StorageClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) err
 	return nil
 }
 
+// DeleteChunk implements chunk.ObjectDeleter.
+func (s *StorageClient) DeleteChunk(ctx context.Context, c chunk.Chunk) error {
+	tableName, err := s.schemaCfg.ChunkTableFor(c.From)
+	if err != nil {
+		return err
+	}
+
+	q := s.session.Query(fmt.Sprintf("DELETE FROM %s WHERE hash = ? AND range = 0x00",
+		tableName), c.ExternalKey())
+	return errors.WithStack(q.Consistency(s.writeConsistency).WithContext(ctx).Exec())
+}
+
+// ListChunks implements chunk.ObjectLister, scanning the keys of the chunk
+// tables of the schema which exist.
+func (s *StorageClient) ListChunks(ctx context.Context, userID string) ([]string, error) {
+	md, err := s.session.KeyspaceMetadata(s.cfg.Keyspace)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var keys []string
+	prefix := userID + "/"
+	for _, tableName := range s.schemaCfg.ChunkTables(model.Now()) {
+		if _, ok := md.Tables[tableName]; !ok {
+			continue
+		}
+		iter := s.session.Query(fmt.Sprintf("SELECT DISTINCT hash FROM %s", tableName)).
+			Consistency(s.readConsistency).WithContext(ctx).Iter()
+		scanner := iter.Scanner()
+		for scanner.Next() {
+			var key string
+			if err := scanner.Scan(&key); err != nil {
+				iter.Close()
+				return nil, errors.WithStack(err)
+			}
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return keys, nil
+}
+
 // GetChunks implements chunk.ObjectClient.
 func (s *StorageClient) GetChunks(ctx context.Context, input []chunk.Chunk) ([]chunk.Chunk, error) {
-	return util.GetParallelChunks(ctx, input, s.getChunk)
+	return s.fetcher.GetChunks(ctx, input, s.getChunk)
 }
 
 func (s *StorageClient) getChunk(ctx context.Context, decodeContext *chunk.DecodeContext, input chunk.Chunk) (chunk.Chunk, error) {
@@ -303,7 +502,7 @@ func (s *StorageClient) getChunk(ctx context.Context, decodeContext *chunk.Decod
 
 	var buf []byte
 	if err := s.session.Query(fmt.Sprintf("SELECT value FROM %s WHERE hash = ?", tableName), input.ExternalKey()).
-		WithContext(ctx).Scan(&buf); err != nil {
+		Consistency(s.readConsistency).WithContext(ctx).Scan(&buf); err != nil {
 		return input, errors.WithStack(err)
 	}
 	err = input.Decode(decodeContext, buf)
@@ -0,0 +1,106 @@
+package cassandra
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func defaultConfig() Config {
+	var cfg Config
+	cfg.RegisterFlags(flag.NewFlagSet("test", flag.PanicOnError))
+	return cfg
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		modify func(*Config)
+		valid  bool
+	}{
+		"defaults": {
+			modify: func(*Config) {},
+			valid:  true,
+		},
+		"read and write consistencies": {
+			modify: func(cfg *Config) {
+				cfg.ReadConsistency = "LOCAL_ONE"
+				cfg.WriteConsistency = "EACH_QUORUM"
+			},
+			valid: true,
+		},
+		"unknown consistency": {
+			modify: func(cfg *Config) { cfg.WriteConsistency = "MOST" },
+		},
+		"unknown host selection policy": {
+			modify: func(cfg *Config) { cfg.HostSelectionPolicy = "random" },
+		},
+		"certificate without key": {
+			modify: func(cfg *Config) { cfg.CertPath = "client.crt" },
+		},
+		"password and password file": {
+			modify: func(cfg *Config) {
+				cfg.Password = "secret"
+				cfg.PasswordFile = "password"
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tc.modify(&cfg)
+			err := cfg.Validate()
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestConfig_Consistencies(t *testing.T) {
+	cfg := defaultConfig()
+	read, write, err := cfg.consistencies()
+	require.NoError(t, err)
+	assert.Equal(t, gocql.Quorum, read)
+	assert.Equal(t, gocql.Quorum, write)
+
+	cfg.ReadConsistency = "LOCAL_ONE"
+	read, write, err = cfg.consistencies()
+	require.NoError(t, err)
+	assert.Equal(t, gocql.LocalOne, read)
+	assert.Equal(t, gocql.Quorum, write)
+}
+
+func TestConfig_SetClusterConfig(t *testing.T) {
+	passwordFile, err := ioutil.TempFile("", "cassandra-password")
+	require.NoError(t, err)
+	defer os.Remove(passwordFile.Name())
+	_, err = passwordFile.WriteString("secret\n")
+	require.NoError(t, err)
+	require.NoError(t, passwordFile.Close())
+
+	cfg := defaultConfig()
+	cfg.LocalDC = "dc1"
+	cfg.SSL = true
+	cfg.CertPath = "client.crt"
+	cfg.KeyPath = "client.key"
+	cfg.Auth = true
+	cfg.Username = "cortex"
+	cfg.PasswordFile = passwordFile.Name()
+
+	cluster := gocql.NewCluster("localhost")
+	require.NoError(t, cfg.setClusterConfig(cluster))
+
+	assert.NotNil(t, cluster.PoolConfig.HostSelectionPolicy)
+	assert.Equal(t, "client.crt", cluster.SslOpts.CertPath)
+	assert.Equal(t, "client.key", cluster.SslOpts.KeyPath)
+	assert.Equal(t, gocql.PasswordAuthenticator{Username: "cortex", Password: "secret"}, cluster.Authenticator)
+
+	cfg.PasswordFile = passwordFile.Name() + ".missing"
+	require.Error(t, cfg.setClusterConfig(gocql.NewCluster("localhost")))
+}
@@ -26,6 +26,7 @@ const (
 	ErrWrongMetadata   = errs.Error("wrong chunk metadata")
 	ErrMetadataLength  = errs.Error("chunk metadata wrong length")
 	ErrDataLength      = errs.Error("chunk data wrong length")
+	ErrEncryptedChunk  = errs.Error("chunk encrypted but no cipher to decrypt it")
 )
 
 var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
@@ -63,6 +64,9 @@ type Chunk struct {
 
 	// The encoded version of the chunk, held so we don't need to re-encode it
 	encoded []byte
+
+	// Set by the encrypting object client, to decrypt the chunk decoded.
+	decrypter *chunkDecrypter
 }
 
 // NewChunk creates a new chunk
@@ -269,6 +273,28 @@ func (c *Chunk) Decode(decodeContext *DecodeContext, input []byte) error {
 		return errors.Wrap(c.Data.UnmarshalFromBuf(input), "when unmarshalling legacy chunk")
 	}
 
+	// The chunks written by the encrypting object client are decrypted first.
+	if isEncrypted(input) {
+		if c.decrypter == nil {
+			return errors.WithStack(ErrEncryptedChunk)
+		}
+		plaintext, err := c.decrypter.decrypt(c, input)
+		if err != nil {
+			return errors.Wrap(err, "when decrypting chunk")
+		}
+		input = plaintext
+	}
+
+	// The chunks written by the compressing object client are compressed
+	// before being encrypted, so they're decompressed after.
+	if isCompressed(input) {
+		decompressed, err := decompress(input)
+		if err != nil {
+			return errors.Wrap(err, "when decompressing chunk")
+		}
+		input = decompressed
+	}
+
 	// First, calculate the checksum of the chunk and confirm it matches
 	// what we expected.
 	if c.ChecksumSet && c.Checksum != crc32.Checksum(input, castagnoliTable) {
@@ -0,0 +1,28 @@
+package chunk
+
+import "context"
+
+// ChunkRefsLimiter limits the chunks fetched by a query. It's given the
+// chunks found in the index, before any of them is fetched, so that a query
+// over the limits fails without loading their data.
+type ChunkRefsLimiter interface {
+	AddChunkRefs(chunks []Chunk) error
+}
+
+type chunkRefsLimiterKey int
+
+// WithChunkRefsLimiter returns a context limiting the chunks fetched by the
+// queries run with it by l.
+func WithChunkRefsLimiter(ctx context.Context, l ChunkRefsLimiter) context.Context {
+	return context.WithValue(ctx, chunkRefsLimiterKey(0), l)
+}
+
+// LimitChunkRefs passes the chunks a store is about to fetch to the limiter of
+// the context, if any.
+func LimitChunkRefs(ctx context.Context, chunks []Chunk) error {
+	l, ok := ctx.Value(chunkRefsLimiterKey(0)).(ChunkRefsLimiter)
+	if !ok {
+		return nil
+	}
+	return l.AddChunkRefs(chunks)
+}
@@ -51,6 +51,10 @@ type StoreConfig struct {
 	// Limits query start time to be greater than now() - MaxLookBackPeriod, if set.
 	MaxLookBackPeriod time.Duration `yaml:"max_look_back_period"`
 
+	// Fail queries missing some of the chunks found in the index, after retrying them.
+	ConsistencyCheck        bool `yaml:"consistency_check"`
+	ConsistencyCheckRetries int  `yaml:"consistency_check_retries"`
+
 	// Not visible in yaml because the setting shouldn't be common between ingesters and queriers
 	chunkCacheStubs bool // don't write the full chunk to cache, just a stub entry
 }
@@ -64,12 +68,28 @@ func (cfg *StoreConfig) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.MinChunkAge, "store.min-chunk-age", 0, "Minimum time between chunk update and being saved to the store.")
 	f.DurationVar(&cfg.CacheLookupsOlderThan, "store.cache-lookups-older-than", 0, "Cache index entries older than this period. 0 to disable.")
 	f.DurationVar(&cfg.MaxLookBackPeriod, "store.max-look-back-period", 0, "Limit how long back data can be queried")
+	f.BoolVar(&cfg.ConsistencyCheck, "store.consistency-check", false, "Fail queries for which some of the chunks found in the index could not be fetched from the chunk store, rather than returning partial results.")
+	f.IntVar(&cfg.ConsistencyCheckRetries, "store.consistency-check-retries", 3, "Number of times to retry fetching the missing chunks before failing the query, if -store.consistency-check is enabled.")
 
 	// Deprecated.
 	flagext.DeprecatedFlag(f, "store.cardinality-cache-size", "DEPRECATED. Use store.index-cache-read.enable-fifocache and store.index-cache-read.fifocache.size instead.")
 	flagext.DeprecatedFlag(f, "store.cardinality-cache-validity", "DEPRECATED. Use store.index-cache-read.enable-fifocache and store.index-cache-read.fifocache.duration instead.")
 }
 
+// Validate validates the config, given the retention period of the tables,
+// 0 if they're kept forever.
+func (cfg *StoreConfig) Validate(retentionPeriod time.Duration) error {
+	// The series entries found in the write dedupe cache aren't written, so
+	// they must expire before the tables they were written to are deleted.
+	if retentionPeriod > 0 && cfg.WriteDedupeCacheConfig.IsEnabled() {
+		validity := cfg.WriteDedupeCacheConfig.Validity()
+		if validity == 0 || validity >= retentionPeriod {
+			return fmt.Errorf("the entries of the index write cache (-store.index-cache-write.*) must expire before the retention period of the tables, %s", retentionPeriod)
+		}
+	}
+	return nil
+}
+
 // store implements Store
 type store struct {
 	cfg StoreConfig
@@ -82,7 +102,7 @@ type store struct {
 }
 
 func newStore(cfg StoreConfig, schema Schema, index IndexClient, chunks ObjectClient, limits StoreLimits) (Store, error) {
-	fetcher, err := NewChunkFetcher(cfg.ChunkCacheConfig, cfg.chunkCacheStubs, chunks)
+	fetcher, err := newStoreFetcher(cfg, chunks)
 	if err != nil {
 		return nil, err
 	}
@@ -132,6 +152,134 @@ func (c *store) PutOne(ctx context.Context, from, through model.Time, chunk Chun
 	return c.index.BatchWrite(ctx, writeReqs)
 }
 
+// DeleteChunk implements Store
+func (c *store) DeleteChunk(ctx context.Context, from, through model.Time, chunk Chunk) error {
+	metricName := chunk.Metric.Get(labels.MetricName)
+	if metricName == "" {
+		return fmt.Errorf("no MetricNameLabel for chunk")
+	}
+
+	entries, err := c.schema.GetWriteEntries(from, through, chunk.UserID, metricName, chunk.Metric, chunk.ExternalKey())
+	if err != nil {
+		return err
+	}
+	return c.deleteChunk(ctx, entries, chunk)
+}
+
+// deleteChunk deletes the index entries of a chunk, so that the queries
+// don't find it anymore, then the chunk itself.
+func (c *store) deleteChunk(ctx context.Context, entries []IndexEntry, chunk Chunk) error {
+	index, ok := c.index.(IndexDeleter)
+	if !ok {
+		return fmt.Errorf("deleting the index entries of the chunks is not supported by %T", c.index)
+	}
+	objects, ok := c.chunks.(ObjectDeleter)
+	if !ok {
+		return fmt.Errorf("deleting the chunks is not supported by %T", c.chunks)
+	}
+
+	if err := index.DeleteEntries(ctx, entries); err != nil {
+		return err
+	}
+	return objects.DeleteChunk(ctx, chunk)
+}
+
+// DeleteUserChunks implements Store
+func (c *store) DeleteUserChunks(ctx context.Context, userID string, from, through model.Time) (int, error) {
+	return c.deleteUserChunks(ctx, userID, from, through, c.schema.GetWriteEntries)
+}
+
+// CheckDeleteUserChunks implements Store.
+func (c *store) CheckDeleteUserChunks() error {
+	if _, ok := c.chunks.(ObjectLister); !ok {
+		return fmt.Errorf("listing the chunks is not supported by %T", c.chunks)
+	}
+	if _, ok := c.index.(IndexDeleter); !ok {
+		return fmt.Errorf("deleting the index entries of the chunks is not supported by %T", c.index)
+	}
+	if _, ok := c.chunks.(ObjectDeleter); !ok {
+		return fmt.Errorf("deleting the chunks is not supported by %T", c.chunks)
+	}
+	return nil
+}
+
+// The chunks of a tenant are fetched and deleted in batches.
+const deleteUserChunksBatchSize = 100
+
+// deleteUserChunks deletes the index entries between from and through of the
+// chunks of a tenant overlapping them, given by entries. A chunk itself is
+// only deleted with the entries of the period it ends in, as the stores of
+// the periods it spans may share its object.
+func (c *store) deleteUserChunks(ctx context.Context, userID string, from, through model.Time,
+	entries func(from, through model.Time, userID string, metricName string, labels labels.Labels, chunkID string) ([]IndexEntry, error)) (int, error) {
+	if err := c.CheckDeleteUserChunks(); err != nil {
+		return 0, err
+	}
+	lister, index, objects := c.chunks.(ObjectLister), c.index.(IndexDeleter), c.chunks.(ObjectDeleter)
+
+	keys, err := lister.ListChunks(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	var chunks []Chunk
+	for _, key := range keys {
+		chunk, err := ParseExternalKey(userID, key)
+		if err != nil {
+			return 0, err
+		}
+		if chunk.Through < from || chunk.From > through {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	deleted := 0
+	for len(chunks) > 0 {
+		batch := chunks
+		if len(batch) > deleteUserChunksBatchSize {
+			batch = batch[:deleteUserChunksBatchSize]
+		}
+		chunks = chunks[len(batch):]
+
+		// The labels of the chunks are needed to find their index entries.
+		batch, err := c.chunks.GetChunks(ctx, batch)
+		if err != nil {
+			return deleted, err
+		}
+		for _, chunk := range batch {
+			es, err := entries(maxTime(from, chunk.From), minTime(through, chunk.Through), userID, chunk.Metric.Get(labels.MetricName), chunk.Metric, chunk.ExternalKey())
+			if err != nil {
+				return deleted, err
+			}
+			if err := index.DeleteEntries(ctx, es); err != nil {
+				return deleted, err
+			}
+			if chunk.Through > through {
+				continue
+			}
+			if err := objects.DeleteChunk(ctx, chunk); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func minTime(a, b model.Time) model.Time {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b model.Time) model.Time {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // calculateIndexEntries creates a set of batched WriteRequests for all the chunks it is given.
 func (c *store) calculateIndexEntries(userID string, from, through model.Time, chunk Chunk) (WriteBatch, error) {
 	seenIndexEntries := map[string]struct{}{}
@@ -165,6 +313,13 @@ func (c *store) Get(ctx context.Context, userID string, from, through model.Time
 	defer log.Span.Finish()
 	level.Debug(log).Log("from", from, "through", through, "matchers", len(allMatchers))
 
+	// The index of these schemas isn't sharded: the chunks are filtered once
+	// fetched.
+	shard, allMatchers, err := ExtractQueryShard(allMatchers)
+	if err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "%v", err)
+	}
+
 	// Validate the query is within reasonable bounds.
 	metricName, matchers, shortcut, err := c.validateQuery(ctx, userID, &from, &through, allMatchers)
 	if err != nil {
@@ -174,7 +329,11 @@ func (c *store) Get(ctx context.Context, userID string, from, through model.Time
 	}
 
 	log.Span.SetTag("metric", metricName)
-	return c.getMetricNameChunks(ctx, userID, from, through, matchers, metricName)
+	chunks, err := c.getMetricNameChunks(ctx, userID, from, through, matchers, metricName)
+	if err != nil {
+		return nil, err
+	}
+	return filterChunksByQueryShard(chunks, shard), nil
 }
 
 func (c *store) GetChunkRefs(ctx context.Context, userID string, from, through model.Time, allMatchers ...*labels.Matcher) ([][]Chunk, []*Fetcher, error) {
@@ -182,10 +341,11 @@ func (c *store) GetChunkRefs(ctx context.Context, userID string, from, through m
 }
 
 // LabelValuesForMetricName retrieves all label values for a single label name and metric name.
-func (c *store) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName, labelName string) ([]string, error) {
+// With matchers, only the values of the chunks matching them are returned.
+func (c *store) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName, labelName string, matchers ...*labels.Matcher) ([]string, error) {
 	log, ctx := spanlogger.New(ctx, "ChunkStore.LabelValues")
 	defer log.Span.Finish()
-	level.Debug(log).Log("from", from, "through", through, "metricName", metricName, "labelName", labelName)
+	level.Debug(log).Log("from", from, "through", through, "metricName", metricName, "labelName", labelName, "matchers", len(matchers))
 
 	shortcut, err := c.validateQueryTimeRange(ctx, userID, &from, &through)
 	if err != nil {
@@ -194,6 +354,30 @@ func (c *store) LabelValuesForMetricName(ctx context.Context, userID string, fro
 		return nil, nil
 	}
 
+	filters, matchers := util.SplitFiltersAndMatchers(matchers)
+	if len(filters) > 0 {
+		// The empty values can't be looked up in the index.
+		series, err := c.GetSeries(ctx, userID, from, through, WithMetricName(metricName, append(filters, matchers...))...)
+		if err != nil {
+			return nil, err
+		}
+		return labelValuesFromSeries(series, labelName), nil
+	}
+
+	var ids []string
+	if len(matchers) > 0 {
+		ids, err = c.lookupChunkIDsByMetricName(ctx, userID, from, through, matchers, metricName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.lookupLabelValues(ctx, userID, from, through, metricName, labelName, ids, len(matchers) > 0)
+}
+
+// lookupLabelValues returns the values of the label of the metric in the
+// index, only those of the entries of the given chunk or series IDs if
+// filterIDs.
+func (c *store) lookupLabelValues(ctx context.Context, userID string, from, through model.Time, metricName, labelName string, ids []string, filterIDs bool) ([]string, error) {
 	queries, err := c.schema.GetReadQueriesForMetricLabel(from, through, userID, metricName, labelName)
 	if err != nil {
 		return nil, err
@@ -204,12 +388,20 @@ func (c *store) LabelValuesForMetricName(ctx context.Context, userID string, fro
 		return nil, err
 	}
 
+	idSet := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		idSet[id] = struct{}{}
+	}
+
 	var result []string
 	for _, entry := range entries {
-		_, labelValue, _, _, err := parseChunkTimeRangeValue(entry.RangeValue, entry.Value)
+		id, labelValue, _, _, err := parseChunkTimeRangeValue(entry.RangeValue, entry.Value)
 		if err != nil {
 			return nil, err
 		}
+		if _, ok := idSet[id]; filterIDs && !ok {
+			continue
+		}
 		result = append(result, string(labelValue))
 	}
 	sort.Strings(result)
@@ -217,6 +409,23 @@ func (c *store) LabelValuesForMetricName(ctx context.Context, userID string, fro
 	return result, nil
 }
 
+// WithMetricName prepends the equality matcher of the metric name to the matchers.
+func WithMetricName(metricName string, matchers []*labels.Matcher) []*labels.Matcher {
+	return append([]*labels.Matcher{{Type: labels.MatchEqual, Name: model.MetricNameLabel, Value: metricName}}, matchers...)
+}
+
+// labelValuesFromSeries returns the sorted unique values of the label of the series.
+func labelValuesFromSeries(series []labels.Labels, labelName string) []string {
+	var result []string
+	for _, ls := range series {
+		if v := ls.Get(labelName); v != "" {
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return uniqueStrings(result)
+}
+
 // LabelNamesForMetricName retrieves all label names for a metric name.
 func (c *store) LabelNamesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string) ([]string, error) {
 	log, ctx := spanlogger.New(ctx, "ChunkStore.LabelNamesForMetricName")
@@ -250,6 +459,48 @@ func (c *store) LabelNamesForMetricName(ctx context.Context, userID string, from
 	return labelNamesFromChunks(allChunks), nil
 }
 
+// GetSeries returns the labels of the series matching the matchers, looked up
+// in the index, fetching a single chunk of each series for its labels.
+func (c *store) GetSeries(ctx context.Context, userID string, from, through model.Time, allMatchers ...*labels.Matcher) ([]labels.Labels, error) {
+	log, ctx := spanlogger.New(ctx, "ChunkStore.GetSeries")
+	defer log.Span.Finish()
+	level.Debug(log).Log("from", from, "through", through, "matchers", len(allMatchers))
+
+	metricName, matchers, shortcut, err := c.validateQuery(ctx, userID, &from, &through, allMatchers)
+	if err != nil {
+		return nil, err
+	} else if shortcut {
+		return nil, nil
+	}
+
+	filters, matchers := util.SplitFiltersAndMatchers(matchers)
+	chunks, err := c.lookupChunksByMetricName(ctx, userID, from, through, matchers, metricName)
+	if err != nil {
+		return nil, err
+	}
+	return c.seriesFromChunks(ctx, from, through, chunks, filters)
+}
+
+// seriesFromChunks returns the labels of the series of the chunks, fetching a
+// single chunk of each series, and filtering them by the matchers.
+func (c *store) seriesFromChunks(ctx context.Context, from, through model.Time, chunks []Chunk, matchers []*labels.Matcher) ([]labels.Labels, error) {
+	filtered := filterChunksByTime(from, through, chunks)
+	filtered, keys := filterChunksByUniqueFingerprint(filtered)
+	chunksPerQuery.Observe(float64(len(filtered)))
+
+	allChunks, err := c.FetchChunks(ctx, filtered, keys)
+	if err != nil {
+		return nil, promql.ErrStorage{Err: err}
+	}
+	allChunks = filterChunksByMatchers(allChunks, matchers)
+
+	result := make([]labels.Labels, 0, len(allChunks))
+	for _, chunk := range allChunks {
+		result = append(result, chunk.Metric)
+	}
+	return result, nil
+}
+
 func (c *store) validateQueryTimeRange(ctx context.Context, userID string, from *model.Time, through *model.Time) (bool, error) {
 	log, ctx := spanlogger.New(ctx, "store.validateQueryTimeRange")
 	defer log.Span.Finish()
@@ -331,10 +582,13 @@ func (c *store) getMetricNameChunks(ctx context.Context, userID string, from, th
 
 	maxChunksPerQuery := c.limits.MaxChunksPerQuery(userID)
 	if maxChunksPerQuery > 0 && len(filtered) > maxChunksPerQuery {
-		err := httpgrpc.Errorf(http.StatusBadRequest, "Query %v fetched too many chunks (%d > %d)", allMatchers, len(filtered), maxChunksPerQuery)
+		err := util.LimitError(fmt.Sprintf("Query %v fetched too many chunks (%d > %d)", allMatchers, len(filtered), maxChunksPerQuery))
 		level.Error(log).Log("err", err)
 		return nil, err
 	}
+	if err := LimitChunkRefs(ctx, filtered); err != nil {
+		return nil, err
+	}
 
 	// Now fetch the actual chunk data from Memcache / S3
 	keys := keysFromChunks(filtered)
@@ -349,7 +603,15 @@ func (c *store) getMetricNameChunks(ctx context.Context, userID string, from, th
 }
 
 func (c *store) lookupChunksByMetricName(ctx context.Context, userID string, from, through model.Time, matchers []*labels.Matcher, metricName string) ([]Chunk, error) {
-	log, ctx := spanlogger.New(ctx, "ChunkStore.lookupChunksByMetricName")
+	chunkIDs, err := c.lookupChunkIDsByMetricName(ctx, userID, from, through, matchers, metricName)
+	if err != nil {
+		return nil, err
+	}
+	return c.convertChunkIDsToChunks(ctx, userID, chunkIDs)
+}
+
+func (c *store) lookupChunkIDsByMetricName(ctx context.Context, userID string, from, through model.Time, matchers []*labels.Matcher, metricName string) ([]string, error) {
+	log, ctx := spanlogger.New(ctx, "ChunkStore.lookupChunkIDsByMetricName")
 	defer log.Finish()
 
 	// Just get chunks for metric if there are no matchers
@@ -372,7 +634,7 @@ func (c *store) lookupChunksByMetricName(ctx context.Context, userID string, fro
 		}
 		level.Debug(log).Log("chunkIDs", len(chunkIDs))
 
-		return c.convertChunkIDsToChunks(ctx, userID, chunkIDs)
+		return chunkIDs, nil
 	}
 
 	// Otherwise get chunks which include other matchers
@@ -432,9 +694,7 @@ func (c *store) lookupChunksByMetricName(ctx context.Context, userID string, fro
 		return nil, lastErr
 	}
 	level.Debug(log).Log("msg", "post intersection", "chunkIDs", len(chunkIDs))
-
-	// Convert IndexEntry's into chunks
-	return c.convertChunkIDsToChunks(ctx, userID, chunkIDs)
+	return chunkIDs, nil
 }
 
 func (c *store) lookupEntriesByQueries(ctx context.Context, queries []IndexQuery) ([]IndexEntry, error) {
@@ -1,9 +1,11 @@
 package chunk
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 
@@ -37,6 +39,7 @@ var schemas = []struct {
 	{"v6", true},
 	{"v9", true},
 	{"v10", true},
+	{"v12", true},
 }
 
 var stores = []struct {
@@ -78,7 +81,7 @@ func newTestChunkStoreConfig(t require.TestingT, schemaName string, storeCfg Sto
 	)
 	flagext.DefaultValues(&tbmConfig)
 	storage := NewMockStorage()
-	tableManager, err := NewTableManager(tbmConfig, schemaCfg, maxChunkAge, storage, nil)
+	tableManager, err := NewTableManager(tbmConfig, schemaCfg, maxChunkAge, storage, nil, nil, nil)
 	require.NoError(t, err)
 
 	err = tableManager.SyncTables(context.Background())
@@ -300,31 +303,53 @@ func TestChunkStore_LabelValuesForMetricName(t *testing.T) {
 	for _, tc := range []struct {
 		metricName, labelName string
 		expect                []string
+		matchers              []*labels.Matcher
 	}{
 		{
 			`foo`, `bar`,
 			[]string{"baz", "beep", "bop"},
+			nil,
 		},
 		{
 			`bar`, `toms`,
 			[]string{"code"},
+			nil,
 		},
 		{
 			`bar`, `bar`,
 			[]string{"baz"},
+			nil,
 		},
 		{
 			`foo`, `foo`,
 			nil,
+			nil,
 		},
 		{
 			`foo`, `flip`,
 			[]string{"flap", "flop"},
+			nil,
+		},
+		{
+			`foo`, `bar`,
+			[]string{"baz", "beep"},
+			[]*labels.Matcher{mustNewLabelMatcher(labels.MatchEqual, "toms", "code")},
+		},
+		{
+			`foo`, `bar`,
+			[]string{"baz", "bop"},
+			[]*labels.Matcher{mustNewLabelMatcher(labels.MatchRegexp, "flip", "fl.p")},
+		},
+		{
+			// The matchers of the empty value are applied to the series.
+			`foo`, `flip`,
+			[]string{"flap"},
+			[]*labels.Matcher{mustNewLabelMatcher(labels.MatchNotEqual, "toms", "code")},
 		},
 	} {
 		for _, schema := range schemas {
 			for _, storeCase := range stores {
-				t.Run(fmt.Sprintf("%s / %s / %s / %s", tc.metricName, tc.labelName, schema.name, storeCase.name), func(t *testing.T) {
+				t.Run(fmt.Sprintf("%s / %s / %v / %s / %s", tc.metricName, tc.labelName, tc.matchers, schema.name, storeCase.name), func(t *testing.T) {
 					t.Log("========= Running labelValues with metricName", tc.metricName, "with labelName", tc.labelName, "with schema", schema.name)
 					storeCfg := storeCase.configFn()
 					store := newTestChunkStoreConfig(t, schema.name, storeCfg)
@@ -341,7 +366,7 @@ func TestChunkStore_LabelValuesForMetricName(t *testing.T) {
 					}
 
 					// Query with ordinary time-range
-					labelValues1, err := store.LabelValuesForMetricName(ctx, userID, now.Add(-time.Hour), now, tc.metricName, tc.labelName)
+					labelValues1, err := store.LabelValuesForMetricName(ctx, userID, now.Add(-time.Hour), now, tc.metricName, tc.labelName, tc.matchers...)
 					require.NoError(t, err)
 
 					if !reflect.DeepEqual(tc.expect, labelValues1) {
@@ -349,7 +374,7 @@ func TestChunkStore_LabelValuesForMetricName(t *testing.T) {
 					}
 
 					// Pushing end of time-range into future should yield exact same resultset
-					labelValues2, err := store.LabelValuesForMetricName(ctx, userID, now.Add(-time.Hour), now.Add(time.Hour*24*10), tc.metricName, tc.labelName)
+					labelValues2, err := store.LabelValuesForMetricName(ctx, userID, now.Add(-time.Hour), now.Add(time.Hour*24*10), tc.metricName, tc.labelName, tc.matchers...)
 					require.NoError(t, err)
 
 					if !reflect.DeepEqual(tc.expect, labelValues2) {
@@ -357,7 +382,7 @@ func TestChunkStore_LabelValuesForMetricName(t *testing.T) {
 					}
 
 					// Query with both begin & end of time-range in future should yield empty resultset
-					labelValues3, err := store.LabelValuesForMetricName(ctx, userID, now.Add(time.Hour), now.Add(time.Hour*2), tc.metricName, tc.labelName)
+					labelValues3, err := store.LabelValuesForMetricName(ctx, userID, now.Add(time.Hour), now.Add(time.Hour*2), tc.metricName, tc.labelName, tc.matchers...)
 					require.NoError(t, err)
 					if len(labelValues3) != 0 {
 						t.Fatalf("%s/%s: future query should yield empty resultset ... actually got %v label values: %#v",
@@ -473,6 +498,60 @@ func TestChunkStore_LabelNamesForMetricName(t *testing.T) {
 }
 
 // TestChunkStore_getMetricNameChunks tests if chunks are fetched correctly when we have the metric name
+func TestChunkStore_GetSeries(t *testing.T) {
+	ctx := context.Background()
+	now := model.Now()
+
+	fooMetric1 := labels.Labels{
+		{Name: labels.MetricName, Value: "foo"},
+		{Name: "bar", Value: "baz"},
+		{Name: "toms", Value: "code"},
+	}
+	fooMetric2 := labels.Labels{
+		{Name: labels.MetricName, Value: "foo"},
+		{Name: "bar", Value: "beep"},
+	}
+	barMetric := labels.Labels{
+		{Name: labels.MetricName, Value: "bar"},
+		{Name: "toms", Value: "code"},
+	}
+
+	for _, tc := range []struct {
+		query  string
+		expect []labels.Labels
+	}{
+		{`foo`, []labels.Labels{fooMetric1, fooMetric2}},
+		{`foo{toms="code"}`, []labels.Labels{fooMetric1}},
+		{`foo{toms=""}`, []labels.Labels{fooMetric2}},
+		{`foo{bar=~"b.*", toms!="code"}`, []labels.Labels{fooMetric2}},
+	} {
+		for _, schema := range schemas {
+			for _, storeCase := range stores {
+				t.Run(fmt.Sprintf("%s / %s / %s", tc.query, schema.name, storeCase.name), func(t *testing.T) {
+					store := newTestChunkStoreConfig(t, schema.name, storeCase.configFn())
+					defer store.Stop()
+
+					// The series with several chunks are only returned once.
+					err := store.Put(ctx, []Chunk{
+						dummyChunkFor(now, fooMetric1),
+						dummyChunkFor(now.Add(-time.Minute), fooMetric1),
+						dummyChunkFor(now, fooMetric2),
+						dummyChunkFor(now, barMetric),
+					})
+					require.NoError(t, err)
+
+					matchers, err := promql.ParseMetricSelector(tc.query)
+					require.NoError(t, err)
+					series, err := store.GetSeries(ctx, userID, now.Add(-time.Hour), now, matchers...)
+					require.NoError(t, err)
+					sort.Slice(series, func(i, j int) bool { return labels.Compare(series[i], series[j]) < 0 })
+					require.Equal(t, tc.expect, series)
+				})
+			}
+		}
+	}
+}
+
 func TestChunkStore_getMetricNameChunks(t *testing.T) {
 	ctx := context.Background()
 	now := model.Now()
@@ -727,6 +806,90 @@ func TestIndexCachingWorks(t *testing.T) {
 	require.Equal(t, n+1, storage.numWrites)
 }
 
+func TestWriteDedupeCacheSkipsWrittenChunks(t *testing.T) {
+	ctx := context.Background()
+	metric := labels.Labels{
+		{Name: labels.MetricName, Value: "foo"},
+		{Name: "bar", Value: "baz"},
+	}
+
+	// The replicas share the write dedupe cache.
+	storeCfg := stores[1].configFn()
+	replica1 := newTestChunkStoreConfig(t, "v9", storeCfg)
+	defer replica1.Stop()
+	replica2 := newTestChunkStoreConfig(t, "v9", storeCfg)
+	defer replica2.Stop()
+
+	storage1 := replica1.(CompositeStore).stores[0].Store.(*seriesStore).storage.(*MockStorage)
+	storage2 := replica2.(CompositeStore).stores[0].Store.(*seriesStore).storage.(*MockStorage)
+
+	fooChunk := dummyChunkFor(model.Time(0).Add(15*time.Second), metric)
+	require.NoError(t, fooChunk.Encode())
+
+	require.NoError(t, replica1.Put(ctx, []Chunk{fooChunk}))
+	n := storage1.numWrites
+	require.NotZero(t, n)
+	require.Len(t, storage1.objects, 1)
+
+	// The chunk isn't written again, by the same replica nor by another one.
+	require.NoError(t, replica1.Put(ctx, []Chunk{fooChunk}))
+	require.Equal(t, n, storage1.numWrites)
+	require.NoError(t, replica2.Put(ctx, []Chunk{fooChunk}))
+	require.Zero(t, storage2.numWrites)
+	require.Empty(t, storage2.objects)
+}
+
+func TestStoreConfigValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		modify          func(*StoreConfig)
+		retentionPeriod time.Duration
+		valid           bool
+	}{
+		"no write dedupe cache": {
+			modify:          func(*StoreConfig) {},
+			retentionPeriod: 24 * time.Hour,
+			valid:           true,
+		},
+		"no retention": {
+			modify:          func(cfg *StoreConfig) { cfg.WriteDedupeCacheConfig.MemcacheClient.Host = "memcached" },
+			retentionPeriod: 0,
+			valid:           true,
+		},
+		"entries never expiring": {
+			modify:          func(cfg *StoreConfig) { cfg.WriteDedupeCacheConfig.MemcacheClient.Host = "memcached" },
+			retentionPeriod: 24 * time.Hour,
+		},
+		"entries expiring before the retention": {
+			modify: func(cfg *StoreConfig) {
+				cfg.WriteDedupeCacheConfig.MemcacheClient.Host = "memcached"
+				cfg.WriteDedupeCacheConfig.DefaultValidity = 12 * time.Hour
+			},
+			retentionPeriod: 24 * time.Hour,
+			valid:           true,
+		},
+		"entries expiring after the retention": {
+			modify: func(cfg *StoreConfig) {
+				cfg.WriteDedupeCacheConfig.MemcacheClient.Host = "memcached"
+				cfg.WriteDedupeCacheConfig.DefaultValidity = 12 * time.Hour
+				cfg.WriteDedupeCacheConfig.Memcache.Expiration = 48 * time.Hour
+			},
+			retentionPeriod: 24 * time.Hour,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var cfg StoreConfig
+			flagext.DefaultValues(&cfg)
+			tc.modify(&cfg)
+			err := cfg.Validate(tc.retentionPeriod)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
 func BenchmarkIndexCaching(b *testing.B) {
 	ctx := context.Background()
 	storeMaker := stores[1]
@@ -792,6 +955,41 @@ func TestChunkStoreError(t *testing.T) {
 	}
 }
 
+type errChunkRefsLimiter struct {
+	refs []Chunk
+}
+
+func (l *errChunkRefsLimiter) AddChunkRefs(chunks []Chunk) error {
+	l.refs = append(l.refs, chunks...)
+	return errors.New("limit exceeded")
+}
+
+func TestChunkStoreLimitsChunkRefs(t *testing.T) {
+	now := model.Now()
+	fooChunk := dummyChunkFor(now, labels.Labels{
+		{Name: labels.MetricName, Value: "foo"},
+		{Name: "bar", Value: "baz"},
+	})
+
+	for _, schema := range schemas {
+		t.Run(schema.name, func(t *testing.T) {
+			store := newTestChunkStore(t, schema.name)
+			defer store.Stop()
+			require.NoError(t, store.Put(context.Background(), []Chunk{fooChunk}))
+
+			limiter := &errChunkRefsLimiter{}
+			ctx := WithChunkRefsLimiter(context.Background(), limiter)
+			_, err := store.Get(ctx, userID, now.Add(-time.Hour), now, mustNewLabelMatcher(labels.MatchEqual, labels.MetricName, "foo"))
+			require.EqualError(t, err, "limit exceeded")
+
+			// The limiter is given the chunks before they're fetched.
+			require.Len(t, limiter.refs, 1)
+			require.Equal(t, fooChunk.ExternalKey(), limiter.refs[0].ExternalKey())
+			require.Nil(t, limiter.refs[0].Data)
+		})
+	}
+}
+
 func TestStoreMaxLookBack(t *testing.T) {
 	ctx := user.InjectOrgID(context.Background(), userID)
 	metric := labels.Labels{
@@ -844,3 +1042,144 @@ func TestStoreMaxLookBack(t *testing.T) {
 	require.Equal(t, 1, len(chunks))
 	chunks[0].Through.Equal(now)
 }
+
+// lossyObjectClient skips the first chunk requested, the first few times.
+type lossyObjectClient struct {
+	*MockStorage
+	losses int
+}
+
+func (c *lossyObjectClient) GetChunks(ctx context.Context, chunks []Chunk) ([]Chunk, error) {
+	if c.losses > 0 && len(chunks) > 0 {
+		c.losses--
+		chunks = chunks[1:]
+	}
+	return c.MockStorage.GetChunks(ctx, chunks)
+}
+
+func TestFetcherConsistencyCheck(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	now := model.Now()
+	chunks := []Chunk{
+		dummyChunkFor(now, labels.Labels{{Name: labels.MetricName, Value: "foo"}}),
+		dummyChunkFor(now, labels.Labels{{Name: labels.MetricName, Value: "bar"}}),
+	}
+	for i := range chunks {
+		require.NoError(t, chunks[i].Encode())
+	}
+	keys := keysFromChunks(chunks)
+	sort.Strings(keys)
+
+	for _, tc := range []struct {
+		name             string
+		consistencyCheck bool
+		losses           int
+		expectedChunks   int
+		expectedErr      bool
+	}{
+		{name: "disabled", losses: 1, expectedChunks: 1},
+		{name: "retried", consistencyCheck: true, losses: 2, expectedChunks: 2},
+		{name: "failed", consistencyCheck: true, losses: 4, expectedErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := &lossyObjectClient{MockStorage: NewMockStorage(), losses: tc.losses}
+			require.NoError(t, storage.PutChunks(ctx, chunks))
+
+			var cfg StoreConfig
+			flagext.DefaultValues(&cfg)
+			cfg.ConsistencyCheck = tc.consistencyCheck
+			fetcher, err := newStoreFetcher(cfg, storage)
+			require.NoError(t, err)
+			defer fetcher.Stop()
+
+			found, err := fetcher.FetchChunks(ctx, chunks, keys)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, found, tc.expectedChunks)
+		})
+	}
+}
+
+func TestChunkStore_DeleteChunk(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	metric := labels.Labels{
+		{Name: labels.MetricName, Value: "foo"},
+		{Name: "bar", Value: "baz"},
+	}
+	matchers := []*labels.Matcher{mustNewLabelMatcher(labels.MatchEqual, labels.MetricName, "foo")}
+
+	for _, schema := range schemas {
+		t.Run(schema.name, func(t *testing.T) {
+			store := newTestChunkStore(t, schema.name)
+			defer store.Stop()
+
+			var chunks []Chunk
+			for i := 0; i < 2; i++ {
+				ts := model.TimeFromUnix(int64(i * 3600))
+				cs, _ := encoding.New().Add(model.SamplePair{Timestamp: ts, Value: model.SampleValue(i)})
+				chunk := NewChunk(userID, model.Fingerprint(1), metric, cs[0], ts, ts.Add(time.Hour))
+				require.NoError(t, chunk.Encode())
+				chunks = append(chunks, chunk)
+			}
+			require.NoError(t, store.Put(ctx, chunks))
+
+			require.NoError(t, store.DeleteChunk(ctx, chunks[0].From, chunks[0].Through, chunks[0]))
+
+			found, err := store.Get(ctx, userID, 0, model.TimeFromUnix(3*3600), matchers...)
+			require.NoError(t, err)
+			require.Len(t, found, 1)
+			require.Equal(t, chunks[1].ExternalKey(), found[0].ExternalKey())
+		})
+	}
+}
+
+func TestChunkStore_DeleteUserChunks(t *testing.T) {
+	metric := labels.Labels{
+		{Name: labels.MetricName, Value: "foo"},
+		{Name: "bar", Value: "baz"},
+	}
+	matchers := []*labels.Matcher{mustNewLabelMatcher(labels.MatchEqual, labels.MetricName, "foo")}
+
+	for _, schema := range schemas {
+		t.Run(schema.name, func(t *testing.T) {
+			store := newTestChunkStore(t, schema.name)
+			defer store.Stop()
+
+			for _, tenant := range []string{userID, "other"} {
+				ctx := user.InjectOrgID(context.Background(), tenant)
+				var chunks []Chunk
+				for i := 0; i < 2; i++ {
+					ts := model.TimeFromUnix(int64(i * 3600))
+					cs, _ := encoding.New().Add(model.SamplePair{Timestamp: ts, Value: model.SampleValue(i)})
+					chunk := NewChunk(tenant, model.Fingerprint(1), metric, cs[0], ts, ts.Add(time.Hour))
+					require.NoError(t, chunk.Encode())
+					chunks = append(chunks, chunk)
+				}
+				require.NoError(t, store.Put(ctx, chunks))
+			}
+
+			deleted, err := store.DeleteUserChunks(user.InjectOrgID(context.Background(), userID), userID, 0, model.Latest)
+			require.NoError(t, err)
+			require.Equal(t, 2, deleted)
+
+			found, err := store.Get(user.InjectOrgID(context.Background(), userID), userID, 0, model.TimeFromUnix(3*3600), matchers...)
+			require.NoError(t, err)
+			require.Empty(t, found)
+			// The series and the labels of the tenant are gone too.
+			values, err := store.LabelValuesForMetricName(user.InjectOrgID(context.Background(), userID), userID, 0, model.TimeFromUnix(3*3600), "foo", "bar")
+			require.NoError(t, err)
+			require.Empty(t, values)
+
+			// The chunks of the other tenants are kept.
+			found, err = store.Get(user.InjectOrgID(context.Background(), "other"), "other", 0, model.TimeFromUnix(3*3600), matchers...)
+			require.NoError(t, err)
+			require.Len(t, found, 2)
+			values, err = store.LabelValuesForMetricName(user.InjectOrgID(context.Background(), "other"), "other", 0, model.TimeFromUnix(3*3600), "foo", "bar")
+			require.NoError(t, err)
+			require.Equal(t, []string{"baz"}, values)
+		})
+	}
+}
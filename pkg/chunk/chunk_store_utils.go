@@ -2,10 +2,14 @@ package chunk
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
@@ -15,7 +19,24 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
 )
 
-const chunkDecodeParallelism = 16
+const (
+	chunkDecodeParallelism = 16
+
+	errChunksMissing = "consistency check failed: %d of the %d chunks found in the index could not be fetched"
+)
+
+var (
+	consistencyBackoff = util.BackoffConfig{
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: time.Second,
+	}
+
+	missingChunks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "chunk_store_consistency_check_missing_chunks_total",
+		Help:      "Total count of chunks found in the index but not returned by the chunk store, including retries.",
+	})
+)
 
 func filterChunksByTime(from, through model.Time, chunks []Chunk) []Chunk {
 	filtered := make([]Chunk, 0, len(chunks))
@@ -92,6 +113,15 @@ type Fetcher struct {
 	cache      cache.Cache
 	cacheStubs bool
 
+	// The cipher of the encrypting object client of the storage, if any: the
+	// chunks of the tenants it encrypts are cached encrypted.
+	cipher ChunkCipher
+
+	// Verify that the storage returned all the chunks requested, retrying
+	// the missing ones this many times.
+	consistencyCheck        bool
+	consistencyCheckRetries int
+
 	wait           sync.WaitGroup
 	decodeRequests chan decodeRequest
 }
@@ -118,6 +148,7 @@ func NewChunkFetcher(cfg cache.Config, cacheStubs bool, storage ObjectClient) (*
 		storage:        storage,
 		cache:          cache,
 		cacheStubs:     cacheStubs,
+		cipher:         chunkCipherOf(storage),
 		decodeRequests: make(chan decodeRequest),
 	}
 
@@ -129,6 +160,17 @@ func NewChunkFetcher(cfg cache.Config, cacheStubs bool, storage ObjectClient) (*
 	return c, nil
 }
 
+// newStoreFetcher makes the ChunkFetcher of a store.
+func newStoreFetcher(cfg StoreConfig, storage ObjectClient) (*Fetcher, error) {
+	c, err := NewChunkFetcher(cfg.ChunkCacheConfig, cfg.chunkCacheStubs, storage)
+	if err != nil {
+		return nil, err
+	}
+	c.consistencyCheck = cfg.ConsistencyCheck
+	c.consistencyCheckRetries = cfg.ConsistencyCheckRetries
+	return c, nil
+}
+
 // Stop the ChunkFetcher.
 func (c *Fetcher) Stop() {
 	close(c.decodeRequests)
@@ -160,14 +202,14 @@ func (c *Fetcher) FetchChunks(ctx context.Context, chunks []Chunk, keys []string
 	// Now fetch the actual chunk data from Memcache / S3
 	cacheHits, cacheBufs, _ := c.cache.Fetch(ctx, keys)
 
-	fromCache, missing, err := c.processCacheResponse(chunks, cacheHits, cacheBufs)
+	fromCache, missing, err := c.processCacheResponse(ctx, chunks, cacheHits, cacheBufs)
 	if err != nil {
 		level.Warn(log).Log("msg", "error fetching from cache", "err", err)
 	}
 
 	var fromStorage []Chunk
 	if len(missing) > 0 {
-		fromStorage, err = c.storage.GetChunks(ctx, missing)
+		fromStorage, err = c.fetchFromStorage(ctx, missing)
 	}
 
 	// Always cache any chunks we did get
@@ -183,13 +225,70 @@ func (c *Fetcher) FetchChunks(ctx context.Context, chunks []Chunk, keys []string
 	return allChunks, nil
 }
 
+// fetchFromStorage fetches chunks from the storage. Some object clients skip
+// the chunks they can't find, e.g. when the store is only eventually
+// consistent: with the consistency check enabled, the missing chunks are
+// fetched again, and the fetch fails if some are still missing.
+func (c *Fetcher) fetchFromStorage(ctx context.Context, chunks []Chunk) ([]Chunk, error) {
+	found, err := c.storage.GetChunks(ctx, chunks)
+	if err != nil || !c.consistencyCheck {
+		return found, err
+	}
+
+	backoff := util.NewBackoff(ctx, consistencyBackoff)
+	for retries := 0; ; retries++ {
+		missing := chunksNotFound(chunks, found)
+		if len(missing) == 0 {
+			return found, nil
+		}
+		missingChunks.Add(float64(len(missing)))
+		if retries >= c.consistencyCheckRetries {
+			return found, util.ConsistencyError(fmt.Sprintf(errChunksMissing, len(missing), len(chunks)))
+		}
+
+		backoff.Wait()
+		if err := ctx.Err(); err != nil {
+			return found, err
+		}
+		more, err := c.storage.GetChunks(ctx, missing)
+		if err != nil {
+			return found, err
+		}
+		found = append(found, more...)
+	}
+}
+
+// chunksNotFound returns the chunks requested which are not in found.
+func chunksNotFound(requested, found []Chunk) []Chunk {
+	keys := make(map[string]struct{}, len(found))
+	for _, c := range found {
+		keys[c.ExternalKey()] = struct{}{}
+	}
+
+	var missing []Chunk
+	for _, c := range requested {
+		if _, ok := keys[c.ExternalKey()]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// writeBackCache writes the chunks to the cache, those of the tenants the
+// cipher encrypts encrypted, so that the caches shared by the tenants don't
+// hold them in plaintext.
 func (c *Fetcher) writeBackCache(ctx context.Context, chunks []Chunk) error {
 	keys := make([]string, 0, len(chunks))
 	bufs := make([][]byte, 0, len(chunks))
 	for i := range chunks {
 		var encoded []byte
 		var err error
-		if !c.cacheStubs {
+		if !c.cacheStubs && c.cipher != nil && c.cipher.Encrypts(chunks[i].UserID) {
+			encoded, err = encryptChunk(ctx, c.cipher, &chunks[i])
+			if err != nil {
+				return err
+			}
+		} else if !c.cacheStubs {
 			encoded, err = chunks[i].Encoded()
 			// TODO don't fail, just log and conitnue?
 			if err != nil {
@@ -206,13 +305,17 @@ func (c *Fetcher) writeBackCache(ctx context.Context, chunks []Chunk) error {
 }
 
 // ProcessCacheResponse decodes the chunks coming back from the cache, separating
-// hits and misses.
-func (c *Fetcher) processCacheResponse(chunks []Chunk, keys []string, bufs [][]byte) ([]Chunk, []Chunk, error) {
+// hits and misses. The chunks cached encrypted are decrypted.
+func (c *Fetcher) processCacheResponse(ctx context.Context, chunks []Chunk, keys []string, bufs [][]byte) ([]Chunk, []Chunk, error) {
 	var (
 		requests  = make([]decodeRequest, 0, len(keys))
 		responses = make(chan decodeResponse)
 		missing   []Chunk
+		decrypter *chunkDecrypter
 	)
+	if c.cipher != nil {
+		decrypter = &chunkDecrypter{ctx: ctx, cipher: c.cipher}
+	}
 
 	i, j := 0, 0
 	for i < len(chunks) && j < len(keys) {
@@ -225,8 +328,10 @@ func (c *Fetcher) processCacheResponse(chunks []Chunk, keys []string, bufs [][]b
 			level.Warn(util.Logger).Log("msg", "got chunk from cache we didn't ask for")
 			j++
 		} else {
+			chunk := chunks[i]
+			chunk.decrypter = decrypter
 			requests = append(requests, decodeRequest{
-				chunk:     chunks[i],
+				chunk:     chunk,
 				buf:       bufs[j],
 				responses: responses,
 			})
@@ -23,8 +23,21 @@ type Store interface {
 	// GetChunkRefs returns the un-loaded chunks and the fetchers to be used to load them. You can load each slice of chunks ([]Chunk),
 	// using the corresponding Fetcher (fetchers[i].FetchChunks(ctx, chunks[i], ...)
 	GetChunkRefs(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([][]Chunk, []*Fetcher, error)
-	LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string, labelName string) ([]string, error)
+	// LabelValuesForMetricName returns the values of a label of the series of
+	// a metric, only of the series matching the matchers if any, from the index.
+	LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string, labelName string, matchers ...*labels.Matcher) ([]string, error)
 	LabelNamesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string) ([]string, error)
+	// GetSeries returns the labels of the series matching the matchers, looked
+	// up in the index, fetching a single chunk of each series for its labels.
+	GetSeries(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]labels.Labels, error)
+	// DeleteChunk deletes a chunk and its index entries between from and through.
+	DeleteChunk(ctx context.Context, from, through model.Time, chunk Chunk) error
+	// DeleteUserChunks deletes the chunks of a tenant overlapping from and
+	// through with their index entries, returning the number deleted.
+	DeleteUserChunks(ctx context.Context, userID string, from, through model.Time) (int, error)
+	// CheckDeleteUserChunks returns an error if the index or object clients
+	// of the store don't support DeleteUserChunks.
+	CheckDeleteUserChunks() error
 	Stop()
 }
 
@@ -49,13 +62,19 @@ func NewCompositeStore() CompositeStore {
 	return CompositeStore{}
 }
 
+// Clone returns a copy of the CompositeStore sharing the stores of its
+// periods, to add periods to while it's in use.
+func (c CompositeStore) Clone() CompositeStore {
+	return CompositeStore{compositeStore{stores: append([]compositeStoreEntry(nil), c.stores...)}}
+}
+
 // AddPeriod adds the configuration for a period of time to the CompositeStore
 func (c *CompositeStore) AddPeriod(storeCfg StoreConfig, cfg PeriodConfig, index IndexClient, chunks ObjectClient, limits StoreLimits) error {
 	schema := cfg.CreateSchema()
 	var store Store
 	var err error
 	switch cfg.Schema {
-	case "v9", "v10":
+	case "v9", "v10", "v12":
 		store, err = newSeriesStore(storeCfg, schema, index, chunks, limits)
 	default:
 		store, err = newStore(storeCfg, schema, index, chunks, limits)
@@ -85,6 +104,31 @@ func (c compositeStore) PutOne(ctx context.Context, from, through model.Time, ch
 	})
 }
 
+func (c compositeStore) DeleteChunk(ctx context.Context, from, through model.Time, chunk Chunk) error {
+	return c.forStores(from, through, func(from, through model.Time, store Store) error {
+		return store.DeleteChunk(ctx, from, through, chunk)
+	})
+}
+
+func (c compositeStore) DeleteUserChunks(ctx context.Context, userID string, from, through model.Time) (int, error) {
+	deleted := 0
+	err := c.forStores(from, through, func(from, through model.Time, store Store) error {
+		n, err := store.DeleteUserChunks(ctx, userID, from, through)
+		deleted += n
+		return err
+	})
+	return deleted, err
+}
+
+func (c compositeStore) CheckDeleteUserChunks() error {
+	for _, store := range c.stores {
+		if err := store.Store.CheckDeleteUserChunks(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c compositeStore) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]Chunk, error) {
 	var results []Chunk
 	err := c.forStores(from, through, func(from, through model.Time, store Store) error {
@@ -99,10 +143,10 @@ func (c compositeStore) Get(ctx context.Context, userID string, from, through mo
 }
 
 // LabelValuesForMetricName retrieves all label values for a single label name and metric name.
-func (c compositeStore) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string, labelName string) ([]string, error) {
+func (c compositeStore) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string, labelName string, matchers ...*labels.Matcher) ([]string, error) {
 	var result []string
 	err := c.forStores(from, through, func(from, through model.Time, store Store) error {
-		labelValues, err := store.LabelValuesForMetricName(ctx, userID, from, through, metricName, labelName)
+		labelValues, err := store.LabelValuesForMetricName(ctx, userID, from, through, metricName, labelName, matchers...)
 		if err != nil {
 			return err
 		}
@@ -126,6 +170,26 @@ func (c compositeStore) LabelNamesForMetricName(ctx context.Context, userID stri
 	return result, err
 }
 
+// GetSeries returns the labels of the series matching the matchers.
+func (c compositeStore) GetSeries(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]labels.Labels, error) {
+	var result []labels.Labels
+	seen := map[string]struct{}{}
+	err := c.forStores(from, through, func(from, through model.Time, store Store) error {
+		series, err := store.GetSeries(ctx, userID, from, through, matchers...)
+		if err != nil {
+			return err
+		}
+		for _, ls := range series {
+			if _, ok := seen[ls.String()]; !ok {
+				seen[ls.String()] = struct{}{}
+				result = append(result, ls)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
 func (c compositeStore) GetChunkRefs(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([][]Chunk, []*Fetcher, error) {
 	chunkIDs := [][]Chunk{}
 	fetchers := []*Fetcher{}
@@ -21,10 +21,26 @@ func (m mockStore) PutOne(ctx context.Context, from, through model.Time, chunk C
 	return nil
 }
 
+func (m mockStore) DeleteChunk(ctx context.Context, from, through model.Time, chunk Chunk) error {
+	return nil
+}
+
+func (m mockStore) DeleteUserChunks(ctx context.Context, userID string, from, through model.Time) (int, error) {
+	return 0, nil
+}
+
+func (m mockStore) CheckDeleteUserChunks() error {
+	return nil
+}
+
 func (m mockStore) Get(tx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]Chunk, error) {
 	return nil, nil
 }
-func (m mockStore) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string, labelName string) ([]string, error) {
+func (m mockStore) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string, labelName string, matchers ...*labels.Matcher) ([]string, error) {
+	return nil, nil
+}
+
+func (m mockStore) GetSeries(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]labels.Labels, error) {
 	return nil, nil
 }
 
@@ -0,0 +1,197 @@
+package chunk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The codecs compressing the chunks written to the object store.
+const (
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+)
+
+// The chunks compressed by the compressing object client start with this
+// prefix, then the byte of their codec. Like the encrypted chunks, they can't
+// be mistaken for the encoding of a chunk, which starts with a zero byte.
+var compressedChunkPrefix = []byte("CXZ\x01")
+
+var compressionCodecs = map[string]byte{
+	CompressionSnappy: 1,
+	CompressionGzip:   2,
+	CompressionZstd:   3,
+}
+
+// The zstd encoder and decoder are safe for concurrent use, with EncodeAll
+// and DecodeAll, so they're shared by all the chunks; they're only made once
+// needed, as they start their own goroutines.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+var (
+	chunkUncompressedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "chunk_compression_uncompressed_bytes_total",
+		Help:      "Size of the encoding of the chunks written by the compressing object client, by codec.",
+	}, []string{"codec"})
+	chunkCompressedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "chunk_compression_compressed_bytes_total",
+		Help:      "Size of the chunks written by the compressing object client, by codec, the chunks compression doesn't shrink being written uncompressed.",
+	}, []string{"codec"})
+)
+
+func isCompressed(buf []byte) bool {
+	return len(buf) > len(compressedChunkPrefix) && bytes.HasPrefix(buf, compressedChunkPrefix)
+}
+
+// decompress returns the encoding of a chunk compressed by the compressing
+// object client, whatever its codec.
+func decompress(buf []byte) ([]byte, error) {
+	codec, compressed := buf[len(compressedChunkPrefix)], buf[len(compressedChunkPrefix)+1:]
+	switch codec {
+	case compressionCodecs[CompressionSnappy]:
+		return snappy.Decode(nil, compressed)
+	case compressionCodecs[CompressionGzip]:
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case compressionCodecs[CompressionZstd]:
+		_, dec, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(compressed, nil)
+	default:
+		return nil, fmt.Errorf("unknown codec of compressed chunk: %d", codec)
+	}
+}
+
+// ValidateCompressionCodec returns an error if the codec isn't supported.
+func ValidateCompressionCodec(codec string) error {
+	if codec == CompressionNone || codec == "" {
+		return nil
+	}
+	if _, ok := compressionCodecs[codec]; !ok {
+		return fmt.Errorf("unsupported chunk compression codec %q, choose one of: %s, %s, %s, %s", codec, CompressionNone, CompressionSnappy, CompressionGzip, CompressionZstd)
+	}
+	return nil
+}
+
+type compressingObjectClient struct {
+	ObjectClient
+	codec string
+}
+
+// NewCompressingObjectClient makes an ObjectClient compressing the chunks with
+// the codec before writing them to the client. The codec is recorded with
+// each chunk, so that the chunks are decoded whatever their codec, the
+// chunks written uncompressed included; with CompressionNone the client
+// itself is returned.
+func NewCompressingObjectClient(client ObjectClient, codec string) (ObjectClient, error) {
+	if codec == CompressionNone || codec == "" {
+		return client, nil
+	}
+	if err := ValidateCompressionCodec(codec); err != nil {
+		return nil, err
+	}
+	return &compressingObjectClient{
+		ObjectClient: client,
+		codec:        codec,
+	}, nil
+}
+
+// PutChunks implements ObjectClient
+func (c *compressingObjectClient) PutChunks(ctx context.Context, chunks []Chunk) error {
+	compressed := make([]Chunk, 0, len(chunks))
+	for _, ch := range chunks {
+		buf, err := ch.Encoded()
+		if err != nil {
+			return err
+		}
+		out, err := c.compress(buf)
+		if err != nil {
+			return err
+		}
+		chunkUncompressedBytes.WithLabelValues(c.codec).Add(float64(len(buf)))
+		if len(out) < len(buf) {
+			// The checksum in the key of the chunk remains the one of its encoding.
+			ch.encoded = out
+		}
+		chunkCompressedBytes.WithLabelValues(c.codec).Add(float64(len(ch.encoded)))
+		compressed = append(compressed, ch)
+	}
+	return c.ObjectClient.PutChunks(ctx, compressed)
+}
+
+func (c *compressingObjectClient) compress(buf []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(buf)))
+	out.Write(compressedChunkPrefix)
+	out.WriteByte(compressionCodecs[c.codec])
+	switch c.codec {
+	case CompressionSnappy:
+		out.Write(snappy.Encode(nil, buf))
+	case CompressionGzip:
+		w := gzip.NewWriter(out)
+		if _, err := w.Write(buf); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		enc, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(buf, out.Bytes()), nil
+	}
+	return out.Bytes(), nil
+}
+
+// DeleteChunk implements ObjectDeleter
+func (c *compressingObjectClient) DeleteChunk(ctx context.Context, ch Chunk) error {
+	objects, ok := c.ObjectClient.(ObjectDeleter)
+	if !ok {
+		return fmt.Errorf("deleting the chunks is not supported by %T", c.ObjectClient)
+	}
+	return objects.DeleteChunk(ctx, ch)
+}
+
+// ListChunks implements ObjectLister
+func (c *compressingObjectClient) ListChunks(ctx context.Context, userID string) ([]string, error) {
+	lister, ok := c.ObjectClient.(ObjectLister)
+	if !ok {
+		return nil, fmt.Errorf("listing the chunks is not supported by %T", c.ObjectClient)
+	}
+	return lister.ListChunks(ctx, userID)
+}
@@ -0,0 +1,86 @@
+package chunk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+)
+
+func TestCompressingObjectClient(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+
+	// Each chunk is written with its codec, and they're all read whatever
+	// the codec of the client.
+	var chunks []Chunk
+	for i, codec := range []string{CompressionNone, CompressionSnappy, CompressionGzip, CompressionZstd} {
+		client, err := NewCompressingObjectClient(storage, codec)
+		require.NoError(t, err)
+
+		c := dummyChunkForEncoding(model.Now().Add(time.Duration(i)*time.Minute), labelsForDummyChunks, encoding.Varbit, 10)
+		require.NoError(t, client.PutChunks(ctx, []Chunk{c}))
+		buf, err := c.Encoded()
+		require.NoError(t, err)
+		if codec == CompressionNone {
+			require.Equal(t, buf, storage.objects[c.ExternalKey()])
+		} else {
+			require.True(t, isCompressed(storage.objects[c.ExternalKey()]))
+			require.True(t, len(storage.objects[c.ExternalKey()]) < len(buf))
+		}
+		chunks = append(chunks, c)
+	}
+
+	fetched, err := storage.GetChunks(ctx, chunks)
+	require.NoError(t, err)
+	require.Len(t, fetched, len(chunks))
+	for i, c := range fetched {
+		samples, err := c.Samples(0, model.Latest)
+		require.NoError(t, err)
+		require.Len(t, samples, 10)
+
+		buf, err := chunks[i].Encoded()
+		require.NoError(t, err)
+		require.Equal(t, buf, c.encoded)
+	}
+
+	_, err = NewCompressingObjectClient(storage, "lz4")
+	require.Error(t, err)
+	require.Error(t, ValidateCompressionCodec("lz4"))
+	require.NoError(t, ValidateCompressionCodec(CompressionZstd))
+	require.NoError(t, ValidateCompressionCodec(CompressionGzip))
+	require.NoError(t, ValidateCompressionCodec(""))
+}
+
+func TestCompressingEncryptingObjectClient(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	client, err := NewCompressingObjectClient(NewEncryptingObjectClient(storage, mockCipher{}), CompressionGzip)
+	require.NoError(t, err)
+
+	c := dummyChunk(model.Now())
+	require.NoError(t, client.PutChunks(ctx, []Chunk{c}))
+	require.True(t, isEncrypted(storage.objects[c.ExternalKey()]))
+
+	// The chunks are compressed before being encrypted.
+	buf, err := c.Encoded()
+	require.NoError(t, err)
+	require.True(t, len(storage.objects[c.ExternalKey()]) < len(buf))
+
+	fetched, err := client.GetChunks(ctx, []Chunk{c})
+	require.NoError(t, err)
+	require.Len(t, fetched, 1)
+	samples, err := fetched[0].Samples(0, model.Latest)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+
+	keys, err := client.(ObjectLister).ListChunks(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, []string{c.ExternalKey()}, keys)
+	require.NoError(t, client.(ObjectDeleter).DeleteChunk(ctx, c))
+	require.NotContains(t, storage.objects, c.ExternalKey())
+}
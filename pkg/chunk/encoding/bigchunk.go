@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/prometheus/common/model"
@@ -32,6 +33,33 @@ func newBigchunk() *bigchunk {
 	return &bigchunk{}
 }
 
+// NewBigchunkFromXORChunks makes a bigchunk of prometheus/tsdb XOR chunks,
+// e.g. the chunks of the TSDB blocks.
+func NewBigchunkFromXORChunks(chks ...chunkenc.Chunk) (Chunk, error) {
+	b := newBigchunk()
+	b.chunks = make([]smallChunk, 0, len(chks))
+	var iter chunkenc.Iterator
+	for _, c := range chks {
+		xor, ok := c.(*chunkenc.XORChunk)
+		if !ok {
+			return nil, fmt.Errorf("unsupported chunk encoding %s", c.Encoding())
+		}
+		var (
+			start int64
+			err   error
+		)
+		start, iter, err = firstTime(xor, iter)
+		if err != nil {
+			return nil, err
+		}
+		b.chunks = append(b.chunks, smallChunk{
+			XORChunk: *xor,
+			start:    start,
+		})
+	}
+	return b, nil
+}
+
 func (b *bigchunk) Add(sample model.SamplePair) ([]Chunk, error) {
 	if b.remainingSamples == 0 {
 		if bigchunkSizeCapBytes > 0 && b.Size() > bigchunkSizeCapBytes {
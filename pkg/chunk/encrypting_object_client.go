@@ -0,0 +1,136 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// The chunks encrypted by the encrypting object client start with this
+// prefix. The encoding of the plaintext chunks starts with the length of
+// their metadata, whose first byte is zero.
+var encryptedChunkPrefix = []byte("CXE\x01")
+
+func isEncrypted(buf []byte) bool {
+	return bytes.HasPrefix(buf, encryptedChunkPrefix)
+}
+
+// ChunkCipher encrypts the chunks of the tenants written to the object
+// store, e.g. with per-tenant data keys, and decrypts them when fetched.
+// The key of a chunk is its external key, to authenticate along with it.
+type ChunkCipher interface {
+	// Encrypts returns whether the chunks of a tenant are encrypted when
+	// written.
+	Encrypts(userID string) bool
+	Encrypt(ctx context.Context, userID, key string, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, userID, key string, ciphertext []byte) ([]byte, error)
+}
+
+type chunkDecrypter struct {
+	ctx    context.Context
+	cipher ChunkCipher
+}
+
+func (d *chunkDecrypter) decrypt(c *Chunk, input []byte) ([]byte, error) {
+	return d.cipher.Decrypt(d.ctx, c.UserID, c.ExternalKey(), input[len(encryptedChunkPrefix):])
+}
+
+// encryptChunk returns the encoding of the chunk encrypted by the cipher,
+// with the prefix of the encrypted chunks. The checksum in the key of the
+// chunk remains the one of its encoding.
+func encryptChunk(ctx context.Context, cipher ChunkCipher, c *Chunk) ([]byte, error) {
+	buf, err := c.Encoded()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := cipher.Encrypt(ctx, c.UserID, c.ExternalKey(), buf)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make([]byte, 0, len(encryptedChunkPrefix)+len(ciphertext)), encryptedChunkPrefix...), ciphertext...), nil
+}
+
+// chunkCipherOf returns the cipher of the encrypting object client of the
+// client, if any, so that the chunk caches hold the chunks it encrypts
+// encrypted too.
+func chunkCipherOf(client ObjectClient) ChunkCipher {
+	for {
+		switch c := client.(type) {
+		case *encryptingObjectClient:
+			return c.cipher
+		case *compressingObjectClient:
+			client = c.ObjectClient
+		default:
+			return nil
+		}
+	}
+}
+
+type encryptingObjectClient struct {
+	ObjectClient
+	cipher ChunkCipher
+}
+
+// NewEncryptingObjectClient makes an ObjectClient encrypting the chunks of
+// the tenants the cipher encrypts before writing them to the client, and
+// decrypting the encrypted chunks fetched from it. The chunks written before
+// encryption was enabled are still read.
+func NewEncryptingObjectClient(client ObjectClient, cipher ChunkCipher) ObjectClient {
+	return &encryptingObjectClient{
+		ObjectClient: client,
+		cipher:       cipher,
+	}
+}
+
+// PutChunks implements ObjectClient
+func (e *encryptingObjectClient) PutChunks(ctx context.Context, chunks []Chunk) error {
+	encrypted := make([]Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		if !e.cipher.Encrypts(c.UserID) {
+			encrypted = append(encrypted, c)
+			continue
+		}
+
+		buf, err := encryptChunk(ctx, e.cipher, &c)
+		if err != nil {
+			return err
+		}
+		c.encoded = buf
+		encrypted = append(encrypted, c)
+	}
+	return e.ObjectClient.PutChunks(ctx, encrypted)
+}
+
+// GetChunks implements ObjectClient
+func (e *encryptingObjectClient) GetChunks(ctx context.Context, chunks []Chunk) ([]Chunk, error) {
+	decrypter := &chunkDecrypter{ctx: ctx, cipher: e.cipher}
+	input := make([]Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		c.decrypter = decrypter
+		input = append(input, c)
+	}
+
+	fetched, err := e.ObjectClient.GetChunks(ctx, input)
+	for i := range fetched {
+		fetched[i].decrypter = nil
+	}
+	return fetched, err
+}
+
+// DeleteChunk implements ObjectDeleter
+func (e *encryptingObjectClient) DeleteChunk(ctx context.Context, c Chunk) error {
+	objects, ok := e.ObjectClient.(ObjectDeleter)
+	if !ok {
+		return fmt.Errorf("deleting the chunks is not supported by %T", e.ObjectClient)
+	}
+	return objects.DeleteChunk(ctx, c)
+}
+
+// ListChunks implements ObjectLister
+func (e *encryptingObjectClient) ListChunks(ctx context.Context, userID string) ([]string, error) {
+	lister, ok := e.ObjectClient.(ObjectLister)
+	if !ok {
+		return nil, fmt.Errorf("listing the chunks is not supported by %T", e.ObjectClient)
+	}
+	return lister.ListChunks(ctx, userID)
+}
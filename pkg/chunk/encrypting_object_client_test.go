@@ -0,0 +1,146 @@
+package chunk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+)
+
+// mockCipher "encrypts" the chunks of userID by appending their key to them,
+// reversed.
+type mockCipher struct{}
+
+func (mockCipher) Encrypts(u string) bool {
+	return u == userID
+}
+
+func (mockCipher) Encrypt(_ context.Context, _, key string, plaintext []byte) ([]byte, error) {
+	return reversed(append(append([]byte{}, plaintext...), key...)), nil
+}
+
+func (mockCipher) Decrypt(_ context.Context, _, key string, ciphertext []byte) ([]byte, error) {
+	buf := reversed(ciphertext)
+	if !bytes.HasSuffix(buf, []byte(key)) {
+		return nil, fmt.Errorf("wrong key")
+	}
+	return buf[:len(buf)-len(key)], nil
+}
+
+func reversed(buf []byte) []byte {
+	out := make([]byte, len(buf))
+	for i := range buf {
+		out[len(buf)-1-i] = buf[i]
+	}
+	return out
+}
+
+func TestEncryptingObjectClient(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	client := NewEncryptingObjectClient(storage, mockCipher{})
+
+	encrypted := dummyChunk(model.Now())
+	plaintext := dummyChunk(model.Now())
+	plaintext.UserID = "other"
+	require.NoError(t, plaintext.Encode())
+	require.NoError(t, client.PutChunks(ctx, []Chunk{encrypted, plaintext}))
+
+	// The chunks of the tenants the cipher encrypts are encrypted.
+	buf, err := encrypted.Encoded()
+	require.NoError(t, err)
+	require.True(t, isEncrypted(storage.objects[encrypted.ExternalKey()]))
+	require.NotEqual(t, buf, storage.objects[encrypted.ExternalKey()])
+	buf, err = plaintext.Encoded()
+	require.NoError(t, err)
+	require.Equal(t, buf, storage.objects[plaintext.ExternalKey()])
+
+	// Both are decoded by the encrypting client.
+	fetched, err := client.GetChunks(ctx, []Chunk{encrypted, plaintext})
+	require.NoError(t, err)
+	require.Len(t, fetched, 2)
+	for _, c := range fetched {
+		require.Nil(t, c.decrypter)
+		samples, err := c.Samples(0, model.Latest)
+		require.NoError(t, err)
+		require.Len(t, samples, 1)
+	}
+
+	// The encrypted chunk can't be decoded without the cipher.
+	_, err = storage.GetChunks(ctx, []Chunk{encrypted})
+	require.Equal(t, ErrEncryptedChunk, errors.Cause(err))
+	_, err = storage.GetChunks(ctx, []Chunk{plaintext})
+	require.NoError(t, err)
+
+	// The ciphertext is authenticated with the key of the chunk.
+	other := dummyChunk(model.Now().Add(time.Minute))
+	storage.objects[other.ExternalKey()] = storage.objects[encrypted.ExternalKey()]
+	_, err = client.GetChunks(ctx, []Chunk{other})
+	require.Error(t, err)
+
+	// The client deletes and lists the chunks of the client it encrypts for.
+	keys, err := client.(ObjectLister).ListChunks(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.NoError(t, client.(ObjectDeleter).DeleteChunk(ctx, encrypted))
+	require.NotContains(t, storage.objects, encrypted.ExternalKey())
+}
+
+func TestFetcherCachesEncryptedChunks(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	client := NewEncryptingObjectClient(storage, mockCipher{})
+
+	encrypted := dummyChunk(model.Now())
+	plaintext := dummyChunk(model.Now())
+	plaintext.UserID = "other"
+	require.NoError(t, plaintext.Encode())
+	chunks := []Chunk{encrypted, plaintext}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].ExternalKey() < chunks[j].ExternalKey() })
+	keys := keysFromChunks(chunks)
+	require.NoError(t, client.PutChunks(ctx, chunks))
+
+	chunkCache := cache.NewMockCache()
+	fetcher, err := NewChunkFetcher(cache.Config{Cache: chunkCache}, false, client)
+	require.NoError(t, err)
+	defer fetcher.Stop()
+
+	fetched, err := fetcher.FetchChunks(ctx, chunks, keys)
+	require.NoError(t, err)
+	require.Len(t, fetched, 2)
+
+	// The chunks of the tenants the cipher encrypts are cached encrypted.
+	found, bufs, missing := chunkCache.Fetch(ctx, keys)
+	require.Empty(t, missing)
+	require.Len(t, found, 2)
+	for i, key := range found {
+		buf, err := plaintext.Encoded()
+		require.NoError(t, err)
+		if key == plaintext.ExternalKey() {
+			require.Equal(t, buf, bufs[i])
+		} else {
+			require.True(t, isEncrypted(bufs[i]))
+		}
+	}
+
+	// They're decrypted when fetched from the cache.
+	for _, key := range keys {
+		delete(storage.objects, key)
+	}
+	fetched, err = fetcher.FetchChunks(ctx, chunks, keys)
+	require.NoError(t, err)
+	require.Len(t, fetched, 2)
+	for _, c := range fetched {
+		samples, err := c.Samples(0, model.Latest)
+		require.NoError(t, err)
+		require.Len(t, samples, 1)
+	}
+}
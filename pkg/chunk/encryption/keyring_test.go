@@ -0,0 +1,157 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockLimits map[string]string
+
+func (m mockLimits) ChunkEncryptionKeyID(userID string) string {
+	return m[userID]
+}
+
+// countingKMS counts the data keys generated and decrypted.
+type countingKMS struct {
+	KMS
+	generated, decrypted int
+}
+
+func (c *countingKMS) GenerateDataKey(ctx context.Context, keyID, userID string) ([]byte, []byte, error) {
+	c.generated++
+	return c.KMS.GenerateDataKey(ctx, keyID, userID)
+}
+
+func (c *countingKMS) Decrypt(ctx context.Context, userID string, wrapped []byte) ([]byte, error) {
+	c.decrypted++
+	return c.KMS.Decrypt(ctx, userID, wrapped)
+}
+
+func newTestKMS(t *testing.T, keyIDs ...string) KMS {
+	dir, err := ioutil.TempDir("", "encryption")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keys := "keys:\n"
+	for _, id := range keyIDs {
+		keys += "  " + id + ": " + base64.StdEncoding.EncodeToString([]byte(id + "-0123456789abcdef0123456789abcdef")[:32]) + "\n"
+	}
+	filename := filepath.Join(dir, "keys.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(keys), 0644))
+	kms, err := NewStaticKMS(filename)
+	require.NoError(t, err)
+	return kms
+}
+
+func TestKeyring(t *testing.T) {
+	ctx := context.Background()
+	kms := &countingKMS{KMS: newTestKMS(t, "key1", "key2")}
+	limits := mockLimits{"user1": "key1", "user2": "key1"}
+	keyring := NewKeyring(Config{DataKeyRotationPeriod: time.Hour, DataKeyCacheSize: 10}, kms, limits)
+
+	require.True(t, keyring.Encrypts("user1"))
+	require.False(t, keyring.Encrypts("user3"))
+
+	ciphertext, err := keyring.Encrypt(ctx, "user1", "user1/a", []byte("chunk"))
+	require.NoError(t, err)
+	require.NotContains(t, string(ciphertext), "chunk")
+
+	plaintext, err := keyring.Decrypt(ctx, "user1", "user1/a", ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, []byte("chunk"), plaintext)
+
+	// The ciphertext is authenticated with the key and the tenant.
+	_, err = keyring.Decrypt(ctx, "user1", "user1/b", ciphertext)
+	require.Error(t, err)
+	_, err = keyring.Decrypt(ctx, "user2", "user1/a", ciphertext)
+	require.Error(t, err)
+
+	// Another keyring, e.g. of a querier, decrypts the chunks.
+	other := NewKeyring(Config{DataKeyCacheSize: 10}, kms, nil)
+	require.False(t, other.Encrypts("user1"))
+	plaintext, err = other.Decrypt(ctx, "user1", "user1/a", ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, []byte("chunk"), plaintext)
+}
+
+func TestKeyringRotation(t *testing.T) {
+	ctx := context.Background()
+	kms := &countingKMS{KMS: newTestKMS(t, "key1", "key2")}
+	limits := mockLimits{"user1": "key1"}
+	keyring := NewKeyring(Config{DataKeyRotationPeriod: time.Hour, DataKeyCacheSize: 10}, kms, limits)
+	now := time.Now()
+	keyring.now = func() time.Time { return now }
+
+	first, err := keyring.Encrypt(ctx, "user1", "user1/a", []byte("chunk"))
+	require.NoError(t, err)
+	_, err = keyring.Encrypt(ctx, "user1", "user1/b", []byte("chunk"))
+	require.NoError(t, err)
+	require.Equal(t, 1, kms.generated)
+
+	// A new data key is generated once the rotation period is over.
+	now = now.Add(time.Hour)
+	second, err := keyring.Encrypt(ctx, "user1", "user1/c", []byte("chunk"))
+	require.NoError(t, err)
+	require.Equal(t, 2, kms.generated)
+
+	// Or once the master key of the tenant changes.
+	limits["user1"] = "key2"
+	third, err := keyring.Encrypt(ctx, "user1", "user1/d", []byte("chunk"))
+	require.NoError(t, err)
+	require.Equal(t, 3, kms.generated)
+
+	// The chunks of all the data keys are decrypted, without unwrapping the
+	// data keys cached.
+	for key, ciphertext := range map[string][]byte{"user1/a": first, "user1/c": second, "user1/d": third} {
+		plaintext, err := keyring.Decrypt(ctx, "user1", key, ciphertext)
+		require.NoError(t, err)
+		require.Equal(t, []byte("chunk"), plaintext)
+	}
+	require.Equal(t, 0, kms.decrypted)
+
+	other := NewKeyring(Config{DataKeyCacheSize: 10}, kms, nil)
+	for i := 0; i < 2; i++ {
+		_, err = other.Decrypt(ctx, "user1", "user1/a", first)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, kms.decrypted)
+}
+
+func TestStaticKMS(t *testing.T) {
+	ctx := context.Background()
+	kms := newTestKMS(t, "key1")
+
+	plaintext, wrapped, err := kms.GenerateDataKey(ctx, "key1", "user1")
+	require.NoError(t, err)
+	require.Len(t, plaintext, 32)
+
+	unwrapped, err := kms.Decrypt(ctx, "user1", wrapped)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, unwrapped)
+
+	// The data keys are only unwrapped for their tenant.
+	_, err = kms.Decrypt(ctx, "user2", wrapped)
+	require.Error(t, err)
+
+	_, _, err = kms.GenerateDataKey(ctx, "key2", "user1")
+	require.Error(t, err)
+	_, _, err = kms.GenerateDataKey(ctx, strings.Repeat("k", 256), "user1")
+	require.Error(t, err)
+
+	// The master keys are 32 bytes.
+	dir, err := ioutil.TempDir("", "encryption")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "keys.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("keys:\n  key1: "+base64.StdEncoding.EncodeToString([]byte("short"))+"\n"), 0644))
+	_, err = NewStaticKMS(filename)
+	require.Error(t, err)
+}
@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+var reencryptedChunks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cortex",
+	Name:      "chunk_reencryptor_reencrypted_chunks_total",
+	Help:      "Number of chunks written again with the current data key of their tenant.",
+}, []string{"user"})
+
+// ReencryptorConfig is the config of the re-encryption of the chunks.
+type ReencryptorConfig struct {
+	Users     flagext.StringSlice `yaml:"users"`
+	BatchSize int                 `yaml:"batch_size"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *ReencryptorConfig) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&cfg.Users, "chunk-reencryptor.user", "Tenant whose chunks are re-encrypted; repeat the flag for each tenant.")
+	f.IntVar(&cfg.BatchSize, "chunk-reencryptor.batch-size", 100, "Number of chunks fetched and written again at once.")
+}
+
+// Reencrypt writes again all the chunks of a tenant to an encrypting object
+// client, so that they're encrypted with the current data key of the tenant,
+// e.g. once its master key changed, or the chunks written before encryption
+// was enabled; or decrypted, once the tenant's chunks are no longer encrypted.
+// It returns the number of chunks written.
+func Reencrypt(ctx context.Context, client chunk.ObjectClient, userID string, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("the batch size must be positive")
+	}
+	lister, ok := client.(chunk.ObjectLister)
+	if !ok {
+		return 0, fmt.Errorf("listing the chunks is not supported by %T", client)
+	}
+	keys, err := lister.ListChunks(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		keys = keys[len(batch):]
+
+		chunks := make([]chunk.Chunk, 0, len(batch))
+		for _, key := range batch {
+			c, err := chunk.ParseExternalKey(userID, key)
+			if err != nil {
+				return written, err
+			}
+			chunks = append(chunks, c)
+		}
+		fetched, err := client.GetChunks(ctx, chunks)
+		if err != nil {
+			return written, err
+		}
+		if len(fetched) != len(chunks) {
+			return written, fmt.Errorf("fetched %d chunks out of %d", len(fetched), len(chunks))
+		}
+		if err := client.PutChunks(ctx, fetched); err != nil {
+			return written, err
+		}
+		written += len(fetched)
+		reencryptedChunks.WithLabelValues(userID).Add(float64(len(fetched)))
+	}
+	return written, nil
+}
@@ -0,0 +1,58 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+)
+
+func newTestChunks(t *testing.T, userID string, n int) []chunk.Chunk {
+	var chunks []chunk.Chunk
+	for i := 0; i < n; i++ {
+		metric := labels.Labels{{Name: labels.MetricName, Value: "foo"}}
+		from := model.Time(i) * model.Time(time.Hour/time.Millisecond)
+		pcs, err := encoding.New().Add(model.SamplePair{Timestamp: from, Value: 1})
+		require.NoError(t, err)
+		c := chunk.NewChunk(userID, model.Fingerprint(metric.Hash()), metric, pcs[0], from, from.Add(time.Minute))
+		require.NoError(t, c.Encode())
+		chunks = append(chunks, c)
+	}
+	return chunks
+}
+
+func TestReencrypt(t *testing.T) {
+	ctx := context.Background()
+	storage := chunk.NewMockStorage()
+	chunks := newTestChunks(t, "user1", 5)
+	require.NoError(t, storage.PutChunks(ctx, chunks))
+
+	// Once encryption is enabled for the tenant, the chunks written before
+	// are encrypted by the re-encryption.
+	limits := mockLimits{"user1": "key1"}
+	client := chunk.NewEncryptingObjectClient(storage, NewKeyring(Config{DataKeyCacheSize: 10}, newTestKMS(t, "key1"), limits))
+	n, err := Reencrypt(ctx, client, "user1", 2)
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	_, err = storage.GetChunks(ctx, chunks[:1])
+	require.Equal(t, chunk.ErrEncryptedChunk, errors.Cause(err))
+	fetched, err := client.GetChunks(ctx, chunks)
+	require.NoError(t, err)
+	require.Len(t, fetched, 5)
+
+	// And decrypted once it's disabled.
+	delete(limits, "user1")
+	_, err = Reencrypt(ctx, client, "user1", 10)
+	require.NoError(t, err)
+	fetched, err = storage.GetChunks(ctx, chunks)
+	require.NoError(t, err)
+	require.Len(t, fetched, 5)
+}
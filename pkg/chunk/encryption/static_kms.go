@@ -0,0 +1,104 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The IDs of the master keys are stored with the data keys they wrap after
+// their length, on a byte.
+const maxStaticKeyIDLength = 255
+
+// StaticKeys is the YAML file of the master keys of the static KMS.
+type StaticKeys struct {
+	// The base64 encoding of the 32 bytes of each key, by key ID.
+	Keys map[string]string `yaml:"keys"`
+}
+
+// staticKMS wraps the data keys with AES-256-GCM by master keys read from a
+// file, for the installations without a KMS. The wrapped keys are the length
+// of the ID of the master key and the ID, then the nonce and the ciphertext,
+// authenticated with the tenant.
+type staticKMS struct {
+	keys map[string]cipher.AEAD
+}
+
+// NewStaticKMS makes a KMS of the master keys of a StaticKeys file. The master
+// keys are rotated by adding a new key, set as the key of the tenants, while
+// the previous one is kept to decrypt the data keys wrapped with it.
+func NewStaticKMS(filename string) (KMS, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var keys StaticKeys
+	if err := yaml.UnmarshalStrict(buf, &keys); err != nil {
+		return nil, err
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("no master keys in %s", filename)
+	}
+
+	kms := &staticKMS{keys: map[string]cipher.AEAD{}}
+	for id, encoded := range keys.Keys {
+		if len(id) > maxStaticKeyIDLength {
+			return nil, fmt.Errorf("master key ID too long: %s", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %v", id, err)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %v", id, err)
+		}
+		kms.keys[id] = aead
+	}
+	return kms, nil
+}
+
+func (s *staticKMS) GenerateDataKey(_ context.Context, keyID, userID string) ([]byte, []byte, error) {
+	if len(keyID) > maxStaticKeyIDLength {
+		return nil, nil, fmt.Errorf("master key ID too long: %s", keyID)
+	}
+	aead, ok := s.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown master key %s", keyID)
+	}
+
+	plaintext := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		return nil, nil, err
+	}
+	wrapped := make([]byte, 1+len(keyID)+aead.NonceSize())
+	wrapped[0] = byte(len(keyID))
+	copy(wrapped[1:], keyID)
+	nonce := wrapped[1+len(keyID):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, aead.Seal(wrapped, nonce, plaintext, []byte(userID)), nil
+}
+
+func (s *staticKMS) Decrypt(_ context.Context, userID string, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 1 || len(wrapped) < 1+int(wrapped[0]) {
+		return nil, fmt.Errorf("wrapped data key too short")
+	}
+	keyID := string(wrapped[1 : 1+int(wrapped[0])])
+	aead, ok := s.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %s", keyID)
+	}
+	rest := wrapped[1+len(keyID):]
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped data key too short")
+	}
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], []byte(userID))
+}
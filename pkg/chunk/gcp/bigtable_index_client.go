@@ -8,6 +8,7 @@ import (
 	"flag"
 	"fmt"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/bigtable"
 	ot "github.com/opentracing/opentracing-go"
@@ -36,6 +37,11 @@ type Config struct {
 
 	GRPCClientConfig grpcclient.Config `yaml:"grpc_client_config"`
 
+	TableGCMaxAge             time.Duration `yaml:"table_gc_max_age"`
+	TableSplitWriteThroughput int64         `yaml:"table_split_write_throughput"`
+
+	ChunkFetch chunk_util.FetchConfig `yaml:"chunk_fetch"`
+
 	ColumnKey      bool
 	DistributeKeys bool
 }
@@ -44,6 +50,10 @@ type Config struct {
 func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.Project, "bigtable.project", "", "Bigtable project ID.")
 	f.StringVar(&cfg.Instance, "bigtable.instance", "", "Bigtable instance ID.")
+	f.DurationVar(&cfg.TableGCMaxAge, "bigtable.table-gc-max-age", 0, "Age after which Bigtable garbage collects the cells written to the tables, set as the GC policy of their column family; 0 for no garbage collection.")
+	f.Int64Var(&cfg.TableSplitWriteThroughput, "bigtable.table-split-write-throughput", 0, "Write throughput of the tables per tablet: the tables of bigtable-hashed are created split into their provisioned write throughput divided by this many tablets; 0 not to split them.")
+
+	cfg.ChunkFetch.RegisterMaxParallelismFlagWithPrefix("bigtable", f)
 
 	cfg.GRPCClientConfig.RegisterFlags("bigtable", f)
 }
@@ -133,17 +143,38 @@ func (s *storageClientColumnKey) Stop() {
 
 func (s *storageClientColumnKey) NewWriteBatch() chunk.WriteBatch {
 	return bigtableWriteBatch{
-		tables: map[string]map[string]*bigtable.Mutation{},
-		keysFn: s.keysFn,
+		tables:    map[string]map[string]*bigtable.Mutation{},
+		keysFn:    s.keysFn,
+		timestamp: s.cfg.cellTimestamp(),
+	}
+}
+
+// cellTimestamp returns the timestamp of the cells written: the time they're
+// written with garbage collection by age, so that it applies, 0 otherwise.
+func (cfg Config) cellTimestamp() bigtable.Timestamp {
+	if cfg.TableGCMaxAge > 0 {
+		return bigtable.Now()
 	}
+	return 0
+}
+
+// readOptions returns the options of the reads of the cells. The cells being
+// written again with a new timestamp with garbage collection by age, only
+// the latest is read until the older ones are garbage collected.
+func (cfg Config) readOptions() []bigtable.ReadOption {
+	if cfg.TableGCMaxAge > 0 {
+		return []bigtable.ReadOption{bigtable.RowFilter(bigtable.LatestNFilter(1))}
+	}
+	return nil
 }
 
 // keysFn returns the row and column keys for the given hash and range keys.
 type keysFn func(hashValue string, rangeValue []byte) (rowKey, columnKey string)
 
 type bigtableWriteBatch struct {
-	tables map[string]map[string]*bigtable.Mutation
-	keysFn keysFn
+	tables    map[string]map[string]*bigtable.Mutation
+	keysFn    keysFn
+	timestamp bigtable.Timestamp
 }
 
 func (b bigtableWriteBatch) Add(tableName, hashValue string, rangeValue []byte, value []byte) {
@@ -160,7 +191,60 @@ func (b bigtableWriteBatch) Add(tableName, hashValue string, rangeValue []byte,
 		rows[rowKey] = mutation
 	}
 
-	mutation.Set(columnFamily, columnKey, 0, value)
+	mutation.Set(columnFamily, columnKey, b.timestamp, value)
+}
+
+func (b bigtableWriteBatch) delete(tableName, hashValue string, rangeValue []byte) {
+	rows, ok := b.tables[tableName]
+	if !ok {
+		rows = map[string]*bigtable.Mutation{}
+		b.tables[tableName] = rows
+	}
+
+	rowKey, columnKey := b.keysFn(hashValue, rangeValue)
+	mutation, ok := rows[rowKey]
+	if !ok {
+		mutation = bigtable.NewMutation()
+		rows[rowKey] = mutation
+	}
+
+	mutation.DeleteCellsInColumn(columnFamily, columnKey)
+}
+
+// DeleteEntries implements chunk.IndexDeleter.
+func (s *storageClientColumnKey) DeleteEntries(ctx context.Context, entries []chunk.IndexEntry) error {
+	batch := s.NewWriteBatch().(bigtableWriteBatch)
+	for _, entry := range entries {
+		batch.delete(entry.TableName, entry.HashValue, entry.RangeValue)
+	}
+	return s.BatchWrite(ctx, batch)
+}
+
+// ScanTable implements chunk.IndexScanner.
+func (s *storageClientColumnKey) ScanTable(ctx context.Context, tableName string, callback func(chunk.IndexEntry) bool) error {
+	table := s.client.Open(tableName)
+	return errors.WithStack(table.ReadRows(ctx, bigtable.InfiniteRange(""), func(row bigtable.Row) bool {
+		hashValue := row.Key()
+		if s.cfg.DistributeKeys {
+			// Strip the hex-encoded 64bit hash prepended to the key.
+			if len(hashValue) > 17 && hashValue[16] == '-' {
+				hashValue = hashValue[17:]
+			}
+		}
+
+		for _, item := range row[columnFamily] {
+			entry := chunk.IndexEntry{
+				TableName:  tableName,
+				HashValue:  hashValue,
+				RangeValue: []byte(strings.TrimPrefix(item.Column, columnPrefix)),
+				Value:      item.Value,
+			}
+			if !callback(entry) {
+				return false
+			}
+		}
+		return true
+	}, s.cfg.readOptions()...))
 }
 
 func (s *storageClientColumnKey) BatchWrite(ctx context.Context, batch chunk.WriteBatch) error {
@@ -244,7 +328,7 @@ func (s *storageClientColumnKey) QueryPages(ctx context.Context, queries []chunk
 					return callback(query, &columnKeyBatch{
 						items: val,
 					})
-				})
+				}, s.cfg.readOptions()...)
 
 				if processingErr != nil {
 					errs <- processingErr
@@ -297,6 +381,24 @@ func (c *columnKeyIterator) Value() []byte {
 	return c.items[c.i].Value
 }
 
+// ScanTable implements chunk.IndexScanner.
+func (s *storageClientV1) ScanTable(ctx context.Context, tableName string, callback func(chunk.IndexEntry) bool) error {
+	table := s.client.Open(tableName)
+	return errors.WithStack(table.ReadRows(ctx, bigtable.InfiniteRange(""), func(row bigtable.Row) bool {
+		parts := strings.SplitN(row.Key(), separator, 2)
+		cf, ok := row[columnFamily]
+		if len(parts) != 2 || !ok || len(cf) != 1 {
+			return true
+		}
+		return callback(chunk.IndexEntry{
+			TableName:  tableName,
+			HashValue:  parts[0],
+			RangeValue: []byte(parts[1]),
+			Value:      cf[0].Value,
+		})
+	}, s.cfg.readOptions()...))
+}
+
 func (s *storageClientV1) QueryPages(ctx context.Context, queries []chunk.IndexQuery, callback func(chunk.IndexQuery, chunk.ReadBatch) bool) error {
 	return chunk_util.DoParallelQueries(ctx, s.query, queries, callback)
 }
@@ -336,7 +438,7 @@ func (s *storageClientV1) query(ctx context.Context, query chunk.IndexQuery, cal
 		}
 
 		return true
-	})
+	}, s.cfg.readOptions()...)
 	if err != nil {
 		sp.LogFields(otlog.String("error", err.Error()))
 		return errors.WithStack(err)
@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
+	chunk_util "github.com/cortexproject/cortex/pkg/chunk/util"
 	"github.com/cortexproject/cortex/pkg/util"
 )
 
@@ -17,6 +18,7 @@ type bigtableObjectClient struct {
 	cfg       Config
 	schemaCfg chunk.SchemaConfig
 	client    *bigtable.Client
+	fetcher   *chunk_util.ParallelFetcher
 }
 
 // NewBigtableObjectClient makes a new chunk.ObjectClient that stores chunks in
@@ -35,6 +37,7 @@ func newBigtableObjectClient(cfg Config, schemaCfg chunk.SchemaConfig, client *b
 		cfg:       cfg,
 		schemaCfg: schemaCfg,
 		client:    client,
+		fetcher:   chunk_util.NewParallelFetcher("bigtable", cfg.ChunkFetch),
 	}
 }
 
@@ -59,7 +62,7 @@ func (s *bigtableObjectClient) PutChunks(ctx context.Context, chunks []chunk.Chu
 		keys[tableName] = append(keys[tableName], key)
 
 		mut := bigtable.NewMutation()
-		mut.Set(columnFamily, column, 0, buf)
+		mut.Set(columnFamily, column, s.cfg.cellTimestamp(), buf)
 		muts[tableName] = append(muts[tableName], mut)
 	}
 
@@ -111,13 +114,20 @@ func (s *bigtableObjectClient) GetChunks(ctx context.Context, input []chunk.Chun
 		for i := 0; i < len(keys); i += maxRowReads {
 			page := keys[i:util.Min(i+maxRowReads, len(keys))]
 			go func(page bigtable.RowList) {
+				release, err := s.fetcher.Acquire(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer release()
+
 				decodeContext := chunk.NewDecodeContext()
 
 				var processingErr error
 				var receivedChunks = 0
 
 				// rows are returned in key order, not order in row list
-				err := table.ReadRows(ctx, page, func(row bigtable.Row) bool {
+				err = table.ReadRows(ctx, page, func(row bigtable.Row) bool {
 					chunk, ok := chunks[row.Key()]
 					if !ok {
 						processingErr = errors.WithStack(fmt.Errorf("Got row for unknown chunk: %s", row.Key()))
@@ -133,7 +143,7 @@ func (s *bigtableObjectClient) GetChunks(ctx context.Context, input []chunk.Chun
 					receivedChunks++
 					outs <- chunk
 					return true
-				})
+				}, s.cfg.readOptions()...)
 
 				if processingErr != nil {
 					errs <- processingErr
@@ -160,3 +170,15 @@ func (s *bigtableObjectClient) GetChunks(ctx context.Context, input []chunk.Chun
 
 	return output, nil
 }
+
+// DeleteChunk implements chunk.ObjectDeleter.
+func (s *bigtableObjectClient) DeleteChunk(ctx context.Context, c chunk.Chunk) error {
+	tableName, err := s.schemaCfg.ChunkTableFor(c.From)
+	if err != nil {
+		return err
+	}
+
+	mut := bigtable.NewMutation()
+	mut.DeleteRow()
+	return s.client.Open(tableName).Apply(ctx, c.ExternalKey(), mut)
+}
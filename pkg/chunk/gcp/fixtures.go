@@ -3,6 +3,7 @@ package gcp
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"cloud.google.com/go/bigtable"
 	"cloud.google.com/go/bigtable/bttest"
@@ -27,6 +28,7 @@ type fixture struct {
 	gcsObjectClient bool
 	columnKeyClient bool
 	hashPrefix      bool
+	tableGC         bool
 }
 
 func (f *fixture) Name() string {
@@ -56,8 +58,16 @@ func (f *fixture) Clients() (
 		return
 	}
 
+	cfg := Config{
+		DistributeKeys: f.hashPrefix,
+	}
+	if f.tableGC {
+		cfg.TableGCMaxAge = 7 * 24 * time.Hour
+	}
+
 	schemaConfig = testutils.DefaultSchemaConfig("gcp-columnkey")
 	tClient = &tableClient{
+		cfg:    cfg,
 		client: adminClient,
 	}
 
@@ -65,10 +75,6 @@ func (f *fixture) Clients() (
 	if err != nil {
 		return
 	}
-
-	cfg := Config{
-		DistributeKeys: f.hashPrefix,
-	}
 	if f.columnKeyClient {
 		iClient = newStorageClientColumnKey(cfg, schemaConfig, client)
 	} else {
@@ -78,9 +84,9 @@ func (f *fixture) Clients() (
 	if f.gcsObjectClient {
 		cClient = newGCSObjectClient(GCSConfig{
 			BucketName: "chunks",
-		}, schemaConfig, f.gcssrv.Client())
+		}, schemaConfig, f.gcssrv.Client(), nil)
 	} else {
-		cClient = newBigtableObjectClient(Config{}, schemaConfig, client)
+		cClient = newBigtableObjectClient(cfg, schemaConfig, client)
 	}
 
 	return
@@ -107,5 +113,12 @@ var Fixtures = func() []testutils.Fixture {
 			}
 		}
 	}
+	for _, columnKeyClient := range []bool{true, false} {
+		fixtures = append(fixtures, &fixture{
+			name:            fmt.Sprintf("bigtable-columnkey:%v-tableGC", columnKeyClient),
+			columnKeyClient: columnKeyClient,
+			tableGC:         true,
+		})
+	}
 	return fixtures
 }()
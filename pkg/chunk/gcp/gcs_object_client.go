@@ -8,9 +8,10 @@ import (
 
 	"cloud.google.com/go/storage"
 	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
-	"github.com/cortexproject/cortex/pkg/chunk/util"
+	chunk_util "github.com/cortexproject/cortex/pkg/chunk/util"
 )
 
 type gcsObjectClient struct {
@@ -18,6 +19,9 @@ type gcsObjectClient struct {
 	schemaCfg chunk.SchemaConfig
 	client    *storage.Client
 	bucket    *storage.BucketHandle
+	limits    chunk_util.BucketLimits
+	fetcher   *chunk_util.ParallelFetcher
+	hedger    *chunk_util.Hedger
 }
 
 // GCSConfig is config for the GCS Chunk Client.
@@ -25,6 +29,9 @@ type GCSConfig struct {
 	BucketName      string        `yaml:"bucket_name"`
 	ChunkBufferSize int           `yaml:"chunk_buffer_size"`
 	RequestTimeout  time.Duration `yaml:"request_timeout"`
+
+	ChunkFetch chunk_util.FetchConfig   `yaml:"chunk_fetch"`
+	Hedging    chunk_util.HedgingConfig `yaml:"hedging"`
 }
 
 // RegisterFlags registers flags.
@@ -32,10 +39,13 @@ func (cfg *GCSConfig) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.BucketName, "gcs.bucketname", "", "Name of GCS bucket to put chunks in.")
 	f.IntVar(&cfg.ChunkBufferSize, "gcs.chunk-buffer-size", 0, "The size of the buffer that GCS client for each PUT request. 0 to disable buffering.")
 	f.DurationVar(&cfg.RequestTimeout, "gcs.request-timeout", 0, "The duration after which the requests to GCS should be timed out.")
+	cfg.ChunkFetch.RegisterFlagsWithPrefix("gcs", f)
+	cfg.Hedging.RegisterFlagsWithPrefix("gcs", f)
 }
 
 // NewGCSObjectClient makes a new chunk.ObjectClient that writes chunks to GCS.
-func NewGCSObjectClient(ctx context.Context, cfg GCSConfig, schemaCfg chunk.SchemaConfig) (chunk.ObjectClient, error) {
+// The limits, if not nil, override the bucket of the chunks per tenant.
+func NewGCSObjectClient(ctx context.Context, cfg GCSConfig, schemaCfg chunk.SchemaConfig, limits chunk_util.BucketLimits) (chunk.ObjectClient, error) {
 	option, err := gcsInstrumentation(ctx, storage.ScopeReadWrite)
 	if err != nil {
 		return nil, err
@@ -45,16 +55,19 @@ func NewGCSObjectClient(ctx context.Context, cfg GCSConfig, schemaCfg chunk.Sche
 	if err != nil {
 		return nil, err
 	}
-	return newGCSObjectClient(cfg, schemaCfg, client), nil
+	return newGCSObjectClient(cfg, schemaCfg, client, limits), nil
 }
 
-func newGCSObjectClient(cfg GCSConfig, schemaCfg chunk.SchemaConfig, client *storage.Client) chunk.ObjectClient {
+func newGCSObjectClient(cfg GCSConfig, schemaCfg chunk.SchemaConfig, client *storage.Client, limits chunk_util.BucketLimits) chunk.ObjectClient {
 	bucket := client.Bucket(cfg.BucketName)
 	return &gcsObjectClient{
 		cfg:       cfg,
 		schemaCfg: schemaCfg,
 		client:    client,
 		bucket:    bucket,
+		limits:    limits,
+		fetcher:   chunk_util.NewParallelFetcher("gcs", cfg.ChunkFetch),
+		hedger:    chunk_util.NewHedger("gcs", "GCS.GetObject", cfg.Hedging),
 	}
 }
 
@@ -68,7 +81,8 @@ func (s *gcsObjectClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) e
 		if err != nil {
 			return err
 		}
-		writer := s.bucket.Object(chunk.ExternalKey()).NewWriter(ctx)
+		bucket := s.bucketsFor(chunk.UserID)[0]
+		writer := bucket.Object(chunk.ExternalKey()).NewWriter(ctx)
 		// Default GCSChunkSize is 8M and for each call, 8M is allocated xD
 		// By setting it to 0, we just upload the object in a single a request
 		// which should work for our chunk sizes.
@@ -85,7 +99,7 @@ func (s *gcsObjectClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) e
 }
 
 func (s *gcsObjectClient) GetChunks(ctx context.Context, input []chunk.Chunk) ([]chunk.Chunk, error) {
-	return util.GetParallelChunks(ctx, input, s.getChunk)
+	return s.fetcher.GetChunks(ctx, input, s.getChunk)
 }
 
 func (s *gcsObjectClient) getChunk(ctx context.Context, decodeContext *chunk.DecodeContext, input chunk.Chunk) (chunk.Chunk, error) {
@@ -96,13 +110,24 @@ func (s *gcsObjectClient) getChunk(ctx context.Context, decodeContext *chunk.Dec
 		defer cancel()
 	}
 
-	reader, err := s.bucket.Object(input.ExternalKey()).NewReader(ctx)
-	if err != nil {
-		return chunk.Chunk{}, errors.WithStack(err)
+	var (
+		buf []byte
+		err error
+	)
+	for _, bucket := range s.bucketsFor(input.UserID) {
+		buf, err = s.hedger.Get(ctx, func(ctx context.Context) ([]byte, error) {
+			reader, err := bucket.Object(input.ExternalKey()).NewReader(ctx)
+			if err != nil {
+				return nil, err
+			}
+			defer reader.Close()
+
+			return ioutil.ReadAll(reader)
+		})
+		if err != storage.ErrObjectNotExist {
+			break
+		}
 	}
-	defer reader.Close()
-
-	buf, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return chunk.Chunk{}, errors.WithStack(err)
 	}
@@ -113,3 +138,49 @@ func (s *gcsObjectClient) getChunk(ctx context.Context, decodeContext *chunk.Dec
 
 	return input, nil
 }
+
+// DeleteChunk implements chunk.ObjectDeleter.
+func (s *gcsObjectClient) DeleteChunk(ctx context.Context, c chunk.Chunk) error {
+	chunkID := c.ExternalKey()
+	for _, bucket := range s.bucketsFor(c.UserID) {
+		if err := bucket.Object(chunkID).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// ListChunks implements chunk.ObjectLister, listing the chunks of the tenant
+// in its bucket and in the bucket of the config.
+func (s *gcsObjectClient) ListChunks(ctx context.Context, userID string) ([]string, error) {
+	var (
+		keys []string
+		seen = map[string]bool{}
+	)
+	for _, bucket := range s.bucketsFor(userID) {
+		it := bucket.Objects(ctx, &storage.Query{Prefix: userID + "/"})
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if !seen[attrs.Name] {
+				seen[attrs.Name] = true
+				keys = append(keys, attrs.Name)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// bucketsFor returns the buckets the chunks of a user may be in: the bucket
+// of the user, if any, where they're written, then the bucket of the config.
+func (s *gcsObjectClient) bucketsFor(userID string) []*storage.BucketHandle {
+	if bucket := chunk_util.TenantBucket(s.limits, userID); bucket != "" && bucket != s.cfg.BucketName {
+		return []*storage.BucketHandle{s.client.Bucket(bucket), s.bucket}
+	}
+	return []*storage.BucketHandle{s.bucket}
+}
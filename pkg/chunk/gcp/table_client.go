@@ -2,6 +2,8 @@ package gcp
 
 import (
 	"context"
+	"fmt"
+	"math"
 
 	"google.golang.org/grpc/codes"
 
@@ -12,6 +14,9 @@ import (
 	"github.com/pkg/errors"
 )
 
+// The most tablets a table is split into when created.
+const maxTableSplits = 1000
+
 type tableClient struct {
 	cfg    Config
 	client *bigtable.AdminClient
@@ -62,10 +67,12 @@ func hasColumnFamily(infos []bigtable.FamilyInfo) bool {
 }
 
 func (c *tableClient) CreateTable(ctx context.Context, desc chunk.TableDesc) error {
-	if err := c.client.CreateTable(ctx, desc.Name); err != nil {
+	created := true
+	if err := c.client.CreatePresplitTable(ctx, desc.Name, c.splitKeys(desc)); err != nil {
 		if !alreadyExistsError(err) {
 			return errors.Wrap(err, "client.CreateTable")
 		}
+		created = false
 	}
 
 	if err := c.client.CreateColumnFamily(ctx, desc.Name, columnFamily); err != nil {
@@ -74,9 +81,46 @@ func (c *tableClient) CreateTable(ctx context.Context, desc chunk.TableDesc) err
 		}
 	}
 
+	// Only the new tables are garbage collected: the cells of the tables
+	// created before may have been written with a timestamp of 0, and would all
+	// be garbage collected.
+	if policy := c.gcPolicy(); policy != nil && created {
+		if err := c.client.SetGCPolicy(ctx, desc.Name, columnFamily, policy); err != nil {
+			return errors.Wrap(err, "client.SetGCPolicy")
+		}
+	}
+
 	return nil
 }
 
+// gcPolicy returns the GC policy of the column family of the new tables, nil to
+// leave it as it is. The cells written again are garbage collected too.
+func (c *tableClient) gcPolicy() bigtable.GCPolicy {
+	if c.cfg.TableGCMaxAge <= 0 {
+		return nil
+	}
+	return bigtable.UnionPolicy(bigtable.MaxAgePolicy(c.cfg.TableGCMaxAge), bigtable.MaxVersionsPolicy(1))
+}
+
+// splitKeys returns the keys splitting a new table into tablets as per its
+// provisioned write throughput. Only the keys of bigtable-hashed, starting
+// with their hex-encoded hash, are evenly distributed across the tablets.
+func (c *tableClient) splitKeys(desc chunk.TableDesc) []string {
+	if !c.cfg.DistributeKeys || c.cfg.TableSplitWriteThroughput <= 0 {
+		return nil
+	}
+	tablets := (desc.ProvisionedWrite + c.cfg.TableSplitWriteThroughput - 1) / c.cfg.TableSplitWriteThroughput
+	if tablets > maxTableSplits {
+		tablets = maxTableSplits
+	}
+
+	keys := make([]string, 0, tablets)
+	for i := int64(1); i < tablets; i++ {
+		keys = append(keys, fmt.Sprintf("%08x", uint32(i*(math.MaxUint32+1)/tablets)))
+	}
+	return keys
+}
+
 func alreadyExistsError(err error) bool {
 	serr, ok := status.FromError(err)
 	return ok && serr.Code() == codes.AlreadyExists
@@ -0,0 +1,82 @@
+package gcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"cloud.google.com/go/bigtable/bttest"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+func TestTableClientGCPolicy(t *testing.T) {
+	srv, err := bttest.NewServer("localhost:0")
+	require.NoError(t, err)
+	defer srv.Close()
+
+	conn, err := grpc.Dial(srv.Addr, grpc.WithInsecure())
+	require.NoError(t, err)
+	ctx := context.Background()
+	adminClient, err := bigtable.NewAdminClient(ctx, proj, instance, option.WithGRPCConn(conn))
+	require.NoError(t, err)
+
+	gcPolicy := func(table string) string {
+		info, err := adminClient.TableInfo(ctx, table)
+		require.NoError(t, err)
+		for _, family := range info.FamilyInfos {
+			if family.Name == columnFamily {
+				return family.GCPolicy
+			}
+		}
+		t.Fatalf("no column family %s in %s", columnFamily, table)
+		return ""
+	}
+
+	// Without a max age, the GC policy is left as it is.
+	client := &tableClient{client: adminClient}
+	desc := chunk.TableDesc{Name: "index_1", ProvisionedWrite: 1000}
+	require.NoError(t, client.CreateTable(ctx, desc))
+	require.NoError(t, client.UpdateTable(ctx, chunk.TableDesc{Name: desc.Name}, desc))
+	require.Equal(t, "<never>", gcPolicy(desc.Name))
+
+	// The tables created before keep their GC policy, their cells having
+	// possibly been written with a timestamp of 0.
+	client.cfg.TableGCMaxAge = 7 * 24 * time.Hour
+	require.NoError(t, client.UpdateTable(ctx, chunk.TableDesc{Name: desc.Name}, desc))
+	require.Equal(t, "<never>", gcPolicy(desc.Name))
+	require.NoError(t, client.CreateTable(ctx, desc))
+	require.Equal(t, "<never>", gcPolicy(desc.Name))
+
+	desc = chunk.TableDesc{Name: "index_2", ProvisionedWrite: 1000}
+	require.NoError(t, client.CreateTable(ctx, desc))
+	require.Equal(t, "(age() > 7d || versions() > 1)", gcPolicy(desc.Name))
+
+	tables, err := client.ListTables(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"index_1", "index_2"}, tables)
+}
+
+func TestTableClientSplitKeys(t *testing.T) {
+	desc := chunk.TableDesc{Name: "index_1", ProvisionedWrite: 1000}
+	for _, tc := range []struct {
+		cfg      Config
+		expected []string
+	}{
+		{Config{TableSplitWriteThroughput: 250}, nil},
+		{Config{DistributeKeys: true}, nil},
+		{Config{DistributeKeys: true, TableSplitWriteThroughput: 1000}, []string{}},
+		{Config{DistributeKeys: true, TableSplitWriteThroughput: 250}, []string{"40000000", "80000000", "c0000000"}},
+		{Config{DistributeKeys: true, TableSplitWriteThroughput: 300}, []string{"40000000", "80000000", "c0000000"}},
+	} {
+		client := &tableClient{cfg: tc.cfg}
+		require.Equal(t, tc.expected, client.splitKeys(desc))
+	}
+
+	client := &tableClient{cfg: Config{DistributeKeys: true, TableSplitWriteThroughput: 1}}
+	require.Len(t, client.splitKeys(desc), maxTableSplits-1)
+}
@@ -0,0 +1,248 @@
+package chunk
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/mtime"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const indexGCDeleteBatchSize = 1000
+
+func (m *TableManager) indexGCLoop() {
+	defer m.wait.Done()
+
+	ticker := time.NewTicker(m.cfg.IndexGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.DeleteExpiredIndexEntries(context.Background()); err != nil {
+				level.Error(util.Logger).Log("msg", "error garbage collecting the index", "err", err)
+			}
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// DeleteExpiredIndexEntries scans the index tables of the schema for the
+// entries of the chunks past the retention period of their tenant, and deletes
+// them, or only logs and counts them in dry-run mode. With the schemas
+// indexing the series, the label entries of the series left without chunks are
+// deleted too. The tables past the retention period of the config are left to
+// the table retention. It is exposed for testing.
+func (m *TableManager) DeleteExpiredIndexEntries(ctx context.Context) error {
+	now := model.TimeFromUnixNano(mtime.Now().UnixNano())
+	dryRun := m.cfg.RetentionDryRun || !m.cfg.RetentionDeletesEnabled
+
+	existing, err := m.client.ListTables(ctx)
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, table := range existing {
+		exists[table] = true
+	}
+
+	scanned := map[string]bool{}
+	schemaCfg := m.schemaConfig()
+	for i, cfg := range schemaCfg.Configs {
+		through := now
+		if i+1 < len(schemaCfg.Configs) && schemaCfg.Configs[i+1].From.Time.Before(now) {
+			through = schemaCfg.Configs[i+1].From.Time
+		}
+		if cfg.From.Time.After(through) {
+			continue
+		}
+
+		for _, table := range cfg.IndexTables.tables(cfg.From.Time, through) {
+			if !exists[table] || scanned[table] {
+				continue
+			}
+			scanned[table] = true
+
+			if err := m.deleteExpiredIndexEntries(ctx, cfg, table, now, dryRun); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteExpiredIndexEntries garbage collects an index table in three scans,
+// deleting the entries in batches as they're iterated. The first deletes the
+// entries of the expired chunks, and finds the series rows they are in with
+// the series schemas; the second finds which of those rows still have chunks
+// in the bucket; the third deletes the label entries of the series of the
+// other rows.
+func (m *TableManager) deleteExpiredIndexEntries(ctx context.Context, cfg PeriodConfig, tableName string, now model.Time, dryRun bool) error {
+	scanner := m.indexClient.(IndexScanner)
+	seriesSchema := false
+	switch cfg.Schema {
+	case "v9", "v10", "v12":
+		seriesSchema = true
+	}
+
+	var (
+		deleter = m.newIndexBatchDeleter(ctx, dryRun)
+		deleted = map[string]int{}
+
+		// The series rows with expired chunks, by hash value, to the tenant
+		// of those chunks.
+		expiredRows = map[string]string{}
+	)
+	err := scanner.ScanTable(ctx, tableName, func(entry IndexEntry) bool {
+		chunkID, _, _, isSeriesID, err := parseChunkTimeRangeValue(entry.RangeValue, entry.Value)
+		if err != nil || isSeriesID {
+			return true
+		}
+		userID, ok := m.chunkExpired(chunkID, now)
+		if !ok {
+			return true
+		}
+
+		deleted[userID]++
+		if seriesSchema {
+			expiredRows[entry.HashValue] = userID
+		}
+		return deleter.add(entry)
+	})
+	if err == nil {
+		err = deleter.flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(expiredRows) > 0 {
+		// The series IDs have no ':', so the series rows are the buckets of
+		// their label entries suffixed with the series ID.
+		bucketsBySeries := map[string][]string{}
+		for hashValue := range expiredRows {
+			i := strings.LastIndexByte(hashValue, ':')
+			if i < 0 {
+				continue
+			}
+			seriesID := hashValue[i+1:]
+			bucketsBySeries[seriesID] = append(bucketsBySeries[seriesID], hashValue[:i])
+		}
+
+		liveRows := map[string]bool{}
+		err := scanner.ScanTable(ctx, tableName, func(entry IndexEntry) bool {
+			if _, ok := expiredRows[entry.HashValue]; !ok {
+				return true
+			}
+			chunkID, _, _, isSeriesID, err := parseChunkTimeRangeValue(entry.RangeValue, entry.Value)
+			if err != nil || isSeriesID {
+				return true
+			}
+			if _, expired := m.chunkExpired(chunkID, now); !expired {
+				liveRows[entry.HashValue] = true
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		err = scanner.ScanTable(ctx, tableName, func(entry IndexEntry) bool {
+			id, _, _, isSeriesID, err := parseChunkTimeRangeValue(entry.RangeValue, entry.Value)
+			if err != nil || !isSeriesID {
+				return true
+			}
+
+			hashValue := entry.HashValue
+			if cfg.Schema == "v10" {
+				// Only the label rows are sharded by the v10 schema.
+				hashValue = hashValue[strings.IndexByte(hashValue, ':')+1:]
+			}
+			for _, bucket := range bucketsBySeries[id] {
+				if !strings.HasPrefix(hashValue, bucket+":") {
+					continue
+				}
+				row := bucket + ":" + id
+				if liveRows[row] {
+					return true
+				}
+				deleted[expiredRows[row]]++
+				return deleter.add(entry)
+			}
+			return true
+		})
+		if err == nil {
+			err = deleter.flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for userID, n := range deleted {
+		level.Info(util.Logger).Log("msg", "index entries have exceeded the retention period", "table", tableName, "user", userID, "entries", n, "dry_run", dryRun)
+		retentionDeletedIndexEntries.WithLabelValues(userID, strconv.FormatBool(dryRun)).Add(float64(n))
+	}
+	return nil
+}
+
+// chunkExpired returns the tenant of a chunk and whether it's past their
+// retention period. The legacy chunk IDs, without their tenant, never expire.
+func (m *TableManager) chunkExpired(chunkID string, now model.Time) (string, bool) {
+	if !strings.Contains(chunkID, "/") {
+		return "", false
+	}
+	chunk, err := parseNewExternalKey(chunkID)
+	if err != nil {
+		return "", false
+	}
+	retention := m.retentionPeriod(chunk.UserID)
+	return chunk.UserID, retention > 0 && chunk.Through.Before(now.Add(-retention))
+}
+
+// indexBatchDeleter deletes index entries in batches of
+// indexGCDeleteBatchSize, or drops them in dry-run mode.
+type indexBatchDeleter struct {
+	ctx     context.Context
+	deleter IndexDeleter
+	dryRun  bool
+	batch   []IndexEntry
+	err     error
+}
+
+func (m *TableManager) newIndexBatchDeleter(ctx context.Context, dryRun bool) *indexBatchDeleter {
+	return &indexBatchDeleter{
+		ctx:     ctx,
+		deleter: m.indexClient.(IndexDeleter),
+		dryRun:  dryRun,
+	}
+}
+
+// add queues the entry for deletion, deleting the batch once it's full. It
+// returns false on error, to stop the scan.
+func (d *indexBatchDeleter) add(entry IndexEntry) bool {
+	if d.dryRun {
+		return true
+	}
+	d.batch = append(d.batch, entry)
+	if len(d.batch) >= indexGCDeleteBatchSize {
+		d.err = d.flush()
+	}
+	return d.err == nil
+}
+
+// flush deletes the queued entries, returning the error of any batch.
+func (d *indexBatchDeleter) flush() error {
+	if d.err != nil || len(d.batch) == 0 {
+		return d.err
+	}
+	d.err = d.deleter.DeleteEntries(d.ctx, d.batch)
+	d.batch = d.batch[:0]
+	return d.err
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/cortexproject/cortex/pkg/util"
@@ -274,6 +275,57 @@ func (m *MockStorage) query(ctx context.Context, query IndexQuery, callback func
 	return nil
 }
 
+// DeleteEntries implements IndexDeleter.
+func (m *MockStorage) DeleteEntries(_ context.Context, entries []IndexEntry) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, entry := range entries {
+		table, ok := m.tables[entry.TableName]
+		if !ok {
+			return fmt.Errorf("table not found")
+		}
+
+		items := table.items[entry.HashValue]
+		i := sort.Search(len(items), func(i int) bool {
+			return bytes.Compare(items[i].rangeValue, entry.RangeValue) >= 0
+		})
+		if i < len(items) && bytes.Equal(items[i].rangeValue, entry.RangeValue) {
+			table.items[entry.HashValue] = append(items[:i], items[i+1:]...)
+		}
+	}
+	return nil
+}
+
+// ScanTable implements IndexScanner.
+func (m *MockStorage) ScanTable(_ context.Context, tableName string, callback func(IndexEntry) bool) error {
+	m.mtx.RLock()
+	table, ok := m.tables[tableName]
+	if !ok {
+		m.mtx.RUnlock()
+		return fmt.Errorf("table not found")
+	}
+	var entries []IndexEntry
+	for hashValue, items := range table.items {
+		for _, item := range items {
+			entries = append(entries, IndexEntry{
+				TableName:  tableName,
+				HashValue:  hashValue,
+				RangeValue: item.rangeValue,
+				Value:      item.value,
+			})
+		}
+	}
+	m.mtx.RUnlock()
+
+	for _, entry := range entries {
+		if !callback(entry) {
+			return nil
+		}
+	}
+	return nil
+}
+
 // PutChunks implements StorageClient.
 func (m *MockStorage) PutChunks(_ context.Context, chunks []Chunk) error {
 	m.mtx.Lock()
@@ -310,6 +362,29 @@ func (m *MockStorage) GetChunks(ctx context.Context, chunkSet []Chunk) ([]Chunk,
 	return result, nil
 }
 
+// DeleteChunk implements ObjectDeleter.
+func (m *MockStorage) DeleteChunk(_ context.Context, c Chunk) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	delete(m.objects, c.ExternalKey())
+	return nil
+}
+
+// ListChunks implements ObjectLister.
+func (m *MockStorage) ListChunks(_ context.Context, userID string) ([]string, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, userID+"/") {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
 type mockWriteBatch []struct {
 	tableName, hashValue string
 	rangeValue           []byte
@@ -24,16 +24,21 @@ const (
 	separator      = "\000"
 	null           = string('\xff')
 	dbReloadPeriod = 10 * time.Minute
+
+	// The number of entries read by a transaction of a table scan.
+	boltScanPageSize = 1000
 )
 
 // BoltDBConfig for a BoltDB index client.
 type BoltDBConfig struct {
 	Directory string `yaml:"directory"`
+	NoSync    bool   `yaml:"no_sync"`
 }
 
 // RegisterFlags registers flags.
 func (cfg *BoltDBConfig) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.Directory, "boltdb.dir", "", "Location of BoltDB index files.")
+	f.BoolVar(&cfg.NoSync, "boltdb.no-sync", false, "Don't sync the BoltDB index files to disk after each write, which is faster but may lose or corrupt the index on a crash of the host.")
 }
 
 type boltIndexClient struct {
@@ -141,7 +146,7 @@ func (b *boltIndexClient) getDB(name string) (*bbolt.DB, error) {
 
 	// Open the database.
 	// Set Timeout to avoid obtaining file lock wait indefinitely.
-	db, err := bbolt.Open(path.Join(b.cfg.Directory, name), 0666, &bbolt.Options{Timeout: 5 * time.Second})
+	db, err := bbolt.Open(path.Join(b.cfg.Directory, name), 0666, &bbolt.Options{Timeout: 5 * time.Second, NoSync: b.cfg.NoSync})
 	if err != nil {
 		return nil, err
 	}
@@ -177,6 +182,108 @@ func (b *boltIndexClient) BatchWrite(ctx context.Context, batch chunk.WriteBatch
 	return nil
 }
 
+// DeleteEntries implements chunk.IndexDeleter.
+func (b *boltIndexClient) DeleteEntries(ctx context.Context, entries []chunk.IndexEntry) error {
+	keysByTable := map[string][]string{}
+	for _, entry := range entries {
+		keysByTable[entry.TableName] = append(keysByTable[entry.TableName], entry.HashValue+separator+string(entry.RangeValue))
+	}
+
+	for table, keys := range keysByTable {
+		db, err := b.getDB(table)
+		if err != nil {
+			return err
+		}
+
+		if err := db.Update(func(tx *bbolt.Tx) error {
+			b := tx.Bucket(bucketName)
+			if b == nil {
+				return nil
+			}
+
+			for _, key := range keys {
+				if err := b.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanTable implements chunk.IndexScanner. The table is read in pages of
+// boltScanPageSize entries, each in its own transaction, and the callback is
+// called outside of the transactions, so that it can write to the table,
+// e.g. delete the entries scanned.
+func (b *boltIndexClient) ScanTable(ctx context.Context, tableName string, callback func(chunk.IndexEntry) bool) error {
+	db, err := b.getDB(tableName)
+	if err != nil {
+		return err
+	}
+
+	var last []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var (
+			entries = make([]chunk.IndexEntry, 0, boltScanPageSize)
+			done    = true
+		)
+		err := db.View(func(tx *bbolt.Tx) error {
+			b := tx.Bucket(bucketName)
+			if b == nil {
+				return nil
+			}
+
+			c := b.Cursor()
+			k, v := c.First()
+			if last != nil {
+				k, v = c.Seek(last)
+				if bytes.Equal(k, last) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil; k, v = c.Next() {
+				if len(entries) == boltScanPageSize {
+					done = false
+					return nil
+				}
+				// The keys and values are only valid for the life of the
+				// transaction.
+				last = append(last[:0], k...)
+				parts := bytes.SplitN(k, []byte(separator), 2)
+				if len(parts) != 2 {
+					continue
+				}
+				entries = append(entries, chunk.IndexEntry{
+					TableName:  tableName,
+					HashValue:  string(parts[0]),
+					RangeValue: append([]byte(nil), parts[1]...),
+					Value:      append([]byte(nil), v...),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if !callback(entry) {
+				return nil
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
 func (b *boltIndexClient) QueryPages(ctx context.Context, queries []chunk.IndexQuery, callback func(chunk.IndexQuery, chunk.ReadBatch) (shouldContinue bool)) error {
 	return chunk_util.DoParallelQueries(ctx, b.query, queries, callback)
 }
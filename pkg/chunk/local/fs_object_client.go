@@ -4,42 +4,75 @@ import (
 	"context"
 	"encoding/base64"
 	"flag"
+	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"time"
+	"strings"
 
-	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/chunk/util"
-	pkgUtil "github.com/cortexproject/cortex/pkg/util"
+)
+
+// The fsync policies of the chunks written.
+const (
+	FsyncNone      = "none"
+	FsyncFile      = "file"
+	FsyncDirectory = "directory"
+)
+
+const (
+	// The chunks of a sharded directory are in shardDirectories
+	// subdirectories, named from the shardPrefix, which can't be the start of
+	// the base64 encoding of a key.
+	shardPrefix      = "shard-"
+	shardDirectories = 256
+
+	tmpFilePrefix = ".tmp-"
 )
 
 // FSConfig is the config for a FSObjectClient.
 type FSConfig struct {
-	Directory string `yaml:"directory"`
+	Directory   string           `yaml:"directory"`
+	Sharding    bool             `yaml:"sharding"`
+	FsyncPolicy string           `yaml:"fsync_policy"`
+	ChunkFetch  util.FetchConfig `yaml:"chunk_fetch"`
 }
 
 // RegisterFlags registers flags.
 func (cfg *FSConfig) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.Directory, "local.chunk-directory", "", "Directory to store chunks in.")
+	f.BoolVar(&cfg.Sharding, "local.chunk-directory-sharding", false, "Write the chunks into 256 subdirectories of the chunk directory, by the hash of their key, rather than all in the chunk directory. The chunks written unsharded are still read.")
+	f.StringVar(&cfg.FsyncPolicy, "local.fsync-policy", FsyncFile, "When the chunks written are synced to disk: none, leaving it to the OS; file, syncing each chunk before it's renamed into place; or directory, also syncing its directory once renamed.")
+	cfg.ChunkFetch.RegisterFlagsWithPrefix("local", f)
 }
 
 // FSObjectClient holds config for filesystem as object store
 type FSObjectClient struct {
-	cfg FSConfig
+	cfg     FSConfig
+	fetcher *util.ParallelFetcher
 }
 
 // NewFSObjectClient makes a chunk.ObjectClient which stores chunks as files in the local filesystem.
 func NewFSObjectClient(cfg FSConfig) (*FSObjectClient, error) {
+	switch cfg.FsyncPolicy {
+	case "":
+		cfg.FsyncPolicy = FsyncNone
+	case FsyncNone, FsyncFile, FsyncDirectory:
+	default:
+		return nil, fmt.Errorf("invalid fsync policy %q, must be one of %s, %s or %s", cfg.FsyncPolicy, FsyncNone, FsyncFile, FsyncDirectory)
+	}
 	if err := ensureDirectory(cfg.Directory); err != nil {
 		return nil, err
 	}
 
 	return &FSObjectClient{
-		cfg: cfg,
+		cfg:     cfg,
+		fetcher: util.NewParallelFetcher("filesystem", cfg.ChunkFetch),
 	}, nil
 }
 
@@ -54,22 +87,105 @@ func (f *FSObjectClient) PutChunks(_ context.Context, chunks []chunk.Chunk) erro
 			return err
 		}
 
-		filename := base64.StdEncoding.EncodeToString([]byte(chunks[i].ExternalKey()))
-		if err := ioutil.WriteFile(path.Join(f.cfg.Directory, filename), buf, 0644); err != nil {
+		filename := f.legacyPath(chunks[i].ExternalKey())
+		if f.cfg.Sharding {
+			filename = f.shardedPath(chunks[i].ExternalKey())
+		}
+		if err := f.writeFile(filename, buf); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// writeFile writes a chunk to a temporary file of its directory, renamed once
+// written so that the chunk is never read half written, syncing them as per
+// the fsync policy.
+func (f *FSObjectClient) writeFile(filename string, buf []byte) error {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, tmpFilePrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if f.cfg.FsyncPolicy != FsyncNone {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+
+	if f.cfg.FsyncPolicy != FsyncDirectory {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// legacyPath is the path of a chunk in an unsharded directory: the base64
+// encoding of its key, whose slashes put it in subdirectories.
+func (f *FSObjectClient) legacyPath(key string) string {
+	return path.Join(f.cfg.Directory, base64.StdEncoding.EncodeToString([]byte(key)))
+}
+
+// shardedPath is the path of a chunk in a sharded directory: the URL-safe
+// base64 encoding of its key, in the subdirectory of the hash of its key.
+func (f *FSObjectClient) shardedPath(key string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	shard := fmt.Sprintf("%s%02x", shardPrefix, h.Sum32()%shardDirectories)
+	return path.Join(f.cfg.Directory, shard, base64.URLEncoding.EncodeToString([]byte(key)))
+}
+
+// paths returns the paths a chunk can be at, the path it's written to first.
+func (f *FSObjectClient) paths(key string) []string {
+	if f.cfg.Sharding {
+		return []string{f.shardedPath(key), f.legacyPath(key)}
+	}
+	return []string{f.legacyPath(key), f.shardedPath(key)}
+}
+
 // GetChunks implements ObjectClient
 func (f *FSObjectClient) GetChunks(ctx context.Context, chunks []chunk.Chunk) ([]chunk.Chunk, error) {
-	return util.GetParallelChunks(ctx, chunks, f.getChunk)
+	return f.fetcher.GetChunks(ctx, chunks, f.getChunk)
 }
 
 func (f *FSObjectClient) getChunk(_ context.Context, decodeContext *chunk.DecodeContext, c chunk.Chunk) (chunk.Chunk, error) {
-	filename := base64.StdEncoding.EncodeToString([]byte(c.ExternalKey()))
-	buf, err := ioutil.ReadFile(path.Join(f.cfg.Directory, filename))
+	var (
+		buf []byte
+		err error
+	)
+	for _, filename := range f.paths(c.ExternalKey()) {
+		buf, err = ioutil.ReadFile(filename)
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
 	if err != nil {
 		return c, err
 	}
@@ -81,15 +197,86 @@ func (f *FSObjectClient) getChunk(_ context.Context, decodeContext *chunk.Decode
 	return c, nil
 }
 
-// DeleteChunksBefore implements BucketClient
-func (f *FSObjectClient) DeleteChunksBefore(ctx context.Context, ts time.Time) error {
-	return filepath.Walk(f.cfg.Directory, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() && info.ModTime().Before(ts) {
-			level.Info(pkgUtil.Logger).Log("msg", "file has exceeded the retention period, removing it", "filepath", info.Name())
-			if err := os.Remove(path); err != nil {
-				return err
-			}
+// DeleteChunk implements chunk.ObjectDeleter
+func (f *FSObjectClient) DeleteChunk(_ context.Context, c chunk.Chunk) error {
+	for _, filename := range f.paths(c.ExternalKey()) {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredChunks implements BucketClient
+func (f *FSObjectClient) DeleteExpiredChunks(ctx context.Context, expired func(userID string, through model.Time) bool, dryRun bool) (map[string]int, error) {
+	deleted := map[string]int{}
+	err := f.walkChunks(ctx, func(path, key string) error {
+		c, err := chunk.ParseExternalKey(userIDFromKey(key), key)
+		if err != nil {
+			return nil // The legacy chunks don't have their tenant in their key.
+		}
+		if !expired(c.UserID, c.Through) {
+			return nil
+		}
+
+		deleted[c.UserID]++
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	return deleted, err
+}
+
+// ListChunks implements chunk.ObjectLister
+func (f *FSObjectClient) ListChunks(ctx context.Context, userID string) ([]string, error) {
+	var keys []string
+	err := f.walkChunks(ctx, func(_, key string) error {
+		if userIDFromKey(key) == userID {
+			keys = append(keys, key)
 		}
 		return nil
 	})
+	return keys, err
+}
+
+// walkChunks calls fn with the path and the key of every chunk.
+func (f *FSObjectClient) walkChunks(ctx context.Context, fn func(path, key string) error) error {
+	return filepath.Walk(f.cfg.Directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		filename, err := filepath.Rel(f.cfg.Directory, path)
+		if err != nil {
+			return err
+		}
+		filename = filepath.ToSlash(filename)
+
+		var key []byte
+		if strings.HasPrefix(filename, shardPrefix) && strings.Count(filename, "/") == 1 {
+			key, err = base64.URLEncoding.DecodeString(filename[strings.Index(filename, "/")+1:])
+		} else {
+			// The base64 encoding of the keys may have slashes, putting the
+			// unsharded chunks in subdirectories.
+			key, err = base64.StdEncoding.DecodeString(filename)
+		}
+		if err != nil {
+			return nil // Not a chunk, e.g. a temporary file.
+		}
+		return fn(path, string(key))
+	})
+}
+
+func userIDFromKey(key string) string {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return ""
 }
@@ -2,57 +2,149 @@ package local
 
 import (
 	"context"
+	"encoding/base64"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/stretchr/testify/require"
-)
 
-func TestFsObjectClient_DeleteChunksBefore(t *testing.T) {
-	deleteFilesOlderThan := 10 * time.Minute
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+)
 
+func TestFsObjectClient_DeleteExpiredChunks(t *testing.T) {
 	fsChunksDir, err := ioutil.TempDir(os.TempDir(), "fs-chunks")
 	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(fsChunksDir))
+	}()
 
 	bucketClient, err := NewFSObjectClient(FSConfig{
 		Directory: fsChunksDir,
 	})
 	require.NoError(t, err)
 
+	keys := []string{
+		"user1/a:0:3e8:0",
+		"user1/b:0:7d0:0",
+		"user2/a:0:3e8:0",
+	}
+	for _, key := range keys {
+		require.NoError(t, ioutil.WriteFile(path.Join(fsChunksDir, base64.StdEncoding.EncodeToString([]byte(key))), nil, 0644))
+	}
+	expired := func(userID string, through model.Time) bool {
+		return userID == "user1" && through < 2000
+	}
+
+	// Nothing is deleted in dry-run mode.
+	deleted, err := bucketClient.DeleteExpiredChunks(context.Background(), expired, true)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"user1": 1}, deleted)
+	files, _ := ioutil.ReadDir(fsChunksDir)
+	require.Len(t, files, 3)
+
+	deleted, err = bucketClient.DeleteExpiredChunks(context.Background(), expired, false)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"user1": 1}, deleted)
+	files, _ = ioutil.ReadDir(fsChunksDir)
+	require.Len(t, files, 2)
+	_, err = os.Stat(path.Join(fsChunksDir, base64.StdEncoding.EncodeToString([]byte(keys[0]))))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestFsObjectClient_ListChunks(t *testing.T) {
+	fsChunksDir, err := ioutil.TempDir(os.TempDir(), "fs-chunks")
+	require.NoError(t, err)
 	defer func() {
 		require.NoError(t, os.RemoveAll(fsChunksDir))
 	}()
 
-	file1 := "file1"
-	file2 := "file2"
+	objectClient, err := NewFSObjectClient(FSConfig{
+		Directory: fsChunksDir,
+	})
+	require.NoError(t, err)
+
+	for _, key := range []string{
+		"user1/a:0:3e8:0",
+		"user1/b:0:7d0:0",
+		"user10/a:0:3e8:0",
+		"user2/a:0:3e8:0",
+	} {
+		require.NoError(t, ioutil.WriteFile(path.Join(fsChunksDir, base64.StdEncoding.EncodeToString([]byte(key))), nil, 0644))
+	}
+
+	keys, err := objectClient.ListChunks(context.Background(), "user1")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"user1/a:0:3e8:0", "user1/b:0:7d0:0"}, keys)
+}
 
-	// Creating dummy files
-	require.NoError(t, os.Chdir(fsChunksDir))
+func TestFsObjectClient_Sharding(t *testing.T) {
+	fsChunksDir, err := ioutil.TempDir(os.TempDir(), "fs-chunks")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(fsChunksDir))
+	}()
 
-	f, err := os.Create(file1)
+	legacyClient, err := NewFSObjectClient(FSConfig{Directory: fsChunksDir})
+	require.NoError(t, err)
+	shardedClient, err := NewFSObjectClient(FSConfig{Directory: fsChunksDir, Sharding: true, FsyncPolicy: FsyncDirectory})
 	require.NoError(t, err)
-	require.NoError(t, f.Close())
 
-	f, err = os.Create(file2)
+	legacy := dummyChunk(t, "user1", 0)
+	sharded := dummyChunk(t, "user1", 1000)
+	require.NoError(t, legacyClient.PutChunks(context.Background(), []chunk.Chunk{legacy}))
+	require.NoError(t, shardedClient.PutChunks(context.Background(), []chunk.Chunk{sharded}))
+
+	// The sharded chunk is in a shard directory, and no temporary file is left.
+	shards, err := filepath.Glob(path.Join(fsChunksDir, shardPrefix+"*", "*"))
 	require.NoError(t, err)
-	require.NoError(t, f.Close())
+	require.Equal(t, []string{shardedClient.shardedPath(sharded.ExternalKey())}, shards)
 
-	// Verify whether all files are created
-	files, _ := ioutil.ReadDir(".")
-	require.Equal(t, 2, len(files), "Number of files should be 2")
+	// Both chunks are read, listed and deleted whatever the layout.
+	for _, client := range []*FSObjectClient{legacyClient, shardedClient} {
+		chunks, err := client.GetChunks(context.Background(), []chunk.Chunk{legacy, sharded})
+		require.NoError(t, err)
+		require.Len(t, chunks, 2)
 
-	// No files should be deleted, since all of them are not much older
-	require.NoError(t, bucketClient.DeleteChunksBefore(context.Background(), time.Now().Add(-deleteFilesOlderThan)))
-	files, _ = ioutil.ReadDir(".")
-	require.Equal(t, 2, len(files), "Number of files should be 2")
+		keys, err := client.ListChunks(context.Background(), "user1")
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{legacy.ExternalKey(), sharded.ExternalKey()}, keys)
+	}
 
-	// Changing mtime of file1 to make it look older
-	require.NoError(t, os.Chtimes(file1, time.Now().Add(-deleteFilesOlderThan), time.Now().Add(-deleteFilesOlderThan)))
-	require.NoError(t, bucketClient.DeleteChunksBefore(context.Background(), time.Now().Add(-deleteFilesOlderThan)))
+	require.NoError(t, legacyClient.DeleteChunk(context.Background(), sharded))
+	require.NoError(t, shardedClient.DeleteChunk(context.Background(), legacy))
+	keys, err := shardedClient.ListChunks(context.Background(), "user1")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
 
-	// Verifying whether older file got deleted
-	files, _ = ioutil.ReadDir(".")
-	require.Equal(t, 1, len(files), "Number of files should be 1 after enforcing retention")
+func TestFsObjectClient_FsyncPolicy(t *testing.T) {
+	fsChunksDir, err := ioutil.TempDir(os.TempDir(), "fs-chunks")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(fsChunksDir))
+	}()
+
+	for _, policy := range []string{"", FsyncNone, FsyncFile, FsyncDirectory} {
+		_, err := NewFSObjectClient(FSConfig{Directory: fsChunksDir, FsyncPolicy: policy})
+		require.NoError(t, err)
+	}
+	_, err = NewFSObjectClient(FSConfig{Directory: fsChunksDir, FsyncPolicy: "sometimes"})
+	require.Error(t, err)
+}
+
+func dummyChunk(t *testing.T, userID string, from model.Time) chunk.Chunk {
+	metric := labels.Labels{{Name: labels.MetricName, Value: "foo"}}
+	pc := encoding.New()
+	pcs, err := pc.Add(model.SamplePair{Timestamp: from, Value: 1})
+	require.NoError(t, err)
+	c := chunk.NewChunk(userID, model.Fingerprint(metric.Hash()), metric, pcs[0], from, from.Add(time.Minute))
+	require.NoError(t, c.Encode())
+	return c
 }
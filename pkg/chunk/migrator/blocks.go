@@ -0,0 +1,496 @@
+package migrator
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	tsdb_labels "github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/weaveworks/common/user"
+	"golang.org/x/time/rate"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+var (
+	convertedChunks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "blocks_migrator_converted_chunks_total",
+		Help:      "Number of chunks converted to blocks.",
+	}, []string{"user"})
+	uploadedBlocks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "blocks_migrator_uploaded_blocks_total",
+		Help:      "Number of blocks uploaded to the blocks storage.",
+	}, []string{"user"})
+)
+
+// BlocksConfig is the config of the blocks migrator.
+type BlocksConfig struct {
+	Users          flagext.StringSlice `yaml:"tenant"`
+	DataDir        string              `yaml:"data_dir"`
+	CheckpointFile string              `yaml:"checkpoint_file"`
+	BlockRange     time.Duration       `yaml:"block_range"`
+	BatchSize      int                 `yaml:"batch_size"`
+	RateLimit      float64             `yaml:"rate_limit"`
+	Verify         bool                `yaml:"verify"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *BlocksConfig) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&cfg.Users, "blocks-migrator.user", "Tenant whose chunks are converted to blocks; repeat the flag for each tenant.")
+	f.StringVar(&cfg.DataDir, "blocks-migrator.data-dir", "./blocks-migrator", "Local directory the blocks are written to before they're uploaded.")
+	f.StringVar(&cfg.CheckpointFile, "blocks-migrator.checkpoint-file", "", "File recording the time ranges converted, so that the migration resumes where it stopped; empty to start over each time.")
+	f.DurationVar(&cfg.BlockRange, "blocks-migrator.block-range", 24*time.Hour, "Time range of the blocks written, aligned on multiples of it.")
+	f.IntVar(&cfg.BatchSize, "blocks-migrator.batch-size", 100, "Number of chunks fetched at once.")
+	f.Float64Var(&cfg.RateLimit, "blocks-migrator.rate-limit", 0, "Maximum number of chunks fetched per second; 0 for no limit.")
+	f.BoolVar(&cfg.Verify, "blocks-migrator.verify", true, "Query each block written for the series of the chunks converted, failing the migration if any sample is missing.")
+}
+
+// BlocksMigrator converts the chunks of tenants of a store to TSDB blocks in
+// the blocks storage, a block per block range. The series of a tenant are
+// found listing the object clients of the store, and the chunks of each series
+// are looked up in the index of the store, so that the chunks deleted from the
+// index aren't converted. The block ranges are converted in order, a series at
+// a time, and the end of the last one converted of each tenant is
+// checkpointed after each block.
+type BlocksMigrator struct {
+	cfg       BlocksConfig
+	source    []SourcePeriod
+	store     chunk.Store
+	bucket    cortex_tsdb.Bucket
+	compactor *tsdb.LeveledCompactor
+	limiter   *rate.Limiter
+
+	// The end, in milliseconds, of the last block range converted, by tenant.
+	checkpoint map[string]int64
+}
+
+// NewBlocksMigrator makes a new BlocksMigrator from the store, whose object
+// clients are given by the source periods in order, to the bucket, resuming
+// from the checkpoint file if any.
+func NewBlocksMigrator(cfg BlocksConfig, source []SourcePeriod, store chunk.Store, bucket cortex_tsdb.Bucket) (*BlocksMigrator, error) {
+	if len(source) == 0 {
+		return nil, fmt.Errorf("no source store to convert the chunks from")
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("the batch size must be positive")
+	}
+	if cfg.BlockRange < time.Hour || cfg.BlockRange%time.Hour != 0 {
+		return nil, fmt.Errorf("the block range must be a multiple of 1h, got %s", cfg.BlockRange)
+	}
+
+	blockRange := int64(cfg.BlockRange / time.Millisecond)
+	compactor, err := tsdb.NewLeveledCompactor(context.Background(), nil, util.Logger, []int64{blockRange}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, cfg.BatchSize)
+	if cfg.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), cfg.BatchSize)
+	}
+
+	m := &BlocksMigrator{
+		cfg:        cfg,
+		source:     source,
+		store:      store,
+		bucket:     bucket,
+		compactor:  compactor,
+		limiter:    limiter,
+		checkpoint: map[string]int64{},
+	}
+	if cfg.CheckpointFile != "" {
+		buf, err := ioutil.ReadFile(cfg.CheckpointFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(buf, &m.checkpoint); err != nil {
+				return nil, fmt.Errorf("invalid checkpoint file %s: %v", cfg.CheckpointFile, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Run converts the chunks of the tenants.
+func (m *BlocksMigrator) Run(ctx context.Context) error {
+	for _, userID := range m.cfg.Users {
+		n, err := m.migrateUser(user.InjectOrgID(ctx, userID), userID)
+		if err != nil {
+			return fmt.Errorf("error converting the chunks of %s to blocks after %d blocks: %v", userID, n, err)
+		}
+		level.Info(util.Logger).Log("msg", "converted the chunks of a tenant to blocks", "user", userID, "blocks", n)
+	}
+	return nil
+}
+
+func (m *BlocksMigrator) migrateUser(ctx context.Context, userID string) (int, error) {
+	keys, err := listChunks(ctx, m.source, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// The chunks listed only give the series of each block range, and a chunk
+	// of each series to read its labels from: the chunks converted are those
+	// of the index. The series of the chunks spanning several block ranges
+	// are converted in each.
+	blockRange := int64(m.cfg.BlockRange / time.Millisecond)
+	ranges := map[int64]map[model.Fingerprint]struct{}{}
+	tenant := &tenantSeries{chunks: map[model.Fingerprint]chunk.Chunk{}, labels: map[model.Fingerprint]labels.Labels{}}
+	for _, key := range keys {
+		c, err := chunk.ParseExternalKey(userID, key)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := tenant.chunks[c.Fingerprint]; !ok {
+			tenant.chunks[c.Fingerprint] = c
+		}
+		for start := int64(c.From) - int64(c.From)%blockRange; start <= int64(c.Through); start += blockRange {
+			if start+blockRange <= m.checkpoint[userID] {
+				continue
+			}
+			if ranges[start] == nil {
+				ranges[start] = map[model.Fingerprint]struct{}{}
+			}
+			ranges[start][c.Fingerprint] = struct{}{}
+		}
+	}
+	starts := make([]int64, 0, len(ranges))
+	for start := range ranges {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	if _, resumed := m.checkpoint[userID]; resumed {
+		level.Info(util.Logger).Log("msg", "resuming the conversion of a tenant", "user", userID, "after", model.Time(m.checkpoint[userID]).Time(), "ranges", len(starts))
+	}
+
+	uploaded := 0
+	for _, start := range starts {
+		fps := make([]model.Fingerprint, 0, len(ranges[start]))
+		for fp := range ranges[start] {
+			fps = append(fps, fp)
+		}
+		sort.Slice(fps, func(i, j int) bool { return fps[i] < fps[j] })
+		delete(ranges, start)
+
+		ok, err := m.convertRange(ctx, userID, tenant, fps, start, start+blockRange)
+		if err != nil {
+			return uploaded, fmt.Errorf("converting the chunks from %s: %v", model.Time(start).Time(), err)
+		}
+		if ok {
+			uploaded++
+		}
+		if err := m.saveCheckpoint(userID, start+blockRange); err != nil {
+			return uploaded, err
+		}
+	}
+	return uploaded, nil
+}
+
+// tenantSeries are the series of a tenant: a chunk of each listed, and their
+// labels once read.
+type tenantSeries struct {
+	chunks map[model.Fingerprint]chunk.Chunk
+	labels map[model.Fingerprint]labels.Labels
+}
+
+// series are the labels of a series written to a block, and its number of
+// samples.
+type series struct {
+	labels  tsdb_labels.Labels
+	samples int
+}
+
+// convertRange writes the samples of the series between mint and maxt,
+// excluded, to a block and uploads it, returning whether there was any. The
+// samples are read and appended to the block a series at a time.
+func (m *BlocksMigrator) convertRange(ctx context.Context, userID string, tenant *tenantSeries, fps []model.Fingerprint, mint, maxt int64) (bool, error) {
+	if err := m.readLabels(ctx, tenant, fps); err != nil {
+		return false, err
+	}
+
+	// The head accepts the samples from half its chunk range before its
+	// latest: with twice the block range, the series appended after a later
+	// one are accepted from mint.
+	head, err := tsdb.NewHead(nil, nil, nil, 2*(maxt-mint))
+	if err != nil {
+		return false, err
+	}
+	defer head.Close()
+
+	written := map[model.Fingerprint]*series{}
+	for _, fp := range fps {
+		samples, err := m.readSamples(ctx, userID, fp, tenant.labels[fp], mint, maxt)
+		if err != nil {
+			return false, err
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		s := &series{labels: toTSDBLabels(tenant.labels[fp])}
+		app := head.Appender()
+		if s.samples, err = appendSamples(app, s.labels, samples); err != nil {
+			_ = app.Rollback()
+			return false, err
+		}
+		if err := app.Commit(); err != nil {
+			return false, err
+		}
+		written[fp] = s
+	}
+	if len(written) == 0 {
+		return false, nil
+	}
+
+	dir := filepath.Join(m.cfg.DataDir, userID)
+	if err := os.RemoveAll(dir); err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	id, err := m.compactor.Write(dir, head, mint, maxt, nil)
+	if err != nil || id == (ulid.ULID{}) {
+		return false, err
+	}
+	blockDir := filepath.Join(dir, id.String())
+	if m.cfg.Verify {
+		if err := verifyBlock(blockDir, written); err != nil {
+			return false, fmt.Errorf("verifying block %s: %v", id, err)
+		}
+	}
+	if err := cortex_tsdb.UploadBlock(ctx, m.bucket, userID, blockDir); err != nil {
+		return false, fmt.Errorf("uploading block %s: %v", id, err)
+	}
+	uploadedBlocks.WithLabelValues(userID).Inc()
+	level.Info(util.Logger).Log("msg", "uploaded block", "user", userID, "block", id, "series", len(written), "mint", model.Time(mint).Time(), "maxt", model.Time(maxt).Time())
+	return true, nil
+}
+
+// readLabels reads the labels of the series not read yet from a chunk of
+// each, in batches.
+func (m *BlocksMigrator) readLabels(ctx context.Context, tenant *tenantSeries, fps []model.Fingerprint) error {
+	var chunks []chunk.Chunk
+	for _, fp := range fps {
+		if _, ok := tenant.labels[fp]; !ok {
+			chunks = append(chunks, tenant.chunks[fp])
+		}
+	}
+	for len(chunks) > 0 {
+		batch := chunks
+		if len(batch) > m.cfg.BatchSize {
+			batch = batch[:m.cfg.BatchSize]
+		}
+		chunks = chunks[len(batch):]
+
+		if err := m.limiter.WaitN(ctx, len(batch)); err != nil {
+			return err
+		}
+		fetched, err := m.fetchChunks(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, c := range fetched {
+			tenant.labels[c.Fingerprint] = c.Metric
+		}
+	}
+	return nil
+}
+
+// readSamples returns the samples between mint and maxt, excluded, of the
+// chunks of a series in the index, fetched in batches.
+func (m *BlocksMigrator) readSamples(ctx context.Context, userID string, fp model.Fingerprint, lset labels.Labels, mint, maxt int64) ([]model.SamplePair, error) {
+	matchers := make([]*labels.Matcher, 0, len(lset))
+	for _, l := range lset {
+		matcher, err := labels.NewMatcher(labels.MatchEqual, l.Name, l.Value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	refs, fetchers, err := m.store.GetChunkRefs(ctx, userID, model.Time(mint), model.Time(maxt-1), matchers...)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		samples []model.SamplePair
+		seen    = map[string]bool{}
+	)
+	for i, cs := range refs {
+		// The series with more labels match too, and the chunks spanning
+		// several periods are in the index of each.
+		var chunks []chunk.Chunk
+		for _, c := range cs {
+			if key := c.ExternalKey(); c.Fingerprint == fp && !seen[key] {
+				seen[key] = true
+				chunks = append(chunks, c)
+			}
+		}
+		sort.Slice(chunks, func(i, j int) bool { return chunks[i].ExternalKey() < chunks[j].ExternalKey() })
+
+		for len(chunks) > 0 {
+			batch := chunks
+			if len(batch) > m.cfg.BatchSize {
+				batch = batch[:m.cfg.BatchSize]
+			}
+			chunks = chunks[len(batch):]
+
+			if err := m.limiter.WaitN(ctx, len(batch)); err != nil {
+				return nil, err
+			}
+			keys := make([]string, 0, len(batch))
+			for _, c := range batch {
+				keys = append(keys, c.ExternalKey())
+			}
+			fetched, err := fetchers[i].FetchChunks(ctx, batch, keys)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range fetched {
+				it := c.Data.NewIterator(nil)
+				for it.Scan() {
+					if sample := it.Value(); int64(sample.Timestamp) >= mint && int64(sample.Timestamp) < maxt {
+						samples = append(samples, sample)
+					}
+				}
+				if err := it.Err(); err != nil {
+					return nil, err
+				}
+			}
+			convertedChunks.WithLabelValues(userID).Add(float64(len(batch)))
+		}
+	}
+	return samples, nil
+}
+
+func (m *BlocksMigrator) fetchChunks(ctx context.Context, chunks []chunk.Chunk) ([]chunk.Chunk, error) {
+	byClient := map[chunk.ObjectClient][]chunk.Chunk{}
+	for _, c := range chunks {
+		client := clientFor(m.source, c.From)
+		byClient[client] = append(byClient[client], c)
+	}
+
+	var fetched []chunk.Chunk
+	for client, cs := range byClient {
+		f, err := client.GetChunks(ctx, cs)
+		if err != nil {
+			return nil, err
+		}
+		if len(f) != len(cs) {
+			return nil, fmt.Errorf("fetched %d chunks out of %d", len(f), len(cs))
+		}
+		fetched = append(fetched, f...)
+	}
+	return fetched, nil
+}
+
+// appendSamples appends the samples of a series, sorting them and dropping
+// those of the same timestamp, e.g. in the chunks of the replicated
+// ingesters, then returns the number appended.
+func appendSamples(app tsdb.Appender, lset tsdb_labels.Labels, samples []model.SamplePair) (int, error) {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Timestamp < samples[j].Timestamp
+	})
+	n := 0
+	for i, sample := range samples {
+		if i > 0 && samples[i-1].Timestamp == sample.Timestamp {
+			continue
+		}
+		if _, err := app.Add(lset, int64(sample.Timestamp), float64(sample.Value)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// verifyBlock queries the block in dir for each series, checking it has all
+// its samples.
+func verifyBlock(dir string, bySeries map[model.Fingerprint]*series) error {
+	b, err := tsdb.OpenBlock(util.Logger, dir, nil)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	q, err := tsdb.NewBlockQuerier(b, b.Meta().MinTime, b.Meta().MaxTime)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	for _, s := range bySeries {
+		matchers := make([]tsdb_labels.Matcher, 0, len(s.labels))
+		for _, l := range s.labels {
+			matchers = append(matchers, tsdb_labels.NewEqualMatcher(l.Name, l.Value))
+		}
+		set, err := q.Select(matchers...)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for set.Next() {
+			if !set.At().Labels().Equals(s.labels) {
+				continue
+			}
+			it := set.At().Iterator()
+			for it.Next() {
+				n++
+			}
+			if err := it.Err(); err != nil {
+				return err
+			}
+		}
+		if err := set.Err(); err != nil {
+			return err
+		}
+		if n != s.samples {
+			return fmt.Errorf("found %d samples of series %s out of %d", n, s.labels, s.samples)
+		}
+	}
+	return nil
+}
+
+// saveCheckpoint records the end of the last block range converted of a
+// tenant, replacing the checkpoint file so that it's never left half written.
+func (m *BlocksMigrator) saveCheckpoint(userID string, end int64) error {
+	m.checkpoint[userID] = end
+	if m.cfg.CheckpointFile == "" {
+		return nil
+	}
+
+	buf, err := json.Marshal(m.checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := m.cfg.CheckpointFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.cfg.CheckpointFile)
+}
+
+func toTSDBLabels(lset labels.Labels) tsdb_labels.Labels {
+	result := make(tsdb_labels.Labels, 0, len(lset))
+	for _, l := range lset {
+		result = append(result, tsdb_labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+package migrator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+func TestBlocksMigrator(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	source, sourceStorage := newTestStore(t, "v9")
+
+	hour := model.TimeFromUnix(3600)
+	require.NoError(t, source.Put(ctx, newTestChunks(t, 5, 0, 3*hour)))
+
+	// The chunks not in the index, e.g. deleted, aren't converted.
+	deleted := newTestChunks(t, 6, 0, hour)[5:]
+	require.NoError(t, sourceStorage.PutChunks(ctx, deleted))
+
+	dir, err := ioutil.TempDir("", "blocks-migrator")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bkt, err := cortex_tsdb.NewFilesystemBucket(filepath.Join(dir, "bucket"))
+	require.NoError(t, err)
+
+	cfg := BlocksConfig{
+		Users:          []string{userID},
+		DataDir:        filepath.Join(dir, "data"),
+		CheckpointFile: filepath.Join(dir, "checkpoint.json"),
+		BlockRange:     2 * time.Hour,
+		BatchSize:      2,
+		RateLimit:      1000,
+		Verify:         true,
+	}
+	m, err := NewBlocksMigrator(cfg, []SourcePeriod{{From: 0, Client: sourceStorage}}, source, bkt)
+	require.NoError(t, err)
+	require.NoError(t, m.Run(context.Background()))
+
+	// The chunks spanning several block ranges are in the blocks of each.
+	metas := readBlockMetas(t, bkt)
+	require.Len(t, metas, 2)
+	require.Equal(t, int64(0), metas[0].MinTime)
+	require.Equal(t, int64(2*hour), metas[0].MaxTime)
+	require.Equal(t, uint64(5), metas[0].Stats.NumSeries)
+	require.Equal(t, uint64(5*120), metas[0].Stats.NumSamples)
+	require.Equal(t, int64(2*hour), metas[1].MinTime)
+	require.Equal(t, int64(4*hour), metas[1].MaxTime)
+	require.Equal(t, uint64(5*61), metas[1].Stats.NumSamples)
+
+	buf, err := ioutil.ReadFile(cfg.CheckpointFile)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"userID":`+strconv.FormatInt(int64(4*hour), 10)+`}`, string(buf))
+
+	// Once done, there's nothing left to convert.
+	m, err = NewBlocksMigrator(cfg, []SourcePeriod{{From: 0, Client: sourceStorage}}, source, bkt)
+	require.NoError(t, err)
+	require.NoError(t, m.Run(context.Background()))
+	require.Len(t, readBlockMetas(t, bkt), 2)
+}
+
+func TestBlocksMigratorDeduplicatesSamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocks-migrator")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := BlocksConfig{BlockRange: 2 * time.Hour, BatchSize: 10}
+	m, err := NewBlocksMigrator(cfg, []SourcePeriod{{}}, nil, nil)
+	require.NoError(t, err)
+
+	// The samples of the chunks of the replicated ingesters overlap.
+	chunks := newTestChunks(t, 1, 0, model.TimeFromUnix(600))
+	var samples []model.SamplePair
+	for i := 0; i < 2; i++ {
+		it := chunks[0].Data.NewIterator(nil)
+		for it.Scan() {
+			samples = append(samples, it.Value())
+		}
+	}
+
+	head, err := tsdb.NewHead(nil, nil, nil, 2*3600*1000)
+	require.NoError(t, err)
+	defer head.Close()
+	s := &series{labels: toTSDBLabels(chunks[0].Metric)}
+	app := head.Appender()
+	s.samples, err = appendSamples(app, s.labels, samples)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+	require.Equal(t, 11, s.samples)
+
+	id, err := m.compactor.Write(dir, head, 0, 2*3600*1000, nil)
+	require.NoError(t, err)
+	bySeries := map[model.Fingerprint]*series{chunks[0].Fingerprint: s}
+	require.NoError(t, verifyBlock(filepath.Join(dir, id.String()), bySeries))
+
+	// The verification fails when a sample is missing.
+	s.samples++
+	require.Error(t, verifyBlock(filepath.Join(dir, id.String()), bySeries))
+}
+
+func readBlockMetas(t *testing.T, bkt cortex_tsdb.Bucket) []*cortex_tsdb.Meta {
+	ctx := context.Background()
+	ids, err := cortex_tsdb.ListBlocks(ctx, bkt, userID)
+	require.NoError(t, err)
+	metas := make([]*cortex_tsdb.Meta, 0, len(ids))
+	for _, id := range ids {
+		meta, err := cortex_tsdb.ReadMeta(ctx, bkt, userID, id)
+		require.NoError(t, err)
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+	return metas
+}
@@ -0,0 +1,334 @@
+package migrator
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/weaveworks/common/user"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/storage"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+var (
+	migratedChunks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "chunk_migrator_migrated_chunks_total",
+		Help:      "Number of chunks copied to the destination store.",
+	}, []string{"user"})
+	verifiedChunks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "chunk_migrator_verified_chunks_total",
+		Help:      "Number of migrated chunks found querying the destination store.",
+	}, []string{"user"})
+)
+
+// Config is the config of the chunk migrator.
+type Config struct {
+	Users                 flagext.StringSlice `yaml:"users"`
+	DestinationConfigFile string              `yaml:"destination_config_file"`
+	CheckpointFile        string              `yaml:"checkpoint_file"`
+	BatchSize             int                 `yaml:"batch_size"`
+	RateLimit             float64             `yaml:"rate_limit"`
+	Verify                bool                `yaml:"verify"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&cfg.Users, "chunk-migrator.user", "Tenant whose chunks are migrated; repeat the flag for each tenant.")
+	f.StringVar(&cfg.DestinationConfigFile, "chunk-migrator.destination-config-file", "", "YAML file of the config of the store the chunks are migrated to, with its storage and schema sections.")
+	f.StringVar(&cfg.CheckpointFile, "chunk-migrator.checkpoint-file", "", "File recording the chunks migrated, so that the migration resumes where it stopped; empty to start over each time.")
+	f.IntVar(&cfg.BatchSize, "chunk-migrator.batch-size", 100, "Number of chunks fetched, written and checkpointed at once.")
+	f.Float64Var(&cfg.RateLimit, "chunk-migrator.rate-limit", 0, "Maximum number of chunks migrated per second; 0 for no limit.")
+	f.BoolVar(&cfg.Verify, "chunk-migrator.verify", true, "Query the destination store for the series of the migrated chunks, failing the migration if any chunk can't be found.")
+}
+
+// DestinationConfig is the config of the store the chunks are migrated to,
+// like the storage and schema sections of the Cortex config.
+type DestinationConfig struct {
+	Storage storage.Config     `yaml:"storage"`
+	Schema  chunk.SchemaConfig `yaml:"schema"`
+}
+
+// LoadDestinationConfig loads the config of the destination store from a
+// YAML file, the storage config defaulting to the defaults of the flags.
+func LoadDestinationConfig(filename string) (DestinationConfig, error) {
+	var cfg DestinationConfig
+	flagext.DefaultValues(&cfg.Storage)
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.UnmarshalStrict(buf, &cfg); err != nil {
+		return cfg, err
+	}
+	if len(cfg.Schema.Configs) == 0 {
+		return cfg, fmt.Errorf("no schema config of the destination store in %s", filename)
+	}
+	return cfg, cfg.Schema.Validate()
+}
+
+// SourcePeriod is a period of the store the chunks are migrated from: the
+// chunks starting in it are fetched from its object client.
+type SourcePeriod struct {
+	From   model.Time
+	Client chunk.ObjectClient
+}
+
+// Migrator copies the chunks of tenants from the object clients of a store to
+// another store, which writes their index entries as per its own schema.
+// The chunks are migrated in batches, in the order of their keys, and the key
+// of the last chunk migrated of each tenant is checkpointed after each batch.
+type Migrator struct {
+	cfg     Config
+	source  []SourcePeriod
+	dest    chunk.Store
+	limiter *rate.Limiter
+
+	// The key of the last chunk migrated, by tenant.
+	checkpoint map[string]string
+}
+
+// New makes a new Migrator from the source periods, in order, to the dest
+// store, resuming from the checkpoint file if any.
+func New(cfg Config, source []SourcePeriod, dest chunk.Store) (*Migrator, error) {
+	if len(source) == 0 {
+		return nil, fmt.Errorf("no source store to migrate the chunks from")
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("the batch size must be positive")
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, cfg.BatchSize)
+	if cfg.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), cfg.BatchSize)
+	}
+
+	m := &Migrator{
+		cfg:        cfg,
+		source:     source,
+		dest:       dest,
+		limiter:    limiter,
+		checkpoint: map[string]string{},
+	}
+	if cfg.CheckpointFile != "" {
+		buf, err := ioutil.ReadFile(cfg.CheckpointFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(buf, &m.checkpoint); err != nil {
+				return nil, fmt.Errorf("invalid checkpoint file %s: %v", cfg.CheckpointFile, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Run migrates the chunks of the tenants.
+func (m *Migrator) Run(ctx context.Context) error {
+	for _, userID := range m.cfg.Users {
+		n, err := m.migrateUser(user.InjectOrgID(ctx, userID), userID)
+		if err != nil {
+			return fmt.Errorf("error migrating the chunks of %s after %d chunks: %v", userID, n, err)
+		}
+		level.Info(util.Logger).Log("msg", "migrated the chunks of a tenant", "user", userID, "chunks", n)
+	}
+	return nil
+}
+
+func (m *Migrator) migrateUser(ctx context.Context, userID string) (int, error) {
+	keys, err := listChunks(ctx, m.source, userID)
+	if err != nil {
+		return 0, err
+	}
+	last, resumed := m.checkpoint[userID]
+	if resumed {
+		i := sort.SearchStrings(keys, last)
+		if i < len(keys) && keys[i] == last {
+			i++
+		}
+		keys = keys[i:]
+		level.Info(util.Logger).Log("msg", "resuming the migration of a tenant", "user", userID, "after", last, "chunks", len(keys))
+	}
+
+	migrated := 0
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > m.cfg.BatchSize {
+			batch = batch[:m.cfg.BatchSize]
+		}
+		keys = keys[len(batch):]
+
+		if err := m.limiter.WaitN(ctx, len(batch)); err != nil {
+			return migrated, err
+		}
+		if err := m.migrateBatch(ctx, userID, batch); err != nil {
+			return migrated, err
+		}
+		migrated += len(batch)
+		migratedChunks.WithLabelValues(userID).Add(float64(len(batch)))
+
+		if err := m.saveCheckpoint(userID, batch[len(batch)-1]); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// listChunks returns the keys of the chunks of a tenant in all the object
+// clients of the source periods, sorted.
+func listChunks(ctx context.Context, source []SourcePeriod, userID string) ([]string, error) {
+	var (
+		keys   []string
+		seen   = map[string]bool{}
+		listed = map[chunk.ObjectClient]bool{}
+	)
+	for _, period := range source {
+		if listed[period.Client] {
+			continue
+		}
+		listed[period.Client] = true
+
+		lister, ok := period.Client.(chunk.ObjectLister)
+		if !ok {
+			return nil, fmt.Errorf("listing the chunks is not supported by %T", period.Client)
+		}
+		ks, err := lister.ListChunks(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		// The chunks spanning several periods are in the stores of each.
+		for _, key := range ks {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Migrator) migrateBatch(ctx context.Context, userID string, keys []string) error {
+	byClient := map[chunk.ObjectClient][]chunk.Chunk{}
+	for _, key := range keys {
+		c, err := chunk.ParseExternalKey(userID, key)
+		if err != nil {
+			return err
+		}
+		client := clientFor(m.source, c.From)
+		byClient[client] = append(byClient[client], c)
+	}
+
+	var chunks []chunk.Chunk
+	for client, cs := range byClient {
+		fetched, err := client.GetChunks(ctx, cs)
+		if err != nil {
+			return err
+		}
+		if len(fetched) != len(cs) {
+			return fmt.Errorf("fetched %d chunks out of %d", len(fetched), len(cs))
+		}
+		chunks = append(chunks, fetched...)
+	}
+
+	if err := m.dest.Put(ctx, chunks); err != nil {
+		return err
+	}
+	if m.cfg.Verify {
+		return m.verify(ctx, userID, chunks)
+	}
+	return nil
+}
+
+// clientFor returns the object client of the source period a chunk starts in.
+func clientFor(source []SourcePeriod, from model.Time) chunk.ObjectClient {
+	client := source[0].Client
+	for _, period := range source {
+		if period.From > from {
+			break
+		}
+		client = period.Client
+	}
+	return client
+}
+
+// verify queries the destination store for the series of the chunks, and
+// checks each chunk is returned.
+func (m *Migrator) verify(ctx context.Context, userID string, chunks []chunk.Chunk) error {
+	bySeries := map[model.Fingerprint][]chunk.Chunk{}
+	for _, c := range chunks {
+		bySeries[c.Fingerprint] = append(bySeries[c.Fingerprint], c)
+	}
+
+	for _, cs := range bySeries {
+		from, through := cs[0].From, cs[0].Through
+		matchers := make([]*labels.Matcher, 0, len(cs[0].Metric))
+		for _, l := range cs[0].Metric {
+			matcher, err := labels.NewMatcher(labels.MatchEqual, l.Name, l.Value)
+			if err != nil {
+				return err
+			}
+			matchers = append(matchers, matcher)
+		}
+		for _, c := range cs[1:] {
+			if c.From < from {
+				from = c.From
+			}
+			if c.Through > through {
+				through = c.Through
+			}
+		}
+
+		found, err := m.dest.Get(ctx, userID, from, through, matchers...)
+		if err != nil {
+			return err
+		}
+		keys := make(map[string]bool, len(found))
+		for _, c := range found {
+			keys[c.ExternalKey()] = true
+		}
+		for _, c := range cs {
+			if !keys[c.ExternalKey()] {
+				return fmt.Errorf("chunk %s not found in the destination store", c.ExternalKey())
+			}
+		}
+		verifiedChunks.WithLabelValues(userID).Add(float64(len(cs)))
+	}
+	return nil
+}
+
+// saveCheckpoint records the last chunk migrated of a tenant, replacing the
+// checkpoint file so that it's never left half written.
+func (m *Migrator) saveCheckpoint(userID, key string) error {
+	m.checkpoint[userID] = key
+	if m.cfg.CheckpointFile == "" {
+		return nil
+	}
+
+	buf, err := json.Marshal(m.checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := m.cfg.CheckpointFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.cfg.CheckpointFile)
+}
@@ -0,0 +1,205 @@
+package migrator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+const userID = "userID"
+
+// newTestStore makes a store of the schema backed by a MockStorage.
+func newTestStore(t *testing.T, schema string) (chunk.Store, *chunk.MockStorage) {
+	var (
+		storeCfg  chunk.StoreConfig
+		tbmConfig chunk.TableManagerConfig
+		limits    validation.Limits
+		schemaCfg = chunk.DefaultSchemaConfig("", schema, 0)
+	)
+	flagext.DefaultValues(&storeCfg, &tbmConfig, &limits)
+	storage := chunk.NewMockStorage()
+
+	tableManager, err := chunk.NewTableManager(tbmConfig, schemaCfg, 12*time.Hour, storage, nil, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, tableManager.SyncTables(context.Background()))
+
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+
+	store := chunk.NewCompositeStore()
+	require.NoError(t, store.AddPeriod(storeCfg, schemaCfg.Configs[0], storage, storage, overrides))
+	return store, storage
+}
+
+// newTestChunks makes a chunk per series of a sample per minute between from
+// and through.
+func newTestChunks(t *testing.T, series int, from, through model.Time) []chunk.Chunk {
+	var chunks []chunk.Chunk
+	for i := 0; i < series; i++ {
+		metric := labels.Labels{
+			{Name: labels.MetricName, Value: "foo"},
+			{Name: "i", Value: strconv.Itoa(i)},
+		}
+		pc := encoding.New()
+		for ts := from; ts <= through; ts = ts.Add(time.Minute) {
+			pcs, err := pc.Add(model.SamplePair{Timestamp: ts, Value: model.SampleValue(ts)})
+			require.NoError(t, err)
+			require.Len(t, pcs, 1)
+			pc = pcs[0]
+		}
+
+		c := chunk.NewChunk(userID, model.Fingerprint(metric.Hash()), metric, pc, from, through)
+		require.NoError(t, c.Encode())
+		chunks = append(chunks, c)
+	}
+	return chunks
+}
+
+func chunkKeys(chunks []chunk.Chunk) []string {
+	keys := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		keys = append(keys, c.ExternalKey())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func queryKeys(t *testing.T, store chunk.Store, through model.Time) []string {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	matcher, err := labels.NewMatcher(labels.MatchEqual, labels.MetricName, "foo")
+	require.NoError(t, err)
+	chunks, err := store.Get(ctx, userID, 0, through, matcher)
+	require.NoError(t, err)
+	return chunkKeys(chunks)
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	source, sourceStorage := newTestStore(t, "v9")
+	dest, _ := newTestStore(t, "v10")
+
+	hour := model.TimeFromUnix(3600)
+	chunks := newTestChunks(t, 5, 0, hour)
+	require.NoError(t, source.Put(ctx, chunks))
+
+	dir, err := ioutil.TempDir("", "migrator")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := Config{
+		Users:          []string{userID},
+		CheckpointFile: filepath.Join(dir, "checkpoint.json"),
+		BatchSize:      2,
+		RateLimit:      1000,
+		Verify:         true,
+	}
+	m, err := New(cfg, []SourcePeriod{{From: 0, Client: sourceStorage}}, dest)
+	require.NoError(t, err)
+	require.NoError(t, m.Run(context.Background()))
+
+	// The chunks are indexed as per the schema of the destination.
+	require.Equal(t, chunkKeys(chunks), queryKeys(t, dest, hour))
+
+	// Once done, there's nothing left to migrate.
+	m, err = New(cfg, []SourcePeriod{{From: 0, Client: sourceStorage}}, lossyStore{dest})
+	require.NoError(t, err)
+	require.NoError(t, m.Run(context.Background()))
+}
+
+func TestMigratorCheckpoint(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	source, sourceStorage := newTestStore(t, "v9")
+	dest, _ := newTestStore(t, "v9")
+
+	hour := model.TimeFromUnix(3600)
+	chunks := newTestChunks(t, 4, 0, hour)
+	require.NoError(t, source.Put(ctx, chunks))
+	keys := chunkKeys(chunks)
+
+	dir, err := ioutil.TempDir("", "migrator")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+	require.NoError(t, ioutil.WriteFile(checkpointFile, []byte(`{"userID":"`+keys[1]+`"}`), 0644))
+
+	m, err := New(Config{Users: []string{userID}, CheckpointFile: checkpointFile, BatchSize: 10}, []SourcePeriod{{From: 0, Client: sourceStorage}}, dest)
+	require.NoError(t, err)
+	require.NoError(t, m.Run(context.Background()))
+	require.Equal(t, keys[2:], queryKeys(t, dest, hour))
+
+	buf, err := ioutil.ReadFile(checkpointFile)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"userID":"`+keys[3]+`"}`, string(buf))
+}
+
+// lossyStore drops the chunks written.
+type lossyStore struct {
+	chunk.Store
+}
+
+func (lossyStore) Put(context.Context, []chunk.Chunk) error {
+	return nil
+}
+
+func TestMigratorVerify(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	source, sourceStorage := newTestStore(t, "v9")
+	dest, _ := newTestStore(t, "v9")
+
+	hour := model.TimeFromUnix(3600)
+	require.NoError(t, source.Put(ctx, newTestChunks(t, 1, 0, hour)))
+
+	m, err := New(Config{Users: []string{userID}, BatchSize: 10, Verify: true}, []SourcePeriod{{From: 0, Client: sourceStorage}}, lossyStore{dest})
+	require.NoError(t, err)
+	require.Error(t, m.Run(context.Background()))
+}
+
+func TestLoadDestinationConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrator")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "destination.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`
+storage:
+  boltdb:
+    directory: /data/index
+  filesystem:
+    directory: /data/chunks
+schema:
+  configs:
+  - from: 2019-01-01
+    store: boltdb
+    object_store: filesystem
+    schema: v10
+    index:
+      prefix: index_
+      period: 168h
+`), 0644))
+
+	cfg, err := LoadDestinationConfig(filename)
+	require.NoError(t, err)
+	require.Equal(t, "/data/chunks", cfg.Storage.FSConfig.Directory)
+	require.Equal(t, "boltdb", cfg.Schema.Configs[0].IndexType)
+	// The storage config defaults to the defaults of the flags.
+	require.Equal(t, "cortex", cfg.Storage.SwiftStorageConfig.ContainerName)
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("storage: {}\n"), 0644))
+	_, err = LoadDestinationConfig(filename)
+	require.Error(t, err)
+}
@@ -0,0 +1,306 @@
+package openstack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/objects"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/common/instrument"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	chunk_util "github.com/cortexproject/cortex/pkg/chunk/util"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+var swiftRequestDuration = instrument.NewHistogramCollector(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "cortex",
+	Name:      "swift_request_duration_seconds",
+	Help:      "Time spent doing Swift requests.",
+	Buckets:   []float64{.025, .05, .1, .25, .5, 1, 2},
+}, []string{"operation", "status_code"}))
+
+func init() {
+	swiftRequestDuration.Register()
+}
+
+// SwiftConfig is config for the OpenStack Swift Chunk Client.
+type SwiftConfig struct {
+	AuthURL           string `yaml:"auth_url"`
+	Username          string `yaml:"username"`
+	UserID            string `yaml:"user_id"`
+	Password          string `yaml:"password"`
+	DomainID          string `yaml:"domain_id"`
+	DomainName        string `yaml:"domain_name"`
+	ProjectID         string `yaml:"project_id"`
+	ProjectName       string `yaml:"project_name"`
+	ProjectDomainID   string `yaml:"project_domain_id"`
+	ProjectDomainName string `yaml:"project_domain_name"`
+	RegionName        string `yaml:"region_name"`
+	ContainerName     string `yaml:"container_name"`
+
+	SegmentSize    int                `yaml:"segment_size"`
+	RequestTimeout time.Duration      `yaml:"request_timeout"`
+	Backoff        util.BackoffConfig `yaml:"backoff_config"`
+
+	ChunkFetch chunk_util.FetchConfig `yaml:"chunk_fetch"`
+}
+
+// RegisterFlags registers flags.
+func (cfg *SwiftConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.AuthURL, "swift.auth-url", "", "URL of the OpenStack Keystone identity service, e.g. https://keystone:5000/v3.")
+	f.StringVar(&cfg.Username, "swift.username", "", "Name of the OpenStack user to authenticate as.")
+	f.StringVar(&cfg.UserID, "swift.user-id", "", "ID of the OpenStack user to authenticate as, instead of its name.")
+	f.StringVar(&cfg.Password, "swift.password", "", "Password of the OpenStack user.")
+	f.StringVar(&cfg.DomainID, "swift.domain-id", "", "ID of the Keystone v3 domain of the user.")
+	f.StringVar(&cfg.DomainName, "swift.domain-name", "", "Name of the Keystone v3 domain of the user.")
+	f.StringVar(&cfg.ProjectID, "swift.project-id", "", "ID of the OpenStack project (tenant) of the container.")
+	f.StringVar(&cfg.ProjectName, "swift.project-name", "", "Name of the OpenStack project (tenant) of the container.")
+	f.StringVar(&cfg.ProjectDomainID, "swift.project-domain-id", "", "ID of the Keystone v3 domain of the project; the domain of the user by default.")
+	f.StringVar(&cfg.ProjectDomainName, "swift.project-domain-name", "", "Name of the Keystone v3 domain of the project; the domain of the user by default.")
+	f.StringVar(&cfg.RegionName, "swift.region-name", "", "OpenStack region of the Swift endpoint.")
+	f.StringVar(&cfg.ContainerName, "swift.container-name", "cortex", "Name of the Swift container to put chunks in.")
+	f.IntVar(&cfg.SegmentSize, "swift.segment-size", 64*1024*1024, "Size of the segments the chunks larger than it are uploaded in, as dynamic large objects.")
+	f.DurationVar(&cfg.RequestTimeout, "swift.request-timeout", 30*time.Second, "Timeout of the Swift requests.")
+	cfg.Backoff.RegisterFlags("swift", f)
+	cfg.ChunkFetch.RegisterFlagsWithPrefix("swift", f)
+}
+
+// Validate the config.
+func (cfg *SwiftConfig) Validate() error {
+	if cfg.AuthURL == "" {
+		return errors.New("no Swift auth URL specified")
+	}
+	if cfg.SegmentSize <= 0 {
+		return fmt.Errorf("invalid Swift segment size %d: must be positive", cfg.SegmentSize)
+	}
+	return nil
+}
+
+func (cfg *SwiftConfig) authOptions() gophercloud.AuthOptions {
+	opts := gophercloud.AuthOptions{
+		IdentityEndpoint: cfg.AuthURL,
+		Username:         cfg.Username,
+		UserID:           cfg.UserID,
+		Password:         cfg.Password,
+		DomainID:         cfg.DomainID,
+		DomainName:       cfg.DomainName,
+		TenantID:         cfg.ProjectID,
+		TenantName:       cfg.ProjectName,
+		// The token is renewed when it expires.
+		AllowReauth: true,
+	}
+	if cfg.ProjectDomainID != "" || cfg.ProjectDomainName != "" {
+		opts.Scope = &gophercloud.AuthScope{
+			ProjectID:   cfg.ProjectID,
+			ProjectName: cfg.ProjectName,
+			DomainID:    cfg.ProjectDomainID,
+			DomainName:  cfg.ProjectDomainName,
+		}
+	}
+	return opts
+}
+
+type swiftObjectClient struct {
+	cfg     SwiftConfig
+	client  *gophercloud.ServiceClient
+	fetcher *chunk_util.ParallelFetcher
+}
+
+// NewSwiftObjectClient makes a new chunk.ObjectClient that writes chunks to
+// a container of OpenStack Swift, authenticating with Keystone.
+func NewSwiftObjectClient(cfg SwiftConfig, schemaCfg chunk.SchemaConfig) (chunk.ObjectClient, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	provider, err := openstack.NewClient(cfg.AuthURL)
+	if err != nil {
+		return nil, err
+	}
+	provider.HTTPClient = http.Client{Timeout: cfg.RequestTimeout}
+	if err := openstack.Authenticate(provider, cfg.authOptions()); err != nil {
+		return nil, err
+	}
+
+	client, err := openstack.NewObjectStorageV1(provider, gophercloud.EndpointOpts{Region: cfg.RegionName})
+	if err != nil {
+		return nil, err
+	}
+	return newSwiftObjectClient(cfg, client), nil
+}
+
+func newSwiftObjectClient(cfg SwiftConfig, client *gophercloud.ServiceClient) *swiftObjectClient {
+	return &swiftObjectClient{
+		cfg:     cfg,
+		client:  client,
+		fetcher: chunk_util.NewParallelFetcher("swift", cfg.ChunkFetch),
+	}
+}
+
+func (s *swiftObjectClient) Stop() {
+}
+
+func (s *swiftObjectClient) PutChunks(ctx context.Context, chunks []chunk.Chunk) error {
+	for _, c := range chunks {
+		buf, err := c.Encoded()
+		if err != nil {
+			return err
+		}
+		if err := s.putObject(ctx, c.ExternalKey(), buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putObject uploads an object, in segments if it's larger than the segment
+// size: the segments are uploaded first, then the manifest of the dynamic
+// large object of all of them.
+func (s *swiftObjectClient) putObject(ctx context.Context, key string, buf []byte) error {
+	if len(buf) <= s.cfg.SegmentSize {
+		return s.create(ctx, "Swift.PutObject", key, buf, "")
+	}
+
+	prefix := segmentsPrefix(key)
+	for i := 0; i*s.cfg.SegmentSize < len(buf); i++ {
+		end := (i + 1) * s.cfg.SegmentSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		segment := fmt.Sprintf("%s%08d", prefix, i)
+		if err := s.create(ctx, "Swift.PutSegment", segment, buf[i*s.cfg.SegmentSize:end], ""); err != nil {
+			return err
+		}
+	}
+	return s.create(ctx, "Swift.PutManifest", key, nil, s.cfg.ContainerName+"/"+prefix)
+}
+
+// segmentsPrefix is the prefix of the segments of the object key.
+func segmentsPrefix(key string) string {
+	return "segments/" + key + "/"
+}
+
+func (s *swiftObjectClient) create(ctx context.Context, operation, key string, buf []byte, manifest string) error {
+	return s.retry(ctx, operation, func() error {
+		opts := objects.CreateOpts{
+			Content:        bytes.NewReader(buf),
+			ContentLength:  int64(len(buf)),
+			ObjectManifest: manifest,
+		}
+		return objects.Create(s.client, s.cfg.ContainerName, key, opts).Err
+	})
+}
+
+func (s *swiftObjectClient) GetChunks(ctx context.Context, input []chunk.Chunk) ([]chunk.Chunk, error) {
+	return s.fetcher.GetChunks(ctx, input, s.getChunk)
+}
+
+func (s *swiftObjectClient) getChunk(ctx context.Context, decodeContext *chunk.DecodeContext, input chunk.Chunk) (chunk.Chunk, error) {
+	buf, err := s.getObject(ctx, input.ExternalKey())
+	if err != nil {
+		return chunk.Chunk{}, err
+	}
+
+	if err := input.Decode(decodeContext, buf); err != nil {
+		return chunk.Chunk{}, err
+	}
+	return input, nil
+}
+
+// getObject downloads an object; Swift joins the segments of the dynamic
+// large objects.
+func (s *swiftObjectClient) getObject(ctx context.Context, key string) ([]byte, error) {
+	var buf []byte
+	err := s.retry(ctx, "Swift.GetObject", func() error {
+		var err error
+		result := objects.Download(s.client, s.cfg.ContainerName, key, nil)
+		buf, err = result.ExtractContent()
+		return err
+	})
+	return buf, err
+}
+
+// ListChunks implements chunk.ObjectLister. The segments of the dynamic
+// large objects are under their own prefix, so they aren't listed.
+func (s *swiftObjectClient) ListChunks(ctx context.Context, userID string) ([]string, error) {
+	var keys []string
+	err := s.retry(ctx, "Swift.ListObjects", func() error {
+		pages, err := objects.List(s.client, s.cfg.ContainerName, objects.ListOpts{Prefix: userID + "/"}).AllPages()
+		if err != nil {
+			return err
+		}
+		keys, err = objects.ExtractNames(pages)
+		return err
+	})
+	return keys, err
+}
+
+// DeleteChunk implements chunk.ObjectDeleter. The segments of a dynamic
+// large object are deleted before its manifest, so that a failed deletion
+// can be tried again.
+func (s *swiftObjectClient) DeleteChunk(ctx context.Context, c chunk.Chunk) error {
+	chunkID := c.ExternalKey()
+	var segments []string
+	err := s.retry(ctx, "Swift.ListSegments", func() error {
+		segments = nil
+		pages, err := objects.List(s.client, s.cfg.ContainerName, objects.ListOpts{Prefix: segmentsPrefix(chunkID)}).AllPages()
+		if err != nil {
+			return err
+		}
+		segments, err = objects.ExtractNames(pages)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range append(segments, chunkID) {
+		key := key
+		err := s.retry(ctx, "Swift.DeleteObject", func() error {
+			return objects.Delete(s.client, s.cfg.ContainerName, key, nil).Err
+		})
+		if _, ok := err.(gophercloud.ErrDefault404); !ok && err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retry does a request until it succeeds, fails with an error that isn't
+// retryable, or runs out of retries, backing off between them.
+func (s *swiftObjectClient) retry(ctx context.Context, operation string, f func() error) error {
+	backoff := util.NewBackoff(ctx, s.cfg.Backoff)
+	var err error
+	for backoff.Ongoing() {
+		err = instrument.CollectedRequest(ctx, operation, swiftRequestDuration, instrument.ErrorCode, func(context.Context) error {
+			return f()
+		})
+		if err == nil || !retryable(err) {
+			return err
+		}
+		backoff.Wait()
+	}
+	if err == nil {
+		err = backoff.Err()
+	}
+	return err
+}
+
+// retryable returns whether a request failing with err may succeed if tried
+// again: the client errors won't, except for the throttled requests.
+func retryable(err error) bool {
+	switch err.(type) {
+	case gophercloud.ErrDefault400, gophercloud.ErrDefault401, gophercloud.ErrDefault403,
+		gophercloud.ErrDefault404, gophercloud.ErrDefault405, gophercloud.ErrDefault409:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package openstack
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// fakeSwift stores the objects of a container, failing the first request to
+// each of them to exercise the retries.
+type fakeSwift struct {
+	mtx       sync.Mutex
+	objects   map[string][]byte
+	manifests map[string]string
+	failed    map[string]bool
+}
+
+func (f *fakeSwift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/cortex/")
+	if !f.failed[r.Method+key] {
+		f.failed[r.Method+key] = true
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		buf, _ := ioutil.ReadAll(r.Body)
+		if manifest := r.Header.Get("X-Object-Manifest"); manifest != "" {
+			f.manifests[key] = strings.TrimPrefix(manifest, "cortex/")
+		} else {
+			f.objects[key] = buf
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		if prefix, ok := f.manifests[key]; ok {
+			var segments []string
+			for k := range f.objects {
+				if strings.HasPrefix(k, prefix) {
+					segments = append(segments, k)
+				}
+			}
+			sort.Strings(segments)
+			for _, s := range segments {
+				_, _ = w.Write(f.objects[s])
+			}
+			return
+		}
+		buf, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(buf)
+	}
+}
+
+func TestSwiftObjectClient(t *testing.T) {
+	fake := &fakeSwift{objects: map[string][]byte{}, manifests: map[string]string{}, failed: map[string]bool{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := newSwiftObjectClient(SwiftConfig{
+		ContainerName: "cortex",
+		SegmentSize:   4,
+		Backoff:       util.BackoffConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetries: 2},
+	}, &gophercloud.ServiceClient{ProviderClient: &gophercloud.ProviderClient{}, Endpoint: server.URL + "/"})
+	ctx := context.Background()
+
+	// Small objects are uploaded at once.
+	require.NoError(t, client.putObject(ctx, "user/small", []byte("abc")))
+	require.Equal(t, []byte("abc"), fake.objects["user/small"])
+
+	// Larger ones in segments, with a manifest.
+	require.NoError(t, client.putObject(ctx, "user/large", []byte("0123456789")))
+	require.Equal(t, map[string]string{"user/large": "segments/user/large/"}, fake.manifests)
+	require.Equal(t, []byte("0123"), fake.objects["segments/user/large/00000000"])
+	require.Equal(t, []byte("4567"), fake.objects["segments/user/large/00000001"])
+	require.Equal(t, []byte("89"), fake.objects["segments/user/large/00000002"])
+
+	buf, err := client.getObject(ctx, "user/large")
+	require.NoError(t, err)
+	require.Equal(t, []byte("0123456789"), buf)
+
+	// The client errors aren't retried.
+	_, err = client.getObject(ctx, "user/missing")
+	require.Error(t, err)
+	require.True(t, fake.failed["GETuser/missing"])
+}
+
+func TestSwiftConfigValidate(t *testing.T) {
+	require.NoError(t, (&SwiftConfig{AuthURL: "http://keystone:5000/v3", SegmentSize: 1}).Validate())
+	require.Error(t, (&SwiftConfig{SegmentSize: 1}).Validate())
+	require.Error(t, (&SwiftConfig{AuthURL: "http://keystone:5000/v3"}).Validate())
+}
@@ -0,0 +1,82 @@
+package purger
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DeleteRequestsCache caches the delete requests of the tenants and their
+// cache generation numbers for a while, so that the queries don't read them
+// from the index store each time. The changes of the requests are seen once
+// the cached ones expire.
+type DeleteRequestsCache struct {
+	store *DeleteStore
+	ttl   time.Duration
+
+	mtx     sync.Mutex
+	tenants map[string]*cachedDeleteRequests
+}
+
+type cachedDeleteRequests struct {
+	reqs     []DeleteRequest
+	gen      string
+	loadedAt time.Time
+}
+
+// NewDeleteRequestsCache makes a new DeleteRequestsCache of the requests in
+// the store, caching them for ttl.
+func NewDeleteRequestsCache(store *DeleteStore, ttl time.Duration) *DeleteRequestsCache {
+	return &DeleteRequestsCache{
+		store:   store,
+		ttl:     ttl,
+		tenants: map[string]*cachedDeleteRequests{},
+	}
+}
+
+// GetAllDeleteRequestsForUser returns all the requests of a tenant. They're
+// shared by the callers, their matchers parsed, so they mustn't be modified.
+func (c *DeleteRequestsCache) GetAllDeleteRequestsForUser(ctx context.Context, userID string) ([]DeleteRequest, error) {
+	cached, err := c.get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return cached.reqs, nil
+}
+
+// GetResultsCacheGenNumber returns the cache generation number of a tenant,
+// to key the cached results of its queries with.
+func (c *DeleteRequestsCache) GetResultsCacheGenNumber(ctx context.Context, userID string) (string, error) {
+	cached, err := c.get(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return cached.gen, nil
+}
+
+func (c *DeleteRequestsCache) get(ctx context.Context, userID string) (*cachedDeleteRequests, error) {
+	now := time.Now()
+	c.mtx.Lock()
+	cached, ok := c.tenants[userID]
+	c.mtx.Unlock()
+	if ok && now.Sub(cached.loadedAt) < c.ttl {
+		return cached, nil
+	}
+
+	reqs, gen, err := c.store.getDeleteRequestsAndCacheGenNumber(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// The matchers are parsed once, rather than by each query sharing them.
+	for i := range reqs {
+		if _, err := reqs[i].Matchers(); err != nil {
+			return nil, err
+		}
+	}
+	cached = &cachedDeleteRequests{reqs: reqs, gen: gen, loadedAt: now}
+
+	c.mtx.Lock()
+	c.tenants[userID] = cached
+	c.mtx.Unlock()
+	return cached, nil
+}
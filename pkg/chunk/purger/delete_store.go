@@ -0,0 +1,310 @@
+package purger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+// DeleteRequestStatus is the status of a delete request.
+type DeleteRequestStatus string
+
+// The statuses of the delete requests. The cancelled requests are removed.
+const (
+	StatusReceived  DeleteRequestStatus = "received"
+	StatusProcessed DeleteRequestStatus = "processed"
+)
+
+const (
+	// All the delete requests are under a single hash value, with range values
+	// prefixed with their tenant.
+	deleteRequestsHashValue = "deleteRequests"
+
+	requestRangeKey   = "r"
+	processedRangeKey = "p"
+
+	// The cache generation numbers of a tenant are recorded in place of the
+	// request ID of the entries with this key.
+	cacheGenRangeKey = "n"
+)
+
+// DeleteRequest is a request of a tenant to delete the series matching any
+// of its selectors between its start and end times.
+type DeleteRequest struct {
+	RequestID string              `json:"request_id"`
+	UserID    string              `json:"-"`
+	StartTime model.Time          `json:"start_time"`
+	EndTime   model.Time          `json:"end_time"`
+	Selectors []string            `json:"selectors"`
+	Status    DeleteRequestStatus `json:"status"`
+	CreatedAt model.Time          `json:"created_at"`
+
+	matchers [][]*labels.Matcher
+}
+
+// Matchers returns the matchers of the selectors of the request.
+func (r *DeleteRequest) Matchers() ([][]*labels.Matcher, error) {
+	if r.matchers != nil {
+		return r.matchers, nil
+	}
+
+	matchers := make([][]*labels.Matcher, 0, len(r.Selectors))
+	for _, selector := range r.Selectors {
+		m, err := promql.ParseMetricSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	r.matchers = matchers
+	return matchers, nil
+}
+
+// DeleteStoreConfig is the config of the store of the delete requests.
+type DeleteStoreConfig struct {
+	Store             string        `yaml:"store"`
+	RequestsTableName string        `yaml:"requests_table_name"`
+	CacheTTL          time.Duration `yaml:"cache_ttl"`
+}
+
+// RegisterFlags registers flags.
+func (cfg *DeleteStoreConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Store, "deletes.store", "", "Index store of the delete requests, e.g. aws-dynamo, bigtable, cassandra or boltdb; the delete series API is disabled if empty.")
+	f.StringVar(&cfg.RequestsTableName, "deletes.requests-table-name", "delete_requests", "Name of the table of the delete requests.")
+	f.DurationVar(&cfg.CacheTTL, "deletes.cache-ttl", 10*time.Second, "How long the delete requests of the tenants are cached by the queriers, and their cache generation numbers by the query-frontends.")
+}
+
+// DeleteStore stores the delete requests in a table of an index store. The
+// entries of a request are never overwritten: its processing is recorded by
+// an entry of its own, so that it works with all the index stores.
+type DeleteStore struct {
+	cfg         DeleteStoreConfig
+	indexClient chunk.IndexClient
+}
+
+// NewDeleteStore makes a new DeleteStore. The index client has to support
+// deleting entries, to cancel the requests.
+func NewDeleteStore(cfg DeleteStoreConfig, indexClient chunk.IndexClient) (*DeleteStore, error) {
+	if _, ok := indexClient.(chunk.IndexDeleter); !ok {
+		return nil, fmt.Errorf("the index client %T doesn't support deleting entries", indexClient)
+	}
+	return &DeleteStore{
+		cfg:         cfg,
+		indexClient: indexClient,
+	}, nil
+}
+
+// AddDeleteRequest adds a delete request of a tenant, returning it.
+func (ds *DeleteStore) AddDeleteRequest(ctx context.Context, userID string, startTime, endTime model.Time, selectors []string) (DeleteRequest, error) {
+	req := DeleteRequest{
+		UserID:    userID,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Selectors: selectors,
+		Status:    StatusReceived,
+		CreatedAt: model.Now(),
+	}
+	req.RequestID = requestID(req)
+
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return DeleteRequest{}, err
+	}
+
+	batch := ds.indexClient.NewWriteBatch()
+	batch.Add(ds.cfg.RequestsTableName, deleteRequestsHashValue, rangeValue(userID, req.RequestID, requestRangeKey), buf)
+	ds.addCacheGenNumber(batch, userID)
+	if err := ds.indexClient.BatchWrite(ctx, batch); err != nil {
+		return DeleteRequest{}, err
+	}
+	return req, nil
+}
+
+// addCacheGenNumber adds a new cache generation number of a tenant to the
+// batch, so that the results cached before its delete requests changed
+// aren't used anymore. The numbers are the time they're added, in
+// nanoseconds, the latest being the largest; they're never overwritten, like
+// the other entries.
+func (ds *DeleteStore) addCacheGenNumber(batch chunk.WriteBatch, userID string) {
+	gen := fmt.Sprintf("%016x", time.Now().UnixNano())
+	batch.Add(ds.cfg.RequestsTableName, deleteRequestsHashValue, rangeValue(userID, gen, cacheGenRangeKey), nil)
+}
+
+// requestID hashes the tenant, creation time and selectors of a request.
+func requestID(req DeleteRequest) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(req.UserID))
+	_, _ = h.Write([]byte(req.CreatedAt.String()))
+	_, _ = h.Write([]byte(strings.Join(req.Selectors, "&")))
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], h.Sum64())
+	return hex.EncodeToString(buf[:])
+}
+
+// MarkProcessed records that the data of a request has been purged.
+func (ds *DeleteStore) MarkProcessed(ctx context.Context, req DeleteRequest) error {
+	batch := ds.indexClient.NewWriteBatch()
+	batch.Add(ds.cfg.RequestsTableName, deleteRequestsHashValue, rangeValue(req.UserID, req.RequestID, processedRangeKey), []byte(model.Now().String()))
+	return ds.indexClient.BatchWrite(ctx, batch)
+}
+
+// RemoveDeleteRequest removes a request, cancelling it.
+func (ds *DeleteStore) RemoveDeleteRequest(ctx context.Context, req DeleteRequest) error {
+	err := ds.indexClient.(chunk.IndexDeleter).DeleteEntries(ctx, []chunk.IndexEntry{
+		{TableName: ds.cfg.RequestsTableName, HashValue: deleteRequestsHashValue, RangeValue: rangeValue(req.UserID, req.RequestID, requestRangeKey)},
+		{TableName: ds.cfg.RequestsTableName, HashValue: deleteRequestsHashValue, RangeValue: rangeValue(req.UserID, req.RequestID, processedRangeKey)},
+	})
+	if err != nil {
+		return err
+	}
+
+	batch := ds.indexClient.NewWriteBatch()
+	ds.addCacheGenNumber(batch, req.UserID)
+	return ds.indexClient.BatchWrite(ctx, batch)
+}
+
+// GetDeleteRequest returns a request of a tenant, nil if there's none.
+func (ds *DeleteStore) GetDeleteRequest(ctx context.Context, userID, requestID string) (*DeleteRequest, error) {
+	reqs, _, err := ds.query(ctx, rangeValue(userID, requestID, ""))
+	if err != nil || len(reqs) == 0 {
+		return nil, err
+	}
+	return &reqs[0], nil
+}
+
+// GetAllDeleteRequestsForUser returns all the requests of a tenant.
+func (ds *DeleteStore) GetAllDeleteRequestsForUser(ctx context.Context, userID string) ([]DeleteRequest, error) {
+	reqs, _, err := ds.query(ctx, []byte(userID+rangeSeparator))
+	return reqs, err
+}
+
+// GetCacheGenNumber returns the cache generation number of a tenant, changed
+// whenever one of its delete requests is added or cancelled, and empty if it
+// never had any.
+func (ds *DeleteStore) GetCacheGenNumber(ctx context.Context, userID string) (string, error) {
+	_, gen, err := ds.query(ctx, []byte(userID+rangeSeparator))
+	return gen, err
+}
+
+// getDeleteRequestsAndCacheGenNumber returns all the requests of a tenant and
+// its cache generation number.
+func (ds *DeleteStore) getDeleteRequestsAndCacheGenNumber(ctx context.Context, userID string) ([]DeleteRequest, string, error) {
+	return ds.query(ctx, []byte(userID+rangeSeparator))
+}
+
+// GetDeleteRequestsByStatus returns the requests of all the tenants with the
+// given status.
+func (ds *DeleteStore) GetDeleteRequestsByStatus(ctx context.Context, status DeleteRequestStatus) ([]DeleteRequest, error) {
+	reqs, _, err := ds.query(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := reqs[:0]
+	for _, req := range reqs {
+		if req.Status == status {
+			filtered = append(filtered, req)
+		}
+	}
+	return filtered, nil
+}
+
+// query returns the requests with the given range value prefix, and the
+// latest cache generation number of their tenants.
+func (ds *DeleteStore) query(ctx context.Context, prefix []byte) ([]DeleteRequest, string, error) {
+	var (
+		reqs      []DeleteRequest
+		processed = map[string]bool{}
+		gen       string
+		err       error
+	)
+	query := chunk.IndexQuery{
+		TableName:        ds.cfg.RequestsTableName,
+		HashValue:        deleteRequestsHashValue,
+		RangeValuePrefix: prefix,
+	}
+	queryErr := ds.indexClient.QueryPages(ctx, []chunk.IndexQuery{query}, func(_ chunk.IndexQuery, batch chunk.ReadBatch) bool {
+		iter := batch.Iterator()
+		for iter.Next() {
+			userID, requestID, key, ok := parseRangeValue(iter.RangeValue())
+			if !ok {
+				continue
+			}
+
+			switch key {
+			case requestRangeKey:
+				req := DeleteRequest{}
+				if err = json.Unmarshal(iter.Value(), &req); err != nil {
+					return false
+				}
+				req.UserID = userID
+				req.RequestID = requestID
+				reqs = append(reqs, req)
+			case processedRangeKey:
+				processed[userID+rangeSeparator+requestID] = true
+			case cacheGenRangeKey:
+				if requestID > gen {
+					gen = requestID
+				}
+			}
+		}
+		return true
+	})
+	if queryErr != nil {
+		return nil, "", queryErr
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i := range reqs {
+		if processed[reqs[i].UserID+rangeSeparator+reqs[i].RequestID] {
+			reqs[i].Status = StatusProcessed
+		}
+	}
+	return reqs, gen, nil
+}
+
+const rangeSeparator = "\x00"
+
+// rangeValue is the range value of an entry of a request, separating its
+// parts with null bytes like the chunk index does.
+func rangeValue(userID, requestID, key string) []byte {
+	return []byte(userID + rangeSeparator + requestID + rangeSeparator + key)
+}
+
+func parseRangeValue(rangeValue []byte) (userID, requestID, key string, ok bool) {
+	parts := bytes.Split(rangeValue, []byte(rangeSeparator))
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+	return string(parts[0]), string(parts[1]), string(parts[2]), true
+}
+
+// CreateRequestsTable creates the table of the delete requests, unless it
+// exists.
+func CreateRequestsTable(ctx context.Context, tableClient chunk.TableClient, desc chunk.TableDesc) error {
+	tables, err := tableClient.ListTables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if table == desc.Name {
+			return nil
+		}
+	}
+	return tableClient.CreateTable(ctx, desc)
+}
@@ -0,0 +1,119 @@
+package purger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+func newTestDeleteStore(t *testing.T) *DeleteStore {
+	cfg := DeleteStoreConfig{RequestsTableName: "delete_requests"}
+	storage := chunk.NewMockStorage()
+	require.NoError(t, CreateRequestsTable(context.Background(), storage, chunk.TableDesc{Name: cfg.RequestsTableName}))
+	// Creating it again is a no-op.
+	require.NoError(t, CreateRequestsTable(context.Background(), storage, chunk.TableDesc{Name: cfg.RequestsTableName}))
+
+	store, err := NewDeleteStore(cfg, storage)
+	require.NoError(t, err)
+	return store
+}
+
+func TestDeleteStore(t *testing.T) {
+	ctx := context.Background()
+	store := newTestDeleteStore(t)
+
+	gen, err := store.GetCacheGenNumber(ctx, "user1")
+	require.NoError(t, err)
+	require.Empty(t, gen)
+
+	req1, err := store.AddDeleteRequest(ctx, "user1", 0, 1000, []string{`foo{bar="baz"}`})
+	require.NoError(t, err)
+	req2, err := store.AddDeleteRequest(ctx, "user1", 2000, 3000, []string{`foo`, `bar`})
+	require.NoError(t, err)
+	req3, err := store.AddDeleteRequest(ctx, "user2", 0, 1000, []string{`foo`})
+	require.NoError(t, err)
+	require.NotEqual(t, req1.RequestID, req2.RequestID)
+
+	reqs, err := store.GetAllDeleteRequestsForUser(ctx, "user1")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []DeleteRequest{req1, req2}, reqs)
+
+	got, err := store.GetDeleteRequest(ctx, "user2", req3.RequestID)
+	require.NoError(t, err)
+	require.Equal(t, &req3, got)
+
+	got, err = store.GetDeleteRequest(ctx, "user2", req1.RequestID)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	// The processed requests have their status.
+	require.NoError(t, store.MarkProcessed(ctx, req1))
+	reqs, err = store.GetDeleteRequestsByStatus(ctx, StatusReceived)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []DeleteRequest{req2, req3}, reqs)
+	reqs, err = store.GetDeleteRequestsByStatus(ctx, StatusProcessed)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	require.Equal(t, req1.RequestID, reqs[0].RequestID)
+
+	// Adding the requests changed the cache generation number.
+	gen, err = store.GetCacheGenNumber(ctx, "user1")
+	require.NoError(t, err)
+	require.NotEmpty(t, gen)
+
+	// The cancelled requests are removed, changing it again.
+	require.NoError(t, store.RemoveDeleteRequest(ctx, req2))
+	reqs, err = store.GetAllDeleteRequestsForUser(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	require.Equal(t, req1.RequestID, reqs[0].RequestID)
+	cancelledGen, err := store.GetCacheGenNumber(ctx, "user1")
+	require.NoError(t, err)
+	require.True(t, cancelledGen > gen)
+}
+
+func TestDeleteRequestsCache(t *testing.T) {
+	ctx := context.Background()
+	store := newTestDeleteStore(t)
+	c := NewDeleteRequestsCache(store, time.Hour)
+
+	reqs, err := c.GetAllDeleteRequestsForUser(ctx, "user1")
+	require.NoError(t, err)
+	require.Empty(t, reqs)
+	gen, err := c.GetResultsCacheGenNumber(ctx, "user1")
+	require.NoError(t, err)
+	require.Empty(t, gen)
+
+	// The requests added are seen once the cached ones expire.
+	_, err = store.AddDeleteRequest(ctx, "user1", 0, 1000, []string{`foo`})
+	require.NoError(t, err)
+	reqs, err = c.GetAllDeleteRequestsForUser(ctx, "user1")
+	require.NoError(t, err)
+	require.Empty(t, reqs)
+
+	c = NewDeleteRequestsCache(store, 0)
+	reqs, err = c.GetAllDeleteRequestsForUser(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	require.NotNil(t, reqs[0].matchers)
+	gen, err = c.GetResultsCacheGenNumber(ctx, "user1")
+	require.NoError(t, err)
+	require.NotEmpty(t, gen)
+}
+
+func TestDeleteRequestMatchers(t *testing.T) {
+	req := DeleteRequest{StartTime: 0, EndTime: model.Now(), Selectors: []string{`foo{bar="baz"}`, `{job=~"a.*"}`}}
+	matchers, err := req.Matchers()
+	require.NoError(t, err)
+	require.Len(t, matchers, 2)
+	require.Len(t, matchers[0], 2)
+
+	req = DeleteRequest{Selectors: []string{`foo{`}}
+	_, err = req.Matchers()
+	require.Error(t, err)
+}
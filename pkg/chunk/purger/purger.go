@@ -0,0 +1,344 @@
+package purger
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// The chunks of a request are looked up a day at a time, within the maximum
+// query length of the tenants.
+const lookupInterval = 24 * time.Hour
+
+var (
+	deleteRequestsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "purger_delete_requests_processed_total",
+		Help:      "Number of delete requests processed per user.",
+	}, []string{"user"})
+	deleteRequestsFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "purger_delete_requests_failed_total",
+		Help:      "Number of delete requests which failed to be processed; they are tried again.",
+	})
+	purgedChunks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "purger_purged_chunks_total",
+		Help:      "Number of chunks deleted, or rewritten without the deleted samples, per user.",
+	}, []string{"user", "operation"})
+	tenantDeletionsProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "purger_tenant_deletions_processed_total",
+		Help:      "Number of tenant deletion requests processed.",
+	})
+	tenantDeletionsFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "purger_tenant_deletions_failed_total",
+		Help:      "Number of tenant deletion requests which failed to be processed; they are tried again.",
+	})
+)
+
+// ConfigsDeleter deletes the rules and Alertmanager configurations of the
+// tenants.
+type ConfigsDeleter interface {
+	DeleteConfig(ctx context.Context, userID string) error
+}
+
+// Config is the config of the Purger.
+type Config struct {
+	Enable                    bool          `yaml:"enable"`
+	DeleteRequestCancelPeriod time.Duration `yaml:"delete_request_cancel_period"`
+	PollInterval              time.Duration `yaml:"poll_interval"`
+	DeleteTenantConfigs       bool          `yaml:"delete_tenant_configs"`
+	TenantDeletionEnabled     bool          `yaml:"tenant_deletion_enabled"`
+}
+
+// RegisterFlags registers flags.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enable, "purger.enable", false, "Enable the purger, which deletes the data of the delete requests from the chunk store.")
+	f.DurationVar(&cfg.DeleteRequestCancelPeriod, "purger.delete-request-cancel-period", 24*time.Hour, "Time the delete requests can be cancelled in, before their data is purged.")
+	f.DurationVar(&cfg.PollInterval, "purger.poll-interval", 5*time.Minute, "How often to look for the delete requests past their cancel period.")
+	f.BoolVar(&cfg.TenantDeletionEnabled, "purger.tenant-deletion-enabled", false, "Serve the tenant deletion API and delete the data of the tenant deletion requests. Cortex refuses to start if the chunk store can't list and delete the chunks of a tenant.")
+	f.BoolVar(&cfg.DeleteTenantConfigs, "purger.delete-tenant-configs", false, "Delete the rules and Alertmanager configurations of the deleted tenants from the configs database, set with -database.uri.")
+}
+
+// Purger deletes the data of the delete requests past their cancel period
+// from the chunk store: the chunks entirely within the interval of a request
+// are deleted, and those overlapping it are rewritten without its samples.
+// It deletes all the data of the tenants of the tenant deletion requests too.
+type Purger struct {
+	cfg         Config
+	deleteStore *DeleteStore
+	chunkStore  chunk.Store
+	configs     ConfigsDeleter
+
+	quit chan struct{}
+	wait sync.WaitGroup
+}
+
+// NewPurger makes a new Purger. The configurations of the deleted tenants are
+// only deleted if configs isn't nil.
+func NewPurger(cfg Config, deleteStore *DeleteStore, chunkStore chunk.Store, configs ConfigsDeleter) *Purger {
+	return &Purger{
+		cfg:         cfg,
+		deleteStore: deleteStore,
+		chunkStore:  chunkStore,
+		configs:     configs,
+		quit:        make(chan struct{}),
+	}
+}
+
+// Start the Purger.
+func (p *Purger) Start() {
+	p.wait.Add(1)
+	go p.loop()
+}
+
+// Stop the Purger.
+func (p *Purger) Stop() {
+	close(p.quit)
+	p.wait.Wait()
+}
+
+func (p *Purger) loop() {
+	defer p.wait.Done()
+
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-p.quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if err := p.ProcessDeleteRequests(ctx); err != nil {
+			level.Error(util.Logger).Log("msg", "error processing the delete requests", "err", err)
+		}
+		if p.cfg.TenantDeletionEnabled {
+			if err := p.ProcessTenantDeletionRequests(ctx); err != nil {
+				level.Error(util.Logger).Log("msg", "error processing the tenant deletion requests", "err", err)
+			}
+		}
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// ProcessDeleteRequests purges the data of the received delete requests past
+// their cancel period. The failed requests are tried again the next time.
+func (p *Purger) ProcessDeleteRequests(ctx context.Context) error {
+	reqs, err := p.deleteStore.GetDeleteRequestsByStatus(ctx, StatusReceived)
+	if err != nil {
+		return err
+	}
+
+	cutoff := model.Now().Add(-p.cfg.DeleteRequestCancelPeriod)
+	for _, req := range reqs {
+		if req.CreatedAt.After(cutoff) {
+			continue
+		}
+
+		if err := p.executeDeleteRequest(ctx, req); err != nil {
+			deleteRequestsFailed.Inc()
+			level.Error(util.Logger).Log("msg", "error purging the data of a delete request", "user", req.UserID, "request_id", req.RequestID, "err", err)
+			continue
+		}
+		if err := p.deleteStore.MarkProcessed(ctx, req); err != nil {
+			return err
+		}
+		deleteRequestsProcessed.WithLabelValues(req.UserID).Inc()
+		level.Info(util.Logger).Log("msg", "purged the data of a delete request", "user", req.UserID, "request_id", req.RequestID)
+	}
+	return nil
+}
+
+func (p *Purger) executeDeleteRequest(ctx context.Context, req DeleteRequest) error {
+	ctx = user.InjectOrgID(ctx, req.UserID)
+	matchers, err := req.Matchers()
+	if err != nil {
+		return err
+	}
+
+	// The chunks spanning several days are found once per day.
+	seen := map[string]bool{}
+	for from := req.StartTime; from <= req.EndTime; from = from.Add(lookupInterval) {
+		through := from.Add(lookupInterval - time.Millisecond)
+		if through > req.EndTime {
+			through = req.EndTime
+		}
+
+		for _, m := range matchers {
+			chunks, err := p.chunkStore.Get(ctx, req.UserID, from, through, m...)
+			if err != nil {
+				return err
+			}
+
+			for _, c := range chunks {
+				if seen[c.ExternalKey()] {
+					continue
+				}
+				seen[c.ExternalKey()] = true
+
+				if err := p.purgeChunk(ctx, c, req.StartTime, req.EndTime); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ProcessTenantDeletionRequests deletes the data of the tenants of the
+// pending tenant deletion requests past their cancel period. Each step of a
+// deletion is recorded once done, and the failed deletions are resumed the
+// next time.
+func (p *Purger) ProcessTenantDeletionRequests(ctx context.Context) error {
+	reqs, err := p.deleteStore.GetPendingTenantDeletionRequests(ctx)
+	if err != nil {
+		return err
+	}
+
+	cutoff := model.Now().Add(-p.cfg.DeleteRequestCancelPeriod)
+	for _, req := range reqs {
+		if req.CreatedAt.After(cutoff) {
+			continue
+		}
+
+		if err := p.executeTenantDeletionRequest(ctx, req); err != nil {
+			tenantDeletionsFailed.Inc()
+			level.Error(util.Logger).Log("msg", "error deleting the data of a tenant", "user", req.UserID, "request_id", req.RequestID, "err", err)
+			continue
+		}
+		tenantDeletionsProcessed.Inc()
+	}
+	return nil
+}
+
+func (p *Purger) executeTenantDeletionRequest(ctx context.Context, req TenantDeletionRequest) error {
+	ctx = user.InjectOrgID(ctx, req.UserID)
+
+	if req.ChunksDeletedAt == 0 {
+		deleted, err := p.chunkStore.DeleteUserChunks(ctx, req.UserID, 0, model.Latest)
+		purgedChunks.WithLabelValues(req.UserID, "delete").Add(float64(deleted))
+		if err != nil {
+			return err
+		}
+		if err := p.deleteStore.MarkTenantChunksDeleted(ctx, req, deleted); err != nil {
+			return err
+		}
+		level.Info(util.Logger).Log("msg", "deleted the chunks of a tenant", "user", req.UserID, "request_id", req.RequestID, "chunks", deleted)
+	}
+
+	if p.configs != nil && req.ConfigsDeletedAt == 0 {
+		if err := p.configs.DeleteConfig(ctx, req.UserID); err != nil {
+			return err
+		}
+		if err := p.deleteStore.MarkTenantConfigsDeleted(ctx, req); err != nil {
+			return err
+		}
+		level.Info(util.Logger).Log("msg", "deleted the configurations of a tenant", "user", req.UserID, "request_id", req.RequestID)
+	}
+
+	// The delete requests of the tenant have its selectors.
+	deleteReqs, err := p.deleteStore.GetAllDeleteRequestsForUser(ctx, req.UserID)
+	if err != nil {
+		return err
+	}
+	for _, deleteReq := range deleteReqs {
+		if err := p.deleteStore.RemoveDeleteRequest(ctx, deleteReq); err != nil {
+			return err
+		}
+	}
+
+	if err := p.deleteStore.MarkTenantDeletionProcessed(ctx, req); err != nil {
+		return err
+	}
+	level.Info(util.Logger).Log("msg", "audit: deleted all the data of a tenant", "user", req.UserID, "request_id", req.RequestID,
+		"requested_by", req.RequestedBy, "remote_addr", req.RemoteAddr, "created_at", req.CreatedAt.Time())
+	return nil
+}
+
+// purgeChunk deletes the samples of a chunk between start and end: it puts
+// the chunks of its other samples, if any, before deleting it.
+func (p *Purger) purgeChunk(ctx context.Context, c chunk.Chunk, start, end model.Time) error {
+	operation := "delete"
+	if c.From < start || c.Through > end {
+		chunks, err := rewriteChunk(c, start, end)
+		if err != nil {
+			return err
+		}
+		if err := p.chunkStore.Put(ctx, chunks); err != nil {
+			return err
+		}
+		operation = "rewrite"
+	}
+
+	if err := p.chunkStore.DeleteChunk(ctx, c.From, c.Through, c); err != nil {
+		return err
+	}
+	purgedChunks.WithLabelValues(c.UserID, operation).Inc()
+	return nil
+}
+
+// rewriteChunk returns the chunks of the samples of c outside of the interval
+// between start and end.
+func rewriteChunk(c chunk.Chunk, start, end model.Time) ([]chunk.Chunk, error) {
+	samples, err := c.Samples(c.From, c.Through)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		chunks        []chunk.Chunk
+		current       encoding.Chunk
+		from, through model.Time
+	)
+	for _, s := range samples {
+		if s.Timestamp >= start && s.Timestamp <= end {
+			continue
+		}
+		if current == nil {
+			current, from = encoding.New(), s.Timestamp
+		}
+
+		cs, err := current.Add(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(cs) > 1 {
+			chunks = append(chunks, chunk.NewChunk(c.UserID, c.Fingerprint, c.Metric, cs[0], from, through))
+			from = s.Timestamp
+		}
+		current, through = cs[len(cs)-1], s.Timestamp
+	}
+	if current != nil {
+		chunks = append(chunks, chunk.NewChunk(c.UserID, c.Fingerprint, c.Metric, current, from, through))
+	}
+
+	for i := range chunks {
+		if err := chunks[i].Encode(); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
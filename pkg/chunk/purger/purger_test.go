@@ -0,0 +1,134 @@
+package purger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+const userID = "userID"
+
+var metric = labels.Labels{
+	{Name: labels.MetricName, Value: "foo"},
+	{Name: "bar", Value: "baz"},
+}
+
+func newTestChunkStore(t *testing.T) chunk.Store {
+	var (
+		storeCfg  chunk.StoreConfig
+		tbmConfig chunk.TableManagerConfig
+		limits    validation.Limits
+		schemaCfg = chunk.DefaultSchemaConfig("", "v9", 0)
+	)
+	flagext.DefaultValues(&storeCfg, &tbmConfig, &limits)
+	storage := chunk.NewMockStorage()
+
+	tableManager, err := chunk.NewTableManager(tbmConfig, schemaCfg, 12*time.Hour, storage, nil, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, tableManager.SyncTables(context.Background()))
+
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+
+	store := chunk.NewCompositeStore()
+	require.NoError(t, store.AddPeriod(storeCfg, schemaCfg.Configs[0], storage, storage, overrides))
+	return store
+}
+
+// newTestChunk makes a chunk with a sample per minute between from and
+// through.
+func newTestChunk(t *testing.T, from, through model.Time) chunk.Chunk {
+	pc := encoding.New()
+	for ts := from; ts <= through; ts = ts.Add(time.Minute) {
+		pcs, err := pc.Add(model.SamplePair{Timestamp: ts, Value: model.SampleValue(ts)})
+		require.NoError(t, err)
+		require.Len(t, pcs, 1)
+		pc = pcs[0]
+	}
+
+	c := chunk.NewChunk(userID, model.Fingerprint(1), metric, pc, from, through)
+	require.NoError(t, c.Encode())
+	return c
+}
+
+func TestPurger(t *testing.T) {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	chunkStore := newTestChunkStore(t)
+	deleteStore := newTestDeleteStore(t)
+
+	hour := model.TimeFromUnix(3600)
+	require.NoError(t, chunkStore.Put(ctx, []chunk.Chunk{
+		newTestChunk(t, 0, hour.Add(-time.Minute)),
+		newTestChunk(t, hour, (2 * hour).Add(-time.Minute)),
+	}))
+
+	// The first chunk is rewritten without its second half, the second
+	// deleted.
+	start := model.TimeFromUnix(1800)
+	req, err := deleteStore.AddDeleteRequest(ctx, userID, start, 2*hour, []string{`foo{bar="baz"}`})
+	require.NoError(t, err)
+
+	purger := NewPurger(Config{DeleteRequestCancelPeriod: time.Hour}, deleteStore, chunkStore, nil)
+
+	// The requests aren't processed before the end of their cancel period.
+	require.NoError(t, purger.ProcessDeleteRequests(ctx))
+	reqs, err := deleteStore.GetDeleteRequestsByStatus(ctx, StatusReceived)
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+
+	purger.cfg.DeleteRequestCancelPeriod = 0
+	require.NoError(t, purger.ProcessDeleteRequests(ctx))
+	got, err := deleteStore.GetDeleteRequest(ctx, userID, req.RequestID)
+	require.NoError(t, err)
+	require.Equal(t, StatusProcessed, got.Status)
+
+	chunks, err := chunkStore.Get(ctx, userID, 0, 3*hour, mustNewMatcher(labels.MatchEqual, labels.MetricName, "foo"))
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	require.Equal(t, model.Time(0), chunks[0].From)
+	require.Equal(t, start.Add(-time.Minute), chunks[0].Through)
+
+	samples, err := chunks[0].Samples(chunks[0].From, chunks[0].Through)
+	require.NoError(t, err)
+	require.Len(t, samples, 30)
+}
+
+func mustNewMatcher(t labels.MatchType, name, value string) *labels.Matcher {
+	m, err := labels.NewMatcher(t, name, value)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func TestRewriteChunk(t *testing.T) {
+	c := newTestChunk(t, 0, model.TimeFromUnix(59*60))
+
+	chunks, err := rewriteChunk(c, model.TimeFromUnix(10*60), model.TimeFromUnix(20*60))
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	require.Equal(t, model.Time(0), chunks[0].From)
+	require.Equal(t, model.TimeFromUnix(59*60), chunks[0].Through)
+
+	samples, err := chunks[0].Samples(chunks[0].From, chunks[0].Through)
+	require.NoError(t, err)
+	require.Len(t, samples, 60-11)
+	for _, s := range samples {
+		require.False(t, s.Timestamp >= model.TimeFromUnix(10*60) && s.Timestamp <= model.TimeFromUnix(20*60))
+	}
+
+	// Nothing is left of the chunks entirely deleted.
+	chunks, err = rewriteChunk(c, 0, model.TimeFromUnix(3600))
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+}
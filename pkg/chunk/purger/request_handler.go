@@ -0,0 +1,239 @@
+package purger
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// DeleteRequestHandler serves the API of the delete requests, compatible
+// with the delete series endpoint of the Prometheus TSDB admin API.
+type DeleteRequestHandler struct {
+	deleteStore  *DeleteStore
+	cancelPeriod time.Duration
+}
+
+// NewDeleteRequestHandler makes a new DeleteRequestHandler; the requests can
+// be cancelled within the cancel period.
+func NewDeleteRequestHandler(deleteStore *DeleteStore, cancelPeriod time.Duration) *DeleteRequestHandler {
+	return &DeleteRequestHandler{
+		deleteStore:  deleteStore,
+		cancelPeriod: cancelPeriod,
+	}
+}
+
+// AddDeleteRequestHandler adds a delete request of the series matching the
+// match[] selectors between the start and end times, the whole history up to
+// now by default.
+func (h *DeleteRequestHandler) AddDeleteRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selectors := r.Form["match[]"]
+	if len(selectors) == 0 {
+		http.Error(w, "no match[] parameter provided", http.StatusBadRequest)
+		return
+	}
+	for _, selector := range selectors {
+		if _, err := promql.ParseMetricSelector(selector); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	startTime, err := parseTime(r.Form.Get("start"), 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	endTime, err := parseTime(r.Form.Get("end"), model.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if endTime.Before(startTime) {
+		http.Error(w, "end time can't be before the start time", http.StatusBadRequest)
+		return
+	}
+	if endTime.After(model.Now()) {
+		http.Error(w, "deletes in the future are not allowed", http.StatusBadRequest)
+		return
+	}
+
+	req, err := h.deleteStore.AddDeleteRequest(r.Context(), userID, startTime, endTime, selectors)
+	if err != nil {
+		level.Error(util.WithContext(r.Context(), util.Logger)).Log("msg", "error adding a delete request", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(util.WithContext(r.Context(), util.Logger)).Log("msg", "added a delete request", "request_id", req.RequestID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAllDeleteRequestsHandler returns the delete requests of the tenant.
+func (h *DeleteRequestHandler) GetAllDeleteRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	reqs, err := h.deleteStore.GetAllDeleteRequestsForUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reqs == nil {
+		reqs = []DeleteRequest{}
+	}
+	util.WriteJSONResponse(w, reqs)
+}
+
+// CancelDeleteRequestHandler cancels the delete request_id of the tenant,
+// unless it's past its cancel period.
+func (h *DeleteRequestHandler) CancelDeleteRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	requestID := r.FormValue("request_id")
+	req, err := h.deleteStore.GetDeleteRequest(r.Context(), userID, requestID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		http.Error(w, "could not find the delete request", http.StatusNotFound)
+		return
+	}
+	if req.Status != StatusReceived {
+		http.Error(w, "the deletion of the request has already been processed", http.StatusBadRequest)
+		return
+	}
+	if req.CreatedAt.Add(h.cancelPeriod).Before(model.Now()) {
+		http.Error(w, fmt.Sprintf("the delete requests can only be cancelled within %s of being added", h.cancelPeriod), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.deleteStore.RemoveDeleteRequest(r.Context(), *req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(util.WithContext(r.Context(), util.Logger)).Log("msg", "cancelled a delete request", "request_id", requestID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddTenantDeletionRequestHandler adds a request to delete all the data of
+// the tenant, on behalf of the user the request is authenticated as, in the
+// X-Scope-UserID header set by the authenticating proxy, or of the tenant
+// itself otherwise.
+func (h *DeleteRequestHandler) AddTenantDeletionRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	requestedBy, _, err := user.ExtractUserIDFromHTTPRequest(r)
+	if err != nil {
+		requestedBy = userID
+	}
+
+	req, err := h.deleteStore.AddTenantDeletionRequest(r.Context(), userID, requestedBy, r.RemoteAddr)
+	if err != nil {
+		level.Error(util.WithContext(r.Context(), util.Logger)).Log("msg", "error adding a tenant deletion request", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(util.WithContext(r.Context(), util.Logger)).Log("msg", "audit: added a tenant deletion request", "request_id", req.RequestID,
+		"requested_by", requestedBy, "remote_addr", r.RemoteAddr)
+
+	util.WriteJSONResponse(w, req)
+}
+
+// GetTenantDeletionRequestsHandler returns the tenant deletion requests of
+// the tenant, with their progress.
+func (h *DeleteRequestHandler) GetTenantDeletionRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	reqs, err := h.deleteStore.GetTenantDeletionRequestsForUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reqs == nil {
+		reqs = []TenantDeletionRequest{}
+	}
+	util.WriteJSONResponse(w, reqs)
+}
+
+// CancelTenantDeletionRequestHandler cancels the tenant deletion request_id
+// of the tenant, unless it's past its cancel period.
+func (h *DeleteRequestHandler) CancelTenantDeletionRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	requestID := r.FormValue("request_id")
+	req, err := h.deleteStore.GetTenantDeletionRequest(r.Context(), userID, requestID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		http.Error(w, "could not find the tenant deletion request", http.StatusNotFound)
+		return
+	}
+	if req.Status != StatusReceived {
+		http.Error(w, "the deletion of the tenant has already started", http.StatusBadRequest)
+		return
+	}
+	if req.CreatedAt.Add(h.cancelPeriod).Before(model.Now()) {
+		http.Error(w, fmt.Sprintf("the tenant deletion requests can only be cancelled within %s of being added", h.cancelPeriod), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.deleteStore.RemoveTenantDeletionRequest(r.Context(), *req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(util.WithContext(r.Context(), util.Logger)).Log("msg", "audit: cancelled a tenant deletion request", "request_id", requestID, "remote_addr", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseTime(s string, def model.Time) (model.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := queryrange.ParseTime(s)
+	if err != nil {
+		return 0, err
+	}
+	return model.Time(t), nil
+}
@@ -0,0 +1,103 @@
+package purger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestDeleteRequestHandler(t *testing.T) {
+	store := newTestDeleteStore(t)
+	h := NewDeleteRequestHandler(store, time.Hour)
+
+	do := func(handler http.HandlerFunc, method string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/admin/tsdb/delete_series", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(user.InjectOrgID(req.Context(), userID))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	for _, form := range []url.Values{
+		{},
+		{"match[]": {"foo{"}},
+		{"match[]": {"foo"}, "start": {"2000"}, "end": {"1000"}},
+		{"match[]": {"foo"}, "end": {"9999999999"}},
+	} {
+		require.Equal(t, http.StatusBadRequest, do(h.AddDeleteRequestHandler, "POST", form).Code, form)
+	}
+
+	w := do(h.AddDeleteRequestHandler, "POST", url.Values{"match[]": {`foo{bar="baz"}`}, "start": {"1000"}, "end": {"2000"}})
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do(h.GetAllDeleteRequestsHandler, "GET", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var reqs []DeleteRequest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reqs))
+	require.Len(t, reqs, 1)
+	require.Equal(t, []string{`foo{bar="baz"}`}, reqs[0].Selectors)
+	require.Equal(t, StatusReceived, reqs[0].Status)
+
+	require.Equal(t, http.StatusNotFound, do(h.CancelDeleteRequestHandler, "POST", url.Values{"request_id": {"missing"}}).Code)
+	require.Equal(t, http.StatusNoContent, do(h.CancelDeleteRequestHandler, "POST", url.Values{"request_id": {reqs[0].RequestID}}).Code)
+
+	w = do(h.GetAllDeleteRequestsHandler, "GET", nil)
+	require.Equal(t, "[]", w.Body.String())
+
+	// The requests can't be cancelled after their cancel period.
+	require.Equal(t, http.StatusNoContent, do(h.AddDeleteRequestHandler, "POST", url.Values{"match[]": {"foo"}}).Code)
+	reqs, err := store.GetAllDeleteRequestsForUser(context.Background(), userID)
+	require.NoError(t, err)
+	h.cancelPeriod = -time.Second
+	require.Equal(t, http.StatusBadRequest, do(h.CancelDeleteRequestHandler, "POST", url.Values{"request_id": {reqs[0].RequestID}}).Code)
+}
+
+func TestTenantDeletionRequestHandler(t *testing.T) {
+	store := newTestDeleteStore(t)
+	h := NewDeleteRequestHandler(store, time.Hour)
+
+	do := func(handler http.HandlerFunc, method string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/admin/tenant/delete", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(user.UserIDHeaderName, "operator")
+		req = req.WithContext(user.InjectOrgID(req.Context(), userID))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// The operator is the authenticated user, whatever the form says.
+	w := do(h.AddTenantDeletionRequestHandler, "POST", url.Values{"requested_by": {"someone-else"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var added TenantDeletionRequest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+	require.Equal(t, userID, added.UserID)
+	require.Equal(t, "operator", added.RequestedBy)
+	require.NotEmpty(t, added.RemoteAddr)
+
+	w = do(h.GetTenantDeletionRequestsHandler, "GET", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var reqs []TenantDeletionRequest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reqs))
+	require.Equal(t, []TenantDeletionRequest{added}, reqs)
+
+	require.Equal(t, http.StatusNotFound, do(h.CancelTenantDeletionRequestHandler, "POST", url.Values{"request_id": {"missing"}}).Code)
+	require.Equal(t, http.StatusNoContent, do(h.CancelTenantDeletionRequestHandler, "POST", url.Values{"request_id": {added.RequestID}}).Code)
+	w = do(h.GetTenantDeletionRequestsHandler, "GET", nil)
+	require.Equal(t, "[]", w.Body.String())
+
+	// The requests can't be cancelled once their deletion has started.
+	added, err := store.AddTenantDeletionRequest(context.Background(), userID, "operator", "")
+	require.NoError(t, err)
+	require.NoError(t, store.MarkTenantChunksDeleted(context.Background(), added, 0))
+	require.Equal(t, http.StatusBadRequest, do(h.CancelTenantDeletionRequestHandler, "POST", url.Values{"request_id": {added.RequestID}}).Code)
+}
@@ -0,0 +1,231 @@
+package purger
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+// StatusDeleting is the status of the tenant deletion requests with some of
+// their data deleted.
+const StatusDeleting DeleteRequestStatus = "deleting"
+
+const (
+	// The tenant deletion requests are under a hash value of their own, with
+	// the same range values as the delete requests.
+	tenantDeletionRequestsHashValue = "tenantDeletionRequests"
+
+	chunksDeletedRangeKey  = "c"
+	configsDeletedRangeKey = "g"
+)
+
+// TenantDeletionRequest is a request of an operator to delete all the data of
+// a tenant: its chunks with their index entries, its delete requests, and its
+// rules and Alertmanager configurations. It is kept once processed, as the
+// audit record of the deletion.
+type TenantDeletionRequest struct {
+	RequestID   string              `json:"request_id"`
+	UserID      string              `json:"user_id"`
+	RequestedBy string              `json:"requested_by"`
+	RemoteAddr  string              `json:"remote_addr"`
+	CreatedAt   model.Time          `json:"created_at"`
+	Status      DeleteRequestStatus `json:"status"`
+
+	// The progress of the deletion.
+	ChunksDeleted    int        `json:"chunks_deleted"`
+	ChunksDeletedAt  model.Time `json:"chunks_deleted_at,omitempty"`
+	ConfigsDeletedAt model.Time `json:"configs_deleted_at,omitempty"`
+	CompletedAt      model.Time `json:"completed_at,omitempty"`
+}
+
+// tenantDeletionStep is the value of the entry recording a step of the
+// deletion of a tenant.
+type tenantDeletionStep struct {
+	Time   model.Time `json:"time"`
+	Chunks int        `json:"chunks,omitempty"`
+}
+
+// AddTenantDeletionRequest adds a request to delete all the data of a tenant,
+// returning it.
+func (ds *DeleteStore) AddTenantDeletionRequest(ctx context.Context, userID, requestedBy, remoteAddr string) (TenantDeletionRequest, error) {
+	req := TenantDeletionRequest{
+		UserID:      userID,
+		RequestedBy: requestedBy,
+		RemoteAddr:  remoteAddr,
+		CreatedAt:   model.Now(),
+		Status:      StatusReceived,
+	}
+	req.RequestID = tenantDeletionRequestID(req)
+
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return TenantDeletionRequest{}, err
+	}
+
+	batch := ds.indexClient.NewWriteBatch()
+	batch.Add(ds.cfg.RequestsTableName, tenantDeletionRequestsHashValue, rangeValue(userID, req.RequestID, requestRangeKey), buf)
+	if err := ds.indexClient.BatchWrite(ctx, batch); err != nil {
+		return TenantDeletionRequest{}, err
+	}
+	return req, nil
+}
+
+// tenantDeletionRequestID hashes the tenant, creation time and requester of
+// a request.
+func tenantDeletionRequestID(req TenantDeletionRequest) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(req.UserID))
+	_, _ = h.Write([]byte(req.CreatedAt.String()))
+	_, _ = h.Write([]byte(req.RequestedBy))
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], h.Sum64())
+	return hex.EncodeToString(buf[:])
+}
+
+// MarkTenantChunksDeleted records that the chunks of a request have been
+// deleted.
+func (ds *DeleteStore) MarkTenantChunksDeleted(ctx context.Context, req TenantDeletionRequest, chunks int) error {
+	return ds.addTenantDeletionStep(ctx, req, chunksDeletedRangeKey, tenantDeletionStep{Time: model.Now(), Chunks: chunks})
+}
+
+// MarkTenantConfigsDeleted records that the rules and Alertmanager
+// configurations of a request have been deleted.
+func (ds *DeleteStore) MarkTenantConfigsDeleted(ctx context.Context, req TenantDeletionRequest) error {
+	return ds.addTenantDeletionStep(ctx, req, configsDeletedRangeKey, tenantDeletionStep{Time: model.Now()})
+}
+
+// MarkTenantDeletionProcessed records that all the data of a request has been
+// deleted.
+func (ds *DeleteStore) MarkTenantDeletionProcessed(ctx context.Context, req TenantDeletionRequest) error {
+	return ds.addTenantDeletionStep(ctx, req, processedRangeKey, tenantDeletionStep{Time: model.Now()})
+}
+
+func (ds *DeleteStore) addTenantDeletionStep(ctx context.Context, req TenantDeletionRequest, key string, step tenantDeletionStep) error {
+	buf, err := json.Marshal(step)
+	if err != nil {
+		return err
+	}
+	batch := ds.indexClient.NewWriteBatch()
+	batch.Add(ds.cfg.RequestsTableName, tenantDeletionRequestsHashValue, rangeValue(req.UserID, req.RequestID, key), buf)
+	return ds.indexClient.BatchWrite(ctx, batch)
+}
+
+// RemoveTenantDeletionRequest removes a request, cancelling it.
+func (ds *DeleteStore) RemoveTenantDeletionRequest(ctx context.Context, req TenantDeletionRequest) error {
+	var entries []chunk.IndexEntry
+	for _, key := range []string{requestRangeKey, chunksDeletedRangeKey, configsDeletedRangeKey, processedRangeKey} {
+		entries = append(entries, chunk.IndexEntry{
+			TableName:  ds.cfg.RequestsTableName,
+			HashValue:  tenantDeletionRequestsHashValue,
+			RangeValue: rangeValue(req.UserID, req.RequestID, key),
+		})
+	}
+	return ds.indexClient.(chunk.IndexDeleter).DeleteEntries(ctx, entries)
+}
+
+// GetTenantDeletionRequest returns a request of a tenant, nil if there's none.
+func (ds *DeleteStore) GetTenantDeletionRequest(ctx context.Context, userID, requestID string) (*TenantDeletionRequest, error) {
+	reqs, err := ds.queryTenantDeletionRequests(ctx, rangeValue(userID, requestID, ""))
+	if err != nil || len(reqs) == 0 {
+		return nil, err
+	}
+	return &reqs[0], nil
+}
+
+// GetTenantDeletionRequestsForUser returns all the requests of a tenant.
+func (ds *DeleteStore) GetTenantDeletionRequestsForUser(ctx context.Context, userID string) ([]TenantDeletionRequest, error) {
+	return ds.queryTenantDeletionRequests(ctx, []byte(userID+rangeSeparator))
+}
+
+// GetPendingTenantDeletionRequests returns the requests of all the tenants
+// which haven't been processed yet.
+func (ds *DeleteStore) GetPendingTenantDeletionRequests(ctx context.Context) ([]TenantDeletionRequest, error) {
+	reqs, err := ds.queryTenantDeletionRequests(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := reqs[:0]
+	for _, req := range reqs {
+		if req.Status != StatusProcessed {
+			filtered = append(filtered, req)
+		}
+	}
+	return filtered, nil
+}
+
+// queryTenantDeletionRequests returns the requests with the given range value
+// prefix, with their progress.
+func (ds *DeleteStore) queryTenantDeletionRequests(ctx context.Context, prefix []byte) ([]TenantDeletionRequest, error) {
+	var (
+		reqs  []TenantDeletionRequest
+		steps = map[string]map[string]tenantDeletionStep{}
+		err   error
+	)
+	query := chunk.IndexQuery{
+		TableName:        ds.cfg.RequestsTableName,
+		HashValue:        tenantDeletionRequestsHashValue,
+		RangeValuePrefix: prefix,
+	}
+	queryErr := ds.indexClient.QueryPages(ctx, []chunk.IndexQuery{query}, func(_ chunk.IndexQuery, batch chunk.ReadBatch) bool {
+		iter := batch.Iterator()
+		for iter.Next() {
+			userID, requestID, key, ok := parseRangeValue(iter.RangeValue())
+			if !ok {
+				continue
+			}
+
+			if key == requestRangeKey {
+				req := TenantDeletionRequest{}
+				if err = json.Unmarshal(iter.Value(), &req); err != nil {
+					return false
+				}
+				req.UserID = userID
+				req.RequestID = requestID
+				reqs = append(reqs, req)
+				continue
+			}
+
+			step := tenantDeletionStep{}
+			if err = json.Unmarshal(iter.Value(), &step); err != nil {
+				return false
+			}
+			id := userID + rangeSeparator + requestID
+			if steps[id] == nil {
+				steps[id] = map[string]tenantDeletionStep{}
+			}
+			steps[id][key] = step
+		}
+		return true
+	})
+	if queryErr != nil {
+		return nil, queryErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range reqs {
+		reqSteps, ok := steps[reqs[i].UserID+rangeSeparator+reqs[i].RequestID]
+		if !ok {
+			continue
+		}
+		reqs[i].Status = StatusDeleting
+		if step, ok := reqSteps[chunksDeletedRangeKey]; ok {
+			reqs[i].ChunksDeleted, reqs[i].ChunksDeletedAt = step.Chunks, step.Time
+		}
+		if step, ok := reqSteps[configsDeletedRangeKey]; ok {
+			reqs[i].ConfigsDeletedAt = step.Time
+		}
+		if step, ok := reqSteps[processedRangeKey]; ok {
+			reqs[i].Status, reqs[i].CompletedAt = StatusProcessed, step.Time
+		}
+	}
+	return reqs, nil
+}
@@ -0,0 +1,121 @@
+package purger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+func TestDeleteStoreTenantDeletionRequests(t *testing.T) {
+	ctx := context.Background()
+	store := newTestDeleteStore(t)
+
+	req1, err := store.AddTenantDeletionRequest(ctx, "user1", "operator", "127.0.0.1:1234")
+	require.NoError(t, err)
+	req2, err := store.AddTenantDeletionRequest(ctx, "user2", "operator", "127.0.0.1:1234")
+	require.NoError(t, err)
+
+	// The tenant deletion requests aren't delete requests.
+	deleteReqs, err := store.GetAllDeleteRequestsForUser(ctx, "user1")
+	require.NoError(t, err)
+	require.Empty(t, deleteReqs)
+
+	reqs, err := store.GetTenantDeletionRequestsForUser(ctx, "user1")
+	require.NoError(t, err)
+	require.Equal(t, []TenantDeletionRequest{req1}, reqs)
+
+	// The progress of the requests is recorded step by step.
+	require.NoError(t, store.MarkTenantChunksDeleted(ctx, req1, 42))
+	got, err := store.GetTenantDeletionRequest(ctx, "user1", req1.RequestID)
+	require.NoError(t, err)
+	require.Equal(t, StatusDeleting, got.Status)
+	require.Equal(t, 42, got.ChunksDeleted)
+	require.NotZero(t, got.ChunksDeletedAt)
+	require.Zero(t, got.ConfigsDeletedAt)
+
+	require.NoError(t, store.MarkTenantConfigsDeleted(ctx, req1))
+	require.NoError(t, store.MarkTenantDeletionProcessed(ctx, req1))
+	got, err = store.GetTenantDeletionRequest(ctx, "user1", req1.RequestID)
+	require.NoError(t, err)
+	require.Equal(t, StatusProcessed, got.Status)
+	require.NotZero(t, got.ConfigsDeletedAt)
+	require.NotZero(t, got.CompletedAt)
+	require.Equal(t, "operator", got.RequestedBy)
+
+	reqs, err = store.GetPendingTenantDeletionRequests(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []TenantDeletionRequest{req2}, reqs)
+
+	// The cancelled requests are removed.
+	require.NoError(t, store.RemoveTenantDeletionRequest(ctx, req2))
+	got, err = store.GetTenantDeletionRequest(ctx, "user2", req2.RequestID)
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
+
+type mockConfigsDeleter []string
+
+func (m *mockConfigsDeleter) DeleteConfig(_ context.Context, userID string) error {
+	*m = append(*m, userID)
+	return nil
+}
+
+func TestPurgerTenantDeletion(t *testing.T) {
+	chunkStore := newTestChunkStore(t)
+	deleteStore := newTestDeleteStore(t)
+	configs := &mockConfigsDeleter{}
+
+	hour := model.TimeFromUnix(3600)
+	for _, tenant := range []string{userID, "other"} {
+		c := newTestChunk(t, 0, hour)
+		c = chunk.NewChunk(tenant, c.Fingerprint, c.Metric, c.Data, c.From, c.Through)
+		require.NoError(t, c.Encode())
+		require.NoError(t, chunkStore.Put(user.InjectOrgID(context.Background(), tenant), []chunk.Chunk{c}))
+	}
+
+	ctx := context.Background()
+	_, err := deleteStore.AddDeleteRequest(ctx, userID, 0, hour, []string{`foo`})
+	require.NoError(t, err)
+	req, err := deleteStore.AddTenantDeletionRequest(ctx, userID, "operator", "127.0.0.1:1234")
+	require.NoError(t, err)
+
+	purger := NewPurger(Config{DeleteRequestCancelPeriod: time.Hour}, deleteStore, chunkStore, configs)
+
+	// The requests aren't processed before the end of their cancel period.
+	require.NoError(t, purger.ProcessTenantDeletionRequests(ctx))
+	got, err := deleteStore.GetTenantDeletionRequest(ctx, userID, req.RequestID)
+	require.NoError(t, err)
+	require.Equal(t, StatusReceived, got.Status)
+
+	purger.cfg.DeleteRequestCancelPeriod = 0
+	require.NoError(t, purger.ProcessTenantDeletionRequests(ctx))
+	got, err = deleteStore.GetTenantDeletionRequest(ctx, userID, req.RequestID)
+	require.NoError(t, err)
+	require.Equal(t, StatusProcessed, got.Status)
+	require.Equal(t, 1, got.ChunksDeleted)
+	require.Equal(t, []string{userID}, []string(*configs))
+
+	matcher := mustNewMatcher(labels.MatchEqual, labels.MetricName, "foo")
+	chunks, err := chunkStore.Get(user.InjectOrgID(ctx, userID), userID, 0, 2*hour, matcher)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+	deleteReqs, err := deleteStore.GetAllDeleteRequestsForUser(ctx, userID)
+	require.NoError(t, err)
+	require.Empty(t, deleteReqs)
+
+	// The data of the other tenants is kept.
+	chunks, err = chunkStore.Get(user.InjectOrgID(ctx, "other"), "other", 0, 2*hour, matcher)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+
+	// The processed requests aren't processed again.
+	require.NoError(t, purger.ProcessTenantDeletionRequests(ctx))
+	require.Len(t, *configs, 1)
+}
@@ -0,0 +1,102 @@
+package chunk
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// QueryShardLabel is the name of the pseudo label of the matcher selecting a
+// shard of the series of a query, e.g. __query_shard__="1_of_16".
+const QueryShardLabel = "__query_shard__"
+
+// QueryShard is a shard of the series of a query: the series whose ID, the
+// hash of their labels, is Index modulo Of. This matches the row shards of the
+// index of the v10 schema when Of divides its number of row shards.
+type QueryShard struct {
+	Index, Of uint32
+}
+
+func (s QueryShard) String() string {
+	return fmt.Sprintf("%d_of_%d", s.Index, s.Of)
+}
+
+// ParseQueryShard parses the value of a query shard matcher.
+func ParseQueryShard(value string) (QueryShard, error) {
+	var s QueryShard
+	if _, err := fmt.Sscanf(value, "%d_of_%d", &s.Index, &s.Of); err != nil || s.Of == 0 || s.Index >= s.Of || s.String() != value {
+		return QueryShard{}, fmt.Errorf("invalid query shard %q", value)
+	}
+	return s, nil
+}
+
+// ExtractQueryShard returns the query shard of the matchers, nil if none, and
+// the other matchers.
+func ExtractQueryShard(matchers []*labels.Matcher) (*QueryShard, []*labels.Matcher, error) {
+	for i, m := range matchers {
+		if m.Name != QueryShardLabel {
+			continue
+		}
+		if m.Type != labels.MatchEqual {
+			return nil, nil, fmt.Errorf("the %s matcher must be an equality matcher", QueryShardLabel)
+		}
+		shard, err := ParseQueryShard(m.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result := make([]*labels.Matcher, 0, len(matchers)-1)
+		result = append(result, matchers[:i]...)
+		result = append(result, matchers[i+1:]...)
+		return &shard, result, nil
+	}
+	return nil, matchers, nil
+}
+
+// MatchesSeriesID returns whether the series with the given ID is in the
+// shard; a nil QueryShard matches all the series.
+func (s *QueryShard) MatchesSeriesID(seriesID []byte) bool {
+	if s == nil {
+		return true
+	}
+	// Like the row shards of the v10 schema, from the first 32 bits of the ID.
+	return binary.BigEndian.Uint32(seriesID)%s.Of == s.Index
+}
+
+// MatchesLabels returns whether the series with the given labels is in the
+// shard; a nil QueryShard matches all the series.
+func (s *QueryShard) MatchesLabels(ls labels.Labels) bool {
+	if s == nil {
+		return true
+	}
+	return s.MatchesSeriesID(labelsSeriesID(ls))
+}
+
+func (s *QueryShard) filterSeriesIDs(seriesIDs []string) []string {
+	if s == nil {
+		return seriesIDs
+	}
+
+	result := seriesIDs[:0]
+	for _, seriesID := range seriesIDs {
+		if s.MatchesSeriesID([]byte(seriesID)) {
+			result = append(result, seriesID)
+		}
+	}
+	return result
+}
+
+func filterChunksByQueryShard(chunks []Chunk, shard *QueryShard) []Chunk {
+	if shard == nil {
+		return chunks
+	}
+
+	result := make([]Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		if shard.MatchesLabels(c.Metric) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package chunk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQueryShard(t *testing.T) {
+	shard, err := ParseQueryShard("3_of_16")
+	require.NoError(t, err)
+	assert.Equal(t, QueryShard{Index: 3, Of: 16}, shard)
+
+	for _, value := range []string{"", "3", "16_of_16", "1_of_0", "01_of_16", "1_of_16x"} {
+		_, err := ParseQueryShard(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestExtractQueryShard(t *testing.T) {
+	name := mustNewLabelMatcher(labels.MatchEqual, labels.MetricName, "foo")
+	shard, matchers, err := ExtractQueryShard([]*labels.Matcher{
+		name,
+		mustNewLabelMatcher(labels.MatchEqual, QueryShardLabel, "1_of_2"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &QueryShard{Index: 1, Of: 2}, shard)
+	assert.Equal(t, []*labels.Matcher{name}, matchers)
+
+	shard, matchers, err = ExtractQueryShard([]*labels.Matcher{name})
+	require.NoError(t, err)
+	assert.Nil(t, shard)
+	assert.Equal(t, []*labels.Matcher{name}, matchers)
+
+	_, _, err = ExtractQueryShard([]*labels.Matcher{
+		mustNewLabelMatcher(labels.MatchRegexp, QueryShardLabel, "1_of_2"),
+	})
+	assert.Error(t, err)
+}
+
+func TestQueryShardMatchesLabels(t *testing.T) {
+	const shards = 4
+	var all []labels.Labels
+	for _, v := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		all = append(all, labels.Labels{{Name: labels.MetricName, Value: "foo"}, {Name: "bar", Value: v}})
+	}
+
+	// Each series is in exactly one shard.
+	for _, ls := range all {
+		matches := 0
+		for i := uint32(0); i < shards; i++ {
+			if (&QueryShard{Index: i, Of: shards}).MatchesLabels(ls) {
+				matches++
+			}
+		}
+		assert.Equal(t, 1, matches, ls.String())
+
+		var shard *QueryShard
+		assert.True(t, shard.MatchesLabels(ls))
+	}
+}
+
+func TestChunkStore_QueryShard(t *testing.T) {
+	ctx := context.Background()
+	now := model.Now()
+
+	var chunks []Chunk
+	for i := 0; i < 8; i++ {
+		chunks = append(chunks, dummyChunkFor(now, labels.Labels{
+			{Name: labels.MetricName, Value: "foo"},
+			{Name: "bar", Value: fmt.Sprint(i)},
+		}))
+	}
+
+	const shards = 4
+	for _, schema := range schemas {
+		t.Run(schema.name, func(t *testing.T) {
+			store := newTestChunkStore(t, schema.name)
+			defer store.Stop()
+			require.NoError(t, store.Put(ctx, chunks))
+
+			total := 0
+			for i := uint32(0); i < shards; i++ {
+				shard := QueryShard{Index: i, Of: shards}
+				result, err := store.Get(ctx, userID, now.Add(-time.Hour), now,
+					mustNewLabelMatcher(labels.MatchEqual, labels.MetricName, "foo"),
+					mustNewLabelMatcher(labels.MatchEqual, QueryShardLabel, shard.String()))
+				require.NoError(t, err)
+				for _, c := range result {
+					assert.True(t, shard.MatchesLabels(c.Metric))
+				}
+				total += len(result)
+			}
+			assert.Equal(t, len(chunks), total)
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"strings"
 
 	"github.com/prometheus/common/model"
@@ -645,47 +646,12 @@ func (s v10Entries) GetLabelWriteEntries(bucket Bucket, metricName string, label
 	// read first 32 bits of the hash and use this to calculate the shard
 	shard := binary.BigEndian.Uint32(seriesID) % s.rowShards
 
-	entries := []IndexEntry{
-		// Entry for metricName -> seriesID
-		{
-			TableName:  bucket.tableName,
-			HashValue:  fmt.Sprintf("%02d:%s:%s", shard, bucket.hashKey, metricName),
-			RangeValue: encodeRangeKey(seriesID, nil, nil, seriesRangeKeyV1),
-		},
-	}
-
-	// Entries for metricName:labelName -> hash(value):seriesID
-	// We use a hash of the value to limit its length.
-	for _, v := range labels {
-		if v.Name == model.MetricNameLabel {
-			continue
-		}
-		valueHash := sha256bytes(v.Value)
-		entries = append(entries, IndexEntry{
-			TableName:  bucket.tableName,
-			HashValue:  fmt.Sprintf("%02d:%s:%s:%s", shard, bucket.hashKey, metricName, v.Name),
-			RangeValue: encodeRangeKey(valueHash, seriesID, nil, labelSeriesRangeKeyV1),
-			Value:      []byte(v.Value),
-		})
-	}
-
-	return entries, nil
+	return shardedLabelWriteEntries(bucket, metricName, labels, seriesID, shard), nil
 }
 
 func (v10Entries) GetChunkWriteEntries(bucket Bucket, metricName string, labels labels.Labels, chunkID string) ([]IndexEntry, error) {
 	seriesID := labelsSeriesID(labels)
-	encodedThroughBytes := encodeTime(bucket.through)
-
-	entries := []IndexEntry{
-		// Entry for seriesID -> chunkID
-		{
-			TableName:  bucket.tableName,
-			HashValue:  bucket.hashKey + ":" + string(seriesID),
-			RangeValue: encodeRangeKey(encodedThroughBytes, nil, []byte(chunkID), chunkTimeRangeKeyV3),
-		},
-	}
-
-	return entries, nil
+	return seriesChunkWriteEntries(bucket, bucket.hashKey+":"+string(seriesID), chunkID), nil
 }
 
 func (s v10Entries) GetReadMetricQueries(bucket Bucket, metricName string) ([]IndexQuery, error) {
@@ -725,12 +691,96 @@ func (s v10Entries) GetReadMetricLabelValueQueries(bucket Bucket, metricName str
 }
 
 func (v10Entries) GetChunksForSeries(bucket Bucket, seriesID []byte) ([]IndexQuery, error) {
+	return seriesChunksQueries(bucket, bucket.hashKey+":"+string(seriesID)), nil
+}
+
+// shardedLabelWriteEntries returns the entries of the labels of a series, in
+// the given row shard, of the sharded schemas.
+func shardedLabelWriteEntries(bucket Bucket, metricName string, labels labels.Labels, seriesID []byte, shard uint32) []IndexEntry {
+	entries := []IndexEntry{
+		// Entry for metricName -> seriesID
+		{
+			TableName:  bucket.tableName,
+			HashValue:  fmt.Sprintf("%02d:%s:%s", shard, bucket.hashKey, metricName),
+			RangeValue: encodeRangeKey(seriesID, nil, nil, seriesRangeKeyV1),
+		},
+	}
+
+	// Entries for metricName:labelName -> hash(value):seriesID
+	// We use a hash of the value to limit its length.
+	for _, v := range labels {
+		if v.Name == model.MetricNameLabel {
+			continue
+		}
+		valueHash := sha256bytes(v.Value)
+		entries = append(entries, IndexEntry{
+			TableName:  bucket.tableName,
+			HashValue:  fmt.Sprintf("%02d:%s:%s:%s", shard, bucket.hashKey, metricName, v.Name),
+			RangeValue: encodeRangeKey(valueHash, seriesID, nil, labelSeriesRangeKeyV1),
+			Value:      []byte(v.Value),
+		})
+	}
+	return entries
+}
+
+// seriesChunkWriteEntries returns the entry of a chunk in the row of its
+// series, of the v10 and later schemas.
+func seriesChunkWriteEntries(bucket Bucket, seriesHashValue string, chunkID string) []IndexEntry {
+	encodedThroughBytes := encodeTime(bucket.through)
+	return []IndexEntry{
+		// Entry for seriesID -> chunkID
+		{
+			TableName:  bucket.tableName,
+			HashValue:  seriesHashValue,
+			RangeValue: encodeRangeKey(encodedThroughBytes, nil, []byte(chunkID), chunkTimeRangeKeyV3),
+		},
+	}
+}
+
+// seriesChunksQueries returns the query of the chunks in the row of a series,
+// of the v10 and later schemas.
+func seriesChunksQueries(bucket Bucket, seriesHashValue string) []IndexQuery {
 	encodedFromBytes := encodeTime(bucket.from)
 	return []IndexQuery{
 		{
 			TableName:       bucket.tableName,
-			HashValue:       bucket.hashKey + ":" + string(seriesID),
+			HashValue:       seriesHashValue,
 			RangeValueStart: encodeRangeKey(encodedFromBytes),
 		},
-	}, nil
+	}
+}
+
+// v12Entries builds on v10 by sharding the rows of the series too, and by
+// picking the shard of a series from the hash of its ID along with the tenant
+// and the day of the bucket. The rows of the series a tenant writes on a day
+// are spread over the shards, rather than being contiguous in BigTable, and
+// the heavy series move to another shard every day.
+type v12Entries struct {
+	v10Entries
+}
+
+// shard returns the row shard of a series in a bucket.
+func (s v12Entries) shard(bucket Bucket, seriesID []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(bucket.hashKey))
+	_, _ = h.Write(seriesID)
+	return h.Sum32() % s.rowShards
+}
+
+// seriesHashValue returns the hash value of the row of the chunks of a series.
+func (s v12Entries) seriesHashValue(bucket Bucket, seriesID []byte) string {
+	return fmt.Sprintf("%02d:%s:%s", s.shard(bucket, seriesID), bucket.hashKey, seriesID)
+}
+
+func (s v12Entries) GetLabelWriteEntries(bucket Bucket, metricName string, labels labels.Labels, chunkID string) ([]IndexEntry, error) {
+	seriesID := labelsSeriesID(labels)
+	return shardedLabelWriteEntries(bucket, metricName, labels, seriesID, s.shard(bucket, seriesID)), nil
+}
+
+func (s v12Entries) GetChunkWriteEntries(bucket Bucket, metricName string, labels labels.Labels, chunkID string) ([]IndexEntry, error) {
+	return seriesChunkWriteEntries(bucket, s.seriesHashValue(bucket, labelsSeriesID(labels)), chunkID), nil
+}
+
+func (s v12Entries) GetChunksForSeries(bucket Bucket, seriesID []byte) ([]IndexQuery, error) {
+	return seriesChunksQueries(bucket, s.seriesHashValue(bucket, seriesID)), nil
 }
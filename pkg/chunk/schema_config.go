@@ -3,7 +3,9 @@ package chunk
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"strconv"
 	"time"
 
@@ -63,6 +65,9 @@ func (d *DayTime) UnmarshalYAML(unmarshal func(interface{}) error) error {
 type SchemaConfig struct {
 	Configs []PeriodConfig `yaml:"configs"`
 
+	// Reload the file of the config on SIGHUP.
+	ReloadOnSIGHUP bool `yaml:"-"`
+
 	fileName string
 	legacy   LegacySchemaConfig // if fileName is set then legacy config is ignored
 }
@@ -93,6 +98,7 @@ type LegacySchemaConfig struct {
 // RegisterFlags adds the flags required to config this to the given FlagSet.
 func (cfg *SchemaConfig) RegisterFlags(f *flag.FlagSet) {
 	flag.StringVar(&cfg.fileName, "config-yaml", "", "Schema config yaml")
+	f.BoolVar(&cfg.ReloadOnSIGHUP, "config-yaml.reload-on-sighup", false, "Reload the schema config yaml on SIGHUP, adding its new periods, which must start in the future, to the stores and the table manager.")
 	cfg.legacy.RegisterFlags(f)
 }
 
@@ -207,18 +213,54 @@ func (cfg PeriodConfig) CreateSchema() Schema {
 	case "v9":
 		s = schema{cfg.dailyBuckets, v9Entries{}}
 	case "v10":
-		rowShards := uint32(16)
-		if cfg.RowShards > 0 {
-			rowShards = cfg.RowShards
-		}
-
 		s = schema{cfg.dailyBuckets, v10Entries{
-			rowShards: rowShards,
+			rowShards: cfg.rowShards(),
 		}}
+	case "v12":
+		s = schema{cfg.dailyBuckets, v12Entries{v10Entries{
+			rowShards: cfg.rowShards(),
+		}}}
 	}
 	return s
 }
 
+// rowShards returns the number of row shards of the sharded schemas, 16 by
+// default.
+func (cfg PeriodConfig) rowShards() uint32 {
+	if cfg.RowShards > 0 {
+		return cfg.RowShards
+	}
+	return 16
+}
+
+func (cfg PeriodConfig) validate() error {
+	switch cfg.Schema {
+	case "v1", "v2", "v3", "v4", "v5", "v6", "v9":
+		if cfg.RowShards > 0 {
+			return fmt.Errorf("schema %s of the period from %s doesn't have row shards", cfg.Schema, cfg.From.Time.Time().Format("2006-01-02"))
+		}
+	case "v10", "v12":
+	default:
+		return fmt.Errorf("unknown schema %q of the period from %s", cfg.Schema, cfg.From.Time.Time().Format("2006-01-02"))
+	}
+	return nil
+}
+
+// Validate the schema config: the periods must have known schemas, and be
+// in order. Migrating to another schema, or number of row shards, takes a
+// new period starting after the tables of the new period are created.
+func (cfg *SchemaConfig) Validate() error {
+	for i, period := range cfg.Configs {
+		if err := period.validate(); err != nil {
+			return err
+		}
+		if i > 0 && period.From.Time < cfg.Configs[i-1].From.Time {
+			return fmt.Errorf("the period from %s starts before the previous one", period.From.Time.Time().Format("2006-01-02"))
+		}
+	}
+	return nil
+}
+
 // Load the yaml file, or build the config from legacy command-line flags
 func (cfg *SchemaConfig) Load() error {
 	if len(cfg.Configs) > 0 {
@@ -232,10 +274,67 @@ func (cfg *SchemaConfig) Load() error {
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
 	decoder := yaml.NewDecoder(f)
 	decoder.SetStrict(true)
-	return decoder.Decode(&cfg)
+	if err := decoder.Decode(&cfg); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+// Reread reads the yaml file of the config again, returning the new config.
+func (cfg *SchemaConfig) Reread() (SchemaConfig, error) {
+	if cfg.fileName == "" {
+		return SchemaConfig{}, fmt.Errorf("the schema config isn't loaded from a file, -config-yaml")
+	}
+	buf, err := ioutil.ReadFile(cfg.fileName)
+	if err != nil {
+		return SchemaConfig{}, err
+	}
+	next, err := ParseSchemaConfig(buf)
+	if err != nil {
+		return SchemaConfig{}, err
+	}
+	next.fileName = cfg.fileName
+	next.ReloadOnSIGHUP = cfg.ReloadOnSIGHUP
+	return next, nil
+}
+
+// ParseSchemaConfig parses and validates a schema config yaml.
+func ParseSchemaConfig(buf []byte) (SchemaConfig, error) {
+	var cfg SchemaConfig
+	if err := yaml.UnmarshalStrict(buf, &cfg); err != nil {
+		return SchemaConfig{}, err
+	}
+	if len(cfg.Configs) == 0 {
+		return SchemaConfig{}, fmt.Errorf("no period in the schema config")
+	}
+	return cfg, cfg.Validate()
+}
+
+// ValidateUpdate checks the config can be updated to next while in use: next
+// must have the same periods, then new periods starting after now, as the
+// chunks already written must still be found with their period.
+func (cfg *SchemaConfig) ValidateUpdate(next SchemaConfig, now model.Time) error {
+	if err := next.Validate(); err != nil {
+		return err
+	}
+	if len(next.Configs) < len(cfg.Configs) {
+		return fmt.Errorf("%d periods in the new schema config, fewer than the %d current ones: the periods can't be removed", len(next.Configs), len(cfg.Configs))
+	}
+	for i, period := range cfg.Configs {
+		if !reflect.DeepEqual(period, next.Configs[i]) {
+			return fmt.Errorf("the period from %s is changed in the new schema config: only new periods can be added", period.From.Time.Time().Format("2006-01-02"))
+		}
+	}
+	for _, period := range next.Configs[len(cfg.Configs):] {
+		if period.From.Time <= now {
+			return fmt.Errorf("the new period from %s doesn't start in the future", period.From.Time.Time().Format("2006-01-02"))
+		}
+	}
+	return nil
 }
 
 // PrintYaml dumps the yaml to stdout, to aid in migration
@@ -442,6 +541,32 @@ func (cfg SchemaConfig) ChunkTableFor(t model.Time) (string, error) {
 	return "", fmt.Errorf("no chunk table found for time %v", t)
 }
 
+// ChunkTables returns the names of the chunk tables of the periods up to
+// through, each once.
+func (cfg SchemaConfig) ChunkTables(through model.Time) []string {
+	var (
+		names []string
+		seen  = map[string]bool{}
+	)
+	for i := range cfg.Configs {
+		from := cfg.Configs[i].From.Time
+		if from.After(through) {
+			break
+		}
+		end := through
+		if i+1 < len(cfg.Configs) && cfg.Configs[i+1].From.Time.Before(through) {
+			end = cfg.Configs[i+1].From.Time.Add(-time.Millisecond)
+		}
+		for _, name := range cfg.Configs[i].ChunkTables.tables(from, end) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
 // TableFor calculates the table shard for a given point in time.
 func (cfg *PeriodicTableConfig) TableFor(t model.Time) string {
 	if cfg.Period == 0 { // non-periodic
@@ -451,6 +576,19 @@ func (cfg *PeriodicTableConfig) TableFor(t model.Time) string {
 	return cfg.tableForPeriod(t.Unix() / periodSecs)
 }
 
+// tables returns the names of the tables between from and through.
+func (cfg *PeriodicTableConfig) tables(from, through model.Time) []string {
+	if cfg.Period == 0 {
+		return []string{cfg.Prefix}
+	}
+	periodSecs := int64(cfg.Period / time.Second)
+	var names []string
+	for i := from.Unix() / periodSecs; i <= through.Unix()/periodSecs; i++ {
+		names = append(names, cfg.tableForPeriod(i))
+	}
+	return names
+}
+
 func (cfg *PeriodicTableConfig) tableForPeriod(i int64) string {
 	return cfg.Prefix + strconv.Itoa(int(i))
 }
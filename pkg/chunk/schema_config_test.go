@@ -270,6 +270,33 @@ func TestChunkTableFor(t *testing.T) {
 	}
 }
 
+func TestChunkTables(t *testing.T) {
+	schemaCfg := SchemaConfig{
+		Configs: []PeriodConfig{
+			{
+				From:        MustParseDayTime("2019-01-01"),
+				ChunkTables: PeriodicTableConfig{Prefix: "chunks_1_", Period: 168 * time.Hour},
+			},
+			{
+				From:        MustParseDayTime("2019-01-15"),
+				ChunkTables: PeriodicTableConfig{Prefix: "chunks_2_", Period: 168 * time.Hour},
+			},
+			{
+				From:        MustParseDayTime("2019-03-01"),
+				ChunkTables: PeriodicTableConfig{Prefix: "chunks_3_", Period: 168 * time.Hour},
+			},
+		},
+	}
+
+	through := MustParseDayTime("2019-01-22").Time
+	require.Equal(t, []string{"chunks_1_2556", "chunks_1_2557", "chunks_1_2558", "chunks_2_2558", "chunks_2_2559"}, schemaCfg.ChunkTables(through))
+
+	// The non-periodic tables are listed once.
+	schemaCfg.Configs[1].ChunkTables = PeriodicTableConfig{Prefix: "chunks"}
+	schemaCfg.Configs[0].ChunkTables = PeriodicTableConfig{Prefix: "chunks"}
+	require.Equal(t, []string{"chunks"}, schemaCfg.ChunkTables(through))
+}
+
 func MustParseDayTime(s string) DayTime {
 	t, err := time.Parse("2006-01-02", s)
 	if err != nil {
@@ -277,3 +304,107 @@ func MustParseDayTime(s string) DayTime {
 	}
 	return DayTime{model.TimeFromUnix(t.Unix())}
 }
+
+func TestSchemaConfigValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		configs []PeriodConfig
+		valid   bool
+	}{
+		"migration to v12": {
+			configs: []PeriodConfig{
+				{From: MustParseDayTime("2019-01-01"), Schema: "v9"},
+				{From: MustParseDayTime("2019-06-01"), Schema: "v10", RowShards: 16},
+				{From: MustParseDayTime("2019-10-01"), Schema: "v12", RowShards: 32},
+			},
+			valid: true,
+		},
+		"unknown schema": {
+			configs: []PeriodConfig{{From: MustParseDayTime("2019-01-01"), Schema: "v11"}},
+		},
+		"row shards of an unsharded schema": {
+			configs: []PeriodConfig{{From: MustParseDayTime("2019-01-01"), Schema: "v9", RowShards: 16}},
+		},
+		"periods out of order": {
+			configs: []PeriodConfig{
+				{From: MustParseDayTime("2019-06-01"), Schema: "v10"},
+				{From: MustParseDayTime("2019-01-01"), Schema: "v12"},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := SchemaConfig{Configs: tc.configs}
+			err := cfg.Validate()
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSchemaConfigValidateUpdate(t *testing.T) {
+	now := MustParseDayTime("2019-08-01").Time
+	current := SchemaConfig{Configs: []PeriodConfig{
+		{From: MustParseDayTime("2019-01-01"), Schema: "v9"},
+		{From: MustParseDayTime("2019-06-01"), Schema: "v10", RowShards: 16},
+	}}
+
+	for name, tc := range map[string]struct {
+		configs []PeriodConfig
+		valid   bool
+	}{
+		"unchanged": {
+			configs: current.Configs,
+			valid:   true,
+		},
+		"new period in the future": {
+			configs: append(current.Configs[:2:2], PeriodConfig{From: MustParseDayTime("2019-10-01"), Schema: "v10", RowShards: 16}),
+			valid:   true,
+		},
+		"new period in the past": {
+			configs: append(current.Configs[:2:2], PeriodConfig{From: MustParseDayTime("2019-07-01"), Schema: "v10", RowShards: 16}),
+		},
+		"period removed": {
+			configs: current.Configs[:1],
+		},
+		"period changed": {
+			configs: []PeriodConfig{
+				{From: MustParseDayTime("2019-01-01"), Schema: "v9"},
+				{From: MustParseDayTime("2019-06-01"), Schema: "v10", RowShards: 32},
+			},
+		},
+		"invalid new period": {
+			configs: append(current.Configs[:2:2], PeriodConfig{From: MustParseDayTime("2019-10-01"), Schema: "v11"}),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := current.ValidateUpdate(SchemaConfig{Configs: tc.configs}, now)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestParseSchemaConfig(t *testing.T) {
+	cfg, err := ParseSchemaConfig([]byte(`
+configs:
+- from: 2019-01-01
+  store: inmemory
+  schema: v10
+  index:
+    prefix: index_
+    period: 168h
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Configs, 1)
+	require.Equal(t, "inmemory", cfg.Configs[0].IndexType)
+
+	_, err = ParseSchemaConfig([]byte("configs: []\n"))
+	require.Error(t, err)
+	_, err = ParseSchemaConfig([]byte("configs:\n- from: 2019-01-01\n  schema: v10\n  unknown: true\n"))
+	require.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
@@ -333,3 +334,62 @@ func TestSchemaRangeKey(t *testing.T) {
 		})
 	}
 }
+
+func TestV12SchemaShards(t *testing.T) {
+	const userID = "userid"
+	schema := PeriodConfig{
+		Schema:      "v12",
+		IndexTables: PeriodicTableConfig{Prefix: table},
+		RowShards:   4,
+	}.CreateSchema()
+
+	day := model.TimeFromUnix(24 * 3600)
+	shards := map[string]bool{}
+	for i := 0; i < 32; i++ {
+		metric := labels.Labels{
+			{Name: model.MetricNameLabel, Value: "foo"},
+			{Name: "bar", Value: fmt.Sprintf("%d", i)},
+		}
+		seriesID := labelsSeriesID(metric)
+
+		// The series entries are in the same shard as the label entries,
+		// and found by the queries.
+		_, labelEntries, err := schema.GetCacheKeysAndLabelWriteEntries(day, day.Add(time.Hour), userID, "foo", metric, "chunkID")
+		require.NoError(t, err)
+		require.Len(t, labelEntries, 1)
+		shard := labelEntries[0][0].HashValue[:3]
+		shards[shard] = true
+
+		chunkEntries, err := schema.GetChunkWriteEntries(day, day.Add(time.Hour), userID, "foo", metric, "chunkID")
+		require.NoError(t, err)
+		require.Len(t, chunkEntries, 1)
+		require.Equal(t, shard+"userid:d1:"+string(seriesID), chunkEntries[0].HashValue)
+
+		queries, err := schema.GetChunksForSeries(day, day.Add(time.Hour), userID, seriesID)
+		require.NoError(t, err)
+		require.Len(t, queries, 1)
+		require.Equal(t, chunkEntries[0].HashValue, queries[0].HashValue)
+
+		queries, err = schema.GetReadQueriesForMetric(day, day.Add(time.Hour), userID, "foo")
+		require.NoError(t, err)
+		require.Len(t, queries, 4)
+		found := false
+		for _, q := range queries {
+			found = found || q.HashValue == labelEntries[0][0].HashValue
+		}
+		require.True(t, found)
+	}
+	// The series are spread over all the shards.
+	require.Len(t, shards, 4)
+
+	// The shard of a series depends on the day too.
+	metric := labels.Labels{{Name: model.MetricNameLabel, Value: "foo"}}
+	days := map[string]bool{}
+	for i := int64(0); i < 16; i++ {
+		from := model.TimeFromUnix(i * 24 * 3600)
+		entries, err := schema.GetChunkWriteEntries(from, from.Add(time.Hour), userID, "foo", metric, "chunkID")
+		require.NoError(t, err)
+		days[entries[0].HashValue[:3]] = true
+	}
+	require.True(t, len(days) > 1)
+}
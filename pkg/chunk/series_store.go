@@ -50,6 +50,16 @@ var (
 		// A reasonable upper bound is around 100k - 10*(8^(6-1)) = 327k.
 		Buckets: prometheus.ExponentialBuckets(10, 8, 6),
 	})
+	storedChunks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "chunk_store_stored_chunks_total",
+		Help:      "Total count of chunks stored, with their index entries.",
+	})
+	dedupedChunks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "chunk_store_deduped_chunks_total",
+		Help:      "Total count of chunks not stored, as they were found in the chunk or write dedupe caches, e.g. as stored by another replica.",
+	})
 	chunksPerQuery = promauto.NewHistogram(prometheus.HistogramOpts{
 		Namespace: "cortex",
 		Name:      "chunk_store_chunks_per_query",
@@ -66,7 +76,7 @@ type seriesStore struct {
 }
 
 func newSeriesStore(cfg StoreConfig, schema Schema, index IndexClient, chunks ObjectClient, limits StoreLimits) (Store, error) {
-	fetcher, err := NewChunkFetcher(cfg.ChunkCacheConfig, cfg.chunkCacheStubs, chunks)
+	fetcher, err := newStoreFetcher(cfg, chunks)
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +116,8 @@ func (c *seriesStore) Get(ctx context.Context, userID string, from, through mode
 	if err != nil {
 		return nil, err
 	}
+	// The query shard is only used to select the series.
+	_, allMatchers, _ = ExtractQueryShard(allMatchers)
 
 	if len(chks) == 0 {
 		// Shortcut
@@ -117,10 +129,13 @@ func (c *seriesStore) Get(ctx context.Context, userID string, from, through mode
 	// Protect ourselves against OOMing.
 	maxChunksPerQuery := c.limits.MaxChunksPerQuery(userID)
 	if maxChunksPerQuery > 0 && len(chunks) > maxChunksPerQuery {
-		err := httpgrpc.Errorf(http.StatusBadRequest, "Query %v fetched too many chunks (%d > %d)", allMatchers, len(chunks), maxChunksPerQuery)
+		err := util.LimitError(fmt.Sprintf("Query %v fetched too many chunks (%d > %d)", allMatchers, len(chunks), maxChunksPerQuery))
 		level.Error(log).Log("err", err)
 		return nil, err
 	}
+	if err := LimitChunkRefs(ctx, chunks); err != nil {
+		return nil, err
+	}
 
 	// Now fetch the actual chunk data from Memcache / S3
 	keys := keysFromChunks(chunks)
@@ -139,6 +154,11 @@ func (c *seriesStore) GetChunkRefs(ctx context.Context, userID string, from, thr
 	log, ctx := spanlogger.New(ctx, "SeriesStore.GetChunkRefs")
 	defer log.Span.Finish()
 
+	shard, allMatchers, err := ExtractQueryShard(allMatchers)
+	if err != nil {
+		return nil, nil, httpgrpc.Errorf(http.StatusBadRequest, "%v", err)
+	}
+
 	// Validate the query is within reasonable bounds.
 	metricName, matchers, shortcut, err := c.validateQuery(ctx, userID, &from, &through, allMatchers)
 	if err != nil {
@@ -156,6 +176,9 @@ func (c *seriesStore) GetChunkRefs(ctx context.Context, userID string, from, thr
 	if err != nil {
 		return nil, nil, err
 	}
+	// Only the chunks of the series of the shard of the query, if any, are
+	// looked up and fetched.
+	seriesIDs = shard.filterSeriesIDs(seriesIDs)
 	level.Debug(log).Log("series-ids", len(seriesIDs))
 
 	// Lookup the series in the index to get the chunks.
@@ -179,6 +202,74 @@ func (c *seriesStore) GetChunkRefs(ctx context.Context, userID string, from, thr
 	return [][]Chunk{chunks}, []*Fetcher{c.store.Fetcher}, nil
 }
 
+// GetSeries returns the labels of the series matching the matchers, looked up
+// in the index, fetching a single chunk of each series for its labels.
+func (c *seriesStore) GetSeries(ctx context.Context, userID string, from, through model.Time, allMatchers ...*labels.Matcher) ([]labels.Labels, error) {
+	log, ctx := spanlogger.New(ctx, "SeriesStore.GetSeries")
+	defer log.Span.Finish()
+	level.Debug(log).Log("from", from, "through", through, "matchers", len(allMatchers))
+
+	chks, fetchers, err := c.GetChunkRefs(ctx, userID, from, through, allMatchers...)
+	if err != nil {
+		return nil, err
+	}
+	_, allMatchers, _ = ExtractQueryShard(allMatchers)
+
+	if len(chks) == 0 {
+		// Shortcut
+		return nil, nil
+	}
+
+	// All the chunks of a series have its labels, one of them is enough.
+	chunks, keys := filterChunksByUniqueFingerprint(chks[0])
+	allChunks, err := fetchers[0].FetchChunks(ctx, chunks, keys)
+	if err != nil {
+		level.Error(log).Log("msg", "FetchChunks", "err", err)
+		return nil, err
+	}
+
+	allChunks = filterChunksByMatchers(allChunks, allMatchers)
+	result := make([]labels.Labels, 0, len(allChunks))
+	for _, chunk := range allChunks {
+		result = append(result, chunk.Metric)
+	}
+	return result, nil
+}
+
+// LabelValuesForMetricName retrieves all label values for a single label name
+// and metric name, of the series matching the matchers if any.
+func (c *seriesStore) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName, labelName string, matchers ...*labels.Matcher) ([]string, error) {
+	log, ctx := spanlogger.New(ctx, "SeriesStore.LabelValuesForMetricName")
+	defer log.Span.Finish()
+	level.Debug(log).Log("from", from, "through", through, "metricName", metricName, "labelName", labelName, "matchers", len(matchers))
+
+	shortcut, err := c.validateQueryTimeRange(ctx, userID, &from, &through)
+	if err != nil {
+		return nil, err
+	} else if shortcut {
+		return nil, nil
+	}
+
+	filters, matchers := util.SplitFiltersAndMatchers(matchers)
+	if len(filters) > 0 {
+		// The empty values can't be looked up in the index.
+		series, err := c.GetSeries(ctx, userID, from, through, WithMetricName(metricName, append(filters, matchers...))...)
+		if err != nil {
+			return nil, err
+		}
+		return labelValuesFromSeries(series, labelName), nil
+	}
+
+	var seriesIDs []string
+	if len(matchers) > 0 {
+		seriesIDs, err = c.lookupSeriesByMetricNameMatchers(ctx, from, through, userID, metricName, matchers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.lookupLabelValues(ctx, userID, from, through, metricName, labelName, seriesIDs, len(matchers) > 0)
+}
+
 // LabelNamesForMetricName retrieves all label names for a metric name.
 func (c *seriesStore) LabelNamesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string) ([]string, error) {
 	log, ctx := spanlogger.New(ctx, "SeriesStore.LabelNamesForMetricName")
@@ -382,6 +473,15 @@ func (c *seriesStore) PutOne(ctx context.Context, from, through model.Time, chun
 	// If this chunk is in cache it must already be in the database so we don't need to write it again
 	found, _, _ := c.cache.Fetch(ctx, []string{chunk.ExternalKey()})
 	if len(found) > 0 {
+		dedupedChunks.Inc()
+		return nil
+	}
+
+	// The chunks written are also recorded in the write dedupe cache, which is
+	// usually shared by the replicas flushing the same chunks.
+	found, _, _ = c.writeDedupeCache.Fetch(ctx, []string{chunk.ExternalKey()})
+	if len(found) > 0 {
+		dedupedChunks.Inc()
 		return nil
 	}
 
@@ -406,12 +506,54 @@ func (c *seriesStore) PutOne(ctx context.Context, from, through model.Time, chun
 		}
 	}
 	c.writeBackCache(ctx, chunks)
+	storedChunks.Inc()
 
+	keysToCache = append(keysToCache, chunk.ExternalKey())
 	bufs := make([][]byte, len(keysToCache))
 	c.writeDedupeCache.Store(ctx, keysToCache, bufs)
 	return nil
 }
 
+// DeleteChunk implements Store. Only the entries of the chunk are deleted:
+// those of its series are shared with its other chunks.
+func (c *seriesStore) DeleteChunk(ctx context.Context, from, through model.Time, chunk Chunk) error {
+	metricName := chunk.Metric.Get(labels.MetricName)
+	if metricName == "" {
+		return fmt.Errorf("no MetricNameLabel for chunk")
+	}
+
+	entries, err := c.schema.GetChunkWriteEntries(from, through, chunk.UserID, metricName, chunk.Metric, chunk.ExternalKey())
+	if err != nil {
+		return err
+	}
+	return c.deleteChunk(ctx, entries, chunk)
+}
+
+// DeleteUserChunks implements Store. Unlike DeleteChunk, the entries of the
+// series and the labels of the chunks are deleted too, so that the series of
+// the tenant are no longer found by the label and series queries: all the
+// chunks of the series between from and through being deleted.
+func (c *seriesStore) DeleteUserChunks(ctx context.Context, userID string, from, through model.Time) (int, error) {
+	return c.deleteUserChunks(ctx, userID, from, through, c.userChunkEntries)
+}
+
+// userChunkEntries returns the entries of a chunk, of its series and labels
+// as well as of the chunk itself.
+func (c *seriesStore) userChunkEntries(from, through model.Time, userID string, metricName string, labels labels.Labels, chunkID string) ([]IndexEntry, error) {
+	_, labelEntries, err := c.schema.GetCacheKeysAndLabelWriteEntries(from, through, userID, metricName, labels, chunkID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.schema.GetChunkWriteEntries(from, through, userID, metricName, labels, chunkID)
+	if err != nil {
+		return nil, err
+	}
+	for _, es := range labelEntries {
+		entries = append(entries, es...)
+	}
+	return entries, nil
+}
+
 // calculateIndexEntries creates a set of batched WriteRequests for all the chunks it is given.
 func (c *seriesStore) calculateIndexEntries(ctx context.Context, from, through model.Time, chunk Chunk) (WriteBatch, []string, error) {
 	seenIndexEntries := map[string]struct{}{}
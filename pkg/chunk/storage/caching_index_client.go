@@ -39,25 +39,37 @@ var (
 		Name: "querier_index_cache_encode_errors_total",
 		Help: "The number of errors for the index cache while encoding the body.",
 	})
+	cacheInvalidations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "querier_index_cache_invalidations_total",
+		Help: "The number of rows invalidated in the index cache as their entries were written or deleted.",
+	})
 )
 
 type cachingIndexClient struct {
 	chunk.IndexClient
-	cache    cache.Cache
-	validity time.Duration
-	limits   StoreLimits
+	cache             cache.Cache
+	validity          time.Duration
+	immutableValidity time.Duration
+	invalidateOnWrite bool
+	limits            StoreLimits
 }
 
-func newCachingIndexClient(client chunk.IndexClient, c cache.Cache, validity time.Duration, limits StoreLimits) chunk.IndexClient {
+// newCachingIndexClient caches the rows of the index queried for validity, or
+// immutableValidity for the immutable ones, 0 to cache them forever. The
+// cached rows are invalidated when their entries are deleted, and written
+// with invalidateOnWrite.
+func newCachingIndexClient(client chunk.IndexClient, c cache.Cache, validity, immutableValidity time.Duration, invalidateOnWrite bool, limits StoreLimits) chunk.IndexClient {
 	if c == nil {
 		return client
 	}
 
 	return &cachingIndexClient{
-		IndexClient: client,
-		cache:       cache.NewSnappy(c),
-		validity:    validity,
-		limits:      limits,
+		IndexClient:       client,
+		cache:             cache.NewSnappy(c),
+		validity:          validity,
+		immutableValidity: immutableValidity,
+		invalidateOnWrite: invalidateOnWrite,
+		limits:            limits,
 	}
 }
 
@@ -65,6 +77,92 @@ func (s *cachingIndexClient) Stop() {
 	s.cache.Stop()
 }
 
+// cachingWriteBatch records the rows of the entries written, to invalidate
+// them in the cache.
+type cachingWriteBatch struct {
+	chunk.WriteBatch
+	keys map[string]struct{}
+}
+
+func (b *cachingWriteBatch) Add(tableName, hashValue string, rangeValue []byte, value []byte) {
+	b.WriteBatch.Add(tableName, hashValue, rangeValue, value)
+	b.keys[queryKey(chunk.IndexQuery{TableName: tableName, HashValue: hashValue})] = struct{}{}
+}
+
+func (s *cachingIndexClient) NewWriteBatch() chunk.WriteBatch {
+	if !s.invalidateOnWrite {
+		return s.IndexClient.NewWriteBatch()
+	}
+	return &cachingWriteBatch{
+		WriteBatch: s.IndexClient.NewWriteBatch(),
+		keys:       map[string]struct{}{},
+	}
+}
+
+func (s *cachingIndexClient) BatchWrite(ctx context.Context, batch chunk.WriteBatch) error {
+	cachingBatch, ok := batch.(*cachingWriteBatch)
+	if !ok {
+		return s.IndexClient.BatchWrite(ctx, batch)
+	}
+
+	if err := s.IndexClient.BatchWrite(ctx, cachingBatch.WriteBatch); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(cachingBatch.keys))
+	for key := range cachingBatch.keys {
+		keys = append(keys, key)
+	}
+	s.invalidate(ctx, keys)
+	return nil
+}
+
+// DeleteEntries implements chunk.IndexDeleter, invalidating the cached rows
+// of the entries, e.g. of the series of a delete request.
+func (s *cachingIndexClient) DeleteEntries(ctx context.Context, entries []chunk.IndexEntry) error {
+	deleter, ok := s.IndexClient.(chunk.IndexDeleter)
+	if !ok {
+		return chunk.ErrNotSupported
+	}
+	if err := deleter.DeleteEntries(ctx, entries); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(entries))
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		key := queryKey(chunk.IndexQuery{TableName: entry.TableName, HashValue: entry.HashValue})
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	s.invalidate(ctx, keys)
+	return nil
+}
+
+// invalidate replaces the cached rows by expired ones, as the caches can't
+// delete their entries, so that they're queried again.
+func (s *cachingIndexClient) invalidate(ctx context.Context, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	batches := make([]ReadBatch, 0, len(keys))
+	for _, key := range keys {
+		batches = append(batches, ReadBatch{Key: key, Expiry: 1})
+	}
+	cacheInvalidations.Add(float64(len(keys)))
+	s.cacheStore(ctx, keys, batches)
+}
+
+// ScanTable implements chunk.IndexScanner, reading the underlying client.
+func (s *cachingIndexClient) ScanTable(ctx context.Context, tableName string, callback func(chunk.IndexEntry) bool) error {
+	scanner, ok := s.IndexClient.(chunk.IndexScanner)
+	if !ok {
+		return chunk.ErrNotSupported
+	}
+	return scanner.ScanTable(ctx, tableName, callback)
+}
+
 func (s *cachingIndexClient) QueryPages(ctx context.Context, queries []chunk.IndexQuery, callback func(chunk.IndexQuery, chunk.ReadBatch) (shouldContinue bool)) error {
 	// We cache the entire row, so filter client side.
 	callback = chunk_util.QueryFilter(callback)
@@ -124,9 +222,13 @@ func (s *cachingIndexClient) QueryPages(ctx context.Context, queries []chunk.Ind
 			Expiry: expiryTime.UnixNano(),
 		}
 
-		// If the query is cacheable forever, nil the expiry.
+		// If the query is immutable, cache it for longer, forever if the
+		// validity of the immutable rows is 0.
 		if queries[0].Immutable {
 			rb.Expiry = 0
+			if s.immutableValidity > 0 {
+				rb.Expiry = time.Now().Add(s.immutableValidity).UnixNano()
+			}
 		}
 
 		results[key] = rb
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -40,7 +41,7 @@ func TestCachingStorageClientBasic(t *testing.T) {
 	limits, err := defaultLimits()
 	require.NoError(t, err)
 	cache := cache.NewFifoCache("test", cache.FifoCacheConfig{Size: 10, Validity: 10 * time.Second})
-	client := newCachingIndexClient(store, cache, 1*time.Second, limits)
+	client := newCachingIndexClient(store, cache, 1*time.Second, 0, false, limits)
 	queries := []chunk.IndexQuery{{
 		TableName: "table",
 		HashValue: "baz",
@@ -71,7 +72,7 @@ func TestTempCachingStorageClient(t *testing.T) {
 	limits, err := defaultLimits()
 	require.NoError(t, err)
 	cache := cache.NewFifoCache("test", cache.FifoCacheConfig{Size: 10, Validity: 10 * time.Second})
-	client := newCachingIndexClient(store, cache, 100*time.Millisecond, limits)
+	client := newCachingIndexClient(store, cache, 100*time.Millisecond, 0, false, limits)
 	queries := []chunk.IndexQuery{
 		{TableName: "table", HashValue: "foo"},
 		{TableName: "table", HashValue: "bar"},
@@ -129,7 +130,7 @@ func TestPermCachingStorageClient(t *testing.T) {
 	limits, err := defaultLimits()
 	require.NoError(t, err)
 	cache := cache.NewFifoCache("test", cache.FifoCacheConfig{Size: 10, Validity: 10 * time.Second})
-	client := newCachingIndexClient(store, cache, 100*time.Millisecond, limits)
+	client := newCachingIndexClient(store, cache, 100*time.Millisecond, 0, false, limits)
 	queries := []chunk.IndexQuery{
 		{TableName: "table", HashValue: "foo", Immutable: true},
 		{TableName: "table", HashValue: "bar", Immutable: true},
@@ -180,7 +181,7 @@ func TestCachingStorageClientEmptyResponse(t *testing.T) {
 	limits, err := defaultLimits()
 	require.NoError(t, err)
 	cache := cache.NewFifoCache("test", cache.FifoCacheConfig{Size: 10, Validity: 10 * time.Second})
-	client := newCachingIndexClient(store, cache, 1*time.Second, limits)
+	client := newCachingIndexClient(store, cache, 1*time.Second, 0, false, limits)
 	queries := []chunk.IndexQuery{{TableName: "table", HashValue: "foo"}}
 	err = client.QueryPages(ctx, queries, func(query chunk.IndexQuery, batch chunk.ReadBatch) bool {
 		assert.False(t, batch.Iterator().Next())
@@ -218,7 +219,7 @@ func TestCachingStorageClientCollision(t *testing.T) {
 	limits, err := defaultLimits()
 	require.NoError(t, err)
 	cache := cache.NewFifoCache("test", cache.FifoCacheConfig{Size: 10, Validity: 10 * time.Second})
-	client := newCachingIndexClient(store, cache, 1*time.Second, limits)
+	client := newCachingIndexClient(store, cache, 1*time.Second, 0, false, limits)
 	queries := []chunk.IndexQuery{
 		{TableName: "table", HashValue: "foo", RangeValuePrefix: []byte("bar")},
 		{TableName: "table", HashValue: "foo", RangeValuePrefix: []byte("baz")},
@@ -255,3 +256,88 @@ func TestCachingStorageClientCollision(t *testing.T) {
 	assert.EqualValues(t, 1, store.queries)
 	assert.EqualValues(t, store.results, results)
 }
+
+func TestCachingStorageClientImmutableValidity(t *testing.T) {
+	store := &mockStore{
+		results: ReadBatch{
+			Entries: []Entry{{
+				Column: []byte("foo"),
+				Value:  []byte("bar"),
+			}},
+		},
+	}
+	limits, err := defaultLimits()
+	require.NoError(t, err)
+	cache := cache.NewFifoCache("test", cache.FifoCacheConfig{Size: 10, Validity: 10 * time.Second})
+	client := newCachingIndexClient(store, cache, 10*time.Millisecond, 100*time.Millisecond, false, limits)
+	queries := []chunk.IndexQuery{
+		{TableName: "table", HashValue: "foo", Immutable: true},
+	}
+	query := func() {
+		err := client.QueryPages(ctx, queries, func(chunk.IndexQuery, chunk.ReadBatch) bool {
+			return true
+		})
+		require.NoError(t, err)
+	}
+
+	query()
+	assert.EqualValues(t, 1, store.queries)
+
+	// Past the validity of the active rows, the immutable ones are still cached.
+	time.Sleep(50 * time.Millisecond)
+	query()
+	assert.EqualValues(t, 1, store.queries)
+
+	// Past the validity of the immutable rows, they're queried again.
+	time.Sleep(100 * time.Millisecond)
+	query()
+	assert.EqualValues(t, 2, store.queries)
+}
+
+func TestCachingStorageClientInvalidation(t *testing.T) {
+	for _, invalidateOnWrite := range []bool{true, false} {
+		t.Run(fmt.Sprintf("invalidateOnWrite=%v", invalidateOnWrite), func(t *testing.T) {
+			storage := chunk.NewMockStorage()
+			require.NoError(t, storage.CreateTable(ctx, chunk.TableDesc{Name: "table"}))
+			limits, err := defaultLimits()
+			require.NoError(t, err)
+			cache := cache.NewFifoCache("test", cache.FifoCacheConfig{Size: 10, Validity: 10 * time.Second})
+			client := newCachingIndexClient(storage, cache, time.Minute, 0, invalidateOnWrite, limits)
+
+			write := func(rangeValue string) {
+				batch := client.NewWriteBatch()
+				batch.Add("table", "foo", []byte(rangeValue), nil)
+				require.NoError(t, client.BatchWrite(ctx, batch))
+			}
+			query := func() []string {
+				var rangeValues []string
+				err := client.QueryPages(ctx, []chunk.IndexQuery{{TableName: "table", HashValue: "foo"}}, func(_ chunk.IndexQuery, batch chunk.ReadBatch) bool {
+					for iter := batch.Iterator(); iter.Next(); {
+						rangeValues = append(rangeValues, string(iter.RangeValue()))
+					}
+					return true
+				})
+				require.NoError(t, err)
+				return rangeValues
+			}
+
+			write("a")
+			require.Equal(t, []string{"a"}, query())
+
+			// The entries written are only seen with the invalidation on write
+			// until the cache validity passes.
+			write("b")
+			if invalidateOnWrite {
+				require.Equal(t, []string{"a", "b"}, query())
+			} else {
+				require.Equal(t, []string{"a"}, query())
+			}
+
+			// The entries deleted are never seen.
+			require.NoError(t, client.(chunk.IndexDeleter).DeleteEntries(ctx, []chunk.IndexEntry{
+				{TableName: "table", HashValue: "foo", RangeValue: []byte("a")},
+			}))
+			require.Equal(t, []string{"b"}, query())
+		})
+	}
+}
@@ -9,32 +9,50 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/chunk/aws"
+	"github.com/cortexproject/cortex/pkg/chunk/azure"
 	"github.com/cortexproject/cortex/pkg/chunk/cache"
 	"github.com/cortexproject/cortex/pkg/chunk/cassandra"
+	"github.com/cortexproject/cortex/pkg/chunk/encryption"
 	"github.com/cortexproject/cortex/pkg/chunk/gcp"
 	"github.com/cortexproject/cortex/pkg/chunk/local"
+	"github.com/cortexproject/cortex/pkg/chunk/openstack"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 )
 
-// StoreLimits helps get Limits specific to Queries for Stores
+// StoreLimits helps get Limits specific to Queries for Stores, and to the
+// chunks written to the object stores.
 type StoreLimits interface {
 	CardinalityLimit(userID string) int
 	MaxChunksPerQuery(userID string) int
 	MaxQueryLength(userID string) time.Duration
+	ObjectLimits
+}
+
+// ObjectLimits are the per-tenant overrides of the server-side encryption of
+// the chunks written to S3, of their buckets, and of their encryption keys.
+type ObjectLimits interface {
+	aws.S3Limits
+	encryption.Limits
 }
 
 // Config chooses which storage client to use.
 type Config struct {
-	AWSStorageConfig       aws.StorageConfig  `yaml:"aws"`
-	GCPStorageConfig       gcp.Config         `yaml:"bigtable"`
-	GCSConfig              gcp.GCSConfig      `yaml:"gcs"`
-	CassandraStorageConfig cassandra.Config   `yaml:"cassandra"`
-	BoltDBConfig           local.BoltDBConfig `yaml:"boltdb"`
-	FSConfig               local.FSConfig     `yaml:"filesystem"`
-
-	IndexCacheValidity time.Duration
+	AWSStorageConfig       aws.StorageConfig       `yaml:"aws"`
+	GCPStorageConfig       gcp.Config              `yaml:"bigtable"`
+	GCSConfig              gcp.GCSConfig           `yaml:"gcs"`
+	AzureStorageConfig     azure.BlobStorageConfig `yaml:"azure"`
+	SwiftStorageConfig     openstack.SwiftConfig   `yaml:"swift"`
+	CassandraStorageConfig cassandra.Config        `yaml:"cassandra"`
+	BoltDBConfig           local.BoltDBConfig      `yaml:"boltdb"`
+	FSConfig               local.FSConfig          `yaml:"filesystem"`
+	Encryption             encryption.Config       `yaml:"encryption"`
+	ChunkCompression       string                  `yaml:"chunk_compression"`
+
+	IndexCacheValidity          time.Duration
+	IndexCacheImmutableValidity time.Duration `yaml:"index_cache_immutable_validity"`
+	IndexCacheInvalidateOnWrite bool          `yaml:"index_cache_invalidate_on_write"`
 
 	IndexQueriesCacheConfig cache.Config `yaml:"index_queries_cache_config,omitempty"`
 }
@@ -44,12 +62,23 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	cfg.AWSStorageConfig.RegisterFlags(f)
 	cfg.GCPStorageConfig.RegisterFlags(f)
 	cfg.GCSConfig.RegisterFlags(f)
+	cfg.AzureStorageConfig.RegisterFlags(f)
+	cfg.SwiftStorageConfig.RegisterFlags(f)
 	cfg.CassandraStorageConfig.RegisterFlags(f)
 	cfg.BoltDBConfig.RegisterFlags(f)
 	cfg.FSConfig.RegisterFlags(f)
+	cfg.Encryption.RegisterFlags(f)
+	f.StringVar(&cfg.ChunkCompression, "store.chunk-compression", chunk.CompressionNone, "Codec compressing the chunks written to the object store: none, snappy, gzip or zstd. The chunks are read whatever their codec.")
 
 	cfg.IndexQueriesCacheConfig.RegisterFlagsWithPrefix("store.index-cache-read.", "Cache config for index entry reading. ", f)
 	f.DurationVar(&cfg.IndexCacheValidity, "store.index-cache-validity", 5*time.Minute, "Cache validity for active index entries. Should be no higher than -ingester.max-chunk-idle.")
+	f.DurationVar(&cfg.IndexCacheImmutableValidity, "store.index-cache-immutable-validity", 0, "Cache validity for the index entries older than -store.cache-lookups-older-than, which are immutable; 0 to cache them forever.")
+	f.BoolVar(&cfg.IndexCacheInvalidateOnWrite, "store.index-cache-invalidate-on-write", false, "Invalidate the cached rows of the index entries written, so that the queries sharing the cache see them before the cache validity passes.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	return chunk.ValidateCompressionCodec(cfg.ChunkCompression)
 }
 
 // NewStore makes the storage clients based on the configuration.
@@ -67,31 +96,18 @@ func NewStore(cfg Config, storeCfg chunk.StoreConfig, schemaCfg chunk.SchemaConf
 	if err != nil {
 		return nil, errors.Wrap(err, "error loading schema config")
 	}
-	stores := chunk.NewCompositeStore()
-
-	for _, s := range schemaCfg.Configs {
-		index, err := NewIndexClient(s.IndexType, cfg, schemaCfg)
-		if err != nil {
-			return nil, errors.Wrap(err, "error creating index client")
-		}
-		index = newCachingIndexClient(index, tieredCache, cfg.IndexCacheValidity, limits)
-
-		objectStoreType := s.ObjectType
-		if objectStoreType == "" {
-			objectStoreType = s.IndexType
-		}
-		chunks, err := NewObjectClient(objectStoreType, cfg, schemaCfg)
-		if err != nil {
-			return nil, errors.Wrap(err, "error creating object client")
-		}
-
-		err = stores.AddPeriod(storeCfg, s, index, chunks, limits)
-		if err != nil {
-			return nil, err
-		}
+	store := &reloadableStore{
+		cfg:         cfg,
+		storeCfg:    storeCfg,
+		schemaCfg:   schemaCfg,
+		limits:      limits,
+		tieredCache: tieredCache,
+		store:       chunk.NewCompositeStore(),
 	}
-
-	return stores, nil
+	if err := store.addPeriods(&store.store, schemaCfg, schemaCfg.Configs); err != nil {
+		return nil, err
+	}
+	return store, nil
 }
 
 // NewIndexClient makes a new index client of the desired type.
@@ -125,14 +141,52 @@ func NewIndexClient(name string, cfg Config, schemaCfg chunk.SchemaConfig) (chun
 	}
 }
 
-// NewObjectClient makes a new ObjectClient of the desired types.
-func NewObjectClient(name string, cfg Config, schemaCfg chunk.SchemaConfig) (chunk.ObjectClient, error) {
+// NewObjectClient makes a new ObjectClient of the desired types. The limits,
+// if not nil, override the server-side encryption of the chunks written to S3
+// per tenant, and give the keys encrypting the chunks of the tenants; without
+// them, the encrypted chunks are only decrypted. The chunks are compressed
+// before being encrypted, as the ciphertext doesn't compress.
+func NewObjectClient(name string, cfg Config, schemaCfg chunk.SchemaConfig, limits ObjectLimits) (chunk.ObjectClient, error) {
+	client, err := newObjectClient(name, cfg, schemaCfg, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Encryption.Enabled() {
+		kms, err := newKMS(cfg.Encryption)
+		if err != nil {
+			client.Stop()
+			return nil, errors.Wrap(err, "error creating KMS")
+		}
+		client = chunk.NewEncryptingObjectClient(client, encryption.NewKeyring(cfg.Encryption, kms, limits))
+	}
+
+	compressing, err := chunk.NewCompressingObjectClient(client, cfg.ChunkCompression)
+	if err != nil {
+		client.Stop()
+		return nil, err
+	}
+	return compressing, nil
+}
+
+func newKMS(cfg encryption.Config) (encryption.KMS, error) {
+	switch cfg.KMS {
+	case encryption.KMSStatic:
+		return encryption.NewStaticKMS(cfg.StaticKeysFile)
+	case encryption.KMSAWS:
+		return aws.NewKMS(cfg.AWSKMSURL.URL)
+	default:
+		return nil, fmt.Errorf("Unrecognized KMS %v, choose one of: %s, %s", cfg.KMS, encryption.KMSStatic, encryption.KMSAWS)
+	}
+}
+
+func newObjectClient(name string, cfg Config, schemaCfg chunk.SchemaConfig, limits aws.S3Limits) (chunk.ObjectClient, error) {
 	switch name {
 	case "inmemory":
 		store := chunk.NewMockStorage()
 		return store, nil
 	case "aws", "s3":
-		return aws.NewS3ObjectClient(cfg.AWSStorageConfig, schemaCfg)
+		return aws.NewS3ObjectClient(cfg.AWSStorageConfig, schemaCfg, limits)
 	case "aws-dynamo", "dynamo":
 		if cfg.AWSStorageConfig.DynamoDB.URL == nil {
 			return nil, fmt.Errorf("Must set -dynamodb.url in aws mode")
@@ -147,13 +201,17 @@ func NewObjectClient(name string, cfg Config, schemaCfg chunk.SchemaConfig) (chu
 	case "gcp-columnkey", "bigtable", "bigtable-hashed":
 		return gcp.NewBigtableObjectClient(context.Background(), cfg.GCPStorageConfig, schemaCfg)
 	case "gcs":
-		return gcp.NewGCSObjectClient(context.Background(), cfg.GCSConfig, schemaCfg)
+		return gcp.NewGCSObjectClient(context.Background(), cfg.GCSConfig, schemaCfg, limits)
+	case "azure":
+		return azure.NewBlobStorage(cfg.AzureStorageConfig, schemaCfg)
+	case "swift":
+		return openstack.NewSwiftObjectClient(cfg.SwiftStorageConfig, schemaCfg)
 	case "cassandra":
 		return cassandra.NewStorageClient(cfg.CassandraStorageConfig, schemaCfg)
 	case "filesystem":
 		return local.NewFSObjectClient(cfg.FSConfig)
 	default:
-		return nil, fmt.Errorf("Unrecognized storage client %v, choose one of: aws, cassandra, inmemory, gcp, bigtable, bigtable-hashed", name)
+		return nil, fmt.Errorf("Unrecognized storage client %v, choose one of: aws, s3, azure, swift, cassandra, inmemory, gcp, bigtable, bigtable-hashed, gcs, filesystem", name)
 	}
 }
 
@@ -171,7 +229,10 @@ func NewTableClient(name string, cfg Config) (chunk.TableClient, error) {
 			level.Warn(util.Logger).Log("msg", "ignoring DynamoDB URL path", "path", path)
 		}
 		return aws.NewDynamoDBTableClient(cfg.AWSStorageConfig.DynamoDBConfig)
-	case "gcp", "gcp-columnkey", "bigtable", "bigtable-hashed":
+	case "gcp", "gcp-columnkey", "bigtable":
+		return gcp.NewTableClient(context.Background(), cfg.GCPStorageConfig)
+	case "bigtable-hashed":
+		cfg.GCPStorageConfig.DistributeKeys = true
 		return gcp.NewTableClient(context.Background(), cfg.GCPStorageConfig)
 	case "cassandra":
 		return cassandra.NewTableClient(context.Background(), cfg.CassandraStorageConfig)
@@ -204,7 +204,7 @@ func TestCardinalityLimit(t *testing.T) {
 		limits, err := defaultLimits()
 		require.NoError(t, err)
 
-		client = newCachingIndexClient(client, cache.NewMockCache(), time.Minute, limits)
+		client = newCachingIndexClient(client, cache.NewMockCache(), time.Minute, 0, false, limits)
 		batch := client.NewWriteBatch()
 		for i := 0; i < 10; i++ {
 			batch.Add(tableName, "bar", []byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
@@ -227,3 +227,88 @@ func TestCardinalityLimit(t *testing.T) {
 		require.Equal(t, 0, have)
 	})
 }
+
+func TestIndexDeleteEntries(t *testing.T) {
+	forAllFixtures(t, func(t *testing.T, client chunk.IndexClient, _ chunk.ObjectClient) {
+		batch := client.NewWriteBatch()
+		for _, entry := range entries {
+			batch.Add(entry.TableName, entry.HashValue, entry.RangeValue, entry.Value)
+		}
+		require.NoError(t, client.BatchWrite(ctx, batch))
+
+		deleter, ok := client.(chunk.IndexDeleter)
+		require.True(t, ok)
+		require.NoError(t, deleter.DeleteEntries(ctx, entries[1:3]))
+
+		var have []string
+		err := client.QueryPages(ctx, []chunk.IndexQuery{{TableName: tableName, HashValue: "foo"}}, func(_ chunk.IndexQuery, read chunk.ReadBatch) bool {
+			iter := read.Iterator()
+			for iter.Next() {
+				have = append(have, string(iter.RangeValue()))
+			}
+			return true
+		})
+		require.NoError(t, err)
+		require.NotContains(t, have, "bar:2")
+		require.NotContains(t, have, "bar:3")
+		require.Contains(t, have, "bar:1")
+		require.Contains(t, have, "baz:1")
+	})
+}
+
+func TestIndexScanTable(t *testing.T) {
+	forAllFixtures(t, func(t *testing.T, client chunk.IndexClient, _ chunk.ObjectClient) {
+		batch := client.NewWriteBatch()
+		for _, entry := range entries {
+			batch.Add(entry.TableName, entry.HashValue, entry.RangeValue, entry.Value)
+		}
+		require.NoError(t, client.BatchWrite(ctx, batch))
+
+		scanner, ok := client.(chunk.IndexScanner)
+		require.True(t, ok)
+
+		var have []chunk.IndexEntry
+		require.NoError(t, scanner.ScanTable(ctx, tableName, func(entry chunk.IndexEntry) bool {
+			have = append(have, entry)
+			return true
+		}))
+		require.ElementsMatch(t, entries, have)
+
+		// The scan stops once the callback returns false.
+		n := 0
+		require.NoError(t, scanner.ScanTable(ctx, tableName, func(chunk.IndexEntry) bool {
+			n++
+			return false
+		}))
+		require.Equal(t, 1, n)
+	})
+}
+
+func TestIndexScanTableDeletingEntries(t *testing.T) {
+	forAllFixtures(t, func(t *testing.T, client chunk.IndexClient, _ chunk.ObjectClient) {
+		// More entries than a page of the scans reading the table in pages.
+		const numEntries = 2500
+		batch := client.NewWriteBatch()
+		for i := 0; i < numEntries; i++ {
+			batch.Add(tableName, fmt.Sprintf("hash%d", i%10), []byte(fmt.Sprintf("range%d", i)), nil)
+		}
+		require.NoError(t, client.BatchWrite(ctx, batch))
+
+		// The entries scanned can be deleted while the table is scanned.
+		scanner, deleter := client.(chunk.IndexScanner), client.(chunk.IndexDeleter)
+		seen := map[string]bool{}
+		require.NoError(t, scanner.ScanTable(ctx, tableName, func(entry chunk.IndexEntry) bool {
+			seen[entry.HashValue+":"+string(entry.RangeValue)] = true
+			require.NoError(t, deleter.DeleteEntries(ctx, []chunk.IndexEntry{entry}))
+			return true
+		}))
+		require.Len(t, seen, numEntries)
+
+		n := 0
+		require.NoError(t, scanner.ScanTable(ctx, tableName, func(chunk.IndexEntry) bool {
+			n++
+			return true
+		}))
+		require.Equal(t, 0, n)
+	})
+}
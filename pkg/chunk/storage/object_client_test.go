@@ -58,3 +58,27 @@ func TestChunksBasic(t *testing.T) {
 		}
 	})
 }
+
+func TestChunksDelete(t *testing.T) {
+	forAllFixtures(t, func(t *testing.T, _ chunk.IndexClient, client chunk.ObjectClient) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		_, chunks, err := testutils.CreateChunks(0, 2, model.Now())
+		require.NoError(t, err)
+		require.NoError(t, client.PutChunks(ctx, chunks))
+
+		deleter, ok := client.(chunk.ObjectDeleter)
+		require.True(t, ok)
+		require.NoError(t, deleter.DeleteChunk(ctx, chunks[0]))
+		// Deleting a missing chunk succeeds.
+		require.NoError(t, deleter.DeleteChunk(ctx, chunks[0]))
+
+		got, err := client.GetChunks(ctx, chunks[1:])
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+
+		got, err = client.GetChunks(ctx, chunks[:1])
+		require.True(t, err != nil || len(got) == 0)
+	})
+}
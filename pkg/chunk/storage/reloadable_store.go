@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+)
+
+// reloadableStore is the chunk.Store of the periods of a schema config, to
+// which the new periods of the config are added as it's reloaded.
+type reloadableStore struct {
+	cfg         Config
+	storeCfg    chunk.StoreConfig
+	limits      StoreLimits
+	tieredCache cache.Cache
+
+	mtx       sync.RWMutex
+	schemaCfg chunk.SchemaConfig
+	store     chunk.CompositeStore
+}
+
+// addPeriods adds the stores of the periods of the schema config to store.
+func (s *reloadableStore) addPeriods(store *chunk.CompositeStore, schemaCfg chunk.SchemaConfig, periods []chunk.PeriodConfig) error {
+	for _, p := range periods {
+		index, err := NewIndexClient(p.IndexType, s.cfg, schemaCfg)
+		if err != nil {
+			return errors.Wrap(err, "error creating index client")
+		}
+		index = newCachingIndexClient(index, s.tieredCache, s.cfg.IndexCacheValidity, s.cfg.IndexCacheImmutableValidity, s.cfg.IndexCacheInvalidateOnWrite, s.limits)
+
+		objectStoreType := p.ObjectType
+		if objectStoreType == "" {
+			objectStoreType = p.IndexType
+		}
+		chunks, err := NewObjectClient(objectStoreType, s.cfg, schemaCfg, s.limits)
+		if err != nil {
+			return errors.Wrap(err, "error creating object client")
+		}
+
+		err = store.AddPeriod(s.storeCfg, p, index, chunks, s.limits)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReloadSchema implements SchemaReloadable, adding the stores of the new
+// periods of the schema config.
+func (s *reloadableStore) ReloadSchema(schemaCfg chunk.SchemaConfig) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err := s.schemaCfg.ValidateUpdate(schemaCfg, model.Now()); err != nil {
+		return err
+	}
+	store := s.store.Clone()
+	if err := s.addPeriods(&store, schemaCfg, schemaCfg.Configs[len(s.schemaCfg.Configs):]); err != nil {
+		return err
+	}
+	s.schemaCfg = schemaCfg
+	s.store = store
+	return nil
+}
+
+func (s *reloadableStore) current() chunk.Store {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.store
+}
+
+func (s *reloadableStore) Put(ctx context.Context, chunks []chunk.Chunk) error {
+	return s.current().Put(ctx, chunks)
+}
+
+func (s *reloadableStore) PutOne(ctx context.Context, from, through model.Time, c chunk.Chunk) error {
+	return s.current().PutOne(ctx, from, through, c)
+}
+
+func (s *reloadableStore) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]chunk.Chunk, error) {
+	return s.current().Get(ctx, userID, from, through, matchers...)
+}
+
+func (s *reloadableStore) GetChunkRefs(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([][]chunk.Chunk, []*chunk.Fetcher, error) {
+	return s.current().GetChunkRefs(ctx, userID, from, through, matchers...)
+}
+
+func (s *reloadableStore) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string, labelName string, matchers ...*labels.Matcher) ([]string, error) {
+	return s.current().LabelValuesForMetricName(ctx, userID, from, through, metricName, labelName, matchers...)
+}
+
+func (s *reloadableStore) GetSeries(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]labels.Labels, error) {
+	return s.current().GetSeries(ctx, userID, from, through, matchers...)
+}
+
+func (s *reloadableStore) LabelNamesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string) ([]string, error) {
+	return s.current().LabelNamesForMetricName(ctx, userID, from, through, metricName)
+}
+
+func (s *reloadableStore) DeleteChunk(ctx context.Context, from, through model.Time, c chunk.Chunk) error {
+	return s.current().DeleteChunk(ctx, from, through, c)
+}
+
+func (s *reloadableStore) DeleteUserChunks(ctx context.Context, userID string, from, through model.Time) (int, error) {
+	return s.current().DeleteUserChunks(ctx, userID, from, through)
+}
+
+func (s *reloadableStore) CheckDeleteUserChunks() error {
+	return s.current().CheckDeleteUserChunks()
+}
+
+func (s *reloadableStore) Stop() {
+	s.current().Stop()
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+var schemaReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cortex",
+	Name:      "schema_config_reloads_total",
+	Help:      "Number of reloads of the schema config file, by status.",
+}, []string{"status"})
+
+// SchemaReloadable is updated with the schema config as it's reloaded.
+type SchemaReloadable interface {
+	ReloadSchema(cfg chunk.SchemaConfig) error
+}
+
+// SchemaReloadFunc is a function implementing SchemaReloadable.
+type SchemaReloadFunc func(cfg chunk.SchemaConfig) error
+
+// ReloadSchema implements SchemaReloadable.
+func (f SchemaReloadFunc) ReloadSchema(cfg chunk.SchemaConfig) error {
+	return f(cfg)
+}
+
+// SchemaReloader reloads the schema config file, on SIGHUP or by its HTTP
+// handlers, adding its new periods to the stores and table managers
+// registered, so that a new period can be added without a restart.
+type SchemaReloader struct {
+	mtx       sync.Mutex
+	cfg       chunk.SchemaConfig
+	reloadees []SchemaReloadable
+
+	stopOnce sync.Once
+	signals  chan os.Signal
+	done     chan struct{}
+}
+
+// NewSchemaReloader makes a new SchemaReloader of the loaded schema config.
+func NewSchemaReloader(cfg chunk.SchemaConfig) *SchemaReloader {
+	return &SchemaReloader{
+		cfg:  cfg,
+		done: make(chan struct{}),
+	}
+}
+
+// Register adds a SchemaReloadable to update with the reloaded configs.
+func (r *SchemaReloader) Register(reloadable SchemaReloadable) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.reloadees = append(r.reloadees, reloadable)
+}
+
+// Reload reads the schema config file again, and updates the reloadables
+// with it if it can be updated to.
+func (r *SchemaReloader) Reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	err := r.reload()
+	if err != nil {
+		schemaReloads.WithLabelValues("failure").Inc()
+		level.Error(util.Logger).Log("msg", "error reloading the schema config", "err", err)
+		return err
+	}
+	schemaReloads.WithLabelValues("success").Inc()
+	level.Info(util.Logger).Log("msg", "reloaded the schema config", "periods", len(r.cfg.Configs))
+	return nil
+}
+
+func (r *SchemaReloader) reload() error {
+	next, err := r.cfg.Reread()
+	if err != nil {
+		return err
+	}
+	if err := r.cfg.ValidateUpdate(next, model.Now()); err != nil {
+		return err
+	}
+	// The reloadables already updated are left as they are if another fails:
+	// reloading the same config again is a no-op for them.
+	for _, reloadable := range r.reloadees {
+		if err := reloadable.ReloadSchema(next); err != nil {
+			return err
+		}
+	}
+	r.cfg = next
+	return nil
+}
+
+// WatchSIGHUP reloads the schema config on SIGHUP, until stopped.
+func (r *SchemaReloader) WatchSIGHUP() {
+	r.signals = make(chan os.Signal, 1)
+	signal.Notify(r.signals, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-r.signals:
+				_ = r.Reload()
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching SIGHUP.
+func (r *SchemaReloader) Stop() {
+	r.stopOnce.Do(func() {
+		if r.signals != nil {
+			signal.Stop(r.signals)
+		}
+		close(r.done)
+	})
+}
+
+// ConfigHandler serves the current schema config.
+func (r *SchemaReloader) ConfigHandler(w http.ResponseWriter, req *http.Request) {
+	r.mtx.Lock()
+	cfg := r.cfg
+	r.mtx.Unlock()
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(out)
+}
+
+// ReloadHandler reloads the schema config file.
+func (r *SchemaReloader) ReloadHandler(w http.ResponseWriter, req *http.Request) {
+	if err := r.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ValidateHandler checks the schema config in the body of the request is
+// valid, and that the current one can be updated to it, without reloading.
+func (r *SchemaReloader) ValidateHandler(w http.ResponseWriter, req *http.Request) {
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	next, err := chunk.ParseSchemaConfig(buf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mtx.Lock()
+	err = r.cfg.ValidateUpdate(next, model.Now())
+	r.mtx.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
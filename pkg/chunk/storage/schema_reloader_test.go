@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func newReloadableTestStore(t *testing.T, schemaCfg chunk.SchemaConfig) chunk.Store {
+	var (
+		cfg         Config
+		storeConfig chunk.StoreConfig
+		defaults    validation.Limits
+	)
+	flagext.DefaultValues(&cfg, &storeConfig, &defaults)
+	limits, err := validation.NewOverrides(defaults)
+	require.NoError(t, err)
+
+	store, err := NewStore(cfg, storeConfig, schemaCfg, limits)
+	require.NoError(t, err)
+	return store
+}
+
+func TestReloadableStore(t *testing.T) {
+	current := chunk.SchemaConfig{Configs: []chunk.PeriodConfig{
+		{From: chunk.DayTime{Time: 0}, IndexType: "inmemory", Schema: "v9"},
+	}}
+	store := newReloadableTestStore(t, current)
+	defer store.Stop()
+	reloadable, ok := store.(SchemaReloadable)
+	require.True(t, ok)
+
+	future := chunk.DayTime{Time: model.Now().Add(24 * time.Hour)}
+
+	// The periods can't be changed, nor added in the past.
+	require.Error(t, reloadable.ReloadSchema(chunk.SchemaConfig{Configs: []chunk.PeriodConfig{
+		{From: chunk.DayTime{Time: 0}, IndexType: "inmemory", Schema: "v10"},
+	}}))
+	require.Error(t, reloadable.ReloadSchema(chunk.SchemaConfig{Configs: []chunk.PeriodConfig{
+		current.Configs[0],
+		{From: chunk.DayTime{Time: model.Now().Add(-time.Hour)}, IndexType: "inmemory", Schema: "v10"},
+	}}))
+
+	next := chunk.SchemaConfig{Configs: []chunk.PeriodConfig{
+		current.Configs[0],
+		{From: future, IndexType: "inmemory", Schema: "v10"},
+	}}
+	require.NoError(t, reloadable.ReloadSchema(next))
+	require.NoError(t, reloadable.ReloadSchema(next))
+
+	// The new config is the current one.
+	require.Error(t, reloadable.ReloadSchema(current))
+}
+
+func TestSchemaReloaderValidateHandler(t *testing.T) {
+	reloader := NewSchemaReloader(chunk.SchemaConfig{Configs: []chunk.PeriodConfig{
+		{From: chunk.DayTime{Time: 0}, IndexType: "inmemory", Schema: "v9"},
+	}})
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{
+			body: "configs:\n- from: 1970-01-01\n  store: inmemory\n  schema: v9\n- from: 2100-01-01\n  store: inmemory\n  schema: v10\n",
+			code: http.StatusNoContent,
+		},
+		{
+			body: "configs:\n- from: 1970-01-01\n  store: inmemory\n  schema: v10\n",
+			code: http.StatusBadRequest,
+		},
+		{
+			body: "configs: [",
+			code: http.StatusBadRequest,
+		},
+	} {
+		w := httptest.NewRecorder()
+		reloader.ValidateHandler(w, httptest.NewRequest("POST", "/schema/validate", strings.NewReader(tc.body)))
+		require.Equal(t, tc.code, w.Code, w.Body.String())
+	}
+
+	// Without a schema config file, reloading fails.
+	w := httptest.NewRecorder()
+	reloader.ReloadHandler(w, httptest.NewRequest("POST", "/schema/reload", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
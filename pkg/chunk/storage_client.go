@@ -27,6 +27,32 @@ type ObjectAndIndexClient interface {
 	PutChunkAndIndex(ctx context.Context, c Chunk, index WriteBatch) error
 }
 
+// IndexDeleter is implemented by the index clients which can delete entries,
+// for the purge of the deleted series.
+type IndexDeleter interface {
+	DeleteEntries(ctx context.Context, entries []IndexEntry) error
+}
+
+// IndexScanner is implemented by the index clients which can read all the
+// entries of a table, for the garbage collection of the index entries of the
+// expired chunks. The entries are returned in no particular order, and can be
+// deleted from the callback.
+type IndexScanner interface {
+	ScanTable(ctx context.Context, tableName string, callback func(entry IndexEntry) (shouldContinue bool)) error
+}
+
+// ObjectDeleter is implemented by the object clients which can delete chunks,
+// for the purge of the deleted series. Deleting a missing chunk succeeds.
+type ObjectDeleter interface {
+	DeleteChunk(ctx context.Context, c Chunk) error
+}
+
+// ObjectLister is implemented by the object clients which can list the chunks
+// of a tenant, for the deletion of its data. It returns their external keys.
+type ObjectLister interface {
+	ListChunks(ctx context.Context, userID string) ([]string, error)
+}
+
 // WriteBatch represents a batch of writes.
 type WriteBatch interface {
 	Add(tableName, hashValue string, rangeValue []byte, value []byte)
@@ -32,13 +32,16 @@ func (desc TableDesc) Equals(other TableDesc) bool {
 		return false
 	}
 
+	// The throughput of the tables in on-demand mode isn't provisioned
+	onDemand := desc.UseOnDemandIOMode && other.UseOnDemandIOMode
+
 	// Only check provisioned read if auto scaling is disabled
-	if !desc.ReadScale.Enabled && desc.ProvisionedRead != other.ProvisionedRead {
+	if !onDemand && !desc.ReadScale.Enabled && desc.ProvisionedRead != other.ProvisionedRead {
 		return false
 	}
 
 	// Only check provisioned write if auto scaling is disabled
-	if !desc.WriteScale.Enabled && desc.ProvisionedWrite != other.ProvisionedWrite {
+	if !onDemand && !desc.WriteScale.Enabled && desc.ProvisionedWrite != other.ProvisionedWrite {
 		return false
 	}
 
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math/rand"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -39,10 +40,28 @@ var (
 		Name:      "dynamo_table_capacity_units",
 		Help:      "Per-table DynamoDB capacity, measured in DynamoDB capacity units.",
 	}, []string{"op", "table"})
+	retentionDeletedTables = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "table_manager_retention_deleted_tables_total",
+		Help:      "Total number of tables deleted past the retention period, or which would have been in dry-run mode.",
+	}, []string{"dry_run"})
+	retentionDeletedChunks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "table_manager_retention_deleted_chunks_total",
+		Help:      "Total number of chunks deleted past the retention period of their tenant, or which would have been in dry-run mode.",
+	}, []string{"user", "dry_run"})
+	retentionDeletedIndexEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "table_manager_retention_deleted_index_entries_total",
+		Help:      "Total number of index entries of the chunks past the retention period of their tenant, or of the series left without chunks, deleted by the garbage collection of the index, or which would have been in dry-run mode.",
+	}, []string{"user", "dry_run"})
 )
 
 func init() {
 	prometheus.MustRegister(tableCapacity)
+	prometheus.MustRegister(retentionDeletedTables)
+	prometheus.MustRegister(retentionDeletedChunks)
+	prometheus.MustRegister(retentionDeletedIndexEntries)
 	syncTableDuration.Register()
 }
 
@@ -57,6 +76,13 @@ type TableManagerConfig struct {
 	// How far back tables will be kept before they are deleted
 	RetentionPeriod time.Duration `yaml:"retention_period"`
 
+	// Only log and count what retention would delete.
+	RetentionDryRun bool `yaml:"retention_dry_run"`
+
+	// Garbage collection of the index entries of the expired chunks.
+	IndexGCEnabled  bool          `yaml:"index_gc_enabled"`
+	IndexGCInterval time.Duration `yaml:"index_gc_interval"`
+
 	// Period with which the table manager will poll for tables.
 	DynamoDBPollInterval time.Duration `yaml:"dynamodb_poll_interval"`
 
@@ -89,6 +115,9 @@ func (cfg *TableManagerConfig) RegisterFlags(f *flag.FlagSet) {
 	f.BoolVar(&cfg.ThroughputUpdatesDisabled, "table-manager.throughput-updates-disabled", false, "If true, disable all changes to DB capacity")
 	f.BoolVar(&cfg.RetentionDeletesEnabled, "table-manager.retention-deletes-enabled", false, "If true, enables retention deletes of DB tables")
 	f.DurationVar(&cfg.RetentionPeriod, "table-manager.retention-period", 0, "Tables older than this retention period are deleted. Note: This setting is destructive to data!(default: 0, which disables deletion)")
+	f.BoolVar(&cfg.RetentionDryRun, "table-manager.retention-dry-run", false, "If true, the tables, chunks and index entries past their retention period are only logged and counted in the deletion metrics, not deleted.")
+	f.BoolVar(&cfg.IndexGCEnabled, "table-manager.index-gc-enabled", false, "If true, the index tables are scanned for the entries of the chunks past the retention period of their tenant, which are deleted along with the label entries of the series left without chunks. Requires retention deletes to be enabled to delete anything.")
+	f.DurationVar(&cfg.IndexGCInterval, "table-manager.index-gc-interval", 24*time.Hour, "How often the index tables are garbage collected.")
 	f.DurationVar(&cfg.DynamoDBPollInterval, "dynamodb.poll-interval", 2*time.Minute, "How frequently to poll DynamoDB to learn our capacity.")
 	f.DurationVar(&cfg.CreationGracePeriod, "dynamodb.periodic-table.grace-period", 10*time.Minute, "DynamoDB periodic tables grace period (duration which table will be created/deleted before/after it's needed).")
 
@@ -114,26 +143,45 @@ func (cfg *ProvisionConfig) RegisterFlags(argPrefix string, f *flag.FlagSet) {
 	f.Int64Var(&cfg.InactiveReadScaleLastN, argPrefix+".inactive-read-throughput.scale-last-n", 4, "Number of last inactive tables to enable read autoscale.")
 }
 
+// RetentionLimits are the per-tenant overrides of the retention period.
+type RetentionLimits interface {
+	RetentionPeriod(userID string) time.Duration
+}
+
 // TableManager creates and manages the provisioned throughput on DynamoDB tables
 type TableManager struct {
 	client       TableClient
 	cfg          TableManagerConfig
+	schemaMtx    sync.RWMutex
 	schemaCfg    SchemaConfig
 	maxChunkAge  time.Duration
 	done         chan struct{}
 	wait         sync.WaitGroup
 	bucketClient BucketClient
+	indexClient  IndexClient
+	limits       RetentionLimits
 }
 
-// NewTableManager makes a new TableManager
+// NewTableManager makes a new TableManager. The chunks of the bucket client
+// are deleted past the retention period of their tenant, overridden by the
+// limits if not nil; the tables, shared by all the tenants, past the retention
+// period of the config. The index client, which can be nil, is garbage
+// collected if enabled, and has to support scanning and deleting entries.
 func NewTableManager(cfg TableManagerConfig, schemaCfg SchemaConfig, maxChunkAge time.Duration, tableClient TableClient,
-	objectClient BucketClient) (*TableManager, error) {
+	objectClient BucketClient, indexClient IndexClient, limits RetentionLimits) (*TableManager, error) {
 
-	if cfg.RetentionPeriod != 0 {
-		// Assume the newest config is the one to use for validation of retention
-		indexTablesPeriod := schemaCfg.Configs[len(schemaCfg.Configs)-1].IndexTables.Period
-		if indexTablesPeriod != 0 && cfg.RetentionPeriod%indexTablesPeriod != 0 {
-			return nil, errors.New("retention period should now be a multiple of periodic table duration")
+	if err := validateRetentionPeriod(cfg, schemaCfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.IndexGCEnabled {
+		if indexClient == nil {
+			return nil, errors.New("the garbage collection of the index requires an index client")
+		}
+		_, canScan := indexClient.(IndexScanner)
+		_, canDelete := indexClient.(IndexDeleter)
+		if !canScan || !canDelete {
+			return nil, fmt.Errorf("the index client %T doesn't support the garbage collection of the index", indexClient)
 		}
 	}
 
@@ -144,18 +192,58 @@ func NewTableManager(cfg TableManagerConfig, schemaCfg SchemaConfig, maxChunkAge
 		client:       tableClient,
 		done:         make(chan struct{}),
 		bucketClient: objectClient,
+		indexClient:  indexClient,
+		limits:       limits,
 	}, nil
 }
 
+func validateRetentionPeriod(cfg TableManagerConfig, schemaCfg SchemaConfig) error {
+	if cfg.RetentionPeriod != 0 {
+		// Assume the newest config is the one to use for validation of retention
+		indexTablesPeriod := schemaCfg.Configs[len(schemaCfg.Configs)-1].IndexTables.Period
+		if indexTablesPeriod != 0 && cfg.RetentionPeriod%indexTablesPeriod != 0 {
+			return errors.New("retention period should now be a multiple of periodic table duration")
+		}
+	}
+	return nil
+}
+
+// ReloadSchema updates the schema config of the TableManager, which must only
+// add new periods starting in the future, for their tables to be created.
+func (m *TableManager) ReloadSchema(schemaCfg SchemaConfig) error {
+	m.schemaMtx.Lock()
+	defer m.schemaMtx.Unlock()
+
+	if err := m.schemaCfg.ValidateUpdate(schemaCfg, model.TimeFromUnixNano(mtime.Now().UnixNano())); err != nil {
+		return err
+	}
+	if err := validateRetentionPeriod(m.cfg, schemaCfg); err != nil {
+		return err
+	}
+	m.schemaCfg = schemaCfg
+	return nil
+}
+
+func (m *TableManager) schemaConfig() SchemaConfig {
+	m.schemaMtx.RLock()
+	defer m.schemaMtx.RUnlock()
+	return m.schemaCfg
+}
+
 // Start the TableManager
 func (m *TableManager) Start() {
 	m.wait.Add(1)
 	go m.loop()
 
-	if m.bucketClient != nil && m.cfg.RetentionPeriod != 0 && m.cfg.RetentionDeletesEnabled == true {
+	if m.bucketClient != nil && (m.cfg.RetentionPeriod != 0 || m.limits != nil) && (m.cfg.RetentionDeletesEnabled || m.cfg.RetentionDryRun) {
 		m.wait.Add(1)
 		go m.bucketRetentionLoop()
 	}
+
+	if m.cfg.IndexGCEnabled && (m.cfg.RetentionPeriod != 0 || m.limits != nil) {
+		m.wait.Add(1)
+		go m.indexGCLoop()
+	}
 }
 
 // Stop the TableManager
@@ -202,10 +290,8 @@ func (m *TableManager) bucketRetentionLoop() {
 	for {
 		select {
 		case <-ticker.C:
-			err := m.bucketClient.DeleteChunksBefore(context.Background(), mtime.Now().Add(-m.cfg.RetentionPeriod))
-
-			if err != nil {
-				level.Error(util.Logger).Log("msg", "error enforcing filesystem retention", "err", err)
+			if err := m.DeleteExpiredChunks(context.Background()); err != nil {
+				level.Error(util.Logger).Log("msg", "error enforcing bucket retention", "err", err)
 			}
 		case <-m.done:
 			return
@@ -213,6 +299,35 @@ func (m *TableManager) bucketRetentionLoop() {
 	}
 }
 
+// DeleteExpiredChunks deletes the chunks of the bucket past the retention
+// period of their tenant, or only logs and counts them in dry-run mode. It is
+// exposed for testing.
+func (m *TableManager) DeleteExpiredChunks(ctx context.Context) error {
+	now := model.TimeFromUnixNano(mtime.Now().UnixNano())
+	dryRun := m.cfg.RetentionDryRun || !m.cfg.RetentionDeletesEnabled
+	deleted, err := m.bucketClient.DeleteExpiredChunks(ctx, func(userID string, through model.Time) bool {
+		retention := m.retentionPeriod(userID)
+		return retention > 0 && through.Before(now.Add(-retention))
+	}, dryRun)
+
+	for userID, n := range deleted {
+		level.Info(util.Logger).Log("msg", "chunks have exceeded the retention period", "user", userID, "chunks", n, "dry_run", dryRun)
+		retentionDeletedChunks.WithLabelValues(userID, strconv.FormatBool(dryRun)).Add(float64(n))
+	}
+	return err
+}
+
+// retentionPeriod returns the retention period of a tenant; 0 to keep their
+// chunks forever.
+func (m *TableManager) retentionPeriod(userID string) time.Duration {
+	if m.limits != nil {
+		if retention := m.limits.RetentionPeriod(userID); retention > 0 {
+			return retention
+		}
+	}
+	return m.cfg.RetentionPeriod
+}
+
 // SyncTables will calculate the tables expected to exist, create those that do
 // not and update those that need it.  It is exposed for testing.
 func (m *TableManager) SyncTables(ctx context.Context) error {
@@ -238,7 +353,8 @@ func (m *TableManager) SyncTables(ctx context.Context) error {
 func (m *TableManager) calculateExpectedTables() []TableDesc {
 	result := []TableDesc{}
 
-	for i, config := range m.schemaCfg.Configs {
+	schemaCfg := m.schemaConfig()
+	for i, config := range schemaCfg.Configs {
 		if config.From.Time.Time().After(mtime.Now()) {
 			continue
 		}
@@ -255,9 +371,9 @@ func (m *TableManager) calculateExpectedTables() []TableDesc {
 				Tags:              config.IndexTables.Tags,
 			}
 			isActive := true
-			if i+1 < len(m.schemaCfg.Configs) {
+			if i+1 < len(schemaCfg.Configs) {
 				var (
-					endTime         = m.schemaCfg.Configs[i+1].From.Unix()
+					endTime         = schemaCfg.Configs[i+1].From.Unix()
 					gracePeriodSecs = int64(m.cfg.CreationGracePeriod / time.Second)
 					maxChunkAgeSecs = int64(m.maxChunkAge / time.Second)
 					now             = mtime.Now().Unix()
@@ -283,8 +399,8 @@ func (m *TableManager) calculateExpectedTables() []TableDesc {
 			result = append(result, table)
 		} else {
 			endTime := mtime.Now().Add(m.cfg.CreationGracePeriod)
-			if i+1 < len(m.schemaCfg.Configs) {
-				nextFrom := m.schemaCfg.Configs[i+1].From.Time.Time()
+			if i+1 < len(schemaCfg.Configs) {
+				nextFrom := schemaCfg.Configs[i+1].From.Time.Time()
 				if endTime.After(nextFrom) {
 					endTime = nextFrom
 				}
@@ -334,7 +450,7 @@ func (m *TableManager) partitionTables(ctx context.Context, descriptions []Table
 	if m.cfg.RetentionPeriod > 0 {
 		// Ensure we only delete tables which have a prefix managed by Cortex.
 		tablePrefixes := map[string]struct{}{}
-		for _, cfg := range m.schemaCfg.Configs {
+		for _, cfg := range m.schemaConfig().Configs {
 			if cfg.IndexTables.Prefix != "" {
 				tablePrefixes[cfg.IndexTables.Prefix] = struct{}{}
 			}
@@ -372,7 +488,8 @@ func (m *TableManager) createTables(ctx context.Context, descriptions []TableDes
 func (m *TableManager) deleteTables(ctx context.Context, descriptions []TableDesc) error {
 	for _, desc := range descriptions {
 		level.Info(util.Logger).Log("msg", "table has exceeded the retention period", "table", desc.Name)
-		if !m.cfg.RetentionDeletesEnabled {
+		if !m.cfg.RetentionDeletesEnabled || m.cfg.RetentionDryRun {
+			retentionDeletedTables.WithLabelValues("true").Inc()
 			continue
 		}
 
@@ -381,6 +498,7 @@ func (m *TableManager) deleteTables(ctx context.Context, descriptions []TableDes
 		if err != nil {
 			return err
 		}
+		retentionDeletedTables.WithLabelValues("false").Inc()
 	}
 	return nil
 }
@@ -7,9 +7,15 @@ import (
 	"time"
 
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
 
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/mtime"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 const (
@@ -174,7 +180,7 @@ func TestTableManager(t *testing.T) {
 			InactiveReadThroughput:     inactiveRead,
 		},
 	}
-	tableManager, err := NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil)
+	tableManager, err := NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -344,7 +350,7 @@ func TestTableManagerAutoscaleInactiveOnly(t *testing.T) {
 			InactiveReadThroughput:     inactiveRead,
 		},
 	}
-	tableManager, err := NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil)
+	tableManager, err := NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -432,7 +438,7 @@ func TestTableManagerDynamicIOModeInactiveOnly(t *testing.T) {
 			InactiveThroughputOnDemandMode: true,
 		},
 	}
-	tableManager, err := NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil)
+	tableManager, err := NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -515,7 +521,7 @@ func TestTableManagerTags(t *testing.T) {
 				IndexTables: PeriodicTableConfig{},
 			}},
 		}
-		tableManager, err := NewTableManager(TableManagerConfig{}, cfg, maxChunkAge, client, nil)
+		tableManager, err := NewTableManager(TableManagerConfig{}, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -539,7 +545,7 @@ func TestTableManagerTags(t *testing.T) {
 				},
 			}},
 		}
-		tableManager, err := NewTableManager(TableManagerConfig{}, cfg, maxChunkAge, client, nil)
+		tableManager, err := NewTableManager(TableManagerConfig{}, cfg, maxChunkAge, client, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -593,7 +599,7 @@ func TestTableManagerRetentionOnly(t *testing.T) {
 			InactiveReadThroughput:     inactiveRead,
 		},
 	}
-	tableManager, err := NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil)
+	tableManager, err := NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -694,6 +700,201 @@ func TestTableManagerRetentionOnly(t *testing.T) {
 
 	// Test table manager retention not multiple of periodic config
 	tbmConfig.RetentionPeriod++
-	_, err = NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil)
+	_, err = NewTableManager(tbmConfig, cfg, maxChunkAge, client, nil, nil, nil)
 	require.Error(t, err)
 }
+
+type mockBucketClient struct {
+	chunks map[string][]model.Time
+}
+
+func (m *mockBucketClient) DeleteExpiredChunks(_ context.Context, expired func(userID string, through model.Time) bool, dryRun bool) (map[string]int, error) {
+	deleted := map[string]int{}
+	for userID, throughs := range m.chunks {
+		kept := throughs[:0]
+		for _, through := range throughs {
+			if !expired(userID, through) {
+				kept = append(kept, through)
+				continue
+			}
+			deleted[userID]++
+			if dryRun {
+				kept = append(kept, through)
+			}
+		}
+		m.chunks[userID] = kept
+	}
+	return deleted, nil
+}
+
+type mockRetentionLimits map[string]time.Duration
+
+func (l mockRetentionLimits) RetentionPeriod(userID string) time.Duration {
+	return l[userID]
+}
+
+func TestTableManagerDeleteExpiredChunks(t *testing.T) {
+	const day = 24 * time.Hour
+	now := model.TimeFromUnix(baseTableStart.Add(100 * day).Unix())
+	mtime.NowForce(now.Time())
+	defer mtime.NowReset()
+
+	newBucket := func() *mockBucketClient {
+		return &mockBucketClient{chunks: map[string][]model.Time{
+			"default": {now.Add(-20 * day), now.Add(-5 * day)},
+			"short":   {now.Add(-20 * day), now.Add(-5 * day), now.Add(-time.Hour)},
+			"old":     {now.Add(-90 * day)},
+		}}
+	}
+	limits := mockRetentionLimits{"short": 2 * day}
+
+	bucket := newBucket()
+	tableManager, err := NewTableManager(TableManagerConfig{RetentionPeriod: 14 * day, RetentionDeletesEnabled: true}, SchemaConfig{Configs: []PeriodConfig{{}}}, maxChunkAge, newMockTableClient(), bucket, nil, limits)
+	require.NoError(t, err)
+	require.NoError(t, tableManager.DeleteExpiredChunks(context.Background()))
+	require.Equal(t, map[string][]model.Time{
+		"default": {now.Add(-5 * day)},
+		"short":   {now.Add(-time.Hour)},
+		"old":     {},
+	}, bucket.chunks)
+
+	// Without a global retention period, only the chunks of the tenants with
+	// their own are deleted.
+	bucket = newBucket()
+	tableManager.cfg.RetentionPeriod = 0
+	tableManager.bucketClient = bucket
+	require.NoError(t, tableManager.DeleteExpiredChunks(context.Background()))
+	require.Equal(t, newBucket().chunks["default"], bucket.chunks["default"])
+	require.Equal(t, []model.Time{now.Add(-time.Hour)}, bucket.chunks["short"])
+
+	// Nothing is deleted in dry-run mode.
+	bucket = newBucket()
+	tableManager.cfg.RetentionDryRun = true
+	tableManager.bucketClient = bucket
+	require.NoError(t, tableManager.DeleteExpiredChunks(context.Background()))
+	require.Equal(t, newBucket().chunks, bucket.chunks)
+}
+
+func TestTableManagerDeleteExpiredIndexEntries(t *testing.T) {
+	const day = 24 * time.Hour
+	now := model.TimeFromUnix(int64((10*day + 12*time.Hour) / time.Second))
+	mtime.NowForce(now.Time())
+	defer mtime.NowReset()
+
+	seriesA := labels.Labels{{Name: labels.MetricName, Value: "foo"}, {Name: "bar", Value: "a"}}
+	seriesB := labels.Labels{{Name: labels.MetricName, Value: "foo"}, {Name: "bar", Value: "b"}}
+	newChunk := func(userID string, lbls labels.Labels, from model.Time) Chunk {
+		cs, _ := encoding.New().Add(model.SamplePair{Timestamp: from, Value: 1})
+		chunk := NewChunk(userID, model.Fingerprint(lbls.Hash()), lbls, cs[0], from, from.Add(time.Hour))
+		require.NoError(t, chunk.Encode())
+		return chunk
+	}
+	var (
+		expired = []Chunk{
+			newChunk("short", seriesA, now.Add(-9*day)),
+			newChunk("short", seriesB, now.Add(-9*day)),
+		}
+		kept = []Chunk{
+			newChunk("short", seriesB, now.Add(-day)),
+			newChunk("other", seriesA, now.Add(-9*day)),
+		}
+	)
+
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.MaxQueryLength = 30 * day
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+
+	// newStorage returns a storage with the index entries of the chunks.
+	newStorage := func(t *testing.T, schemaCfg SchemaConfig, chunks []Chunk) (*MockStorage, *TableManager) {
+		storage := NewMockStorage()
+		tbmConfig := TableManagerConfig{RetentionDeletesEnabled: true, IndexGCEnabled: true}
+		tableManager, err := NewTableManager(tbmConfig, schemaCfg, maxChunkAge, storage, nil, storage, mockRetentionLimits{"short": 2 * day})
+		require.NoError(t, err)
+		require.NoError(t, tableManager.SyncTables(context.Background()))
+
+		store := NewCompositeStore()
+		require.NoError(t, store.AddPeriod(StoreConfig{}, schemaCfg.Configs[0], storage, storage, overrides))
+		for _, chunk := range chunks {
+			require.NoError(t, store.Put(user.InjectOrgID(context.Background(), chunk.UserID), []Chunk{chunk}))
+		}
+		return storage, tableManager
+	}
+	indexEntries := func(t *testing.T, storage *MockStorage) map[string]bool {
+		tables, err := storage.ListTables(context.Background())
+		require.NoError(t, err)
+		entries := map[string]bool{}
+		for _, table := range tables {
+			require.NoError(t, storage.ScanTable(context.Background(), table, func(entry IndexEntry) bool {
+				entries[entry.TableName+":"+entry.HashValue+":"+string(entry.RangeValue)] = true
+				return true
+			}))
+		}
+		return entries
+	}
+
+	for _, schema := range schemas {
+		t.Run(schema.name, func(t *testing.T) {
+			schemaCfg := DefaultSchemaConfig("", schema.name, 0)
+			storage, tableManager := newStorage(t, schemaCfg, append(append([]Chunk{}, expired...), kept...))
+			before := indexEntries(t, storage)
+
+			// Nothing is deleted in dry-run mode.
+			tableManager.cfg.RetentionDryRun = true
+			require.NoError(t, tableManager.DeleteExpiredIndexEntries(context.Background()))
+			require.Equal(t, before, indexEntries(t, storage))
+
+			// Only the entries of the kept chunks are left, including the label
+			// entries of their series.
+			tableManager.cfg.RetentionDryRun = false
+			require.NoError(t, tableManager.DeleteExpiredIndexEntries(context.Background()))
+			expected, _ := newStorage(t, schemaCfg, kept)
+			require.Equal(t, indexEntries(t, expected), indexEntries(t, storage))
+		})
+	}
+}
+
+func TestTableManagerReloadSchema(t *testing.T) {
+	client := newMockTableClient()
+
+	base := PeriodConfig{
+		From:        DayTime{model.TimeFromUnix(baseTableStart.Unix())},
+		Schema:      "v9",
+		IndexTables: PeriodicTableConfig{Prefix: baseTableName},
+	}
+	weekly := PeriodConfig{
+		From:   DayTime{model.TimeFromUnix(weeklyTableStart.Unix())},
+		Schema: "v9",
+		IndexTables: PeriodicTableConfig{
+			Prefix: tablePrefix,
+			Period: tablePeriod,
+		},
+	}
+	tableManager, err := NewTableManager(TableManagerConfig{}, SchemaConfig{Configs: []PeriodConfig{base}}, maxChunkAge, client, nil, nil, nil)
+	require.NoError(t, err)
+
+	mtime.NowForce(baseTableStart.Add(tablePeriod))
+	// The period of the tables already created can't be changed.
+	changed := base
+	changed.IndexTables.Prefix = tablePrefix
+	require.Error(t, tableManager.ReloadSchema(SchemaConfig{Configs: []PeriodConfig{changed}}))
+	require.NoError(t, tableManager.ReloadSchema(SchemaConfig{Configs: []PeriodConfig{base, weekly}}))
+	mtime.NowReset()
+
+	tmTest(t, client, tableManager,
+		"Before the new period",
+		baseTableStart.Add(tablePeriod),
+		[]TableDesc{
+			{Name: baseTableName},
+		},
+	)
+	tmTest(t, client, tableManager,
+		"In the new period",
+		weeklyTableStart.Add(time.Hour),
+		[]TableDesc{
+			{Name: baseTableName},
+			{Name: tablePrefix + week1Suffix},
+		},
+	)
+}
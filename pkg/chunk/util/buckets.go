@@ -0,0 +1,19 @@
+package util
+
+// BucketLimits are the per-tenant buckets of the object stores, so that the
+// policies of the object stores can isolate the tenants.
+type BucketLimits interface {
+	ObjectStoreBucketName(userID string) string
+}
+
+// TenantBucket returns the bucket of the chunks of a tenant, empty for them to
+// be in the buckets of the config of the object store, or if limits is nil.
+// The chunks written before the tenant had a bucket stay in the buckets of the
+// config, so they're read from there when missing from the bucket of the
+// tenant.
+func TenantBucket(limits BucketLimits, userID string) string {
+	if limits == nil {
+		return ""
+	}
+	return limits.ObjectStoreBucketName(userID)
+}
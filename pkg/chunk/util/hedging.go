@@ -0,0 +1,207 @@
+package util
+
+import (
+	"context"
+	"flag"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// The latency quantile is computed over the latest hedgingSamples
+	// requests, every hedgingRecomputeEvery requests.
+	hedgingSamples        = 1000
+	hedgingRecomputeEvery = 100
+
+	// hedgingBurst is the number of hedged requests the budget allows before
+	// any request has been made.
+	hedgingBurst = 10
+)
+
+var (
+	hedgedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "object_store_hedged_requests_total",
+		Help:      "Number of hedged requests sent for slow object store requests.",
+	}, []string{"backend", "operation"})
+	hedgedRequestsWon = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "object_store_hedged_requests_won_total",
+		Help:      "Number of hedged object store requests which completed before the request they hedged.",
+	}, []string{"backend", "operation"})
+	hedgedRequestsOverBudget = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "object_store_hedged_requests_over_budget_total",
+		Help:      "Number of hedged object store requests not sent as they were over budget.",
+	}, []string{"backend", "operation"})
+)
+
+// HedgingConfig is the config of the hedging of the requests to an object
+// store.
+type HedgingConfig struct {
+	Quantile float64       `yaml:"quantile"`
+	MinDelay time.Duration `yaml:"min_delay"`
+	MaxRatio float64       `yaml:"max_ratio"`
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given
+// FlagSet, prefixed with the name of the object store.
+func (cfg *HedgingConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.Float64Var(&cfg.Quantile, prefix+".hedging.quantile", 0, "Quantile of the latency of the reads of the chunks past which a second read is sent, and the first response used, e.g. 0.99; 0 to disable hedging.")
+	f.DurationVar(&cfg.MinDelay, prefix+".hedging.min-delay", 0, "Minimum delay before sending a hedged read.")
+	f.Float64Var(&cfg.MaxRatio, prefix+".hedging.max-ratio", 0.05, "Maximum number of hedged reads per read. 0 for no limit.")
+}
+
+// Hedger sends a second request for the requests slower than a quantile of
+// the latency of the latest requests, and returns the first response, within
+// a budget of hedged requests per request. A nil Hedger doesn't hedge.
+type Hedger struct {
+	cfg      HedgingConfig
+	hedged   prometheus.Counter
+	won      prometheus.Counter
+	rejected prometheus.Counter
+
+	mtx       sync.Mutex
+	samples   []time.Duration
+	observed  int
+	threshold time.Duration
+	balance   float64
+}
+
+// NewHedger makes a new Hedger of the requests of an operation of an object
+// store; nil if hedging is disabled.
+func NewHedger(backend, operation string, cfg HedgingConfig) *Hedger {
+	if cfg.Quantile <= 0 {
+		return nil
+	}
+	return &Hedger{
+		cfg:      cfg,
+		hedged:   hedgedRequests.WithLabelValues(backend, operation),
+		won:      hedgedRequestsWon.WithLabelValues(backend, operation),
+		rejected: hedgedRequestsOverBudget.WithLabelValues(backend, operation),
+		samples:  make([]time.Duration, 0, hedgingSamples),
+		balance:  hedgingBurst,
+	}
+}
+
+type hedgeResult struct {
+	buf      []byte
+	err      error
+	duration time.Duration
+	hedge    bool
+}
+
+// Get does a request with f, hedging it if it's slow. The slower request is
+// cancelled once there's a response.
+func (h *Hedger) Get(ctx context.Context, f func(context.Context) ([]byte, error)) ([]byte, error) {
+	if h == nil {
+		return f(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	do := func(hedge bool) {
+		start := time.Now()
+		buf, err := f(ctx)
+		results <- hedgeResult{buf: buf, err: err, duration: time.Since(start), hedge: hedge}
+	}
+	go do(false)
+
+	var hedge <-chan time.Time
+	if threshold, ok := h.request(); ok {
+		timer := time.NewTimer(threshold)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+
+	pending := 1
+	for {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil || pending == 0 {
+				if result.err == nil {
+					h.observe(result.duration)
+					if result.hedge {
+						h.won.Inc()
+					}
+				}
+				return result.buf, result.err
+			}
+
+		case <-hedge:
+			hedge = nil
+			if h.withdraw() {
+				h.hedged.Inc()
+				pending++
+				go do(true)
+			} else {
+				h.rejected.Inc()
+			}
+		}
+	}
+}
+
+// request adds a request to the budget, and returns the delay after which it's
+// hedged, false if not enough requests completed yet.
+func (h *Hedger) request() (time.Duration, bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.balance = math.Min(h.balance+h.cfg.MaxRatio, hedgingBurst)
+	return h.threshold, h.observed >= hedgingRecomputeEvery
+}
+
+// withdraw returns whether a hedged request is within the budget, and takes
+// it out of the budget if so.
+func (h *Hedger) withdraw() bool {
+	if h.cfg.MaxRatio <= 0 {
+		return true
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.balance < 1 {
+		return false
+	}
+	h.balance--
+	return true
+}
+
+// observe records the latency of a successful request, and updates the
+// threshold every hedgingRecomputeEvery requests.
+func (h *Hedger) observe(d time.Duration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if len(h.samples) < hedgingSamples {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.observed%hedgingSamples] = d
+	}
+	h.observed++
+	if h.observed%hedgingRecomputeEvery != 0 {
+		return
+	}
+
+	samples := make([]time.Duration, len(h.samples))
+	copy(samples, h.samples)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(math.Ceil(h.cfg.Quantile*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	h.threshold = samples[i]
+	if h.threshold < h.cfg.MinDelay {
+		h.threshold = h.cfg.MinDelay
+	}
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// warmHedger returns a hedger which observed enough requests of the given
+// latency to hedge.
+func warmHedger(cfg HedgingConfig, latency time.Duration) *Hedger {
+	h := NewHedger("test", "Get", cfg)
+	for i := 0; i < hedgingRecomputeEvery; i++ {
+		h.observe(latency)
+	}
+	return h
+}
+
+func TestHedgerDisabled(t *testing.T) {
+	require.Nil(t, NewHedger("test", "Get", HedgingConfig{}))
+
+	var h *Hedger
+	buf, err := h.Get(context.Background(), func(context.Context) ([]byte, error) {
+		return []byte("chunk"), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("chunk"), buf)
+}
+
+func TestHedgerThreshold(t *testing.T) {
+	h := NewHedger("test", "Get", HedgingConfig{Quantile: 0.99, MinDelay: 5 * time.Millisecond})
+
+	// No hedging until enough requests completed.
+	for i := 1; i < hedgingRecomputeEvery; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	_, ok := h.request()
+	require.False(t, ok)
+
+	h.observe(100 * time.Millisecond)
+	threshold, ok := h.request()
+	require.True(t, ok)
+	require.Equal(t, 99*time.Millisecond, threshold)
+
+	// The threshold is at least the min delay.
+	h = warmHedger(HedgingConfig{Quantile: 0.99, MinDelay: 5 * time.Millisecond}, time.Millisecond)
+	threshold, _ = h.request()
+	require.Equal(t, 5*time.Millisecond, threshold)
+}
+
+func TestHedgerGet(t *testing.T) {
+	h := warmHedger(HedgingConfig{Quantile: 0.99}, time.Millisecond)
+
+	// The first request hangs until cancelled; the hedged one responds.
+	var calls int32
+	buf, err := h.Get(context.Background(), func(ctx context.Context) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return []byte("hedged"), nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hedged"), buf)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// A request failing before the threshold isn't hedged.
+	calls = 0
+	_, err = h.Get(context.Background(), func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, fmt.Errorf("not found")
+	})
+	require.Error(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHedgerBudget(t *testing.T) {
+	h := warmHedger(HedgingConfig{Quantile: 0.99, MaxRatio: 0.001}, time.Millisecond)
+
+	var calls int32
+	slow := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+		return nil, nil
+	}
+
+	// Only the burst of the budget is hedged.
+	for i := 0; i < hedgingBurst+5; i++ {
+		_, err := h.Get(context.Background(), slow)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(2*hedgingBurst+5), atomic.LoadInt32(&calls))
+}
@@ -2,43 +2,133 @@ package util
 
 import (
 	"context"
+	"flag"
+	"sync"
+	"time"
 
 	ot "github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 )
 
 const maxParallel = 1000
 
+// The weight of the latest fetch in the moving average of the latency.
+const latencyDecay = 0.1
+
+var (
+	chunkFetchInflight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cortex",
+		Name:      "chunk_fetch_inflight_batches",
+		Help:      "Number of batches of chunks being fetched from the object store.",
+	}, []string{"backend"})
+	chunkFetchBatchSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cortex",
+		Name:      "chunk_fetch_batch_size",
+		Help:      "Number of chunks of the batches fetched from the object store.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+	}, []string{"backend"})
+)
+
+// FetchConfig is the config of the fetching of the chunks from an object
+// store.
+type FetchConfig struct {
+	MaxParallelism     int           `yaml:"max_parallelism"`
+	MaxBatchSize       int           `yaml:"max_batch_size"`
+	TargetBatchLatency time.Duration `yaml:"target_batch_latency"`
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given
+// FlagSet, prefixed with the name of the object store.
+func (cfg *FetchConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxParallelism, prefix+".chunk-fetch.max-parallelism", maxParallel, "Maximum number of batches of chunks fetched at once from the object store, across all the queries.")
+	f.IntVar(&cfg.MaxBatchSize, prefix+".chunk-fetch.max-batch-size", 16, "Maximum number of chunks fetched one after the other by a worker.")
+	f.DurationVar(&cfg.TargetBatchLatency, prefix+".chunk-fetch.target-batch-latency", 0, "Target duration of the batches of chunks, sized from the average latency of the fetches so that the queries share the workers; 0 to fetch the chunks one by one.")
+}
+
+// RegisterMaxParallelismFlagWithPrefix only adds the max parallelism flag,
+// for the object stores fetching the chunks in batches of their own.
+func (cfg *FetchConfig) RegisterMaxParallelismFlagWithPrefix(prefix string, f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxParallelism, prefix+".chunk-fetch.max-parallelism", maxParallel, "Maximum number of requests fetching chunks at once from the object store, across all the queries.")
+}
+
+// ParallelFetcher fetches chunks from an object store in batches, in
+// parallel, with at most MaxParallelism batches being fetched at once across
+// all the calls. The batches are sized from the average latency of the
+// fetches to take about TargetBatchLatency. The concurrent fetches of a chunk
+// by several calls are coalesced into one.
+type ParallelFetcher struct {
+	cfg       FetchConfig
+	sem       chan struct{}
+	inflight  prometheus.Gauge
+	batchSize prometheus.Observer
+
+	mtx     sync.Mutex
+	latency time.Duration
+	calls   map[string]*fetchCall
+}
+
+// fetchCall is a fetch of a chunk, which the concurrent fetches of the same
+// chunk wait for.
+type fetchCall struct {
+	done  chan struct{}
+	chunk chunk.Chunk
+	err   error
+}
+
+// NewParallelFetcher makes a new ParallelFetcher; backend names the object
+// store in the metrics.
+func NewParallelFetcher(backend string, cfg FetchConfig) *ParallelFetcher {
+	if cfg.MaxParallelism <= 0 {
+		cfg.MaxParallelism = maxParallel
+	}
+	if cfg.MaxBatchSize <= 0 {
+		cfg.MaxBatchSize = 1
+	}
+	return &ParallelFetcher{
+		cfg:       cfg,
+		sem:       make(chan struct{}, cfg.MaxParallelism),
+		inflight:  chunkFetchInflight.WithLabelValues(backend),
+		batchSize: chunkFetchBatchSize.WithLabelValues(backend),
+		calls:     map[string]*fetchCall{},
+	}
+}
+
 // GetParallelChunks fetches chunks in parallel (up to maxParallel).
 func GetParallelChunks(ctx context.Context, chunks []chunk.Chunk, f func(context.Context, *chunk.DecodeContext, chunk.Chunk) (chunk.Chunk, error)) ([]chunk.Chunk, error) {
+	return NewParallelFetcher("", FetchConfig{}).GetChunks(ctx, chunks, f)
+}
+
+// GetChunks fetches the chunks with f, returning those it did fetch along
+// with the last error.
+func (p *ParallelFetcher) GetChunks(ctx context.Context, chunks []chunk.Chunk, f func(context.Context, *chunk.DecodeContext, chunk.Chunk) (chunk.Chunk, error)) ([]chunk.Chunk, error) {
 	sp, ctx := ot.StartSpanFromContext(ctx, "GetParallelChunks")
 	defer sp.Finish()
 	sp.LogFields(otlog.Int("chunks requested", len(chunks)))
 
-	queuedChunks := make(chan chunk.Chunk)
+	size := p.nextBatchSize()
+	sp.LogFields(otlog.Int("batch size", size))
 
+	queuedBatches := make(chan []chunk.Chunk)
 	go func() {
-		for _, c := range chunks {
-			queuedChunks <- c
+		for i := 0; i < len(chunks); i += size {
+			queuedBatches <- chunks[i:min(i+size, len(chunks))]
 		}
-		close(queuedChunks)
+		close(queuedBatches)
 	}()
 
 	processedChunks := make(chan chunk.Chunk)
 	errors := make(chan error)
 
-	for i := 0; i < min(maxParallel, len(chunks)); i++ {
+	workers := min(p.cfg.MaxParallelism, (len(chunks)+size-1)/size)
+	for i := 0; i < workers; i++ {
 		go func() {
 			decodeContext := chunk.NewDecodeContext()
-			for c := range queuedChunks {
-				c, err := f(ctx, decodeContext, c)
-				if err != nil {
-					errors <- err
-				} else {
-					processedChunks <- c
-				}
+			for batch := range queuedBatches {
+				p.fetchBatch(ctx, decodeContext, batch, f, processedChunks, errors)
 			}
 		}()
 	}
@@ -63,6 +153,112 @@ func GetParallelChunks(ctx context.Context, chunks []chunk.Chunk, f func(context
 	return result, lastErr
 }
 
+// fetchBatch fetches the chunks of a batch one after the other, once there's
+// room for another batch.
+func (p *ParallelFetcher) fetchBatch(ctx context.Context, decodeContext *chunk.DecodeContext, batch []chunk.Chunk, f func(context.Context, *chunk.DecodeContext, chunk.Chunk) (chunk.Chunk, error),
+	processedChunks chan<- chunk.Chunk, errors chan<- error) {
+
+	release, err := p.Acquire(ctx)
+	if err != nil {
+		for range batch {
+			errors <- err
+		}
+		return
+	}
+	defer release()
+	p.batchSize.Observe(float64(len(batch)))
+
+	for _, c := range batch {
+		c, err := p.fetch(ctx, decodeContext, c, f)
+		if err != nil {
+			errors <- err
+			continue
+		}
+		processedChunks <- c
+	}
+}
+
+// Acquire waits for room for another batch, or request, fetching chunks,
+// returning the function releasing it.
+func (p *ParallelFetcher) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	p.inflight.Inc()
+	return func() {
+		p.inflight.Dec()
+		<-p.sem
+	}, nil
+}
+
+// fetch fetches a chunk with f, unless it's being fetched already by another
+// call, in which case the chunk it fetches is returned.
+func (p *ParallelFetcher) fetch(ctx context.Context, decodeContext *chunk.DecodeContext, c chunk.Chunk, f func(context.Context, *chunk.DecodeContext, chunk.Chunk) (chunk.Chunk, error)) (chunk.Chunk, error) {
+	key := c.ExternalKey()
+	for {
+		p.mtx.Lock()
+		call, ok := p.calls[key]
+		if !ok {
+			break
+		}
+		p.mtx.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return c, ctx.Err()
+		}
+		// The fetch failed, e.g. canceled with its call, is done again.
+		if call.err == nil {
+			return call.chunk, nil
+		}
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	p.calls[key] = call
+	p.mtx.Unlock()
+
+	start := time.Now()
+	call.chunk, call.err = f(ctx, decodeContext, c)
+	if call.err == nil {
+		p.observeLatency(time.Since(start))
+	}
+
+	p.mtx.Lock()
+	delete(p.calls, key)
+	p.mtx.Unlock()
+	close(call.done)
+	return call.chunk, call.err
+}
+
+func (p *ParallelFetcher) observeLatency(latency time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.latency == 0 {
+		p.latency = latency
+		return
+	}
+	p.latency += time.Duration(latencyDecay * float64(latency-p.latency))
+}
+
+// nextBatchSize returns the number of chunks of the batches fetching for about
+// the target latency, given the average latency of the fetches.
+func (p *ParallelFetcher) nextBatchSize() int {
+	p.mtx.Lock()
+	latency := p.latency
+	p.mtx.Unlock()
+
+	if p.cfg.TargetBatchLatency <= 0 || latency <= 0 {
+		return 1
+	}
+	size := int(p.cfg.TargetBatchLatency / latency)
+	if size < 1 {
+		return 1
+	}
+	return min(size, p.cfg.MaxBatchSize)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,164 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+func makeChunks(n int) []chunk.Chunk {
+	chunks := make([]chunk.Chunk, 0, n)
+	for i := 0; i < n; i++ {
+		chunks = append(chunks, chunk.Chunk{UserID: "userID", Fingerprint: model.Fingerprint(i)})
+	}
+	return chunks
+}
+
+func TestParallelFetcherMaxParallelism(t *testing.T) {
+	fetcher := NewParallelFetcher("test", FetchConfig{MaxParallelism: 3, MaxBatchSize: 1})
+
+	var inflight, maxInflight int32
+	fetch := func(_ context.Context, _ *chunk.DecodeContext, c chunk.Chunk) (chunk.Chunk, error) {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+		return c, nil
+	}
+
+	// The limit holds across the concurrent calls.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunks, err := fetcher.GetChunks(context.Background(), makeChunks(20), fetch)
+			require.NoError(t, err)
+			require.Len(t, chunks, 20)
+		}()
+	}
+	wg.Wait()
+	require.True(t, maxInflight <= 3, "max inflight %d", maxInflight)
+}
+
+func TestParallelFetcherBatchSize(t *testing.T) {
+	fetcher := NewParallelFetcher("test", FetchConfig{MaxParallelism: 10, MaxBatchSize: 8, TargetBatchLatency: 40 * time.Millisecond})
+
+	// Without any latency observed the chunks are fetched one by one.
+	require.Equal(t, 1, fetcher.nextBatchSize())
+
+	fetcher.observeLatency(10 * time.Millisecond)
+	require.Equal(t, 4, fetcher.nextBatchSize())
+
+	// The latency is a moving average.
+	fetcher.observeLatency(time.Millisecond)
+	require.Equal(t, 9100*time.Microsecond, fetcher.latency)
+
+	// The batches are capped by the max batch size...
+	fetcher.latency = time.Millisecond
+	require.Equal(t, 8, fetcher.nextBatchSize())
+
+	// ...and hold at least a chunk.
+	fetcher.latency = time.Second
+	require.Equal(t, 1, fetcher.nextBatchSize())
+}
+
+func TestParallelFetcherPartialResult(t *testing.T) {
+	for _, cfg := range []FetchConfig{
+		{MaxParallelism: 2, MaxBatchSize: 1},
+		{MaxParallelism: 2, MaxBatchSize: 4, TargetBatchLatency: time.Hour},
+	} {
+		t.Run(fmt.Sprintf("%+v", cfg), func(t *testing.T) {
+			fetcher := NewParallelFetcher("test", cfg)
+			fetcher.observeLatency(time.Millisecond)
+
+			fetch := func(_ context.Context, _ *chunk.DecodeContext, c chunk.Chunk) (chunk.Chunk, error) {
+				if c.Fingerprint%3 == 0 {
+					return chunk.Chunk{}, fmt.Errorf("failed to fetch chunk %d", c.Fingerprint)
+				}
+				return c, nil
+			}
+			chunks, err := fetcher.GetChunks(context.Background(), makeChunks(10), fetch)
+			require.Error(t, err)
+			require.Len(t, chunks, 6)
+		})
+	}
+}
+
+func TestParallelFetcherCancelled(t *testing.T) {
+	fetcher := NewParallelFetcher("test", FetchConfig{MaxParallelism: 1, MaxBatchSize: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fetch := func(ctx context.Context, _ *chunk.DecodeContext, c chunk.Chunk) (chunk.Chunk, error) {
+		return c, ctx.Err()
+	}
+	_, err := fetcher.GetChunks(ctx, makeChunks(5), fetch)
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestParallelFetcherCoalescesFetches(t *testing.T) {
+	fetcher := NewParallelFetcher("test", FetchConfig{MaxParallelism: 10, MaxBatchSize: 1})
+
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func(_ context.Context, _ *chunk.DecodeContext, c chunk.Chunk) (chunk.Chunk, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return c, nil
+	}
+
+	// The concurrent fetches of the same chunks are done once.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunks, err := fetcher.GetChunks(context.Background(), makeChunks(2), fetch)
+			require.NoError(t, err)
+			require.Len(t, chunks, 2)
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// The failed fetches are done again by the calls waiting for them.
+	fetches = 0
+	errFetch := func(ctx context.Context, _ *chunk.DecodeContext, c chunk.Chunk) (chunk.Chunk, error) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			time.Sleep(10 * time.Millisecond)
+			return c, fmt.Errorf("failed")
+		}
+		return c, nil
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := fetcher.GetChunks(context.Background(), makeChunks(1), errFetch)
+			errs <- err
+		}()
+	}
+	failed := 0
+	for i := 0; i < 2; i++ {
+		if <-errs != nil {
+			failed++
+		}
+	}
+	require.Equal(t, 1, failed)
+}
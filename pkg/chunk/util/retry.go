@@ -0,0 +1,38 @@
+package util
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+var requestRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cortex",
+	Name:      "object_store_request_retries_total",
+	Help:      "Number of object store requests retried after failing.",
+}, []string{"backend", "operation"})
+
+// Retry does a request of an operation of an object store with f until it
+// succeeds, fails with an error that isn't retryable, or runs out of retries,
+// backing off between them.
+func Retry(ctx context.Context, backend, operation string, cfg util.BackoffConfig, retryable func(error) bool, f func(context.Context) error) error {
+	backoff := util.NewBackoff(ctx, cfg)
+	var err error
+	for backoff.Ongoing() {
+		if backoff.NumRetries() > 0 {
+			requestRetries.WithLabelValues(backend, operation).Inc()
+		}
+		err = f(ctx)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		backoff.Wait()
+	}
+	if err == nil {
+		err = backoff.Err()
+	}
+	return err
+}
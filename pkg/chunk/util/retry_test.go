@@ -0,0 +1,44 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+var errNotRetryable = fmt.Errorf("not retryable")
+
+func TestRetry(t *testing.T) {
+	cfg := util.BackoffConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetries: 3}
+	retryable := func(err error) bool { return err != errNotRetryable }
+
+	for _, tc := range []struct {
+		name  string
+		errs  []error
+		calls int
+		err   error
+	}{
+		{name: "success", calls: 1},
+		{name: "retried", errs: []error{fmt.Errorf("timeout")}, calls: 2},
+		{name: "not retryable", errs: []error{errNotRetryable}, calls: 1, err: errNotRetryable},
+		{name: "out of retries", errs: []error{fmt.Errorf("1"), fmt.Errorf("2"), fmt.Errorf("3")}, calls: 3, err: fmt.Errorf("3")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := Retry(context.Background(), "test", "Get", cfg, retryable, func(context.Context) error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			require.Equal(t, tc.err, err)
+			require.Equal(t, tc.calls, calls)
+		})
+	}
+}
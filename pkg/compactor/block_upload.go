@@ -0,0 +1,436 @@
+package compactor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/weaveworks/common/user"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// The meta file of a block being uploaded, in the directory of the block. The
+// meta file of the block is only written once the upload is complete and the
+// block validated, so that the block isn't queried nor compacted before.
+const uploadingMetaFilename = "uploading-meta.json"
+
+// uploadingMeta is the meta file of a block being uploaded, with the start
+// time of its upload.
+type uploadingMeta struct {
+	cortex_tsdb.Meta
+
+	// Unix timestamp, in seconds, of the start of the upload.
+	UploadStartedAt int64 `json:"cortex_upload_started_at"`
+}
+
+// The maximum size of the meta file of an uploaded block.
+const maxMetaSize = 1 << 20
+
+// The files of an uploaded block, besides its meta file.
+var blockFileRE = regexp.MustCompile(`^(index|chunks/[0-9]{6})$`)
+
+var blocksUploaded = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "cortex",
+	Name:      "compactor_blocks_uploaded_total",
+	Help:      "Total number of blocks uploaded through the block upload API.",
+})
+
+// StartBlockUploadHandler starts the upload of a block of the tenant of the
+// request, whose meta file is the body of the request. The meta file is
+// validated, the blocks out of the retention of the tenant rejected.
+func (c *Compactor) StartBlockUploadHandler(w http.ResponseWriter, req *http.Request) {
+	userID, id, ok := c.blockUploadRequest(w, req)
+	if !ok {
+		return
+	}
+	ctx := req.Context()
+
+	exists, err := c.bucket.Exists(ctx, path.Join(cortex_tsdb.BlockDir(userID, id), cortex_tsdb.MetaFilename))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, fmt.Sprintf("block %s already exists", id), http.StatusConflict)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxMetaSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	meta, err := cortex_tsdb.ParseMeta(buf)
+	if err == nil {
+		err = c.validateUploadedMeta(userID, id, meta)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid meta file: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	buf, err = json.Marshal(&uploadingMeta{Meta: *meta, UploadStartedAt: time.Now().Unix()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := c.bucket.Upload(ctx, path.Join(cortex_tsdb.BlockDir(userID, id), uploadingMetaFilename), bytes.NewReader(buf)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(util.Logger).Log("msg", "started block upload", "user", userID, "block", id)
+}
+
+// UploadBlockFileHandler uploads a file of a block being uploaded, the index
+// or a segment file of its chunks, named by the path parameter. The files over
+// the maximum file size of the tenant, or taking the block over its maximum
+// block size, are rejected with 413.
+func (c *Compactor) UploadBlockFileHandler(w http.ResponseWriter, req *http.Request) {
+	userID, id, ok := c.blockUploadRequest(w, req)
+	if !ok {
+		return
+	}
+	ctx := req.Context()
+
+	name := req.URL.Query().Get("path")
+	if !blockFileRE.MatchString(name) {
+		http.Error(w, fmt.Sprintf("invalid block file %q", name), http.StatusBadRequest)
+		return
+	}
+	if _, ok := c.readUploadingMeta(ctx, w, userID, id); !ok {
+		return
+	}
+
+	maxSize, err := c.maxUploadedFileSize(ctx, userID, id, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var body io.Reader = req.Body
+	if maxSize >= 0 {
+		if req.ContentLength > maxSize {
+			http.Error(w, fmt.Sprintf("block file %s of %d bytes is over the maximum size of %d bytes", name, req.ContentLength, maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		body = &limitedReader{r: body, n: maxSize}
+	}
+	if err := c.bucket.Upload(ctx, path.Join(cortex_tsdb.BlockDir(userID, id), name), body); err != nil {
+		if err == errUploadTooLarge {
+			// The part of the file uploaded would make the block invalid.
+			if err := c.bucket.Delete(ctx, path.Join(cortex_tsdb.BlockDir(userID, id), name)); err != nil && !c.bucket.IsObjNotFoundErr(err) {
+				level.Warn(util.Logger).Log("msg", "failed to delete the block file over the maximum size", "user", userID, "block", id, "file", name, "err", err)
+			}
+			http.Error(w, fmt.Sprintf("block file %s is over the maximum size of %d bytes", name, maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// maxUploadedFileSize returns the maximum size of a file of a block being
+// uploaded: the maximum file size of the tenant, and at most what's left of
+// its maximum block size by the other files of the block. -1 if unlimited.
+func (c *Compactor) maxUploadedFileSize(ctx context.Context, userID string, id ulid.ULID, name string) (int64, error) {
+	maxSize := c.limits.CompactorBlockUploadMaxFileSize(userID)
+	if maxSize <= 0 {
+		maxSize = -1
+	}
+	maxBlockSize := c.limits.CompactorBlockUploadMaxBlockSize(userID)
+	if maxBlockSize <= 0 {
+		return maxSize, nil
+	}
+
+	dir := cortex_tsdb.BlockDir(userID, id)
+	left := maxBlockSize
+	err := cortex_tsdb.Walk(ctx, c.bucket, dir, func(f string) error {
+		// The file replaced doesn't count.
+		if f == path.Join(dir, name) {
+			return nil
+		}
+		size, err := c.bucket.ObjectSize(ctx, f)
+		if c.bucket.IsObjNotFoundErr(err) {
+			return nil
+		}
+		left -= size
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if left < 0 {
+		left = 0
+	}
+	if maxSize < 0 || left < maxSize {
+		maxSize = left
+	}
+	return maxSize, nil
+}
+
+var errUploadTooLarge = errors.New("upload over the maximum size")
+
+// limitedReader reads at most n bytes from r, and fails with
+// errUploadTooLarge if r has more.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// Whether there's more to read than the limit.
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			return 0, errUploadTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// FinishBlockUploadHandler completes the upload of a block, once its files are
+// all uploaded. The block is downloaded and validated, then its meta file
+// written, with the stats of the block, so that it's queried and compacted
+// like the blocks shipped by the ingesters.
+func (c *Compactor) FinishBlockUploadHandler(w http.ResponseWriter, req *http.Request) {
+	userID, id, ok := c.blockUploadRequest(w, req)
+	if !ok {
+		return
+	}
+	ctx := req.Context()
+
+	meta, ok := c.readUploadingMeta(ctx, w, userID, id)
+	if !ok {
+		return
+	}
+
+	dir := filepath.Join(c.cfg.DataDir, "upload", userID, id.String())
+	if err := os.RemoveAll(dir); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	if err := cortex_tsdb.DownloadBlock(ctx, c.bucket, userID, id, dir); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := cortex_tsdb.WriteLocalMeta(dir, meta); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := validateUploadedBlock(dir, meta); err != nil {
+		http.Error(w, fmt.Sprintf("invalid block: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(meta.Compaction.Sources) == 0 {
+		meta.Compaction.Sources = []ulid.ULID{id}
+	}
+	if meta.Compaction.Level == 0 {
+		meta.Compaction.Level = 1
+	}
+	if err := cortex_tsdb.WriteLocalMeta(dir, meta); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The uploading meta file is deleted before the block is published, so
+	// that its files can't be uploaded again once it's queried; the block is
+	// not published if it fails, and its upload has to be started again.
+	if err := c.bucket.Delete(ctx, path.Join(cortex_tsdb.BlockDir(userID, id), uploadingMetaFilename)); err != nil && !c.bucket.IsObjNotFoundErr(err) {
+		http.Error(w, fmt.Sprintf("failed to delete the uploading meta file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := uploadLocalFile(ctx, c.bucket, filepath.Join(dir, cortex_tsdb.MetaFilename), path.Join(cortex_tsdb.BlockDir(userID, id), cortex_tsdb.MetaFilename)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	blocksUploaded.Inc()
+	level.Info(util.Logger).Log("msg", "finished block upload", "user", userID, "block", id, "series", meta.Stats.NumSeries)
+}
+
+// blockUploadRequest returns the tenant and the block of a block upload
+// request, failing it if the tenant can't upload blocks.
+func (c *Compactor) blockUploadRequest(w http.ResponseWriter, req *http.Request) (string, ulid.ULID, bool) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", ulid.ULID{}, false
+	}
+	userID, err := user.ExtractOrgID(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", ulid.ULID{}, false
+	}
+	if c.limits == nil || !c.limits.CompactorBlockUploadEnabled(userID) {
+		http.Error(w, "block upload is disabled for this tenant", http.StatusForbidden)
+		return "", ulid.ULID{}, false
+	}
+	id, err := ulid.Parse(mux.Vars(req)["block"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid block ID: %v", err), http.StatusBadRequest)
+		return "", ulid.ULID{}, false
+	}
+	return userID, id, true
+}
+
+// readUploadingMeta reads the meta file of a block being uploaded, failing the
+// request if the upload wasn't started.
+func (c *Compactor) readUploadingMeta(ctx context.Context, w http.ResponseWriter, userID string, id ulid.ULID) (*cortex_tsdb.Meta, bool) {
+	r, err := c.bucket.Get(ctx, path.Join(cortex_tsdb.BlockDir(userID, id), uploadingMetaFilename))
+	if c.bucket.IsObjNotFoundErr(err) {
+		http.Error(w, fmt.Sprintf("upload of block %s not started", id), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	meta, err := cortex_tsdb.ParseMeta(buf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return meta, true
+}
+
+// validateUploadedMeta validates the meta file of a block uploaded by a
+// tenant.
+func (c *Compactor) validateUploadedMeta(userID string, id ulid.ULID, meta *cortex_tsdb.Meta) error {
+	if meta.ULID != id {
+		return fmt.Errorf("block ID %s doesn't match the block uploaded %s", meta.ULID, id)
+	}
+	if meta.MinTime >= meta.MaxTime {
+		return fmt.Errorf("min time %d isn't before max time %d", meta.MinTime, meta.MaxTime)
+	}
+	now := time.Now()
+	if meta.MaxTime > now.UnixNano()/int64(time.Millisecond) {
+		return fmt.Errorf("max time %d is in the future", meta.MaxTime)
+	}
+	if retention := c.limits.CompactorBlocksRetentionPeriod(userID); retention > 0 && meta.MaxTime <= now.Add(-retention).UnixNano()/int64(time.Millisecond) {
+		return fmt.Errorf("block is out of the retention period %s of the tenant", retention)
+	}
+	if meta.Shard != nil {
+		return fmt.Errorf("block is a shard of a split block")
+	}
+	return nil
+}
+
+// validateUploadedBlock validates the series of an uploaded block in dir,
+// their labels and the time range of their chunks, and sets the stats of its
+// meta.
+func validateUploadedBlock(dir string, meta *cortex_tsdb.Meta) error {
+	b, err := tsdb.OpenBlock(util.Logger, dir, nil)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	ir, err := b.Index()
+	if err != nil {
+		return err
+	}
+	defer ir.Close()
+	cr, err := b.Chunks()
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+
+	postings, err := ir.Postings(index.AllPostingsKey())
+	if err != nil {
+		return err
+	}
+	var (
+		stats tsdb.BlockStats
+		lset  labels.Labels
+		chks  []chunks.Meta
+	)
+	for postings.Next() {
+		if err := ir.Series(postings.At(), &lset, &chks); err != nil {
+			return err
+		}
+		if err := validateLabels(lset); err != nil {
+			return fmt.Errorf("series %s: %v", lset, err)
+		}
+		for _, chk := range chks {
+			// The max time of the blocks is exclusive.
+			if chk.MinTime < meta.MinTime || chk.MaxTime >= meta.MaxTime {
+				return fmt.Errorf("series %s: chunk %d-%d out of the block time range", lset, chk.MinTime, chk.MaxTime)
+			}
+			c, err := cr.Chunk(chk.Ref)
+			if err != nil {
+				return fmt.Errorf("series %s: %v", lset, err)
+			}
+			stats.NumChunks++
+			stats.NumSamples += uint64(c.NumSamples())
+		}
+		stats.NumSeries++
+	}
+	if err := postings.Err(); err != nil {
+		return err
+	}
+	meta.Stats = stats
+	return nil
+}
+
+// validateLabels validates the labels of a series, which must have a valid
+// metric name and valid label names, sorted and unique.
+func validateLabels(lset labels.Labels) error {
+	if !model.IsValidMetricName(model.LabelValue(lset.Get(model.MetricNameLabel))) {
+		return fmt.Errorf("invalid metric name")
+	}
+	for i, l := range lset {
+		if !model.LabelName(l.Name).IsValid() {
+			return fmt.Errorf("invalid label name %q", l.Name)
+		}
+		if !utf8.ValidString(l.Value) {
+			return fmt.Errorf("invalid value of label %q", l.Name)
+		}
+		if i > 0 && l.Name <= lset[i-1].Name {
+			return fmt.Errorf("labels not sorted or duplicated")
+		}
+	}
+	return nil
+}
+
+func uploadLocalFile(ctx context.Context, bkt cortex_tsdb.Bucket, file, name string) error {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return bkt.Upload(ctx, name, bytes.NewReader(buf))
+}
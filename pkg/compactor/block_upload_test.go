@@ -0,0 +1,289 @@
+package compactor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// blockUploadRouter routes the block upload requests like the server.
+func blockUploadRouter(c *Compactor) *mux.Router {
+	r := mux.NewRouter()
+	r.Path("/api/v1/upload/block/{block}/start").HandlerFunc(c.StartBlockUploadHandler)
+	r.Path("/api/v1/upload/block/{block}/files").HandlerFunc(c.UploadBlockFileHandler)
+	r.Path("/api/v1/upload/block/{block}/finish").HandlerFunc(c.FinishBlockUploadHandler)
+	return r
+}
+
+func uploadRequest(t *testing.T, r *mux.Router, userID, url string, body []byte) int {
+	req := httptest.NewRequest("POST", url, bytes.NewReader(body))
+	req = req.WithContext(user.InjectOrgID(req.Context(), userID))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func readFile(t *testing.T, file string) []byte {
+	buf, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	return buf
+}
+
+func TestBlockUpload(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.CompactorBlockUploadEnabled = true
+	limits.CompactorBlocksRetentionPeriod = 30 * 24 * time.Hour
+	c.limits, err = validation.NewOverrides(limits)
+	require.NoError(t, err)
+	r := blockUploadRouter(c)
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	local := filepath.Join(dir, "local")
+	id, err := testutil.CreateBlock(local, testSeries, now-4*hour, now-2*hour, hour/60)
+	require.NoError(t, err)
+	blockDir := testutil.BlockDir(local, id)
+	meta := readFile(t, filepath.Join(blockDir, cortex_tsdb.MetaFilename))
+	url := "/api/v1/upload/block/" + id.String()
+
+	// The files can't be uploaded before the upload is started.
+	require.Equal(t, http.StatusNotFound, uploadRequest(t, r, "user", url+"/files?path=index", readFile(t, filepath.Join(blockDir, "index"))))
+	require.Equal(t, http.StatusNotFound, uploadRequest(t, r, "user", url+"/finish", nil))
+	require.Equal(t, http.StatusBadRequest, uploadRequest(t, r, "user", "/api/v1/upload/block/"+ulid.MustNew(1, nil).String()+"/start", meta))
+
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/start", meta))
+	require.Equal(t, http.StatusBadRequest, uploadRequest(t, r, "user", url+"/files?path=../other", nil))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=index", readFile(t, filepath.Join(blockDir, "index"))))
+
+	// The block isn't listed as complete until finished.
+	require.Empty(t, readMetas(t, bkt, "user"))
+	require.Equal(t, http.StatusBadRequest, uploadRequest(t, r, "user", url+"/finish", nil))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=chunks/000001", readFile(t, filepath.Join(blockDir, "chunks", "000001"))))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/finish", nil))
+
+	metas := readMetas(t, bkt, "user")
+	require.Len(t, metas, 1)
+	require.Equal(t, id, metas[0].ULID)
+	require.Equal(t, uint64(len(testSeries)), metas[0].Stats.NumSeries)
+	require.Equal(t, uint64(len(testSeries)*121), metas[0].Stats.NumSamples)
+	require.Equal(t, []ulid.ULID{id}, metas[0].Compaction.Sources)
+	exists, err := bkt.Exists(context.Background(), path.Join(cortex_tsdb.BlockDir("user", id), uploadingMetaFilename))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// The blocks can't be uploaded twice.
+	require.Equal(t, http.StatusConflict, uploadRequest(t, r, "user", url+"/start", meta))
+
+	// The tenants need to be allowed to upload blocks.
+	limits.CompactorBlockUploadEnabled = false
+	c.limits, err = validation.NewOverrides(limits)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, uploadRequest(t, r, "other", url+"/start", meta))
+}
+
+func TestBlockUploadValidation(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.CompactorBlockUploadEnabled = true
+	limits.CompactorBlocksRetentionPeriod = 24 * time.Hour
+	c.limits, err = validation.NewOverrides(limits)
+	require.NoError(t, err)
+	r := blockUploadRouter(c)
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, tc := range []struct {
+		name       string
+		mint, maxt int64
+		edit       func(*cortex_tsdb.Meta)
+		startCode  int
+		finishCode int
+	}{
+		{
+			name:      "out of retention",
+			mint:      now - 50*hour,
+			maxt:      now - 48*hour,
+			startCode: http.StatusBadRequest,
+		},
+		{
+			name:      "in the future",
+			mint:      now + hour,
+			maxt:      now + 2*hour,
+			startCode: http.StatusBadRequest,
+		},
+		{
+			name: "empty time range",
+			mint: now - 2*hour,
+			maxt: now - hour,
+			edit: func(m *cortex_tsdb.Meta) {
+				m.MaxTime = m.MinTime
+			},
+			startCode: http.StatusBadRequest,
+		},
+		{
+			name: "chunks out of the block time range",
+			mint: now - 2*hour,
+			maxt: now - hour,
+			edit: func(m *cortex_tsdb.Meta) {
+				m.MaxTime -= hour / 2
+			},
+			startCode:  http.StatusOK,
+			finishCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			local := filepath.Join(dir, "local", tc.name)
+			id, err := testutil.CreateBlock(local, testSeries, tc.mint, tc.maxt, hour/60)
+			require.NoError(t, err)
+			blockDir := testutil.BlockDir(local, id)
+			if tc.edit != nil {
+				meta, err := cortex_tsdb.ReadLocalMeta(blockDir)
+				require.NoError(t, err)
+				tc.edit(meta)
+				require.NoError(t, cortex_tsdb.WriteLocalMeta(blockDir, meta))
+			}
+
+			url := "/api/v1/upload/block/" + id.String()
+			require.Equal(t, tc.startCode, uploadRequest(t, r, "user", url+"/start", readFile(t, filepath.Join(blockDir, cortex_tsdb.MetaFilename))))
+			if tc.startCode != http.StatusOK {
+				return
+			}
+			require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=index", readFile(t, filepath.Join(blockDir, "index"))))
+			require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=chunks/000001", readFile(t, filepath.Join(blockDir, "chunks", "000001"))))
+			require.Equal(t, tc.finishCode, uploadRequest(t, r, "user", url+"/finish", nil))
+		})
+	}
+	require.Empty(t, readMetas(t, bkt, "user"))
+}
+
+func TestBlockUploadMaxSize(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	local := filepath.Join(dir, "local")
+	id, err := testutil.CreateBlock(local, testSeries, now-4*hour, now-2*hour, hour/60)
+	require.NoError(t, err)
+	blockDir := testutil.BlockDir(local, id)
+	meta := readFile(t, filepath.Join(blockDir, cortex_tsdb.MetaFilename))
+	index := readFile(t, filepath.Join(blockDir, "index"))
+	chunks := readFile(t, filepath.Join(blockDir, "chunks", "000001"))
+	url := "/api/v1/upload/block/" + id.String()
+
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.CompactorBlockUploadEnabled = true
+	limits.CompactorBlockUploadMaxFileSize = int64(len(index))
+	c.limits, err = validation.NewOverrides(limits)
+	require.NoError(t, err)
+	r := blockUploadRouter(c)
+
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/start", meta))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=index", index))
+	require.Equal(t, http.StatusRequestEntityTooLarge, uploadRequest(t, r, "user", url+"/files?path=index", append(index, 0)))
+
+	// The files of unknown size are failed once they're read over the limit,
+	// and deleted.
+	req := httptest.NewRequest("POST", url+"/files?path=index", bytes.NewReader(append(index, 0)))
+	req.ContentLength = -1
+	req = req.WithContext(user.InjectOrgID(req.Context(), "user"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	exists, err := bkt.Exists(context.Background(), path.Join(cortex_tsdb.BlockDir("user", id), "index"))
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// The files can't take the block over its maximum size, the file
+	// replaced not counting.
+	limits.CompactorBlockUploadMaxFileSize = 0
+	limits.CompactorBlockUploadMaxBlockSize = int64(len(meta) + len(index) + len(chunks))
+	c.limits, err = validation.NewOverrides(limits)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=index", index))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=index", index))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=chunks/000001", chunks))
+	require.Equal(t, http.StatusRequestEntityTooLarge, uploadRequest(t, r, "user", url+"/files?path=chunks/000002", chunks))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/finish", nil))
+}
+
+// deleteFailingBucket fails to delete the objects.
+type deleteFailingBucket struct {
+	cortex_tsdb.Bucket
+}
+
+func (deleteFailingBucket) Delete(context.Context, string) error {
+	return errors.New("failed to delete")
+}
+
+func TestBlockUploadFinishDeleteFailure(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.CompactorBlockUploadEnabled = true
+	c.limits, err = validation.NewOverrides(limits)
+	require.NoError(t, err)
+	r := blockUploadRouter(c)
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	local := filepath.Join(dir, "local")
+	id, err := testutil.CreateBlock(local, testSeries, now-4*hour, now-2*hour, hour/60)
+	require.NoError(t, err)
+	blockDir := testutil.BlockDir(local, id)
+	url := "/api/v1/upload/block/" + id.String()
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/start", readFile(t, filepath.Join(blockDir, cortex_tsdb.MetaFilename))))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=index", readFile(t, filepath.Join(blockDir, "index"))))
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/files?path=chunks/000001", readFile(t, filepath.Join(blockDir, "chunks", "000001"))))
+
+	// The block isn't published if its uploading meta file can't be deleted.
+	c.bucket = deleteFailingBucket{Bucket: bkt}
+	require.Equal(t, http.StatusInternalServerError, uploadRequest(t, r, "user", url+"/finish", nil))
+	require.Empty(t, readMetas(t, bkt, "user"))
+
+	c.bucket = bkt
+	require.Equal(t, http.StatusOK, uploadRequest(t, r, "user", url+"/finish", nil))
+	require.Len(t, readMetas(t, bkt, "user"), 1)
+}
+
+func TestValidateLabels(t *testing.T) {
+	require.NoError(t, validateLabels(labels.FromStrings("__name__", "foo", "a", "1")))
+	require.Error(t, validateLabels(labels.FromStrings("a", "1")))
+	require.Error(t, validateLabels(labels.FromStrings("__name__", "foo", "a-b", "1")))
+	require.Error(t, validateLabels(labels.FromStrings("__name__", "foo", "a", "\xff")))
+	require.Error(t, validateLabels(labels.Labels{{Name: "__name__", Value: "foo"}, {Name: "b", Value: "1"}, {Name: "a", Value: "1"}}))
+}
@@ -0,0 +1,154 @@
+package compactor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+var (
+	partialBlocksDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_partial_blocks_deleted_total",
+		Help:      "Total number of blocks partially uploaded deleted.",
+	})
+	blocksQuarantined = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_blocks_quarantined_total",
+		Help:      "Total number of corrupted blocks moved to the quarantine directory of their tenant.",
+	})
+)
+
+// isPartialBlock returns whether a block of a tenant without meta file has
+// been partially uploaded for longer than the partial block deletion delay,
+// from the start of its upload through the block upload API, or from the
+// creation of its ID otherwise.
+func (c *Compactor) isPartialBlock(ctx context.Context, userID string, id ulid.ULID) (bool, error) {
+	if c.cfg.PartialBlockDeletionDelay == 0 {
+		return false, nil
+	}
+
+	since := ulid.Time(id.Time())
+	r, err := c.bucket.Get(ctx, path.Join(cortex_tsdb.BlockDir(userID, id), uploadingMetaFilename))
+	if err != nil && !c.bucket.IsObjNotFoundErr(err) {
+		return false, err
+	}
+	if err == nil {
+		defer r.Close()
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return false, err
+		}
+		var meta uploadingMeta
+		if err := json.Unmarshal(buf, &meta); err == nil && meta.UploadStartedAt > 0 {
+			since = time.Unix(meta.UploadStartedAt, 0)
+		}
+	}
+	return time.Since(since) > c.cfg.PartialBlockDeletionDelay, nil
+}
+
+// deletePartialBlocks deletes the blocks of a tenant partially uploaded.
+func (c *Compactor) deletePartialBlocks(ctx context.Context, userID string, ids []ulid.ULID) error {
+	for _, id := range ids {
+		if err := cortex_tsdb.DeleteBlock(ctx, c.bucket, userID, id); err != nil {
+			return fmt.Errorf("deleting partial block %s: %v", id, err)
+		}
+		partialBlocksDeleted.Inc()
+		level.Info(util.Logger).Log("msg", "deleted partially uploaded block", "user", userID, "block", id)
+	}
+	return nil
+}
+
+// quarantineBlock moves a corrupted block of a tenant to its quarantine
+// directory, for the operators to inspect it.
+func (c *Compactor) quarantineBlock(ctx context.Context, userID string, id ulid.ULID) error {
+	if err := cortex_tsdb.QuarantineBlock(ctx, c.bucket, userID, id); err != nil {
+		return fmt.Errorf("quarantining block %s: %v", id, err)
+	}
+	blocksQuarantined.Inc()
+	level.Error(util.Logger).Log("msg", "quarantined corrupted block, it must be inspected then deleted", "user", userID, "block", id, "dir", path.Join(userID, cortex_tsdb.QuarantineDir, id.String()))
+	return nil
+}
+
+// quarantineCorruptedBlocks quarantines the blocks downloaded to dirs, planned
+// for a compaction, which are corrupted, removing their metas from metaDir.
+// It returns whether any was, or the error opening a block which isn't
+// definitely corrupted, e.g. out of disk space, so that the compaction is
+// retried rather than a healthy block quarantined.
+func (c *Compactor) quarantineCorruptedBlocks(ctx context.Context, userID, metaDir string, ids []ulid.ULID, dirs []string) (bool, error) {
+	corrupted := false
+	for i, dir := range dirs {
+		b, err := tsdb.OpenBlock(util.Logger, dir, nil)
+		if err == nil {
+			if err := b.Close(); err != nil {
+				return false, fmt.Errorf("closing block %s: %v", ids[i], err)
+			}
+			continue
+		}
+		if !isCorruptedBlockError(err) {
+			return false, fmt.Errorf("opening block %s: %v", ids[i], err)
+		}
+
+		level.Error(util.Logger).Log("msg", "failed to open corrupted block planned for compaction", "user", userID, "block", ids[i], "err", err)
+		if err := c.quarantineBlock(ctx, userID, ids[i]); err != nil {
+			return false, err
+		}
+		if err := os.RemoveAll(filepath.Join(metaDir, ids[i].String())); err != nil {
+			return false, err
+		}
+		corrupted = true
+	}
+	return corrupted, nil
+}
+
+// isCorruptedBlockError returns whether the error opening a block is due to
+// the content of its files, rather than to reading them: an invalid checksum,
+// size, magic number or version of its index, chunks or tombstones, or a meta
+// file which can't be decoded.
+func isCorruptedBlockError(err error) bool {
+	// The errors opening a block are returned along with the errors closing
+	// its files opened before.
+	if merr, ok := err.(tsdb_errors.MultiError); ok && len(merr) > 0 {
+		err = merr[0]
+	}
+	cause := errors.Cause(err)
+	switch cause.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	}
+	if cause == encoding.ErrInvalidChecksum || cause == encoding.ErrInvalidSize {
+		return true
+	}
+	msg := cause.Error()
+	for _, corruption := range []string{
+		"invalid size",
+		"invalid magic number",
+		"unknown index file version",
+		"unexpected meta file version",
+		"invalid tombstone format",
+		"checksum did not match",
+	} {
+		if strings.Contains(msg, corruption) {
+			return true
+		}
+	}
+	return false
+}
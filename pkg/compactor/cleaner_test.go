@@ -0,0 +1,134 @@
+package compactor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
+	"github.com/stretchr/testify/require"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+)
+
+func TestCompactorDeletesPartialBlocks(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	old := ulid.MustNew(ulid.Timestamp(time.Now().Add(-48*time.Hour)), nil)
+	recent := ulid.MustNew(ulid.Timestamp(time.Now().Add(-time.Hour)), nil)
+	uploading := ulid.MustNew(ulid.Timestamp(time.Now().Add(-49*time.Hour)), nil)
+	abandoned := ulid.MustNew(ulid.Timestamp(time.Now().Add(-50*time.Hour)), nil)
+	for _, id := range []ulid.ULID{old, recent, uploading, abandoned} {
+		require.NoError(t, bkt.Upload(ctx, path.Join(cortex_tsdb.BlockDir("user", id), "index"), strings.NewReader("")))
+	}
+
+	// The blocks uploaded through the API are partial from the start of
+	// their upload.
+	for id, started := range map[ulid.ULID]time.Time{uploading: time.Now(), abandoned: time.Now().Add(-48 * time.Hour)} {
+		buf, err := json.Marshal(&uploadingMeta{UploadStartedAt: started.Unix()})
+		require.NoError(t, err)
+		require.NoError(t, bkt.Upload(ctx, path.Join(cortex_tsdb.BlockDir("user", id), uploadingMetaFilename), bytes.NewReader(buf)))
+	}
+
+	cfg.PartialBlockDeletionDelay = 24 * time.Hour
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+
+	ids, err := cortex_tsdb.ListBlocks(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+	require.NotContains(t, ids, old)
+	require.NotContains(t, ids, abandoned)
+	require.Contains(t, ids, recent)
+	require.Contains(t, ids, uploading)
+	require.Len(t, readMetas(t, bkt, "user"), 1)
+}
+
+func TestCompactorQuarantinesCorruptedBlocks(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	var ids []ulid.ULID
+	for i := 0; i < 3; i++ {
+		ids = append(ids, testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour))
+	}
+	corruptedMeta := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 2*hour, 4*hour)
+	require.NoError(t, bkt.Upload(ctx, path.Join(cortex_tsdb.BlockDir("user", corruptedMeta), cortex_tsdb.MetaFilename), strings.NewReader("{")))
+	require.NoError(t, bkt.Upload(ctx, path.Join(cortex_tsdb.BlockDir("user", ids[0]), "index"), strings.NewReader("corrupted")))
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+
+	// The corrupted blocks are quarantined, the others still compacted.
+	metas := readMetas(t, bkt, "user")
+	require.Len(t, metas, 1)
+	require.ElementsMatch(t, ids[1:], metas[0].Compaction.Sources)
+	listed, err := cortex_tsdb.ListBlocks(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	for _, id := range []ulid.ULID{ids[0], corruptedMeta} {
+		exists, err := bkt.Exists(ctx, path.Join("user", cortex_tsdb.QuarantineDir, id.String(), "index"))
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+}
+
+func TestCompactorRetriesBlocksFailingToOpen(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	var ids []ulid.ULID
+	for i := 0; i < 3; i++ {
+		ids = append(ids, testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour))
+	}
+	// A block missing its chunks fails to open without being corrupted, e.g.
+	// as if it was partially downloaded.
+	require.NoError(t, bkt.Delete(ctx, path.Join(cortex_tsdb.BlockDir("user", ids[0]), "chunks", "000001")))
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+
+	// The blocks aren't quarantined nor compacted, for the compaction to be
+	// retried.
+	require.Len(t, readMetas(t, bkt, "user"), 3)
+	exists, err := bkt.Exists(ctx, path.Join("user", cortex_tsdb.QuarantineDir, ids[0].String(), "index"))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestIsCorruptedBlockError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		corrupted bool
+	}{
+		{errors.Wrap(encoding.ErrInvalidChecksum, "read TOC"), true},
+		{errors.Wrap(encoding.ErrInvalidSize, "index header"), true},
+		{errors.Errorf("invalid magic number %x", 0), true},
+		{&json.SyntaxError{}, true},
+		{tsdb_errors.MultiError{errors.New("checksum did not match"), syscall.EMFILE}, true},
+		{&os.PathError{Op: "write", Path: "index", Err: syscall.ENOSPC}, false},
+		{&os.PathError{Op: "open", Path: "chunks", Err: syscall.EMFILE}, false},
+		{errors.Wrap(&os.PathError{Op: "mmap", Path: "index", Err: syscall.ENOMEM}, "mmap"), false},
+		{tsdb_errors.MultiError{syscall.EMFILE}, false},
+	} {
+		require.Equal(t, tc.corrupted, isCorruptedBlockError(tc.err), tc.err.Error())
+	}
+}
@@ -0,0 +1,969 @@
+package compactor
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/segmentio/fasthash/fnv1a"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+var (
+	compactionRunsStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_runs_started_total",
+		Help:      "Total number of compaction runs started.",
+	})
+	compactionRunsCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_runs_completed_total",
+		Help:      "Total number of compaction runs successfully completed.",
+	})
+	compactionRunsFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_runs_failed_total",
+		Help:      "Total number of compaction runs failed.",
+	})
+	compactionLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cortex",
+		Name:      "compactor_last_successful_run_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful compaction run.",
+	})
+	blocksCompacted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_blocks_compacted_total",
+		Help:      "Total number of blocks compacted into larger blocks.",
+	})
+	blocksMarkedForDeletion = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_blocks_marked_for_deletion_total",
+		Help:      "Total number of blocks marked for deletion.",
+	})
+	blocksDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_blocks_deleted_total",
+		Help:      "Total number of blocks deleted, once marked for deletion for the deletion delay.",
+	})
+	blocksSplit = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "compactor_blocks_split_total",
+		Help:      "Total number of blocks split into shards by the split-and-merge compaction.",
+	})
+)
+
+// The compaction strategies.
+const (
+	// CompactionStrategyDefault compacts the blocks of a tenant into blocks of
+	// larger time ranges, with all its series.
+	CompactionStrategyDefault = "default"
+	// CompactionStrategySplitAndMerge splits the blocks of a tenant into shards
+	// of its series, then compacts the blocks of each shard, so that the
+	// tenants whose blocks would exceed the size limit of the index can still
+	// be compacted.
+	CompactionStrategySplitAndMerge = "split-and-merge"
+)
+
+// Config is the config of the compactor.
+type Config struct {
+	BlockRanges           cortex_tsdb.DurationList `yaml:"block_ranges"`
+	ConsistencyDelay      time.Duration            `yaml:"consistency_delay"`
+	DataDir               string                   `yaml:"data_dir"`
+	CompactionInterval    time.Duration            `yaml:"compaction_interval"`
+	CompactionRetries     int                      `yaml:"compaction_retries"`
+	CompactionConcurrency int                      `yaml:"compaction_concurrency"`
+	CompactionStrategy    string                   `yaml:"compaction_strategy"`
+	SplitShards           int                      `yaml:"split_shards"`
+	DeletionDelay         time.Duration            `yaml:"deletion_delay"`
+
+	PartialBlockDeletionDelay time.Duration `yaml:"partial_block_deletion_delay"`
+
+	EnabledTenants  flagext.StringSlice `yaml:"enabled_tenants"`
+	DisabledTenants flagext.StringSlice `yaml:"disabled_tenants"`
+
+	ShardingEnabled  bool                  `yaml:"sharding_enabled"`
+	LifecyclerConfig ring.LifecyclerConfig `yaml:"sharding_ring"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.LifecyclerConfig.RegisterFlagsWithPrefix("compactor.", f)
+	// The compactors have a ring of their own, which mustn't share the keys
+	// of the ingesters one.
+	if prefix := f.Lookup("compactor.prefix"); prefix != nil {
+		prefix.DefValue = "compactor/"
+		_ = prefix.Value.Set(prefix.DefValue)
+	}
+
+	cfg.BlockRanges = cortex_tsdb.DurationList{2 * time.Hour, 12 * time.Hour, 24 * time.Hour}
+	f.Var(&cfg.BlockRanges, "compactor.block-ranges", "Comma separated list of the time ranges of the compacted blocks, the first of which must be the range of the blocks shipped by the ingesters.")
+	f.DurationVar(&cfg.ConsistencyDelay, "compactor.consistency-delay", 30*time.Minute, "Minimum age of the blocks compacted, so that the object store is consistent and the blocks fully uploaded.")
+	f.StringVar(&cfg.DataDir, "compactor.data-dir", "./data", "Local directory the blocks are compacted in.")
+	f.DurationVar(&cfg.CompactionInterval, "compactor.compaction-interval", time.Hour, "The frequency at which the blocks of the tenants are compacted.")
+	f.IntVar(&cfg.CompactionRetries, "compactor.compaction-retries", 3, "Number of times to retry the compaction of a tenant which failed, within a run.")
+	f.IntVar(&cfg.CompactionConcurrency, "compactor.compaction-concurrency", 1, "Number of tenants compacted concurrently.")
+	f.StringVar(&cfg.CompactionStrategy, "compactor.compaction-strategy", CompactionStrategyDefault, "The compaction strategy, either default or split-and-merge.")
+	f.IntVar(&cfg.SplitShards, "compactor.split-shards", 4, "Number of shards the series of the blocks are split into, with the split-and-merge compaction strategy.")
+	f.DurationVar(&cfg.DeletionDelay, "compactor.deletion-delay", 12*time.Hour, "How long the blocks compacted or beyond the retention of their tenant, or the tenants deleted, are marked for deletion before they're deleted, for the queriers and the store-gateways to notice first. 0 to delete them right away.")
+	f.DurationVar(&cfg.PartialBlockDeletionDelay, "compactor.partial-block-deletion-delay", 24*time.Hour, "How long the blocks without meta file, partially uploaded, are kept before they're deleted, from their creation or the start of their upload through the block upload API. 0 to keep them.")
+	f.Var(&cfg.EnabledTenants, "compactor.enabled-tenant", "Tenant whose blocks are compacted, can be repeated. All the tenants are compacted if none is set.")
+	f.Var(&cfg.DisabledTenants, "compactor.disabled-tenant", "Tenant whose blocks aren't compacted, can be repeated.")
+	f.BoolVar(&cfg.ShardingEnabled, "compactor.sharding-enabled", false, "Shard the tenants across the compactors using the ring.")
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	if len(cfg.BlockRanges) == 0 {
+		return fmt.Errorf("no compactor block ranges specified")
+	}
+	if cfg.BlockRanges[0] <= 0 {
+		return fmt.Errorf("compactor block range %s must be positive", cfg.BlockRanges[0])
+	}
+	for i := 1; i < len(cfg.BlockRanges); i++ {
+		if cfg.BlockRanges[i]%cfg.BlockRanges[i-1] != 0 {
+			return fmt.Errorf("compactor block range %s isn't a multiple of %s", cfg.BlockRanges[i], cfg.BlockRanges[i-1])
+		}
+	}
+	if cfg.CompactionConcurrency <= 0 {
+		return fmt.Errorf("compactor compaction concurrency must be at least 1, got %d", cfg.CompactionConcurrency)
+	}
+	switch cfg.CompactionStrategy {
+	case CompactionStrategyDefault:
+	case CompactionStrategySplitAndMerge:
+		if cfg.SplitShards <= 0 {
+			return fmt.Errorf("compactor split shards must be at least 1, got %d", cfg.SplitShards)
+		}
+	default:
+		return fmt.Errorf("unknown compaction strategy %q", cfg.CompactionStrategy)
+	}
+	return nil
+}
+
+// ValidateLimits validates the compaction limits of the tenants against the
+// config: the first of their block ranges must be the range of the blocks
+// shipped by the ingesters, the first of the compactor ones.
+func (cfg *Config) ValidateLimits(limits validation.Limits) error {
+	return cfg.validateTenantBlockRanges(limits.CompactorBlockRanges)
+}
+
+// ValidateBlocksStorage validates the blocks storage config against the
+// config: with the bucket index, the blocks marked for deletion must no longer
+// be queried before the compactor deletes them.
+func (cfg *Config) ValidateBlocksStorage(storageCfg cortex_tsdb.Config) error {
+	if !storageCfg.BucketIndex.Enabled || cfg.DeletionDelay <= 0 {
+		return nil
+	}
+	if storageCfg.BucketIndex.IgnoreDeletionMarksDelay >= cfg.DeletionDelay {
+		return fmt.Errorf("the bucket index ignore deletion marks delay %s must be shorter than the compactor deletion delay %s", storageCfg.BucketIndex.IgnoreDeletionMarksDelay, cfg.DeletionDelay)
+	}
+	return nil
+}
+
+func (cfg *Config) validateTenantBlockRanges(ranges []time.Duration) error {
+	if len(ranges) > 0 && len(cfg.BlockRanges) > 0 && ranges[0] != cfg.BlockRanges[0] {
+		return fmt.Errorf("the first tenant block range %s isn't the first compactor block range %s", ranges[0], cfg.BlockRanges[0])
+	}
+	return nil
+}
+
+// Compactor compacts the blocks of the tenants in the object store into
+// blocks of larger time ranges, merging the overlapping blocks shipped by
+// the ingesters replicas.
+type Compactor struct {
+	cfg           Config
+	bucket        cortex_tsdb.Bucket
+	tsdbCompactor *tsdb.LeveledCompactor
+
+	// The compactors of the tenants with block ranges of their own, by ranges.
+	tenantCompactorsMtx sync.Mutex
+	tenantCompactors    map[string]*tsdb.LeveledCompactor
+
+	lifecycler *ring.Lifecycler
+	ring       *ring.Ring
+
+	// Whether the bucket indexes of the tenants are updated.
+	bucketIndex bool
+	// The retention, block ranges and compaction concurrency of the blocks of
+	// the tenants, the compactor ones being used if nil.
+	limits *validation.Overrides
+
+	// The compaction statuses of the tenants, and the number and total
+	// duration of the jobs run.
+	statusMtx    sync.Mutex
+	tenants      map[string]*tenantStatus
+	jobs         int
+	jobsDuration time.Duration
+
+	// The tenants whose compaction was triggered through the API.
+	triggerMtx sync.Mutex
+	triggered  map[string]struct{}
+	trigger    chan struct{}
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewCompactor makes a new Compactor of the blocks in the object store and
+// starts it.
+func NewCompactor(cfg Config, storageCfg cortex_tsdb.Config, limits *validation.Overrides) (*Compactor, error) {
+	bucket, err := cortex_tsdb.NewBucketClient(context.Background(), storageCfg)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newCompactor(cfg, bucket)
+	if err != nil {
+		bucket.Close()
+		return nil, err
+	}
+	c.bucketIndex = storageCfg.BucketIndex.Enabled
+	c.limits = limits
+	go c.loop()
+	return c, nil
+}
+
+func newCompactor(cfg Config, bucket cortex_tsdb.Bucket) (*Compactor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	tsdbCompactor, err := tsdb.NewLeveledCompactor(context.Background(), nil, util.Logger, cfg.BlockRanges.ToMilliseconds(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Compactor{
+		cfg:              cfg,
+		bucket:           bucket,
+		tsdbCompactor:    tsdbCompactor,
+		tenantCompactors: map[string]*tsdb.LeveledCompactor{},
+		tenants:          map[string]*tenantStatus{},
+		triggered:        map[string]struct{}{},
+		trigger:          make(chan struct{}, 1),
+		quit:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+
+	if cfg.ShardingEnabled {
+		c.lifecycler, err = ring.NewLifecycler(cfg.LifecyclerConfig, c, "compactor")
+		if err != nil {
+			return nil, err
+		}
+
+		// A tenant is compacted by a single compactor.
+		ringCfg := cfg.LifecyclerConfig.RingConfig
+		ringCfg.ReplicationFactor = 1
+		c.ring, err = ring.New(ringCfg, "compactor")
+		if err != nil {
+			c.lifecycler.Shutdown()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Stop stops the Compactor, waiting for the compaction in progress.
+func (c *Compactor) Stop() {
+	close(c.quit)
+	<-c.done
+	c.shutdown()
+}
+
+// shutdown leaves the ring and closes the bucket.
+func (c *Compactor) shutdown() {
+	if c.cfg.ShardingEnabled {
+		c.lifecycler.Shutdown()
+		c.ring.Stop()
+	}
+	c.bucket.Close()
+}
+
+func (c *Compactor) loop() {
+	defer close(c.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(c.cfg.CompactionInterval)
+	defer ticker.Stop()
+
+	c.compactUsers(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			c.compactUsers(ctx)
+		case <-c.trigger:
+			c.compactTriggeredUsers(ctx)
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// compactUsers runs a compaction of the blocks of the tenants of this
+// compactor.
+func (c *Compactor) compactUsers(ctx context.Context) {
+	compactionRunsStarted.Inc()
+	level.Info(util.Logger).Log("msg", "compaction of the tenants blocks started")
+
+	users, err := c.users(ctx)
+	if err != nil {
+		level.Error(util.Logger).Log("msg", "failed to list the tenants with blocks", "err", err)
+		compactionRunsFailed.Inc()
+		return
+	}
+	c.keepTenantStatuses(users)
+
+	var (
+		wg     sync.WaitGroup
+		mtx    sync.Mutex
+		failed int
+		queue  = make(chan string)
+	)
+	for i := 0; i < c.cfg.CompactionConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userID := range queue {
+				if err := c.compactUserWithRetries(ctx, userID); err != nil {
+					level.Error(util.Logger).Log("msg", "failed to compact the tenant blocks", "user", userID, "err", err)
+					mtx.Lock()
+					failed++
+					mtx.Unlock()
+				}
+			}
+		}()
+	}
+	for _, userID := range users {
+		queue <- userID
+	}
+	close(queue)
+	wg.Wait()
+
+	if failed > 0 || ctx.Err() != nil {
+		compactionRunsFailed.Inc()
+		return
+	}
+	compactionRunsCompleted.Inc()
+	compactionLastSuccess.SetToCurrentTime()
+	level.Info(util.Logger).Log("msg", "compaction of the tenants blocks completed", "users", len(users))
+}
+
+// users returns the tenants whose blocks are compacted by this compactor.
+func (c *Compactor) users(ctx context.Context) ([]string, error) {
+	all, err := cortex_tsdb.ListUsers(ctx, c.bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(all))
+	for _, userID := range all {
+		if !c.isUserEnabled(userID) {
+			continue
+		}
+		// The jobs of the tenants are sharded, rather than the tenants, with
+		// the split-and-merge compaction.
+		if c.cfg.ShardingEnabled && c.cfg.CompactionStrategy != CompactionStrategySplitAndMerge {
+			owned, err := c.ownsUser(userID)
+			if err != nil {
+				return nil, err
+			}
+			if !owned {
+				continue
+			}
+		}
+		users = append(users, userID)
+	}
+	return users, nil
+}
+
+func (c *Compactor) isUserEnabled(userID string) bool {
+	for _, u := range c.cfg.DisabledTenants {
+		if u == userID {
+			return false
+		}
+	}
+	if len(c.cfg.EnabledTenants) == 0 {
+		return true
+	}
+	for _, u := range c.cfg.EnabledTenants {
+		if u == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compactor) ownsUser(userID string) (bool, error) {
+	return c.owns(userID)
+}
+
+// owns returns whether the key, a tenant or a job of a tenant, is owned by this
+// compactor.
+func (c *Compactor) owns(key string) (bool, error) {
+	// Hashed with fnv1a, so that the keys which only differ in their last
+	// characters are spread across the ring.
+	rs, err := c.ring.Get(fnv1a.HashString32(key), ring.Read, nil)
+	if err != nil {
+		return false, err
+	}
+	return len(rs.Ingesters) > 0 && rs.Ingesters[0].Addr == c.lifecycler.Addr, nil
+}
+
+func (c *Compactor) compactUserWithRetries(ctx context.Context, userID string) (err error) {
+	c.startTenantRun(userID)
+	defer func() {
+		c.endTenantRun(userID, err)
+	}()
+
+	for i := 0; i <= c.cfg.CompactionRetries; i++ {
+		if err = c.compactUser(ctx, userID); err == nil || ctx.Err() != nil {
+			return err
+		}
+		level.Warn(util.Logger).Log("msg", "failed to compact the tenant blocks, retrying", "user", userID, "err", err)
+	}
+	return err
+}
+
+// compactUser compacts the blocks of a tenant until there are none left to
+// compact. The metas of the blocks are kept locally for the planning, and the
+// blocks downloaded only when they're compacted.
+func (c *Compactor) compactUser(ctx context.Context, userID string) error {
+	if !validTenantID(userID) {
+		return fmt.Errorf("invalid tenant %q", userID)
+	}
+	dir := filepath.Join(c.cfg.DataDir, userID)
+	metaDir := filepath.Join(dir, "meta")
+	compactDir := filepath.Join(dir, "compact")
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// The tenant is owned by this compactor, unless the jobs of its blocks
+	// are spread across the compactors, in which case its blocks are cleaned
+	// and its bucket index updated by the compactor owning it.
+	owned := true
+	if c.cfg.ShardingEnabled && c.cfg.CompactionStrategy == CompactionStrategySplitAndMerge {
+		var err error
+		if owned, err = c.ownsUser(userID); err != nil {
+			return err
+		}
+	}
+
+	deletion, err := cortex_tsdb.ReadTenantDeletionMark(ctx, c.bucket, userID)
+	if err != nil {
+		return fmt.Errorf("reading tenant deletion mark: %v", err)
+	}
+	if deletion != nil {
+		if !owned {
+			return nil
+		}
+		return c.deleteUser(ctx, userID, deletion)
+	}
+
+	synced, err := c.syncMetas(ctx, userID, metaDir)
+	if err != nil {
+		return err
+	}
+	if owned {
+		if err := c.deleteMarkedBlocks(ctx, userID, synced.marks); err != nil {
+			return err
+		}
+		if err := c.deletePartialBlocks(ctx, userID, synced.partial); err != nil {
+			return err
+		}
+		for _, id := range synced.corrupted {
+			if err := c.quarantineBlock(ctx, userID, id); err != nil {
+				return err
+			}
+		}
+		if err := c.applyRetention(ctx, userID, metaDir); err != nil {
+			return err
+		}
+	}
+	if err := c.planTenant(userID, metaDir); err != nil {
+		return err
+	}
+
+	tsdbCompactor, err := c.tenantCompactor(userID)
+	if err != nil {
+		return err
+	}
+	if c.cfg.CompactionStrategy == CompactionStrategySplitAndMerge {
+		err = c.splitAndMerge(ctx, userID, tsdbCompactor, dir, metaDir)
+	} else {
+		err = c.compactBlocks(ctx, userID, tsdbCompactor, metaDir, compactDir, nil, c.tenantConcurrency(userID))
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.bucketIndex && owned {
+		return c.updateBucketIndex(ctx, userID)
+	}
+	return nil
+}
+
+// tenantCompactor returns the compactor of the blocks of a tenant, with its
+// block ranges.
+func (c *Compactor) tenantCompactor(userID string) (*tsdb.LeveledCompactor, error) {
+	if c.limits == nil {
+		return c.tsdbCompactor, nil
+	}
+	ranges := cortex_tsdb.DurationList(c.limits.CompactorBlockRanges(userID))
+	if len(ranges) == 0 {
+		return c.tsdbCompactor, nil
+	}
+	// The overrides are reloaded without the compactor config.
+	if err := c.cfg.validateTenantBlockRanges(ranges); err != nil {
+		return nil, err
+	}
+
+	c.tenantCompactorsMtx.Lock()
+	defer c.tenantCompactorsMtx.Unlock()
+	key := ranges.String()
+	if tsdbCompactor, ok := c.tenantCompactors[key]; ok {
+		return tsdbCompactor, nil
+	}
+	tsdbCompactor, err := tsdb.NewLeveledCompactor(context.Background(), nil, util.Logger, ranges.ToMilliseconds(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating compactor of block ranges %s: %v", key, err)
+	}
+	c.tenantCompactors[key] = tsdbCompactor
+	return tsdbCompactor, nil
+}
+
+// blockRanges returns the block ranges of the blocks of a tenant.
+func (c *Compactor) blockRanges(userID string) cortex_tsdb.DurationList {
+	if c.limits != nil {
+		if ranges := c.limits.CompactorBlockRanges(userID); len(ranges) > 0 {
+			return ranges
+		}
+	}
+	return c.cfg.BlockRanges
+}
+
+// tenantConcurrency returns the number of compaction jobs of a tenant run
+// concurrently.
+func (c *Compactor) tenantConcurrency(userID string) int {
+	if c.limits == nil {
+		return 1
+	}
+	if n := c.limits.CompactorTenantCompactionConcurrency(userID); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// deleteUser deletes the blocks and the bucket index of a tenant marked for
+// deletion, once marked for the deletion delay, its blocks being no longer
+// queried in the meantime. The deletion mark is kept, so that the tenant isn't
+// queried until its blocks are all gone.
+func (c *Compactor) deleteUser(ctx context.Context, userID string, mark *cortex_tsdb.TenantDeletionMark) error {
+	if time.Since(time.Unix(mark.DeletionTime, 0)) <= c.cfg.DeletionDelay {
+		return nil
+	}
+
+	ids, err := cortex_tsdb.ListBlocks(ctx, c.bucket, userID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := cortex_tsdb.DeleteBlock(ctx, c.bucket, userID, id); err != nil {
+			return fmt.Errorf("deleting block %s: %v", id, err)
+		}
+		blocksDeleted.Inc()
+	}
+	if err := cortex_tsdb.DeleteBucketIndex(ctx, c.bucket, userID); err != nil {
+		return fmt.Errorf("deleting bucket index: %v", err)
+	}
+	if len(ids) > 0 {
+		level.Info(util.Logger).Log("msg", "deleted the blocks of tenant marked for deletion", "user", userID, "blocks", len(ids))
+	}
+	return nil
+}
+
+// DeleteTenantHandler marks the tenant of the request for deletion, its blocks
+// being no longer queried, and deleted after the deletion delay.
+func (c *Compactor) DeleteTenantHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := user.ExtractOrgID(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := cortex_tsdb.MarkTenantForDeletion(req.Context(), c.bucket, userID); err != nil {
+		level.Error(util.Logger).Log("msg", "failed to mark tenant for deletion", "user", userID, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(util.Logger).Log("msg", "marked tenant for deletion", "user", userID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteBlock deletes a block of a tenant, marking it for deletion unless the
+// deletion delay is 0.
+func (c *Compactor) deleteBlock(ctx context.Context, userID string, id ulid.ULID) error {
+	if c.cfg.DeletionDelay == 0 {
+		return cortex_tsdb.DeleteBlock(ctx, c.bucket, userID, id)
+	}
+	if err := cortex_tsdb.MarkBlockForDeletion(ctx, c.bucket, userID, id); err != nil {
+		return err
+	}
+	blocksMarkedForDeletion.Inc()
+	return nil
+}
+
+// deleteMarkedBlocks deletes the blocks of a tenant marked for deletion for
+// longer than the deletion delay.
+func (c *Compactor) deleteMarkedBlocks(ctx context.Context, userID string, marks []*cortex_tsdb.BlockDeletionMark) error {
+	for _, m := range marks {
+		if time.Since(m.Time()) <= c.cfg.DeletionDelay {
+			continue
+		}
+		if err := cortex_tsdb.DeleteBlock(ctx, c.bucket, userID, m.ID); err != nil {
+			return fmt.Errorf("deleting block %s: %v", m.ID, err)
+		}
+		blocksDeleted.Inc()
+		level.Info(util.Logger).Log("msg", "deleted block marked for deletion", "user", userID, "block", m.ID)
+	}
+	return nil
+}
+
+// applyRetention deletes the blocks of a tenant, whose metas are in metaDir,
+// entirely beyond its retention period, if any.
+func (c *Compactor) applyRetention(ctx context.Context, userID, metaDir string) error {
+	if c.limits == nil {
+		return nil
+	}
+	retention := c.limits.CompactorBlocksRetentionPeriod(userID)
+	if retention <= 0 {
+		return nil
+	}
+
+	metas, err := readLocalMetas(metaDir)
+	if err != nil {
+		return err
+	}
+	minTime := time.Now().Add(-retention).UnixNano() / int64(time.Millisecond)
+	for _, meta := range metas {
+		if meta.MaxTime > minTime {
+			continue
+		}
+		if err := c.deleteBlock(ctx, userID, meta.ULID); err != nil {
+			return fmt.Errorf("deleting block %s beyond retention: %v", meta.ULID, err)
+		}
+		if err := os.RemoveAll(filepath.Join(metaDir, meta.ULID.String())); err != nil {
+			return err
+		}
+		level.Info(util.Logger).Log("msg", "deleted block beyond retention", "user", userID, "block", meta.ULID, "retention", retention)
+	}
+	return nil
+}
+
+// compactBlocks compacts the blocks whose metas are in metaDir until there are
+// none left to compact, the compacted blocks being of the shard if not nil. Up
+// to concurrency groups of blocks are compacted at once, see planJobs.
+func (c *Compactor) compactBlocks(ctx context.Context, userID string, tsdbCompactor *tsdb.LeveledCompactor, metaDir, compactDir string, shard *cortex_tsdb.BlockShard, concurrency int) error {
+	for ctx.Err() == nil {
+		plans, err := c.planJobs(userID, tsdbCompactor, metaDir, filepath.Join(compactDir, "plan"), concurrency)
+		if err != nil {
+			return err
+		}
+		if len(plans) == 0 {
+			return nil
+		}
+
+		jobs := make([]func() error, 0, len(plans))
+		for i, plan := range plans {
+			plan, jobDir := plan, filepath.Join(compactDir, strconv.Itoa(i))
+			jobs = append(jobs, func() error {
+				return c.compactPlan(ctx, userID, tsdbCompactor, metaDir, jobDir, shard, plan)
+			})
+		}
+		if err := runJobs(ctx, concurrency, jobs); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(compactDir); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// planJobs plans up to n groups of the blocks whose metas are in metaDir, to
+// be compacted concurrently. Each group after the first is planned, in
+// planDir, without the blocks in the time ranges, of the largest block range
+// of the tenant, of the groups planned before it, so that the groups are
+// independent: the blocks of different ranges are never compacted together.
+func (c *Compactor) planJobs(userID string, tsdbCompactor *tsdb.LeveledCompactor, metaDir, planDir string, n int) ([][]string, error) {
+	plan, err := tsdbCompactor.Plan(metaDir)
+	if err != nil || len(plan) == 0 || n <= 1 {
+		if len(plan) == 0 {
+			return nil, err
+		}
+		return [][]string{plan}, err
+	}
+
+	metas, err := readLocalMetas(metaDir)
+	if err != nil {
+		return nil, err
+	}
+	ranges := c.blockRanges(userID)
+	maxRange := ranges[len(ranges)-1].Nanoseconds() / int64(time.Millisecond)
+	byID := make(map[ulid.ULID]*cortex_tsdb.Meta, len(metas))
+	for _, meta := range metas {
+		byID[meta.ULID] = meta
+	}
+
+	plans := [][]string{plan}
+	// The ranges of the groups planned, by their start.
+	planned := map[int64]struct{}{}
+	for len(plans) < n {
+		for _, p := range plan {
+			id, err := ulid.Parse(filepath.Base(p))
+			if err != nil {
+				return nil, err
+			}
+			meta, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("block %s planned without meta", id)
+			}
+			for t := meta.MinTime - meta.MinTime%maxRange; t < meta.MaxTime; t += maxRange {
+				planned[t] = struct{}{}
+			}
+		}
+
+		if err := os.RemoveAll(planDir); err != nil {
+			return nil, err
+		}
+		for _, meta := range metas {
+			if !isBlockPlanned(meta, planned, maxRange) {
+				if err := cortex_tsdb.WriteLocalMeta(filepath.Join(planDir, meta.ULID.String()), meta); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := os.MkdirAll(planDir, 0777); err != nil {
+			return nil, err
+		}
+		plan, err = tsdbCompactor.Plan(planDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(plan) == 0 {
+			break
+		}
+		plans = append(plans, plan)
+	}
+	return plans, os.RemoveAll(planDir)
+}
+
+// isBlockPlanned returns whether the block is in any of the ranges planned.
+func isBlockPlanned(meta *cortex_tsdb.Meta, planned map[int64]struct{}, maxRange int64) bool {
+	for t := meta.MinTime - meta.MinTime%maxRange; t < meta.MaxTime; t += maxRange {
+		if _, ok := planned[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compactPlan compacts a group of blocks planned by planJobs in compactDir,
+// the compacted blocks being of the shard if not nil. The corrupted blocks are
+// quarantined, the group being left for the next plan.
+func (c *Compactor) compactPlan(ctx context.Context, userID string, tsdbCompactor *tsdb.LeveledCompactor, metaDir, compactDir string, shard *cortex_tsdb.BlockShard, plan []string) error {
+	start := time.Now()
+
+	ids := make([]ulid.ULID, 0, len(plan))
+	dirs := make([]string, 0, len(plan))
+	for _, p := range plan {
+		id, err := ulid.Parse(filepath.Base(p))
+		if err != nil {
+			return err
+		}
+		blockDir := filepath.Join(compactDir, id.String())
+		if err := cortex_tsdb.DownloadBlock(ctx, c.bucket, userID, id, blockDir); err != nil {
+			return fmt.Errorf("downloading block %s: %v", id, err)
+		}
+		ids = append(ids, id)
+		dirs = append(dirs, blockDir)
+	}
+
+	// The corrupted blocks are quarantined rather than failing all the
+	// compactions of the tenant, and the blocks planned again without.
+	corrupted, err := c.quarantineCorruptedBlocks(ctx, userID, metaDir, ids, dirs)
+	if err != nil {
+		return err
+	}
+	if corrupted {
+		return os.RemoveAll(compactDir)
+	}
+
+	newID, err := tsdbCompactor.Compact(compactDir, dirs, nil)
+	if err != nil {
+		return fmt.Errorf("compacting blocks %v: %v", ids, err)
+	}
+
+	// The compacted block is uploaded before the blocks it replaces are
+	// deleted, the queriers deduplicating the samples in the meantime.
+	if newID != (ulid.ULID{}) {
+		newDir := filepath.Join(compactDir, newID.String())
+		meta, err := cortex_tsdb.ReadLocalMeta(newDir)
+		if err != nil {
+			return err
+		}
+		if shard != nil {
+			meta.Shard = shard
+			if err := cortex_tsdb.WriteLocalMeta(newDir, meta); err != nil {
+				return err
+			}
+		}
+		if err := cortex_tsdb.UploadBlock(ctx, c.bucket, userID, newDir); err != nil {
+			return fmt.Errorf("uploading block %s: %v", newID, err)
+		}
+		if err := cortex_tsdb.WriteLocalMeta(filepath.Join(metaDir, newID.String()), meta); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		if err := c.deleteBlock(ctx, userID, id); err != nil {
+			return fmt.Errorf("deleting block %s: %v", id, err)
+		}
+		if err := os.RemoveAll(filepath.Join(metaDir, id.String())); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(compactDir); err != nil {
+		return err
+	}
+
+	blocksCompacted.Add(float64(len(ids)))
+	c.jobCompleted(userID, time.Since(start))
+	level.Info(util.Logger).Log("msg", "compacted blocks", "user", userID, "sources", fmt.Sprintf("%v", ids), "block", newID)
+	return nil
+}
+
+// updateBucketIndex updates the bucket index of a tenant with its blocks once
+// compacted.
+func (c *Compactor) updateBucketIndex(ctx context.Context, userID string) error {
+	old, err := cortex_tsdb.ReadBucketIndex(ctx, c.bucket, userID)
+	if err != nil && err != cortex_tsdb.ErrBucketIndexNotFound {
+		level.Warn(util.Logger).Log("msg", "failed to read the bucket index, rebuilding it", "user", userID, "err", err)
+	}
+	idx, err := cortex_tsdb.UpdateBucketIndex(ctx, c.bucket, userID, old)
+	if err != nil {
+		return fmt.Errorf("updating bucket index: %v", err)
+	}
+	return cortex_tsdb.WriteBucketIndex(ctx, c.bucket, userID, idx)
+}
+
+// syncedBlocks are the blocks of a tenant not compacted, found by syncMetas.
+type syncedBlocks struct {
+	marks     []*cortex_tsdb.BlockDeletionMark
+	partial   []ulid.ULID
+	corrupted []ulid.ULID
+}
+
+// syncMetas writes the metas of the blocks of a tenant, old enough to be
+// consistent in the object store and not marked for deletion, to a local
+// directory, and returns the deletion marks of its blocks, its blocks
+// partially uploaded for longer than the partial block deletion delay and
+// its blocks with a corrupted meta file.
+func (c *Compactor) syncMetas(ctx context.Context, userID, metaDir string) (*syncedBlocks, error) {
+	if err := os.MkdirAll(metaDir, 0777); err != nil {
+		return nil, err
+	}
+	ids, err := cortex_tsdb.ListBlocks(ctx, c.bucket, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	synced := &syncedBlocks{}
+	minAge := ulid.Timestamp(time.Now().Add(-c.cfg.ConsistencyDelay))
+	for _, id := range ids {
+		mark, err := cortex_tsdb.ReadDeletionMark(ctx, c.bucket, userID, id)
+		if err != nil {
+			return nil, fmt.Errorf("reading deletion mark of block %s: %v", id, err)
+		}
+		if mark != nil {
+			synced.marks = append(synced.marks, mark)
+			continue
+		}
+		if id.Time() > minAge {
+			continue
+		}
+		meta, err := cortex_tsdb.ReadMeta(ctx, c.bucket, userID, id)
+		if c.bucket.IsObjNotFoundErr(err) {
+			partial, err := c.isPartialBlock(ctx, userID, id)
+			if err != nil {
+				return nil, err
+			}
+			if partial {
+				synced.partial = append(synced.partial, id)
+			}
+			continue
+		}
+		if cortex_tsdb.IsCorruptedMetaErr(err) {
+			level.Error(util.Logger).Log("msg", "found block with corrupted meta file", "user", userID, "block", id, "err", err)
+			synced.corrupted = append(synced.corrupted, id)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading meta of block %s: %v", id, err)
+		}
+		if err := cortex_tsdb.WriteLocalMeta(filepath.Join(metaDir, id.String()), meta); err != nil {
+			return nil, err
+		}
+	}
+	return synced, nil
+}
+
+func (c *Compactor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if c.cfg.ShardingEnabled {
+		c.ring.ServeHTTP(w, req)
+	} else {
+		var unshardedPage = `
+			<!DOCTYPE html>
+			<html>
+				<head>
+					<meta charset="UTF-8">
+					<title>Cortex Compactor Status</title>
+				</head>
+				<body>
+					<h1>Cortex Compactor Status</h1>
+					<p>Compactor running with shards disabled</p>
+				</body>
+			</html>`
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(unshardedPage))
+	}
+}
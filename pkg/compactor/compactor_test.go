@@ -0,0 +1,629 @@
+package compactor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+const hour = int64(time.Hour / time.Millisecond)
+
+var testSeries = []labels.Labels{
+	labels.FromStrings("__name__", "foo", "a", "1"),
+	labels.FromStrings("__name__", "foo", "a", "2"),
+}
+
+func prepare(t *testing.T) (Config, cortex_tsdb.Bucket, string) {
+	bkt, dir := testutil.PrepareBucket(t, "compactor")
+
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.ConsistencyDelay = 0
+	cfg.DeletionDelay = 0
+	return cfg, bkt, dir
+}
+
+func readMetas(t *testing.T, bkt cortex_tsdb.Bucket, userID string) []*cortex_tsdb.Meta {
+	ctx := context.Background()
+	ids, err := cortex_tsdb.ListBlocks(ctx, bkt, userID)
+	require.NoError(t, err)
+
+	metas := make([]*cortex_tsdb.Meta, 0, len(ids))
+	for _, id := range ids {
+		meta, err := cortex_tsdb.ReadMeta(ctx, bkt, userID, id)
+		if bkt.IsObjNotFoundErr(err) {
+			continue
+		}
+		require.NoError(t, err)
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+	return metas
+}
+
+func TestCompactorVerticalCompaction(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	// The same block shipped by 3 ingesters replicas, and a newer one.
+	for i := 0; i < 3; i++ {
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	}
+	testutil.UploadBlock(t, bkt, dir, "user", testSeries, 2*hour, 4*hour)
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+
+	metas := readMetas(t, bkt, "user")
+	require.Len(t, metas, 2)
+	require.Equal(t, int64(0), metas[0].MinTime)
+	require.Equal(t, 2*hour, metas[0].MaxTime)
+	require.Len(t, metas[0].Compaction.Sources, 3)
+	// The samples of the replicas are deduplicated.
+	require.Equal(t, uint64(2), metas[0].Stats.NumSeries)
+	require.Equal(t, uint64(2*120), metas[0].Stats.NumSamples)
+	require.Equal(t, 2*hour, metas[1].MinTime)
+}
+
+func TestCompactorCompactsBlockRanges(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	for i := int64(0); i < 7; i++ {
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour)
+	}
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+
+	// The 6 blocks of the first 12h are compacted, the most recent one isn't.
+	metas := readMetas(t, bkt, "user")
+	require.Len(t, metas, 2)
+	require.Equal(t, int64(0), metas[0].MinTime)
+	require.Equal(t, 12*hour, metas[0].MaxTime)
+	require.Len(t, metas[0].Compaction.Sources, 6)
+	require.Equal(t, 12*hour, metas[1].MinTime)
+	require.Equal(t, 14*hour, metas[1].MaxTime)
+
+	// Nothing is left to compact.
+	c.compactUsers(context.Background())
+	require.Len(t, readMetas(t, bkt, "user"), 2)
+}
+
+func TestCompactorTenantBlockRanges(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	for i := int64(0); i < 7; i++ {
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour)
+	}
+
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.CompactorBlockRanges = []time.Duration{2 * time.Hour, 4 * time.Hour}
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.limits = overrides
+	c.compactUsers(context.Background())
+
+	// The blocks are compacted into the block ranges of the tenant.
+	metas := readMetas(t, bkt, "user")
+	require.Len(t, metas, 4)
+	for i, meta := range metas[:3] {
+		require.Equal(t, int64(i)*4*hour, meta.MinTime)
+		require.Equal(t, int64(i+1)*4*hour, meta.MaxTime)
+		require.Len(t, meta.Compaction.Sources, 2)
+	}
+	require.Equal(t, 12*hour, metas[3].MinTime)
+}
+
+func TestCompactorTenantCompactionConcurrency(t *testing.T) {
+	// The jobs of the tenant are run one at a time, or concurrently.
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			cfg, bkt, dir := prepare(t)
+			defer os.RemoveAll(dir)
+
+			for i := int64(0); i < 25; i++ {
+				testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour)
+			}
+
+			c, err := newCompactor(cfg, bkt)
+			require.NoError(t, err)
+			var limits validation.Limits
+			flagext.DefaultValues(&limits)
+			limits.CompactorTenantCompactionConcurrency = concurrency
+			c.limits, err = validation.NewOverrides(limits)
+			require.NoError(t, err)
+			c.compactUsers(context.Background())
+
+			// The blocks of each of the first 2 days are compacted, the most
+			// recent one isn't.
+			metas := readMetas(t, bkt, "user")
+			require.Len(t, metas, 3)
+			for i, meta := range metas[:2] {
+				require.Equal(t, int64(i)*24*hour, meta.MinTime)
+				require.Equal(t, int64(i+1)*24*hour, meta.MaxTime)
+				require.Len(t, meta.Compaction.Sources, 12)
+			}
+			require.Equal(t, 48*hour, metas[2].MinTime)
+		})
+	}
+}
+
+func TestPlanJobs(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	metaDir := filepath.Join(dir, "meta")
+	for i := int64(0); i < 25; i++ {
+		id := testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour)
+		meta, err := cortex_tsdb.ReadMeta(context.Background(), bkt, "user", id)
+		require.NoError(t, err)
+		require.NoError(t, cortex_tsdb.WriteLocalMeta(filepath.Join(metaDir, id.String()), meta))
+	}
+
+	// The groups are planned in distinct days, the largest block range.
+	plans, err := c.planJobs("user", c.tsdbCompactor, metaDir, filepath.Join(dir, "plan"), 3)
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	for i, plan := range plans {
+		require.Len(t, plan, 6)
+		for _, p := range plan {
+			id, err := ulid.Parse(filepath.Base(p))
+			require.NoError(t, err)
+			meta, err := cortex_tsdb.ReadLocalMeta(filepath.Join(metaDir, id.String()))
+			require.NoError(t, err)
+			require.Equal(t, int64(i), meta.MinTime/(24*hour))
+		}
+	}
+
+	plans, err = c.planJobs("user", c.tsdbCompactor, metaDir, filepath.Join(dir, "plan"), 1)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+}
+
+func TestConfigValidate(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	require.NoError(t, cfg.Validate())
+
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	require.NoError(t, cfg.ValidateLimits(limits))
+	limits.CompactorBlockRanges = []time.Duration{2 * time.Hour, 24 * time.Hour}
+	require.NoError(t, cfg.ValidateLimits(limits))
+	limits.CompactorBlockRanges = []time.Duration{time.Hour, 24 * time.Hour}
+	require.Error(t, cfg.ValidateLimits(limits))
+
+	var storageCfg cortex_tsdb.Config
+	flagext.DefaultValues(&storageCfg)
+	storageCfg.BucketIndex.Enabled = true
+	require.NoError(t, cfg.ValidateBlocksStorage(storageCfg))
+	storageCfg.BucketIndex.IgnoreDeletionMarksDelay = cfg.DeletionDelay
+	require.Error(t, cfg.ValidateBlocksStorage(storageCfg))
+	cfg.DeletionDelay = 0
+	require.NoError(t, cfg.ValidateBlocksStorage(storageCfg))
+
+	cfg.BlockRanges = cortex_tsdb.DurationList{0, 2 * time.Hour}
+	require.Error(t, cfg.Validate())
+}
+
+func TestCompactorUpdatesBucketIndex(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	for i := int64(0); i < 7; i++ {
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour)
+	}
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.bucketIndex = true
+	c.compactUsers(context.Background())
+
+	// The index lists the blocks once compacted.
+	idx, err := cortex_tsdb.ReadBucketIndex(context.Background(), bkt, "user")
+	require.NoError(t, err)
+	metas := readMetas(t, bkt, "user")
+	require.Len(t, idx.Blocks, len(metas))
+	for i, meta := range metas {
+		require.Equal(t, meta.ULID, idx.Blocks[i].ID)
+		require.Equal(t, meta.MinTime, idx.Blocks[i].MinTime)
+		require.Equal(t, meta.MaxTime, idx.Blocks[i].MaxTime)
+	}
+}
+
+func TestCompactorSplitAndMerge(t *testing.T) {
+	// The jobs of the tenant are run one at a time, or concurrently.
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			cfg, bkt, dir := prepare(t)
+			defer os.RemoveAll(dir)
+
+			// The first block shipped by 3 ingesters replicas.
+			for i := 0; i < 3; i++ {
+				testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+			}
+			for i := int64(1); i < 7; i++ {
+				testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour)
+			}
+
+			cfg.CompactionStrategy = CompactionStrategySplitAndMerge
+			cfg.SplitShards = 2
+			c, err := newCompactor(cfg, bkt)
+			require.NoError(t, err)
+			var limits validation.Limits
+			flagext.DefaultValues(&limits)
+			limits.CompactorTenantCompactionConcurrency = concurrency
+			c.limits, err = validation.NewOverrides(limits)
+			require.NoError(t, err)
+			c.compactUsers(context.Background())
+
+			// The blocks of each shard of the first 12h are compacted, the most
+			// recent ones aren't.
+			var series, samples uint64
+			metas := readMetas(t, bkt, "user")
+			for _, meta := range metas {
+				require.NotNil(t, meta.Shard)
+				require.Equal(t, uint64(2), meta.Shard.Count)
+				require.Equal(t, cortex_tsdb.ShardHashSeriesID, meta.Shard.Hash)
+				if meta.MinTime == 0 {
+					require.Equal(t, 12*hour, meta.MaxTime)
+					require.Len(t, meta.Compaction.Sources, 8)
+					series += meta.Stats.NumSeries
+					samples += meta.Stats.NumSamples
+				} else {
+					require.Equal(t, 12*hour, meta.MinTime)
+					require.Equal(t, 14*hour, meta.MaxTime)
+				}
+			}
+			// The series are split across the shards, the samples of the replicas
+			// deduplicated.
+			require.Equal(t, uint64(2), series)
+			require.Equal(t, uint64(2*720), samples)
+
+			// Nothing is left to split or compact.
+			c.compactUsers(context.Background())
+			require.Equal(t, metas, readMetas(t, bkt, "user"))
+		})
+	}
+}
+
+func TestShardBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shard-block")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var series []labels.Labels
+	for i := 0; i < 10; i++ {
+		series = append(series, labels.FromStrings("__name__", "foo", "a", fmt.Sprint(i)))
+	}
+	id, err := testutil.CreateBlock(dir, series, 0, 100, 10)
+	require.NoError(t, err)
+	b, err := tsdb.OpenBlock(nil, testutil.BlockDir(dir, id), nil)
+	require.NoError(t, err)
+	defer b.Close()
+
+	// Each series is of a single shard.
+	seen := map[uint64]struct{}{}
+	for i := uint64(0); i < 3; i++ {
+		shard := cortex_tsdb.BlockShard{Index: i, Count: 3}
+		ir, err := (&shardBlock{BlockReader: b, shard: shard}).Index()
+		require.NoError(t, err)
+		p, err := ir.Postings(index.AllPostingsKey())
+		require.NoError(t, err)
+		for p.Next() {
+			var lset labels.Labels
+			var chks []chunks.Meta
+			require.NoError(t, ir.Series(p.At(), &lset, &chks))
+			require.Equal(t, i, lset.Hash()%3)
+			seen[lset.Hash()] = struct{}{}
+		}
+		require.NoError(t, p.Err())
+		require.NoError(t, ir.Close())
+	}
+	require.Len(t, seen, len(series))
+
+	// The series of the shards by series ID are those of the query shards.
+	seen = map[uint64]struct{}{}
+	for i := uint64(0); i < 3; i++ {
+		shard := cortex_tsdb.BlockShard{Index: i, Count: 3, Hash: cortex_tsdb.ShardHashSeriesID}
+		ir, err := (&shardBlock{BlockReader: b, shard: shard}).Index()
+		require.NoError(t, err)
+		p, err := ir.Postings(index.AllPostingsKey())
+		require.NoError(t, err)
+		for p.Next() {
+			var lset labels.Labels
+			var chks []chunks.Meta
+			require.NoError(t, ir.Series(p.At(), &lset, &chks))
+			require.True(t, (&chunk.QueryShard{Index: uint32(i), Of: 3}).MatchesLabels(fromTSDBLabels(lset)))
+			seen[lset.Hash()] = struct{}{}
+		}
+		require.NoError(t, p.Err())
+		require.NoError(t, ir.Close())
+	}
+	require.Len(t, seen, len(series))
+}
+
+func TestCompactorSplitsBlocksSplitByLabels(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	// A block split by the hash of its labels, before the shards by series ID.
+	id := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	meta, err := cortex_tsdb.ReadMeta(context.Background(), bkt, "user", id)
+	require.NoError(t, err)
+	meta.Shard = &cortex_tsdb.BlockShard{Index: 0, Count: 2}
+	buf, err := json.Marshal(meta)
+	require.NoError(t, err)
+	require.NoError(t, bkt.Upload(context.Background(), path.Join(cortex_tsdb.BlockDir("user", id), cortex_tsdb.MetaFilename), bytes.NewReader(buf)))
+
+	cfg.CompactionStrategy = CompactionStrategySplitAndMerge
+	cfg.SplitShards = 2
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+
+	metas := readMetas(t, bkt, "user")
+	require.NotEmpty(t, metas)
+	for _, meta := range metas {
+		require.NotEqual(t, id, meta.ULID)
+		require.Equal(t, cortex_tsdb.ShardHashSeriesID, meta.Shard.Hash)
+	}
+}
+
+func TestCompactorDelayedDeletion(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 2; i++ {
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	}
+
+	cfg.DeletionDelay = time.Hour
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+
+	// The blocks compacted are marked for deletion, and no longer compacted.
+	metas := readMetas(t, bkt, "user")
+	require.Len(t, metas, 3)
+	var marked []ulid.ULID
+	for _, meta := range metas {
+		mark, err := cortex_tsdb.ReadDeletionMark(context.Background(), bkt, "user", meta.ULID)
+		require.NoError(t, err)
+		if mark != nil {
+			marked = append(marked, mark.ID)
+		}
+	}
+	require.Len(t, marked, 2)
+	c.compactUsers(context.Background())
+	require.Len(t, readMetas(t, bkt, "user"), 3)
+
+	// The blocks are deleted once marked for longer than the delay.
+	c.cfg.DeletionDelay = time.Nanosecond
+	time.Sleep(time.Second)
+	c.compactUsers(context.Background())
+	metas = readMetas(t, bkt, "user")
+	require.Len(t, metas, 1)
+	require.NotContains(t, marked, metas[0].ULID)
+}
+
+func TestCompactorTenantDeletion(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	for i := int64(0); i < 2; i++ {
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour)
+	}
+	testutil.UploadBlock(t, bkt, dir, "other", testSeries, 0, 2*hour)
+
+	cfg.DeletionDelay = time.Hour
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.bucketIndex = true
+
+	// The tenant is marked for deletion through the API.
+	req := httptest.NewRequest("POST", "/compactor/delete_tenant", nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "user"))
+	w := httptest.NewRecorder()
+	c.DeleteTenantHandler(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	mark, err := cortex_tsdb.ReadTenantDeletionMark(context.Background(), bkt, "user")
+	require.NoError(t, err)
+	require.NotNil(t, mark)
+
+	// The blocks of the tenant are no longer compacted, and kept for the
+	// deletion delay.
+	c.compactUsers(context.Background())
+	require.Len(t, readMetas(t, bkt, "user"), 2)
+	_, err = cortex_tsdb.ReadBucketIndex(context.Background(), bkt, "user")
+	require.Equal(t, cortex_tsdb.ErrBucketIndexNotFound, err)
+
+	c.cfg.DeletionDelay = time.Nanosecond
+	time.Sleep(time.Second)
+	c.compactUsers(context.Background())
+	require.Empty(t, readMetas(t, bkt, "user"))
+	require.Len(t, readMetas(t, bkt, "other"), 1)
+	mark, err = cortex_tsdb.ReadTenantDeletionMark(context.Background(), bkt, "user")
+	require.NoError(t, err)
+	require.NotNil(t, mark)
+}
+
+func TestCompactorRetention(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	testutil.UploadBlock(t, bkt, dir, "user", testSeries, now-50*hour, now-48*hour)
+	testutil.UploadBlock(t, bkt, dir, "user", testSeries, now-2*hour, now)
+
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.CompactorBlocksRetentionPeriod = 24 * time.Hour
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.limits = overrides
+	c.compactUsers(context.Background())
+
+	// Only the block beyond the retention is deleted.
+	metas := readMetas(t, bkt, "user")
+	require.Len(t, metas, 1)
+	require.Equal(t, now-2*hour, metas[0].MinTime)
+}
+
+func TestCompactorSkipsRecentAndPartialBlocks(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 2; i++ {
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	}
+	// A partially uploaded block, without meta file, kept with a 0 partial
+	// block deletion delay.
+	require.NoError(t, bkt.Upload(context.Background(), "user/01DTVP434PA9VFXSW2JKB3392D/index", strings.NewReader("")))
+
+	cfg.ConsistencyDelay = time.Hour
+	cfg.PartialBlockDeletionDelay = 0
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+	ids, err := cortex_tsdb.ListBlocks(context.Background(), bkt, "user")
+	require.NoError(t, err)
+	require.Len(t, ids, 3)
+
+	cfg.ConsistencyDelay = 0
+	c, err = newCompactor(cfg, bkt)
+	require.NoError(t, err)
+	c.compactUsers(context.Background())
+	ids, err = cortex_tsdb.ListBlocks(context.Background(), bkt, "user")
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+	require.Len(t, readMetas(t, bkt, "user"), 1)
+}
+
+func TestCompactorEnabledDisabledTenants(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	for _, userID := range []string{"user-1", "user-2", "user-3"} {
+		testutil.UploadBlock(t, bkt, dir, userID, testSeries, 0, 2*hour)
+	}
+
+	for _, tc := range []struct {
+		enabled, disabled []string
+		expected          []string
+	}{
+		{expected: []string{"user-1", "user-2", "user-3"}},
+		{enabled: []string{"user-1", "user-3"}, expected: []string{"user-1", "user-3"}},
+		{disabled: []string{"user-2"}, expected: []string{"user-1", "user-3"}},
+		{enabled: []string{"user-1", "user-2"}, disabled: []string{"user-2"}, expected: []string{"user-1"}},
+	} {
+		cfg.EnabledTenants = tc.enabled
+		cfg.DisabledTenants = tc.disabled
+		c, err := newCompactor(cfg, bkt)
+		require.NoError(t, err)
+		users, err := c.users(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, users)
+	}
+}
+
+func TestCompactorSharding(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	var expected []string
+	for i := 0; i < 20; i++ {
+		userID := fmt.Sprintf("user-%02d", i)
+		testutil.UploadBlock(t, bkt, dir, userID, testSeries, 0, 2*hour)
+		expected = append(expected, userID)
+	}
+
+	kvStore := consul.NewInMemoryClient(ring.GetCodec())
+	compactors := make([]*Compactor, 0, 2)
+	for _, addr := range []string{"1.1.1.1", "2.2.2.2"} {
+		cfg := cfg
+		cfg.ShardingEnabled = true
+		cfg.LifecyclerConfig.RingConfig.KVStore.Mock = kvStore
+		cfg.LifecyclerConfig.Addr = addr
+		cfg.LifecyclerConfig.Port = 1
+		cfg.LifecyclerConfig.ID = addr
+		cfg.LifecyclerConfig.NumTokens = 64
+		cfg.LifecyclerConfig.FinalSleep = 0
+
+		c, err := newCompactor(cfg, bkt)
+		require.NoError(t, err)
+		defer c.shutdown()
+		compactors = append(compactors, c)
+	}
+	for _, c := range compactors {
+		c := c
+		test.Poll(t, 5*time.Second, 2, func() interface{} {
+			set, err := c.ring.GetAll()
+			if err != nil {
+				return 0
+			}
+			active := 0
+			for _, ing := range set.Ingesters {
+				if ing.State == ring.ACTIVE {
+					active++
+				}
+			}
+			return active
+		})
+	}
+
+	// Each tenant is owned by a single compactor.
+	var all []string
+	for _, c := range compactors {
+		users, err := c.users(context.Background())
+		require.NoError(t, err)
+		require.NotEmpty(t, users)
+		all = append(all, users...)
+	}
+	sort.Strings(all)
+	require.Equal(t, expected, all)
+}
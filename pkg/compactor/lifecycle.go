@@ -0,0 +1,18 @@
+package compactor
+
+import (
+	"context"
+)
+
+// TransferOut is a noop for the compactor, the blocks are all in the object
+// store.
+func (c *Compactor) TransferOut(ctx context.Context) error {
+	return nil
+}
+
+// StopIncomingRequests is a noop for the compactor, which serves no requests.
+func (c *Compactor) StopIncomingRequests() {}
+
+// Flush is a noop for the compactor, the compaction in progress is waited
+// for by Stop.
+func (c *Compactor) Flush() {}
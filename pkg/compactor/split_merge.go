@@ -0,0 +1,335 @@
+package compactor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	prom_labels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/labels"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// splitAndMerge splits the blocks of a tenant which aren't of a shard by series
+// ID yet into shards of their series, then compacts the blocks of each shard.
+// Each block split and each shard compacted is a job of its own, spread across
+// the compactors when sharding is enabled, and run concurrently up to the
+// compaction concurrency of the tenant.
+func (c *Compactor) splitAndMerge(ctx context.Context, userID string, tsdbCompactor *tsdb.LeveledCompactor, dir, metaDir string) error {
+	metas, err := readLocalMetas(metaDir)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mtx    sync.Mutex
+		shards = make(map[cortex_tsdb.BlockShard][]*cortex_tsdb.Meta, c.cfg.SplitShards)
+		jobs   []func() error
+	)
+	for _, meta := range metas {
+		if meta.Shard != nil {
+			// The blocks split in other shard counts are left as they are, those
+			// split by the hash of their labels split again by series ID.
+			if meta.Shard.Count != uint64(c.cfg.SplitShards) {
+				continue
+			}
+			if meta.Shard.Hash == cortex_tsdb.ShardHashSeriesID {
+				shards[*meta.Shard] = append(shards[*meta.Shard], meta)
+				continue
+			}
+		}
+
+		owned, err := c.ownsJob(userID, meta.ULID.String())
+		if err != nil {
+			return err
+		}
+		if !owned {
+			continue
+		}
+		meta := meta
+		jobs = append(jobs, func() error {
+			start := time.Now()
+			split, err := c.splitBlock(ctx, userID, meta, filepath.Join(dir, "split-"+meta.ULID.String()))
+			if err != nil {
+				return fmt.Errorf("splitting block %s: %v", meta.ULID, err)
+			}
+			c.jobCompleted(userID, time.Since(start))
+			mtx.Lock()
+			defer mtx.Unlock()
+			for _, m := range split {
+				shards[*m.Shard] = append(shards[*m.Shard], m)
+			}
+			return nil
+		})
+	}
+	if err := runJobs(ctx, c.tenantConcurrency(userID), jobs); err != nil {
+		return err
+	}
+
+	jobs = jobs[:0]
+	for i := uint64(0); i < uint64(c.cfg.SplitShards); i++ {
+		shard := cortex_tsdb.BlockShard{Index: i, Count: uint64(c.cfg.SplitShards), Hash: cortex_tsdb.ShardHashSeriesID}
+		owned, err := c.ownsJob(userID, shard.String())
+		if err != nil {
+			return err
+		}
+		if !owned || len(shards[shard]) == 0 {
+			continue
+		}
+
+		shardDir := filepath.Join(dir, "meta-"+shard.String())
+		for _, meta := range shards[shard] {
+			if err := cortex_tsdb.WriteLocalMeta(filepath.Join(shardDir, meta.ULID.String()), meta); err != nil {
+				return err
+			}
+		}
+		jobs = append(jobs, func() error {
+			// The shards are already compacted concurrently.
+			if err := c.compactBlocks(ctx, userID, tsdbCompactor, shardDir, filepath.Join(dir, "compact-"+shard.String()), &shard, 1); err != nil {
+				return fmt.Errorf("compacting shard %s: %v", shard, err)
+			}
+			return nil
+		})
+	}
+	return runJobs(ctx, c.tenantConcurrency(userID), jobs)
+}
+
+// runJobs runs the jobs of a tenant, at most concurrency of them at once, until
+// one fails, and returns its error.
+func runJobs(ctx context.Context, concurrency int, jobs []func() error) error {
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		firstErr error
+		queue    = make(chan func() error)
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if err := job(); err != nil {
+					mtx.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mtx.Unlock()
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		mtx.Lock()
+		failed := firstErr != nil
+		mtx.Unlock()
+		if failed || ctx.Err() != nil {
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// ownsJob returns whether a job of a tenant is run by this compactor.
+func (c *Compactor) ownsJob(userID, job string) (bool, error) {
+	if !c.cfg.ShardingEnabled {
+		return true, nil
+	}
+	return c.owns(userID + "/" + job)
+}
+
+// splitBlock splits a block of a tenant into a block per shard of its series,
+// and returns their metas. The blocks of the shards are uploaded before the
+// block split is deleted, the queriers deduplicating the samples in the
+// meantime.
+func (c *Compactor) splitBlock(ctx context.Context, userID string, meta *cortex_tsdb.Meta, compactDir string) ([]*cortex_tsdb.Meta, error) {
+	defer os.RemoveAll(compactDir)
+
+	blockDir := filepath.Join(compactDir, meta.ULID.String())
+	if err := cortex_tsdb.DownloadBlock(ctx, c.bucket, userID, meta.ULID, blockDir); err != nil {
+		return nil, fmt.Errorf("downloading block: %v", err)
+	}
+
+	split, err := c.writeShards(blockDir, meta, compactDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range split {
+		if err := cortex_tsdb.UploadBlock(ctx, c.bucket, userID, filepath.Join(compactDir, m.ULID.String())); err != nil {
+			return nil, fmt.Errorf("uploading block %s: %v", m.ULID, err)
+		}
+	}
+	if err := c.deleteBlock(ctx, userID, meta.ULID); err != nil {
+		return nil, fmt.Errorf("deleting block: %v", err)
+	}
+
+	blocksSplit.Inc()
+	level.Info(util.Logger).Log("msg", "split block", "user", userID, "block", meta.ULID, "shards", len(split))
+	return split, nil
+}
+
+// writeShards writes the blocks of the shards of the series of the block in
+// blockDir to dir, the shards without series having none.
+func (c *Compactor) writeShards(blockDir string, meta *cortex_tsdb.Meta, dir string) ([]*cortex_tsdb.Meta, error) {
+	b, err := tsdb.OpenBlock(util.Logger, blockDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	var split []*cortex_tsdb.Meta
+	for i := uint64(0); i < uint64(c.cfg.SplitShards); i++ {
+		shard := cortex_tsdb.BlockShard{Index: i, Count: uint64(c.cfg.SplitShards), Hash: cortex_tsdb.ShardHashSeriesID}
+		id, err := c.tsdbCompactor.Write(dir, &shardBlock{BlockReader: b, shard: shard}, meta.MinTime, meta.MaxTime, &meta.BlockMeta)
+		if err != nil {
+			return nil, fmt.Errorf("writing shard %s: %v", shard, err)
+		}
+		if id == (ulid.ULID{}) {
+			continue
+		}
+
+		// The blocks of the shards keep the compaction history of the block.
+		newDir := filepath.Join(dir, id.String())
+		m, err := cortex_tsdb.ReadLocalMeta(newDir)
+		if err != nil {
+			return nil, err
+		}
+		m.Compaction.Level = meta.Compaction.Level
+		m.Compaction.Sources = meta.Compaction.Sources
+		m.Shard = &shard
+		if err := cortex_tsdb.WriteLocalMeta(newDir, m); err != nil {
+			return nil, err
+		}
+		split = append(split, m)
+	}
+	return split, nil
+}
+
+// readLocalMetas reads the metas of the blocks in dir, sorted by time.
+func readLocalMetas(dir string) ([]*cortex_tsdb.Meta, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	metas := make([]*cortex_tsdb.Meta, 0, len(files))
+	for _, f := range files {
+		meta, err := cortex_tsdb.ReadLocalMeta(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].MinTime < metas[j].MinTime
+	})
+	return metas, nil
+}
+
+// shardBlock is a block with the series of a shard only.
+type shardBlock struct {
+	tsdb.BlockReader
+	shard cortex_tsdb.BlockShard
+}
+
+func (b *shardBlock) Index() (tsdb.IndexReader, error) {
+	ir, err := b.BlockReader.Index()
+	if err != nil {
+		return nil, err
+	}
+	return &shardIndexReader{IndexReader: ir, shard: b.shard}, nil
+}
+
+// shardIndexReader filters the postings of an index with the series of a
+// shard.
+type shardIndexReader struct {
+	tsdb.IndexReader
+	shard cortex_tsdb.BlockShard
+}
+
+func (r *shardIndexReader) Postings(name, value string) (index.Postings, error) {
+	p, err := r.IndexReader.Postings(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return &shardPostings{Postings: p, ir: r.IndexReader, shard: r.shard}, nil
+}
+
+type shardPostings struct {
+	index.Postings
+	ir    tsdb.IndexReader
+	shard cortex_tsdb.BlockShard
+
+	lset labels.Labels
+	chks []chunks.Meta
+	err  error
+}
+
+func (p *shardPostings) Next() bool {
+	for p.err == nil && p.Postings.Next() {
+		if p.inShard() {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *shardPostings) Seek(v uint64) bool {
+	if !p.Postings.Seek(v) {
+		return false
+	}
+	if p.inShard() {
+		return true
+	}
+	return p.Next()
+}
+
+func (p *shardPostings) Err() error {
+	if p.err != nil {
+		return p.err
+	}
+	return p.Postings.Err()
+}
+
+// inShard returns whether the series at the current position is of the shard,
+// stopping the iteration on error.
+func (p *shardPostings) inShard() bool {
+	if p.err != nil {
+		return false
+	}
+	if err := p.ir.Series(p.At(), &p.lset, &p.chks); err != nil {
+		p.err = err
+		return false
+	}
+	if p.shard.Hash == cortex_tsdb.ShardHashSeriesID {
+		shard := chunk.QueryShard{Index: uint32(p.shard.Index), Of: uint32(p.shard.Count)}
+		return shard.MatchesLabels(fromTSDBLabels(p.lset))
+	}
+	return p.lset.Hash()%p.shard.Count == p.shard.Index
+}
+
+func fromTSDBLabels(lset labels.Labels) prom_labels.Labels {
+	result := make(prom_labels.Labels, 0, len(lset))
+	for _, l := range lset {
+		result = append(result, prom_labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return result
+}
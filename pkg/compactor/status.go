@@ -0,0 +1,412 @@
+package compactor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const statusTpl = `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>Cortex Compactor Tenants</title>
+	</head>
+	<body>
+		<h1>Cortex Compactor Tenants</h1>
+		<p>Current time: {{ .Now }}</p>
+		<table border="1">
+			<thead>
+				<tr>
+					<th>User</th>
+					<th>Running</th>
+					<th>Last Run</th>
+					<th>Last Success</th>
+					<th>Last Error</th>
+					<th>Blocks by Level</th>
+					<th>Pending Jobs</th>
+					<th>Estimated Catch Up</th>
+					<th></th>
+				</tr>
+			</thead>
+			<tbody>
+				{{ range .Tenants }}
+				<tr>
+					<td>{{ .UserID }}</td>
+					<td>{{ .Running }}</td>
+					<td>{{ .LastRun }}</td>
+					<td>{{ .LastSuccess }}</td>
+					<td>{{ .LastError }}</td>
+					<td>{{ range .Levels }}{{ .Level }}: {{ .Blocks }} {{ end }}</td>
+					<td align='right'>{{ .PendingJobs }}</td>
+					<td>{{ if .EstimatedCatchUp }}{{ .EstimatedCatchUp }}{{ else }}unknown{{ end }}</td>
+					<td>
+						<form action="compact_tenant" method="POST">
+							<input type="hidden" name="tenant" value="{{ .UserID }}">
+							<button type="submit">Compact</button>
+						</form>
+					</td>
+				</tr>
+				{{ end }}
+			</tbody>
+		</table>
+	</body>
+</html>`
+
+var statusTmpl = template.Must(template.New("webpage").Parse(statusTpl))
+
+// tenantStatus is the compaction status of a tenant compacted by this
+// compactor, its blocks being those planned by its last run, and its jobs
+// those of its run in progress or failed.
+type tenantStatus struct {
+	running       bool
+	lastRun       time.Time
+	lastSuccess   time.Time
+	lastError     string
+	levels        map[int]int
+	plannedJobs   int
+	completedJobs int
+}
+
+// TenantStatus is the compaction status of a tenant, as shown by the status
+// page.
+type TenantStatus struct {
+	UserID      string        `json:"user_id"`
+	Running     bool          `json:"running"`
+	LastRun     time.Time     `json:"last_run"`
+	LastSuccess time.Time     `json:"last_success"`
+	LastError   string        `json:"last_error,omitempty"`
+	Levels      []LevelBlocks `json:"levels"`
+	PendingJobs int           `json:"pending_jobs"`
+	// The pending jobs run for the average duration of the jobs of this
+	// compactor, empty until it has run one.
+	EstimatedCatchUp string `json:"estimated_catch_up,omitempty"`
+}
+
+// LevelBlocks is the number of blocks of a tenant of a compaction level.
+type LevelBlocks struct {
+	Level  int `json:"level"`
+	Blocks int `json:"blocks"`
+}
+
+// startTenantRun records the start of a compaction of a tenant.
+func (c *Compactor) startTenantRun(userID string) {
+	c.statusMtx.Lock()
+	defer c.statusMtx.Unlock()
+	s, ok := c.tenants[userID]
+	if !ok {
+		s = &tenantStatus{}
+		c.tenants[userID] = s
+	}
+	s.running = true
+	s.lastRun = time.Now()
+}
+
+// endTenantRun records the end of a compaction of a tenant, successful if err
+// is nil.
+func (c *Compactor) endTenantRun(userID string, err error) {
+	c.statusMtx.Lock()
+	defer c.statusMtx.Unlock()
+	s, ok := c.tenants[userID]
+	if !ok {
+		return
+	}
+	s.running = false
+	if err != nil {
+		s.lastError = err.Error()
+		return
+	}
+	// The blocks left to compact once successful are only planned by the next
+	// run.
+	s.lastSuccess = time.Now()
+	s.lastError = ""
+	s.plannedJobs = 0
+	s.completedJobs = 0
+}
+
+// planTenant records the blocks of a tenant, whose metas are in metaDir, and
+// the number of jobs estimated to compact them.
+func (c *Compactor) planTenant(userID, metaDir string) error {
+	metas, err := readLocalMetas(metaDir)
+	if err != nil {
+		return err
+	}
+	levels := map[int]int{}
+	for _, meta := range metas {
+		levels[meta.Compaction.Level]++
+	}
+	jobs := c.estimateJobs(metas, c.blockRanges(userID).ToMilliseconds())
+
+	c.statusMtx.Lock()
+	defer c.statusMtx.Unlock()
+	if s, ok := c.tenants[userID]; ok {
+		s.levels = levels
+		s.plannedJobs = jobs
+		s.completedJobs = 0
+	}
+	return nil
+}
+
+// jobCompleted records a compaction job of a tenant, a compaction of blocks or
+// a block split, run for d.
+func (c *Compactor) jobCompleted(userID string, d time.Duration) {
+	c.statusMtx.Lock()
+	defer c.statusMtx.Unlock()
+	c.jobs++
+	c.jobsDuration += d
+	if s, ok := c.tenants[userID]; ok {
+		s.completedJobs++
+	}
+}
+
+// keepTenantStatuses forgets the statuses of the tenants no longer compacted
+// by this compactor.
+func (c *Compactor) keepTenantStatuses(users []string) {
+	keep := make(map[string]struct{}, len(users))
+	for _, userID := range users {
+		keep[userID] = struct{}{}
+	}
+	c.statusMtx.Lock()
+	defer c.statusMtx.Unlock()
+	for userID := range c.tenants {
+		if _, ok := keep[userID]; !ok {
+			delete(c.tenants, userID)
+		}
+	}
+}
+
+// TenantStatuses returns the compaction statuses of the tenants of this
+// compactor, sorted by tenant.
+func (c *Compactor) TenantStatuses() []TenantStatus {
+	c.statusMtx.Lock()
+	defer c.statusMtx.Unlock()
+
+	var avgJob time.Duration
+	if c.jobs > 0 {
+		avgJob = c.jobsDuration / time.Duration(c.jobs)
+	}
+	statuses := make([]TenantStatus, 0, len(c.tenants))
+	for userID, s := range c.tenants {
+		status := TenantStatus{
+			UserID:      userID,
+			Running:     s.running,
+			LastRun:     s.lastRun,
+			LastSuccess: s.lastSuccess,
+			LastError:   s.lastError,
+			Levels:      make([]LevelBlocks, 0, len(s.levels)),
+		}
+		for l, n := range s.levels {
+			status.Levels = append(status.Levels, LevelBlocks{Level: l, Blocks: n})
+		}
+		sort.Slice(status.Levels, func(i, j int) bool {
+			return status.Levels[i].Level < status.Levels[j].Level
+		})
+		if s.plannedJobs > s.completedJobs {
+			status.PendingJobs = s.plannedJobs - s.completedJobs
+		}
+		if status.PendingJobs == 0 {
+			status.EstimatedCatchUp = time.Duration(0).String()
+		} else if c.jobs > 0 {
+			status.EstimatedCatchUp = (time.Duration(status.PendingJobs) * avgJob).Round(time.Second).String()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].UserID < statuses[j].UserID
+	})
+	return statuses
+}
+
+// StatusHandler shows the compaction statuses of the tenants of this
+// compactor, as JSON if asked for.
+func (c *Compactor) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := c.TenantStatuses()
+
+	if encodings, found := r.Header["Accept"]; found &&
+		len(encodings) > 0 && strings.Contains(encodings[0], "json") {
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			http.Error(w, fmt.Sprintf("Error marshalling response: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := statusTmpl.Execute(w, struct {
+		Now     time.Time
+		Tenants []TenantStatus
+	}{
+		Now:     time.Now(),
+		Tenants: statuses,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// CompactTenantHandler triggers the compaction of the tenant of the tenant form
+// value, run by this compactor right after its compaction in progress, if
+// any.
+func (c *Compactor) CompactTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.FormValue("tenant")
+	if userID == "" {
+		http.Error(w, "no tenant specified", http.StatusBadRequest)
+		return
+	}
+	if !validTenantID(userID) {
+		http.Error(w, fmt.Sprintf("invalid tenant %q", userID), http.StatusBadRequest)
+		return
+	}
+	// Only the tenants with blocks compacted by this compactor are compacted,
+	// the tenant being joined to the data dir of the compactor.
+	users, err := c.users(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, u := range users {
+		if u == userID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("tenant %s isn't compacted by this compactor", userID), http.StatusNotFound)
+		return
+	}
+
+	c.triggerMtx.Lock()
+	c.triggered[userID] = struct{}{}
+	c.triggerMtx.Unlock()
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+	level.Info(util.Logger).Log("msg", "triggered compaction of tenant", "user", userID)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "compaction of tenant %s triggered\n", userID)
+}
+
+// validTenantID returns whether the tenant can be a directory of the data dir
+// of the compactor, and not a path out of it.
+func validTenantID(userID string) bool {
+	return userID != "" && userID != "." && userID != ".." && !strings.ContainsAny(userID, `/\`)
+}
+
+// compactTriggeredUsers compacts the tenants whose compaction was triggered.
+func (c *Compactor) compactTriggeredUsers(ctx context.Context) {
+	c.triggerMtx.Lock()
+	users := make([]string, 0, len(c.triggered))
+	for userID := range c.triggered {
+		users = append(users, userID)
+	}
+	c.triggered = map[string]struct{}{}
+	c.triggerMtx.Unlock()
+
+	sort.Strings(users)
+	for _, userID := range users {
+		if err := c.compactUserWithRetries(ctx, userID); err != nil {
+			level.Error(util.Logger).Log("msg", "failed to compact the tenant blocks", "user", userID, "err", err)
+		}
+	}
+}
+
+// estimateJobs estimates the number of jobs left to compact the blocks of a
+// tenant with its block ranges: with the split-and-merge strategy, a job per
+// block not split by series ID yet, then the compactions of each shard.
+func (c *Compactor) estimateJobs(metas []*cortex_tsdb.Meta, ranges []int64) int {
+	if c.cfg.CompactionStrategy != CompactionStrategySplitAndMerge {
+		blocks := make([]timeRange, 0, len(metas))
+		for _, meta := range metas {
+			blocks = append(blocks, timeRange{meta.MinTime, meta.MaxTime})
+		}
+		return estimateCompactions(blocks, ranges)
+	}
+
+	var (
+		jobs     int
+		unsplit  []timeRange
+		shards   = make([][]timeRange, c.cfg.SplitShards)
+		shardsOf = uint64(c.cfg.SplitShards)
+	)
+	for _, meta := range metas {
+		switch {
+		case meta.Shard != nil && meta.Shard.Count != shardsOf:
+		case meta.Shard != nil && meta.Shard.Hash == cortex_tsdb.ShardHashSeriesID:
+			shards[meta.Shard.Index] = append(shards[meta.Shard.Index], timeRange{meta.MinTime, meta.MaxTime})
+		default:
+			jobs++
+			unsplit = append(unsplit, timeRange{meta.MinTime, meta.MaxTime})
+		}
+	}
+	for _, blocks := range shards {
+		jobs += estimateCompactions(append(blocks, unsplit...), ranges)
+	}
+	return jobs
+}
+
+type timeRange struct {
+	minTime, maxTime int64
+}
+
+// estimateCompactions estimates the number of compactions of blocks, for each
+// block range but the first: the blocks shorter than the range within each of
+// its aligned windows, up to the newest block, are compacted into a block of
+// the window, itself compacted within the windows of the next ranges.
+func estimateCompactions(blocks []timeRange, ranges []int64) int {
+	jobs := 0
+	for i := 1; i < len(ranges); i++ {
+		r := ranges[i]
+		// The newest block isn't compacted, nor the blocks of its windows.
+		var highTime int64
+		for _, b := range blocks {
+			if b.minTime > highTime {
+				highTime = b.minTime
+			}
+		}
+
+		windows := map[int64][]timeRange{}
+		next := make([]timeRange, 0, len(blocks))
+		for _, b := range blocks {
+			w := b.minTime / r
+			if b.maxTime-b.minTime >= r || (b.maxTime-1)/r != w || (w+1)*r > highTime {
+				next = append(next, b)
+				continue
+			}
+			windows[w] = append(windows[w], b)
+		}
+		for _, bs := range windows {
+			if len(bs) < 2 {
+				next = append(next, bs...)
+				continue
+			}
+			jobs++
+			merged := bs[0]
+			for _, b := range bs[1:] {
+				if b.minTime < merged.minTime {
+					merged.minTime = b.minTime
+				}
+				if b.maxTime > merged.maxTime {
+					merged.maxTime = b.maxTime
+				}
+			}
+			next = append(next, merged)
+		}
+		blocks = next
+	}
+	return jobs
+}
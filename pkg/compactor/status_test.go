@@ -0,0 +1,126 @@
+package compactor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+)
+
+func TestEstimateCompactions(t *testing.T) {
+	ranges := []int64{2 * hour, 12 * hour, 24 * hour}
+	blocks := func(n int) []timeRange {
+		var blocks []timeRange
+		for i := int64(0); i < int64(n); i++ {
+			blocks = append(blocks, timeRange{i * 2 * hour, (i + 1) * 2 * hour})
+		}
+		return blocks
+	}
+
+	for _, tc := range []struct {
+		name   string
+		blocks []timeRange
+		jobs   int
+	}{
+		{name: "newest window", blocks: blocks(6), jobs: 0},
+		{name: "one window", blocks: blocks(7), jobs: 1},
+		{name: "next ranges", blocks: blocks(13), jobs: 3},
+		{name: "sparse blocks", blocks: []timeRange{{0, 2 * hour}, {12 * hour, 14 * hour}, {24 * hour, 26 * hour}}, jobs: 1},
+		{name: "compacted", blocks: []timeRange{{0, 24 * hour}, {24 * hour, 26 * hour}}, jobs: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.jobs, estimateCompactions(tc.blocks, ranges))
+		})
+	}
+}
+
+func TestCompactorTenantStatuses(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	for i := int64(0); i < 7; i++ {
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour)
+	}
+	cfg.DisabledTenants = []string{"disabled"}
+	c, err := newCompactor(cfg, bkt)
+	require.NoError(t, err)
+
+	require.NoError(t, c.planTenantForTest("user"))
+	statuses := c.TenantStatuses()
+	require.Len(t, statuses, 1)
+	require.Equal(t, []LevelBlocks{{Level: 1, Blocks: 7}}, statuses[0].Levels)
+	require.Equal(t, 1, statuses[0].PendingJobs)
+	require.Empty(t, statuses[0].EstimatedCatchUp)
+
+	c.compactUsers(context.Background())
+	statuses = c.TenantStatuses()
+	require.Len(t, statuses, 1)
+	require.False(t, statuses[0].Running)
+	require.False(t, statuses[0].LastSuccess.IsZero())
+	require.Empty(t, statuses[0].LastError)
+	require.Equal(t, 0, statuses[0].PendingJobs)
+	require.Equal(t, "0s", statuses[0].EstimatedCatchUp)
+	lastRun := statuses[0].LastRun
+
+	req := httptest.NewRequest("GET", "/compactor/status", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	c.StatusHandler(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var served []TenantStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&served))
+	require.Len(t, served, 1)
+	require.Equal(t, "user", served[0].UserID)
+
+	w = httptest.NewRecorder()
+	c.StatusHandler(w, httptest.NewRequest("GET", "/compactor/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "<td>user</td>")
+
+	compact := func(method, tenant string) int {
+		req := httptest.NewRequest(method, "/compactor/compact_tenant", strings.NewReader(url.Values{"tenant": {tenant}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		c.CompactTenantHandler(w, req)
+		return w.Code
+	}
+	require.Equal(t, http.StatusMethodNotAllowed, compact("GET", "user"))
+	require.Equal(t, http.StatusBadRequest, compact("POST", ""))
+	require.Equal(t, http.StatusBadRequest, compact("POST", "."))
+	require.Equal(t, http.StatusBadRequest, compact("POST", ".."))
+	require.Equal(t, http.StatusBadRequest, compact("POST", "../../.."))
+	require.Equal(t, http.StatusNotFound, compact("POST", "disabled"))
+	require.Equal(t, http.StatusNotFound, compact("POST", "unknown"))
+	require.Equal(t, http.StatusAccepted, compact("POST", "user"))
+
+	// The triggered tenants are compacted once.
+	select {
+	case <-c.trigger:
+	default:
+		t.Fatal("compaction not triggered")
+	}
+	c.compactTriggeredUsers(context.Background())
+	statuses = c.TenantStatuses()
+	require.True(t, statuses[0].LastRun.After(lastRun))
+	require.Empty(t, c.triggered)
+}
+
+// planTenantForTest plans the compaction of a tenant, as done by its runs.
+func (c *Compactor) planTenantForTest(userID string) error {
+	metaDir := filepath.Join(c.cfg.DataDir, userID, "meta")
+	defer os.RemoveAll(c.cfg.DataDir)
+	if _, err := c.syncMetas(context.Background(), userID, metaDir); err != nil {
+		return err
+	}
+	c.startTenantRun(userID)
+	return c.planTenant(userID, metaDir)
+}
@@ -54,6 +54,9 @@ type DB interface {
 
 	DeactivateConfig(ctx context.Context, userID string) error
 	RestoreConfig(ctx context.Context, userID string) error
+	// DeleteConfig deletes all the versions of the configuration of a user,
+	// leaving a deactivated empty configuration for the pollers to drop it.
+	DeleteConfig(ctx context.Context, userID string) error
 
 	Close() error
 }
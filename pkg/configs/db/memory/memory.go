@@ -83,6 +83,17 @@ func (d *DB) RestoreConfig(ctx context.Context, userID string) error {
 	return d.SetDeletedAtConfig(ctx, userID, time.Time{})
 }
 
+// DeleteConfig deletes the configuration of a user, replacing it with an
+// empty deactivated configuration.
+func (d *DB) DeleteConfig(ctx context.Context, userID string) error {
+	if _, ok := d.cfgs[userID]; !ok {
+		return nil
+	}
+	d.cfgs[userID] = configs.View{ID: configs.ID(d.id), DeletedAt: time.Now()}
+	d.id++
+	return nil
+}
+
 // Close finishes using the db. Noop.
 func (d *DB) Close() error {
 	return nil
@@ -306,6 +306,23 @@ func (d DB) RestoreConfig(ctx context.Context, userID string) error {
 	return d.SetDeletedAtConfig(ctx, userID, pq.NullTime{}, cfg.Config)
 }
 
+// DeleteConfig deletes all the versions of a configuration, inserting an
+// empty deactivated configuration for the pollers to drop it.
+func (d DB) DeleteConfig(ctx context.Context, userID string) error {
+	return d.Transaction(func(tx DB) error {
+		result, err := tx.Delete("configs").
+			Where(squirrel.And{allConfigs, squirrel.Eq{"owner_id": userID}}).
+			Exec()
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		return tx.SetDeletedAtConfig(ctx, userID, pq.NullTime{Time: time.Now(), Valid: true}, configs.Config{})
+	})
+}
+
 // Transaction runs the given function in a postgres transaction. If fn returns
 // an error the txn will be rolled back.
 func (d DB) Transaction(f func(DB) error) error {
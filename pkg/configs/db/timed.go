@@ -89,6 +89,12 @@ func (t timed) RestoreConfig(ctx context.Context, userID string) (err error) {
 	})
 }
 
+func (t timed) DeleteConfig(ctx context.Context, userID string) error {
+	return instrument.CollectedRequest(ctx, "DB.DeleteConfig", databaseRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		return t.d.DeleteConfig(ctx, userID)
+	})
+}
+
 func (t timed) Close() error {
 	return instrument.CollectedRequest(context.Background(), "DB.Close", databaseRequestDuration, instrument.ErrorCode, func(ctx context.Context) error {
 		return t.d.Close()
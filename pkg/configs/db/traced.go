@@ -48,6 +48,11 @@ func (t traced) RestoreConfig(ctx context.Context, userID string) (err error) {
 	return t.d.RestoreConfig(ctx, userID)
 }
 
+func (t traced) DeleteConfig(ctx context.Context, userID string) (err error) {
+	defer func() { t.trace("DeleteConfig", userID, err) }()
+	return t.d.DeleteConfig(ctx, userID)
+}
+
 func (t traced) Close() (err error) {
 	defer func() { t.trace("Close", err) }()
 	return t.d.Close()
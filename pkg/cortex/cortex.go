@@ -1,9 +1,11 @@
 package cortex
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
@@ -15,8 +17,12 @@ import (
 	"github.com/cortexproject/cortex/pkg/alertmanager"
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/chunk/encryption"
+	"github.com/cortexproject/cortex/pkg/chunk/migrator"
+	"github.com/cortexproject/cortex/pkg/chunk/purger"
 	"github.com/cortexproject/cortex/pkg/chunk/storage"
 	chunk_util "github.com/cortexproject/cortex/pkg/chunk/util"
+	"github.com/cortexproject/cortex/pkg/compactor"
 	"github.com/cortexproject/cortex/pkg/configs/api"
 	config_client "github.com/cortexproject/cortex/pkg/configs/client"
 	"github.com/cortexproject/cortex/pkg/configs/db"
@@ -26,7 +32,10 @@ import (
 	"github.com/cortexproject/cortex/pkg/querier"
 	"github.com/cortexproject/cortex/pkg/querier/frontend"
 	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/memberlist"
 	"github.com/cortexproject/cortex/pkg/ruler"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storegateway"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
@@ -67,12 +76,26 @@ type Config struct {
 	Prealloc       client.PreallocConfig    `yaml:"prealloc,omitempty"`
 	Worker         frontend.WorkerConfig    `yaml:"frontend_worker,omitempty"`
 	Frontend       frontend.Config          `yaml:"frontend,omitempty"`
+	QueryScheduler frontend.SchedulerConfig `yaml:"query_scheduler,omitempty"`
 	TableManager   chunk.TableManagerConfig `yaml:"table_manager,omitempty"`
 	Encoding       encoding.Config          `yaml:"-"` // No yaml for this, it only works with flags.
+	DeleteStore    purger.DeleteStoreConfig `yaml:"delete_store,omitempty"`
+	Purger         purger.Config            `yaml:"purger,omitempty"`
+	ChunkMigrator  migrator.Config          `yaml:"chunk_migrator,omitempty"`
+	BlocksMigrator migrator.BlocksConfig    `yaml:"blocks_migrator,omitempty"`
+
+	ChunkReencryptor encryption.ReencryptorConfig `yaml:"chunk_reencryptor,omitempty"`
+
+	BlocksStorage tsdb.Config                    `yaml:"blocks_storage,omitempty"`
+	Compactor     compactor.Config               `yaml:"compactor,omitempty"`
+	StoreGateway  storegateway.Config            `yaml:"store_gateway,omitempty"`
+	BlocksStore   storegateway.BlocksStoreConfig `yaml:"blocks_store,omitempty"`
 
 	Ruler        ruler.Config                               `yaml:"ruler,omitempty"`
 	ConfigStore  config_client.Config                       `yaml:"config_store,omitempty"`
 	Alertmanager alertmanager.MultitenantAlertmanagerConfig `yaml:"alertmanager,omitempty"`
+
+	MemberlistKV memberlist.KVConfig `yaml:"memberlist"`
 }
 
 // RegisterFlags registers flag.
@@ -97,17 +120,80 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	c.Prealloc.RegisterFlags(f)
 	c.Worker.RegisterFlags(f)
 	c.Frontend.RegisterFlags(f)
+	c.QueryScheduler.RegisterFlags(f)
 	c.TableManager.RegisterFlags(f)
 	c.Encoding.RegisterFlags(f)
+	c.DeleteStore.RegisterFlags(f)
+	c.Purger.RegisterFlags(f)
+	c.ChunkMigrator.RegisterFlags(f)
+	c.BlocksMigrator.RegisterFlags(f)
+	c.ChunkReencryptor.RegisterFlags(f)
+	c.BlocksStorage.RegisterFlags(f)
+	c.Compactor.RegisterFlags(f)
+	c.StoreGateway.RegisterFlags(f)
+	c.BlocksStore.RegisterFlags(f)
 
 	c.Ruler.RegisterFlags(f)
 	c.ConfigStore.RegisterFlags(f)
 	c.Alertmanager.RegisterFlags(f)
+	c.MemberlistKV.RegisterFlags(f)
 
 	// These don't seem to have a home.
 	flag.IntVar(&chunk_util.QueryParallelism, "querier.query-parallelism", 100, "Max subqueries run in parallel per higher-level query.")
 }
 
+// Validate the cortex config and returns an error if the validation
+// doesn't pass
+func (c *Config) Validate() error {
+	if err := c.IngesterClient.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ingester_client config")
+	}
+	if err := c.Worker.GRPCClientConfig.Validate(); err != nil {
+		return errors.Wrap(err, "invalid frontend_worker config")
+	}
+	if err := c.Frontend.SchedulerGRPCClientConfig.Validate(); err != nil {
+		return errors.Wrap(err, "invalid frontend config")
+	}
+	var retentionPeriod time.Duration
+	if c.TableManager.RetentionDeletesEnabled {
+		retentionPeriod = c.TableManager.RetentionPeriod
+	}
+	if err := c.Storage.Validate(); err != nil {
+		return errors.Wrap(err, "invalid storage config")
+	}
+	if err := c.ChunkStore.Validate(retentionPeriod); err != nil {
+		return errors.Wrap(err, "invalid chunk_store config")
+	}
+	if err := c.BlocksStorage.Validate(); err != nil {
+		return errors.Wrap(err, "invalid blocks_storage config")
+	}
+	if err := c.LimitsConfig.Validate(); err != nil {
+		return errors.Wrap(err, "invalid limits config")
+	}
+	if err := c.Ingester.LifecyclerConfig.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ingester config")
+	}
+	if err := c.Ruler.LifecyclerConfig.Validate(); err != nil {
+		return errors.Wrap(err, "invalid ruler config")
+	}
+	if err := c.StoreGateway.ShardingRing.Validate(); err != nil {
+		return errors.Wrap(err, "invalid store_gateway config")
+	}
+	if err := c.Compactor.LifecyclerConfig.Validate(); err != nil {
+		return errors.Wrap(err, "invalid compactor config")
+	}
+	if err := c.Compactor.Validate(); err != nil {
+		return errors.Wrap(err, "invalid compactor config")
+	}
+	if err := c.Compactor.ValidateLimits(c.LimitsConfig); err != nil {
+		return errors.Wrap(err, "invalid limits config")
+	}
+	if err := c.Compactor.ValidateBlocksStorage(c.BlocksStorage); err != nil {
+		return errors.Wrap(err, "invalid blocks_storage config")
+	}
+	return nil
+}
+
 // Cortex is the root datastructure for Cortex.
 type Cortex struct {
 	target             moduleName
@@ -121,12 +207,39 @@ type Cortex struct {
 	store        chunk.Store
 	worker       frontend.Worker
 	frontend     *frontend.Frontend
+	scheduler    *frontend.Scheduler
 	tableManager *chunk.TableManager
+	deleteStore  *purger.DeleteStore
+	deletesCache *purger.DeleteRequestsCache
+	purger       *purger.Purger
+	// The configs database of the purger, to delete the configurations of
+	// the deleted tenants.
+	purgerConfigDB db.DB
+	// The stores of the chunk migrator.
+	migratorSources []chunk.ObjectClient
+	migratorStore   chunk.Store
+	migratorCancel  context.CancelFunc
+	// The error of the chunk migration, failing the run.
+	migratorErr chan error
+	// The bucket of the blocks migrator.
+	migratorBucket tsdb.Bucket
+	// The object clients of the chunk re-encryptor.
+	reencryptorClients []chunk.ObjectClient
+	reencryptorCancel  context.CancelFunc
+	// Reloads the schema config of the store and the table manager.
+	schemaReloader *storage.SchemaReloader
+	compactor      *compactor.Compactor
+	storeGateway   *storegateway.StoreGateway
+	// Queries the blocks through the store-gateways.
+	blocksStore *storegateway.BlocksStore
 
 	ruler        *ruler.Ruler
 	configAPI    *api.API
 	configDB     db.DB
 	alertmanager *alertmanager.MultitenantAlertmanager
+
+	// Created on its first use by a ring.
+	memberlistKV *memberlist.KVInit
 }
 
 // New makes a new Cortex.
@@ -138,6 +251,10 @@ func New(cfg Config) (*Cortex, error) {
 		os.Exit(0)
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	cortex := &Cortex{
 		target: cfg.Target,
 	}
@@ -206,7 +323,15 @@ func (t *Cortex) initModule(cfg *Config, m moduleName) error {
 
 // Run starts Cortex running, and blocks until a signal is received.
 func (t *Cortex) Run() error {
-	return t.server.Run()
+	if err := t.server.Run(); err != nil {
+		return err
+	}
+	select {
+	case err := <-t.migratorErr:
+		return err
+	default:
+		return nil
+	}
 }
 
 // Stop gracefully stops a Cortex.
@@ -217,6 +342,9 @@ func (t *Cortex) Stop() error {
 	for i := len(deps) - 1; i >= 0; i-- {
 		t.stopModule(deps[i])
 	}
+	if t.schemaReloader != nil {
+		t.schemaReloader.Stop()
+	}
 	return nil
 }
 
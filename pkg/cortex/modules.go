@@ -1,6 +1,7 @@
 package cortex
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,15 +12,21 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/promql"
 	v1 "github.com/prometheus/prometheus/web/api/v1"
-	httpgrpc_server "github.com/weaveworks/common/httpgrpc/server"
 	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/server"
+	"github.com/weaveworks/common/user"
 	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/cortexproject/cortex/pkg/alertmanager"
 	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/chunk/encryption"
+	"github.com/cortexproject/cortex/pkg/chunk/migrator"
+	"github.com/cortexproject/cortex/pkg/chunk/purger"
 	"github.com/cortexproject/cortex/pkg/chunk/storage"
+	"github.com/cortexproject/cortex/pkg/compactor"
 	"github.com/cortexproject/cortex/pkg/configs/api"
 	config_client "github.com/cortexproject/cortex/pkg/configs/client"
 	"github.com/cortexproject/cortex/pkg/configs/db"
@@ -28,8 +35,12 @@ import (
 	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/querier"
 	"github.com/cortexproject/cortex/pkg/querier/frontend"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
 	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/memberlist"
 	"github.com/cortexproject/cortex/pkg/ruler"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storegateway"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
@@ -45,11 +56,20 @@ const (
 	Ingester
 	Querier
 	QueryFrontend
+	QueryScheduler
 	Store
+	DeleteRequestsStore
+	Purger
 	TableManager
 	Ruler
 	Configs
 	AlertManager
+	ChunkMigrator
+	BlocksMigrator
+	ChunkReencryptor
+	Compactor
+	StoreGateway
+	MemberlistKV
 	All
 )
 
@@ -65,12 +85,18 @@ func (m moduleName) String() string {
 		return "distributor"
 	case Store:
 		return "store"
+	case DeleteRequestsStore:
+		return "delete-requests-store"
+	case Purger:
+		return "purger"
 	case Ingester:
 		return "ingester"
 	case Querier:
 		return "querier"
 	case QueryFrontend:
 		return "query-frontend"
+	case QueryScheduler:
+		return "query-scheduler"
 	case TableManager:
 		return "table-manager"
 	case Ruler:
@@ -79,6 +105,18 @@ func (m moduleName) String() string {
 		return "configs"
 	case AlertManager:
 		return "alertmanager"
+	case ChunkMigrator:
+		return "chunk-migrator"
+	case BlocksMigrator:
+		return "blocks-migrator"
+	case ChunkReencryptor:
+		return "chunk-reencryptor"
+	case Compactor:
+		return "compactor"
+	case StoreGateway:
+		return "store-gateway"
+	case MemberlistKV:
+		return "memberlist-kv"
 	case All:
 		return "all"
 	default:
@@ -103,6 +141,12 @@ func (m *moduleName) Set(s string) error {
 	case "store":
 		*m = Store
 		return nil
+	case "delete-requests-store":
+		*m = DeleteRequestsStore
+		return nil
+	case "purger":
+		*m = Purger
+		return nil
 	case "ingester":
 		*m = Ingester
 		return nil
@@ -112,6 +156,9 @@ func (m *moduleName) Set(s string) error {
 	case "query-frontend":
 		*m = QueryFrontend
 		return nil
+	case "query-scheduler":
+		*m = QueryScheduler
+		return nil
 	case "table-manager":
 		*m = TableManager
 		return nil
@@ -124,6 +171,24 @@ func (m *moduleName) Set(s string) error {
 	case "alertmanager":
 		*m = AlertManager
 		return nil
+	case "chunk-migrator":
+		*m = ChunkMigrator
+		return nil
+	case "blocks-migrator":
+		*m = BlocksMigrator
+		return nil
+	case "chunk-reencryptor":
+		*m = ChunkReencryptor
+		return nil
+	case "compactor":
+		*m = Compactor
+		return nil
+	case "store-gateway":
+		*m = StoreGateway
+		return nil
+	case "memberlist-kv":
+		*m = MemberlistKV
+		return nil
 	case "all":
 		*m = All
 		return nil
@@ -157,12 +222,38 @@ func (t *Cortex) initRing(cfg *Config) (err error) {
 	}
 	prometheus.MustRegister(t.ring)
 	t.server.HTTP.Handle("/ring", t.ring)
+	t.server.HTTP.Handle("/ingester/ring", t.ring)
 	return
 }
 
+func (t *Cortex) initMemberlistKV(cfg *Config) (err error) {
+	t.memberlistKV = memberlist.NewKVInit(&cfg.MemberlistKV)
+	cfg.Ingester.LifecyclerConfig.RingConfig.KVStore.MemberlistKV = t.memberlistKV.GetMemberlistKV
+	cfg.Ruler.LifecyclerConfig.RingConfig.KVStore.MemberlistKV = t.memberlistKV.GetMemberlistKV
+	cfg.Compactor.LifecyclerConfig.RingConfig.KVStore.MemberlistKV = t.memberlistKV.GetMemberlistKV
+	cfg.StoreGateway.ShardingRing.RingConfig.KVStore.MemberlistKV = t.memberlistKV.GetMemberlistKV
+	t.server.HTTP.Handle("/memberlist", t.memberlistKV)
+	return nil
+}
+
+func (t *Cortex) stopMemberlistKV() error {
+	t.memberlistKV.Stop()
+	return nil
+}
+
 func (t *Cortex) initOverrides(cfg *Config) (err error) {
 	t.overrides, err = validation.NewOverrides(cfg.LimitsConfig)
-	return err
+	if err != nil {
+		return err
+	}
+	// The multi KV stores can switch their primary store at runtime, from
+	// the overrides file.
+	cfg.Ingester.LifecyclerConfig.RingConfig.KVStore.Multi.ConfigProvider = t.overrides.MultiKVConfigs
+	cfg.Ruler.LifecyclerConfig.RingConfig.KVStore.Multi.ConfigProvider = t.overrides.MultiKVConfigs
+	cfg.Compactor.LifecyclerConfig.RingConfig.KVStore.Multi.ConfigProvider = t.overrides.MultiKVConfigs
+	cfg.StoreGateway.ShardingRing.RingConfig.KVStore.Multi.ConfigProvider = t.overrides.MultiKVConfigs
+	cfg.Distributor.HATrackerConfig.KVStore.Multi.ConfigProvider = t.overrides.MultiKVConfigs
+	return nil
 }
 
 func (t *Cortex) stopOverrides() error {
@@ -188,33 +279,92 @@ func (t *Cortex) stopDistributor() (err error) {
 }
 
 func (t *Cortex) initQuerier(cfg *Config) (err error) {
-	t.worker, err = frontend.NewWorker(cfg.Worker, httpgrpc_server.NewServer(t.server.HTTPServer.Handler), util.Logger)
+	cfg.Worker.MaxConcurrentRequests = cfg.Querier.MaxConcurrent
+	t.worker, err = frontend.NewWorker(cfg.Worker, t.server.HTTPServer.Handler, t.overrides, util.Logger)
 	if err != nil {
 		return
 	}
 
-	queryable, engine := querier.New(cfg.Querier, t.distributor, t.store)
-	api := v1.NewAPI(
-		engine,
-		queryable,
-		querier.DummyTargetRetriever{},
-		querier.DummyAlertmanagerRetriever{},
-		func() config.Config { return config.Config{} },
-		map[string]string{}, // TODO: include configuration flags
-		func(f http.HandlerFunc) http.HandlerFunc { return f },
-		func() v1.TSDBAdmin { return nil }, // Only needed for admin APIs.
-		false,                              // Disable admin APIs.
-		util.Logger,
-		querier.DummyRulesRetriever{},
-		0, 0, 0, // Remote read samples and concurrency limit.
-		regexp.MustCompile(".*"),
-	)
-	promRouter := route.New().WithPrefix("/api/prom/api/v1")
-	api.Register(promRouter)
+	// The blocks are queried through the store-gateways besides the chunks.
+	var chunkStore querier.ChunkStore = t.store
+	if cfg.BlocksStore.Enabled {
+		t.blocksStore, err = storegateway.NewBlocksStore(cfg.BlocksStore, cfg.StoreGateway, cfg.BlocksStorage)
+		if err != nil {
+			return
+		}
+		chunkStore = querier.NewMultiChunkStore(t.store, t.blocksStore)
+	}
+
+	queryable, engine := querier.New(cfg.Querier, t.distributor, chunkStore, t.overrides)
+	if t.deleteStore != nil {
+		queryable = querier.NewDeleteFilteringQueryable(queryable, t.deletesCache)
+	}
+	if cfg.Querier.TenantFederation {
+		queryable = querier.NewFederatedQueryable(queryable, t.overrides)
+	}
+	// The Prometheus API is built for each of the PromQL engines enforcing
+	// the engine limits of the tenants.
+	newPromRouter := func(engine *promql.Engine) http.Handler {
+		api := v1.NewAPI(
+			engine,
+			queryable,
+			querier.DummyTargetRetriever{},
+			querier.DummyAlertmanagerRetriever{},
+			func() config.Config { return config.Config{} },
+			map[string]string{}, // TODO: include configuration flags
+			func(f http.HandlerFunc) http.HandlerFunc { return f },
+			func() v1.TSDBAdmin { return nil }, // Only needed for admin APIs.
+			false,                              // Disable admin APIs.
+			util.Logger,
+			querier.DummyRulesRetriever{},
+			0, 0, 0, // Remote read samples and concurrency limit.
+			regexp.MustCompile(".*"),
+		)
+		promRouter := route.New().WithPrefix("/api/prom/api/v1")
+		api.Register(promRouter)
+		return querier.TypedQueryRangeHandler(engine, queryable, promRouter)
+	}
+	promRouter := querier.NewTenantEnginesHandler(cfg.Querier, engine, t.overrides, newPromRouter)
+
+	var promHandler http.Handler = querier.ErrorStatusMiddleware(querier.BlockedQueriesMiddleware(t.overrides, promRouter))
+	if cfg.Querier.QueryStatsEnabled {
+		promHandler = querier.QueryStatsMiddleware(promHandler)
+	}
+
+	// The queries honour the read consistency of the request, or of the tenant.
+	queryHandler := func(h http.Handler) http.Handler {
+		return t.httpAuthMiddleware.Wrap(querier.ReadConsistencyMiddleware(t.overrides, h))
+	}
+
+	// The metadata queries go through the queryable of the queries, and are
+	// blocked by their match[] selectors.
+	metadataHandler := func(h http.Handler) http.Handler {
+		return queryHandler(querier.ErrorStatusMiddleware(querier.BlockedQueriesMiddleware(t.overrides, h)))
+	}
 
 	subrouter := t.server.HTTP.PathPrefix("/api/prom").Subrouter()
-	subrouter.PathPrefix("/api/v1").Handler(t.httpAuthMiddleware.Wrap(promRouter))
-	subrouter.Path("/read").Handler(t.httpAuthMiddleware.Wrap(querier.RemoteReadHandler(queryable)))
+	// Serve the metadata endpoints of the Prometheus API ourselves, honouring
+	// the time range and the matchers of the requests.
+	subrouter.Path("/api/v1/series").Handler(metadataHandler(querier.SeriesHandler(cfg.Querier, queryable)))
+	subrouter.Path("/api/v1/labels").Handler(metadataHandler(querier.LabelNamesHandler(cfg.Querier, queryable)))
+	subrouter.Path("/api/v1/label/{name}/values").Handler(metadataHandler(querier.LabelValuesHandler(cfg.Querier, queryable)))
+	subrouter.Path("/api/v1/cardinality/label_names").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.distributor.LabelNamesCardinalityHandler)))
+	subrouter.Path("/api/v1/cardinality/label_values").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.distributor.LabelValuesCardinalityHandler)))
+	if t.deleteStore != nil {
+		deleteRequestHandler := purger.NewDeleteRequestHandler(t.deleteStore, cfg.Purger.DeleteRequestCancelPeriod)
+		subrouter.Path("/api/v1/admin/tsdb/delete_series").Methods("PUT", "POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(deleteRequestHandler.AddDeleteRequestHandler)))
+		subrouter.Path("/api/v1/admin/tsdb/delete_series").Methods("GET").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(deleteRequestHandler.GetAllDeleteRequestsHandler)))
+		subrouter.Path("/api/v1/admin/tsdb/cancel_delete_request").Methods("PUT", "POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(deleteRequestHandler.CancelDeleteRequestHandler)))
+		if cfg.Purger.TenantDeletionEnabled {
+			subrouter.Path("/api/v1/admin/tenant/delete").Methods("PUT", "POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(deleteRequestHandler.AddTenantDeletionRequestHandler)))
+			subrouter.Path("/api/v1/admin/tenant/delete").Methods("GET").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(deleteRequestHandler.GetTenantDeletionRequestsHandler)))
+			subrouter.Path("/api/v1/admin/tenant/cancel_delete").Methods("PUT", "POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(deleteRequestHandler.CancelTenantDeletionRequestHandler)))
+		}
+	}
+	subrouter.Path("/api/v1/format_query").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(querier.FormatQueryHandler)))
+	subrouter.Path("/api/v1/parse_query").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(querier.ParseQueryHandler)))
+	subrouter.PathPrefix("/api/v1").Handler(queryHandler(promHandler))
+	subrouter.Path("/read").Handler(queryHandler(querier.RemoteReadHandler(queryable)))
 	subrouter.Path("/validate_expr").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.distributor.ValidateExprHandler)))
 	subrouter.Path("/chunks").Handler(t.httpAuthMiddleware.Wrap(querier.ChunksHandler(queryable)))
 	subrouter.Path("/user_stats").Handler(middleware.AuthenticateUser.Wrap(http.HandlerFunc(t.distributor.UserStatsHandler)))
@@ -223,11 +373,15 @@ func (t *Cortex) initQuerier(cfg *Config) (err error) {
 
 func (t *Cortex) stopQuerier() error {
 	t.worker.Stop()
+	if t.blocksStore != nil {
+		t.blocksStore.Stop()
+	}
 	return nil
 }
 
 func (t *Cortex) initIngester(cfg *Config) (err error) {
 	cfg.Ingester.LifecyclerConfig.ListenPort = &cfg.Server.GRPCListenPort
+	cfg.Ingester.ShardByAllLabels = cfg.Distributor.ShardByAllLabels
 	t.ingester, err = ingester.New(cfg.Ingester, cfg.IngesterClient, t.overrides, t.store, prometheus.DefaultRegisterer)
 	if err != nil {
 		return
@@ -237,6 +391,9 @@ func (t *Cortex) initIngester(cfg *Config) (err error) {
 	grpc_health_v1.RegisterHealthServer(t.server.GRPC, t.ingester)
 	t.server.HTTP.Path("/ready").Handler(http.HandlerFunc(t.ingester.ReadinessHandler))
 	t.server.HTTP.Path("/flush").Handler(http.HandlerFunc(t.ingester.FlushHandler))
+	t.server.HTTP.Path("/ingester/flush-priority").Handler(http.HandlerFunc(t.ingester.FlushPriorityHandler))
+	t.server.HTTP.Path("/ingester/read-only").Handler(http.HandlerFunc(t.ingester.ReadOnlyHandler))
+	t.server.HTTP.Path("/ingester/memory-stats").Handler(http.HandlerFunc(t.ingester.MemoryStatsHandler))
 	return
 }
 
@@ -252,21 +409,372 @@ func (t *Cortex) initStore(cfg *Config) (err error) {
 	}
 
 	t.store, err = storage.NewStore(cfg.Storage, cfg.ChunkStore, cfg.Schema, t.overrides)
+	if err != nil {
+		return
+	}
+	if cfg.Purger.TenantDeletionEnabled {
+		if err = t.store.CheckDeleteUserChunks(); err != nil {
+			return fmt.Errorf("the chunk store doesn't support the tenant deletion, disable it with -purger.tenant-deletion-enabled=false: %v", err)
+		}
+	}
+
+	if reloadable, ok := t.store.(storage.SchemaReloadable); ok {
+		t.registerSchemaReloadable(cfg, reloadable)
+	}
 	return
 }
 
+// registerSchemaReloadable updates the reloadable with the schema config as
+// it's reloaded, making the schema reloader on the first call.
+func (t *Cortex) registerSchemaReloadable(cfg *Config, reloadable storage.SchemaReloadable) {
+	if t.schemaReloader == nil {
+		t.schemaReloader = storage.NewSchemaReloader(cfg.Schema)
+		t.server.HTTP.Path("/schema").Methods("GET").HandlerFunc(t.schemaReloader.ConfigHandler)
+		t.server.HTTP.Path("/schema/reload").Methods("POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.schemaReloader.ReloadHandler)))
+		t.server.HTTP.Path("/schema/validate").Methods("POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.schemaReloader.ValidateHandler)))
+		if cfg.Schema.ReloadOnSIGHUP {
+			t.schemaReloader.WatchSIGHUP()
+		}
+	}
+	t.schemaReloader.Register(reloadable)
+}
+
 func (t *Cortex) stopStore() error {
 	t.store.Stop()
 	return nil
 }
 
+// initDeleteRequestsStore makes the store of the delete requests, if
+// enabled: the delete series API, and the filtering of the deleted series
+// from the queries, are only served with one.
+func (t *Cortex) initDeleteRequestsStore(cfg *Config) (err error) {
+	storeType := cfg.DeleteStore.Store
+	if storeType == "" {
+		return
+	}
+
+	err = cfg.Schema.Load()
+	if err != nil {
+		return
+	}
+
+	indexClient, err := storage.NewIndexClient(storeType, cfg.Storage, cfg.Schema)
+	if err != nil {
+		return
+	}
+	tableClient, ok := indexClient.(chunk.TableClient)
+	if !ok {
+		tableClient, err = storage.NewTableClient(storeType, cfg.Storage)
+		if err != nil {
+			return
+		}
+	}
+	err = purger.CreateRequestsTable(context.Background(), tableClient, chunk.TableDesc{
+		Name:             cfg.DeleteStore.RequestsTableName,
+		ProvisionedRead:  cfg.TableManager.IndexTables.InactiveReadThroughput,
+		ProvisionedWrite: cfg.TableManager.IndexTables.InactiveWriteThroughput,
+	})
+	if err != nil {
+		return
+	}
+
+	t.deleteStore, err = purger.NewDeleteStore(cfg.DeleteStore, indexClient)
+	if err != nil {
+		return
+	}
+	t.deletesCache = purger.NewDeleteRequestsCache(t.deleteStore, cfg.DeleteStore.CacheTTL)
+	return
+}
+
+func (t *Cortex) initPurger(cfg *Config) (err error) {
+	if !cfg.Purger.Enable {
+		return
+	}
+	if t.deleteStore == nil {
+		return fmt.Errorf("the purger needs the store of the delete requests, set with -deletes.store")
+	}
+
+	var configs purger.ConfigsDeleter
+	if cfg.Purger.DeleteTenantConfigs {
+		t.purgerConfigDB, err = db.New(cfg.ConfigStore.DBConfig)
+		if err != nil {
+			return
+		}
+		configs = t.purgerConfigDB
+	}
+
+	t.purger = purger.NewPurger(cfg.Purger, t.deleteStore, t.store, configs)
+	t.purger.Start()
+	return
+}
+
+func (t *Cortex) stopPurger() error {
+	if t.purger != nil {
+		t.purger.Stop()
+	}
+	if t.purgerConfigDB != nil {
+		t.purgerConfigDB.Close()
+	}
+	return nil
+}
+
+// initChunkMigrator migrates the chunks of the tenants from the store of
+// the config to the destination store in the background, stopping Cortex once
+// done.
+func (t *Cortex) initChunkMigrator(cfg *Config) (err error) {
+	if cfg.ChunkMigrator.DestinationConfigFile == "" {
+		return fmt.Errorf("the chunk migrator needs the store to migrate the chunks to, set with -chunk-migrator.destination-config-file")
+	}
+	err = cfg.Schema.Load()
+	if err != nil {
+		return
+	}
+	destCfg, err := migrator.LoadDestinationConfig(cfg.ChunkMigrator.DestinationConfigFile)
+	if err != nil {
+		return
+	}
+
+	source, err := t.initMigratorSources(cfg)
+	if err != nil {
+		return
+	}
+
+	// The destination store has no caches: the chunks found in the caches of
+	// the source store would never be written.
+	destStoreCfg := cfg.ChunkStore
+	destStoreCfg.ChunkCacheConfig = cache.Config{}
+	destStoreCfg.WriteDedupeCacheConfig = cache.Config{}
+	t.migratorStore, err = storage.NewStore(destCfg.Storage, destStoreCfg, destCfg.Schema, t.overrides)
+	if err != nil {
+		return
+	}
+	m, err := migrator.New(cfg.ChunkMigrator, source, t.migratorStore)
+	if err != nil {
+		return
+	}
+
+	var ctx context.Context
+	ctx, t.migratorCancel = context.WithCancel(context.Background())
+	t.migratorErr = make(chan error, 1)
+	go func() {
+		if err := m.Run(ctx); err != nil {
+			level.Error(util.Logger).Log("msg", "error migrating the chunks", "err", err)
+			t.migratorErr <- err
+		} else {
+			level.Info(util.Logger).Log("msg", "migrated the chunks of all the tenants")
+		}
+		t.server.Stop()
+	}()
+	return
+}
+
+// initMigratorSources returns the source periods of the schema config the
+// chunks are migrated from. The chunks are fetched from the object clients of
+// the source periods, created once per object store.
+func (t *Cortex) initMigratorSources(cfg *Config) ([]migrator.SourcePeriod, error) {
+	var (
+		source  []migrator.SourcePeriod
+		clients = map[string]chunk.ObjectClient{}
+	)
+	for _, period := range cfg.Schema.Configs {
+		objectType := period.ObjectType
+		if objectType == "" {
+			objectType = period.IndexType
+		}
+		client, ok := clients[objectType]
+		if !ok {
+			var err error
+			client, err = storage.NewObjectClient(objectType, cfg.Storage, cfg.Schema, nil)
+			if err != nil {
+				return nil, err
+			}
+			clients[objectType] = client
+			t.migratorSources = append(t.migratorSources, client)
+		}
+		source = append(source, migrator.SourcePeriod{From: period.From.Time, Client: client})
+	}
+	return source, nil
+}
+
+func (t *Cortex) stopChunkMigrator() error {
+	if t.migratorCancel != nil {
+		t.migratorCancel()
+	}
+	if t.migratorStore != nil {
+		t.migratorStore.Stop()
+	}
+	for _, client := range t.migratorSources {
+		client.Stop()
+	}
+	return nil
+}
+
+// initBlocksMigrator converts the chunks of the tenants from the store of the
+// config, looked up in its index, to blocks in the blocks storage in the background, stopping Cortex
+// once done.
+func (t *Cortex) initBlocksMigrator(cfg *Config) (err error) {
+	err = cfg.Schema.Load()
+	if err != nil {
+		return
+	}
+	source, err := t.initMigratorSources(cfg)
+	if err != nil {
+		return
+	}
+
+	t.migratorBucket, err = tsdb.NewBucketClient(context.Background(), cfg.BlocksStorage)
+	if err != nil {
+		return
+	}
+	m, err := migrator.NewBlocksMigrator(cfg.BlocksMigrator, source, t.store, t.migratorBucket)
+	if err != nil {
+		return
+	}
+
+	var ctx context.Context
+	ctx, t.migratorCancel = context.WithCancel(context.Background())
+	go func() {
+		if err := m.Run(ctx); err != nil {
+			level.Error(util.Logger).Log("msg", "error converting the chunks to blocks", "err", err)
+		} else {
+			level.Info(util.Logger).Log("msg", "converted the chunks of all the tenants to blocks")
+		}
+		t.server.Stop()
+	}()
+	return
+}
+
+func (t *Cortex) stopBlocksMigrator() error {
+	if t.migratorCancel != nil {
+		t.migratorCancel()
+	}
+	if t.migratorBucket != nil {
+		t.migratorBucket.Close()
+	}
+	for _, client := range t.migratorSources {
+		client.Stop()
+	}
+	return nil
+}
+
+// initChunkReencryptor writes again the chunks of the tenants to the object
+// stores of the schema config, encrypting them with the current data keys of
+// the tenants, then stops Cortex.
+func (t *Cortex) initChunkReencryptor(cfg *Config) (err error) {
+	if !cfg.Storage.Encryption.Enabled() {
+		return fmt.Errorf("the chunk re-encryptor needs the KMS encrypting the chunks, set with -encryption.kms")
+	}
+	err = cfg.Schema.Load()
+	if err != nil {
+		return
+	}
+
+	// The periods sharing an object store share its chunks.
+	seen := map[string]bool{}
+	for _, period := range cfg.Schema.Configs {
+		objectType := period.ObjectType
+		if objectType == "" {
+			objectType = period.IndexType
+		}
+		if seen[objectType] {
+			continue
+		}
+		seen[objectType] = true
+
+		client, err := storage.NewObjectClient(objectType, cfg.Storage, cfg.Schema, t.overrides)
+		if err != nil {
+			return err
+		}
+		t.reencryptorClients = append(t.reencryptorClients, client)
+	}
+
+	var ctx context.Context
+	ctx, t.reencryptorCancel = context.WithCancel(context.Background())
+	go func() {
+		defer t.server.Stop()
+		for _, userID := range cfg.ChunkReencryptor.Users {
+			for _, client := range t.reencryptorClients {
+				n, err := encryption.Reencrypt(user.InjectOrgID(ctx, userID), client, userID, cfg.ChunkReencryptor.BatchSize)
+				if err != nil {
+					level.Error(util.Logger).Log("msg", "error re-encrypting the chunks", "user", userID, "chunks", n, "err", err)
+					return
+				}
+				level.Info(util.Logger).Log("msg", "re-encrypted the chunks of a tenant", "user", userID, "chunks", n)
+			}
+		}
+	}()
+	return
+}
+
+func (t *Cortex) stopChunkReencryptor() error {
+	if t.reencryptorCancel != nil {
+		t.reencryptorCancel()
+	}
+	for _, client := range t.reencryptorClients {
+		client.Stop()
+	}
+	return nil
+}
+
+func (t *Cortex) initCompactor(cfg *Config) (err error) {
+	cfg.Compactor.LifecyclerConfig.ListenPort = &cfg.Server.GRPCListenPort
+	t.compactor, err = compactor.NewCompactor(cfg.Compactor, cfg.BlocksStorage, t.overrides)
+	if err != nil {
+		return
+	}
+
+	t.server.HTTP.Handle("/compactor_ring", t.compactor)
+	t.server.HTTP.Handle("/compactor/ring", t.compactor)
+	t.server.HTTP.HandleFunc("/compactor/status", t.compactor.StatusHandler)
+	t.server.HTTP.Path("/compactor/compact_tenant").Methods("POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.compactor.CompactTenantHandler)))
+	t.server.HTTP.Handle("/compactor/delete_tenant", t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.compactor.DeleteTenantHandler)))
+	t.server.HTTP.Path("/api/v1/upload/block/{block}/start").Methods("POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.compactor.StartBlockUploadHandler)))
+	t.server.HTTP.Path("/api/v1/upload/block/{block}/files").Methods("POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.compactor.UploadBlockFileHandler)))
+	t.server.HTTP.Path("/api/v1/upload/block/{block}/finish").Methods("POST").Handler(t.httpAuthMiddleware.Wrap(http.HandlerFunc(t.compactor.FinishBlockUploadHandler)))
+	return
+}
+
+func (t *Cortex) stopCompactor() error {
+	t.compactor.Stop()
+	return nil
+}
+
+func (t *Cortex) initStoreGateway(cfg *Config) (err error) {
+	cfg.StoreGateway.ShardingRing.ListenPort = &cfg.Server.GRPCListenPort
+	t.storeGateway, err = storegateway.NewStoreGateway(cfg.StoreGateway, cfg.BlocksStorage)
+	if err != nil {
+		return
+	}
+
+	storegateway.RegisterStoreGatewayServer(t.server.GRPC, t.storeGateway)
+	t.server.HTTP.Handle("/store_gateway_ring", t.storeGateway)
+	t.server.HTTP.Handle("/store-gateway/ring", t.storeGateway)
+	return
+}
+
+func (t *Cortex) stopStoreGateway() error {
+	t.storeGateway.Stop()
+	return nil
+}
+
 func (t *Cortex) initQueryFrontend(cfg *Config) (err error) {
-	t.frontend, err = frontend.New(cfg.Frontend, util.Logger, t.overrides)
+	// The results of the tenants are cached under their cache generation
+	// number, changed by their delete requests.
+	var genLoader queryrange.CacheGenNumberLoader
+	if t.deletesCache != nil {
+		genLoader = t.deletesCache
+	}
+	t.frontend, err = frontend.New(cfg.Frontend, util.Logger, t.overrides, genLoader)
 	if err != nil {
 		return
 	}
 
 	frontend.RegisterFrontendServer(t.server.GRPC, t.frontend)
+	t.server.HTTP.Path(cfg.HTTPPrefix + "/api/v1/user_limits").Handler(
+		t.httpAuthMiddleware.Wrap(
+			http.HandlerFunc(t.frontend.UserLimitsHandler),
+		),
+	)
 	t.server.HTTP.PathPrefix(cfg.HTTPPrefix).Handler(
 		t.httpAuthMiddleware.Wrap(
 			t.frontend.Handler(),
@@ -280,6 +788,18 @@ func (t *Cortex) stopQueryFrontend() (err error) {
 	return
 }
 
+func (t *Cortex) initQueryScheduler(cfg *Config) (err error) {
+	t.scheduler = frontend.NewScheduler(cfg.QueryScheduler, t.overrides)
+	frontend.RegisterFrontendServer(t.server.GRPC, t.scheduler)
+	frontend.RegisterSchedulerServer(t.server.GRPC, t.scheduler)
+	return
+}
+
+func (t *Cortex) stopQueryScheduler() (err error) {
+	t.scheduler.Close()
+	return
+}
+
 func (t *Cortex) initTableManager(cfg *Config) error {
 	err := cfg.Schema.Load()
 	if err != nil {
@@ -310,10 +830,28 @@ func (t *Cortex) initTableManager(cfg *Config) error {
 	bucketClient, err := storage.NewBucketClient(cfg.Storage)
 	util.CheckFatal("initializing bucket client", err)
 
-	t.tableManager, err = chunk.NewTableManager(cfg.TableManager, cfg.Schema, cfg.Ingester.MaxChunkAge, tableClient, bucketClient)
+	var indexClient chunk.IndexClient
+	if cfg.TableManager.IndexGCEnabled {
+		indexClient, err = storage.NewIndexClient(lastConfig.IndexType, cfg.Storage, cfg.Schema)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.tableManager, err = chunk.NewTableManager(cfg.TableManager, cfg.Schema, cfg.Ingester.MaxChunkAge, tableClient, bucketClient, indexClient, t.overrides)
 	if err != nil {
 		return err
 	}
+	// The tables of the new periods are created by the table client of the
+	// last period, so they can't have another store.
+	t.registerSchemaReloadable(cfg, storage.SchemaReloadFunc(func(schemaCfg chunk.SchemaConfig) error {
+		for _, period := range schemaCfg.Configs[len(cfg.Schema.Configs):] {
+			if period.IndexType != lastConfig.IndexType {
+				return fmt.Errorf("the new period from %s has the store %s, not the %s of the table manager: restart it instead", period.From.Time.Time().Format("2006-01-02"), period.IndexType, lastConfig.IndexType)
+			}
+		}
+		return t.tableManager.ReloadSchema(schemaCfg)
+	}))
 	t.tableManager.Start()
 	return nil
 }
@@ -327,7 +865,10 @@ func (t *Cortex) initRuler(cfg *Config) (err error) {
 	cfg.Querier.MaxConcurrent = cfg.Ruler.NumWorkers
 	cfg.Querier.Timeout = cfg.Ruler.GroupTimeout
 	cfg.Ruler.LifecyclerConfig.ListenPort = &cfg.Server.GRPCListenPort
-	queryable, engine := querier.New(cfg.Querier, t.distributor, t.store)
+	queryable, engine := querier.New(cfg.Querier, t.distributor, t.store, t.overrides)
+	if t.deleteStore != nil {
+		queryable = querier.NewDeleteFilteringQueryable(queryable, t.deletesCache)
+	}
 
 	rulesAPI, err := config_client.New(cfg.ConfigStore)
 	if err != nil {
@@ -351,6 +892,7 @@ func (t *Cortex) initRuler(cfg *Config) (err error) {
 	}
 
 	t.server.HTTP.Handle("/ruler_ring", t.ruler)
+	t.server.HTTP.Handle("/ruler/ring", t.ruler)
 	return
 }
 
@@ -408,10 +950,16 @@ var modules = map[moduleName]module{
 	},
 
 	Ring: {
-		deps: []moduleName{Server},
+		deps: []moduleName{Server, MemberlistKV, Overrides},
 		init: (*Cortex).initRing,
 	},
 
+	MemberlistKV: {
+		deps: []moduleName{Server},
+		init: (*Cortex).initMemberlistKV,
+		stop: (*Cortex).stopMemberlistKV,
+	},
+
 	Overrides: {
 		init: (*Cortex).initOverrides,
 		stop: (*Cortex).stopOverrides,
@@ -424,37 +972,53 @@ var modules = map[moduleName]module{
 	},
 
 	Store: {
-		deps: []moduleName{Overrides},
+		deps: []moduleName{Server, Overrides},
 		init: (*Cortex).initStore,
 		stop: (*Cortex).stopStore,
 	},
 
 	Ingester: {
-		deps: []moduleName{Overrides, Store, Server},
+		deps: []moduleName{Overrides, Store, Server, MemberlistKV},
 		init: (*Cortex).initIngester,
 		stop: (*Cortex).stopIngester,
 	},
 
+	DeleteRequestsStore: {
+		init: (*Cortex).initDeleteRequestsStore,
+	},
+
+	Purger: {
+		deps: []moduleName{Store, DeleteRequestsStore},
+		init: (*Cortex).initPurger,
+		stop: (*Cortex).stopPurger,
+	},
+
 	Querier: {
-		deps: []moduleName{Distributor, Store, Ring, Server},
+		deps: []moduleName{Distributor, Store, DeleteRequestsStore, Ring, Server},
 		init: (*Cortex).initQuerier,
 		stop: (*Cortex).stopQuerier,
 	},
 
 	QueryFrontend: {
-		deps: []moduleName{Server, Overrides},
+		deps: []moduleName{Server, Overrides, DeleteRequestsStore},
 		init: (*Cortex).initQueryFrontend,
 		stop: (*Cortex).stopQueryFrontend,
 	},
 
+	QueryScheduler: {
+		deps: []moduleName{Server, Overrides},
+		init: (*Cortex).initQueryScheduler,
+		stop: (*Cortex).stopQueryScheduler,
+	},
+
 	TableManager: {
-		deps: []moduleName{Server},
+		deps: []moduleName{Server, Overrides},
 		init: (*Cortex).initTableManager,
 		stop: (*Cortex).stopTableManager,
 	},
 
 	Ruler: {
-		deps: []moduleName{Distributor, Store},
+		deps: []moduleName{Distributor, Store, DeleteRequestsStore, MemberlistKV},
 		init: (*Cortex).initRuler,
 		stop: (*Cortex).stopRuler,
 	},
@@ -471,7 +1035,37 @@ var modules = map[moduleName]module{
 		stop: (*Cortex).stopAlertmanager,
 	},
 
+	ChunkMigrator: {
+		deps: []moduleName{Server, Overrides},
+		init: (*Cortex).initChunkMigrator,
+		stop: (*Cortex).stopChunkMigrator,
+	},
+
+	BlocksMigrator: {
+		deps: []moduleName{Server, Store},
+		init: (*Cortex).initBlocksMigrator,
+		stop: (*Cortex).stopBlocksMigrator,
+	},
+
+	ChunkReencryptor: {
+		deps: []moduleName{Server, Overrides},
+		init: (*Cortex).initChunkReencryptor,
+		stop: (*Cortex).stopChunkReencryptor,
+	},
+
+	Compactor: {
+		deps: []moduleName{Server, Overrides, MemberlistKV},
+		init: (*Cortex).initCompactor,
+		stop: (*Cortex).stopCompactor,
+	},
+
+	StoreGateway: {
+		deps: []moduleName{Server, Overrides, MemberlistKV},
+		init: (*Cortex).initStoreGateway,
+		stop: (*Cortex).stopStoreGateway,
+	},
+
 	All: {
-		deps: []moduleName{Querier, Ingester, Distributor, TableManager},
+		deps: []moduleName{Querier, Ingester, Distributor, TableManager, Purger},
 	},
 }
@@ -112,7 +112,7 @@ type Distributor struct {
 
 	// Per-user rate limiters.
 	ingestLimitersMtx sync.RWMutex
-	ingestLimiters    map[string]*rate.Limiter
+	ingestLimiters    map[string]*ingestLimiter
 	quit              chan struct{}
 }
 
@@ -179,7 +179,7 @@ func New(cfg Config, clientConfig ingester_client.Config, limits *validation.Ove
 		ingesterPool:   ingester_client.NewPool(cfg.PoolConfig, ring, cfg.ingesterClientFactory, util.Logger),
 		billingClient:  billingClient,
 		limits:         limits,
-		ingestLimiters: map[string]*rate.Limiter{},
+		ingestLimiters: map[string]*ingestLimiter{},
 		quit:           make(chan struct{}),
 		Replicas:       replicas,
 	}
@@ -200,7 +200,7 @@ func (d *Distributor) loop() {
 		select {
 		case <-ticker.C:
 			d.ingestLimitersMtx.Lock()
-			d.ingestLimiters = make(map[string]*rate.Limiter, len(d.ingestLimiters))
+			d.ingestLimiters = make(map[string]*ingestLimiter, len(d.ingestLimiters))
 			d.ingestLimitersMtx.Unlock()
 
 		case <-d.quit:
@@ -362,12 +362,21 @@ func (d *Distributor) Push(ctx context.Context, req *client.WriteRequest) (*clie
 		return &client.WriteResponse{}, lastPartialErr
 	}
 
+	now := time.Now()
 	limiter := d.getOrCreateIngestLimiter(userID)
-	if !limiter.AllowN(time.Now(), validatedSamples) {
+	if !limiter.limit.AllowN(now, validatedSamples) {
 		// Return a 4xx here to have the client discard the data and not retry. If a client
 		// is sending too much data consistently we will unlikely ever catch up otherwise.
 		validation.DiscardedSamples.WithLabelValues(validation.RateLimited, userID).Add(float64(validatedSamples))
-		return nil, httpgrpc.Errorf(http.StatusTooManyRequests, "ingestion rate limit (%v) exceeded while adding %d samples", limiter.Limit(), numSamples)
+		return nil, httpgrpc.Errorf(http.StatusTooManyRequests, "ingestion rate limit (%v) exceeded while adding %d samples", limiter.limit.Limit(), numSamples)
+	}
+
+	// Warnings are deduplicated, as replicas usually return the same ones.
+	var warningsMtx sync.Mutex
+	warnings := map[string]struct{}{}
+	if limiter.warn != nil && !limiter.warn.AllowN(now, validatedSamples) {
+		validation.LimitWarnings.WithLabelValues(validation.IngestionRateLimitWarning, userID).Inc()
+		warnings[fmt.Sprintf("ingestion rate limit (%v) almost reached", limiter.limit.Limit())] = struct{}{}
 	}
 
 	err = ring.DoBatch(ctx, d.ring, keys, func(ingester ring.IngesterDesc, indexes []int) error {
@@ -383,15 +392,37 @@ func (d *Distributor) Push(ctx context.Context, req *client.WriteRequest) (*clie
 		if sp := opentracing.SpanFromContext(ctx); sp != nil {
 			localCtx = opentracing.ContextWithSpan(localCtx, sp)
 		}
-		return d.sendSamples(localCtx, ingester, timeseries)
+		resp, err := d.sendSamples(localCtx, ingester, timeseries)
+		if err == nil && len(resp.GetWarnings()) > 0 {
+			warningsMtx.Lock()
+			for _, warning := range resp.GetWarnings() {
+				warnings[warning] = struct{}{}
+			}
+			warningsMtx.Unlock()
+		}
+		return err
 	}, func() { client.ReuseSlice(req.Timeseries) })
 	if err != nil {
 		return nil, err
 	}
-	return &client.WriteResponse{}, lastPartialErr
+
+	resp := &client.WriteResponse{}
+	warningsMtx.Lock()
+	for warning := range warnings {
+		resp.Warnings = append(resp.Warnings, warning)
+	}
+	warningsMtx.Unlock()
+	sort.Strings(resp.Warnings)
+	return resp, lastPartialErr
 }
 
-func (d *Distributor) getOrCreateIngestLimiter(userID string) *rate.Limiter {
+// ingestLimiter holds the ingestion rate limiters of a user.
+type ingestLimiter struct {
+	limit *rate.Limiter
+	warn  *rate.Limiter // Limits at the warning threshold; nil if disabled.
+}
+
+func (d *Distributor) getOrCreateIngestLimiter(userID string) *ingestLimiter {
 	d.ingestLimitersMtx.RLock()
 	limiter, ok := d.ingestLimiters[userID]
 	d.ingestLimitersMtx.RUnlock()
@@ -400,7 +431,12 @@ func (d *Distributor) getOrCreateIngestLimiter(userID string) *rate.Limiter {
 		return limiter
 	}
 
-	limiter = rate.NewLimiter(rate.Limit(d.limits.IngestionRate(userID)), d.limits.IngestionBurstSize(userID))
+	limiter = &ingestLimiter{
+		limit: rate.NewLimiter(rate.Limit(d.limits.IngestionRate(userID)), d.limits.IngestionBurstSize(userID)),
+	}
+	if threshold := d.limits.LimitsWarningThreshold(userID); threshold > 0 {
+		limiter.warn = rate.NewLimiter(rate.Limit(threshold*d.limits.IngestionRate(userID)), int(threshold*float64(d.limits.IngestionBurstSize(userID))))
+	}
 
 	d.ingestLimitersMtx.Lock()
 	d.ingestLimiters[userID] = limiter
@@ -409,32 +445,33 @@ func (d *Distributor) getOrCreateIngestLimiter(userID string) *rate.Limiter {
 	return limiter
 }
 
-func (d *Distributor) sendSamples(ctx context.Context, ingester ring.IngesterDesc, timeseries []client.PreallocTimeseries) error {
+func (d *Distributor) sendSamples(ctx context.Context, ingester ring.IngesterDesc, timeseries []client.PreallocTimeseries) (*client.WriteResponse, error) {
 	h, err := d.ingesterPool.GetClientFor(ingester.Addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	c := h.(ingester_client.IngesterClient)
 
 	req := client.WriteRequest{
 		Timeseries: timeseries,
 	}
-	_, err = c.Push(ctx, &req)
+	resp, err := c.Push(ctx, &req)
 
 	ingesterAppends.WithLabelValues(ingester.Addr).Inc()
 	if err != nil {
 		ingesterAppendFailures.WithLabelValues(ingester.Addr).Inc()
 	}
-	return err
+	return resp, err
 }
 
-// forAllIngesters runs f, in parallel, for all ingesters
+// forAllIngesters runs f, in parallel, for all ingesters; really all of them
+// for the queries of strong read consistency.
 func (d *Distributor) forAllIngesters(ctx context.Context, reallyAll bool, f func(client.IngesterClient) (interface{}, error)) ([]interface{}, error) {
 	replicationSet, err := d.ring.GetAll()
 	if err != nil {
 		return nil, err
 	}
-	if reallyAll {
+	if reallyAll || util.IsStrongReadConsistency(ctx) {
 		replicationSet.MaxErrors = 0
 	}
 
@@ -449,10 +486,13 @@ func (d *Distributor) forAllIngesters(ctx context.Context, reallyAll bool, f fun
 }
 
 // LabelValuesForLabelName returns all of the label values that are associated with a given label name.
-func (d *Distributor) LabelValuesForLabelName(ctx context.Context, labelName model.LabelName) ([]string, error) {
-	req := &client.LabelValuesRequest{
-		LabelName: string(labelName),
+// If matchers are given, only values of the series matching them within [from, to] are returned.
+func (d *Distributor) LabelValuesForLabelName(ctx context.Context, from, to model.Time, labelName model.LabelName, matchers ...*labels.Matcher) ([]string, error) {
+	req, err := ingester_client.ToLabelValuesRequest(labelName, from, to, matchers)
+	if err != nil {
+		return nil, err
 	}
+
 	resps, err := d.forAllIngesters(ctx, false, func(client client.IngesterClient) (interface{}, error) {
 		return client.LabelValues(ctx, req)
 	})
@@ -475,8 +515,13 @@ func (d *Distributor) LabelValuesForLabelName(ctx context.Context, labelName mod
 }
 
 // LabelNames returns all of the label names.
-func (d *Distributor) LabelNames(ctx context.Context) ([]string, error) {
-	req := &client.LabelNamesRequest{}
+// If matchers are given, only names of the series matching them within [from, to] are returned.
+func (d *Distributor) LabelNames(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) ([]string, error) {
+	req, err := ingester_client.ToLabelNamesRequest(from, to, matchers)
+	if err != nil {
+		return nil, err
+	}
+
 	resps, err := d.forAllIngesters(ctx, false, func(client client.IngesterClient) (interface{}, error) {
 		return client.LabelNames(ctx, req)
 	})
@@ -533,6 +578,48 @@ func (d *Distributor) MetricsForLabelMatchers(ctx context.Context, from, through
 	return result, nil
 }
 
+// LabelCardinality returns, for each label name, the number of series of each
+// of its values, among the series within [from, to] matching the matchers.
+// Only the given label names are counted; all of them if none is given.
+func (d *Distributor) LabelCardinality(ctx context.Context, from, to model.Time, labelNames []string, matchers ...*labels.Matcher) (map[string]map[string]uint64, error) {
+	req, err := ingester_client.ToLabelCardinalityRequest(from, to, labelNames, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	// Series are counted by each of their replicas, so all the ingesters are
+	// queried and the counts are divided by the replication factor.
+	resps, err := d.forAllIngesters(ctx, true, func(client client.IngesterClient) (interface{}, error) {
+		return client.LabelCardinality(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]map[string]uint64{}
+	for _, resp := range resps {
+		for _, label := range resp.(*client.LabelCardinalityResponse).Labels {
+			values, ok := counts[label.LabelName]
+			if !ok {
+				values = map[string]uint64{}
+				counts[label.LabelName] = values
+			}
+			for _, v := range label.Values {
+				values[v.LabelValue] += v.SeriesCount
+			}
+		}
+	}
+
+	// Round up, so that a series not yet on all its replicas is still counted.
+	rf := uint64(d.ring.ReplicationFactor())
+	for _, values := range counts {
+		for v, n := range values {
+			values[v] = (n + rf - 1) / rf
+		}
+	}
+	return counts, nil
+}
+
 // UserStats returns statistics about the current user.
 func (d *Distributor) UserStats(ctx context.Context) (*UserStats, error) {
 	req := &client.UserStatsRequest{}
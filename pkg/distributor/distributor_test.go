@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"sort"
 	"strconv"
 	"sync"
@@ -27,8 +28,10 @@ import (
 	"github.com/cortexproject/cortex/pkg/ring/kv"
 	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
 	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/user"
@@ -109,6 +112,29 @@ func TestDistributorPush(t *testing.T) {
 	}
 }
 
+func TestDistributorPushIngestionRateWarning(t *testing.T) {
+	for _, tc := range []struct {
+		samples  int
+		expected []string
+	}{
+		{samples: 5},
+		{samples: 15, expected: []string{"ingestion rate limit (20) almost reached"}},
+	} {
+		t.Run(fmt.Sprintf("samples=%d", tc.samples), func(t *testing.T) {
+			limits := &validation.Limits{}
+			flagext.DefaultValues(limits)
+			limits.LimitsWarningThreshold = 0.5
+
+			d := prepare(t, 3, 3, 0, true, limits)
+			defer d.Stop()
+
+			response, err := d.Push(ctx, makeWriteRequest(tc.samples))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, response.Warnings)
+		})
+	}
+}
+
 func TestDistributorPushHAInstances(t *testing.T) {
 	ctx = user.InjectOrgID(context.Background(), "user")
 
@@ -299,6 +325,57 @@ func TestDistributorPushQuery(t *testing.T) {
 	}
 }
 
+func TestDistributorQueryPartialResults(t *testing.T) {
+	nameMatcher := mustEqualMatcher(model.MetricNameLabel, "foo")
+
+	for _, tc := range []struct {
+		name           string
+		partialResults bool
+		strong         bool
+		happyIngesters int
+		expectedErr    bool
+		expectWarning  bool
+	}{
+		{name: "disabled", happyIngesters: 3, expectedErr: true},
+		{name: "minority failed", partialResults: true, happyIngesters: 3, expectWarning: true},
+		{name: "majority failed", partialResults: true, happyIngesters: 2, expectedErr: true},
+		{name: "within replication", partialResults: true, happyIngesters: 4},
+		// The queries of strong read consistency wait for all the ingesters.
+		{name: "strong read consistency", partialResults: true, strong: true, happyIngesters: 4, expectedErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limits := &validation.Limits{}
+			flagext.DefaultValues(limits)
+			limits.QueryPartialResults = tc.partialResults
+
+			d := prepare(t, 5, tc.happyIngesters, 0, true, limits)
+			defer d.Stop()
+
+			// Some series fail to be pushed to enough ingesters: ignore it.
+			_, _ = d.Push(ctx, makeWriteRequest(10))
+
+			ctx, warnings := util.WithWarnings(ctx)
+			if tc.strong {
+				ctx = util.WithReadConsistency(ctx, util.ReadConsistencyStrong)
+			}
+			_, err := d.Query(ctx, 0, 10, nameMatcher)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			w := warnings.Take()
+			if tc.expectWarning {
+				require.Len(t, w, 1)
+				assert.Contains(t, w[0], "2 of 5 ingesters failed (3, 4)")
+			} else {
+				assert.Empty(t, w)
+			}
+		})
+	}
+}
+
 func TestSlowQueries(t *testing.T) {
 	nameMatcher := mustEqualMatcher(model.MetricNameLabel, "foo")
 	nIngesters := 3
@@ -320,6 +397,219 @@ func TestSlowQueries(t *testing.T) {
 	}
 }
 
+func samplesFrom(ts ...int64) []model.SamplePair {
+	res := make([]model.SamplePair, 0, len(ts))
+	for _, t := range ts {
+		res = append(res, model.SamplePair{Timestamp: model.Time(t), Value: model.SampleValue(t)})
+	}
+	return res
+}
+
+func TestMergeReplicaSamples(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		replicas [][]model.SamplePair
+		expected []model.SamplePair
+	}{
+		{
+			name:     "consistent replicas",
+			replicas: [][]model.SamplePair{samplesFrom(1, 2, 3), samplesFrom(1, 2, 3)},
+			expected: samplesFrom(1, 2, 3),
+		},
+		{
+			name:     "lagging replica",
+			replicas: [][]model.SamplePair{samplesFrom(1, 2), samplesFrom(1, 2, 3, 4)},
+			expected: samplesFrom(1, 2, 3, 4),
+		},
+		{
+			// The samples of all the replicas are kept.
+			name:     "diverging replica",
+			replicas: [][]model.SamplePair{samplesFrom(1, 2, 3, 5), samplesFrom(1, 2, 4)},
+			expected: samplesFrom(1, 2, 3, 4, 5),
+		},
+		{
+			// The gap in the middle of the most recent replica is filled in.
+			name:     "gap in the most recent replica",
+			replicas: [][]model.SamplePair{samplesFrom(1, 2, 5, 6), samplesFrom(1, 2, 3, 4, 5)},
+			expected: samplesFrom(1, 2, 3, 4, 5, 6),
+		},
+		{
+			// The most recent replica wins the samples of the same timestamp.
+			name: "duplicate timestamps",
+			replicas: [][]model.SamplePair{
+				{{Timestamp: 1, Value: 10}, {Timestamp: 2, Value: 20}},
+				{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}, {Timestamp: 3, Value: 3}},
+			},
+			expected: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}, {Timestamp: 3, Value: 3}},
+		},
+		{
+			// The most recent replica restarted and lost the older samples.
+			name:     "restarted replica",
+			replicas: [][]model.SamplePair{samplesFrom(3, 4, 5), samplesFrom(1, 2, 3)},
+			expected: samplesFrom(1, 2, 3, 4, 5),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			streams := make([]*model.SampleStream, 0, len(tc.replicas))
+			for _, values := range tc.replicas {
+				streams = append(streams, &model.SampleStream{Values: values})
+			}
+			assert.Equal(t, tc.expected, mergeReplicaSamples(streams))
+		})
+	}
+}
+
+func TestMergeReplicaChunks(t *testing.T) {
+	// chunk returns a chunk of a sample every millisecond from through.
+	chunk := func(from, through int64) client.Chunk {
+		c := encoding.New()
+		for ts := from; ts <= through; ts++ {
+			cs, err := c.Add(model.SamplePair{Timestamp: model.Time(ts), Value: model.SampleValue(ts)})
+			require.NoError(t, err)
+			require.Len(t, cs, 1)
+			c = cs[0]
+		}
+		var buf bytes.Buffer
+		require.NoError(t, c.Marshal(&buf))
+		return client.Chunk{StartTimestampMs: from, EndTimestampMs: through, Encoding: int32(c.Encoding()), Data: buf.Bytes()}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		replicas [][]client.Chunk
+		expected [][2]int64
+		samples  int
+	}{
+		{
+			name:     "consistent replicas",
+			replicas: [][]client.Chunk{{chunk(0, 9), chunk(10, 19)}, {chunk(0, 9), chunk(10, 19)}},
+			expected: [][2]int64{{0, 9}, {10, 19}},
+			samples:  20,
+		},
+		{
+			name:     "lagging replica",
+			replicas: [][]client.Chunk{{chunk(0, 9), chunk(10, 14)}, {chunk(0, 9), chunk(10, 19)}},
+			expected: [][2]int64{{0, 9}, {10, 19}},
+			samples:  20,
+		},
+		{
+			// The chunks of the most recent replica cover the ones of the
+			// other, cut at other times.
+			name:     "overlapping replicas",
+			replicas: [][]client.Chunk{{chunk(0, 14), chunk(15, 29)}, {chunk(0, 9), chunk(10, 19), chunk(20, 24)}},
+			expected: [][2]int64{{0, 14}, {15, 29}},
+			samples:  30,
+		},
+		{
+			// Only the samples of the other replica between the chunks of the
+			// most recent replica are kept.
+			name:     "overlapping replicas with a gap",
+			replicas: [][]client.Chunk{{chunk(0, 9), chunk(15, 29)}, {chunk(5, 19)}},
+			expected: [][2]int64{{0, 9}, {10, 14}, {15, 29}},
+			samples:  30,
+		},
+		{
+			name:     "restarted replica",
+			replicas: [][]client.Chunk{{chunk(15, 29)}, {chunk(0, 9), chunk(10, 19)}},
+			expected: [][2]int64{{0, 9}, {10, 14}, {15, 29}},
+			samples:  30,
+		},
+		{
+			// The chunks in the gap of the most recent replica are kept.
+			name:     "gap in the most recent replica",
+			replicas: [][]client.Chunk{{chunk(0, 9), chunk(20, 29)}, {chunk(0, 9), chunk(10, 19)}},
+			expected: [][2]int64{{0, 9}, {10, 19}, {20, 29}},
+			samples:  30,
+		},
+		{
+			name:     "series missing from a replica",
+			replicas: [][]client.Chunk{nil, {chunk(0, 9)}},
+			expected: [][2]int64{{0, 9}},
+			samples:  10,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, err := mergeReplicaChunks(tc.replicas)
+			require.NoError(t, err)
+
+			ranges := make([][2]int64, 0, len(merged))
+			seen := map[model.Time]struct{}{}
+			for _, c := range merged {
+				ranges = append(ranges, [2]int64{c.StartTimestampMs, c.EndTimestampMs})
+				decoded, err := encoding.NewForEncoding(encoding.Encoding(byte(c.Encoding)))
+				require.NoError(t, err)
+				require.NoError(t, decoded.UnmarshalFromBuf(c.Data))
+				it := decoded.NewIterator(nil)
+				for it.Scan() {
+					ts := it.Value().Timestamp
+					require.NotContains(t, seen, ts, "duplicated sample")
+					require.True(t, int64(ts) >= c.StartTimestampMs && int64(ts) <= c.EndTimestampMs)
+					seen[ts] = struct{}{}
+				}
+				require.NoError(t, it.Err())
+			}
+			assert.Equal(t, tc.expected, ranges)
+			assert.Len(t, seen, tc.samples)
+		})
+	}
+}
+
+func TestDistributorLabelCardinalityHandlers(t *testing.T) {
+	d := prepare(t, 3, 3, 0, true, nil)
+	defer d.Stop()
+
+	_, err := d.Push(ctx, makeWriteRequest(10))
+	require.NoError(t, err)
+
+	// Push returns once a quorum of the ingesters got the series: wait for all of them.
+	test.Poll(t, time.Second, uint64(10), func() interface{} {
+		counts, err := d.LabelCardinality(ctx, 0, model.Now(), []string{model.MetricNameLabel})
+		require.NoError(t, err)
+		return counts[model.MetricNameLabel]["foo"]
+	})
+
+	for _, tc := range []struct {
+		url      string
+		handler  http.HandlerFunc
+		code     int
+		expected string
+	}{
+		{
+			url:      "/api/v1/cardinality/label_names?limit=2",
+			handler:  d.LabelNamesCardinalityHandler,
+			code:     http.StatusOK,
+			expected: `{"label_values_count_total":12,"label_names_count":3,"cardinality":[{"label_name":"sample","label_values_count":10,"series_count":10},{"label_name":"__name__","label_values_count":1,"series_count":10}]}`,
+		},
+		{
+			url:      `/api/v1/cardinality/label_values?label_names[]=__name__&label_names[]=sample&selector={sample="1"}`,
+			handler:  d.LabelValuesCardinalityHandler,
+			code:     http.StatusOK,
+			expected: `{"labels":[{"label_name":"__name__","label_values_count":1,"series_count":1,"cardinality":[{"label_value":"foo","series_count":1}]},{"label_name":"sample","label_values_count":1,"series_count":1,"cardinality":[{"label_value":"1","series_count":1}]}]}`,
+		},
+		{
+			url:     "/api/v1/cardinality/label_values",
+			handler: d.LabelValuesCardinalityHandler,
+			code:    http.StatusBadRequest,
+		},
+		{
+			url:     "/api/v1/cardinality/label_names?limit=0",
+			handler: d.LabelNamesCardinalityHandler,
+			code:    http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil).WithContext(ctx)
+			resp := httptest.NewRecorder()
+			tc.handler(resp, req)
+
+			assert.Equal(t, tc.code, resp.Code)
+			if tc.code == http.StatusOK {
+				assert.JSONEq(t, tc.expected, resp.Body.String())
+			}
+		})
+	}
+}
+
 func prepare(t *testing.T, numIngesters, happyIngesters int, queryDelay time.Duration, shardByAllLabels bool, limits *validation.Limits) *Distributor {
 	ingesters := []mockIngester{}
 	for i := 0; i < happyIngesters; i++ {
@@ -590,6 +880,10 @@ func (i *mockIngester) QueryStream(ctx context.Context, req *client.QueryRequest
 		chunk := client.Chunk{
 			Encoding: int32(c.Encoding()),
 		}
+		if len(ts.Samples) > 0 {
+			chunk.StartTimestampMs = ts.Samples[0].TimestampMs
+			chunk.EndTimestampMs = ts.Samples[len(ts.Samples)-1].TimestampMs
+		}
 		if err := c.Marshal(&buf); err != nil {
 			panic(err)
 		}
@@ -628,6 +922,48 @@ func (s *stream) Recv() (*client.QueryStreamResponse, error) {
 	return result, nil
 }
 
+func (i *mockIngester) LabelCardinality(ctx context.Context, req *client.LabelCardinalityRequest, opts ...grpc.CallOption) (*client.LabelCardinalityResponse, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if !i.happy {
+		return nil, errFail
+	}
+
+	_, _, labelNames, matchers, err := client.FromLabelCardinalityRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]map[string]uint64{}
+	for _, name := range labelNames {
+		counts[name] = map[string]uint64{}
+	}
+	for _, ts := range i.timeseries {
+		if !match(ts.Labels, matchers) {
+			continue
+		}
+		for _, l := range ts.Labels {
+			if _, ok := counts[l.Name]; !ok && len(labelNames) == 0 {
+				counts[l.Name] = map[string]uint64{}
+			}
+			if values, ok := counts[l.Name]; ok {
+				values[l.Value]++
+			}
+		}
+	}
+
+	response := client.LabelCardinalityResponse{}
+	for name, values := range counts {
+		label := &client.LabelValueSeriesCounts{LabelName: name}
+		for value, n := range values {
+			label.Values = append(label.Values, client.LabelValueSeriesCount{LabelValue: value, SeriesCount: n})
+		}
+		response.Labels = append(response.Labels, label)
+	}
+	return &response, nil
+}
+
 func (i *mockIngester) AllUserStats(ctx context.Context, in *client.UserStatsRequest, opts ...grpc.CallOption) (*client.UsersStatsResponse, error) {
 	return &i.stats, nil
 }
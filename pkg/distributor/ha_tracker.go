@@ -115,7 +115,7 @@ func (cfg *HATrackerConfig) RegisterFlags(f *flag.FlagSet) {
 // NewClusterTracker returns a new HA cluster tracker using either Consul
 // or in-memory KV store.
 func newClusterTracker(cfg HATrackerConfig) (*haTracker, error) {
-	codec := codec.Proto{Factory: ProtoReplicaDescFactory}
+	codec := codec.NewProtoCodec("replicaDesc", ProtoReplicaDescFactory)
 
 	if cfg.FailoverTimeout <= cfg.UpdateTimeout {
 		return nil, fmt.Errorf("HA Tracker failover timeout must be greater than update timeout, %d is <= %d", cfg.FailoverTimeout, cfg.UpdateTimeout)
@@ -3,15 +3,25 @@ package distributor
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 
 	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
 
 	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/weaveworks/common/httpgrpc"
 )
 
+const (
+	defaultCardinalityLimit = 20
+	maxCardinalityLimit     = 500
+)
+
 // PushHandler is a http.Handler which accepts WriteRequests.
 func (d *Distributor) PushHandler(w http.ResponseWriter, r *http.Request) {
 	compressionType := util.CompressionTypeFor(r.Header.Get("X-Prometheus-Remote-Write-Version"))
@@ -35,7 +45,8 @@ func (d *Distributor) PushHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if _, err := d.Push(r.Context(), &req.WriteRequest); err != nil {
+	pushResp, err := d.Push(r.Context(), &req.WriteRequest)
+	if err != nil {
 		resp, ok := httpgrpc.HTTPResponseFromError(err)
 		if !ok {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -45,6 +56,13 @@ func (d *Distributor) PushHandler(w http.ResponseWriter, r *http.Request) {
 			level.Error(logger).Log("msg", "push error", "err", err)
 		}
 		http.Error(w, string(resp.Body), int(resp.Code))
+		return
+	}
+
+	// Give the client advance notice of the limits it is approaching, using
+	// the "miscellaneous persistent warning" code of RFC 7234.
+	for _, warning := range pushResp.Warnings {
+		w.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
 	}
 }
 
@@ -106,3 +124,185 @@ func (d *Distributor) ValidateExprHandler(w http.ResponseWriter, r *http.Request
 		},
 	})
 }
+
+// LabelNameCardinality is the number of values, and of series, of a label name.
+type LabelNameCardinality struct {
+	LabelName        string `json:"label_name"`
+	LabelValuesCount int    `json:"label_values_count"`
+	SeriesCount      uint64 `json:"series_count"`
+}
+
+// LabelNamesCardinalityResponse is the response of LabelNamesCardinalityHandler.
+type LabelNamesCardinalityResponse struct {
+	LabelValuesCountTotal int                    `json:"label_values_count_total"`
+	LabelNamesCount       int                    `json:"label_names_count"`
+	Cardinality           []LabelNameCardinality `json:"cardinality"`
+}
+
+// LabelValueCardinality is the number of series of a label value.
+type LabelValueCardinality struct {
+	LabelValue  string `json:"label_value"`
+	SeriesCount uint64 `json:"series_count"`
+}
+
+// LabelValuesCardinality is the number of series of the values of a label name.
+type LabelValuesCardinality struct {
+	LabelName        string                  `json:"label_name"`
+	LabelValuesCount int                     `json:"label_values_count"`
+	SeriesCount      uint64                  `json:"series_count"`
+	Cardinality      []LabelValueCardinality `json:"cardinality"`
+}
+
+// LabelValuesCardinalityResponse is the response of LabelValuesCardinalityHandler.
+type LabelValuesCardinalityResponse struct {
+	Labels []LabelValuesCardinality `json:"labels"`
+}
+
+// LabelNamesCardinalityHandler reports the label names of the user with the
+// most values, among the series within the start and end of the request and
+// matching its selector.
+func (d *Distributor) LabelNamesCardinalityHandler(w http.ResponseWriter, r *http.Request) {
+	from, through, matchers, limit, err := parseCardinalityRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	counts, err := d.LabelCardinality(r.Context(), from, through, nil, matchers...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := LabelNamesCardinalityResponse{
+		LabelNamesCount: len(counts),
+		Cardinality:     make([]LabelNameCardinality, 0, len(counts)),
+	}
+	for name, values := range counts {
+		c := LabelNameCardinality{LabelName: name, LabelValuesCount: len(values)}
+		for _, n := range values {
+			c.SeriesCount += n
+		}
+		resp.LabelValuesCountTotal += len(values)
+		resp.Cardinality = append(resp.Cardinality, c)
+	}
+	sort.Slice(resp.Cardinality, func(i, j int) bool {
+		a, b := resp.Cardinality[i], resp.Cardinality[j]
+		if a.LabelValuesCount != b.LabelValuesCount {
+			return a.LabelValuesCount > b.LabelValuesCount
+		}
+		return a.LabelName < b.LabelName
+	})
+	if len(resp.Cardinality) > limit {
+		resp.Cardinality = resp.Cardinality[:limit]
+	}
+
+	util.WriteJSONResponse(w, resp)
+}
+
+// LabelValuesCardinalityHandler reports, for each of the label_names[] of the
+// request, its values with the most series, among the series within the start
+// and end of the request and matching its selector. The values of the metric
+// name label give the metrics with the most series.
+func (d *Distributor) LabelValuesCardinalityHandler(w http.ResponseWriter, r *http.Request) {
+	from, through, matchers, limit, err := parseCardinalityRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	labelNames := r.Form["label_names[]"]
+	if len(labelNames) == 0 {
+		http.Error(w, "no label_names[] parameter provided", http.StatusBadRequest)
+		return
+	}
+	for _, name := range labelNames {
+		if !model.LabelName(name).IsValid() {
+			http.Error(w, fmt.Sprintf("invalid label name %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	counts, err := d.LabelCardinality(r.Context(), from, through, labelNames, matchers...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := LabelValuesCardinalityResponse{
+		Labels: make([]LabelValuesCardinality, 0, len(labelNames)),
+	}
+	sort.Strings(labelNames)
+	for i, name := range labelNames {
+		if i > 0 && name == labelNames[i-1] {
+			continue
+		}
+
+		values := counts[name]
+		c := LabelValuesCardinality{
+			LabelName:        name,
+			LabelValuesCount: len(values),
+			Cardinality:      make([]LabelValueCardinality, 0, len(values)),
+		}
+		for value, n := range values {
+			c.SeriesCount += n
+			c.Cardinality = append(c.Cardinality, LabelValueCardinality{LabelValue: value, SeriesCount: n})
+		}
+		sort.Slice(c.Cardinality, func(i, j int) bool {
+			a, b := c.Cardinality[i], c.Cardinality[j]
+			if a.SeriesCount != b.SeriesCount {
+				return a.SeriesCount > b.SeriesCount
+			}
+			return a.LabelValue < b.LabelValue
+		})
+		if len(c.Cardinality) > limit {
+			c.Cardinality = c.Cardinality[:limit]
+		}
+		resp.Labels = append(resp.Labels, c)
+	}
+
+	util.WriteJSONResponse(w, resp)
+}
+
+// parseCardinalityRequest returns the time range, selector and limit of a
+// cardinality request. The range defaults to all the series in memory.
+func parseCardinalityRequest(r *http.Request) (model.Time, model.Time, []*labels.Matcher, int, error) {
+	if err := r.ParseForm(); err != nil {
+		return 0, 0, nil, 0, err
+	}
+
+	from, through := model.Time(0), model.Now()
+	if s := r.FormValue("start"); s != "" {
+		t, err := queryrange.ParseTime(s)
+		if err != nil {
+			return 0, 0, nil, 0, err
+		}
+		from = model.Time(t)
+	}
+	if s := r.FormValue("end"); s != "" {
+		t, err := queryrange.ParseTime(s)
+		if err != nil {
+			return 0, 0, nil, 0, err
+		}
+		through = model.Time(t)
+	}
+	if through.Before(from) {
+		return 0, 0, nil, 0, fmt.Errorf("end timestamp must not be before start time")
+	}
+
+	var matchers []*labels.Matcher
+	if s := r.FormValue("selector"); s != "" {
+		var err error
+		if matchers, err = promql.ParseMetricSelector(s); err != nil {
+			return 0, 0, nil, 0, err
+		}
+	}
+
+	limit := defaultCardinalityLimit
+	if s := r.FormValue("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxCardinalityLimit {
+			return 0, 0, nil, 0, fmt.Errorf("invalid limit %q: must be between 1 and %d", s, maxCardinalityLimit)
+		}
+	}
+	return from, through, matchers, limit, nil
+}
@@ -1,13 +1,21 @@
 package distributor
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"math"
+	"sort"
+	"strings"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
 
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
 	ingester_client "github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/ring"
@@ -17,6 +25,19 @@ import (
 	"github.com/weaveworks/common/user"
 )
 
+var (
+	replicaMismatches = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "distributor_query_replica_mismatches_total",
+		Help:      "The total number of queried series for which the replicas returned different latest samples.",
+	})
+	partialQueries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "distributor_partial_queries_total",
+		Help:      "The total number of queries which returned partial results, as too many ingesters failed.",
+	})
+)
+
 // Query multiple ingesters and returns a Matrix of samples.
 func (d *Distributor) Query(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) (model.Matrix, error) {
 	var matrix model.Matrix
@@ -75,11 +96,75 @@ func (d *Distributor) queryPrep(ctx context.Context, from, to model.Time, matche
 	return replicationSet, req, err
 }
 
+// doQuery runs f on the ingesters of the replication set. If partial results
+// are allowed for the user, the query only fails if a majority of the
+// ingesters fail instead of more than MaxErrors of them, and warns about the
+// ingesters which failed. The queries of strong read consistency wait for
+// all the ingesters, and fail if any of them fails.
+func (d *Distributor) doQuery(ctx context.Context, replicationSet ring.ReplicationSet, f func(*ring.IngesterDesc) (interface{}, error)) ([]interface{}, error) {
+	if util.IsStrongReadConsistency(ctx) {
+		replicationSet.MaxErrors = 0
+		return replicationSet.Do(ctx, d.cfg.ExtraQueryDelay, f)
+	}
+
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil || !d.limits.QueryPartialResults(userID) {
+		return replicationSet.Do(ctx, d.cfg.ExtraQueryDelay, f)
+	}
+
+	// Unlike Do, wait for all the ingesters: once some have failed, the
+	// results of all the others are needed to return as many series as
+	// possible.
+	type result struct {
+		addr  string
+		value interface{}
+		err   error
+	}
+	resultsChan := make(chan result, len(replicationSet.Ingesters))
+	for i := range replicationSet.Ingesters {
+		go func(ing *ring.IngesterDesc) {
+			value, err := f(ing)
+			resultsChan <- result{addr: ing.Addr, value: value, err: err}
+		}(&replicationSet.Ingesters[i])
+	}
+
+	var (
+		results []interface{}
+		failed  []string
+		lastErr error
+	)
+	for range replicationSet.Ingesters {
+		select {
+		case r := <-resultsChan:
+			if r.err != nil {
+				failed = append(failed, r.addr)
+				lastErr = r.err
+			} else {
+				results = append(results, r.value)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if len(failed) <= replicationSet.MaxErrors {
+		return results, nil
+	}
+	if 2*len(failed) >= len(replicationSet.Ingesters) {
+		return nil, lastErr
+	}
+	sort.Strings(failed)
+	partialQueries.Inc()
+	util.WarningsFromContext(ctx).Add(fmt.Sprintf("partial results: some series may be missing, %d of %d ingesters failed (%s): %v",
+		len(failed), len(replicationSet.Ingesters), strings.Join(failed, ", "), lastErr))
+	return results, nil
+}
+
 // queryIngesters queries the ingesters via the older, sample-based API.
 func (d *Distributor) queryIngesters(ctx context.Context, replicationSet ring.ReplicationSet, req *client.QueryRequest) (model.Matrix, error) {
 	// Fetch samples from multiple ingesters in parallel, using the replicationSet
 	// to deal with consistency.
-	results, err := replicationSet.Do(ctx, d.cfg.ExtraQueryDelay, func(ing *ring.IngesterDesc) (interface{}, error) {
+	results, err := d.doQuery(ctx, replicationSet, func(ing *ring.IngesterDesc) (interface{}, error) {
 		client, err := d.ingesterPool.GetClientFor(ing.Addr)
 		if err != nil {
 			return nil, err
@@ -98,33 +183,215 @@ func (d *Distributor) queryIngesters(ctx context.Context, replicationSet ring.Re
 		return nil, err
 	}
 
-	// Merge the results into a single matrix.
-	fpToSampleStream := map[model.Fingerprint]*model.SampleStream{}
+	// Merge the results into a single matrix, each result holding at most
+	// one stream per series.
+	fpToSampleStreams := map[model.Fingerprint][]*model.SampleStream{}
 	for _, result := range results {
 		for _, ss := range result.(model.Matrix) {
 			fp := ss.Metric.Fingerprint()
-			mss, ok := fpToSampleStream[fp]
-			if !ok {
-				mss = &model.SampleStream{
-					Metric: ss.Metric,
+			fpToSampleStreams[fp] = append(fpToSampleStreams[fp], ss)
+		}
+	}
+	result := make(model.Matrix, 0, len(fpToSampleStreams))
+	for _, streams := range fpToSampleStreams {
+		result = append(result, &model.SampleStream{
+			Metric: streams[0].Metric,
+			Values: mergeReplicaSamples(streams),
+		})
+	}
+
+	return result, nil
+}
+
+// mergeReplicaSamples merges the samples of a series returned by different
+// replicas: the result is the union of their samples, ordered by timestamp, so
+// that the gaps of a replica, e.g. while it was restarting, are filled in by the
+// others. The samples of the replica with the most recent sample are preferred
+// for the timestamps returned by several replicas.
+func mergeReplicaSamples(streams []*model.SampleStream) []model.SamplePair {
+	primary := -1
+	for i, ss := range streams {
+		if len(ss.Values) > 0 && (primary < 0 || ss.Values[len(ss.Values)-1].Timestamp > streams[primary].Values[len(streams[primary].Values)-1].Timestamp) {
+			primary = i
+		}
+	}
+	if primary < 0 {
+		return nil
+	}
+
+	last := streams[primary].Values[len(streams[primary].Values)-1].Timestamp
+	result := streams[primary].Values
+	mismatch := false
+	for i, ss := range streams {
+		if len(ss.Values) == 0 || ss.Values[len(ss.Values)-1].Timestamp != last {
+			mismatch = true
+		}
+		if i != primary {
+			result = util.MergeSampleSets(result, ss.Values)
+		}
+	}
+	if mismatch {
+		replicaMismatches.Inc()
+	}
+	return result
+}
+
+// mergeReplicaChunks is like mergeReplicaSamples for the chunks of a series
+// returned by different replicas: the chunks of the replica with the most
+// recent data are kept, along with the samples of the other replicas outside of
+// their time ranges, so that no sample is returned twice. The chunks of the
+// other replicas are kept as they are when they don't overlap the chunks kept,
+// dropped when their time range is covered by them, and otherwise encoded
+// again with only their samples outside of them.
+func mergeReplicaChunks(replicas [][]client.Chunk) ([]client.Chunk, error) {
+	primary, last := -1, int64(0)
+	for i, chunks := range replicas {
+		for _, c := range chunks {
+			if primary < 0 || c.EndTimestampMs > last {
+				primary, last = i, c.EndTimestampMs
+			}
+		}
+	}
+	if primary < 0 {
+		return nil, nil
+	}
+
+	result := append([]client.Chunk(nil), replicas[primary]...)
+	mismatch := false
+	for i, chunks := range replicas {
+		end := int64(math.MinInt64)
+		for _, c := range chunks {
+			if c.EndTimestampMs > end {
+				end = c.EndTimestampMs
+			}
+		}
+		// Replicas which don't hold the series at all are not queried for
+		// it only, e.g. when querying all the ingesters.
+		if len(chunks) > 0 && end != last {
+			mismatch = true
+		}
+		if i == primary {
+			continue
+		}
+
+		kept := len(result)
+		for _, c := range chunks {
+			switch overlapping := overlappingChunks(result[:kept], c); {
+			case len(overlapping) == 0:
+				result = append(result, c)
+			case covered(overlapping, c.StartTimestampMs, c.EndTimestampMs):
+			default:
+				missing, err := missingChunks(result[:kept], c)
+				if err != nil {
+					return nil, err
 				}
-				fpToSampleStream[fp] = mss
+				result = append(result, missing...)
 			}
-			mss.Values = util.MergeSampleSets(mss.Values, ss.Values)
 		}
 	}
-	result := model.Matrix{}
-	for _, ss := range fpToSampleStream {
-		result = append(result, ss)
+	if mismatch {
+		replicaMismatches.Inc()
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].StartTimestampMs < result[j].StartTimestampMs })
+	return result, nil
+}
+
+// overlappingChunks returns the chunks whose time range overlaps the one of c.
+func overlappingChunks(chunks []client.Chunk, c client.Chunk) []client.Chunk {
+	var overlapping []client.Chunk
+	for _, o := range chunks {
+		if o.StartTimestampMs <= c.EndTimestampMs && c.StartTimestampMs <= o.EndTimestampMs {
+			overlapping = append(overlapping, o)
+		}
+	}
+	return overlapping
+}
+
+// covered returns whether the time range from through, in milliseconds, is
+// covered by the time ranges of the chunks.
+func covered(chunks []client.Chunk, from, through int64) bool {
+	chunks = append([]client.Chunk(nil), chunks...)
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].StartTimestampMs < chunks[j].StartTimestampMs })
+	for _, c := range chunks {
+		if c.StartTimestampMs > from {
+			return false
+		}
+		if c.EndTimestampMs >= from {
+			from = c.EndTimestampMs + 1
+		}
+		if from > through {
+			return true
+		}
+	}
+	return false
+}
+
+// missingChunks returns the samples of c outside of the time ranges of the
+// chunks, encoded into new chunks.
+func missingChunks(chunks []client.Chunk, c client.Chunk) ([]client.Chunk, error) {
+	decoded, err := encoding.NewForEncoding(encoding.Encoding(byte(c.Encoding)))
+	if err != nil {
+		return nil, err
+	}
+	if err := decoded.UnmarshalFromBuf(c.Data); err != nil {
+		return nil, err
 	}
 
+	var (
+		result  []client.Chunk
+		current encoding.Chunk
+		first   model.Time
+		prev    model.Time
+	)
+	flush := func(c encoding.Chunk, through model.Time) error {
+		var buf bytes.Buffer
+		if err := c.Marshal(&buf); err != nil {
+			return err
+		}
+		result = append(result, client.Chunk{
+			StartTimestampMs: int64(first),
+			EndTimestampMs:   int64(through),
+			Encoding:         int32(c.Encoding()),
+			Data:             buf.Bytes(),
+		})
+		return nil
+	}
+	it := decoded.NewIterator(nil)
+	for it.Scan() {
+		s := it.Value()
+		if covered(chunks, int64(s.Timestamp), int64(s.Timestamp)) {
+			continue
+		}
+		if current == nil {
+			current, first = encoding.New(), s.Timestamp
+		}
+		overflow, err := current.Add(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(overflow) > 1 {
+			if err := flush(overflow[0], prev); err != nil {
+				return nil, err
+			}
+			first = s.Timestamp
+		}
+		current, prev = overflow[len(overflow)-1], s.Timestamp
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		if err := flush(current, prev); err != nil {
+			return nil, err
+		}
+	}
 	return result, nil
 }
 
 // queryIngesterStream queries the ingesters using the new streaming API.
 func (d *Distributor) queryIngesterStream(ctx context.Context, replicationSet ring.ReplicationSet, req *client.QueryRequest) ([]client.TimeSeriesChunk, error) {
 	// Fetch samples from multiple ingesters
-	results, err := replicationSet.Do(ctx, d.cfg.ExtraQueryDelay, func(ing *ring.IngesterDesc) (interface{}, error) {
+	results, err := d.doQuery(ctx, replicationSet, func(ing *ring.IngesterDesc) (interface{}, error) {
 		client, err := d.ingesterPool.GetClientFor(ing.Addr)
 		if err != nil {
 			return nil, err
@@ -154,21 +421,38 @@ func (d *Distributor) queryIngesterStream(ctx context.Context, replicationSet ri
 		return nil, err
 	}
 
-	hashToSeries := map[model.Fingerprint]ingester_client.TimeSeriesChunk{}
-	for _, result := range results {
+	type replicatedSeries struct {
+		labels   []client.LabelAdapter
+		replicas [][]client.Chunk // chunks of the series, per replica
+	}
+	hashToSeries := map[model.Fingerprint]*replicatedSeries{}
+	for replica, result := range results {
 		for _, response := range result.([]*ingester_client.QueryStreamResponse) {
 			for _, series := range response.Timeseries {
 				hash := client.FastFingerprint(series.Labels)
-				existing := hashToSeries[hash]
-				existing.Labels = series.Labels
-				existing.Chunks = append(existing.Chunks, series.Chunks...)
-				hashToSeries[hash] = existing
+				existing, ok := hashToSeries[hash]
+				if !ok {
+					existing = &replicatedSeries{
+						labels:   series.Labels,
+						replicas: make([][]client.Chunk, len(results)),
+					}
+					hashToSeries[hash] = existing
+				}
+				// A series can be split across several batches of a replica.
+				existing.replicas[replica] = append(existing.replicas[replica], series.Chunks...)
 			}
 		}
 	}
 	result := make([]client.TimeSeriesChunk, 0, len(hashToSeries))
 	for _, series := range hashToSeries {
-		result = append(result, series)
+		chunks, err := mergeReplicaChunks(series.replicas)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, client.TimeSeriesChunk{
+			Labels: series.labels,
+			Chunks: chunks,
+		})
 	}
 
 	return result, nil
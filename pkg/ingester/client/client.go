@@ -76,3 +76,8 @@ type Config struct {
 func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	cfg.GRPCClientConfig.RegisterFlags("ingester.client", f)
 }
+
+// Validate the ingester client config.
+func (cfg *Config) Validate() error {
+	return cfg.GRPCClientConfig.Validate()
+}
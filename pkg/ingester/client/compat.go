@@ -38,7 +38,7 @@ func ToWriteRequest(lbls []labels.Labels, samples []Sample, source WriteRequest_
 
 // ToQueryRequest builds a QueryRequest proto.
 func ToQueryRequest(from, to model.Time, matchers []*labels.Matcher) (*QueryRequest, error) {
-	ms, err := toLabelMatchers(matchers)
+	ms, err := ToLabelMatchers(matchers)
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +52,7 @@ func ToQueryRequest(from, to model.Time, matchers []*labels.Matcher) (*QueryRequ
 
 // FromQueryRequest unpacks a QueryRequest proto.
 func FromQueryRequest(req *QueryRequest) (model.Time, model.Time, []*labels.Matcher, error) {
-	matchers, err := fromLabelMatchers(req.Matchers)
+	matchers, err := FromLabelMatchers(req.Matchers)
 	if err != nil {
 		return 0, 0, nil, err
 	}
@@ -101,7 +101,7 @@ func FromQueryResponse(resp *QueryResponse) model.Matrix {
 
 // ToMetricsForLabelMatchersRequest builds a MetricsForLabelMatchersRequest proto
 func ToMetricsForLabelMatchersRequest(from, to model.Time, matchers []*labels.Matcher) (*MetricsForLabelMatchersRequest, error) {
-	ms, err := toLabelMatchers(matchers)
+	ms, err := ToLabelMatchers(matchers)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +117,7 @@ func ToMetricsForLabelMatchersRequest(from, to model.Time, matchers []*labels.Ma
 func FromMetricsForLabelMatchersRequest(req *MetricsForLabelMatchersRequest) (model.Time, model.Time, [][]*labels.Matcher, error) {
 	matchersSet := make([][]*labels.Matcher, 0, len(req.MatchersSet))
 	for _, matchers := range req.MatchersSet {
-		matchers, err := fromLabelMatchers(matchers.Matchers)
+		matchers, err := FromLabelMatchers(matchers.Matchers)
 		if err != nil {
 			return 0, 0, nil, err
 		}
@@ -128,6 +128,80 @@ func FromMetricsForLabelMatchersRequest(req *MetricsForLabelMatchersRequest) (mo
 	return from, to, matchersSet, nil
 }
 
+// ToLabelValuesRequest builds a LabelValuesRequest proto
+func ToLabelValuesRequest(labelName model.LabelName, from, to model.Time, matchers []*labels.Matcher) (*LabelValuesRequest, error) {
+	ms, err := ToLabelMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LabelValuesRequest{
+		LabelName:        string(labelName),
+		StartTimestampMs: int64(from),
+		EndTimestampMs:   int64(to),
+		Matchers:         ms,
+	}, nil
+}
+
+// FromLabelValuesRequest unpacks a LabelValuesRequest proto
+func FromLabelValuesRequest(req *LabelValuesRequest) (string, model.Time, model.Time, []*labels.Matcher, error) {
+	matchers, err := FromLabelMatchers(req.Matchers)
+	if err != nil {
+		return "", 0, 0, nil, err
+	}
+
+	return req.LabelName, model.Time(req.StartTimestampMs), model.Time(req.EndTimestampMs), matchers, nil
+}
+
+// ToLabelNamesRequest builds a LabelNamesRequest proto
+func ToLabelNamesRequest(from, to model.Time, matchers []*labels.Matcher) (*LabelNamesRequest, error) {
+	ms, err := ToLabelMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LabelNamesRequest{
+		StartTimestampMs: int64(from),
+		EndTimestampMs:   int64(to),
+		Matchers:         ms,
+	}, nil
+}
+
+// FromLabelNamesRequest unpacks a LabelNamesRequest proto
+func FromLabelNamesRequest(req *LabelNamesRequest) (model.Time, model.Time, []*labels.Matcher, error) {
+	matchers, err := FromLabelMatchers(req.Matchers)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return model.Time(req.StartTimestampMs), model.Time(req.EndTimestampMs), matchers, nil
+}
+
+// ToLabelCardinalityRequest builds a LabelCardinalityRequest proto
+func ToLabelCardinalityRequest(from, to model.Time, labelNames []string, matchers []*labels.Matcher) (*LabelCardinalityRequest, error) {
+	ms, err := ToLabelMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LabelCardinalityRequest{
+		StartTimestampMs: int64(from),
+		EndTimestampMs:   int64(to),
+		Matchers:         ms,
+		LabelNames:       labelNames,
+	}, nil
+}
+
+// FromLabelCardinalityRequest unpacks a LabelCardinalityRequest proto
+func FromLabelCardinalityRequest(req *LabelCardinalityRequest) (model.Time, model.Time, []string, []*labels.Matcher, error) {
+	matchers, err := FromLabelMatchers(req.Matchers)
+	if err != nil {
+		return 0, 0, nil, nil, err
+	}
+
+	return model.Time(req.StartTimestampMs), model.Time(req.EndTimestampMs), req.LabelNames, matchers, nil
+}
+
 // FromMetricsForLabelMatchersResponse unpacks a MetricsForLabelMatchersResponse proto
 func FromMetricsForLabelMatchersResponse(resp *MetricsForLabelMatchersResponse) []model.Metric {
 	metrics := []model.Metric{}
@@ -137,7 +211,8 @@ func FromMetricsForLabelMatchersResponse(resp *MetricsForLabelMatchersResponse)
 	return metrics
 }
 
-func toLabelMatchers(matchers []*labels.Matcher) ([]*LabelMatcher, error) {
+// ToLabelMatchers converts matchers to their proto representation.
+func ToLabelMatchers(matchers []*labels.Matcher) ([]*LabelMatcher, error) {
 	result := make([]*LabelMatcher, 0, len(matchers))
 	for _, matcher := range matchers {
 		var mType MatchType
@@ -162,7 +237,8 @@ func toLabelMatchers(matchers []*labels.Matcher) ([]*LabelMatcher, error) {
 	return result, nil
 }
 
-func fromLabelMatchers(matchers []*LabelMatcher) ([]*labels.Matcher, error) {
+// FromLabelMatchers converts matchers from their proto representation.
+func FromLabelMatchers(matchers []*LabelMatcher) ([]*labels.Matcher, error) {
 	result := make([]*labels.Matcher, 0, len(matchers))
 	for _, matcher := range matchers {
 		var mtype labels.MatchType
@@ -3,6 +3,7 @@ package ingester
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -15,6 +16,7 @@ import (
 	"github.com/prometheus/prometheus/pkg/labels"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/util"
 )
 
@@ -22,6 +24,10 @@ const (
 	// Backoff for retrying 'immediate' flushes. Only counts for queue
 	// position, not wallclock time.
 	flushBackoff = 1 * time.Second
+
+	// Maximum number of slots the flush period is split in when the flushes
+	// of the tenants are staggered.
+	maxTenantFlushSlots = 60
 )
 
 var (
@@ -67,6 +73,10 @@ var (
 		Name: "cortex_ingester_dropped_chunks_total",
 		Help: "Total number of chunks dropped from flushing because they have too few samples.",
 	})
+	idleUsersRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cortex_ingester_idle_users_removed_total",
+		Help: "Total number of tenants removed from memory after being idle for longer than -ingester.max-tenant-idle.",
+	})
 )
 
 // Flush triggers a flush of all the chunks and closes the flush queues.
@@ -83,12 +93,68 @@ func (i *Ingester) Flush() {
 }
 
 // FlushHandler triggers a flush of all in memory chunks.  Mainly used for
-// local testing.
+// local testing. When one or more tenant parameters are given, only the
+// chunks of those tenants are flushed.
 func (i *Ingester) FlushHandler(w http.ResponseWriter, r *http.Request) {
-	i.sweepUsers(true)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenants := r.Form["tenant"]
+	if len(tenants) == 0 {
+		i.sweepUsers(true)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if i.chunkStore != nil {
+		now := time.Now()
+		for _, userID := range tenants {
+			if state, ok := i.userStates.get(userID); ok {
+				i.sweepUser(userID, state, true, now)
+			}
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// FlushPriorityHandler reports (GET) the tenants whose flushes are
+// prioritised, or prioritises (POST) or deprioritises (DELETE) the flushes of
+// the tenants given as tenant parameters.
+func (i *Ingester) FlushPriorityHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		tenants := r.Form["tenant"]
+		if len(tenants) == 0 {
+			http.Error(w, "missing tenant parameter", http.StatusBadRequest)
+			return
+		}
+		prioritised := r.Method == http.MethodPost
+		for _, userID := range tenants {
+			for _, flushQueue := range i.flushQueues {
+				flushQueue.SetPrioritised(userID, prioritised)
+			}
+			level.Info(util.Logger).Log("msg", "updated tenant flush priority", "user", userID, "prioritised", prioritised)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tenants []string
+	if len(i.flushQueues) > 0 {
+		tenants = i.flushQueues[0].Prioritised()
+	}
+	fmt.Fprintf(w, "prioritised: %s\n", strings.Join(tenants, ","))
+}
+
 type flushOp struct {
 	from      model.Time
 	userID    string
@@ -104,19 +170,94 @@ func (o *flushOp) Priority() int64 {
 	return -int64(o.from)
 }
 
-// sweepUsers periodically schedules series for flushing and garbage collects users with no series
+// sweepUsers schedules the series of all the users for flushing at once.
 func (i *Ingester) sweepUsers(immediate bool) {
 	if i.chunkStore == nil {
 		return
 	}
 
+	now := time.Now()
 	for id, state := range i.userStates.cp() {
-		for pair := range state.fpToSeries.iter() {
-			state.fpLocker.Lock(pair.fp)
-			i.sweepSeries(id, pair.fp, pair.series, immediate)
-			i.removeFlushedChunks(state, pair.fp, pair.series)
-			state.fpLocker.Unlock(pair.fp)
+		i.sweepUser(id, state, immediate, now)
+	}
+}
+
+// sweepUsersInSlot periodically schedules for flushing the series of the
+// users whose flush slot is slot, out of slots.
+func (i *Ingester) sweepUsersInSlot(slot, slots int) {
+	if i.chunkStore == nil {
+		return
+	}
+
+	now := time.Now()
+	for id, state := range i.userStates.cp() {
+		if tenantFlushSlot(id, slots) == slot {
+			i.sweepUser(id, state, false, now)
+		}
+	}
+}
+
+// tenantFlushSlots returns the number of slots the flush period is split in:
+// one slot per second of the period, up to maxTenantFlushSlots, when the
+// flushes of the tenants are staggered, otherwise a single one.
+func (cfg *Config) tenantFlushSlots() int {
+	if !cfg.StaggerTenantFlushes {
+		return 1
+	}
+	slots := int(cfg.FlushCheckPeriod / time.Second)
+	if slots > maxTenantFlushSlots {
+		return maxTenantFlushSlots
+	} else if slots < 1 {
+		return 1
+	}
+	return slots
+}
+
+// tenantFlushSlot returns the slot of the flush period, out of slots, in
+// which the series of a tenant are swept.
+func tenantFlushSlot(userID string, slots int) int {
+	return int(client.HashAdd32(client.HashNew32(), userID) % uint32(slots))
+}
+
+// sweepUser schedules the series of a single user for flushing.
+func (i *Ingester) sweepUser(userID string, state *userState, immediate bool, now time.Time) {
+	// Tenants which stopped writing have all their series flushed
+	// immediately, and their state is dropped once nothing is left.
+	// It will be recreated on the next write.
+	idle := i.cfg.MaxTenantIdle > 0 && state.idleFor(now) > i.cfg.MaxTenantIdle
+
+	// The bounds of the in-memory samples are recomputed from the series
+	// left once the flushed chunks are dropped.
+	from, through := model.Latest, model.Earliest
+	state.timeRange.startSweep()
+	for pair := range state.fpToSeries.iter() {
+		state.fpLocker.Lock(pair.fp)
+		i.sweepSeries(state, pair.fp, pair.series, immediate || idle)
+		i.removeFlushedChunks(state, pair.fp, pair.series)
+		if len(pair.series.chunkDescs) > 0 {
+			from = minTime(from, pair.series.firstTime())
+			through = maxTime(through, pair.series.head().LastTime)
 		}
+		state.fpLocker.Unlock(pair.fp)
+	}
+	state.timeRange.endSweep(from, through)
+
+	if idle {
+		i.removeIdleUser(userID)
+	}
+}
+
+// removeIdleUser drops the in-memory state of a tenant once all of its series
+// have been flushed and removed.
+func (i *Ingester) removeIdleUser(userID string) {
+	// Series are only created with the read lock held, so taking the write
+	// lock guarantees no series is added while the state is being removed.
+	i.userStatesMtx.Lock()
+	defer i.userStatesMtx.Unlock()
+
+	if i.userStates.deleteIfEmpty(userID) {
+		idleUsersRemoved.Inc()
+		level.Info(util.Logger).Log("msg", "removed idle tenant from memory", "user", userID)
 	}
 }
 
@@ -128,6 +269,7 @@ const (
 	reasonMultipleChunksInSeries
 	reasonAged
 	reasonIdle
+	reasonSparse
 )
 
 func (f flushReason) String() string {
@@ -142,6 +284,8 @@ func (f flushReason) String() string {
 		return "Aged"
 	case reasonIdle:
 		return "Idle"
+	case reasonSparse:
+		return "Sparse"
 	default:
 		panic("unrecognised flushReason")
 	}
@@ -151,25 +295,29 @@ func (f flushReason) String() string {
 //
 // NB we don't close the head chunk here, as the series could wait in the queue
 // for some time, and we want to encourage chunks to be as full as possible.
-func (i *Ingester) sweepSeries(userID string, fp model.Fingerprint, series *memorySeries, immediate bool) {
-	if len(series.chunkDescs) <= 0 {
+func (i *Ingester) sweepSeries(state *userState, fp model.Fingerprint, series *memorySeries, immediate bool) {
+	// Ephemeral series are never flushed: their chunks are dropped from
+	// memory once past the retention, by removeFlushedChunks.
+	if len(series.chunkDescs) <= 0 || series.ephemeral {
 		return
 	}
 
+	userID := state.userID
 	firstTime := series.firstTime()
-	flush := i.shouldFlushSeries(series, fp, immediate)
+	flush := i.shouldFlushSeries(userID, series, fp, immediate)
 	if flush == noFlush {
 		return
 	}
 
 	flushQueueIndex := int(uint64(fp) % uint64(i.cfg.ConcurrentFlushes))
-	if i.flushQueues[flushQueueIndex].Enqueue(&flushOp{firstTime, userID, fp, immediate}) {
+	// Tenants get a share of the flushes proportional to their number of series.
+	if i.flushQueues[flushQueueIndex].Enqueue(&flushOp{firstTime, userID, fp, immediate}, state.fpToSeries.length()) {
 		flushReasons.WithLabelValues(flush.String()).Inc()
 		util.Event().Log("msg", "add to flush queue", "userID", userID, "reason", flush, "firstTime", firstTime, "fp", fp, "series", series.metric, "queue", flushQueueIndex)
 	}
 }
 
-func (i *Ingester) shouldFlushSeries(series *memorySeries, fp model.Fingerprint, immediate bool) flushReason {
+func (i *Ingester) shouldFlushSeries(userID string, series *memorySeries, fp model.Fingerprint, immediate bool) flushReason {
 	if immediate {
 		return reasonImmediate
 	}
@@ -179,27 +327,35 @@ func (i *Ingester) shouldFlushSeries(series *memorySeries, fp model.Fingerprint,
 		return reasonMultipleChunksInSeries
 	} else if len(series.chunkDescs) > 0 {
 		// Otherwise look in more detail at the first chunk
-		return i.shouldFlushChunk(series.chunkDescs[0], fp)
+		return i.shouldFlushChunk(userID, series.chunkDescs[0], fp)
 	}
 
 	return noFlush
 }
 
-func (i *Ingester) shouldFlushChunk(c *desc, fp model.Fingerprint) flushReason {
+func (i *Ingester) shouldFlushChunk(userID string, c *desc, fp model.Fingerprint) flushReason {
 	if c.flushed { // don't flush chunks we've already flushed
 		return noFlush
 	}
 
+	maxChunkAge := i.maxChunkAge(userID)
+
 	// Adjust max age slightly to spread flushes out over time
 	var jitter time.Duration
-	if i.cfg.ChunkAgeJitter != 0 {
+	if i.cfg.ChunkAgeJitter != 0 && i.cfg.ChunkAgeJitter < maxChunkAge {
 		jitter = time.Duration(fp) % i.cfg.ChunkAgeJitter
 	}
 	// Chunks should be flushed if they span longer than MaxChunkAge
-	if c.LastTime.Sub(c.FirstTime) > (i.cfg.MaxChunkAge - jitter) {
+	if c.LastTime.Sub(c.FirstTime) > (maxChunkAge - jitter) {
 		return reasonAged
 	}
 
+	// Chunks of sparse series are cut early, so that they don't span a long
+	// time range, which would have them fetched by many queries.
+	if i.cfg.SparseChunkMaxAge > 0 && c.LastTime.Sub(c.FirstTime) > i.cfg.SparseChunkMaxAge && c.C.Len() < i.cfg.SparseChunkMinSamples {
+		return reasonSparse
+	}
+
 	// Chunk should be flushed if their last update is older then MaxChunkIdle
 	if model.Now().Sub(c.LastUpdate) > i.cfg.MaxChunkIdle {
 		return reasonIdle
@@ -208,6 +364,15 @@ func (i *Ingester) shouldFlushChunk(c *desc, fp model.Fingerprint) flushReason {
 	return noFlush
 }
 
+// maxChunkAge returns the max age of the chunks of a user, its override if
+// any, otherwise -ingester.max-chunk-age.
+func (i *Ingester) maxChunkAge(userID string) time.Duration {
+	if userMaxChunkAge := i.limits.MaxChunkAge(userID); userMaxChunkAge > 0 {
+		return userMaxChunkAge
+	}
+	return i.cfg.MaxChunkAge
+}
+
 func (i *Ingester) flushLoop(j int) {
 	defer func() {
 		level.Debug(util.Logger).Log("msg", "Ingester.flushLoop() exited")
@@ -215,11 +380,10 @@ func (i *Ingester) flushLoop(j int) {
 	}()
 
 	for {
-		o := i.flushQueues[j].Dequeue()
-		if o == nil {
+		op := i.flushQueues[j].Dequeue()
+		if op == nil {
 			return
 		}
-		op := o.(*flushOp)
 
 		err := i.flushUserSeries(j, op.userID, op.fp, op.immediate)
 		if err != nil {
@@ -230,11 +394,19 @@ func (i *Ingester) flushLoop(j int) {
 		// back in the queue at a later point.
 		if op.immediate && err != nil {
 			op.from = op.from.Add(flushBackoff)
-			i.flushQueues[j].Enqueue(op)
+			i.flushQueues[j].Enqueue(op, i.flushWeight(op.userID))
 		}
 	}
 }
 
+// flushWeight returns the share of the flushes given to a tenant.
+func (i *Ingester) flushWeight(userID string) int {
+	if state, ok := i.userStates.get(userID); ok {
+		return state.fpToSeries.length()
+	}
+	return 1
+}
+
 func (i *Ingester) flushUserSeries(flushQueueIndex int, userID string, fp model.Fingerprint, immediate bool) error {
 	if i.preFlushUserSeries != nil {
 		i.preFlushUserSeries()
@@ -251,7 +423,7 @@ func (i *Ingester) flushUserSeries(flushQueueIndex int, userID string, fp model.
 	}
 
 	userState.fpLocker.Lock(fp)
-	reason := i.shouldFlushSeries(series, fp, immediate)
+	reason := i.shouldFlushSeries(userID, series, fp, immediate)
 	if reason == noFlush {
 		userState.fpLocker.Unlock(fp)
 		return nil
@@ -259,7 +431,7 @@ func (i *Ingester) flushUserSeries(flushQueueIndex int, userID string, fp model.
 
 	// Assume we're going to flush everything, and maybe don't flush the head chunk if it doesn't need it.
 	chunks := series.chunkDescs
-	if immediate || (len(chunks) > 0 && i.shouldFlushChunk(series.head(), fp) != noFlush) {
+	if immediate || (len(chunks) > 0 && i.shouldFlushChunk(userID, series.head(), fp) != noFlush) {
 		series.closeHead()
 	} else {
 		chunks = chunks[:len(chunks)-1]
@@ -295,8 +467,27 @@ func (i *Ingester) flushUserSeries(flushQueueIndex int, userID string, fp model.
 		return nil
 	}
 
+	wireChunks, err := encodeChunks(userID, fp, series.metric, chunks)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the upload to be allowed before starting the flush timeout, so
+	// throttled flushes don't time out. The flushes other than the immediate
+	// ones are cancelled on shutdown, their chunks being flushed by the final
+	// flush, which has to upload all of them.
+	flushCtx := i.flushCtx
+	if immediate {
+		flushCtx = context.Background()
+	}
+	release, err := i.uploadThrottle.acquire(flushCtx, uploadSize(wireChunks))
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// flush the chunks without locking the series, as we don't want to hold the series lock for the duration of the dynamo/s3 rpcs.
-	ctx, cancel := context.WithTimeout(context.Background(), i.cfg.FlushOpTimeout)
+	ctx, cancel := context.WithTimeout(flushCtx, i.cfg.FlushOpTimeout)
 	defer cancel() // releases resources if slowOperation completes before timeout elapses
 
 	sp, ctx := ot.StartSpanFromContext(ctx, "flushUserSeries")
@@ -304,7 +495,7 @@ func (i *Ingester) flushUserSeries(flushQueueIndex int, userID string, fp model.
 	sp.SetTag("organization", userID)
 
 	util.Event().Log("msg", "flush chunks", "userID", userID, "reason", reason, "numChunks", len(chunks), "firstTime", chunks[0].FirstTime, "fp", fp, "series", series.metric, "queue", flushQueueIndex)
-	err := i.flushChunks(ctx, userID, fp, series.metric, chunks)
+	err = i.flushChunks(ctx, userID, fp, series.metric, chunks, wireChunks)
 	if err != nil {
 		return err
 	}
@@ -327,8 +518,13 @@ func (i *Ingester) flushUserSeries(flushQueueIndex int, userID string, fp model.
 // must be called under fpLocker lock
 func (i *Ingester) removeFlushedChunks(userState *userState, fp model.Fingerprint, series *memorySeries) {
 	now := model.Now()
+	retainPeriod := i.cfg.RetainPeriod
+	if series.ephemeral {
+		// The chunks of ephemeral series are never flushed.
+		retainPeriod = i.limits.EphemeralSeriesRetention(userState.userID)
+	}
 	for len(series.chunkDescs) > 0 {
-		if series.chunkDescs[0].flushed && now.Sub(series.chunkDescs[0].LastUpdate) > i.cfg.RetainPeriod {
+		if (series.chunkDescs[0].flushed || series.ephemeral) && now.Sub(series.chunkDescs[0].LastUpdate) > retainPeriod {
 			series.chunkDescs[0] = nil // erase reference so the chunk can be garbage-collected
 			series.chunkDescs = series.chunkDescs[1:]
 			memoryChunks.Dec()
@@ -341,16 +537,22 @@ func (i *Ingester) removeFlushedChunks(userState *userState, fp model.Fingerprin
 	}
 }
 
-func (i *Ingester) flushChunks(ctx context.Context, userID string, fp model.Fingerprint, metric labels.Labels, chunkDescs []*desc) error {
+// encodeChunks encodes the chunks of a series to be written to the chunk store.
+func encodeChunks(userID string, fp model.Fingerprint, metric labels.Labels, chunkDescs []*desc) ([]chunk.Chunk, error) {
 	wireChunks := make([]chunk.Chunk, 0, len(chunkDescs))
 	for _, chunkDesc := range chunkDescs {
 		c := chunk.NewChunk(userID, fp, metric, chunkDesc.C, chunkDesc.FirstTime, chunkDesc.LastTime)
 		if err := c.Encode(); err != nil {
-			return err
+			return nil, err
 		}
 		wireChunks = append(wireChunks, c)
 	}
+	return wireChunks, nil
+}
 
+// flushChunks writes the chunks of a series, encoded by encodeChunks, to the
+// chunk store.
+func (i *Ingester) flushChunks(ctx context.Context, userID string, fp model.Fingerprint, metric labels.Labels, chunkDescs []*desc, wireChunks []chunk.Chunk) error {
 	if err := i.chunkStore.Put(ctx, wireChunks); err != nil {
 		return err
 	}
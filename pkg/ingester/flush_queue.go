@@ -0,0 +1,240 @@
+package ingester
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// flushQueue is a queue of flush operations which schedules tenants fairly:
+// each tenant has its own queue, ordered by priority like util.PriorityQueue,
+// and the next operation is taken from the tenant which received the least
+// flushes relative to its weight (stride scheduling). Tenants with more series
+// get a proportionally bigger share of the flushes, but a tenant with a huge
+// backlog no longer delays the flushes of all the other tenants.
+//
+// Tenants can be prioritised, in which case their operations are dequeued
+// before those of any non-prioritised tenant.
+type flushQueue struct {
+	lock        sync.Mutex
+	cond        *sync.Cond
+	closing     bool
+	closed      bool
+	length      int
+	lengthGauge prometheus.Gauge
+
+	tenants     map[string]*tenantFlushQueue
+	active      tenantHeap // tenants with pending operations
+	prioritised map[string]struct{}
+
+	// Pass of the last scheduled tenant. Tenants becoming active start from
+	// it, so they can't claim the flushes they missed while idle.
+	pass float64
+}
+
+type tenantFlushQueue struct {
+	userID      string
+	ops         flushOpHeap
+	hit         map[string]struct{}
+	weight      float64
+	pass        float64
+	prioritised bool
+	index       int // in flushQueue.active
+}
+
+func newFlushQueue(lengthGauge prometheus.Gauge) *flushQueue {
+	q := &flushQueue{
+		lengthGauge: lengthGauge,
+		tenants:     map[string]*tenantFlushQueue{},
+		prioritised: map[string]struct{}{},
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// Length returns the number of operations in the queue.
+func (q *flushQueue) Length() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.length
+}
+
+// Close signals that the queue should be closed when it is empty.
+// A closed queue will not accept new items.
+func (q *flushQueue) Close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closing = true
+	q.cond.Broadcast()
+}
+
+// DiscardAndClose closes the queue and removes all the items from it.
+func (q *flushQueue) DiscardAndClose() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	if q.lengthGauge != nil {
+		q.lengthGauge.Sub(float64(q.length))
+	}
+	q.length = 0
+	q.tenants = map[string]*tenantFlushQueue{}
+	q.active = nil
+	q.cond.Broadcast()
+}
+
+// Enqueue adds an operation to the queue of its tenant, whose share of the
+// flushes is proportional to weight (e.g. its number of series). Returns true
+// if added; false if the operation was already on the queue.
+func (q *flushQueue) Enqueue(op *flushOp, weight int) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		panic("enqueue on closed queue")
+	}
+
+	t, ok := q.tenants[op.userID]
+	if !ok {
+		_, prioritised := q.prioritised[op.userID]
+		t = &tenantFlushQueue{
+			userID:      op.userID,
+			hit:         map[string]struct{}{},
+			pass:        q.pass,
+			prioritised: prioritised,
+		}
+		q.tenants[op.userID] = t
+		heap.Push(&q.active, t)
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	t.weight = float64(weight)
+
+	if _, enqueued := t.hit[op.Key()]; enqueued {
+		return false
+	}
+	t.hit[op.Key()] = struct{}{}
+	heap.Push(&t.ops, op)
+
+	q.length++
+	if q.lengthGauge != nil {
+		q.lengthGauge.Inc()
+	}
+	q.cond.Broadcast()
+	return true
+}
+
+// Dequeue returns the highest priority operation of the next tenant to be
+// scheduled; blocks if the queue is empty; returns nil if queue is closed.
+func (q *flushQueue) Dequeue() *flushOp {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for q.length == 0 && !(q.closing || q.closed) {
+		q.cond.Wait()
+	}
+
+	if q.length == 0 && (q.closing || q.closed) {
+		q.closed = true
+		return nil
+	}
+
+	t := q.active[0]
+	op := heap.Pop(&t.ops).(*flushOp)
+	delete(t.hit, op.Key())
+
+	q.pass = t.pass
+	t.pass += 1 / t.weight
+	if len(t.ops) == 0 {
+		heap.Remove(&q.active, t.index)
+		delete(q.tenants, t.userID)
+	} else {
+		heap.Fix(&q.active, t.index)
+	}
+
+	q.length--
+	if q.lengthGauge != nil {
+		q.lengthGauge.Dec()
+	}
+	return op
+}
+
+// SetPrioritised sets whether the operations of a tenant are dequeued before
+// those of the non-prioritised tenants.
+func (q *flushQueue) SetPrioritised(userID string, prioritised bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if prioritised {
+		q.prioritised[userID] = struct{}{}
+	} else {
+		delete(q.prioritised, userID)
+	}
+
+	if t, ok := q.tenants[userID]; ok {
+		t.prioritised = prioritised
+		heap.Fix(&q.active, t.index)
+	}
+}
+
+// Prioritised returns the sorted list of prioritised tenants.
+func (q *flushQueue) Prioritised() []string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	userIDs := make([]string, 0, len(q.prioritised))
+	for userID := range q.prioritised {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	return userIDs
+}
+
+type tenantHeap []*tenantFlushQueue
+
+func (h tenantHeap) Len() int { return len(h) }
+func (h tenantHeap) Less(i, j int) bool {
+	if h[i].prioritised != h[j].prioritised {
+		return h[i].prioritised
+	}
+	return h[i].pass < h[j].pass
+}
+func (h tenantHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *tenantHeap) Push(x interface{}) {
+	t := x.(*tenantFlushQueue)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *tenantHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	*h = old[0 : n-1]
+	return t
+}
+
+type flushOpHeap []*flushOp
+
+func (h flushOpHeap) Len() int           { return len(h) }
+func (h flushOpHeap) Less(i, j int) bool { return h[i].Priority() > h[j].Priority() }
+func (h flushOpHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *flushOpHeap) Push(x interface{}) {
+	*h = append(*h, x.(*flushOp))
+}
+
+func (h *flushOpHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
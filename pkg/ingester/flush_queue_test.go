@@ -0,0 +1,74 @@
+package ingester
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dequeueTenants(q *flushQueue, n int) []string {
+	userIDs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		userIDs = append(userIDs, q.Dequeue().userID)
+	}
+	return userIDs
+}
+
+func TestFlushQueueFairness(t *testing.T) {
+	q := newFlushQueue(nil)
+
+	// A big tenant with a large, older backlog doesn't delay the flushes of a
+	// smaller tenant: they are shared in proportion to the weights.
+	for fp := 0; fp < 100; fp++ {
+		require.True(t, q.Enqueue(&flushOp{from: model.Time(fp), userID: "big", fp: model.Fingerprint(fp)}, 3))
+	}
+	for fp := 0; fp < 10; fp++ {
+		require.True(t, q.Enqueue(&flushOp{from: model.Time(1000 + fp), userID: "small", fp: model.Fingerprint(fp)}, 1))
+	}
+	require.Equal(t, 110, q.Length())
+
+	counts := map[string]int{}
+	for _, userID := range dequeueTenants(q, 40) {
+		counts[userID]++
+	}
+	assert.Equal(t, map[string]int{"big": 30, "small": 10}, counts)
+	assert.Equal(t, 70, q.Length())
+}
+
+func TestFlushQueueOrderAndDedupe(t *testing.T) {
+	q := newFlushQueue(nil)
+
+	require.True(t, q.Enqueue(&flushOp{from: 2, userID: "1", fp: 2}, 1))
+	require.True(t, q.Enqueue(&flushOp{from: 1, userID: "1", fp: 1}, 1))
+	require.False(t, q.Enqueue(&flushOp{from: 1, userID: "1", fp: 1}, 1))
+
+	// Within a tenant, the oldest series is flushed first.
+	assert.Equal(t, model.Fingerprint(1), q.Dequeue().fp)
+	assert.Equal(t, model.Fingerprint(2), q.Dequeue().fp)
+
+	q.Close()
+	assert.Nil(t, q.Dequeue())
+}
+
+func TestFlushQueuePrioritised(t *testing.T) {
+	q := newFlushQueue(nil)
+
+	for fp := 0; fp < 3; fp++ {
+		q.Enqueue(&flushOp{from: model.Time(fp), userID: "a", fp: model.Fingerprint(fp)}, 1)
+		q.Enqueue(&flushOp{from: model.Time(fp), userID: "b", fp: model.Fingerprint(fp)}, 1)
+	}
+
+	q.SetPrioritised("b", true)
+	assert.Equal(t, []string{"b"}, q.Prioritised())
+	assert.Equal(t, []string{"b", "b", "b", "a", "a", "a"}, dequeueTenants(q, 6))
+
+	// Priorities are retained for tenants with no pending flush.
+	q.Enqueue(&flushOp{userID: "a"}, 1)
+	q.Enqueue(&flushOp{userID: "b"}, 1)
+	assert.Equal(t, []string{"b", "a"}, dequeueTenants(q, 2))
+
+	q.SetPrioritised("b", false)
+	assert.Empty(t, q.Prioritised())
+}
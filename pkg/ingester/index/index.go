@@ -76,6 +76,28 @@ func (ii *InvertedIndex) LabelValues(name string) []string {
 	return mergeStringSlices(results)
 }
 
+// Stats describes the memory held by an InvertedIndex.
+type Stats struct {
+	// Number of label name and value strings interned by the index, and their
+	// total size in bytes. Shards intern their own copy of each string.
+	InternedStrings      int `json:"interned_strings"`
+	InternedStringsBytes int `json:"interned_strings_bytes"`
+	// Number of fingerprints referenced by the postings lists.
+	Postings int `json:"postings"`
+	// Approximate size, in bytes, of the index.
+	Bytes int `json:"bytes"`
+}
+
+// Stats returns statistics about the memory held by the index.
+func (ii *InvertedIndex) Stats() Stats {
+	var stats Stats
+	for i := range ii.shards {
+		ii.shards[i].stats(&stats)
+	}
+	stats.Bytes = stats.InternedStringsBytes + stats.Postings*int(unsafe.Sizeof(model.Fingerprint(0)))
+	return stats
+}
+
 // Delete a fingerprint with the given label pairs.
 func (ii *InvertedIndex) Delete(labels labels.Labels, fp model.Fingerprint) {
 	shard := &ii.shards[util.HashFP(fp)%indexShards]
@@ -213,6 +235,21 @@ func (shard *indexShard) labelValues(name string) []string {
 	return results
 }
 
+func (shard *indexShard) stats(stats *Stats) {
+	shard.mtx.RLock()
+	defer shard.mtx.RUnlock()
+
+	for name, values := range shard.idx {
+		stats.InternedStrings++
+		stats.InternedStringsBytes += len(name)
+		for value, fps := range values.fps {
+			stats.InternedStrings++
+			stats.InternedStringsBytes += len(value)
+			stats.Postings += len(fps.fps)
+		}
+	}
+}
+
 func (shard *indexShard) delete(labels labels.Labels, fp model.Fingerprint) {
 	shard.mtx.Lock()
 	defer shard.mtx.Unlock()
@@ -51,6 +51,19 @@ func TestIndex(t *testing.T) {
 	assert.Equal(t, []string{"flap", "flop"}, index.LabelValues("flip"))
 }
 
+func TestIndexStats(t *testing.T) {
+	index := New()
+	index.Add(client.FromMetricsToLabelAdapters(model.Metric{"foo": "bar", "flip": "flop"}), 0)
+	index.Add(client.FromMetricsToLabelAdapters(model.Metric{"foo": "bar", "flip": "flap"}), 0)
+
+	assert.Equal(t, Stats{
+		InternedStrings:      5,
+		InternedStringsBytes: 18,
+		Postings:             4,
+		Bytes:                18 + 4*8,
+	}, index.Stats())
+}
+
 func mustParseMatcher(s string) []*labels.Matcher {
 	ms, err := promql.ParseMetricSelector(s)
 	if err != nil {
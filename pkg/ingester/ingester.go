@@ -5,7 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	// Needed for gRPC compatibility.
@@ -15,6 +17,7 @@ import (
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/gogo/status"
+	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
@@ -36,8 +39,15 @@ const (
 
 	// Number of timeseries to return in each batch of a QueryStream.
 	queryStreamBatchSize = 128
+
+	// Max size, in bytes, of a batch of a QueryStream. Bounding the batches by
+	// size, rather than only by series, keeps the memory used by queriers
+	// bounded when series have lots of chunks.
+	queryStreamBatchMessageSize = 1 * 1024 * 1024
 )
 
+var errIngesterReadOnly = httpgrpc.Errorf(http.StatusServiceUnavailable, "cannot push: ingester is read-only")
+
 type ingesterMetrics struct {
 	flushQueueLength    prometheus.Gauge
 	ingestedSamples     prometheus.Counter
@@ -46,6 +56,8 @@ type ingesterMetrics struct {
 	queriedSamples      prometheus.Histogram
 	queriedSeries       prometheus.Histogram
 	queriedChunks       prometheus.Histogram
+	rejectedPushes      *prometheus.CounterVec
+	pushStageDuration   *prometheus.HistogramVec
 }
 
 func newIngesterMetrics(r prometheus.Registerer) *ingesterMetrics {
@@ -84,6 +96,16 @@ func newIngesterMetrics(r prometheus.Registerer) *ingesterMetrics {
 			// A small number of chunks per series - 10*(8^(7-1)) = 2.6m.
 			Buckets: prometheus.ExponentialBuckets(10, 8, 7),
 		}),
+		rejectedPushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingester_instance_rejected_requests_total",
+			Help: "Requests rejected for hitting per-instance limits.",
+		}, []string{"reason"}),
+		pushStageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "cortex_ingester_push_stage_duration_seconds",
+			Help: "Time spent in each stage of a push request.",
+			// From 10us, biggest bucket is 10us*4^(10-1) = 2.6s.
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"stage"}),
 	}
 
 	if r != nil {
@@ -95,6 +117,8 @@ func newIngesterMetrics(r prometheus.Registerer) *ingesterMetrics {
 			m.queriedSamples,
 			m.queriedSeries,
 			m.queriedChunks,
+			m.rejectedPushes,
+			m.pushStageDuration,
 		)
 	}
 
@@ -109,17 +133,36 @@ type Config struct {
 	MaxTransferRetries int `yaml:"max_transfer_retries,omitempty"`
 
 	// Config for chunk flushing.
-	FlushCheckPeriod  time.Duration
-	RetainPeriod      time.Duration
-	MaxChunkIdle      time.Duration
-	FlushOpTimeout    time.Duration
-	MaxChunkAge       time.Duration
-	ChunkAgeJitter    time.Duration
-	ConcurrentFlushes int
-	SpreadFlushes     bool
+	FlushCheckPeriod      time.Duration
+	RetainPeriod          time.Duration
+	MaxChunkIdle          time.Duration
+	FlushOpTimeout        time.Duration
+	MaxChunkAge           time.Duration
+	MaxTenantIdle         time.Duration
+	ChunkAgeJitter        time.Duration
+	SparseChunkMaxAge     time.Duration
+	SparseChunkMinSamples int
+	ConcurrentFlushes     int
+	SpreadFlushes         bool
+	StaggerTenantFlushes  bool
+
+	// Throttling of the chunk uploads done by flushes.
+	FlushUploadRateLimit      int `yaml:"flush_upload_rate_limit,omitempty"`
+	FlushMaxConcurrentUploads int `yaml:"flush_max_concurrent_uploads,omitempty"`
 
 	RateUpdatePeriod time.Duration
 
+	QueryParallelism          int
+	QueryParallelismMinSeries int
+
+	InstanceLimits InstanceLimits `yaml:"instance_limits,omitempty"`
+
+	ReadOnly bool `yaml:"read_only,omitempty"`
+
+	// Injected at runtime and read from the distributor config, required
+	// to accurately apply global limits.
+	ShardByAllLabels bool `yaml:"-"`
+
 	// For testing, you can override the address and ID of this ingester.
 	ingesterClientFactory func(addr string, cfg client.Config) (client.HealthAndIngesterClient, error)
 }
@@ -127,6 +170,7 @@ type Config struct {
 // RegisterFlags adds the flags required to config this to the given FlagSet
 func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	cfg.LifecyclerConfig.RegisterFlags(f)
+	cfg.InstanceLimits.RegisterFlags(f)
 
 	f.IntVar(&cfg.MaxTransferRetries, "ingester.max-transfer-retries", 10, "Number of times to try and transfer chunks before falling back to flushing.")
 	f.DurationVar(&cfg.FlushCheckPeriod, "ingester.flush-period", 1*time.Minute, "Period with which to attempt to flush chunks.")
@@ -134,10 +178,19 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&cfg.FlushOpTimeout, "ingester.flush-op-timeout", 1*time.Minute, "Timeout for individual flush operations.")
 	f.DurationVar(&cfg.MaxChunkIdle, "ingester.max-chunk-idle", 5*time.Minute, "Maximum chunk idle time before flushing.")
 	f.DurationVar(&cfg.MaxChunkAge, "ingester.max-chunk-age", 12*time.Hour, "Maximum chunk age before flushing.")
+	f.DurationVar(&cfg.MaxTenantIdle, "ingester.max-tenant-idle", 0, "Flush all series of a tenant and release its in-memory state once it has not received a sample for this long. 0 to disable.")
 	f.DurationVar(&cfg.ChunkAgeJitter, "ingester.chunk-age-jitter", 20*time.Minute, "Range of time to subtract from MaxChunkAge to spread out flushes")
+	f.DurationVar(&cfg.SparseChunkMaxAge, "ingester.sparse-chunk-max-age", 0, "Maximum age of a chunk holding fewer than -ingester.sparse-chunk-min-samples samples before flushing. Cuts chunks of sparse series early to improve query locality. 0 to disable.")
+	f.IntVar(&cfg.SparseChunkMinSamples, "ingester.sparse-chunk-min-samples", 120, "Chunks with fewer samples than this are considered sparse, see -ingester.sparse-chunk-max-age.")
 	f.BoolVar(&cfg.SpreadFlushes, "ingester.spread-flushes", false, "If true, spread series flushes across the whole period of MaxChunkAge")
+	f.BoolVar(&cfg.StaggerTenantFlushes, "ingester.stagger-tenant-flushes", false, "If true, spread the periodic flushes of the tenants across -ingester.flush-period, each tenant being checked at an offset derived from a hash of its ID, rather than all of them at once.")
 	f.IntVar(&cfg.ConcurrentFlushes, "ingester.concurrent-flushes", 50, "Number of concurrent goroutines flushing to dynamodb.")
+	f.IntVar(&cfg.FlushUploadRateLimit, "ingester.flush-upload-rate-limit", 0, "Maximum bandwidth, in bytes/sec, used to upload flushed chunks to the chunk store. 0 = unlimited.")
+	f.IntVar(&cfg.FlushMaxConcurrentUploads, "ingester.flush-max-concurrent-uploads", 0, "Maximum number of flushes uploading chunks to the chunk store at the same time. 0 = only limited by -ingester.concurrent-flushes.")
 	f.DurationVar(&cfg.RateUpdatePeriod, "ingester.rate-update-period", 15*time.Second, "Period with which to update the per-user ingestion rates.")
+	f.IntVar(&cfg.QueryParallelism, "ingester.query-parallelism", 1, "Maximum number of goroutines used to walk the series selected by a single query, when it selects more than -ingester.query-parallelism-min-series series.")
+	f.IntVar(&cfg.QueryParallelismMinSeries, "ingester.query-parallelism-min-series", 10000, "Minimum number of series a query must select to be walked by multiple goroutines.")
+	f.BoolVar(&cfg.ReadOnly, "ingester.read-only", false, "Start the ingester in read-only mode, rejecting pushes while still serving queries and flushing chunks. Can be toggled at runtime via the /ingester/read-only endpoint.")
 }
 
 // Ingester deals with "in flight" chunks.  Based on Prometheus 1.x
@@ -151,6 +204,7 @@ type Ingester struct {
 	chunkStore ChunkStore
 	lifecycler *ring.Lifecycler
 	limits     *validation.Overrides
+	limiter    *SeriesLimiter
 
 	quit chan struct{}
 	done sync.WaitGroup
@@ -159,11 +213,27 @@ type Ingester struct {
 	userStates    *userStates
 	stopped       bool // protected by userStatesMtx
 
+	// Instance-wide ingestion rate and number of inflight push requests,
+	// used to enforce the instance limits.
+	ingestionRate        *ewmaRate
+	inflightPushRequests int64 // atomic
+
+	// Non-zero when pushes are rejected, see SetReadOnly.
+	readOnly int32 // atomic
+
 	// One queue per flush thread.  Fingerprint is used to
 	// pick a queue.
-	flushQueues     []*util.PriorityQueue
+	flushQueues     []*flushQueue
 	flushQueuesDone sync.WaitGroup
 
+	// Throttles the chunk uploads of the flushes; nil if unlimited.
+	uploadThrottle *uploadThrottle
+
+	// The context of the flushes other than the immediate ones, cancelled
+	// on shutdown.
+	flushCtx      context.Context
+	cancelFlushes context.CancelFunc
+
 	// Hook for injecting behaviour from tests.
 	preFlushUserSeries func()
 }
@@ -187,21 +257,30 @@ func New(cfg Config, clientConfig client.Config, limits *validation.Overrides, c
 
 		limits:     limits,
 		chunkStore: chunkStore,
-		userStates: newUserStates(limits, cfg),
 
-		quit:        make(chan struct{}),
-		flushQueues: make([]*util.PriorityQueue, cfg.ConcurrentFlushes, cfg.ConcurrentFlushes),
+		ingestionRate: newEWMARate(0.2, cfg.RateUpdatePeriod),
+
+		quit:           make(chan struct{}),
+		flushQueues:    make([]*flushQueue, cfg.ConcurrentFlushes, cfg.ConcurrentFlushes),
+		uploadThrottle: newUploadThrottle(cfg.FlushUploadRateLimit, cfg.FlushMaxConcurrentUploads),
 	}
 
+	i.flushCtx, i.cancelFlushes = context.WithCancel(context.Background())
+	i.SetReadOnly(cfg.ReadOnly)
+
 	var err error
 	i.lifecycler, err = ring.NewLifecycler(cfg.LifecyclerConfig, i, "ingester")
 	if err != nil {
 		return nil, err
 	}
 
+	// Init the limiter and instantiate the user states which depend on it
+	i.limiter = NewSeriesLimiter(limits, i.lifecycler, cfg.LifecyclerConfig.RingConfig.ReplicationFactor, cfg.ShardByAllLabels)
+	i.userStates = newUserStates(i.limiter, limits, cfg)
+
 	i.flushQueuesDone.Add(cfg.ConcurrentFlushes)
 	for j := 0; j < cfg.ConcurrentFlushes; j++ {
-		i.flushQueues[j] = util.NewPriorityQueue(i.metrics.flushQueueLength)
+		i.flushQueues[j] = newFlushQueue(i.metrics.flushQueueLength)
 		go i.flushLoop(j)
 	}
 
@@ -214,7 +293,11 @@ func New(cfg Config, clientConfig client.Config, limits *validation.Overrides, c
 func (i *Ingester) loop() {
 	defer i.done.Done()
 
-	flushTicker := time.NewTicker(i.cfg.FlushCheckPeriod)
+	// The flush period is split in slots, each tenant being swept once per
+	// period, in its own slot.
+	slots := i.cfg.tenantFlushSlots()
+	slot := 0
+	flushTicker := time.NewTicker(i.cfg.FlushCheckPeriod / time.Duration(slots))
 	defer flushTicker.Stop()
 
 	rateUpdateTicker := time.NewTicker(i.cfg.RateUpdatePeriod)
@@ -223,10 +306,12 @@ func (i *Ingester) loop() {
 	for {
 		select {
 		case <-flushTicker.C:
-			i.sweepUsers(false)
+			i.sweepUsersInSlot(slot, slots)
+			slot = (slot + 1) % slots
 
 		case <-rateUpdateTicker.C:
 			i.userStates.updateRates()
+			i.ingestionRate.tick()
 
 		case <-i.quit:
 			return
@@ -236,9 +321,11 @@ func (i *Ingester) loop() {
 
 // Shutdown beings the process to stop this ingester.
 func (i *Ingester) Shutdown() {
-	// First wait for our flush loop to stop.
+	// First wait for our flush loop to stop, and cancel the flushes it
+	// scheduled: their chunks are flushed by the final flush.
 	close(i.quit)
 	i.done.Wait()
+	i.cancelFlushes()
 
 	// Next initiate our graceful exit from the ring.
 	i.lifecycler.Shutdown()
@@ -251,22 +338,76 @@ func (i *Ingester) StopIncomingRequests() {
 	i.stopped = true
 }
 
+// SetReadOnly puts the ingester in (or out of) read-only mode. While read-only,
+// pushes are rejected but queries are still served and chunks are still flushed,
+// which allows draining an ingester ahead of its decommission.
+func (i *Ingester) SetReadOnly(readOnly bool) {
+	var v int32
+	if readOnly {
+		v = 1
+	}
+	atomic.StoreInt32(&i.readOnly, v)
+}
+
+// IsReadOnly returns true if the ingester is rejecting pushes.
+func (i *Ingester) IsReadOnly() bool {
+	return atomic.LoadInt32(&i.readOnly) != 0
+}
+
 // Push implements client.IngesterServer
 func (i *Ingester) Push(ctx old_ctx.Context, req *client.WriteRequest) (*client.WriteResponse, error) {
+	var stages pushStages
+	defer stages.observe(ctx, i.metrics.pushStageDuration)
+
+	start := time.Now()
 	userID, err := user.ExtractOrgID(ctx)
+	stages.auth = time.Since(start)
 	if err != nil {
 		return nil, fmt.Errorf("no user id")
 	}
+
+	start = time.Now()
+	if i.IsReadOnly() {
+		return nil, errIngesterReadOnly
+	}
+
+	inflight := atomic.AddInt64(&i.inflightPushRequests, 1)
+	defer atomic.AddInt64(&i.inflightPushRequests, -1)
+	err = i.checkInstanceLimits(inflight)
+	stages.instanceLimits = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+
 	var lastPartialErr error
+	// The bounds of the appended samples extend those of the tenant's
+	// in-memory samples once they're all appended.
+	from, through := model.Latest, model.Earliest
+	defer func() {
+		if state, ok := i.userStates.get(userID); ok && from <= through {
+			state.timeRange.extend(from, through)
+		}
+	}()
 
+	// The samples are timed as a whole, rather than each of them, as timing
+	// them would be a measurable overhead.
+	start = time.Now()
 	for _, ts := range req.Timeseries {
 		for _, s := range ts.Samples {
-			err := i.append(ctx, userID, ts.Labels, model.Time(s.TimestampMs), model.SampleValue(s.Value), req.Source)
+			err := i.append(ctx, userID, ts.Labels, model.Time(s.TimestampMs), model.SampleValue(s.Value), req.Source, &stages)
 			if err == nil {
+				from = minTime(from, model.Time(s.TimestampMs))
+				through = maxTime(through, model.Time(s.TimestampMs))
 				continue
 			}
 
 			i.metrics.ingestedSamplesFail.Inc()
+			switch err {
+			case errMaxSeriesLimitReached:
+				i.metrics.rejectedPushes.WithLabelValues(instanceLimitMaxSeries).Inc()
+			case errMaxTenantsLimitReached:
+				i.metrics.rejectedPushes.WithLabelValues(instanceLimitMaxTenants).Inc()
+			}
 			if httpResp, ok := httpgrpc.HTTPResponseFromError(err); ok {
 				switch httpResp.Code {
 				case http.StatusBadRequest, http.StatusTooManyRequests:
@@ -275,15 +416,69 @@ func (i *Ingester) Push(ctx old_ctx.Context, req *client.WriteRequest) (*client.
 				}
 			}
 
+			stages.append = time.Since(start) - stages.series
 			return nil, err
 		}
 	}
+	stages.append = time.Since(start) - stages.series
 	client.ReuseSlice(req.Timeseries)
 
-	return &client.WriteResponse{}, lastPartialErr
+	resp := &client.WriteResponse{}
+	if state, ok := i.userStates.get(userID); ok {
+		if warning := i.limiter.SeriesPerUserWarning(userID, state.fpToSeries.length()); warning != "" {
+			validation.LimitWarnings.WithLabelValues(validation.SeriesLimitWarning, userID).Inc()
+			resp.Warnings = append(resp.Warnings, warning)
+		}
+	}
+
+	return resp, lastPartialErr
+}
+
+// pushStages accumulates the time spent in each stage of a push request, so
+// that latency regressions can be attributed to a stage.
+type pushStages struct {
+	auth           time.Duration // extracting the tenant
+	instanceLimits time.Duration // read-only mode and instance limits
+	series         time.Duration // creating series, including per-tenant series limits
+	append         time.Duration // looking up the series and appending samples to them
+}
+
+// observe records the stages in the histogram, and on the request span if any.
+func (s *pushStages) observe(ctx context.Context, hist *prometheus.HistogramVec) {
+	hist.WithLabelValues("auth").Observe(s.auth.Seconds())
+	hist.WithLabelValues("instance_limits").Observe(s.instanceLimits.Seconds())
+	hist.WithLabelValues("series").Observe(s.series.Seconds())
+	hist.WithLabelValues("append").Observe(s.append.Seconds())
+
+	if sp := opentracing.SpanFromContext(ctx); sp != nil {
+		sp.LogKV(
+			"auth", s.auth,
+			"instance_limits", s.instanceLimits,
+			"series", s.series,
+			"append", s.append,
+		)
+	}
+}
+
+// checkInstanceLimits returns an error if this push must be rejected because
+// of the instance limits, regardless of the tenant it belongs to.
+func (i *Ingester) checkInstanceLimits(inflight int64) error {
+	limits := i.cfg.InstanceLimits
+
+	if limits.MaxInflightPushRequests > 0 && inflight > limits.MaxInflightPushRequests {
+		i.metrics.rejectedPushes.WithLabelValues(instanceLimitMaxInflight).Inc()
+		return errMaxInflightLimitReached
+	}
+
+	if limits.MaxIngestionRate > 0 && i.ingestionRate.rate() > limits.MaxIngestionRate {
+		i.metrics.rejectedPushes.WithLabelValues(instanceLimitIngestionRate).Inc()
+		return errMaxIngestionRateReached
+	}
+
+	return nil
 }
 
-func (i *Ingester) append(ctx context.Context, userID string, labels labelPairs, timestamp model.Time, value model.SampleValue, source client.WriteRequest_SourceEnum) error {
+func (i *Ingester) append(ctx context.Context, userID string, labels labelPairs, timestamp model.Time, value model.SampleValue, source client.WriteRequest_SourceEnum, stages *pushStages) error {
 	labels.removeBlanks()
 
 	var (
@@ -300,27 +495,31 @@ func (i *Ingester) append(ctx context.Context, userID string, labels labelPairs,
 	if i.stopped {
 		return fmt.Errorf("ingester stopping")
 	}
-	state, fp, series, err := i.userStates.getOrCreateSeries(ctx, userID, labels)
+	state, fp, series, err := i.userStates.getOrCreateSeries(ctx, userID, labels, &stages.series)
 	if err != nil {
 		state = nil // don't want to unlock the fp if there is an error
 		return err
 	}
 
+	state.touch()
+
 	prevNumChunks := len(series.chunkDescs)
 	if i.cfg.SpreadFlushes && prevNumChunks > 0 {
 		// Map from the fingerprint hash to a point in the cycle of period MaxChunkAge
-		startOfCycle := timestamp.Add(-(timestamp.Sub(model.Time(0)) % i.cfg.MaxChunkAge))
-		slot := startOfCycle.Add(time.Duration(uint64(fp) % uint64(i.cfg.MaxChunkAge)))
+		maxChunkAge := i.maxChunkAge(userID)
+		startOfCycle := timestamp.Add(-(timestamp.Sub(model.Time(0)) % maxChunkAge))
+		slot := startOfCycle.Add(time.Duration(uint64(fp) % uint64(maxChunkAge)))
 		// If adding this sample means the head chunk will span that point in time, close so it will get flushed
 		if series.head().FirstTime < slot && timestamp >= slot {
 			series.closeHead()
 		}
 	}
 
-	if err := series.add(model.SamplePair{
+	err = series.add(model.SamplePair{
 		Value:     value,
 		Timestamp: timestamp,
-	}); err != nil {
+	})
+	if err != nil {
 		if mse, ok := err.(*memorySeriesError); ok {
 			state.discardedSamples.WithLabelValues(mse.errorType).Inc()
 			if mse.noReport {
@@ -334,6 +533,7 @@ func (i *Ingester) append(ctx context.Context, userID string, labels labelPairs,
 
 	memoryChunks.Add(float64(len(series.chunkDescs) - prevNumChunks))
 	i.metrics.ingestedSamples.Inc()
+	i.ingestionRate.inc()
 	switch source {
 	case client.RULE:
 		state.ingestedRuleSamples.inc()
@@ -372,7 +572,8 @@ func (i *Ingester) Query(ctx old_ctx.Context, req *client.QueryRequest) (*client
 	result := &client.QueryResponse{}
 	numSeries, numSamples := 0, 0
 	maxSamplesPerQuery := i.limits.MaxSamplesPerQuery(userID)
-	err = state.forSeriesMatching(ctx, matchers, func(ctx context.Context, _ model.Fingerprint, series *memorySeries) error {
+	var resultMtx sync.Mutex // the series may be added concurrently
+	err = state.forSeriesMatchingConcurrently(ctx, matchers, func(ctx context.Context, _ model.Fingerprint, series *memorySeries) error {
 		values, err := series.samplesForRange(from, through)
 		if err != nil {
 			return err
@@ -380,12 +581,6 @@ func (i *Ingester) Query(ctx old_ctx.Context, req *client.QueryRequest) (*client
 		if len(values) == 0 {
 			return nil
 		}
-		numSeries++
-
-		numSamples += len(values)
-		if numSamples > maxSamplesPerQuery {
-			return httpgrpc.Errorf(http.StatusRequestEntityTooLarge, "exceeded maximum number of samples in a query (%d)", maxSamplesPerQuery)
-		}
 
 		ts := client.TimeSeries{
 			Labels:  client.FromLabelsToLabelAdapters(series.metric),
@@ -397,9 +592,18 @@ func (i *Ingester) Query(ctx old_ctx.Context, req *client.QueryRequest) (*client
 				TimestampMs: int64(s.Timestamp),
 			})
 		}
+
+		resultMtx.Lock()
+		defer resultMtx.Unlock()
+
+		numSeries++
+		numSamples += len(values)
+		if numSamples > maxSamplesPerQuery {
+			return httpgrpc.Errorf(http.StatusUnprocessableEntity, "exceeded maximum number of samples in a query (%d)", maxSamplesPerQuery)
+		}
 		result.Timeseries = append(result.Timeseries, ts)
 		return nil
-	}, nil, 0)
+	}, nil, 0, i.cfg.QueryParallelism, i.cfg.QueryParallelismMinSeries)
 	i.metrics.queriedSeries.Observe(float64(numSeries))
 	i.metrics.queriedSamples.Observe(float64(numSamples))
 	return result, err
@@ -425,13 +629,25 @@ func (i *Ingester) QueryStream(req *client.QueryRequest, stream client.Ingester_
 		return nil
 	}
 
-	numSeries, numChunks := 0, 0
+	numSeries, numChunks, batchSize := 0, 0, 0
 	batch := make([]client.TimeSeriesChunk, 0, queryStreamBatchSize)
+	sendBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := stream.Send(&client.QueryStreamResponse{
+			Timeseries: batch,
+		})
+		batch = batch[:0]
+		batchSize = 0
+		return err
+	}
 	// We'd really like to have series in label order, not FP order, so we
 	// can iteratively merge them with entries coming from the chunk store.  But
 	// that would involve locking all the series & sorting, so until we have
 	// a better solution in the ingesters I'd rather take the hit in the queriers.
-	err = state.forSeriesMatching(stream.Context(), matchers, func(ctx context.Context, _ model.Fingerprint, series *memorySeries) error {
+	var batchMtx sync.Mutex // the series may be added concurrently
+	err = state.forSeriesMatchingConcurrently(stream.Context(), matchers, func(ctx context.Context, _ model.Fingerprint, series *memorySeries) error {
 		chunks := make([]*desc, 0, len(series.chunkDescs))
 		for _, chunk := range series.chunkDescs {
 			if !(chunk.FirstTime.After(through) || chunk.LastTime.Before(from)) {
@@ -443,32 +659,40 @@ func (i *Ingester) QueryStream(req *client.QueryRequest, stream client.Ingester_
 			return nil
 		}
 
-		numSeries++
 		wireChunks, err := toWireChunks(chunks)
 		if err != nil {
 			return err
 		}
 
-		numChunks += len(wireChunks)
-		batch = append(batch, client.TimeSeriesChunk{
+		ts := client.TimeSeriesChunk{
 			Labels: client.FromLabelsToLabelAdapters(series.metric),
 			Chunks: wireChunks,
-		})
+		}
+		size := ts.Size()
+
+		batchMtx.Lock()
+		defer batchMtx.Unlock()
+
+		numSeries++
+		numChunks += len(wireChunks)
+		batchSize += size
+		batch = append(batch, ts)
 
 		return nil
 	}, func(ctx context.Context) error {
-		if len(batch) == 0 {
+		// Called after every series with no lock held; only send once
+		// the batch is full, so gRPC flow control applies per batch.
+		if len(batch) < queryStreamBatchSize && batchSize < queryStreamBatchMessageSize {
 			return nil
 		}
-		err = stream.Send(&client.QueryStreamResponse{
-			Timeseries: batch,
-		})
-		batch = batch[:0]
-		return err
-	}, queryStreamBatchSize)
+		return sendBatch()
+	}, 1, i.cfg.QueryParallelism, i.cfg.QueryParallelismMinSeries)
 	if err != nil {
 		return err
 	}
+	if err := sendBatch(); err != nil {
+		return err
+	}
 
 	i.metrics.queriedSeries.Observe(float64(numSeries))
 	i.metrics.queriedChunks.Observe(float64(numChunks))
@@ -478,7 +702,14 @@ func (i *Ingester) QueryStream(req *client.QueryRequest, stream client.Ingester_
 }
 
 // LabelValues returns all label values that are associated with a given label name.
+// If a time range is given, only the values of the series having samples
+// within it, and matching the matchers if any, are returned.
 func (i *Ingester) LabelValues(ctx old_ctx.Context, req *client.LabelValuesRequest) (*client.LabelValuesResponse, error) {
+	labelName, from, through, matchers, err := client.FromLabelValuesRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
 	i.userStatesMtx.RLock()
 	defer i.userStatesMtx.RUnlock()
 	state, ok, err := i.userStates.getViaContext(ctx)
@@ -488,16 +719,49 @@ func (i *Ingester) LabelValues(ctx old_ctx.Context, req *client.LabelValuesReque
 		return &client.LabelValuesResponse{}, nil
 	}
 
+	// Without matchers, the values are read from the index, checking the
+	// series of each value until one has samples within the time range,
+	// unless the range covers all the in-memory samples.
 	resp := &client.LabelValuesResponse{}
-	for _, v := range state.index.LabelValues(req.LabelName) {
-		resp.LabelValues = append(resp.LabelValues, v)
+	if len(matchers) == 0 {
+		if through != 0 && !state.timeRange.overlaps(from, through) {
+			return resp, nil
+		}
+		covered := through == 0 || state.timeRange.within(from, through)
+		for _, v := range state.index.LabelValues(labelName) {
+			if covered || state.anyOverlapping(labelName, v, from, through) {
+				resp.LabelValues = append(resp.LabelValues, v)
+			}
+		}
+		return resp, nil
 	}
 
+	values := map[string]struct{}{}
+	err = state.forSeriesOverlapping(ctx, matchers, from, through, func(series *memorySeries) {
+		if v := series.metric.Get(labelName); v != "" {
+			values[v] = struct{}{}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for v := range values {
+		resp.LabelValues = append(resp.LabelValues, v)
+	}
+	sort.Strings(resp.LabelValues)
 	return resp, nil
 }
 
 // LabelNames return all the label names.
+// If a time range is given, only the names of the series having samples
+// within it, and matching the matchers if any, are returned.
 func (i *Ingester) LabelNames(ctx old_ctx.Context, req *client.LabelNamesRequest) (*client.LabelNamesResponse, error) {
+	from, through, matchers, err := client.FromLabelNamesRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
 	i.userStatesMtx.RLock()
 	defer i.userStatesMtx.RUnlock()
 	state, ok, err := i.userStates.getViaContext(ctx)
@@ -507,11 +771,35 @@ func (i *Ingester) LabelNames(ctx old_ctx.Context, req *client.LabelNamesRequest
 		return &client.LabelNamesResponse{}, nil
 	}
 
+	// Without matchers, the names are read from the index as for the values.
 	resp := &client.LabelNamesResponse{}
-	for _, v := range state.index.LabelNames() {
-		resp.LabelNames = append(resp.LabelNames, v)
+	if len(matchers) == 0 {
+		if through != 0 && !state.timeRange.overlaps(from, through) {
+			return resp, nil
+		}
+		covered := through == 0 || state.timeRange.within(from, through)
+		for _, n := range state.index.LabelNames() {
+			if covered || state.anyValueOverlapping(n, from, through) {
+				resp.LabelNames = append(resp.LabelNames, n)
+			}
+		}
+		return resp, nil
 	}
 
+	names := map[string]struct{}{}
+	err = state.forSeriesOverlapping(ctx, matchers, from, through, func(series *memorySeries) {
+		for _, l := range series.metric {
+			names[l.Name] = struct{}{}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for n := range names {
+		resp.LabelNames = append(resp.LabelNames, n)
+	}
+	sort.Strings(resp.LabelNames)
 	return resp, nil
 }
 
@@ -554,6 +842,77 @@ func (i *Ingester) MetricsForLabelMatchers(ctx old_ctx.Context, req *client.Metr
 	return result, nil
 }
 
+// LabelCardinality returns, for each label name, the number of series of each
+// of its values, among the series within the time range and matching the
+// matchers of the request.
+func (i *Ingester) LabelCardinality(ctx old_ctx.Context, req *client.LabelCardinalityRequest) (*client.LabelCardinalityResponse, error) {
+	from, through, labelNames, matchers, err := client.FromLabelCardinalityRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	i.userStatesMtx.RLock()
+	defer i.userStatesMtx.RUnlock()
+	state, ok, err := i.userStates.getViaContext(ctx)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return &client.LabelCardinalityResponse{}, nil
+	}
+
+	wanted := make(map[string]struct{}, len(labelNames))
+	for _, name := range labelNames {
+		wanted[name] = struct{}{}
+	}
+	counts := map[string]map[string]uint64{}
+	count := func(series *memorySeries) {
+		if !series.overlaps(from, through) {
+			return
+		}
+		for _, l := range series.metric {
+			if _, ok := wanted[l.Name]; !ok && len(wanted) > 0 {
+				continue
+			}
+			values, ok := counts[l.Name]
+			if !ok {
+				values = map[string]uint64{}
+				counts[l.Name] = values
+			}
+			values[l.Value]++
+		}
+	}
+
+	if len(matchers) == 0 {
+		// Without matchers, walk all the series rather than looking them up in
+		// the index, which would hit the max series per query limit.
+		for pair := range state.fpToSeries.iter() {
+			state.fpLocker.Lock(pair.fp)
+			count(pair.series)
+			state.fpLocker.Unlock(pair.fp)
+		}
+	} else if err := state.forSeriesMatching(ctx, matchers, func(_ context.Context, _ model.Fingerprint, series *memorySeries) error {
+		count(series)
+		return nil
+	}, nil, 0); err != nil {
+		return nil, err
+	}
+
+	resp := &client.LabelCardinalityResponse{
+		Labels: make([]*client.LabelValueSeriesCounts, 0, len(counts)),
+	}
+	for name, values := range counts {
+		label := &client.LabelValueSeriesCounts{
+			LabelName: name,
+			Values:    make([]client.LabelValueSeriesCount, 0, len(values)),
+		}
+		for value, n := range values {
+			label.Values = append(label.Values, client.LabelValueSeriesCount{LabelValue: value, SeriesCount: n})
+		}
+		resp.Labels = append(resp.Labels, label)
+	}
+	return resp, nil
+}
+
 // UserStats returns ingestion statistics for the current user.
 func (i *Ingester) UserStats(ctx old_ctx.Context, req *client.UserStatsRequest) (*client.UserStatsResponse, error) {
 	i.userStatesMtx.RLock()
@@ -610,6 +969,25 @@ func (i *Ingester) Watch(in *grpc_health_v1.HealthCheckRequest, stream grpc_heal
 	return status.Error(codes.Unimplemented, "Watching is not supported")
 }
 
+// ReadOnlyHandler reports (GET), enables (POST) or disables (DELETE) the
+// read-only mode of the ingester.
+func (i *Ingester) ReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		i.SetReadOnly(true)
+		level.Info(util.Logger).Log("msg", "ingester is now read-only")
+	case http.MethodDelete:
+		i.SetReadOnly(false)
+		level.Info(util.Logger).Log("msg", "ingester is no longer read-only")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fmt.Fprintf(w, "read-only: %v\n", i.IsReadOnly())
+}
+
 // ReadinessHandler is used to indicate to k8s when the ingesters are ready for
 // the addition removal of another ingester. Returns 204 when the ingester is
 // ready, 500 otherwise.
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"sort"
 	"strconv"
 	"sync"
@@ -16,12 +17,15 @@ import (
 	net_context "golang.org/x/net/context"
 	"google.golang.org/grpc"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
+	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/user"
@@ -225,6 +229,61 @@ func TestIngesterSendsOnlySeriesWithData(t *testing.T) {
 	ing.Shutdown()
 }
 
+func TestIngesterQueryStreamBatches(t *testing.T) {
+	_, ing := newDefaultTestStore(t)
+	defer ing.Shutdown()
+
+	userIDs, testData := pushTestSamples(t, ing, 300, 10, 0)
+
+	ctx := user.InjectOrgID(context.Background(), userIDs[0])
+	_, req, err := runTestQuery(ctx, t, ing, labels.MatchRegexp, model.JobLabel, ".+")
+	require.NoError(t, err)
+
+	s := stream{
+		ctx: ctx,
+	}
+	require.NoError(t, ing.QueryStream(req, &s))
+
+	// 300 series are sent in batches of at most queryStreamBatchSize.
+	require.Len(t, s.responses, 3)
+	for _, resp := range s.responses {
+		require.True(t, len(resp.Timeseries) <= queryStreamBatchSize)
+	}
+
+	res, err := chunkcompat.StreamsToMatrix(model.Earliest, model.Latest, s.responses)
+	require.NoError(t, err)
+	sort.Sort(res)
+	assert.Equal(t, testData[userIDs[0]].String(), res.String())
+}
+
+func TestIngesterQueryParallelism(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.QueryParallelism = 4
+	cfg.QueryParallelismMinSeries = 50
+	_, ing := newTestStore(t, cfg, defaultClientTestConfig(), defaultLimitsTestConfig())
+	defer ing.Shutdown()
+
+	userIDs, testData := pushTestSamples(t, ing, 300, 10, 0)
+
+	for _, userID := range userIDs {
+		ctx := user.InjectOrgID(context.Background(), userID)
+		res, req, err := runTestQuery(ctx, t, ing, labels.MatchRegexp, model.JobLabel, ".+")
+		require.NoError(t, err)
+		assert.Equal(t, testData[userID], res)
+
+		s := stream{
+			ctx: ctx,
+		}
+		require.NoError(t, ing.QueryStream(req, &s))
+		require.True(t, len(s.responses) >= 3)
+
+		res, err = chunkcompat.StreamsToMatrix(model.Earliest, model.Latest, s.responses)
+		require.NoError(t, err)
+		sort.Sort(res)
+		assert.Equal(t, testData[userID].String(), res.String())
+	}
+}
+
 func TestIngesterIdleFlush(t *testing.T) {
 	// Create test ingester with short flush cycle
 	cfg := defaultIngesterTestConfig()
@@ -260,6 +319,171 @@ func TestIngesterIdleFlush(t *testing.T) {
 	}
 }
 
+func TestIngesterFlushHandlerTenant(t *testing.T) {
+	store, ing := newDefaultTestStore(t)
+	defer ing.Shutdown()
+
+	userIDs, testData := pushTestSamples(t, ing, 4, 100, 0)
+
+	w := httptest.NewRecorder()
+	ing.FlushHandler(w, httptest.NewRequest("POST", "/flush?tenant="+userIDs[0], nil))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	// Only the chunks of the requested tenant are flushed.
+	test.Poll(t, time.Second, 4, func() interface{} {
+		store.mtx.Lock()
+		defer store.mtx.Unlock()
+		return len(store.chunks[userIDs[0]])
+	})
+	store.checkData(t, userIDs[:1], testData)
+
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	for _, userID := range userIDs[1:] {
+		require.Empty(t, store.chunks[userID])
+	}
+}
+
+func TestIngesterEphemeralSeries(t *testing.T) {
+	limits := defaultLimitsTestConfig()
+	limits.EphemeralSeriesSelectors = []string{`{job="testjob0"}`}
+
+	// Only the series of testjob1 are ever flushed.
+	persistent := func(m model.Matrix) model.Matrix {
+		res := model.Matrix{}
+		for _, ss := range m {
+			if ss.Metric[model.JobLabel] == "testjob1" {
+				res = append(res, ss)
+			}
+		}
+		return res
+	}
+
+	t.Run("never flushed", func(t *testing.T) {
+		store, ing := newTestStore(t, defaultIngesterTestConfig(), defaultClientTestConfig(), limits)
+		userIDs, testData := pushTestSamples(t, ing, 10, 100, 0)
+
+		// Ephemeral series are queryable from memory.
+		for _, userID := range userIDs {
+			ctx := user.InjectOrgID(context.Background(), userID)
+			res, _, err := runTestQuery(ctx, t, ing, labels.MatchRegexp, model.JobLabel, ".+")
+			require.NoError(t, err)
+			assert.Equal(t, testData[userID], res)
+		}
+
+		ing.Shutdown()
+		for _, userID := range userIDs {
+			testData[userID] = persistent(testData[userID])
+		}
+		store.checkData(t, userIDs, testData)
+	})
+
+	t.Run("removed after retention", func(t *testing.T) {
+		limits := limits
+		limits.EphemeralSeriesRetention = time.Nanosecond
+		_, ing := newTestStore(t, defaultIngesterTestConfig(), defaultClientTestConfig(), limits)
+		defer ing.Shutdown()
+		userIDs, testData := pushTestSamples(t, ing, 10, 100, 0)
+
+		time.Sleep(time.Millisecond)
+		ing.sweepUsers(false)
+
+		for _, userID := range userIDs {
+			ctx := user.InjectOrgID(context.Background(), userID)
+			res, _, err := runTestQuery(ctx, t, ing, labels.MatchRegexp, model.JobLabel, ".+")
+			require.NoError(t, err)
+			assert.Equal(t, persistent(testData[userID]), res)
+		}
+	})
+}
+
+func TestIngesterStaggerTenantFlushes(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.FlushCheckPeriod = time.Minute
+	cfg.StaggerTenantFlushes = true
+	cfg.MaxChunkIdle = time.Millisecond
+	require.Equal(t, 60, cfg.tenantFlushSlots())
+
+	store, ing := newTestStore(t, cfg, defaultClientTestConfig(), defaultLimitsTestConfig())
+	defer ing.Shutdown()
+
+	userIDs, _ := pushTestSamples(t, ing, 4, 100, 0)
+	time.Sleep(10 * time.Millisecond)
+
+	// Only the tenants of the slot swept have their idle chunks flushed.
+	slot := tenantFlushSlot(userIDs[0], 60)
+	ing.sweepUsersInSlot(slot, 60)
+
+	expected := map[string]int{}
+	for _, userID := range userIDs {
+		if tenantFlushSlot(userID, 60) == slot {
+			expected[userID] = 4
+		}
+	}
+	test.Poll(t, time.Second, expected, func() interface{} {
+		store.mtx.Lock()
+		defer store.mtx.Unlock()
+		flushed := map[string]int{}
+		for userID, chunks := range store.chunks {
+			if len(chunks) > 0 {
+				flushed[userID] = len(chunks)
+			}
+		}
+		return flushed
+	})
+}
+
+func TestTenantFlushSlots(t *testing.T) {
+	for _, tc := range []struct {
+		stagger bool
+		period  time.Duration
+		slots   int
+	}{
+		{stagger: false, period: time.Minute, slots: 1},
+		{stagger: true, period: 10 * time.Second, slots: 10},
+		{stagger: true, period: time.Hour, slots: maxTenantFlushSlots},
+		{stagger: true, period: 20 * time.Millisecond, slots: 1},
+	} {
+		cfg := Config{StaggerTenantFlushes: tc.stagger, FlushCheckPeriod: tc.period}
+		assert.Equal(t, tc.slots, cfg.tenantFlushSlots())
+	}
+
+	// The tenants are spread across the slots.
+	seen := map[int]struct{}{}
+	for i := 0; i < 1000; i++ {
+		slot := tenantFlushSlot(fmt.Sprintf("tenant-%d", i), maxTenantFlushSlots)
+		require.True(t, slot >= 0 && slot < maxTenantFlushSlots)
+		seen[slot] = struct{}{}
+	}
+	assert.Len(t, seen, maxTenantFlushSlots)
+}
+
+func TestIngesterIdleTenantRemoved(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.FlushCheckPeriod = 20 * time.Millisecond
+	cfg.MaxTenantIdle = 100 * time.Millisecond
+	store, ing := newTestStore(t, cfg, defaultClientTestConfig(), defaultLimitsTestConfig())
+	defer ing.Shutdown()
+
+	userIDs, testData := pushTestSamples(t, ing, 4, 100, 0)
+
+	// wait beyond the tenant idle time so samples flush and tenants are removed
+	time.Sleep(cfg.MaxTenantIdle * 3)
+
+	store.checkData(t, userIDs, testData)
+	for _, userID := range userIDs {
+		_, ok := ing.userStates.get(userID)
+		require.False(t, ok, "tenant %s should have been removed", userID)
+	}
+
+	// tenants are recreated on the next write
+	pushTestSamples(t, ing, 1, 1, 1000)
+	for _, userID := range userIDs {
+		_, ok := ing.userStates.get(userID)
+		require.True(t, ok)
+	}
+}
+
 func TestIngesterSpreadFlush(t *testing.T) {
 	// Create test ingester with short flush cycle
 	cfg := defaultIngesterTestConfig()
@@ -280,6 +504,28 @@ func TestIngesterSpreadFlush(t *testing.T) {
 	store.checkData(t, userIDs, testData)
 }
 
+func TestIngesterSpreadFlushPerTenantMaxChunkAge(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.SpreadFlushes = true
+	limits := defaultLimitsTestConfig()
+	limits.MaxChunkAge = 2 * time.Hour
+	_, ing := newTestStore(t, cfg, defaultClientTestConfig(), limits)
+	defer ing.Shutdown()
+
+	// The head chunks are cut in the cycle of the max chunk age of the
+	// tenant, rather than in the one of -ingester.max-chunk-age.
+	userIDs, _ := pushTestSamples(t, ing, 4, 1, 0)
+	_, _ = pushTestSamples(t, ing, 4, 1, int((limits.MaxChunkAge-time.Second).Seconds())*1000)
+
+	for _, userID := range userIDs {
+		state, ok := ing.userStates.get(userID)
+		require.True(t, ok)
+		for pair := range state.fpToSeries.iter() {
+			assert.Len(t, pair.series.chunkDescs, 2)
+		}
+	}
+}
+
 type stream struct {
 	grpc.ServerStream
 	ctx       context.Context
@@ -291,10 +537,47 @@ func (s *stream) Context() net_context.Context {
 }
 
 func (s *stream) Send(response *client.QueryStreamResponse) error {
-	s.responses = append(s.responses, response)
+	// Copy the series, as gRPC would have marshalled them by the time Send
+	// returns and the ingester reuses the batch.
+	s.responses = append(s.responses, &client.QueryStreamResponse{
+		Timeseries: append([]client.TimeSeriesChunk(nil), response.Timeseries...),
+	})
 	return nil
 }
 
+func TestIngesterPushStageDuration(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	overrides, err := validation.NewOverrides(defaultLimitsTestConfig())
+	require.NoError(t, err)
+	ing, err := New(defaultIngesterTestConfig(), defaultClientTestConfig(), overrides, &testStore{chunks: map[string][]chunk.Chunk{}}, registry)
+	require.NoError(t, err)
+	defer ing.Shutdown()
+
+	pushTestSamples(t, ing, 10, 10, 0)
+
+	mfs, err := registry.Gather()
+	require.NoError(t, err)
+	stages := map[string]uint64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "cortex_ingester_push_stage_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				stages[l.GetValue()] = m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	// One observation per stage for each of the 3 pushes.
+	assert.Equal(t, map[string]uint64{
+		"auth":            3,
+		"instance_limits": 3,
+		"series":          3,
+		"append":          3,
+	}, stages)
+}
+
 func TestIngesterAppendOutOfOrderAndDuplicate(t *testing.T) {
 	_, ing := newDefaultTestStore(t)
 	defer ing.Shutdown()
@@ -303,22 +586,22 @@ func TestIngesterAppendOutOfOrderAndDuplicate(t *testing.T) {
 		{Name: model.MetricNameLabel, Value: "testmetric"},
 	}
 	ctx := context.Background()
-	err := ing.append(ctx, userID, m, 1, 0, client.API)
+	err := ing.append(ctx, userID, m, 1, 0, client.API, &pushStages{})
 	require.NoError(t, err)
 
 	// Two times exactly the same sample (noop).
-	err = ing.append(ctx, userID, m, 1, 0, client.API)
+	err = ing.append(ctx, userID, m, 1, 0, client.API, &pushStages{})
 	require.NoError(t, err)
 
 	// Earlier sample than previous one.
-	err = ing.append(ctx, userID, m, 0, 0, client.API)
+	err = ing.append(ctx, userID, m, 0, 0, client.API, &pushStages{})
 	require.Contains(t, err.Error(), "sample timestamp out of order")
 	errResp, ok := httpgrpc.HTTPResponseFromError(err)
 	require.True(t, ok)
 	require.Equal(t, errResp.Code, int32(400))
 
 	// Same timestamp as previous sample, but different value.
-	err = ing.append(ctx, userID, m, 1, 1, client.API)
+	err = ing.append(ctx, userID, m, 1, 1, client.API, &pushStages{})
 	require.Contains(t, err.Error(), "sample with repeated timestamp but different value")
 	errResp, ok = httpgrpc.HTTPResponseFromError(err)
 	require.True(t, ok)
@@ -336,7 +619,7 @@ func TestIngesterAppendBlankLabel(t *testing.T) {
 		{Name: "bar", Value: ""},
 	}
 	ctx := user.InjectOrgID(context.Background(), userID)
-	err := ing.append(ctx, userID, lp, 1, 0, client.API)
+	err := ing.append(ctx, userID, lp, 1, 0, client.API, &pushStages{})
 	require.NoError(t, err)
 
 	res, _, err := runTestQuery(ctx, t, ing, labels.MatchEqual, labels.MetricName, "testmetric")
@@ -354,9 +637,230 @@ func TestIngesterAppendBlankLabel(t *testing.T) {
 	assert.Equal(t, expected, res)
 }
 
+func TestIngesterLabelNamesAndValuesWithMatchers(t *testing.T) {
+	_, ing := newDefaultTestStore(t)
+	defer ing.Shutdown()
+
+	ctx := user.InjectOrgID(context.Background(), userID)
+	for _, lp := range []labelPairs{
+		{{Name: model.MetricNameLabel, Value: "up"}, {Name: "job", Value: "a"}, {Name: "env", Value: "prod"}},
+		{{Name: model.MetricNameLabel, Value: "up"}, {Name: "job", Value: "b"}},
+		{{Name: model.MetricNameLabel, Value: "down"}, {Name: "job", Value: "c"}, {Name: "zone", Value: "z1"}},
+	} {
+		_, err := ing.Push(ctx, client.ToWriteRequest([]labels.Labels{client.FromLabelAdaptersToLabels(lp)}, []client.Sample{{TimestampMs: 100}}, client.API))
+		require.NoError(t, err)
+	}
+
+	// Without matchers nor time range, everything in the index is returned.
+	valuesResp, err := ing.LabelValues(ctx, &client.LabelValuesRequest{LabelName: "job"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, valuesResp.LabelValues)
+
+	// Without matchers, only the series within the time range are.
+	_, err = ing.Push(ctx, client.ToWriteRequest([]labels.Labels{{{Name: model.MetricNameLabel, Value: "late"}, {Name: "job", Value: "d"}}}, []client.Sample{{TimestampMs: 250}}, client.API))
+	require.NoError(t, err)
+	valuesReq, err := client.ToLabelValuesRequest("job", 0, 200, nil)
+	require.NoError(t, err)
+	valuesResp, err = ing.LabelValues(ctx, valuesReq)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, valuesResp.LabelValues)
+	valuesReq, err = client.ToLabelValuesRequest("job", 200, 300, nil)
+	require.NoError(t, err)
+	valuesResp, err = ing.LabelValues(ctx, valuesReq)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d"}, valuesResp.LabelValues)
+
+	namesReq, err := client.ToLabelNamesRequest(200, 300, nil)
+	require.NoError(t, err)
+	namesResp, err := ing.LabelNames(ctx, namesReq)
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.MetricNameLabel, "job"}, namesResp.LabelNames)
+	namesReq, err = client.ToLabelNamesRequest(0, 200, nil)
+	require.NoError(t, err)
+	namesResp, err = ing.LabelNames(ctx, namesReq)
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.MetricNameLabel, "env", "job", "zone"}, namesResp.LabelNames)
+
+	m, err := labels.NewMatcher(labels.MatchEqual, model.MetricNameLabel, "up")
+	require.NoError(t, err)
+	upMatcher := []*labels.Matcher{m}
+
+	valuesReq, err = client.ToLabelValuesRequest("job", 0, 200, upMatcher)
+	require.NoError(t, err)
+	valuesResp, err = ing.LabelValues(ctx, valuesReq)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, valuesResp.LabelValues)
+
+	namesReq, err = client.ToLabelNamesRequest(0, 200, upMatcher)
+	require.NoError(t, err)
+	namesResp, err = ing.LabelNames(ctx, namesReq)
+	require.NoError(t, err)
+	assert.Equal(t, []string{model.MetricNameLabel, "env", "job"}, namesResp.LabelNames)
+
+	// Series outside the time range are excluded.
+	namesReq, err = client.ToLabelNamesRequest(200, 300, upMatcher)
+	require.NoError(t, err)
+	namesResp, err = ing.LabelNames(ctx, namesReq)
+	require.NoError(t, err)
+	assert.Empty(t, namesResp.LabelNames)
+}
+
+func TestIngesterLabelCardinality(t *testing.T) {
+	_, ing := newDefaultTestStore(t)
+	defer ing.Shutdown()
+
+	ctx := user.InjectOrgID(context.Background(), userID)
+	for _, lp := range []labelPairs{
+		{{Name: model.MetricNameLabel, Value: "up"}, {Name: "job", Value: "a"}},
+		{{Name: model.MetricNameLabel, Value: "up"}, {Name: "job", Value: "b"}},
+		{{Name: model.MetricNameLabel, Value: "down"}, {Name: "job", Value: "a"}},
+	} {
+		require.NoError(t, ing.append(ctx, userID, lp, 100, 0, client.API, &pushStages{}))
+	}
+
+	cardinality := func(from, through model.Time, labelNames []string, matchers ...*labels.Matcher) map[string]map[string]uint64 {
+		req, err := client.ToLabelCardinalityRequest(from, through, labelNames, matchers)
+		require.NoError(t, err)
+		resp, err := ing.LabelCardinality(ctx, req)
+		require.NoError(t, err)
+
+		result := map[string]map[string]uint64{}
+		for _, label := range resp.Labels {
+			result[label.LabelName] = map[string]uint64{}
+			for _, v := range label.Values {
+				result[label.LabelName][v.LabelValue] = v.SeriesCount
+			}
+		}
+		return result
+	}
+
+	assert.Equal(t, map[string]map[string]uint64{
+		model.MetricNameLabel: {"up": 2, "down": 1},
+		"job":                 {"a": 2, "b": 1},
+	}, cardinality(0, 200, nil))
+
+	m, err := labels.NewMatcher(labels.MatchEqual, model.MetricNameLabel, "up")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]uint64{
+		"job": {"a": 1, "b": 1},
+	}, cardinality(0, 200, []string{"job"}, m))
+
+	// Series outside the time range are excluded.
+	assert.Empty(t, cardinality(200, 300, nil))
+}
+
+func TestIngesterReadOnly(t *testing.T) {
+	_, ing := newDefaultTestStore(t)
+	defer ing.Shutdown()
+
+	userIDs, testData := pushTestSamples(t, ing, 10, 10, 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/ingester/read-only", nil)
+	rec := httptest.NewRecorder()
+	ing.ReadOnlyHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, ing.IsReadOnly())
+
+	// Pushes are rejected with a 503.
+	ctx := user.InjectOrgID(context.Background(), userIDs[0])
+	_, err := ing.Push(ctx, client.ToWriteRequest(matrixToLables(testData[userIDs[0]]), matrixToSamples(testData[userIDs[0]]), client.API))
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	require.Equal(t, int32(http.StatusServiceUnavailable), resp.Code)
+
+	// Queries are still served.
+	res, _, err := runTestQuery(ctx, t, ing, labels.MatchRegexp, model.JobLabel, ".+")
+	require.NoError(t, err)
+	assert.Equal(t, testData[userIDs[0]], res)
+
+	req = httptest.NewRequest(http.MethodDelete, "/ingester/read-only", nil)
+	rec = httptest.NewRecorder()
+	ing.ReadOnlyHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.False(t, ing.IsReadOnly())
+
+	_, err = ing.Push(ctx, client.ToWriteRequest([]labels.Labels{{{Name: labels.MetricName, Value: "new"}}}, []client.Sample{{TimestampMs: 1, Value: 1}}, client.API))
+	require.NoError(t, err)
+}
+
+func TestIngesterMemoryStats(t *testing.T) {
+	_, ing := newDefaultTestStore(t)
+	defer ing.Shutdown()
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	_, err := ing.Push(ctx, client.ToWriteRequest(matrixToLables(buildTestMatrix(10, 10, 0)), matrixToSamples(buildTestMatrix(10, 10, 0)), client.API))
+	require.NoError(t, err)
+	ctx = user.InjectOrgID(context.Background(), "2")
+	_, err = ing.Push(ctx, client.ToWriteRequest(matrixToLables(buildTestMatrix(1, 10, 0)), matrixToSamples(buildTestMatrix(1, 10, 0)), client.API))
+	require.NoError(t, err)
+
+	stats := ing.memoryStats()
+	require.Len(t, stats, 2)
+
+	// The largest tenant comes first.
+	assert.Equal(t, "1", stats[0].UserID)
+	assert.Equal(t, 10, stats[0].Series)
+	assert.Equal(t, 10, stats[0].Chunks)
+	assert.True(t, stats[0].ChunkBytes > 0)
+	assert.True(t, stats[0].Index.Bytes > 0)
+
+	assert.Equal(t, "2", stats[1].UserID)
+	assert.Equal(t, 1, stats[1].Series)
+}
+
+func TestIngesterPerTenantChunkEncoding(t *testing.T) {
+	limits := defaultLimitsTestConfig()
+	limits.ChunkEncoding = "Varbit"
+
+	_, ing := newTestStore(t, defaultIngesterTestConfig(), defaultClientTestConfig(), limits)
+	defer ing.Shutdown()
+
+	ctx := user.InjectOrgID(context.Background(), userID)
+	lp := labelPairs{{Name: model.MetricNameLabel, Value: "testmetric"}}
+	require.NoError(t, ing.append(ctx, userID, lp, 1, 0, client.API, &pushStages{}))
+
+	state, ok := ing.userStates.get(userID)
+	require.True(t, ok)
+	for pair := range state.fpToSeries.iter() {
+		assert.Equal(t, encoding.Varbit, pair.series.head().C.Encoding())
+	}
+}
+
+func TestIngesterShouldFlushChunk(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.ChunkAgeJitter = 0
+	cfg.SparseChunkMaxAge = time.Hour
+	cfg.SparseChunkMinSamples = 10
+
+	limits := defaultLimitsTestConfig()
+	limits.MaxChunkAge = 2 * time.Hour
+
+	_, ing := newTestStore(t, cfg, defaultClientTestConfig(), limits)
+	defer ing.Shutdown()
+
+	newChunk := func(span time.Duration, samples int) *desc {
+		c := newDesc(encoding.New(), 0, 0)
+		for i := 0; i < samples; i++ {
+			cs, err := c.add(model.SamplePair{Timestamp: model.Time(i), Value: 1})
+			require.NoError(t, err)
+			c.C = cs[0]
+		}
+		c.LastTime = model.Time(0).Add(span)
+		return c
+	}
+
+	// The per-tenant max chunk age overrides the ingester one.
+	assert.Equal(t, flushReason(reasonAged), ing.shouldFlushChunk(userID, newChunk(3*time.Hour, 100), 0))
+	assert.Equal(t, flushReason(noFlush), ing.shouldFlushChunk(userID, newChunk(90*time.Minute, 100), 0))
+
+	// Sparse chunks are cut early.
+	assert.Equal(t, flushReason(reasonSparse), ing.shouldFlushChunk(userID, newChunk(90*time.Minute, 5), 0))
+	assert.Equal(t, flushReason(noFlush), ing.shouldFlushChunk(userID, newChunk(30*time.Minute, 5), 0))
+}
+
 func TestIngesterUserSeriesLimitExceeded(t *testing.T) {
 	limits := defaultLimitsTestConfig()
-	limits.MaxSeriesPerUser = 1
+	limits.MaxLocalSeriesPerUser = 1
 
 	_, ing := newTestStore(t, defaultIngesterTestConfig(), defaultClientTestConfig(), limits)
 	defer ing.Shutdown()
@@ -413,7 +917,7 @@ func TestIngesterUserSeriesLimitExceeded(t *testing.T) {
 
 func TestIngesterMetricSeriesLimitExceeded(t *testing.T) {
 	limits := defaultLimitsTestConfig()
-	limits.MaxSeriesPerMetric = 1
+	limits.MaxLocalSeriesPerMetric = 1
 
 	_, ing := newTestStore(t, defaultIngesterTestConfig(), defaultClientTestConfig(), limits)
 	defer ing.Shutdown()
@@ -468,6 +972,60 @@ func TestIngesterMetricSeriesLimitExceeded(t *testing.T) {
 	assert.Equal(t, expected, res)
 }
 
+func TestIngesterInstanceLimitsExceeded(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.InstanceLimits.MaxInMemorySeries = 1
+	cfg.InstanceLimits.MaxInMemoryTenants = 1
+
+	_, ing := newTestStore(t, cfg, defaultClientTestConfig(), defaultLimitsTestConfig())
+	defer ing.Shutdown()
+
+	labels1 := labels.Labels{{Name: labels.MetricName, Value: "testmetric"}, {Name: "foo", Value: "bar"}}
+	labels2 := labels.Labels{{Name: labels.MetricName, Value: "testmetric"}, {Name: "foo", Value: "biz"}}
+	sample := client.Sample{TimestampMs: 0, Value: 1}
+
+	// Append only one series first, expect no error.
+	ctx := user.InjectOrgID(context.Background(), "1")
+	_, err := ing.Push(ctx, client.ToWriteRequest([]labels.Labels{labels1}, []client.Sample{sample}, client.API))
+	require.NoError(t, err)
+
+	// A second series is rejected by the instance series limit.
+	sample.TimestampMs = 1
+	_, err = ing.Push(ctx, client.ToWriteRequest([]labels.Labels{labels2}, []client.Sample{sample}, client.API))
+	require.Equal(t, errMaxSeriesLimitReached, err)
+	if resp, ok := httpgrpc.HTTPResponseFromError(err); !ok || resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected instance limit error, got %v", err)
+	}
+
+	// A second tenant is rejected by the instance tenants limit.
+	ctx2 := user.InjectOrgID(context.Background(), "2")
+	_, err = ing.Push(ctx2, client.ToWriteRequest([]labels.Labels{labels1}, []client.Sample{sample}, client.API))
+	require.Equal(t, errMaxTenantsLimitReached, err)
+
+	// Pushes to the existing series are still accepted.
+	sample.TimestampMs = 2
+	_, err = ing.Push(ctx, client.ToWriteRequest([]labels.Labels{labels1}, []client.Sample{sample}, client.API))
+	require.NoError(t, err)
+}
+
+func TestIngesterInstanceLimitsInflightAndRate(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.InstanceLimits.MaxInflightPushRequests = 1
+	cfg.InstanceLimits.MaxIngestionRate = 10
+
+	_, ing := newTestStore(t, cfg, defaultClientTestConfig(), defaultLimitsTestConfig())
+	defer ing.Shutdown()
+
+	require.NoError(t, ing.checkInstanceLimits(1))
+	require.Equal(t, errMaxInflightLimitReached, ing.checkInstanceLimits(2))
+
+	for j := 0; j < 1000; j++ {
+		ing.ingestionRate.inc()
+	}
+	ing.ingestionRate.tick()
+	require.Equal(t, errMaxIngestionRateReached, ing.checkInstanceLimits(1))
+}
+
 func BenchmarkIngesterSeriesCreationLocking(b *testing.B) {
 	for i := 1; i <= 32; i++ {
 		b.Run(strconv.Itoa(i), func(b *testing.B) {
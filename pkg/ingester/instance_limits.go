@@ -0,0 +1,43 @@
+package ingester
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/weaveworks/common/httpgrpc"
+)
+
+// Reasons for rejecting a push because of an instance limit.
+const (
+	instanceLimitMaxSeries     = "max_series"
+	instanceLimitMaxTenants    = "max_tenants"
+	instanceLimitMaxInflight   = "max_inflight_push_requests"
+	instanceLimitIngestionRate = "max_ingestion_rate"
+)
+
+// Instance limits are reported with a 503, which is distinct from the 4xx codes
+// used for per-tenant limits: the request is not at fault, this ingester is full.
+var (
+	errMaxSeriesLimitReached   = httpgrpc.Errorf(http.StatusServiceUnavailable, "cannot add series: ingester's max series limit reached")
+	errMaxTenantsLimitReached  = httpgrpc.Errorf(http.StatusServiceUnavailable, "cannot create tenant: ingester's max tenants limit reached")
+	errMaxInflightLimitReached = httpgrpc.Errorf(http.StatusServiceUnavailable, "cannot push: too many inflight push requests in ingester")
+	errMaxIngestionRateReached = httpgrpc.Errorf(http.StatusServiceUnavailable, "cannot push: ingester's max ingestion rate reached")
+)
+
+// InstanceLimits describes limits applied to a single ingester, regardless of
+// the tenants the data belongs to. They protect the ingester from running out
+// of memory. A value of 0 disables the limit.
+type InstanceLimits struct {
+	MaxInMemorySeries       int64   `yaml:"max_series"`
+	MaxInMemoryTenants      int64   `yaml:"max_tenants"`
+	MaxInflightPushRequests int64   `yaml:"max_inflight_push_requests"`
+	MaxIngestionRate        float64 `yaml:"max_ingestion_rate"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (l *InstanceLimits) RegisterFlags(f *flag.FlagSet) {
+	f.Int64Var(&l.MaxInMemorySeries, "ingester.instance-limits.max-series", 0, "Max series that this ingester can hold (across all tenants). Requests to create additional series will be rejected. 0 = unlimited.")
+	f.Int64Var(&l.MaxInMemoryTenants, "ingester.instance-limits.max-tenants", 0, "Max tenants that this ingester can hold. Requests from additional tenants will be rejected. 0 = unlimited.")
+	f.Int64Var(&l.MaxInflightPushRequests, "ingester.instance-limits.max-inflight-push-requests", 0, "Max inflight push requests that this ingester can handle (across all tenants). Additional requests will be rejected. 0 = unlimited.")
+	f.Float64Var(&l.MaxIngestionRate, "ingester.instance-limits.max-ingestion-rate", 0, "Max ingestion rate (samples/sec) that this ingester will accept. Requests will be rejected when the limit is reached. 0 = unlimited.")
+}
@@ -0,0 +1,207 @@
+package ingester
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+type ringCountMock struct {
+	count int
+}
+
+func (m ringCountMock) HealthyInstancesCount() int {
+	return m.count
+}
+
+func TestSeriesLimiter_maxSeriesPerUser(t *testing.T) {
+	tests := map[string]struct {
+		maxLocalSeriesPerUser  int
+		maxGlobalSeriesPerUser int
+		ringIngesterCount      int
+		ringReplicationFactor  int
+		shardByAllLabels       bool
+		expected               int
+	}{
+		"both local and global limits are disabled": {
+			ringIngesterCount:     10,
+			ringReplicationFactor: 3,
+			shardByAllLabels:      true,
+			expected:              math.MaxInt32,
+		},
+		"only local limit is enabled": {
+			maxLocalSeriesPerUser: 1000,
+			ringIngesterCount:     10,
+			ringReplicationFactor: 3,
+			shardByAllLabels:      true,
+			expected:              1000,
+		},
+		"only global limit is enabled with shard-by-all-labels": {
+			maxGlobalSeriesPerUser: 1000,
+			ringIngesterCount:      10,
+			ringReplicationFactor:  3,
+			shardByAllLabels:       true,
+			expected:               300, // (1000 / 10) * 3
+		},
+		"only global limit is enabled without shard-by-all-labels": {
+			maxGlobalSeriesPerUser: 1000,
+			ringIngesterCount:      10,
+			ringReplicationFactor:  3,
+			shardByAllLabels:       false,
+			expected:               math.MaxInt32,
+		},
+		"both limits are enabled, local is lower": {
+			maxLocalSeriesPerUser:  200,
+			maxGlobalSeriesPerUser: 1000,
+			ringIngesterCount:      10,
+			ringReplicationFactor:  3,
+			shardByAllLabels:       true,
+			expected:               200,
+		},
+		"both limits are enabled, global is lower": {
+			maxLocalSeriesPerUser:  500,
+			maxGlobalSeriesPerUser: 1000,
+			ringIngesterCount:      10,
+			ringReplicationFactor:  3,
+			shardByAllLabels:       true,
+			expected:               300,
+		},
+		"global limit is ignored until the ring is known": {
+			maxLocalSeriesPerUser:  500,
+			maxGlobalSeriesPerUser: 1000,
+			ringIngesterCount:      0,
+			ringReplicationFactor:  3,
+			shardByAllLabels:       true,
+			expected:               500,
+		},
+	}
+
+	for testName, testData := range tests {
+		testData := testData
+
+		t.Run(testName, func(t *testing.T) {
+			limits, err := validation.NewOverrides(validation.Limits{
+				MaxLocalSeriesPerUser:  testData.maxLocalSeriesPerUser,
+				MaxGlobalSeriesPerUser: testData.maxGlobalSeriesPerUser,
+			})
+			require.NoError(t, err)
+
+			limiter := NewSeriesLimiter(limits, ringCountMock{count: testData.ringIngesterCount}, testData.ringReplicationFactor, testData.shardByAllLabels)
+			assert.Equal(t, testData.expected, limiter.maxSeriesPerUser("test"))
+		})
+	}
+}
+
+func TestSeriesLimiter_maxSeriesPerMetric(t *testing.T) {
+	tests := map[string]struct {
+		maxLocalSeriesPerMetric  int
+		maxGlobalSeriesPerMetric int
+		ringIngesterCount        int
+		ringReplicationFactor    int
+		shardByAllLabels         bool
+		expected                 int
+	}{
+		"both local and global limits are disabled": {
+			ringIngesterCount:     10,
+			ringReplicationFactor: 3,
+			expected:              math.MaxInt32,
+		},
+		"only global limit is enabled with shard-by-all-labels": {
+			maxGlobalSeriesPerMetric: 1000,
+			ringIngesterCount:        10,
+			ringReplicationFactor:    3,
+			shardByAllLabels:         true,
+			expected:                 300,
+		},
+		"only global limit is enabled without shard-by-all-labels": {
+			maxGlobalSeriesPerMetric: 1000,
+			ringIngesterCount:        10,
+			ringReplicationFactor:    3,
+			shardByAllLabels:         false,
+			expected:                 1000,
+		},
+		"both limits are enabled, local is lower": {
+			maxLocalSeriesPerMetric:  100,
+			maxGlobalSeriesPerMetric: 1000,
+			ringIngesterCount:        10,
+			ringReplicationFactor:    3,
+			shardByAllLabels:         true,
+			expected:                 100,
+		},
+	}
+
+	for testName, testData := range tests {
+		testData := testData
+
+		t.Run(testName, func(t *testing.T) {
+			limits, err := validation.NewOverrides(validation.Limits{
+				MaxLocalSeriesPerMetric:  testData.maxLocalSeriesPerMetric,
+				MaxGlobalSeriesPerMetric: testData.maxGlobalSeriesPerMetric,
+			})
+			require.NoError(t, err)
+
+			limiter := NewSeriesLimiter(limits, ringCountMock{count: testData.ringIngesterCount}, testData.ringReplicationFactor, testData.shardByAllLabels)
+			assert.Equal(t, testData.expected, limiter.maxSeriesPerMetric("test"))
+		})
+	}
+}
+
+func TestSeriesLimiter_AssertMaxSeriesPerUser(t *testing.T) {
+	limits, err := validation.NewOverrides(validation.Limits{
+		MaxLocalSeriesPerUser:  1000,
+		MaxGlobalSeriesPerUser: 1000,
+	})
+	require.NoError(t, err)
+
+	limiter := NewSeriesLimiter(limits, ringCountMock{count: 10}, 3, true)
+	assert.NoError(t, limiter.AssertMaxSeriesPerUser("test", 299))
+	assert.EqualError(t, limiter.AssertMaxSeriesPerUser("test", 300), "per-user series limit (local: 1000 global: 1000 actual local: 300) exceeded")
+}
+
+func TestSeriesLimiter_SeriesPerUserWarning(t *testing.T) {
+	tests := map[string]struct {
+		maxLocalSeriesPerUser int
+		threshold             float64
+		series                int
+		expected              string
+	}{
+		"warnings are disabled": {
+			maxLocalSeriesPerUser: 1000,
+			series:                999,
+		},
+		"limit is disabled": {
+			threshold: 0.8,
+			series:    999,
+		},
+		"below the threshold": {
+			maxLocalSeriesPerUser: 1000,
+			threshold:             0.8,
+			series:                799,
+		},
+		"above the threshold": {
+			maxLocalSeriesPerUser: 1000,
+			threshold:             0.8,
+			series:                800,
+			expected:              "per-user series limit (actual local: 1000) almost reached: 800 series",
+		},
+	}
+
+	for testName, testData := range tests {
+		testData := testData
+
+		t.Run(testName, func(t *testing.T) {
+			limits, err := validation.NewOverrides(validation.Limits{
+				MaxLocalSeriesPerUser:  testData.maxLocalSeriesPerUser,
+				LimitsWarningThreshold: testData.threshold,
+			})
+			require.NoError(t, err)
+
+			limiter := NewSeriesLimiter(limits, ringCountMock{count: 1}, 1, false)
+			assert.Equal(t, testData.expected, limiter.SeriesPerUserWarning("test", testData.series))
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package ingester
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/cortexproject/cortex/pkg/ingester/index"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// TenantMemoryStats is the breakdown of the memory held by a single tenant in
+// an ingester.
+type TenantMemoryStats struct {
+	UserID     string      `json:"user_id"`
+	Series     int         `json:"series"`
+	Chunks     int         `json:"chunks"`
+	ChunkBytes int         `json:"chunk_bytes"`
+	Index      index.Stats `json:"index"`
+}
+
+// memoryStats returns the memory breakdown of every tenant, largest first.
+func (i *Ingester) memoryStats() []TenantMemoryStats {
+	i.userStatesMtx.RLock()
+	defer i.userStatesMtx.RUnlock()
+
+	result := []TenantMemoryStats{}
+	for userID, state := range i.userStates.cp() {
+		stats := TenantMemoryStats{
+			UserID: userID,
+			Index:  state.index.Stats(),
+		}
+		for pair := range state.fpToSeries.iter() {
+			state.fpLocker.Lock(pair.fp)
+			stats.Series++
+			stats.Chunks += len(pair.series.chunkDescs)
+			for _, desc := range pair.series.chunkDescs {
+				stats.ChunkBytes += desc.C.Size()
+			}
+			state.fpLocker.Unlock(pair.fp)
+		}
+		result = append(result, stats)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if a, b := result[i].ChunkBytes+result[i].Index.Bytes, result[j].ChunkBytes+result[j].Index.Bytes; a != b {
+			return a > b
+		}
+		return result[i].UserID < result[j].UserID
+	})
+	return result
+}
+
+// MemoryStatsHandler dumps a per-tenant breakdown of the memory held by the
+// ingester, sorted by the tenants holding the most memory first.
+func (i *Ingester) MemoryStatsHandler(w http.ResponseWriter, r *http.Request) {
+	util.WriteJSONResponse(w, i.memoryStats())
+}
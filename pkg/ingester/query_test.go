@@ -31,7 +31,7 @@ func BenchmarkQueryStream(b *testing.B) {
 	)
 
 	encoding.DefaultEncoding = encoding.Bigchunk
-	limits.MaxSeriesPerMetric = numSeries
+	limits.MaxLocalSeriesPerMetric = numSeries
 	limits.MaxSeriesPerQuery = numSeries
 	cfg.FlushCheckPeriod = 15 * time.Minute
 	_, ing := newTestStore(b, cfg, clientCfg, limits)
@@ -55,7 +55,7 @@ func BenchmarkQueryStream(b *testing.B) {
 			{Name: "cpu", Value: cpus[i%numCPUs]},
 		}
 
-		state, fp, series, err := ing.userStates.getOrCreateSeries(ctx, "1", labels)
+		state, fp, series, err := ing.userStates.getOrCreateSeries(ctx, "1", labels, nil)
 		require.NoError(b, err)
 
 		for j := 0; j < numSamples; j++ {
@@ -26,6 +26,13 @@ func init() {
 type memorySeries struct {
 	metric labels.Labels
 
+	// Encoding of the chunks created for this series.
+	encoding encoding.Encoding
+
+	// Whether this series is ephemeral, i.e. it is kept in memory only and
+	// its chunks are never flushed.
+	ephemeral bool
+
 	// Sorted by start time, overlapping chunk ranges are forbidden.
 	chunkDescs []*desc
 
@@ -54,10 +61,11 @@ func (error *memorySeriesError) Error() string {
 }
 
 // newMemorySeries returns a pointer to a newly allocated memorySeries for the
-// given metric.
-func newMemorySeries(m labels.Labels) *memorySeries {
+// given metric, whose chunks will use the given encoding.
+func newMemorySeries(m labels.Labels, enc encoding.Encoding) *memorySeries {
 	return &memorySeries{
 		metric:   m,
+		encoding: enc,
 		lastTime: model.Earliest,
 	}
 }
@@ -91,7 +99,11 @@ func (s *memorySeries) add(v model.SamplePair) error {
 	}
 
 	if len(s.chunkDescs) == 0 || s.headChunkClosed {
-		newHead := newDesc(encoding.New(), v.Timestamp, v.Timestamp)
+		c, err := encoding.NewForEncoding(s.encoding)
+		if err != nil {
+			return err
+		}
+		newHead := newDesc(c, v.Timestamp, v.Timestamp)
 		s.chunkDescs = append(s.chunkDescs, newHead)
 		s.headChunkClosed = false
 		createdChunks.Inc()
@@ -162,6 +174,15 @@ func (s *memorySeries) head() *desc {
 	return s.chunkDescs[len(s.chunkDescs)-1]
 }
 
+// overlaps returns true if the series has chunks overlapping [from, through].
+// The caller must have locked the fingerprint of the memorySeries.
+func (s *memorySeries) overlaps(from, through model.Time) bool {
+	if len(s.chunkDescs) == 0 {
+		return false
+	}
+	return !s.firstTime().After(through) && !s.head().LastTime.Before(from)
+}
+
 func (s *memorySeries) samplesForRange(from, through model.Time) ([]model.SamplePair, error) {
 	// Find first chunk with start time after "from".
 	fromIdx := sort.Search(len(s.chunkDescs), func(i int) bool {
@@ -62,7 +62,7 @@ func (i *Ingester) TransferChunks(stream client.Ingester_TransferChunksServer) e
 		}
 	}()
 
-	userStates := newUserStates(i.limits, i.cfg)
+	userStates := newUserStates(i.limiter, i.limits, i.cfg)
 	fromIngesterID := ""
 	seriesReceived := 0
 
@@ -87,7 +87,7 @@ func (i *Ingester) TransferChunks(stream client.Ingester_TransferChunksServer) e
 			return err
 		}
 
-		state, fp, series, err := userStates.getOrCreateSeries(stream.Context(), wireSeries.UserId, wireSeries.Labels)
+		state, fp, series, err := userStates.getOrCreateSeries(stream.Context(), wireSeries.UserId, wireSeries.Labels, nil)
 		if err != nil {
 			return err
 		}
@@ -98,6 +98,9 @@ func (i *Ingester) TransferChunks(stream client.Ingester_TransferChunksServer) e
 		if err != nil {
 			return err
 		}
+		if len(descs) > 0 {
+			state.timeRange.extend(descs[0].FirstTime, descs[len(descs)-1].LastTime)
+		}
 
 		seriesReceived++
 		memoryChunks.Add(float64(len(series.chunkDescs) - prevNumChunks))
@@ -0,0 +1,103 @@
+package ingester
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+var uploadThrottledSeconds = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "cortex_ingester_flush_upload_throttled_seconds_total",
+	Help: "Total time flushes waited for upload bandwidth or an upload slot.",
+})
+
+// uploadThrottle limits the bandwidth and the number of concurrent uploads
+// used to flush chunks and their index entries, so that mass flushes (e.g. on shutdown) don't saturate
+// the network shared with the query path. A nil uploadThrottle doesn't throttle.
+type uploadThrottle struct {
+	limiter *rate.Limiter // nil if the bandwidth is unlimited
+	slots   chan struct{} // nil if the concurrency is unlimited
+}
+
+// newUploadThrottle returns a throttle allowing bytesPerSec bytes/sec and
+// maxConcurrent concurrent uploads; 0 means unlimited. Returns nil if neither
+// is limited.
+func newUploadThrottle(bytesPerSec, maxConcurrent int) *uploadThrottle {
+	if bytesPerSec <= 0 && maxConcurrent <= 0 {
+		return nil
+	}
+
+	t := &uploadThrottle{}
+	if bytesPerSec > 0 {
+		t.limiter = rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)
+	}
+	if maxConcurrent > 0 {
+		t.slots = make(chan struct{}, maxConcurrent)
+	}
+	return t
+}
+
+// acquire waits for an upload slot and for the bandwidth to upload size bytes.
+// The returned function must be called to release the slot once the upload
+// is done.
+func (t *uploadThrottle) acquire(ctx context.Context, size int) (func(), error) {
+	if t == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	defer func() {
+		uploadThrottledSeconds.Add(time.Since(start).Seconds())
+	}()
+
+	release := func() {}
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+			release = func() { <-t.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if t.limiter != nil {
+		// WaitN fails for more than the burst, so wait for big uploads in
+		// burst-sized pieces.
+		for size > 0 {
+			n := size
+			if burst := t.limiter.Burst(); n > burst {
+				n = burst
+			}
+			if err := t.limiter.WaitN(ctx, n); err != nil {
+				release()
+				return nil, err
+			}
+			size -= n
+		}
+	}
+	return release, nil
+}
+
+// uploadSize returns the number of bytes written to the chunk store to flush
+// the encoded chunks: their data, and their index entries. The entries are
+// written by the store according to its schema, so their size is estimated
+// as an entry per label of the series, holding the label and the chunk ID.
+func uploadSize(chunks []chunk.Chunk) int {
+	size := 0
+	for i := range chunks {
+		c := &chunks[i]
+		if buf, err := c.Encoded(); err == nil {
+			size += len(buf)
+		}
+		id := len(c.ExternalKey())
+		for _, l := range c.Metric {
+			size += len(l.Name) + len(l.Value) + id
+		}
+	}
+	return size
+}
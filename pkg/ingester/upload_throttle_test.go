@@ -0,0 +1,100 @@
+package ingester
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestUploadThrottleUnlimited(t *testing.T) {
+	throttle := newUploadThrottle(0, 0)
+	require.Nil(t, throttle)
+
+	release, err := throttle.acquire(context.Background(), 1<<30)
+	require.NoError(t, err)
+	release()
+}
+
+func TestUploadThrottleBandwidth(t *testing.T) {
+	throttle := newUploadThrottle(1000, 0)
+
+	// The burst is used up by the first upload, and uploads bigger than the
+	// burst wait for it to be refilled several times.
+	start := time.Now()
+	for _, size := range []int{1000, 2500} {
+		release, err := throttle.acquire(context.Background(), size)
+		require.NoError(t, err)
+		release()
+	}
+	assert.True(t, time.Since(start) >= 2*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := throttle.acquire(ctx, 1000)
+	assert.Error(t, err)
+}
+
+func TestUploadThrottleConcurrency(t *testing.T) {
+	throttle := newUploadThrottle(0, 1)
+
+	release, err := throttle.acquire(context.Background(), 1)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = throttle.acquire(ctx, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	release()
+	release, err = throttle.acquire(context.Background(), 1)
+	require.NoError(t, err)
+	release()
+}
+
+func TestUploadSize(t *testing.T) {
+	metric := labels.Labels{{Name: model.MetricNameLabel, Value: "foo"}, {Name: "bar", Value: "baz"}}
+	c := chunk.NewChunk("user", 0, metric, encoding.New(), 0, 1)
+	require.NoError(t, c.Encode())
+	buf, err := c.Encoded()
+	require.NoError(t, err)
+
+	// The chunk data, and an index entry per label holding the chunk ID.
+	id := len(c.ExternalKey())
+	assert.Equal(t, len(buf)+len("__name__foo")+id+len("barbaz")+id, uploadSize([]chunk.Chunk{c}))
+}
+
+func TestIngesterShutdownCancelsThrottledFlushes(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.MaxChunkIdle = time.Millisecond
+	cfg.FlushMaxConcurrentUploads = 1
+	store, ing := newTestStore(t, cfg, defaultClientTestConfig(), defaultLimitsTestConfig())
+
+	// The flushes of the idle chunks wait for the upload slot.
+	release, err := ing.uploadThrottle.acquire(context.Background(), 1)
+	require.NoError(t, err)
+	userIDs, testData := pushTestSamples(t, ing, 1, 10, 0)
+	time.Sleep(10 * time.Millisecond)
+	ing.sweepUsers(false)
+
+	// They're cancelled on shutdown, the final flush uploading the chunks.
+	done := make(chan struct{})
+	go func() {
+		ing.Shutdown()
+		close(done)
+	}()
+	test.Poll(t, time.Second, context.Canceled, func() interface{} {
+		return ing.flushCtx.Err()
+	})
+	release()
+	<-done
+	store.checkData(t, userIDs, testData)
+}
@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
 	"github.com/segmentio/fasthash/fnv1a"
 
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/ingester/index"
 	"github.com/cortexproject/cortex/pkg/util"
@@ -43,12 +48,19 @@ var (
 )
 
 type userStates struct {
-	states sync.Map
-	limits *validation.Overrides
-	cfg    Config
+	states  sync.Map
+	limiter *SeriesLimiter
+	limits  *validation.Overrides
+	cfg     Config
+
+	// Number of tenants and series held across all userStates, used to
+	// enforce the instance limits. Updated atomically.
+	numTenants int64
+	numSeries  int64
 }
 
 type userState struct {
+	limiter             *SeriesLimiter
 	limits              *validation.Overrides
 	userID              string
 	fpLocker            *fingerprintLocker
@@ -58,11 +70,27 @@ type userState struct {
 	ingestedAPISamples  *ewmaRate
 	ingestedRuleSamples *ewmaRate
 
+	// Unix nanoseconds of the last sample appended for this tenant. Updated atomically.
+	lastAppend int64
+
+	// Bounds of the times of the in-memory samples, so that the label
+	// queries covering all of them are served from the index.
+	timeRange memoryTimeRange
+
 	seriesInMetric []metricCounterShard
 
+	// Parsed ephemeral series selectors, cached until the overrides change.
+	ephemeralMtx       sync.Mutex
+	ephemeralSelectors []string
+	ephemeralMatchers  [][]*labels.Matcher
+
 	memSeriesCreatedTotal prometheus.Counter
 	memSeriesRemovedTotal prometheus.Counter
 	discardedSamples      *prometheus.CounterVec
+
+	// Instance-wide series counter, shared by all userStates.
+	instanceLimits *InstanceLimits
+	instanceSeries *int64
 }
 
 const metricCounterShards = 128
@@ -78,10 +106,11 @@ type metricCounterShard struct {
 	m   map[string]int
 }
 
-func newUserStates(limits *validation.Overrides, cfg Config) *userStates {
+func newUserStates(limiter *SeriesLimiter, limits *validation.Overrides, cfg Config) *userStates {
 	return &userStates{
-		limits: limits,
-		cfg:    cfg,
+		limiter: limiter,
+		limits:  limits,
+		cfg:     cfg,
 	}
 }
 
@@ -96,14 +125,24 @@ func (us *userStates) cp() map[string]*userState {
 
 func (us *userStates) gc() {
 	us.states.Range(func(key, value interface{}) bool {
-		state := value.(*userState)
-		if state.fpToSeries.length() == 0 {
-			us.states.Delete(key)
-		}
+		us.deleteIfEmpty(key.(string))
 		return true
 	})
 }
 
+// deleteIfEmpty removes the state of the given tenant if it has no series
+// left. The caller must ensure no series are concurrently being created.
+func (us *userStates) deleteIfEmpty(userID string) bool {
+	state, ok := us.get(userID)
+	if !ok || state.fpToSeries.length() > 0 {
+		return false
+	}
+	us.states.Delete(userID)
+	memUsers.Dec()
+	atomic.AddInt64(&us.numTenants, -1)
+	return true
+}
+
 func (us *userStates) updateRates() {
 	us.states.Range(func(key, value interface{}) bool {
 		state := value.(*userState)
@@ -130,10 +169,17 @@ func (us *userStates) getViaContext(ctx context.Context) (*userState, bool, erro
 	return state, ok, nil
 }
 
-func (us *userStates) getOrCreateSeries(ctx context.Context, userID string, labels []client.LabelAdapter) (*userState, model.Fingerprint, *memorySeries, error) {
+// getOrCreateSeries returns the series of the labels, creating it if needed,
+// with its fingerprint locked. If creating is not nil, the time spent creating
+// the series, including checking the series limits, is added to it.
+func (us *userStates) getOrCreateSeries(ctx context.Context, userID string, labels []client.LabelAdapter, creating *time.Duration) (*userState, model.Fingerprint, *memorySeries, error) {
 
 	state, ok := us.get(userID)
 	if !ok {
+		maxTenants := us.cfg.InstanceLimits.MaxInMemoryTenants
+		if maxTenants > 0 && atomic.LoadInt64(&us.numTenants) >= maxTenants {
+			return nil, 0, nil, errMaxTenantsLimitReached
+		}
 
 		seriesInMetric := make([]metricCounterShard, 0, metricCounterShards)
 		for i := 0; i < metricCounterShards; i++ {
@@ -147,6 +193,7 @@ func (us *userStates) getOrCreateSeries(ctx context.Context, userID string, labe
 		// us, in which case this userState will be discarded
 		state = &userState{
 			userID:              userID,
+			limiter:             us.limiter,
 			limits:              us.limits,
 			fpToSeries:          newSeriesMap(),
 			fpLocker:            newFingerprintLocker(16 * 1024),
@@ -154,24 +201,118 @@ func (us *userStates) getOrCreateSeries(ctx context.Context, userID string, labe
 			ingestedAPISamples:  newEWMARate(0.2, us.cfg.RateUpdatePeriod),
 			ingestedRuleSamples: newEWMARate(0.2, us.cfg.RateUpdatePeriod),
 			seriesInMetric:      seriesInMetric,
+			lastAppend:          time.Now().UnixNano(),
+			timeRange:           newMemoryTimeRange(),
 
 			memSeriesCreatedTotal: memSeriesCreatedTotal.WithLabelValues(userID),
 			memSeriesRemovedTotal: memSeriesRemovedTotal.WithLabelValues(userID),
 			discardedSamples:      validation.DiscardedSamples.MustCurryWith(prometheus.Labels{"user": userID}),
+
+			instanceLimits: &us.cfg.InstanceLimits,
+			instanceSeries: &us.numSeries,
 		}
 		state.mapper = newFPMapper(state.fpToSeries)
 		stored, ok := us.states.LoadOrStore(userID, state)
 		if !ok {
 			memUsers.Inc()
+			atomic.AddInt64(&us.numTenants, 1)
 		}
 		state = stored.(*userState)
 	}
 
-	fp, series, err := state.getSeries(labels)
+	fp, series, err := state.getSeries(labels, creating)
 	return state, fp, series, err
 }
 
-func (u *userState) getSeries(metric labelPairs) (model.Fingerprint, *memorySeries, error) {
+// touch records that a sample was just appended for this tenant.
+func (u *userState) touch() {
+	atomic.StoreInt64(&u.lastAppend, time.Now().UnixNano())
+}
+
+// idleFor returns how long it has been since the last sample was appended
+// for this tenant.
+func (u *userState) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&u.lastAppend)))
+}
+
+// memoryTimeRange tracks the bounds of the times of the in-memory samples of
+// a tenant. They're recomputed from the series by each flush sweep, which
+// drops the flushed chunks, and extended by the samples appended meanwhile.
+// The bounds may be wider than the samples, but never narrower.
+type memoryTimeRange struct {
+	// Serialises the sweeps recomputing the bounds.
+	sweepMtx sync.Mutex
+
+	mtx                           sync.Mutex
+	from, through                 model.Time
+	sweeping                      bool
+	appendedFrom, appendedThrough model.Time
+}
+
+func newMemoryTimeRange() memoryTimeRange {
+	return memoryTimeRange{from: model.Latest, through: model.Earliest}
+}
+
+// extend extends the bounds to [from, through], once the samples within it
+// have been appended.
+func (r *memoryTimeRange) extend(from, through model.Time) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.from, r.through = minTime(r.from, from), maxTime(r.through, through)
+	if r.sweeping {
+		r.appendedFrom, r.appendedThrough = minTime(r.appendedFrom, from), maxTime(r.appendedThrough, through)
+	}
+}
+
+// startSweep starts recomputing the bounds, tracking the samples appended
+// until the sweep ends. The series must be walked after it.
+func (r *memoryTimeRange) startSweep() {
+	r.sweepMtx.Lock()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.sweeping = true
+	r.appendedFrom, r.appendedThrough = model.Latest, model.Earliest
+}
+
+// endSweep sets the bounds to those of the series walked by the sweep, and
+// of the samples appended meanwhile.
+func (r *memoryTimeRange) endSweep(from, through model.Time) {
+	defer r.sweepMtx.Unlock()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.from, r.through = minTime(from, r.appendedFrom), maxTime(through, r.appendedThrough)
+	r.sweeping = false
+}
+
+// within returns true if all the in-memory samples are within [from, through].
+func (r *memoryTimeRange) within(from, through model.Time) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return from <= r.from && through >= r.through
+}
+
+// overlaps returns true if [from, through] overlaps the in-memory samples.
+func (r *memoryTimeRange) overlaps(from, through model.Time) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return from <= r.through && through >= r.from
+}
+
+func minTime(a, b model.Time) model.Time {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b model.Time) model.Time {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (u *userState) getSeries(metric labelPairs, creating *time.Duration) (model.Fingerprint, *memorySeries, error) {
 	rawFP := client.FastFingerprint(metric)
 	u.fpLocker.Lock(rawFP)
 	fp := u.mapper.mapFP(rawFP, metric)
@@ -185,15 +326,25 @@ func (u *userState) getSeries(metric labelPairs) (model.Fingerprint, *memorySeri
 		return fp, series, nil
 	}
 
+	if creating != nil {
+		start := time.Now()
+		defer func() { *creating += time.Since(start) }()
+	}
+
 	// There's theoretically a relatively harmless race here if multiple
 	// goroutines get the length of the series map at the same time, then
 	// all proceed to add a new series. This is likely not worth addressing,
 	// as this should happen rarely (all samples from one push are added
 	// serially), and the overshoot in allowed series would be minimal.
-	if u.fpToSeries.length() >= u.limits.MaxSeriesPerUser(u.userID) {
+	if err := u.limiter.AssertMaxSeriesPerUser(u.userID, u.fpToSeries.length()); err != nil {
 		u.fpLocker.Unlock(fp)
 		u.discardedSamples.WithLabelValues(perUserSeriesLimit).Inc()
-		return fp, nil, httpgrpc.Errorf(http.StatusTooManyRequests, "per-user series limit (%d) exceeded", u.limits.MaxSeriesPerUser(u.userID))
+		return fp, nil, httpgrpc.Errorf(http.StatusTooManyRequests, "%s", err.Error())
+	}
+
+	if max := u.instanceLimits.MaxInMemorySeries; max > 0 && atomic.LoadInt64(u.instanceSeries) >= max {
+		u.fpLocker.Unlock(fp)
+		return fp, nil, errMaxSeriesLimitReached
 	}
 
 	metricName, err := extract.MetricNameFromLabelAdapters(metric)
@@ -202,32 +353,92 @@ func (u *userState) getSeries(metric labelPairs) (model.Fingerprint, *memorySeri
 		return fp, nil, err
 	}
 
-	if !u.canAddSeriesFor(string(metricName)) {
+	if err := u.canAddSeriesFor(string(metricName)); err != nil {
 		u.fpLocker.Unlock(fp)
 		u.discardedSamples.WithLabelValues(perMetricSeriesLimit).Inc()
-		return fp, nil, httpgrpc.Errorf(http.StatusTooManyRequests, "per-metric series limit (%d) exceeded for %s: %s", u.limits.MaxSeriesPerMetric(u.userID), metricName, metric)
+		return fp, nil, httpgrpc.Errorf(http.StatusTooManyRequests, "%s for %s: %s", err.Error(), metricName, metric)
 	}
 
 	u.memSeriesCreatedTotal.Inc()
 	memSeries.Inc()
+	atomic.AddInt64(u.instanceSeries, 1)
 
 	labels := u.index.Add(metric, fp)
-	series = newMemorySeries(labels)
+	series = newMemorySeries(labels, u.chunkEncoding())
+	series.ephemeral = u.isEphemeral(labels)
 	u.fpToSeries.put(fp, series)
 
 	return fp, series, nil
 }
 
-func (u *userState) canAddSeriesFor(metric string) bool {
+// chunkEncoding returns the encoding to use for the new chunks of this tenant.
+func (u *userState) chunkEncoding() encoding.Encoding {
+	enc := encoding.DefaultEncoding
+	if name := u.limits.ChunkEncoding(u.userID); name != "" {
+		// Overrides are validated when loaded, so this can't fail.
+		if err := enc.Set(name); err != nil {
+			return encoding.DefaultEncoding
+		}
+	}
+	return enc
+}
+
+// isEphemeral returns whether a series matches one of the ephemeral series
+// selectors of this tenant.
+func (u *userState) isEphemeral(metric labels.Labels) bool {
+	selectors := u.limits.EphemeralSeriesSelectors(u.userID)
+	if len(selectors) == 0 {
+		return false
+	}
+
+	u.ephemeralMtx.Lock()
+	if !stringsEqual(selectors, u.ephemeralSelectors) {
+		u.ephemeralSelectors = selectors
+		u.ephemeralMatchers = u.ephemeralMatchers[:0]
+		for _, selector := range selectors {
+			// Overrides are validated when loaded, so this can't fail.
+			if matchers, err := promql.ParseMetricSelector(selector); err == nil {
+				u.ephemeralMatchers = append(u.ephemeralMatchers, matchers)
+			}
+		}
+	}
+	all := u.ephemeralMatchers
+	u.ephemeralMtx.Unlock()
+
+outer:
+	for _, matchers := range all {
+		for _, m := range matchers {
+			if !m.Matches(metric.Get(m.Name)) {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *userState) canAddSeriesFor(metric string) error {
 	shard := &u.seriesInMetric[util.HashFP(model.Fingerprint(fnv1a.HashString64(string(metric))))%metricCounterShards]
 	shard.mtx.Lock()
 	defer shard.mtx.Unlock()
 
-	if shard.m[metric] >= u.limits.MaxSeriesPerMetric(u.userID) {
-		return false
+	if err := u.limiter.AssertMaxSeriesPerMetric(u.userID, shard.m[metric]); err != nil {
+		return err
 	}
 	shard.m[metric]++
-	return true
+	return nil
 }
 
 func (u *userState) removeSeries(fp model.Fingerprint, metric labels.Labels) {
@@ -252,6 +463,7 @@ func (u *userState) removeSeries(fp model.Fingerprint, metric labels.Labels) {
 
 	u.memSeriesRemovedTotal.Inc()
 	memSeries.Dec()
+	atomic.AddInt64(u.instanceSeries, -1)
 }
 
 // forSeriesMatching passes all series matching the given matchers to the
@@ -262,53 +474,102 @@ func (u *userState) removeSeries(fp model.Fingerprint, metric labels.Labels) {
 // - The `send` callback is called at certain intervals specified by batchSize
 //   with no locks held, and is intended to be used by the caller to send the
 //   built batches.
+//
+// A chunk.QueryShardLabel matcher selects a shard of the matching series.
 func (u *userState) forSeriesMatching(ctx context.Context, allMatchers []*labels.Matcher,
 	add func(context.Context, model.Fingerprint, *memorySeries) error,
 	send func(context.Context) error, batchSize int,
+) error {
+	return u.forSeriesMatchingConcurrently(ctx, allMatchers, add, send, batchSize, 1, 0)
+}
+
+// forSeriesOverlapping calls f with each series matching the matchers having
+// samples between from and through.
+func (u *userState) forSeriesOverlapping(ctx context.Context, matchers []*labels.Matcher, from, through model.Time, f func(*memorySeries)) error {
+	return u.forSeriesMatching(ctx, matchers, func(_ context.Context, _ model.Fingerprint, series *memorySeries) error {
+		if series.overlaps(from, through) {
+			f(series)
+		}
+		return nil
+	}, nil, 0)
+}
+
+// anyValueOverlapping returns true if any of the series having a label named
+// name has samples between from and through.
+func (u *userState) anyValueOverlapping(name string, from, through model.Time) bool {
+	for _, v := range u.index.LabelValues(name) {
+		if u.anyOverlapping(name, v, from, through) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyOverlapping returns true if any of the series having the label has
+// samples between from and through. It stops at the first one found.
+func (u *userState) anyOverlapping(name, value string, from, through model.Time) bool {
+	for _, fp := range u.index.Lookup([]*labels.Matcher{{Type: labels.MatchEqual, Name: name, Value: value}}) {
+		u.fpLocker.Lock(fp)
+		series, ok := u.fpToSeries.get(fp)
+		overlaps := ok && series.overlaps(from, through)
+		u.fpLocker.Unlock(fp)
+		if overlaps {
+			return true
+		}
+	}
+	return false
+}
+
+// forSeriesMatchingConcurrently is like forSeriesMatching, but when the matchers
+// select more than minSeries series, they are walked by up to parallelism
+// goroutines. In that case add must be safe for concurrent use: it is called
+// while holding a read lock, and send is called with the write lock held, so
+// send never runs concurrently with add. As series are added while a batch is
+// being sent, batches may then hold more than batchSize series.
+func (u *userState) forSeriesMatchingConcurrently(ctx context.Context, allMatchers []*labels.Matcher,
+	add func(context.Context, model.Fingerprint, *memorySeries) error,
+	send func(context.Context) error, batchSize int,
+	parallelism, minSeries int,
 ) error {
 	log, ctx := spanlogger.New(ctx, "forSeriesMatching")
 	defer log.Finish()
 
+	shard, allMatchers, err := chunk.ExtractQueryShard(allMatchers)
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, "%v", err)
+	}
+
 	filters, matchers := util.SplitFiltersAndMatchers(allMatchers)
 	fps := u.index.Lookup(matchers)
 	if len(fps) > u.limits.MaxSeriesPerQuery(u.userID) {
-		return httpgrpc.Errorf(http.StatusRequestEntityTooLarge, "exceeded maximum number of series in a query")
+		return httpgrpc.Errorf(http.StatusUnprocessableEntity, "exceeded maximum number of series in a query")
 	}
 
 	level.Debug(log).Log("series", len(fps))
 
+	if parallelism > 1 && len(fps) > minSeries {
+		level.Debug(log).Log("parallelism", parallelism)
+		return u.forSeriesParallel(ctx, fps, shard, filters, add, send, batchSize, parallelism)
+	}
+
 	// We only hold one FP lock at once here, so no opportunity to deadlock.
 	sent := 0
-outer:
 	for _, fp := range fps {
 		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		u.fpLocker.Lock(fp)
-		series, ok := u.fpToSeries.get(fp)
-		if !ok {
-			u.fpLocker.Unlock(fp)
-			continue
-		}
-
-		for _, filter := range filters {
-			if !filter.Matches(series.metric.Get(filter.Name)) {
-				u.fpLocker.Unlock(fp)
-				continue outer
-			}
-		}
-
-		err := add(ctx, fp, series)
-		u.fpLocker.Unlock(fp)
+		ok, err := u.addSeries(ctx, fp, shard, filters, add)
 		if err != nil {
 			return err
+		} else if !ok {
+			continue
 		}
 
 		sent++
 		if batchSize > 0 && sent%batchSize == 0 && send != nil {
 			if err = send(ctx); err != nil {
-				return nil
+				return err
 			}
 		}
 	}
@@ -318,3 +579,102 @@ outer:
 	}
 	return nil
 }
+
+func (u *userState) forSeriesParallel(ctx context.Context, fps []model.Fingerprint, shard *chunk.QueryShard, filters []*labels.Matcher,
+	add func(context.Context, model.Fingerprint, *memorySeries) error,
+	send func(context.Context) error, batchSize int,
+	parallelism int,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		// Workers hold the read lock while adding series, and take the write
+		// lock to send a batch, so partial results are streamed as they come.
+		sendMtx  sync.RWMutex
+		next     int64 = -1
+		sent     int64
+		errOnce  sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	wg.Add(parallelism)
+	for w := 0; w < parallelism; w++ {
+		go func() {
+			defer wg.Done()
+
+			for {
+				idx := atomic.AddInt64(&next, 1)
+				if idx >= int64(len(fps)) {
+					return
+				}
+				if err := ctx.Err(); err != nil {
+					fail(err)
+					return
+				}
+
+				sendMtx.RLock()
+				ok, err := u.addSeries(ctx, fps[idx], shard, filters, add)
+				sendMtx.RUnlock()
+				if err != nil {
+					fail(err)
+					return
+				} else if !ok {
+					continue
+				}
+
+				n := atomic.AddInt64(&sent, 1)
+				if batchSize > 0 && n%int64(batchSize) == 0 && send != nil {
+					sendMtx.Lock()
+					err := send(ctx)
+					sendMtx.Unlock()
+					if err != nil {
+						fail(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if batchSize > 0 && sent%int64(batchSize) > 0 && send != nil {
+		return send(ctx)
+	}
+	return nil
+}
+
+// addSeries calls add for the series with the given fingerprint, if it still
+// exists and matches the filters. It returns whether add was called.
+func (u *userState) addSeries(ctx context.Context, fp model.Fingerprint, shard *chunk.QueryShard, filters []*labels.Matcher,
+	add func(context.Context, model.Fingerprint, *memorySeries) error,
+) (bool, error) {
+	u.fpLocker.Lock(fp)
+	defer u.fpLocker.Unlock(fp)
+
+	series, ok := u.fpToSeries.get(fp)
+	if !ok {
+		return false, nil
+	}
+
+	for _, filter := range filters {
+		if !filter.Matches(series.metric.Get(filter.Name)) {
+			return false, nil
+		}
+	}
+	if !shard.MatchesLabels(series.metric) {
+		return false, nil
+	}
+
+	return true, add(ctx, fp, series)
+}
@@ -72,3 +72,30 @@ func TestForSeriesMatchingBatching(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryTimeRange(t *testing.T) {
+	r := newMemoryTimeRange()
+	require.True(t, r.within(0, 0))
+	require.False(t, r.overlaps(0, math.MaxInt64))
+
+	r.extend(100, 200)
+	r.extend(150, 250)
+	require.True(t, r.within(100, 250))
+	require.False(t, r.within(101, 250))
+	require.True(t, r.overlaps(250, 300))
+	require.False(t, r.overlaps(251, 300))
+
+	// The samples appended during a sweep extend the bounds it recomputes.
+	r.startSweep()
+	require.True(t, r.within(100, 250))
+	r.extend(300, 300)
+	r.endSweep(200, 250)
+	require.True(t, r.within(200, 300))
+	require.False(t, r.within(201, 300))
+	require.False(t, r.overlaps(0, 199))
+
+	// A sweep of no series, without appends, empties the bounds.
+	r.startSweep()
+	r.endSweep(model.Latest, model.Earliest)
+	require.False(t, r.overlaps(0, math.MaxInt64))
+}
@@ -0,0 +1,107 @@
+package querier
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+var blockedQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cortex",
+	Name:      "querier_blocked_queries_total",
+	Help:      "The total number of queries rejected as blocked by the limits of the tenant.",
+}, []string{"user"})
+
+// BlockedQueriesLimits are the per-tenant limits used to reject queries.
+type BlockedQueriesLimits interface {
+	BlockedQueries(string) []validation.BlockedQuery
+}
+
+// BlockedQueriesMiddleware rejects the /query and /query_range requests whose
+// query, and the /series, /labels and /label/<name>/values requests any of
+// whose match[] selectors, is blocked by the limits of the tenant, or of any
+// of the tenants of a federated query, before the query is run.
+func BlockedQueriesMiddleware(limits BlockedQueriesLimits, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isQuery := strings.HasSuffix(r.URL.Path, "/query") || strings.HasSuffix(r.URL.Path, "/query_range")
+		isMetadata := strings.HasSuffix(r.URL.Path, "/series") || strings.HasSuffix(r.URL.Path, "/labels") || strings.HasSuffix(r.URL.Path, "/values")
+		if !isQuery && !isMetadata {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		orgID, err := user.ExtractOrgID(r.Context())
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+
+		queries := []string{r.FormValue("query")}
+		if isMetadata {
+			queries = r.Form["match[]"]
+		}
+		now := time.Now()
+		for _, userID := range strings.Split(orgID, TenantSeparator) {
+			blocked := limits.BlockedQueries(userID)
+			for _, query := range queries {
+				if q, ok := findBlockedQuery(blocked, query, now); ok {
+					blockedQueries.WithLabelValues(userID).Inc()
+					writeAPIError(w, http.StatusBadRequest, "bad_data", blockedQueryError(q))
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// findBlockedQuery returns the first of the blocked queries matching the query
+// at the given time. Exact patterns are compared to the query once both are
+// formatted, so that the spacing of the query doesn't matter.
+func findBlockedQuery(blocked []validation.BlockedQuery, query string, now time.Time) (validation.BlockedQuery, bool) {
+	formatted := formatQuery(query)
+	for _, q := range blocked {
+		if !q.Until.IsZero() && now.After(q.Until) {
+			continue
+		}
+
+		if q.Regex {
+			re, err := q.Regexp()
+			if err == nil && re.MatchString(query) {
+				return q, true
+			}
+			continue
+		}
+		if query == q.Pattern || formatted == formatQuery(q.Pattern) {
+			return q, true
+		}
+	}
+	return validation.BlockedQuery{}, false
+}
+
+func formatQuery(query string) string {
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		return query
+	}
+	return expr.String()
+}
+
+func blockedQueryError(q validation.BlockedQuery) error {
+	if q.Reason == "" {
+		return fmt.Errorf("the query is blocked by the limits of the tenant")
+	}
+	return fmt.Errorf("the query is blocked by the limits of the tenant: %s", q.Reason)
+}
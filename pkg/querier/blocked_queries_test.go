@@ -0,0 +1,127 @@
+package querier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+type blockedQueriesLimitsMock map[string][]validation.BlockedQuery
+
+func (m blockedQueriesLimitsMock) BlockedQueries(userID string) []validation.BlockedQuery {
+	return m[userID]
+}
+
+func TestBlockedQueriesMiddleware(t *testing.T) {
+	limits := blockedQueriesLimitsMock{
+		"a": {
+			{Pattern: `sum(rate(foo[5m]))`, Reason: "fix the dashboard"},
+			{Pattern: `.*bar.*`, Regex: true},
+			{Pattern: `baz`, Until: time.Now().Add(-time.Hour)},
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := BlockedQueriesMiddleware(limits, next)
+
+	for _, tc := range []struct {
+		orgID, path, query string
+		param              string // "query" if empty.
+		code               int
+		expected           string
+	}{
+		{
+			orgID: "a",
+			path:  "/api/v1/query_range",
+			query: `sum( rate(foo[5m]) )`,
+			code:  http.StatusBadRequest,
+			expected: `{"status":"error","errorType":"bad_data",` +
+				`"error":"the query is blocked by the limits of the tenant: fix the dashboard"}`,
+		},
+		{
+			orgID:    "a",
+			path:     "/api/v1/query",
+			query:    `count(bar)`,
+			code:     http.StatusBadRequest,
+			expected: `{"status":"error","errorType":"bad_data","error":"the query is blocked by the limits of the tenant"}`,
+		},
+		{
+			// b|a is blocked by the limits of a.
+			orgID: "b|a",
+			path:  "/api/v1/query",
+			query: `count(bar)`,
+			code:  http.StatusBadRequest,
+		},
+		{
+			orgID: "b",
+			path:  "/api/v1/query",
+			query: `count(bar)`,
+			code:  http.StatusOK,
+		},
+		{
+			// The blocking has expired.
+			orgID: "a",
+			path:  "/api/v1/query",
+			query: `baz`,
+			code:  http.StatusOK,
+		},
+		{
+			orgID: "a",
+			path:  "/api/v1/query",
+			query: `sum(rate(foo[1m]))`,
+			code:  http.StatusOK,
+		},
+		{
+			// The metadata requests check their match[] selectors only.
+			orgID: "a",
+			path:  "/api/v1/series",
+			query: `bar`,
+			code:  http.StatusOK,
+		},
+		{
+			orgID: "a",
+			path:  "/api/v1/series",
+			query: `bar`,
+			param: "match[]",
+			code:  http.StatusBadRequest,
+		},
+		{
+			orgID: "a",
+			path:  "/api/v1/label/job/values",
+			query: `{job="bar"}`,
+			param: "match[]",
+			code:  http.StatusBadRequest,
+		},
+		{
+			orgID: "a",
+			path:  "/api/v1/labels",
+			query: `foo`,
+			param: "match[]",
+			code:  http.StatusOK,
+		},
+	} {
+		t.Run(tc.orgID+tc.path+tc.query, func(t *testing.T) {
+			param := tc.param
+			if param == "" {
+				param = "query"
+			}
+			req := httptest.NewRequest("GET", tc.path+"?"+url.QueryEscape(param)+"="+url.QueryEscape(tc.query), nil)
+			req = req.WithContext(user.InjectOrgID(req.Context(), tc.orgID))
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.code, resp.Code)
+			if tc.expected != "" {
+				assert.JSONEq(t, tc.expected, resp.Body.String())
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package querier
 
 import (
 	"context"
+	"sync"
 
 	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/prometheus/common/model"
@@ -11,6 +12,7 @@ import (
 	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/util/extract"
 )
 
 type chunkIteratorFunc func(chunks []chunk.Chunk, from, through model.Time) storage.SeriesIterator
@@ -39,10 +41,16 @@ func (q *chunkStoreQuerier) Select(sp *storage.SelectParams, matchers ...*labels
 	if err != nil {
 		return nil, nil, err
 	}
+	if sp == nil {
+		return q.metadataSelect(userID, matchers...)
+	}
 	chunks, err := q.store.Get(q.ctx, userID, model.Time(sp.Start), model.Time(sp.End), matchers...)
 	if err != nil {
 		return nil, nil, promql.ErrStorage{Err: err}
 	}
+	if err := queryLimiterFromContext(q.ctx).addStoreChunks(chunks); err != nil {
+		return nil, nil, err
+	}
 
 	return q.partitionChunks(chunks), nil, nil
 }
@@ -54,10 +62,11 @@ func (q *chunkStoreQuerier) partitionChunks(chunks []chunk.Chunk) storage.Series
 		chunksBySeries[fp] = append(chunksBySeries[fp], c)
 	}
 
+	interner := labelInternerFromContext(q.ctx)
 	series := make([]storage.Series, 0, len(chunksBySeries))
 	for i := range chunksBySeries {
 		series = append(series, &chunkSeries{
-			labels:            chunksBySeries[i][0].Metric,
+			labels:            interner.intern(chunksBySeries[i][0].Metric),
 			chunks:            chunksBySeries[i],
 			chunkIteratorFunc: q.chunkIteratorFunc,
 			mint:              q.mint,
@@ -68,6 +77,79 @@ func (q *chunkStoreQuerier) partitionChunks(chunks []chunk.Chunk) storage.Series
 	return newConcreteSeriesSet(series)
 }
 
+// metadataSelect returns the series matching the matchers, without their
+// samples, looked up in the index of the store.
+func (q *chunkStoreQuerier) metadataSelect(userID string, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	series, err := getSeries(q.ctx, q.store, userID, model.Time(q.mint), model.Time(q.maxt), matchers...)
+	if err != nil {
+		return nil, nil, promql.ErrStorage{Err: err}
+	}
+
+	limiter := queryLimiterFromContext(q.ctx)
+	result := make([]storage.Series, 0, len(series))
+	for _, ls := range series {
+		if err := limiter.addSeries(client.Fingerprint(ls)); err != nil {
+			return nil, nil, err
+		}
+		result = append(result, newConcreteSeries(ls, nil))
+	}
+	return newConcreteSeriesSet(result), nil, nil
+}
+
+// LabelNamesFor implements labelsQuerier. The label names of a metric are
+// looked up in the index of the store, which can't be enumerated without
+// matchers.
+func (q *chunkStoreQuerier) LabelNamesFor(matchers ...*labels.Matcher) ([]string, error) {
+	userID, err := user.ExtractOrgID(q.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, nil
+	}
+
+	metricName, others, ok := extract.MetricNameMatcherFromMatchers(matchers)
+	if !ok || metricName.Type != labels.MatchEqual || len(others) > 0 {
+		series, err := selectSeries(q, matchers...)
+		if err != nil {
+			return nil, err
+		}
+		return labelNamesOf(series), nil
+	}
+	names, err := getLabelNames(q.ctx, q.store, userID, model.Time(q.mint), model.Time(q.maxt), metricName.Value)
+	if err != nil {
+		return nil, promql.ErrStorage{Err: err}
+	}
+	return names, nil
+}
+
+// LabelValuesFor implements labelsQuerier. The values of a label of the series
+// of a metric are looked up in the index of the store, only fetching chunks
+// for the matchers which can't be.
+func (q *chunkStoreQuerier) LabelValuesFor(name string, matchers ...*labels.Matcher) ([]string, error) {
+	userID, err := user.ExtractOrgID(q.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, nil
+	}
+
+	metricName, others, ok := extract.MetricNameMatcherFromMatchers(matchers)
+	if !ok || metricName.Type != labels.MatchEqual {
+		series, err := selectSeries(q, matchers...)
+		if err != nil {
+			return nil, err
+		}
+		return labelValuesOf(series, name), nil
+	}
+	values, err := getLabelValues(q.ctx, q.store, userID, model.Time(q.mint), model.Time(q.maxt), metricName.Value, name, others...)
+	if err != nil {
+		return nil, promql.ErrStorage{Err: err}
+	}
+	return values, nil
+}
+
 func (q *chunkStoreQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
 	return nil, nil, nil
 }
@@ -95,3 +177,191 @@ func (s *chunkSeries) Labels() labels.Labels {
 func (s *chunkSeries) Iterator() storage.SeriesIterator {
 	return s.chunkIteratorFunc(s.chunks, model.Time(s.mint), model.Time(s.maxt))
 }
+
+// seriesStore is implemented by the stores which look up the series matching
+// some matchers without fetching all of their chunks.
+type seriesStore interface {
+	GetSeries(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]labels.Labels, error)
+}
+
+// labelValuesStore is implemented by the stores which look up the values of a
+// label of the series of a metric in their index.
+type labelValuesStore interface {
+	LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName, labelName string, matchers ...*labels.Matcher) ([]string, error)
+}
+
+// labelNamesStore is implemented by the stores which look up the label names
+// of the series of a metric in their index.
+type labelNamesStore interface {
+	LabelNamesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string) ([]string, error)
+}
+
+// getSeries returns the labels of the series of the store matching the
+// matchers, from the chunks of the store if it can't look them up otherwise.
+func getSeries(ctx context.Context, store ChunkStore, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]labels.Labels, error) {
+	if s, ok := store.(seriesStore); ok {
+		return s.GetSeries(ctx, userID, from, through, matchers...)
+	}
+
+	chunks, err := store.Get(ctx, userID, from, through, matchers...)
+	if err != nil {
+		return nil, err
+	}
+	series := map[model.Fingerprint]labels.Labels{}
+	for _, c := range chunks {
+		series[client.Fingerprint(c.Metric)] = c.Metric
+	}
+	result := make([]labels.Labels, 0, len(series))
+	for _, ls := range series {
+		result = append(result, ls)
+	}
+	return result, nil
+}
+
+// getLabelValues returns the values of a label of the series of a metric of
+// the store matching the matchers.
+func getLabelValues(ctx context.Context, store ChunkStore, userID string, from, through model.Time, metricName, labelName string, matchers ...*labels.Matcher) ([]string, error) {
+	if s, ok := store.(labelValuesStore); ok {
+		return s.LabelValuesForMetricName(ctx, userID, from, through, metricName, labelName, matchers...)
+	}
+
+	series, err := getSeries(ctx, store, userID, from, through, chunk.WithMetricName(metricName, matchers)...)
+	if err != nil {
+		return nil, err
+	}
+	return labelValuesOf(series, labelName), nil
+}
+
+// getLabelNames returns the label names of the series of a metric of the store.
+func getLabelNames(ctx context.Context, store ChunkStore, userID string, from, through model.Time, metricName string) ([]string, error) {
+	if s, ok := store.(labelNamesStore); ok {
+		return s.LabelNamesForMetricName(ctx, userID, from, through, metricName)
+	}
+
+	series, err := getSeries(ctx, store, userID, from, through, chunk.WithMetricName(metricName, nil)...)
+	if err != nil {
+		return nil, err
+	}
+	return labelNamesOf(series), nil
+}
+
+// NewMultiChunkStore returns a ChunkStore of the chunks of all the stores,
+// e.g. the chunk store and the blocks queried through the store-gateways,
+// queried in parallel.
+func NewMultiChunkStore(stores ...ChunkStore) ChunkStore {
+	return multiChunkStore(stores)
+}
+
+type multiChunkStore []ChunkStore
+
+func (m multiChunkStore) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]chunk.Chunk, error) {
+	type result struct {
+		chunks []chunk.Chunk
+		err    error
+	}
+	results := make(chan result, len(m))
+	for _, store := range m {
+		go func(store ChunkStore) {
+			chunks, err := store.Get(ctx, userID, from, through, matchers...)
+			results <- result{chunks, err}
+		}(store)
+	}
+
+	var (
+		chunks []chunk.Chunk
+		err    error
+	)
+	for range m {
+		r := <-results
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+		chunks = append(chunks, r.chunks...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// GetSeries implements seriesStore.
+func (m multiChunkStore) GetSeries(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]labels.Labels, error) {
+	var (
+		mtx    sync.Mutex
+		series = map[string]labels.Labels{}
+	)
+	err := m.forEach(func(store ChunkStore) error {
+		ss, err := getSeries(ctx, store, userID, from, through, matchers...)
+		if err != nil {
+			return err
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, ls := range ss {
+			series[ls.String()] = ls
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]labels.Labels, 0, len(series))
+	for _, ls := range series {
+		result = append(result, ls)
+	}
+	return result, nil
+}
+
+// LabelValuesForMetricName implements labelValuesStore.
+func (m multiChunkStore) LabelValuesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName, labelName string, matchers ...*labels.Matcher) ([]string, error) {
+	return m.mergeStrings(func(store ChunkStore) ([]string, error) {
+		return getLabelValues(ctx, store, userID, from, through, metricName, labelName, matchers...)
+	})
+}
+
+// LabelNamesForMetricName implements labelNamesStore.
+func (m multiChunkStore) LabelNamesForMetricName(ctx context.Context, userID string, from, through model.Time, metricName string) ([]string, error) {
+	return m.mergeStrings(func(store ChunkStore) ([]string, error) {
+		return getLabelNames(ctx, store, userID, from, through, metricName)
+	})
+}
+
+func (m multiChunkStore) mergeStrings(f func(ChunkStore) ([]string, error)) ([]string, error) {
+	var (
+		mtx    sync.Mutex
+		values = map[string]struct{}{}
+	)
+	err := m.forEach(func(store ChunkStore) error {
+		vs, err := f(store)
+		if err != nil {
+			return err
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		addStrings(values, vs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortedStrings(values), nil
+}
+
+// forEach calls f for all the stores in parallel, and returns the first error.
+func (m multiChunkStore) forEach(f func(ChunkStore) error) error {
+	errs := make(chan error, len(m))
+	for _, store := range m {
+		go func(store ChunkStore) {
+			errs <- f(store)
+		}(store)
+	}
+
+	var err error
+	for range m {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
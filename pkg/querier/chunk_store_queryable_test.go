@@ -28,11 +28,33 @@ func TestChunkQueryable(t *testing.T) {
 	}
 }
 
+func TestMultiChunkStore(t *testing.T) {
+	store, _ := makeMockChunkStore(t, 2, promchunk.Bigchunk)
+	other, _ := makeMockChunkStore(t, 3, promchunk.Bigchunk)
+
+	chunks, err := NewMultiChunkStore(store, other).Get(context.Background(), "user", 0, model.Latest)
+	require.NoError(t, err)
+	require.Len(t, chunks, 5)
+
+	_, err = NewMultiChunkStore(store, errChunkStore{}).Get(context.Background(), "user", 0, model.Latest)
+	require.Error(t, err)
+}
+
+type errChunkStore struct{}
+
+func (errChunkStore) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]chunk.Chunk, error) {
+	return nil, fmt.Errorf("store unavailable")
+}
+
 type mockChunkStore struct {
 	chunks []chunk.Chunk
 }
 
 func (m mockChunkStore) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]chunk.Chunk, error) {
+	// Like the chunk store, the limits are checked before fetching the chunks.
+	if err := chunk.LimitChunkRefs(ctx, m.chunks); err != nil {
+		return nil, err
+	}
 	return m.chunks, nil
 }
 
@@ -0,0 +1,233 @@
+package querier
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/purger"
+)
+
+// DeleteRequestsStore returns the delete requests of the tenants.
+type DeleteRequestsStore interface {
+	GetAllDeleteRequestsForUser(ctx context.Context, userID string) ([]purger.DeleteRequest, error)
+}
+
+// NewDeleteFilteringQueryable returns a queryable which drops the samples of
+// the delete requests of the tenants from the results of the given one, from
+// as soon as the store returns them, e.g. a purger.DeleteRequestsCache: the
+// purger deletes them from the chunk store later, and the ingesters may still
+// flush some.
+func NewDeleteFilteringQueryable(queryable storage.Queryable, store DeleteRequestsStore) storage.Queryable {
+	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		q, err := queryable.Querier(ctx, mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+
+		userID, err := user.ExtractOrgID(ctx)
+		if err != nil {
+			return q, nil
+		}
+		reqs, err := store.GetAllDeleteRequestsForUser(ctx, userID)
+		if err != nil {
+			_ = q.Close()
+			return nil, err
+		}
+
+		var tombstones []tombstone
+		for i := range reqs {
+			if int64(reqs[i].EndTime) < mint || int64(reqs[i].StartTime) > maxt {
+				continue
+			}
+			matchers, err := reqs[i].Matchers()
+			if err != nil {
+				_ = q.Close()
+				return nil, err
+			}
+			tombstones = append(tombstones, tombstone{
+				start:    reqs[i].StartTime,
+				end:      reqs[i].EndTime,
+				matchers: matchers,
+			})
+		}
+		if len(tombstones) == 0 {
+			return q, nil
+		}
+		return &deleteFilteringQuerier{Querier: q, tombstones: tombstones, mint: model.Time(mint), maxt: model.Time(maxt)}, nil
+	})
+}
+
+// tombstone is the interval deleted from the series matching any of the
+// matchers.
+type tombstone struct {
+	start, end model.Time
+	matchers   [][]*labels.Matcher
+}
+
+func (t tombstone) matches(lbls labels.Labels) bool {
+	for _, matchers := range t.matchers {
+		if matchesAll(matchers, lbls) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(matchers []*labels.Matcher, lbls labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// deleteFilteringQuerier drops the deleted samples from the series, and the
+// series left without samples. The metadata queries having no samples to
+// filter, it drops the series deleted over the whole range of the query from
+// them. It doesn't implement labelsQuerier: the
+// label names and values are those of the series which are left.
+type deleteFilteringQuerier struct {
+	storage.Querier
+	tombstones []tombstone
+	mint, maxt model.Time
+}
+
+// Select implements storage.Querier.
+func (q *deleteFilteringQuerier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	set, warnings, err := q.Querier.Select(sp, matchers...)
+	if err != nil {
+		return nil, warnings, err
+	}
+	if sp == nil {
+		return &deletedSeriesSet{SeriesSet: set, tombstones: q.tombstones, mint: q.mint, maxt: q.maxt}, warnings, nil
+	}
+	return &deleteFilteringSeriesSet{SeriesSet: set, tombstones: q.tombstones}, warnings, nil
+}
+
+// deletedSeriesSet skips the series whose samples are all deleted within the
+// range, the tombstones matching them covering all of it.
+type deletedSeriesSet struct {
+	storage.SeriesSet
+	tombstones []tombstone
+	mint, maxt model.Time
+}
+
+func (s *deletedSeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		if !s.isDeleted(s.SeriesSet.At().Labels()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *deletedSeriesSet) isDeleted(lbls labels.Labels) bool {
+	var deleted []tombstone
+	for _, t := range s.tombstones {
+		if t.matches(lbls) {
+			deleted = append(deleted, t)
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].start < deleted[j].start })
+
+	// The range is deleted if the intervals, ordered by start, cover it with
+	// no gap.
+	next := s.mint
+	for _, t := range deleted {
+		if t.start > next {
+			return false
+		}
+		if t.end >= s.maxt {
+			return true
+		}
+		if t.end+1 > next {
+			next = t.end + 1
+		}
+	}
+	return false
+}
+
+// deleteFilteringSeriesSet drops the deleted samples from the series, skipping
+// the series left without samples.
+type deleteFilteringSeriesSet struct {
+	storage.SeriesSet
+	tombstones []tombstone
+	cur        storage.Series
+}
+
+func (s *deleteFilteringSeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		series := s.SeriesSet.At()
+
+		var deleted []tombstone
+		for _, t := range s.tombstones {
+			if t.matches(series.Labels()) {
+				deleted = append(deleted, t)
+			}
+		}
+		if len(deleted) == 0 {
+			s.cur = series
+			return true
+		}
+
+		s.cur = &deleteFilteringSeries{Series: series, deleted: deleted}
+		if s.cur.Iterator().Next() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *deleteFilteringSeriesSet) At() storage.Series {
+	return s.cur
+}
+
+type deleteFilteringSeries struct {
+	storage.Series
+	deleted []tombstone
+}
+
+func (s *deleteFilteringSeries) Iterator() storage.SeriesIterator {
+	return &deleteFilteringIterator{SeriesIterator: s.Series.Iterator(), deleted: s.deleted}
+}
+
+// deleteFilteringIterator skips the samples of the deleted intervals.
+type deleteFilteringIterator struct {
+	storage.SeriesIterator
+	deleted []tombstone
+}
+
+func (it *deleteFilteringIterator) Seek(t int64) bool {
+	if !it.SeriesIterator.Seek(t) {
+		return false
+	}
+	if !it.isDeleted() {
+		return true
+	}
+	return it.Next()
+}
+
+func (it *deleteFilteringIterator) Next() bool {
+	for it.SeriesIterator.Next() {
+		if !it.isDeleted() {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *deleteFilteringIterator) isDeleted() bool {
+	ts, _ := it.SeriesIterator.At()
+	for _, t := range it.deleted {
+		if model.Time(ts) >= t.start && model.Time(ts) <= t.end {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package querier
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/purger"
+)
+
+type deleteRequestsStoreMock map[string][]purger.DeleteRequest
+
+func (m deleteRequestsStoreMock) GetAllDeleteRequestsForUser(_ context.Context, userID string) ([]purger.DeleteRequest, error) {
+	return m[userID], nil
+}
+
+type seriesQuerierMock struct {
+	tenantQuerierMock
+	series []storage.Series
+}
+
+func (q seriesQuerierMock) Select(_ *storage.SelectParams, _ ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	return newConcreteSeriesSet(q.series), nil, nil
+}
+
+func TestDeleteFilteringQueryable(t *testing.T) {
+	samples := []model.SamplePair{}
+	for ts := model.Time(0); ts < 10; ts++ {
+		samples = append(samples, model.SamplePair{Timestamp: ts, Value: model.SampleValue(ts)})
+	}
+	inner := storage.QueryableFunc(func(context.Context, int64, int64) (storage.Querier, error) {
+		return seriesQuerierMock{series: []storage.Series{
+			newConcreteSeries(labels.Labels{{Name: labels.MetricName, Value: "bar"}}, samples),
+			newConcreteSeries(labels.Labels{{Name: labels.MetricName, Value: "foo"}, {Name: "job", Value: "a"}}, samples),
+			newConcreteSeries(labels.Labels{{Name: labels.MetricName, Value: "baz"}}, samples),
+		}}, nil
+	})
+	store := deleteRequestsStoreMock{
+		"user": {
+			{StartTime: 2, EndTime: 3, Selectors: []string{`{job="a"}`}},
+			// All the samples of baz are deleted, so it isn't returned.
+			{StartTime: 0, EndTime: 9, Selectors: []string{`baz`}},
+			{StartTime: 5, EndTime: 7, Selectors: []string{`foo`, `baz`}},
+			// Outside of the time range of the query.
+			{StartTime: 20, EndTime: 30, Selectors: []string{`bar`}},
+		},
+	}
+	queryable := NewDeleteFilteringQueryable(inner, store)
+
+	querier, err := queryable.Querier(user.InjectOrgID(context.Background(), "user"), 0, 10)
+	require.NoError(t, err)
+	set, _, err := querier.Select(&storage.SelectParams{Start: 0, End: 10})
+	require.NoError(t, err)
+
+	actual := map[string][]int64{}
+	for set.Next() {
+		var ts []int64
+		it := set.At().Iterator()
+		for it.Next() {
+			t, _ := it.At()
+			ts = append(ts, t)
+		}
+		actual[set.At().Labels().Get(labels.MetricName)] = ts
+
+		// Seeking skips the deleted samples too.
+		it = set.At().Iterator()
+		if it.Seek(2) {
+			t, _ := it.At()
+			actual["seek_"+set.At().Labels().Get(labels.MetricName)] = []int64{t}
+		}
+	}
+	require.NoError(t, set.Err())
+	require.Equal(t, map[string][]int64{
+		"bar":      {0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		"foo":      {0, 1, 4, 8, 9},
+		"seek_bar": {2},
+		"seek_foo": {4},
+	}, actual)
+
+	// The queries of the tenants without delete requests aren't filtered.
+	querier, err = queryable.Querier(user.InjectOrgID(context.Background(), "other"), 0, 10)
+	require.NoError(t, err)
+	require.IsType(t, seriesQuerierMock{}, querier)
+}
+
+func TestDeleteFilteringQueryableMetadata(t *testing.T) {
+	inner := storage.QueryableFunc(func(context.Context, int64, int64) (storage.Querier, error) {
+		return seriesQuerierMock{series: []storage.Series{
+			newConcreteSeries(labels.Labels{{Name: labels.MetricName, Value: "bar"}}, nil),
+			newConcreteSeries(labels.Labels{{Name: labels.MetricName, Value: "foo"}, {Name: "job", Value: "a"}}, nil),
+		}}, nil
+	})
+	store := deleteRequestsStoreMock{
+		"user": {
+			// All of the range of foo is deleted, across two requests.
+			{StartTime: 0, EndTime: 4, Selectors: []string{`foo`}},
+			{StartTime: 5, EndTime: 10, Selectors: []string{`{job="a"}`}},
+			// The end of the range of bar is left.
+			{StartTime: 0, EndTime: 9, Selectors: []string{`bar`}},
+		},
+	}
+	queryable := NewDeleteFilteringQueryable(inner, store)
+
+	querier, err := queryable.Querier(user.InjectOrgID(context.Background(), "user"), 0, 10)
+	require.NoError(t, err)
+	series, err := selectSeries(querier)
+	require.NoError(t, err)
+	require.Equal(t, []labels.Labels{{{Name: labels.MetricName, Value: "bar"}}}, series)
+}
@@ -17,8 +17,8 @@ import (
 type Distributor interface {
 	Query(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) (model.Matrix, error)
 	QueryStream(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) ([]client.TimeSeriesChunk, error)
-	LabelValuesForLabelName(context.Context, model.LabelName) ([]string, error)
-	LabelNames(context.Context) ([]string, error)
+	LabelValuesForLabelName(ctx context.Context, from, to model.Time, label model.LabelName, matchers ...*labels.Matcher) ([]string, error)
+	LabelNames(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) ([]string, error)
 	MetricsForLabelMatchers(ctx context.Context, from, through model.Time, matchers ...*labels.Matcher) ([]metric.Metric, error)
 }
 
@@ -40,27 +40,76 @@ type distributorQuerier struct {
 }
 
 func (q *distributorQuerier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
-	mint, maxt := q.mint, q.maxt
-	if sp != nil {
-		mint = sp.Start
-		maxt = sp.End
+	// Kludge: Prometheus passes nil SelectParams if it is doing a 'series' operation,
+	// which needs only metadata.
+	if sp == nil {
+		return q.metadataSelect(matchers...)
 	}
 
-	matrix, err := q.distributor.Query(q.ctx, model.Time(mint), model.Time(maxt), matchers...)
+	matrix, err := q.distributor.Query(q.ctx, model.Time(sp.Start), model.Time(sp.End), matchers...)
 	if err != nil {
 		return nil, nil, promql.ErrStorage{Err: err}
 	}
 
+	// The ingesters return samples rather than chunks: only the series and
+	// the estimated size of the samples are limited.
+	limiter := queryLimiterFromContext(q.ctx)
+	interner := labelInternerFromContext(q.ctx)
+	for _, stream := range matrix {
+		stream.Metric = interner.internMetric(stream.Metric)
+		if err := limiter.addSeries(stream.Metric.Fingerprint()); err != nil {
+			return nil, nil, err
+		}
+		if err := limiter.addChunks(0, len(stream.Values)*sampleSize); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	return matrixToSeriesSet(matrix), nil, nil
 }
 
+// metadataSelect returns the series of the ingesters matching the matchers,
+// without their samples.
+func (q *distributorQuerier) metadataSelect(matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	ms, err := q.distributor.MetricsForLabelMatchers(q.ctx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+	if err != nil {
+		return nil, nil, promql.ErrStorage{Err: err}
+	}
+
+	limiter := queryLimiterFromContext(q.ctx)
+	for _, m := range ms {
+		if err := limiter.addSeries(m.Metric.Fingerprint()); err != nil {
+			return nil, nil, err
+		}
+	}
+	return metricsToSeriesSet(ms), nil, nil
+}
+
+// LabelNamesFor implements labelsQuerier.
+func (q *distributorQuerier) LabelNamesFor(matchers ...*labels.Matcher) ([]string, error) {
+	ln, err := q.distributor.LabelNames(q.ctx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+	if err != nil {
+		return nil, promql.ErrStorage{Err: err}
+	}
+	return ln, nil
+}
+
+// LabelValuesFor implements labelsQuerier.
+func (q *distributorQuerier) LabelValuesFor(name string, matchers ...*labels.Matcher) ([]string, error) {
+	lv, err := q.distributor.LabelValuesForLabelName(q.ctx, model.Time(q.mint), model.Time(q.maxt), model.LabelName(name), matchers...)
+	if err != nil {
+		return nil, promql.ErrStorage{Err: err}
+	}
+	return lv, nil
+}
+
 func (q *distributorQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
-	lv, err := q.distributor.LabelValuesForLabelName(q.ctx, model.LabelName(name))
+	lv, err := q.distributor.LabelValuesForLabelName(q.ctx, model.Time(q.mint), model.Time(q.maxt), model.LabelName(name))
 	return lv, nil, err
 }
 
 func (q *distributorQuerier) LabelNames() ([]string, storage.Warnings, error) {
-	ln, err := q.distributor.LabelNames(q.ctx)
+	ln, err := q.distributor.LabelNames(q.ctx, model.Time(q.mint), model.Time(q.maxt))
 	return ln, nil, err
 }
 
@@ -8,6 +8,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/prom1/storage/metric"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
 )
 
@@ -35,7 +36,7 @@ func TestDistributorQuerier(t *testing.T) {
 	querier, err := queryable.Querier(context.Background(), mint, maxt)
 	require.NoError(t, err)
 
-	seriesSet, _, err := querier.Select(nil)
+	seriesSet, _, err := querier.Select(&storage.SelectParams{Start: mint, End: maxt})
 	require.NoError(t, err)
 
 	require.True(t, seriesSet.Next())
@@ -61,10 +62,10 @@ func (m *mockDistributor) Query(ctx context.Context, from, to model.Time, matche
 func (m *mockDistributor) QueryStream(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) ([]client.TimeSeriesChunk, error) {
 	return m.r, nil
 }
-func (m *mockDistributor) LabelValuesForLabelName(context.Context, model.LabelName) ([]string, error) {
+func (m *mockDistributor) LabelValuesForLabelName(context.Context, model.Time, model.Time, model.LabelName, ...*labels.Matcher) ([]string, error) {
 	return nil, nil
 }
-func (m *mockDistributor) LabelNames(context.Context) ([]string, error) {
+func (m *mockDistributor) LabelNames(context.Context, model.Time, model.Time, ...*labels.Matcher) ([]string, error) {
 	return nil, nil
 }
 func (m *mockDistributor) MetricsForLabelMatchers(ctx context.Context, from, through model.Time, matchers ...*labels.Matcher) ([]metric.Metric, error) {
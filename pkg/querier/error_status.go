@@ -0,0 +1,175 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/querier/errstatus"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// queryError records the first error of the storage of a query, for the
+// status code of the response, and whether its results are partial. A nil
+// queryError doesn't record anything.
+type queryError struct {
+	mtx     sync.Mutex
+	err     error
+	partial bool
+}
+
+type queryErrorKey int
+
+func withQueryError(ctx context.Context, e *queryError) context.Context {
+	return context.WithValue(ctx, queryErrorKey(0), e)
+}
+
+// queryErrorFromContext returns the error of the query; nil if none.
+func queryErrorFromContext(ctx context.Context) *queryError {
+	e, _ := ctx.Value(queryErrorKey(0)).(*queryError)
+	return e
+}
+
+func (e *queryError) record(err error) {
+	if e == nil || err == nil {
+		return
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *queryError) get() error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.err
+}
+
+// recordWarnings records the results as partial if there are warnings.
+func (e *queryError) recordWarnings(warnings storage.Warnings) {
+	if e == nil || len(warnings) == 0 {
+		return
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.partial = true
+}
+
+func (e *queryError) isPartial() bool {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.partial
+}
+
+// ErrorStatusMiddleware sets the status code of the failed responses of the
+// Prometheus API from the error of the storage which failed the query, see
+// errstatus.Code, rather than from the type of the error of the engine:
+// e.g. a query hitting a limit fails with 422, and a query failing because
+// the ingesters are unavailable with 503. The requests canceled by the client
+// fail with 499. The responses of the queries returning warnings, e.g.
+// partial results, have a Cache-Control: no-store header, so that the results
+// cache of the query frontend doesn't cache them.
+func ErrorStatusMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &queryError{}
+		r = r.WithContext(withQueryError(r.Context(), e))
+		next.ServeHTTP(&errorStatusResponseWriter{ResponseWriter: w, ctx: r.Context(), err: e}, r)
+	})
+}
+
+type errorStatusResponseWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+	err *queryError
+}
+
+func (w *errorStatusResponseWriter) WriteHeader(code int) {
+	if w.err.isPartial() {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if code >= http.StatusBadRequest {
+		if w.ctx.Err() == context.Canceled {
+			code = util.StatusClientClosedRequest
+		} else if err := w.err.get(); err != nil {
+			code = errstatus.Code(err)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// newErrorQueryable returns a queryable recording the errors of its queriers
+// in the error of the query, if any.
+func newErrorQueryable(queryable storage.Queryable) storage.Queryable {
+	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		e := queryErrorFromContext(ctx)
+		q, err := queryable.Querier(ctx, mint, maxt)
+		if err != nil || e == nil {
+			e.record(err)
+			return q, err
+		}
+		return errorQuerier{Querier: q, err: e}, nil
+	})
+}
+
+// errorQuerier records the errors of the selects, including those of the
+// series sets, returned once the series are iterated.
+type errorQuerier struct {
+	storage.Querier
+	err *queryError
+}
+
+func (q errorQuerier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	set, warnings, err := q.Querier.Select(sp, matchers...)
+	q.err.recordWarnings(warnings)
+	if err != nil {
+		q.err.record(err)
+		return set, warnings, err
+	}
+	return errorSeriesSet{SeriesSet: set, err: q.err}, warnings, nil
+}
+
+func (q errorQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
+	values, warnings, err := q.Querier.LabelValues(name)
+	q.err.recordWarnings(warnings)
+	q.err.record(err)
+	return values, warnings, err
+}
+
+func (q errorQuerier) LabelNames() ([]string, storage.Warnings, error) {
+	names, warnings, err := q.Querier.LabelNames()
+	q.err.recordWarnings(warnings)
+	q.err.record(err)
+	return names, warnings, err
+}
+
+// LabelNamesFor implements labelsQuerier.
+func (q errorQuerier) LabelNamesFor(matchers ...*labels.Matcher) ([]string, error) {
+	names, err := labelNamesFor(q.Querier, matchers...)
+	q.err.record(err)
+	return names, err
+}
+
+// LabelValuesFor implements labelsQuerier.
+func (q errorQuerier) LabelValuesFor(name string, matchers ...*labels.Matcher) ([]string, error) {
+	values, err := labelValuesFor(q.Querier, name, matchers...)
+	q.err.record(err)
+	return values, err
+}
+
+type errorSeriesSet struct {
+	storage.SeriesSet
+	err *queryError
+}
+
+func (s errorSeriesSet) Err() error {
+	err := s.SeriesSet.Err()
+	s.err.record(err)
+	return err
+}
@@ -0,0 +1,89 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+type failingQuerier struct {
+	storage.Querier
+	selectErr, setErr error
+	warnings          storage.Warnings
+}
+
+func (q failingQuerier) Select(*storage.SelectParams, ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	if q.selectErr != nil {
+		return nil, q.warnings, q.selectErr
+	}
+	return failingSeriesSet{err: q.setErr}, q.warnings, nil
+}
+
+type failingSeriesSet struct {
+	storage.SeriesSet
+	err error
+}
+
+func (failingSeriesSet) Next() bool {
+	return false
+}
+
+func (s failingSeriesSet) Err() error {
+	return s.err
+}
+
+func TestErrorStatusMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		selectErr, setErr error
+		warnings          storage.Warnings
+		cancel            bool
+		code              int
+		noStore           bool
+	}{
+		{name: "no error", code: http.StatusUnprocessableEntity},
+		{name: "limit", selectErr: util.LimitError("limit"), code: http.StatusUnprocessableEntity},
+		{name: "unavailable", selectErr: util.UnavailableError{Err: errors.New("empty ring")}, code: http.StatusServiceUnavailable},
+		{name: "series set", setErr: util.ConsistencyError("consistency"), code: http.StatusInternalServerError},
+		{name: "canceled", selectErr: context.Canceled, cancel: true, code: util.StatusClientClosedRequest},
+		{name: "warnings", warnings: storage.Warnings{errors.New("partial")}, code: http.StatusUnprocessableEntity, noStore: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			queryable := newErrorQueryable(storage.QueryableFunc(func(context.Context, int64, int64) (storage.Querier, error) {
+				return failingQuerier{selectErr: tc.selectErr, setErr: tc.setErr, warnings: tc.warnings}, nil
+			}))
+
+			handler := ErrorStatusMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				q, err := queryable.Querier(r.Context(), 0, 1)
+				assert.NoError(t, err)
+				if set, _, err := q.Select(&storage.SelectParams{}); err == nil {
+					for set.Next() {
+					}
+					_ = set.Err()
+				}
+				// The status code of the execution errors of the engine.
+				w.WriteHeader(http.StatusUnprocessableEntity)
+			}))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tc.cancel {
+				cancel()
+			} else {
+				defer cancel()
+			}
+			req := httptest.NewRequest("GET", "/api/v1/query", nil).WithContext(ctx)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assert.Equal(t, tc.code, resp.Code)
+			assert.Equal(t, tc.noStore, resp.Header().Get("Cache-Control") == "no-store")
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Package errstatus maps the errors of the read path to HTTP status codes.
+package errstatus
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/weaveworks/common/httpgrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// Code returns the HTTP status code of an error of the read path:
+// 422 for the limit errors, 500 for the consistency errors, 499 for the
+// canceled requests and 503 for the requests timing out or for which the
+// storage is unavailable. The errors built by httpgrpc.Errorf, including those
+// returned through gRPC, keep their status code; the other gRPC errors are
+// classified by their code. Any other error is an internal error.
+func Code(err error) int {
+	switch e := errors.Cause(err).(type) {
+	case util.LimitError:
+		return http.StatusUnprocessableEntity
+	case util.ConsistencyError:
+		return http.StatusInternalServerError
+	case util.UnavailableError:
+		return http.StatusServiceUnavailable
+	case promql.ErrStorage:
+		return Code(e.Err)
+	case promql.ErrQueryCanceled:
+		return util.StatusClientClosedRequest
+	case promql.ErrQueryTimeout:
+		return http.StatusServiceUnavailable
+	}
+
+	switch errors.Cause(err) {
+	case context.Canceled:
+		return util.StatusClientClosedRequest
+	case context.DeadlineExceeded:
+		return http.StatusServiceUnavailable
+	}
+
+	if resp, ok := httpgrpc.HTTPResponseFromError(errors.Cause(err)); ok {
+		return int(resp.Code)
+	}
+	if s, ok := status.FromError(errors.Cause(err)); ok {
+		switch s.Code() {
+		case codes.Canceled:
+			return util.StatusClientClosedRequest
+		case codes.DeadlineExceeded, codes.Unavailable:
+			return http.StatusServiceUnavailable
+		case codes.ResourceExhausted:
+			return http.StatusUnprocessableEntity
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// HTTPGRPCError returns the error as an httpgrpc error with the status code of
+// Code, so that the status code survives a gRPC boundary.
+func HTTPGRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := httpgrpc.HTTPResponseFromError(err); ok {
+		return err
+	}
+	return httpgrpc.Errorf(Code(err), "%s", err.Error())
+}
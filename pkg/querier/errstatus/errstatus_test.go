@@ -0,0 +1,49 @@
+package errstatus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/common/httpgrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+func TestCode(t *testing.T) {
+	for i, tc := range []struct {
+		err  error
+		code int
+	}{
+		{util.LimitError("limit"), http.StatusUnprocessableEntity},
+		{pkgerrors.Wrap(util.LimitError("limit"), "wrapped"), http.StatusUnprocessableEntity},
+		{util.ConsistencyError("consistency"), http.StatusInternalServerError},
+		{util.UnavailableError{Err: errors.New("empty ring")}, http.StatusServiceUnavailable},
+		{promql.ErrStorage{Err: util.LimitError("limit")}, http.StatusUnprocessableEntity},
+		{promql.ErrStorage{Err: errors.New("storage")}, http.StatusInternalServerError},
+		{promql.ErrQueryCanceled("canceled"), util.StatusClientClosedRequest},
+		{promql.ErrQueryTimeout("timeout"), http.StatusServiceUnavailable},
+		{context.Canceled, util.StatusClientClosedRequest},
+		{context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{httpgrpc.Errorf(http.StatusBadRequest, "bad request"), http.StatusBadRequest},
+		{status.Error(codes.Canceled, "canceled"), util.StatusClientClosedRequest},
+		{status.Error(codes.Unavailable, "unavailable"), http.StatusServiceUnavailable},
+		{errors.New("unknown"), http.StatusInternalServerError},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			assert.Equal(t, tc.code, Code(tc.err))
+
+			// The status code survives a gRPC boundary.
+			resp, ok := httpgrpc.HTTPResponseFromError(HTTPGRPCError(tc.err))
+			assert.True(t, ok)
+			assert.Equal(t, tc.code, int(resp.Code))
+		})
+	}
+}
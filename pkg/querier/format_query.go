@@ -0,0 +1,155 @@
+package querier
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// FormatQueryHandler implements the /api/v1/format_query endpoint, returning
+// the query of the request in the canonical PromQL format, so that UIs can
+// pretty-print queries without embedding a parser.
+func FormatQueryHandler(w http.ResponseWriter, r *http.Request) {
+	expr, err := promql.ParseExpr(r.FormValue("query"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	writeAPIResponse(w, expr.String())
+}
+
+// ParseQueryHandler implements the /api/v1/parse_query endpoint, returning the
+// syntax tree of the query of the request as JSON, e.g. for linting queries.
+func ParseQueryHandler(w http.ResponseWriter, r *http.Request) {
+	expr, err := promql.ParseExpr(r.FormValue("query"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+		return
+	}
+	writeAPIResponse(w, translateAST(expr))
+}
+
+// translateAST returns the JSON representation of a PromQL node. Durations are
+// in milliseconds.
+func translateAST(node promql.Expr) interface{} {
+	if node == nil {
+		return nil
+	}
+
+	switch n := node.(type) {
+	case *promql.AggregateExpr:
+		return map[string]interface{}{
+			"type":     "aggregation",
+			"op":       n.Op.String(),
+			"expr":     translateAST(n.Expr),
+			"param":    translateAST(n.Param),
+			"grouping": stringsOrEmpty(n.Grouping),
+			"without":  n.Without,
+		}
+	case *promql.BinaryExpr:
+		var matching interface{}
+		if m := n.VectorMatching; m != nil {
+			matching = map[string]interface{}{
+				"card":    m.Card.String(),
+				"labels":  stringsOrEmpty(m.MatchingLabels),
+				"on":      m.On,
+				"include": stringsOrEmpty(m.Include),
+			}
+		}
+		return map[string]interface{}{
+			"type":     "binaryExpr",
+			"op":       n.Op.String(),
+			"lhs":      translateAST(n.LHS),
+			"rhs":      translateAST(n.RHS),
+			"matching": matching,
+			"bool":     n.ReturnBool,
+		}
+	case *promql.Call:
+		args := make([]interface{}, 0, len(n.Args))
+		for _, arg := range n.Args {
+			args = append(args, translateAST(arg))
+		}
+		return map[string]interface{}{
+			"type": "call",
+			"func": map[string]interface{}{
+				"name":       n.Func.Name,
+				"returnType": string(n.Func.ReturnType),
+			},
+			"args": args,
+		}
+	case *promql.MatrixSelector:
+		return map[string]interface{}{
+			"type":     "matrixSelector",
+			"name":     n.Name,
+			"matchers": translateMatchers(n.LabelMatchers),
+			"range":    durationMillis(n.Range),
+			"offset":   durationMillis(n.Offset),
+		}
+	case *promql.SubqueryExpr:
+		return map[string]interface{}{
+			"type":   "subquery",
+			"expr":   translateAST(n.Expr),
+			"range":  durationMillis(n.Range),
+			"offset": durationMillis(n.Offset),
+			"step":   durationMillis(n.Step),
+		}
+	case *promql.NumberLiteral:
+		// As a string, like the sample values of the API, to represent NaN
+		// and the infinities.
+		return map[string]interface{}{
+			"type": "numberLiteral",
+			"val":  strconv.FormatFloat(n.Val, 'f', -1, 64),
+		}
+	case *promql.ParenExpr:
+		return map[string]interface{}{
+			"type": "parenExpr",
+			"expr": translateAST(n.Expr),
+		}
+	case *promql.StringLiteral:
+		return map[string]interface{}{
+			"type": "stringLiteral",
+			"val":  n.Val,
+		}
+	case *promql.UnaryExpr:
+		return map[string]interface{}{
+			"type": "unaryExpr",
+			"op":   n.Op.String(),
+			"expr": translateAST(n.Expr),
+		}
+	case *promql.VectorSelector:
+		return map[string]interface{}{
+			"type":     "vectorSelector",
+			"name":     n.Name,
+			"matchers": translateMatchers(n.LabelMatchers),
+			"offset":   durationMillis(n.Offset),
+		}
+	}
+	panic(fmt.Sprintf("unknown PromQL node type %T", node))
+}
+
+func translateMatchers(matchers []*labels.Matcher) []interface{} {
+	result := make([]interface{}, 0, len(matchers))
+	for _, m := range matchers {
+		result = append(result, map[string]string{
+			"type":  m.Type.String(),
+			"name":  m.Name,
+			"value": m.Value,
+		})
+	}
+	return result
+}
+
+func durationMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func stringsOrEmpty(ss []string) []string {
+	if ss == nil {
+		return []string{}
+	}
+	return ss
+}
@@ -0,0 +1,67 @@
+package querier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatQueryHandler(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		code     int
+		expected string
+	}{
+		{
+			query:    `sum  by(job)(rate( foo{bar="baz"}[5m] ))`,
+			code:     http.StatusOK,
+			expected: `{"status":"success","data":"sum by(job) (rate(foo{bar=\"baz\"}[5m]))"}`,
+		},
+		{
+			query:    `sum(`,
+			code:     http.StatusBadRequest,
+			expected: `{"status":"error","errorType":"bad_data","error":"parse error at char 5: unclosed left parenthesis"}`,
+		},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/format_query?query="+url.QueryEscape(tc.query), nil)
+			resp := httptest.NewRecorder()
+			FormatQueryHandler(resp, req)
+
+			assert.Equal(t, tc.code, resp.Code)
+			assert.JSONEq(t, tc.expected, resp.Body.String())
+		})
+	}
+}
+
+func TestParseQueryHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/parse_query?query="+url.QueryEscape(`sum without(a) (rate(foo[5m] offset 1m)) > bool on(b) group_left(c) -vector(1)`), nil)
+	resp := httptest.NewRecorder()
+	ParseQueryHandler(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"status":"success","data":{
+		"type":"binaryExpr","op":">","bool":true,
+		"matching":{"card":"many-to-one","labels":["b"],"on":true,"include":["c"]},
+		"lhs":{
+			"type":"aggregation","op":"sum","grouping":["a"],"without":true,"param":null,
+			"expr":{
+				"type":"call","func":{"name":"rate","returnType":"vector"},
+				"args":[{
+					"type":"matrixSelector","name":"foo","range":300000,"offset":60000,
+					"matchers":[{"type":"=","name":"__name__","value":"foo"}]
+				}]
+			}
+		},
+		"rhs":{
+			"type":"unaryExpr","op":"-",
+			"expr":{
+				"type":"call","func":{"name":"vector","returnType":"vector"},
+				"args":[{"type":"numberLiteral","val":"1"}]
+			}
+		}
+	}}`, resp.Body.String())
+}
@@ -0,0 +1,214 @@
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var downstreamHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "cortex",
+	Name:      "frontend_downstream_healthy",
+	Help:      "Whether the downstream passed its last health check.",
+}, []string{"downstream"})
+
+type downstream struct {
+	url     *url.URL
+	weight  int
+	current int // The current weight of the smooth weighted round-robin.
+	healthy bool
+}
+
+// downstreams balances the requests between the downstream URLs, by weighted
+// round-robin, skipping the downstreams failing their health checks or the
+// requests, until they pass a health check again. If all of them are
+// unhealthy, the requests are balanced between all of them.
+type downstreams struct {
+	log      log.Logger
+	path     string
+	interval time.Duration
+	client   *http.Client
+
+	mtx         sync.Mutex
+	downstreams []*downstream
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newDownstreams parses the comma-separated downstream URLs and weights of
+// cfg, and starts health checking them if the health check interval is set.
+func newDownstreams(cfg Config, log log.Logger) (*downstreams, error) {
+	urls := strings.Split(cfg.DownstreamURL, ",")
+	var weights []string
+	if cfg.DownstreamWeights != "" {
+		weights = strings.Split(cfg.DownstreamWeights, ",")
+		if len(weights) != len(urls) {
+			return nil, fmt.Errorf("got %d downstream weights for %d downstream URLs", len(weights), len(urls))
+		}
+	}
+
+	d := &downstreams{
+		log:      log,
+		path:     cfg.DownstreamHealthCheckPath,
+		interval: cfg.DownstreamHealthCheckInterval,
+		client:   &http.Client{Timeout: cfg.DownstreamHealthCheckTimeout},
+		quit:     make(chan struct{}),
+	}
+	for i, rawurl := range urls {
+		u, err := url.Parse(strings.TrimSpace(rawurl))
+		if err != nil {
+			return nil, err
+		}
+		weight := 1
+		if weights != nil {
+			if weight, err = strconv.Atoi(strings.TrimSpace(weights[i])); err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight %q of downstream %s: must be a positive integer", weights[i], u)
+			}
+		}
+		d.downstreams = append(d.downstreams, &downstream{url: u, weight: weight, healthy: true})
+		downstreamHealthy.WithLabelValues(u.String()).Set(1)
+	}
+
+	if d.interval > 0 {
+		d.wg.Add(1)
+		go d.loop()
+	}
+	return d, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (d *downstreams) RoundTrip(r *http.Request) (*http.Response, error) {
+	ds := d.pick()
+	r.URL.Scheme = ds.url.Scheme
+	r.URL.Host = ds.url.Host
+	r.URL.Path = path.Join(ds.url.Path, r.URL.Path)
+
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil && r.Context().Err() == nil && len(d.downstreams) > 1 && d.interval > 0 {
+		level.Warn(d.log).Log("msg", "request to downstream failed, skipping it until it passes a health check", "downstream", ds.url, "err", err)
+		d.setHealthy(ds, false)
+	}
+	return resp, err
+}
+
+// pick returns the next downstream of the smooth weighted round-robin of the
+// healthy downstreams, or of all of them if none is healthy.
+func (d *downstreams) pick() *downstream {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	candidates := make([]*downstream, 0, len(d.downstreams))
+	for _, ds := range d.downstreams {
+		if ds.healthy {
+			candidates = append(candidates, ds)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = d.downstreams
+	}
+
+	var (
+		best  *downstream
+		total int
+	)
+	for _, ds := range candidates {
+		ds.current += ds.weight
+		total += ds.weight
+		if best == nil || ds.current > best.current {
+			best = ds
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func (d *downstreams) setHealthy(ds *downstream, healthy bool) {
+	d.mtx.Lock()
+	ds.healthy = healthy
+	d.mtx.Unlock()
+
+	value := 0.
+	if healthy {
+		value = 1
+	}
+	downstreamHealthy.WithLabelValues(ds.url.String()).Set(value)
+}
+
+func (d *downstreams) loop() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.checkHealth()
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// checkHealth checks the health of all the downstreams in parallel: they are
+// healthy if their health check path returns a 2xx.
+func (d *downstreams) checkHealth() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, ds := range d.downstreams {
+		wg.Add(1)
+		go func(ds *downstream) {
+			defer wg.Done()
+			err := d.check(ctx, ds)
+			if err != nil {
+				level.Warn(d.log).Log("msg", "downstream failed its health check", "downstream", ds.url, "err", err)
+			}
+			d.setHealthy(ds, err == nil)
+		}(ds)
+	}
+	wg.Wait()
+}
+
+func (d *downstreams) check(ctx context.Context, ds *downstream) error {
+	u := *ds.url
+	u.Path = path.Join(u.Path, d.path)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *downstreams) stop() {
+	close(d.quit)
+	d.wg.Wait()
+}
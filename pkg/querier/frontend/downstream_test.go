@@ -0,0 +1,111 @@
+package frontend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDownstream struct {
+	*httptest.Server
+
+	mtx      sync.Mutex
+	requests int
+	healthy  bool
+}
+
+func newFakeDownstream(t *testing.T) *fakeDownstream {
+	d := &fakeDownstream{healthy: true}
+	d.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		if r.URL.Path == "/prometheus/-/ready" {
+			if !d.healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		require.Equal(t, "/prometheus/api/v1/query", r.URL.Path)
+		d.requests++
+	}))
+	return d
+}
+
+func (d *fakeDownstream) setHealthy(healthy bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.healthy = healthy
+}
+
+func (d *fakeDownstream) count() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	n := d.requests
+	d.requests = 0
+	return n
+}
+
+func TestDownstreams(t *testing.T) {
+	a, b, c := newFakeDownstream(t), newFakeDownstream(t), newFakeDownstream(t)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	ds, err := newDownstreams(Config{
+		DownstreamURL:             a.URL + "/prometheus," + b.URL + "/prometheus," + c.URL + "/prometheus",
+		DownstreamWeights:         "1,2,3",
+		DownstreamHealthCheckPath: "/-/ready",
+	}, log.NewNopLogger())
+	require.NoError(t, err)
+	defer ds.stop()
+
+	do := func(n int) {
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest("GET", "/api/v1/query", nil)
+			req.RequestURI = ""
+			resp, err := ds.RoundTrip(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			_ = resp.Body.Close()
+		}
+	}
+
+	// The requests are balanced by weight.
+	do(60)
+	require.Equal(t, []int{10, 20, 30}, []int{a.count(), b.count(), c.count()})
+
+	// The unhealthy downstreams are skipped.
+	c.setHealthy(false)
+	ds.checkHealth()
+	do(30)
+	require.Equal(t, []int{10, 20, 0}, []int{a.count(), b.count(), c.count()})
+
+	// Unless all of them are.
+	a.setHealthy(false)
+	b.setHealthy(false)
+	ds.checkHealth()
+	do(6)
+	require.Equal(t, []int{1, 2, 3}, []int{a.count(), b.count(), c.count()})
+
+	// Until they pass a health check again.
+	a.setHealthy(true)
+	ds.checkHealth()
+	do(6)
+	require.Equal(t, []int{6, 0, 0}, []int{a.count(), b.count(), c.count()})
+}
+
+func TestDownstreamsConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{DownstreamURL: "http://a,http://b", DownstreamWeights: "1"},
+		{DownstreamURL: "http://a,http://b", DownstreamWeights: "1,0"},
+		{DownstreamURL: "http://a", DownstreamWeights: "x"},
+		{DownstreamURL: "http://a\x7f"},
+	} {
+		_, err := newDownstreams(cfg, log.NewNopLogger())
+		require.Error(t, err, cfg.DownstreamURL)
+	}
+}
@@ -6,26 +6,37 @@ import (
 	"flag"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
-	"net/url"
-	"path"
-	"sync"
 	"time"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/httpgrpc/server"
+	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/naming"
 
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/querier/errstatus"
 	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/querier/stats"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
+// QueueTimeHeader is the header of the requests sent to the queriers holding
+// the time they were queued for in the frontend.
+const QueueTimeHeader = "X-Cortex-Queue-Time"
+
+const errResponseTooLarge = "the response of the query exceeded the maximum size of %d bytes"
+
 var (
 	queueDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Namespace: "cortex",
@@ -38,6 +49,11 @@ var (
 		Name:      "query_frontend_queue_length",
 		Help:      "Number of queries in the queue.",
 	})
+	evictedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "query_frontend_evicted_requests_total",
+		Help:      "Number of queued requests evicted as they were canceled, e.g. as their client disconnected.",
+	})
 	queryRangeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: "cortex",
 		Name:      "frontend_query_range_duration_seconds",
@@ -47,19 +63,41 @@ var (
 
 	errServerClosing  = httpgrpc.Errorf(http.StatusTeapot, "server closing down")
 	errTooManyRequest = httpgrpc.Errorf(http.StatusTooManyRequests, "too many outstanding requests")
-	errCanceled       = httpgrpc.Errorf(http.StatusInternalServerError, "context cancelled")
+	errCanceled       = httpgrpc.Errorf(util.StatusClientClosedRequest, "context cancelled")
 )
 
 // Config for a Frontend.
 type Config struct {
-	MaxOutstandingPerTenant       int  `yaml:"max_outstanding_per_tenant"`
-	MaxRetries                    int  `yaml:"max_retries"`
-	SplitQueriesByDay             bool `yaml:"split_queries_by_day"`
-	AlignQueriesWithStep          bool `yaml:"align_queries_with_step"`
-	CacheResults                  bool `yaml:"cache_results"`
-	CompressResponses             bool `yaml:"compress_responses"`
+	MaxOutstandingPerTenant       int     `yaml:"max_outstanding_per_tenant"`
+	MaxRetries                    int     `yaml:"max_retries"`
+	SplitQueriesByDay             bool    `yaml:"split_queries_by_day"`
+	SplitQueriesByCost            bool    `yaml:"split_queries_by_cost"`
+	SplitQueriesTargetCost        int     `yaml:"split_queries_target_cost"`
+	HedgeSplitQueries             bool    `yaml:"hedge_split_queries"`
+	AlignQueriesWithStep          bool    `yaml:"align_queries_with_step"`
+	CacheResults                  bool    `yaml:"cache_results"`
+	CacheMetadataResults          bool    `yaml:"cache_metadata_results"`
+	CompressResponses             bool    `yaml:"compress_responses"`
+	QueryShards                   int     `yaml:"query_shards"`
+	HedgingBudget                 float64 `yaml:"hedging_budget"`
+	RetryBudget                   float64 `yaml:"retry_budget"`
 	queryrange.ResultsCacheConfig `yaml:"results_cache"`
 	DownstreamURL                 string `yaml:"downstream"`
+	DownstreamWeights             string `yaml:"downstream_weights"`
+
+	DownstreamHealthCheckPath     string        `yaml:"downstream_health_check_path"`
+	DownstreamHealthCheckInterval time.Duration `yaml:"downstream_health_check_interval"`
+	DownstreamHealthCheckTimeout  time.Duration `yaml:"downstream_health_check_timeout"`
+
+	SplitInstantQueriesByInterval time.Duration `yaml:"split_instant_queries_by_interval"`
+	SplitRemoteReadByInterval     time.Duration `yaml:"split_remote_read_by_interval"`
+	CacheRemoteReadResults        bool          `yaml:"cache_remote_read_results"`
+	LogQueriesLongerThan          time.Duration `yaml:"log_queries_longer_than"`
+	TypedQueryAPI                 bool          `yaml:"typed_query_api"`
+
+	SchedulerAddress          string            `yaml:"scheduler_address"`
+	SchedulerDNSLookupPeriod  time.Duration     `yaml:"scheduler_dns_lookup_period"`
+	SchedulerGRPCClientConfig grpcclient.Config `yaml:"scheduler_grpc_client_config"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet.
@@ -67,11 +105,30 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&cfg.MaxOutstandingPerTenant, "querier.max-outstanding-requests-per-tenant", 100, "Maximum number of outstanding requests per tenant per frontend; requests beyond this error with HTTP 429.")
 	f.IntVar(&cfg.MaxRetries, "querier.max-retries-per-request", 5, "Maximum number of retries for a single request; beyond this, the downstream error is returned.")
 	f.BoolVar(&cfg.SplitQueriesByDay, "querier.split-queries-by-day", false, "Split queries by day and execute in parallel.")
+	f.BoolVar(&cfg.SplitQueriesByCost, "querier.split-queries-by-cost", false, "Split queries by an interval chosen per query from the estimated number of series it selects, instead of by day, and execute them in parallel.")
+	f.IntVar(&cfg.SplitQueriesTargetCost, "querier.split-queries-target-cost", 1000000, "Maximum estimated cost of the queries split by cost, in series times hours: the queries within it aren't split, and the other queries are split by the largest interval within it, from 1h to 24h.")
+	f.BoolVar(&cfg.HedgeSplitQueries, "querier.hedge-split-queries", false, "Send a second request for the split queries slower than the 99th percentile of the other queries of the split, and use the first response.")
+	f.Float64Var(&cfg.HedgingBudget, "querier.hedging-budget", 0.1, "Maximum number of hedged requests per split query, across the tenants. 0 for no limit.")
+	f.Float64Var(&cfg.RetryBudget, "querier.retry-budget", 0, "Maximum number of retries per request, across the tenants, on top of -querier.max-retries-per-request. 0 for no limit.")
 	f.BoolVar(&cfg.AlignQueriesWithStep, "querier.align-querier-with-step", false, "Mutate incoming queries to align their start and end with their step.")
 	f.BoolVar(&cfg.CacheResults, "querier.cache-results", false, "Cache query results.")
+	f.BoolVar(&cfg.CacheMetadataResults, "querier.cache-metadata-results", false, "Cache the responses of the label names, label values and series APIs in the results cache.")
 	f.BoolVar(&cfg.CompressResponses, "querier.compress-http-responses", false, "Compress HTTP responses.")
+	f.IntVar(&cfg.QueryShards, "querier.query-shards", 0, "Split the shardable aggregations into this number of queries, each selecting a shard of the series, and execute them in parallel; 0 to disable.")
+	f.DurationVar(&cfg.SplitInstantQueriesByInterval, "querier.split-instant-queries-by-interval", 0, "Split the instant queries of a sum, count, min or max over time of a range selector longer than this interval into one query per interval of the range, and execute them in parallel; 0 to disable.")
+	f.DurationVar(&cfg.SplitRemoteReadByInterval, "querier.split-remote-read-by-interval", 0, "Split the queries of the remote read requests into one query per interval, and execute them in parallel; 0 to disable.")
+	f.BoolVar(&cfg.CacheRemoteReadResults, "querier.cache-remote-read-results", false, "Cache the samples of the split queries of the remote read requests in the results cache; requires -querier.split-remote-read-by-interval.")
+	f.DurationVar(&cfg.LogQueriesLongerThan, "frontend.log-queries-longer-than", 0, "Log the queries taking longer than this duration, with their tenant, parameters, stats and trace ID. The stats are returned by the queriers with -querier.query-stats-enabled. 0 to disable.")
+	f.BoolVar(&cfg.TypedQueryAPI, "frontend.typed-query-api", false, "Send the parsed range queries to the queriers, which return their results as protobuf rather than JSON. The queriers not supporting it return JSON.")
 	cfg.ResultsCacheConfig.RegisterFlags(f)
-	f.StringVar(&cfg.DownstreamURL, "frontend.downstream-url", "", "URL of downstream Prometheus.")
+	f.StringVar(&cfg.DownstreamURL, "frontend.downstream-url", "", "URL of downstream Prometheus, or comma-separated URLs of downstream Prometheus or Thanos queriers to balance the requests between.")
+	f.StringVar(&cfg.DownstreamWeights, "frontend.downstream-weights", "", "Comma-separated weights of the downstream URLs in the weighted round-robin of the requests; all 1 by default.")
+	f.StringVar(&cfg.DownstreamHealthCheckPath, "frontend.downstream-health-check-path", "/-/ready", "Path of the health checks of the downstream URLs, healthy if it returns a 2xx.")
+	f.DurationVar(&cfg.DownstreamHealthCheckInterval, "frontend.downstream-health-check-interval", 10*time.Second, "How often to check the health of the downstream URLs; the requests are balanced between the healthy ones. 0 to disable.")
+	f.DurationVar(&cfg.DownstreamHealthCheckTimeout, "frontend.downstream-health-check-timeout", 5*time.Second, "Timeout of the health checks of the downstream URLs.")
+	f.StringVar(&cfg.SchedulerAddress, "frontend.scheduler-address", "", "Address of the query-scheduler service, which queues the requests instead of the frontend; the queriers then connect to the schedulers.")
+	f.DurationVar(&cfg.SchedulerDNSLookupPeriod, "frontend.scheduler-dns-lookup-period", 10*time.Second, "How often to query DNS for the addresses of the schedulers.")
+	cfg.SchedulerGRPCClientConfig.RegisterFlags("frontend.scheduler-client", f)
 }
 
 // Frontend queues HTTP requests, dispatches them to backends, and handles retries
@@ -80,80 +137,133 @@ type Frontend struct {
 	cfg          Config
 	log          log.Logger
 	roundTripper http.RoundTripper
+	limits       *validation.Overrides
 
-	mtx    sync.Mutex
-	cond   *sync.Cond
-	queues map[string]chan *request
-}
+	queue *queue
 
-type request struct {
-	enqueueTime time.Time
-	queueSpan   opentracing.Span
-	originalCtx context.Context
+	// The client of the schedulers, and its connection, which queue the
+	// requests instead of the frontend if -frontend.scheduler-address is set.
+	scheduler     SchedulerClient
+	schedulerConn *grpc.ClientConn
 
-	request  *ProcessRequest
-	err      chan error
-	response chan *ProcessResponse
+	// The downstream URLs if -frontend.downstream-url is set.
+	downstreams *downstreams
 }
 
-// New creates a new frontend.
-func New(cfg Config, log log.Logger, limits *validation.Overrides) (*Frontend, error) {
+// New creates a new frontend. The genLoader, if not nil, gives the cache
+// generation numbers of the tenants, keying their cached results.
+func New(cfg Config, log log.Logger, limits *validation.Overrides, genLoader queryrange.CacheGenNumberLoader) (*Frontend, error) {
 	f := &Frontend{
 		cfg:    cfg,
 		log:    log,
-		queues: map[string]chan *request{},
+		limits: limits,
+		queue:  newQueue(cfg.MaxOutstandingPerTenant, limits),
 	}
-	f.cond = sync.NewCond(&f.mtx)
 
-	// Stack up the pipeline of various query range middlewares.
-	var queryRangeMiddleware []queryrange.Middleware
-	if cfg.AlignQueriesWithStep {
-		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("step_align", queryRangeDuration), queryrange.StepAlignMiddleware)
-	}
-	if cfg.SplitQueriesByDay {
-		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("split_by_day", queryRangeDuration), queryrange.SplitByDayMiddleware(limits))
-	}
-	if cfg.CacheResults {
-		queryCacheMiddleware, err := queryrange.NewResultsCacheMiddleware(log, cfg.ResultsCacheConfig, limits)
+	if cfg.SchedulerAddress != "" {
+		conn, err := dialSchedulers(cfg)
 		if err != nil {
 			return nil, err
 		}
-		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("results_cache", queryRangeDuration), queryCacheMiddleware)
+		f.scheduler, f.schedulerConn = NewSchedulerClient(conn), conn
 	}
-	if cfg.MaxRetries > 0 {
-		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("retry", queryRangeDuration), queryrange.NewRetryMiddleware(log, cfg.MaxRetries))
+
+	var resultsCache cache.Cache
+	if cfg.CacheResults || cfg.CacheMetadataResults || cfg.CacheRemoteReadResults {
+		var err error
+		resultsCache, err = queryrange.NewResultsCache(cfg.ResultsCacheConfig)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// If the user has specified a downstream Prometheus, then we should
-	// forward requests to that.  Otherwise we will wait for queries to
+	// If the user has specified downstream Prometheus, then we should
+	// forward requests to them.  Otherwise we will wait for queries to
 	// contact us.
 	var roundTripper http.RoundTripper = f
 	if cfg.DownstreamURL != "" {
-		u, err := url.Parse(cfg.DownstreamURL)
-		if err != nil {
+		var err error
+		if f.downstreams, err = newDownstreams(cfg, log); err != nil {
 			return nil, err
 		}
+		roundTripper = f.downstreams
+	}
+
+	// The federated queries, of several tenants, get the strictest limits of
+	// their tenants.
+	var queryRangeLimits queryrange.Limits = limits
+	if limits != nil {
+		queryRangeLimits = queryrange.NewFederatedLimits(limits)
+	}
 
-		roundTripper = RoundTripFunc(func(r *http.Request) (*http.Response, error) {
-			r.URL.Scheme = u.Scheme
-			r.URL.Host = u.Host
-			r.URL.Path = path.Join(u.Path, r.URL.Path)
-			return http.DefaultTransport.RoundTrip(r)
-		})
+	// Stack up the pipeline of various query range middlewares.
+	var queryRangeMiddleware []queryrange.Middleware
+	if cfg.AlignQueriesWithStep {
+		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("step_align", queryRangeDuration), queryrange.StepAlignMiddleware)
+	}
+	if cfg.SplitQueriesByDay || cfg.SplitQueriesByCost {
+		var hedging *queryrange.Hedging
+		if cfg.HedgeSplitQueries {
+			hedging = queryrange.NewHedging(queryrange.NewBudget(cfg.HedgingBudget))
+		}
+		if cfg.SplitQueriesByCost {
+			estimator := queryrange.NewCardinalitySeriesEstimator(roundTripper)
+			queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("split_by_cost", queryRangeDuration), queryrange.SplitByCostMiddleware(log, cfg.SplitQueriesTargetCost, estimator, queryRangeLimits, hedging))
+		} else {
+			queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("split_by_day", queryRangeDuration), queryrange.SplitByDayMiddleware(queryRangeLimits, hedging))
+		}
+	}
+	if cfg.CacheResults {
+		queryCacheMiddleware := queryrange.NewResultsCacheMiddleware(log, cfg.ResultsCacheConfig, resultsCache, queryRangeLimits, genLoader)
+		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("results_cache", queryRangeDuration), queryCacheMiddleware)
+	}
+	if cfg.QueryShards > 1 {
+		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("query_sharding", queryRangeDuration), queryrange.QueryShardingMiddleware(cfg.QueryShards, queryRangeLimits))
+	}
+	if cfg.MaxRetries > 0 {
+		queryRangeMiddleware = append(queryRangeMiddleware, queryrange.InstrumentMiddleware("retry", queryRangeDuration), queryrange.NewRetryMiddleware(log, cfg.MaxRetries, queryrange.NewBudget(cfg.RetryBudget)))
 	}
 
 	// Finally, if the user selected any query range middleware, stitch it in.
 	if len(queryRangeMiddleware) > 0 {
+		var downstream queryrange.Handler = &queryrange.ToRoundTripperMiddleware{Next: roundTripper}
+		if cfg.TypedQueryAPI && cfg.DownstreamURL == "" {
+			downstream = queryrange.ToTypedRoundTripperMiddleware{Next: f}
+		}
 		roundTripper = queryrange.NewRoundTripper(
 			roundTripper,
-			queryrange.MergeMiddlewares(queryRangeMiddleware...).Wrap(&queryrange.ToRoundTripperMiddleware{Next: roundTripper}),
-			limits,
+			queryrange.MergeMiddlewares(queryRangeMiddleware...).Wrap(downstream),
+			queryRangeLimits,
 		)
 	}
+	if cfg.SplitInstantQueriesByInterval > 0 {
+		roundTripper = queryrange.NewInstantQuerySplitRoundTripper(cfg.SplitInstantQueriesByInterval, queryRangeLimits, roundTripper)
+	}
+	if cfg.CacheMetadataResults {
+		roundTripper = queryrange.NewMetadataCacheRoundTripper(log, cfg.ResultsCacheConfig, resultsCache, queryRangeLimits, genLoader, roundTripper)
+	}
+	var remoteReadCache cache.Cache
+	if cfg.CacheRemoteReadResults {
+		remoteReadCache = resultsCache
+	}
+	roundTripper = queryrange.NewRemoteReadRoundTripper(log, cfg.SplitRemoteReadByInterval, cfg.ResultsCacheConfig, remoteReadCache, queryRangeLimits, genLoader, roundTripper)
 	f.roundTripper = roundTripper
 	return f, nil
 }
 
+// dialSchedulers connects to the schedulers, balancing the requests between
+// the addresses the scheduler address resolves to.
+func dialSchedulers(cfg Config) (*grpc.ClientConn, error) {
+	resolver, err := naming.NewDNSResolverWithFreq(cfg.SchedulerDNSLookupPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithBalancer(grpc.RoundRobin(resolver))}
+	opts = append(opts, cfg.SchedulerGRPCClientConfig.DialOption([]grpc.UnaryClientInterceptor{middleware.ClientUserHeaderInterceptor}, nil)...)
+	return grpc.Dial(cfg.SchedulerAddress, opts...)
+}
+
 // RoundTripFunc is to http.RoundTripper what http.HandlerFunc is to http.Handler.
 type RoundTripFunc func(*http.Request) (*http.Response, error)
 
@@ -164,10 +274,14 @@ func (f RoundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
 
 // Close stops new requests and errors out any pending requests.
 func (f *Frontend) Close() {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
-	for len(f.queues) > 0 {
-		f.cond.Wait()
+	f.queue.close()
+	if f.downstreams != nil {
+		f.downstreams.stop()
+	}
+	if f.schedulerConn != nil {
+		if err := f.schedulerConn.Close(); err != nil {
+			level.Error(f.log).Log("msg", "error closing connection to the schedulers", "err", err)
+		}
 	}
 }
 
@@ -180,33 +294,124 @@ func (f *Frontend) Handler() http.Handler {
 }
 
 func (f *Frontend) handle(w http.ResponseWriter, r *http.Request) {
+	var (
+		start      = time.Now()
+		queryStats = &stats.Stats{}
+		code       int
+	)
+	r = r.WithContext(stats.WithStats(r.Context(), queryStats))
+	defer func() {
+		f.logSlowQuery(r, code, time.Since(start), queryStats)
+	}()
+
+	priority, err := parsePriority(r.Header.Get(QueryPriorityHeader))
+	if err != nil {
+		code = writeError(w, err)
+		return
+	}
+	r = r.WithContext(withPriority(r.Context(), priority))
+
+	readConsistency := r.Header.Get(util.ReadConsistencyHeader)
+	if err := util.ValidateReadConsistency(readConsistency); err != nil {
+		code = writeError(w, err)
+		return
+	}
+	if orgID, err := user.ExtractOrgID(r.Context()); err == nil && readConsistency == "" && f.limits != nil {
+		readConsistency = util.TenantReadConsistency(orgID, f.limits.ReadConsistency)
+	}
+	r = r.WithContext(util.WithReadConsistency(r.Context(), readConsistency))
+
 	resp, err := f.roundTripper.RoundTrip(r)
 	if err != nil {
-		server.WriteError(w, err)
+		code = writeError(w, errstatus.HTTPGRPCError(err))
 		return
 	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if userID, err := user.ExtractOrgID(r.Context()); err == nil && f.limits != nil {
+		if limit := util.SmallestPositiveIntPerTenant(userID, f.limits.MaxResponseSize); limit > 0 {
+			buf, err := readLimitedBody(resp, limit)
+			if err != nil {
+				code = writeError(w, err)
+				return
+			}
+			body = bytes.NewReader(buf)
+		}
+	}
 
 	hs := w.Header()
 	for h, vs := range resp.Header {
 		hs[h] = vs
 	}
+	code = resp.StatusCode
 	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	io.Copy(w, body)
+}
+
+// writeError writes the error, and returns its status code.
+func writeError(w http.ResponseWriter, err error) int {
+	server.WriteError(w, err)
+	if resp, ok := httpgrpc.HTTPResponseFromError(err); ok {
+		return int(resp.Code)
+	}
+	return http.StatusInternalServerError
+}
+
+// readLimitedBody reads the body of a response of at most limit bytes. The
+// body of a downstream Prometheus is read as it is received, and the read
+// aborted once it exceeds the limit, so that the frontend never holds more than
+// limit bytes of it. The queriers fail their responses over the limit as they
+// build them, but the results of the split queries, merged by the frontend,
+// are checked here too.
+func readLimitedBody(resp *http.Response, limit int) ([]byte, error) {
+	errTooLarge := httpgrpc.Errorf(http.StatusUnprocessableEntity, errResponseTooLarge, limit)
+	if resp.ContentLength > int64(limit) {
+		return nil, errTooLarge
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > limit {
+		return nil, errTooLarge
+	}
+	return buf, nil
 }
 
 // RoundTrip implement http.Transport.
 func (f *Frontend) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, _, err := f.roundTrip(r, nil)
+	return resp, err
+}
+
+// roundTrip sends the request to a querier, along with the parsed range query
+// if set, and returns the response, along with the parsed response if the
+// querier returned it.
+func (f *Frontend) roundTrip(r *http.Request, query *queryrange.Request) (*http.Response, *queryrange.APIResponse, error) {
 	req, err := server.HTTPRequest(r)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// The requests built by the frontend, e.g. the split queries, inherit the
+	// priority and the read consistency of the request.
+	if priority, ok := priorityFromContext(r.Context()); ok && r.Header.Get(QueryPriorityHeader) == "" {
+		(*httpgrpcHeadersCarrier)(req).Set(QueryPriorityHeader, priority.String())
+	}
+	if level, ok := util.ReadConsistencyLevelFromContext(r.Context()); ok && r.Header.Get(util.ReadConsistencyHeader) == "" {
+		(*httpgrpcHeadersCarrier)(req).Set(util.ReadConsistencyHeader, level)
 	}
 
 	resp, err := f.RoundTripGRPC(r.Context(), &ProcessRequest{
-		HttpRequest: req,
+		HttpRequest:       req,
+		QueryRangeRequest: query,
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	addResponseStats(stats.FromContext(r.Context()), resp.HttpResponse)
 
 	httpResp := &http.Response{
 		StatusCode: int(resp.HttpResponse.Code),
@@ -216,7 +421,7 @@ func (f *Frontend) RoundTrip(r *http.Request) (*http.Response, error) {
 	for _, h := range resp.HttpResponse.Headers {
 		httpResp.Header[h.Key] = h.Values
 	}
-	return httpResp, nil
+	return httpResp, resp.ApiResponse, nil
 }
 
 type httpgrpcHeadersCarrier httpgrpc.HTTPRequest
@@ -237,152 +442,18 @@ func (f *Frontend) RoundTripGRPC(ctx context.Context, req *ProcessRequest) (*Pro
 		tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier)
 	}
 
-	request := request{
-		request:     req,
-		originalCtx: ctx,
-
-		// Buffer of 1 to ensure response can be written by the server side
-		// of the Process stream, even if this goroutine goes away due to
-		// client context cancellation.
-		err:      make(chan error, 1),
-		response: make(chan *ProcessResponse, 1),
-	}
-
-	if err := f.queueRequest(ctx, &request); err != nil {
-		return nil, err
+	if f.scheduler == nil {
+		return f.queue.roundTrip(ctx, req)
 	}
 
-	select {
-	case <-ctx.Done():
+	resp, err := f.scheduler.Enqueue(ctx, req)
+	if err != nil && ctx.Err() != nil {
 		return nil, errCanceled
-
-	case resp := <-request.response:
-		return resp, nil
-
-	case err := <-request.err:
-		return nil, err
 	}
+	return resp, err
 }
 
 // Process allows backends to pull requests from the frontend.
 func (f *Frontend) Process(server Frontend_ProcessServer) error {
-	// If the downstream request(from querier -> frontend) is cancelled,
-	// we need to ping the condition variable to unblock getNextRequest.
-	// Ideally we'd have ctx aware condition variables...
-	go func() {
-		<-server.Context().Done()
-		f.cond.Broadcast()
-	}()
-
-	for {
-		req, err := f.getNextRequest(server.Context())
-		if err != nil {
-			return err
-		}
-
-		// Handle the stream sending & receiving on a goroutine so we can
-		// monitoring the contexts in a select and cancel things appropriately.
-		resps := make(chan *ProcessResponse, 1)
-		errs := make(chan error, 1)
-		go func() {
-			err = server.Send(req.request)
-			if err != nil {
-				errs <- err
-				return
-			}
-
-			resp, err := server.Recv()
-			if err != nil {
-				errs <- err
-				return
-			}
-
-			resps <- resp
-		}()
-
-		select {
-		// If the upstream reqeust is cancelled, we need to cancel the
-		// downstream req.  Only way we can do that is to close the stream.
-		// The worker client is expecting this semantics.
-		case <-req.originalCtx.Done():
-			return req.originalCtx.Err()
-
-		// Is there was an error handling this request due to network IO,
-		// then error out this upstream request _and_ stream.
-		case err := <-errs:
-			req.err <- err
-			return err
-
-		// Happy path: propagate the response.
-		case resp := <-resps:
-			req.response <- resp
-		}
-	}
-}
-
-func (f *Frontend) queueRequest(ctx context.Context, req *request) error {
-	userID, err := user.ExtractOrgID(ctx)
-	if err != nil {
-		return err
-	}
-
-	req.enqueueTime = time.Now()
-	req.queueSpan, _ = opentracing.StartSpanFromContext(ctx, "queued")
-
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
-
-	queue, ok := f.queues[userID]
-	if !ok {
-		queue = make(chan *request, f.cfg.MaxOutstandingPerTenant)
-		f.queues[userID] = queue
-	}
-
-	select {
-	case queue <- req:
-		queueLength.Add(1)
-		f.cond.Broadcast()
-		return nil
-	default:
-		return errTooManyRequest
-	}
-}
-
-// getQueue picks a random queue and takes the next request off of it, so we
-// fairly process users queries.  Will block if there are no requests.
-func (f *Frontend) getNextRequest(ctx context.Context) (*request, error) {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
-
-	for len(f.queues) == 0 && ctx.Err() == nil {
-		f.cond.Wait()
-	}
-
-	if err := ctx.Err(); err != nil {
-		return nil, err
-	}
-
-	i, n := 0, rand.Intn(len(f.queues))
-	for userID, queue := range f.queues {
-		if i < n {
-			i++
-			continue
-		}
-
-		request := <-queue
-		if len(queue) == 0 {
-			delete(f.queues, userID)
-		}
-
-		// Tell close() we've processed a request.
-		f.cond.Broadcast()
-
-		queueDuration.Observe(time.Now().Sub(request.enqueueTime).Seconds())
-		queueLength.Add(-1)
-		request.queueSpan.Finish()
-
-		return request, nil
-	}
-
-	panic("should never happen")
+	return f.queue.process(server)
 }
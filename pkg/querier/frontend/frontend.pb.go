@@ -138,30 +138,32 @@ func init() {
 func init() { proto.RegisterFile("frontend.proto", fileDescriptor_eca3873955a29cfe) }
 
 var fileDescriptor_eca3873955a29cfe = []byte{
-	// 367 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0xbf, 0x4e, 0xeb, 0x30,
-	0x14, 0xc6, 0xe3, 0x3b, 0xdc, 0x5b, 0xb9, 0x57, 0x45, 0x18, 0x01, 0xa5, 0x83, 0x85, 0x3a, 0x75,
-	0x21, 0x41, 0x05, 0x09, 0xc1, 0x80, 0x54, 0x84, 0xf8, 0xb3, 0x45, 0x51, 0x27, 0xb6, 0x36, 0xb8,
-	0x69, 0xa9, 0x1a, 0xbb, 0x8e, 0x43, 0x61, 0x63, 0x64, 0x60, 0xe0, 0x31, 0x78, 0x14, 0xc6, 0x8e,
-	0x1d, 0xa9, 0xbb, 0x30, 0xf6, 0x11, 0x50, 0x6d, 0xd7, 0x32, 0x85, 0x29, 0xe7, 0xd3, 0xf9, 0xbe,
-	0x73, 0x7e, 0xd1, 0x31, 0x2c, 0x75, 0x38, 0x4d, 0x05, 0x49, 0x6f, 0x7d, 0xc6, 0xa9, 0xa0, 0xa8,
-	0xb0, 0xd4, 0x95, 0xbd, 0xa4, 0x27, 0xba, 0x79, 0xdb, 0x8f, 0xe9, 0x20, 0x48, 0x68, 0x42, 0x03,
-	0x65, 0x68, 0xe7, 0x1d, 0xa5, 0x94, 0x50, 0x95, 0x0e, 0x56, 0x0e, 0x1d, 0xfb, 0x88, 0xb4, 0xee,
-	0xc9, 0x88, 0xf2, 0x7e, 0x16, 0xc4, 0x74, 0x30, 0xa0, 0x69, 0xd0, 0x15, 0x82, 0x25, 0x9c, 0xc5,
-	0xb6, 0x30, 0xa9, 0x4b, 0x27, 0x15, 0x53, 0x2e, 0xc8, 0x03, 0xe3, 0xf4, 0x8e, 0xc4, 0xc2, 0xa8,
-	0x80, 0xf5, 0x93, 0x60, 0x98, 0x13, 0xde, 0x23, 0x5c, 0x7d, 0x1f, 0x79, 0x2b, 0x4d, 0x88, 0x53,
-	0xea, 0x41, 0xd5, 0x17, 0x00, 0x4b, 0x21, 0xa7, 0x31, 0xc9, 0xb2, 0x88, 0x0c, 0x73, 0x92, 0x09,
-	0x74, 0x04, 0x8b, 0x8b, 0x6d, 0x46, 0x96, 0xc1, 0x2e, 0xa8, 0x15, 0xeb, 0x9b, 0xbe, 0x25, 0xb8,
-	0x6a, 0x36, 0x43, 0xd3, 0x8c, 0x5c, 0x27, 0x6a, 0xc0, 0x75, 0x35, 0x3f, 0x5a, 0xcc, 0x5f, 0xc6,
-	0xff, 0xa8, 0xf8, 0x86, 0xef, 0x6c, 0x5e, 0x86, 0x7f, 0xba, 0xab, 0xcf, 0x00, 0xae, 0x59, 0x9c,
-	0x8c, 0xd1, 0x34, 0x23, 0xe8, 0x04, 0xfe, 0xd7, 0x5b, 0xb4, 0x36, 0x40, 0x5b, 0xab, 0x40, 0xba,
-	0x1b, 0x7d, 0xf3, 0xa2, 0x63, 0x58, 0x6c, 0xb1, 0x9e, 0x8d, 0x6a, 0x98, 0x6d, 0x17, 0xa6, 0x11,
-	0x5e, 0xdb, 0xac, 0xeb, 0xad, 0x87, 0xb0, 0x70, 0x61, 0x6e, 0x8a, 0xce, 0xe1, 0x3f, 0x43, 0x85,
-	0x76, 0x7c, 0x7b, 0xf9, 0x15, 0xd0, 0x4a, 0xf9, 0x97, 0x96, 0xfe, 0x2d, 0xaf, 0x06, 0xf6, 0xc1,
-	0xd9, 0xe9, 0x78, 0x8a, 0xbd, 0xc9, 0x14, 0x7b, 0xf3, 0x29, 0x06, 0x4f, 0x12, 0x83, 0x37, 0x89,
-	0xc1, 0xbb, 0xc4, 0x60, 0x2c, 0x31, 0xf8, 0x90, 0x18, 0x7c, 0x4a, 0xec, 0xcd, 0x25, 0x06, 0xaf,
-	0x33, 0xec, 0x8d, 0x67, 0xd8, 0x9b, 0xcc, 0xb0, 0x77, 0x63, 0x5f, 0x56, 0xfb, 0xaf, 0x3a, 0xd9,
-	0xc1, 0x57, 0x00, 0x00, 0x00, 0xff, 0xff, 0x0c, 0x0d, 0xde, 0xba, 0x7c, 0x02, 0x00, 0x00,
+	// 395 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x92, 0x3f, 0x4f, 0xf2, 0x50,
+	0x14, 0xc6, 0xef, 0x7d, 0x87, 0x17, 0xde, 0xcb, 0x1b, 0x8c, 0xd7, 0xa8, 0xd8, 0xe1, 0xc6, 0x30,
+	0xb1, 0xd8, 0x1a, 0x34, 0x31, 0x3a, 0x18, 0x31, 0xfe, 0x1d, 0x4c, 0x9a, 0xca, 0xe4, 0x06, 0xe5,
+	0x50, 0x10, 0xe9, 0x2d, 0xb7, 0xb7, 0xa2, 0x9b, 0xa3, 0x83, 0x83, 0x1f, 0xc3, 0x8f, 0xe2, 0xc8,
+	0xc8, 0x28, 0x65, 0x71, 0xe4, 0x23, 0x18, 0x6e, 0x4b, 0x53, 0x51, 0xa6, 0x9e, 0x27, 0xe7, 0xf9,
+	0x9d, 0xf3, 0x34, 0xf7, 0x90, 0x7c, 0x53, 0x70, 0x57, 0x82, 0xdb, 0xd0, 0x3d, 0xc1, 0x25, 0xa7,
+	0xd9, 0x99, 0xd6, 0xb6, 0x9c, 0xb6, 0x6c, 0x05, 0x75, 0xdd, 0xe6, 0x5d, 0xc3, 0xe1, 0x0e, 0x37,
+	0x94, 0xa1, 0x1e, 0x34, 0x95, 0x52, 0x42, 0x55, 0x11, 0xa8, 0xed, 0xa6, 0xec, 0x7d, 0xa8, 0xdd,
+	0x43, 0x9f, 0x8b, 0x8e, 0x6f, 0xd8, 0xbc, 0xdb, 0xe5, 0xae, 0xd1, 0x92, 0xd2, 0x73, 0x84, 0x67,
+	0x27, 0x45, 0x4c, 0x9d, 0xa7, 0x28, 0x9b, 0x0b, 0x09, 0x0f, 0x9e, 0xe0, 0xb7, 0x60, 0xcb, 0x58,
+	0x19, 0x5e, 0xc7, 0x31, 0x7a, 0x01, 0x88, 0x36, 0x08, 0xf5, 0x7d, 0x14, 0x35, 0xd7, 0x81, 0x54,
+	0x19, 0x0d, 0x2a, 0xbe, 0x60, 0x92, 0x37, 0x05, 0xb7, 0xc1, 0xf7, 0x2d, 0xe8, 0x05, 0xe0, 0x4b,
+	0xba, 0x47, 0x72, 0xd3, 0x6d, 0xb1, 0x2c, 0xe0, 0x4d, 0x5c, 0xca, 0x95, 0x57, 0xf5, 0x24, 0xc1,
+	0x45, 0xb5, 0x6a, 0xc6, 0x4d, 0x2b, 0xed, 0xa4, 0x15, 0xb2, 0xac, 0xe6, 0x5b, 0xd3, 0xf9, 0x33,
+	0xfc, 0x8f, 0xc2, 0x57, 0xf4, 0xd4, 0xe6, 0x19, 0xfc, 0xd3, 0x5d, 0x7c, 0xc6, 0x64, 0x29, 0x89,
+	0xe3, 0x7b, 0xdc, 0xf5, 0x81, 0x1e, 0x90, 0xff, 0xd1, 0x96, 0x48, 0xc7, 0x81, 0xd6, 0xe6, 0x03,
+	0x45, 0x5d, 0xeb, 0x9b, 0x97, 0xee, 0x93, 0x5c, 0xcd, 0x6b, 0x27, 0x68, 0x14, 0x66, 0x3d, 0x1d,
+	0xa6, 0x62, 0x5e, 0x26, 0x6c, 0xda, 0x5b, 0x36, 0x49, 0xf6, 0x2c, 0x7e, 0x53, 0x7a, 0x42, 0x32,
+	0x71, 0x2a, 0xba, 0xa1, 0x27, 0x2f, 0x3f, 0x17, 0x54, 0x2b, 0xfc, 0xd2, 0x8a, 0x7e, 0x0b, 0x95,
+	0xf0, 0x36, 0x2e, 0x5f, 0x91, 0x7f, 0xd7, 0x76, 0x0b, 0x1a, 0xc1, 0x1d, 0x08, 0x7a, 0x44, 0x32,
+	0xa7, 0x6e, 0x2f, 0x80, 0x00, 0xe8, 0x42, 0x4e, 0x5b, 0xbc, 0xac, 0x88, 0x8e, 0x0f, 0x07, 0x23,
+	0x86, 0x86, 0x23, 0x86, 0x26, 0x23, 0x86, 0x9f, 0x42, 0x86, 0xdf, 0x42, 0x86, 0xdf, 0x43, 0x86,
+	0x07, 0x21, 0xc3, 0x1f, 0x21, 0xc3, 0x9f, 0x21, 0x43, 0x93, 0x90, 0xe1, 0xd7, 0x31, 0x43, 0x83,
+	0x31, 0x43, 0xc3, 0x31, 0x43, 0x37, 0xc9, 0xa1, 0xd6, 0xff, 0xaa, 0x0b, 0xd8, 0xf9, 0x0a, 0x00,
+	0x00, 0xff, 0xff, 0xc4, 0xa9, 0x41, 0x7a, 0xcb, 0x02, 0x00, 0x00,
 }
 
 func (this *ProcessRequest) Equal(that interface{}) bool {
@@ -361,6 +363,72 @@ var _Frontend_serviceDesc = grpc.ServiceDesc{
 	Metadata: "frontend.proto",
 }
 
+// SchedulerClient is the client API for Scheduler service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type SchedulerClient interface {
+	// Enqueue returns the response of the request once processed by a querier.
+	Enqueue(ctx context.Context, in *ProcessRequest, opts ...grpc.CallOption) (*ProcessResponse, error)
+}
+
+type schedulerClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSchedulerClient(cc *grpc.ClientConn) SchedulerClient {
+	return &schedulerClient{cc}
+}
+
+func (c *schedulerClient) Enqueue(ctx context.Context, in *ProcessRequest, opts ...grpc.CallOption) (*ProcessResponse, error) {
+	out := new(ProcessResponse)
+	err := c.cc.Invoke(ctx, "/frontend.Scheduler/Enqueue", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SchedulerServer is the server API for Scheduler service.
+type SchedulerServer interface {
+	// Enqueue returns the response of the request once processed by a querier.
+	Enqueue(context.Context, *ProcessRequest) (*ProcessResponse, error)
+}
+
+func RegisterSchedulerServer(s *grpc.Server, srv SchedulerServer) {
+	s.RegisterService(&_Scheduler_serviceDesc, srv)
+}
+
+func _Scheduler_Enqueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProcessRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SchedulerServer).Enqueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/frontend.Scheduler/Enqueue",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SchedulerServer).Enqueue(ctx, req.(*ProcessRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Scheduler_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "frontend.Scheduler",
+	HandlerType: (*SchedulerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Enqueue",
+			Handler:    _Scheduler_Enqueue_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "frontend.proto",
+}
+
 func (m *ProcessRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -1,11 +1,15 @@
 package frontend
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -18,12 +22,16 @@ import (
 	"github.com/stretchr/testify/require"
 	jaeger "github.com/uber/jaeger-client-go"
 	"github.com/uber/jaeger-client-go/config"
-	httpgrpc_server "github.com/weaveworks/common/httpgrpc/server"
+	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/user"
 	"google.golang.org/grpc"
 
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/querier/stats"
+	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
@@ -54,6 +62,73 @@ func TestFrontend(t *testing.T) {
 	testFrontend(t, handler, test)
 }
 
+func TestFrontendQueryPriority(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(QueryPriorityHeader)))
+	})
+	test := func(addr string) {
+		for _, tc := range []struct {
+			priority string
+			code     int
+		}{
+			{"batch", http.StatusOK},
+			{"urgent", http.StatusBadRequest},
+		} {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/", addr), nil)
+			require.NoError(t, err)
+			req.Header.Set(QueryPriorityHeader, tc.priority)
+			err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), "1"), req)
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, tc.code, resp.StatusCode)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			if tc.code == http.StatusOK {
+				assert.Equal(t, tc.priority, string(body))
+			}
+		}
+	}
+	testFrontend(t, handler, test)
+}
+
+func TestFrontendReadConsistency(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(util.ReadConsistencyHeader)))
+	})
+	test := func(addr string) {
+		for _, tc := range []struct {
+			readConsistency string
+			code            int
+			expected        string
+		}{
+			// The default of the tenant.
+			{"", http.StatusOK, "eventual"},
+			{"strong", http.StatusOK, "strong"},
+			{"weak", http.StatusBadRequest, ""},
+		} {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/", addr), nil)
+			require.NoError(t, err)
+			req.Header.Set(util.ReadConsistencyHeader, tc.readConsistency)
+			err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), "1"), req)
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, tc.code, resp.StatusCode)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			if tc.code == http.StatusOK {
+				assert.Equal(t, tc.expected, string(body))
+			}
+		}
+	}
+	testFrontend(t, handler, test)
+}
+
 func TestFrontendPropagateTrace(t *testing.T) {
 	closer, err := config.Configuration{}.InitGlobalTracer("test")
 	require.NoError(t, err)
@@ -134,6 +209,203 @@ func TestFrontendCancel(t *testing.T) {
 	testFrontend(t, handler, test)
 }
 
+func TestFrontendMaxResponseSize(t *testing.T) {
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.MaxResponseSize = 1024
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name          string
+		size          int64
+		contentLength int64
+		code          int
+	}{
+		{name: "below the limit", size: 1024, contentLength: -1, code: http.StatusOK},
+		{name: "streamed above the limit", size: 1 << 30, contentLength: -1, code: http.StatusUnprocessableEntity},
+		{name: "declared above the limit", size: 1 << 30, contentLength: 1 << 30, code: http.StatusUnprocessableEntity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := &countingReader{size: tc.size}
+			f := &Frontend{
+				limits: overrides,
+				roundTripper: RoundTripFunc(func(*http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode:    http.StatusOK,
+						Body:          ioutil.NopCloser(body),
+						ContentLength: tc.contentLength,
+					}, nil
+				}),
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+			w := httptest.NewRecorder()
+			f.handle(w, req)
+			assert.Equal(t, tc.code, w.Code)
+			if tc.code == http.StatusOK {
+				assert.Equal(t, 1024, w.Body.Len())
+			}
+
+			// The body isn't read beyond the limit.
+			assert.True(t, body.read <= 2*1024)
+		})
+	}
+}
+
+func TestWorkerMaxResponseSize(t *testing.T) {
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.MaxResponseSize = 1024
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		size int
+		code int32
+	}{
+		{name: "below the limit", size: 1024, code: http.StatusOK},
+		{name: "above the limit", size: 1 << 20, code: http.StatusUnprocessableEntity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			written := 0
+			w := &worker{
+				limits: overrides,
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					for written < tc.size {
+						n, err := w.Write(make([]byte, 512))
+						written += n
+						if err != nil {
+							return
+						}
+					}
+				}),
+			}
+
+			req := &httpgrpc.HTTPRequest{
+				Method:  "GET",
+				Url:     "/api/v1/query",
+				Headers: []*httpgrpc.Header{{Key: user.OrgIDHeaderName, Values: []string{"1"}}},
+			}
+			resp, err := w.handle(context.Background(), req, nil)
+			if tc.code != http.StatusOK {
+				resp, _ = httpgrpc.HTTPResponseFromError(err)
+			} else {
+				require.NoError(t, err)
+				assert.Len(t, resp.Body, tc.size)
+			}
+			assert.Equal(t, tc.code, resp.Code)
+
+			// The response isn't recorded beyond the limit.
+			assert.True(t, written <= 1024)
+		})
+	}
+}
+
+func TestFrontendSlowQueryLog(t *testing.T) {
+	var buf bytes.Buffer
+	f := &Frontend{
+		cfg: Config{LogQueriesLongerThan: time.Nanosecond},
+		log: log.NewLogfmtLogger(&buf),
+		roundTripper: RoundTripFunc(func(r *http.Request) (*http.Response, error) {
+			// Two split queries.
+			for i := 0; i < 2; i++ {
+				addResponseStats(stats.FromContext(r.Context()), &httpgrpc.HTTPResponse{
+					Headers: []*httpgrpc.Header{
+						{Key: QueueTimeHeader, Values: []string{"1s"}},
+						{Key: SeriesFetchedHeader, Values: []string{"2"}},
+						{Key: ChunksFetchedHeader, Values: []string{"3"}},
+						{Key: BytesFetchedHeader, Values: []string{"300"}},
+					},
+				})
+			}
+			time.Sleep(time.Millisecond)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader("{}")),
+			}, nil
+		}),
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/query_range?query=up&start=0&end=3600&step=60", nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+	f.handle(httptest.NewRecorder(), req)
+
+	line := buf.String()
+	for _, expected := range []string{
+		`msg="slow query detected"`, "user=1", "status_code=200", "param_query=up", "param_start=0",
+		"param_end=3600", "param_step=60", "querier_requests=2", "queue_time=2s", "series_fetched=4",
+		"chunks_fetched=6", "bytes_fetched=600",
+	} {
+		assert.Contains(t, line, expected)
+	}
+	assert.NotContains(t, line, "param_time")
+
+	// The faster queries aren't logged.
+	buf.Reset()
+	f.cfg.LogQueriesLongerThan = time.Hour
+	f.handle(httptest.NewRecorder(), req)
+	assert.Empty(t, buf.String())
+}
+
+func TestFrontendTypedQuery(t *testing.T) {
+	var received *queryrange.Request
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		typed := TypedQueryFromContext(r.Context())
+		require.NotNil(t, typed)
+		received = typed.Request
+		typed.Response = &queryrange.APIResponse{
+			Status: "success",
+			Data: queryrange.Response{
+				ResultType: "matrix",
+				Result:     []queryrange.SampleStream{},
+			},
+			Warnings: []string{"typed"},
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	test := func(addr string) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/%s", addr, query), nil)
+		require.NoError(t, err)
+		err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), "1"), req)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		// The handler wrote no body: the response is the typed one.
+		assert.JSONEq(t, `{"status":"success","data":{"resultType":"matrix","result":[]},"warnings":["typed"]}`, string(body))
+		require.NotNil(t, received)
+		assert.Equal(t, "sum(container_memory_rss) by (namespace)", received.Query)
+	}
+
+	var config Config
+	flagext.DefaultValues(&config)
+	config.TypedQueryAPI = true
+	testFrontendWithConfig(t, config, handler, test)
+}
+
+// countingReader returns size bytes, counting the bytes read.
+type countingReader struct {
+	size, read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.read >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-r.read {
+		p = p[:r.size-r.read]
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
 func defaultOverrides(t *testing.T) *validation.Overrides {
 	var limits validation.Limits
 	flagext.DefaultValues(&limits)
@@ -143,14 +415,17 @@ func defaultOverrides(t *testing.T) *validation.Overrides {
 }
 
 func testFrontend(t *testing.T, handler http.Handler, test func(addr string)) {
+	var config Config
+	flagext.DefaultValues(&config)
+	config.SplitQueriesByDay = true
+	testFrontendWithConfig(t, config, handler, test)
+}
+
+func testFrontendWithConfig(t *testing.T, config Config, handler http.Handler, test func(addr string)) {
 	logger := log.NewNopLogger()
 
-	var (
-		config       Config
-		workerConfig WorkerConfig
-	)
-	flagext.DefaultValues(&config, &workerConfig)
-	config.SplitQueriesByDay = true
+	var workerConfig WorkerConfig
+	flagext.DefaultValues(&workerConfig)
 	workerConfig.Parallelism = 1
 
 	// localhost:0 prevents firewall warnings on Mac OS X.
@@ -161,7 +436,7 @@ func testFrontend(t *testing.T, handler http.Handler, test func(addr string)) {
 	httpListen, err := net.Listen("tcp", "localhost:0")
 	require.NoError(t, err)
 
-	frontend, err := New(config, logger, defaultOverrides(t))
+	frontend, err := New(config, logger, defaultOverrides(t), nil)
 	require.NoError(t, err)
 	defer frontend.Close()
 
@@ -183,9 +458,149 @@ func testFrontend(t *testing.T, handler http.Handler, test func(addr string)) {
 	go httpServer.Serve(httpListen)
 	go grpcServer.Serve(grpcListen)
 
-	worker, err := NewWorker(workerConfig, httpgrpc_server.NewServer(handler), logger)
+	worker, err := NewWorker(workerConfig, handler, defaultOverrides(t), logger)
 	require.NoError(t, err)
 	defer worker.Stop()
 
 	test(httpListen.Addr().String())
 }
+
+// blockingFrontendClient blocks processing until its context is cancelled,
+// counting the streams in progress.
+type blockingFrontendClient struct {
+	FrontendClient
+	active int32
+}
+
+func (c *blockingFrontendClient) Process(ctx context.Context, _ ...grpc.CallOption) (Frontend_ProcessClient, error) {
+	atomic.AddInt32(&c.active, 1)
+	defer atomic.AddInt32(&c.active, -1)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func TestWorkerMatchMaxConcurrency(t *testing.T) {
+	var cfg WorkerConfig
+	flagext.DefaultValues(&cfg)
+	cfg.MatchMaxConcurrency = true
+	cfg.MaxConcurrentRequests = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &worker{cfg: cfg, log: log.NewNopLogger(), ctx: ctx}
+
+	clients := map[string]*blockingFrontendClient{}
+	managers := map[string]*frontendManager{}
+	for _, addr := range []string{"a", "b", "c"} {
+		clients[addr] = &blockingFrontendClient{}
+		managers[addr] = &frontendManager{worker: w, conn: nopCloser{}, client: clients[addr]}
+	}
+
+	active := func(addr string) func() interface{} {
+		return func() interface{} { return atomic.LoadInt32(&clients[addr].active) }
+	}
+
+	w.resetConcurrency(managers)
+	test.Poll(t, time.Second, int32(2), active("a"))
+	test.Poll(t, time.Second, int32(2), active("b"))
+	test.Poll(t, time.Second, int32(1), active("c"))
+
+	// Once a frontend is gone, its share goes to the others.
+	managers["c"].stop()
+	delete(managers, "c")
+	w.resetConcurrency(managers)
+	test.Poll(t, time.Second, int32(3), active("a"))
+	test.Poll(t, time.Second, int32(2), active("b"))
+	test.Poll(t, time.Second, int32(0), active("c"))
+
+	for _, m := range managers {
+		m.stop()
+	}
+}
+
+// streamingFrontendClient serves the requests sent to its channel on a
+// single stream at a time.
+type streamingFrontendClient struct {
+	FrontendClient
+	requests  chan *ProcessRequest
+	responses chan *ProcessResponse
+}
+
+func (c *streamingFrontendClient) Process(ctx context.Context, _ ...grpc.CallOption) (Frontend_ProcessClient, error) {
+	return &streamingProcessClient{ctx: ctx, client: c}, nil
+}
+
+type streamingProcessClient struct {
+	Frontend_ProcessClient
+	ctx    context.Context
+	client *streamingFrontendClient
+}
+
+func (c *streamingProcessClient) Context() context.Context { return c.ctx }
+
+func (c *streamingProcessClient) Recv() (*ProcessRequest, error) {
+	select {
+	case req := <-c.client.requests:
+		return req, nil
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+func (c *streamingProcessClient) Send(resp *ProcessResponse) error {
+	c.client.responses <- resp
+	return nil
+}
+
+func TestWorkerDrainsOnScaleDown(t *testing.T) {
+	var cfg WorkerConfig
+	flagext.DefaultValues(&cfg)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			http.Error(w, r.Context().Err().Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &worker{cfg: cfg, log: log.NewNopLogger(), handler: handler, ctx: ctx}
+	client := &streamingFrontendClient{requests: make(chan *ProcessRequest), responses: make(chan *ProcessResponse, 1)}
+	m := &frontendManager{worker: w, conn: nopCloser{}, client: client}
+	m.concurrency(1)
+
+	client.requests <- &ProcessRequest{HttpRequest: &httpgrpc.HTTPRequest{Method: "GET", Url: "/"}}
+	<-started
+
+	// The request in progress completes before the connection is stopped.
+	stopped := make(chan struct{})
+	go func() {
+		m.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stopped while processing a request")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	resp := <-client.responses
+	require.Equal(t, int32(http.StatusOK), resp.HttpResponse.Code)
+	require.Equal(t, "ok", string(resp.HttpResponse.Body))
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("not stopped once the request completed")
+	}
+}
@@ -0,0 +1,72 @@
+package frontend
+
+import (
+	"net/http"
+
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// userLimits are the limits applied to the queries of a tenant, as named in
+// the overrides; the durations are formatted, e.g. 12h0m0s, and 0 disables a
+// limit, apart from the engine limits, where it stands for the limits of the
+// queriers.
+type userLimits struct {
+	MaxQueryLength               string `json:"max_query_length"`
+	MaxQueryLookback             string `json:"max_query_lookback"`
+	MaxQueryParallelism          int    `json:"max_query_parallelism"`
+	MaxSeriesPerQuery            int    `json:"max_series_per_query"`
+	MaxSamplesPerQuery           int    `json:"max_samples_per_query"`
+	MaxFetchedSeriesPerQuery     int    `json:"max_fetched_series_per_query"`
+	MaxFetchedChunksPerQuery     int    `json:"max_fetched_chunks_per_query"`
+	MaxFetchedChunkBytesPerQuery int    `json:"max_fetched_chunk_bytes_per_query"`
+	MaxQueryResponseSize         int    `json:"max_query_response_size"`
+	MaxOutstandingRequests       int    `json:"max_outstanding_requests_per_tenant"`
+	QueryEngineTimeout           string `json:"query_engine_timeout"`
+	QueryEngineMaxSamples        int    `json:"query_engine_max_samples"`
+	AutoStepMaxPoints            int    `json:"auto_step_max_points"`
+	ResultsCacheTTL              string `json:"results_cache_ttl"`
+	MaxCacheFreshness            string `json:"max_cache_freshness"`
+	QueryPartialResults          bool   `json:"query_partial_results"`
+	ReadConsistency              string `json:"read_consistency"`
+}
+
+// UserLimitsHandler returns the limits applied to the queries of the calling
+// tenant, so that they can understand why their queries are rejected. The
+// outstanding requests default to the limit of the frontend.
+func (f *Frontend) UserLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if f.limits == nil {
+		http.Error(w, "no limits configured", http.StatusNotFound)
+		return
+	}
+
+	maxOutstanding := f.cfg.MaxOutstandingPerTenant
+	if limit := f.limits.MaxOutstandingPerTenant(userID); limit > 0 {
+		maxOutstanding = limit
+	}
+	util.WriteJSONResponse(w, userLimits{
+		MaxQueryLength:               f.limits.MaxQueryLength(userID).String(),
+		MaxQueryLookback:             f.limits.MaxQueryLookback(userID).String(),
+		MaxQueryParallelism:          f.limits.MaxQueryParallelism(userID),
+		MaxSeriesPerQuery:            f.limits.MaxSeriesPerQuery(userID),
+		MaxSamplesPerQuery:           f.limits.MaxSamplesPerQuery(userID),
+		MaxFetchedSeriesPerQuery:     f.limits.MaxFetchedSeriesPerQuery(userID),
+		MaxFetchedChunksPerQuery:     f.limits.MaxFetchedChunksPerQuery(userID),
+		MaxFetchedChunkBytesPerQuery: f.limits.MaxFetchedChunkBytesPerQuery(userID),
+		MaxQueryResponseSize:         f.limits.MaxResponseSize(userID),
+		MaxOutstandingRequests:       maxOutstanding,
+		QueryEngineTimeout:           f.limits.QueryEngineTimeout(userID).String(),
+		QueryEngineMaxSamples:        f.limits.QueryEngineMaxSamples(userID),
+		AutoStepMaxPoints:            f.limits.AutoStepMaxPoints(userID),
+		ResultsCacheTTL:              f.limits.ResultsCacheTTL(userID).String(),
+		MaxCacheFreshness:            f.cfg.ResultsCacheConfig.MaxCacheFreshness.String(),
+		QueryPartialResults:          f.limits.QueryPartialResults(userID),
+		ReadConsistency:              f.limits.ReadConsistency(userID),
+	})
+}
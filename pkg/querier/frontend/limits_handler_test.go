@@ -0,0 +1,61 @@
+package frontend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestUserLimitsHandler(t *testing.T) {
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	limits.MaxQueryLength = 7 * 24 * time.Hour
+	limits.MaxQueryParallelism = 32
+	limits.ResultsCacheTTL = time.Hour
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+
+	f := &Frontend{
+		cfg:    Config{MaxOutstandingPerTenant: 100},
+		limits: overrides,
+	}
+	f.cfg.ResultsCacheConfig.MaxCacheFreshness = time.Minute
+
+	req := httptest.NewRequest("GET", "/api/prom/api/v1/user_limits", nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+	w := httptest.NewRecorder()
+	f.UserLimitsHandler(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"max_query_length": "168h0m0s",
+		"max_query_lookback": "0s",
+		"max_query_parallelism": 32,
+		"max_series_per_query": 100000,
+		"max_samples_per_query": 1000000,
+		"max_fetched_series_per_query": 0,
+		"max_fetched_chunks_per_query": 0,
+		"max_fetched_chunk_bytes_per_query": 0,
+		"max_query_response_size": 0,
+		"max_outstanding_requests_per_tenant": 100,
+		"query_engine_timeout": "0s",
+		"query_engine_max_samples": 0,
+		"auto_step_max_points": 0,
+		"results_cache_ttl": "1h0m0s",
+		"max_cache_freshness": "1m0s",
+		"query_partial_results": false,
+		"read_consistency": "eventual"
+	}`, w.Body.String())
+
+	// The requests need a tenant.
+	w = httptest.NewRecorder()
+	f.UserLimitsHandler(w, httptest.NewRequest("GET", "/api/prom/api/v1/user_limits", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
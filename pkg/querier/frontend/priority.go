@@ -0,0 +1,66 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/weaveworks/common/httpgrpc"
+)
+
+// QueryPriorityHeader is the header of the requests setting their priority in
+// the queue: interactive (the default), ruler or batch. The queued requests of
+// the highest priority are processed first, so that e.g. the dashboards stay
+// responsive while bulk exports queue behind them.
+const QueryPriorityHeader = "X-Cortex-Query-Priority"
+
+type priority int
+
+const (
+	priorityInteractive priority = iota
+	priorityRuler
+	priorityBatch
+	numPriorities
+)
+
+var priorityNames = [numPriorities]string{"interactive", "ruler", "batch"}
+
+func (p priority) String() string {
+	return priorityNames[p]
+}
+
+func parsePriority(value string) (priority, error) {
+	if value == "" {
+		return priorityInteractive, nil
+	}
+	for p, name := range priorityNames {
+		if value == name {
+			return priority(p), nil
+		}
+	}
+	return 0, httpgrpc.Errorf(http.StatusBadRequest, "invalid %s header %q, expected one of %v", QueryPriorityHeader, value, priorityNames)
+}
+
+// requestPriority returns the priority of a request from its header.
+func requestPriority(req *ProcessRequest) (priority, error) {
+	if req.HttpRequest != nil {
+		for _, h := range req.HttpRequest.Headers {
+			if http.CanonicalHeaderKey(h.Key) == QueryPriorityHeader && len(h.Values) > 0 {
+				return parsePriority(h.Values[0])
+			}
+		}
+	}
+	return priorityInteractive, nil
+}
+
+type priorityKey int
+
+// withPriority keeps the priority of a request for the requests the frontend
+// sends for it, e.g. the split queries built without its headers.
+func withPriority(ctx context.Context, p priority) context.Context {
+	return context.WithValue(ctx, priorityKey(0), p)
+}
+
+func priorityFromContext(ctx context.Context) (priority, bool) {
+	p, ok := ctx.Value(priorityKey(0)).(priority)
+	return p, ok
+}
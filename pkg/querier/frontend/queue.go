@@ -0,0 +1,355 @@
+package frontend
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// queue is the fair queue of the requests of the tenants, from which the
+// queriers pull the requests with Frontend.Process. It is part of the
+// frontend, or of the scheduler, shared by the frontends.
+//
+// The requests of the highest priority queued are processed first; between
+// the tenants which queued them, the next request is picked at random,
+// weighted by the weight of the tenant.
+//
+// A federated request, of several tenants, is queued in the queue of its
+// first tenant, rather than in a queue of its own, and counts towards the
+// max outstanding requests of each of its tenants, so that combining tenants
+// doesn't bypass their fairness and limits.
+type queue struct {
+	maxOutstandingPerTenant int
+	limits                  queueLimits
+
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	queues  map[string]*tenantQueue
+	lengths [numPriorities]int
+
+	// The number of queued requests of each tenant, its federated requests
+	// queued in the queue of another tenant included.
+	outstanding map[string]int
+}
+
+// queueLimits are the per-tenant limits of the queue.
+type queueLimits interface {
+	// QueryWeight returns the weight of the tenant; below 1 is treated as 1.
+	QueryWeight(userID string) int
+	// MaxOutstandingPerTenant returns the maximum number of queued requests of
+	// the tenant; 0 for the limit of the queue.
+	MaxOutstandingPerTenant(userID string) int
+}
+
+// tenantQueue holds the queued requests of a tenant, by priority.
+type tenantQueue struct {
+	requests [numPriorities][]*request
+	length   int
+}
+
+type request struct {
+	userID      string   // The tenant whose queue holds the request.
+	tenants     []string // The tenants of the request, several if federated.
+	enqueueTime time.Time
+	priority    priority
+	queueSpan   opentracing.Span
+	originalCtx context.Context
+
+	request  *ProcessRequest
+	err      chan error
+	response chan *ProcessResponse
+}
+
+// newQueue creates a new queue; nil limits give the same weight and limit to
+// all the tenants.
+func newQueue(maxOutstandingPerTenant int, limits queueLimits) *queue {
+	q := &queue{
+		maxOutstandingPerTenant: maxOutstandingPerTenant,
+		limits:                  limits,
+		queues:                  map[string]*tenantQueue{},
+		outstanding:             map[string]int{},
+	}
+	q.cond = sync.NewCond(&q.mtx)
+	return q
+}
+
+// close waits for the queued requests to be processed.
+func (q *queue) close() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for len(q.queues) > 0 {
+		q.cond.Wait()
+	}
+}
+
+// roundTrip queues the request, and returns its response once processed by a
+// querier.
+func (q *queue) roundTrip(ctx context.Context, req *ProcessRequest) (*ProcessResponse, error) {
+	request := request{
+		request:     req,
+		originalCtx: ctx,
+
+		// Buffer of 1 to ensure response can be written by the server side
+		// of the Process stream, even if this goroutine goes away due to
+		// client context cancellation.
+		err:      make(chan error, 1),
+		response: make(chan *ProcessResponse, 1),
+	}
+
+	if err := q.queueRequest(ctx, &request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		// The request is evicted if it's still queued, e.g. when the client
+		// disconnected, so that it doesn't hold the queue of the tenant.
+		q.evict(&request)
+		return nil, errCanceled
+
+	case resp := <-request.response:
+		return resp, nil
+
+	case err := <-request.err:
+		return nil, err
+	}
+}
+
+// process sends the queued requests to a querier.
+func (q *queue) process(server Frontend_ProcessServer) error {
+	// If the downstream request(from querier -> frontend) is cancelled,
+	// we need to ping the condition variable to unblock getNextRequest.
+	// Ideally we'd have ctx aware condition variables...
+	go func() {
+		<-server.Context().Done()
+		q.cond.Broadcast()
+	}()
+
+	for {
+		req, err := q.getNextRequest(server.Context())
+		if err != nil {
+			return err
+		}
+
+		// Skip the requests canceled before they could be evicted, rather
+		// than closing the stream of the querier.
+		if req.originalCtx.Err() != nil {
+			continue
+		}
+
+		// Handle the stream sending & receiving on a goroutine so we can
+		// monitoring the contexts in a select and cancel things appropriately.
+		resps := make(chan *ProcessResponse, 1)
+		errs := make(chan error, 1)
+		go func() {
+			err = server.Send(req.request)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			resp, err := server.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			resps <- resp
+		}()
+
+		select {
+		// If the upstream reqeust is cancelled, we need to cancel the
+		// downstream req.  Only way we can do that is to close the stream.
+		// The worker client is expecting this semantics.
+		case <-req.originalCtx.Done():
+			return req.originalCtx.Err()
+
+		// Is there was an error handling this request due to network IO,
+		// then error out this upstream request _and_ stream.
+		case err := <-errs:
+			req.err <- err
+			return err
+
+		// Happy path: propagate the response.
+		case resp := <-resps:
+			req.response <- resp
+		}
+	}
+}
+
+func (q *queue) queueRequest(ctx context.Context, req *request) error {
+	orgID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return err
+	}
+	tenants := util.TenantIDs(orgID)
+	userID := tenants[0]
+
+	req.priority, err = requestPriority(req.request)
+	if err != nil {
+		return err
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for _, tenant := range tenants {
+		maxOutstanding := q.maxOutstandingPerTenant
+		if q.limits != nil {
+			if limit := q.limits.MaxOutstandingPerTenant(tenant); limit > 0 {
+				maxOutstanding = limit
+			}
+		}
+		if q.outstanding[tenant] >= maxOutstanding {
+			return errTooManyRequest
+		}
+	}
+
+	queue := q.queues[userID]
+	if queue == nil {
+		queue = &tenantQueue{}
+	}
+	q.queues[userID] = queue
+	for _, tenant := range tenants {
+		q.outstanding[tenant]++
+	}
+
+	req.userID = userID
+	req.tenants = tenants
+	req.enqueueTime = time.Now()
+	req.queueSpan, _ = opentracing.StartSpanFromContext(ctx, "queued")
+
+	queue.requests[req.priority] = append(queue.requests[req.priority], req)
+	queue.length++
+	q.lengths[req.priority]++
+	queueLength.Add(1)
+	q.cond.Broadcast()
+	return nil
+}
+
+// getNextRequest picks a random queue, weighted by the weights of the tenants,
+// and takes the next request of the highest priority off of it, so we fairly
+// process users queries.  Will block if there are no requests.
+func (q *queue) getNextRequest(ctx context.Context) (*request, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for len(q.queues) == 0 && ctx.Err() == nil {
+		q.cond.Wait()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// The requests of the highest priority queued go first.
+	p := priorityInteractive
+	for q.lengths[p] == 0 {
+		p++
+	}
+
+	type candidate struct {
+		userID string
+		queue  *tenantQueue
+		weight int
+	}
+	var (
+		candidates []candidate
+		total      int
+	)
+	for userID, queue := range q.queues {
+		if len(queue.requests[p]) == 0 {
+			continue
+		}
+		weight := 1
+		if q.limits != nil {
+			if w := q.limits.QueryWeight(userID); w > 1 {
+				weight = w
+			}
+		}
+		candidates = append(candidates, candidate{userID: userID, queue: queue, weight: weight})
+		total += weight
+	}
+
+	n := rand.Intn(total)
+	for _, c := range candidates {
+		if n >= c.weight {
+			n -= c.weight
+			continue
+		}
+
+		queue := c.queue
+		request := queue.requests[p][0]
+		queue.requests[p][0] = nil
+		queue.requests[p] = queue.requests[p][1:]
+		queue.length--
+		q.lengths[p]--
+		if queue.length == 0 {
+			delete(q.queues, c.userID)
+		}
+		q.dequeued(request)
+
+		// Tell close() we've processed a request.
+		q.cond.Broadcast()
+
+		queueTime := time.Now().Sub(request.enqueueTime)
+		queueDuration.Observe(queueTime.Seconds())
+		queueLength.Add(-1)
+		request.queueSpan.Finish()
+
+		// The queriers report the time spent in the queue in the query stats.
+		(*httpgrpcHeadersCarrier)(request.request.HttpRequest).Set(QueueTimeHeader, queueTime.String())
+
+		return request, nil
+	}
+
+	panic("should never happen")
+}
+
+// evict removes a request from the queue, if it's still queued.
+func (q *queue) evict(req *request) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	queue := q.queues[req.userID]
+	if queue == nil {
+		return
+	}
+	requests := queue.requests[req.priority]
+	for i, r := range requests {
+		if r != req {
+			continue
+		}
+
+		queue.requests[req.priority] = append(requests[:i], requests[i+1:]...)
+		queue.length--
+		q.lengths[req.priority]--
+		if queue.length == 0 {
+			delete(q.queues, req.userID)
+		}
+		q.dequeued(req)
+		queueLength.Add(-1)
+		evictedRequests.Inc()
+		req.queueSpan.Finish()
+
+		// Tell close() we've removed a request.
+		q.cond.Broadcast()
+		return
+	}
+}
+
+// dequeued no longer counts a request taken off the queue towards the
+// outstanding requests of its tenants.
+func (q *queue) dequeued(req *request) {
+	for _, tenant := range req.tenants {
+		if q.outstanding[tenant]--; q.outstanding[tenant] <= 0 {
+			delete(q.outstanding, tenant)
+		}
+	}
+}
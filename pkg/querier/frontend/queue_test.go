@@ -0,0 +1,170 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+func queueTestRequest(t *testing.T, q *queue, userID, priority string) *request {
+	ctx := user.InjectOrgID(context.Background(), userID)
+	req := &request{
+		originalCtx: ctx,
+		request:     &ProcessRequest{HttpRequest: &httpgrpc.HTTPRequest{}},
+	}
+	if priority != "" {
+		(*httpgrpcHeadersCarrier)(req.request.HttpRequest).Set(QueryPriorityHeader, priority)
+	}
+	require.NoError(t, q.queueRequest(ctx, req))
+	return req
+}
+
+type queueTestLimits struct {
+	weights        map[string]int
+	maxOutstanding map[string]int
+}
+
+func (l queueTestLimits) QueryWeight(userID string) int {
+	return l.weights[userID]
+}
+
+func (l queueTestLimits) MaxOutstandingPerTenant(userID string) int {
+	return l.maxOutstanding[userID]
+}
+
+func TestQueuePriorities(t *testing.T) {
+	q := newQueue(10, nil)
+	batch := queueTestRequest(t, q, "1", "batch")
+	ruler := queueTestRequest(t, q, "2", "ruler")
+	interactive1 := queueTestRequest(t, q, "1", "")
+	interactive2 := queueTestRequest(t, q, "1", "interactive")
+
+	for _, expected := range []*request{interactive1, interactive2, ruler, batch} {
+		req, err := q.getNextRequest(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, expected, req)
+	}
+	assert.Empty(t, q.queues)
+}
+
+func TestQueueWeights(t *testing.T) {
+	q := newQueue(10, queueTestLimits{weights: map[string]int{"heavy": 3}}) // 0, treated as 1, for light.
+
+	processed := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		for _, userID := range []string{"heavy", "light"} {
+			if q.queues[userID] == nil {
+				queueTestRequest(t, q, userID, "")
+			}
+		}
+
+		req, err := q.getNextRequest(context.Background())
+		require.NoError(t, err)
+		userID, err := user.ExtractOrgID(req.originalCtx)
+		require.NoError(t, err)
+		processed[userID]++
+	}
+
+	ratio := float64(processed["heavy"]) / float64(processed["light"])
+	assert.InDelta(t, 3, ratio, 0.5)
+}
+
+func TestQueueLimits(t *testing.T) {
+	q := newQueue(1, nil)
+	queueTestRequest(t, q, "1", "batch")
+
+	// The limit covers the requests of all the priorities.
+	err := q.queueRequest(user.InjectOrgID(context.Background(), "1"), &request{
+		request: &ProcessRequest{HttpRequest: &httpgrpc.HTTPRequest{}},
+	})
+	assert.Equal(t, errTooManyRequest, err)
+
+	req := &request{
+		request: &ProcessRequest{HttpRequest: &httpgrpc.HTTPRequest{}},
+	}
+	(*httpgrpcHeadersCarrier)(req.request.HttpRequest).Set(QueryPriorityHeader, "urgent")
+	err = q.queueRequest(user.InjectOrgID(context.Background(), "2"), req)
+	resp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	assert.Equal(t, int32(http.StatusBadRequest), resp.Code)
+	assert.Len(t, q.queues, 1)
+}
+
+func TestQueuePerTenantLimits(t *testing.T) {
+	q := newQueue(1, queueTestLimits{maxOutstanding: map[string]int{"2": 2}})
+
+	queueTestRequest(t, q, "1", "")
+	err := q.queueRequest(user.InjectOrgID(context.Background(), "1"), &request{
+		request: &ProcessRequest{HttpRequest: &httpgrpc.HTTPRequest{}},
+	})
+	assert.Equal(t, errTooManyRequest, err)
+
+	// The limit of the tenant overrides the limit of the queue.
+	queueTestRequest(t, q, "2", "")
+	queueTestRequest(t, q, "2", "")
+	err = q.queueRequest(user.InjectOrgID(context.Background(), "2"), &request{
+		request: &ProcessRequest{HttpRequest: &httpgrpc.HTTPRequest{}},
+	})
+	assert.Equal(t, errTooManyRequest, err)
+}
+
+func TestQueueFederatedRequests(t *testing.T) {
+	q := newQueue(2, nil)
+
+	// The federated requests are queued in the queue of their first tenant,
+	// counting towards the limits of all their tenants.
+	queueTestRequest(t, q, "b|a", "")
+	queueTestRequest(t, q, "a|b", "")
+	err := q.queueRequest(user.InjectOrgID(context.Background(), "b"), &request{
+		request: &ProcessRequest{HttpRequest: &httpgrpc.HTTPRequest{}},
+	})
+	assert.Equal(t, errTooManyRequest, err)
+	queueTestRequest(t, q, "c", "")
+	assert.Len(t, q.queues, 2)
+	assert.Equal(t, 2, q.queues["a"].length)
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "c": 1}, q.outstanding)
+
+	for i := 0; i < 3; i++ {
+		_, err := q.getNextRequest(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Empty(t, q.queues)
+	assert.Empty(t, q.outstanding)
+	queueTestRequest(t, q, "b", "")
+}
+
+func TestQueueEvict(t *testing.T) {
+	q := newQueue(1, nil)
+
+	ctx, cancel := context.WithCancel(user.InjectOrgID(context.Background(), "1"))
+	errs := make(chan error)
+	go func() {
+		_, err := q.roundTrip(ctx, &ProcessRequest{HttpRequest: &httpgrpc.HTTPRequest{}})
+		errs <- err
+	}()
+	for {
+		q.mtx.Lock()
+		queued := len(q.queues)
+		q.mtx.Unlock()
+		if queued > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The canceled request is evicted, and no longer counts towards the limit.
+	cancel()
+	assert.Equal(t, errCanceled, <-errs)
+	q.mtx.Lock()
+	assert.Empty(t, q.queues)
+	assert.Empty(t, q.outstanding)
+	assert.Equal(t, 0, q.lengths[priorityInteractive])
+	q.mtx.Unlock()
+	queueTestRequest(t, q, "1", "")
+}
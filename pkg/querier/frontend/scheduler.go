@@ -0,0 +1,49 @@
+package frontend
+
+import (
+	"context"
+	"flag"
+
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// SchedulerConfig for a Scheduler.
+type SchedulerConfig struct {
+	MaxOutstandingPerTenant int `yaml:"max_outstanding_per_tenant"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *SchedulerConfig) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxOutstandingPerTenant, "query-scheduler.max-outstanding-requests-per-tenant", 100, "Maximum number of outstanding requests per tenant per scheduler; requests beyond this error with HTTP 429.")
+}
+
+// Scheduler holds the queue of the requests of the frontends, which use it
+// with -frontend.scheduler-address: the queriers pull the requests from the
+// schedulers rather than from each frontend, so that the frontends can be
+// scaled without splitting the queue of each tenant between them.
+type Scheduler struct {
+	queue *queue
+}
+
+// NewScheduler creates a new scheduler.
+func NewScheduler(cfg SchedulerConfig, limits *validation.Overrides) *Scheduler {
+	return &Scheduler{
+		queue: newQueue(cfg.MaxOutstandingPerTenant, limits),
+	}
+}
+
+// Enqueue queues a request of a frontend, and returns its response once
+// processed by a querier.
+func (s *Scheduler) Enqueue(ctx context.Context, req *ProcessRequest) (*ProcessResponse, error) {
+	return s.queue.roundTrip(ctx, req)
+}
+
+// Process allows backends to pull requests from the scheduler.
+func (s *Scheduler) Process(server Frontend_ProcessServer) error {
+	return s.queue.process(server)
+}
+
+// Close waits for the queued requests to be processed.
+func (s *Scheduler) Close() {
+	s.queue.close()
+}
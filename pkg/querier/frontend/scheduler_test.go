@@ -0,0 +1,91 @@
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestScheduler(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	var (
+		schedulerConfig SchedulerConfig
+		config          Config
+		workerConfig    WorkerConfig
+	)
+	flagext.DefaultValues(&schedulerConfig, &config, &workerConfig)
+	workerConfig.Parallelism = 1
+
+	// localhost:0 prevents firewall warnings on Mac OS X.
+	grpcListen, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	config.SchedulerAddress = grpcListen.Addr().String()
+	workerConfig.SchedulerAddress = grpcListen.Addr().String()
+
+	scheduler := NewScheduler(schedulerConfig, defaultOverrides(t))
+	defer scheduler.Close()
+
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(middleware.ServerUserHeaderInterceptor))
+	defer grpcServer.GracefulStop()
+	RegisterFrontendServer(grpcServer, scheduler)
+	RegisterSchedulerServer(grpcServer, scheduler)
+	go grpcServer.Serve(grpcListen)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hello World"))
+	})
+	worker, err := NewWorker(workerConfig, handler, defaultOverrides(t), logger)
+	require.NoError(t, err)
+	defer worker.Stop()
+
+	// Both frontends forward their requests to the scheduler.
+	for i := 0; i < 2; i++ {
+		frontend, err := New(config, logger, defaultOverrides(t), nil)
+		require.NoError(t, err)
+		defer frontend.Close()
+
+		httpListen, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		httpServer := http.Server{
+			Handler: middleware.AuthenticateUser.Wrap(frontend.Handler()),
+		}
+		defer httpServer.Shutdown(context.Background())
+		go httpServer.Serve(httpListen)
+
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/", httpListen.Addr().String()), nil)
+		require.NoError(t, err)
+		err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), "1"), req)
+		require.NoError(t, err)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello World", string(body))
+	}
+}
+
+func TestWorkerFrontendAndSchedulerAddress(t *testing.T) {
+	var cfg WorkerConfig
+	flagext.DefaultValues(&cfg)
+	cfg.Address = "frontend:9095"
+	cfg.SchedulerAddress = "scheduler:9095"
+
+	_, err := NewWorker(cfg, nil, nil, log.NewNopLogger())
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package frontend
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/querier/stats"
+)
+
+// The headers of the responses of the queriers holding the stats of the query,
+// summed by the frontend over the requests of a query, e.g. its split queries,
+// for the slow query log. The time the request was queued for is returned in
+// the QueueTimeHeader.
+const (
+	SeriesFetchedHeader = "X-Cortex-Series-Fetched"
+	ChunksFetchedHeader = "X-Cortex-Chunks-Fetched"
+	BytesFetchedHeader  = "X-Cortex-Bytes-Fetched"
+)
+
+// addResponseStats adds the stats of the response of a querier to the stats
+// of the query.
+func addResponseStats(s *stats.Stats, resp *httpgrpc.HTTPResponse) {
+	v := stats.Values{Requests: 1}
+	for _, h := range resp.Headers {
+		if len(h.Values) == 0 {
+			continue
+		}
+		switch http.CanonicalHeaderKey(h.Key) {
+		case QueueTimeHeader:
+			v.QueueTime, _ = time.ParseDuration(h.Values[0])
+		case SeriesFetchedHeader:
+			v.Series, _ = strconv.Atoi(h.Values[0])
+		case ChunksFetchedHeader:
+			v.Chunks, _ = strconv.Atoi(h.Values[0])
+		case BytesFetchedHeader:
+			v.Bytes, _ = strconv.Atoi(h.Values[0])
+		}
+	}
+	s.Add(v)
+}
+
+// logSlowQuery logs the queries which took longer than
+// -frontend.log-queries-longer-than, with their tenant, parameters, stats and
+// trace ID. Only the parameters in the URL are logged, the body of the
+// request having been sent to the queriers.
+func (f *Frontend) logSlowQuery(r *http.Request, code int, duration time.Duration, queryStats *stats.Stats) {
+	if f.cfg.LogQueriesLongerThan <= 0 || duration <= f.cfg.LogQueriesLongerThan {
+		return
+	}
+
+	userID, _ := user.ExtractOrgID(r.Context())
+	logMessage := []interface{}{
+		"msg", "slow query detected",
+		"user", userID,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status_code", code,
+		"time_taken", duration,
+	}
+	params := r.URL.Query()
+	for _, name := range []string{"query", "start", "end", "step", "time"} {
+		if v := params.Get(name); v != "" {
+			logMessage = append(logMessage, "param_"+name, v)
+		}
+	}
+
+	v := queryStats.Values()
+	logMessage = append(logMessage,
+		"querier_requests", v.Requests,
+		"queue_time", v.QueueTime,
+		"series_fetched", v.Series,
+		"chunks_fetched", v.Chunks,
+		"bytes_fetched", v.Bytes,
+	)
+
+	if span := opentracing.SpanFromContext(r.Context()); span != nil {
+		if spanContext, ok := span.Context().(jaeger.SpanContext); ok {
+			logMessage = append(logMessage, "trace_id", spanContext.TraceID().String())
+		}
+	}
+	level.Info(f.log).Log(logMessage...)
+}
@@ -0,0 +1,39 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+)
+
+// TypedQuery is a range query sent by the frontend with the typed query API,
+// -frontend.typed-query-api: the frontend sends the parsed request along with
+// the HTTP request, and the querier returns the response as protobuf, rather
+// than encoding and decoding it as JSON. The worker passes it in the context
+// of the HTTP request, so that the HTTP middlewares of the querier, e.g. the
+// authentication or the query stats, still handle the request; the querier
+// sets the response if it supports the typed query API, otherwise the JSON
+// response is returned.
+type TypedQuery struct {
+	Request  *queryrange.Request
+	Response *queryrange.APIResponse
+}
+
+type typedQueryKey int
+
+// WithTypedQuery returns a context holding the typed query.
+func WithTypedQuery(ctx context.Context, q *TypedQuery) context.Context {
+	return context.WithValue(ctx, typedQueryKey(0), q)
+}
+
+// TypedQueryFromContext returns the typed query of a request; nil if none.
+func TypedQueryFromContext(ctx context.Context) *TypedQuery {
+	q, _ := ctx.Value(typedQueryKey(0)).(*TypedQuery)
+	return q
+}
+
+// RoundTripTyped implements queryrange.TypedRoundTripper.
+func (f *Frontend) RoundTripTyped(r *http.Request, req *queryrange.Request) (*http.Response, *queryrange.APIResponse, error) {
+	return f.roundTrip(r, req)
+}
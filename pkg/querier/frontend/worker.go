@@ -1,23 +1,30 @@
 package frontend
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/weaveworks/common/httpgrpc"
-	"github.com/weaveworks/common/httpgrpc/server"
 	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/user"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/naming"
 
+	"github.com/cortexproject/cortex/pkg/querier/errstatus"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 var (
@@ -29,17 +36,24 @@ var (
 
 // WorkerConfig is config for a worker.
 type WorkerConfig struct {
-	Address           string
-	Parallelism       int
-	DNSLookupDuration time.Duration
+	Address             string
+	SchedulerAddress    string `yaml:"scheduler_address"`
+	Parallelism         int
+	MatchMaxConcurrency bool `yaml:"match_max_concurrent"`
+	DNSLookupDuration   time.Duration
 
 	GRPCClientConfig grpcclient.Config `yaml:"grpc_client_config"`
+
+	// The -querier.max-concurrent of the querier, set by the caller.
+	MaxConcurrentRequests int `yaml:"-"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet.
 func (cfg *WorkerConfig) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.Address, "querier.frontend-address", "", "Address of query frontend service.")
+	f.StringVar(&cfg.SchedulerAddress, "querier.scheduler-address", "", "Address of the query-scheduler service, to pull the requests from the schedulers rather than from the frontends.")
 	f.IntVar(&cfg.Parallelism, "querier.worker-parallelism", 10, "Number of simultaneous queries to process.")
+	f.BoolVar(&cfg.MatchMaxConcurrency, "querier.worker-match-max-concurrent", false, "Split -querier.max-concurrent between the frontends found, rather than processing -querier.worker-parallelism queries from each of them.")
 	f.DurationVar(&cfg.DNSLookupDuration, "querier.dns-lookup-period", 10*time.Second, "How often to query DNS.")
 
 	cfg.GRPCClientConfig.RegisterFlags("querier.frontend-client", f)
@@ -51,9 +65,10 @@ type Worker interface {
 }
 
 type worker struct {
-	cfg    WorkerConfig
-	log    log.Logger
-	server *server.Server
+	cfg     WorkerConfig
+	log     log.Logger
+	handler http.Handler
+	limits  *validation.Overrides
 
 	ctx     context.Context
 	cancel  context.CancelFunc
@@ -66,9 +81,17 @@ type noopWorker struct {
 
 func (noopWorker) Stop() {}
 
-// NewWorker creates a new Worker.
-func NewWorker(cfg WorkerConfig, server *server.Server, log log.Logger) (Worker, error) {
-	if cfg.Address == "" {
+// NewWorker creates a new Worker, serving the requests with the handler and
+// failing the responses larger than the max response size of the tenants.
+func NewWorker(cfg WorkerConfig, handler http.Handler, limits *validation.Overrides, log log.Logger) (Worker, error) {
+	address := cfg.Address
+	if cfg.SchedulerAddress != "" {
+		if cfg.Address != "" {
+			return nil, fmt.Errorf("only one of -querier.frontend-address and -querier.scheduler-address can be set")
+		}
+		address = cfg.SchedulerAddress
+	}
+	if address == "" {
 		level.Info(log).Log("msg", "no address specified, not starting worker")
 		return noopWorker{}, nil
 	}
@@ -78,7 +101,7 @@ func NewWorker(cfg WorkerConfig, server *server.Server, log log.Logger) (Worker,
 		return nil, err
 	}
 
-	watcher, err := resolver.Resolve(cfg.Address)
+	watcher, err := resolver.Resolve(address)
 	if err != nil {
 		return nil, err
 	}
@@ -86,9 +109,10 @@ func NewWorker(cfg WorkerConfig, server *server.Server, log log.Logger) (Worker,
 	ctx, cancel := context.WithCancel(context.Background())
 
 	w := &worker{
-		cfg:    cfg,
-		log:    log,
-		server: server,
+		cfg:     cfg,
+		log:     log,
+		handler: handler,
+		limits:  limits,
 
 		ctx:     ctx,
 		cancel:  cancel,
@@ -110,10 +134,10 @@ func (w *worker) Stop() {
 func (w *worker) watchDNSLoop() {
 	defer w.wg.Done()
 
-	cancels := map[string]context.CancelFunc{}
+	managers := map[string]*frontendManager{}
 	defer func() {
-		for _, cancel := range cancels {
-			cancel()
+		for _, m := range managers {
+			m.stop()
 		}
 	}()
 
@@ -128,42 +152,151 @@ func (w *worker) watchDNSLoop() {
 			switch update.Op {
 			case naming.Add:
 				level.Debug(w.log).Log("msg", "adding connection", "addr", update.Addr)
-				ctx, cancel := context.WithCancel(w.ctx)
-				cancels[update.Addr] = cancel
-				w.runMany(ctx, update.Addr)
+				m, err := w.newFrontendManager(update.Addr)
+				if err != nil {
+					level.Error(w.log).Log("msg", "error connecting", "addr", update.Addr, "err", err)
+					continue
+				}
+				managers[update.Addr] = m
 
 			case naming.Delete:
 				level.Debug(w.log).Log("msg", "removing connection", "addr", update.Addr)
-				if cancel, ok := cancels[update.Addr]; ok {
-					cancel()
+				if m, ok := managers[update.Addr]; ok {
+					// The requests being processed are completed in the
+					// background.
+					w.wg.Add(1)
+					go func() {
+						defer w.wg.Done()
+						m.stop()
+					}()
+					delete(managers, update.Addr)
 				}
 
 			default:
 				panic("unknown op")
 			}
 		}
+
+		w.resetConcurrency(managers)
+	}
+}
+
+// resetConcurrency sets the number of queries processed concurrently from
+// each frontend. With -querier.worker-match-max-concurrent, -querier.max-concurrent
+// is split between the frontends, each getting at least one.
+func (w *worker) resetConcurrency(managers map[string]*frontendManager) {
+	addresses := make([]string, 0, len(managers))
+	for addr := range managers {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+
+	for i, addr := range addresses {
+		concurrency := w.cfg.Parallelism
+		if w.cfg.MatchMaxConcurrency {
+			concurrency = w.cfg.MaxConcurrentRequests / len(addresses)
+			if i < w.cfg.MaxConcurrentRequests%len(addresses) {
+				concurrency++
+			}
+			if concurrency < 1 {
+				concurrency = 1
+			}
+		}
+		managers[addr].concurrency(concurrency)
 	}
 }
 
-// runMany starts N runOne loops for a given address.
-func (w *worker) runMany(ctx context.Context, address string) {
-	client, err := w.connect(address)
+// frontendManager runs the loops processing the requests of a frontend, or of
+// a scheduler.
+type frontendManager struct {
+	worker *worker
+	conn   io.Closer
+	client FrontendClient
+
+	wg       sync.WaitGroup
+	drainers []*drainer
+}
+
+func (w *worker) newFrontendManager(address string) (*frontendManager, error) {
+	conn, err := w.connect(address)
 	if err != nil {
-		level.Error(w.log).Log("msg", "error connecting", "addr", address, "err", err)
-		return
+		return nil, err
+	}
+	return &frontendManager{
+		worker: w,
+		conn:   conn,
+		client: NewFrontendClient(conn),
+	}, nil
+}
+
+// concurrency starts or stops runOne loops, so that n of them are running.
+// The loops stopped complete the request they're processing, if any.
+func (m *frontendManager) concurrency(n int) {
+	for len(m.drainers) < n {
+		ctx, cancel := context.WithCancel(m.worker.ctx)
+		d := &drainer{cancel: cancel}
+		m.drainers = append(m.drainers, d)
+
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			defer cancel()
+			m.worker.runOne(ctx, m.client, d)
+		}()
+	}
+
+	for len(m.drainers) > n {
+		m.drainers[len(m.drainers)-1].stop()
+		m.drainers = m.drainers[:len(m.drainers)-1]
+	}
+}
+
+// stop the runOne loops and close the connection to the frontend, once the
+// requests being processed are completed.
+func (m *frontendManager) stop() {
+	m.concurrency(0)
+	m.wg.Wait()
+	if err := m.conn.Close(); err != nil {
+		level.Error(m.worker.log).Log("msg", "error closing connection", "err", err)
+	}
+}
+
+// drainer cancels the context of a runOne loop once stopped, as soon as it
+// isn't processing a request, so that the request in progress completes.
+type drainer struct {
+	mtx      sync.Mutex
+	cancel   context.CancelFunc
+	busy     bool
+	stopping bool
+}
+
+func (d *drainer) stop() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.stopping = true
+	if !d.busy {
+		d.cancel()
 	}
+}
+
+func (d *drainer) start() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.busy = true
+}
 
-	w.wg.Add(w.cfg.Parallelism)
-	for i := 0; i < w.cfg.Parallelism; i++ {
-		go w.runOne(ctx, client)
+func (d *drainer) done() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.busy = false
+	if d.stopping {
+		d.cancel()
 	}
 }
 
 // runOne loops, trying to establish a stream to the frontend to begin
 // request processing.
-func (w *worker) runOne(ctx context.Context, client FrontendClient) {
-	defer w.wg.Done()
-
+func (w *worker) runOne(ctx context.Context, client FrontendClient, d *drainer) {
 	backoff := util.NewBackoff(ctx, backoffConfig)
 	for backoff.Ongoing() {
 		c, err := client.Process(ctx)
@@ -173,7 +306,7 @@ func (w *worker) runOne(ctx context.Context, client FrontendClient) {
 			continue
 		}
 
-		if err := w.process(c); err != nil {
+		if err := w.process(c, d); err != nil {
 			level.Error(w.log).Log("msg", "error processing requests", "err", err)
 			backoff.Wait()
 			continue
@@ -184,7 +317,7 @@ func (w *worker) runOne(ctx context.Context, client FrontendClient) {
 }
 
 // process loops processing requests on an established stream.
-func (w *worker) process(c Frontend_ProcessClient) error {
+func (w *worker) process(c Frontend_ProcessClient, d *drainer) error {
 	// Build a child context so we can cancel querie when the stream is closed.
 	ctx, cancel := context.WithCancel(c.Context())
 	defer cancel()
@@ -194,6 +327,7 @@ func (w *worker) process(c Frontend_ProcessClient) error {
 		if err != nil {
 			return err
 		}
+		d.start()
 
 		// Handle the request on a "background" goroutine, so we go back to
 		// blocking on c.Recv().  This allows us to detect the stream closing
@@ -201,43 +335,109 @@ func (w *worker) process(c Frontend_ProcessClient) error {
 		// here, as we're running in lock step with the server - each Recv is
 		// paired with a Send.
 		go func() {
-			response, err := w.server.Handle(ctx, request.HttpRequest)
+			defer d.done()
+
+			ctx := ctx
+			var typed *TypedQuery
+			if request.QueryRangeRequest != nil {
+				typed = &TypedQuery{Request: request.QueryRangeRequest}
+				ctx = WithTypedQuery(ctx, typed)
+			}
+
+			response, err := w.handle(ctx, request.HttpRequest, typed)
 			if err != nil {
 				var ok bool
 				response, ok = httpgrpc.HTTPResponseFromError(err)
 				if !ok {
 					response = &httpgrpc.HTTPResponse{
-						Code: http.StatusInternalServerError,
+						Code: int32(errstatus.Code(err)),
 						Body: []byte(err.Error()),
 					}
 				}
 			}
 
+			processResponse := &ProcessResponse{
+				HttpResponse: response,
+			}
+			// The parsed response of a typed query replaces the body.
+			if typed != nil && typed.Response != nil && err == nil {
+				processResponse.ApiResponse = typed.Response
+			}
+
 			// Ensure responses that are too big are not retried.
-			if len(response.Body) >= w.cfg.GRPCClientConfig.MaxSendMsgSize {
-				errMsg := fmt.Sprintf("response larger than the max (%d vs %d)", len(response.Body), w.cfg.GRPCClientConfig.MaxSendMsgSize)
-				response = &httpgrpc.HTTPResponse{
-					Code: http.StatusRequestEntityTooLarge,
-					Body: []byte(errMsg),
+			if size := processResponse.Size(); size >= w.cfg.GRPCClientConfig.MaxSendMsgSize {
+				errMsg := fmt.Sprintf("response larger than the max (%d vs %d)", size, w.cfg.GRPCClientConfig.MaxSendMsgSize)
+				processResponse = &ProcessResponse{
+					HttpResponse: &httpgrpc.HTTPResponse{
+						Code: http.StatusRequestEntityTooLarge,
+						Body: []byte(errMsg),
+					},
 				}
 				level.Error(w.log).Log("msg", "error processing query", "err", errMsg)
 			}
 
-			if err := c.Send(&ProcessResponse{
-				HttpResponse: response,
-			}); err != nil {
+			if err := c.Send(processResponse); err != nil {
 				level.Error(w.log).Log("msg", "error processing requests", "err", err)
 			}
 		}()
 	}
 }
 
-func (w *worker) connect(address string) (FrontendClient, error) {
-	opts := []grpc.DialOption{grpc.WithInsecure()}
-	opts = append(opts, w.cfg.GRPCClientConfig.DialOption([]grpc.UnaryClientInterceptor{middleware.ClientUserHeaderInterceptor}, nil)...)
-	conn, err := grpc.Dial(address, opts...)
+// handle serves the request with the handler of the querier, like the
+// httpgrpc server does, but fails it with HTTP 422 as soon as the body of the
+// response, or the parsed response of a typed query, exceeds the max response
+// size of the tenant, rather than buffering all of it.
+func (w *worker) handle(ctx context.Context, r *httpgrpc.HTTPRequest, typed *TypedQuery) (*httpgrpc.HTTPResponse, error) {
+	req, err := http.NewRequest(r.Method, r.Url, ioutil.NopCloser(bytes.NewReader(r.Body)))
 	if err != nil {
 		return nil, err
 	}
-	return NewFrontendClient(conn), nil
+	for _, h := range r.Headers {
+		req.Header[http.CanonicalHeaderKey(h.Key)] = h.Values
+	}
+	req = req.WithContext(ctx)
+	req.RequestURI = r.Url
+
+	recorder := &limitedRecorder{ResponseRecorder: httptest.NewRecorder()}
+	if userID, _, err := user.ExtractOrgIDFromHTTPRequest(req); err == nil && w.limits != nil {
+		recorder.limit = util.SmallestPositiveIntPerTenant(userID, w.limits.MaxResponseSize)
+	}
+	w.handler.ServeHTTP(recorder, req)
+
+	if recorder.exceeded || (recorder.limit > 0 && typed != nil && typed.Response != nil && typed.Response.Size() > recorder.limit) {
+		return nil, httpgrpc.Errorf(http.StatusUnprocessableEntity, errResponseTooLarge, recorder.limit)
+	}
+	resp := &httpgrpc.HTTPResponse{
+		Code: int32(recorder.Code),
+		Body: recorder.Body.Bytes(),
+	}
+	for k, vs := range recorder.Header() {
+		resp.Headers = append(resp.Headers, &httpgrpc.Header{Key: k, Values: vs})
+	}
+	if recorder.Code/100 == 5 {
+		return nil, httpgrpc.ErrorFromHTTPResponse(resp)
+	}
+	return resp, nil
+}
+
+// limitedRecorder records a response, failing the writes beyond limit bytes
+// of body; 0 disables the limit.
+type limitedRecorder struct {
+	*httptest.ResponseRecorder
+	limit    int
+	exceeded bool
+}
+
+func (r *limitedRecorder) Write(b []byte) (int, error) {
+	if r.limit > 0 && r.Body.Len()+len(b) > r.limit {
+		r.exceeded = true
+		return 0, fmt.Errorf(errResponseTooLarge, r.limit)
+	}
+	return r.ResponseRecorder.Write(b)
+}
+
+func (w *worker) connect(address string) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	opts = append(opts, w.cfg.GRPCClientConfig.DialOption([]grpc.UnaryClientInterceptor{middleware.ClientUserHeaderInterceptor}, nil)...)
+	return grpc.Dial(address, opts...)
 }
@@ -65,6 +65,52 @@ func (i ingesterQueryable) Get(ctx context.Context, userID string, from, through
 	return chunks, nil
 }
 
+// selectSeries queries the ingesters and returns a set of the series they
+// returned, along with the size of their chunk data. The chunks of a series are
+// only decoded when it is iterated.
+func (i ingesterQueryable) selectSeries(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) (storage.SeriesSet, int, error) {
+	results, err := i.distributor.QueryStream(ctx, from, through, matchers...)
+	if err != nil {
+		return nil, 0, promql.ErrStorage{Err: err}
+	}
+
+	limiter := queryLimiterFromContext(ctx)
+	interner := labelInternerFromContext(ctx)
+	size := 0
+	serieses := make([]storage.Series, 0, len(results))
+	for _, result := range results {
+		// Sometimes the ingester can send series that have no data.
+		if len(result.Chunks) == 0 {
+			continue
+		}
+
+		seriesSize := 0
+		for _, c := range result.Chunks {
+			seriesSize += len(c.Data)
+		}
+		size += seriesSize
+
+		ls := interner.intern(client.FromLabelAdaptersToLabels(result.Labels))
+		sort.Sort(ls)
+		if err := limiter.addSeries(client.Fingerprint(ls)); err != nil {
+			return nil, 0, err
+		}
+		if err := limiter.addChunks(len(result.Chunks), seriesSize); err != nil {
+			return nil, 0, err
+		}
+		serieses = append(serieses, &ingesterChunkSeries{
+			userID:            userID,
+			labels:            ls,
+			chunks:            result.Chunks,
+			chunkIteratorFunc: i.chunkIteratorFunc,
+			mint:              from,
+			maxt:              through,
+		})
+	}
+
+	return newConcreteSeriesSet(serieses), size, nil
+}
+
 type ingesterStreamingQuerier struct {
 	chunkIteratorFunc chunkIteratorFunc
 	distributorQuerier
@@ -82,32 +128,36 @@ func (q *ingesterStreamingQuerier) Select(sp *storage.SelectParams, matchers ...
 		maxt = sp.End
 	}
 
-	results, err := q.distributor.QueryStream(q.ctx, model.Time(mint), model.Time(maxt), matchers...)
+	i := ingesterQueryable{
+		distributor:       q.distributor,
+		chunkIteratorFunc: q.chunkIteratorFunc,
+	}
+	set, _, err := i.selectSeries(q.ctx, userID, model.Time(mint), model.Time(maxt), matchers...)
 	if err != nil {
-		return nil, nil, promql.ErrStorage{Err: err}
+		return nil, nil, err
 	}
+	return set, nil, nil
+}
 
-	serieses := make([]storage.Series, 0, len(results))
-	for _, result := range results {
-		// Sometimes the ingester can send series that have no data.
-		if len(result.Chunks) == 0 {
-			continue
-		}
+// ingesterChunkSeries is a series returned by the ingesters, whose chunks are
+// kept in their wire format until the series is iterated.
+type ingesterChunkSeries struct {
+	userID            string
+	labels            labels.Labels
+	chunks            []client.Chunk
+	chunkIteratorFunc chunkIteratorFunc
+	mint, maxt        model.Time
+}
 
-		chunks, err := chunkcompat.FromChunks(userID, nil, result.Chunks)
-		if err != nil {
-			return nil, nil, promql.ErrStorage{Err: err}
-		}
+func (s *ingesterChunkSeries) Labels() labels.Labels {
+	return s.labels
+}
 
-		ls := client.FromLabelAdaptersToLabels(result.Labels)
-		sort.Sort(ls)
-		series := &chunkSeries{
-			labels:            ls,
-			chunks:            chunks,
-			chunkIteratorFunc: q.chunkIteratorFunc,
-		}
-		serieses = append(serieses, series)
+// Iterator returns a new iterator of the data of the series.
+func (s *ingesterChunkSeries) Iterator() storage.SeriesIterator {
+	chunks, err := chunkcompat.FromChunks(s.userID, nil, s.chunks)
+	if err != nil {
+		return errIterator{promql.ErrStorage{Err: err}}
 	}
-
-	return newConcreteSeriesSet(serieses), nil, nil
+	return s.chunkIteratorFunc(chunks, s.mint, s.maxt)
 }
@@ -61,3 +61,31 @@ func TestIngesterStreaming(t *testing.T) {
 	require.False(t, seriesSet.Next())
 	require.NoError(t, seriesSet.Err())
 }
+
+func TestIngesterStreamingDecodesChunksLazily(t *testing.T) {
+	d := &mockDistributor{
+		r: []client.TimeSeriesChunk{
+			{
+				Labels: []client.LabelAdapter{
+					{Name: "foo", Value: "bar"},
+				},
+				Chunks: []client.Chunk{
+					{Encoding: -1, Data: []byte("invalid")},
+				},
+			},
+		},
+	}
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newIngesterStreamingQueryable(d, mergeChunks)
+	querier, err := queryable.Querier(ctx, mint, maxt)
+	require.NoError(t, err)
+
+	// The invalid chunk is only decoded once the series is iterated.
+	seriesSet, _, err := querier.Select(nil)
+	require.NoError(t, err)
+	require.True(t, seriesSet.Next())
+
+	iter := seriesSet.At().Iterator()
+	require.False(t, iter.Next())
+	require.Error(t, iter.Err())
+}
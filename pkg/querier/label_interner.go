@@ -0,0 +1,118 @@
+package querier
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+var (
+	internedLabelStrings = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cortex",
+		Name:      "querier_interned_label_strings",
+		Help:      "The number of unique label names and values held by the label interners of the running queries.",
+	})
+	internedLabelStringsPerQuery = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "cortex",
+		Name:      "querier_interned_label_strings_per_query",
+		Help:      "The number of unique label names and values held by the label interner of a query.",
+		Buckets:   prometheus.ExponentialBuckets(10, 4, 8),
+	})
+	deduplicatedLabelStrings = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "querier_deduplicated_label_strings_total",
+		Help:      "The total number of label names and values replaced by the copy held by the label interner of the query.",
+	})
+)
+
+// labelInterner deduplicates the label names and values of the series fetched
+// by a query, from the ingesters and the store, so that the many copies of the
+// same strings decoded from the responses, e.g. once per replica of a series
+// or per chunk, are freed as soon as the series are built, rather than held
+// until the query is done. The interner is dropped with the query. A nil
+// labelInterner doesn't intern.
+type labelInterner struct {
+	mtx     sync.Mutex
+	strings map[string]string
+}
+
+// newLabelInterner returns the interner of a query, which is released when ctx
+// is done; nil if ctx is never done.
+func newLabelInterner(ctx context.Context) *labelInterner {
+	if ctx.Done() == nil {
+		return nil
+	}
+
+	i := &labelInterner{
+		strings: map[string]string{},
+	}
+	go func() {
+		<-ctx.Done()
+		i.mtx.Lock()
+		defer i.mtx.Unlock()
+		internedLabelStrings.Sub(float64(len(i.strings)))
+		internedLabelStringsPerQuery.Observe(float64(len(i.strings)))
+	}()
+	return i
+}
+
+type labelInternerKey int
+
+func withLabelInterner(ctx context.Context, i *labelInterner) context.Context {
+	return context.WithValue(ctx, labelInternerKey(0), i)
+}
+
+// labelInternerFromContext returns the interner of the query; nil if none.
+func labelInternerFromContext(ctx context.Context) *labelInterner {
+	i, _ := ctx.Value(labelInternerKey(0)).(*labelInterner)
+	return i
+}
+
+// intern returns a copy of the labels holding the interned strings. The given
+// labels are left untouched, as they may be shared, e.g. by cached chunks.
+func (i *labelInterner) intern(ls labels.Labels) labels.Labels {
+	if i == nil {
+		return ls
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	result := make(labels.Labels, len(ls))
+	for j, l := range ls {
+		result[j] = labels.Label{Name: i.internString(l.Name), Value: i.internString(l.Value)}
+	}
+	return result
+}
+
+// internMetric returns a copy of the metric holding the interned strings.
+func (i *labelInterner) internMetric(m model.Metric) model.Metric {
+	if i == nil {
+		return m
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	result := make(model.Metric, len(m))
+	for name, value := range m {
+		result[model.LabelName(i.internString(string(name)))] = model.LabelValue(i.internString(string(value)))
+	}
+	return result
+}
+
+func (i *labelInterner) internString(s string) string {
+	if interned, ok := i.strings[s]; ok {
+		deduplicatedLabelStrings.Inc()
+		return interned
+	}
+	// The labels decoded from the responses of the ingesters are backed by the
+	// buffers of the responses, which are reused: the interned string must be
+	// a copy.
+	s = string([]byte(s))
+	i.strings[s] = s
+	internedLabelStrings.Inc()
+	return s
+}
@@ -0,0 +1,72 @@
+package querier
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestLabelInterner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interner := newLabelInterner(ctx)
+	require.NotNil(t, interner)
+
+	// Build the strings at runtime, so that they don't share their memory.
+	value := func() string { return strings.Repeat("a", 3) }
+	a := labels.Labels{{Name: "foo", Value: value()}}
+	b := labels.Labels{{Name: "foo", Value: value()}}
+
+	ia := interner.intern(a)
+	ib := interner.intern(b)
+	assert.Equal(t, a, ia)
+	assert.Equal(t, b, ib)
+	assert.Len(t, interner.strings, 2)
+
+	m := interner.internMetric(model.Metric{"foo": model.LabelValue(value()), "bar": "baz"})
+	assert.Equal(t, model.Metric{"foo": "aaa", "bar": "baz"}, m)
+	assert.Len(t, interner.strings, 4)
+
+	// The strings backed by a buffer reused once decoded are copied.
+	buf := []byte("bbb")
+	ic := interner.intern(labels.Labels{{Name: "foo", Value: yoloString(buf)}})
+	copy(buf, "ccc")
+	assert.Equal(t, labels.Labels{{Name: "foo", Value: "bbb"}}, ic)
+	assert.Equal(t, "bbb", interner.strings["bbb"])
+
+	// Without a done channel, the context has no interner.
+	assert.Nil(t, newLabelInterner(context.Background()))
+	assert.Equal(t, a, (*labelInterner)(nil).intern(a))
+}
+
+func yoloString(b []byte) string {
+	return *((*string)(unsafe.Pointer(&b)))
+}
+
+func TestQuerierLabelInterning(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.LabelInterning = true
+	cfg.metricsRegisterer = nil
+
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming=%t", streaming), func(t *testing.T) {
+			cfg.IngesterStreaming = streaming
+
+			chunkStore, through := makeMockChunkStore(t, 24, encodings[0].e)
+			distributor := mockDistibutorFor(t, chunkStore, through)
+
+			queryable, _ := New(cfg, distributor, chunkStore, defaultLimits(t))
+			testQuery(t, queryable, through, queries[0])
+		})
+	}
+}
@@ -47,6 +47,16 @@ func (l lazyQuerier) Close() error {
 	return l.next.Close()
 }
 
+// LabelNamesFor implements labelsQuerier.
+func (l lazyQuerier) LabelNamesFor(matchers ...*labels.Matcher) ([]string, error) {
+	return labelNamesFor(l.next, matchers...)
+}
+
+// LabelValuesFor implements labelsQuerier.
+func (l lazyQuerier) LabelValuesFor(name string, matchers ...*labels.Matcher) ([]string, error) {
+	return labelValuesFor(l.next, name, matchers...)
+}
+
 // Get implements ChunkStore for the chunk tar HTTP handler.
 func (l lazyQuerier) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]chunk.Chunk, error) {
 	store, ok := l.next.(ChunkStore)
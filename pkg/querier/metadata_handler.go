@@ -0,0 +1,324 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/querier/errstatus"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+)
+
+// labelsQuerier is implemented by the queriers which answer the label names
+// and values of the series matching some matchers without selecting the
+// series, e.g. from the index of the store. The other queriers answer them
+// from the series of a metadata select.
+type labelsQuerier interface {
+	LabelNamesFor(matchers ...*labels.Matcher) ([]string, error)
+	LabelValuesFor(name string, matchers ...*labels.Matcher) ([]string, error)
+}
+
+// metadataQuerier answers the metadata queries of the Prometheus HTTP API
+// over the queryable of the queries, honouring both the time range and the
+// matchers of the request, as well as the limits of the queries, the delete
+// requests and the federation of the tenants.
+type metadataQuerier struct {
+	cfg       Config
+	queryable storage.Queryable
+}
+
+// SeriesHandler implements the Prometheus /api/v1/series endpoint.
+func SeriesHandler(cfg Config, queryable storage.Queryable) http.Handler {
+	q := &metadataQuerier{cfg: cfg, queryable: queryable}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, from, through, matcherSets, err := q.parseRequest(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+		if len(matcherSets) == 0 {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("no match[] parameter provided"))
+			return
+		}
+
+		series, err := q.series(ctx, from, through, matcherSets)
+		if err != nil {
+			writeAPIError(w, errstatus.Code(err), "execution", err)
+			return
+		}
+		writeAPIResponse(w, series)
+	})
+}
+
+// LabelNamesHandler implements the Prometheus /api/v1/labels endpoint.
+func LabelNamesHandler(cfg Config, queryable storage.Queryable) http.Handler {
+	q := &metadataQuerier{cfg: cfg, queryable: queryable}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, from, through, matcherSets, err := q.parseRequest(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+
+		names, err := q.labelNames(ctx, from, through, matcherSets)
+		if err != nil {
+			writeAPIError(w, errstatus.Code(err), "execution", err)
+			return
+		}
+		writeAPIResponse(w, names)
+	})
+}
+
+// LabelValuesHandler implements the Prometheus /api/v1/label/<name>/values
+// endpoint.
+func LabelValuesHandler(cfg Config, queryable storage.Queryable) http.Handler {
+	q := &metadataQuerier{cfg: cfg, queryable: queryable}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if !model.LabelNameRE.MatchString(name) {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", fmt.Errorf("invalid label name: %q", name))
+			return
+		}
+
+		ctx, from, through, matcherSets, err := q.parseRequest(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+
+		values, err := q.labelValues(ctx, from, through, name, matcherSets)
+		if err != nil {
+			writeAPIError(w, errstatus.Code(err), "execution", err)
+			return
+		}
+		writeAPIResponse(w, values)
+	})
+}
+
+// parseRequest returns the time range and the match[] selectors of a request.
+// Without a start, the range covers -querier.metadata-default-lookback.
+func (q *metadataQuerier) parseRequest(r *http.Request) (context.Context, model.Time, model.Time, [][]*labels.Matcher, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, 0, 0, nil, err
+	}
+
+	through := model.Now()
+	if s := r.FormValue("end"); s != "" {
+		t, err := queryrange.ParseTime(s)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		through = model.Time(t)
+	}
+
+	from := through.Add(-q.cfg.MetadataDefaultLookback)
+	if s := r.FormValue("start"); s != "" {
+		t, err := queryrange.ParseTime(s)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		from = model.Time(t)
+	}
+	if through.Before(from) {
+		return nil, 0, 0, nil, fmt.Errorf("end timestamp must not be before start time")
+	}
+
+	var matcherSets [][]*labels.Matcher
+	for _, s := range r.Form["match[]"] {
+		matchers, err := promql.ParseMetricSelector(s)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+	return r.Context(), from, through, matcherSets, nil
+}
+
+func (q *metadataQuerier) series(ctx context.Context, from, through model.Time, matcherSets [][]*labels.Matcher) ([]labels.Labels, error) {
+	querier, err := q.queryable.Querier(ctx, int64(from), int64(through))
+	if err != nil {
+		return nil, err
+	}
+	defer querier.Close()
+
+	series := map[string]labels.Labels{}
+	for _, matchers := range matcherSets {
+		ss, err := selectSeries(querier, matchers...)
+		if err != nil {
+			return nil, err
+		}
+		for _, ls := range ss {
+			series[ls.String()] = ls
+		}
+	}
+
+	result := make([]labels.Labels, 0, len(series))
+	for _, ls := range series {
+		result = append(result, ls)
+	}
+	sort.Slice(result, func(i, j int) bool { return labels.Compare(result[i], result[j]) < 0 })
+	return result, nil
+}
+
+func (q *metadataQuerier) labelNames(ctx context.Context, from, through model.Time, matcherSets [][]*labels.Matcher) ([]string, error) {
+	querier, err := q.queryable.Querier(ctx, int64(from), int64(through))
+	if err != nil {
+		return nil, err
+	}
+	defer querier.Close()
+
+	names := map[string]struct{}{}
+	for _, matchers := range withoutSelectors(matcherSets) {
+		ns, err := labelNamesFor(querier, matchers...)
+		if err != nil {
+			return nil, err
+		}
+		addStrings(names, ns)
+	}
+	return sortedStrings(names), nil
+}
+
+func (q *metadataQuerier) labelValues(ctx context.Context, from, through model.Time, name string, matcherSets [][]*labels.Matcher) ([]string, error) {
+	querier, err := q.queryable.Querier(ctx, int64(from), int64(through))
+	if err != nil {
+		return nil, err
+	}
+	defer querier.Close()
+
+	values := map[string]struct{}{}
+	for _, matchers := range withoutSelectors(matcherSets) {
+		vs, err := labelValuesFor(querier, name, matchers...)
+		if err != nil {
+			return nil, err
+		}
+		addStrings(values, vs)
+	}
+	return sortedStrings(values), nil
+}
+
+// withoutSelectors returns a single empty selector if there are none, looking
+// up the label names and values of all the series of the range.
+func withoutSelectors(matcherSets [][]*labels.Matcher) [][]*labels.Matcher {
+	if len(matcherSets) == 0 {
+		return [][]*labels.Matcher{nil}
+	}
+	return matcherSets
+}
+
+// selectSeries returns the labels of the series of a metadata select.
+func selectSeries(q storage.Querier, matchers ...*labels.Matcher) ([]labels.Labels, error) {
+	set, _, err := q.Select(nil, matchers...)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []labels.Labels
+	for set.Next() {
+		result = append(result, set.At().Labels())
+	}
+	return result, set.Err()
+}
+
+// labelNamesFor returns the label names of the series matching the matchers,
+// or of all the series without matchers.
+func labelNamesFor(q storage.Querier, matchers ...*labels.Matcher) ([]string, error) {
+	if lq, ok := q.(labelsQuerier); ok {
+		return lq.LabelNamesFor(matchers...)
+	}
+	if len(matchers) == 0 {
+		names, _, err := q.LabelNames()
+		return names, err
+	}
+
+	series, err := selectSeries(q, matchers...)
+	if err != nil {
+		return nil, err
+	}
+	return labelNamesOf(series), nil
+}
+
+// labelValuesFor returns the values of a label of the series matching the
+// matchers, or of all the series without matchers.
+func labelValuesFor(q storage.Querier, name string, matchers ...*labels.Matcher) ([]string, error) {
+	if lq, ok := q.(labelsQuerier); ok {
+		return lq.LabelValuesFor(name, matchers...)
+	}
+	if len(matchers) == 0 {
+		values, _, err := q.LabelValues(name)
+		return values, err
+	}
+
+	series, err := selectSeries(q, matchers...)
+	if err != nil {
+		return nil, err
+	}
+	return labelValuesOf(series, name), nil
+}
+
+// labelNamesOf returns the sorted label names of the series.
+func labelNamesOf(series []labels.Labels) []string {
+	names := map[string]struct{}{}
+	for _, ls := range series {
+		for _, l := range ls {
+			names[l.Name] = struct{}{}
+		}
+	}
+	return sortedStrings(names)
+}
+
+// labelValuesOf returns the sorted values of a label of the series.
+func labelValuesOf(series []labels.Labels, name string) []string {
+	values := map[string]struct{}{}
+	for _, ls := range series {
+		if v := ls.Get(name); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+	return sortedStrings(values)
+}
+
+func addStrings(set map[string]struct{}, values []string) {
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+}
+
+func sortedStrings(set map[string]struct{}) []string {
+	result := make([]string, 0, len(set))
+	for v := range set {
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
+
+type apiResponse struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+func writeAPIResponse(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(apiResponse{Status: "success", Data: data}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, code int, errorType string, err error) {
+	if _, ok := err.(promql.ErrStorage); ok {
+		code, errorType = http.StatusInternalServerError, "internal"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(apiResponse{Status: "error", ErrorType: errorType, Error: err.Error()})
+}
@@ -0,0 +1,254 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/prom1/storage/metric"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// metadataDistributorMock returns the values of the "job" label of the
+// ingesters, and records the time range queried.
+type metadataDistributorMock struct {
+	mockDistributor
+	from, through model.Time
+}
+
+func (m *metadataDistributorMock) LabelValuesForLabelName(_ context.Context, from, through model.Time, _ model.LabelName, _ ...*labels.Matcher) ([]string, error) {
+	m.from, m.through = from, through
+	return []string{"ingester"}, nil
+}
+
+func (m *metadataDistributorMock) LabelNames(_ context.Context, from, through model.Time, _ ...*labels.Matcher) ([]string, error) {
+	m.from, m.through = from, through
+	return []string{model.MetricNameLabel, "job"}, nil
+}
+
+func (m *metadataDistributorMock) MetricsForLabelMatchers(_ context.Context, from, through model.Time, _ ...*labels.Matcher) ([]metric.Metric, error) {
+	m.from, m.through = from, through
+	return []metric.Metric{
+		{Metric: model.Metric{model.MetricNameLabel: "foo", "job": "ingester"}},
+		{Metric: model.Metric{model.MetricNameLabel: "foo", "job": "both"}},
+	}, nil
+}
+
+// metadataStoreMock returns the values of the "job" label of its chunks, and
+// "index" if the index is used.
+type metadataStoreMock struct {
+	chunks []chunk.Chunk
+}
+
+func (m metadataStoreMock) Get(_ context.Context, _ string, _, _ model.Time, _ ...*labels.Matcher) ([]chunk.Chunk, error) {
+	return m.chunks, nil
+}
+
+func (m metadataStoreMock) LabelValuesForMetricName(_ context.Context, _ string, _, _ model.Time, _, _ string, _ ...*labels.Matcher) ([]string, error) {
+	return []string{"index"}, nil
+}
+
+func (m metadataStoreMock) LabelNamesForMetricName(_ context.Context, _ string, _, _ model.Time, _ string) ([]string, error) {
+	return []string{model.MetricNameLabel, "index"}, nil
+}
+
+func newMetadataRouter(t *testing.T, cfg Config, distributor Distributor, store ChunkStore, deletes DeleteRequestsStore) *mux.Router {
+	cfg.metricsRegisterer = nil
+	queryable, _ := New(cfg, distributor, store, defaultLimits(t))
+	if deletes != nil {
+		queryable = NewDeleteFilteringQueryable(queryable, deletes)
+	}
+
+	router := mux.NewRouter()
+	router.Path("/api/v1/series").Handler(SeriesHandler(cfg, queryable))
+	router.Path("/api/v1/labels").Handler(LabelNamesHandler(cfg, queryable))
+	router.Path("/api/v1/label/{name}/values").Handler(LabelValuesHandler(cfg, queryable))
+	return router
+}
+
+func TestMetadataHandlers(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming=%t", streaming), func(t *testing.T) {
+			testMetadataHandlers(t, streaming)
+		})
+	}
+}
+
+func testMetadataHandlers(t *testing.T, streaming bool) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.IngesterStreaming = streaming
+
+	distributor := &metadataDistributorMock{}
+	store := metadataStoreMock{chunks: []chunk.Chunk{
+		{Metric: labels.Labels{{Name: model.MetricNameLabel, Value: "foo"}, {Name: "job", Value: "both"}}},
+		{Metric: labels.Labels{{Name: model.MetricNameLabel, Value: "foo"}, {Name: "job", Value: "store"}}},
+	}}
+	router := newMetadataRouter(t, cfg, distributor, store, nil)
+
+	for _, tc := range []struct {
+		url      string
+		code     int
+		expected string
+	}{
+		{
+			url:      "/api/v1/label/job/values?start=100&end=200",
+			code:     http.StatusOK,
+			expected: `{"status":"success","data":["ingester"]}`,
+		},
+		{
+			// With a metric name only, the index of the store is used.
+			url:      "/api/v1/label/job/values?match[]=foo&start=100&end=200",
+			code:     http.StatusOK,
+			expected: `{"status":"success","data":["index","ingester"]}`,
+		},
+		{
+			// With other matchers too, the index of the store is used.
+			url:      `/api/v1/label/job/values?match[]=foo{job!="x"}&start=100&end=200`,
+			code:     http.StatusOK,
+			expected: `{"status":"success","data":["index","ingester"]}`,
+		},
+		{
+			// Without a metric name, the labels of the series are used.
+			url:      `/api/v1/label/job/values?match[]={job=~".+"}&start=100&end=200`,
+			code:     http.StatusOK,
+			expected: `{"status":"success","data":["both","ingester","store"]}`,
+		},
+		{
+			url:      "/api/v1/labels?match[]=foo&start=100&end=200",
+			code:     http.StatusOK,
+			expected: `{"status":"success","data":["__name__","index","job"]}`,
+		},
+		{
+			url:      "/api/v1/series?match[]=foo&start=100&end=200",
+			code:     http.StatusOK,
+			expected: `{"status":"success","data":[{"__name__":"foo","job":"both"},{"__name__":"foo","job":"ingester"},{"__name__":"foo","job":"store"}]}`,
+		},
+		{
+			url:      "/api/v1/series?start=100&end=200",
+			code:     http.StatusBadRequest,
+			expected: `{"status":"error","errorType":"bad_data","error":"no match[] parameter provided"}`,
+		},
+		{
+			url:      "/api/v1/labels?start=200&end=100",
+			code:     http.StatusBadRequest,
+			expected: `{"status":"error","errorType":"bad_data","error":"end timestamp must not be before start time"}`,
+		},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.code, resp.Code)
+			assert.JSONEq(t, tc.expected, resp.Body.String())
+			if tc.code == http.StatusOK {
+				assert.Equal(t, model.Time(100000), distributor.from)
+				assert.Equal(t, model.Time(200000), distributor.through)
+			}
+		})
+	}
+}
+
+func TestMetadataHandlersDefaultRange(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.MetadataDefaultLookback = time.Hour
+
+	cfg.metricsRegisterer = nil
+
+	distributor := &metadataDistributorMock{}
+	queryable, _ := New(cfg, distributor, metadataStoreMock{}, defaultLimits(t))
+	handler := LabelNamesHandler(cfg, queryable)
+
+	req := httptest.NewRequest("GET", "/api/v1/labels", nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var body apiResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	assert.Equal(t, []interface{}{model.MetricNameLabel, "job"}, body.Data)
+	assert.Equal(t, time.Hour, distributor.through.Sub(distributor.from))
+	assert.WithinDuration(t, time.Now(), distributor.through.Time(), time.Minute)
+}
+
+func TestMetadataHandlersIngesterLookback(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming=%t", streaming), func(t *testing.T) {
+			var cfg Config
+			flagext.DefaultValues(&cfg)
+			cfg.IngesterStreaming = streaming
+			cfg.IngesterMaxQueryLookback = time.Hour
+
+			// Without match[], the ingesters are not queried beyond their lookback
+			// either.
+			distributor := &metadataDistributorMock{}
+			router := newMetadataRouter(t, cfg, distributor, metadataStoreMock{}, nil)
+			for _, url := range []string{"/api/v1/labels?start=100&end=200", "/api/v1/label/job/values?start=100&end=200"} {
+				req := httptest.NewRequest("GET", url, nil)
+				req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+				resp := httptest.NewRecorder()
+				router.ServeHTTP(resp, req)
+
+				require.Equal(t, http.StatusOK, resp.Code)
+				assert.JSONEq(t, `{"status":"success","data":[]}`, resp.Body.String())
+				assert.Equal(t, model.Time(0), distributor.through)
+			}
+		})
+	}
+}
+
+func TestMetadataHandlersDeleteRequests(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+
+	distributor := &metadataDistributorMock{}
+	store := metadataStoreMock{chunks: []chunk.Chunk{
+		{Metric: labels.Labels{{Name: model.MetricNameLabel, Value: "foo"}, {Name: "job", Value: "store"}}},
+	}}
+	deletes := deleteRequestsStoreMock{
+		"1": {{StartTime: 0, EndTime: 300000, Selectors: []string{`foo{job=~"store|both"}`}}},
+	}
+	router := newMetadataRouter(t, cfg, distributor, store, deletes)
+
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{
+			url:      "/api/v1/series?match[]=foo&start=100&end=200",
+			expected: `{"status":"success","data":[{"__name__":"foo","job":"ingester"}]}`,
+		},
+		{
+			// The index can't tell the deleted series apart: the values are
+			// those of the series which are left.
+			url:      "/api/v1/label/job/values?match[]=foo&start=100&end=200",
+			expected: `{"status":"success","data":["ingester"]}`,
+		},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, tc.expected, resp.Body.String())
+		})
+	}
+}
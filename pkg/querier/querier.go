@@ -2,19 +2,27 @@ package querier
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
 	"github.com/cortexproject/cortex/pkg/querier/batch"
 	"github.com/cortexproject/cortex/pkg/querier/iterators"
+	"github.com/cortexproject/cortex/pkg/querier/stats"
 	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 // Config contains the configuration require to create a querier
@@ -26,6 +34,11 @@ type Config struct {
 	IngesterStreaming        bool
 	MaxSamples               int
 	IngesterMaxQueryLookback time.Duration
+	TenantFederation         bool
+	MetadataDefaultLookback  time.Duration
+	QueryStatsEnabled        bool
+	RemoteReadTimeout        time.Duration
+	LabelInterning           bool
 
 	// The default evaluation interval for the promql engine.
 	// Needs to be configured for subqueries to work as it is the default
@@ -44,10 +57,15 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 		f.DurationVar(&promql.LookbackDelta, "promql.lookback-delta", promql.LookbackDelta, "Time since the last sample after which a time series is considered stale and ignored by expression evaluations.")
 	}
 	f.BoolVar(&cfg.Iterators, "querier.iterators", false, "Use iterators to execute query, as opposed to fully materialising the series in memory.")
-	f.BoolVar(&cfg.BatchIterators, "querier.batch-iterators", false, "Use batch iterators to execute query, as opposed to fully materialising the series in memory.  Takes precedent over the -querier.iterators flag.")
+	f.BoolVar(&cfg.BatchIterators, "querier.batch-iterators", true, "Use batch iterators to execute query, as opposed to fully materialising the series in memory.  Takes precedent over the -querier.iterators flag.")
 	f.BoolVar(&cfg.IngesterStreaming, "querier.ingester-streaming", false, "Use streaming RPCs to query ingester.")
 	f.IntVar(&cfg.MaxSamples, "querier.max-samples", 50e6, "Maximum number of samples a single query can load into memory.")
 	f.DurationVar(&cfg.IngesterMaxQueryLookback, "querier.query-ingesters-within", 0, "Maximum lookback beyond which queries are not sent to ingester. 0 means all queries are sent to ingester.")
+	f.BoolVar(&cfg.TenantFederation, "querier.tenant-federation", false, "Allow queries across multiple tenants, listed in the X-Scope-OrgID header separated by '|'. Each series is labelled with the tenant it belongs to, in "+TenantLabel+".")
+	f.DurationVar(&cfg.MetadataDefaultLookback, "querier.metadata-default-lookback", 24*time.Hour, "Time range of the series, labels and label values queries which have no start time.")
+	f.BoolVar(&cfg.QueryStatsEnabled, "querier.query-stats-enabled", false, "Log the stats of each query: the series, chunks and bytes fetched, and the wall and queue time. The stats are also added to the responses of the queries requested with the stats parameter.")
+	f.DurationVar(&cfg.RemoteReadTimeout, "querier.remote-read-timeout", time.Minute, "Timeout of the queries to the remote read endpoints listed in the remote_read_urls limit of a tenant.")
+	f.BoolVar(&cfg.LabelInterning, "querier.label-interning", false, "Deduplicate the label names and values of the series fetched by a query, so that their copies decoded from the responses of the ingesters and the store are freed early.")
 	f.DurationVar(&cfg.DefaultEvaluationInterval, "querier.default-evaluation-interval", time.Minute, "The default evaluation interval or step size for subqueries.")
 	cfg.metricsRegisterer = prometheus.DefaultRegisterer
 }
@@ -59,7 +77,7 @@ type ChunkStore interface {
 }
 
 // New builds a queryable and promql engine.
-func New(cfg Config, distributor Distributor, chunkStore ChunkStore) (storage.Queryable, *promql.Engine) {
+func New(cfg Config, distributor Distributor, chunkStore ChunkStore, limits Limits) (storage.Queryable, *promql.Engine) {
 	iteratorFunc := mergeChunks
 	if cfg.BatchIterators {
 		iteratorFunc = batch.NewChunkMergeIterator
@@ -76,8 +94,46 @@ func New(cfg Config, distributor Distributor, chunkStore ChunkStore) (storage.Qu
 		dq := newDistributorQueryable(distributor)
 		queryable = NewQueryable(dq, cq, distributor, cfg.IngesterMaxQueryLookback)
 	}
+	queryable = newRemoteReadQueryable(queryable, distributor, limits, cfg.RemoteReadTimeout)
 
 	lazyQueryable := storage.QueryableFunc(func(ctx context.Context, mint int64, maxt int64) (storage.Querier, error) {
+		// The series fetched by the query share the strings of its interner.
+		if cfg.LabelInterning {
+			ctx = withLabelInterner(ctx, newLabelInterner(ctx))
+		}
+
+		// The limits on the data fetched apply to the whole query, across all
+		// of its selects, and all of its tenants for a federated query, which
+		// brings its limiter.
+		partialResults := false
+		if userID, err := user.ExtractOrgID(ctx); err == nil {
+			if queryLimiterFromContext(ctx) == nil {
+				ctx = withQueryLimiter(ctx, newQueryLimiter(limits, userID, stats.FromContext(ctx)))
+			}
+			partialResults = limits.QueryPartialResults(userID)
+
+			var ok bool
+			mint, maxt, ok, err = limitQueryRange(limits, userID, mint, maxt)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return storage.NoopQuerier(), nil
+			}
+		}
+
+		// The warnings about partial results are only known once a select is
+		// done, while the engine only gets the warnings returned by Select:
+		// the selects of these users are not done in the background.
+		if partialResults {
+			ctx, warnings := util.WithWarnings(ctx)
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			if err != nil {
+				return nil, err
+			}
+			return warningsQuerier{Querier: querier, warnings: warnings}, nil
+		}
+
 		querier, err := queryable.Querier(ctx, mint, maxt)
 		if err != nil {
 			return nil, err
@@ -93,7 +149,30 @@ func New(cfg Config, distributor Distributor, chunkStore ChunkStore) (storage.Qu
 		MaxSamples:    cfg.MaxSamples,
 		Timeout:       cfg.Timeout,
 	})
-	return lazyQueryable, engine
+	return newErrorQueryable(lazyQueryable), engine
+}
+
+// limitQueryRange drops the part of the range beyond the max query lookback of
+// the user, and returns false if no part is left. It then fails if the range,
+// which spans the data fetched for the query, is longer than the max query
+// length.
+func limitQueryRange(limits Limits, userID string, mint, maxt int64) (int64, int64, bool, error) {
+	if lookback := limits.MaxQueryLookback(userID); lookback > 0 {
+		minT := timestamp.FromTime(time.Now().Add(-lookback))
+		if maxt < minT {
+			return 0, 0, false, nil
+		}
+		if mint < minT {
+			mint = minT
+		}
+	}
+
+	maxLength := limits.MaxQueryLength(userID)
+	length := timestamp.Time(maxt).Sub(timestamp.Time(mint))
+	if maxLength > 0 && length > maxLength {
+		return 0, 0, false, httpgrpc.Errorf(http.StatusBadRequest, validation.ErrQueryTooLong, length, maxLength)
+	}
+	return mint, maxt, true, nil
 }
 
 // NewQueryable creates a new Queryable for cortex.
@@ -112,8 +191,9 @@ func NewQueryable(dq, cq storage.Queryable, distributor Distributor, ingesterMax
 			maxt:        maxt,
 		}
 
-		// Include ingester only if maxt is within ingesterMaxQueryLookback w.r.t. current time.
-		if ingesterMaxQueryLookback == 0 || maxt >= time.Now().Add(-ingesterMaxQueryLookback).UnixNano()/1e6 {
+		// Include ingester only if maxt is within ingesterMaxQueryLookback w.r.t. current time,
+		// or the query is of strong read consistency.
+		if ingesterMaxQueryLookback == 0 || util.IsStrongReadConsistency(ctx) || maxt >= time.Now().Add(-ingesterMaxQueryLookback).UnixNano()/1e6 {
 			dqr, err := dq.Querier(ctx, mint, maxt)
 			if err != nil {
 				return nil, err
@@ -135,12 +215,9 @@ type querier struct {
 
 // Select implements storage.Querier.
 func (q querier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
-	// Kludge: Prometheus passes nil SelectParams if it is doing a 'series' operation,
-	// which needs only metadata.
-	if sp == nil {
-		return q.metadataQuery(matchers...)
-	}
-
+	// Prometheus passes nil SelectParams if it is doing a 'series' operation,
+	// which needs only metadata: the queriers answer it from the ingesters and
+	// the index of the store.
 	sets := make(chan storage.SeriesSet, len(q.queriers))
 	errs := make(chan error, len(q.queriers))
 	for _, querier := range q.queriers {
@@ -169,23 +246,93 @@ func (q querier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (
 
 // LabelsValue implements storage.Querier.
 func (q querier) LabelValues(name string) ([]string, storage.Warnings, error) {
-	lv, err := q.distributor.LabelValuesForLabelName(q.ctx, model.LabelName(name))
+	lv, err := q.distributor.LabelValuesForLabelName(q.ctx, model.Time(q.mint), model.Time(q.maxt), model.LabelName(name))
 	return lv, nil, err
 }
 
 func (q querier) LabelNames() ([]string, storage.Warnings, error) {
-	ln, err := q.distributor.LabelNames(q.ctx)
+	ln, err := q.distributor.LabelNames(q.ctx, model.Time(q.mint), model.Time(q.maxt))
 	return ln, nil, err
 }
 
-func (q querier) metadataQuery(matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
-	ms, err := q.distributor.MetricsForLabelMatchers(q.ctx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+// LabelNamesFor implements labelsQuerier.
+func (q querier) LabelNamesFor(matchers ...*labels.Matcher) ([]string, error) {
+	return q.mergeStrings(func(querier storage.Querier) ([]string, error) {
+		return labelNamesFor(querier, matchers...)
+	})
+}
+
+// LabelValuesFor implements labelsQuerier.
+func (q querier) LabelValuesFor(name string, matchers ...*labels.Matcher) ([]string, error) {
+	return q.mergeStrings(func(querier storage.Querier) ([]string, error) {
+		return labelValuesFor(querier, name, matchers...)
+	})
+}
+
+// mergeStrings merges the strings returned by f for all the queriers, called
+// in parallel.
+func (q querier) mergeStrings(f func(storage.Querier) ([]string, error)) ([]string, error) {
+	type result struct {
+		values []string
+		err    error
+	}
+	results := make(chan result, len(q.queriers))
+	for _, querier := range q.queriers {
+		go func(querier storage.Querier) {
+			values, err := f(querier)
+			results <- result{values, err}
+		}(querier)
+	}
+
+	values := map[string]struct{}{}
+	var err error
+	for range q.queriers {
+		r := <-results
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+		addStrings(values, r.values)
+	}
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return metricsToSeriesSet(ms), nil, nil
+	return sortedStrings(values), nil
 }
 
 func (querier) Close() error {
 	return nil
 }
+
+// warningsQuerier returns the warnings collected during each Select.
+type warningsQuerier struct {
+	storage.Querier
+	warnings *util.Warnings
+}
+
+func (q warningsQuerier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	set, warnings, err := q.Querier.Select(sp, matchers...)
+	for _, w := range q.warnings.Take() {
+		warnings = append(warnings, errors.New(w))
+	}
+	return set, warnings, err
+}
+
+// LabelNamesFor implements labelsQuerier.
+func (q warningsQuerier) LabelNamesFor(matchers ...*labels.Matcher) ([]string, error) {
+	return labelNamesFor(q.Querier, matchers...)
+}
+
+// LabelValuesFor implements labelsQuerier.
+func (q warningsQuerier) LabelValuesFor(name string, matchers ...*labels.Matcher) ([]string, error) {
+	return labelValuesFor(q.Querier, name, matchers...)
+}
+
+// Get implements ChunkStore for the chunk tar HTTP handler.
+func (q warningsQuerier) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]chunk.Chunk, error) {
+	store, ok := q.Querier.(ChunkStore)
+	if !ok {
+		return nil, fmt.Errorf("not supported")
+	}
+
+	return store.Get(ctx, userID, from, through, matchers...)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 	"github.com/weaveworks/common/user"
 )
 
@@ -138,7 +139,7 @@ func TestQuerier(t *testing.T) {
 						chunkStore, through := makeMockChunkStore(t, 24, encoding.e)
 						distributor := mockDistibutorFor(t, chunkStore, through)
 
-						queryable, _ := New(cfg, distributor, chunkStore)
+						queryable, _ := New(cfg, distributor, chunkStore, defaultLimits(t))
 						testQuery(t, queryable, through, query)
 					})
 				}
@@ -206,7 +207,7 @@ func TestNoHistoricalQueryToIngester(t *testing.T) {
 				chunkStore, _ := makeMockChunkStore(t, 24, encodings[0].e)
 				distributor := &errDistributor{}
 
-				queryable, _ := New(cfg, distributor, chunkStore)
+				queryable, _ := New(cfg, distributor, chunkStore, defaultLimits(t))
 				query, err := engine.NewRangeQuery(queryable, "dummy", c.mint, c.maxt, 1*time.Minute)
 				require.NoError(t, err)
 
@@ -228,6 +229,195 @@ func TestNoHistoricalQueryToIngester(t *testing.T) {
 
 }
 
+func defaultLimits(t *testing.T) *validation.Overrides {
+	var limits validation.Limits
+	flagext.DefaultValues(&limits)
+	overrides, err := validation.NewOverrides(limits)
+	require.NoError(t, err)
+	return overrides
+}
+
+func TestQuerierFetchedLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		limits   func(*validation.Limits)
+		expected string
+	}{
+		{
+			name:   "no limits",
+			limits: func(*validation.Limits) {},
+		},
+		{
+			name:   "series within limit",
+			limits: func(l *validation.Limits) { l.MaxFetchedSeriesPerQuery = 1 },
+		},
+		{
+			name:     "chunks",
+			limits:   func(l *validation.Limits) { l.MaxFetchedChunksPerQuery = 10 },
+			expected: fmt.Sprintf(errMaxFetchedChunks, 10),
+		},
+		{
+			name:     "chunk bytes",
+			limits:   func(l *validation.Limits) { l.MaxFetchedChunkBytesPerQuery = 1000 },
+			expected: fmt.Sprintf(errMaxFetchedChunkBytes, 1000),
+		},
+	} {
+		for _, streaming := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/streaming=%t", tc.name, streaming), func(t *testing.T) {
+				var cfg Config
+				flagext.DefaultValues(&cfg)
+				cfg.IngesterStreaming = streaming
+				cfg.metricsRegisterer = nil
+
+				var limits validation.Limits
+				flagext.DefaultValues(&limits)
+				tc.limits(&limits)
+				overrides, err := validation.NewOverrides(limits)
+				require.NoError(t, err)
+
+				chunkStore, through := makeMockChunkStore(t, 24, encodings[0].e)
+				distributor := mockDistibutorFor(t, chunkStore, through)
+				queryable, _ := New(cfg, distributor, chunkStore, overrides)
+
+				engine := promql.NewEngine(promql.EngineOpts{
+					Logger:        util.Logger,
+					MaxConcurrent: 10,
+					MaxSamples:    1e6,
+					Timeout:       1 * time.Minute,
+				})
+				query, err := engine.NewRangeQuery(queryable, "foo", time.Unix(0, 0), through.Time(), time.Minute)
+				require.NoError(t, err)
+
+				r := query.Exec(user.InjectOrgID(context.Background(), "0"))
+				if tc.expected == "" {
+					require.NoError(t, r.Err)
+				} else {
+					require.Error(t, r.Err)
+					require.Equal(t, tc.expected, r.Err.Error())
+				}
+			})
+		}
+	}
+}
+
+// partialDistributor warns about partial results, like the distributor does
+// when a minority of the ingesters fail.
+type partialDistributor struct {
+	*mockDistributor
+}
+
+func (d partialDistributor) Query(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) (model.Matrix, error) {
+	util.WarningsFromContext(ctx).Add("partial results")
+	return d.mockDistributor.Query(ctx, from, to, matchers...)
+}
+
+func (d partialDistributor) QueryStream(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) ([]client.TimeSeriesChunk, error) {
+	util.WarningsFromContext(ctx).Add("partial results")
+	return d.mockDistributor.QueryStream(ctx, from, to, matchers...)
+}
+
+func TestQuerierPartialResultsWarnings(t *testing.T) {
+	for _, partialResults := range []bool{false, true} {
+		for _, streaming := range []bool{false, true} {
+			t.Run(fmt.Sprintf("partialResults=%t/streaming=%t", partialResults, streaming), func(t *testing.T) {
+				var cfg Config
+				flagext.DefaultValues(&cfg)
+				cfg.IngesterStreaming = streaming
+				cfg.metricsRegisterer = nil
+
+				var limits validation.Limits
+				flagext.DefaultValues(&limits)
+				limits.QueryPartialResults = partialResults
+				overrides, err := validation.NewOverrides(limits)
+				require.NoError(t, err)
+
+				chunkStore, through := makeMockChunkStore(t, 24, encodings[0].e)
+				distributor := partialDistributor{mockDistibutorFor(t, chunkStore, through)}
+				queryable, _ := New(cfg, distributor, chunkStore, overrides)
+
+				engine := promql.NewEngine(promql.EngineOpts{
+					Logger:        util.Logger,
+					MaxConcurrent: 10,
+					MaxSamples:    1e6,
+					Timeout:       1 * time.Minute,
+				})
+				query, err := engine.NewRangeQuery(queryable, "foo", time.Unix(0, 0), through.Time(), time.Minute)
+				require.NoError(t, err)
+
+				r := query.Exec(user.InjectOrgID(context.Background(), "0"))
+				require.NoError(t, r.Err)
+				if partialResults {
+					require.Len(t, r.Warnings, 1)
+					require.Equal(t, "partial results", r.Warnings[0].Error())
+				} else {
+					require.Empty(t, r.Warnings)
+				}
+			})
+		}
+	}
+}
+
+func TestQuerierQueryRangeLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		limits         func(*validation.Limits)
+		expectedSeries int
+		expectedErr    bool
+	}{
+		{
+			name:           "no limits",
+			limits:         func(*validation.Limits) {},
+			expectedSeries: 1,
+		},
+		{
+			// All the data is beyond the lookback: nothing is queried.
+			name:   "max query lookback",
+			limits: func(l *validation.Limits) { l.MaxQueryLookback = time.Hour },
+		},
+		{
+			name:        "max query length",
+			limits:      func(l *validation.Limits) { l.MaxQueryLength = time.Hour },
+			expectedErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			flagext.DefaultValues(&cfg)
+			cfg.metricsRegisterer = nil
+
+			var limits validation.Limits
+			flagext.DefaultValues(&limits)
+			tc.limits(&limits)
+			overrides, err := validation.NewOverrides(limits)
+			require.NoError(t, err)
+
+			chunkStore, through := makeMockChunkStore(t, 24, encodings[0].e)
+			distributor := mockDistibutorFor(t, chunkStore, through)
+			queryable, _ := New(cfg, distributor, chunkStore, overrides)
+
+			engine := promql.NewEngine(promql.EngineOpts{
+				Logger:        util.Logger,
+				MaxConcurrent: 10,
+				MaxSamples:    1e6,
+				Timeout:       1 * time.Minute,
+			})
+			query, err := engine.NewRangeQuery(queryable, "foo", time.Unix(0, 0), through.Time(), time.Minute)
+			require.NoError(t, err)
+
+			r := query.Exec(user.InjectOrgID(context.Background(), "0"))
+			if tc.expectedErr {
+				require.Error(t, r.Err)
+				require.Contains(t, r.Err.Error(), "invalid query, length > limit")
+				return
+			}
+			require.NoError(t, r.Err)
+			m, err := r.Matrix()
+			require.NoError(t, err)
+			require.Len(t, m, tc.expectedSeries)
+		})
+	}
+}
+
 // mockDistibutorFor duplicates the chunks in the mockChunkStore into the mockDistributor
 // so we can test everything is dedupe correctly.
 func mockDistibutorFor(t *testing.T, cs mockChunkStore, through model.Time) *mockDistributor {
@@ -291,10 +481,10 @@ func (m *errDistributor) Query(ctx context.Context, from, to model.Time, matcher
 func (m *errDistributor) QueryStream(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) ([]client.TimeSeriesChunk, error) {
 	return m.r, errDistributorError
 }
-func (m *errDistributor) LabelValuesForLabelName(context.Context, model.LabelName) ([]string, error) {
+func (m *errDistributor) LabelValuesForLabelName(context.Context, model.Time, model.Time, model.LabelName, ...*labels.Matcher) ([]string, error) {
 	return nil, errDistributorError
 }
-func (m *errDistributor) LabelNames(context.Context) ([]string, error) {
+func (m *errDistributor) LabelNames(context.Context, model.Time, model.Time, ...*labels.Matcher) ([]string, error) {
 	return nil, errDistributorError
 }
 func (m *errDistributor) MetricsForLabelMatchers(ctx context.Context, from, through model.Time, matchers ...*labels.Matcher) ([]metric.Metric, error) {
@@ -0,0 +1,166 @@
+package querier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/stats"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const (
+	errMaxFetchedChunks     = "the query hit the max number of chunks limit (limit: %d chunks)"
+	errMaxFetchedSeries     = "the query hit the max number of series limit (limit: %d series)"
+	errMaxFetchedChunkBytes = "the query hit the max size of chunk data limit (limit: %d bytes)"
+
+	// Estimated size of a sample returned by a non-streaming ingester query.
+	sampleSize = 16
+)
+
+// Limits are the per-tenant limits enforced by the querier.
+type Limits interface {
+	MaxFetchedChunksPerQuery(string) int
+	MaxFetchedSeriesPerQuery(string) int
+	MaxFetchedChunkBytesPerQuery(string) int
+	QueryPartialResults(string) bool
+	MaxQueryLookback(string) time.Duration
+	MaxQueryLength(string) time.Duration
+	RemoteReadURLs(string) []string
+}
+
+// queryLimiter tracks the chunks, series and bytes fetched by a query, across
+// all its selects, and fails the query once one of the limits is exceeded; 0
+// disables a limit. A nil queryLimiter doesn't limit.
+type queryLimiter struct {
+	maxChunks, maxSeries, maxBytes int
+
+	mtx    sync.Mutex
+	series map[model.Fingerprint]struct{}
+	chunks int
+	bytes  int
+
+	// The stats of the query, also collected by the limiter.
+	stats *stats.Stats
+}
+
+func newQueryLimiter(limits Limits, userID string, queryStats *stats.Stats) *queryLimiter {
+	return &queryLimiter{
+		stats:     queryStats,
+		maxChunks: limits.MaxFetchedChunksPerQuery(userID),
+		maxSeries: limits.MaxFetchedSeriesPerQuery(userID),
+		maxBytes:  limits.MaxFetchedChunkBytesPerQuery(userID),
+		series:    map[model.Fingerprint]struct{}{},
+	}
+}
+
+// newFederatedQueryLimiter returns the limiter of a federated query, shared
+// by its tenants, with the strictest limits of the tenants.
+func newFederatedQueryLimiter(limits FederationLimits, orgID string, queryStats *stats.Stats) *queryLimiter {
+	return &queryLimiter{
+		stats:     queryStats,
+		maxChunks: util.SmallestPositiveIntPerTenant(orgID, limits.MaxFetchedChunksPerQuery),
+		maxSeries: util.SmallestPositiveIntPerTenant(orgID, limits.MaxFetchedSeriesPerQuery),
+		maxBytes:  util.SmallestPositiveIntPerTenant(orgID, limits.MaxFetchedChunkBytesPerQuery),
+		series:    map[model.Fingerprint]struct{}{},
+	}
+}
+
+type queryLimiterKey int
+
+// withQueryLimiter returns a context with the limiter, also limiting the
+// chunks the chunk store fetches for the query.
+func withQueryLimiter(ctx context.Context, l *queryLimiter) context.Context {
+	ctx = chunk.WithChunkRefsLimiter(ctx, l)
+	return context.WithValue(ctx, queryLimiterKey(0), l)
+}
+
+// queryLimiterFromContext returns the limiter of the query; nil if none.
+func queryLimiterFromContext(ctx context.Context) *queryLimiter {
+	l, _ := ctx.Value(queryLimiterKey(0)).(*queryLimiter)
+	return l
+}
+
+// addSeries records a series fetched by the query; series fetched several
+// times, e.g. from both the ingesters and the store, are only counted once.
+func (l *queryLimiter) addSeries(fp model.Fingerprint) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if _, ok := l.series[fp]; !ok {
+		l.series[fp] = struct{}{}
+		l.stats.AddSeries(1)
+	}
+	if l.maxSeries > 0 && len(l.series) > l.maxSeries {
+		return util.LimitError(fmt.Sprintf(errMaxFetchedSeries, l.maxSeries))
+	}
+	return nil
+}
+
+// addChunks records chunks, holding size bytes of data, fetched by the query.
+func (l *queryLimiter) addChunks(count, size int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.chunks += count
+	l.bytes += size
+	l.stats.AddChunks(count, size)
+	if l.maxChunks > 0 && l.chunks > l.maxChunks {
+		return util.LimitError(fmt.Sprintf(errMaxFetchedChunks, l.maxChunks))
+	}
+	if l.maxBytes > 0 && l.bytes > l.maxBytes {
+		return util.LimitError(fmt.Sprintf(errMaxFetchedChunkBytes, l.maxBytes))
+	}
+	return nil
+}
+
+// AddChunkRefs implements chunk.ChunkRefsLimiter. The chunks found in the
+// index of the store are counted before they're fetched, their size
+// estimated to encoding.ChunkLen bytes each; their actual size is recorded by
+// addStoreChunks once fetched.
+func (l *queryLimiter) AddChunkRefs(chunks []chunk.Chunk) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.chunks += len(chunks)
+	l.stats.AddChunks(len(chunks), 0)
+	if l.maxChunks > 0 && l.chunks > l.maxChunks {
+		return util.LimitError(fmt.Sprintf(errMaxFetchedChunks, l.maxChunks))
+	}
+	if l.maxBytes > 0 && l.bytes+len(chunks)*encoding.ChunkLen > l.maxBytes {
+		return util.LimitError(fmt.Sprintf(errMaxFetchedChunkBytes, l.maxBytes))
+	}
+	return nil
+}
+
+// addStoreChunks records the series and the size of the chunks fetched from
+// the store, the chunks being counted by AddChunkRefs beforehand.
+func (l *queryLimiter) addStoreChunks(chunks []chunk.Chunk) error {
+	if l == nil {
+		return nil
+	}
+
+	size := 0
+	for _, c := range chunks {
+		if err := l.addSeries(client.Fingerprint(c.Metric)); err != nil {
+			return err
+		}
+		size += c.Data.Size()
+	}
+	return l.addChunks(0, size)
+}
@@ -0,0 +1,169 @@
+package querier
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/querier/frontend"
+	"github.com/cortexproject/cortex/pkg/querier/stats"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// queryStatsResponse is added to the stats of the response of the queries
+// requested with the stats parameter.
+type queryStatsResponse struct {
+	WallTime      float64 `json:"wallTime"`
+	QueueTime     float64 `json:"queueTime"`
+	SeriesFetched int     `json:"seriesFetched"`
+	ChunksFetched int     `json:"chunksFetched"`
+	BytesFetched  int     `json:"bytesFetched"`
+}
+
+// QueryStatsMiddleware collects the stats of the /query and /query_range
+// requests, and logs them once the query is done, with the tenant and the
+// user agent of the request. The stats are also added to the stats field of
+// the responses of the requests with the stats parameter.
+func QueryStatsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/query") && !strings.HasSuffix(r.URL.Path, "/query_range") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := user.ExtractOrgID(r.Context())
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+
+		var queueTime time.Duration
+		if s := r.Header.Get(frontend.QueueTimeHeader); s != "" {
+			queueTime, _ = time.ParseDuration(s)
+		}
+
+		queryStats := &stats.Stats{}
+		r = r.WithContext(stats.WithStats(r.Context(), queryStats))
+		sw := &statsResponseWriter{ResponseWriter: w, code: http.StatusOK}
+		if r.FormValue("stats") != "" {
+			sw.body = &bytes.Buffer{}
+		}
+
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+		wallTime := time.Since(start)
+
+		v := queryStats.Values()
+		resp := queryStatsResponse{
+			WallTime:      wallTime.Seconds(),
+			QueueTime:     queueTime.Seconds(),
+			SeriesFetched: v.Series,
+			ChunksFetched: v.Chunks,
+			BytesFetched:  v.Bytes,
+		}
+
+		// The stats are returned to the frontend in headers, for its slow query
+		// log. As the httpgrpc server buffers the responses, the headers set
+		// once the response is written still reach the frontend.
+		h := w.Header()
+		h.Set(frontend.QueueTimeHeader, queueTime.String())
+		h.Set(frontend.SeriesFetchedHeader, strconv.Itoa(resp.SeriesFetched))
+		h.Set(frontend.ChunksFetchedHeader, strconv.Itoa(resp.ChunksFetched))
+		h.Set(frontend.BytesFetchedHeader, strconv.Itoa(resp.BytesFetched))
+
+		level.Info(util.Logger).Log(
+			"msg", "query stats",
+			"user", userID,
+			"user_agent", r.UserAgent(),
+			"path", r.URL.Path,
+			"query", r.FormValue("query"),
+			"status_code", sw.code,
+			"wall_time", wallTime,
+			"queue_time", queueTime,
+			"series_fetched", resp.SeriesFetched,
+			"chunks_fetched", resp.ChunksFetched,
+			"bytes_fetched", resp.BytesFetched,
+		)
+
+		if sw.body != nil {
+			body := sw.body.Bytes()
+			if sw.code == http.StatusOK {
+				body = addQueryStats(body, resp)
+			}
+			w.WriteHeader(sw.code)
+			_, _ = w.Write(body)
+		}
+	})
+}
+
+// addQueryStats adds the stats to the stats of the data of a response of the
+// Prometheus API, next to the stats of the engine. The response is returned
+// as is if it can't be decoded.
+func addQueryStats(body []byte, resp queryStatsResponse) []byte {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return body
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(response["data"], &data); err != nil {
+		return body
+	}
+	engineStats := map[string]interface{}{}
+	if s, ok := data["stats"]; ok {
+		if err := json.Unmarshal(s, &engineStats); err != nil {
+			return body
+		}
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return body
+	}
+	if err := json.Unmarshal(b, &engineStats); err != nil {
+		return body
+	}
+
+	if data["stats"], err = json.Marshal(engineStats); err != nil {
+		return body
+	}
+	if response["data"], err = json.Marshal(data); err != nil {
+		return body
+	}
+	result, err := json.Marshal(response)
+	if err != nil {
+		return body
+	}
+	return result
+}
+
+// statsResponseWriter records the status code of a response and, if body is
+// set, buffers the response rather than writing it.
+type statsResponseWriter struct {
+	http.ResponseWriter
+	code int
+	body *bytes.Buffer
+}
+
+func (w *statsResponseWriter) WriteHeader(code int) {
+	w.code = code
+	if w.body == nil {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *statsResponseWriter) Write(b []byte) (int, error) {
+	if w.body == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
@@ -0,0 +1,84 @@
+package querier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/querier/frontend"
+	"github.com/cortexproject/cortex/pkg/querier/stats"
+)
+
+func TestQueryStatsMiddleware(t *testing.T) {
+	const body = `{"status":"success","data":{"resultType":"matrix","result":[],"stats":{"timings":{"evalTotalTime":0.1}}}}`
+	limits := defaultLimits(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The same series fetched twice is counted once.
+		l := newQueryLimiter(limits, "1", stats.FromContext(r.Context()))
+		require.NoError(t, l.addSeries(1))
+		require.NoError(t, l.addSeries(1))
+		require.NoError(t, l.addSeries(2))
+		require.NoError(t, l.addChunks(3, 300))
+		_, _ = w.Write([]byte(body))
+	})
+	handler := QueryStatsMiddleware(next)
+
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{
+			url:      "/api/v1/query_range?query=foo",
+			expected: body,
+		},
+		{
+			url: "/api/v1/query_range?query=foo&stats=all",
+		},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+			req.Header.Set(frontend.QueueTimeHeader, "1.5s")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+
+			// The stats are returned to the frontend in headers too.
+			assert.Equal(t, "1.5s", resp.Header().Get(frontend.QueueTimeHeader))
+			assert.Equal(t, "2", resp.Header().Get(frontend.SeriesFetchedHeader))
+			assert.Equal(t, "3", resp.Header().Get(frontend.ChunksFetchedHeader))
+			assert.Equal(t, "300", resp.Header().Get(frontend.BytesFetchedHeader))
+
+			if tc.expected != "" {
+				assert.Equal(t, tc.expected, resp.Body.String())
+				return
+			}
+
+			var response struct {
+				Data struct {
+					Stats struct {
+						Timings       map[string]float64 `json:"timings"`
+						WallTime      float64            `json:"wallTime"`
+						QueueTime     float64            `json:"queueTime"`
+						SeriesFetched int                `json:"seriesFetched"`
+						ChunksFetched int                `json:"chunksFetched"`
+						BytesFetched  int                `json:"bytesFetched"`
+					} `json:"stats"`
+				} `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+			stats := response.Data.Stats
+			assert.Equal(t, map[string]float64{"evalTotalTime": 0.1}, stats.Timings)
+			assert.True(t, stats.WallTime > 0)
+			assert.Equal(t, 1.5, stats.QueueTime)
+			assert.Equal(t, 2, stats.SeriesFetched)
+			assert.Equal(t, 3, stats.ChunksFetched)
+			assert.Equal(t, 300, stats.BytesFetched)
+		})
+	}
+}
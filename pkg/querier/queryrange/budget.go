@@ -0,0 +1,72 @@
+package queryrange
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// budgetBurst is the number of extra requests a budget allows before any
+// request has been made, and at most after a period of no extra requests.
+const budgetBurst = 10
+
+var overBudget = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cortex",
+	Name:      "query_frontend_over_budget_total",
+	Help:      "Number of retries or hedged requests not sent as they were over budget.",
+}, []string{"request"})
+
+// Budget limits the extra requests, retries or hedged requests, to a ratio of
+// the requests, across the tenants, so that they don't overload the queriers
+// when most of the requests are slow or failing: each request adds the ratio
+// to the budget, and each extra request takes one out of it. A nil Budget
+// doesn't limit the extra requests.
+type Budget struct {
+	ratio float64
+
+	mtx     sync.Mutex
+	balance float64
+}
+
+// NewBudget returns a budget allowing ratio extra requests per request; nil,
+// no limit, if ratio isn't positive.
+func NewBudget(ratio float64) *Budget {
+	if ratio <= 0 {
+		return nil
+	}
+	return &Budget{
+		ratio:   ratio,
+		balance: budgetBurst,
+	}
+}
+
+// request adds a request to the budget.
+func (b *Budget) request() {
+	if b == nil {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.balance += b.ratio
+	if b.balance > budgetBurst {
+		b.balance = budgetBurst
+	}
+}
+
+// withdraw returns whether an extra request is within the budget, and takes
+// it out of the budget if so.
+func (b *Budget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.balance < 1 {
+		return false
+	}
+	b.balance--
+	return true
+}
@@ -0,0 +1,149 @@
+package queryrange
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var hedgedRequests = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "cortex",
+	Name:      "query_frontend_hedged_requests_total",
+	Help:      "Number of hedged requests sent for slow split queries.",
+})
+
+// Hedging sends a second request for the split queries slower than the 99th
+// percentile of their siblings, once at least half of them completed, and
+// returns the first response, within a budget. As the first request keeps a
+// querier busy, the second one is processed by another querier.
+type Hedging struct {
+	budget *Budget
+}
+
+// NewHedging returns a Hedging limited by the budget.
+func NewHedging(budget *Budget) *Hedging {
+	return &Hedging{
+		budget: budget,
+	}
+}
+
+// wrap returns a handler hedging the requests of n siblings; next if hedging
+// is disabled or there are no siblings.
+func (h *Hedging) wrap(next Handler, n int) Handler {
+	if h == nil || n < 2 {
+		return next
+	}
+	return &hedgeGroup{
+		hedging:  h,
+		next:     next,
+		siblings: n,
+		updated:  make(chan struct{}),
+	}
+}
+
+type hedgeGroup struct {
+	hedging  *Hedging
+	next     Handler
+	siblings int
+
+	mtx       sync.Mutex
+	durations []time.Duration
+	updated   chan struct{} // Closed when a sibling completes.
+}
+
+type hedgeResult struct {
+	resp *APIResponse
+	err  error
+}
+
+// Do implements Handler.
+func (g *hedgeGroup) Do(ctx context.Context, req *Request) (*APIResponse, error) {
+	g.hedging.budget.request()
+	start := time.Now()
+
+	// Cancels the slowest request once we have a response.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	do := func() {
+		resp, err := g.next.Do(ctx, req)
+		results <- hedgeResult{resp, err}
+	}
+	go do()
+
+	pending, hedged := 1, false
+	for {
+		var (
+			timer *time.Timer
+			hedge <-chan time.Time
+		)
+		threshold, ok, updated := g.threshold()
+		if ok && !hedged {
+			timer = time.NewTimer(threshold - time.Since(start))
+			hedge = timer.C
+		}
+
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil || pending == 0 {
+				if result.err == nil {
+					g.completed(time.Since(start))
+				}
+				stopTimer(timer)
+				return result.resp, result.err
+			}
+
+		case <-hedge:
+			hedged = true
+			if g.hedging.budget.withdraw() {
+				hedgedRequests.Inc()
+				pending++
+				go do()
+			} else {
+				overBudget.WithLabelValues("hedge").Inc()
+			}
+
+		case <-updated:
+		}
+		stopTimer(timer)
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// threshold returns the duration after which a request is hedged, false if
+// not enough siblings completed yet, and a channel closed when it changes.
+func (g *hedgeGroup) threshold() (time.Duration, bool, <-chan struct{}) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	n := len(g.durations)
+	if n == 0 || 2*n < g.siblings {
+		return 0, false, g.updated
+	}
+
+	durations := make([]time.Duration, n)
+	copy(durations, g.durations)
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[int(math.Ceil(0.99*float64(n)))-1], true, g.updated
+}
+
+func (g *hedgeGroup) completed(d time.Duration) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.durations = append(g.durations, d)
+	close(g.updated)
+	g.updated = make(chan struct{})
+}
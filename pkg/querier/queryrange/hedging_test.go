@@ -0,0 +1,88 @@
+package queryrange
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestBudget(t *testing.T) {
+	assert.Nil(t, NewBudget(0))
+
+	var unlimited *Budget
+	unlimited.request()
+	assert.True(t, unlimited.withdraw())
+
+	b := NewBudget(0.5)
+	for i := 0; i < budgetBurst; i++ {
+		assert.True(t, b.withdraw())
+	}
+	assert.False(t, b.withdraw())
+
+	b.request()
+	assert.False(t, b.withdraw())
+	b.request()
+	assert.True(t, b.withdraw())
+}
+
+func TestHedging(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		budget   *Budget
+		hedged   bool
+		canceled bool
+	}{
+		{name: "hedged", budget: NewBudget(0.1), hedged: true, canceled: true},
+		{name: "over budget", budget: &Budget{ratio: 0.1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls, slowCalls, canceled int32
+			next := HandlerFunc(func(ctx context.Context, req *Request) (*APIResponse, error) {
+				atomic.AddInt32(&calls, 1)
+
+				// The first request of the last split query is slow.
+				if req.Start == 2 && atomic.AddInt32(&slowCalls, 1) == 1 {
+					select {
+					case <-ctx.Done():
+						atomic.AddInt32(&canceled, 1)
+						return nil, ctx.Err()
+					case <-time.After(200 * time.Millisecond):
+					}
+				}
+				return &APIResponse{Status: statusSuccess}, nil
+			})
+
+			reqs := []*Request{{Start: 0}, {Start: 1}, {Start: 2}}
+			resps, err := doRequests(user.InjectOrgID(context.Background(), "1"), NewHedging(tc.budget).wrap(next, len(reqs)), reqs, fakeLimits{})
+			require.NoError(t, err)
+			assert.Len(t, resps, 3)
+
+			if tc.hedged {
+				assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+			} else {
+				assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+			}
+
+			// The slow request is canceled once the hedged one returned.
+			time.Sleep(10 * time.Millisecond)
+			assert.Equal(t, tc.canceled, atomic.LoadInt32(&canceled) == 1)
+		})
+	}
+}
+
+func TestHedgingDisabled(t *testing.T) {
+	next := HandlerFunc(func(context.Context, *Request) (*APIResponse, error) {
+		return nil, nil
+	})
+
+	var hedging *Hedging
+	_, hedged := hedging.wrap(next, 3).(*hedgeGroup)
+	assert.False(t, hedged)
+	_, hedged = NewHedging(nil).wrap(next, 1).(*hedgeGroup)
+	assert.False(t, hedged)
+}
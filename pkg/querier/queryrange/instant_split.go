@@ -0,0 +1,281 @@
+package queryrange
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/weaveworks/common/user"
+)
+
+// overTimeMerges are the aggregation merging the partial results of the
+// functions over time whose instant queries can be split by interval.
+var overTimeMerges = map[string]promql.ItemType{
+	"sum_over_time":   promql.ItemSum,
+	"count_over_time": promql.ItemSum,
+	"min_over_time":   promql.ItemMin,
+	"max_over_time":   promql.ItemMax,
+}
+
+type instantSplit struct {
+	interval time.Duration
+	limits   Limits
+	next     http.RoundTripper
+}
+
+// NewInstantQuerySplitRoundTripper splits the instant queries of a sum, count,
+// min or max over time of a range selector longer than interval, optionally
+// aggregated by the same operation, e.g. sum(sum_over_time(x[30d])), into one
+// query per interval of the range, executed in parallel and merged.
+func NewInstantQuerySplitRoundTripper(interval time.Duration, limits Limits, next http.RoundTripper) http.RoundTripper {
+	return instantSplit{
+		interval: interval,
+		limits:   limits,
+		next:     next,
+	}
+}
+
+func (s instantSplit) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/api/v1/query") {
+		return s.next.RoundTrip(r)
+	}
+
+	params := r.URL.Query()
+	expr, err := promql.ParseExpr(params.Get("query"))
+	if err != nil {
+		// Let the querier return the error.
+		return s.next.RoundTrip(r)
+	}
+	op, ok := splittableInstantQuery(expr, s.interval)
+	if !ok {
+		return s.next.RoundTrip(r)
+	}
+
+	// All the queries are evaluated at the same time.
+	if params.Get("time") == "" {
+		params.Set("time", encodeTime(timestamp.FromTime(time.Now())))
+	}
+	queries, err := splitInstantQuery(params.Get("query"), s.interval)
+	if err != nil {
+		return nil, err
+	}
+
+	reqs := make([]*http.Request, 0, len(queries))
+	for _, query := range queries {
+		params.Set("query", query)
+		u := *r.URL
+		u.RawQuery = params.Encode()
+		req := r.WithContext(r.Context())
+		req.URL = &u
+		req.RequestURI = u.RequestURI()
+		reqs = append(reqs, req)
+	}
+
+	resps, err := s.doRequests(r.Context(), reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result   = map[string]*model.Sample{}
+		warnings []string
+		seen     = map[string]struct{}{}
+		noStore  bool
+	)
+	for i, resp := range resps {
+		// Return the first failure as is.
+		if resp.StatusCode != http.StatusOK {
+			for _, other := range resps[i+1:] {
+				_ = other.Body.Close()
+			}
+			return resp, nil
+		}
+
+		noStore = noStore || hasCacheDirective(resp.Header, noStoreDirective)
+
+		var partial instantQueryResponse
+		err := json.NewDecoder(resp.Body).Decode(&partial)
+		_ = resp.Body.Close()
+		if err != nil {
+			for _, other := range resps[i+1:] {
+				_ = other.Body.Close()
+			}
+			return nil, err
+		}
+		for _, sample := range partial.Data.Result {
+			key := sample.Metric.String()
+			if existing, ok := result[key]; ok {
+				existing.Value = model.SampleValue(mergeValues(op, float64(existing.Value), float64(sample.Value)))
+			} else {
+				result[key] = sample
+			}
+		}
+		for _, w := range partial.Warnings {
+			if _, ok := seen[w]; !ok {
+				seen[w] = struct{}{}
+				warnings = append(warnings, w)
+			}
+		}
+	}
+
+	response := instantQueryResponse{Status: statusSuccess, Warnings: warnings}
+	response.Data.ResultType = model.ValVector.String()
+	response.Data.Result = make(model.Vector, 0, len(result))
+	for _, sample := range result {
+		response.Data.Result = append(response.Data.Result, sample)
+	}
+	sort.Slice(response.Data.Result, func(i, j int) bool {
+		return response.Data.Result[i].Metric.Before(response.Data.Result[j].Metric)
+	})
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	if noStore {
+		header.Set(cacheControlHeader, noStoreDirective)
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
+
+type instantQueryResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string       `json:"resultType"`
+		Result     model.Vector `json:"result"`
+	} `json:"data"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// doRequests executes the requests with the parallelism of the user, and
+// returns their responses in order.
+func (s instantSplit) doRequests(ctx context.Context, reqs []*http.Request) ([]*http.Response, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parallelism := s.limits.MaxQueryParallelism(userID)
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var (
+		resps = make([]*http.Response, len(reqs))
+		errs  = make([]error, len(reqs))
+		sem   = make(chan struct{}, parallelism)
+		done  = make(chan struct{})
+	)
+	for i := range reqs {
+		go func(i int) {
+			sem <- struct{}{}
+			defer func() { <-sem; done <- struct{}{} }()
+			resps[i], errs[i] = s.next.RoundTrip(reqs[i])
+		}(i)
+	}
+	for range reqs {
+		<-done
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			for _, resp := range resps {
+				if resp != nil {
+					_ = resp.Body.Close()
+				}
+			}
+			return nil, err
+		}
+	}
+	return resps, nil
+}
+
+// splittableInstantQuery returns the operation merging the partial results of
+// a query splittable by interval.
+func splittableInstantQuery(expr promql.Expr, interval time.Duration) (promql.ItemType, bool) {
+	expr = unwrapParens(expr)
+
+	var agg *promql.AggregateExpr
+	if a, ok := expr.(*promql.AggregateExpr); ok {
+		if a.Param != nil {
+			return 0, false
+		}
+		agg, expr = a, unwrapParens(a.Expr)
+	}
+
+	call, ok := expr.(*promql.Call)
+	if !ok || len(call.Args) != 1 {
+		return 0, false
+	}
+	op, ok := overTimeMerges[call.Func.Name]
+	if !ok || (agg != nil && agg.Op != op) {
+		return 0, false
+	}
+	selector, ok := call.Args[0].(*promql.MatrixSelector)
+	if !ok || selector.Range <= interval {
+		return 0, false
+	}
+	return op, true
+}
+
+func unwrapParens(expr promql.Expr) promql.Expr {
+	for {
+		paren, ok := expr.(*promql.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.Expr
+	}
+}
+
+// splitInstantQuery returns the queries of the intervals of the range of the
+// range selector of a splittable query, from the most recent one. As the range
+// selectors include the samples at both the start and the end of their range,
+// the ranges of all but the oldest interval are one millisecond shorter, so
+// that no sample is selected twice.
+func splitInstantQuery(query string, interval time.Duration) ([]string, error) {
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	var total time.Duration
+	promql.Inspect(expr, func(node promql.Node, _ []promql.Node) error {
+		if selector, ok := node.(*promql.MatrixSelector); ok {
+			total = selector.Range
+		}
+		return nil
+	})
+
+	var queries []string
+	for offset := time.Duration(0); offset < total; offset += interval {
+		expr, err := promql.ParseExpr(query)
+		if err != nil {
+			return nil, err
+		}
+		promql.Inspect(expr, func(node promql.Node, _ []promql.Node) error {
+			if selector, ok := node.(*promql.MatrixSelector); ok {
+				selector.Offset += offset
+				selector.Range = interval - time.Millisecond
+				if offset+interval >= total {
+					selector.Range = total - offset
+				}
+			}
+			return nil
+		})
+		queries = append(queries, expr.String())
+	}
+	return queries, nil
+}
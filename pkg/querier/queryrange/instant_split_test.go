@@ -0,0 +1,133 @@
+package queryrange
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestSplittableInstantQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		op    promql.ItemType
+		ok    bool
+	}{
+		{query: `sum_over_time(foo[3d])`, op: promql.ItemSum, ok: true},
+		{query: `(count_over_time(foo{bar="baz"}[3d]))`, op: promql.ItemSum, ok: true},
+		{query: `sum by (bar) (sum_over_time(foo[3d]))`, op: promql.ItemSum, ok: true},
+		{query: `min(min_over_time(foo[3d]))`, op: promql.ItemMin, ok: true},
+		{query: `max_over_time(foo[3d] offset 1h)`, op: promql.ItemMax, ok: true},
+		{query: `sum_over_time(foo[1d])`},
+		{query: `avg_over_time(foo[3d])`},
+		{query: `max(min_over_time(foo[3d]))`},
+		{query: `topk(1, max_over_time(foo[3d]))`},
+		{query: `sum_over_time(rate(foo[5m])[3d:1m])`},
+		{query: `sum_over_time(foo[3d]) / 2`},
+		{query: `foo`},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			expr, err := promql.ParseExpr(tc.query)
+			require.NoError(t, err)
+			op, ok := splittableInstantQuery(expr, 24*time.Hour)
+			assert.Equal(t, tc.ok, ok)
+			if tc.ok {
+				assert.Equal(t, tc.op, op)
+			}
+		})
+	}
+}
+
+func TestSplitInstantQuery(t *testing.T) {
+	queries, err := splitInstantQuery(`sum(sum_over_time(foo[60h] offset 1h))`, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`sum(sum_over_time(foo[86399999ms] offset 1h))`,
+		`sum(sum_over_time(foo[86399999ms] offset 25h))`,
+		`sum(sum_over_time(foo[12h] offset 49h))`,
+	}, queries)
+}
+
+func TestInstantQuerySplitRoundTripper(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		queries []string
+		times   []string
+	)
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		mtx.Lock()
+		queries = append(queries, r.URL.Query().Get("query"))
+		times = append(times, r.URL.Query().Get("time"))
+		mtx.Unlock()
+
+		body := `{"status":"success","data":{"resultType":"vector","result":[` +
+			`{"metric":{"bar":"a"},"value":[100,"1"]},` +
+			`{"metric":{"bar":"b"},"value":[100,"2"]}]},"warnings":["partial"]}`
+		if strings.Contains(r.URL.Query().Get("query"), "offset 1d") {
+			body = `{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"bar":"a"},"value":[100,"3"]}]},"warnings":["partial"]}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+	rt := NewInstantQuerySplitRoundTripper(24*time.Hour, fakeLimits{}, next)
+
+	req := httptest.NewRequest("GET", `/api/prom/api/v1/query?query=sum+by+(bar)+(sum_over_time(foo[36h]))`, nil)
+	req = req.WithContext(user.InjectOrgID(context.Background(), "1"))
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"success","data":{"resultType":"vector","result":[`+
+		`{"metric":{"bar":"a"},"value":[100,"4"]},`+
+		`{"metric":{"bar":"b"},"value":[100,"2"]}]},"warnings":["partial"]}`, string(body))
+
+	require.Len(t, queries, 2)
+	assert.ElementsMatch(t, []string{
+		`sum by(bar) (sum_over_time(foo[86399999ms]))`,
+		`sum by(bar) (sum_over_time(foo[12h] offset 1d))`,
+	}, queries)
+	// All the queries are evaluated at the same time.
+	assert.NotEmpty(t, times[0])
+	assert.Equal(t, times[0], times[1])
+
+	// The other queries are forwarded as is.
+	queries = nil
+	req = httptest.NewRequest("GET", `/api/prom/api/v1/query?query=sum_over_time(foo[1h])`, nil)
+	req = req.WithContext(user.InjectOrgID(context.Background(), "1"))
+	_, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`sum_over_time(foo[1h])`}, queries)
+}
+
+func TestInstantQuerySplitRoundTripperError(t *testing.T) {
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		status, body := http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[]}}`
+		if strings.Contains(r.URL.Query().Get("query"), "offset") {
+			status, body = http.StatusUnprocessableEntity, `{"status":"error","error":"too many samples"}`
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+	rt := NewInstantQuerySplitRoundTripper(24*time.Hour, fakeLimits{}, next)
+
+	req := httptest.NewRequest("GET", `/api/v1/query?query=max_over_time(foo[2d])`, nil)
+	req = req.WithContext(user.InjectOrgID(context.Background(), "1"))
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
@@ -0,0 +1,169 @@
+package queryrange
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+type metadataCache struct {
+	logger log.Logger
+	cfg    ResultsCacheConfig
+	next   http.RoundTripper
+	cache  cache.Cache
+	limits Limits
+
+	genLoader CacheGenNumberLoader
+}
+
+// NewMetadataCacheRoundTripper caches in c the successful responses of the
+// label names, label values and series APIs, for -frontend.max-cache-freshness
+// or the results cache TTL of the tenant if lower, so that the dashboards
+// refreshing their variables don't query the store each time. The requests
+// whose start and end fall in the same period share the cached response.
+// With a genLoader, the responses are cached under the cache generation
+// number of their tenant.
+func NewMetadataCacheRoundTripper(logger log.Logger, cfg ResultsCacheConfig, c cache.Cache, limits Limits, genLoader CacheGenNumberLoader, next http.RoundTripper) http.RoundTripper {
+	return metadataCache{
+		logger:    logger,
+		cfg:       cfg,
+		next:      next,
+		cache:     c,
+		limits:    limits,
+		genLoader: genLoader,
+	}
+}
+
+func isMetadataRequest(path string) bool {
+	if strings.HasSuffix(path, "/api/v1/labels") || strings.HasSuffix(path, "/api/v1/series") {
+		return true
+	}
+	return strings.Contains(path, "/api/v1/label/") && strings.HasSuffix(path, "/values")
+}
+
+func (s metadataCache) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodGet || !isMetadataRequest(r.URL.Path) {
+		return s.next.RoundTrip(r)
+	}
+
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	validity := s.cfg.MaxCacheFreshness
+	if ttl := s.limits.ResultsCacheTTL(userID); ttl > 0 && ttl < validity {
+		validity = ttl
+	}
+	if validity <= 0 {
+		return s.next.RoundTrip(r)
+	}
+
+	key, ok := metadataCacheKey(userID, r, validity)
+	if !ok {
+		return s.next.RoundTrip(r)
+	}
+	genPrefix, err := cacheGenNumberPrefix(r.Context(), s.genLoader, userID)
+	if err != nil {
+		return nil, err
+	}
+	key = genPrefix + key
+
+	// The requests bypassing the cache, including the queries of strong read
+	// consistency, replace the cached response.
+	if !hasCacheDirective(r.Header, noCacheDirective) && !util.IsStrongReadConsistency(r.Context()) {
+		if cached, ok := s.get(r, key, validity); ok {
+			return cached, nil
+		}
+	}
+
+	resp, err := s.next.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" || hasCacheDirective(resp.Header, noStoreDirective) {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	s.put(r, &CachedHTTPResponse{
+		Key:         key,
+		CachedAt:    int64(model.Now()),
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+	})
+	return resp, nil
+}
+
+// metadataCacheKey returns the key of the cached response of a request, with
+// its start and end truncated to the validity of the cached responses; false
+// if they can't be parsed.
+func metadataCacheKey(userID string, r *http.Request, validity time.Duration) (string, bool) {
+	params := r.URL.Query()
+	for _, name := range []string{"start", "end"} {
+		if v := params.Get(name); v != "" {
+			t, err := ParseTime(v)
+			if err != nil {
+				return "", false
+			}
+			period := int64(validity / time.Millisecond)
+			params.Set(name, strconv.FormatInt(t/period*period, 10))
+		}
+	}
+	return fmt.Sprintf("metadata:%s:%s?%s", util.NormalizeOrgID(userID), r.URL.Path, params.Encode()), true
+}
+
+func (s metadataCache) get(r *http.Request, key string, validity time.Duration) (*http.Response, bool) {
+	found, bufs, _ := s.cache.Fetch(r.Context(), []string{cache.HashKey(key)})
+	if len(found) != 1 {
+		return nil, false
+	}
+
+	var cached CachedHTTPResponse
+	if err := proto.Unmarshal(bufs[0], &cached); err != nil {
+		level.Error(s.logger).Log("msg", "error unmarshalling cached value", "err", err)
+		return nil, false
+	}
+	if cached.Key != key || model.Time(cached.CachedAt).Add(validity).Before(model.Now()) {
+		return nil, false
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(cached.Body)),
+		Request:    r,
+	}
+	if cached.ContentType != "" {
+		resp.Header.Set("Content-Type", cached.ContentType)
+	}
+	return resp, true
+}
+
+func (s metadataCache) put(r *http.Request, cached *CachedHTTPResponse) {
+	buf, err := proto.Marshal(cached)
+	if err != nil {
+		level.Error(s.logger).Log("msg", "error marshalling cached value", "err", err)
+		return
+	}
+	if s.cfg.MaxItemSize > 0 && len(buf) > s.cfg.MaxItemSize {
+		return
+	}
+
+	s.cache.Store(r.Context(), []string{cache.HashKey(cached.Key)}, [][]byte{buf})
+}
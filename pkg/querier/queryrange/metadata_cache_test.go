@@ -0,0 +1,110 @@
+package queryrange
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+)
+
+func TestMetadataCache(t *testing.T) {
+	const body = `{"status":"success","data":["__name__","job"]}`
+	calls := 0
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}, nil
+	})
+	rt := NewMetadataCacheRoundTripper(log.NewNopLogger(), ResultsCacheConfig{MaxCacheFreshness: time.Hour}, cache.NewMockCache(), fakeLimits{}, nil, next)
+
+	do := func(url string) {
+		req := httptest.NewRequest("GET", url, nil)
+		req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(b))
+	}
+
+	do("/api/prom/api/v1/labels?start=1536673680&end=1536716898")
+	require.Equal(t, 1, calls)
+
+	// The same period is served from the cache.
+	do("/api/prom/api/v1/labels?start=1536673690&end=1536716890")
+	require.Equal(t, 1, calls)
+
+	// Other endpoints and parameters aren't.
+	do("/api/prom/api/v1/label/job/values?start=1536673680&end=1536716898")
+	require.Equal(t, 2, calls)
+	do("/api/prom/api/v1/series?match[]=up&start=1536673680&end=1536716898")
+	require.Equal(t, 3, calls)
+	do("/api/prom/api/v1/series?match[]=up&start=1536673680&end=1536716898")
+	require.Equal(t, 3, calls)
+
+	// Nor are the other APIs.
+	do("/api/prom/api/v1/query?query=up")
+	do("/api/prom/api/v1/query?query=up")
+	require.Equal(t, 5, calls)
+}
+
+func TestMetadataCacheControl(t *testing.T) {
+	const body = `{"status":"success","data":["__name__","job"]}`
+	var calls int
+	noStore := false
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}
+		if noStore {
+			resp.Header.Set("Cache-Control", "private, no-store")
+		}
+		return resp, nil
+	})
+	rt := NewMetadataCacheRoundTripper(log.NewNopLogger(), ResultsCacheConfig{MaxCacheFreshness: time.Hour}, cache.NewMockCache(), fakeLimits{}, nil, next)
+
+	do := func(cacheControl string) {
+		req := httptest.NewRequest("GET", "/api/prom/api/v1/labels", nil)
+		req.Header.Set("Cache-Control", cacheControl)
+		req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// The responses downstream asked not to store are not cached.
+	noStore = true
+	do("")
+	do("")
+	require.Equal(t, 2, calls)
+
+	// The requests bypassing the cache are forwarded, and cached.
+	noStore = false
+	do("")
+	do("no-cache")
+	require.Equal(t, 4, calls)
+	do("")
+	require.Equal(t, 4, calls)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
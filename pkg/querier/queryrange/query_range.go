@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -23,6 +24,14 @@ import (
 
 const statusSuccess = "success"
 
+// The Cache-Control header, and its directives, of the requests bypassing the
+// results cache and the responses which must not be cached.
+const (
+	cacheControlHeader = "Cache-Control"
+	noCacheDirective   = "no-cache"
+	noStoreDirective   = "no-store"
+)
+
 var (
 	matrix            = model.ValMatrix.String()
 	json              = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -31,7 +40,13 @@ var (
 	errStepTooSmall   = httpgrpc.Errorf(http.StatusBadRequest, "exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)")
 )
 
-func parseRequest(r *http.Request) (*Request, error) {
+// maxPointsPerSeries is the maximum number of points per series of a query.
+const maxPointsPerSeries = 11000
+
+// parseRequest parses a range query, raising its step so that it returns at
+// most autoStepMaxPoints points per series if it's positive, and aligning the
+// start and end of the adjusted query to its step.
+func parseRequest(r *http.Request, autoStepMaxPoints int) (*Request, error) {
 	var result Request
 	var err error
 	result.Start, err = ParseTime(r.FormValue("start"))
@@ -57,17 +72,43 @@ func parseRequest(r *http.Request) (*Request, error) {
 		return nil, errNegativeStep
 	}
 
+	if autoStepMaxPoints > maxPointsPerSeries {
+		autoStepMaxPoints = maxPointsPerSeries
+	}
+	if autoStepMaxPoints > 0 && (result.End-result.Start)/result.Step > int64(autoStepMaxPoints) {
+		result.Step = minStep(result.End-result.Start, autoStepMaxPoints)
+		// The adjusted queries are aligned to their step even without
+		// -querier.align-querier-with-step, so that the queries adjusted to
+		// the same step share their cached results.
+		result.Start = (result.Start / result.Step) * result.Step
+		result.End = (result.End / result.Step) * result.Step
+	}
+
 	// For safety, limit the number of returned points per timeseries.
 	// This is sufficient for 60s resolution for a week or 1h resolution for a year.
-	if (result.End-result.Start)/result.Step > 11000 {
+	if (result.End-result.Start)/result.Step > maxPointsPerSeries {
 		return nil, errStepTooSmall
 	}
 
 	result.Query = r.FormValue("query")
 	result.Path = r.URL.Path
+	result.NoCache = hasCacheDirective(r.Header, noCacheDirective)
 	return &result, nil
 }
 
+// hasCacheDirective returns whether the Cache-Control header has the
+// directive.
+func hasCacheDirective(h http.Header, directive string) bool {
+	for _, v := range h[cacheControlHeader] {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (q Request) copy() Request {
 	return q
 }
@@ -132,6 +173,14 @@ func parseDurationMs(s string) (int64, error) {
 	return 0, httpgrpc.Errorf(http.StatusBadRequest, "cannot parse %q to a valid duration", s)
 }
 
+// minStep returns the smallest step, in whole seconds so that the adjusted
+// queries share their cached results, of a query of the range returning at most
+// points points per series.
+func minStep(rangeMs int64, points int) int64 {
+	step := (rangeMs + int64(points) - 1) / int64(points)
+	return (step + 999) / 1000 * 1000
+}
+
 func encodeTime(t int64) string {
 	f := float64(t) / 1.0e3
 	return strconv.FormatFloat(f, 'f', -1, 64)
@@ -162,6 +211,7 @@ func parseResponse(ctx context.Context, r *http.Response) (*APIResponse, error)
 	if err := json.Unmarshal(buf, &resp); err != nil {
 		return nil, httpgrpc.Errorf(http.StatusInternalServerError, "error decoding response: %v", err)
 	}
+	resp.NoStore = hasCacheDirective(r.Header, noStoreDirective)
 	return &resp, nil
 }
 
@@ -209,6 +259,9 @@ func (a *APIResponse) toHTTPResponse(ctx context.Context) (*http.Response, error
 		Body:       ioutil.NopCloser(bytes.NewBuffer(b)),
 		StatusCode: http.StatusOK,
 	}
+	if a.NoStore {
+		resp.Header.Set(cacheControlHeader, noStoreDirective)
+	}
 	return &resp, nil
 }
 
@@ -265,9 +318,37 @@ func mergeAPIResponses(responses []*APIResponse) (*APIResponse, error) {
 			ResultType: model.ValMatrix.String(),
 			Result:     matrixMerge(responses),
 		},
+		Warnings: warningsMerge(responses),
+		NoStore:  noStoreMerge(responses),
 	}, nil
 }
 
+// noStoreMerge returns whether any of the responses must not be cached.
+func noStoreMerge(resps []*APIResponse) bool {
+	for _, resp := range resps {
+		if resp.NoStore {
+			return true
+		}
+	}
+	return false
+}
+
+// warningsMerge returns the distinct warnings of the responses.
+func warningsMerge(resps []*APIResponse) []string {
+	var warnings []string
+	seen := map[string]struct{}{}
+	for _, resp := range resps {
+		for _, w := range resp.Warnings {
+			if _, ok := seen[w]; ok {
+				continue
+			}
+			seen[w] = struct{}{}
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
 type byFirstTime []*APIResponse
 
 func (a byFirstTime) Len() int           { return len(a) }
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"testing"
 
 	jsoniter "github.com/json-iterator/go"
@@ -59,7 +60,7 @@ func TestRequest(t *testing.T) {
 			ctx := user.InjectOrgID(context.Background(), "1")
 			r = r.WithContext(ctx)
 
-			req, err := parseRequest(r)
+			req, err := parseRequest(r, 0)
 			if err != nil {
 				require.EqualValues(t, tc.expectedErr, err)
 				return
@@ -73,6 +74,60 @@ func TestRequest(t *testing.T) {
 	}
 }
 
+func TestParseRequestAutoStep(t *testing.T) {
+	for _, tc := range []struct {
+		url        string
+		maxPoints  int
+		step       int64
+		start, end int64
+	}{
+		{url: "api/v1/query_range?start=0&end=86400&step=1", maxPoints: 1000, step: 87000, start: 0, end: 86391000},
+		// Not adjusted, so not aligned either.
+		{url: "api/v1/query_range?start=1&end=86401&step=120", maxPoints: 1000, step: 120000, start: 1000, end: 86401000},
+		// Capped to the maximum number of points.
+		{url: "api/v1/query_range?start=0&end=86400&step=1", maxPoints: 20000, step: 8000, start: 0, end: 86400000},
+		// The adjusted queries are aligned to their step.
+		{url: "api/v1/query_range?start=1&end=86401&step=1", maxPoints: 1000, step: 87000, start: 0, end: 86391000},
+		{url: "api/v1/query_range?start=2&end=86402&step=1", maxPoints: 1000, step: 87000, start: 0, end: 86391000},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			r, err := http.NewRequest("GET", tc.url, nil)
+			require.NoError(t, err)
+
+			req, err := parseRequest(r, tc.maxPoints)
+			require.NoError(t, err)
+			require.Equal(t, tc.step, req.Step)
+			require.Equal(t, tc.start, req.Start)
+			require.Equal(t, tc.end, req.End)
+		})
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	r, err := http.NewRequest("GET", "api/v1/query_range?start=0&end=60&step=15", nil)
+	require.NoError(t, err)
+	req, err := parseRequest(r, 0)
+	require.NoError(t, err)
+	require.False(t, req.NoCache)
+
+	r.Header.Set("Cache-Control", "max-age=0, No-Cache")
+	req, err = parseRequest(r, 0)
+	require.NoError(t, err)
+	require.True(t, req.NoCache)
+
+	resp, err := parseResponse(context.Background(), &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Cache-Control": []string{"no-store"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"status":"success","data":{"resultType":"matrix","result":[]}}`)),
+	})
+	require.NoError(t, err)
+	require.True(t, resp.NoStore)
+
+	merged, err := mergeAPIResponses([]*APIResponse{{Status: statusSuccess}, resp})
+	require.NoError(t, err)
+	require.True(t, merged.NoStore)
+}
+
 func TestResponse(t *testing.T) {
 	for i, tc := range []struct {
 		body     string
@@ -214,6 +269,22 @@ func TestMergeAPIResponses(t *testing.T) {
 			},
 		},
 
+		// The warnings of the responses are kept, once.
+		{
+			input: []*APIResponse{
+				{Data: Response{ResultType: matrix, Result: []SampleStream{}}, Warnings: []string{"a"}},
+				{Data: Response{ResultType: matrix, Result: []SampleStream{}}, Warnings: []string{"a", "b"}},
+			},
+			expected: &APIResponse{
+				Status: statusSuccess,
+				Data: Response{
+					ResultType: matrix,
+					Result:     []SampleStream{},
+				},
+				Warnings: []string{"a", "b"},
+			},
+		},
+
 		// Merging of responses when labels are in different order.
 		{
 			input: []*APIResponse{
@@ -0,0 +1,222 @@
+package queryrange
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+// shardableFunctions are the functions computed independently for each series,
+// which can be computed on a shard of the series of a query.
+var shardableFunctions = map[string]struct{}{
+	"abs": {}, "avg_over_time": {}, "ceil": {}, "changes": {}, "clamp_max": {}, "clamp_min": {},
+	"count_over_time": {}, "delta": {}, "deriv": {}, "exp": {}, "floor": {}, "holt_winters": {},
+	"idelta": {}, "increase": {}, "irate": {}, "ln": {}, "log10": {}, "log2": {},
+	"max_over_time": {}, "min_over_time": {}, "predict_linear": {}, "quantile_over_time": {},
+	"rate": {}, "resets": {}, "round": {}, "sqrt": {}, "stddev_over_time": {},
+	"stdvar_over_time": {}, "sum_over_time": {}, "time": {}, "timestamp": {},
+}
+
+// QueryShardingMiddleware creates a new Middleware that splits the shardable
+// queries, sum, min, max and count aggregations of series computed
+// independently, into one query per shard of their series, selected by the
+// __query_shard__ matcher, executed in parallel and merged.
+func QueryShardingMiddleware(shards int, limits Limits) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return queryShardingMiddleware{
+			next:   next,
+			shards: shards,
+			limits: limits,
+		}
+	})
+}
+
+type queryShardingMiddleware struct {
+	next   Handler
+	shards int
+	limits Limits
+}
+
+func (s queryShardingMiddleware) Do(ctx context.Context, r *Request) (*APIResponse, error) {
+	if s.shards <= 1 {
+		return s.next.Do(ctx, r)
+	}
+
+	expr, err := promql.ParseExpr(r.Query)
+	if err != nil {
+		// Let the querier return the error.
+		return s.next.Do(ctx, r)
+	}
+	op, ok := shardableAggregation(expr)
+	if !ok {
+		return s.next.Do(ctx, r)
+	}
+
+	reqs, err := shardQuery(r, s.shards)
+	if err != nil {
+		return nil, err
+	}
+
+	reqResps, err := doRequests(ctx, s.next, reqs, s.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	resps := make([]*APIResponse, 0, len(reqResps))
+	for _, reqResp := range reqResps {
+		resps = append(resps, reqResp.resp)
+	}
+	return &APIResponse{
+		Status: statusSuccess,
+		Data: Response{
+			ResultType: model.ValMatrix.String(),
+			Result:     shardedMatrixMerge(op, resps),
+		},
+		Warnings: warningsMerge(resps),
+		NoStore:  noStoreMerge(resps),
+	}, nil
+}
+
+// shardableAggregation returns the aggregation of a shardable query.
+func shardableAggregation(expr promql.Expr) (promql.ItemType, bool) {
+	agg, ok := unwrapParens(expr).(*promql.AggregateExpr)
+	if !ok {
+		return 0, false
+	}
+	switch agg.Op {
+	case promql.ItemSum, promql.ItemMin, promql.ItemMax, promql.ItemCount:
+	default:
+		return 0, false
+	}
+
+	shardable, selectors := true, 0
+	promql.Inspect(agg.Expr, func(node promql.Node, _ []promql.Node) error {
+		switch n := node.(type) {
+		case *promql.AggregateExpr:
+			shardable = false
+		case *promql.Call:
+			if _, ok := shardableFunctions[n.Func.Name]; !ok {
+				shardable = false
+			}
+		case *promql.BinaryExpr:
+			// The binary operations between two vectors match series which may
+			// be in different shards.
+			if n.LHS.Type() != promql.ValueTypeScalar && n.RHS.Type() != promql.ValueTypeScalar {
+				shardable = false
+			}
+		case *promql.SubqueryExpr:
+			// The offset of the subqueries isn't printed back.
+			if n.Offset != 0 {
+				shardable = false
+			}
+		case *promql.VectorSelector, *promql.MatrixSelector:
+			selectors++
+		}
+		return nil
+	})
+	return agg.Op, shardable && selectors > 0
+}
+
+// shardQuery returns the query of each shard of the request, with the shard
+// matcher added to each of its selectors.
+func shardQuery(r *Request, shards int) ([]*Request, error) {
+	reqs := make([]*Request, 0, shards)
+	for i := 0; i < shards; i++ {
+		expr, err := promql.ParseExpr(r.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		shard := chunk.QueryShard{Index: uint32(i), Of: uint32(shards)}
+		matcher, err := labels.NewMatcher(labels.MatchEqual, chunk.QueryShardLabel, shard.String())
+		if err != nil {
+			return nil, err
+		}
+		promql.Inspect(expr, func(node promql.Node, _ []promql.Node) error {
+			switch n := node.(type) {
+			case *promql.VectorSelector:
+				n.LabelMatchers = append(n.LabelMatchers, matcher)
+			case *promql.MatrixSelector:
+				n.LabelMatchers = append(n.LabelMatchers, matcher)
+			}
+			return nil
+		})
+
+		req := r.copy()
+		req.Query = expr.String()
+		reqs = append(reqs, &req)
+	}
+	return reqs, nil
+}
+
+// shardedMatrixMerge merges the results of the shards of an aggregation: the
+// samples of the same series at the same timestamp are summed for sum and
+// count, and the lowest or highest is kept for min and max.
+func shardedMatrixMerge(op promql.ItemType, resps []*APIResponse) []SampleStream {
+	output := map[string]*SampleStream{}
+	values := map[string]map[int64]float64{}
+	for _, resp := range resps {
+		for _, stream := range resp.Data.Result {
+			metric := client.FromLabelAdaptersToLabels(stream.Labels).String()
+			if _, ok := output[metric]; !ok {
+				output[metric] = &SampleStream{Labels: stream.Labels}
+				values[metric] = map[int64]float64{}
+			}
+
+			series := values[metric]
+			for _, sample := range stream.Samples {
+				if existing, ok := series[sample.TimestampMs]; ok {
+					series[sample.TimestampMs] = mergeValues(op, existing, sample.Value)
+				} else {
+					series[sample.TimestampMs] = sample.Value
+				}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(output))
+	for key := range output {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]SampleStream, 0, len(output))
+	for _, key := range keys {
+		stream := output[key]
+		stream.Samples = make([]client.Sample, 0, len(values[key]))
+		for ts, v := range values[key] {
+			stream.Samples = append(stream.Samples, client.Sample{TimestampMs: ts, Value: v})
+		}
+		sort.Slice(stream.Samples, func(i, j int) bool {
+			return stream.Samples[i].TimestampMs < stream.Samples[j].TimestampMs
+		})
+		result = append(result, *stream)
+	}
+	return result
+}
+
+// mergeValues merges the values of the same series of two partial results of
+// an aggregation: the lowest for min, the highest for max and the sum else.
+func mergeValues(op promql.ItemType, existing, v float64) float64 {
+	switch op {
+	case promql.ItemMin:
+		if v < existing || math.IsNaN(existing) {
+			return v
+		}
+		return existing
+	case promql.ItemMax:
+		if v > existing || math.IsNaN(existing) {
+			return v
+		}
+		return existing
+	default:
+		return existing + v
+	}
+}
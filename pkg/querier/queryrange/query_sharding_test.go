@@ -0,0 +1,128 @@
+package queryrange
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+func TestShardableAggregation(t *testing.T) {
+	for _, tc := range []struct {
+		query     string
+		shardable bool
+	}{
+		{`sum(foo)`, true},
+		{`sum by (bar) (rate(foo[5m]))`, true},
+		{`(max without (bar) (foo{bar="baz"} * 2))`, true},
+		{`count(foo > 1)`, true},
+		{`min(max_over_time(rate(foo[5m])[1h:1m]))`, true},
+		{`sum(foo) / 2`, false},
+		{`avg(foo)`, false},
+		{`topk(5, foo)`, false},
+		{`sum(sum by (bar) (foo))`, false},
+		{`sum(foo / bar)`, false},
+		{`sum(label_replace(foo, "a", "$1", "b", "(.*)"))`, false},
+		{`sum(max_over_time(rate(foo[5m])[1h:1m] offset 1h))`, false},
+		{`foo`, false},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			expr, err := promql.ParseExpr(tc.query)
+			require.NoError(t, err)
+			_, shardable := shardableAggregation(expr)
+			assert.Equal(t, tc.shardable, shardable)
+		})
+	}
+}
+
+func TestShardQuery(t *testing.T) {
+	reqs, err := shardQuery(&Request{
+		Path:  "/api/v1/query_range",
+		Start: 0,
+		End:   60 * seconds,
+		Step:  15 * seconds,
+		Query: `sum by (bar) (rate(foo{bar="baz"}[5m]) + foo)`,
+	}, 2)
+	require.NoError(t, err)
+	require.Len(t, reqs, 2)
+	assert.Equal(t, `sum by(bar) (rate(foo{__query_shard__="0_of_2",bar="baz"}[5m]) + foo{__query_shard__="0_of_2"})`, reqs[0].Query)
+	assert.Equal(t, `sum by(bar) (rate(foo{__query_shard__="1_of_2",bar="baz"}[5m]) + foo{__query_shard__="1_of_2"})`, reqs[1].Query)
+	for _, req := range reqs {
+		assert.Equal(t, int64(60*seconds), req.End)
+		assert.Equal(t, int64(15*seconds), req.Step)
+	}
+}
+
+func TestQueryShardingMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		expected []float64
+		calls    int
+	}{
+		{`sum(foo)`, []float64{6, 3}, 3},
+		{`count(foo)`, []float64{6, 3}, 3},
+		{`min(foo)`, []float64{1, 1}, 3},
+		{`max(foo)`, []float64{3, 2}, 3},
+		{`avg(foo)`, nil, 1},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			var (
+				mtx   sync.Mutex
+				calls int
+			)
+			handler := QueryShardingMiddleware(3, fakeLimits{}).Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+				mtx.Lock()
+				calls++
+				mtx.Unlock()
+
+				// The shard i returns i+1 at the first step, and i at the
+				// second step except for the shard 0, which has no sample.
+				var v float64
+				for i, shard := range []string{"0_of_3", "1_of_3", "2_of_3"} {
+					if strings.Contains(req.Query, shard) {
+						v = float64(i)
+					}
+				}
+				samples := []client.Sample{{TimestampMs: 0, Value: v + 1}}
+				if v > 0 {
+					samples = append(samples, client.Sample{TimestampMs: 15 * seconds, Value: v})
+				}
+				return &APIResponse{
+					Status: statusSuccess,
+					Data: Response{
+						ResultType: matrix,
+						Result:     []SampleStream{{Samples: samples}},
+					},
+				}, nil
+			}))
+
+			ctx := user.InjectOrgID(context.Background(), "1")
+			resp, err := handler.Do(ctx, &Request{
+				Path:  "/api/v1/query_range",
+				Start: 0,
+				End:   15 * seconds,
+				Step:  15 * seconds,
+				Query: tc.query,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.calls, calls)
+			require.Len(t, resp.Data.Result, 1)
+
+			var values []float64
+			for _, sample := range resp.Data.Result[0].Samples {
+				values = append(values, sample.Value)
+			}
+			if tc.calls > 1 {
+				assert.Equal(t, tc.expected, values)
+				assert.Equal(t, int64(15*seconds), resp.Data.Result[0].Samples[1].TimestampMs)
+			}
+		})
+	}
+}
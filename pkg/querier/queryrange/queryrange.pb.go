@@ -4,6 +4,7 @@
 package queryrange
 
 import (
+	bytes "bytes"
 	fmt "fmt"
 	client "github.com/cortexproject/cortex/pkg/ingester/client"
 	github_com_cortexproject_cortex_pkg_ingester_client "github.com/cortexproject/cortex/pkg/ingester/client"
@@ -37,6 +38,9 @@ type Request struct {
 	Step    int64         `protobuf:"varint,4,opt,name=step,proto3" json:"step,omitempty"`
 	Timeout time.Duration `protobuf:"bytes,5,opt,name=timeout,proto3,stdduration" json:"timeout"`
 	Query   string        `protobuf:"bytes,6,opt,name=query,proto3" json:"query,omitempty"`
+	// Whether the results cache is bypassed, requested with a Cache-Control:
+	// no-cache header.
+	NoCache bool `protobuf:"varint,7,opt,name=no_cache,json=noCache,proto3" json:"-"`
 }
 
 func (m *Request) Reset()      { *m = Request{} }
@@ -113,11 +117,22 @@ func (m *Request) GetQuery() string {
 	return ""
 }
 
+func (m *Request) GetNoCache() bool {
+	if m != nil {
+		return m.NoCache
+	}
+	return false
+}
+
 type APIResponse struct {
 	Status    string   `protobuf:"bytes,1,opt,name=Status,json=status,proto3" json:"status"`
 	Data      Response `protobuf:"bytes,2,opt,name=Data,json=data,proto3" json:"data,omitempty"`
 	ErrorType string   `protobuf:"bytes,3,opt,name=ErrorType,json=errorType,proto3" json:"errorType,omitempty"`
 	Error     string   `protobuf:"bytes,4,opt,name=Error,json=error,proto3" json:"error,omitempty"`
+	Warnings  []string `protobuf:"bytes,5,rep,name=Warnings,json=warnings,proto3" json:"warnings,omitempty"`
+	// Whether the response must not be cached, from a Cache-Control: no-store
+	// header of the downstream response.
+	NoStore bool `protobuf:"varint,6,opt,name=NoStore,json=noStore,proto3" json:"-"`
 }
 
 func (m *APIResponse) Reset()      { *m = APIResponse{} }
@@ -180,6 +195,20 @@ func (m *APIResponse) GetError() string {
 	return ""
 }
 
+func (m *APIResponse) GetWarnings() []string {
+	if m != nil {
+		return m.Warnings
+	}
+	return nil
+}
+
+func (m *APIResponse) GetNoStore() bool {
+	if m != nil {
+		return m.NoStore
+	}
+	return false
+}
+
 type Response struct {
 	ResultType string         `protobuf:"bytes,1,opt,name=ResultType,json=resultType,proto3" json:"resultType"`
 	Result     []SampleStream `protobuf:"bytes,2,rep,name=Result,json=result,proto3" json:"result"`
@@ -332,6 +361,8 @@ type Extent struct {
 	End      int64        `protobuf:"varint,2,opt,name=end,proto3" json:"end"`
 	Response *APIResponse `protobuf:"bytes,3,opt,name=response,proto3" json:"response"`
 	TraceId  string       `protobuf:"bytes,4,opt,name=trace_id,json=traceId,proto3" json:"-"`
+	// When the oldest data of the extent was cached, in milliseconds.
+	CachedAt int64 `protobuf:"varint,5,opt,name=cached_at,json=cachedAt,proto3" json:"cached_at"`
 }
 
 func (m *Extent) Reset()      { *m = Extent{} }
@@ -394,6 +425,82 @@ func (m *Extent) GetTraceId() string {
 	return ""
 }
 
+func (m *Extent) GetCachedAt() int64 {
+	if m != nil {
+		return m.CachedAt
+	}
+	return 0
+}
+
+// A response of the metadata APIs, e.g. label names, cached by the frontend.
+type CachedHTTPResponse struct {
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// When the response was cached, in milliseconds.
+	CachedAt    int64  `protobuf:"varint,2,opt,name=cached_at,json=cachedAt,proto3" json:"cached_at,omitempty"`
+	ContentType string `protobuf:"bytes,3,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Body        []byte `protobuf:"bytes,4,opt,name=body,proto3" json:"body,omitempty"`
+}
+
+func (m *CachedHTTPResponse) Reset()      { *m = CachedHTTPResponse{} }
+func (*CachedHTTPResponse) ProtoMessage() {}
+func (*CachedHTTPResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_79b02382e213d0b2, []int{6}
+}
+func (m *CachedHTTPResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *CachedHTTPResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_CachedHTTPResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *CachedHTTPResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CachedHTTPResponse.Merge(m, src)
+}
+func (m *CachedHTTPResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *CachedHTTPResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CachedHTTPResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CachedHTTPResponse proto.InternalMessageInfo
+
+func (m *CachedHTTPResponse) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *CachedHTTPResponse) GetCachedAt() int64 {
+	if m != nil {
+		return m.CachedAt
+	}
+	return 0
+}
+
+func (m *CachedHTTPResponse) GetContentType() string {
+	if m != nil {
+		return m.ContentType
+	}
+	return ""
+}
+
+func (m *CachedHTTPResponse) GetBody() []byte {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Request)(nil), "queryrange.Request")
 	proto.RegisterType((*APIResponse)(nil), "queryrange.APIResponse")
@@ -401,57 +508,66 @@ func init() {
 	proto.RegisterType((*SampleStream)(nil), "queryrange.SampleStream")
 	proto.RegisterType((*CachedResponse)(nil), "queryrange.CachedResponse")
 	proto.RegisterType((*Extent)(nil), "queryrange.Extent")
+	proto.RegisterType((*CachedHTTPResponse)(nil), "queryrange.CachedHTTPResponse")
 }
 
 func init() { proto.RegisterFile("queryrange.proto", fileDescriptor_79b02382e213d0b2) }
 
 var fileDescriptor_79b02382e213d0b2 = []byte{
-	// 705 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xcd, 0x4e, 0xdb, 0x4a,
-	0x14, 0xce, 0x90, 0xc4, 0x49, 0x26, 0x28, 0x70, 0x07, 0x74, 0x31, 0x2c, 0xec, 0x28, 0xab, 0x5c,
-	0xe9, 0xe2, 0x48, 0xb9, 0xba, 0x52, 0x37, 0x95, 0x82, 0x0b, 0x0b, 0xa4, 0x2e, 0xd0, 0xd0, 0x55,
-	0x37, 0xd5, 0x24, 0x3e, 0x35, 0x86, 0xc4, 0x36, 0xe3, 0x71, 0x45, 0xa4, 0x56, 0xea, 0x23, 0x74,
-	0xd9, 0x47, 0x68, 0x37, 0x7d, 0x8d, 0xb2, 0x64, 0x53, 0x09, 0x75, 0xe1, 0x16, 0xb3, 0xa9, 0xbc,
-	0xe2, 0x11, 0x2a, 0xcf, 0xd8, 0xc1, 0xeb, 0x6e, 0xf0, 0x39, 0xdf, 0x9c, 0x9f, 0xef, 0x3b, 0x9c,
-	0x13, 0xbc, 0x79, 0x19, 0x03, 0x5f, 0x72, 0xe6, 0xbb, 0x60, 0x85, 0x3c, 0x10, 0x01, 0xc1, 0x8f,
-	0xc8, 0xde, 0xbe, 0xeb, 0x89, 0xb3, 0x78, 0x6a, 0xcd, 0x82, 0xc5, 0xc8, 0x0d, 0xdc, 0x60, 0x24,
-	0x43, 0xa6, 0xf1, 0x6b, 0xe9, 0x49, 0x47, 0x5a, 0x2a, 0x75, 0xcf, 0x70, 0x83, 0xc0, 0x9d, 0xc3,
-	0x63, 0x94, 0x13, 0x73, 0x26, 0xbc, 0xc0, 0x2f, 0xde, 0x27, 0x95, 0x72, 0xb3, 0x80, 0x0b, 0xb8,
-	0x0a, 0x79, 0x70, 0x0e, 0x33, 0x51, 0x78, 0xa3, 0xf0, 0xc2, 0x1d, 0x79, 0xbe, 0x0b, 0x91, 0x00,
-	0x3e, 0x9a, 0xcd, 0x3d, 0xf0, 0xcb, 0x27, 0x55, 0x61, 0xf0, 0x05, 0xe1, 0x16, 0x85, 0xcb, 0x18,
-	0x22, 0x41, 0x08, 0x6e, 0x84, 0x4c, 0x9c, 0xe9, 0xa8, 0x8f, 0x86, 0x1d, 0x2a, 0x6d, 0xb2, 0x8d,
-	0x9b, 0x91, 0x60, 0x5c, 0xe8, 0x6b, 0x7d, 0x34, 0xac, 0x53, 0xe5, 0x90, 0x4d, 0x5c, 0x07, 0xdf,
-	0xd1, 0xeb, 0x12, 0xcb, 0xcd, 0x3c, 0x37, 0x12, 0x10, 0xea, 0x0d, 0x09, 0x49, 0x9b, 0x3c, 0xc5,
-	0x2d, 0xe1, 0x2d, 0x20, 0x88, 0x85, 0xde, 0xec, 0xa3, 0x61, 0x77, 0xbc, 0x6b, 0x29, 0x3d, 0x56,
-	0xa9, 0xc7, 0x3a, 0x2c, 0xf4, 0xd8, 0xed, 0xeb, 0xc4, 0xac, 0x7d, 0xfc, 0x61, 0x22, 0x5a, 0xe6,
-	0xe4, 0xad, 0xe5, 0xe4, 0x74, 0x4d, 0xf2, 0x51, 0xce, 0xe0, 0x1b, 0xc2, 0xdd, 0x83, 0x93, 0x63,
-	0x0a, 0x51, 0x18, 0xf8, 0x11, 0x90, 0x01, 0xd6, 0x4e, 0x05, 0x13, 0x71, 0xa4, 0x68, 0xdb, 0x38,
-	0x4b, 0x4c, 0x2d, 0x92, 0x08, 0x2d, 0xbe, 0x64, 0x82, 0x1b, 0x87, 0x4c, 0x30, 0xa9, 0xa1, 0x3b,
-	0xde, 0xb6, 0x2a, 0xff, 0xa2, 0xb2, 0x8e, 0xfd, 0x77, 0x4e, 0x20, 0x4b, 0xcc, 0x9e, 0xc3, 0x04,
-	0xfb, 0x37, 0x58, 0x78, 0x02, 0x16, 0xa1, 0x58, 0xd2, 0x46, 0xee, 0x93, 0xff, 0x71, 0xe7, 0x88,
-	0xf3, 0x80, 0xbf, 0x58, 0x86, 0x20, 0x65, 0x77, 0xec, 0x9d, 0x2c, 0x31, 0xb7, 0xa0, 0x04, 0x2b,
-	0x19, 0x9d, 0x15, 0x48, 0xfe, 0xc1, 0x4d, 0x99, 0x26, 0xc7, 0xd2, 0xb1, 0xb7, 0xb2, 0xc4, 0xdc,
-	0x90, 0xaf, 0x95, 0xf0, 0xa6, 0x04, 0x06, 0x6f, 0x71, 0x7b, 0xa5, 0xc9, 0xc2, 0x98, 0x42, 0x14,
-	0xcf, 0x85, 0x6c, 0xa7, 0x74, 0xf5, 0xb2, 0xc4, 0xc4, 0x7c, 0x85, 0xd2, 0x8a, 0x4d, 0x26, 0x58,
-	0x53, 0xf1, 0xfa, 0x5a, 0xbf, 0x3e, 0xec, 0x8e, 0xf5, 0xaa, 0xc2, 0x53, 0xb6, 0x08, 0xe7, 0x70,
-	0x2a, 0x38, 0xb0, 0x85, 0xdd, 0x2b, 0x54, 0x6a, 0x2a, 0x9b, 0x16, 0xdf, 0xc1, 0x57, 0x84, 0xd7,
-	0xab, 0x81, 0xe4, 0x1d, 0xd6, 0xe6, 0x6c, 0x0a, 0xf3, 0x7c, 0xac, 0x79, 0xc9, 0xbf, 0xac, 0x62,
-	0x6d, 0x9e, 0xe7, 0xe8, 0x09, 0xf3, 0xb8, 0x4d, 0xf3, 0x5a, 0xdf, 0x13, 0xf3, 0x4f, 0x96, 0x50,
-	0x95, 0x39, 0x70, 0x58, 0x28, 0x80, 0xe7, 0x7c, 0x16, 0x20, 0xb8, 0x37, 0xa3, 0x45, 0x53, 0xf2,
-	0x04, 0xb7, 0x22, 0x49, 0x27, 0x2a, 0x24, 0xf5, 0xca, 0xfe, 0x8a, 0xe5, 0xa3, 0x90, 0x37, 0x6c,
-	0x1e, 0x43, 0x44, 0xcb, 0xf0, 0xc1, 0x39, 0xee, 0x3d, 0x63, 0xb3, 0x33, 0x70, 0x56, 0xd3, 0xdc,
-	0xc5, 0xf5, 0x0b, 0x58, 0x16, 0x63, 0x6c, 0x65, 0x89, 0x99, 0xbb, 0x34, 0xff, 0x93, 0x6f, 0x28,
-	0x5c, 0x09, 0xf0, 0x45, 0xd9, 0x86, 0x54, 0x27, 0x77, 0x24, 0x9f, 0xec, 0x8d, 0xa2, 0x55, 0x19,
-	0x4a, 0x4b, 0x63, 0xf0, 0x19, 0x61, 0x4d, 0x05, 0x11, 0xb3, 0xbc, 0x93, 0xbc, 0x4d, 0xdd, 0xee,
-	0x64, 0x89, 0xa9, 0x80, 0xf2, 0x64, 0x76, 0xd5, 0xc9, 0xc8, 0x33, 0x52, 0x2c, 0xc0, 0x77, 0xd4,
-	0xed, 0x1c, 0xe0, 0x36, 0x2f, 0xc8, 0xca, 0xdd, 0xea, 0x8e, 0x77, 0xaa, 0x34, 0x2a, 0xdb, 0x6e,
-	0xaf, 0x67, 0x89, 0xb9, 0x0a, 0xa6, 0x2b, 0x8b, 0xf4, 0x71, 0x5b, 0x70, 0x36, 0x83, 0x57, 0x9e,
-	0x53, 0xec, 0x5a, 0x33, 0x4b, 0x4c, 0xb4, 0x4f, 0x5b, 0x12, 0x3e, 0x76, 0xec, 0xc9, 0xcd, 0x9d,
-	0x51, 0xbb, 0xbd, 0x33, 0x6a, 0x0f, 0x77, 0x06, 0x7a, 0x9f, 0x1a, 0xe8, 0x53, 0x6a, 0xa0, 0xeb,
-	0xd4, 0x40, 0x37, 0xa9, 0x81, 0x7e, 0xa6, 0x06, 0xfa, 0x95, 0x1a, 0xb5, 0x87, 0xd4, 0x40, 0x1f,
-	0xee, 0x8d, 0xda, 0xcd, 0xbd, 0x51, 0xbb, 0xbd, 0x37, 0x6a, 0x2f, 0x2b, 0xbf, 0x5d, 0x53, 0x4d,
-	0x5e, 0xed, 0x7f, 0xbf, 0x03, 0x00, 0x00, 0xff, 0xff, 0x86, 0xdf, 0xbc, 0x7a, 0xe2, 0x04, 0x00,
-	0x00,
+	// 840 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0x4f, 0x8f, 0xdb, 0x44,
+	0x14, 0xcf, 0x6c, 0x12, 0x27, 0x99, 0x2c, 0xe9, 0x32, 0xad, 0x5a, 0x6f, 0x91, 0xec, 0x90, 0x53,
+	0x40, 0xd4, 0x91, 0x82, 0x90, 0xb8, 0x20, 0xed, 0x9a, 0x56, 0xa2, 0x12, 0x42, 0xab, 0xd9, 0x95,
+	0x90, 0xb8, 0xac, 0x26, 0xf6, 0xc3, 0xeb, 0x36, 0xf1, 0xb8, 0xe3, 0x31, 0x34, 0x08, 0x24, 0x3e,
+	0x02, 0x47, 0x3e, 0x02, 0xe2, 0x8b, 0xd0, 0xe3, 0x5e, 0x90, 0x2a, 0x0e, 0x86, 0xcd, 0x5e, 0x90,
+	0x4f, 0xfd, 0x08, 0x68, 0x66, 0x6c, 0xc7, 0xe2, 0xd8, 0x8b, 0xfd, 0xde, 0x6f, 0xde, 0xdf, 0xdf,
+	0xbc, 0x37, 0xf8, 0xe8, 0x45, 0x0e, 0x62, 0x2b, 0x58, 0x12, 0x81, 0x97, 0x0a, 0x2e, 0x39, 0xc1,
+	0x7b, 0xe4, 0xe1, 0xa3, 0x28, 0x96, 0x57, 0xf9, 0xca, 0x0b, 0xf8, 0x66, 0x11, 0xf1, 0x88, 0x2f,
+	0xb4, 0xc9, 0x2a, 0xff, 0x56, 0x6b, 0x5a, 0xd1, 0x92, 0x71, 0x7d, 0xe8, 0x44, 0x9c, 0x47, 0x6b,
+	0xd8, 0x5b, 0x85, 0xb9, 0x60, 0x32, 0xe6, 0x49, 0x75, 0x7e, 0xd2, 0x0a, 0x17, 0x70, 0x21, 0xe1,
+	0x65, 0x2a, 0xf8, 0x33, 0x08, 0x64, 0xa5, 0x2d, 0xd2, 0xe7, 0xd1, 0x22, 0x4e, 0x22, 0xc8, 0x24,
+	0x88, 0x45, 0xb0, 0x8e, 0x21, 0xa9, 0x8f, 0x4c, 0x84, 0xd9, 0x35, 0xc2, 0x03, 0x0a, 0x2f, 0x72,
+	0xc8, 0x24, 0x21, 0xb8, 0x97, 0x32, 0x79, 0x65, 0xa3, 0x29, 0x9a, 0x8f, 0xa8, 0x96, 0xc9, 0x3d,
+	0xdc, 0xcf, 0x24, 0x13, 0xd2, 0x3e, 0x98, 0xa2, 0x79, 0x97, 0x1a, 0x85, 0x1c, 0xe1, 0x2e, 0x24,
+	0xa1, 0xdd, 0xd5, 0x98, 0x12, 0x95, 0x6f, 0x26, 0x21, 0xb5, 0x7b, 0x1a, 0xd2, 0x32, 0xf9, 0x0c,
+	0x0f, 0x64, 0xbc, 0x01, 0x9e, 0x4b, 0xbb, 0x3f, 0x45, 0xf3, 0xf1, 0xf2, 0xd8, 0x33, 0xfd, 0x78,
+	0x75, 0x3f, 0xde, 0xe3, 0xaa, 0x1f, 0x7f, 0xf8, 0xaa, 0x70, 0x3b, 0xbf, 0xfe, 0xed, 0x22, 0x5a,
+	0xfb, 0xa8, 0xd4, 0x9a, 0x39, 0xdb, 0xd2, 0xf5, 0x18, 0x85, 0x4c, 0xf1, 0x30, 0xe1, 0x97, 0x01,
+	0x0b, 0xae, 0xc0, 0x1e, 0x4c, 0xd1, 0x7c, 0xe8, 0xf7, 0xcb, 0xc2, 0x45, 0x8f, 0xe8, 0x20, 0xe1,
+	0x9f, 0x2b, 0x74, 0xf6, 0xfb, 0x01, 0x1e, 0x9f, 0x9e, 0x3d, 0xa5, 0x90, 0xa5, 0x3c, 0xc9, 0x80,
+	0xcc, 0xb0, 0x75, 0x2e, 0x99, 0xcc, 0x33, 0xd3, 0x98, 0x8f, 0xcb, 0xc2, 0xb5, 0x32, 0x8d, 0xd0,
+	0xea, 0x4f, 0x4e, 0x70, 0xef, 0x31, 0x93, 0x4c, 0x77, 0x39, 0x5e, 0xde, 0xf3, 0x5a, 0x97, 0x58,
+	0xc7, 0xf1, 0xef, 0xab, 0x12, 0xcb, 0xc2, 0x9d, 0x84, 0x4c, 0xb2, 0x8f, 0xf8, 0x26, 0x96, 0xb0,
+	0x49, 0xe5, 0x96, 0xf6, 0x94, 0x4e, 0x3e, 0xc1, 0xa3, 0x27, 0x42, 0x70, 0x71, 0xb1, 0x4d, 0x41,
+	0x13, 0x33, 0xf2, 0x1f, 0x94, 0x85, 0x7b, 0x17, 0x6a, 0xb0, 0xe5, 0x31, 0x6a, 0x40, 0xf2, 0x01,
+	0xee, 0x6b, 0x37, 0x4d, 0xdc, 0xc8, 0xbf, 0x5b, 0x16, 0xee, 0x1d, 0x7d, 0xda, 0x32, 0xef, 0x6b,
+	0x80, 0x2c, 0xf1, 0xf0, 0x6b, 0x26, 0x92, 0x38, 0x89, 0x32, 0xbb, 0x3f, 0xed, 0xce, 0x47, 0xfe,
+	0xfd, 0xb2, 0x70, 0xc9, 0xf7, 0x15, 0xd6, 0x72, 0x18, 0xd6, 0x18, 0x71, 0xf1, 0xe0, 0x2b, 0x7e,
+	0x2e, 0xb9, 0x00, 0xdb, 0xfa, 0x1f, 0x59, 0x1a, 0x9d, 0xfd, 0x88, 0x87, 0x0d, 0x51, 0x1e, 0xc6,
+	0x14, 0xb2, 0x7c, 0x2d, 0x75, 0x0f, 0x86, 0xac, 0x49, 0x59, 0xb8, 0x58, 0x34, 0x28, 0x6d, 0xc9,
+	0xe4, 0x04, 0x5b, 0xc6, 0xde, 0x3e, 0x98, 0x76, 0xe7, 0xe3, 0xa5, 0xdd, 0xa6, 0xed, 0x9c, 0x6d,
+	0xd2, 0x35, 0x9c, 0x4b, 0x01, 0x6c, 0xe3, 0x4f, 0x2a, 0xea, 0x2c, 0xe3, 0x4d, 0xab, 0xff, 0xec,
+	0x0f, 0x84, 0x0f, 0xdb, 0x86, 0xe4, 0x27, 0x6c, 0xad, 0xd9, 0x0a, 0xd6, 0xea, 0xae, 0x54, 0xc8,
+	0x77, 0xbd, 0x6a, 0x5a, 0xbf, 0x54, 0xe8, 0x19, 0x8b, 0x85, 0x4f, 0x55, 0xac, 0xbf, 0x0a, 0xf7,
+	0x6d, 0x66, 0xdf, 0x84, 0x39, 0x0d, 0x59, 0x2a, 0x41, 0xa8, 0x7a, 0x36, 0x20, 0x45, 0x1c, 0xd0,
+	0x2a, 0x29, 0xf9, 0x14, 0x0f, 0x32, 0x5d, 0x4e, 0x56, 0xb5, 0x34, 0xa9, 0xf3, 0x9b, 0x2a, 0xf7,
+	0x8d, 0x7c, 0xc7, 0xd6, 0x39, 0x64, 0xb4, 0x36, 0x9f, 0x3d, 0xc3, 0x13, 0x3d, 0x7d, 0x61, 0xc3,
+	0xe6, 0x31, 0xee, 0x3e, 0x87, 0x6d, 0x45, 0xe3, 0xa0, 0x2c, 0x5c, 0xa5, 0x52, 0xf5, 0x51, 0x8b,
+	0x01, 0x2f, 0x25, 0x24, 0xb2, 0x4e, 0x43, 0xda, 0xcc, 0x3d, 0xd1, 0x47, 0xfe, 0x9d, 0x2a, 0x55,
+	0x6d, 0x4a, 0x6b, 0x61, 0xf6, 0x27, 0xc2, 0x96, 0x31, 0x22, 0x6e, 0xbd, 0x9e, 0x2a, 0x4d, 0xd7,
+	0x1f, 0x95, 0x85, 0x6b, 0x80, 0x7a, 0x53, 0x8f, 0xcd, 0xa6, 0xea, 0xed, 0x35, 0x55, 0x40, 0x12,
+	0x9a, 0x95, 0x3d, 0xc5, 0x43, 0x51, 0x15, 0xab, 0x07, 0x76, 0xbc, 0x7c, 0xd0, 0x2e, 0xa3, 0xb5,
+	0x42, 0xfe, 0x61, 0x59, 0xb8, 0x8d, 0x31, 0x6d, 0x24, 0xb5, 0x8c, 0x52, 0xb0, 0x00, 0x2e, 0xe3,
+	0xb0, 0x1a, 0xe0, 0x7a, 0xbe, 0x34, 0xfc, 0x34, 0x24, 0x1f, 0xe2, 0x91, 0xde, 0xd5, 0xf0, 0x92,
+	0x99, 0x57, 0xa0, 0xeb, 0xbf, 0x53, 0x16, 0xee, 0x1e, 0xa4, 0x43, 0x23, 0x9e, 0xca, 0xd9, 0x0f,
+	0x98, 0x18, 0x0e, 0xbf, 0xb8, 0xb8, 0x38, 0x6b, 0x78, 0x3c, 0x6a, 0xf1, 0x68, 0xe8, 0x7b, 0xaf,
+	0x1d, 0xd3, 0xbc, 0x4b, 0x4d, 0x10, 0xf2, 0x3e, 0x3e, 0x0c, 0x78, 0xa2, 0xc8, 0xb9, 0x94, 0xcd,
+	0x2a, 0xd2, 0x71, 0x85, 0xe9, 0xb9, 0x25, 0xb8, 0xb7, 0xe2, 0xe1, 0x56, 0x57, 0x7c, 0x48, 0xb5,
+	0xec, 0x9f, 0x5c, 0xdf, 0x38, 0x9d, 0xd7, 0x37, 0x4e, 0xe7, 0xcd, 0x8d, 0x83, 0x7e, 0xde, 0x39,
+	0xe8, 0xb7, 0x9d, 0x83, 0x5e, 0xed, 0x1c, 0x74, 0xbd, 0x73, 0xd0, 0x3f, 0x3b, 0x07, 0xfd, 0xbb,
+	0x73, 0x3a, 0x6f, 0x76, 0x0e, 0xfa, 0xe5, 0xd6, 0xe9, 0x5c, 0xdf, 0x3a, 0x9d, 0xd7, 0xb7, 0x4e,
+	0xe7, 0x9b, 0xd6, 0xd3, 0xbe, 0xb2, 0xf4, 0xa3, 0xf6, 0xf1, 0x7f, 0x01, 0x00, 0x00, 0xff, 0xff,
+	0x76, 0x71, 0x15, 0x60, 0x01, 0x06, 0x00, 0x00,
 }
 
 func (this *Request) Equal(that interface{}) bool {
@@ -491,6 +607,9 @@ func (this *Request) Equal(that interface{}) bool {
 	if this.Query != that1.Query {
 		return false
 	}
+	if this.NoCache != that1.NoCache {
+		return false
+	}
 	return true
 }
 func (this *APIResponse) Equal(that interface{}) bool {
@@ -524,6 +643,17 @@ func (this *APIResponse) Equal(that interface{}) bool {
 	if this.Error != that1.Error {
 		return false
 	}
+	if len(this.Warnings) != len(that1.Warnings) {
+		return false
+	}
+	for i := range this.Warnings {
+		if this.Warnings[i] != that1.Warnings[i] {
+			return false
+		}
+	}
+	if this.NoStore != that1.NoStore {
+		return false
+	}
 	return true
 }
 func (this *Response) Equal(that interface{}) bool {
@@ -658,13 +788,49 @@ func (this *Extent) Equal(that interface{}) bool {
 	if this.TraceId != that1.TraceId {
 		return false
 	}
+	if this.CachedAt != that1.CachedAt {
+		return false
+	}
+	return true
+}
+func (this *CachedHTTPResponse) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*CachedHTTPResponse)
+	if !ok {
+		that2, ok := that.(CachedHTTPResponse)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if this.Key != that1.Key {
+		return false
+	}
+	if this.CachedAt != that1.CachedAt {
+		return false
+	}
+	if this.ContentType != that1.ContentType {
+		return false
+	}
+	if !bytes.Equal(this.Body, that1.Body) {
+		return false
+	}
 	return true
 }
 func (this *Request) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 10)
+	s := make([]string, 0, 11)
 	s = append(s, "&queryrange.Request{")
 	s = append(s, "Path: "+fmt.Sprintf("%#v", this.Path)+",\n")
 	s = append(s, "Start: "+fmt.Sprintf("%#v", this.Start)+",\n")
@@ -672,6 +838,7 @@ func (this *Request) GoString() string {
 	s = append(s, "Step: "+fmt.Sprintf("%#v", this.Step)+",\n")
 	s = append(s, "Timeout: "+fmt.Sprintf("%#v", this.Timeout)+",\n")
 	s = append(s, "Query: "+fmt.Sprintf("%#v", this.Query)+",\n")
+	s = append(s, "NoCache: "+fmt.Sprintf("%#v", this.NoCache)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -679,12 +846,14 @@ func (this *APIResponse) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 8)
+	s := make([]string, 0, 10)
 	s = append(s, "&queryrange.APIResponse{")
 	s = append(s, "Status: "+fmt.Sprintf("%#v", this.Status)+",\n")
 	s = append(s, "Data: "+strings.Replace(this.Data.GoString(), `&`, ``, 1)+",\n")
 	s = append(s, "ErrorType: "+fmt.Sprintf("%#v", this.ErrorType)+",\n")
 	s = append(s, "Error: "+fmt.Sprintf("%#v", this.Error)+",\n")
+	s = append(s, "Warnings: "+fmt.Sprintf("%#v", this.Warnings)+",\n")
+	s = append(s, "NoStore: "+fmt.Sprintf("%#v", this.NoStore)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -743,7 +912,7 @@ func (this *Extent) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 8)
+	s := make([]string, 0, 9)
 	s = append(s, "&queryrange.Extent{")
 	s = append(s, "Start: "+fmt.Sprintf("%#v", this.Start)+",\n")
 	s = append(s, "End: "+fmt.Sprintf("%#v", this.End)+",\n")
@@ -751,6 +920,20 @@ func (this *Extent) GoString() string {
 		s = append(s, "Response: "+fmt.Sprintf("%#v", this.Response)+",\n")
 	}
 	s = append(s, "TraceId: "+fmt.Sprintf("%#v", this.TraceId)+",\n")
+	s = append(s, "CachedAt: "+fmt.Sprintf("%#v", this.CachedAt)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *CachedHTTPResponse) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 8)
+	s = append(s, "&queryrange.CachedHTTPResponse{")
+	s = append(s, "Key: "+fmt.Sprintf("%#v", this.Key)+",\n")
+	s = append(s, "CachedAt: "+fmt.Sprintf("%#v", this.CachedAt)+",\n")
+	s = append(s, "ContentType: "+fmt.Sprintf("%#v", this.ContentType)+",\n")
+	s = append(s, "Body: "+fmt.Sprintf("%#v", this.Body)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -812,6 +995,16 @@ func (m *Request) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Query)))
 		i += copy(dAtA[i:], m.Query)
 	}
+	if m.NoCache {
+		dAtA[i] = 0x38
+		i++
+		if m.NoCache {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -856,6 +1049,31 @@ func (m *APIResponse) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Error)))
 		i += copy(dAtA[i:], m.Error)
 	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			dAtA[i] = 0x2a
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if m.NoStore {
+		dAtA[i] = 0x30
+		i++
+		if m.NoStore {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -1014,6 +1232,52 @@ func (m *Extent) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.TraceId)))
 		i += copy(dAtA[i:], m.TraceId)
 	}
+	if m.CachedAt != 0 {
+		dAtA[i] = 0x28
+		i++
+		i = encodeVarintQueryrange(dAtA, i, uint64(m.CachedAt))
+	}
+	return i, nil
+}
+
+func (m *CachedHTTPResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CachedHTTPResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Key) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Key)))
+		i += copy(dAtA[i:], m.Key)
+	}
+	if m.CachedAt != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintQueryrange(dAtA, i, uint64(m.CachedAt))
+	}
+	if len(m.ContentType) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.ContentType)))
+		i += copy(dAtA[i:], m.ContentType)
+	}
+	if len(m.Body) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintQueryrange(dAtA, i, uint64(len(m.Body)))
+		i += copy(dAtA[i:], m.Body)
+	}
 	return i, nil
 }
 
@@ -1051,6 +1315,9 @@ func (m *Request) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovQueryrange(uint64(l))
 	}
+	if m.NoCache {
+		n += 2
+	}
 	return n
 }
 
@@ -1074,6 +1341,15 @@ func (m *APIResponse) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovQueryrange(uint64(l))
 	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			l = len(s)
+			n += 1 + l + sovQueryrange(uint64(l))
+		}
+	}
+	if m.NoStore {
+		n += 2
+	}
 	return n
 }
 
@@ -1156,6 +1432,33 @@ func (m *Extent) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovQueryrange(uint64(l))
 	}
+	if m.CachedAt != 0 {
+		n += 1 + sovQueryrange(uint64(m.CachedAt))
+	}
+	return n
+}
+
+func (m *CachedHTTPResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Key)
+	if l > 0 {
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
+	if m.CachedAt != 0 {
+		n += 1 + sovQueryrange(uint64(m.CachedAt))
+	}
+	l = len(m.ContentType)
+	if l > 0 {
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
+	l = len(m.Body)
+	if l > 0 {
+		n += 1 + l + sovQueryrange(uint64(l))
+	}
 	return n
 }
 
@@ -1183,6 +1486,7 @@ func (this *Request) String() string {
 		`Step:` + fmt.Sprintf("%v", this.Step) + `,`,
 		`Timeout:` + strings.Replace(strings.Replace(this.Timeout.String(), "Duration", "duration.Duration", 1), `&`, ``, 1) + `,`,
 		`Query:` + fmt.Sprintf("%v", this.Query) + `,`,
+		`NoCache:` + fmt.Sprintf("%v", this.NoCache) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1196,6 +1500,8 @@ func (this *APIResponse) String() string {
 		`Data:` + strings.Replace(strings.Replace(this.Data.String(), "Response", "Response", 1), `&`, ``, 1) + `,`,
 		`ErrorType:` + fmt.Sprintf("%v", this.ErrorType) + `,`,
 		`Error:` + fmt.Sprintf("%v", this.Error) + `,`,
+		`Warnings:` + fmt.Sprintf("%v", this.Warnings) + `,`,
+		`NoStore:` + fmt.Sprintf("%v", this.NoStore) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1242,6 +1548,20 @@ func (this *Extent) String() string {
 		`End:` + fmt.Sprintf("%v", this.End) + `,`,
 		`Response:` + strings.Replace(fmt.Sprintf("%v", this.Response), "APIResponse", "APIResponse", 1) + `,`,
 		`TraceId:` + fmt.Sprintf("%v", this.TraceId) + `,`,
+		`CachedAt:` + fmt.Sprintf("%v", this.CachedAt) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *CachedHTTPResponse) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&CachedHTTPResponse{`,
+		`Key:` + fmt.Sprintf("%v", this.Key) + `,`,
+		`CachedAt:` + fmt.Sprintf("%v", this.CachedAt) + `,`,
+		`ContentType:` + fmt.Sprintf("%v", this.ContentType) + `,`,
+		`Body:` + fmt.Sprintf("%v", this.Body) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1437,6 +1757,26 @@ func (m *Request) Unmarshal(dAtA []byte) error {
 			}
 			m.Query = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NoCache", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.NoCache = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipQueryrange(dAtA[iNdEx:])
@@ -1619,6 +1959,58 @@ func (m *APIResponse) Unmarshal(dAtA []byte) error {
 			}
 			m.Error = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NoStore", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.NoStore = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipQueryrange(dAtA[iNdEx:])
@@ -2137,6 +2529,195 @@ func (m *Extent) Unmarshal(dAtA []byte) error {
 			}
 			m.TraceId = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CachedAt", wireType)
+			}
+			m.CachedAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.CachedAt |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipQueryrange(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *CachedHTTPResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowQueryrange
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: CachedHTTPResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: CachedHTTPResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Key = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CachedAt", wireType)
+			}
+			m.CachedAt = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.CachedAt |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ContentType", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ContentType = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Body", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowQueryrange
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthQueryrange
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Body = append(m.Body[:0], dAtA[iNdEx:postIndex]...)
+			if m.Body == nil {
+				m.Body = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipQueryrange(dAtA[iNdEx:])
@@ -0,0 +1,426 @@
+package queryrange
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// remoteReadVersionHeader is the header of the remote read requests setting
+// the compression of their body and response.
+const remoteReadVersionHeader = "X-Prometheus-Remote-Read-Version"
+
+type remoteRead struct {
+	logger   log.Logger
+	interval time.Duration
+	cfg      ResultsCacheConfig
+	cache    cache.Cache
+	limits   Limits
+	next     http.RoundTripper
+
+	genLoader CacheGenNumberLoader
+}
+
+// NewRemoteReadRoundTripper enforces the max query lookback and length of the
+// tenant on the queries of the remote read requests, and executes them in
+// parallel, each split into one query per interval if interval is positive.
+// If c isn't nil, the samples of the split queries older than
+// -frontend.max-cache-freshness are cached in it, for the results cache TTL
+// of the tenant if any, and under its cache generation number with a
+// genLoader.
+func NewRemoteReadRoundTripper(logger log.Logger, interval time.Duration, cfg ResultsCacheConfig, c cache.Cache, limits Limits, genLoader CacheGenNumberLoader, next http.RoundTripper) http.RoundTripper {
+	return remoteRead{
+		logger:    logger,
+		interval:  interval,
+		cfg:       cfg,
+		cache:     c,
+		limits:    limits,
+		next:      next,
+		genLoader: genLoader,
+	}
+}
+
+func isRemoteReadRequest(path string) bool {
+	return strings.HasSuffix(path, "/read")
+}
+
+func (s remoteRead) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodPost || !isRemoteReadRequest(r.URL.Path) {
+		return s.next.RoundTrip(r)
+	}
+
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	compression := util.CompressionTypeFor(r.Header.Get(remoteReadVersionHeader))
+	var req client.ReadRequest
+	if _, err := util.ParseProtoReader(r.Context(), r.Body, &req, compression); err != nil {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "%v", err)
+	}
+
+	var genPrefix string
+	if s.cache != nil {
+		if genPrefix, err = cacheGenNumberPrefix(r.Context(), s.genLoader, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		resp    = client.ReadResponse{Results: make([]*client.QueryResponse, len(req.Queries))}
+		splits  []*remoteReadSplit
+		byQuery = make([][]*remoteReadSplit, len(req.Queries))
+	)
+	for i, query := range req.Queries {
+		ok, err := limitReadQuery(s.limits, userID, query)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			resp.Results[i] = &client.QueryResponse{}
+			continue
+		}
+		byQuery[i] = s.splitReadQuery(genPrefix, userID, query)
+		splits = append(splits, byQuery[i]...)
+	}
+
+	if s.cache != nil && !hasCacheDirective(r.Header, noCacheDirective) && !util.IsStrongReadConsistency(r.Context()) {
+		s.fetch(r.Context(), userID, splits)
+	}
+
+	var pending []*remoteReadSplit
+	for _, split := range splits {
+		if split.resp == nil {
+			pending = append(pending, split)
+		}
+	}
+	if failed, err := s.doRequests(r, pending); err != nil || failed != nil {
+		return failed, err
+	}
+	if s.cache != nil {
+		s.store(r.Context(), pending)
+	}
+
+	for i, splits := range byQuery {
+		if splits != nil {
+			resp.Results[i] = mergeQueryResponses(splits)
+		}
+	}
+
+	body, err := util.SerializeProto(&resp, compression)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{
+		"Content-Type":     []string{"application/x-protobuf"},
+		"Content-Encoding": []string{"snappy"},
+	}
+	if compression == util.NoCompression {
+		header.Del("Content-Encoding")
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
+
+// limitReadQuery drops the part of a remote read query beyond the max query
+// lookback of the user, and returns false if no part is left. It then fails
+// if the query is longer than the max query length.
+func limitReadQuery(limits Limits, userID string, query *client.QueryRequest) (bool, error) {
+	if lookback := limits.MaxQueryLookback(userID); lookback > 0 {
+		minStart := timestamp.FromTime(time.Now().Add(-lookback))
+		if query.EndTimestampMs < minStart {
+			return false, nil
+		}
+		if query.StartTimestampMs < minStart {
+			query.StartTimestampMs = minStart
+		}
+	}
+
+	maxQueryLen := limits.MaxQueryLength(userID)
+	queryLen := timestamp.Time(query.EndTimestampMs).Sub(timestamp.Time(query.StartTimestampMs))
+	if maxQueryLen != 0 && queryLen > maxQueryLen {
+		return false, httpgrpc.Errorf(http.StatusBadRequest, validation.ErrQueryTooLong, queryLen, maxQueryLen)
+	}
+	return true, nil
+}
+
+// remoteReadSplit is a query of a remote read request over an interval, and
+// its response once fetched from the cache or executed.
+type remoteReadSplit struct {
+	query     *client.QueryRequest
+	key       string // empty if too recent to be cached
+	resp      *client.QueryResponse
+	cacheable bool // whether resp was executed and is to be cached
+}
+
+// splitReadQuery splits a query into one query per interval, aligned on the
+// intervals since the epoch; the start and end of the queries are inclusive.
+// The splits ending before the max cache freshness are cacheable, their keys
+// prefixed with genPrefix.
+func (s remoteRead) splitReadQuery(genPrefix, userID string, query *client.QueryRequest) []*remoteReadSplit {
+	interval := int64(s.interval / time.Millisecond)
+	if interval <= 0 {
+		return []*remoteReadSplit{{query: query}}
+	}
+
+	maxCacheTime := timestamp.FromTime(time.Now().Add(-s.cfg.MaxCacheFreshness))
+	matchers := matchersKey(query.Matchers)
+	var splits []*remoteReadSplit
+	for start := query.StartTimestampMs; start <= query.EndTimestampMs; start = (start/interval + 1) * interval {
+		end := (start/interval+1)*interval - 1
+		if end > query.EndTimestampMs {
+			end = query.EndTimestampMs
+		}
+
+		split := &remoteReadSplit{query: &client.QueryRequest{
+			StartTimestampMs: start,
+			EndTimestampMs:   end,
+			Matchers:         query.Matchers,
+		}}
+		if end < maxCacheTime {
+			split.key = genPrefix + fmt.Sprintf("remote_read:%s:%s:%d:%d", util.NormalizeOrgID(userID), matchers, start, end)
+		}
+		splits = append(splits, split)
+	}
+	return splits
+}
+
+// matchersKey returns the matchers of a query in a canonical form.
+func matchersKey(matchers []*client.LabelMatcher) string {
+	ms := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		ms = append(ms, fmt.Sprintf("%s %s %q", m.Name, m.Type, m.Value))
+	}
+	sort.Strings(ms)
+	return "{" + strings.Join(ms, ",") + "}"
+}
+
+// fetch sets the response of the cacheable splits found in the cache.
+func (s remoteRead) fetch(ctx context.Context, userID string, splits []*remoteReadSplit) {
+	var (
+		keys   []string
+		byHash = map[string]*remoteReadSplit{}
+	)
+	for _, split := range splits {
+		if split.key != "" {
+			hash := cache.HashKey(split.key)
+			keys = append(keys, hash)
+			byHash[hash] = split
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	ttl := s.limits.ResultsCacheTTL(userID)
+	found, bufs, _ := s.cache.Fetch(ctx, keys)
+	for i, hash := range found {
+		split := byHash[hash]
+
+		var cached CachedHTTPResponse
+		if err := proto.Unmarshal(bufs[i], &cached); err != nil {
+			level.Error(s.logger).Log("msg", "error unmarshalling cached value", "err", err)
+			continue
+		}
+		if cached.Key != split.key || (ttl > 0 && model.Time(cached.CachedAt).Add(ttl).Before(model.Now())) {
+			continue
+		}
+
+		var resp client.QueryResponse
+		if err := resp.Unmarshal(cached.Body); err != nil {
+			level.Error(s.logger).Log("msg", "error unmarshalling cached value", "err", err)
+			continue
+		}
+		split.resp = &resp
+	}
+}
+
+// store caches the responses of the cacheable splits.
+func (s remoteRead) store(ctx context.Context, splits []*remoteReadSplit) {
+	var (
+		keys []string
+		bufs [][]byte
+	)
+	for _, split := range splits {
+		if !split.cacheable {
+			continue
+		}
+
+		body, err := split.resp.Marshal()
+		if err != nil {
+			level.Error(s.logger).Log("msg", "error marshalling cached value", "err", err)
+			continue
+		}
+		buf, err := proto.Marshal(&CachedHTTPResponse{
+			Key:         split.key,
+			CachedAt:    int64(model.Now()),
+			ContentType: "application/x-protobuf",
+			Body:        body,
+		})
+		if err != nil {
+			level.Error(s.logger).Log("msg", "error marshalling cached value", "err", err)
+			continue
+		}
+		if s.cfg.MaxItemSize > 0 && len(buf) > s.cfg.MaxItemSize {
+			continue
+		}
+		keys = append(keys, cache.HashKey(split.key))
+		bufs = append(bufs, buf)
+	}
+	if len(keys) > 0 {
+		s.cache.Store(ctx, keys, bufs)
+	}
+}
+
+// doRequests executes the splits with the parallelism of the user, each as a
+// remote read request of a single query. It returns the response of the
+// first split failing, as is.
+func (s remoteRead) doRequests(r *http.Request, splits []*remoteReadSplit) (*http.Response, error) {
+	if len(splits) == 0 {
+		return nil, nil
+	}
+
+	userID, err := user.ExtractOrgID(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	parallelism := s.limits.MaxQueryParallelism(userID)
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	// If one of the splits fails, cancel the rest of them.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var (
+		failed = make([]*http.Response, len(splits))
+		errs   = make([]error, len(splits))
+		sem    = make(chan struct{}, parallelism)
+		done   = make(chan struct{})
+	)
+	for i := range splits {
+		go func(i int) {
+			sem <- struct{}{}
+			defer func() { <-sem; done <- struct{}{} }()
+			failed[i], errs[i] = s.doRequest(ctx, r, splits[i])
+			if failed[i] != nil || errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	for range splits {
+		<-done
+	}
+
+	var (
+		first *http.Response
+		found bool
+	)
+	for i := range splits {
+		if !found && (failed[i] != nil || errs[i] != nil) {
+			first, err, found = failed[i], errs[i], true
+		} else if failed[i] != nil {
+			_ = failed[i].Body.Close()
+		}
+	}
+	return first, err
+}
+
+// doRequest executes a split, returning the downstream response if it failed.
+func (s remoteRead) doRequest(ctx context.Context, r *http.Request, split *remoteReadSplit) (*http.Response, error) {
+	body, err := util.SerializeProto(&client.ReadRequest{Queries: []*client.QueryRequest{split.query}}, util.RawSnappy)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set(remoteReadVersionHeader, "0.1.0")
+	req.Header.Del("Content-Length")
+	req.RequestURI = r.RequestURI
+	req = req.WithContext(ctx)
+
+	resp, err := s.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var readResp client.ReadResponse
+	if _, err := util.ParseProtoReader(ctx, resp.Body, &readResp, util.RawSnappy); err != nil {
+		return nil, err
+	}
+	if len(readResp.Results) != 1 {
+		return nil, httpgrpc.Errorf(http.StatusInternalServerError, "expected 1 result of the remote read query, got %d", len(readResp.Results))
+	}
+	split.resp = readResp.Results[0]
+	split.cacheable = split.key != "" && !hasCacheDirective(resp.Header, noStoreDirective)
+	return nil, nil
+}
+
+// mergeQueryResponses merges the responses of the splits of a query, in
+// order, into the series sorted by labels, as returned by the queriers.
+func mergeQueryResponses(splits []*remoteReadSplit) *client.QueryResponse {
+	if len(splits) == 1 {
+		return splits[0].resp
+	}
+
+	var (
+		result = &client.QueryResponse{}
+		series = map[string]int{}
+	)
+	for _, split := range splits {
+		for _, ts := range split.resp.Timeseries {
+			key := client.FromLabelAdaptersToLabels(ts.Labels).String()
+			if i, ok := series[key]; ok {
+				result.Timeseries[i].Samples = append(result.Timeseries[i].Samples, ts.Samples...)
+				continue
+			}
+			series[key] = len(result.Timeseries)
+			result.Timeseries = append(result.Timeseries, client.TimeSeries{
+				Labels:  ts.Labels,
+				Samples: append([]client.Sample(nil), ts.Samples...),
+			})
+		}
+	}
+	sort.Slice(result.Timeseries, func(i, j int) bool {
+		return labels.Compare(client.FromLabelAdaptersToLabels(result.Timeseries[i].Labels), client.FromLabelAdaptersToLabels(result.Timeseries[j].Labels)) < 0
+	})
+	return result
+}
@@ -0,0 +1,163 @@
+package queryrange
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// remoteReadQuerier returns the samples at the start and end of each query,
+// for the series up{job="a"}, and up{job="b"} from hour 1.
+func remoteReadQuerier(t *testing.T, queries *[]client.QueryRequest) http.RoundTripper {
+	var mtx sync.Mutex
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var req client.ReadRequest
+		_, err := util.ParseProtoReader(r.Context(), r.Body, &req, util.CompressionTypeFor(r.Header.Get(remoteReadVersionHeader)))
+		require.NoError(t, err)
+		require.Len(t, req.Queries, 1)
+		q := req.Queries[0]
+
+		mtx.Lock()
+		*queries = append(*queries, *q)
+		mtx.Unlock()
+
+		result := &client.QueryResponse{}
+		for _, job := range []string{"b", "a"} {
+			if job == "b" && q.EndTimestampMs < hourMs {
+				continue
+			}
+			result.Timeseries = append(result.Timeseries, client.TimeSeries{
+				Labels: client.FromLabelsToLabelAdapters(labels.FromStrings("__name__", "up", "job", job)),
+				Samples: []client.Sample{
+					{TimestampMs: q.StartTimestampMs, Value: 1},
+					{TimestampMs: q.EndTimestampMs, Value: 2},
+				},
+			})
+		}
+		body, err := util.SerializeProto(&client.ReadResponse{Results: []*client.QueryResponse{result}}, util.RawSnappy)
+		require.NoError(t, err)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/x-protobuf"}},
+			Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		}, nil
+	})
+}
+
+const hourMs = int64(time.Hour / time.Millisecond)
+
+func doRemoteRead(t *testing.T, rt http.RoundTripper, header http.Header, queries ...*client.QueryRequest) (*client.ReadResponse, error) {
+	body, err := util.SerializeProto(&client.ReadRequest{Queries: queries}, util.FramedSnappy)
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/api/prom/read", bytes.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req = req.WithContext(user.InjectOrgID(req.Context(), "1"))
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var readResp client.ReadResponse
+	_, err = util.ParseProtoReader(req.Context(), resp.Body, &readResp, util.FramedSnappy)
+	require.NoError(t, err)
+	return &readResp, nil
+}
+
+func readQuery(t *testing.T, start, end int64) *client.QueryRequest {
+	query, err := client.ToQueryRequest(model.Time(start), model.Time(end), []*labels.Matcher{{Type: labels.MatchEqual, Name: "__name__", Value: "up"}})
+	require.NoError(t, err)
+	return query
+}
+
+func TestRemoteReadSplitAndCache(t *testing.T) {
+	var queries []client.QueryRequest
+	rt := NewRemoteReadRoundTripper(log.NewNopLogger(), time.Hour, ResultsCacheConfig{MaxCacheFreshness: time.Minute}, cache.NewMockCache(), fakeLimits{}, nil, remoteReadQuerier(t, &queries))
+
+	resp, err := doRemoteRead(t, rt, nil, readQuery(t, hourMs/2, 2*hourMs+5))
+	require.NoError(t, err)
+	require.Len(t, queries, 3)
+	require.Equal(t, &client.ReadResponse{Results: []*client.QueryResponse{{
+		Timeseries: []client.TimeSeries{
+			{
+				Labels: client.FromLabelsToLabelAdapters(labels.FromStrings("__name__", "up", "job", "a")),
+				Samples: []client.Sample{
+					{TimestampMs: hourMs / 2, Value: 1}, {TimestampMs: hourMs - 1, Value: 2},
+					{TimestampMs: hourMs, Value: 1}, {TimestampMs: 2*hourMs - 1, Value: 2},
+					{TimestampMs: 2 * hourMs, Value: 1}, {TimestampMs: 2*hourMs + 5, Value: 2},
+				},
+			},
+			{
+				Labels: client.FromLabelsToLabelAdapters(labels.FromStrings("__name__", "up", "job", "b")),
+				Samples: []client.Sample{
+					{TimestampMs: hourMs, Value: 1}, {TimestampMs: 2*hourMs - 1, Value: 2},
+					{TimestampMs: 2 * hourMs, Value: 1}, {TimestampMs: 2*hourMs + 5, Value: 2},
+				},
+			},
+		},
+	}}}, resp)
+
+	// The splits are served from the cache.
+	cached, err := doRemoteRead(t, rt, nil, readQuery(t, hourMs/2, 2*hourMs+5))
+	require.NoError(t, err)
+	require.Len(t, queries, 3)
+	require.Equal(t, resp, cached)
+
+	// Apart from the recent ones.
+	now := int64(model.Now())
+	_, err = doRemoteRead(t, rt, nil, readQuery(t, now-time.Minute.Nanoseconds()/1e6/2, now))
+	require.NoError(t, err)
+	_, err = doRemoteRead(t, rt, nil, readQuery(t, now-time.Minute.Nanoseconds()/1e6/2, now))
+	require.NoError(t, err)
+	require.Len(t, queries, 5)
+
+	// Nor are the queries bypassing the cache.
+	_, err = doRemoteRead(t, rt, http.Header{"Cache-Control": []string{"no-cache"}}, readQuery(t, 0, hourMs-1))
+	require.NoError(t, err)
+	require.Len(t, queries, 6)
+}
+
+func TestRemoteReadLimits(t *testing.T) {
+	var queries []client.QueryRequest
+	limits := lookbackLimits{maxQueryLength: 2 * time.Hour, maxQueryLookback: 3 * time.Hour}
+	rt := NewRemoteReadRoundTripper(log.NewNopLogger(), 0, ResultsCacheConfig{}, nil, limits, nil, remoteReadQuerier(t, &queries))
+
+	now := int64(model.Now())
+	resp, err := doRemoteRead(t, rt, nil,
+		readQuery(t, now-4*hourMs, now-2*hourMs),
+		readQuery(t, now-5*hourMs, now-4*hourMs),
+	)
+	require.NoError(t, err)
+	require.Len(t, resp.Results, 2)
+
+	// The first query starts at the max lookback, and the second is dropped.
+	require.Len(t, queries, 1)
+	require.InDelta(t, now-3*hourMs, queries[0].StartTimestampMs, float64(time.Minute/time.Millisecond))
+	require.Equal(t, now-2*hourMs, queries[0].EndTimestampMs)
+	require.Len(t, resp.Results[0].Timeseries, 2)
+	require.Empty(t, resp.Results[1].Timeseries)
+
+	_, err = doRemoteRead(t, rt, nil, readQuery(t, now-3*hourMs, now))
+	require.Error(t, err)
+	httpResp, ok := httpgrpc.HTTPResponseFromError(err)
+	require.True(t, ok)
+	require.Equal(t, int32(http.StatusBadRequest), httpResp.Code)
+	require.Len(t, queries, 1)
+}
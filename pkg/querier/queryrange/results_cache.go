@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -17,6 +18,7 @@ import (
 	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
 )
 
@@ -24,38 +26,66 @@ import (
 type ResultsCacheConfig struct {
 	CacheConfig       cache.Config  `yaml:"cache"`
 	MaxCacheFreshness time.Duration `yaml:"max_freshness"`
+	Compression       string        `yaml:"compression"`
+	MaxItemSize       int           `yaml:"max_item_size"`
 }
 
 // RegisterFlags registers flags.
 func (cfg *ResultsCacheConfig) RegisterFlags(f *flag.FlagSet) {
 	cfg.CacheConfig.RegisterFlagsWithPrefix("frontend.", "", f)
 	f.DurationVar(&cfg.MaxCacheFreshness, "frontend.max-cache-freshness", 1*time.Minute, "Most recent allowed cacheable result, to prevent caching very recent results that might still be in flux.")
+	f.StringVar(&cfg.Compression, "frontend.results-cache.compression", "", "Compression of the cached results: snappy, or empty to disable.")
+	f.IntVar(&cfg.MaxItemSize, "frontend.results-cache.max-item-size", 0, "Maximum size, in bytes before compression, of a cached item; larger results are not cached. 0 to disable.")
 }
 
-type resultsCache struct {
-	logger log.Logger
-	cfg    ResultsCacheConfig
-	next   Handler
-	cache  cache.Cache
-	limits Limits
-}
-
-// NewResultsCacheMiddleware creates results cache middleware from config.
-func NewResultsCacheMiddleware(logger log.Logger, cfg ResultsCacheConfig, limits Limits) (Middleware, error) {
+// NewResultsCache creates the cache of the results from config, compressing
+// the cached items if configured.
+func NewResultsCache(cfg ResultsCacheConfig) (cache.Cache, error) {
 	c, err := cache.New(cfg.CacheConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	switch cfg.Compression {
+	case "":
+		return c, nil
+	case "snappy":
+		return cache.NewSnappy(c), nil
+	default:
+		return nil, fmt.Errorf("unsupported results cache compression: %q", cfg.Compression)
+	}
+}
+
+// CacheGenNumberLoader returns the cache generation number of a tenant,
+// changed whenever its cached results become stale, e.g. once some of its
+// series are deleted.
+type CacheGenNumberLoader interface {
+	GetResultsCacheGenNumber(ctx context.Context, userID string) (string, error)
+}
+
+type resultsCache struct {
+	logger    log.Logger
+	cfg       ResultsCacheConfig
+	next      Handler
+	cache     cache.Cache
+	limits    Limits
+	genLoader CacheGenNumberLoader
+}
+
+// NewResultsCacheMiddleware creates results cache middleware from config,
+// caching the results in c. With a genLoader, the results are cached under
+// the cache generation number of their tenant.
+func NewResultsCacheMiddleware(logger log.Logger, cfg ResultsCacheConfig, c cache.Cache, limits Limits, genLoader CacheGenNumberLoader) Middleware {
 	return MiddlewareFunc(func(next Handler) Handler {
 		return &resultsCache{
-			logger: logger,
-			cfg:    cfg,
-			next:   next,
-			cache:  c,
-			limits: limits,
+			logger:    logger,
+			cfg:       cfg,
+			next:      next,
+			cache:     c,
+			limits:    limits,
+			genLoader: genLoader,
 		}
-	}), nil
+	})
 }
 
 func (s resultsCache) Do(ctx context.Context, r *Request) (*APIResponse, error) {
@@ -66,7 +96,7 @@ func (s resultsCache) Do(ctx context.Context, r *Request) (*APIResponse, error)
 
 	var (
 		day      = r.Start / millisecondPerDay
-		key      = fmt.Sprintf("%s:%s:%d:%d", userID, r.Query, r.Step, day)
+		key      = fmt.Sprintf("%s:%s:%d:%d", util.NormalizeOrgID(userID), r.Query, r.Step, day)
 		extents  []Extent
 		response *APIResponse
 	)
@@ -76,14 +106,31 @@ func (s resultsCache) Do(ctx context.Context, r *Request) (*APIResponse, error)
 		return s.next.Do(ctx, r)
 	}
 
-	cached, ok := s.get(ctx, key)
-	if ok {
+	genPrefix, err := cacheGenNumberPrefix(ctx, s.genLoader, userID)
+	if err != nil {
+		return nil, err
+	}
+	key = genPrefix + key
+
+	// The requests bypassing the cache, including the queries of strong read
+	// consistency, replace the cached results.
+	var cached []Extent
+	if !r.NoCache && !util.IsStrongReadConsistency(ctx) {
+		var ok bool
+		cached, ok = s.get(ctx, key)
+		if ok {
+			cached = dropExpiredExtents(cached, s.limits.ResultsCacheTTL(userID))
+		}
+	}
+	if len(cached) > 0 {
 		response, extents, err = s.handleHit(ctx, r, cached)
 	} else {
 		response, extents, err = s.handleMiss(ctx, r)
 	}
 
-	if err == nil && len(extents) > 0 {
+	// Responses with warnings, e.g. partial results, or which downstream asked
+	// not to store, are not cached.
+	if err == nil && len(extents) > 0 && len(response.Warnings) == 0 && !response.NoStore {
 		extents = s.filterRecentExtents(r, extents)
 		s.put(ctx, key, extents)
 	}
@@ -91,6 +138,32 @@ func (s resultsCache) Do(ctx context.Context, r *Request) (*APIResponse, error)
 	return response, err
 }
 
+// cacheGenNumberPrefix returns the prefix of the cache keys of a tenant, its
+// cache generation number if any, so that the entries cached before it
+// changed aren't used. The prefix of a federated query has the cache
+// generation numbers of all its tenants.
+func cacheGenNumberPrefix(ctx context.Context, genLoader CacheGenNumberLoader, userID string) (string, error) {
+	if genLoader == nil {
+		return "", nil
+	}
+
+	tenants := util.TenantIDs(userID)
+	gens := make([]string, 0, len(tenants))
+	found := false
+	for _, tenant := range tenants {
+		gen, err := genLoader.GetResultsCacheGenNumber(ctx, tenant)
+		if err != nil {
+			return "", err
+		}
+		gens = append(gens, gen)
+		found = found || gen != ""
+	}
+	if !found {
+		return "", nil
+	}
+	return strings.Join(gens, ",") + ":", nil
+}
+
 func (s resultsCache) handleMiss(ctx context.Context, r *Request) (*APIResponse, []Extent, error) {
 	response, err := s.next.Do(ctx, r)
 	if err != nil {
@@ -103,6 +176,7 @@ func (s resultsCache) handleMiss(ctx context.Context, r *Request) (*APIResponse,
 			End:      r.End,
 			Response: response,
 			TraceId:  jaegerTraceID(ctx),
+			CachedAt: int64(model.Now()),
 		},
 	}
 	return response, extents, nil
@@ -135,6 +209,7 @@ func (s resultsCache) handleHit(ctx context.Context, r *Request, extents []Exten
 			End:      reqResp.req.End,
 			Response: reqResp.resp,
 			TraceId:  jaegerTraceID(ctx),
+			CachedAt: int64(model.Now()),
 		})
 	}
 	sort.Slice(extents, func(i, j int) bool {
@@ -154,6 +229,9 @@ func (s resultsCache) handleHit(ctx context.Context, r *Request, extents []Exten
 
 		accumulator.TraceId = jaegerTraceID(ctx)
 		accumulator.End = extents[i].End
+		if extents[i].CachedAt < accumulator.CachedAt {
+			accumulator.CachedAt = extents[i].CachedAt
+		}
 		accumulator.Response, err = mergeAPIResponses([]*APIResponse{accumulator.Response, extents[i].Response})
 		if err != nil {
 			return nil, nil, err
@@ -213,6 +291,23 @@ func (s resultsCache) filterRecentExtents(req *Request, extents []Extent) []Exte
 	return extents
 }
 
+// dropExpiredExtents drops the extents cached for longer than the TTL, if any.
+// Merged extents expire with their oldest data.
+func dropExpiredExtents(extents []Extent, ttl time.Duration) []Extent {
+	if ttl <= 0 {
+		return extents
+	}
+
+	minCachedAt := int64(model.Now().Add(-ttl))
+	result := extents[:0]
+	for _, extent := range extents {
+		if extent.CachedAt >= minCachedAt {
+			result = append(result, extent)
+		}
+	}
+	return result
+}
+
 func (s resultsCache) get(ctx context.Context, key string) ([]Extent, bool) {
 	found, bufs, _ := s.cache.Fetch(ctx, []string{cache.HashKey(key)})
 	if len(found) != 1 {
@@ -247,6 +342,10 @@ func (s resultsCache) put(ctx context.Context, key string, extents []Extent) {
 		level.Error(s.logger).Log("msg", "error marshalling cached value", "err", err)
 		return
 	}
+	if s.cfg.MaxItemSize > 0 && len(buf) > s.cfg.MaxItemSize {
+		level.Debug(s.logger).Log("msg", "not caching results larger than the max item size", "size", len(buf), "max_item_size", s.cfg.MaxItemSize)
+		return
+	}
 
 	s.cache.Store(ctx, []string{cache.HashKey(key)}, [][]byte{buf})
 }
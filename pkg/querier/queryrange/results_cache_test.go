@@ -14,6 +14,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/chunk/cache"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
 )
 
@@ -195,22 +196,25 @@ func (fakeLimits) MaxQueryLength(string) time.Duration {
 	return 0 // Disable.
 }
 
+func (fakeLimits) MaxQueryLookback(string) time.Duration {
+	return 0 // Disable.
+}
+
 func (fakeLimits) MaxQueryParallelism(string) int {
 	return 14 // Flag default.
 }
 
+func (fakeLimits) ResultsCacheTTL(string) time.Duration {
+	return 0 // Disable.
+}
+
+func (fakeLimits) AutoStepMaxPoints(string) int {
+	return 0 // Disable.
+}
+
 func TestResultsCache(t *testing.T) {
 	calls := 0
-	rcm, err := NewResultsCacheMiddleware(
-		log.NewNopLogger(),
-		ResultsCacheConfig{
-			CacheConfig: cache.Config{
-				Cache: cache.NewMockCache(),
-			},
-		},
-		fakeLimits{},
-	)
-	require.NoError(t, err)
+	rcm := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{}, cache.NewMockCache(), fakeLimits{}, nil)
 
 	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
 		calls++
@@ -236,12 +240,172 @@ func TestResultsCache(t *testing.T) {
 	require.Equal(t, 2, calls)
 }
 
+type genLoaderMock map[string]string
+
+func (m genLoaderMock) GetResultsCacheGenNumber(_ context.Context, userID string) (string, error) {
+	return m[userID], nil
+}
+
+func TestResultsCacheGenNumber(t *testing.T) {
+	calls := 0
+	gens := genLoaderMock{}
+	rcm := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{}, cache.NewMockCache(), fakeLimits{}, gens)
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+		calls++
+		return parsedResponse, nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	for _, tc := range []struct {
+		gen   string
+		calls int
+	}{
+		{gen: "", calls: 1},
+		{gen: "", calls: 1},
+		// The results cached under another generation aren't used.
+		{gen: "1", calls: 2},
+		{gen: "1", calls: 2},
+		{gen: "2", calls: 3},
+	} {
+		gens["1"] = tc.gen
+		resp, err := rc.Do(ctx, parsedRequest)
+		require.NoError(t, err)
+		require.Equal(t, tc.calls, calls)
+		require.Equal(t, parsedResponse, resp)
+	}
+}
+
+func TestResultsCacheFederatedQueries(t *testing.T) {
+	calls := 0
+	gens := genLoaderMock{}
+	rcm := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{}, cache.NewMockCache(), fakeLimits{}, gens)
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+		calls++
+		return parsedResponse, nil
+	}))
+
+	for _, tc := range []struct {
+		orgID string
+		gen   string
+		calls int
+	}{
+		{orgID: "1|2", calls: 1},
+		// The same tenants share their cached results, whatever their order.
+		{orgID: "2|1", calls: 1},
+		{orgID: "2|1|1", calls: 1},
+		// The results are cached again once a tenant deletes data.
+		{orgID: "1|2", gen: "1", calls: 2},
+		{orgID: "2|1", gen: "1", calls: 2},
+	} {
+		gens["2"] = tc.gen
+		resp, err := rc.Do(user.InjectOrgID(context.Background(), tc.orgID), parsedRequest)
+		require.NoError(t, err)
+		require.Equal(t, tc.calls, calls)
+		require.Equal(t, parsedResponse, resp)
+	}
+}
+
+type federatedLimitsMock struct {
+	fakeLimits
+	maxQueryLength map[string]time.Duration
+}
+
+func (l federatedLimitsMock) MaxQueryLength(userID string) time.Duration {
+	return l.maxQueryLength[userID]
+}
+
+func TestFederatedLimits(t *testing.T) {
+	limits := NewFederatedLimits(federatedLimitsMock{
+		maxQueryLength: map[string]time.Duration{"a": time.Hour, "b": 2 * time.Hour},
+	})
+
+	// The strictest limit of the tenants applies, 0 disabling it.
+	require.Equal(t, time.Hour, limits.MaxQueryLength("a"))
+	require.Equal(t, time.Hour, limits.MaxQueryLength("a|b|c"))
+	require.Equal(t, 2*time.Hour, limits.MaxQueryLength("b|c"))
+	require.Equal(t, time.Duration(0), limits.MaxQueryLength("c"))
+	require.Equal(t, 14, limits.MaxQueryParallelism("a|b"))
+}
+
+func TestResultsCacheWarnings(t *testing.T) {
+	rcm := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{}, cache.NewMockCache(), fakeLimits{}, nil)
+
+	calls := 0
+	response := *parsedResponse
+	response.Warnings = []string{"partial results"}
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+		calls++
+		return &response, nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// Partial results are not cached, so every request results in a query.
+	for i := 1; i <= 2; i++ {
+		resp, err := rc.Do(ctx, parsedRequest)
+		require.NoError(t, err)
+		require.Equal(t, i, calls)
+		require.Equal(t, &response, resp)
+	}
+}
+
+func TestResultsCacheNoStore(t *testing.T) {
+	rcm := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{}, cache.NewMockCache(), fakeLimits{}, nil)
+
+	calls := 0
+	response := *parsedResponse
+	response.NoStore = true
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+		calls++
+		return &response, nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// The responses downstream asked not to store are not cached.
+	for i := 1; i <= 2; i++ {
+		resp, err := rc.Do(ctx, parsedRequest)
+		require.NoError(t, err)
+		require.Equal(t, i, calls)
+		require.Equal(t, &response, resp)
+	}
+}
+
+func TestResultsCacheNoCache(t *testing.T) {
+	rcm := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{}, cache.NewMockCache(), fakeLimits{}, nil)
+
+	calls := 0
+	rc := rcm.Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+		calls++
+		return parsedResponse, nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	_, err := rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	// The requests bypassing the cache are queried, and replace the cached
+	// results.
+	req := parsedRequest.copy()
+	req.NoCache = true
+	_, err = rc.Do(ctx, &req)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+
+	resp, err := rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+	require.Equal(t, parsedResponse, resp)
+
+	// So do the queries of strong read consistency.
+	_, err = rc.Do(util.WithReadConsistency(ctx, util.ReadConsistencyStrong), parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
+
 func TestResultsCacheRecent(t *testing.T) {
 	var cfg ResultsCacheConfig
 	flagext.DefaultValues(&cfg)
-	cfg.CacheConfig.Cache = cache.NewMockCache()
-	rcm, err := NewResultsCacheMiddleware(log.NewNopLogger(), cfg, fakeLimits{})
-	require.NoError(t, err)
+	rcm := NewResultsCacheMiddleware(log.NewNopLogger(), cfg, cache.NewMockCache(), fakeLimits{}, nil)
 
 	req := parsedRequest.copy()
 	req.End = int64(model.Now())
@@ -267,3 +431,72 @@ func TestResultsCacheRecent(t *testing.T) {
 	require.Equal(t, 2, calls)
 	require.Equal(t, parsedResponse, resp)
 }
+
+type ttlLimits struct {
+	fakeLimits
+	ttl time.Duration
+}
+
+func (l ttlLimits) ResultsCacheTTL(string) time.Duration {
+	return l.ttl
+}
+
+func TestResultsCacheTTL(t *testing.T) {
+	c := cache.NewMockCache()
+	calls := 0
+	next := HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+		calls++
+		return parsedResponse, nil
+	})
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	rc := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{}, c, ttlLimits{ttl: time.Hour}, nil).Wrap(next)
+	for i := 0; i < 2; i++ {
+		_, err := rc.Do(ctx, parsedRequest)
+		require.NoError(t, err)
+		require.Equal(t, 1, calls)
+	}
+
+	// The cached results expire once the TTL of the tenant is lowered.
+	rc = NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{}, c, ttlLimits{ttl: time.Nanosecond}, nil).Wrap(next)
+	time.Sleep(time.Millisecond)
+	resp, err := rc.Do(ctx, parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+	require.Equal(t, parsedResponse, resp)
+
+	// So do the queries of strong read consistency.
+	_, err = rc.Do(util.WithReadConsistency(ctx, util.ReadConsistencyStrong), parsedRequest)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
+
+func TestResultsCacheMaxItemSize(t *testing.T) {
+	calls := 0
+	rc := NewResultsCacheMiddleware(log.NewNopLogger(), ResultsCacheConfig{MaxItemSize: 10}, cache.NewMockCache(), fakeLimits{}, nil).Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+		calls++
+		return parsedResponse, nil
+	}))
+	ctx := user.InjectOrgID(context.Background(), "1")
+
+	// The results are larger than the max item size, so they aren't cached.
+	for i := 1; i <= 2; i++ {
+		_, err := rc.Do(ctx, parsedRequest)
+		require.NoError(t, err)
+		require.Equal(t, i, calls)
+	}
+}
+
+func TestNewResultsCacheCompression(t *testing.T) {
+	c, err := NewResultsCache(ResultsCacheConfig{CacheConfig: cache.Config{Cache: cache.NewMockCache()}, Compression: "snappy"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	c.Store(ctx, []string{"key"}, [][]byte{[]byte("value")})
+	found, bufs, _ := c.Fetch(ctx, []string{"key"})
+	require.Equal(t, []string{"key"}, found)
+	require.Equal(t, [][]byte{[]byte("value")}, bufs)
+
+	_, err = NewResultsCache(ResultsCacheConfig{Compression: "gzip"})
+	require.Error(t, err)
+}
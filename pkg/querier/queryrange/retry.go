@@ -7,7 +7,8 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/weaveworks/common/httpgrpc"
+
+	"github.com/cortexproject/cortex/pkg/querier/errstatus"
 )
 
 var retries = promauto.NewHistogram(prometheus.HistogramOpts{
@@ -21,16 +22,18 @@ type retry struct {
 	log        log.Logger
 	next       Handler
 	maxRetries int
+	budget     *Budget
 }
 
 // NewRetryMiddleware returns a middleware that retries requests if they
-// fail with 500 or a non-HTTP error.
-func NewRetryMiddleware(log log.Logger, maxRetries int) Middleware {
+// fail with 500 or a non-HTTP error, within the budget.
+func NewRetryMiddleware(log log.Logger, maxRetries int, budget *Budget) Middleware {
 	return MiddlewareFunc(func(next Handler) Handler {
 		return retry{
 			log:        log,
 			next:       next,
 			maxRetries: maxRetries,
+			budget:     budget,
 		}
 	})
 }
@@ -39,16 +42,24 @@ func (r retry) Do(ctx context.Context, req *Request) (*APIResponse, error) {
 	tries := 0
 	defer func() { retries.Observe(float64(tries)) }()
 
+	r.budget.request()
+
 	var lastErr error
 	for ; tries < r.maxRetries; tries++ {
+		if tries > 0 && !r.budget.withdraw() {
+			overBudget.WithLabelValues("retry").Inc()
+			level.Warn(r.log).Log("msg", "not retrying request over the retry budget", "try", tries)
+			break
+		}
+
 		resp, err := r.next.Do(ctx, req)
 		if err == nil {
 			return resp, nil
 		}
 
-		// Retry if we get a HTTP 500 or a non-HTTP error.
-		httpResp, ok := httpgrpc.HTTPResponseFromError(err)
-		if !ok || httpResp.Code/100 == 5 {
+		// Retry if we get a HTTP 500 or a non-HTTP error, unless the request
+		// was canceled.
+		if errstatus.Code(err)/100 == 5 {
 			lastErr = err
 			level.Error(r.log).Log("msg", "error processing request", "try", tries, "err", err)
 			continue
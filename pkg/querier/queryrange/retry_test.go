@@ -58,10 +58,23 @@ func TestRetry(t *testing.T) {
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			try = 0
-			h := NewRetryMiddleware(log.NewNopLogger(), 5).Wrap(tc.handler)
+			h := NewRetryMiddleware(log.NewNopLogger(), 5, nil).Wrap(tc.handler)
 			resp, err := h.Do(nil, nil)
 			require.Equal(t, tc.err, err)
 			require.Equal(t, tc.resp, resp)
 		})
 	}
 }
+
+func TestRetryBudget(t *testing.T) {
+	var try int32
+	h := NewRetryMiddleware(log.NewNopLogger(), 5, &Budget{ratio: 0.1}).Wrap(HandlerFunc(func(_ context.Context, req *Request) (*APIResponse, error) {
+		atomic.AddInt32(&try, 1)
+		return nil, fmt.Errorf("fail")
+	}))
+
+	// The budget doesn't allow any retry.
+	_, err := h.Do(nil, nil)
+	require.Error(t, err)
+	require.Equal(t, int32(1), try)
+}
@@ -21,10 +21,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/user"
 
+	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
@@ -32,7 +34,41 @@ import (
 // the query handling code.
 type Limits interface {
 	MaxQueryLength(string) time.Duration
+	MaxQueryLookback(string) time.Duration
 	MaxQueryParallelism(string) int
+	ResultsCacheTTL(string) time.Duration
+	AutoStepMaxPoints(string) int
+}
+
+// NewFederatedLimits returns the limits of the queries of an org ID: the
+// federated queries, of several tenants, get the strictest limits of their
+// tenants.
+func NewFederatedLimits(limits Limits) Limits {
+	return federatedLimits{limits}
+}
+
+type federatedLimits struct {
+	Limits
+}
+
+func (l federatedLimits) MaxQueryLength(orgID string) time.Duration {
+	return util.SmallestPositiveDurationPerTenant(orgID, l.Limits.MaxQueryLength)
+}
+
+func (l federatedLimits) MaxQueryLookback(orgID string) time.Duration {
+	return util.SmallestPositiveDurationPerTenant(orgID, l.Limits.MaxQueryLookback)
+}
+
+func (l federatedLimits) MaxQueryParallelism(orgID string) int {
+	return util.SmallestPositiveIntPerTenant(orgID, l.Limits.MaxQueryParallelism)
+}
+
+func (l federatedLimits) ResultsCacheTTL(orgID string) time.Duration {
+	return util.SmallestPositiveDurationPerTenant(orgID, l.Limits.ResultsCacheTTL)
+}
+
+func (l federatedLimits) AutoStepMaxPoints(orgID string) int {
+	return util.SmallestPositiveIntPerTenant(orgID, l.Limits.AutoStepMaxPoints)
 }
 
 // HandlerFunc is like http.HandlerFunc, but for Handler.
@@ -93,20 +129,22 @@ func (q roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 		return q.next.RoundTrip(r)
 	}
 
-	request, err := parseRequest(r)
+	userid, err := user.ExtractOrgID(r.Context())
 	if err != nil {
 		return nil, err
 	}
 
-	userid, err := user.ExtractOrgID(r.Context())
+	request, err := parseRequest(r, q.limits.AutoStepMaxPoints(userid))
 	if err != nil {
 		return nil, err
 	}
 
-	maxQueryLen := q.limits.MaxQueryLength(userid)
-	queryLen := timestamp.Time(request.End).Sub(timestamp.Time(request.Start))
-	if maxQueryLen != 0 && queryLen > maxQueryLen {
-		return nil, httpgrpc.Errorf(http.StatusBadRequest, validation.ErrQueryTooLong, queryLen, maxQueryLen)
+	ok, err := limitRequest(q.limits, userid, request)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return (&APIResponse{Status: statusSuccess, Data: Response{ResultType: model.ValMatrix.String()}}).toHTTPResponse(r.Context())
 	}
 
 	response, err := q.handler.Do(r.Context(), request)
@@ -117,6 +155,29 @@ func (q roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 	return response.toHTTPResponse(r.Context())
 }
 
+// limitRequest drops the part of the request beyond the max query lookback of
+// the user, keeping the start aligned with the steps of the query, and returns
+// false if no part is left. It then fails if the request is longer than the max
+// query length.
+func limitRequest(limits Limits, userID string, request *Request) (bool, error) {
+	if lookback := limits.MaxQueryLookback(userID); lookback > 0 {
+		minStart := timestamp.FromTime(time.Now().Add(-lookback))
+		if request.End < minStart {
+			return false, nil
+		}
+		if request.Start < minStart && request.Step > 0 {
+			request.Start += (minStart - request.Start + request.Step - 1) / request.Step * request.Step
+		}
+	}
+
+	maxQueryLen := limits.MaxQueryLength(userID)
+	queryLen := timestamp.Time(request.End).Sub(timestamp.Time(request.Start))
+	if maxQueryLen != 0 && queryLen > maxQueryLen {
+		return false, httpgrpc.Errorf(http.StatusBadRequest, validation.ErrQueryTooLong, queryLen, maxQueryLen)
+	}
+	return true, nil
+}
+
 // ToRoundTripperMiddleware not quite sure what this does.
 type ToRoundTripperMiddleware struct {
 	Next http.RoundTripper
@@ -141,3 +202,46 @@ func (q ToRoundTripperMiddleware) Do(ctx context.Context, r *Request) (*APIRespo
 
 	return parseResponse(ctx, response)
 }
+
+// TypedRoundTripper round trips the HTTP form of a range query along with the
+// parsed query, and returns the HTTP response, along with the parsed response
+// if the downstream returned it.
+type TypedRoundTripper interface {
+	RoundTripTyped(*http.Request, *Request) (*http.Response, *APIResponse, error)
+}
+
+// ToTypedRoundTripperMiddleware is ToRoundTripperMiddleware for the queriers
+// of the typed query API, which return the parsed responses: the responses
+// are only decoded from JSON if the downstream didn't return them parsed.
+type ToTypedRoundTripperMiddleware struct {
+	Next TypedRoundTripper
+}
+
+// Do implements Handler.
+func (q ToTypedRoundTripperMiddleware) Do(ctx context.Context, r *Request) (*APIResponse, error) {
+	request, err := r.toHTTPRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := user.InjectOrgIDIntoHTTPRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	response, apiResponse, err := q.Next.RoundTripTyped(request, r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if apiResponse != nil && response.StatusCode/100 == 2 {
+		apiResponse.NoStore = hasCacheDirective(response.Header, noStoreDirective)
+		// Protobuf doesn't tell an empty result from none; return [] as the
+		// Prometheus API does.
+		if apiResponse.Data.Result == nil {
+			apiResponse.Data.Result = []SampleStream{}
+		}
+		return apiResponse, nil
+	}
+	return parseResponse(ctx, response)
+}
@@ -7,9 +7,12 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/user"
 )
@@ -82,3 +85,146 @@ func (s singleHostRoundTripper) RoundTrip(r *http.Request) (*http.Response, erro
 	r.URL.Host = s.host
 	return s.next.RoundTrip(r)
 }
+
+type lookbackLimits struct {
+	fakeLimits
+	maxQueryLength, maxQueryLookback time.Duration
+}
+
+func (l lookbackLimits) MaxQueryLength(string) time.Duration {
+	return l.maxQueryLength
+}
+
+func (l lookbackLimits) MaxQueryLookback(string) time.Duration {
+	return l.maxQueryLookback
+}
+
+func TestLimitRequest(t *testing.T) {
+	now := time.Now().Unix() * 1000
+	const hour = int64(time.Hour / time.Millisecond)
+
+	for _, tc := range []struct {
+		name          string
+		start, end    int64
+		limits        lookbackLimits
+		expectedOK    bool
+		expectedStart int64
+		expectedCode  int32
+	}{
+		{
+			name:          "within lookback",
+			start:         now - hour,
+			end:           now,
+			limits:        lookbackLimits{maxQueryLookback: 2 * time.Hour},
+			expectedOK:    true,
+			expectedStart: now - hour,
+		},
+		{
+			// The start is moved by a whole number of steps.
+			name:          "start beyond lookback",
+			start:         now - 3*hour,
+			end:           now,
+			limits:        lookbackLimits{maxQueryLookback: 2*time.Hour + 30*time.Second},
+			expectedOK:    true,
+			expectedStart: now - 2*hour,
+		},
+		{
+			name:   "whole query beyond lookback",
+			start:  now - 4*hour,
+			end:    now - 3*hour,
+			limits: lookbackLimits{maxQueryLookback: 2 * time.Hour},
+		},
+		{
+			// The length is checked once the start is truncated.
+			name:          "too long before truncation",
+			start:         now - 3*hour,
+			end:           now,
+			limits:        lookbackLimits{maxQueryLength: 2 * time.Hour, maxQueryLookback: 2*time.Hour + 30*time.Second},
+			expectedOK:    true,
+			expectedStart: now - 2*hour,
+		},
+		{
+			name:         "too long",
+			start:        now - 3*hour,
+			end:          now,
+			limits:       lookbackLimits{maxQueryLength: 2 * time.Hour},
+			expectedCode: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &Request{Path: "/api/v1/query_range", Start: tc.start, End: tc.end, Step: 60 * 1000, Query: "up"}
+			ok, err := limitRequest(tc.limits, "1", req)
+			if tc.expectedCode != 0 {
+				resp, isHTTP := httpgrpc.HTTPResponseFromError(err)
+				require.True(t, isHTTP)
+				require.Equal(t, tc.expectedCode, resp.Code)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOK, ok)
+			if ok {
+				require.Equal(t, tc.expectedStart, req.Start)
+			}
+		})
+	}
+}
+
+type typedRoundTripFunc func(*http.Request, *Request) (*http.Response, *APIResponse, error)
+
+func (f typedRoundTripFunc) RoundTripTyped(r *http.Request, req *Request) (*http.Response, *APIResponse, error) {
+	return f(r, req)
+}
+
+func TestToTypedRoundTripperMiddleware(t *testing.T) {
+	typed := &APIResponse{Status: statusSuccess, Data: Response{ResultType: "matrix"}}
+	for _, tc := range []struct {
+		name        string
+		code        int
+		apiResponse *APIResponse
+		expected    *APIResponse
+		err         bool
+	}{
+		{
+			name:        "typed",
+			code:        http.StatusOK,
+			apiResponse: typed,
+			expected:    &APIResponse{Status: statusSuccess, Data: Response{ResultType: "matrix", Result: []SampleStream{}}},
+		},
+		{
+			name:     "json",
+			code:     http.StatusOK,
+			expected: &APIResponse{Status: statusSuccess, Data: Response{ResultType: "matrix", Result: []SampleStream{}}},
+		},
+		{
+			name:        "error",
+			code:        http.StatusUnprocessableEntity,
+			apiResponse: typed,
+			err:         true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var received *Request
+			handler := ToTypedRoundTripperMiddleware{Next: typedRoundTripFunc(func(r *http.Request, req *Request) (*http.Response, *APIResponse, error) {
+				received = req
+				body := `{"status":"success","data":{"resultType":"matrix","result":[]}}`
+				if tc.code != http.StatusOK {
+					body = `{"status":"error","errorType":"execution","error":"too many samples"}`
+				}
+				return &http.Response{
+					StatusCode: tc.code,
+					Body:       ioutil.NopCloser(strings.NewReader(body)),
+				}, tc.apiResponse, nil
+			})}
+
+			req := &Request{Path: "/api/v1/query_range", Start: 0, End: 60000, Step: 30000, Query: "foo"}
+			resp, err := handler.Do(user.InjectOrgID(context.Background(), "1"), req)
+			require.Equal(t, req, received)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, resp)
+		})
+	}
+}
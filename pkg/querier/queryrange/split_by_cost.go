@@ -0,0 +1,220 @@
+package queryrange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+// splitIntervals are the intervals the queries can be split by. They divide a
+// day, so that the splits line up with the days of the results cache.
+var splitIntervals = []time.Duration{
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 4 * time.Hour, 6 * time.Hour, 8 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+var splitByCostIntervals = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "cortex",
+	Name:      "frontend_split_queries_interval_seconds",
+	Help:      "Interval the queries split by cost are split by; 0 for the queries not split.",
+	Buckets:   append([]float64{0}, durationsSeconds(splitIntervals)...),
+})
+
+func durationsSeconds(ds []time.Duration) []float64 {
+	secs := make([]float64, 0, len(ds))
+	for _, d := range ds {
+		secs = append(secs, d.Seconds())
+	}
+	return secs
+}
+
+// SeriesEstimator estimates the number of series selected by a query.
+type SeriesEstimator interface {
+	EstimateSeries(context.Context, *Request) (int, error)
+}
+
+// SplitByCostMiddleware creates a new Middleware that splits the requests by
+// the largest interval whose estimated cost, the number of series selected
+// times the hours of the interval, is at most targetCost; the requests within
+// it aren't split. The requests whose series can't be estimated are split by
+// day. The slow split queries are hedged if hedging isn't nil.
+func SplitByCostMiddleware(logger log.Logger, targetCost int, estimator SeriesEstimator, limits Limits, hedging *Hedging) Middleware {
+	return MiddlewareFunc(func(next Handler) Handler {
+		return splitByCost{
+			logger:     logger,
+			targetCost: targetCost,
+			estimator:  estimator,
+			next:       next,
+			limits:     limits,
+			hedging:    hedging,
+		}
+	})
+}
+
+type splitByCost struct {
+	logger     log.Logger
+	targetCost int
+	estimator  SeriesEstimator
+	next       Handler
+	limits     Limits
+	hedging    *Hedging
+}
+
+func (s splitByCost) Do(ctx context.Context, r *Request) (*APIResponse, error) {
+	interval := millisecondPerDay
+	if series, err := s.estimator.EstimateSeries(ctx, r); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to estimate the series of the query, splitting it by day", "query", r.Query, "err", err)
+	} else {
+		interval = splitInterval(series, r, s.targetCost)
+	}
+	splitByCostIntervals.Observe(float64(interval) / 1e3)
+
+	if interval == 0 {
+		return s.next.Do(ctx, r)
+	}
+	reqs := splitQueryByInterval(r, interval)
+	if len(reqs) == 1 {
+		return s.next.Do(ctx, reqs[0])
+	}
+
+	reqResps, err := doRequests(ctx, s.hedging.wrap(s.next, len(reqs)), reqs, s.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	resps := make([]*APIResponse, 0, len(reqResps))
+	for _, reqResp := range reqResps {
+		resps = append(resps, reqResp.resp)
+	}
+	return mergeAPIResponses(resps)
+}
+
+// splitInterval returns the interval in milliseconds to split a request
+// selecting series by, to keep the cost of the splits under targetCost if
+// possible; 0 if it's not to be split. The interval is at least the step of
+// the request, so that each split is of several steps.
+func splitInterval(series int, r *Request, targetCost int) int64 {
+	hours := float64(r.End-r.Start) / float64(time.Hour/time.Millisecond)
+	if float64(series)*hours <= float64(targetCost) {
+		return 0
+	}
+
+	var interval time.Duration
+	for _, candidate := range splitIntervals {
+		if int64(candidate/time.Millisecond) <= r.Step {
+			continue
+		}
+		if interval != 0 && float64(series)*candidate.Hours() > float64(targetCost) {
+			break
+		}
+		interval = candidate
+	}
+	if interval == 0 {
+		return 0
+	}
+	return int64(interval / time.Millisecond)
+}
+
+type cardinalityEstimator struct {
+	next http.RoundTripper
+}
+
+// NewCardinalitySeriesEstimator estimates the series of the queries from the
+// cardinality API of the queriers, as the number of series in the memory of
+// the ingesters matching the selectors of the queries, whatever their range:
+// the index of the chunk store can't be enumerated, and the series of the
+// ingesters stand for the series of the recent days.
+func NewCardinalitySeriesEstimator(next http.RoundTripper) SeriesEstimator {
+	return cardinalityEstimator{next: next}
+}
+
+func (e cardinalityEstimator) EstimateSeries(ctx context.Context, r *Request) (int, error) {
+	expr, err := promql.ParseExpr(r.Query)
+	if err != nil {
+		return 0, err
+	}
+
+	var selectors [][]*labels.Matcher
+	promql.Inspect(expr, func(node promql.Node, _ []promql.Node) error {
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			selectors = append(selectors, n.LabelMatchers)
+		case *promql.MatrixSelector:
+			selectors = append(selectors, n.LabelMatchers)
+		}
+		return nil
+	})
+
+	series := 0
+	for _, matchers := range selectors {
+		n, err := e.seriesCount(ctx, r.Path, matchers)
+		if err != nil {
+			return 0, err
+		}
+		series += n
+	}
+	return series, nil
+}
+
+type labelValuesCardinalityResponse struct {
+	Labels []struct {
+		SeriesCount int `json:"series_count"`
+	} `json:"labels"`
+}
+
+// seriesCount returns the number of series matching a selector, from the
+// cardinality of their metric names.
+func (e cardinalityEstimator) seriesCount(ctx context.Context, path string, matchers []*labels.Matcher) (int, error) {
+	ms := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		ms = append(ms, m.String())
+	}
+	params := url.Values{
+		"label_names[]": []string{labels.MetricName},
+		"selector":      []string{"{" + strings.Join(ms, ",") + "}"},
+		"limit":         []string{"1"},
+	}
+	u := &url.URL{
+		Path:     strings.TrimSuffix(path, "/query_range") + "/cardinality/label_values",
+		RawQuery: params.Encode(),
+	}
+	req := (&http.Request{
+		Method:     "GET",
+		RequestURI: u.String(), // This is what the httpgrpc code looks at.
+		URL:        u,
+		Body:       http.NoBody,
+		Header:     http.Header{},
+	}).WithContext(ctx)
+	if err := user.InjectOrgIDIntoHTTPRequest(ctx, req); err != nil {
+		return 0, err
+	}
+
+	resp, err := e.next.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return 0, httpgrpc.Errorf(resp.StatusCode, "cardinality request failed: %s", resp.Status)
+	}
+
+	var cardinality labelValuesCardinalityResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cardinality); err != nil {
+		return 0, fmt.Errorf("error decoding cardinality response: %v", err)
+	}
+	if len(cardinality.Labels) != 1 {
+		return 0, fmt.Errorf("expected the cardinality of 1 label, got %d", len(cardinality.Labels))
+	}
+	return cardinality.Labels[0].SeriesCount, nil
+}
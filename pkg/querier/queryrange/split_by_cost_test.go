@@ -0,0 +1,118 @@
+package queryrange
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+)
+
+func TestSplitInterval(t *testing.T) {
+	const hour = 3600 * seconds
+	for i, tc := range []struct {
+		series     int
+		start, end int64
+		step       int64
+		expected   int64
+	}{
+		// Within the target cost: not split.
+		{series: 100, end: 7 * 24 * hour, step: 60 * seconds, expected: 0},
+		{series: 0, end: 7 * 24 * hour, step: 60 * seconds, expected: 0},
+		// The largest interval within the target cost.
+		{series: 1000, end: 7 * 24 * hour, step: 60 * seconds, expected: 24 * hour},
+		{series: 10000, end: 7 * 24 * hour, step: 60 * seconds, expected: 8 * hour},
+		{series: 30000, end: 7 * 24 * hour, step: 60 * seconds, expected: 3 * hour},
+		// At least an hour.
+		{series: 1000000, end: 7 * 24 * hour, step: 60 * seconds, expected: hour},
+		// Longer than the step.
+		{series: 1000000, end: 7 * 24 * hour, step: 2 * hour, expected: 3 * hour},
+		{series: 1000000, end: 7 * 24 * hour, step: 24 * hour, expected: 0},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			r := &Request{Start: tc.start, End: tc.end, Step: tc.step}
+			require.Equal(t, tc.expected, splitInterval(tc.series, r, 100000))
+		})
+	}
+}
+
+type fakeEstimator struct {
+	series int
+	err    error
+}
+
+func (e fakeEstimator) EstimateSeries(context.Context, *Request) (int, error) {
+	return e.series, e.err
+}
+
+func TestSplitByCost(t *testing.T) {
+	const hour = 3600 * seconds
+	for _, tc := range []struct {
+		name      string
+		estimator fakeEstimator
+		expected  int
+	}{
+		{name: "cheap", estimator: fakeEstimator{series: 10}, expected: 1},
+		{name: "expensive", estimator: fakeEstimator{series: 10000}, expected: 6},
+		{name: "estimate failed", estimator: fakeEstimator{err: errors.New("failed")}, expected: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				mtx  sync.Mutex
+				reqs []*Request
+			)
+			next := HandlerFunc(func(_ context.Context, r *Request) (*APIResponse, error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				reqs = append(reqs, r)
+				return &APIResponse{Status: statusSuccess, Data: Response{ResultType: matrix, Result: []SampleStream{}}}, nil
+			})
+
+			handler := SplitByCostMiddleware(log.NewNopLogger(), 100000, tc.estimator, fakeLimits{}, nil).Wrap(next)
+			ctx := user.InjectOrgID(context.Background(), "1")
+			resp, err := handler.Do(ctx, &Request{Path: "/api/v1/query_range", Start: 0, End: 48 * hour, Step: 60 * seconds, Query: "foo"})
+			require.NoError(t, err)
+			require.Equal(t, statusSuccess, resp.Status)
+			require.Len(t, reqs, tc.expected)
+		})
+	}
+}
+
+func TestCardinalitySeriesEstimator(t *testing.T) {
+	var selectors []string
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		require.Equal(t, "/api/prom/api/v1/cardinality/label_values", r.URL.Path)
+		require.Equal(t, "__name__", r.URL.Query().Get("label_names[]"))
+		orgID, err := user.ExtractOrgID(r.Context())
+		require.NoError(t, err)
+		require.Equal(t, "1", orgID)
+
+		selector := r.URL.Query().Get("selector")
+		selectors = append(selectors, selector)
+		count := "10"
+		if strings.Contains(selector, "bar") {
+			count = "5"
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"labels":[{"label_name":"__name__","label_values_count":1,"series_count":` + count + `}]}`)),
+		}, nil
+	})
+
+	estimator := NewCardinalitySeriesEstimator(next)
+	ctx := user.InjectOrgID(context.Background(), "1")
+	series, err := estimator.EstimateSeries(ctx, &Request{Path: "/api/prom/api/v1/query_range", Query: `sum(rate(foo{job="a"}[5m])) / bar`})
+	require.NoError(t, err)
+	require.Equal(t, 15, series)
+	require.Equal(t, []string{`{job="a",__name__="foo"}`, `{__name__="bar"}`}, selectors)
+
+	_, err = estimator.EstimateSeries(ctx, &Request{Path: "/api/prom/api/v1/query_range", Query: `sum(`})
+	require.Error(t, err)
+}
@@ -9,19 +9,22 @@ import (
 
 const millisecondPerDay = int64(24 * time.Hour / time.Millisecond)
 
-// SplitByDayMiddleware creates a new Middleware that splits requests by day.
-func SplitByDayMiddleware(limits Limits) Middleware {
+// SplitByDayMiddleware creates a new Middleware that splits requests by day,
+// hedging the slow split queries if hedging isn't nil.
+func SplitByDayMiddleware(limits Limits, hedging *Hedging) Middleware {
 	return MiddlewareFunc(func(next Handler) Handler {
 		return splitByDay{
-			next:   next,
-			limits: limits,
+			next:    next,
+			limits:  limits,
+			hedging: hedging,
 		}
 	})
 }
 
 type splitByDay struct {
-	next   Handler
-	limits Limits
+	next    Handler
+	limits  Limits
+	hedging *Hedging
 }
 
 func (s splitByDay) Do(ctx context.Context, r *Request) (*APIResponse, error) {
@@ -29,7 +32,7 @@ func (s splitByDay) Do(ctx context.Context, r *Request) (*APIResponse, error) {
 	// to line up the boundaries with step.
 	reqs := splitQuery(r)
 
-	reqResps, err := doRequests(ctx, s.next, reqs, s.limits)
+	reqResps, err := doRequests(ctx, s.hedging.wrap(s.next, len(reqs)), reqs, s.limits)
 	if err != nil {
 		return nil, err
 	}
@@ -43,9 +46,15 @@ func (s splitByDay) Do(ctx context.Context, r *Request) (*APIResponse, error) {
 }
 
 func splitQuery(r *Request) []*Request {
+	return splitQueryByInterval(r, millisecondPerDay)
+}
+
+// splitQueryByInterval splits a request into one request per interval since
+// the epoch, taking care to line up the boundaries with step.
+func splitQueryByInterval(r *Request, interval int64) []*Request {
 	var reqs []*Request
-	for start := r.Start; start < r.End; start = nextDayBoundary(start, r.Step) + r.Step {
-		end := nextDayBoundary(start, r.Step)
+	for start := r.Start; start < r.End; start = nextIntervalBoundary(start, r.Step, interval) + r.Step {
+		end := nextIntervalBoundary(start, r.Step, interval)
 		if end+r.Step >= r.End {
 			end = r.End
 		}
@@ -63,10 +72,15 @@ func splitQuery(r *Request) []*Request {
 
 // Round up to the step before the next day boundary.
 func nextDayBoundary(t, step int64) int64 {
-	startOfNextDay := ((t / millisecondPerDay) + 1) * millisecondPerDay
+	return nextIntervalBoundary(t, step, millisecondPerDay)
+}
+
+// Round up to the step before the next interval boundary.
+func nextIntervalBoundary(t, step, interval int64) int64 {
+	startOfNextInterval := ((t / interval) + 1) * interval
 	// ensure that target is a multiple of steps away from the start time
-	target := startOfNextDay - ((startOfNextDay - t) % step)
-	if target == startOfNextDay {
+	target := startOfNextInterval - ((startOfNextInterval - t) % step)
+	if target == startOfNextInterval {
 		target -= step
 	}
 	return target
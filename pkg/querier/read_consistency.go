@@ -0,0 +1,34 @@
+package querier
+
+import (
+	"net/http"
+
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// ReadConsistencyLimits are the per-tenant limits setting the default read
+// consistency of the queries.
+type ReadConsistencyLimits interface {
+	ReadConsistency(string) string
+}
+
+// ReadConsistencyMiddleware sets the read consistency of the queries from
+// their util.ReadConsistencyHeader, set by the client or forwarded by the
+// query frontend, or else from the limits of the tenant; a federated query
+// is of strong consistency if any of its tenants is.
+func ReadConsistencyMiddleware(limits ReadConsistencyLimits, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		level := r.Header.Get(util.ReadConsistencyHeader)
+		if err := util.ValidateReadConsistency(level); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+
+		if orgID, err := user.ExtractOrgID(r.Context()); err == nil && level == "" {
+			level = util.TenantReadConsistency(orgID, limits.ReadConsistency)
+		}
+		next.ServeHTTP(w, r.WithContext(util.WithReadConsistency(r.Context(), level)))
+	})
+}
@@ -0,0 +1,60 @@
+package querier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+type readConsistencyLimitsMock map[string]string
+
+func (m readConsistencyLimitsMock) ReadConsistency(userID string) string {
+	if level, ok := m[userID]; ok {
+		return level
+	}
+	return util.ReadConsistencyEventual
+}
+
+func TestReadConsistencyMiddleware(t *testing.T) {
+	limits := readConsistencyLimitsMock{"strong": util.ReadConsistencyStrong}
+
+	var served string
+	handler := ReadConsistencyMiddleware(limits, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		served = util.ReadConsistencyFromContext(r.Context())
+	}))
+
+	for _, tc := range []struct {
+		orgID, header string
+		code          int
+		expected      string
+	}{
+		{orgID: "a", expected: util.ReadConsistencyEventual},
+		{orgID: "strong", expected: util.ReadConsistencyStrong},
+		// The header overrides the limits of the tenant.
+		{orgID: "a", header: "strong", expected: util.ReadConsistencyStrong},
+		{orgID: "strong", header: "eventual", expected: util.ReadConsistencyEventual},
+		// Any tenant of strong read consistency makes a federated query strong.
+		{orgID: "a|strong|b", expected: util.ReadConsistencyStrong},
+		{orgID: "a", header: "weak", code: http.StatusBadRequest},
+	} {
+		served = ""
+		req := httptest.NewRequest("GET", "/api/v1/query", nil)
+		req.Header.Set(util.ReadConsistencyHeader, tc.header)
+		req = req.WithContext(user.InjectOrgID(req.Context(), tc.orgID))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if tc.code != 0 {
+			assert.Equal(t, tc.code, w.Code)
+			assert.Empty(t, served)
+			continue
+		}
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, tc.expected, served, tc.orgID+" "+tc.header)
+	}
+}
@@ -0,0 +1,141 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+)
+
+// remoteReadQueryable queries the Prometheus remote read endpoints of a user
+// together with the ingesters and the store, merging the series of all of
+// them, e.g. while the data of the user still lives in the Prometheus servers
+// it was migrated from. The remote endpoints know nothing of the tenants:
+// each user has its own endpoints.
+type remoteReadQueryable struct {
+	next        storage.Queryable
+	distributor Distributor
+	limits      Limits
+	timeout     time.Duration
+
+	mtx     sync.Mutex
+	clients map[string]storage.Queryable // By URL.
+}
+
+func newRemoteReadQueryable(next storage.Queryable, distributor Distributor, limits Limits, timeout time.Duration) storage.Queryable {
+	return &remoteReadQueryable{
+		next:        next,
+		distributor: distributor,
+		limits:      limits,
+		timeout:     timeout,
+		clients:     map[string]storage.Queryable{},
+	}
+}
+
+// Querier implements storage.Queryable.
+func (r *remoteReadQueryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	primary, err := r.next.Querier(ctx, mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return primary, nil
+	}
+	urls := r.limits.RemoteReadURLs(userID)
+	if len(urls) == 0 {
+		return primary, nil
+	}
+
+	// Like the ingesters and the store, the remote endpoints are queried in
+	// parallel, and fail the query if they fail. The label names and values
+	// queries without matchers are only answered by the ingesters.
+	q := querier{
+		queriers:    []storage.Querier{primary},
+		distributor: r.distributor,
+		ctx:         ctx,
+		mint:        mint,
+		maxt:        maxt,
+	}
+	for _, u := range urls {
+		client, err := r.client(u)
+		if err != nil {
+			return nil, err
+		}
+		rq, err := client.Querier(ctx, mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		q.queriers = append(q.queriers, queryShardQuerier{Querier: rq})
+	}
+	return q, nil
+}
+
+// client returns the remote read client of an endpoint, created on first use.
+func (r *remoteReadQueryable) client(rawurl string) (storage.Queryable, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if client, ok := r.clients[rawurl]; ok {
+		return client, nil
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	client, err := remote.NewClient(len(r.clients), &remote.ClientConfig{
+		URL:     &config_util.URL{URL: u},
+		Timeout: model.Duration(r.timeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	queryable := remote.QueryableClient(client)
+	r.clients[rawurl] = queryable
+	return queryable, nil
+}
+
+// queryShardQuerier selects the shard of the series of the sharded queries
+// from the series returned by a querier which doesn't support query shards.
+type queryShardQuerier struct {
+	storage.Querier
+}
+
+func (q queryShardQuerier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	shard, matchers, err := chunk.ExtractQueryShard(matchers)
+	if err != nil {
+		return nil, nil, httpgrpc.Errorf(http.StatusBadRequest, "%v", err)
+	}
+	set, warnings, err := q.Querier.Select(sp, matchers...)
+	if err != nil || shard == nil {
+		return set, warnings, err
+	}
+	return &queryShardSeriesSet{SeriesSet: set, shard: shard}, warnings, nil
+}
+
+type queryShardSeriesSet struct {
+	storage.SeriesSet
+	shard *chunk.QueryShard
+}
+
+func (s *queryShardSeriesSet) Next() bool {
+	for s.SeriesSet.Next() {
+		if s.shard.MatchesLabels(s.SeriesSet.At().Labels()) {
+			return true
+		}
+	}
+	return false
+}
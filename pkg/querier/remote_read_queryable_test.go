@@ -0,0 +1,92 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestRemoteReadQueryable(t *testing.T) {
+	var queried []prompb.Query
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := remote.DecodeReadRequest(r)
+		require.NoError(t, err)
+		queried = append(queried, *req.Queries[0])
+
+		resp := &prompb.ReadResponse{Results: []*prompb.QueryResult{{
+			Timeseries: []*prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "foo"}, {Name: "legacy", Value: "true"}},
+				Samples: []prompb.Sample{{Timestamp: 0, Value: 1}},
+			}},
+		}}}
+		require.NoError(t, remote.EncodeReadResponse(resp, w))
+	}))
+	defer server.Close()
+
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.metricsRegisterer = nil
+
+	chunkStore, through := makeMockChunkStore(t, 24, encodings[0].e)
+	distributor := mockDistibutorFor(t, chunkStore, through)
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		Logger:        util.Logger,
+		MaxConcurrent: 10,
+		MaxSamples:    1e6,
+		Timeout:       1 * time.Minute,
+	})
+
+	for _, tc := range []struct {
+		urls           []string
+		expectedSeries []labels.Labels
+	}{
+		{
+			expectedSeries: []labels.Labels{
+				{{Name: "__name__", Value: "foo"}},
+			},
+		},
+		{
+			urls: []string{server.URL},
+			expectedSeries: []labels.Labels{
+				{{Name: "__name__", Value: "foo"}},
+				{{Name: "__name__", Value: "foo"}, {Name: "legacy", Value: "true"}},
+			},
+		},
+	} {
+		queried = nil
+		var limits validation.Limits
+		flagext.DefaultValues(&limits)
+		limits.RemoteReadURLs = tc.urls
+		overrides, err := validation.NewOverrides(limits)
+		require.NoError(t, err)
+		queryable, _ := New(cfg, distributor, chunkStore, overrides)
+
+		query, err := engine.NewRangeQuery(queryable, "foo", time.Unix(0, 0), through.Time(), time.Minute)
+		require.NoError(t, err)
+		r := query.Exec(user.InjectOrgID(context.Background(), "0"))
+		require.NoError(t, r.Err)
+
+		m, err := r.Matrix()
+		require.NoError(t, err)
+		var series []labels.Labels
+		for _, s := range m {
+			series = append(series, s.Metric)
+		}
+		require.Equal(t, tc.expectedSeries, series)
+		require.Len(t, queried, len(tc.urls))
+	}
+}
@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Stats are the statistics of a query: in the queriers, collected across all
+// its selects and, for a federated query, all its tenants; in the query
+// frontend, summed over the responses of the queriers to its requests. A nil
+// Stats doesn't collect anything.
+type Stats struct {
+	mtx    sync.Mutex
+	values Values
+}
+
+// Values are the values of the stats of a query.
+type Values struct {
+	// The requests sent to the queriers, and the time they were queued for.
+	Requests  int
+	QueueTime time.Duration
+
+	// The series, chunks and bytes of chunks fetched.
+	Series int
+	Chunks int
+	Bytes  int
+}
+
+type contextKey int
+
+// WithStats returns a context collecting the stats of the query in s.
+func WithStats(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, contextKey(0), s)
+}
+
+// FromContext returns the stats of the query; nil if none.
+func FromContext(ctx context.Context) *Stats {
+	s, _ := ctx.Value(contextKey(0)).(*Stats)
+	return s
+}
+
+// Add adds the values to the stats.
+func (s *Stats) Add(v Values) {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.values.Requests += v.Requests
+	s.values.QueueTime += v.QueueTime
+	s.values.Series += v.Series
+	s.values.Chunks += v.Chunks
+	s.values.Bytes += v.Bytes
+}
+
+// AddSeries adds fetched series to the stats.
+func (s *Stats) AddSeries(count int) {
+	s.Add(Values{Series: count})
+}
+
+// AddChunks adds fetched chunks, of size bytes in total, to the stats.
+func (s *Stats) AddChunks(count, size int) {
+	s.Add(Values{Chunks: count, Bytes: size})
+}
+
+// Values returns the current values of the stats.
+func (s *Stats) Values() Values {
+	if s == nil {
+		return Values{}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.values
+}
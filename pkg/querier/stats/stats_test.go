@@ -0,0 +1,23 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	s := &Stats{}
+	ctx := WithStats(context.Background(), s)
+	FromContext(ctx).AddSeries(1)
+	FromContext(ctx).AddChunks(2, 200)
+	FromContext(ctx).Add(Values{Requests: 1, QueueTime: time.Second, Series: 1})
+	assert.Equal(t, Values{Requests: 1, QueueTime: time.Second, Series: 2, Chunks: 2, Bytes: 200}, s.Values())
+
+	// Without stats in the context, nothing is collected.
+	assert.Nil(t, FromContext(context.Background()))
+	FromContext(context.Background()).AddSeries(1)
+	assert.Equal(t, Values{}, FromContext(context.Background()).Values())
+}
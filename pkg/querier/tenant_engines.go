@@ -0,0 +1,89 @@
+package querier
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/gate"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/querier/errstatus"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// EngineLimits are the per-tenant overrides of the limits of the PromQL
+// engine; 0 uses the limits of the querier.
+type EngineLimits interface {
+	QueryEngineTimeout(string) time.Duration
+	QueryEngineMaxSamples(string) int
+}
+
+type engineLimits struct {
+	timeout    time.Duration
+	maxSamples int
+}
+
+// NewTenantEnginesHandler returns a handler serving the requests of each
+// tenant with a PromQL engine enforcing the engine limits of the tenant. As
+// the engine of the Prometheus API is fixed, newHandler builds the handler of
+// the API for each engine; the engines are created on first use, and shared by
+// the tenants with the same limits. The tenants without overrides are served
+// by the given engine's handler. As each engine has its own gate, the requests
+// of all the engines go through a shared gate, so that the querier runs at most
+// cfg.MaxConcurrent requests at a time whichever engines serve them.
+func NewTenantEnginesHandler(cfg Config, engine *promql.Engine, limits EngineLimits, newHandler func(*promql.Engine) http.Handler) http.Handler {
+	defaults := engineLimits{timeout: cfg.Timeout, maxSamples: cfg.MaxSamples}
+	defaultHandler := newHandler(engine)
+	concurrency := gate.New(cfg.MaxConcurrent)
+
+	var (
+		mtx      sync.Mutex
+		handlers = map[engineLimits]http.Handler{}
+	)
+	handlerFor := func(l engineLimits) http.Handler {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if h, ok := handlers[l]; ok {
+			return h
+		}
+
+		// The engine metrics are only registered by the default engine.
+		h := newHandler(promql.NewEngine(promql.EngineOpts{
+			Logger:        util.Logger,
+			MaxConcurrent: cfg.MaxConcurrent,
+			MaxSamples:    l.maxSamples,
+			Timeout:       l.timeout,
+		}))
+		handlers[l] = h
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := concurrency.Start(r.Context()); err != nil {
+			http.Error(w, err.Error(), errstatus.Code(err))
+			return
+		}
+		defer concurrency.Done()
+
+		userID, err := user.ExtractOrgID(r.Context())
+		if err != nil {
+			defaultHandler.ServeHTTP(w, r)
+			return
+		}
+
+		l := defaults
+		if timeout := limits.QueryEngineTimeout(userID); timeout > 0 {
+			l.timeout = timeout
+		}
+		if maxSamples := limits.QueryEngineMaxSamples(userID); maxSamples > 0 {
+			l.maxSamples = maxSamples
+		}
+		if l == defaults {
+			defaultHandler.ServeHTTP(w, r)
+			return
+		}
+		handlerFor(l).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,110 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+type engineLimitsMock map[string]engineLimits
+
+func (m engineLimitsMock) QueryEngineTimeout(userID string) time.Duration {
+	return m[userID].timeout
+}
+
+func (m engineLimitsMock) QueryEngineMaxSamples(userID string) int {
+	return m[userID].maxSamples
+}
+
+func TestTenantEnginesHandler(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+
+	limits := engineLimitsMock{
+		"a": {timeout: time.Hour},
+		"b": {timeout: time.Hour},
+		"c": {maxSamples: 1},
+		// The same limits as the querier.
+		"d": {timeout: cfg.Timeout, maxSamples: cfg.MaxSamples},
+	}
+
+	engine := promql.NewEngine(promql.EngineOpts{MaxSamples: cfg.MaxSamples, Timeout: cfg.Timeout})
+	var served *promql.Engine
+	handler := NewTenantEnginesHandler(cfg, engine, limits, func(e *promql.Engine) http.Handler {
+		return http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			served = e
+		})
+	})
+
+	serve := func(userID string) *promql.Engine {
+		req := httptest.NewRequest("GET", "/api/v1/query", nil)
+		req = req.WithContext(user.InjectOrgID(req.Context(), userID))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return served
+	}
+
+	assert.Same(t, engine, serve("none"))
+	assert.Same(t, engine, serve("d"))
+
+	a := serve("a")
+	assert.True(t, a != engine)
+	// Tenants with the same limits share an engine.
+	assert.Same(t, a, serve("b"))
+	assert.Same(t, a, serve("a"))
+
+	c := serve("c")
+	assert.True(t, c != engine)
+	assert.True(t, c != a)
+}
+
+func TestTenantEnginesHandlerSharesMaxConcurrent(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.MaxConcurrent = 1
+
+	limits := engineLimitsMock{"a": {timeout: time.Hour}}
+	engine := promql.NewEngine(promql.EngineOpts{MaxConcurrent: cfg.MaxConcurrent, MaxSamples: cfg.MaxSamples, Timeout: cfg.Timeout})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := NewTenantEnginesHandler(cfg, engine, limits, func(e *promql.Engine) http.Handler {
+		return http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			if e != engine {
+				close(started)
+				<-release
+			}
+		})
+	})
+
+	request := func(ctx context.Context, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/query", nil)
+		req = req.WithContext(user.InjectOrgID(ctx, userID))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request(context.Background(), "a")
+	}()
+	<-started
+
+	// The request of the tenant engine holds the only slot, so a request of
+	// the default engine waits until it's canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, http.StatusServiceUnavailable, request(ctx, "none").Code)
+
+	close(release)
+	<-done
+	assert.Equal(t, http.StatusOK, request(context.Background(), "none").Code)
+}
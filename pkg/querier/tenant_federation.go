@@ -0,0 +1,260 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/querier/stats"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const (
+	// TenantLabel is the label added to the series returned by a federated
+	// query, identifying the tenant they belong to.
+	TenantLabel = "__tenant_id__"
+
+	// TenantSeparator separates the tenants of a federated query in the
+	// X-Scope-OrgID header, e.g. "a|b|c".
+	TenantSeparator = util.TenantSeparator
+
+	// allowAllTenants in the allowlist of a tenant allows it to be queried
+	// together with any tenant.
+	allowAllTenants = "*"
+)
+
+// FederationLimits are the per-tenant limits used by federated queries.
+type FederationLimits interface {
+	FederationAllowedTenants(string) []string
+	MaxFetchedChunksPerQuery(string) int
+	MaxFetchedSeriesPerQuery(string) int
+	MaxFetchedChunkBytesPerQuery(string) int
+}
+
+// NewFederatedQueryable returns a queryable which queries multiple tenants in
+// one request, when the org ID lists several tenants separated by
+// TenantSeparator. Each tenant is queried with the given queryable, and its
+// series are labelled with TenantLabel. Requests for a single tenant are
+// passed through.
+//
+// A tenant is only queried together with the tenants in its allowlist, so
+// that a tenant has to opt in for its data to be combined with some other's.
+// The data fetched by a federated query is limited across all its tenants,
+// by the strictest limits of the tenants.
+func NewFederatedQueryable(queryable storage.Queryable, limits FederationLimits) storage.Queryable {
+	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		orgID, err := user.ExtractOrgID(ctx)
+		if err != nil || !strings.Contains(orgID, TenantSeparator) {
+			return queryable.Querier(ctx, mint, maxt)
+		}
+
+		tenants, err := parseTenants(orgID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkFederationAllowed(tenants, limits); err != nil {
+			return nil, err
+		}
+		ctx = withQueryLimiter(ctx, newFederatedQueryLimiter(limits, orgID, stats.FromContext(ctx)))
+
+		q := &federatedQuerier{
+			tenants:  tenants,
+			queriers: make([]storage.Querier, 0, len(tenants)),
+		}
+		for _, tenant := range tenants {
+			tq, err := queryable.Querier(user.InjectOrgID(ctx, tenant), mint, maxt)
+			if err != nil {
+				_ = q.Close()
+				return nil, err
+			}
+			q.queriers = append(q.queriers, tq)
+		}
+		return q, nil
+	})
+}
+
+// parseTenants returns the sorted, deduplicated tenants of a federated org ID.
+func parseTenants(orgID string) ([]string, error) {
+	tenants := util.TenantIDs(orgID)
+	if tenants[0] == "" {
+		return nil, httpgrpc.Errorf(http.StatusBadRequest, "invalid org ID %q: empty tenant", orgID)
+	}
+	return tenants, nil
+}
+
+func checkFederationAllowed(tenants []string, limits FederationLimits) error {
+	for _, tenant := range tenants {
+		allowed := map[string]struct{}{}
+		for _, other := range limits.FederationAllowedTenants(tenant) {
+			allowed[other] = struct{}{}
+		}
+		if _, ok := allowed[allowAllTenants]; ok {
+			continue
+		}
+
+		for _, other := range tenants {
+			if _, ok := allowed[other]; !ok && other != tenant {
+				return httpgrpc.Errorf(http.StatusForbidden, "tenant %s cannot be queried together with tenant %s", tenant, other)
+			}
+		}
+	}
+	return nil
+}
+
+type federatedQuerier struct {
+	tenants  []string
+	queriers []storage.Querier // one per tenant
+}
+
+// Select implements storage.Querier. Matchers on TenantLabel select the
+// tenants queried, and are not passed to the tenants' queriers.
+func (q *federatedQuerier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	tenantMatchers, matchers := splitTenantMatchers(matchers)
+
+	type result struct {
+		series   []storage.Series
+		warnings storage.Warnings
+		err      error
+	}
+	results := make([]chan result, len(q.tenants))
+	for i, tenant := range q.tenants {
+		results[i] = make(chan result, 1)
+		if !matchTenant(tenant, tenantMatchers) {
+			results[i] <- result{}
+			continue
+		}
+
+		go func(tenant string, querier storage.Querier, results chan<- result) {
+			set, warnings, err := querier.Select(sp, matchers...)
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+
+			var series []storage.Series
+			for set.Next() {
+				series = append(series, newTenantSeries(set.At(), tenant))
+			}
+			results <- result{series: series, warnings: warnings, err: set.Err()}
+		}(tenant, q.queriers[i], results[i])
+	}
+
+	var (
+		series   []storage.Series
+		warnings storage.Warnings
+	)
+	for _, c := range results {
+		r := <-c
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+		series = append(series, r.series...)
+		warnings = append(warnings, r.warnings...)
+	}
+	return newConcreteSeriesSet(series), warnings, nil
+}
+
+// LabelValues implements storage.Querier.
+func (q *federatedQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
+	if name == TenantLabel {
+		return q.tenants, nil, nil
+	}
+
+	return q.mergeStrings(func(querier storage.Querier) ([]string, storage.Warnings, error) {
+		return querier.LabelValues(name)
+	})
+}
+
+// LabelNames implements storage.Querier.
+func (q *federatedQuerier) LabelNames() ([]string, storage.Warnings, error) {
+	names, warnings, err := q.mergeStrings(func(querier storage.Querier) ([]string, storage.Warnings, error) {
+		return querier.LabelNames()
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names = append(names, TenantLabel)
+	sort.Strings(names)
+	return names, warnings, nil
+}
+
+// mergeStrings returns the sorted union of the strings returned by the
+// queriers of all the tenants.
+func (q *federatedQuerier) mergeStrings(f func(storage.Querier) ([]string, storage.Warnings, error)) ([]string, storage.Warnings, error) {
+	var warnings storage.Warnings
+	set := map[string]struct{}{}
+	for _, querier := range q.queriers {
+		values, ws, err := f(querier)
+		if err != nil {
+			return nil, nil, err
+		}
+		warnings = append(warnings, ws...)
+		for _, value := range values {
+			set[value] = struct{}{}
+		}
+	}
+
+	result := make([]string, 0, len(set))
+	for value := range set {
+		result = append(result, value)
+	}
+	sort.Strings(result)
+	return result, warnings, nil
+}
+
+// Close implements storage.Querier.
+func (q *federatedQuerier) Close() error {
+	var lastErr error
+	for _, querier := range q.queriers {
+		if err := querier.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func splitTenantMatchers(matchers []*labels.Matcher) (tenantMatchers, others []*labels.Matcher) {
+	for _, m := range matchers {
+		if m.Name == TenantLabel {
+			tenantMatchers = append(tenantMatchers, m)
+		} else {
+			others = append(others, m)
+		}
+	}
+	return tenantMatchers, others
+}
+
+func matchTenant(tenant string, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(tenant) {
+			return false
+		}
+	}
+	return true
+}
+
+// tenantSeries adds TenantLabel to the labels of a series.
+type tenantSeries struct {
+	storage.Series
+	labels labels.Labels
+}
+
+func newTenantSeries(series storage.Series, tenant string) storage.Series {
+	b := labels.NewBuilder(series.Labels())
+	b.Set(TenantLabel, tenant)
+	return &tenantSeries{
+		Series: series,
+		labels: b.Labels(),
+	}
+}
+
+func (s *tenantSeries) Labels() labels.Labels {
+	return s.labels
+}
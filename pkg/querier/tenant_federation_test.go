@@ -0,0 +1,177 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+)
+
+type federationLimitsMock map[string][]string
+
+func (m federationLimitsMock) FederationAllowedTenants(userID string) []string {
+	return m[userID]
+}
+
+func (federationLimitsMock) MaxFetchedChunksPerQuery(string) int     { return 0 }
+func (federationLimitsMock) MaxFetchedSeriesPerQuery(string) int     { return 0 }
+func (federationLimitsMock) MaxFetchedChunkBytesPerQuery(string) int { return 0 }
+
+// tenantQuerierMock returns a single series, named after the tenant queried.
+type tenantQuerierMock struct {
+	tenant string
+}
+
+func (q tenantQuerierMock) Select(_ *storage.SelectParams, _ ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	return newConcreteSeriesSet([]storage.Series{
+		newConcreteSeries(labels.Labels{{Name: "foo", Value: q.tenant}}, []model.SamplePair{{Timestamp: 1, Value: 1}}),
+	}), nil, nil
+}
+
+func (q tenantQuerierMock) LabelValues(string) ([]string, storage.Warnings, error) {
+	return []string{q.tenant}, nil, nil
+}
+
+func (q tenantQuerierMock) LabelNames() ([]string, storage.Warnings, error) {
+	return []string{"foo"}, nil, nil
+}
+
+func (tenantQuerierMock) Close() error {
+	return nil
+}
+
+var tenantQueryableMock = storage.QueryableFunc(func(ctx context.Context, _, _ int64) (storage.Querier, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tenantQuerierMock{tenant: userID}, nil
+})
+
+func TestFederatedQueryable(t *testing.T) {
+	limits := federationLimitsMock{
+		"a": {"b"},
+		"b": {"*"},
+		"c": {"b"},
+	}
+	queryable := NewFederatedQueryable(tenantQueryableMock, limits)
+
+	tenantMatcher, err := labels.NewMatcher(labels.MatchEqual, TenantLabel, "b")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		orgID    string
+		matchers []*labels.Matcher
+		expected []labels.Labels
+		code     int32
+	}{
+		{
+			orgID:    "a",
+			expected: []labels.Labels{{{Name: "foo", Value: "a"}}},
+		},
+		{
+			orgID: "b|a|a",
+			expected: []labels.Labels{
+				{{Name: TenantLabel, Value: "a"}, {Name: "foo", Value: "a"}},
+				{{Name: TenantLabel, Value: "b"}, {Name: "foo", Value: "b"}},
+			},
+		},
+		{
+			orgID:    "a|b",
+			matchers: []*labels.Matcher{tenantMatcher},
+			expected: []labels.Labels{
+				{{Name: TenantLabel, Value: "b"}, {Name: "foo", Value: "b"}},
+			},
+		},
+		{
+			// a and c only allow b.
+			orgID: "a|b|c",
+			code:  http.StatusForbidden,
+		},
+		{
+			orgID: "a||b",
+			code:  http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.orgID, func(t *testing.T) {
+			ctx := user.InjectOrgID(context.Background(), tc.orgID)
+			querier, err := queryable.Querier(ctx, 0, 10)
+			if tc.code != 0 {
+				resp, ok := httpgrpc.HTTPResponseFromError(err)
+				require.True(t, ok)
+				assert.Equal(t, tc.code, resp.Code)
+				return
+			}
+			require.NoError(t, err)
+
+			set, _, err := querier.Select(&storage.SelectParams{Start: 0, End: 10}, tc.matchers...)
+			require.NoError(t, err)
+
+			var actual []labels.Labels
+			for set.Next() {
+				actual = append(actual, set.At().Labels())
+			}
+			require.NoError(t, set.Err())
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestFederatedQueryableLabels(t *testing.T) {
+	queryable := NewFederatedQueryable(tenantQueryableMock, federationLimitsMock{"a": {"*"}, "b": {"*"}})
+	querier, err := queryable.Querier(user.InjectOrgID(context.Background(), "a|b"), 0, 10)
+	require.NoError(t, err)
+
+	values, _, err := querier.LabelValues("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, values)
+
+	values, _, err = querier.LabelValues(TenantLabel)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, values)
+
+	names, _, err := querier.LabelNames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{TenantLabel, "foo"}, names)
+}
+
+type federationFetchLimitsMock struct {
+	federationLimitsMock
+	maxChunks map[string]int
+}
+
+func (m federationFetchLimitsMock) MaxFetchedChunksPerQuery(userID string) int {
+	return m.maxChunks[userID]
+}
+
+func TestFederatedQueryableSharesQueryLimiter(t *testing.T) {
+	limiters := map[string]*queryLimiter{}
+	queryable := storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		userID, err := user.ExtractOrgID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		limiters[userID] = queryLimiterFromContext(ctx)
+		return tenantQuerierMock{tenant: userID}, nil
+	})
+	limits := federationFetchLimitsMock{
+		federationLimitsMock: federationLimitsMock{"a": {"*"}, "b": {"*"}},
+		maxChunks:            map[string]int{"a": 20, "b": 10},
+	}
+
+	_, err := NewFederatedQueryable(queryable, limits).Querier(user.InjectOrgID(context.Background(), "a|b"), 0, 1)
+	require.NoError(t, err)
+
+	// The tenants share the limiter of the query, with their strictest limits.
+	require.Len(t, limiters, 2)
+	require.NotNil(t, limiters["a"])
+	require.True(t, limiters["a"] == limiters["b"])
+	require.Equal(t, 10, limiters["a"].maxChunks)
+}
@@ -0,0 +1,84 @@
+package querier
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/frontend"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+)
+
+// TypedQueryRangeHandler executes the range queries sent by the frontend with
+// the typed query API, -frontend.typed-query-api, and returns their results
+// to the worker as protobuf rather than encoding them as JSON; the errors are
+// returned as JSON, as by the Prometheus API. The stats of the query collected
+// by QueryStatsMiddleware are returned in the headers of the response. The
+// other requests, including the queries requesting their stats in the
+// response, which protobuf can't hold, are served by next.
+func TypedQueryRangeHandler(engine *promql.Engine, queryable storage.Queryable, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		typed := frontend.TypedQueryFromContext(r.Context())
+		if typed == nil || typed.Request == nil || !strings.HasSuffix(r.URL.Path, "/api/v1/query_range") || r.FormValue("stats") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		req := typed.Request
+		qry, err := engine.NewRangeQuery(queryable, req.Query, timestamp.Time(req.Start), timestamp.Time(req.End), time.Duration(req.Step)*time.Millisecond)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_data", err)
+			return
+		}
+		defer qry.Close()
+
+		res := qry.Exec(r.Context())
+		if res.Err != nil {
+			switch res.Err.(type) {
+			case promql.ErrQueryCanceled:
+				writeAPIError(w, http.StatusServiceUnavailable, "canceled", res.Err)
+			case promql.ErrQueryTimeout:
+				writeAPIError(w, http.StatusServiceUnavailable, "timeout", res.Err)
+			default:
+				writeAPIError(w, http.StatusUnprocessableEntity, "execution", res.Err)
+			}
+			return
+		}
+
+		matrix, err := res.Matrix()
+		if err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, "execution", err)
+			return
+		}
+		result := make([]queryrange.SampleStream, 0, len(matrix))
+		for _, series := range matrix {
+			stream := queryrange.SampleStream{
+				Labels:  client.FromLabelsToLabelAdapters(series.Metric),
+				Samples: make([]client.Sample, 0, len(series.Points)),
+			}
+			for _, p := range series.Points {
+				stream.Samples = append(stream.Samples, client.Sample{TimestampMs: p.T, Value: p.V})
+			}
+			result = append(result, stream)
+		}
+
+		var warnings []string
+		for _, warning := range res.Warnings {
+			warnings = append(warnings, warning.Error())
+		}
+		typed.Response = &queryrange.APIResponse{
+			Status: "success",
+			Data: queryrange.Response{
+				ResultType: string(promql.ValueTypeMatrix),
+				Result:     result,
+			},
+			Warnings: warnings,
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
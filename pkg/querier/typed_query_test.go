@@ -0,0 +1,107 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/frontend"
+	"github.com/cortexproject/cortex/pkg/querier/queryrange"
+	"github.com/cortexproject/cortex/pkg/querier/stats"
+)
+
+func TestTypedQueryRangeHandler(t *testing.T) {
+	engine := promql.NewEngine(promql.EngineOpts{MaxConcurrent: 1, MaxSamples: 1e6, Timeout: time.Minute})
+	var nextCalled bool
+	handler := TypedQueryRangeHandler(engine, tenantQueryableMock, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		nextCalled = true
+	}))
+
+	serve := func(path string, typed *frontend.TypedQuery) *httptest.ResponseRecorder {
+		nextCalled = false
+		req := httptest.NewRequest("GET", path, nil)
+		ctx := user.InjectOrgID(req.Context(), "a")
+		if typed != nil {
+			ctx = frontend.WithTypedQuery(ctx, typed)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req.WithContext(ctx))
+		return w
+	}
+
+	typed := &frontend.TypedQuery{Request: &queryrange.Request{Query: "foo", Start: 0, End: 60000, Step: 30000}}
+	w := serve("/api/prom/api/v1/query_range", typed)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, nextCalled)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, &queryrange.APIResponse{
+		Status: "success",
+		Data: queryrange.Response{
+			ResultType: "matrix",
+			Result: []queryrange.SampleStream{{
+				Labels:  []client.LabelAdapter{{Name: "foo", Value: "a"}},
+				Samples: []client.Sample{{TimestampMs: 30000, Value: 1}, {TimestampMs: 60000, Value: 1}},
+			}},
+		},
+	}, typed.Response)
+
+	// The errors are returned as JSON.
+	typed = &frontend.TypedQuery{Request: &queryrange.Request{Query: "foo{", Start: 0, End: 60000, Step: 30000}}
+	w = serve("/api/prom/api/v1/query_range", typed)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"errorType":"bad_data"`)
+	assert.Nil(t, typed.Response)
+
+	// The other requests are served by next.
+	serve("/api/prom/api/v1/query_range", nil)
+	assert.True(t, nextCalled)
+	serve("/api/prom/api/v1/query", &frontend.TypedQuery{Request: &queryrange.Request{Query: "foo"}})
+	assert.True(t, nextCalled)
+}
+
+func TestTypedQueryRangeHandlerStats(t *testing.T) {
+	engine := promql.NewEngine(promql.EngineOpts{MaxConcurrent: 1, MaxSamples: 1e6, Timeout: time.Minute})
+	queryable := storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		stats.FromContext(ctx).AddSeries(1)
+		stats.FromContext(ctx).AddChunks(2, 200)
+		return tenantQueryableMock.Querier(ctx, mint, maxt)
+	})
+	var nextCalled bool
+	handler := QueryStatsMiddleware(TypedQueryRangeHandler(engine, queryable, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		nextCalled = true
+	})))
+
+	serve := func(path string, typed *frontend.TypedQuery) *httptest.ResponseRecorder {
+		nextCalled = false
+		req := httptest.NewRequest("GET", path, nil)
+		ctx := frontend.WithTypedQuery(user.InjectOrgID(req.Context(), "a"), typed)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req.WithContext(ctx))
+		return w
+	}
+
+	// The stats of a typed query are returned in the headers.
+	typed := &frontend.TypedQuery{Request: &queryrange.Request{Query: "foo", Start: 0, End: 60000, Step: 30000}}
+	w := serve("/api/prom/api/v1/query_range?query=foo&start=0&end=60&step=30", typed)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, nextCalled)
+	assert.NotNil(t, typed.Response)
+	assert.Equal(t, "1", w.Header().Get(frontend.SeriesFetchedHeader))
+	assert.Equal(t, "2", w.Header().Get(frontend.ChunksFetchedHeader))
+	assert.Equal(t, "200", w.Header().Get(frontend.BytesFetchedHeader))
+
+	// The queries requesting their stats in the response are returned as JSON.
+	typed = &frontend.TypedQuery{Request: &queryrange.Request{Query: "foo", Start: 0, End: 60000, Step: 30000}}
+	serve("/api/prom/api/v1/query_range?query=foo&start=0&end=60&step=30&stats=all", typed)
+	assert.True(t, nextCalled)
+	assert.Nil(t, typed.Response)
+}
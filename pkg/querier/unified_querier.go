@@ -4,18 +4,28 @@ import (
 	"context"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/util"
 )
 
-func newUnifiedChunkQueryable(ds, cs ChunkStore, distributor Distributor, chunkIteratorFunc chunkIteratorFunc, ingesterMaxQueryLookback time.Duration) storage.Queryable {
+var selectEstimatedMemory = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "cortex",
+	Name:      "querier_select_estimated_memory_bytes",
+	Help:      "Estimate of the peak memory used by the chunks of a single select, i.e. the size of the chunks fetched from the store and the ingesters.",
+	Buckets:   prometheus.ExponentialBuckets(1024, 4, 10), // biggest bucket is 1024*4^(10-1) = 256MiB
+})
+
+func newUnifiedChunkQueryable(ds *ingesterQueryable, cs ChunkStore, distributor Distributor, chunkIteratorFunc chunkIteratorFunc, ingesterMaxQueryLookback time.Duration) storage.Queryable {
 	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
 		ucq := &unifiedChunkQuerier{
-			stores: []ChunkStore{cs},
+			store: cs,
 			querier: querier{
 				ctx:         ctx,
 				mint:        mint,
@@ -23,6 +33,7 @@ func newUnifiedChunkQueryable(ds, cs ChunkStore, distributor Distributor, chunkI
 				distributor: distributor,
 			},
 			csq: chunkStoreQuerier{
+				store:             cs,
 				chunkIteratorFunc: chunkIteratorFunc,
 				ctx:               ctx,
 				mint:              mint,
@@ -30,9 +41,10 @@ func newUnifiedChunkQueryable(ds, cs ChunkStore, distributor Distributor, chunkI
 			},
 		}
 
-		// Include ingester only if maxt is within ingesterMaxQueryLookback w.r.t. current time.
-		if ingesterMaxQueryLookback == 0 || maxt >= time.Now().Add(-ingesterMaxQueryLookback).UnixNano()/1e6 {
-			ucq.stores = append(ucq.stores, ds)
+		// Include ingester only if maxt is within ingesterMaxQueryLookback w.r.t. current time,
+		// or the query is of strong read consistency.
+		if ingesterMaxQueryLookback == 0 || util.IsStrongReadConsistency(ctx) || maxt >= time.Now().Add(-ingesterMaxQueryLookback).UnixNano()/1e6 {
+			ucq.ingesters = ds
 		}
 
 		return ucq, nil
@@ -40,19 +52,52 @@ func newUnifiedChunkQueryable(ds, cs ChunkStore, distributor Distributor, chunkI
 }
 
 type unifiedChunkQuerier struct {
-	stores []ChunkStore
+	store     ChunkStore
+	ingesters *ingesterQueryable // nil if the ingesters are not queried
 
-	// We reuse metadataQuery, LabelValues and Close from querier.
+	// We reuse LabelValues and Close from querier.
 	querier
 
-	// We reuse partitionChunks from chunkStoreQuerier.
+	// We reuse partitionChunks and the metadata queries from chunkStoreQuerier.
 	csq chunkStoreQuerier
 }
 
+// metadataQuerier returns the querier of the metadata queries, over the index
+// of the store and the ingesters.
+func (q *unifiedChunkQuerier) metadataQuerier() querier {
+	mq := q.querier
+	mq.queriers = []storage.Querier{&q.csq}
+	if q.ingesters != nil {
+		mq.queriers = append(mq.queriers, &distributorQuerier{
+			distributor: q.distributor,
+			ctx:         q.ctx,
+			mint:        q.mint,
+			maxt:        q.maxt,
+		})
+	}
+	return mq
+}
+
+// LabelNamesFor implements labelsQuerier.
+func (q *unifiedChunkQuerier) LabelNamesFor(matchers ...*labels.Matcher) ([]string, error) {
+	return q.metadataQuerier().LabelNamesFor(matchers...)
+}
+
+// LabelValuesFor implements labelsQuerier.
+func (q *unifiedChunkQuerier) LabelValuesFor(name string, matchers ...*labels.Matcher) ([]string, error) {
+	return q.metadataQuerier().LabelValuesFor(name, matchers...)
+}
+
+// Get implements ChunkStore for the chunk tar HTTP handler.
 func (q *unifiedChunkQuerier) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]chunk.Chunk, error) {
-	css := make(chan []chunk.Chunk, len(q.stores))
-	errs := make(chan error, len(q.stores))
-	for _, store := range q.stores {
+	stores := []ChunkStore{q.store}
+	if q.ingesters != nil {
+		stores = append(stores, q.ingesters)
+	}
+
+	css := make(chan []chunk.Chunk, len(stores))
+	errs := make(chan error, len(stores))
+	for _, store := range stores {
 		go func(store ChunkStore) {
 			cs, err := store.Get(ctx, userID, from, through, matchers...)
 			if err != nil {
@@ -64,7 +109,7 @@ func (q *unifiedChunkQuerier) Get(ctx context.Context, userID string, from, thro
 	}
 
 	chunks := []chunk.Chunk{}
-	for range q.stores {
+	for range stores {
 		select {
 		case err := <-errs:
 			return nil, err
@@ -75,7 +120,10 @@ func (q *unifiedChunkQuerier) Get(ctx context.Context, userID string, from, thro
 	return chunks, nil
 }
 
-// Select implements storage.Querier.
+// Select implements storage.Querier. The series from the store and from the
+// ingesters are merged lazily as they are iterated, and the chunks streamed
+// from the ingesters are only decoded then, rather than materialising all the
+// chunks of all the series up front.
 func (q *unifiedChunkQuerier) Select(sp *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
 	userID, err := user.ExtractOrgID(q.ctx)
 	if err != nil {
@@ -83,13 +131,56 @@ func (q *unifiedChunkQuerier) Select(sp *storage.SelectParams, matchers ...*labe
 	}
 
 	if sp == nil {
-		return q.metadataQuery(matchers...)
+		return q.metadataQuerier().Select(nil, matchers...)
 	}
 
-	chunks, err := q.Get(q.ctx, userID, model.Time(sp.Start), model.Time(sp.End), matchers...)
-	if err != nil {
-		return nil, nil, err
+	from, through := model.Time(sp.Start), model.Time(sp.End)
+
+	type result struct {
+		set  storage.SeriesSet
+		size int
+		err  error
+	}
+	results := make(chan result, 2)
+	pending := 1
+	go func() {
+		chunks, err := q.store.Get(q.ctx, userID, from, through, matchers...)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		if err := queryLimiterFromContext(q.ctx).addStoreChunks(chunks); err != nil {
+			results <- result{err: err}
+			return
+		}
+		size := 0
+		for _, c := range chunks {
+			size += c.Data.Size()
+		}
+		results <- result{set: q.csq.partitionChunks(chunks), size: size}
+	}()
+	if q.ingesters != nil {
+		pending++
+		go func() {
+			set, size, err := q.ingesters.selectSeries(q.ctx, userID, from, through, matchers...)
+			results <- result{set: set, size: size, err: err}
+		}()
 	}
 
-	return q.csq.partitionChunks(chunks), nil, nil
+	sets := make([]storage.SeriesSet, 0, pending)
+	size := 0
+	for ; pending > 0; pending-- {
+		r := <-results
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+		sets = append(sets, r.set)
+		size += r.size
+	}
+	selectEstimatedMemory.Observe(float64(size))
+
+	if len(sets) == 1 {
+		return sets[0], nil, nil
+	}
+	return storage.NewMergeSeriesSet(sets, nil), nil, nil
 }
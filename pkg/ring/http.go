@@ -2,15 +2,17 @@ package ring
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"math"
 	"net/http"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log/level"
-	"github.com/gogo/protobuf/proto"
 
 	"github.com/cortexproject/cortex/pkg/util"
 )
@@ -24,16 +26,22 @@ const tpl = `
 	</head>
 	<body>
 		<h1>Cortex Ring Status</h1>
+		<p>Ring: {{ .Name }}</p>
 		<p>Current time: {{ .Now }}</p>
+		{{ if .ZonesError }}
+		<p><b>Zones: {{ .ZonesError }}</b></p>
+		{{ end }}
 		<form action="" method="POST">
 			<input type="hidden" name="csrf_token" value="$__CSRF_TOKEN_PLACEHOLDER__">
 			<table width="100%" border="1">
 				<thead>
 					<tr>
-						<th>Ingester</th>
+						<th>Instance</th>
 						<th>State</th>
 						<th>Address</th>
+						<th>Zone</th>
 						<th>Last Heartbeat</th>
+						<th>Heartbeat Age</th>
 						<th>Tokens</th>
 						<th>Ownership</th>
 						<th>Actions</th>
@@ -49,15 +57,28 @@ const tpl = `
 						<td>{{ .ID }}</td>
 						<td>{{ .State }}</td>
 						<td>{{ .Address }}</td>
-						<td>{{ .Timestamp }}</td>
-						<td>{{ .Tokens }}</td>
+						<td>{{ .Zone }}</td>
+						<td>{{ .HeartbeatTimestamp }}</td>
+						<td>{{ .HeartbeatAge }}</td>
+						<td>{{ .NumTokens }}</td>
 						<td>{{ .Ownership }}%</td>
 						<td><button name="forget" value="{{ .ID }}" type="submit">Forget</button></td>
 					</tr>
 					{{ end }}
 				</tbody>
 			</table>
-			<pre>{{ .Ring }}</pre>
+			<br>
+			{{ if .ShowTokens }}
+			<input type="button" value="Hide Tokens" onclick="window.location.href = '?tokens=false'" />
+			{{ else }}
+			<input type="button" value="Show Tokens" onclick="window.location.href = '?tokens=true'" />
+			{{ end }}
+			{{ if .ShowTokens }}
+			{{ range $i, $ing := .Ingesters }}
+			<h2>Instance: {{ .ID }}</h2>
+			<p>Tokens: {{ range $token := .Tokens }}{{ $token }} {{ end }}</p>
+			{{ end }}
+			{{ end }}
 		</form>
 	</body>
 </html>`
@@ -70,6 +91,31 @@ func init() {
 	tmpl = template.Must(t.Parse(tpl))
 }
 
+// errInstanceNotFound is returned when forgetting an instance missing from
+// the ring.
+var errInstanceNotFound = errors.New("instance not found in the ring")
+
+// ingesterStatus is the status of an instance of the ring, on its status page.
+type ingesterStatus struct {
+	ID                 string   `json:"id"`
+	State              string   `json:"state"`
+	Address            string   `json:"address"`
+	Zone               string   `json:"zone"`
+	HeartbeatTimestamp string   `json:"heartbeat_timestamp"`
+	HeartbeatAge       string   `json:"heartbeat_age"`
+	NumTokens          int      `json:"num_tokens"`
+	Tokens             []uint32 `json:"tokens"`
+	Ownership          float64  `json:"ownership"`
+}
+
+type ringStatus struct {
+	Name       string           `json:"name"`
+	Ingesters  []ingesterStatus `json:"instances"`
+	Now        time.Time        `json:"now"`
+	ZonesError string           `json:"zones_error,omitempty"`
+	ShowTokens bool             `json:"-"`
+}
+
 func (r *Ring) forget(ctx context.Context, id string) error {
 	unregister := func(in interface{}) (out interface{}, retry bool, err error) {
 		if in == nil {
@@ -77,25 +123,70 @@ func (r *Ring) forget(ctx context.Context, id string) error {
 		}
 
 		ringDesc := in.(*Desc)
+		if ing, ok := ringDesc.Ingesters[id]; !ok || ing.State == LEFT {
+			return nil, false, errInstanceNotFound
+		}
 		ringDesc.RemoveIngester(id)
 		return ringDesc, true, nil
 	}
 	return r.KVClient.CAS(ctx, ConsulKey, unregister)
 }
 
+// ServeHTTP shows the instances of the ring, and forgets the instance of the
+// "forget" form value of a POST, for example the stuck unhealthy instances.
+// Both the status and the forget API are also served in JSON, to the
+// requests accepting it.
 func (r *Ring) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if req.Method == http.MethodPost {
-		ingesterID := req.FormValue("forget")
-		if err := r.forget(req.Context(), ingesterID); err != nil {
-			level.Error(util.WithContext(req.Context(), util.Logger)).Log("msg", "error forgetting ingester", "err", err)
+		r.serveForget(w, req)
+		return
+	}
+
+	status := r.status(req.URL.Query().Get("tokens") == "true")
+	if acceptsJSON(req) {
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := tmpl.Execute(w, status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (r *Ring) serveForget(w http.ResponseWriter, req *http.Request) {
+	ingesterID := req.FormValue("forget")
+	if ingesterID == "" {
+		http.Error(w, "the instance to forget is missing", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.forget(req.Context(), ingesterID); err != nil {
+		level.Error(util.WithContext(req.Context(), util.Logger)).Log("msg", "error forgetting instance", "ring", r.name, "instance", ingesterID, "err", err)
+		code := http.StatusInternalServerError
+		if err == errInstanceNotFound {
+			code = http.StatusNotFound
 		}
+		http.Error(w, fmt.Sprintf("failed to forget instance %s: %v", ingesterID, err), code)
+		return
+	}
+	level.Info(util.WithContext(req.Context(), util.Logger)).Log("msg", "instance forgotten", "ring", r.name, "instance", ingesterID)
 
-		// Implement PRG pattern to prevent double-POST and work with CSRF middleware.
-		// https://en.wikipedia.org/wiki/Post/Redirect/Get
-		http.Redirect(w, req, req.RequestURI, http.StatusFound)
+	if acceptsJSON(req) {
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	// Implement PRG pattern to prevent double-POST and work with CSRF middleware.
+	// https://en.wikipedia.org/wiki/Post/Redirect/Get
+	http.Redirect(w, req, req.RequestURI, http.StatusFound)
+}
+
+// status returns the status of the instances of the ring, with their tokens
+// if showTokens.
+func (r *Ring) status(showTokens bool) ringStatus {
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
 
@@ -105,8 +196,15 @@ func (r *Ring) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	sort.Strings(ingesterIDs)
 
-	ingesters := []interface{}{}
-	tokens, owned := countTokens(r.ringDesc)
+	// The tokens of the ring are denormalised, and sorted, by the ring.
+	tokensByIngester := map[string][]uint32{}
+	for _, token := range r.ringDesc.Tokens {
+		tokensByIngester[token.Ingester] = append(tokensByIngester[token.Ingester], token.Token)
+	}
+
+	now := time.Now()
+	ingesters := []ingesterStatus{}
+	_, owned := countTokens(r.ringDesc)
 	for _, id := range ingesterIDs {
 		ing := r.ringDesc.Ingesters[id]
 		timestamp := time.Unix(ing.Timestamp, 0)
@@ -115,30 +213,35 @@ func (r *Ring) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			state = unhealthy
 		}
 
-		ingesters = append(ingesters, struct {
-			ID, State, Address, Timestamp string
-			Tokens                        uint32
-			Ownership                     float64
-		}{
-			ID:        id,
-			State:     state,
-			Address:   ing.Addr,
-			Timestamp: timestamp.String(),
-			Tokens:    tokens[id],
-			Ownership: (float64(owned[id]) / float64(math.MaxUint32)) * 100,
-		})
+		status := ingesterStatus{
+			ID:                 id,
+			State:              state,
+			Address:            ing.Addr,
+			Zone:               ing.Zone,
+			HeartbeatTimestamp: timestamp.String(),
+			HeartbeatAge:       now.Sub(timestamp).Truncate(time.Second).String(),
+			NumTokens:          len(tokensByIngester[id]),
+			Ownership:          (float64(owned[id]) / float64(math.MaxUint32)) * 100,
+		}
+		if showTokens {
+			status.Tokens = tokensByIngester[id]
+		}
+		ingesters = append(ingesters, status)
 	}
 
-	if err := tmpl.Execute(w, struct {
-		Ingesters []interface{}
-		Now       time.Time
-		Ring      string
-	}{
-		Ingesters: ingesters,
-		Now:       time.Now(),
-		Ring:      proto.MarshalTextString(r.ringDesc),
-	}); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	status := ringStatus{
+		Name:       r.name,
+		Ingesters:  ingesters,
+		Now:        now,
+		ShowTokens: showTokens,
+	}
+	if r.zonesErr != nil {
+		status.ZonesError = r.zonesErr.Error()
 	}
+	return status
+}
+
+func acceptsJSON(req *http.Request) bool {
+	encodings, found := req.Header["Accept"]
+	return found && len(encodings) > 0 && strings.Contains(encodings[0], "json")
 }
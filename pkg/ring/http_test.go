@@ -0,0 +1,89 @@
+package ring
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestRingStatusPage(t *testing.T) {
+	now := time.Now()
+	desc := NewDesc()
+	desc.AddIngester("ing1", "addr1", "zone-a", []uint32{1, 3}, ACTIVE, true)
+	desc.AddIngester("ing2", "addr2", "zone-b", []uint32{2}, ACTIVE, true)
+	ing2 := desc.Ingesters["ing2"]
+	ing2.Timestamp = now.Add(-time.Hour).Unix()
+	desc.Ingesters["ing2"] = ing2
+	kvClient := consul.NewInMemoryClient(GetCodec())
+	require.NoError(t, kvClient.CAS(context.Background(), ConsulKey, func(interface{}) (interface{}, bool, error) {
+		return desc, true, nil
+	}))
+
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.KVStore.Mock = kvClient
+	r, err := New(cfg, "store-gateway")
+	require.NoError(t, err)
+	defer r.Stop()
+
+	getStatus := func(query string) ringStatus {
+		req := httptest.NewRequest("GET", "/store-gateway/ring"+query, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var status ringStatus
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+		return status
+	}
+	test.Poll(t, 5*time.Second, 2, func() interface{} {
+		return len(getStatus("").Ingesters)
+	})
+
+	status := getStatus("?tokens=true")
+	require.Equal(t, "store-gateway", status.Name)
+	require.Equal(t, "ing1", status.Ingesters[0].ID)
+	require.Equal(t, ACTIVE.String(), status.Ingesters[0].State)
+	require.Equal(t, "zone-a", status.Ingesters[0].Zone)
+	require.Equal(t, []uint32{1, 3}, status.Ingesters[0].Tokens)
+	require.Equal(t, 2, status.Ingesters[0].NumTokens)
+	require.Equal(t, unhealthy, status.Ingesters[1].State)
+	require.True(t, strings.HasPrefix(status.Ingesters[1].HeartbeatAge, "1h0m"), status.Ingesters[1].HeartbeatAge)
+	require.Nil(t, getStatus("").Ingesters[0].Tokens)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/store-gateway/ring?tokens=true", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "<td>zone-b</td>")
+	require.Contains(t, w.Body.String(), "<h2>Instance: ing2</h2>")
+
+	forget := func(id string) int {
+		form := url.Values{}
+		if id != "" {
+			form.Set("forget", id)
+		}
+		req := httptest.NewRequest("POST", "/store-gateway/ring", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, http.StatusBadRequest, forget(""))
+	require.Equal(t, http.StatusNotFound, forget("unknown"))
+	require.Equal(t, http.StatusFound, forget("ing2"))
+	test.Poll(t, 5*time.Second, 1, func() interface{} {
+		return len(getStatus("").Ingesters)
+	})
+	require.Equal(t, "ing1", getStatus("").Ingesters[0].ID)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
 	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
 	"github.com/cortexproject/cortex/pkg/ring/kv/etcd"
+	"github.com/cortexproject/cortex/pkg/ring/kv/memberlist"
 )
 
 // The NewInMemoryKVClient returned by NewClient() is a singleton, so
@@ -18,13 +19,18 @@ var inmemoryStoreInit sync.Once
 var inmemoryStore Client
 
 // Config is config for a KVStore currently used by ring and HA tracker,
-// where store can be consul or inmemory.
+// where store can be consul, etcd, memberlist, multi or inmemory.
 type Config struct {
 	Store  string        `yaml:"store,omitempty"`
 	Consul consul.Config `yaml:"consul,omitempty"`
 	Etcd   etcd.Config   `yaml:"etcd,omitempty"`
+	Multi  MultiConfig   `yaml:"multi,omitempty"`
 	Prefix string        `yaml:"prefix,omitempty"`
 
+	// The memberlist KV store is shared by all the rings of the process,
+	// so it's configured on its own and injected here.
+	MemberlistKV func() (*memberlist.KV, error) `yaml:"-"`
+
 	Mock Client
 }
 
@@ -40,11 +46,12 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	// be easier to have everything under ring, so ring.consul.<flag-name>
 	cfg.Consul.RegisterFlags(f, prefix)
 	cfg.Etcd.RegisterFlagsWithPrefix(f, prefix)
+	cfg.Multi.RegisterFlagsWithPrefix(f, prefix)
 	if prefix == "" {
 		prefix = "ring."
 	}
 	f.StringVar(&cfg.Prefix, prefix+"prefix", "collectors/", "The prefix for the keys in the store. Should end with a /.")
-	f.StringVar(&cfg.Store, prefix+"store", "consul", "Backend storage to use for the ring (consul, etcd, inmemory).")
+	f.StringVar(&cfg.Store, prefix+"store", "consul", "Backend storage to use for the ring (consul, etcd, memberlist, multi, inmemory).")
 }
 
 // Client is a high-level client for key-value stores (such as Etcd and
@@ -71,22 +78,45 @@ type Client interface {
 	WatchPrefix(ctx context.Context, prefix string, f func(string, interface{}) bool)
 }
 
-// NewClient creates a new Client (consul, etcd or inmemory) based on the config,
+// NewClient creates a new Client (consul, etcd, memberlist, multi or inmemory) based on the config,
 // encodes and decodes data for storage using the codec.
 func NewClient(cfg Config, codec codec.Codec) (Client, error) {
 	if cfg.Mock != nil {
 		return cfg.Mock, nil
 	}
 
-	var client Client
-	var err error
+	client, err := createClient(cfg.Store, cfg, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Prefix != "" {
+		client = PrefixClient(client, cfg.Prefix)
+	}
+
+	return metrics{client}, nil
+}
 
-	switch cfg.Store {
+func createClient(store string, cfg Config, codec codec.Codec) (Client, error) {
+	switch store {
 	case "consul":
-		client, err = consul.NewClient(cfg.Consul, codec)
+		return consul.NewClient(cfg.Consul, codec)
 
 	case "etcd":
-		client, err = etcd.New(cfg.Etcd, codec)
+		return etcd.New(cfg.Etcd, codec)
+
+	case "memberlist":
+		if cfg.MemberlistKV == nil {
+			return nil, fmt.Errorf("memberlist KV store not configured")
+		}
+		kv, err := cfg.MemberlistKV()
+		if err != nil {
+			return nil, err
+		}
+		return memberlist.NewClient(kv, codec)
+
+	case "multi":
+		return buildMultiClient(cfg, codec)
 
 	case "inmemory":
 		// If we use the in-memory store, make sure everyone gets the same instance
@@ -94,19 +124,35 @@ func NewClient(cfg Config, codec codec.Codec) (Client, error) {
 		inmemoryStoreInit.Do(func() {
 			inmemoryStore = consul.NewInMemoryClient(codec)
 		})
-		client = inmemoryStore
+		return inmemoryStore, nil
 
 	default:
-		return nil, fmt.Errorf("invalid KV store type: %s", cfg.Store)
+		return nil, fmt.Errorf("invalid KV store type: %s", store)
+	}
+}
+
+func buildMultiClient(cfg Config, codec codec.Codec) (Client, error) {
+	if cfg.Multi.Primary == "" || cfg.Multi.Secondary == "" {
+		return nil, fmt.Errorf("primary or secondary store of the multi KV store not set")
+	}
+	if cfg.Multi.Primary == cfg.Multi.Secondary {
+		return nil, fmt.Errorf("primary and secondary stores of the multi KV store must be different")
+	}
+	if cfg.Multi.Primary == "multi" || cfg.Multi.Secondary == "multi" {
+		return nil, fmt.Errorf("the multi KV store can't use another multi KV store")
 	}
 
+	primary, err := createClient(cfg.Multi.Primary, cfg, codec)
 	if err != nil {
 		return nil, err
 	}
-
-	if cfg.Prefix != "" {
-		client = PrefixClient(client, cfg.Prefix)
+	secondary, err := createClient(cfg.Multi.Secondary, cfg, codec)
+	if err != nil {
+		return nil, err
 	}
 
-	return metrics{client}, nil
+	return newMultiClient(cfg.Multi, []kvclient{
+		{client: primary, name: cfg.Multi.Primary},
+		{client: secondary, name: cfg.Multi.Secondary},
+	}), nil
 }
@@ -9,13 +9,28 @@ import (
 type Codec interface {
 	Decode([]byte) (interface{}, error)
 	Encode(interface{}) ([]byte, error)
+
+	// CodecID is the ID of the codec, which the gossiping KV stores send
+	// along with the values to find the codec to decode them with.
+	CodecID() string
 }
 
 // Proto is a Codec for proto/snappy
 type Proto struct {
+	id      string
 	Factory func() proto.Message
 }
 
+// NewProtoCodec returns a Proto codec identified by id.
+func NewProtoCodec(id string, factory func() proto.Message) Proto {
+	return Proto{id: id, Factory: factory}
+}
+
+// CodecID implements Codec
+func (p Proto) CodecID() string {
+	return p.id
+}
+
 // Decode implements Codec
 func (p Proto) Decode(bytes []byte) (interface{}, error) {
 	out := p.Factory()
@@ -41,6 +56,11 @@ func (p Proto) Encode(msg interface{}) ([]byte, error) {
 // String is a code for strings.
 type String struct{}
 
+// CodecID implements Codec.
+func (String) CodecID() string {
+	return "string"
+}
+
 // Decode implements Codec.
 func (String) Decode(bytes []byte) (interface{}, error) {
 	return string(bytes), nil
@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-kit/kit/log/level"
 	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/pkg/transport"
 
 	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
 	"github.com/cortexproject/cortex/pkg/util"
@@ -16,9 +17,21 @@ import (
 
 // Config for a new etcd.Client.
 type Config struct {
-	Endpoints   []string      `yaml:"endpoints"`
-	DialTimeout time.Duration `yaml:"dial_timeout"`
-	MaxRetries  int           `yaml:"max_retries"`
+	Endpoints        []string      `yaml:"endpoints"`
+	DialTimeout      time.Duration `yaml:"dial_timeout"`
+	RequestTimeout   time.Duration `yaml:"request_timeout"`
+	MaxRetries       int           `yaml:"max_retries"`
+	AutoSyncInterval time.Duration `yaml:"auto_sync_interval"`
+
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	TLSEnabled            bool   `yaml:"tls_enabled"`
+	TLSCertPath           string `yaml:"tls_cert_path"`
+	TLSKeyPath            string `yaml:"tls_key_path"`
+	TLSCAPath             string `yaml:"tls_ca_path"`
+	TLSServerName         string `yaml:"tls_server_name"`
+	TLSInsecureSkipVerify bool   `yaml:"tls_insecure_skip_verify"`
 }
 
 // Client implements ring.KVClient for etcd.
@@ -33,15 +46,57 @@ func (cfg *Config) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
 	cfg.Endpoints = []string{}
 	f.Var((*flagext.Strings)(&cfg.Endpoints), prefix+"etcd.endpoints", "The etcd endpoints to connect to.")
 	f.DurationVar(&cfg.DialTimeout, prefix+"etcd.dial-timeout", 10*time.Second, "The dial timeout for the etcd connection.")
+	f.DurationVar(&cfg.RequestTimeout, prefix+"etcd.request-timeout", 10*time.Second, "The timeout of each request to etcd, 0 to disable.")
 	f.IntVar(&cfg.MaxRetries, prefix+"etcd.max-retries", 10, "The maximum number of retries to do for failed ops.")
+	f.DurationVar(&cfg.AutoSyncInterval, prefix+"etcd.auto-sync-interval", 0, "The interval to update the endpoints with the members of the etcd cluster, 0 to disable.")
+	f.StringVar(&cfg.Username, prefix+"etcd.username", "", "The username to authenticate to etcd with.")
+	f.StringVar(&cfg.Password, prefix+"etcd.password", "", "The password to authenticate to etcd with.")
+	f.BoolVar(&cfg.TLSEnabled, prefix+"etcd.tls-enabled", false, "Connect to etcd with TLS.")
+	f.StringVar(&cfg.TLSCertPath, prefix+"etcd.tls-cert-path", "", "The client certificate to connect to etcd with, for mutual TLS.")
+	f.StringVar(&cfg.TLSKeyPath, prefix+"etcd.tls-key-path", "", "The key of the client certificate.")
+	f.StringVar(&cfg.TLSCAPath, prefix+"etcd.tls-ca-path", "", "The CA to verify the certificates of etcd with, instead of the system CAs.")
+	f.StringVar(&cfg.TLSServerName, prefix+"etcd.tls-server-name", "", "The name to verify the certificates of etcd with, instead of their hostnames.")
+	f.BoolVar(&cfg.TLSInsecureSkipVerify, prefix+"etcd.tls-insecure-skip-verify", false, "Skip the verification of the certificates of etcd.")
+}
+
+// clientConfig returns the config of the etcd client of cfg.
+func (cfg *Config) clientConfig() (clientv3.Config, error) {
+	clientCfg := clientv3.Config{
+		Endpoints:        cfg.Endpoints,
+		DialTimeout:      cfg.DialTimeout,
+		AutoSyncInterval: cfg.AutoSyncInterval,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+	}
+
+	if cfg.TLSEnabled {
+		if (cfg.TLSCertPath == "") != (cfg.TLSKeyPath == "") {
+			return clientv3.Config{}, fmt.Errorf("both the certificate and the key must be set for the etcd client certificate")
+		}
+		tlsInfo := transport.TLSInfo{
+			CertFile:           cfg.TLSCertPath,
+			KeyFile:            cfg.TLSKeyPath,
+			TrustedCAFile:      cfg.TLSCAPath,
+			ServerName:         cfg.TLSServerName,
+			InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+		}
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return clientv3.Config{}, fmt.Errorf("failed to load the etcd TLS config: %v", err)
+		}
+		clientCfg.TLS = tlsConfig
+	}
+
+	return clientCfg, nil
 }
 
 // New makes a new Client.
 func New(cfg Config, codec codec.Codec) (*Client, error) {
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   cfg.Endpoints,
-		DialTimeout: cfg.DialTimeout,
-	})
+	clientCfg, err := cfg.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientv3.New(clientCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -53,13 +108,23 @@ func New(cfg Config, codec codec.Codec) (*Client, error) {
 	}, nil
 }
 
+// withRequestTimeout bounds a request to etcd by the request timeout, if any.
+func (c *Client) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.cfg.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.cfg.RequestTimeout)
+}
+
 // CAS implements kv.Client.
 func (c *Client) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
 	var revision int64
 	var lastErr error
 
 	for i := 0; i < c.cfg.MaxRetries; i++ {
-		resp, err := c.cli.Get(ctx, key)
+		getCtx, cancel := c.withRequestTimeout(ctx)
+		resp, err := c.cli.Get(getCtx, key)
+		cancel()
 		if err != nil {
 			level.Error(util.Logger).Log("msg", "error getting key", "key", key, "err", err)
 			lastErr = err
@@ -99,10 +164,12 @@ func (c *Client) CAS(ctx context.Context, key string, f func(in interface{}) (ou
 			continue
 		}
 
-		result, err := c.cli.Txn(ctx).
+		txnCtx, cancel := c.withRequestTimeout(ctx)
+		result, err := c.cli.Txn(txnCtx).
 			If(clientv3.Compare(clientv3.Version(key), "=", revision)).
 			Then(clientv3.OpPut(key, string(buf))).
 			Commit()
+		cancel()
 		if err != nil {
 			level.Error(util.Logger).Log("msg", "error CASing", "key", key, "err", err)
 			lastErr = err
@@ -185,6 +252,9 @@ func (c *Client) WatchPrefix(ctx context.Context, key string, f func(string, int
 
 // Get implements kv.Client.
 func (c *Client) Get(ctx context.Context, key string) (interface{}, error) {
+	ctx, cancel := c.withRequestTimeout(ctx)
+	defer cancel()
+
 	resp, err := c.cli.Get(ctx, key)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,115 @@
+package etcd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/auth/authpb"
+	"go.etcd.io/etcd/embed"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+	"go.etcd.io/etcd/pkg/transport"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func TestClientTLSAndAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	test.WriteTestCertificates(t, dir)
+
+	// An etcd requiring the client certificates and a password.
+	etcdCfg := embed.NewConfig()
+	etcdCfg.Dir = filepath.Join(dir, "data")
+	lpurl, _ := url.Parse("http://localhost:0")
+	lcurl, _ := url.Parse("https://localhost:0")
+	etcdCfg.LPUrls = []url.URL{*lpurl}
+	etcdCfg.LCUrls = []url.URL{*lcurl}
+	etcdCfg.ClientTLSInfo = transport.TLSInfo{
+		CertFile:       filepath.Join(dir, "cert.pem"),
+		KeyFile:        filepath.Join(dir, "key.pem"),
+		TrustedCAFile:  filepath.Join(dir, "ca.pem"),
+		ClientCertAuth: true,
+	}
+	server, err := embed.StartEtcd(etcdCfg)
+	require.NoError(t, err)
+	defer server.Close()
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(etcdStartTimeout):
+		t.Fatal("etcd took too long to start")
+	}
+
+	ctx := context.Background()
+	_, err = server.Server.UserAdd(ctx, &pb.AuthUserAddRequest{Name: "root", Password: "secret", Options: &authpb.UserAddOptions{}})
+	require.NoError(t, err)
+	_, err = server.Server.UserGrantRole(ctx, &pb.AuthUserGrantRoleRequest{User: "root", Role: "root"})
+	require.NoError(t, err)
+	_, err = server.Server.AuthEnable(ctx, &pb.AuthEnableRequest{})
+	require.NoError(t, err)
+
+	newConfig := func() Config {
+		var cfg Config
+		flagext.DefaultValues(&cfg)
+		cfg.Endpoints = []string{server.Clients[0].Addr().String()}
+		cfg.DialTimeout = time.Second
+		cfg.TLSEnabled = true
+		cfg.TLSCertPath = filepath.Join(dir, "cert.pem")
+		cfg.TLSKeyPath = filepath.Join(dir, "key.pem")
+		cfg.TLSCAPath = filepath.Join(dir, "ca.pem")
+		cfg.Username = "root"
+		cfg.Password = "secret"
+		return cfg
+	}
+
+	client, err := New(newConfig(), codec.String{})
+	require.NoError(t, err)
+	require.NoError(t, client.CAS(ctx, "key", func(in interface{}) (interface{}, bool, error) {
+		return "value", true, nil
+	}))
+	value, err := client.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, "value", value)
+
+	// Without the client certificate, or with the wrong password, the
+	// client can't connect.
+	cfg := newConfig()
+	cfg.TLSCertPath, cfg.TLSKeyPath = "", ""
+	_, err = New(cfg, codec.String{})
+	require.Error(t, err)
+
+	cfg = newConfig()
+	cfg.Password = "wrong"
+	_, err = New(cfg, codec.String{})
+	require.Error(t, err)
+}
+
+func TestClientConfig(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.AutoSyncInterval = time.Minute
+	cfg.TLSEnabled = true
+	cfg.TLSServerName = "etcd"
+
+	clientCfg, err := cfg.clientConfig()
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, clientCfg.AutoSyncInterval)
+	require.Equal(t, "etcd", clientCfg.TLS.ServerName)
+
+	cfg.TLSCertPath = "cert.pem"
+	_, err = cfg.clientConfig()
+	require.Error(t, err)
+
+	cfg.TLSCertPath = ""
+	cfg.TLSCAPath = "missing.pem"
+	_, err = cfg.clientConfig()
+	require.Error(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -24,6 +25,12 @@ func withFixtures(t *testing.T, f func(*testing.T, Client)) {
 		{"etcd", func() (Client, io.Closer, error) {
 			return etcd.Mock(codec.String{})
 		}},
+		{"multi", func() (Client, io.Closer, error) {
+			return newMultiClient(MultiConfig{MirrorEnabled: true, MirrorTimeout: time.Second}, []kvclient{
+				{client: consul.NewInMemoryClient(codec.String{}), name: "consul"},
+				{client: consul.NewInMemoryClient(codec.String{}), name: "inmemory"},
+			}), etcd.NopCloser, nil
+		}},
 	} {
 		t.Run(fixture.name, func(t *testing.T) {
 			client, closer, err := fixture.factory()
@@ -0,0 +1,44 @@
+package memberlist
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+)
+
+// Client is a kv.Client of the values of a codec in the memberlist KV store.
+type Client struct {
+	kv    *KV
+	codec codec.Codec
+}
+
+// NewClient returns a client of the KV store, registering the codec so that
+// the values gossiped for it are merged.
+func NewClient(kv *KV, codec codec.Codec) (*Client, error) {
+	if codec.CodecID() == "" {
+		return nil, fmt.Errorf("the memberlist KV store requires codecs with an ID")
+	}
+	kv.registerCodec(codec)
+	return &Client{kv: kv, codec: codec}, nil
+}
+
+// Get implements kv.Client.
+func (c *Client) Get(ctx context.Context, key string) (interface{}, error) {
+	return c.kv.Get(key, c.codec)
+}
+
+// CAS implements kv.Client.
+func (c *Client) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	return c.kv.CAS(ctx, key, c.codec, f)
+}
+
+// WatchKey implements kv.Client.
+func (c *Client) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
+	c.kv.WatchKey(ctx, key, c.codec, f)
+}
+
+// WatchPrefix implements kv.Client.
+func (c *Client) WatchPrefix(ctx context.Context, prefix string, f func(string, interface{}) bool) {
+	c.kv.WatchPrefix(ctx, prefix, c.codec, f)
+}
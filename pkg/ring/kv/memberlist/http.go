@@ -0,0 +1,128 @@
+package memberlist
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const statusTpl = `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>Cortex Memberlist Status</title>
+	</head>
+	<body>
+		<h1>Cortex Memberlist Status</h1>
+		<p>Current time: {{ .Now }}</p>
+		<p>Node: {{ .Node }}</p>
+		<h2>Members</h2>
+		<table border="1">
+			<thead>
+				<tr>
+					<th>Name</th>
+					<th>Address</th>
+				</tr>
+			</thead>
+			<tbody>
+				{{ range .Members }}
+				<tr>
+					<td>{{ .Name }}</td>
+					<td>{{ .Address }}</td>
+				</tr>
+				{{ end }}
+			</tbody>
+		</table>
+		<h2>Store</h2>
+		<table border="1">
+			<thead>
+				<tr>
+					<th>Key</th>
+					<th>Codec</th>
+					<th>Version</th>
+					<th>Size</th>
+				</tr>
+			</thead>
+			<tbody>
+				{{ range .Keys }}
+				<tr>
+					<td>{{ .Key }}</td>
+					<td>{{ .Codec }}</td>
+					<td align='right'>{{ .Version }}</td>
+					<td align='right'>{{ .Size }}</td>
+				</tr>
+				{{ end }}
+			</tbody>
+		</table>
+	</body>
+</html>`
+
+var statusTmpl = template.Must(template.New("webpage").Parse(statusTpl))
+
+// MemberStatus is a member of the cluster shown by the status page.
+type MemberStatus struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// KeyStatus is a value of the store shown by the status page.
+type KeyStatus struct {
+	Key     string `json:"key"`
+	Codec   string `json:"codec"`
+	Version uint   `json:"version"`
+	Size    int    `json:"size"`
+}
+
+// Status is the status of the store and of its cluster.
+type Status struct {
+	Now     time.Time      `json:"now"`
+	Node    string         `json:"node"`
+	Members []MemberStatus `json:"members"`
+	Keys    []KeyStatus    `json:"keys"`
+}
+
+// Status returns the status of the store and of its cluster.
+func (m *KV) Status() Status {
+	status := Status{
+		Now:  time.Now(),
+		Node: m.memberlist.LocalNode().Name,
+	}
+	for _, n := range m.memberlist.Members() {
+		status.Members = append(status.Members, MemberStatus{Name: n.Name, Address: n.Address()})
+	}
+	sort.Slice(status.Members, func(i, j int) bool { return status.Members[i].Name < status.Members[j].Name })
+
+	m.storeMtx.Lock()
+	for key, v := range m.store {
+		status.Keys = append(status.Keys, KeyStatus{Key: key, Codec: v.codecID, Version: v.version, Size: len(v.value)})
+	}
+	m.storeMtx.Unlock()
+	sort.Slice(status.Keys, func(i, j int) bool { return status.Keys[i].Key < status.Keys[j].Key })
+	return status
+}
+
+// ServeHTTP shows the members of the cluster and the values of the store.
+func (i *KVInit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kv := i.getKV()
+	if kv == nil {
+		http.Error(w, "The memberlist KV store isn't used by this process.", http.StatusNotFound)
+		return
+	}
+	status := kv.Status()
+
+	if encodings, found := r.Header["Accept"]; found &&
+		len(encodings) > 0 && strings.Contains(encodings[0], "json") {
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := statusTmpl.Execute(w, status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,763 @@
+package memberlist
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+const (
+	maxCasRetries = 10
+
+	// The TCP transport has no limit on the size of the packets, so the
+	// broadcasts of the changes are only limited to keep the gossip cheap.
+	maxPacketSize = 1 << 20
+)
+
+var (
+	receivedBroadcasts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "memberlist_received_broadcasts_total",
+		Help:      "Total number of changes received from the members of the memberlist cluster.",
+	})
+	invalidBroadcasts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "memberlist_received_broadcasts_invalid_total",
+		Help:      "Total number of changes received from the members of the memberlist cluster which couldn't be decoded or merged.",
+	})
+	queuedBroadcasts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "memberlist_queued_broadcasts_total",
+		Help:      "Total number of changes queued to be gossiped to the members of the memberlist cluster.",
+	})
+	remoteStateMerges = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "memberlist_remote_state_merges_total",
+		Help:      "Total number of full states pulled from the members of the memberlist cluster and merged.",
+	})
+)
+
+var (
+	errVersionMismatch = errors.New("the value changed since it was read")
+	errStopped         = errors.New("the memberlist KV store was stopped")
+)
+
+// Mergeable is a value which can be stored in the memberlist KV store. As the
+// members of the cluster receive the changes in any order, and may receive
+// them several times, merging must be commutative and idempotent.
+type Mergeable interface {
+	// Merge merges other into this value, and returns the changed part of
+	// this value, or nil if it didn't change. On a local CAS, other is the
+	// value updated by the CAS, whose missing parts were removed by it.
+	Merge(other Mergeable, localCAS bool) (change Mergeable, err error)
+
+	// MergeContent returns the IDs of the parts of the value, so that the
+	// broadcasts of the changes of the same parts replace the older ones.
+	MergeContent() []string
+
+	// RemoveTombstones removes the markers of the removed parts of the value
+	// which are older than limit.
+	RemoveTombstones(limit time.Time)
+}
+
+// KVConfig is the config of the memberlist KV store. There's one store per
+// process, shared by all its rings.
+type KVConfig struct {
+	NodeName             string              `yaml:"node_name"`
+	StreamTimeout        time.Duration       `yaml:"stream_timeout"`
+	RetransmitMult       int                 `yaml:"retransmit_factor"`
+	PushPullInterval     time.Duration       `yaml:"pull_push_interval"`
+	GossipInterval       time.Duration       `yaml:"gossip_interval"`
+	GossipNodes          int                 `yaml:"gossip_nodes"`
+	JoinMembers          flagext.StringSlice `yaml:"join_members"`
+	JoinBackoff          util.BackoffConfig  `yaml:"join_backoff"`
+	AbortIfJoinFails     bool                `yaml:"abort_if_cluster_join_fails"`
+	LeftIngestersTimeout time.Duration       `yaml:"left_ingesters_timeout"`
+	LeaveTimeout         time.Duration       `yaml:"leave_timeout"`
+	AdvertiseAddr        string              `yaml:"advertise_addr"`
+	AdvertisePort        int                 `yaml:"advertise_port"`
+
+	TCPTransport TCPTransportConfig `yaml:",inline"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *KVConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.NodeName, "memberlist.nodename", "", "Name of the node in the memberlist cluster, unique across the cluster. Defaults to the hostname.")
+	f.DurationVar(&cfg.StreamTimeout, "memberlist.stream-timeout", 10*time.Second, "The timeout of the connections streaming the full state to the other members, and of the TCP pings.")
+	f.IntVar(&cfg.RetransmitMult, "memberlist.retransmit-factor", 4, "Multiplier of the number of times the changes are gossiped, scaled by the log of the number of members.")
+	f.DurationVar(&cfg.PushPullInterval, "memberlist.pullpush-interval", 30*time.Second, "How often the full state is exchanged with a random member.")
+	f.DurationVar(&cfg.GossipInterval, "memberlist.gossip-interval", 200*time.Millisecond, "How often the queued changes are gossiped.")
+	f.IntVar(&cfg.GossipNodes, "memberlist.gossip-nodes", 3, "How many members the queued changes are gossiped to, each gossip interval.")
+	f.Var(&cfg.JoinMembers, "memberlist.join", "Address of a member of the cluster to join on startup, as host or host:port. May be repeated, the hosts may resolve to several members.")
+	cfg.JoinBackoff.RegisterFlags("memberlist.join", f)
+	f.BoolVar(&cfg.AbortIfJoinFails, "memberlist.abort-if-join-fails", true, "Fail the startup if the cluster can't be joined. Otherwise, the cluster is joined in the background.")
+	f.DurationVar(&cfg.LeftIngestersTimeout, "memberlist.left-ingesters-timeout", 5*time.Minute, "How long the tombstones of the instances removed from the rings are kept, so that all the members remove them. 0 keeps them forever.")
+	f.DurationVar(&cfg.LeaveTimeout, "memberlist.leave-timeout", 5*time.Second, "How long the last changes are gossiped, and the other members are told about leaving the cluster, on shutdown.")
+	f.StringVar(&cfg.AdvertiseAddr, "memberlist.advertise-addr", "", "The IP address advertised to the other members. Defaults to the bind address, or to a private IP address if bound to all of them.")
+	f.IntVar(&cfg.AdvertisePort, "memberlist.advertise-port", 0, "The port advertised to the other members. Defaults to the bind port.")
+	cfg.TCPTransport.RegisterFlags(f)
+}
+
+// valueDesc is a value of the store.
+type valueDesc struct {
+	// The encoded value.
+	value []byte
+
+	// Incremented on each change of the value, to check the CASes.
+	version uint
+
+	codecID string
+}
+
+// KV is a KV store gossiped to the members of a memberlist cluster. The
+// values are merged by each member, so the store is eventually consistent
+// and only supports Mergeable values.
+type KV struct {
+	cfg        KVConfig
+	memberlist *memberlist.Memberlist
+	broadcasts *memberlist.TransmitLimitedQueue
+
+	codecsMtx sync.RWMutex
+	codecs    map[string]codec.Codec
+
+	storeMtx sync.Mutex
+	store    map[string]valueDesc
+
+	watchersMtx    sync.Mutex
+	watchers       map[string][]*watcher
+	prefixWatchers map[string][]*watcher
+
+	numMembersMtx sync.Mutex
+	numMembers    int
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewKV creates the memberlist KV store and joins its cluster.
+func NewKV(cfg KVConfig) (*KV, error) {
+	m := &KV{
+		cfg:            cfg,
+		codecs:         map[string]codec.Codec{},
+		store:          map[string]valueDesc{},
+		watchers:       map[string][]*watcher{},
+		prefixWatchers: map[string][]*watcher{},
+		quit:           make(chan struct{}),
+	}
+	m.broadcasts = &memberlist.TransmitLimitedQueue{
+		NumNodes:       m.getNumMembers,
+		RetransmitMult: cfg.RetransmitMult,
+	}
+
+	transport, err := NewTCPTransport(cfg.TCPTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	mlCfg := memberlist.DefaultLANConfig()
+	if cfg.NodeName != "" {
+		mlCfg.Name = cfg.NodeName
+	}
+	mlCfg.TCPTimeout = cfg.StreamTimeout
+	mlCfg.RetransmitMult = cfg.RetransmitMult
+	mlCfg.PushPullInterval = cfg.PushPullInterval
+	mlCfg.GossipInterval = cfg.GossipInterval
+	mlCfg.GossipNodes = cfg.GossipNodes
+	mlCfg.AdvertiseAddr = cfg.AdvertiseAddr
+	mlCfg.AdvertisePort = cfg.AdvertisePort
+	mlCfg.UDPBufferSize = maxPacketSize
+	mlCfg.Transport = transport
+	mlCfg.Delegate = m
+	mlCfg.Events = m
+	mlCfg.Logger = newMemberlistLogger()
+
+	m.memberlist, err = memberlist.Create(mlCfg)
+	if err != nil {
+		_ = transport.Shutdown()
+		return nil, fmt.Errorf("failed to create memberlist: %v", err)
+	}
+
+	if len(cfg.JoinMembers) > 0 {
+		if cfg.AbortIfJoinFails {
+			if err := m.joinMembers(); err != nil {
+				_ = m.memberlist.Shutdown()
+				return nil, err
+			}
+		} else {
+			m.wg.Add(1)
+			go func() {
+				defer m.wg.Done()
+				if err := m.joinMembers(); err != nil {
+					level.Error(util.Logger).Log("msg", "failed to join the memberlist cluster", "err", err)
+				}
+			}()
+		}
+	}
+
+	if cfg.LeftIngestersTimeout > 0 {
+		m.wg.Add(1)
+		go m.removeTombstonesLoop()
+	}
+	return m, nil
+}
+
+// joinMembers joins the cluster, retrying with backoff until one of the
+// members is reached.
+func (m *KV) joinMembers() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := util.NewBackoff(ctx, m.cfg.JoinBackoff)
+	var err error
+	for backoff.Ongoing() {
+		var n int
+		n, err = m.memberlist.Join(m.cfg.JoinMembers)
+		if err == nil {
+			level.Info(util.Logger).Log("msg", "joined the memberlist cluster", "reached_members", n)
+			return nil
+		}
+		level.Warn(util.Logger).Log("msg", "failed to join the memberlist cluster, retrying", "err", err)
+		backoff.Wait()
+	}
+	return fmt.Errorf("failed to join the memberlist cluster after %d attempts: %v", backoff.NumRetries(), err)
+}
+
+// Stop gossips the last changes, leaves the cluster and shuts the store down.
+func (m *KV) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+
+	// The changes made when stopping, such as the instances leaving their
+	// rings, are gossiped before leaving.
+	deadline := time.Now().Add(m.cfg.LeaveTimeout)
+	for m.broadcasts.NumQueued() > 0 && m.memberlist.NumMembers() > 1 && time.Now().Before(deadline) {
+		time.Sleep(m.cfg.GossipInterval)
+	}
+
+	if err := m.memberlist.Leave(m.cfg.LeaveTimeout); err != nil {
+		level.Warn(util.Logger).Log("msg", "failed to leave the memberlist cluster", "err", err)
+	}
+	if err := m.memberlist.Shutdown(); err != nil {
+		level.Warn(util.Logger).Log("msg", "failed to shut memberlist down", "err", err)
+	}
+}
+
+// GetCodec returns the codec registered with the ID, or nil.
+func (m *KV) GetCodec(id string) codec.Codec {
+	m.codecsMtx.RLock()
+	defer m.codecsMtx.RUnlock()
+	return m.codecs[id]
+}
+
+func (m *KV) registerCodec(c codec.Codec) {
+	m.codecsMtx.Lock()
+	defer m.codecsMtx.Unlock()
+	m.codecs[c.CodecID()] = c
+}
+
+// Get returns the value of the key, decoded by the codec, or nil.
+func (m *KV) Get(key string, codec codec.Codec) (interface{}, error) {
+	val, _, err := m.get(key, codec)
+	return val, err
+}
+
+func (m *KV) get(key string, codec codec.Codec) (interface{}, uint, error) {
+	m.storeMtx.Lock()
+	v, ok := m.store[key]
+	m.storeMtx.Unlock()
+
+	if !ok {
+		return nil, 0, nil
+	}
+	if v.codecID != codec.CodecID() {
+		return nil, 0, fmt.Errorf("the value of key %s has codec %s, not %s", key, v.codecID, codec.CodecID())
+	}
+	out, err := codec.Decode(v.value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, v.version, nil
+}
+
+// CAS updates the value of the key with f, as kv.Client.CAS does. The value
+// returned by f is merged into the stored one, and the changes are gossiped.
+func (m *KV) CAS(ctx context.Context, key string, codec codec.Codec, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	var lastErr error
+	for i := 0; i < maxCasRetries; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		val, version, err := m.get(key, codec)
+		if err != nil {
+			return err
+		}
+
+		out, retry, err := f(val)
+		if err != nil {
+			if !retry {
+				return err
+			}
+			lastErr = err
+			continue
+		}
+
+		// As with the other KV stores, nil means there's nothing to update.
+		if out == nil {
+			return nil
+		}
+		incoming, ok := out.(Mergeable)
+		if !ok {
+			return fmt.Errorf("the memberlist KV store only supports mergeable values, got %T", out)
+		}
+
+		change, newVersion, err := m.mergeValueForKey(key, incoming, version, true, codec)
+		if err == errVersionMismatch {
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if change != nil {
+			m.broadcastChange(key, change, newVersion, codec)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to CAS %s: %v", key, lastErr)
+}
+
+// mergeValueForKey merges incoming into the value of the key, and returns the
+// change and the new version of the value. A local CAS fails if the value
+// changed since the version was read.
+func (m *KV) mergeValueForKey(key string, incoming Mergeable, casVersion uint, localCAS bool, codec codec.Codec) (Mergeable, uint, error) {
+	m.storeMtx.Lock()
+	defer m.storeMtx.Unlock()
+
+	current, found := m.store[key]
+	if localCAS && current.version != casVersion {
+		return nil, 0, errVersionMismatch
+	}
+
+	result, change := incoming, incoming
+	if found {
+		if current.codecID != codec.CodecID() {
+			return nil, 0, fmt.Errorf("the value of key %s has codec %s, not %s", key, current.codecID, codec.CodecID())
+		}
+		decoded, err := codec.Decode(current.value)
+		if err != nil {
+			return nil, 0, err
+		}
+		stored, ok := decoded.(Mergeable)
+		if !ok {
+			return nil, 0, fmt.Errorf("the value of key %s isn't mergeable: %T", key, decoded)
+		}
+		change, err = stored.Merge(incoming, localCAS)
+		if err != nil {
+			return nil, 0, err
+		}
+		result = stored
+	}
+	if change == nil {
+		return nil, current.version, nil
+	}
+
+	encoded, err := codec.Encode(result)
+	if err != nil {
+		return nil, 0, err
+	}
+	newVersion := current.version + 1
+	m.store[key] = valueDesc{
+		value:   encoded,
+		version: newVersion,
+		codecID: codec.CodecID(),
+	}
+	m.notifyWatchers(key)
+	return change, newVersion, nil
+}
+
+// mergeEncodedValueForKey merges a change received from another member.
+func (m *KV) mergeEncodedValueForKey(pair *KeyValuePair) {
+	codec := m.GetCodec(pair.Codec)
+	if codec == nil {
+		// Only the values of the codecs of the rings of this process are kept.
+		level.Debug(util.Logger).Log("msg", "ignoring the value of an unknown codec", "key", pair.Key, "codec", pair.Codec)
+		return
+	}
+	decoded, err := codec.Decode(pair.Value)
+	if err != nil {
+		invalidBroadcasts.Inc()
+		level.Warn(util.Logger).Log("msg", "failed to decode the value received from memberlist", "key", pair.Key, "err", err)
+		return
+	}
+	incoming, ok := decoded.(Mergeable)
+	if !ok {
+		invalidBroadcasts.Inc()
+		level.Warn(util.Logger).Log("msg", "the value received from memberlist isn't mergeable", "key", pair.Key, "type", fmt.Sprintf("%T", decoded))
+		return
+	}
+
+	change, version, err := m.mergeValueForKey(pair.Key, incoming, 0, false, codec)
+	if err != nil {
+		invalidBroadcasts.Inc()
+		level.Warn(util.Logger).Log("msg", "failed to merge the value received from memberlist", "key", pair.Key, "err", err)
+		return
+	}
+	// The changes are gossiped further, until all the members have them.
+	if change != nil {
+		m.broadcastChange(pair.Key, change, version, codec)
+	}
+}
+
+// broadcastChange queues the change of the key to be gossiped.
+func (m *KV) broadcastChange(key string, change Mergeable, version uint, codec codec.Codec) {
+	value, err := codec.Encode(change)
+	if err != nil {
+		level.Error(util.Logger).Log("msg", "failed to encode the change", "key", key, "err", err)
+		return
+	}
+	pair := KeyValuePair{Key: key, Value: value, Codec: codec.CodecID()}
+	msg, err := pair.Marshal()
+	if err != nil {
+		level.Error(util.Logger).Log("msg", "failed to encode the change", "key", key, "err", err)
+		return
+	}
+	if len(msg) > maxPacketSize {
+		// The change gets to the other members by the exchanges of the full state.
+		level.Warn(util.Logger).Log("msg", "change too big to be gossiped", "key", key, "size", len(msg))
+		return
+	}
+
+	queuedBroadcasts.Inc()
+	m.broadcasts.QueueBroadcast(broadcast{
+		key:     key,
+		content: change.MergeContent(),
+		version: version,
+		msg:     msg,
+	})
+}
+
+// removeTombstonesLoop periodically removes the tombstones which were kept
+// for long enough to be gossiped to all the members.
+func (m *KV) removeTombstonesLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.cfg.LeftIngestersTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.removeTombstones(time.Now().Add(-m.cfg.LeftIngestersTimeout))
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+func (m *KV) removeTombstones(limit time.Time) {
+	m.storeMtx.Lock()
+	defer m.storeMtx.Unlock()
+
+	for key, v := range m.store {
+		codec := m.GetCodec(v.codecID)
+		if codec == nil {
+			continue
+		}
+		decoded, err := codec.Decode(v.value)
+		if err != nil {
+			continue
+		}
+		val, ok := decoded.(Mergeable)
+		if !ok {
+			continue
+		}
+		val.RemoveTombstones(limit)
+		encoded, err := codec.Encode(val)
+		if err != nil {
+			level.Warn(util.Logger).Log("msg", "failed to encode the value without its tombstones", "key", key, "err", err)
+			continue
+		}
+		v.value = encoded
+		m.store[key] = v
+	}
+}
+
+// WatchKey calls f with the current value of the key, then on each of its
+// changes, until f returns false or the context is done.
+func (m *KV) WatchKey(ctx context.Context, key string, codec codec.Codec, f func(interface{}) bool) {
+	w := newWatcher()
+	m.watchersMtx.Lock()
+	m.watchers[key] = append(m.watchers[key], w)
+	m.watchersMtx.Unlock()
+	defer m.removeWatcher(m.watchers, key, w)
+
+	w.notify(key)
+	for {
+		select {
+		case <-w.ch:
+			if len(w.changed()) == 0 {
+				continue
+			}
+			val, err := m.Get(key, codec)
+			if err != nil {
+				level.Warn(util.Logger).Log("msg", "failed to get the watched key", "key", key, "err", err)
+				continue
+			}
+			if val == nil {
+				continue
+			}
+			if !f(val) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WatchPrefix calls f with the current values of the keys with the prefix,
+// then on each of their changes, until f returns false or the context is done.
+func (m *KV) WatchPrefix(ctx context.Context, prefix string, codec codec.Codec, f func(string, interface{}) bool) {
+	w := newWatcher()
+	m.watchersMtx.Lock()
+	m.prefixWatchers[prefix] = append(m.prefixWatchers[prefix], w)
+	m.watchersMtx.Unlock()
+	defer m.removeWatcher(m.prefixWatchers, prefix, w)
+
+	for _, key := range m.keys() {
+		if strings.HasPrefix(key, prefix) {
+			w.notify(key)
+		}
+	}
+	for {
+		select {
+		case <-w.ch:
+			for _, key := range w.changed() {
+				val, err := m.Get(key, codec)
+				if err != nil {
+					level.Warn(util.Logger).Log("msg", "failed to get the watched key", "key", key, "err", err)
+					continue
+				}
+				if val == nil {
+					continue
+				}
+				if !f(key, val) {
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *KV) removeWatcher(watchers map[string][]*watcher, key string, w *watcher) {
+	m.watchersMtx.Lock()
+	defer m.watchersMtx.Unlock()
+	ws := watchers[key]
+	for i := range ws {
+		if ws[i] == w {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(watchers, key)
+	} else {
+		watchers[key] = ws
+	}
+}
+
+func (m *KV) notifyWatchers(key string) {
+	m.watchersMtx.Lock()
+	defer m.watchersMtx.Unlock()
+	for _, w := range m.watchers[key] {
+		w.notify(key)
+	}
+	for prefix, ws := range m.prefixWatchers {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for _, w := range ws {
+			w.notify(key)
+		}
+	}
+}
+
+func (m *KV) keys() []string {
+	m.storeMtx.Lock()
+	defer m.storeMtx.Unlock()
+	keys := make([]string, 0, len(m.store))
+	for key := range m.store {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *KV) getNumMembers() int {
+	m.numMembersMtx.Lock()
+	defer m.numMembersMtx.Unlock()
+	return m.numMembers
+}
+
+func (m *KV) addNumMembers(delta int) {
+	m.numMembersMtx.Lock()
+	defer m.numMembersMtx.Unlock()
+	m.numMembers += delta
+}
+
+// NodeMeta implements memberlist.Delegate.
+func (m *KV) NodeMeta(limit int) []byte {
+	return nil
+}
+
+// NotifyMsg implements memberlist.Delegate, merging the changes gossiped by
+// the other members.
+func (m *KV) NotifyMsg(msg []byte) {
+	receivedBroadcasts.Inc()
+	pair := KeyValuePair{}
+	if err := pair.Unmarshal(msg); err != nil {
+		invalidBroadcasts.Inc()
+		level.Warn(util.Logger).Log("msg", "failed to decode the change received from memberlist", "err", err)
+		return
+	}
+	m.mergeEncodedValueForKey(&pair)
+}
+
+// GetBroadcasts implements memberlist.Delegate.
+func (m *KV) GetBroadcasts(overhead, limit int) [][]byte {
+	return m.broadcasts.GetBroadcasts(overhead, limit)
+}
+
+// LocalState implements memberlist.Delegate, returning the full state of the
+// store for the push/pull with another member.
+func (m *KV) LocalState(join bool) []byte {
+	m.storeMtx.Lock()
+	state := KeyValueStore{}
+	for key, v := range m.store {
+		state.Pairs = append(state.Pairs, &KeyValuePair{Key: key, Value: v.value, Codec: v.codecID})
+	}
+	m.storeMtx.Unlock()
+
+	buf, err := state.Marshal()
+	if err != nil {
+		level.Error(util.Logger).Log("msg", "failed to encode the state of the store", "err", err)
+		return nil
+	}
+	return buf
+}
+
+// MergeRemoteState implements memberlist.Delegate, merging the full state of
+// the store of another member.
+func (m *KV) MergeRemoteState(buf []byte, join bool) {
+	remoteStateMerges.Inc()
+	state := KeyValueStore{}
+	if err := state.Unmarshal(buf); err != nil {
+		level.Warn(util.Logger).Log("msg", "failed to decode the state received from memberlist", "err", err)
+		return
+	}
+	for _, pair := range state.Pairs {
+		m.mergeEncodedValueForKey(pair)
+	}
+}
+
+// NotifyJoin implements memberlist.EventDelegate, counting the members
+// including this one.
+func (m *KV) NotifyJoin(*memberlist.Node) { m.addNumMembers(1) }
+
+// NotifyLeave implements memberlist.EventDelegate.
+func (m *KV) NotifyLeave(*memberlist.Node) { m.addNumMembers(-1) }
+
+// NotifyUpdate implements memberlist.EventDelegate.
+func (m *KV) NotifyUpdate(*memberlist.Node) {}
+
+// broadcast is a change of a key, gossiped to the other members.
+type broadcast struct {
+	key     string
+	content []string
+	version uint
+	msg     []byte
+}
+
+// Invalidates implements memberlist.Broadcast: a change replaces the older
+// changes of the same parts of the value.
+func (b broadcast) Invalidates(old memberlist.Broadcast) bool {
+	o, ok := old.(broadcast)
+	if !ok || o.key != b.key || o.version > b.version {
+		return false
+	}
+	content := make(map[string]struct{}, len(b.content))
+	for _, c := range b.content {
+		content[c] = struct{}{}
+	}
+	for _, c := range o.content {
+		if _, ok := content[c]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Message implements memberlist.Broadcast.
+func (b broadcast) Message() []byte { return b.msg }
+
+// Finished implements memberlist.Broadcast.
+func (b broadcast) Finished() {}
+
+// watcher collects the changed keys until they're read.
+type watcher struct {
+	mtx  sync.Mutex
+	keys map[string]struct{}
+	ch   chan struct{}
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		keys: map[string]struct{}{},
+		ch:   make(chan struct{}, 1),
+	}
+}
+
+func (w *watcher) notify(key string) {
+	w.mtx.Lock()
+	w.keys[key] = struct{}{}
+	w.mtx.Unlock()
+
+	select {
+	case w.ch <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) changed() []string {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	keys := make([]string, 0, len(w.keys))
+	for key := range w.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w.keys = map[string]struct{}{}
+	return keys
+}
@@ -0,0 +1,55 @@
+package memberlist
+
+import (
+	"sync"
+)
+
+// KVInit creates the memberlist KV store on its first use, so that the
+// processes whose rings don't use it don't join the cluster.
+type KVInit struct {
+	cfg *KVConfig
+
+	mtx     sync.Mutex
+	kv      *KV
+	err     error
+	stopped bool
+}
+
+// NewKVInit returns a KVInit of the config, which may still be changed until
+// the store is first used.
+func NewKVInit(cfg *KVConfig) *KVInit {
+	return &KVInit{cfg: cfg}
+}
+
+// GetMemberlistKV returns the store, creating it on the first call.
+func (i *KVInit) GetMemberlistKV() (*KV, error) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if i.kv == nil && i.err == nil {
+		if i.stopped {
+			return nil, errStopped
+		}
+		i.kv, i.err = NewKV(*i.cfg)
+	}
+	return i.kv, i.err
+}
+
+// Stop stops the store, if it was created.
+func (i *KVInit) Stop() {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	i.stopped = true
+	if i.kv != nil {
+		i.kv.Stop()
+		i.kv = nil
+		i.err = errStopped
+	}
+}
+
+func (i *KVInit) getKV() *KV {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	return i.kv
+}
@@ -0,0 +1,301 @@
+package memberlist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+// entries is a Mergeable map of the latest timestamps of its entries, a
+// negative timestamp being the tombstone of an entry.
+type entries map[string]int64
+
+func (e entries) Merge(other Mergeable, localCAS bool) (Mergeable, error) {
+	o, ok := other.(entries)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T", other)
+	}
+	change := entries{}
+	for k, v := range o {
+		if abs(v) > abs(e[k]) || (abs(v) == abs(e[k]) && v < e[k]) {
+			e[k] = v
+			change[k] = v
+		}
+	}
+	if localCAS {
+		for k, v := range e {
+			if _, ok := o[k]; !ok && v > 0 {
+				e[k] = -v
+				change[k] = -v
+			}
+		}
+	}
+	if len(change) == 0 {
+		return nil, nil
+	}
+	return change, nil
+}
+
+func (e entries) MergeContent() []string {
+	var result []string
+	for k := range e {
+		result = append(result, k)
+	}
+	return result
+}
+
+func (e entries) RemoveTombstones(limit time.Time) {
+	for k, v := range e {
+		if v < 0 && (limit.IsZero() || -v < limit.Unix()) {
+			delete(e, k)
+		}
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+type entriesCodec struct{}
+
+func (entriesCodec) CodecID() string { return "entries" }
+
+func (entriesCodec) Decode(buf []byte) (interface{}, error) {
+	e := entries{}
+	return e, json.Unmarshal(buf, &e)
+}
+
+func (entriesCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func testKVConfig(name string, join ...string) KVConfig {
+	var cfg KVConfig
+	flagext.DefaultValues(&cfg)
+	cfg.NodeName = name
+	cfg.TCPTransport.BindAddr = "127.0.0.1"
+	cfg.TCPTransport.BindPort = 0
+	cfg.GossipInterval = 10 * time.Millisecond
+	cfg.PushPullInterval = time.Second
+	cfg.LeaveTimeout = time.Second
+	cfg.JoinMembers = join
+	return cfg
+}
+
+func startKV(t *testing.T, cfg KVConfig) (*KV, *Client) {
+	kv, err := NewKV(cfg)
+	require.NoError(t, err)
+	client, err := NewClient(kv, entriesCodec{})
+	require.NoError(t, err)
+	return kv, client
+}
+
+func kvAddr(kv *KV) string {
+	return kv.memberlist.LocalNode().Address()
+}
+
+func setEntry(t *testing.T, c *Client, key, entry string, ts int64) {
+	require.NoError(t, c.CAS(context.Background(), key, func(in interface{}) (interface{}, bool, error) {
+		e, _ := in.(entries)
+		if e == nil {
+			e = entries{}
+		}
+		e[entry] = ts
+		return e, true, nil
+	}))
+}
+
+func getEntries(t *testing.T, c *Client, key string) interface{} {
+	v, err := c.Get(context.Background(), key)
+	require.NoError(t, err)
+	if v == nil {
+		return entries(nil)
+	}
+	return v
+}
+
+func TestKVGossip(t *testing.T) {
+	kv1, c1 := startKV(t, testKVConfig("kv1"))
+	defer kv1.Stop()
+	kv2, c2 := startKV(t, testKVConfig("kv2", kvAddr(kv1)))
+	defer kv2.Stop()
+
+	// The changes are gossiped, and merged.
+	setEntry(t, c1, "key", "a", 1)
+	test.Poll(t, 5*time.Second, entries{"a": 1}, func() interface{} {
+		return getEntries(t, c2, "key")
+	})
+	setEntry(t, c2, "key", "b", 2)
+	test.Poll(t, 5*time.Second, entries{"a": 1, "b": 2}, func() interface{} {
+		return getEntries(t, c1, "key")
+	})
+
+	// The members joining later pull the full state.
+	kv3, c3 := startKV(t, testKVConfig("kv3", kvAddr(kv2)))
+	defer kv3.Stop()
+	test.Poll(t, 5*time.Second, entries{"a": 1, "b": 2}, func() interface{} {
+		return getEntries(t, c3, "key")
+	})
+	test.Poll(t, 5*time.Second, 3, func() interface{} {
+		return len(kv1.memberlist.Members())
+	})
+
+	// The entries removed by a CAS are removed everywhere.
+	require.NoError(t, c3.CAS(context.Background(), "key", func(in interface{}) (interface{}, bool, error) {
+		e := in.(entries)
+		delete(e, "a")
+		return e, true, nil
+	}))
+	test.Poll(t, 5*time.Second, entries{"a": -1, "b": 2}, func() interface{} {
+		return getEntries(t, c1, "key")
+	})
+	kv1.removeTombstones(time.Time{})
+	require.Equal(t, entries{"b": 2}, getEntries(t, c1, "key"))
+
+	// Nil returned by the CAS callback doesn't update the value.
+	require.NoError(t, c1.CAS(context.Background(), "key", func(in interface{}) (interface{}, bool, error) {
+		return nil, false, nil
+	}))
+	require.Error(t, c1.CAS(context.Background(), "key", func(in interface{}) (interface{}, bool, error) {
+		return "not mergeable", false, nil
+	}))
+}
+
+func TestKVWatch(t *testing.T) {
+	kv1, c1 := startKV(t, testKVConfig("kv1"))
+	defer kv1.Stop()
+	kv2, c2 := startKV(t, testKVConfig("kv2", kvAddr(kv1)))
+	defer kv2.Stop()
+
+	setEntry(t, c1, "prefix/key1", "a", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values := make(chan interface{}, 10)
+	go c2.WatchKey(ctx, "prefix/key1", func(v interface{}) bool {
+		values <- v
+		return true
+	})
+	keys := make(chan string, 10)
+	go c2.WatchPrefix(ctx, "prefix/", func(key string, v interface{}) bool {
+		keys <- key
+		return true
+	})
+
+	// The watchers get the current values, then the changes.
+	require.Equal(t, entries{"a": 1}, waitValue(t, values))
+	require.Equal(t, "prefix/key1", waitValue(t, keys))
+	setEntry(t, c1, "prefix/key1", "b", 2)
+	require.Equal(t, entries{"a": 1, "b": 2}, waitValue(t, values))
+	require.Equal(t, "prefix/key1", waitValue(t, keys))
+	setEntry(t, c1, "prefix/key2", "a", 1)
+	setEntry(t, c1, "other", "a", 1)
+	require.Equal(t, "prefix/key2", waitValue(t, keys))
+
+	select {
+	case key := <-keys:
+		t.Fatalf("unexpected change of %s", key)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func waitValue(t *testing.T, ch interface{}) interface{} {
+	timeout := time.After(5 * time.Second)
+	switch ch := ch.(type) {
+	case chan interface{}:
+		select {
+		case v := <-ch:
+			return v
+		case <-timeout:
+		}
+	case chan string:
+		select {
+		case v := <-ch:
+			return v
+		case <-timeout:
+		}
+	}
+	t.Fatal("timed out waiting for the watcher")
+	return nil
+}
+
+func TestKVTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memberlist-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	test.WriteTestCertificates(t, dir)
+
+	tlsConfig := func(cfg KVConfig) KVConfig {
+		cfg.TCPTransport.TLSEnabled = true
+		cfg.TCPTransport.TLSCertPath = filepath.Join(dir, "cert.pem")
+		cfg.TCPTransport.TLSKeyPath = filepath.Join(dir, "key.pem")
+		cfg.TCPTransport.TLSCAPath = filepath.Join(dir, "ca.pem")
+		return cfg
+	}
+	kv1, c1 := startKV(t, tlsConfig(testKVConfig("kv1")))
+	defer kv1.Stop()
+	kv2, c2 := startKV(t, tlsConfig(testKVConfig("kv2", kvAddr(kv1))))
+	defer kv2.Stop()
+
+	setEntry(t, c1, "key", "a", 1)
+	test.Poll(t, 5*time.Second, entries{"a": 1}, func() interface{} {
+		return getEntries(t, c2, "key")
+	})
+
+	// The members without TLS can't join.
+	cfg := testKVConfig("kv3", kvAddr(kv1))
+	cfg.JoinBackoff.MaxRetries = 1
+	_, err = NewKV(cfg)
+	require.Error(t, err)
+}
+
+func TestKVStatus(t *testing.T) {
+	cfg := testKVConfig("kv1")
+	init := NewKVInit(&cfg)
+	defer init.Stop()
+
+	w := httptest.NewRecorder()
+	init.ServeHTTP(w, httptest.NewRequest("GET", "/memberlist", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	kv, err := init.GetMemberlistKV()
+	require.NoError(t, err)
+	client, err := NewClient(kv, entriesCodec{})
+	require.NoError(t, err)
+	setEntry(t, client, "key", "a", 1)
+
+	req := httptest.NewRequest("GET", "/memberlist", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	init.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var status Status
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	require.Equal(t, "kv1", status.Node)
+	require.Equal(t, []MemberStatus{{Name: "kv1", Address: kvAddr(kv)}}, status.Members)
+	require.Equal(t, []KeyStatus{{Key: "key", Codec: "entries", Version: 1, Size: len(`{"a":1}`)}}, status.Keys)
+
+	w = httptest.NewRecorder()
+	init.ServeHTTP(w, httptest.NewRequest("GET", "/memberlist", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "<td>kv1</td>")
+
+	init.Stop()
+	_, err = init.GetMemberlistKV()
+	require.Error(t, err)
+}
@@ -0,0 +1,389 @@
+package memberlist
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	sockaddr "github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// The first byte of the connections tells whether they send a packet or open
+// a stream.
+const (
+	packetConn byte = iota + 1
+	streamConn
+)
+
+var (
+	sentPackets = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "memberlist_tcp_transport_packets_sent_total",
+		Help:      "Total number of packets sent to the members of the memberlist cluster.",
+	})
+	sentPacketErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "memberlist_tcp_transport_packets_sent_errors_total",
+		Help:      "Total number of packets which couldn't be sent to the members of the memberlist cluster.",
+	})
+	receivedPackets = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "memberlist_tcp_transport_packets_received_total",
+		Help:      "Total number of packets received from the members of the memberlist cluster.",
+	})
+)
+
+// TCPTransportConfig is the config of the TCP transport of memberlist.
+type TCPTransportConfig struct {
+	BindAddr           string        `yaml:"bind_addr"`
+	BindPort           int           `yaml:"bind_port"`
+	PacketDialTimeout  time.Duration `yaml:"packet_dial_timeout"`
+	PacketWriteTimeout time.Duration `yaml:"packet_write_timeout"`
+
+	TLSEnabled            bool   `yaml:"tls_enabled"`
+	TLSCertPath           string `yaml:"tls_cert_path"`
+	TLSKeyPath            string `yaml:"tls_key_path"`
+	TLSCAPath             string `yaml:"tls_ca_path"`
+	TLSServerName         string `yaml:"tls_server_name"`
+	TLSInsecureSkipVerify bool   `yaml:"tls_insecure_skip_verify"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *TCPTransportConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.BindAddr, "memberlist.bind-addr", "0.0.0.0", "IP address to listen on for the connections of the other members.")
+	f.IntVar(&cfg.BindPort, "memberlist.bind-port", 7946, "Port to listen on for the connections of the other members.")
+	f.DurationVar(&cfg.PacketDialTimeout, "memberlist.packet-dial-timeout", 5*time.Second, "Timeout of the connections sending the gossiped packets.")
+	f.DurationVar(&cfg.PacketWriteTimeout, "memberlist.packet-write-timeout", 5*time.Second, "Timeout of the writes of the gossiped packets.")
+	f.BoolVar(&cfg.TLSEnabled, "memberlist.tls-enabled", false, "Encrypt the connections between the members with TLS. Each member is both a server and a client, with the same certificate.")
+	f.StringVar(&cfg.TLSCertPath, "memberlist.tls-cert-path", "", "Path to the certificate of the member.")
+	f.StringVar(&cfg.TLSKeyPath, "memberlist.tls-key-path", "", "Path to the key of the certificate of the member.")
+	f.StringVar(&cfg.TLSCAPath, "memberlist.tls-ca-path", "", "Path to the CA certificates verifying the certificates of the other members, as servers and as clients. Defaults to the system CAs, without verifying the clients.")
+	f.StringVar(&cfg.TLSServerName, "memberlist.tls-server-name", "", "Name verified in the certificates of the other members. Defaults to their addresses.")
+	f.BoolVar(&cfg.TLSInsecureSkipVerify, "memberlist.tls-insecure-skip-verify", false, "Skip validating the certificates of the other members.")
+}
+
+func (cfg *TCPTransportConfig) tlsConfig() (*tls.Config, error) {
+	if !cfg.TLSEnabled {
+		return nil, nil
+	}
+	if cfg.TLSCertPath == "" || cfg.TLSKeyPath == "" {
+		return nil, fmt.Errorf("the memberlist TLS certificate and key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertPath, cfg.TLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the memberlist TLS certificate: %v", err)
+	}
+	c := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+	}
+	if cfg.TLSCAPath != "" {
+		buf, err := ioutil.ReadFile(cfg.TLSCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the memberlist TLS CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificate found in the memberlist TLS CA %s", cfg.TLSCAPath)
+		}
+		c.RootCAs = pool
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// TCPTransport is a memberlist.Transport sending both the packets and the
+// streams over TCP, optionally with TLS, instead of sending the packets over
+// UDP which can't be encrypted. Each packet is sent with its own connection.
+type TCPTransport struct {
+	cfg       TCPTransportConfig
+	tlsConfig *tls.Config
+	listener  net.Listener
+
+	packetCh chan *memberlist.Packet
+	streamCh chan net.Conn
+
+	advertiseMtx  sync.RWMutex
+	advertiseAddr string
+
+	shutdownOnce sync.Once
+	shutdown     chan struct{}
+	wg           sync.WaitGroup
+}
+
+// NewTCPTransport listens on the bind address of the config.
+func NewTCPTransport(cfg TCPTransportConfig) (*TCPTransport, error) {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(cfg.BindAddr, strconv.Itoa(cfg.BindPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for memberlist: %v", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	t := &TCPTransport{
+		cfg:       cfg,
+		tlsConfig: tlsConfig,
+		listener:  listener,
+		packetCh:  make(chan *memberlist.Packet),
+		streamCh:  make(chan net.Conn),
+		shutdown:  make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.acceptLoop()
+	return t, nil
+}
+
+// Port returns the port the transport listens on.
+func (t *TCPTransport) Port() int {
+	return t.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (t *TCPTransport) acceptLoop() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			select {
+			case <-t.shutdown:
+				return
+			default:
+			}
+			level.Warn(util.Logger).Log("msg", "memberlist failed to accept a connection", "err", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go t.handleConnection(conn)
+	}
+}
+
+func (t *TCPTransport) handleConnection(conn net.Conn) {
+	keepOpen := false
+	defer func() {
+		if !keepOpen {
+			_ = conn.Close()
+		}
+	}()
+
+	// The TLS handshake is made by the first read.
+	_ = conn.SetReadDeadline(time.Now().Add(t.cfg.PacketWriteTimeout))
+	header := []byte{0}
+	if _, err := io.ReadFull(conn, header); err != nil {
+		level.Debug(util.Logger).Log("msg", "memberlist failed to read the type of a connection", "remote", conn.RemoteAddr(), "err", err)
+		return
+	}
+
+	switch header[0] {
+	case streamConn:
+		_ = conn.SetReadDeadline(time.Time{})
+		select {
+		case t.streamCh <- conn:
+			keepOpen = true
+		case <-t.shutdown:
+		}
+
+	case packetConn:
+		from, buf, err := readPacket(conn)
+		if err != nil {
+			level.Warn(util.Logger).Log("msg", "memberlist failed to read a packet", "remote", conn.RemoteAddr(), "err", err)
+			return
+		}
+		receivedPackets.Inc()
+		select {
+		case t.packetCh <- &memberlist.Packet{Buf: buf, From: from, Timestamp: time.Now()}:
+		case <-t.shutdown:
+		}
+
+	default:
+		level.Warn(util.Logger).Log("msg", "memberlist received a connection of an unknown type", "remote", conn.RemoteAddr(), "type", header[0])
+	}
+}
+
+// A packet is sent as the length and the advertised address of the sender,
+// then the length and the content of the packet.
+func readPacket(r io.Reader) (net.Addr, []byte, error) {
+	var addrLen uint8
+	if err := binary.Read(r, binary.BigEndian, &addrLen); err != nil {
+		return nil, nil, err
+	}
+	addr := make([]byte, addrLen)
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return nil, nil, err
+	}
+	from, err := net.ResolveTCPAddr("tcp", string(addr))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var bufLen uint32
+	if err := binary.Read(r, binary.BigEndian, &bufLen); err != nil {
+		return nil, nil, err
+	}
+	if bufLen > maxPacketSize {
+		return nil, nil, fmt.Errorf("packet of %d bytes is too big", bufLen)
+	}
+	buf := make([]byte, bufLen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, nil, err
+	}
+	return from, buf, nil
+}
+
+// FinalAdvertiseAddr implements memberlist.Transport.
+func (t *TCPTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	if ip == "" {
+		ip = t.cfg.BindAddr
+		if ip == "" || ip == "0.0.0.0" {
+			var err error
+			ip, err = sockaddr.GetPrivateIP()
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to get a private IP address to advertise: %v", err)
+			}
+			if ip == "" {
+				return nil, 0, fmt.Errorf("no private IP address found to advertise, and no address configured")
+			}
+		}
+	}
+	advertiseIP := net.ParseIP(ip)
+	if advertiseIP == nil {
+		return nil, 0, fmt.Errorf("failed to parse the advertised address %q", ip)
+	}
+	if ip4 := advertiseIP.To4(); ip4 != nil {
+		advertiseIP = ip4
+	}
+	if port <= 0 {
+		port = t.Port()
+	}
+
+	t.advertiseMtx.Lock()
+	t.advertiseAddr = net.JoinHostPort(advertiseIP.String(), strconv.Itoa(port))
+	t.advertiseMtx.Unlock()
+	return advertiseIP, port, nil
+}
+
+// WriteTo implements memberlist.Transport. The packets are sent in the
+// background, as memberlist expects them to be sent as UDP ones.
+func (t *TCPTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	t.advertiseMtx.RLock()
+	from := t.advertiseAddr
+	t.advertiseMtx.RUnlock()
+
+	var buf bytes.Buffer
+	buf.WriteByte(packetConn)
+	buf.WriteByte(byte(len(from)))
+	buf.WriteString(from)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+
+	go func() {
+		if err := t.writePacket(buf.Bytes(), addr); err != nil {
+			sentPacketErrors.Inc()
+			level.Debug(util.Logger).Log("msg", "memberlist failed to send a packet", "addr", addr, "err", err)
+			return
+		}
+		sentPackets.Inc()
+	}()
+	return time.Now(), nil
+}
+
+func (t *TCPTransport) writePacket(buf []byte, addr string) error {
+	conn, err := t.dial(addr, t.cfg.PacketDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(t.cfg.PacketWriteTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write(buf)
+	return err
+}
+
+// PacketCh implements memberlist.Transport.
+func (t *TCPTransport) PacketCh() <-chan *memberlist.Packet {
+	return t.packetCh
+}
+
+// DialTimeout implements memberlist.Transport.
+func (t *TCPTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := t.dial(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{streamConn}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *TCPTransport) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if t.tlsConfig == nil {
+		return dialer.Dial("tcp", addr)
+	}
+	return tls.DialWithDialer(dialer, "tcp", addr, t.tlsConfig)
+}
+
+// StreamCh implements memberlist.Transport.
+func (t *TCPTransport) StreamCh() <-chan net.Conn {
+	return t.streamCh
+}
+
+// Shutdown implements memberlist.Transport.
+func (t *TCPTransport) Shutdown() error {
+	var err error
+	t.shutdownOnce.Do(func() {
+		close(t.shutdown)
+		err = t.listener.Close()
+		t.wg.Wait()
+	})
+	return err
+}
+
+// newMemberlistLogger logs the messages of memberlist, prefixed with their
+// levels, with the logger of Cortex.
+func newMemberlistLogger() *log.Logger {
+	return log.New(memberlistLogWriter{}, "", 0)
+}
+
+type memberlistLogWriter struct{}
+
+func (memberlistLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	switch {
+	case strings.HasPrefix(msg, "[ERR]"):
+		level.Error(util.Logger).Log("msg", strings.TrimSpace(strings.TrimPrefix(msg, "[ERR]")))
+	case strings.HasPrefix(msg, "[WARN]"):
+		level.Warn(util.Logger).Log("msg", strings.TrimSpace(strings.TrimPrefix(msg, "[WARN]")))
+	case strings.HasPrefix(msg, "[INFO]"):
+		level.Info(util.Logger).Log("msg", strings.TrimSpace(strings.TrimPrefix(msg, "[INFO]")))
+	default:
+		level.Debug(util.Logger).Log("msg", strings.TrimSpace(strings.TrimPrefix(msg, "[DEBUG]")))
+	}
+	return len(p), nil
+}
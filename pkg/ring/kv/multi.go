@@ -0,0 +1,299 @@
+package kv
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/kvconfig"
+)
+
+var (
+	primaryStoreGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cortex_multikv_primary_store",
+		Help: "Selected primary KV store, 1 for the primary store, 0 for the secondary one.",
+	}, []string{"store"})
+
+	mirrorEnabledGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cortex_multikv_mirror_enabled",
+		Help: "Whether the writes to the primary KV store are mirrored to the secondary one.",
+	})
+
+	mirrorWritesCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cortex_multikv_mirror_writes_total",
+		Help: "Number of mirror writes to the secondary KV store.",
+	})
+
+	mirrorFailuresCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cortex_multikv_mirror_write_errors_total",
+		Help: "Number of failed mirror writes to the secondary KV store.",
+	})
+)
+
+// MultiConfig is the config of the multi KV store, which reads from and
+// writes to a primary store, and can mirror the writes to a secondary store.
+type MultiConfig struct {
+	Primary   string `yaml:"primary"`
+	Secondary string `yaml:"secondary"`
+
+	MirrorEnabled bool          `yaml:"mirror_enabled"`
+	MirrorTimeout time.Duration `yaml:"mirror_timeout"`
+
+	// ConfigProvider returns a channel of the runtime configs overriding the
+	// primary store and the mirroring, or nil if they can't be changed at runtime.
+	ConfigProvider func() <-chan kvconfig.MultiRuntimeConfig `yaml:"-"`
+}
+
+// RegisterFlagsWithPrefix registers the flags of the multi KV store.
+func (cfg *MultiConfig) RegisterFlagsWithPrefix(f *flag.FlagSet, prefix string) {
+	f.StringVar(&cfg.Primary, prefix+"multi.primary", "", "Primary backend storage used by the multi KV store (consul, etcd, memberlist, inmemory).")
+	f.StringVar(&cfg.Secondary, prefix+"multi.secondary", "", "Secondary backend storage used by the multi KV store (consul, etcd, memberlist, inmemory).")
+	f.BoolVar(&cfg.MirrorEnabled, prefix+"multi.mirror-enabled", false, "Mirror the writes to the primary store of the multi KV store to its secondary store.")
+	f.DurationVar(&cfg.MirrorTimeout, prefix+"multi.mirror-timeout", 2*time.Second, "Timeout of a mirror write to the secondary store of the multi KV store.")
+}
+
+type kvclient struct {
+	client Client
+	name   string
+}
+
+type watchInProgress struct {
+	client int
+	cancel context.CancelFunc
+}
+
+// MultiClient implements the kv.Client of the multi KV store. The reads and
+// the watches go to the primary store, as well as the writes, which can also
+// be mirrored to the secondary store. The primary store and the mirroring can
+// be changed at runtime, in which case the watches are restarted on the new
+// primary store.
+type MultiClient struct {
+	clients       []kvclient
+	mirrorTimeout time.Duration
+
+	// The defaults, from the flags.
+	defaultPrimary int
+	defaultMirror  bool
+
+	mtx              sync.Mutex
+	primary          int
+	mirroringEnabled bool
+	inProgress       map[int]watchInProgress
+	inProgressID     int
+}
+
+// newMultiClient makes a new MultiClient of the given stores, the first one
+// being the default primary store.
+func newMultiClient(cfg MultiConfig, clients []kvclient) *MultiClient {
+	c := &MultiClient{
+		clients:          clients,
+		mirrorTimeout:    cfg.MirrorTimeout,
+		defaultMirror:    cfg.MirrorEnabled,
+		mirroringEnabled: cfg.MirrorEnabled,
+		inProgress:       map[int]watchInProgress{},
+	}
+	c.updatePrimaryStoreGauge()
+	c.updateMirrorEnabledGauge()
+
+	if cfg.ConfigProvider != nil {
+		go c.watchConfigChannel(cfg.ConfigProvider())
+	}
+	return c
+}
+
+func (m *MultiClient) watchConfigChannel(configChannel <-chan kvconfig.MultiRuntimeConfig) {
+	for cfg := range configChannel {
+		mirroring := m.defaultMirror
+		if cfg.Mirroring != nil {
+			mirroring = *cfg.Mirroring
+		}
+		m.setMirroringEnabled(mirroring)
+
+		primary := m.clients[m.defaultPrimary].name
+		if cfg.PrimaryStore != "" {
+			primary = cfg.PrimaryStore
+		}
+		if err := m.setNewPrimaryClient(primary); err != nil {
+			level.Error(util.Logger).Log("msg", "failed to switch the primary KV store", "store", primary, "err", err)
+		}
+	}
+}
+
+func (m *MultiClient) setMirroringEnabled(enabled bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.mirroringEnabled == enabled {
+		return
+	}
+	m.mirroringEnabled = enabled
+	m.updateMirrorEnabledGauge()
+	level.Info(util.Logger).Log("msg", "KV store mirroring changed", "enabled", enabled)
+}
+
+// setNewPrimaryClient switches the primary store, and restarts the watches
+// in progress on it.
+func (m *MultiClient) setNewPrimaryClient(store string) error {
+	newPrimary := -1
+	for i, c := range m.clients {
+		if c.name == store {
+			newPrimary = i
+			break
+		}
+	}
+	if newPrimary < 0 {
+		return fmt.Errorf("KV store %q isn't one of the stores of the multi KV store", store)
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.primary == newPrimary {
+		return nil
+	}
+	m.primary = newPrimary
+	m.updatePrimaryStoreGauge()
+	level.Info(util.Logger).Log("msg", "primary KV store changed", "store", store)
+
+	// Cancelling the watches makes them restart on the new primary store.
+	for _, w := range m.inProgress {
+		if w.client != newPrimary {
+			w.cancel()
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held, or before the client is shared.
+func (m *MultiClient) updatePrimaryStoreGauge() {
+	for i, c := range m.clients {
+		if i == m.primary {
+			primaryStoreGauge.WithLabelValues(c.name).Set(1)
+		} else {
+			primaryStoreGauge.WithLabelValues(c.name).Set(0)
+		}
+	}
+}
+
+// Must be called with the lock held, or before the client is shared.
+func (m *MultiClient) updateMirrorEnabledGauge() {
+	if m.mirroringEnabled {
+		mirrorEnabledGauge.Set(1)
+	} else {
+		mirrorEnabledGauge.Set(0)
+	}
+}
+
+func (m *MultiClient) getPrimaryClient() (int, kvclient) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.primary, m.clients[m.primary]
+}
+
+// registerCancelFn registers the cancel function of a watch on the primary
+// client, which it returns, so that the watch is restarted when switched.
+func (m *MultiClient) registerCancelFn(cancel context.CancelFunc) (int, kvclient) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.inProgressID++
+	m.inProgress[m.inProgressID] = watchInProgress{client: m.primary, cancel: cancel}
+	return m.inProgressID, m.clients[m.primary]
+}
+
+func (m *MultiClient) unregisterCancelFn(id int) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.inProgress, id)
+}
+
+// runWithPrimaryClient runs f with the primary client, and runs it again with
+// the new primary client when the primary store is switched while f runs.
+func (m *MultiClient) runWithPrimaryClient(origCtx context.Context, f func(ctx context.Context, primary kvclient)) {
+	for {
+		ctx, cancel := context.WithCancel(origCtx)
+		id, primary := m.registerCancelFn(cancel)
+
+		f(ctx, primary)
+
+		m.unregisterCancelFn(id)
+		cancelled := ctx.Err() != nil
+		cancel()
+
+		// f stopped by itself, or the caller cancelled the watch.
+		if !cancelled || origCtx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Get is a part of kv.Client interface.
+func (m *MultiClient) Get(ctx context.Context, key string) (interface{}, error) {
+	_, primary := m.getPrimaryClient()
+	return primary.client.Get(ctx, key)
+}
+
+// CAS is a part of kv.Client interface.
+func (m *MultiClient) CAS(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	ix, primary := m.getPrimaryClient()
+
+	var updatedValue interface{}
+	err := primary.client.CAS(ctx, key, func(in interface{}) (interface{}, bool, error) {
+		out, retry, err := f(in)
+		updatedValue = out
+		return out, retry, err
+	})
+	if err == nil && updatedValue != nil {
+		m.writeToSecondary(ctx, ix, key, updatedValue)
+	}
+	return err
+}
+
+// writeToSecondary mirrors a value written to the primary store, if enabled.
+// The failures are logged, but don't fail the write to the primary store.
+func (m *MultiClient) writeToSecondary(ctx context.Context, primary int, key string, newValue interface{}) {
+	m.mtx.Lock()
+	enabled := m.mirroringEnabled
+	m.mtx.Unlock()
+	if !enabled {
+		return
+	}
+
+	for i, c := range m.clients {
+		if i == primary {
+			continue
+		}
+
+		mirrorWritesCounter.Inc()
+		mirrorCtx, cancel := context.WithTimeout(ctx, m.mirrorTimeout)
+		err := c.client.CAS(mirrorCtx, key, func(in interface{}) (interface{}, bool, error) {
+			return newValue, false, nil
+		})
+		cancel()
+		if err != nil {
+			mirrorFailuresCounter.Inc()
+			level.Warn(util.Logger).Log("msg", "failed to mirror the write to the secondary KV store", "key", key, "store", c.name, "err", err)
+		}
+	}
+}
+
+// WatchKey is a part of kv.Client interface.
+func (m *MultiClient) WatchKey(ctx context.Context, key string, f func(interface{}) bool) {
+	m.runWithPrimaryClient(ctx, func(ctx context.Context, primary kvclient) {
+		primary.client.WatchKey(ctx, key, f)
+	})
+}
+
+// WatchPrefix is a part of kv.Client interface.
+func (m *MultiClient) WatchPrefix(ctx context.Context, prefix string, f func(string, interface{}) bool) {
+	m.runWithPrimaryClient(ctx, func(ctx context.Context, primary kvclient) {
+		primary.client.WatchPrefix(ctx, prefix, f)
+	})
+}
@@ -0,0 +1,140 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/ring/kv/etcd"
+	"github.com/cortexproject/cortex/pkg/util/kvconfig"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func newTestMultiClient(t *testing.T, cfg MultiConfig) (*MultiClient, Client, Client, func()) {
+	primary, closer, err := etcd.Mock(codec.String{})
+	require.NoError(t, err)
+	secondary := consul.NewInMemoryClient(codec.String{})
+	cfg.MirrorTimeout = time.Second
+
+	client := newMultiClient(cfg, []kvclient{
+		{client: primary, name: "etcd"},
+		{client: secondary, name: "consul"},
+	})
+	return client, primary, secondary, func() { closer.Close() }
+}
+
+func getValue(t *testing.T, client Client) interface{} {
+	v, err := client.Get(ctx, key)
+	require.NoError(t, err)
+	return v
+}
+
+func setValue(t *testing.T, client Client, value string) {
+	require.NoError(t, client.CAS(ctx, key, func(in interface{}) (interface{}, bool, error) {
+		return value, true, nil
+	}))
+}
+
+func TestMultiClientMirroring(t *testing.T) {
+	configs := make(chan kvconfig.MultiRuntimeConfig, 1)
+	client, primary, secondary, closer := newTestMultiClient(t, MultiConfig{
+		MirrorEnabled:  true,
+		ConfigProvider: func() <-chan kvconfig.MultiRuntimeConfig { return configs },
+	})
+	defer closer()
+	defer close(configs)
+
+	// The writes go to both stores.
+	setValue(t, client, "1")
+	require.EqualValues(t, "1", getValue(t, client))
+	require.EqualValues(t, "1", getValue(t, primary))
+	require.EqualValues(t, "1", getValue(t, secondary))
+
+	// Unless the mirroring is disabled at runtime.
+	configs <- kvconfig.MultiRuntimeConfig{Mirroring: boolPtr(false)}
+	test.Poll(t, time.Second, false, func() interface{} {
+		client.mtx.Lock()
+		defer client.mtx.Unlock()
+		return client.mirroringEnabled
+	})
+	setValue(t, client, "2")
+	require.EqualValues(t, "2", getValue(t, primary))
+	require.EqualValues(t, "1", getValue(t, secondary))
+
+	// The empty runtime config reverts to the flags.
+	configs <- kvconfig.MultiRuntimeConfig{}
+	test.Poll(t, time.Second, true, func() interface{} {
+		client.mtx.Lock()
+		defer client.mtx.Unlock()
+		return client.mirroringEnabled
+	})
+}
+
+func TestMultiClientSwitchPrimary(t *testing.T) {
+	configs := make(chan kvconfig.MultiRuntimeConfig, 1)
+	client, primary, secondary, closer := newTestMultiClient(t, MultiConfig{
+		ConfigProvider: func() <-chan kvconfig.MultiRuntimeConfig { return configs },
+	})
+	defer closer()
+	defer close(configs)
+
+	setValue(t, secondary, "secondary")
+
+	watchCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values := make(chan interface{}, 10)
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		client.WatchKey(watchCtx, key, func(v interface{}) bool {
+			values <- v
+			return true
+		})
+	}()
+
+	// Etcd only watches the changes made after the watch started.
+	test.Poll(t, 5*time.Second, "primary", func() interface{} {
+		setValue(t, primary, "primary")
+		select {
+		case v := <-values:
+			return v
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	})
+
+	// The reads, the writes and the watches switch to the new primary store.
+	configs <- kvconfig.MultiRuntimeConfig{PrimaryStore: "consul"}
+	v := <-values
+	for v == "primary" {
+		// A late notification of the writes to the old primary store.
+		v = <-values
+	}
+	require.EqualValues(t, "secondary", v)
+	require.EqualValues(t, "secondary", getValue(t, client))
+	setValue(t, client, "switched")
+	require.EqualValues(t, "switched", <-values)
+	require.EqualValues(t, "primary", getValue(t, primary))
+
+	// The unknown stores are ignored.
+	configs <- kvconfig.MultiRuntimeConfig{PrimaryStore: "unknown"}
+	configs <- kvconfig.MultiRuntimeConfig{PrimaryStore: "consul"}
+	require.EqualValues(t, "switched", getValue(t, client))
+
+	// The in-memory Consul only wakes the watch up on a change.
+	cancel()
+	setValue(t, secondary, "stopped")
+	select {
+	case <-watchDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not stopped")
+	}
+}
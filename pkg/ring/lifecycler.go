@@ -31,6 +31,14 @@ var (
 		Name: "cortex_member_ring_tokens_to_own",
 		Help: "The number of tokens to own in the ring.",
 	}, []string{"name"})
+	tokenConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_member_ring_token_conflicts_total",
+		Help: "The total number of tokens replaced while observing the ring, as they conflicted with the tokens of other members.",
+	}, []string{"name"})
+	forgottenInstances = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cortex_member_ring_forgotten_instances_total",
+		Help: "The total number of unhealthy members forgotten from the ring.",
+	}, []string{"name"})
 	shutdownDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "cortex_shutdown_duration_seconds",
 		Help:    "Duration (in seconds) of cortex shutdown procedure (ie transfer or flush).",
@@ -38,6 +46,15 @@ var (
 	}, []string{"op", "status", "name"})
 )
 
+// The strategies generating the tokens of the joining instances.
+const (
+	// RandomTokensStrategy generates random tokens.
+	RandomTokensStrategy = "random"
+	// EvenlySpacedTokensStrategy generates the tokens splitting the largest
+	// ranges between the tokens of the ring.
+	EvenlySpacedTokensStrategy = "evenly-spaced"
+)
+
 // LifecyclerConfig is the config to build a Lifecycler.
 type LifecyclerConfig struct {
 	RingConfig Config `yaml:"ring,omitempty"`
@@ -53,11 +70,35 @@ type LifecyclerConfig struct {
 	InfNames         []string      `yaml:"interface_names"`
 	FinalSleep       time.Duration `yaml:"final_sleep"`
 
+	UnregisterOnShutdown bool   `yaml:"unregister_on_shutdown"`
+	TokensFilePath       string `yaml:"tokens_file_path"`
+
+	TokensGenerationStrategy string        `yaml:"tokens_generation_strategy"`
+	ObservePeriod            time.Duration `yaml:"observe_period"`
+
+	AutoForgetUnhealthyPeriods int `yaml:"auto_forget_unhealthy_periods"`
+
 	// For testing, you can override the address and ID of this ingester
 	Addr           string `yaml:"address"`
 	Port           int
 	ID             string
 	SkipUnregister bool
+
+	// The availability zone of this ingester, spreading the replicas across
+	// the zones when the ring is zone-aware.
+	Zone string `yaml:"availability_zone"`
+}
+
+// Validate the config: the tokens of the rings gossiped by the memberlist KV
+// store must be normalised.
+func (cfg *LifecyclerConfig) Validate() error {
+	kvCfg := cfg.RingConfig.KVStore
+	memberlist := kvCfg.Store == "memberlist" ||
+		kvCfg.Store == "multi" && (kvCfg.Multi.Primary == "memberlist" || kvCfg.Multi.Secondary == "memberlist")
+	if memberlist && !cfg.NormaliseTokens {
+		return fmt.Errorf("the tokens must be normalised to store the ring in the memberlist KV store")
+	}
+	return nil
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet
@@ -80,8 +121,13 @@ func (cfg *LifecyclerConfig) RegisterFlagsWithPrefix(prefix string, f *flag.Flag
 	f.DurationVar(&cfg.JoinAfter, prefix+"join-after", 0*time.Second, "Period to wait for a claim from another member; will join automatically after this.")
 	f.DurationVar(&cfg.MinReadyDuration, prefix+"min-ready-duration", 1*time.Minute, "Minimum duration to wait before becoming ready. This is to work around race conditions with ingesters exiting and updating the ring.")
 	flagext.DeprecatedFlag(f, prefix+"claim-on-rollout", "DEPRECATED. This feature is no longer optional.")
-	f.BoolVar(&cfg.NormaliseTokens, prefix+"normalise-tokens", false, "Store tokens in a normalised fashion to reduce allocations.")
+	f.BoolVar(&cfg.NormaliseTokens, prefix+"normalise-tokens", false, "Store tokens in a normalised fashion to reduce allocations. Required with the memberlist KV store.")
 	f.DurationVar(&cfg.FinalSleep, prefix+"final-sleep", 30*time.Second, "Duration to sleep for before exiting, to ensure metrics are scraped.")
+	f.BoolVar(&cfg.UnregisterOnShutdown, prefix+"unregister-on-shutdown", true, "Unregister from the ring on shutdown. When false, the entry is left in the ring in the LEAVING state, and is resumed with the same tokens on restart.")
+	f.StringVar(&cfg.TokensFilePath, prefix+"tokens-file-path", "", "File path where the tokens are stored. If set, the tokens are stored when they change, and reused on startup when the ring has no entry for this ingester.")
+	f.StringVar(&cfg.TokensGenerationStrategy, prefix+"tokens-generation-strategy", RandomTokensStrategy, fmt.Sprintf("How the tokens of a joining ingester are generated: %s, or %s splitting the largest ranges between the tokens of the ring. With zone awareness, the tokens are planned in the zone of the ingester.", RandomTokensStrategy, EvenlySpacedTokensStrategy))
+	f.DurationVar(&cfg.ObservePeriod, prefix+"observe-period", 0*time.Second, "Period to observe the tokens of a joining ingester, in the JOINING state, before going ACTIVE. The tokens conflicting with the tokens of other ingesters are replaced, and observed again. 0 to go ACTIVE on joining.")
+	f.IntVar(&cfg.AutoForgetUnhealthyPeriods, prefix+"auto-forget-unhealthy-periods", 0, "Number of heartbeat timeouts without a heartbeat after which an ingester is forgotten from the ring by the other ingesters. 0 to never forget them.")
 
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -94,6 +140,7 @@ func (cfg *LifecyclerConfig) RegisterFlagsWithPrefix(prefix string, f *flag.Flag
 	f.StringVar(&cfg.Addr, prefix+"lifecycler.addr", "", "IP address to advertise in consul.")
 	f.IntVar(&cfg.Port, prefix+"lifecycler.port", 0, "port to advertise in consul (defaults to server.grpc-listen-port).")
 	f.StringVar(&cfg.ID, prefix+"lifecycler.ID", hostname, "ID to register into consul.")
+	f.StringVar(&cfg.Zone, prefix+"availability-zone", "", "The availability zone this instance runs in, registered into the ring.")
 }
 
 // FlushTransferer controls the shutdown of an ingester.
@@ -129,6 +176,10 @@ type Lifecycler struct {
 	readyLock sync.Mutex
 	startTime time.Time
 	ready     bool
+
+	// Keeps stats updated at every heartbeat period
+	countersLock          sync.RWMutex
+	healthyInstancesCount int
 }
 
 // NewLifecycler makes and starts a new Lifecycler.
@@ -141,6 +192,9 @@ func NewLifecycler(cfg LifecyclerConfig, flushTransferer FlushTransferer, name s
 			return nil, err
 		}
 	}
+	if cfg.TokensGenerationStrategy != RandomTokensStrategy && cfg.TokensGenerationStrategy != EvenlySpacedTokensStrategy {
+		return nil, fmt.Errorf("unsupported tokens generation strategy %q", cfg.TokensGenerationStrategy)
+	}
 	port := cfg.Port
 	if port == 0 {
 		port = *cfg.ListenPort
@@ -246,8 +300,52 @@ func (i *Lifecycler) setTokens(tokens []uint32) {
 	tokensOwned.WithLabelValues(i.RingName).Set(float64(len(tokens)))
 
 	i.stateMtx.Lock()
-	defer i.stateMtx.Unlock()
 	i.tokens = tokens
+	i.stateMtx.Unlock()
+
+	if i.cfg.TokensFilePath != "" && len(tokens) > 0 {
+		if err := storeTokensToFile(i.cfg.TokensFilePath, tokens); err != nil {
+			level.Error(util.Logger).Log("msg", "failed to store tokens to file", "path", i.cfg.TokensFilePath, "err", err)
+		}
+	}
+}
+
+// tokensFromFile returns the tokens stored in the tokens file, unless some of
+// them are now owned by other ingesters.
+func (i *Lifecycler) tokensFromFile(ringDesc *Desc) []uint32 {
+	if i.cfg.TokensFilePath == "" {
+		return nil
+	}
+
+	tokens, err := loadTokensFromFile(i.cfg.TokensFilePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			level.Error(util.Logger).Log("msg", "failed to load tokens from file", "path", i.cfg.TokensFilePath, "err", err)
+		}
+		return nil
+	}
+
+	_, takenTokens := ringDesc.TokensFor(i.ID)
+	taken := make(map[uint32]struct{}, len(takenTokens))
+	for _, token := range takenTokens {
+		taken[token] = struct{}{}
+	}
+	for _, token := range tokens {
+		if _, ok := taken[token]; ok {
+			level.Warn(util.Logger).Log("msg", "ignoring tokens from file, as some are owned by other ingesters", "path", i.cfg.TokensFilePath)
+			return nil
+		}
+	}
+	return tokens
+}
+
+// HealthyInstancesCount returns the number of healthy instances in the ring, updated
+// during the last heartbeat period.
+func (i *Lifecycler) HealthyInstancesCount() int {
+	i.countersLock.RLock()
+	defer i.countersLock.RUnlock()
+
+	return i.healthyInstancesCount
 }
 
 // ClaimTokensFor takes all the tokens for the supplied ingester and assigns them to this ingester.
@@ -308,6 +406,7 @@ func (i *Lifecycler) loop() {
 
 	// We do various period tasks
 	autoJoinAfter := time.After(i.cfg.JoinAfter)
+	var observeChan <-chan time.Time
 
 	heartbeatTicker := time.NewTicker(i.cfg.HeartbeatPeriod)
 	defer heartbeatTicker.Stop()
@@ -325,6 +424,29 @@ loop:
 					level.Error(util.Logger).Log("msg", "failed to pick tokens in consul", "err", err)
 					os.Exit(1)
 				}
+				if i.GetState() == JOINING {
+					observeChan = time.After(i.cfg.ObservePeriod)
+				}
+			}
+
+		case <-observeChan:
+			// The tokens were observed long enough: go ACTIVE, unless some
+			// conflict with the tokens of other ingesters, in which case
+			// they are replaced and observed again.
+			observeChan = nil
+			if i.GetState() != JOINING {
+				break
+			}
+			verified, err := i.verifyTokens(context.Background())
+			if err != nil {
+				level.Error(util.Logger).Log("msg", "failed to verify tokens in consul", "err", err)
+			}
+			if !verified {
+				observeChan = time.After(i.cfg.ObservePeriod)
+				break
+			}
+			if err := i.changeState(context.Background(), ACTIVE); err != nil {
+				level.Error(util.Logger).Log("msg", "failed to change state to ACTIVE after observing tokens", "err", err)
 			}
 
 		case <-heartbeatTicker.C:
@@ -366,7 +488,7 @@ heartbeatLoop:
 		}
 	}
 
-	if !i.cfg.SkipUnregister {
+	if !i.cfg.SkipUnregister && i.cfg.UnregisterOnShutdown {
 		if err := i.unregister(context.Background()); err != nil {
 			level.Error(util.Logger).Log("msg", "Failed to unregister from consul", "err", err)
 			os.Exit(1)
@@ -387,17 +509,39 @@ func (i *Lifecycler) initRing(ctx context.Context) error {
 			ringDesc = in.(*Desc)
 		}
 
+		// The LEFT entries are the tombstones of the gossiped rings, of the
+		// ingesters removed from them.
 		ingesterDesc, ok := ringDesc.Ingesters[i.ID]
-		if !ok {
-			// Either we are a new ingester, or consul must have restarted
+		if !ok || ingesterDesc.State == LEFT {
+			// Either we are a new ingester, or consul must have restarted.
+			// Reuse the tokens we stored before restarting, if any.
+			if tokens := i.tokensFromFile(ringDesc); len(tokens) > 0 {
+				level.Info(util.Logger).Log("msg", "entry not found in ring, adding with tokens from file", "tokens", len(tokens))
+				i.setState(ACTIVE)
+				i.setTokens(tokens)
+				ringDesc.AddIngester(i.ID, i.Addr, i.cfg.Zone, tokens, i.GetState(), i.cfg.NormaliseTokens)
+				return ringDesc, true, nil
+			}
+
 			level.Info(util.Logger).Log("msg", "entry not found in ring, adding with no tokens")
-			ringDesc.AddIngester(i.ID, i.Addr, []uint32{}, i.GetState(), i.cfg.NormaliseTokens)
+			ringDesc.AddIngester(i.ID, i.Addr, i.cfg.Zone, []uint32{}, i.GetState(), i.cfg.NormaliseTokens)
 			return ringDesc, true, nil
 		}
 
 		// We exist in the ring, so assume the ring is right and copy out tokens & state out of there.
-		i.setState(ingesterDesc.State)
 		tokens, _ := ringDesc.TokensFor(i.ID)
+		state := ingesterDesc.State
+		if state == LEAVING {
+			// Left in the ring by a shutdown which didn't unregister: resume
+			// with our tokens, or start over if they were handed over.
+			state = PENDING
+			if len(tokens) > 0 {
+				state = ACTIVE
+			}
+			ingesterDesc.State = state
+			ringDesc.Ingesters[i.ID] = ingesterDesc
+		}
+		i.setState(state)
 		i.setTokens(tokens)
 
 		level.Info(util.Logger).Log("msg", "existing entry found in ring", "state", i.GetState(), "tokens", len(tokens))
@@ -405,7 +549,8 @@ func (i *Lifecycler) initRing(ctx context.Context) error {
 	})
 }
 
-// autoJoin selects random tokens & moves state to ACTIVE
+// autoJoin selects new tokens & moves state to ACTIVE, or to JOINING to
+// observe them first with an observe period.
 func (i *Lifecycler) autoJoin(ctx context.Context) error {
 	return i.KVStore.CAS(ctx, ConsulKey, func(in interface{}) (out interface{}, retry bool, err error) {
 		var ringDesc *Desc
@@ -421,9 +566,13 @@ func (i *Lifecycler) autoJoin(ctx context.Context) error {
 			level.Error(util.Logger).Log("msg", "tokens already exist for this ingester - wasn't expecting any!", "num_tokens", len(myTokens))
 		}
 
-		newTokens := GenerateTokens(i.cfg.NumTokens-len(myTokens), takenTokens)
-		i.setState(ACTIVE)
-		ringDesc.AddIngester(i.ID, i.Addr, newTokens, i.GetState(), i.cfg.NormaliseTokens)
+		newTokens := i.generateTokens(ringDesc, i.cfg.NumTokens-len(myTokens), myTokens, takenTokens)
+		if i.cfg.ObservePeriod > 0 {
+			i.setState(JOINING)
+		} else {
+			i.setState(ACTIVE)
+		}
+		ringDesc.AddIngester(i.ID, i.Addr, i.cfg.Zone, newTokens, i.GetState(), i.cfg.NormaliseTokens)
 
 		tokens := append(myTokens, newTokens...)
 		sort.Sort(sortableUint32(tokens))
@@ -433,6 +582,77 @@ func (i *Lifecycler) autoJoin(ctx context.Context) error {
 	})
 }
 
+// generateTokens makes numTokens new tokens, besides myTokens, following the
+// tokens generation strategy. None of them clash with takenTokens.
+func (i *Lifecycler) generateTokens(ringDesc *Desc, numTokens int, myTokens, takenTokens []uint32) []uint32 {
+	switch {
+	case i.cfg.RingConfig.ZoneAwarenessEnabled && i.cfg.Zone != "":
+		// The tokens are planned in the zone, for its instances to own
+		// balanced shares of the ring.
+		return GenerateZoneTokens(numTokens, append(ringDesc.zoneTokens(i.cfg.Zone, i.ID), myTokens...), takenTokens)
+	case i.cfg.TokensGenerationStrategy == EvenlySpacedTokensStrategy:
+		return GenerateZoneTokens(numTokens, append(ringDesc.otherTokens(i.ID), myTokens...), takenTokens)
+	default:
+		return GenerateTokens(numTokens, takenTokens)
+	}
+}
+
+// verifyTokens checks that our tokens don't conflict with the tokens of the
+// other ingesters, once observed. Our tokens owned by another ingester which
+// isn't joining, or joining with a lower ID, are lost: they are replaced by
+// new tokens, to be observed again, and verifyTokens returns false.
+func (i *Lifecycler) verifyTokens(ctx context.Context) (bool, error) {
+	verified := false
+	err := i.KVStore.CAS(ctx, ConsulKey, func(in interface{}) (out interface{}, retry bool, err error) {
+		var ringDesc *Desc
+		if in == nil {
+			ringDesc = NewDesc()
+		} else {
+			ringDesc = in.(*Desc)
+		}
+
+		lost := map[uint32]bool{}
+		var takenTokens []uint32
+		for _, token := range migrateRing(ringDesc) {
+			ing, ok := ringDesc.Ingesters[token.Ingester]
+			if token.Ingester == i.ID || !ok || ing.State == LEFT {
+				continue
+			}
+			takenTokens = append(takenTokens, token.Token)
+			if ing.State != JOINING || token.Ingester < i.ID {
+				lost[token.Token] = true
+			}
+		}
+
+		var tokens []uint32
+		for _, token := range i.getTokens() {
+			if !lost[token] {
+				tokens = append(tokens, token)
+			}
+		}
+		ingesterDesc, ok := ringDesc.Ingesters[i.ID]
+		if len(tokens) == len(i.getTokens()) && ok && ingesterDesc.State != LEFT {
+			verified = true
+			return nil, false, nil
+		}
+
+		conflicts := len(i.getTokens()) - len(tokens)
+		if conflicts > 0 {
+			level.Warn(util.Logger).Log("msg", "replacing tokens conflicting with other ingesters", "conflicts", conflicts)
+			tokenConflicts.WithLabelValues(i.RingName).Add(float64(conflicts))
+		}
+		newTokens := i.generateTokens(ringDesc, conflicts, tokens, append(takenTokens, tokens...))
+		tokens = append(tokens, newTokens...)
+		sort.Sort(sortableUint32(tokens))
+
+		ringDesc.RemoveIngester(i.ID)
+		ringDesc.AddIngester(i.ID, i.Addr, i.cfg.Zone, tokens, i.GetState(), i.cfg.NormaliseTokens)
+		i.setTokens(tokens)
+		return ringDesc, true, nil
+	})
+	return verified, err
+}
+
 // updateConsul updates our entries in consul, heartbeating and dealing with
 // consul restarts.
 func (i *Lifecycler) updateConsul(ctx context.Context) error {
@@ -445,21 +665,60 @@ func (i *Lifecycler) updateConsul(ctx context.Context) error {
 		}
 
 		ingesterDesc, ok := ringDesc.Ingesters[i.ID]
-		if !ok {
-			// consul must have restarted
+		if !ok || ingesterDesc.State == LEFT {
+			// consul must have restarted, or we were forgotten
 			level.Info(util.Logger).Log("msg", "found empty ring, inserting tokens")
-			ringDesc.AddIngester(i.ID, i.Addr, i.getTokens(), i.GetState(), i.cfg.NormaliseTokens)
+			ringDesc.AddIngester(i.ID, i.Addr, i.cfg.Zone, i.getTokens(), i.GetState(), i.cfg.NormaliseTokens)
 		} else {
 			ingesterDesc.Timestamp = time.Now().Unix()
 			ingesterDesc.State = i.GetState()
 			ingesterDesc.Addr = i.Addr
+			ingesterDesc.Zone = i.cfg.Zone
 			ringDesc.Ingesters[i.ID] = ingesterDesc
 		}
 
+		i.forgetUnhealthy(ringDesc)
+		i.updateCounters(ringDesc)
 		return ringDesc, true, nil
 	})
 }
 
+// forgetUnhealthy removes from the ring the other ingesters without a
+// heartbeat for AutoForgetUnhealthyPeriods heartbeat timeouts, as they never
+// came back, so that they don't take up the replicas of their tokens.
+func (i *Lifecycler) forgetUnhealthy(ringDesc *Desc) {
+	if i.cfg.AutoForgetUnhealthyPeriods <= 0 {
+		return
+	}
+
+	forgetAfter := time.Duration(i.cfg.AutoForgetUnhealthyPeriods) * i.cfg.RingConfig.HeartbeatTimeout
+	for id, ingester := range ringDesc.Ingesters {
+		if id == i.ID || ingester.State == LEFT {
+			continue
+		}
+		if lastHeartbeat := time.Unix(ingester.Timestamp, 0); time.Since(lastHeartbeat) > forgetAfter {
+			level.Warn(util.Logger).Log("msg", "forgetting unhealthy ingester from the ring", "ring", i.RingName, "ingester", id, "last_heartbeat", lastHeartbeat)
+			ringDesc.RemoveIngester(id)
+			forgottenInstances.WithLabelValues(i.RingName).Inc()
+		}
+	}
+}
+
+// updateCounters updates the counters derived from the ring, read by
+// HealthyInstancesCount().
+func (i *Lifecycler) updateCounters(ringDesc *Desc) {
+	healthyInstancesCount := 0
+	for _, ingester := range ringDesc.Ingesters {
+		if ingester.State == ACTIVE && time.Since(time.Unix(ingester.Timestamp, 0)) <= i.cfg.RingConfig.HeartbeatTimeout {
+			healthyInstancesCount++
+		}
+	}
+
+	i.countersLock.Lock()
+	i.healthyInstancesCount = healthyInstancesCount
+	i.countersLock.Unlock()
+}
+
 // changeState updates consul with state transitions for us.  NB this must be
 // called from loop()!  Use ChangeState for calls from outside of loop().
 func (i *Lifecycler) changeState(ctx context.Context, state IngesterState) error {
@@ -2,12 +2,19 @@ package ring
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/cortexproject/cortex/pkg/ring/kv/memberlist"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/cortexproject/cortex/pkg/util/test"
 )
@@ -55,6 +62,22 @@ func checkNormalised(d interface{}, id string) bool {
 		len(desc.Tokens) == 0
 }
 
+func TestLifecyclerConfigValidate(t *testing.T) {
+	var cfg LifecyclerConfig
+	flagext.DefaultValues(&cfg)
+	require.NoError(t, cfg.Validate())
+
+	// The tokens of the rings gossiped by memberlist must be normalised.
+	cfg.RingConfig.KVStore.Store = "memberlist"
+	require.Error(t, cfg.Validate())
+	cfg.RingConfig.KVStore.Store = "multi"
+	cfg.RingConfig.KVStore.Multi.Primary = "consul"
+	cfg.RingConfig.KVStore.Multi.Secondary = "memberlist"
+	require.Error(t, cfg.Validate())
+	cfg.NormaliseTokens = true
+	require.NoError(t, cfg.Validate())
+}
+
 func TestRingNormaliseMigration(t *testing.T) {
 	var ringConfig Config
 	flagext.DefaultValues(&ringConfig)
@@ -149,6 +172,102 @@ func TestRingRestart(t *testing.T) {
 	})
 }
 
+type noTransferFlushTransferer struct{}
+
+func (f *noTransferFlushTransferer) StopIncomingRequests() {}
+func (f *noTransferFlushTransferer) Flush()                {}
+func (f *noTransferFlushTransferer) TransferOut(ctx context.Context) error {
+	return fmt.Errorf("no pending ingester")
+}
+
+func TestRestartWithoutUnregister(t *testing.T) {
+	var ringConfig Config
+	flagext.DefaultValues(&ringConfig)
+	ringConfig.KVStore.Mock = consul.NewInMemoryClient(GetCodec())
+
+	r, err := New(ringConfig, "ingester")
+	require.NoError(t, err)
+	defer r.Stop()
+
+	lifecyclerConfig := testLifecyclerConfig(ringConfig, "ing1")
+	lifecyclerConfig.UnregisterOnShutdown = false
+	l1, err := NewLifecycler(lifecyclerConfig, &noTransferFlushTransferer{}, "ingester")
+	require.NoError(t, err)
+
+	test.Poll(t, 1000*time.Millisecond, true, func() interface{} {
+		d, err := r.KVClient.Get(context.Background(), ConsulKey)
+		require.NoError(t, err)
+		return checkDenormalised(d, "ing1")
+	})
+	token := l1.getTokens()[0]
+	l1.Shutdown()
+
+	// The entry is left in the ring...
+	d, err := r.KVClient.Get(context.Background(), ConsulKey)
+	require.NoError(t, err)
+	require.Equal(t, LEAVING, d.(*Desc).Ingesters["ing1"].State)
+
+	// ...and resumed on restart, with the same tokens.
+	lifecyclerConfig.JoinAfter = 100 * time.Second
+	l2, err := NewLifecycler(lifecyclerConfig, &noTransferFlushTransferer{}, "ingester")
+	require.NoError(t, err)
+	defer l2.Shutdown()
+
+	test.Poll(t, 1000*time.Millisecond, true, func() interface{} {
+		d, err := r.KVClient.Get(context.Background(), ConsulKey)
+		require.NoError(t, err)
+		return checkDenormalised(d, "ing1") && d.(*Desc).Tokens[0].Token == token
+	})
+}
+
+func TestTokensFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var ringConfig Config
+	flagext.DefaultValues(&ringConfig)
+	ringConfig.KVStore.Mock = consul.NewInMemoryClient(GetCodec())
+
+	r, err := New(ringConfig, "ingester")
+	require.NoError(t, err)
+	defer r.Stop()
+
+	lifecyclerConfig := testLifecyclerConfig(ringConfig, "ing1")
+	lifecyclerConfig.NumTokens = 4
+	lifecyclerConfig.TokensFilePath = filepath.Join(dir, "tokens")
+	l1, err := NewLifecycler(lifecyclerConfig, &noTransferFlushTransferer{}, "ingester")
+	require.NoError(t, err)
+
+	test.Poll(t, 1000*time.Millisecond, 4, func() interface{} {
+		return len(l1.getTokens())
+	})
+	tokens := l1.getTokens()
+	l1.Shutdown()
+
+	stored, err := loadTokensFromFile(lifecyclerConfig.TokensFilePath)
+	require.NoError(t, err)
+	require.Equal(t, tokens, stored)
+
+	// The ingester unregistered, so it rejoins with the tokens from the file,
+	// without waiting to auto-join.
+	lifecyclerConfig.JoinAfter = 100 * time.Second
+	l2, err := NewLifecycler(lifecyclerConfig, &noTransferFlushTransferer{}, "ingester")
+	require.NoError(t, err)
+	defer l2.Shutdown()
+
+	test.Poll(t, 1000*time.Millisecond, true, func() interface{} {
+		d, err := r.KVClient.Get(context.Background(), ConsulKey)
+		require.NoError(t, err)
+		desc, ok := d.(*Desc)
+		if !ok || desc.Ingesters["ing1"].State != ACTIVE {
+			return false
+		}
+		ringTokens, _ := desc.TokensFor("ing1")
+		return reflect.DeepEqual(tokens, ringTokens)
+	})
+}
+
 type MockClient struct {
 	GetFunc         func(ctx context.Context, key string) (interface{}, error)
 	CASFunc         func(ctx context.Context, key string, f func(in interface{}) (out interface{}, retry bool, err error)) error
@@ -203,3 +322,176 @@ func TestCheckReady(t *testing.T) {
 	err = l1.CheckReady(context.Background())
 	require.Error(t, err)
 }
+
+func TestLifecyclerMemberlist(t *testing.T) {
+	newKV := func(name string, join ...string) *memberlist.KV {
+		var cfg memberlist.KVConfig
+		flagext.DefaultValues(&cfg)
+		cfg.NodeName = name
+		cfg.TCPTransport.BindAddr = "127.0.0.1"
+		cfg.TCPTransport.BindPort = 0
+		cfg.GossipInterval = 10 * time.Millisecond
+		cfg.LeaveTimeout = time.Second
+		cfg.JoinMembers = join
+		kv, err := memberlist.NewKV(cfg)
+		require.NoError(t, err)
+		return kv
+	}
+	kv1 := newKV("kv1")
+	defer kv1.Stop()
+	kv2 := newKV("kv2", kv1.Status().Members[0].Address)
+	defer kv2.Stop()
+
+	ringConfig := func(kv *memberlist.KV) Config {
+		var cfg Config
+		flagext.DefaultValues(&cfg)
+		cfg.KVStore.Store = "memberlist"
+		cfg.KVStore.MemberlistKV = func() (*memberlist.KV, error) { return kv, nil }
+		return cfg
+	}
+	r, err := New(ringConfig(kv2), "ingester")
+	require.NoError(t, err)
+	defer r.Stop()
+
+	// The ingester joining through a member is seen by the others.
+	lifecyclerConfig := testLifecyclerConfig(ringConfig(kv1), "ing1")
+	lifecyclerConfig.NormaliseTokens = true
+	l1, err := NewLifecycler(lifecyclerConfig, &noTransferFlushTransferer{}, "ingester")
+	require.NoError(t, err)
+	test.Poll(t, 5*time.Second, true, func() interface{} {
+		d, err := r.KVClient.Get(context.Background(), ConsulKey)
+		require.NoError(t, err)
+		return checkNormalised(d, "ing1")
+	})
+	test.Poll(t, 5*time.Second, 1, func() interface{} {
+		rs, err := r.GetAll()
+		if err != nil {
+			return 0
+		}
+		return len(rs.Ingesters)
+	})
+
+	// Its removal is gossiped as a tombstone, ignored by the ring.
+	l1.Shutdown()
+	test.Poll(t, 5*time.Second, LEFT, func() interface{} {
+		d, err := r.KVClient.Get(context.Background(), ConsulKey)
+		require.NoError(t, err)
+		return d.(*Desc).Ingesters["ing1"].State
+	})
+	test.Poll(t, 5*time.Second, 0, func() interface{} {
+		r.mtx.RLock()
+		defer r.mtx.RUnlock()
+		return len(r.ringDesc.Ingesters)
+	})
+
+	// The ingester rejoins with its ID.
+	l2, err := NewLifecycler(lifecyclerConfig, &noTransferFlushTransferer{}, "ingester")
+	require.NoError(t, err)
+	defer l2.Shutdown()
+	test.Poll(t, 5*time.Second, true, func() interface{} {
+		d, err := r.KVClient.Get(context.Background(), ConsulKey)
+		require.NoError(t, err)
+		return checkNormalised(d, "ing1")
+	})
+}
+
+func TestLifecyclerObservePeriod(t *testing.T) {
+	var ringConfig Config
+	flagext.DefaultValues(&ringConfig)
+	ringConfig.KVStore.Mock = consul.NewInMemoryClient(GetCodec())
+
+	lifecyclerConfig := testLifecyclerConfig(ringConfig, "ing1")
+	lifecyclerConfig.NumTokens = 4
+	lifecyclerConfig.ObservePeriod = 2 * time.Second
+	l1, err := NewLifecycler(lifecyclerConfig, &noTransferFlushTransferer{}, "ingester")
+	require.NoError(t, err)
+	defer l1.Shutdown()
+
+	// The ingester observes its tokens before going ACTIVE.
+	test.Poll(t, time.Second, JOINING, func() interface{} {
+		return l1.GetState()
+	})
+	tokens := l1.getTokens()
+	require.Len(t, tokens, 4)
+
+	// Another ingester took one of them meanwhile, so it's replaced.
+	require.NoError(t, ringConfig.KVStore.Mock.CAS(context.Background(), ConsulKey, func(in interface{}) (interface{}, bool, error) {
+		ringDesc := in.(*Desc)
+		ringDesc.AddIngester("ing0", "addr0", "", []uint32{tokens[0]}, ACTIVE, false)
+		return ringDesc, true, nil
+	}))
+	test.Poll(t, 10*time.Second, ACTIVE, func() interface{} {
+		return l1.GetState()
+	})
+
+	d, err := ringConfig.KVStore.Mock.Get(context.Background(), ConsulKey)
+	require.NoError(t, err)
+	ringTokens, _ := d.(*Desc).TokensFor("ing1")
+	require.Equal(t, l1.getTokens(), ringTokens)
+	require.Len(t, ringTokens, 4)
+	require.NotContains(t, ringTokens, tokens[0])
+	for _, token := range tokens[1:] {
+		require.Contains(t, ringTokens, token)
+	}
+}
+
+func TestLifecyclerTokensGenerationStrategy(t *testing.T) {
+	var ringConfig Config
+	flagext.DefaultValues(&ringConfig)
+	ringConfig.KVStore.Mock = consul.NewInMemoryClient(GetCodec())
+
+	lifecyclerConfig := testLifecyclerConfig(ringConfig, "ing2")
+	lifecyclerConfig.TokensGenerationStrategy = "unknown"
+	_, err := NewLifecycler(lifecyclerConfig, &nopFlushTransferer{}, "ingester")
+	require.Error(t, err)
+
+	// The evenly-spaced tokens split the largest ranges of the ring.
+	desc := NewDesc()
+	desc.AddIngester("ing1", "addr1", "", []uint32{0, 1 << 31}, ACTIVE, true)
+	lifecyclerConfig.TokensGenerationStrategy = EvenlySpacedTokensStrategy
+	l := &Lifecycler{cfg: lifecyclerConfig, ID: "ing2"}
+	require.Equal(t, []uint32{3 << 30, 1 << 30}, l.generateTokens(desc, 2, nil, []uint32{0, 1 << 31}))
+	require.Equal(t, []uint32{1 << 30}, l.generateTokens(desc, 1, []uint32{3 << 30}, []uint32{0, 1 << 31, 3 << 30}))
+
+	lifecyclerConfig.TokensGenerationStrategy = RandomTokensStrategy
+	l = &Lifecycler{cfg: lifecyclerConfig, ID: "ing2"}
+	require.Len(t, l.generateTokens(desc, 2, nil, []uint32{0, 1 << 31}), 2)
+}
+
+func TestLifecyclerAutoForgetUnhealthy(t *testing.T) {
+	var ringConfig Config
+	flagext.DefaultValues(&ringConfig)
+	ringConfig.KVStore.Mock = consul.NewInMemoryClient(GetCodec())
+
+	// ing0 crashed an hour ago, ing1 missed its last heartbeats.
+	require.NoError(t, ringConfig.KVStore.Mock.CAS(context.Background(), ConsulKey, func(interface{}) (interface{}, bool, error) {
+		ringDesc := NewDesc()
+		for id, age := range map[string]time.Duration{"ing0": time.Hour, "ing1": 90 * time.Second} {
+			ringDesc.AddIngester(id, id, "", []uint32{1}, ACTIVE, true)
+			ing := ringDesc.Ingesters[id]
+			ing.Timestamp = time.Now().Add(-age).Unix()
+			ringDesc.Ingesters[id] = ing
+		}
+		return ringDesc, true, nil
+	}))
+
+	lifecyclerConfig := testLifecyclerConfig(ringConfig, "ing2")
+	lifecyclerConfig.HeartbeatPeriod = 100 * time.Millisecond
+	lifecyclerConfig.AutoForgetUnhealthyPeriods = 2
+	l, err := NewLifecycler(lifecyclerConfig, &noTransferFlushTransferer{}, "ingester")
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	// Only ing0, without a heartbeat for more than 2 heartbeat timeouts, is
+	// forgotten.
+	test.Poll(t, time.Second, []string{"ing1", "ing2"}, func() interface{} {
+		d, err := ringConfig.KVStore.Mock.Get(context.Background(), ConsulKey)
+		require.NoError(t, err)
+		var ids []string
+		for id := range d.(*Desc).Ingesters {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids
+	})
+}
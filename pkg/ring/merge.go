@@ -0,0 +1,117 @@
+package ring
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/memberlist"
+)
+
+// stateRanks orders the states of the ingesters by their lifecycle, to pick
+// one of two entries of the same ingester with the same timestamp.
+var stateRanks = map[IngesterState]int{
+	PENDING: 0,
+	JOINING: 1,
+	ACTIVE:  2,
+	LEAVING: 3,
+	LEFT:    4,
+}
+
+// Merge merges the other ring into this one, for the memberlist KV store,
+// keeping the latest entry of each ingester. On a local CAS, other is the ring
+// updated by the CAS: its entries override ours, and the ingesters missing from
+// it are replaced by LEFT tombstones, so that they're removed by the other
+// members too. Merge returns the entries which changed, or nil.
+func (d *Desc) Merge(mergeable memberlist.Mergeable, localCAS bool) (memberlist.Mergeable, error) {
+	if mergeable == nil {
+		return nil, nil
+	}
+	other, ok := mergeable.(*Desc)
+	if !ok {
+		return nil, fmt.Errorf("expected *ring.Desc, got %T", mergeable)
+	}
+	if other == nil {
+		return nil, nil
+	}
+	if len(d.Tokens) > 0 || len(other.Tokens) > 0 {
+		return nil, fmt.Errorf("the tokens of the ring must be normalised to gossip it")
+	}
+
+	if d.Ingesters == nil {
+		d.Ingesters = map[string]IngesterDesc{}
+	}
+	changed := map[string]IngesterDesc{}
+	for id, ing := range other.Ingesters {
+		current, found := d.Ingesters[id]
+		if localCAS {
+			if found && current.Equal(ing) {
+				continue
+			}
+			// The local changes win over the entries they were made on.
+			if found && ing.Timestamp <= current.Timestamp {
+				ing.Timestamp = current.Timestamp + 1
+			}
+		} else if found && !newerIngesterDesc(ing, current) {
+			continue
+		}
+		d.Ingesters[id] = ing
+		changed[id] = ing
+	}
+
+	if localCAS {
+		now := time.Now().Unix()
+		for id, current := range d.Ingesters {
+			if _, ok := other.Ingesters[id]; ok || current.State == LEFT {
+				continue
+			}
+			tombstone := IngesterDesc{
+				Addr:      current.Addr,
+				Timestamp: now,
+				State:     LEFT,
+				Zone:      current.Zone,
+			}
+			if tombstone.Timestamp <= current.Timestamp {
+				tombstone.Timestamp = current.Timestamp + 1
+			}
+			d.Ingesters[id] = tombstone
+			changed[id] = tombstone
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	return &Desc{Ingesters: changed}, nil
+}
+
+// MergeContent returns the IDs of the ingesters of the ring.
+func (d *Desc) MergeContent() []string {
+	result := make([]string, 0, len(d.Ingesters))
+	for id := range d.Ingesters {
+		result = append(result, id)
+	}
+	return result
+}
+
+// RemoveTombstones removes the LEFT ingesters whose last update is before
+// limit, or all of them if limit is zero.
+func (d *Desc) RemoveTombstones(limit time.Time) {
+	for id, ing := range d.Ingesters {
+		if ing.State == LEFT && (limit.IsZero() || time.Unix(ing.Timestamp, 0).Before(limit)) {
+			delete(d.Ingesters, id)
+		}
+	}
+}
+
+// newerIngesterDesc returns whether a is newer than b, two entries of the same
+// ingester. The entries with the same timestamp are ordered by their states,
+// then by their contents, so that all the members pick the same one.
+func newerIngesterDesc(a, b IngesterDesc) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp > b.Timestamp
+	}
+	if stateRanks[a.State] != stateRanks[b.State] {
+		return stateRanks[a.State] > stateRanks[b.State]
+	}
+	return a.String() > b.String()
+}
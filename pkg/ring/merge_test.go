@@ -0,0 +1,102 @@
+package ring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testMergeDesc(ingesters map[string]IngesterDesc) *Desc {
+	d := NewDesc()
+	for id, ing := range ingesters {
+		d.Ingesters[id] = ing
+	}
+	return d
+}
+
+func TestDescMerge(t *testing.T) {
+	now := time.Now().Unix()
+	first := func() *Desc {
+		return testMergeDesc(map[string]IngesterDesc{
+			"ing1": {Addr: "addr1", Timestamp: now - 10, State: ACTIVE, Tokens: []uint32{1}},
+			"ing2": {Addr: "addr2", Timestamp: now - 10, State: JOINING},
+		})
+	}
+	second := func() *Desc {
+		return testMergeDesc(map[string]IngesterDesc{
+			"ing2": {Addr: "addr2", Timestamp: now - 5, State: ACTIVE, Tokens: []uint32{2}},
+			"ing3": {Addr: "addr3", Timestamp: now - 5, State: PENDING},
+		})
+	}
+
+	// The latest entries win, whatever the order of the merges.
+	a, b := first(), second()
+	change, err := a.Merge(second(), false)
+	require.NoError(t, err)
+	require.Equal(t, testMergeDesc(map[string]IngesterDesc{
+		"ing2": second().Ingesters["ing2"],
+		"ing3": second().Ingesters["ing3"],
+	}), change)
+	change, err = b.Merge(first(), false)
+	require.NoError(t, err)
+	require.Equal(t, testMergeDesc(map[string]IngesterDesc{"ing1": first().Ingesters["ing1"]}), change)
+	require.Equal(t, a, b)
+
+	// Merging the same entries again doesn't change anything.
+	change, err = a.Merge(second(), false)
+	require.NoError(t, err)
+	require.Nil(t, change)
+
+	// The entries with the same timestamp are picked by their states.
+	left := testMergeDesc(map[string]IngesterDesc{"ing3": {Addr: "addr3", Timestamp: now - 5, State: LEFT}})
+	change, err = a.Merge(left, false)
+	require.NoError(t, err)
+	require.Equal(t, left, change)
+	change, err = a.Merge(second(), false)
+	require.NoError(t, err)
+	require.Nil(t, change)
+
+	_, err = a.Merge(&Desc{Tokens: []TokenDesc{{Token: 1, Ingester: "ing1"}}}, false)
+	require.Error(t, err)
+}
+
+func TestDescMergeLocalCAS(t *testing.T) {
+	now := time.Now().Unix()
+	stored := testMergeDesc(map[string]IngesterDesc{
+		"ing1": {Addr: "addr1", Timestamp: now + 10, State: ACTIVE, Tokens: []uint32{1}},
+		"ing2": {Addr: "addr2", Timestamp: now - 10, State: ACTIVE, Tokens: []uint32{2}},
+		"ing3": {Addr: "addr3", Timestamp: now - 10, State: LEFT},
+	})
+
+	// The CAS updates ing1 in the same second and removes ing2.
+	updated := testMergeDesc(map[string]IngesterDesc{
+		"ing1": {Addr: "addr1", Timestamp: now + 10, State: LEAVING, Tokens: []uint32{1}},
+		"ing3": {Addr: "addr3", Timestamp: now - 10, State: LEFT},
+	})
+	change, err := stored.Merge(updated, true)
+	require.NoError(t, err)
+	require.Equal(t, testMergeDesc(map[string]IngesterDesc{
+		"ing1": {Addr: "addr1", Timestamp: now + 11, State: LEAVING, Tokens: []uint32{1}},
+		"ing2": {Addr: "addr2", Timestamp: stored.Ingesters["ing2"].Timestamp, State: LEFT},
+	}), change)
+	require.True(t, stored.Ingesters["ing2"].Timestamp >= now)
+	require.Equal(t, LEFT, stored.Ingesters["ing2"].State)
+	require.Empty(t, stored.Ingesters["ing2"].Tokens)
+
+	// The tombstones are removed once old enough.
+	stored.RemoveTombstones(time.Unix(now-5, 0))
+	require.Equal(t, []string{"ing1", "ing2"}, sortedIngesterIDs(stored))
+	stored.RemoveTombstones(time.Time{})
+	require.Equal(t, []string{"ing1"}, sortedIngesterIDs(stored))
+}
+
+func sortedIngesterIDs(d *Desc) []string {
+	var ids []string
+	for _, id := range []string{"ing1", "ing2", "ing3"} {
+		if _, ok := d.Ingesters[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
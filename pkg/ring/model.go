@@ -24,7 +24,7 @@ func ProtoDescFactory() proto.Message {
 
 // GetCodec returns the codec used to encode and decode data being put by ring.
 func GetCodec() codec.Codec {
-	return codec.Proto{Factory: ProtoDescFactory}
+	return codec.NewProtoCodec("ringDesc", ProtoDescFactory)
 }
 
 // NewDesc returns an empty ring.Desc
@@ -34,8 +34,8 @@ func NewDesc() *Desc {
 	}
 }
 
-// AddIngester adds the given ingester to the ring.
-func (d *Desc) AddIngester(id, addr string, tokens []uint32, state IngesterState, normaliseTokens bool) {
+// AddIngester adds the given ingester to the ring, in its availability zone.
+func (d *Desc) AddIngester(id, addr, zone string, tokens []uint32, state IngesterState, normaliseTokens bool) {
 	if d.Ingesters == nil {
 		d.Ingesters = map[string]IngesterDesc{}
 	}
@@ -44,6 +44,7 @@ func (d *Desc) AddIngester(id, addr string, tokens []uint32, state IngesterState
 		Addr:      addr,
 		Timestamp: time.Now().Unix(),
 		State:     state,
+		Zone:      zone,
 	}
 
 	if normaliseTokens {
@@ -133,6 +134,9 @@ func (d *Desc) FindIngestersByState(state IngesterState) []IngesterDesc {
 func (d *Desc) Ready(heartbeatTimeout time.Duration) error {
 	numTokens := len(d.Tokens)
 	for id, ingester := range d.Ingesters {
+		if ingester.State == LEFT {
+			continue
+		}
 		if time.Now().Sub(time.Unix(ingester.Timestamp, 0)) > heartbeatTimeout {
 			return fmt.Errorf("ingester %s past heartbeat timeout", id)
 		} else if ingester.State != ACTIVE {
@@ -3,6 +3,8 @@ package ring
 import (
 	"fmt"
 	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
 )
 
 // replicationStrategy decides, given the set of ingesters eligible for a key,
@@ -37,8 +39,8 @@ func (r *Ring) replicationStrategy(ingesters []IngesterDesc, op Operation) ([]In
 	// This is just a shortcut - if there are not minSuccess available ingesters,
 	// after filtering out dead ones, don't even bother trying.
 	if maxFailure < 0 || len(ingesters) < minSuccess {
-		err := fmt.Errorf("at least %d live ingesters required, could only find %d",
-			minSuccess, len(ingesters))
+		err := util.UnavailableError{Err: fmt.Errorf("at least %d live ingesters required, could only find %d",
+			minSuccess, len(ingesters))}
 		return nil, 0, err
 	}
 
@@ -16,7 +16,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/cortexproject/cortex/pkg/ring/kv"
-	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
 	"github.com/cortexproject/cortex/pkg/util"
 )
 
@@ -57,13 +56,20 @@ func (x uint32s) Less(i, j int) bool { return x[i] < x[j] }
 func (x uint32s) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
 
 // ErrEmptyRing is the error returned when trying to get an element when nothing has been added to hash.
-var ErrEmptyRing = errors.New("empty ring")
+var ErrEmptyRing = util.UnavailableError{Err: errors.New("empty ring")}
 
 // Config for a Ring
 type Config struct {
 	KVStore           kv.Config     `yaml:"kvstore,omitempty"`
 	HeartbeatTimeout  time.Duration `yaml:"heartbeat_timeout,omitempty"`
 	ReplicationFactor int           `yaml:"replication_factor,omitempty"`
+
+	// Spread the replicas of each key across the availability zones of the
+	// ingesters, one per zone.
+	ZoneAwarenessEnabled bool `yaml:"zone_awareness_enabled,omitempty"`
+	// How much more than their fair share of their zone the instances may
+	// own in a zone-aware ring.
+	ZoneMaxImbalance float64 `yaml:"zone_max_imbalance,omitempty"`
 }
 
 // RegisterFlags adds the flags required to config this to the given FlagSet with a specified prefix
@@ -77,6 +83,8 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 
 	f.DurationVar(&cfg.HeartbeatTimeout, prefix+"ring.heartbeat-timeout", time.Minute, "The heartbeat timeout after which ingesters are skipped for reads/writes.")
 	f.IntVar(&cfg.ReplicationFactor, prefix+"distributor.replication-factor", 3, "The number of ingesters to write to and read from.")
+	f.BoolVar(&cfg.ZoneAwarenessEnabled, prefix+"distributor.zone-awareness-enabled", false, "Spread the replicas of each key across the availability zones of the ingesters, which need as many zones as replicas.")
+	f.Float64Var(&cfg.ZoneMaxImbalance, prefix+"distributor.zone-max-imbalance", 0.5, "How much more than their fair share of their zone the instances may own in a zone-aware ring, e.g. 0.5 for 50%, before the tokens of the ring are reported as unbalanced.")
 }
 
 // Ring holds the information about the members of the consistent hash ring.
@@ -89,11 +97,14 @@ type Ring struct {
 
 	mtx      sync.RWMutex
 	ringDesc *Desc
+	// The error of the check of the zones of a zone-aware ring, if any.
+	zonesErr error
 
 	memberOwnershipDesc *prometheus.Desc
 	numMembersDesc      *prometheus.Desc
 	totalTokensDesc     *prometheus.Desc
 	numTokensDesc       *prometheus.Desc
+	zonesBalancedDesc   *prometheus.Desc
 }
 
 // New creates a new Ring
@@ -101,7 +112,7 @@ func New(cfg Config, name string) (*Ring, error) {
 	if cfg.ReplicationFactor <= 0 {
 		return nil, fmt.Errorf("ReplicationFactor must be greater than zero: %d", cfg.ReplicationFactor)
 	}
-	codec := codec.Proto{Factory: ProtoDescFactory}
+	codec := GetCodec()
 	store, err := kv.NewClient(cfg.KVStore, codec)
 	if err != nil {
 		return nil, err
@@ -133,6 +144,11 @@ func New(cfg Config, name string) (*Ring, error) {
 			"The number of tokens in the ring owned by the member",
 			[]string{"member", "name"}, nil,
 		),
+		zonesBalancedDesc: prometheus.NewDesc(
+			"cortex_ring_zones_balanced",
+			"Whether the zone-aware ring has a zone per replica, and balanced tokens in each zone.",
+			[]string{"name"}, nil,
+		),
 	}
 	var ctx context.Context
 	ctx, r.quit = context.WithCancel(context.Background())
@@ -155,10 +171,19 @@ func (r *Ring) loop(ctx context.Context) {
 		}
 
 		ringDesc := value.(*Desc)
+		ringDesc.RemoveTombstones(time.Time{})
 		ringDesc.Tokens = migrateRing(ringDesc)
+		var zonesErr error
+		if r.cfg.ZoneAwarenessEnabled {
+			zonesErr = checkZoneTokens(ringDesc, ringDesc.Tokens, r.cfg.ReplicationFactor, r.cfg.ZoneMaxImbalance)
+		}
 		r.mtx.Lock()
 		defer r.mtx.Unlock()
+		if zonesErr != nil && (r.zonesErr == nil || r.zonesErr.Error() != zonesErr.Error()) {
+			level.Warn(util.Logger).Log("msg", "the zones of the ring aren't balanced", "ring", r.name, "err", zonesErr)
+		}
 		r.ringDesc = ringDesc
+		r.zonesErr = zonesErr
 		return true
 	})
 }
@@ -191,10 +216,46 @@ func (r *Ring) Get(key uint32, op Operation, buf []IngesterDesc) (ReplicationSet
 		return ReplicationSet{}, ErrEmptyRing
 	}
 
+	ingesters := r.replicas(key, op, buf)
+	liveIngesters, maxFailure, err := r.replicationStrategy(ingesters, op)
+	if err != nil {
+		return ReplicationSet{}, err
+	}
+
+	return ReplicationSet{
+		Ingesters: liveIngesters,
+		MaxErrors: maxFailure,
+	}, nil
+}
+
+// GetReplicas returns the healthy ingesters among the replicas for the given
+// key, without requiring a quorum of them, for the data any replica serves
+// on its own, e.g. the blocks loaded by the store-gateways.
+func (r *Ring) GetReplicas(key uint32, op Operation) ([]IngesterDesc, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	if r.ringDesc == nil || len(r.ringDesc.Tokens) == 0 {
+		return nil, ErrEmptyRing
+	}
+
+	ingesters := r.replicas(key, op, nil)
+	healthy := ingesters[:0]
+	for i := range ingesters {
+		if r.IsHealthy(&ingesters[i], op) {
+			healthy = append(healthy, ingesters[i])
+		}
+	}
+	return healthy, nil
+}
+
+// replicas returns the ingesters of the replicas for the given key, before
+// the unhealthy ones are filtered out. r.mtx must be held.
+func (r *Ring) replicas(key uint32, op Operation, buf []IngesterDesc) []IngesterDesc {
 	var (
 		n             = r.cfg.ReplicationFactor
 		ingesters     = buf[:0]
 		distinctHosts = map[string]struct{}{}
+		distinctZones = map[string]struct{}{}
 		start         = r.search(key)
 		iterations    = 0
 	)
@@ -208,9 +269,16 @@ func (r *Ring) Get(key uint32, op Operation, buf []IngesterDesc) (ReplicationSet
 		if _, ok := distinctHosts[token.Ingester]; ok {
 			continue
 		}
-		distinctHosts[token.Ingester] = struct{}{}
 		ingester := r.ringDesc.Ingesters[token.Ingester]
 
+		// And each of them in a distinct zone when zone-aware, the ingesters
+		// without a zone being in zones of their own.
+		zoneAware := r.cfg.ZoneAwarenessEnabled && ingester.Zone != ""
+		if _, ok := distinctZones[ingester.Zone]; ok && zoneAware {
+			continue
+		}
+		distinctHosts[token.Ingester] = struct{}{}
+
 		// We do not want to Write to Ingesters that are not ACTIVE, but we do want
 		// to write the extra replica somewhere.  So we increase the size of the set
 		// of replicas for the key. This means we have to also increase the
@@ -221,20 +289,14 @@ func (r *Ring) Get(key uint32, op Operation, buf []IngesterDesc) (ReplicationSet
 			n++
 		} else if op == Read && (ingester.State != ACTIVE && ingester.State != LEAVING) {
 			n++
+		} else if zoneAware {
+			// The extra replicas may be in the zones of the ones they replace.
+			distinctZones[ingester.Zone] = struct{}{}
 		}
 
 		ingesters = append(ingesters, ingester)
 	}
-
-	liveIngesters, maxFailure, err := r.replicationStrategy(ingesters, op)
-	if err != nil {
-		return ReplicationSet{}, err
-	}
-
-	return ReplicationSet{
-		Ingesters: liveIngesters,
-		MaxErrors: maxFailure,
-	}, nil
+	return ingesters
 }
 
 // GetAll returns all available ingesters in the ring.
@@ -258,7 +320,7 @@ func (r *Ring) GetAll() (ReplicationSet, error) {
 	}
 
 	if maxErrors < 0 {
-		return ReplicationSet{}, fmt.Errorf("too many failed ingesters")
+		return ReplicationSet{}, util.UnavailableError{Err: fmt.Errorf("too many failed ingesters")}
 	}
 
 	return ReplicationSet{
@@ -283,6 +345,7 @@ func (r *Ring) Describe(ch chan<- *prometheus.Desc) {
 	ch <- r.numMembersDesc
 	ch <- r.totalTokensDesc
 	ch <- r.numTokensDesc
+	ch <- r.zonesBalancedDesc
 }
 
 func countTokens(ringDesc *Desc) (map[string]uint32, map[string]uint32) {
@@ -365,4 +428,16 @@ func (r *Ring) Collect(ch chan<- prometheus.Metric) {
 		float64(len(r.ringDesc.Tokens)),
 		r.name,
 	)
+	if r.cfg.ZoneAwarenessEnabled {
+		balanced := 1.0
+		if r.zonesErr != nil {
+			balanced = 0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			r.zonesBalancedDesc,
+			prometheus.GaugeValue,
+			balanced,
+			r.name,
+		)
+	}
 }
@@ -33,6 +33,9 @@ const (
 	LEAVING IngesterState = 1
 	PENDING IngesterState = 2
 	JOINING IngesterState = 3
+	// This state is only used by gossiping code to distribute information about
+	// ingesters that have been removed from the ring. Ring users should not use it directly.
+	LEFT IngesterState = 4
 )
 
 var IngesterState_name = map[int32]string{
@@ -40,6 +43,7 @@ var IngesterState_name = map[int32]string{
 	1: "LEAVING",
 	2: "PENDING",
 	3: "JOINING",
+	4: "LEFT",
 }
 
 var IngesterState_value = map[string]int32{
@@ -47,6 +51,7 @@ var IngesterState_value = map[string]int32{
 	"LEAVING": 1,
 	"PENDING": 2,
 	"JOINING": 3,
+	"LEFT":    4,
 }
 
 func (IngesterState) EnumDescriptor() ([]byte, []int) {
@@ -109,6 +114,7 @@ type IngesterDesc struct {
 	Timestamp int64         `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	State     IngesterState `protobuf:"varint,3,opt,name=state,proto3,enum=ring.IngesterState" json:"state,omitempty"`
 	Tokens    []uint32      `protobuf:"varint,6,rep,packed,name=tokens,proto3" json:"tokens,omitempty"`
+	Zone      string        `protobuf:"bytes,7,opt,name=zone,proto3" json:"zone,omitempty"`
 }
 
 func (m *IngesterDesc) Reset()      { *m = IngesterDesc{} }
@@ -171,6 +177,13 @@ func (m *IngesterDesc) GetTokens() []uint32 {
 	return nil
 }
 
+func (m *IngesterDesc) GetZone() string {
+	if m != nil {
+		return m.Zone
+	}
+	return ""
+}
+
 type TokenDesc struct {
 	Token    uint32 `protobuf:"varint,1,opt,name=token,proto3" json:"token,omitempty"`
 	Ingester string `protobuf:"bytes,2,opt,name=ingester,proto3" json:"ingester,omitempty"`
@@ -233,34 +246,35 @@ func init() {
 func init() { proto.RegisterFile("ring.proto", fileDescriptor_26381ed67e202a6e) }
 
 var fileDescriptor_26381ed67e202a6e = []byte{
-	// 418 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x92, 0x4d, 0x6e, 0xd3, 0x40,
-	0x14, 0xc7, 0xe7, 0xf9, 0x8b, 0xf8, 0x85, 0x14, 0x6b, 0x40, 0xc8, 0x44, 0x68, 0xb0, 0xb2, 0x32,
-	0x48, 0x75, 0xa5, 0xc0, 0x02, 0x21, 0x75, 0xd1, 0x50, 0x0b, 0x39, 0x42, 0xa1, 0x32, 0x55, 0xf7,
-	0x4e, 0x3b, 0x18, 0xab, 0xc4, 0xae, 0xec, 0x09, 0x52, 0x77, 0xdc, 0x00, 0x8e, 0xc1, 0x49, 0x50,
-	0x97, 0x59, 0x76, 0x85, 0x88, 0xb3, 0x61, 0xd9, 0x23, 0xa0, 0x19, 0xc7, 0x09, 0xd9, 0xbd, 0xdf,
-	0xfc, 0x3f, 0x66, 0x9e, 0x65, 0xc4, 0x32, 0xcb, 0xd3, 0xe0, 0xaa, 0x2c, 0x44, 0x41, 0x0d, 0x39,
-	0xf7, 0xf7, 0xd3, 0x4c, 0x7c, 0x9e, 0x4f, 0x83, 0xf3, 0x62, 0x76, 0x90, 0x16, 0x69, 0x71, 0xa0,
-	0xc4, 0xe9, 0xfc, 0x93, 0x22, 0x05, 0x6a, 0x6a, 0x42, 0x83, 0x5f, 0x80, 0xc6, 0x31, 0xaf, 0xce,
-	0xe9, 0x21, 0xda, 0x59, 0x9e, 0xf2, 0x4a, 0xf0, 0xb2, 0x72, 0xc1, 0xd3, 0xfd, 0xee, 0xf0, 0x49,
-	0xa0, 0xda, 0xa5, 0x1c, 0x44, 0xad, 0x16, 0xe6, 0xa2, 0xbc, 0x1e, 0x19, 0x37, 0xbf, 0x9f, 0x91,
-	0x78, 0x9b, 0xa0, 0xfb, 0x68, 0x89, 0xe2, 0x92, 0xe7, 0x95, 0xab, 0xa9, 0xec, 0x83, 0x26, 0x7b,
-	0x2a, 0xcf, 0x64, 0xc1, 0x3a, 0xb1, 0x36, 0xf5, 0x4f, 0x70, 0x6f, 0xb7, 0x91, 0x3a, 0xa8, 0x5f,
-	0xf2, 0x6b, 0x17, 0x3c, 0xf0, 0xed, 0x58, 0x8e, 0xd4, 0x47, 0xf3, 0x6b, 0xf2, 0x65, 0xce, 0x5d,
-	0xcd, 0x03, 0xbf, 0x3b, 0xa4, 0x4d, 0x63, 0x1b, 0x93, 0xa5, 0x71, 0x63, 0x78, 0xa3, 0xbd, 0x86,
-	0xc1, 0x77, 0xc0, 0xfb, 0xff, 0x6b, 0x94, 0xa2, 0x91, 0x5c, 0x5c, 0x94, 0xeb, 0x46, 0x35, 0xd3,
-	0xa7, 0x68, 0x8b, 0x6c, 0xc6, 0x2b, 0x91, 0xcc, 0xae, 0x54, 0xad, 0x1e, 0x6f, 0x0f, 0xe8, 0x73,
-	0x34, 0x2b, 0x91, 0x08, 0xee, 0xea, 0x1e, 0xf8, 0x7b, 0xc3, 0x87, 0xbb, 0x17, 0x7e, 0x94, 0x52,
-	0xdc, 0x38, 0xe8, 0xe3, 0xcd, 0xba, 0x96, 0xa7, 0xfb, 0xbd, 0x76, 0xaf, 0xb1, 0xd1, 0x31, 0x1c,
-	0x73, 0x6c, 0x74, 0x4c, 0xc7, 0x1a, 0x1c, 0xa2, 0xbd, 0x59, 0x9f, 0x3e, 0x42, 0x53, 0x59, 0xd4,
-	0x73, 0x7a, 0x71, 0x03, 0xb4, 0x8f, 0x9d, 0xf6, 0x13, 0xaa, 0xe7, 0xd8, 0xf1, 0x86, 0x5f, 0x8c,
-	0xb0, 0xb7, 0x73, 0x35, 0x45, 0xb4, 0x8e, 0xde, 0x9e, 0x46, 0x67, 0xa1, 0x43, 0x68, 0x17, 0xef,
-	0xbd, 0x0f, 0x8f, 0xce, 0xa2, 0xc9, 0x3b, 0x07, 0x24, 0x9c, 0x84, 0x93, 0x63, 0x09, 0x9a, 0x84,
-	0xf1, 0x87, 0x68, 0x22, 0x41, 0x1f, 0xbd, 0x5a, 0x2c, 0x19, 0xb9, 0x5d, 0x32, 0x72, 0xb7, 0x64,
-	0xf0, 0xad, 0x66, 0xf0, 0xb3, 0x66, 0x70, 0x53, 0x33, 0x58, 0xd4, 0x0c, 0xfe, 0xd4, 0x0c, 0xfe,
-	0xd6, 0x8c, 0xdc, 0xd5, 0x0c, 0x7e, 0xac, 0x18, 0x59, 0xac, 0x18, 0xb9, 0x5d, 0x31, 0x32, 0xb5,
-	0xd4, 0xaf, 0xf1, 0xf2, 0x5f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x72, 0x58, 0x13, 0x3d, 0x5d, 0x02,
-	0x00, 0x00,
+	// 437 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x52, 0x4d, 0x6f, 0xd3, 0x40,
+	0x10, 0xf5, 0xc4, 0x6b, 0x37, 0x9e, 0x90, 0x62, 0x0d, 0x08, 0x99, 0x08, 0x2d, 0x56, 0x4e, 0x06,
+	0xa9, 0xa9, 0x14, 0x38, 0x20, 0xa4, 0x1e, 0x5a, 0x6a, 0x50, 0xa2, 0x28, 0x54, 0x26, 0xea, 0x3d,
+	0x69, 0x17, 0x13, 0x95, 0xd8, 0x95, 0xbd, 0x41, 0x2a, 0x27, 0x7e, 0x02, 0xff, 0x81, 0x0b, 0xbf,
+	0x04, 0xf5, 0x98, 0x63, 0x4f, 0x88, 0x38, 0x17, 0x8e, 0xfd, 0x09, 0x68, 0xd7, 0x71, 0x4a, 0x6e,
+	0xef, 0xed, 0x9b, 0xf7, 0xe6, 0x43, 0x8b, 0x98, 0x4d, 0x93, 0xb8, 0x73, 0x99, 0xa5, 0x32, 0x25,
+	0xa6, 0x70, 0x6b, 0x2f, 0x9e, 0xca, 0x4f, 0xf3, 0x49, 0xe7, 0x2c, 0x9d, 0xed, 0xc7, 0x69, 0x9c,
+	0xee, 0x6b, 0x71, 0x32, 0xff, 0xa8, 0x99, 0x26, 0x1a, 0x95, 0xa6, 0xf6, 0x2f, 0x40, 0x76, 0x2c,
+	0xf2, 0x33, 0x3a, 0x40, 0x67, 0x9a, 0xc4, 0x22, 0x97, 0x22, 0xcb, 0x3d, 0xf0, 0xcd, 0xa0, 0xd1,
+	0x7d, 0xdc, 0xd1, 0xe9, 0x4a, 0xee, 0xf4, 0x2a, 0x2d, 0x4c, 0x64, 0x76, 0x75, 0xc4, 0xae, 0x7f,
+	0x3f, 0x35, 0xa2, 0x3b, 0x07, 0xed, 0xa1, 0x2d, 0xd3, 0x0b, 0x91, 0xe4, 0x5e, 0x4d, 0x7b, 0xef,
+	0x97, 0xde, 0x91, 0x7a, 0x53, 0x01, 0x6b, 0xc7, 0xba, 0xa8, 0x75, 0x82, 0xbb, 0xdb, 0x89, 0xe4,
+	0xa2, 0x79, 0x21, 0xae, 0x3c, 0xf0, 0x21, 0x70, 0x22, 0x05, 0x29, 0x40, 0xeb, 0xcb, 0xf8, 0xf3,
+	0x5c, 0x78, 0x35, 0x1f, 0x82, 0x46, 0x97, 0xca, 0xc4, 0xca, 0xa6, 0x42, 0xa3, 0xb2, 0xe0, 0x75,
+	0xed, 0x15, 0xb4, 0x7f, 0x00, 0xde, 0xfb, 0x5f, 0x23, 0x42, 0x36, 0x3e, 0x3f, 0xcf, 0xd6, 0x89,
+	0x1a, 0xd3, 0x13, 0x74, 0xe4, 0x74, 0x26, 0x72, 0x39, 0x9e, 0x5d, 0xea, 0x58, 0x33, 0xba, 0x7b,
+	0xa0, 0x67, 0x68, 0xe5, 0x72, 0x2c, 0x85, 0x67, 0xfa, 0x10, 0xec, 0x76, 0x1f, 0x6c, 0x37, 0xfc,
+	0xa0, 0xa4, 0xa8, 0xac, 0xa0, 0x47, 0x9b, 0x75, 0x6d, 0xdf, 0x0c, 0x9a, 0xd5, 0x5e, 0xaa, 0xe9,
+	0xd7, 0x34, 0x11, 0xde, 0x4e, 0xd9, 0x54, 0xe1, 0x3e, 0xab, 0x33, 0xd7, 0xea, 0xb3, 0xba, 0xe5,
+	0xda, 0xed, 0x03, 0x74, 0x36, 0x27, 0xa1, 0x87, 0x68, 0x69, 0x9b, 0x1e, 0xb1, 0x19, 0x95, 0x84,
+	0x5a, 0x58, 0xaf, 0xce, 0xaa, 0x47, 0x74, 0xa2, 0x0d, 0x7f, 0x3e, 0xc0, 0xe6, 0xd6, 0x38, 0x84,
+	0x68, 0x1f, 0xbe, 0x19, 0xf5, 0x4e, 0x43, 0xd7, 0xa0, 0x06, 0xee, 0x0c, 0xc2, 0xc3, 0xd3, 0xde,
+	0xf0, 0x9d, 0x0b, 0x8a, 0x9c, 0x84, 0xc3, 0x63, 0x45, 0x6a, 0x8a, 0xf4, 0xdf, 0xf7, 0x86, 0x8a,
+	0x98, 0x54, 0x47, 0x36, 0x08, 0xdf, 0x8e, 0x5c, 0x76, 0xf4, 0x72, 0xb1, 0xe4, 0xc6, 0xcd, 0x92,
+	0x1b, 0xb7, 0x4b, 0x0e, 0xdf, 0x0a, 0x0e, 0x3f, 0x0b, 0x0e, 0xd7, 0x05, 0x87, 0x45, 0xc1, 0xe1,
+	0x4f, 0xc1, 0xe1, 0x6f, 0xc1, 0x8d, 0xdb, 0x82, 0xc3, 0xf7, 0x15, 0x37, 0x16, 0x2b, 0x6e, 0xdc,
+	0xac, 0xb8, 0x31, 0xb1, 0xf5, 0xc7, 0x79, 0xf1, 0x2f, 0x00, 0x00, 0xff, 0xff, 0xa8, 0x63, 0x21,
+	0xee, 0x7b, 0x02, 0x00, 0x00,
 }
 
 func (x IngesterState) String() string {
@@ -345,6 +359,9 @@ func (this *IngesterDesc) Equal(that interface{}) bool {
 			return false
 		}
 	}
+	if this.Zone != that1.Zone {
+		return false
+	}
 	return true
 }
 func (this *TokenDesc) Equal(that interface{}) bool {
@@ -407,12 +424,13 @@ func (this *IngesterDesc) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 8)
+	s := make([]string, 0, 9)
 	s = append(s, "&ring.IngesterDesc{")
 	s = append(s, "Addr: "+fmt.Sprintf("%#v", this.Addr)+",\n")
 	s = append(s, "Timestamp: "+fmt.Sprintf("%#v", this.Timestamp)+",\n")
 	s = append(s, "State: "+fmt.Sprintf("%#v", this.State)+",\n")
 	s = append(s, "Tokens: "+fmt.Sprintf("%#v", this.Tokens)+",\n")
+	s = append(s, "Zone: "+fmt.Sprintf("%#v", this.Zone)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -539,6 +557,12 @@ func (m *IngesterDesc) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintRing(dAtA, i, uint64(j2))
 		i += copy(dAtA[i:], dAtA3[:j2])
 	}
+	if len(m.Zone) > 0 {
+		dAtA[i] = 0x3a
+		i++
+		i = encodeVarintRing(dAtA, i, uint64(len(m.Zone)))
+		i += copy(dAtA[i:], m.Zone)
+	}
 	return i, nil
 }
 
@@ -627,6 +651,10 @@ func (m *IngesterDesc) Size() (n int) {
 		}
 		n += 1 + sovRing(uint64(l)) + l
 	}
+	l = len(m.Zone)
+	if l > 0 {
+		n += 1 + l + sovRing(uint64(l))
+	}
 	return n
 }
 
@@ -689,6 +717,7 @@ func (this *IngesterDesc) String() string {
 		`Timestamp:` + fmt.Sprintf("%v", this.Timestamp) + `,`,
 		`State:` + fmt.Sprintf("%v", this.State) + `,`,
 		`Tokens:` + fmt.Sprintf("%v", this.Tokens) + `,`,
+		`Zone:` + fmt.Sprintf("%v", this.Zone) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1103,6 +1132,38 @@ func (m *IngesterDesc) Unmarshal(dAtA []byte) error {
 			} else {
 				return fmt.Errorf("proto: wrong wireType = %d for field Tokens", wireType)
 			}
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Zone", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRing
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRing
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRing
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Zone = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRing(dAtA[iNdEx:])
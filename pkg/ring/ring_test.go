@@ -35,7 +35,7 @@ func benchmarkBatch(b *testing.B, numIngester, numKeys int) {
 	for i := 0; i < numIngester; i++ {
 		tokens := GenerateTokens(numTokens, takenTokens)
 		takenTokens = append(takenTokens, tokens...)
-		desc.AddIngester(fmt.Sprintf("%d", i), fmt.Sprintf("ingester%d", i), tokens, ACTIVE, false)
+		desc.AddIngester(fmt.Sprintf("%d", i), fmt.Sprintf("ingester%d", i), "", tokens, ACTIVE, false)
 	}
 
 	cfg := Config{}
@@ -68,3 +68,90 @@ func generateKeys(r *rand.Rand, numTokens int, dest []uint32) {
 		dest[i] = r.Uint32()
 	}
 }
+
+func TestRingGetReplicas(t *testing.T) {
+	desc := NewDesc()
+	takenTokens := []uint32{}
+	for i := 0; i < 3; i++ {
+		tokens := GenerateTokens(numTokens, takenTokens)
+		takenTokens = append(takenTokens, tokens...)
+		desc.AddIngester(fmt.Sprintf("%d", i), fmt.Sprintf("ingester%d", i), "", tokens, ACTIVE, false)
+	}
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	r := Ring{
+		name:     "ingester",
+		cfg:      cfg,
+		ringDesc: desc,
+	}
+
+	replicas, err := r.GetReplicas(1234, Read)
+	require.NoError(t, err)
+	require.Len(t, replicas, 3)
+
+	// The replicas which are healthy are returned without a quorum.
+	for _, id := range []string{"0", "1"} {
+		ing := desc.Ingesters[id]
+		ing.Timestamp = 0
+		desc.Ingesters[id] = ing
+	}
+	_, err = r.Get(1234, Read, nil)
+	require.Error(t, err)
+	replicas, err = r.GetReplicas(1234, Read)
+	require.NoError(t, err)
+	require.Len(t, replicas, 1)
+	require.Equal(t, "ingester2", replicas[0].Addr)
+
+	r.ringDesc = NewDesc()
+	_, err = r.GetReplicas(1234, Read)
+	require.Equal(t, ErrEmptyRing, err)
+}
+
+func TestRingGetReplicasZoneAware(t *testing.T) {
+	desc := NewDesc()
+	takenTokens := []uint32{}
+	for i := 0; i < 6; i++ {
+		tokens := GenerateTokens(numTokens, takenTokens)
+		takenTokens = append(takenTokens, tokens...)
+		desc.AddIngester(fmt.Sprintf("%d", i), fmt.Sprintf("ingester%d", i), fmt.Sprintf("zone%d", i%3), tokens, ACTIVE, false)
+	}
+
+	cfg := Config{}
+	flagext.DefaultValues(&cfg)
+	cfg.ZoneAwarenessEnabled = true
+	r := Ring{
+		name:     "ingester",
+		cfg:      cfg,
+		ringDesc: desc,
+	}
+
+	zones := func(replicas []IngesterDesc) map[string]int {
+		byZone := map[string]int{}
+		for _, ing := range replicas {
+			byZone[ing.Zone]++
+		}
+		return byZone
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < 100; i++ {
+		replicas, err := r.GetReplicas(rnd.Uint32(), Read)
+		require.NoError(t, err)
+		require.Len(t, replicas, 3)
+		require.Equal(t, map[string]int{"zone0": 1, "zone1": 1, "zone2": 1}, zones(replicas))
+	}
+
+	// The replicas replacing the ones not active may be in their zones.
+	for _, id := range []string{"0", "3"} {
+		ing := desc.Ingesters[id]
+		ing.State = PENDING
+		desc.Ingesters[id] = ing
+	}
+	for i := 0; i < 100; i++ {
+		replicas, err := r.GetReplicas(rnd.Uint32(), Read)
+		require.NoError(t, err)
+		byZone := zones(replicas)
+		require.Equal(t, 1, byZone["zone1"])
+		require.Equal(t, 1, byZone["zone2"])
+	}
+}
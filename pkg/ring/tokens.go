@@ -0,0 +1,51 @@
+package ring
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type tokensFile struct {
+	Tokens []uint32 `json:"tokens"`
+}
+
+// storeTokensToFile atomically replaces the content of the tokens file.
+func storeTokensToFile(path string, tokens []uint32) error {
+	b, err := json.Marshal(tokensFile{Tokens: tokens})
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// loadTokensFromFile returns the sorted tokens stored in the tokens file.
+func loadTokensFromFile(path string) ([]uint32, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f tokensFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	sort.Sort(sortableUint32(f.Tokens))
+	return f.Tokens, nil
+}
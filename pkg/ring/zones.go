@@ -0,0 +1,153 @@
+package ring
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ringSize is the number of tokens of the ring.
+const ringSize = uint64(math.MaxUint32) + 1
+
+// GenerateZoneTokens makes numTokens unique tokens for an instance of a zone,
+// each of them splitting the largest range between the tokens of the zone,
+// so that the instances of the zone own balanced shares of the ring. None of
+// them clash with takenTokens, the tokens of all the zones.
+func GenerateZoneTokens(numTokens int, zoneTokens, takenTokens []uint32) []uint32 {
+	used := make(map[uint32]bool, len(takenTokens))
+	for _, v := range takenTokens {
+		used[v] = true
+	}
+
+	tokens := make([]uint32, 0, numTokens)
+	planned := append([]uint32(nil), zoneTokens...)
+	sort.Sort(sortableUint32(planned))
+	if len(planned) == 0 && numTokens > 0 {
+		// The first instance of the zone starts anywhere.
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		planned = append(planned, nextFreeToken(r.Uint32(), used))
+		tokens = append(tokens, planned[0])
+	}
+
+	for len(tokens) < numTokens {
+		// The largest range, wrapping around the ring for the last token.
+		start, size := planned[len(planned)-1], uint64(planned[0])+ringSize-uint64(planned[len(planned)-1])
+		for i := 1; i < len(planned); i++ {
+			if s := uint64(planned[i] - planned[i-1]); s > size {
+				start, size = planned[i-1], s
+			}
+		}
+		if size < 2 {
+			// The zone has no room left.
+			break
+		}
+
+		token := nextFreeToken(start+uint32(size/2), used)
+		tokens = append(tokens, token)
+		ix := sort.Search(len(planned), func(i int) bool { return planned[i] >= token })
+		planned = append(planned, 0)
+		copy(planned[ix+1:], planned[ix:])
+		planned[ix] = token
+	}
+	return tokens
+}
+
+// nextFreeToken returns the first token from token not in used, and marks it
+// as used.
+func nextFreeToken(token uint32, used map[uint32]bool) uint32 {
+	for used[token] {
+		token++
+	}
+	used[token] = true
+	return token
+}
+
+// zoneTokens returns the tokens of the instances of the zone, other than the
+// instance id.
+func (d *Desc) zoneTokens(zone, id string) []uint32 {
+	var tokens []uint32
+	for _, token := range migrateRing(d) {
+		if ing, ok := d.Ingesters[token.Ingester]; ok && token.Ingester != id && ing.Zone == zone && ing.State != LEFT {
+			tokens = append(tokens, token.Token)
+		}
+	}
+	return tokens
+}
+
+// otherTokens returns the tokens of the instances other than the instance id.
+func (d *Desc) otherTokens(id string) []uint32 {
+	var tokens []uint32
+	for _, token := range migrateRing(d) {
+		if ing, ok := d.Ingesters[token.Ingester]; ok && token.Ingester != id && ing.State != LEFT {
+			tokens = append(tokens, token.Token)
+		}
+	}
+	return tokens
+}
+
+// checkZoneTokens checks the tokens of a zone-aware ring, sorted and
+// denormalised: each of the replicas of a key needs a zone of its own, the
+// instances without a zone being in zones of their own, and the instances of
+// each zone must own balanced shares of the ring, at most maxImbalance more
+// than their fair share, so that the loss of a zone only affects a single
+// replica of each key, and the instances left in the other zones don't get
+// more than their share of it.
+func checkZoneTokens(d *Desc, tokens []TokenDesc, replicationFactor int, maxImbalance float64) error {
+	tokensByZone := map[string][]TokenDesc{}
+	zones := 0
+	for _, token := range tokens {
+		ing, ok := d.Ingesters[token.Ingester]
+		if !ok || ing.State == LEFT {
+			continue
+		}
+		zone := ing.Zone
+		if zone == "" {
+			zone = "instance " + token.Ingester
+		}
+		if _, ok := tokensByZone[zone]; !ok {
+			zones++
+		}
+		tokensByZone[zone] = append(tokensByZone[zone], token)
+	}
+	if zones < replicationFactor {
+		return fmt.Errorf("%d zones in the ring, less than the replication factor of %d", zones, replicationFactor)
+	}
+
+	zoneNames := make([]string, 0, len(tokensByZone))
+	for zone := range tokensByZone {
+		zoneNames = append(zoneNames, zone)
+	}
+	sort.Strings(zoneNames)
+
+	for _, zone := range zoneNames {
+		zoneTokens := tokensByZone[zone]
+		// Each token owns the range of the keys up to it, from the previous
+		// token of the zone.
+		owned := map[string]uint64{}
+		prev := uint64(zoneTokens[len(zoneTokens)-1].Token)
+		for _, token := range zoneTokens {
+			owned[token.Ingester] += (uint64(token.Token) + ringSize - prev) % ringSize
+			prev = uint64(token.Token)
+		}
+		if len(owned) < 2 {
+			continue
+		}
+
+		ids := make([]string, 0, len(owned))
+		for id := range owned {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		fairShare := float64(ringSize) / float64(len(owned))
+		for _, id := range ids {
+			if o := owned[id]; float64(o) > fairShare*(1+maxImbalance) {
+				return fmt.Errorf("instance %s owns %.1f%% of the ring in zone %s, more than %.0f%% over its fair share of %.1f%%",
+					id, float64(o)/float64(ringSize)*100, zone, maxImbalance*100, fairShare/float64(ringSize)*100)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package ring
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateZoneTokens(t *testing.T) {
+	desc := NewDesc()
+	var taken []uint32
+	for _, zone := range []string{"zone-a", "zone-b", "zone-c"} {
+		for i := 0; i < 4; i++ {
+			id := fmt.Sprintf("%s-%d", zone, i)
+			tokens := GenerateZoneTokens(16, desc.zoneTokens(zone, id), taken)
+			require.Len(t, tokens, 16)
+			taken = append(taken, tokens...)
+			desc.AddIngester(id, id, zone, tokens, ACTIVE, true)
+		}
+	}
+
+	// The tokens are unique, and balanced in each zone.
+	unique := map[uint32]struct{}{}
+	for _, token := range taken {
+		unique[token] = struct{}{}
+	}
+	require.Len(t, unique, len(taken))
+	require.NoError(t, checkZoneTokens(desc, migrateRing(desc), 3, 0.1))
+
+	// The tokens taken by the other zones are skipped.
+	tokens := GenerateZoneTokens(2, []uint32{0}, []uint32{0, 1 << 31})
+	require.Equal(t, []uint32{1<<31 + 1, 1 << 30}, tokens)
+}
+
+func TestCheckZoneTokens(t *testing.T) {
+	desc := NewDesc()
+	desc.AddIngester("a-1", "a-1", "zone-a", []uint32{1 << 30, 3 << 30}, ACTIVE, true)
+	desc.AddIngester("a-2", "a-2", "zone-a", []uint32{2 << 30, 0}, ACTIVE, true)
+	desc.AddIngester("b-1", "b-1", "zone-b", []uint32{1<<30 + 1}, ACTIVE, true)
+
+	// The instances without a zone are in zones of their own.
+	require.Error(t, checkZoneTokens(desc, migrateRing(desc), 3, 0.1))
+	desc.AddIngester("c-1", "c-1", "", []uint32{1<<30 + 2}, ACTIVE, true)
+	require.NoError(t, checkZoneTokens(desc, migrateRing(desc), 3, 0.1))
+
+	// a-3 owns half of zone-a, more than 10% over its third.
+	desc.AddIngester("a-1", "a-1", "zone-a", []uint32{1 << 30}, ACTIVE, true)
+	desc.AddIngester("a-2", "a-2", "zone-a", []uint32{2 << 30}, ACTIVE, true)
+	desc.AddIngester("a-3", "a-3", "zone-a", []uint32{3 << 30, 0}, ACTIVE, true)
+	err := checkZoneTokens(desc, migrateRing(desc), 3, 0.1)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "instance a-3")
+
+	// The instances which left don't count.
+	desc.Ingesters["a-3"] = IngesterDesc{State: LEFT, Zone: "zone-a", Tokens: []uint32{3 << 30, 0}}
+	require.NoError(t, checkZoneTokens(desc, migrateRing(desc), 3, 0.6))
+}
@@ -0,0 +1,265 @@
+package tsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// MetaFilename is the name of the meta file of a block. It's uploaded last,
+// so the blocks without one are still being uploaded, or partially uploaded.
+const MetaFilename = "meta.json"
+
+// The version of the meta file of the blocks written by tsdb.
+const metaVersion = 1
+
+// Meta is the meta file of a block, the tsdb one extended with the fields
+// written by Cortex.
+type Meta struct {
+	tsdb.BlockMeta
+
+	// The shard of the series of the block, nil if the block isn't split.
+	Shard *BlockShard `json:"cortex_shard,omitempty"`
+}
+
+// ShardHashSeriesID is the hash of the shards of the blocks split by series
+// ID, the hash of the query shards, which the store-gateways select the series
+// of the sharded queries from the shards of without reading them.
+const ShardHashSeriesID = "series_id"
+
+// BlockShard is the shard of the series of a block split by the compactor, the
+// series whose hash modulo Count is Index: their series ID with the
+// ShardHashSeriesID Hash, the hash of their labels without.
+type BlockShard struct {
+	Index uint64 `json:"index"`
+	Count uint64 `json:"count"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// String returns the shard as "<index>_of_<count>".
+func (s BlockShard) String() string {
+	return fmt.Sprintf("%d_of_%d", s.Index, s.Count)
+}
+
+// QuarantineDir is the directory of the corrupted blocks of a tenant, in the
+// directory of the tenant.
+const QuarantineDir = "quarantine"
+
+// BlockDir returns the directory of a block of a tenant in the bucket.
+func BlockDir(userID string, id ulid.ULID) string {
+	return userID + "/" + id.String()
+}
+
+// ListUsers returns the tenants with blocks in the bucket.
+func ListUsers(ctx context.Context, bkt Bucket) ([]string, error) {
+	var users []string
+	err := bkt.Iter(ctx, "", func(name string) error {
+		if strings.HasSuffix(name, "/") {
+			users = append(users, strings.TrimSuffix(name, "/"))
+		}
+		return nil
+	})
+	return users, err
+}
+
+// ListBlocks returns the blocks of a tenant in the bucket, those partially
+// uploaded included.
+func ListBlocks(ctx context.Context, bkt Bucket, userID string) ([]ulid.ULID, error) {
+	var ids []ulid.ULID
+	err := bkt.Iter(ctx, userID+"/", func(name string) error {
+		if !strings.HasSuffix(name, "/") {
+			return nil
+		}
+		id, err := ulid.Parse(path.Base(name))
+		if err != nil {
+			// Not a block.
+			return nil
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	return ids, err
+}
+
+// ReadMeta reads the meta file of a block of a tenant from the bucket.
+func ReadMeta(ctx context.Context, bkt Bucket, userID string, id ulid.ULID) (*Meta, error) {
+	r, err := bkt.Get(ctx, path.Join(BlockDir(userID, id), MetaFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMeta(buf)
+}
+
+// ReadLocalMeta reads the meta file of a block in a local directory.
+func ReadLocalMeta(dir string) (*Meta, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, MetaFilename))
+	if err != nil {
+		return nil, err
+	}
+	return ParseMeta(buf)
+}
+
+// ParseMeta parses the meta file of a block, of the version written by tsdb.
+func ParseMeta(buf []byte) (*Meta, error) {
+	var meta Meta
+	if err := json.Unmarshal(buf, &meta); err != nil {
+		return nil, corruptedMetaError{err}
+	}
+	if meta.Version != metaVersion {
+		return nil, corruptedMetaError{fmt.Errorf("unexpected version %d of block meta file", meta.Version)}
+	}
+	return &meta, nil
+}
+
+// corruptedMetaError is the error of a meta file which can't be parsed.
+type corruptedMetaError struct {
+	err error
+}
+
+func (e corruptedMetaError) Error() string {
+	return "corrupted meta file: " + e.err.Error()
+}
+
+// IsCorruptedMetaErr returns whether the error is the one of a meta file which
+// can't be parsed, so that the block will never be readable.
+func IsCorruptedMetaErr(err error) bool {
+	_, ok := err.(corruptedMetaError)
+	return ok
+}
+
+// WriteLocalMeta writes the meta file of a block to a local directory.
+func WriteLocalMeta(dir string, meta *Meta) error {
+	m := *meta
+	m.Version = metaVersion
+	buf, err := json.MarshalIndent(&m, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, MetaFilename), buf, 0666)
+}
+
+// UploadBlock uploads the block in a local directory to the bucket, as a block
+// of the tenant, its meta file last.
+func UploadBlock(ctx context.Context, bkt Bucket, userID, dir string) error {
+	meta, err := ReadLocalMeta(dir)
+	if err != nil {
+		return err
+	}
+	dest := BlockDir(userID, meta.ULID)
+
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if rel == MetaFilename {
+			return nil
+		}
+		return uploadFile(ctx, bkt, file, path.Join(dest, filepath.ToSlash(rel)))
+	})
+	if err != nil {
+		return err
+	}
+	return uploadFile(ctx, bkt, filepath.Join(dir, MetaFilename), path.Join(dest, MetaFilename))
+}
+
+func uploadFile(ctx context.Context, bkt Bucket, file, name string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return bkt.Upload(ctx, name, f)
+}
+
+// DownloadBlock downloads a block of a tenant from the bucket to the dst
+// directory.
+func DownloadBlock(ctx context.Context, bkt Bucket, userID string, id ulid.ULID, dst string) error {
+	src := BlockDir(userID, id)
+	return Walk(ctx, bkt, src, func(name string) error {
+		file := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(name, src+"/")))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			return err
+		}
+		return downloadFile(ctx, bkt, name, file)
+	})
+}
+
+func downloadFile(ctx context.Context, bkt Bucket, name, file string) error {
+	r, err := bkt.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// DeleteBlock deletes a block of a tenant from the bucket, its meta file
+// first, so that it's never seen partially deleted as a complete block.
+func DeleteBlock(ctx context.Context, bkt Bucket, userID string, id ulid.ULID) error {
+	dir := BlockDir(userID, id)
+	meta := path.Join(dir, MetaFilename)
+	if err := bkt.Delete(ctx, meta); err != nil && !bkt.IsObjNotFoundErr(err) {
+		return err
+	}
+	return Walk(ctx, bkt, dir, func(name string) error {
+		return bkt.Delete(ctx, name)
+	})
+}
+
+// QuarantineBlock moves a block of a tenant to its QuarantineDir directory, so
+// that it's no longer listed, and kept for the operators to inspect.
+func QuarantineBlock(ctx context.Context, bkt Bucket, userID string, id ulid.ULID) error {
+	src := BlockDir(userID, id)
+	dst := path.Join(userID, QuarantineDir, id.String())
+	err := Walk(ctx, bkt, src, func(name string) error {
+		r, err := bkt.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return bkt.Upload(ctx, path.Join(dst, strings.TrimPrefix(name, src+"/")), r)
+	})
+	if err != nil {
+		return err
+	}
+	return DeleteBlock(ctx, bkt, userID, id)
+}
+
+// Walk calls f with the name of each object under dir, recursively.
+func Walk(ctx context.Context, bkt Bucket, dir string, f func(string) error) error {
+	return bkt.Iter(ctx, dirPrefix(dir), func(name string) error {
+		if strings.HasSuffix(name, "/") {
+			return Walk(ctx, bkt, name, f)
+		}
+		return f(name)
+	})
+}
@@ -0,0 +1,130 @@
+package tsdb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/stretchr/testify/require"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+)
+
+func TestFilesystemBucket(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "bucket")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	bkt, err := cortex_tsdb.NewFilesystemBucket(root)
+	require.NoError(t, err)
+
+	require.NoError(t, bkt.Upload(ctx, "a/b/c", strings.NewReader("abcdef")))
+	require.NoError(t, bkt.Upload(ctx, "a/d", strings.NewReader("d")))
+
+	var names []string
+	require.NoError(t, bkt.Iter(ctx, "a", func(name string) error {
+		names = append(names, name)
+		return nil
+	}))
+	require.Equal(t, []string{"a/b/", "a/d"}, names)
+
+	r, err := bkt.GetRange(ctx, "a/b/c", 2, 3)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "cde", string(buf))
+
+	ok, err := bkt.Exists(ctx, "a/b")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, bkt.Delete(ctx, "a/b/c"))
+	_, err = bkt.Get(ctx, "a/b/c")
+	require.True(t, bkt.IsObjNotFoundErr(err))
+
+	// The directories left empty are removed.
+	_, err = os.Stat(filepath.Join(root, "a", "b"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestUploadDownloadDeleteBlock(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "blocks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bkt, err := cortex_tsdb.NewFilesystemBucket(filepath.Join(dir, "bucket"))
+	require.NoError(t, err)
+
+	series := []labels.Labels{labels.FromStrings("__name__", "foo", "a", "1")}
+	id, err := testutil.CreateBlock(filepath.Join(dir, "local"), series, 0, 1000, 100)
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.UploadBlock(ctx, bkt, "user", testutil.BlockDir(filepath.Join(dir, "local"), id)))
+
+	users, err := cortex_tsdb.ListUsers(ctx, bkt)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user"}, users)
+
+	ids, err := cortex_tsdb.ListBlocks(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	require.Equal(t, id, ids[0])
+
+	meta, err := cortex_tsdb.ReadMeta(ctx, bkt, "user", id)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), meta.MinTime)
+	require.Equal(t, int64(1001), meta.MaxTime)
+	require.Equal(t, uint64(1), meta.Stats.NumSeries)
+
+	dst := filepath.Join(dir, "downloaded")
+	require.NoError(t, cortex_tsdb.DownloadBlock(ctx, bkt, "user", id, dst))
+	downloaded, err := cortex_tsdb.ReadLocalMeta(dst)
+	require.NoError(t, err)
+	require.Equal(t, meta, downloaded)
+	_, err = os.Stat(filepath.Join(dst, "index"))
+	require.NoError(t, err)
+
+	require.NoError(t, cortex_tsdb.DeleteBlock(ctx, bkt, "user", id))
+	ids, err = cortex_tsdb.ListBlocks(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
+
+func TestQuarantineBlock(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "quarantine")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bkt, err := cortex_tsdb.NewFilesystemBucket(filepath.Join(dir, "bucket"))
+	require.NoError(t, err)
+	id, err := testutil.CreateBlock(filepath.Join(dir, "local"), []labels.Labels{labels.FromStrings("__name__", "foo")}, 0, 1000, 100)
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.UploadBlock(ctx, bkt, "user", testutil.BlockDir(filepath.Join(dir, "local"), id)))
+
+	// The meta files which can't be parsed are corrupted.
+	require.NoError(t, bkt.Upload(ctx, path.Join(cortex_tsdb.BlockDir("user", id), cortex_tsdb.MetaFilename), strings.NewReader("{")))
+	_, err = cortex_tsdb.ReadMeta(ctx, bkt, "user", id)
+	require.True(t, cortex_tsdb.IsCorruptedMetaErr(err))
+	_, err = cortex_tsdb.ReadMeta(ctx, bkt, "user", ulid.MustNew(1, nil))
+	require.False(t, cortex_tsdb.IsCorruptedMetaErr(err))
+
+	require.NoError(t, cortex_tsdb.QuarantineBlock(ctx, bkt, "user", id))
+	ids, err := cortex_tsdb.ListBlocks(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Empty(t, ids)
+	for _, name := range []string{cortex_tsdb.MetaFilename, "index", "chunks/000001"} {
+		exists, err := bkt.Exists(ctx, path.Join("user", cortex_tsdb.QuarantineDir, id.String(), name))
+		require.NoError(t, err)
+		require.True(t, exists, name)
+	}
+}
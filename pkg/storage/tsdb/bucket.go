@@ -0,0 +1,62 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Bucket is the object store the blocks are stored in. The names of the
+// objects are slash separated paths, like tenant/block/index.
+type Bucket interface {
+	// Iter calls f with the name of each object and directory right under
+	// the dir, the names of the directories ending with a slash.
+	Iter(ctx context.Context, dir string, f func(name string) error) error
+
+	// Get returns a reader of an object.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// GetRange returns a reader of length bytes of an object from off.
+	GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error)
+
+	// Exists returns whether an object exists.
+	Exists(ctx context.Context, name string) (bool, error)
+
+	// ObjectSize returns the size of an object.
+	ObjectSize(ctx context.Context, name string) (int64, error)
+
+	// Upload writes an object, replacing it if it exists.
+	Upload(ctx context.Context, name string, r io.Reader) error
+
+	// Delete deletes an object.
+	Delete(ctx context.Context, name string) error
+
+	// IsObjNotFoundErr returns whether an error is returned for an object
+	// which doesn't exist.
+	IsObjNotFoundErr(err error) bool
+
+	Close() error
+}
+
+// NewBucketClient makes the client of the bucket of the config.
+func NewBucketClient(ctx context.Context, cfg Config) (Bucket, error) {
+	switch cfg.Backend {
+	case BackendS3:
+		return NewS3Bucket(cfg.S3)
+	case BackendGCS:
+		return NewGCSBucket(ctx, cfg.GCS)
+	case BackendFilesystem:
+		return NewFilesystemBucket(cfg.Filesystem.Directory)
+	default:
+		return nil, fmt.Errorf("unsupported blocks storage backend %q, choose one of: %s, %s, %s", cfg.Backend, BackendS3, BackendGCS, BackendFilesystem)
+	}
+}
+
+// dirPrefix returns the prefix of the objects under dir.
+func dirPrefix(dir string) string {
+	if dir == "" || strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
@@ -0,0 +1,145 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type filesystemBucket struct {
+	root string
+}
+
+// NewFilesystemBucket makes a Bucket of the files under a local directory.
+func NewFilesystemBucket(root string) (Bucket, error) {
+	if root == "" {
+		return nil, fmt.Errorf("no directory specified for the filesystem blocks storage")
+	}
+	root = filepath.Clean(root)
+	if err := os.MkdirAll(root, 0777); err != nil {
+		return nil, err
+	}
+	return &filesystemBucket{root: root}, nil
+}
+
+func (b *filesystemBucket) path(name string) string {
+	return filepath.Join(b.root, filepath.FromSlash(name))
+}
+
+// Iter implements Bucket
+func (b *filesystemBucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	infos, err := ioutil.ReadDir(b.path(dir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := dirPrefix(dir) + info.Name()
+		if info.IsDir() {
+			name += "/"
+		} else if strings.HasSuffix(name, ".tmp") {
+			// Being uploaded.
+			continue
+		}
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements Bucket
+func (b *filesystemBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(b.path(name))
+}
+
+// GetRange implements Bucket
+func (b *filesystemBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(b.path(name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// Exists implements Bucket
+func (b *filesystemBucket) Exists(ctx context.Context, name string) (bool, error) {
+	info, err := os.Stat(b.path(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// ObjectSize implements Bucket
+func (b *filesystemBucket) ObjectSize(ctx context.Context, name string) (int64, error) {
+	info, err := os.Stat(b.path(name))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Upload implements Bucket. The object is written to a temporary file renamed
+// once written, so that it's never read half written.
+func (b *filesystemBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	path := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Delete implements Bucket. The directories left empty are removed too.
+func (b *filesystemBucket) Delete(ctx context.Context, name string) error {
+	path := b.path(name)
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != b.root && strings.HasPrefix(dir, b.root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// IsObjNotFoundErr implements Bucket
+func (b *filesystemBucket) IsObjNotFoundErr(err error) bool {
+	return os.IsNotExist(err)
+}
+
+// Close implements Bucket
+func (b *filesystemBucket) Close() error {
+	return nil
+}
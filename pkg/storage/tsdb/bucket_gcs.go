@@ -0,0 +1,108 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type gcsBucket struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+// NewGCSBucket makes a Bucket of a GCS bucket, with the default credentials.
+func NewGCSBucket(ctx context.Context, cfg GCSConfig) (Bucket, error) {
+	if cfg.BucketName == "" {
+		return nil, fmt.Errorf("no bucket specified for the GCS blocks storage")
+	}
+	client, err := storage.NewClient(ctx, option.WithScopes(storage.ScopeReadWrite))
+	if err != nil {
+		return nil, err
+	}
+	return &gcsBucket{
+		client: client,
+		bucket: client.Bucket(cfg.BucketName),
+	}, nil
+}
+
+// Iter implements Bucket
+func (b *gcsBucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	it := b.bucket.Objects(ctx, &storage.Query{
+		Prefix:    dirPrefix(dir),
+		Delimiter: "/",
+	})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := attrs.Name
+		if attrs.Prefix != "" {
+			name = attrs.Prefix
+		}
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+}
+
+// Get implements Bucket
+func (b *gcsBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.bucket.Object(name).NewReader(ctx)
+}
+
+// GetRange implements Bucket
+func (b *gcsBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.bucket.Object(name).NewRangeReader(ctx, off, length)
+}
+
+// Exists implements Bucket
+func (b *gcsBucket) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := b.bucket.Object(name).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ObjectSize implements Bucket
+func (b *gcsBucket) ObjectSize(ctx context.Context, name string) (int64, error) {
+	attrs, err := b.bucket.Object(name).Attrs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+// Upload implements Bucket
+func (b *gcsBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	w := b.bucket.Object(name).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Delete implements Bucket
+func (b *gcsBucket) Delete(ctx context.Context, name string) error {
+	return b.bucket.Object(name).Delete(ctx)
+}
+
+// IsObjNotFoundErr implements Bucket
+func (b *gcsBucket) IsObjNotFoundErr(err error) bool {
+	return err == storage.ErrObjectNotExist
+}
+
+// Close implements Bucket
+func (b *gcsBucket) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,241 @@
+package tsdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+)
+
+// BucketIndexFilename is the name of the bucket index of a tenant, in the
+// directory of the tenant.
+const BucketIndexFilename = "bucket-index.json.gz"
+
+// DeletionMarkFilename is the name of the deletion mark of a block, in the
+// directory of the block.
+const DeletionMarkFilename = "deletion-mark.json"
+
+// The version of the bucket index format.
+const bucketIndexVersion = 1
+
+// ErrBucketIndexNotFound is returned when a tenant has no bucket index.
+var ErrBucketIndexNotFound = errors.New("bucket index not found")
+
+// BucketIndex lists the blocks of a tenant in the bucket and their deletion
+// marks, so that they're discovered with a single request.
+type BucketIndex struct {
+	Version            int                  `json:"version"`
+	Blocks             []*BlockEntry        `json:"blocks"`
+	BlockDeletionMarks []*BlockDeletionMark `json:"block_deletion_marks"`
+	// Unix timestamp, in seconds, of the last update of the index.
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// BlockEntry is a block of a bucket index.
+type BlockEntry struct {
+	ID      ulid.ULID `json:"block_id"`
+	MinTime int64     `json:"min_time"`
+	MaxTime int64     `json:"max_time"`
+	// The shard of the series of the block, nil if the block isn't split.
+	Shard *BlockShard `json:"shard,omitempty"`
+}
+
+// Meta returns the meta of the block, the ID, time range and shard only.
+func (b *BlockEntry) Meta() *Meta {
+	return &Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    b.ID,
+			MinTime: b.MinTime,
+			MaxTime: b.MaxTime,
+			Version: metaVersion,
+		},
+		Shard: b.Shard,
+	}
+}
+
+// BlockDeletionMark marks a block for deletion.
+type BlockDeletionMark struct {
+	ID ulid.ULID `json:"id"`
+	// Unix timestamp, in seconds, of when the block was marked.
+	DeletionTime int64 `json:"deletion_time"`
+}
+
+// Time returns the time the block was marked for deletion.
+func (m *BlockDeletionMark) Time() time.Time {
+	return time.Unix(m.DeletionTime, 0)
+}
+
+// UpdatedTime returns the time of the last update of the index.
+func (idx *BucketIndex) UpdatedTime() time.Time {
+	return time.Unix(idx.UpdatedAt, 0)
+}
+
+// QueriedBlocks returns the blocks of the index except those marked for
+// deletion for longer than ignoreDeletionMarksDelay, which the queriers
+// mustn't rely on.
+func (idx *BucketIndex) QueriedBlocks(ignoreDeletionMarksDelay time.Duration) []*BlockEntry {
+	ignored := map[ulid.ULID]struct{}{}
+	for _, m := range idx.BlockDeletionMarks {
+		if time.Since(m.Time()) > ignoreDeletionMarksDelay {
+			ignored[m.ID] = struct{}{}
+		}
+	}
+	blocks := make([]*BlockEntry, 0, len(idx.Blocks))
+	for _, b := range idx.Blocks {
+		if _, ok := ignored[b.ID]; !ok {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// ReadBucketIndex reads the bucket index of a tenant, returning
+// ErrBucketIndexNotFound if there's none.
+func ReadBucketIndex(ctx context.Context, bkt Bucket, userID string) (*BucketIndex, error) {
+	r, err := bkt.Get(ctx, path.Join(userID, BucketIndexFilename))
+	if bkt.IsObjNotFoundErr(err) {
+		return nil, ErrBucketIndexNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var idx BucketIndex
+	if err := json.NewDecoder(gz).Decode(&idx); err != nil {
+		return nil, err
+	}
+	if idx.Version != bucketIndexVersion {
+		return nil, fmt.Errorf("unexpected version %d of bucket index", idx.Version)
+	}
+	return &idx, nil
+}
+
+// WriteBucketIndex writes the bucket index of a tenant.
+func WriteBucketIndex(ctx context.Context, bkt Bucket, userID string, idx *BucketIndex) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(idx); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return bkt.Upload(ctx, path.Join(userID, BucketIndexFilename), bytes.NewReader(buf.Bytes()))
+}
+
+// DeleteBucketIndex deletes the bucket index of a tenant, if any.
+func DeleteBucketIndex(ctx context.Context, bkt Bucket, userID string) error {
+	err := bkt.Delete(ctx, path.Join(userID, BucketIndexFilename))
+	if err != nil && !bkt.IsObjNotFoundErr(err) {
+		return err
+	}
+	return nil
+}
+
+// UpdateBucketIndex lists the blocks of a tenant to make its bucket index,
+// reusing the entries of the blocks of the old index, which may be nil.
+func UpdateBucketIndex(ctx context.Context, bkt Bucket, userID string, old *BucketIndex) (*BucketIndex, error) {
+	known := map[ulid.ULID]*BlockEntry{}
+	if old != nil {
+		for _, b := range old.Blocks {
+			known[b.ID] = b
+		}
+	}
+
+	ids, err := ListBlocks(ctx, bkt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &BucketIndex{
+		Version:            bucketIndexVersion,
+		Blocks:             []*BlockEntry{},
+		BlockDeletionMarks: []*BlockDeletionMark{},
+	}
+	for _, id := range ids {
+		entry, ok := known[id]
+		if !ok {
+			meta, err := ReadMeta(ctx, bkt, userID, id)
+			if bkt.IsObjNotFoundErr(err) {
+				// Partially uploaded, or being deleted.
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading meta of block %s: %v", id, err)
+			}
+			entry = &BlockEntry{ID: id, MinTime: meta.MinTime, MaxTime: meta.MaxTime, Shard: meta.Shard}
+		} else if exists, err := bkt.Exists(ctx, path.Join(BlockDir(userID, id), MetaFilename)); err != nil {
+			return nil, err
+		} else if !exists {
+			// Being deleted.
+			continue
+		}
+		idx.Blocks = append(idx.Blocks, entry)
+
+		mark, err := ReadDeletionMark(ctx, bkt, userID, id)
+		if err != nil {
+			return nil, fmt.Errorf("reading deletion mark of block %s: %v", id, err)
+		}
+		if mark != nil {
+			idx.BlockDeletionMarks = append(idx.BlockDeletionMarks, mark)
+		}
+	}
+
+	sort.Slice(idx.Blocks, func(i, j int) bool {
+		return idx.Blocks[i].MinTime < idx.Blocks[j].MinTime
+	})
+	idx.UpdatedAt = time.Now().Unix()
+	return idx, nil
+}
+
+// ReadDeletionMark reads the deletion mark of a block, returning nil if the
+// block isn't marked for deletion.
+func ReadDeletionMark(ctx context.Context, bkt Bucket, userID string, id ulid.ULID) (*BlockDeletionMark, error) {
+	r, err := bkt.Get(ctx, path.Join(BlockDir(userID, id), DeletionMarkFilename))
+	if bkt.IsObjNotFoundErr(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var mark BlockDeletionMark
+	if err := json.Unmarshal(buf, &mark); err != nil {
+		return nil, err
+	}
+	return &mark, nil
+}
+
+// MarkBlockForDeletion marks a block of a tenant for deletion, unless it's
+// already marked.
+func MarkBlockForDeletion(ctx context.Context, bkt Bucket, userID string, id ulid.ULID) error {
+	mark, err := ReadDeletionMark(ctx, bkt, userID, id)
+	if err != nil || mark != nil {
+		return err
+	}
+	buf, err := json.Marshal(&BlockDeletionMark{ID: id, DeletionTime: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	return bkt.Upload(ctx, path.Join(BlockDir(userID, id), DeletionMarkFilename), bytes.NewReader(buf))
+}
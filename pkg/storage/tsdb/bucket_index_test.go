@@ -0,0 +1,125 @@
+package tsdb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/stretchr/testify/require"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+)
+
+func TestBucketIndex(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "bucket-index")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bkt, err := cortex_tsdb.NewFilesystemBucket(filepath.Join(dir, "bucket"))
+	require.NoError(t, err)
+
+	_, err = cortex_tsdb.ReadBucketIndex(ctx, bkt, "user")
+	require.Equal(t, cortex_tsdb.ErrBucketIndexNotFound, err)
+
+	series := []labels.Labels{labels.FromStrings("__name__", "foo")}
+	local := filepath.Join(dir, "local")
+	var ids []ulid.ULID
+	for i := int64(0); i < 2; i++ {
+		id, err := testutil.CreateBlock(local, series, i*1000, (i+1)*1000-1, 100)
+		require.NoError(t, err)
+		if i == 1 {
+			meta, err := cortex_tsdb.ReadLocalMeta(testutil.BlockDir(local, id))
+			require.NoError(t, err)
+			meta.Shard = &cortex_tsdb.BlockShard{Index: 1, Count: 2, Hash: cortex_tsdb.ShardHashSeriesID}
+			require.NoError(t, cortex_tsdb.WriteLocalMeta(testutil.BlockDir(local, id), meta))
+		}
+		require.NoError(t, cortex_tsdb.UploadBlock(ctx, bkt, "user", testutil.BlockDir(local, id)))
+		ids = append(ids, id)
+	}
+	// A partially uploaded block, without meta file.
+	require.NoError(t, bkt.Upload(ctx, "user/01DTVP434PA9VFXSW2JKB3392D/index", strings.NewReader("")))
+	// The second block is marked for deletion.
+	require.NoError(t, bkt.Upload(ctx, path.Join(cortex_tsdb.BlockDir("user", ids[1]), cortex_tsdb.DeletionMarkFilename), strings.NewReader(`{"id":"`+ids[1].String()+`","deletion_time":1234}`)))
+
+	idx, err := cortex_tsdb.UpdateBucketIndex(ctx, bkt, "user", nil)
+	require.NoError(t, err)
+	require.Equal(t, []*cortex_tsdb.BlockEntry{
+		{ID: ids[0], MinTime: 0, MaxTime: 1000},
+		{ID: ids[1], MinTime: 1000, MaxTime: 2000, Shard: &cortex_tsdb.BlockShard{Index: 1, Count: 2, Hash: cortex_tsdb.ShardHashSeriesID}},
+	}, idx.Blocks)
+	require.Equal(t, idx.Blocks[1].Shard, idx.Blocks[1].Meta().Shard)
+	require.Equal(t, []*cortex_tsdb.BlockDeletionMark{{ID: ids[1], DeletionTime: 1234}}, idx.BlockDeletionMarks)
+	require.WithinDuration(t, time.Now(), idx.UpdatedTime(), time.Minute)
+
+	require.NoError(t, cortex_tsdb.WriteBucketIndex(ctx, bkt, "user", idx))
+	read, err := cortex_tsdb.ReadBucketIndex(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Equal(t, idx, read)
+
+	// The blocks marked for deletion for longer than the delay aren't queried.
+	require.Len(t, idx.QueriedBlocks(time.Since(time.Unix(0, 0))), 2)
+	require.Equal(t, idx.Blocks[:1], idx.QueriedBlocks(time.Hour))
+
+	// The index isn't a block of the tenant.
+	listed, err := cortex_tsdb.ListBlocks(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Len(t, listed, 3)
+
+	// The entries of the old index are reused, the deleted blocks removed.
+	require.NoError(t, cortex_tsdb.DeleteBlock(ctx, bkt, "user", ids[1]))
+	old := &cortex_tsdb.BucketIndex{Blocks: []*cortex_tsdb.BlockEntry{{ID: ids[0], MinTime: 5, MaxTime: 6}, {ID: ids[1]}}}
+	idx, err = cortex_tsdb.UpdateBucketIndex(ctx, bkt, "user", old)
+	require.NoError(t, err)
+	require.Equal(t, []*cortex_tsdb.BlockEntry{{ID: ids[0], MinTime: 5, MaxTime: 6}}, idx.Blocks)
+	require.Empty(t, idx.BlockDeletionMarks)
+
+	require.NoError(t, cortex_tsdb.DeleteBucketIndex(ctx, bkt, "user"))
+	require.NoError(t, cortex_tsdb.DeleteBucketIndex(ctx, bkt, "user"))
+	_, err = cortex_tsdb.ReadBucketIndex(ctx, bkt, "user")
+	require.Equal(t, cortex_tsdb.ErrBucketIndexNotFound, err)
+}
+
+func TestMarkBlockForDeletion(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "deletion-mark")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bkt, err := cortex_tsdb.NewFilesystemBucket(filepath.Join(dir, "bucket"))
+	require.NoError(t, err)
+	id, err := testutil.CreateBlock(filepath.Join(dir, "local"), []labels.Labels{labels.FromStrings("__name__", "foo")}, 0, 1000, 100)
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.UploadBlock(ctx, bkt, "user", testutil.BlockDir(filepath.Join(dir, "local"), id)))
+
+	mark, err := cortex_tsdb.ReadDeletionMark(ctx, bkt, "user", id)
+	require.NoError(t, err)
+	require.Nil(t, mark)
+
+	require.NoError(t, cortex_tsdb.MarkBlockForDeletion(ctx, bkt, "user", id))
+	mark, err = cortex_tsdb.ReadDeletionMark(ctx, bkt, "user", id)
+	require.NoError(t, err)
+	require.Equal(t, id, mark.ID)
+	require.WithinDuration(t, time.Now(), mark.Time(), time.Minute)
+
+	// The block keeps its first mark.
+	require.NoError(t, bkt.Upload(ctx, path.Join(cortex_tsdb.BlockDir("user", id), cortex_tsdb.DeletionMarkFilename), strings.NewReader(`{"id":"`+id.String()+`","deletion_time":1234}`)))
+	require.NoError(t, cortex_tsdb.MarkBlockForDeletion(ctx, bkt, "user", id))
+	mark, err = cortex_tsdb.ReadDeletionMark(ctx, bkt, "user", id)
+	require.NoError(t, err)
+	require.Equal(t, int64(1234), mark.DeletionTime)
+
+	// The mark is deleted with the block.
+	require.NoError(t, cortex_tsdb.DeleteBlock(ctx, bkt, "user", id))
+	mark, err = cortex_tsdb.ReadDeletionMark(ctx, bkt, "user", id)
+	require.NoError(t, err)
+	require.Nil(t, mark)
+}
@@ -0,0 +1,153 @@
+package tsdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	awscommon "github.com/weaveworks/common/aws"
+)
+
+type s3Bucket struct {
+	s3     s3iface.S3API
+	bucket string
+}
+
+// NewS3Bucket makes a Bucket of an S3 bucket.
+func NewS3Bucket(cfg S3Config) (Bucket, error) {
+	if cfg.URL.URL == nil {
+		return nil, fmt.Errorf("no URL specified for the S3 blocks storage")
+	}
+	s3Config, err := awscommon.ConfigFromURL(cfg.URL.URL)
+	if err != nil {
+		return nil, err
+	}
+	s3Config = s3Config.WithS3ForcePathStyle(cfg.ForcePathStyle)
+	return &s3Bucket{
+		s3:     s3.New(session.New(s3Config)),
+		bucket: strings.TrimPrefix(cfg.URL.URL.Path, "/"),
+	}, nil
+}
+
+// Iter implements Bucket
+func (b *s3Bucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	var names []string
+	err := b.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(b.bucket),
+		Prefix:    aws.String(dirPrefix(dir)),
+		Delimiter: aws.String("/"),
+	}, func(output *s3.ListObjectsV2Output, _ bool) bool {
+		for _, prefix := range output.CommonPrefixes {
+			names = append(names, *prefix.Prefix)
+		}
+		for _, object := range output.Contents {
+			names = append(names, *object.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements Bucket
+func (b *s3Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := b.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// GetRange implements Bucket
+func (b *s3Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	resp, err := b.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, off+length-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Exists implements Bucket
+func (b *s3Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := b.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+	})
+	if b.IsObjNotFoundErr(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ObjectSize implements Bucket
+func (b *s3Bucket) ObjectSize(ctx context.Context, name string) (int64, error) {
+	resp, err := b.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(resp.ContentLength), nil
+}
+
+// Upload implements Bucket. The objects uploaded from files are streamed,
+// the others buffered, as S3 needs to seek them.
+func (b *s3Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+	_, err := b.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+		Body:   body,
+	})
+	return err
+}
+
+// Delete implements Bucket
+func (b *s3Bucket) Delete(ctx context.Context, name string) error {
+	_, err := b.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(name),
+	})
+	return err
+}
+
+// IsObjNotFoundErr implements Bucket
+func (b *s3Bucket) IsObjNotFoundErr(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound")
+}
+
+// Close implements Bucket
+func (b *s3Bucket) Close() error {
+	return nil
+}
@@ -0,0 +1,174 @@
+package tsdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+)
+
+var (
+	metadataCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "blocks_storage_metadata_cache_requests_total",
+		Help:      "Total number of bucket calls looked up in the metadata cache.",
+	}, []string{"op"})
+	metadataCacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "blocks_storage_metadata_cache_hits_total",
+		Help:      "Total number of bucket calls answered from the metadata cache.",
+	}, []string{"op"})
+)
+
+// MetadataCacheConfig is the config of the cache of the listings, the
+// existence checks and the meta files of the bucket of the blocks, each
+// cached for its own TTL; a TTL of 0 disables the caching of its calls.
+type MetadataCacheConfig struct {
+	Cache              cache.Config  `yaml:"cache"`
+	IterTTL            time.Duration `yaml:"iter_ttl"`
+	ExistsTTL          time.Duration `yaml:"exists_ttl"`
+	MetafileContentTTL time.Duration `yaml:"metafile_content_ttl"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *MetadataCacheConfig) RegisterFlags(f *flag.FlagSet) {
+	cfg.Cache.RegisterFlagsWithPrefix("blocks-storage.metadata-cache.", "Cache config for the listings, the existence checks and the meta files of the bucket, used by the queriers and the store-gateways. ", f)
+	f.DurationVar(&cfg.IterTTL, "blocks-storage.metadata-cache.iter-ttl", 5*time.Minute, "How long the listings of the directories of the bucket are cached, so how long new or deleted blocks may go unnoticed.")
+	f.DurationVar(&cfg.ExistsTTL, "blocks-storage.metadata-cache.exists-ttl", 10*time.Minute, "How long the existence checks of the objects of the bucket are cached.")
+	f.DurationVar(&cfg.MetafileContentTTL, "blocks-storage.metadata-cache.metafile-content-ttl", 24*time.Hour, "How long the contents of the meta files of the blocks are cached.")
+}
+
+// cachingBucket caches the results of the Iter and Exists calls, and the
+// contents of the meta files, of a bucket. The cache has no TTL per item, so
+// each value is stored after the time it expires at.
+type cachingBucket struct {
+	Bucket
+	cache cache.Cache
+	cfg   MetadataCacheConfig
+}
+
+// NewCachingBucket wraps a bucket with the metadata cache of the config,
+// returning the bucket itself if the cache isn't enabled. The writes to the
+// bucket don't update the cache, so the wrapped bucket must only be used by
+// readers which tolerate the staleness of the TTLs.
+func NewCachingBucket(b Bucket, cfg MetadataCacheConfig) (Bucket, error) {
+	if !cfg.Cache.IsEnabled() {
+		return b, nil
+	}
+	c, err := cache.New(cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return &cachingBucket{Bucket: b, cache: c, cfg: cfg}, nil
+}
+
+func (b *cachingBucket) Iter(ctx context.Context, dir string, f func(name string) error) error {
+	if b.cfg.IterTTL <= 0 {
+		return b.Bucket.Iter(ctx, dir, f)
+	}
+
+	key := "iter:" + cache.HashKey(dir)
+	var names []string
+	buf, ok := b.fetch(ctx, "iter", key)
+	if !ok || json.Unmarshal(buf, &names) != nil {
+		names = names[:0]
+		if err := b.Bucket.Iter(ctx, dir, func(name string) error {
+			names = append(names, name)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if buf, err := json.Marshal(names); err == nil {
+			b.store(ctx, key, buf, b.cfg.IterTTL)
+		}
+	}
+
+	for _, name := range names {
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *cachingBucket) Exists(ctx context.Context, name string) (bool, error) {
+	if b.cfg.ExistsTTL <= 0 {
+		return b.Bucket.Exists(ctx, name)
+	}
+
+	key := "exists:" + cache.HashKey(name)
+	if buf, ok := b.fetch(ctx, "exists", key); ok && len(buf) == 1 {
+		return buf[0] == 1, nil
+	}
+	exists, err := b.Bucket.Exists(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	buf := []byte{0}
+	if exists {
+		buf[0] = 1
+	}
+	b.store(ctx, key, buf, b.cfg.ExistsTTL)
+	return exists, nil
+}
+
+// Get caches the contents of the meta files only, which are never changed
+// once uploaded.
+func (b *cachingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if b.cfg.MetafileContentTTL <= 0 || path.Base(name) != MetaFilename {
+		return b.Bucket.Get(ctx, name)
+	}
+
+	key := "content:" + cache.HashKey(name)
+	if buf, ok := b.fetch(ctx, "metafile", key); ok {
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+	r, err := b.Bucket.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b.store(ctx, key, buf, b.cfg.MetafileContentTTL)
+	return ioutil.NopCloser(bytes.NewReader(buf)), nil
+}
+
+func (b *cachingBucket) Close() error {
+	b.cache.Stop()
+	return b.Bucket.Close()
+}
+
+// fetch returns the value of a key in the cache, if it hasn't expired.
+func (b *cachingBucket) fetch(ctx context.Context, op, key string) ([]byte, bool) {
+	metadataCacheRequests.WithLabelValues(op).Inc()
+	found, bufs, _ := b.cache.Fetch(ctx, []string{key})
+	if len(found) != 1 || len(bufs[0]) < 8 {
+		return nil, false
+	}
+	expiry := time.Unix(0, int64(binary.BigEndian.Uint64(bufs[0])))
+	if time.Now().After(expiry) {
+		return nil, false
+	}
+	metadataCacheHits.WithLabelValues(op).Inc()
+	return bufs[0][8:], true
+}
+
+// store caches the value of a key for ttl.
+func (b *cachingBucket) store(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	buf := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().Add(ttl).UnixNano()))
+	b.cache.Store(ctx, []string{key}, [][]byte{append(buf, value...)})
+}
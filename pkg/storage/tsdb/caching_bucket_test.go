@@ -0,0 +1,87 @@
+package tsdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+)
+
+func TestCachingBucket(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "caching-bucket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bkt, err := NewFilesystemBucket(dir)
+	require.NoError(t, err)
+	cfg := MetadataCacheConfig{
+		Cache:              cache.Config{Cache: cache.NewMockCache()},
+		IterTTL:            100 * time.Millisecond,
+		ExistsTTL:          time.Hour,
+		MetafileContentTTL: time.Hour,
+	}
+	cached, err := NewCachingBucket(bkt, cfg)
+	require.NoError(t, err)
+	defer cached.Close()
+
+	list := func() []string {
+		var names []string
+		require.NoError(t, cached.Iter(ctx, "user", func(name string) error {
+			names = append(names, name)
+			return nil
+		}))
+		return names
+	}
+	read := func(name string) string {
+		r, err := cached.Get(ctx, name)
+		require.NoError(t, err)
+		defer r.Close()
+		buf, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		return string(buf)
+	}
+
+	// The listings are stale until their TTL expires.
+	require.NoError(t, bkt.Upload(ctx, "user/a/"+MetaFilename, strings.NewReader("a")))
+	require.Equal(t, []string{"user/a/"}, list())
+	require.NoError(t, bkt.Upload(ctx, "user/b/"+MetaFilename, strings.NewReader("b")))
+	require.Equal(t, []string{"user/a/"}, list())
+	time.Sleep(2 * cfg.IterTTL)
+	require.Equal(t, []string{"user/a/", "user/b/"}, list())
+
+	exists, err := cached.Exists(ctx, "user/c/index")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, bkt.Upload(ctx, "user/c/index", strings.NewReader("c")))
+	exists, err = cached.Exists(ctx, "user/c/index")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// Only the contents of the meta files are cached.
+	require.Equal(t, "a", read("user/a/"+MetaFilename))
+	require.Equal(t, "c", read("user/c/index"))
+	require.NoError(t, bkt.Delete(ctx, "user/a/"+MetaFilename))
+	require.NoError(t, bkt.Delete(ctx, "user/c/index"))
+	require.Equal(t, "a", read("user/a/"+MetaFilename))
+	_, err = cached.Get(ctx, "user/c/index")
+	require.True(t, cached.IsObjNotFoundErr(err))
+
+	// A TTL of 0 disables the caching of its calls.
+	cfg.ExistsTTL = 0
+	uncached, err := NewCachingBucket(bkt, cfg)
+	require.NoError(t, err)
+	exists, err = uncached.Exists(ctx, "user/b/"+MetaFilename)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.NoError(t, bkt.Delete(ctx, "user/b/"+MetaFilename))
+	exists, err = uncached.Exists(ctx, "user/b/"+MetaFilename)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
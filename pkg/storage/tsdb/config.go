@@ -0,0 +1,113 @@
+package tsdb
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// The object stores the blocks can be stored in.
+const (
+	BackendS3         = "s3"
+	BackendGCS        = "gcs"
+	BackendFilesystem = "filesystem"
+)
+
+// Config is the config of the object store the TSDB blocks of the tenants
+// are stored in.
+type Config struct {
+	Backend    string           `yaml:"backend"`
+	S3         S3Config         `yaml:"s3"`
+	GCS        GCSConfig        `yaml:"gcs"`
+	Filesystem FilesystemConfig `yaml:"filesystem"`
+
+	BucketIndex   BucketIndexConfig   `yaml:"bucket_index"`
+	MetadataCache MetadataCacheConfig `yaml:"metadata_cache"`
+}
+
+// S3Config is the config of the S3 bucket of the blocks.
+type S3Config struct {
+	URL            flagext.URLValue `yaml:"url"`
+	ForcePathStyle bool             `yaml:"force_path_style"`
+}
+
+// GCSConfig is the config of the GCS bucket of the blocks.
+type GCSConfig struct {
+	BucketName string `yaml:"bucket_name"`
+}
+
+// FilesystemConfig is the config of the local directory of the blocks.
+type FilesystemConfig struct {
+	Directory string `yaml:"dir"`
+}
+
+// BucketIndexConfig is the config of the bucket indexes of the tenants,
+// written by the compactors and read by the queriers and the store-gateways.
+type BucketIndexConfig struct {
+	Enabled                  bool          `yaml:"enabled"`
+	ReloadInterval           time.Duration `yaml:"reload_interval"`
+	MaxStalePeriod           time.Duration `yaml:"max_stale_period"`
+	IgnoreDeletionMarksDelay time.Duration `yaml:"ignore_deletion_marks_delay"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&cfg.Backend, "blocks-storage.backend", BackendS3, "Object store the TSDB blocks are stored in: s3, gcs or filesystem.")
+	f.Var(&cfg.S3.URL, "blocks-storage.s3.url", "S3 URL of the bucket of the blocks, e.g. s3://region/bucket or with the credentials s3://key:secret@region/bucket.")
+	f.BoolVar(&cfg.S3.ForcePathStyle, "blocks-storage.s3.force-path-style", false, "Use path-style addressing for the S3 bucket of the blocks.")
+	f.StringVar(&cfg.GCS.BucketName, "blocks-storage.gcs.bucket-name", "", "Name of the GCS bucket of the blocks.")
+	f.StringVar(&cfg.Filesystem.Directory, "blocks-storage.filesystem.dir", "", "Local directory the blocks are stored in, e.g. a shared volume.")
+	f.BoolVar(&cfg.BucketIndex.Enabled, "blocks-storage.bucket-index.enabled", false, "Discover the blocks of the tenants from their bucket index, updated by the compactors, rather than by listing the bucket.")
+	f.DurationVar(&cfg.BucketIndex.ReloadInterval, "blocks-storage.bucket-index.reload-interval", time.Minute, "How long the queriers use a bucket index before reading it again.")
+	f.DurationVar(&cfg.BucketIndex.MaxStalePeriod, "blocks-storage.bucket-index.max-stale-period", 3*time.Hour, "The queries of a tenant fail if its bucket index wasn't updated for this long.")
+	f.DurationVar(&cfg.BucketIndex.IgnoreDeletionMarksDelay, "blocks-storage.bucket-index.ignore-deletion-marks-delay", time.Hour, "How long the blocks marked for deletion in the bucket index are still queried, which must be shorter than -compactor.deletion-delay for the queriers to notice their deletion first.")
+	cfg.MetadataCache.RegisterFlags(f)
+}
+
+// Validate the config.
+func (cfg *Config) Validate() error {
+	switch cfg.Backend {
+	case BackendS3, BackendGCS, BackendFilesystem:
+		return nil
+	}
+	return fmt.Errorf("unsupported blocks storage backend %q, choose one of: %s, %s, %s", cfg.Backend, BackendS3, BackendGCS, BackendFilesystem)
+}
+
+// DurationList is a list of durations, set from a comma separated list.
+type DurationList []time.Duration
+
+// String implements flag.Value
+func (d DurationList) String() string {
+	values := make([]string, 0, len(d))
+	for _, v := range d {
+		values = append(values, v.String())
+	}
+	return strings.Join(values, ",")
+}
+
+// Set implements flag.Value
+func (d *DurationList) Set(s string) error {
+	values := strings.Split(s, ",")
+	*d = make([]time.Duration, 0, len(values))
+	for _, v := range values {
+		t, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = append(*d, t)
+	}
+	return nil
+}
+
+// ToMilliseconds returns the durations in milliseconds, the unit of the time
+// ranges of the blocks.
+func (d DurationList) ToMilliseconds() []int64 {
+	values := make([]int64, 0, len(d))
+	for _, t := range d {
+		values = append(values, t.Nanoseconds()/int64(time.Millisecond))
+	}
+	return values
+}
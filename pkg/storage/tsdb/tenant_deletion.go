@@ -0,0 +1,57 @@
+package tsdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"time"
+)
+
+// TenantDeletionMarkFilename is the name of the deletion mark of a tenant, in
+// the directory of the tenant. The blocks of a tenant marked for deletion are
+// no longer queried, and deleted by the compactor.
+const TenantDeletionMarkFilename = "tenant-deletion-mark.json"
+
+// TenantDeletionMark is the deletion mark of a tenant.
+type TenantDeletionMark struct {
+	// Unix timestamp, in seconds, of the deletion of the tenant.
+	DeletionTime int64 `json:"deletion_time"`
+}
+
+// ReadTenantDeletionMark reads the deletion mark of a tenant, returning nil if
+// the tenant isn't marked for deletion.
+func ReadTenantDeletionMark(ctx context.Context, bkt Bucket, userID string) (*TenantDeletionMark, error) {
+	r, err := bkt.Get(ctx, path.Join(userID, TenantDeletionMarkFilename))
+	if bkt.IsObjNotFoundErr(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var mark TenantDeletionMark
+	if err := json.Unmarshal(buf, &mark); err != nil {
+		return nil, err
+	}
+	return &mark, nil
+}
+
+// MarkTenantForDeletion marks a tenant for deletion, unless it's already
+// marked.
+func MarkTenantForDeletion(ctx context.Context, bkt Bucket, userID string) error {
+	mark, err := ReadTenantDeletionMark(ctx, bkt, userID)
+	if err != nil || mark != nil {
+		return err
+	}
+	buf, err := json.Marshal(&TenantDeletionMark{DeletionTime: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	return bkt.Upload(ctx, path.Join(userID, TenantDeletionMarkFilename), bytes.NewReader(buf))
+}
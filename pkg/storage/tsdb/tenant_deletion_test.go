@@ -0,0 +1,47 @@
+package tsdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkTenantForDeletion(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "tenant-deletion-mark")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bkt, err := NewFilesystemBucket(filepath.Join(dir, "bucket"))
+	require.NoError(t, err)
+
+	mark, err := ReadTenantDeletionMark(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Nil(t, mark)
+
+	require.NoError(t, MarkTenantForDeletion(ctx, bkt, "user"))
+	mark, err = ReadTenantDeletionMark(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), time.Unix(mark.DeletionTime, 0), time.Minute)
+
+	// The tenant keeps its first mark, and is still listed.
+	require.NoError(t, bkt.Upload(ctx, path.Join("user", TenantDeletionMarkFilename), strings.NewReader(`{"deletion_time":1234}`)))
+	require.NoError(t, MarkTenantForDeletion(ctx, bkt, "user"))
+	mark, err = ReadTenantDeletionMark(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Equal(t, int64(1234), mark.DeletionTime)
+
+	users, err := ListUsers(ctx, bkt)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user"}, users)
+	ids, err := ListBlocks(ctx, bkt, "user")
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
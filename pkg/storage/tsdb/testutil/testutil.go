@@ -0,0 +1,72 @@
+package testutil
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/stretchr/testify/require"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+// CreateBlock writes a block of the series to a directory under dir, with a
+// sample of each series every step from mint to maxt, and returns its ID.
+func CreateBlock(dir string, series []labels.Labels, mint, maxt, step int64) (ulid.ULID, error) {
+	head, err := tsdb.NewHead(nil, nil, nil, maxt-mint+1)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	defer head.Close()
+
+	app := head.Appender()
+	for _, lbls := range series {
+		for t := mint; t <= maxt; t += step {
+			if _, err := app.Add(lbls, t, float64(t)); err != nil {
+				return ulid.ULID{}, err
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return ulid.ULID{}, err
+	}
+
+	compactor, err := tsdb.NewLeveledCompactor(context.Background(), nil, nil, []int64{maxt - mint + 1}, nil)
+	if err != nil {
+		return ulid.ULID{}, err
+	}
+	return compactor.Write(dir, head, mint, maxt+1, nil)
+}
+
+// BlockDir returns the local directory of a block created by CreateBlock.
+func BlockDir(dir string, id ulid.ULID) string {
+	return filepath.Join(dir, id.String())
+}
+
+// PrepareBucket creates a temporary directory, named after prefix, holding a
+// filesystem bucket. It returns the bucket and the directory, which the
+// caller has to remove.
+func PrepareBucket(t testing.TB, prefix string) (cortex_tsdb.Bucket, string) {
+	dir, err := ioutil.TempDir("", prefix)
+	require.NoError(t, err)
+
+	bkt, err := cortex_tsdb.NewFilesystemBucket(filepath.Join(dir, "bucket"))
+	require.NoError(t, err)
+	return bkt, dir
+}
+
+// UploadBlock uploads a block of the series to the bucket of a user, with a
+// sample every minute from mint to maxt, excluded. The block is created in
+// the local subdirectory of dir.
+func UploadBlock(t testing.TB, bkt cortex_tsdb.Bucket, dir, userID string, series []labels.Labels, mint, maxt int64) ulid.ULID {
+	local := filepath.Join(dir, "local")
+	id, err := CreateBlock(local, series, mint, maxt-1, int64(time.Minute/time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.UploadBlock(context.Background(), bkt, userID, BlockDir(local, id)))
+	return id
+}
@@ -0,0 +1,427 @@
+package storegateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	tsdb_labels "github.com/prometheus/prometheus/tsdb/labels"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// The bytes fetched after the offset of the last chunk of a series, so that
+// its chunks are fetched in a single request; a chunk of 120 samples is
+// usually far smaller.
+const chunkSizeEstimate = 16 * 1024
+
+// block is a block of a tenant loaded by the store-gateway. The index of the
+// block, its index-header, is downloaded and opened on the first query of the
+// block, and closed once idle or evicted, while the chunks are fetched from
+// the bucket as queried.
+type block struct {
+	userID string
+	meta   *cortex_tsdb.Meta
+	dir    string
+	bucket cortex_tsdb.Bucket
+	caches *blockCaches
+
+	mtx   sync.Mutex
+	index *index.Reader
+	// The index-header file the index is read from.
+	header io.Closer
+	// Closed once the index being loaded, if any, is loaded or failed to.
+	loading  chan struct{}
+	refs     int
+	lastUsed time.Time
+	dropped  bool
+}
+
+func newBlock(userID string, meta *cortex_tsdb.Meta, dir string, bucket cortex_tsdb.Bucket, caches *blockCaches) *block {
+	return &block{
+		userID: userID,
+		meta:   meta,
+		dir:    dir,
+		bucket: bucket,
+		caches: caches,
+	}
+}
+
+func (b *block) objectName(name string) string {
+	return path.Join(cortex_tsdb.BlockDir(b.userID, b.meta.ULID), name)
+}
+
+// acquireIndex returns the index of the block, loading it if needed, which
+// isn't unloaded until it is released. The index-header is built, if needed,
+// and opened without holding the lock of the block, the other queries of the
+// block waiting for it.
+func (b *block) acquireIndex(ctx context.Context) (*index.Reader, bool, error) {
+	for {
+		b.mtx.Lock()
+		if b.index != nil {
+			b.refs++
+			b.lastUsed = time.Now()
+			ir := b.index
+			b.mtx.Unlock()
+			return ir, false, nil
+		}
+		if loading := b.loading; loading != nil {
+			b.mtx.Unlock()
+			select {
+			case <-loading:
+				continue
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			}
+		}
+		loading := make(chan struct{})
+		b.loading = loading
+		b.mtx.Unlock()
+
+		ir, header, err := b.loadIndex(ctx)
+
+		b.mtx.Lock()
+		b.loading = nil
+		close(loading)
+		if err != nil {
+			b.mtx.Unlock()
+			return nil, false, err
+		}
+		b.index, b.header = ir, header
+		b.refs++
+		b.lastUsed = time.Now()
+		b.mtx.Unlock()
+		indexHeaderLoads.Inc()
+		indexHeadersLoaded.Inc()
+		return ir, true, nil
+	}
+}
+
+// loadIndex opens the index of the block from its index-header, built first
+// if not on disk.
+func (b *block) loadIndex(ctx context.Context) (*index.Reader, io.Closer, error) {
+	file := filepath.Join(b.dir, "index-header")
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if err := b.buildIndexHeader(ctx, file); err != nil {
+			return nil, nil, err
+		}
+	}
+	return b.openIndexHeader(file)
+}
+
+func (b *block) releaseIndex() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.refs--
+	b.lastUsed = time.Now()
+	if b.dropped && b.refs == 0 {
+		b.removeLocked()
+	}
+}
+
+// unloadIndex closes the index of the block unless it's in use, returning
+// whether it's no longer loaded.
+func (b *block) unloadIndex() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.refs > 0 {
+		return false
+	}
+	b.closeIndexLocked()
+	return true
+}
+
+// drop closes the index of a block no longer loaded by the store-gateway and
+// removes its local files, once the queries in progress are done with it.
+func (b *block) drop() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.dropped = true
+	if b.refs == 0 {
+		b.removeLocked()
+	}
+}
+
+func (b *block) closeIndexLocked() {
+	if b.index == nil {
+		return
+	}
+	b.index.Close()
+	b.header.Close()
+	b.index, b.header = nil, nil
+	indexHeaderUnloads.Inc()
+	indexHeadersLoaded.Dec()
+}
+
+func (b *block) removeLocked() {
+	b.closeIndexLocked()
+	if err := os.RemoveAll(b.dir); err != nil {
+		level.Warn(util.Logger).Log("msg", "failed to remove the local files of block", "user", b.userID, "block", b.meta.ULID, "err", err)
+	}
+}
+
+func (b *block) idleSince() time.Time {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.lastUsed
+}
+
+// series sends the chunks of the series of the block matching the matchers,
+// within mint and maxt, by batches of batchSize series, each batch being read
+// once the previous one is sent so that only one is held at once.
+func (b *block) series(ctx context.Context, ir *index.Reader, matchers []tsdb_labels.Matcher, shard *chunk.QueryShard, mint, maxt int64, limiter *seriesLimiter, batchSize int, send func([]client.TimeSeriesChunk) error) error {
+	shard, ok := b.queryShard(shard)
+	if !ok {
+		return nil
+	}
+
+	var r tsdb.IndexReader = ir
+	if b.caches.index != nil {
+		r = &cachingIndexReader{IndexReader: ir, ctx: ctx, cache: b.caches.index, block: b.meta.ULID.String()}
+	}
+	r = &limitingIndexReader{IndexReader: r, limiter: limiter}
+	postings, err := tsdb.PostingsForMatchers(r, matchers...)
+	if err != nil {
+		return err
+	}
+	refs, err := index.ExpandPostings(postings)
+	if err != nil {
+		return err
+	}
+
+	for len(refs) > 0 && ctx.Err() == nil {
+		n := batchSize
+		if n > len(refs) {
+			n = len(refs)
+		}
+		series, err := b.readSeries(ctx, ir, refs[:n])
+		if err != nil {
+			return err
+		}
+		refs = refs[n:]
+
+		batch := make([]client.TimeSeriesChunk, 0, len(series))
+		for _, s := range series {
+			lset := fromTSDBLabels(s.labels)
+			if !shard.MatchesLabels(client.FromLabelAdaptersToLabels(lset)) {
+				continue
+			}
+			inRange := s.chunks[:0]
+			for _, meta := range s.chunks {
+				if meta.MaxTime >= mint && meta.MinTime <= maxt {
+					inRange = append(inRange, meta)
+				}
+			}
+			if len(inRange) == 0 {
+				continue
+			}
+
+			chks, err := b.readChunks(ctx, inRange)
+			if err != nil {
+				return err
+			}
+			size := 0
+			for _, chk := range chks {
+				size += len(chk.Data)
+			}
+			if err := limiter.addChunkBytes(size); err != nil {
+				return err
+			}
+			batch = append(batch, client.TimeSeriesChunk{
+				Labels: lset,
+				Chunks: chks,
+			})
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := send(batch); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// queryShard returns the query shard the series of the block are filtered
+// with, nil if they're all of it, and whether the block has series of the
+// query shard at all. The series of a shard split by series ID are either all
+// or none of a query shard when one of their counts divides the other.
+func (b *block) queryShard(shard *chunk.QueryShard) (*chunk.QueryShard, bool) {
+	if shard == nil || b.meta.Shard == nil || b.meta.Shard.Hash != cortex_tsdb.ShardHashSeriesID {
+		return shard, true
+	}
+	count, index := b.meta.Shard.Count, b.meta.Shard.Index
+	of, queried := uint64(shard.Of), uint64(shard.Index)
+	switch {
+	case count%of == 0:
+		return nil, index%of == queried
+	case of%count == 0:
+		return shard, queried%count == index
+	}
+	return shard, true
+}
+
+// readChunks reads the chunks of a series from the bucket, with a request for
+// each segment file they're in.
+func (b *block) readChunks(ctx context.Context, metas []chunks.Meta) ([]client.Chunk, error) {
+	result := make([]client.Chunk, 0, len(metas))
+	for i := 0; i < len(metas); {
+		segment := metas[i].Ref >> 32
+		j := i + 1
+		for j < len(metas) && metas[j].Ref>>32 == segment {
+			j++
+		}
+
+		start := int64(uint32(metas[i].Ref))
+		end := int64(uint32(metas[j-1].Ref)) + chunkSizeEstimate
+		buf, err := b.readRange(ctx, segment, start, end-start)
+		if err != nil {
+			return nil, err
+		}
+		for _, meta := range metas[i:j] {
+			offset := int64(uint32(meta.Ref))
+			chk, err := b.decodeChunk(ctx, segment, offset, buf[offset-start:])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, chk)
+		}
+		i = j
+	}
+	return result, nil
+}
+
+// readRange reads a range of a segment file of the chunks of the block, through
+// the chunks cache if enabled.
+func (b *block) readRange(ctx context.Context, segment uint64, off, length int64) ([]byte, error) {
+	if b.caches.chunks != nil {
+		return b.readCachedRange(ctx, segment, off, length)
+	}
+	return b.readObjectRange(ctx, segment, off, length)
+}
+
+func (b *block) readObjectRange(ctx context.Context, segment uint64, off, length int64) ([]byte, error) {
+	name := b.objectName(path.Join("chunks", fmt.Sprintf("%0.6d", segment+1)))
+	r, err := b.bucket.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// decodeChunk decodes the chunk at the start of buf, read from offset of its
+// segment file, reading it again if it's larger than buf. The chunks are
+// stored as their length, encoding, data and checksum.
+func (b *block) decodeChunk(ctx context.Context, segment uint64, offset int64, buf []byte) (client.Chunk, error) {
+	length, n := binary.Uvarint(buf)
+	if n <= 0 {
+		// The length may be truncated too.
+		var err error
+		if buf, err = b.readRange(ctx, segment, offset, binary.MaxVarintLen32); err != nil {
+			return client.Chunk{}, err
+		}
+		if length, n = binary.Uvarint(buf); n <= 0 {
+			return client.Chunk{}, fmt.Errorf("reading the length of chunk %d of segment %d", offset, segment)
+		}
+	}
+	size := n + 1 + int(length)
+	if len(buf) < size {
+		var err error
+		if buf, err = b.readRange(ctx, segment, offset, int64(size)); err != nil {
+			return client.Chunk{}, err
+		}
+		if len(buf) < size {
+			return client.Chunk{}, fmt.Errorf("chunk %d of segment %d is truncated", offset, segment)
+		}
+	}
+
+	data := make([]byte, length)
+	copy(data, buf[n+1:size])
+	chk, err := chunkenc.FromData(chunkenc.Encoding(buf[n]), data)
+	if err != nil {
+		return client.Chunk{}, err
+	}
+	bigchunk, err := encoding.NewBigchunkFromXORChunks(chk)
+	if err != nil {
+		return client.Chunk{}, err
+	}
+
+	var out bytes.Buffer
+	if err := bigchunk.Marshal(&out); err != nil {
+		return client.Chunk{}, err
+	}
+	first, last := chunkTimeRange(chk)
+	return client.Chunk{
+		StartTimestampMs: first,
+		EndTimestampMs:   last,
+		Encoding:         int32(encoding.Bigchunk),
+		Data:             out.Bytes(),
+	}, nil
+}
+
+func chunkTimeRange(chk chunkenc.Chunk) (int64, int64) {
+	var first, last int64
+	it := chk.Iterator(nil)
+	for i := 0; it.Next(); i++ {
+		t, _ := it.At()
+		if i == 0 {
+			first = t
+		}
+		last = t
+	}
+	return first, last
+}
+
+func fromTSDBLabels(lset tsdb_labels.Labels) []client.LabelAdapter {
+	result := make([]client.LabelAdapter, 0, len(lset))
+	for _, l := range lset {
+		result = append(result, client.LabelAdapter{Name: l.Name, Value: l.Value})
+	}
+	return result
+}
+
+// toTSDBMatchers converts the matchers of a query to the matchers of the
+// index of the blocks.
+func toTSDBMatchers(matchers []*labels.Matcher) ([]tsdb_labels.Matcher, error) {
+	result := make([]tsdb_labels.Matcher, 0, len(matchers))
+	for _, m := range matchers {
+		switch m.Type {
+		case labels.MatchEqual:
+			result = append(result, tsdb_labels.NewEqualMatcher(m.Name, m.Value))
+		case labels.MatchNotEqual:
+			result = append(result, tsdb_labels.Not(tsdb_labels.NewEqualMatcher(m.Name, m.Value)))
+		case labels.MatchRegexp, labels.MatchNotRegexp:
+			// The matchers of the queries are anchored.
+			re, err := tsdb_labels.NewRegexpMatcher(m.Name, "^(?:"+m.Value+")$")
+			if err != nil {
+				return nil, err
+			}
+			if m.Type == labels.MatchNotRegexp {
+				re = tsdb_labels.Not(re)
+			}
+			result = append(result, re)
+		default:
+			return nil, fmt.Errorf("unsupported matcher type %v", m.Type)
+		}
+	}
+	return result, nil
+}
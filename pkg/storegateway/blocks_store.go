@@ -0,0 +1,447 @@
+package storegateway
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	otgrpc "github.com/opentracing-contrib/go-grpc"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/middleware"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/ring"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/grpcclient"
+)
+
+// BlocksStoreConfig is the config of the queriers querying the blocks
+// through the store-gateways.
+type BlocksStoreConfig struct {
+	Enabled   bool                `yaml:"enabled"`
+	Addresses flagext.StringSlice `yaml:"store_gateway_addresses"`
+	Zone      string              `yaml:"availability_zone"`
+
+	GRPCClientConfig grpcclient.Config `yaml:"store_gateway_client"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *BlocksStoreConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "querier.blocks-storage-enabled", false, "Query the blocks in the object store through the store-gateways, besides the chunk store.")
+	f.Var(&cfg.Addresses, "querier.store-gateway-addresses", "Address of a store-gateway, can be repeated. Only used when the store-gateways aren't sharded, all of them loading all the blocks.")
+	f.StringVar(&cfg.Zone, "querier.availability-zone", "", "The availability zone the querier runs in, the blocks being queried from the store-gateways of the same zone first, then from the other zones.")
+	cfg.GRPCClientConfig.RegisterFlags("querier.store-gateway-client", f)
+}
+
+// BlocksStore queries the series of the blocks of the tenants from the
+// store-gateways loading them, falling back to the other replicas of the
+// blocks when a store-gateway fails.
+type BlocksStore struct {
+	cfg         BlocksStoreConfig
+	bucket      cortex_tsdb.Bucket
+	bucketIndex cortex_tsdb.BucketIndexConfig
+	ring        *ring.Ring
+
+	// The metas of the blocks, which never change, by tenant.
+	metasMtx sync.Mutex
+	metas    map[string]map[ulid.ULID]*tsdb.BlockMeta
+
+	// The bucket indexes of the tenants, when enabled.
+	indexesMtx sync.Mutex
+	indexes    map[string]*loadedBucketIndex
+
+	clientsMtx sync.Mutex
+	clients    map[string]*grpc.ClientConn
+}
+
+// NewBlocksStore makes a new BlocksStore of the blocks in the object store,
+// using the ring of the store-gateways when they're sharded.
+func NewBlocksStore(cfg BlocksStoreConfig, gatewayCfg Config, storageCfg cortex_tsdb.Config) (*BlocksStore, error) {
+	if err := cfg.GRPCClientConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if !gatewayCfg.ShardingEnabled && len(cfg.Addresses) == 0 {
+		return nil, fmt.Errorf("no store-gateway addresses specified")
+	}
+
+	bucket, err := cortex_tsdb.NewBucketClient(context.Background(), storageCfg)
+	if err != nil {
+		return nil, err
+	}
+	cached, err := cortex_tsdb.NewCachingBucket(bucket, storageCfg.MetadataCache)
+	if err != nil {
+		bucket.Close()
+		return nil, err
+	}
+	bucket = cached
+
+	var r *ring.Ring
+	if gatewayCfg.ShardingEnabled {
+		r, err = ring.New(gatewayCfg.ShardingRing.RingConfig, "store-gateway")
+		if err != nil {
+			bucket.Close()
+			return nil, err
+		}
+	}
+	s := newBlocksStore(cfg, bucket, r)
+	s.bucketIndex = storageCfg.BucketIndex
+	return s, nil
+}
+
+func newBlocksStore(cfg BlocksStoreConfig, bucket cortex_tsdb.Bucket, r *ring.Ring) *BlocksStore {
+	return &BlocksStore{
+		cfg:     cfg,
+		bucket:  bucket,
+		ring:    r,
+		metas:   map[string]map[ulid.ULID]*tsdb.BlockMeta{},
+		indexes: map[string]*loadedBucketIndex{},
+		clients: map[string]*grpc.ClientConn{},
+	}
+}
+
+// Stop stops the BlocksStore.
+func (s *BlocksStore) Stop() {
+	if s.ring != nil {
+		s.ring.Stop()
+	}
+
+	s.clientsMtx.Lock()
+	for addr, conn := range s.clients {
+		_ = conn.Close()
+		delete(s.clients, addr)
+	}
+	s.clientsMtx.Unlock()
+
+	s.bucket.Close()
+}
+
+// Get implements querier.ChunkStore, returning the chunks of the series of
+// the blocks overlapping the time range.
+func (s *BlocksStore) Get(ctx context.Context, userID string, from, through model.Time, matchers ...*labels.Matcher) ([]chunk.Chunk, error) {
+	metas, err := s.blockMetas(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []ulid.ULID
+	for _, meta := range metas {
+		// The max time of the blocks is exclusive.
+		if meta.MinTime <= int64(through) && meta.MaxTime > int64(from) {
+			ids = append(ids, meta.ULID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	ms, err := client.ToLabelMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	// The blocks are queried from the store-gateways loading them, each one
+	// being queried for all its blocks at once.
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		result   []chunk.Chunk
+		firstErr error
+	)
+	for _, group := range s.groupBlocks(ids) {
+		wg.Add(1)
+		go func(group blocksGroup) {
+			defer wg.Done()
+			chunks, err := s.queryGroup(ctx, userID, group, &SeriesRequest{
+				MinTime:  int64(from),
+				MaxTime:  int64(through),
+				Matchers: ms,
+			})
+			if err == nil {
+				// The chunks are only known once sent by the store-gateways.
+				err = chunk.LimitChunkRefs(ctx, chunks)
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			result = append(result, chunks...)
+		}(group)
+	}
+	wg.Wait()
+	return result, firstErr
+}
+
+// blocksGroup is a set of blocks loaded by the same replicas.
+type blocksGroup struct {
+	ids      []ulid.ULID
+	replicas []string
+}
+
+// groupBlocks groups the blocks by their replicas among the store-gateways,
+// the ones in the zone of the querier first.
+func (s *BlocksStore) groupBlocks(ids []ulid.ULID) []blocksGroup {
+	if s.ring == nil {
+		// All the store-gateways load all the blocks.
+		return []blocksGroup{{ids: ids, replicas: s.cfg.Addresses}}
+	}
+
+	var (
+		groups  []blocksGroup
+		byAddrs = map[string]int{}
+	)
+	for _, id := range ids {
+		var replicas []string
+		if ingesters, err := s.ring.GetReplicas(blockKey(id), ring.Read); err == nil {
+			if s.cfg.Zone != "" {
+				sort.SliceStable(ingesters, func(i, j int) bool {
+					return ingesters[i].Zone == s.cfg.Zone && ingesters[j].Zone != s.cfg.Zone
+				})
+			}
+			for _, ing := range ingesters {
+				replicas = append(replicas, ing.Addr)
+			}
+		}
+		key := fmt.Sprintf("%v", replicas)
+		i, ok := byAddrs[key]
+		if !ok {
+			i = len(groups)
+			byAddrs[key] = i
+			groups = append(groups, blocksGroup{replicas: replicas})
+		}
+		groups[i].ids = append(groups[i].ids, id)
+	}
+	return groups
+}
+
+// queryGroup queries the blocks of a group from the first of their replicas
+// which succeeds.
+func (s *BlocksStore) queryGroup(ctx context.Context, userID string, group blocksGroup, req *SeriesRequest) ([]chunk.Chunk, error) {
+	req.BlockIds = make([]string, 0, len(group.ids))
+	for _, id := range group.ids {
+		req.BlockIds = append(req.BlockIds, id.String())
+	}
+
+	var err error
+	for _, addr := range group.replicas {
+		var chunks []chunk.Chunk
+		chunks, err = s.querySeries(ctx, userID, addr, req)
+		if err == nil {
+			return chunks, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// The other replicas would hit the same limit.
+		if resp, ok := httpgrpc.HTTPResponseFromError(err); ok && resp.Code == http.StatusUnprocessableEntity {
+			return nil, util.LimitError(resp.Body)
+		}
+		level.Warn(util.Logger).Log("msg", "failed to query the blocks from a store-gateway, trying the next replica", "addr", addr, "err", err)
+	}
+	if err == nil {
+		err = fmt.Errorf("no store-gateway loading blocks %v", req.BlockIds)
+	}
+	return nil, err
+}
+
+func (s *BlocksStore) querySeries(ctx context.Context, userID, addr string, req *SeriesRequest) ([]chunk.Chunk, error) {
+	conn, err := s.client(addr)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := NewStoreGatewayClient(conn).Series(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []chunk.Chunk
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, series := range resp.Series {
+			chunks, err := chunkcompat.FromChunks(userID, client.FromLabelAdaptersToLabels(series.Labels), series.Chunks)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, chunks...)
+		}
+	}
+}
+
+func (s *BlocksStore) client(addr string) (*grpc.ClientConn, error) {
+	s.clientsMtx.Lock()
+	defer s.clientsMtx.Unlock()
+
+	if conn, ok := s.clients[addr]; ok {
+		return conn, nil
+	}
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	opts = append(opts, s.cfg.GRPCClientConfig.DialOption(
+		[]grpc.UnaryClientInterceptor{
+			otgrpc.OpenTracingClientInterceptor(opentracing.GlobalTracer()),
+			middleware.ClientUserHeaderInterceptor,
+		},
+		[]grpc.StreamClientInterceptor{
+			otgrpc.OpenTracingStreamClientInterceptor(opentracing.GlobalTracer()),
+			middleware.StreamClientUserHeaderInterceptor,
+		},
+	)...)
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.clients[addr] = conn
+	return conn, nil
+}
+
+// blockMetas returns the metas of the blocks of a tenant, from its bucket
+// index if enabled, or reading the ones of the blocks not seen yet, e.g. of
+// the tenants without bucket index as not compacted. The tenants marked for
+// deletion have no blocks.
+func (s *BlocksStore) blockMetas(ctx context.Context, userID string) ([]*tsdb.BlockMeta, error) {
+	if s.bucketIndex.Enabled {
+		metas, found, err := s.bucketIndexMetas(ctx, userID)
+		if err != nil || found {
+			return metas, err
+		}
+	}
+
+	deletion, err := cortex_tsdb.ReadTenantDeletionMark(ctx, s.bucket, userID)
+	if err != nil {
+		return nil, err
+	}
+	if deletion != nil {
+		return nil, nil
+	}
+
+	ids, err := cortex_tsdb.ListBlocks(ctx, s.bucket, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.metasMtx.Lock()
+	cached := s.metas[userID]
+	if cached == nil {
+		cached = map[ulid.ULID]*tsdb.BlockMeta{}
+		s.metas[userID] = cached
+	}
+	s.metasMtx.Unlock()
+
+	metas := make([]*tsdb.BlockMeta, 0, len(ids))
+	listed := make(map[ulid.ULID]struct{}, len(ids))
+	for _, id := range ids {
+		listed[id] = struct{}{}
+		s.metasMtx.Lock()
+		meta := cached[id]
+		s.metasMtx.Unlock()
+		if meta == nil {
+			m, err := cortex_tsdb.ReadMeta(ctx, s.bucket, userID, id)
+			if s.bucket.IsObjNotFoundErr(err) {
+				// Partially uploaded or deleted.
+				continue
+			}
+			if cortex_tsdb.IsCorruptedMetaErr(err) {
+				// Left for the compactor to quarantine.
+				level.Warn(util.Logger).Log("msg", "skipping block with corrupted meta file", "user", userID, "block", id, "err", err)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			meta = &m.BlockMeta
+			s.metasMtx.Lock()
+			cached[id] = meta
+			s.metasMtx.Unlock()
+		}
+		metas = append(metas, meta)
+	}
+
+	// Forget the metas of the deleted blocks.
+	s.metasMtx.Lock()
+	for id := range cached {
+		if _, ok := listed[id]; !ok {
+			delete(cached, id)
+		}
+	}
+	s.metasMtx.Unlock()
+	return metas, nil
+}
+
+type loadedBucketIndex struct {
+	index    *cortex_tsdb.BucketIndex
+	deleted  bool
+	loadedAt time.Time
+}
+
+// bucketIndexMetas returns the metas of the blocks of the bucket index of a
+// tenant, read again once older than the reload interval, and whether it was
+// found. The queries fail if the index is stale, the blocks it's missing being
+// left unqueried. The tenants marked for deletion have no index loaded.
+func (s *BlocksStore) bucketIndexMetas(ctx context.Context, userID string) ([]*tsdb.BlockMeta, bool, error) {
+	s.indexesMtx.Lock()
+	loaded := s.indexes[userID]
+	s.indexesMtx.Unlock()
+
+	if loaded == nil || time.Since(loaded.loadedAt) >= s.bucketIndex.ReloadInterval {
+		deletion, err := cortex_tsdb.ReadTenantDeletionMark(ctx, s.bucket, userID)
+		if err != nil {
+			return nil, false, err
+		}
+		loaded = &loadedBucketIndex{deleted: deletion != nil, loadedAt: time.Now()}
+		if deletion == nil {
+			loaded.index, err = cortex_tsdb.ReadBucketIndex(ctx, s.bucket, userID)
+			if err == cortex_tsdb.ErrBucketIndexNotFound {
+				// The tenant isn't compacted, or not yet.
+				loaded.index, err = nil, nil
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		s.indexesMtx.Lock()
+		s.indexes[userID] = loaded
+		s.indexesMtx.Unlock()
+	}
+
+	if loaded.deleted {
+		return nil, true, nil
+	}
+	idx := loaded.index
+	if idx == nil {
+		return nil, false, nil
+	}
+	if age := time.Since(idx.UpdatedTime()); s.bucketIndex.MaxStalePeriod > 0 && age > s.bucketIndex.MaxStalePeriod {
+		return nil, false, fmt.Errorf("the bucket index of user %s is stale, last updated %s ago", userID, age.Round(time.Second))
+	}
+
+	blocks := idx.QueriedBlocks(s.bucketIndex.IgnoreDeletionMarksDelay)
+	metas := make([]*tsdb.BlockMeta, 0, len(blocks))
+	for _, b := range blocks {
+		metas = append(metas, &b.Meta().BlockMeta)
+	}
+	return metas, true, nil
+}
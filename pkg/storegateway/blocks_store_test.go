@@ -0,0 +1,161 @@
+package storegateway
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+// serveGateway serves the store-gateway over gRPC, returning its address.
+func serveGateway(t *testing.T, g *StoreGateway) (string, func()) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer(grpc.StreamInterceptor(middleware.StreamServerUserHeaderInterceptor))
+	RegisterStoreGatewayServer(srv, g)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	return lis.Addr().String(), srv.Stop
+}
+
+func TestBlocksStore(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	testutil.UploadBlock(t, bkt, dir, "user", testSeries, 2*hour, 4*hour)
+	testutil.UploadBlock(t, bkt, dir, "other", testSeries, 0, 2*hour)
+
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	require.NoError(t, g.syncBlocks(context.Background()))
+	addr, stop := serveGateway(t, g)
+	defer stop()
+
+	// The unreachable store-gateway is skipped for the next one.
+	unreachable, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	require.NoError(t, unreachable.Close())
+
+	var storeCfg BlocksStoreConfig
+	flagext.DefaultValues(&storeCfg)
+	storeCfg.Addresses = []string{unreachable.Addr().String(), addr}
+	s := newBlocksStore(storeCfg, bkt, nil)
+	defer func() {
+		for _, conn := range s.clients {
+			_ = conn.Close()
+		}
+	}()
+
+	ctx := user.InjectOrgID(context.Background(), "user")
+	matcher := mustNewMatcher(labels.MatchEqual, "__name__", "foo")
+
+	chunks, err := s.Get(ctx, "user", 0, model.Time(4*hour), matcher)
+	require.NoError(t, err)
+	series := map[string]int{}
+	for _, c := range chunks {
+		require.Equal(t, "user", c.UserID)
+		series[c.Metric.String()] += c.Data.Len()
+	}
+	require.Equal(t, map[string]int{
+		`{__name__="foo", a="1"}`: 240,
+		`{__name__="foo", a="2"}`: 240,
+	}, series)
+
+	// Only the blocks overlapping the time range are queried.
+	chunks, err = s.Get(ctx, "user", model.Time(2*hour), model.Time(3*hour), matcher)
+	require.NoError(t, err)
+	for _, c := range chunks {
+		require.True(t, int64(c.From) >= 2*hour)
+	}
+	chunks, err = s.Get(ctx, "user", model.Time(5*hour), model.Time(6*hour), matcher)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+
+	// The tenants marked for deletion have no blocks.
+	require.NoError(t, cortex_tsdb.MarkTenantForDeletion(context.Background(), bkt, "other"))
+	chunks, err = s.Get(user.InjectOrgID(context.Background(), "other"), "other", 0, model.Time(2*hour), matcher)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+
+	// The store fails once no store-gateway can be queried.
+	stop()
+	_, err = s.Get(ctx, "user", 0, model.Time(4*hour), matcher)
+	require.Error(t, err)
+}
+
+func TestBlocksStoreBucketIndex(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	addr, stop := serveGateway(t, g)
+	defer stop()
+
+	var storeCfg BlocksStoreConfig
+	flagext.DefaultValues(&storeCfg)
+	storeCfg.Addresses = []string{addr}
+	s := newBlocksStore(storeCfg, bkt, nil)
+	defer func() {
+		for _, conn := range s.clients {
+			_ = conn.Close()
+		}
+	}()
+	s.bucketIndex = cortex_tsdb.BucketIndexConfig{Enabled: true, MaxStalePeriod: time.Hour}
+
+	ctx := user.InjectOrgID(context.Background(), "user")
+	matcher := mustNewMatcher(labels.MatchEqual, "__name__", "foo")
+
+	// The blocks of the tenants without bucket index, e.g. disabled in the
+	// compactors, are listed from the bucket.
+	chunks, err := s.Get(ctx, "user", 0, model.Time(2*hour), matcher)
+	require.NoError(t, err)
+	require.NotEmpty(t, chunks)
+
+	// Once written, only the blocks of the index are queried.
+	idx, err := cortex_tsdb.UpdateBucketIndex(context.Background(), bkt, "user", nil)
+	require.NoError(t, err)
+	idx.Blocks = nil
+	require.NoError(t, cortex_tsdb.WriteBucketIndex(context.Background(), bkt, "user", idx))
+	chunks, err = s.Get(ctx, "user", 0, model.Time(2*hour), matcher)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+
+	idx, err = cortex_tsdb.UpdateBucketIndex(context.Background(), bkt, "user", nil)
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.WriteBucketIndex(context.Background(), bkt, "user", idx))
+	chunks, err = s.Get(ctx, "user", 0, model.Time(2*hour), matcher)
+	require.NoError(t, err)
+	require.NotEmpty(t, chunks)
+
+	// The queries fail once the index is stale.
+	idx.UpdatedAt = time.Now().Add(-s.bucketIndex.MaxStalePeriod - time.Minute).Unix()
+	require.NoError(t, cortex_tsdb.WriteBucketIndex(context.Background(), bkt, "user", idx))
+	_, err = s.Get(ctx, "user", 0, model.Time(2*hour), matcher)
+	require.Error(t, err)
+
+	// The tenants marked for deletion have no blocks, once the index is read
+	// again, even if stale.
+	require.NoError(t, cortex_tsdb.MarkTenantForDeletion(context.Background(), bkt, "user"))
+	chunks, err = s.Get(ctx, "user", 0, model.Time(2*hour), matcher)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+}
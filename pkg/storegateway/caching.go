@@ -0,0 +1,273 @@
+package storegateway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	tsdb_encoding "github.com/prometheus/prometheus/tsdb/encoding"
+	"github.com/prometheus/prometheus/tsdb/index"
+	tsdb_labels "github.com/prometheus/prometheus/tsdb/labels"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+)
+
+// The items cached by the store-gateway.
+const (
+	cachedPostings = "postings"
+	cachedSeries   = "series"
+	cachedChunks   = "chunks"
+)
+
+var (
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "storegateway_cache_requests_total",
+		Help:      "Total number of items requested from the caches of the store-gateway.",
+	}, []string{"item"})
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "storegateway_cache_hits_total",
+		Help:      "Total number of items found in the caches of the store-gateway.",
+	}, []string{"item"})
+)
+
+// blockCaches are the caches of the index lookups and the chunks of the
+// blocks, nil when disabled. The chunks are cached by subranges of their
+// segment files, so that the ranges read by the queries overlapping them
+// share the subranges.
+type blockCaches struct {
+	index        cache.Cache
+	chunks       cache.Cache
+	subrangeSize int64
+}
+
+// fetch fetches the keys from the cache, returning the bytes found by key.
+func fetch(ctx context.Context, c cache.Cache, item string, keys []string) map[string][]byte {
+	found, bufs, _ := c.Fetch(ctx, keys)
+	result := make(map[string][]byte, len(found))
+	for i, key := range found {
+		result[key] = bufs[i]
+	}
+	cacheRequests.WithLabelValues(item).Add(float64(len(keys)))
+	cacheHits.WithLabelValues(item).Add(float64(len(found)))
+	return result
+}
+
+// cachingIndexReader caches the postings looked up in the index of a block.
+type cachingIndexReader struct {
+	tsdb.IndexReader
+	ctx   context.Context
+	cache cache.Cache
+	block string
+}
+
+func (r *cachingIndexReader) Postings(name, value string) (index.Postings, error) {
+	// The label values may be too long or invalid in a memcached key.
+	key := "P:" + r.block + ":" + cache.HashKey(name+"\xff"+value)
+	if buf, ok := fetch(r.ctx, r.cache, cachedPostings, []string{key})[key]; ok {
+		refs, err := decodePostings(buf)
+		if err == nil {
+			return index.NewListPostings(refs), nil
+		}
+	}
+
+	p, err := r.IndexReader.Postings(name, value)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := index.ExpandPostings(p)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.Store(r.ctx, []string{key}, [][]byte{encodePostings(refs)})
+	return index.NewListPostings(refs), nil
+}
+
+// The postings are encoded as the deltas of their sorted refs.
+func encodePostings(refs []uint64) []byte {
+	var e tsdb_encoding.Encbuf
+	e.PutUvarint(len(refs))
+	var last uint64
+	for _, ref := range refs {
+		e.PutUvarint64(ref - last)
+		last = ref
+	}
+	return e.Get()
+}
+
+func decodePostings(buf []byte) ([]uint64, error) {
+	d := tsdb_encoding.Decbuf{B: buf}
+	refs := make([]uint64, 0, d.Uvarint())
+	var last uint64
+	for i := cap(refs); i > 0 && d.Err() == nil; i-- {
+		last += d.Uvarint64()
+		refs = append(refs, last)
+	}
+	return refs, d.Err()
+}
+
+// seriesEntry is a series of the index of a block.
+type seriesEntry struct {
+	labels tsdb_labels.Labels
+	chunks []chunks.Meta
+}
+
+// readSeries reads the series of the refs from the index of the block, through
+// the index cache if enabled.
+func (b *block) readSeries(ctx context.Context, ir tsdb.IndexReader, refs []uint64) ([]seriesEntry, error) {
+	result := make([]seriesEntry, len(refs))
+	if b.caches.index == nil {
+		for i, ref := range refs {
+			if err := ir.Series(ref, &result[i].labels, &result[i].chunks); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	keys := make([]string, len(refs))
+	for i, ref := range refs {
+		keys[i] = "S:" + b.meta.ULID.String() + ":" + strconv.FormatUint(ref, 10)
+	}
+	found := fetch(ctx, b.caches.index, cachedSeries, keys)
+
+	var (
+		missingKeys []string
+		missingBufs [][]byte
+	)
+	for i, ref := range refs {
+		if buf, ok := found[keys[i]]; ok {
+			if s, err := decodeSeries(buf); err == nil {
+				result[i] = s
+				continue
+			}
+		}
+		if err := ir.Series(ref, &result[i].labels, &result[i].chunks); err != nil {
+			return nil, err
+		}
+		missingKeys = append(missingKeys, keys[i])
+		missingBufs = append(missingBufs, encodeSeries(result[i]))
+	}
+	if len(missingKeys) > 0 {
+		b.caches.index.Store(ctx, missingKeys, missingBufs)
+	}
+	return result, nil
+}
+
+func encodeSeries(s seriesEntry) []byte {
+	var e tsdb_encoding.Encbuf
+	e.PutUvarint(len(s.labels))
+	for _, l := range s.labels {
+		e.PutUvarintStr(l.Name)
+		e.PutUvarintStr(l.Value)
+	}
+	e.PutUvarint(len(s.chunks))
+	for _, c := range s.chunks {
+		e.PutUvarint64(c.Ref)
+		e.PutVarint64(c.MinTime)
+		e.PutVarint64(c.MaxTime)
+	}
+	return e.Get()
+}
+
+func decodeSeries(buf []byte) (seriesEntry, error) {
+	d := tsdb_encoding.Decbuf{B: buf}
+	var s seriesEntry
+	s.labels = make(tsdb_labels.Labels, d.Uvarint())
+	for i := range s.labels {
+		s.labels[i].Name = d.UvarintStr()
+		s.labels[i].Value = d.UvarintStr()
+	}
+	s.chunks = make([]chunks.Meta, d.Uvarint())
+	for i := range s.chunks {
+		s.chunks[i].Ref = d.Uvarint64()
+		s.chunks[i].MinTime = d.Varint64()
+		s.chunks[i].MaxTime = d.Varint64()
+	}
+	return s, d.Err()
+}
+
+// readCachedRange reads a range of a segment file of the chunks of the block
+// from its subranges, the subranges missing from the cache being read from
+// the bucket, a request for each run of consecutive ones. The ranges past the
+// end of the segment file are truncated.
+func (b *block) readCachedRange(ctx context.Context, segment uint64, off, length int64) ([]byte, error) {
+	size := b.caches.subrangeSize
+	first, last := off/size, (off+length-1)/size
+	keys := make([]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		keys = append(keys, fmt.Sprintf("C:%s:%d:%d", b.meta.ULID, segment, i))
+	}
+	found := fetch(ctx, b.caches.chunks, cachedChunks, keys)
+
+	subranges := make([][]byte, len(keys))
+	var (
+		missingKeys []string
+		missingBufs [][]byte
+	)
+	for i := 0; i < len(keys); {
+		// The subranges after a short one are past the end of the segment
+		// file.
+		if i > 0 && int64(len(subranges[i-1])) < size {
+			break
+		}
+		if buf, ok := found[keys[i]]; ok {
+			subranges[i] = buf
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(keys) {
+			if _, ok := found[keys[j]]; ok {
+				break
+			}
+			j++
+		}
+
+		start := (first + int64(i)) * size
+		buf, err := b.readObjectRange(ctx, segment, start, int64(j-i)*size)
+		if err != nil {
+			return nil, err
+		}
+		// The subrange at the end of the segment file is cached shorter, or
+		// empty, so that the ranges read past it are cached too.
+		for ; i < j; i++ {
+			n := size
+			if int64(len(buf)) < n {
+				n = int64(len(buf))
+			}
+			subranges[i], buf = buf[:n], buf[n:]
+			missingKeys = append(missingKeys, keys[i])
+			missingBufs = append(missingBufs, subranges[i])
+			if n < size {
+				i++
+				break
+			}
+		}
+	}
+	if len(missingKeys) > 0 {
+		b.caches.chunks.Store(ctx, missingKeys, missingBufs)
+	}
+
+	result := make([]byte, 0, length)
+	for _, buf := range subranges {
+		result = append(result, buf...)
+		if int64(len(buf)) < size {
+			break
+		}
+	}
+	skip := off - first*size
+	if skip >= int64(len(result)) {
+		return nil, nil
+	}
+	result = result[skip:]
+	if int64(len(result)) > length {
+		result = result[:length]
+	}
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package storegateway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oklog/ulid"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	tsdb_labels "github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+)
+
+func TestStoreGatewayCaching(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	id := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+
+	uncached, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer uncached.shutdown()
+	require.NoError(t, uncached.syncBlocks(context.Background()))
+
+	// The subranges are smaller than the chunks, so that they span several.
+	cfg.DataDir = filepath.Join(dir, "cached")
+	cfg.IndexCache.Cache = cache.NewMockCache()
+	cfg.ChunksCache.Cache = cache.NewMockCache()
+	cfg.ChunksCacheSubrangeSize = 100
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	require.NoError(t, g.syncBlocks(context.Background()))
+
+	matchers := []*labels.Matcher{
+		mustNewMatcher(labels.MatchEqual, "__name__", "foo"),
+		mustNewMatcher(labels.MatchNotEqual, "a", "2"),
+	}
+	expected, err := querySeries(t, uncached, "user", []ulid.ULID{id}, 0, 2*hour, matchers...)
+	require.NoError(t, err)
+	require.Len(t, expected, 1)
+
+	series, err := querySeries(t, g, "user", []ulid.ULID{id}, 0, 2*hour, matchers...)
+	require.NoError(t, err)
+	require.Equal(t, expected, series)
+
+	// Once cached, the chunks are no longer read from the bucket.
+	hits := map[string]float64{}
+	for _, item := range []string{cachedPostings, cachedSeries, cachedChunks} {
+		hits[item] = cacheHitsOf(t, item)
+	}
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "bucket", cortex_tsdb.BlockDir("user", id), "chunks")))
+
+	series, err = querySeries(t, g, "user", []ulid.ULID{id}, 0, 2*hour, matchers...)
+	require.NoError(t, err)
+	require.Equal(t, expected, series)
+	for item, before := range hits {
+		require.True(t, cacheHitsOf(t, item) > before, item)
+	}
+}
+
+func cacheHitsOf(t *testing.T, item string) float64 {
+	var m dto.Metric
+	require.NoError(t, cacheHits.WithLabelValues(item).Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestReadCachedRange(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	id := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	meta, err := cortex_tsdb.ReadMeta(context.Background(), bkt, "user", id)
+	require.NoError(t, err)
+
+	uncached := newBlock("user", meta, cfg.DataDir, bkt, &blockCaches{})
+	b := newBlock("user", meta, cfg.DataDir, bkt, &blockCaches{chunks: cache.NewMockCache(), subrangeSize: 10})
+	ctx := context.Background()
+
+	object, err := uncached.readRange(ctx, 0, 0, 1<<20)
+	require.NoError(t, err)
+	size := int64(len(object))
+
+	for _, r := range []struct{ off, length int64 }{
+		{off: 0, length: 1},
+		{off: 5, length: 10},
+		{off: 15, length: 40},
+		// Fetching the missing subranges around the cached ones.
+		{off: 0, length: 100},
+		// The ranges exceeding the end of the segment file are truncated.
+		{off: size - 15, length: 100},
+		{off: 0, length: size + 100},
+		{off: size + 5, length: 10},
+	} {
+		buf, err := b.readRange(ctx, 0, r.off, r.length)
+		require.NoError(t, err)
+		end := r.off + r.length
+		if end > size {
+			end = size
+		}
+		if r.off >= size {
+			require.Empty(t, buf)
+			continue
+		}
+		require.Equal(t, object[r.off:end], buf, "range %d-%d", r.off, r.off+r.length)
+	}
+}
+
+func TestSeriesEncoding(t *testing.T) {
+	s := seriesEntry{
+		labels: tsdb_labels.FromStrings("__name__", "foo", "a", "1"),
+		chunks: []chunks.Meta{{Ref: 8, MinTime: -5, MaxTime: 10}, {Ref: 1<<32 | 8, MinTime: 11, MaxTime: 20}},
+	}
+	decoded, err := decodeSeries(encodeSeries(s))
+	require.NoError(t, err)
+	require.Equal(t, s, decoded)
+
+	_, err = decodeSeries(encodeSeries(s)[:5])
+	require.Error(t, err)
+
+	refs := []uint64{3, 16, 17, 1 << 40}
+	decodedRefs, err := decodePostings(encodePostings(refs))
+	require.NoError(t, err)
+	require.Equal(t, refs, decodedRefs)
+}
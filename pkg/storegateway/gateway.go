@@ -0,0 +1,532 @@
+package storegateway
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
+	tsdb_labels "github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/segmentio/fasthash/fnv1a"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/chunk/cache"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/ring"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+var (
+	blocksLoaded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cortex",
+		Name:      "storegateway_blocks_loaded",
+		Help:      "Number of blocks loaded by the store-gateway.",
+	})
+	indexHeadersLoaded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cortex",
+		Name:      "storegateway_index_headers_loaded",
+		Help:      "Number of blocks index-headers currently loaded.",
+	})
+	indexHeaderLoads = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "storegateway_index_header_loads_total",
+		Help:      "Total number of blocks index-headers loaded.",
+	})
+	indexHeaderUnloads = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "storegateway_index_header_unloads_total",
+		Help:      "Total number of blocks index-headers unloaded, evicted or idle.",
+	})
+	syncsFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cortex",
+		Name:      "storegateway_blocks_syncs_failed_total",
+		Help:      "Total number of failed syncs of the blocks loaded by the store-gateway.",
+	})
+)
+
+// Config is the config of the store-gateway.
+type Config struct {
+	DataDir                string        `yaml:"data_dir"`
+	SyncInterval           time.Duration `yaml:"sync_interval"`
+	MaxLoadedIndexHeaders  int           `yaml:"max_loaded_index_headers"`
+	IndexHeaderIdleTimeout time.Duration `yaml:"index_header_idle_timeout"`
+
+	IndexCache              cache.Config `yaml:"index_cache"`
+	ChunksCache             cache.Config `yaml:"chunks_cache"`
+	ChunksCacheSubrangeSize int64        `yaml:"chunks_cache_subrange_size"`
+
+	SeriesBatchSize              int `yaml:"series_batch_size"`
+	MaxTouchedPostingsPerQuery   int `yaml:"max_touched_postings_per_query"`
+	MaxFetchedChunkBytesPerQuery int `yaml:"max_fetched_chunk_bytes_per_query"`
+
+	ShardingEnabled bool                  `yaml:"sharding_enabled"`
+	ShardingRing    ring.LifecyclerConfig `yaml:"sharding_ring"`
+}
+
+// RegisterFlags adds the flags required to config this to the given FlagSet
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.ShardingRing.RegisterFlagsWithPrefix("store-gateway.", f)
+	// The store-gateways have a ring of their own, which mustn't share the
+	// keys of the ingesters one.
+	if prefix := f.Lookup("store-gateway.prefix"); prefix != nil {
+		prefix.DefValue = "store-gateway/"
+		_ = prefix.Value.Set(prefix.DefValue)
+	}
+
+	f.StringVar(&cfg.DataDir, "store-gateway.data-dir", "./store-gateway", "Local directory the index-headers of the blocks are stored in, which mustn't be shared with the compactor.")
+	f.DurationVar(&cfg.SyncInterval, "store-gateway.sync-interval", 5*time.Minute, "The frequency at which the blocks loaded by the store-gateway are synced with the object store and the ring.")
+	f.IntVar(&cfg.MaxLoadedIndexHeaders, "store-gateway.max-loaded-index-headers", 0, "Maximum number of index-headers loaded at once, the least recently queried being unloaded beyond. 0 to disable.")
+	f.DurationVar(&cfg.IndexHeaderIdleTimeout, "store-gateway.index-header-idle-timeout", 0, "Unload the index-headers not queried for this long. 0 to disable.")
+	cfg.IndexCache.RegisterFlagsWithPrefix("store-gateway.index-cache.", "Cache config for the postings and series of the blocks. ", f)
+	cfg.ChunksCache.RegisterFlagsWithPrefix("store-gateway.chunks-cache.", "Cache config for the chunks of the blocks. ", f)
+	f.Int64Var(&cfg.ChunksCacheSubrangeSize, "store-gateway.chunks-cache.subrange-size", 16*1024, "Size of the subranges of the chunks segment files cached, the ranges read being aligned on them.")
+	f.IntVar(&cfg.SeriesBatchSize, "store-gateway.series-batch-size", 100, "Number of series read then sent at once in the Series responses, the next ones being read once sent to the querier.")
+	f.IntVar(&cfg.MaxTouchedPostingsPerQuery, "store-gateway.max-touched-postings-per-query", 0, "Maximum number of postings a single Series request can look up in the index of its blocks, failing beyond. 0 to disable.")
+	f.IntVar(&cfg.MaxFetchedChunkBytesPerQuery, "store-gateway.max-fetched-chunk-bytes-per-query", 0, "Maximum size, in bytes, of the chunks a single Series request can fetch from its blocks, failing beyond. 0 to disable.")
+	f.BoolVar(&cfg.ShardingEnabled, "store-gateway.sharding-enabled", false, "Shard the blocks across the store-gateways using the ring, each block being loaded by -store-gateway.distributor.replication-factor of them.")
+}
+
+// StoreGateway loads the blocks of the tenants in the object store, or the
+// ones it owns in the ring when sharded, and serves their series to the
+// queriers over gRPC.
+type StoreGateway struct {
+	cfg         Config
+	bucket      cortex_tsdb.Bucket
+	bucketIndex cortex_tsdb.BucketIndexConfig
+	headers     *indexHeaders
+	caches      blockCaches
+
+	lifecycler *ring.Lifecycler
+	ring       *ring.Ring
+
+	mtx    sync.RWMutex
+	blocks map[string]map[ulid.ULID]*block
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewStoreGateway makes a new StoreGateway of the blocks in the object store
+// and starts it.
+func NewStoreGateway(cfg Config, storageCfg cortex_tsdb.Config) (*StoreGateway, error) {
+	bucket, err := cortex_tsdb.NewBucketClient(context.Background(), storageCfg)
+	if err != nil {
+		return nil, err
+	}
+	cached, err := cortex_tsdb.NewCachingBucket(bucket, storageCfg.MetadataCache)
+	if err != nil {
+		bucket.Close()
+		return nil, err
+	}
+	bucket = cached
+	g, err := newStoreGateway(cfg, bucket)
+	if err != nil {
+		bucket.Close()
+		return nil, err
+	}
+	g.bucketIndex = storageCfg.BucketIndex
+	go g.loop()
+	return g, nil
+}
+
+func newStoreGateway(cfg Config, bucket cortex_tsdb.Bucket) (*StoreGateway, error) {
+	if cfg.SeriesBatchSize <= 0 {
+		return nil, fmt.Errorf("store-gateway series batch size must be at least 1, got %d", cfg.SeriesBatchSize)
+	}
+
+	g := &StoreGateway{
+		cfg:     cfg,
+		bucket:  bucket,
+		headers: newIndexHeaders(cfg.MaxLoadedIndexHeaders),
+		blocks:  map[string]map[ulid.ULID]*block{},
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	var err error
+	if cfg.IndexCache.IsEnabled() {
+		if g.caches.index, err = cache.New(cfg.IndexCache); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ChunksCache.IsEnabled() {
+		if cfg.ChunksCacheSubrangeSize <= 0 {
+			return nil, fmt.Errorf("invalid chunks cache subrange size %d", cfg.ChunksCacheSubrangeSize)
+		}
+		if g.caches.chunks, err = cache.New(cfg.ChunksCache); err != nil {
+			return nil, err
+		}
+		g.caches.subrangeSize = cfg.ChunksCacheSubrangeSize
+	}
+
+	if cfg.ShardingEnabled {
+		g.lifecycler, err = ring.NewLifecycler(cfg.ShardingRing, g, "store-gateway")
+		if err != nil {
+			return nil, err
+		}
+		g.ring, err = ring.New(cfg.ShardingRing.RingConfig, "store-gateway")
+		if err != nil {
+			g.lifecycler.Shutdown()
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// Stop stops the StoreGateway.
+func (g *StoreGateway) Stop() {
+	close(g.quit)
+	<-g.done
+	g.shutdown()
+}
+
+// shutdown leaves the ring, unloads the blocks, stops the caches and closes
+// the bucket.
+func (g *StoreGateway) shutdown() {
+	if g.cfg.ShardingEnabled {
+		g.lifecycler.Shutdown()
+		g.ring.Stop()
+	}
+
+	g.mtx.Lock()
+	for userID, blocks := range g.blocks {
+		for id, b := range blocks {
+			g.dropBlock(userID, id, b)
+		}
+	}
+	g.mtx.Unlock()
+
+	if g.caches.index != nil {
+		g.caches.index.Stop()
+	}
+	if g.caches.chunks != nil {
+		g.caches.chunks.Stop()
+	}
+	g.bucket.Close()
+}
+
+func (g *StoreGateway) loop() {
+	defer close(g.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-g.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	syncTicker := time.NewTicker(g.cfg.SyncInterval)
+	defer syncTicker.Stop()
+
+	// The idle index-headers are checked more often than their timeout, so
+	// that they're unloaded soon after.
+	var idleTicks <-chan time.Time
+	if g.cfg.IndexHeaderIdleTimeout > 0 {
+		idleTicker := time.NewTicker(g.cfg.IndexHeaderIdleTimeout / 10)
+		defer idleTicker.Stop()
+		idleTicks = idleTicker.C
+	}
+
+	g.sync(ctx)
+	for {
+		select {
+		case <-syncTicker.C:
+			g.sync(ctx)
+		case <-idleTicks:
+			g.headers.unloadIdle(g.cfg.IndexHeaderIdleTimeout)
+		case <-g.quit:
+			return
+		}
+	}
+}
+
+func (g *StoreGateway) sync(ctx context.Context) {
+	if err := g.syncBlocks(ctx); err != nil && ctx.Err() == nil {
+		level.Error(util.Logger).Log("msg", "failed to sync the blocks loaded by the store-gateway", "err", err)
+		syncsFailed.Inc()
+	}
+}
+
+// syncBlocks loads the blocks in the object store owned by this
+// store-gateway, and drops the ones it no longer owns or which were deleted.
+func (g *StoreGateway) syncBlocks(ctx context.Context) error {
+	users, err := cortex_tsdb.ListUsers(ctx, g.bucket)
+	if err != nil {
+		return err
+	}
+
+	// The blocks failing to be listed, owned or loaded are kept as they are
+	// until the next sync, rather than failing the sync of the others.
+	var errs tsdb_errors.MultiError
+	failedUsers := map[string]struct{}{}
+	owned := map[string]map[ulid.ULID]struct{}{}
+	for _, userID := range users {
+		metas, err := g.listBlocks(ctx, userID)
+		if err != nil {
+			errs.Add(err)
+			failedUsers[userID] = struct{}{}
+			continue
+		}
+		owned[userID] = map[ulid.ULID]struct{}{}
+		for id, meta := range metas {
+			ok, err := g.ownsBlock(id)
+			if err != nil {
+				errs.Add(err)
+				owned[userID][id] = struct{}{}
+				continue
+			}
+			if !ok {
+				continue
+			}
+			b, err := g.loadBlock(ctx, userID, id, meta)
+			if err != nil {
+				errs.Add(fmt.Errorf("loading block %s of user %s: %v", id, userID, err))
+				owned[userID][id] = struct{}{}
+				continue
+			}
+			if b != nil {
+				owned[userID][id] = struct{}{}
+			}
+		}
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	for userID, blocks := range g.blocks {
+		if _, ok := failedUsers[userID]; ok {
+			continue
+		}
+		for id, b := range blocks {
+			if _, ok := owned[userID][id]; !ok {
+				g.dropBlock(userID, id, b)
+			}
+		}
+	}
+	return errs.Err()
+}
+
+// listBlocks returns the blocks of a tenant, from its bucket index if
+// enabled and written, with their meta when known without reading it. The
+// blocks of the index marked for deletion long enough aren't listed, nor the
+// blocks of the tenants marked for deletion. The blocks of the tenants without
+// bucket index, e.g. not compacted, are listed from the bucket.
+func (g *StoreGateway) listBlocks(ctx context.Context, userID string) (map[ulid.ULID]*cortex_tsdb.Meta, error) {
+	metas := map[ulid.ULID]*cortex_tsdb.Meta{}
+	deletion, err := cortex_tsdb.ReadTenantDeletionMark(ctx, g.bucket, userID)
+	if err != nil {
+		return nil, fmt.Errorf("reading tenant deletion mark of user %s: %v", userID, err)
+	}
+	if deletion != nil {
+		return metas, nil
+	}
+	if g.bucketIndex.Enabled {
+		idx, err := cortex_tsdb.ReadBucketIndex(ctx, g.bucket, userID)
+		if err != nil && err != cortex_tsdb.ErrBucketIndexNotFound {
+			return nil, fmt.Errorf("reading bucket index of user %s: %v", userID, err)
+		}
+		if err == nil {
+			for _, b := range idx.QueriedBlocks(g.bucketIndex.IgnoreDeletionMarksDelay) {
+				metas[b.ID] = b.Meta()
+			}
+			return metas, nil
+		}
+		level.Debug(util.Logger).Log("msg", "no bucket index, listing the blocks", "user", userID)
+	}
+
+	ids, err := cortex_tsdb.ListBlocks(ctx, g.bucket, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		metas[id] = nil
+	}
+	return metas, nil
+}
+
+// ownsBlock returns whether the block is one of the ones loaded by this
+// store-gateway.
+func (g *StoreGateway) ownsBlock(id ulid.ULID) (bool, error) {
+	if !g.cfg.ShardingEnabled {
+		return true, nil
+	}
+	replicas, err := g.ring.GetReplicas(blockKey(id), ring.Read)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range replicas {
+		if r.Addr == g.lifecycler.Addr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadBlock returns the block, loading it with its meta, read if nil, if it
+// isn't loaded yet, or nil if it has been deleted or is partially uploaded.
+func (g *StoreGateway) loadBlock(ctx context.Context, userID string, id ulid.ULID, meta *cortex_tsdb.Meta) (*block, error) {
+	g.mtx.RLock()
+	b := g.blocks[userID][id]
+	g.mtx.RUnlock()
+	if b != nil {
+		return b, nil
+	}
+
+	if meta == nil {
+		m, err := cortex_tsdb.ReadMeta(ctx, g.bucket, userID, id)
+		if g.bucket.IsObjNotFoundErr(err) {
+			return nil, nil
+		}
+		if cortex_tsdb.IsCorruptedMetaErr(err) {
+			// Left for the compactor to quarantine.
+			level.Warn(util.Logger).Log("msg", "skipping block with corrupted meta file", "user", userID, "block", id, "err", err)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		meta = m
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if b := g.blocks[userID][id]; b != nil {
+		return b, nil
+	}
+	if g.blocks[userID] == nil {
+		g.blocks[userID] = map[ulid.ULID]*block{}
+	}
+	b = newBlock(userID, meta, filepath.Join(g.cfg.DataDir, userID, id.String()), g.bucket, &g.caches)
+	// Leftovers of a previous run may be stale.
+	if err := os.RemoveAll(b.dir); err != nil {
+		return nil, err
+	}
+	g.blocks[userID][id] = b
+	blocksLoaded.Inc()
+	return b, nil
+}
+
+// dropBlock drops a block loaded by the store-gateway. g.mtx must be held.
+func (g *StoreGateway) dropBlock(userID string, id ulid.ULID, b *block) {
+	delete(g.blocks[userID], id)
+	if len(g.blocks[userID]) == 0 {
+		delete(g.blocks, userID)
+	}
+	g.headers.remove(b)
+	b.drop()
+	blocksLoaded.Dec()
+}
+
+// Series implements StoreGatewayServer, streaming the series of the blocks of
+// the request matching its matchers.
+func (g *StoreGateway) Series(req *SeriesRequest, srv StoreGateway_SeriesServer) error {
+	ctx := srv.Context()
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return err
+	}
+
+	matchers, err := client.FromLabelMatchers(req.Matchers)
+	if err != nil {
+		return err
+	}
+	shard, matchers, err := chunk.ExtractQueryShard(matchers)
+	if err != nil {
+		return httpgrpc.Errorf(http.StatusBadRequest, "%v", err)
+	}
+	tsdbMatchers, err := toTSDBMatchers(matchers)
+	if err != nil {
+		return err
+	}
+
+	// The batches are sent as they're read, the flow control of the stream
+	// holding back the reads of a querier slower than the store-gateway.
+	limiter := newSeriesLimiter(g.cfg)
+	send := func(series []client.TimeSeriesChunk) error {
+		return srv.Send(&SeriesResponse{Series: series})
+	}
+	for _, blockID := range req.BlockIds {
+		id, err := ulid.Parse(blockID)
+		if err != nil {
+			return err
+		}
+		if err := g.blockSeries(ctx, userID, id, req, tsdbMatchers, shard, limiter, send); err != nil {
+			if _, ok := httpgrpc.HTTPResponseFromError(err); ok {
+				return err
+			}
+			return fmt.Errorf("querying block %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func (g *StoreGateway) blockSeries(ctx context.Context, userID string, id ulid.ULID, req *SeriesRequest, matchers []tsdb_labels.Matcher, shard *chunk.QueryShard, limiter *seriesLimiter, send func([]client.TimeSeriesChunk) error) error {
+	// The blocks uploaded since the last sync are loaded on their first query.
+	owned, err := g.ownsBlock(id)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return fmt.Errorf("block isn't owned by the store-gateway")
+	}
+	b, err := g.loadBlock(ctx, userID, id, nil)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		// Deleted since the querier listed it, e.g. compacted.
+		return nil
+	}
+
+	ir, _, err := b.acquireIndex(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.releaseIndex()
+	g.headers.touch(b)
+
+	return b.series(ctx, ir, matchers, shard, req.MinTime, req.MaxTime, limiter, g.cfg.SeriesBatchSize, send)
+}
+
+func (g *StoreGateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if g.cfg.ShardingEnabled {
+		g.ring.ServeHTTP(w, req)
+	} else {
+		var unshardedPage = `
+			<!DOCTYPE html>
+			<html>
+				<head>
+					<meta charset="UTF-8">
+					<title>Cortex Store Gateway Status</title>
+				</head>
+				<body>
+					<h1>Cortex Store Gateway Status</h1>
+					<p>Store gateway running with shards disabled</p>
+				</body>
+			</html>`
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(unshardedPage))
+	}
+}
+
+// blockKey is the key of a block in the ring. Hashed with fnv1a, so that the
+// blocks whose IDs only differ in their last characters are spread across
+// the ring.
+func blockKey(id ulid.ULID) uint32 {
+	return fnv1a.HashString32(id.String())
+}
@@ -0,0 +1,554 @@
+package storegateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	tsdb_labels "github.com/prometheus/prometheus/tsdb/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/test"
+)
+
+const hour = int64(time.Hour / time.Millisecond)
+
+var testSeries = []tsdb_labels.Labels{
+	tsdb_labels.FromStrings("__name__", "foo", "a", "1"),
+	tsdb_labels.FromStrings("__name__", "foo", "a", "2"),
+	tsdb_labels.FromStrings("__name__", "bar", "a", "1"),
+}
+
+func prepare(t *testing.T) (Config, cortex_tsdb.Bucket, string) {
+	bkt, dir := testutil.PrepareBucket(t, "storegateway")
+
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.DataDir = filepath.Join(dir, "data")
+	return cfg, bkt, dir
+}
+
+// mockSeriesServer collects the responses of a Series request.
+type mockSeriesServer struct {
+	grpc.ServerStream
+	ctx       context.Context
+	responses []*SeriesResponse
+}
+
+func (s *mockSeriesServer) Context() context.Context { return s.ctx }
+
+func (s *mockSeriesServer) Send(resp *SeriesResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func querySeries(t *testing.T, g *StoreGateway, userID string, ids []ulid.ULID, mint, maxt int64, matchers ...*labels.Matcher) ([]client.TimeSeriesChunk, error) {
+	ms, err := client.ToLabelMatchers(matchers)
+	require.NoError(t, err)
+	req := &SeriesRequest{MinTime: mint, MaxTime: maxt, Matchers: ms}
+	for _, id := range ids {
+		req.BlockIds = append(req.BlockIds, id.String())
+	}
+
+	srv := &mockSeriesServer{ctx: user.InjectOrgID(context.Background(), userID)}
+	if err := g.Series(req, srv); err != nil {
+		return nil, err
+	}
+	var result []client.TimeSeriesChunk
+	for _, resp := range srv.responses {
+		result = append(result, resp.Series...)
+	}
+	return result, nil
+}
+
+// countSamples returns the number of samples of the series within mint and
+// maxt.
+func countSamples(t *testing.T, series []client.TimeSeriesChunk, mint, maxt int64) int {
+	matrix, err := chunkcompat.SeriesChunksToMatrix(model.Time(mint), model.Time(maxt), series)
+	require.NoError(t, err)
+	samples := 0
+	for _, s := range matrix {
+		samples += len(s.Values)
+	}
+	return samples
+}
+
+func TestStoreGatewaySeries(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	first := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	second := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 2*hour, 4*hour)
+
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.Len(t, g.blocks["user"], 2)
+
+	for _, tc := range []struct {
+		name     string
+		ids      []ulid.ULID
+		mint     int64
+		maxt     int64
+		matchers []*labels.Matcher
+		series   int
+		samples  int
+	}{
+		{
+			name:     "all series of a block",
+			ids:      []ulid.ULID{first},
+			mint:     0,
+			maxt:     2 * hour,
+			matchers: []*labels.Matcher{mustNewMatcher(labels.MatchRegexp, "__name__", ".+")},
+			series:   3,
+			samples:  3 * 120,
+		},
+		{
+			name:     "equal matcher across the blocks",
+			ids:      []ulid.ULID{first, second},
+			mint:     0,
+			maxt:     4 * hour,
+			matchers: []*labels.Matcher{mustNewMatcher(labels.MatchEqual, "__name__", "foo")},
+			series:   4,
+			samples:  2 * 240,
+		},
+		{
+			name: "anchored regexp and not equal matchers",
+			ids:  []ulid.ULID{first},
+			mint: 0,
+			maxt: 2 * hour,
+			matchers: []*labels.Matcher{
+				mustNewMatcher(labels.MatchRegexp, "__name__", "fo"),
+				mustNewMatcher(labels.MatchNotEqual, "a", "2"),
+			},
+		},
+		{
+			name: "time range",
+			ids:  []ulid.ULID{first, second},
+			mint: 2 * hour,
+			maxt: 3 * hour,
+			matchers: []*labels.Matcher{
+				mustNewMatcher(labels.MatchEqual, "__name__", "foo"),
+				mustNewMatcher(labels.MatchNotRegexp, "a", "2"),
+			},
+			series:  1,
+			samples: 61,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			series, err := querySeries(t, g, "user", tc.ids, tc.mint, tc.maxt, tc.matchers...)
+			require.NoError(t, err)
+			require.Len(t, series, tc.series)
+			require.Equal(t, tc.samples, countSamples(t, series, tc.mint, tc.maxt))
+		})
+	}
+
+	// The blocks of the other tenants aren't queried.
+	series, err := querySeries(t, g, "other", []ulid.ULID{first}, 0, 2*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+	require.NoError(t, err)
+	require.Empty(t, series)
+}
+
+// uploadShardBlock uploads a block of the test series like
+// testutil.UploadBlock, its meta claiming it's of a shard.
+func uploadShardBlock(t *testing.T, bkt cortex_tsdb.Bucket, dir, userID string, mint, maxt int64, shard cortex_tsdb.BlockShard) ulid.ULID {
+	local := filepath.Join(dir, "local")
+	id, err := testutil.CreateBlock(local, testSeries, mint, maxt-1, hour/60)
+	require.NoError(t, err)
+	meta, err := cortex_tsdb.ReadLocalMeta(testutil.BlockDir(local, id))
+	require.NoError(t, err)
+	meta.Shard = &shard
+	require.NoError(t, cortex_tsdb.WriteLocalMeta(testutil.BlockDir(local, id), meta))
+	require.NoError(t, cortex_tsdb.UploadBlock(context.Background(), bkt, userID, testutil.BlockDir(local, id)))
+	return id
+}
+
+func TestStoreGatewayQueryShards(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	// The blocks claim to be of a shard while having all the test series, to
+	// tell the series filtered from the blocks skipped.
+	unsplit := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	bySeriesID := uploadShardBlock(t, bkt, dir, "user", 0, 2*hour, cortex_tsdb.BlockShard{Index: 1, Count: 4, Hash: cortex_tsdb.ShardHashSeriesID})
+	byLabels := uploadShardBlock(t, bkt, dir, "user", 0, 2*hour, cortex_tsdb.BlockShard{Index: 1, Count: 4})
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+
+	inShard := func(shard chunk.QueryShard) int {
+		n := 0
+		for _, s := range testSeries {
+			if shard.MatchesLabels(client.FromLabelAdaptersToLabels(fromTSDBLabels(s))) {
+				n++
+			}
+		}
+		return n
+	}
+	for _, tc := range []struct {
+		name   string
+		id     ulid.ULID
+		shard  chunk.QueryShard
+		series int
+	}{
+		{name: "unsplit block", id: unsplit, shard: chunk.QueryShard{Index: 1, Of: 2}, series: inShard(chunk.QueryShard{Index: 1, Of: 2})},
+		{name: "block of the query shard", id: bySeriesID, shard: chunk.QueryShard{Index: 1, Of: 2}, series: len(testSeries)},
+		{name: "block of another query shard", id: bySeriesID, shard: chunk.QueryShard{Index: 0, Of: 2}, series: 0},
+		{name: "query shard of the block", id: bySeriesID, shard: chunk.QueryShard{Index: 5, Of: 8}, series: inShard(chunk.QueryShard{Index: 5, Of: 8})},
+		{name: "query shard of another block", id: bySeriesID, shard: chunk.QueryShard{Index: 2, Of: 8}, series: 0},
+		{name: "shard counts not dividing", id: bySeriesID, shard: chunk.QueryShard{Index: 2, Of: 3}, series: inShard(chunk.QueryShard{Index: 2, Of: 3})},
+		{name: "block split by labels", id: byLabels, shard: chunk.QueryShard{Index: 0, Of: 2}, series: inShard(chunk.QueryShard{Index: 0, Of: 2})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			series, err := querySeries(t, g, "user", []ulid.ULID{tc.id}, 0, 2*hour, mustNewMatcher(labels.MatchRegexp, "a", ".+"), mustNewMatcher(labels.MatchEqual, chunk.QueryShardLabel, tc.shard.String()))
+			require.NoError(t, err)
+			require.Len(t, series, tc.series)
+		})
+	}
+
+	_, err = querySeries(t, g, "user", []ulid.ULID{unsplit}, 0, 2*hour, mustNewMatcher(labels.MatchRegexp, "a", ".+"), mustNewMatcher(labels.MatchEqual, chunk.QueryShardLabel, "2_of_2"))
+	require.Error(t, err)
+}
+
+func TestStoreGatewaySyncBlocks(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	first := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	require.NoError(t, g.syncBlocks(context.Background()))
+
+	// The blocks uploaded since the last sync are loaded on their first query.
+	second := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 2*hour, 4*hour)
+	series, err := querySeries(t, g, "user", []ulid.ULID{second}, 0, 4*hour, mustNewMatcher(labels.MatchEqual, "__name__", "bar"))
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	require.FileExists(t, filepath.Join(cfg.DataDir, "user", second.String(), "index-header"))
+
+	// The deleted blocks are dropped, with their local files.
+	require.NoError(t, cortex_tsdb.DeleteBlock(context.Background(), bkt, "user", second))
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.Len(t, g.blocks["user"], 1)
+	require.NotNil(t, g.blocks["user"][first])
+	_, err = os.Stat(filepath.Join(cfg.DataDir, "user", second.String()))
+	require.True(t, os.IsNotExist(err))
+
+	// The blocks with a corrupted meta file are skipped.
+	corrupted := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 4*hour, 6*hour)
+	require.NoError(t, bkt.Upload(context.Background(), path.Join(cortex_tsdb.BlockDir("user", corrupted), cortex_tsdb.MetaFilename), strings.NewReader("{")))
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.Len(t, g.blocks["user"], 1)
+
+	// The blocks of the tenants marked for deletion are dropped.
+	require.NoError(t, cortex_tsdb.MarkTenantForDeletion(context.Background(), bkt, "user"))
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.Empty(t, g.blocks["user"])
+}
+
+// failingBucket fails the reads of its objects with an error.
+type failingBucket struct {
+	cortex_tsdb.Bucket
+	failures map[string]error
+}
+
+func (b *failingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := b.failures[name]; err != nil {
+		return nil, err
+	}
+	return b.Bucket.Get(ctx, name)
+}
+
+func TestStoreGatewaySyncBlocksFailures(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	failing := &failingBucket{Bucket: bkt, failures: map[string]error{}}
+	first := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	g, err := newStoreGateway(cfg, failing)
+	require.NoError(t, err)
+	defer g.shutdown()
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.Len(t, g.blocks["user"], 1)
+
+	// The blocks of the tenants failing to be listed are kept, and the other
+	// blocks are loaded past the blocks failing to be loaded.
+	failing.failures[path.Join("user", cortex_tsdb.TenantDeletionMarkFilename)] = errors.New("listing failed")
+	broken := testutil.UploadBlock(t, bkt, dir, "other", testSeries, 0, 2*hour)
+	failing.failures[path.Join(cortex_tsdb.BlockDir("other", broken), cortex_tsdb.MetaFilename)] = errors.New("loading failed")
+	loaded := testutil.UploadBlock(t, bkt, dir, "other", testSeries, 2*hour, 4*hour)
+	err = g.syncBlocks(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "listing failed")
+	require.Contains(t, err.Error(), "loading failed")
+	require.Len(t, g.blocks["user"], 1)
+	require.Contains(t, g.blocks["user"], first)
+	require.Len(t, g.blocks["other"], 1)
+	require.Contains(t, g.blocks["other"], loaded)
+
+	// The blocks are loaded once they no longer fail.
+	failing.failures = map[string]error{}
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.Len(t, g.blocks["user"], 1)
+	require.Len(t, g.blocks["other"], 2)
+}
+
+func TestStoreGatewaySyncBlocksFromBucketIndex(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	first := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	g.bucketIndex.Enabled = true
+
+	// The blocks of the tenants without bucket index, e.g. disabled in the
+	// compactors, are listed from the bucket.
+	disabled := testutil.UploadBlock(t, bkt, dir, "disabled", testSeries, 0, 2*hour)
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.Len(t, g.blocks["user"], 1)
+	require.Len(t, g.blocks["disabled"], 1)
+	require.Contains(t, g.blocks["disabled"], disabled)
+
+	idx, err := cortex_tsdb.UpdateBucketIndex(context.Background(), bkt, "user", nil)
+	require.NoError(t, err)
+	require.NoError(t, cortex_tsdb.WriteBucketIndex(context.Background(), bkt, "user", idx))
+
+	// The blocks uploaded since the index was written aren't loaded.
+	testutil.UploadBlock(t, bkt, dir, "user", testSeries, 2*hour, 4*hour)
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.Len(t, g.blocks["user"], 1)
+	require.Len(t, g.blocks["disabled"], 1)
+	require.Equal(t, int64(0), g.blocks["user"][first].meta.MinTime)
+	require.Equal(t, 2*hour, g.blocks["user"][first].meta.MaxTime)
+
+	series, err := querySeries(t, g, "user", []ulid.ULID{first}, 0, 2*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+}
+
+func TestStoreGatewayIndexHeaders(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	var ids []ulid.ULID
+	for i := int64(0); i < 3; i++ {
+		ids = append(ids, testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour))
+	}
+
+	cfg.MaxLoadedIndexHeaders = 2
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	require.NoError(t, g.syncBlocks(context.Background()))
+
+	// The index-headers are only loaded once the blocks are queried.
+	require.Equal(t, 0, g.headers.len())
+	loaded := func() []ulid.ULID {
+		var result []ulid.ULID
+		for _, id := range ids {
+			if g.blocks["user"][id].index != nil {
+				result = append(result, id)
+			}
+		}
+		return result
+	}
+
+	query := func(id ulid.ULID) {
+		_, err := querySeries(t, g, "user", []ulid.ULID{id}, 0, 6*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+		require.NoError(t, err)
+	}
+	query(ids[0])
+	query(ids[1])
+	require.Equal(t, []ulid.ULID{ids[0], ids[1]}, loaded())
+
+	// The least recently queried index-header is unloaded beyond the max.
+	query(ids[0])
+	query(ids[2])
+	require.Equal(t, []ulid.ULID{ids[0], ids[2]}, loaded())
+	require.Equal(t, 2, g.headers.len())
+
+	// The index-headers in use aren't unloaded.
+	b := g.blocks["user"][ids[1]]
+	_, _, err = b.acquireIndex(context.Background())
+	require.NoError(t, err)
+	g.headers.touch(b)
+	g.headers.unloadIdle(0)
+	require.Equal(t, []ulid.ULID{ids[1]}, loaded())
+	b.releaseIndex()
+
+	// The idle index-headers are unloaded.
+	g.headers.unloadIdle(time.Hour)
+	require.Equal(t, []ulid.ULID{ids[1]}, loaded())
+	g.headers.unloadIdle(0)
+	require.Empty(t, loaded())
+	require.Equal(t, 0, g.headers.len())
+}
+
+func TestStoreGatewaySharding(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	var expected []ulid.ULID
+	for i := int64(0); i < 20; i++ {
+		expected = append(expected, testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour))
+	}
+
+	gateways := startShardedGateways(t, cfg, bkt, dir, map[string]string{"1.1.1.1": "", "2.2.2.2": "", "3.3.3.3": ""})
+	defer func() {
+		for _, g := range gateways {
+			g.shutdown()
+		}
+	}()
+
+	// Each block is loaded by 2 store-gateways.
+	replicas := map[ulid.ULID]int{}
+	for _, g := range gateways {
+		require.NoError(t, g.syncBlocks(context.Background()))
+		require.NotEmpty(t, g.blocks["user"])
+		require.True(t, len(g.blocks["user"]) < len(expected))
+		for id := range g.blocks["user"] {
+			replicas[id]++
+		}
+	}
+	require.Len(t, replicas, len(expected))
+	for _, id := range expected {
+		require.Equal(t, 2, replicas[id], fmt.Sprintf("block %s", id))
+	}
+
+	// The store-gateways refuse the queries of the blocks they don't own.
+	for _, g := range gateways {
+		for _, id := range expected {
+			_, owned := g.blocks["user"][id]
+			_, err := querySeries(t, g, "user", []ulid.ULID{id}, 0, 40*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+			require.Equal(t, owned, err == nil)
+		}
+	}
+}
+
+func TestStoreGatewayZoneAwareSharding(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	var expected []ulid.ULID
+	for i := int64(0); i < 20; i++ {
+		expected = append(expected, testutil.UploadBlock(t, bkt, dir, "user", testSeries, i*2*hour, (i+1)*2*hour))
+	}
+
+	zones := map[string]string{"1.1.1.1": "a", "2.2.2.2": "a", "3.3.3.3": "b", "4.4.4.4": "b"}
+	cfg.ShardingRing.RingConfig.ZoneAwarenessEnabled = true
+	gateways := startShardedGateways(t, cfg, bkt, dir, zones)
+	defer func() {
+		for _, g := range gateways {
+			g.shutdown()
+		}
+	}()
+
+	zoneOf := func(addr string) string {
+		host, _, err := net.SplitHostPort(addr)
+		require.NoError(t, err)
+		return zones[host]
+	}
+
+	// Each block is loaded by a store-gateway of each zone.
+	replicas := map[ulid.ULID]map[string]int{}
+	for _, g := range gateways {
+		require.NoError(t, g.syncBlocks(context.Background()))
+		for id := range g.blocks["user"] {
+			if replicas[id] == nil {
+				replicas[id] = map[string]int{}
+			}
+			replicas[id][zoneOf(g.lifecycler.Addr)]++
+		}
+	}
+	require.Len(t, replicas, len(expected))
+	for _, id := range expected {
+		require.Equal(t, map[string]int{"a": 1, "b": 1}, replicas[id], fmt.Sprintf("block %s", id))
+	}
+
+	// The queriers query the store-gateways of their zone first.
+	for _, zone := range []string{"a", "b"} {
+		s := newBlocksStore(BlocksStoreConfig{Zone: zone}, bkt, gateways[0].ring)
+		groups := s.groupBlocks(expected)
+		require.NotEmpty(t, groups)
+		for _, group := range groups {
+			require.Len(t, group.replicas, 2)
+			require.Equal(t, zone, zoneOf(group.replicas[0]))
+			require.NotEqual(t, zone, zoneOf(group.replicas[1]))
+		}
+	}
+}
+
+// startShardedGateways starts store-gateways sharding the blocks, at the
+// addresses in their availability zones, and waits for all of them to be
+// active in the ring.
+func startShardedGateways(t *testing.T, cfg Config, bkt cortex_tsdb.Bucket, dir string, zones map[string]string) []*StoreGateway {
+	kvStore := consul.NewInMemoryClient(ring.GetCodec())
+	gateways := make([]*StoreGateway, 0, len(zones))
+	for addr, zone := range zones {
+		cfg := cfg
+		cfg.DataDir = filepath.Join(dir, addr)
+		cfg.ShardingEnabled = true
+		cfg.ShardingRing.RingConfig.KVStore.Mock = kvStore
+		cfg.ShardingRing.RingConfig.ReplicationFactor = 2
+		cfg.ShardingRing.Addr = addr
+		cfg.ShardingRing.Port = 1
+		cfg.ShardingRing.ID = addr
+		cfg.ShardingRing.Zone = zone
+		cfg.ShardingRing.NumTokens = 64
+		cfg.ShardingRing.FinalSleep = 0
+
+		g, err := newStoreGateway(cfg, bkt)
+		require.NoError(t, err)
+		gateways = append(gateways, g)
+	}
+	for _, g := range gateways {
+		g := g
+		test.Poll(t, 5*time.Second, len(zones), func() interface{} {
+			set, err := g.ring.GetAll()
+			if err != nil {
+				return 0
+			}
+			active := 0
+			for _, ing := range set.Ingesters {
+				if ing.State == ring.ACTIVE {
+					active++
+				}
+			}
+			return active
+		})
+	}
+	return gateways
+}
+
+func mustNewMatcher(t labels.MatchType, name, value string) *labels.Matcher {
+	m, err := labels.NewMatcher(t, name, value)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
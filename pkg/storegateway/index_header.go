@@ -0,0 +1,232 @@
+package storegateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const (
+	// The size of the TOC at the end of an index.
+	indexTOCLen = 6*8 + 4
+
+	// The bytes read from the bucket from the start of a series or postings
+	// list, so that its length and its content are read in a single request,
+	// as are the nearby series of a batch.
+	indexReadAhead = 16 * 1024
+)
+
+// The index-header of a block holds the sections of its index read when the
+// index is opened, and to look up the label values: the symbols, the label
+// indices and the offset tables, with the TOC. They're written one after the
+// other, followed by the size of the index. The series and the postings are
+// read from the bucket as queried.
+
+// buildIndexHeader writes the index-header of the block to file, reading only
+// its sections from the index in the bucket.
+func (b *block) buildIndexHeader(ctx context.Context, file string) error {
+	name := b.objectName("index")
+	size, err := b.bucket.ObjectSize(ctx, name)
+	if err != nil {
+		return err
+	}
+	if size < indexTOCLen {
+		return fmt.Errorf("index of %d bytes is too small", size)
+	}
+	buf, err := readBucketRange(ctx, b.bucket, name, size-indexTOCLen, indexTOCLen)
+	if err != nil {
+		return err
+	}
+	toc, err := index.NewTOCFromByteSlice(byteSlice(buf))
+	if err != nil {
+		return err
+	}
+	sections, err := indexHeaderSections(toc, size)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(b.dir, 0777); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := writeIndexHeader(ctx, f, b.bucket, name, sections, size); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func writeIndexHeader(ctx context.Context, w io.Writer, bkt cortex_tsdb.Bucket, name string, sections [][2]int64, size int64) error {
+	for _, s := range sections {
+		r, err := bkt.GetRange(ctx, name, s[0], s[1]-s[0])
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(w, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if n != s[1]-s[0] {
+			return fmt.Errorf("read %d bytes of index section [%d, %d)", n, s[0], s[1])
+		}
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(size))
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// indexHeaderSections returns the ranges of the index held in its
+// index-header: from its start to the series, the label indices, and from
+// the offset tables to its end.
+func indexHeaderSections(toc *index.TOC, size int64) ([][2]int64, error) {
+	offsets := []uint64{toc.Series, toc.LabelIndices, toc.Postings, toc.LabelIndicesTable, uint64(size)}
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i-1] == 0 || offsets[i-1] > offsets[i] {
+			return nil, fmt.Errorf("unexpected index sections %+v of an index of %d bytes", *toc, size)
+		}
+	}
+	return [][2]int64{
+		{0, int64(toc.Series)},
+		{int64(toc.LabelIndices), int64(toc.Postings)},
+		{int64(toc.LabelIndicesTable), size},
+	}, nil
+}
+
+// openIndexHeader opens the index of the block from its index-header, reading
+// the series and the postings from the bucket.
+func (b *block) openIndexHeader(file string) (*index.Reader, io.Closer, error) {
+	f, err := fileutil.OpenMmapFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := b.newIndexHeaderReader(f.Bytes())
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("opening index-header %s: %v", file, err)
+	}
+	return r, f, nil
+}
+
+func (b *block) newIndexHeaderReader(buf []byte) (*index.Reader, error) {
+	if len(buf) < 8+indexTOCLen {
+		return nil, fmt.Errorf("index-header of %d bytes is too small", len(buf))
+	}
+	size := int64(binary.BigEndian.Uint64(buf[len(buf)-8:]))
+	buf = buf[:len(buf)-8]
+	toc, err := index.NewTOCFromByteSlice(byteSlice(buf))
+	if err != nil {
+		return nil, err
+	}
+	sections, err := indexHeaderSections(toc, size)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := &indexHeaderSlice{size: int(size), block: b}
+	for _, s := range sections {
+		n := int(s[1] - s[0])
+		if len(buf) < n {
+			return nil, fmt.Errorf("index-header truncated")
+		}
+		bs.local = append(bs.local, indexSection{start: int(s[0]), data: buf[:n]})
+		buf = buf[n:]
+	}
+	return index.NewReader(bs)
+}
+
+type indexSection struct {
+	start int
+	data  []byte
+}
+
+func (s indexSection) contains(start, end int) bool {
+	return start >= s.start && end <= s.start+len(s.data)
+}
+
+// indexHeaderSlice is the index of a block, its index-header sections held
+// locally and the others read from the bucket, with a read ahead.
+type indexHeaderSlice struct {
+	size  int
+	local []indexSection
+	block *block
+
+	mtx  sync.Mutex
+	last indexSection
+}
+
+func (s *indexHeaderSlice) Len() int {
+	return s.size
+}
+
+// Range returns the bytes of the index from start to end. As the errors of
+// the bucket can't be returned, they're logged and bytes failing the decoding
+// of the series and postings are returned: a length over the size of the
+// index, or an invalid uvarint.
+func (s *indexHeaderSlice) Range(start, end int) []byte {
+	for _, section := range s.local {
+		if section.contains(start, end) {
+			return section.data[start-section.start : end-section.start]
+		}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.last.contains(start, end) {
+		length := end - start
+		if length < indexReadAhead {
+			length = indexReadAhead
+		}
+		if start+length > s.size {
+			length = s.size - start
+		}
+		buf, err := readBucketRange(context.Background(), s.block.bucket, s.block.objectName("index"), int64(start), int64(length))
+		if err == nil && len(buf) < end-start {
+			err = fmt.Errorf("read %d bytes of the index from %d, expected %d", len(buf), start, end-start)
+		}
+		if err != nil {
+			level.Error(util.Logger).Log("msg", "failed to read the index of block", "user", s.block.userID, "block", s.block.meta.ULID, "err", err)
+			return bytes.Repeat([]byte{0xff}, end-start)
+		}
+		s.last = indexSection{start: start, data: buf}
+	}
+	return s.last.data[start-s.last.start : end-s.last.start]
+}
+
+func readBucketRange(ctx context.Context, bkt cortex_tsdb.Bucket, name string, off, length int64) ([]byte, error) {
+	r, err := bkt.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// byteSlice is an index.ByteSlice of a buffer.
+type byteSlice []byte
+
+func (b byteSlice) Len() int                    { return len(b) }
+func (b byteSlice) Range(start, end int) []byte { return b[start:end] }
@@ -0,0 +1,87 @@
+package storegateway
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// indexHeaders keeps track of the blocks whose index-header is loaded, in
+// the order they were last queried, to unload the least recently queried
+// ones beyond the max and the idle ones.
+type indexHeaders struct {
+	max int
+
+	mtx    sync.Mutex
+	lru    *list.List
+	blocks map[*block]*list.Element
+}
+
+func newIndexHeaders(max int) *indexHeaders {
+	return &indexHeaders{
+		max:    max,
+		lru:    list.New(),
+		blocks: map[*block]*list.Element{},
+	}
+}
+
+// touch records that the index-header of the block was queried, unloading
+// the least recently queried ones if there are more than the max loaded. The
+// index-headers in use aren't unloaded, so there may temporarily be more.
+func (h *indexHeaders) touch(b *block) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if e, ok := h.blocks[b]; ok {
+		h.lru.MoveToFront(e)
+	} else {
+		h.blocks[b] = h.lru.PushFront(b)
+	}
+
+	if h.max <= 0 {
+		return
+	}
+	for e := h.lru.Back(); e != nil && h.lru.Len() > h.max; {
+		prev := e.Prev()
+		if victim := e.Value.(*block); victim.unloadIndex() {
+			h.lru.Remove(e)
+			delete(h.blocks, victim)
+		}
+		e = prev
+	}
+}
+
+// unloadIdle unloads the index-headers not queried for the timeout.
+func (h *indexHeaders) unloadIdle(timeout time.Duration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	deadline := time.Now().Add(-timeout)
+	for e := h.lru.Back(); e != nil; {
+		prev := e.Prev()
+		b := e.Value.(*block)
+		if b.idleSince().Before(deadline) && b.unloadIndex() {
+			h.lru.Remove(e)
+			delete(h.blocks, b)
+		}
+		e = prev
+	}
+}
+
+// remove stops keeping track of a block, once it's no longer loaded by the
+// store-gateway.
+func (h *indexHeaders) remove(b *block) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if e, ok := h.blocks[b]; ok {
+		h.lru.Remove(e)
+		delete(h.blocks, b)
+	}
+}
+
+func (h *indexHeaders) len() int {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.lru.Len()
+}
@@ -0,0 +1,18 @@
+package storegateway
+
+import (
+	"context"
+)
+
+// TransferOut is a noop for the store-gateway, the blocks are all in the
+// object store.
+func (g *StoreGateway) TransferOut(ctx context.Context) error {
+	return nil
+}
+
+// StopIncomingRequests is a noop for the store-gateway, the queriers moving
+// on to the other replicas of its blocks once it leaves the ring.
+func (g *StoreGateway) StopIncomingRequests() {}
+
+// Flush is a noop for the store-gateway, which has nothing to flush.
+func (g *StoreGateway) Flush() {}
@@ -0,0 +1,119 @@
+package storegateway
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/index"
+
+	"github.com/cortexproject/cortex/pkg/querier/errstatus"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const (
+	errMaxTouchedPostings   = "the query hit the max number of touched postings limit of the store-gateway (limit: %d postings)"
+	errMaxFetchedChunkBytes = "the query hit the max size of chunk data limit of the store-gateway (limit: %d bytes)"
+)
+
+var queriesLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cortex",
+	Name:      "storegateway_queries_limited_total",
+	Help:      "Total number of Series requests failed by a limit of the store-gateway.",
+}, []string{"limit"})
+
+// seriesLimiter tracks the postings touched and the chunks bytes fetched by a
+// Series request, across all its blocks, and fails the request once one of
+// the limits is exceeded; 0 disables a limit. The errors are limit errors
+// turned into HTTP 422 gRPC errors, so that the queriers don't query the other
+// replicas of the blocks again.
+type seriesLimiter struct {
+	maxPostings, maxChunkBytes int
+	postings, chunkBytes       int
+}
+
+func newSeriesLimiter(cfg Config) *seriesLimiter {
+	return &seriesLimiter{
+		maxPostings:   cfg.MaxTouchedPostingsPerQuery,
+		maxChunkBytes: cfg.MaxFetchedChunkBytesPerQuery,
+	}
+}
+
+// addPostings records postings touched by the request.
+func (l *seriesLimiter) addPostings(n int) error {
+	l.postings += n
+	if l.maxPostings > 0 && l.postings > l.maxPostings {
+		queriesLimited.WithLabelValues("postings").Inc()
+		return errstatus.HTTPGRPCError(util.LimitError(fmt.Sprintf(errMaxTouchedPostings, l.maxPostings)))
+	}
+	return nil
+}
+
+// addChunkBytes records chunks bytes fetched by the request.
+func (l *seriesLimiter) addChunkBytes(n int) error {
+	l.chunkBytes += n
+	if l.maxChunkBytes > 0 && l.chunkBytes > l.maxChunkBytes {
+		queriesLimited.WithLabelValues("chunk_bytes").Inc()
+		return errstatus.HTTPGRPCError(util.LimitError(fmt.Sprintf(errMaxFetchedChunkBytes, l.maxChunkBytes)))
+	}
+	return nil
+}
+
+// limitingIndexReader counts the postings looked up in the index of a block by
+// a Series request.
+type limitingIndexReader struct {
+	tsdb.IndexReader
+	limiter *seriesLimiter
+}
+
+func (r *limitingIndexReader) Postings(name, value string) (index.Postings, error) {
+	p, err := r.IndexReader.Postings(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return &limitingPostings{Postings: p, limiter: r.limiter}, nil
+}
+
+// limitingPostings counts the postings as they're iterated, so that a long
+// list is not read past the limit. A Seek not moving the iterator doesn't
+// count the current posting again.
+type limitingPostings struct {
+	index.Postings
+	limiter *seriesLimiter
+	started bool
+	err     error
+}
+
+func (p *limitingPostings) Next() bool {
+	if p.err != nil || !p.Postings.Next() {
+		return false
+	}
+	return p.add()
+}
+
+func (p *limitingPostings) Seek(v uint64) bool {
+	if p.err != nil {
+		return false
+	}
+	if p.started && p.Postings.At() >= v {
+		return true
+	}
+	if !p.Postings.Seek(v) {
+		return false
+	}
+	return p.add()
+}
+
+func (p *limitingPostings) add() bool {
+	p.started = true
+	p.err = p.limiter.addPostings(1)
+	return p.err == nil
+}
+
+func (p *limitingPostings) Err() error {
+	if p.err != nil {
+		return p.err
+	}
+	return p.Postings.Err()
+}
@@ -0,0 +1,134 @@
+package storegateway
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier/errstatus"
+	"github.com/cortexproject/cortex/pkg/storage/tsdb/testutil"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+)
+
+func TestStoreGatewaySeriesBatches(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	id := testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour)
+	cfg.SeriesBatchSize = 1
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+
+	ms, err := client.ToLabelMatchers([]*labels.Matcher{mustNewMatcher(labels.MatchEqual, "a", "1")})
+	require.NoError(t, err)
+	srv := &mockSeriesServer{ctx: user.InjectOrgID(context.Background(), "user")}
+	require.NoError(t, g.Series(&SeriesRequest{MinTime: 0, MaxTime: 2 * hour, Matchers: ms, BlockIds: []string{id.String()}}, srv))
+
+	// Each batch of series is sent on its own.
+	require.Len(t, srv.responses, 2)
+	for _, resp := range srv.responses {
+		require.Len(t, resp.Series, 1)
+	}
+
+	cfg.SeriesBatchSize = 0
+	_, err = newStoreGateway(cfg, bkt)
+	require.Error(t, err)
+}
+
+func TestStoreGatewaySeriesLimits(t *testing.T) {
+	cfg, bkt, dir := prepare(t)
+	defer os.RemoveAll(dir)
+
+	ids := []ulid.ULID{
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, 0, 2*hour),
+		testutil.UploadBlock(t, bkt, dir, "user", testSeries, 2*hour, 4*hour),
+	}
+	unlimited, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer unlimited.shutdown()
+	series, err := querySeries(t, unlimited, "user", ids[:1], 0, 4*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+	require.NoError(t, err)
+	chunkBytes := 0
+	for _, s := range series {
+		for _, c := range s.Chunks {
+			chunkBytes += len(c.Data)
+		}
+	}
+
+	requireLimited := func(err error) {
+		resp, ok := httpgrpc.HTTPResponseFromError(err)
+		require.True(t, ok, err)
+		require.Equal(t, int32(http.StatusUnprocessableEntity), resp.Code)
+	}
+
+	// The postings are counted across the matchers and the blocks.
+	cfg.MaxTouchedPostingsPerQuery = 4
+	g, err := newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	_, err = querySeries(t, g, "user", ids[:1], 0, 4*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"), mustNewMatcher(labels.MatchEqual, "a", "1"))
+	require.NoError(t, err)
+	_, err = querySeries(t, g, "user", ids, 0, 4*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"), mustNewMatcher(labels.MatchEqual, "a", "1"))
+	requireLimited(err)
+
+	cfg.MaxTouchedPostingsPerQuery = 0
+	cfg.MaxFetchedChunkBytesPerQuery = chunkBytes
+	g, err = newStoreGateway(cfg, bkt)
+	require.NoError(t, err)
+	defer g.shutdown()
+	_, err = querySeries(t, g, "user", ids[:1], 0, 4*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+	require.NoError(t, err)
+	_, err = querySeries(t, g, "user", ids, 0, 4*hour, mustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+	requireLimited(err)
+
+	// The queriers don't query the other replicas of the blocks once limited.
+	require.NoError(t, g.syncBlocks(context.Background()))
+	require.NoError(t, unlimited.syncBlocks(context.Background()))
+	limitedAddr, stopLimited := serveGateway(t, g)
+	defer stopLimited()
+	unlimitedAddr, stopUnlimited := serveGateway(t, unlimited)
+	defer stopUnlimited()
+
+	var storeCfg BlocksStoreConfig
+	flagext.DefaultValues(&storeCfg)
+	storeCfg.Addresses = []string{limitedAddr, unlimitedAddr}
+	s := newBlocksStore(storeCfg, bkt, nil)
+	defer func() {
+		for _, conn := range s.clients {
+			_ = conn.Close()
+		}
+	}()
+	_, err = s.Get(user.InjectOrgID(context.Background(), "user"), "user", 0, model.Time(4*hour), mustNewMatcher(labels.MatchEqual, "__name__", "foo"))
+	require.Error(t, err)
+	_, ok := err.(util.LimitError)
+	require.True(t, ok, err)
+	require.Equal(t, http.StatusUnprocessableEntity, errstatus.Code(err))
+}
+
+func TestLimitingPostings(t *testing.T) {
+	limiter := &seriesLimiter{maxPostings: 3}
+	p := &limitingPostings{Postings: index.NewListPostings([]uint64{1, 2, 3, 4, 5, 6}), limiter: limiter}
+
+	// Seeking to the current posting doesn't count it again.
+	require.True(t, p.Next())
+	require.True(t, p.Seek(1))
+	require.True(t, p.Seek(3))
+	require.Equal(t, uint64(3), p.At())
+	require.True(t, p.Next())
+	require.False(t, p.Next())
+	require.False(t, p.Next())
+	require.Error(t, p.Err())
+	require.Equal(t, 4, limiter.postings)
+}
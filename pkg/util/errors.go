@@ -0,0 +1,31 @@
+package util
+
+// StatusClientClosedRequest is the status code of the requests canceled by the
+// client, as used by nginx.
+const StatusClientClosedRequest = 499
+
+// LimitError is returned when a request hits a limit, e.g. the max number of
+// series fetched by a query.
+type LimitError string
+
+func (e LimitError) Error() string {
+	return string(e)
+}
+
+// ConsistencyError is returned when the data read from the storage are
+// inconsistent, e.g. some chunks found in the index can't be fetched.
+type ConsistencyError string
+
+func (e ConsistencyError) Error() string {
+	return string(e)
+}
+
+// UnavailableError wraps the errors returned when a storage, e.g. the ring of
+// the ingesters, can't serve a request.
+type UnavailableError struct {
+	Err error
+}
+
+func (e UnavailableError) Error() string {
+	return e.Err.Error()
+}
@@ -0,0 +1,32 @@
+package snappy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnappyRoundTrip(t *testing.T) {
+	c := newCompressor()
+	input := strings.Repeat("cortex chunks compress well ", 1000)
+
+	// Run twice so pooled writers and readers get reused.
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		w, err := c.Compress(&buf)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(input))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.True(t, buf.Len() < len(input))
+
+		r, err := c.Decompress(&buf)
+		require.NoError(t, err)
+		output, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, input, string(output))
+	}
+}
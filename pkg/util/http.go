@@ -105,10 +105,25 @@ func ParseProtoReader(ctx context.Context, reader io.Reader, req proto.Message,
 
 // SerializeProtoResponse serializes a protobuf response into an HTTP response.
 func SerializeProtoResponse(w http.ResponseWriter, resp proto.Message, compression CompressionType) error {
-	data, err := proto.Marshal(resp)
+	data, err := SerializeProto(resp, compression)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return fmt.Errorf("error marshaling proto response: %v", err)
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return fmt.Errorf("error sending proto response: %v", err)
+	}
+	return nil
+}
+
+// SerializeProto serializes and compresses a protobuf message, as read by
+// ParseProtoReader.
+func SerializeProto(msg proto.Message, compression CompressionType) ([]byte, error) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling proto response: %v", err)
 	}
 
 	switch compression {
@@ -116,16 +131,11 @@ func SerializeProtoResponse(w http.ResponseWriter, resp proto.Message, compressi
 	case FramedSnappy:
 		buf := bytes.Buffer{}
 		if _, err := snappy.NewWriter(&buf).Write(data); err != nil {
-			return err
+			return nil, err
 		}
 		data = buf.Bytes()
 	case RawSnappy:
 		data = snappy.Encode(nil, data)
 	}
-
-	if _, err := w.Write(data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return fmt.Errorf("error sending proto response: %v", err)
-	}
-	return nil
+	return data, nil
 }
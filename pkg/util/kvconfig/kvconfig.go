@@ -0,0 +1,13 @@
+// Package kvconfig holds the runtime config of the KV stores, shared by the
+// KV stores and the runtime overrides without them depending on each other.
+package kvconfig
+
+// MultiRuntimeConfig is the part of the runtime config overriding the config of
+// the multi KV stores, to migrate between their stores without restarting.
+type MultiRuntimeConfig struct {
+	// Primary store of the multi KV stores, empty to use the store of their flags.
+	PrimaryStore string `yaml:"primary"`
+
+	// Whether to mirror the writes to the secondary store, nil to use the flag.
+	Mirroring *bool `yaml:"mirror_enabled"`
+}
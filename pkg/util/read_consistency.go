@@ -0,0 +1,73 @@
+package util
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/weaveworks/common/httpgrpc"
+)
+
+// ReadConsistencyHeader is the header of the queries setting their read
+// consistency, overriding the read_consistency limit of the tenant. It is
+// forwarded by the query frontend to the queriers.
+const ReadConsistencyHeader = "X-Cortex-Read-Consistency"
+
+// The read consistency levels: the strong queries bypass the caches of the
+// query frontend, and query all the ingesters of the replication set,
+// whatever -querier.query-ingesters-within, failing if any of them fails; the
+// eventual queries are served with whatever is available, the fastest.
+const (
+	ReadConsistencyStrong   = "strong"
+	ReadConsistencyEventual = "eventual"
+)
+
+type readConsistencyKey int
+
+// ValidateReadConsistency checks the read consistency level of a query; empty
+// for the default of the tenant.
+func ValidateReadConsistency(level string) error {
+	switch level {
+	case "", ReadConsistencyStrong, ReadConsistencyEventual:
+		return nil
+	}
+	return httpgrpc.Errorf(http.StatusBadRequest, "invalid %s %q, expected %s or %s", ReadConsistencyHeader, level, ReadConsistencyStrong, ReadConsistencyEventual)
+}
+
+// TenantReadConsistency returns the default read consistency of the queries
+// of the org ID, from the read_consistency limit of its tenants: a federated
+// query is of strong consistency if any of its tenants is.
+func TenantReadConsistency(orgID string, limit func(userID string) string) string {
+	for _, userID := range TenantIDs(orgID) {
+		if limit(userID) == ReadConsistencyStrong {
+			return ReadConsistencyStrong
+		}
+	}
+	return ReadConsistencyEventual
+}
+
+// WithReadConsistency returns a context holding the read consistency level of
+// a query.
+func WithReadConsistency(ctx context.Context, level string) context.Context {
+	return context.WithValue(ctx, readConsistencyKey(0), level)
+}
+
+// ReadConsistencyFromContext returns the read consistency level of a query;
+// eventual if none.
+func ReadConsistencyFromContext(ctx context.Context) string {
+	if level, ok := ReadConsistencyLevelFromContext(ctx); ok {
+		return level
+	}
+	return ReadConsistencyEventual
+}
+
+// ReadConsistencyLevelFromContext returns the read consistency level set for
+// a query, if any.
+func ReadConsistencyLevelFromContext(ctx context.Context) (string, bool) {
+	level, ok := ctx.Value(readConsistencyKey(0)).(string)
+	return level, ok && level != ""
+}
+
+// IsStrongReadConsistency returns whether a query is of strong consistency.
+func IsStrongReadConsistency(ctx context.Context) bool {
+	return ReadConsistencyFromContext(ctx) == ReadConsistencyStrong
+}
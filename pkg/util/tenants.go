@@ -0,0 +1,61 @@
+package util
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// TenantSeparator separates the tenants of a federated query in the
+// X-Scope-OrgID header, e.g. "a|b|c".
+const TenantSeparator = "|"
+
+// TenantIDs returns the sorted, deduplicated tenants of an org ID, several
+// for a federated query. An empty tenant, e.g. of "a||b", comes first.
+func TenantIDs(orgID string) []string {
+	tenants := strings.Split(orgID, TenantSeparator)
+	if len(tenants) == 1 {
+		return tenants
+	}
+
+	sort.Strings(tenants)
+	deduped := tenants[:1]
+	for _, tenant := range tenants[1:] {
+		if tenant != deduped[len(deduped)-1] {
+			deduped = append(deduped, tenant)
+		}
+	}
+	return deduped
+}
+
+// NormalizeOrgID returns the org ID of the sorted, deduplicated tenants of an
+// org ID, so that the federated queries of the same tenants are treated alike
+// whatever the order of their tenants.
+func NormalizeOrgID(orgID string) string {
+	return strings.Join(TenantIDs(orgID), TenantSeparator)
+}
+
+// SmallestPositiveIntPerTenant returns the smallest positive limit of the
+// tenants of an org ID, 0 if none of them is limited. The strictest limit of
+// the tenants applies to their federated queries.
+func SmallestPositiveIntPerTenant(orgID string, limit func(userID string) int) int {
+	result := 0
+	for _, userID := range TenantIDs(orgID) {
+		if l := limit(userID); l > 0 && (result == 0 || l < result) {
+			result = l
+		}
+	}
+	return result
+}
+
+// SmallestPositiveDurationPerTenant is SmallestPositiveIntPerTenant for the
+// limits which are durations.
+func SmallestPositiveDurationPerTenant(orgID string, limit func(userID string) time.Duration) time.Duration {
+	var result time.Duration
+	for _, userID := range TenantIDs(orgID) {
+		if l := limit(userID); l > 0 && (result == 0 || l < result) {
+			result = l
+		}
+	}
+	return result
+}
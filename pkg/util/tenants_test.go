@@ -0,0 +1,25 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantIDs(t *testing.T) {
+	assert.Equal(t, []string{"a"}, TenantIDs("a"))
+	assert.Equal(t, []string{"a", "b", "c"}, TenantIDs("c|a|b|a"))
+	assert.Equal(t, []string{"", "a", "b"}, TenantIDs("a||b"))
+	assert.Equal(t, "a|b", NormalizeOrgID("b|a|b"))
+}
+
+func TestSmallestPositivePerTenant(t *testing.T) {
+	ints := map[string]int{"a": 3, "b": 2, "c": 0}
+	assert.Equal(t, 2, SmallestPositiveIntPerTenant("a|b|c", func(userID string) int { return ints[userID] }))
+	assert.Equal(t, 3, SmallestPositiveIntPerTenant("c|a", func(userID string) int { return ints[userID] }))
+	assert.Equal(t, 0, SmallestPositiveIntPerTenant("c", func(userID string) int { return ints[userID] }))
+
+	durations := map[string]time.Duration{"a": time.Hour, "b": time.Minute}
+	assert.Equal(t, time.Minute, SmallestPositiveDurationPerTenant("a|b|c", func(userID string) time.Duration { return durations[userID] }))
+}
@@ -2,10 +2,19 @@ package validation
 
 import (
 	"flag"
+	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"sync"
 	"time"
 
+	"github.com/prometheus/prometheus/promql"
 	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/kvconfig"
 )
 
 // Limits describe all the limits for users; can be used to describe global default
@@ -24,25 +33,154 @@ type Limits struct {
 	RejectOldSamplesMaxAge time.Duration `yaml:"reject_old_samples_max_age"`
 	CreationGracePeriod    time.Duration `yaml:"creation_grace_period"`
 	EnforceMetricName      bool          `yaml:"enforce_metric_name"`
+	LimitsWarningThreshold float64       `yaml:"limits_warning_threshold"`
 
 	// Ingester enforced limits.
-	MaxSeriesPerQuery  int `yaml:"max_series_per_query"`
-	MaxSamplesPerQuery int `yaml:"max_samples_per_query"`
-	MaxSeriesPerUser   int `yaml:"max_series_per_user"`
-	MaxSeriesPerMetric int `yaml:"max_series_per_metric"`
-	MinChunkLength     int `yaml:"min_chunk_length"`
+	MaxSeriesPerQuery        int `yaml:"max_series_per_query"`
+	MaxSamplesPerQuery       int `yaml:"max_samples_per_query"`
+	MaxLocalSeriesPerUser    int `yaml:"max_series_per_user"`
+	MaxLocalSeriesPerMetric  int `yaml:"max_series_per_metric"`
+	MaxGlobalSeriesPerUser   int `yaml:"max_global_series_per_user"`
+	MaxGlobalSeriesPerMetric int `yaml:"max_global_series_per_metric"`
+	MinChunkLength           int `yaml:"min_chunk_length"`
+
+	// Ingester chunk settings, overriding the ingester-wide
+	// -ingester.chunk-encoding and -ingester.max-chunk-age when set.
+	ChunkEncoding string        `yaml:"chunk_encoding"`
+	MaxChunkAge   time.Duration `yaml:"max_chunk_age"`
+
+	// Series matching any of these selectors are ephemeral: they are only kept
+	// in ingester memory, for EphemeralSeriesRetention, and never flushed.
+	EphemeralSeriesSelectors []string      `yaml:"ephemeral_series_selectors"`
+	EphemeralSeriesRetention time.Duration `yaml:"ephemeral_series_retention"`
 
 	// Querier enforced limits.
 	MaxChunksPerQuery   int           `yaml:"max_chunks_per_query"`
 	MaxQueryLength      time.Duration `yaml:"max_query_length"`
+	MaxQueryLookback    time.Duration `yaml:"max_query_lookback"`
 	MaxQueryParallelism int           `yaml:"max_query_parallelism"`
 	CardinalityLimit    int           `yaml:"cardinality_limit"`
+	ResultsCacheTTL     time.Duration `yaml:"results_cache_ttl"`
+	QueryWeight         int           `yaml:"query_weight"`
+	MaxOutstanding      int           `yaml:"max_outstanding_requests_per_tenant"`
+	MaxResponseSize     int           `yaml:"max_query_response_size"`
+	AutoStepMaxPoints   int           `yaml:"auto_step_max_points"`
+
+	// Overrides of -querier.timeout and -querier.max-samples; 0 to use them.
+	QueryEngineTimeout    time.Duration `yaml:"query_engine_timeout"`
+	QueryEngineMaxSamples int           `yaml:"query_engine_max_samples"`
+
+	// Limits on the data fetched by a single query, enforced by the querier.
+	MaxFetchedChunksPerQuery     int `yaml:"max_fetched_chunks_per_query"`
+	MaxFetchedSeriesPerQuery     int `yaml:"max_fetched_series_per_query"`
+	MaxFetchedChunkBytesPerQuery int `yaml:"max_fetched_chunk_bytes_per_query"`
+
+	// Tenants whose data can be queried together with this tenant's in a
+	// federated query; "*" allows any tenant.
+	FederationAllowedTenants []string `yaml:"federation_allowed_tenants"`
+
+	// Prometheus remote read endpoints queried together with the ingesters
+	// and the store, e.g. while migrating from them.
+	RemoteReadURLs []string `yaml:"remote_read_urls"`
+
+	// Whether queries succeed, with warnings, when a minority of the
+	// ingesters fail.
+	QueryPartialResults bool `yaml:"query_partial_results"`
+
+	// The read consistency of the queries without the read consistency
+	// header: strong or eventual.
+	ReadConsistency string `yaml:"read_consistency"`
+
+	// Queries rejected by the queriers.
+	BlockedQueries []BlockedQuery `yaml:"blocked_queries"`
+
+	// Server-side encryption of the chunks written to S3, overriding
+	// -s3.sse.type and -s3.sse.kms-key-id when set.
+	S3SSEType     string `yaml:"s3_sse_type"`
+	S3SSEKMSKeyID string `yaml:"s3_sse_kms_key_id"`
+
+	// The bucket of the chunks of the tenant in S3 or GCS, overriding the
+	// buckets of their config when set.
+	ObjectStoreBucketName string `yaml:"object_store_bucket_name"`
+
+	// The master key of -encryption.kms encrypting the data keys of the
+	// chunks of the tenant; the chunks aren't encrypted if unset.
+	ChunkEncryptionKeyID string `yaml:"chunk_encryption_key_id"`
+
+	// How long the chunks of the tenant are kept before the table manager
+	// deletes them, overriding -table-manager.retention-period when set.
+	RetentionPeriod time.Duration `yaml:"retention_period"`
+
+	// How long the blocks of the tenant are kept before the compactor deletes
+	// them, 0 to keep them forever.
+	CompactorBlocksRetentionPeriod time.Duration `yaml:"compactor_blocks_retention_period"`
+
+	// Whether the tenant can upload blocks through the compactor, and the
+	// maximum sizes of the files and of the blocks it uploads.
+	CompactorBlockUploadEnabled      bool  `yaml:"compactor_block_upload_enabled"`
+	CompactorBlockUploadMaxFileSize  int64 `yaml:"compactor_block_upload_max_file_size"`
+	CompactorBlockUploadMaxBlockSize int64 `yaml:"compactor_block_upload_max_block_size"`
+
+	// The time ranges of the compacted blocks of the tenant, overriding
+	// -compactor.block-ranges when set, and the number of its compaction
+	// jobs run concurrently.
+	CompactorBlockRanges                 []time.Duration `yaml:"compactor_block_ranges"`
+	CompactorTenantCompactionConcurrency int             `yaml:"compactor_tenant_compaction_concurrency"`
 
 	// Config for overrides, convenient if it goes here.
 	PerTenantOverrideConfig string        `yaml:"per_tenant_override_config"`
 	PerTenantOverridePeriod time.Duration `yaml:"per_tenant_override_period"`
 }
 
+// BlockedQuery is a PromQL query the queriers reject, e.g. to stop a query
+// overloading the cluster while the tenant fixes it.
+type BlockedQuery struct {
+	// Pattern is the query blocked, or a regular expression matching the
+	// queries blocked, anchored at both ends, if Regex is set.
+	Pattern string `yaml:"pattern"`
+	Regex   bool   `yaml:"regex"`
+
+	// Reason is returned in the error of the blocked queries.
+	Reason string `yaml:"reason"`
+
+	// Until optionally ends the blocking; zero blocks the queries forever.
+	Until time.Time `yaml:"until"`
+
+	// The regular expression of Pattern, compiled when the limits are loaded.
+	re *regexp.Regexp
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, compiling the pattern of a regex
+// blocked query once, rather than for each query checked.
+func (q *BlockedQuery) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain BlockedQuery
+	if err := unmarshal((*plain)(q)); err != nil {
+		return err
+	}
+	if !q.Regex {
+		return nil
+	}
+	re, err := q.compile()
+	if err != nil {
+		return fmt.Errorf("invalid blocked query pattern %q: %v", q.Pattern, err)
+	}
+	q.re = re
+	return nil
+}
+
+// Regexp returns the regular expression of a regex blocked query, anchored at
+// both ends.
+func (q BlockedQuery) Regexp() (*regexp.Regexp, error) {
+	if q.re != nil {
+		return q.re, nil
+	}
+	return q.compile()
+}
+
+func (q BlockedQuery) compile() (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + q.Pattern + ")$")
+}
+
 // RegisterFlags adds the flags required to config this to the given FlagSet
 func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.Float64Var(&l.IngestionRate, "distributor.ingestion-rate-limit", 25000, "Per-user ingestion rate limit in samples per second.")
@@ -57,22 +195,69 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.DurationVar(&l.RejectOldSamplesMaxAge, "validation.reject-old-samples.max-age", 14*24*time.Hour, "Maximum accepted sample age before rejecting.")
 	f.DurationVar(&l.CreationGracePeriod, "validation.create-grace-period", 10*time.Minute, "Duration which table will be created/deleted before/after it's needed; we won't accept sample from before this time.")
 	f.BoolVar(&l.EnforceMetricName, "validation.enforce-metric-name", true, "Enforce every sample has a metric name.")
+	f.Float64Var(&l.LimitsWarningThreshold, "validation.limits-warning-threshold", 0, "Fraction of the per-user series and ingestion rate limits above which pushes still succeed, but with a warning. 0 to disable.")
 
 	f.IntVar(&l.MaxSeriesPerQuery, "ingester.max-series-per-query", 100000, "The maximum number of series that a query can return.")
 	f.IntVar(&l.MaxSamplesPerQuery, "ingester.max-samples-per-query", 1000000, "The maximum number of samples that a query can return.")
-	f.IntVar(&l.MaxSeriesPerUser, "ingester.max-series-per-user", 5000000, "Maximum number of active series per user.")
-	f.IntVar(&l.MaxSeriesPerMetric, "ingester.max-series-per-metric", 50000, "Maximum number of active series per metric name.")
+	f.IntVar(&l.MaxLocalSeriesPerUser, "ingester.max-series-per-user", 5000000, "Maximum number of active series per user, per ingester. 0 to disable.")
+	f.IntVar(&l.MaxLocalSeriesPerMetric, "ingester.max-series-per-metric", 50000, "Maximum number of active series per metric name, per ingester. 0 to disable.")
+	f.IntVar(&l.MaxGlobalSeriesPerUser, "ingester.max-global-series-per-user", 0, "Maximum number of active series per user, across the cluster. 0 to disable. Supported only if -distributor.shard-by-all-labels is true.")
+	f.IntVar(&l.MaxGlobalSeriesPerMetric, "ingester.max-global-series-per-metric", 0, "Maximum number of active series per metric name, across the cluster. 0 to disable.")
 	f.IntVar(&l.MinChunkLength, "ingester.min-chunk-length", 0, "Minimum number of samples in an idle chunk to flush it to the store. Use with care, if chunks are less than this size they will be discarded.")
+	f.DurationVar(&l.EphemeralSeriesRetention, "ingester.ephemeral-series-retention", 10*time.Minute, "How long the chunks of ephemeral series are kept in memory after their last update. Ephemeral series are never flushed to the store.")
 
 	f.IntVar(&l.MaxChunksPerQuery, "store.query-chunk-limit", 2e6, "Maximum number of chunks that can be fetched in a single query.")
-	f.DurationVar(&l.MaxQueryLength, "store.max-query-length", 0, "Limit to length of chunk store queries, 0 to disable.")
+	f.DurationVar(&l.MaxQueryLength, "store.max-query-length", 0, "Limit to length of chunk store queries, also enforced by the queriers and the query frontend. 0 to disable.")
+	f.DurationVar(&l.MaxQueryLookback, "querier.max-query-lookback", 0, "Limit how far back in time data can be queried, e.g. to the retention period: the part of the queries beyond it is silently dropped. 0 to disable.")
 	f.IntVar(&l.MaxQueryParallelism, "querier.max-query-parallelism", 14, "Maximum number of queries will be scheduled in parallel by the frontend.")
 	f.IntVar(&l.CardinalityLimit, "store.cardinality-limit", 1e5, "Cardinality limit for index queries.")
+	f.DurationVar(&l.ResultsCacheTTL, "frontend.results-cache-ttl", 0, "How long the query frontend serves the query results cached for a tenant before querying them again, e.g. when old data may be backfilled. 0 to keep them until evicted.")
+	f.IntVar(&l.QueryWeight, "frontend.query-weight", 1, "Weight of the tenant in the queue of the query frontend and query-scheduler: a tenant with a weight of 2 gets twice as many of its queued requests processed as a tenant with a weight of 1.")
+	f.IntVar(&l.MaxOutstanding, "frontend.max-outstanding-requests-per-tenant", 0, "Maximum number of queued requests of the tenant in each query frontend or query-scheduler; requests beyond this error with HTTP 429. 0 to use -querier.max-outstanding-requests-per-tenant, or -query-scheduler.max-outstanding-requests-per-tenant.")
+	f.IntVar(&l.MaxResponseSize, "frontend.max-query-response-size", 0, "Maximum size, in bytes, of the response of a query returned by the query frontend; larger responses are failed with HTTP 422 by the queriers as they write them, or by the query frontend as it reads them from a downstream Prometheus. 0 to disable.")
+	f.IntVar(&l.AutoStepMaxPoints, "frontend.auto-step-max-points", 0, "Raise the step of the range queries returning more than this number of points per series, up to 11,000, so that they return at most this number of points rather than failing. 0 to disable.")
+	f.IntVar(&l.MaxFetchedChunksPerQuery, "querier.max-fetched-chunks-per-query", 0, "Maximum number of chunks a single query can fetch from the ingesters and the store. 0 to disable.")
+	f.IntVar(&l.MaxFetchedSeriesPerQuery, "querier.max-fetched-series-per-query", 0, "Maximum number of unique series a single query can fetch from the ingesters and the store. 0 to disable.")
+	f.IntVar(&l.MaxFetchedChunkBytesPerQuery, "querier.max-fetched-chunk-bytes-per-query", 0, "Maximum size, in bytes, of the chunk data a single query can fetch from the ingesters and the store. 0 to disable.")
+	f.BoolVar(&l.QueryPartialResults, "querier.partial-results", false, "Return partial results, with a warning naming the failed ingesters, rather than failing queries when some of the series may be missing because a minority of the ingesters failed.")
+	f.StringVar(&l.ReadConsistency, "querier.read-consistency", util.ReadConsistencyEventual, "Read consistency of the queries without the "+util.ReadConsistencyHeader+" header: strong, to bypass the caches of the query frontend and wait for all the ingesters, whatever -querier.query-ingesters-within, or eventual, to serve whatever is available the fastest.")
+
+	f.DurationVar(&l.CompactorBlocksRetentionPeriod, "compactor.blocks-retention-period", 0, "How long the compactor keeps the blocks of a tenant, from their end, before it deletes them. 0 to keep them forever.")
+	f.BoolVar(&l.CompactorBlockUploadEnabled, "compactor.block-upload-enabled", false, "Allow the tenants to upload blocks built outside of Cortex, to backfill their series, through the block upload API of the compactor.")
+	f.Int64Var(&l.CompactorBlockUploadMaxFileSize, "compactor.block-upload-max-file-size", 4<<30, "Maximum size, in bytes, of a file of a block uploaded through the block upload API. 0 to disable.")
+	f.Int64Var(&l.CompactorBlockUploadMaxBlockSize, "compactor.block-upload-max-block-size", 64<<30, "Maximum size, in bytes, of the files of a block uploaded through the block upload API, all together. 0 to disable.")
+	f.IntVar(&l.CompactorTenantCompactionConcurrency, "compactor.tenant-compaction-concurrency", 1, "Number of compaction jobs of a tenant run concurrently: its groups of blocks in distinct ranges of its largest block range compacted, or with the split-and-merge compaction strategy, its blocks split and its shards compacted.")
 
 	f.StringVar(&l.PerTenantOverrideConfig, "limits.per-user-override-config", "", "File name of per-user overrides.")
 	f.DurationVar(&l.PerTenantOverridePeriod, "limits.per-user-override-period", 10*time.Second, "Period with this to reload the overrides.")
 }
 
+// Validate validates the default limits.
+func (l *Limits) Validate() error {
+	switch l.ReadConsistency {
+	case util.ReadConsistencyStrong, util.ReadConsistencyEventual:
+	default:
+		return fmt.Errorf("invalid read consistency %q, expected %s or %s", l.ReadConsistency, util.ReadConsistencyStrong, util.ReadConsistencyEventual)
+	}
+	return ValidateS3SSEType(l.S3SSEType)
+}
+
+// The server-side encryption types of the chunks written to S3.
+const (
+	S3SSES3  = "SSE-S3"
+	S3SSEKMS = "SSE-KMS"
+)
+
+// ValidateS3SSEType checks a server-side encryption type of the chunks written
+// to S3; empty for the default encryption of the bucket.
+func ValidateS3SSEType(sseType string) error {
+	switch sseType {
+	case "", S3SSES3, S3SSEKMS:
+		return nil
+	}
+	return fmt.Errorf("invalid S3 server-side encryption type %q, expected %s or %s", sseType, S3SSES3, S3SSEKMS)
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (l *Limits) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// We want to set c to the defaults and then overwrite it with the input.
@@ -97,6 +282,12 @@ var defaultLimits *Limits
 // functions for fetching the correct value.
 type Overrides struct {
 	overridesManager *OverridesManager
+
+	// The multi KV config of the overrides file, sent to the listeners
+	// whenever it changes.
+	multiKVMtx       sync.Mutex
+	multiKVConfig    kvconfig.MultiRuntimeConfig
+	multiKVListeners []chan kvconfig.MultiRuntimeConfig
 }
 
 // NewOverrides makes a new Overrides.
@@ -105,10 +296,11 @@ type Overrides struct {
 // become the new global defaults.
 func NewOverrides(defaults Limits) (*Overrides, error) {
 	defaultLimits = &defaults
+	o := &Overrides{}
 	overridesManagerConfig := OverridesManagerConfig{
 		OverridesReloadPeriod: defaults.PerTenantOverridePeriod,
 		OverridesLoadPath:     defaults.PerTenantOverrideConfig,
-		OverridesLoader:       loadOverrides,
+		OverridesLoader:       o.loadOverrides,
 		Defaults:              &defaults,
 	}
 
@@ -116,15 +308,69 @@ func NewOverrides(defaults Limits) (*Overrides, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	return &Overrides{
-		overridesManager: overridesManager,
-	}, nil
+	o.overridesManager = overridesManager
+	return o, nil
 }
 
 // Stop background reloading of overrides.
 func (o *Overrides) Stop() {
 	o.overridesManager.Stop()
+
+	o.multiKVMtx.Lock()
+	defer o.multiKVMtx.Unlock()
+	for _, ch := range o.multiKVListeners {
+		close(ch)
+	}
+	o.multiKVListeners = nil
+}
+
+// MultiKVConfigs returns a channel of the multi KV config of the overrides
+// file, which is sent the current config, then each change of it.
+func (o *Overrides) MultiKVConfigs() <-chan kvconfig.MultiRuntimeConfig {
+	o.multiKVMtx.Lock()
+	defer o.multiKVMtx.Unlock()
+
+	ch := make(chan kvconfig.MultiRuntimeConfig, 1)
+	ch <- o.multiKVConfig
+	o.multiKVListeners = append(o.multiKVListeners, ch)
+	return ch
+}
+
+func (o *Overrides) loadOverrides(filename string) (map[string]interface{}, error) {
+	overrides, multiKVConfig, err := loadOverrides(filename)
+	if err != nil {
+		return nil, err
+	}
+	if multiKVConfig == nil {
+		multiKVConfig = &kvconfig.MultiRuntimeConfig{}
+	}
+	o.setMultiKVConfig(*multiKVConfig)
+	return overrides, nil
+}
+
+func (o *Overrides) setMultiKVConfig(cfg kvconfig.MultiRuntimeConfig) {
+	o.multiKVMtx.Lock()
+	defer o.multiKVMtx.Unlock()
+
+	if multiKVConfigEqual(o.multiKVConfig, cfg) {
+		return
+	}
+	o.multiKVConfig = cfg
+	for _, ch := range o.multiKVListeners {
+		// Only the latest config matters to the listeners.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- cfg
+	}
+}
+
+func multiKVConfigEqual(a, b kvconfig.MultiRuntimeConfig) bool {
+	if a.PrimaryStore != b.PrimaryStore || (a.Mirroring == nil) != (b.Mirroring == nil) {
+		return false
+	}
+	return a.Mirroring == nil || *a.Mirroring == *b.Mirroring
 }
 
 // IngestionRate returns the limit on ingester rate (samples per second).
@@ -185,6 +431,12 @@ func (o *Overrides) CreationGracePeriod(userID string) time.Duration {
 	return o.overridesManager.GetLimits(userID).(*Limits).CreationGracePeriod
 }
 
+// LimitsWarningThreshold returns the fraction of the series and ingestion rate
+// limits of a user above which pushes succeed with a warning, or 0 if disabled.
+func (o *Overrides) LimitsWarningThreshold(userID string) float64 {
+	return o.overridesManager.GetLimits(userID).(*Limits).LimitsWarningThreshold
+}
+
 // MaxSeriesPerQuery returns the maximum number of series a query is allowed to hit.
 func (o *Overrides) MaxSeriesPerQuery(userID string) int {
 	return o.overridesManager.GetLimits(userID).(*Limits).MaxSeriesPerQuery
@@ -195,14 +447,161 @@ func (o *Overrides) MaxSamplesPerQuery(userID string) int {
 	return o.overridesManager.GetLimits(userID).(*Limits).MaxSamplesPerQuery
 }
 
-// MaxSeriesPerUser returns the maximum number of series a user is allowed to store.
-func (o *Overrides) MaxSeriesPerUser(userID string) int {
-	return o.overridesManager.GetLimits(userID).(*Limits).MaxSeriesPerUser
+// ChunkEncoding returns the encoding of the chunks created for a user, or an
+// empty string to use the ingester's default.
+func (o *Overrides) ChunkEncoding(userID string) string {
+	return o.overridesManager.GetLimits(userID).(*Limits).ChunkEncoding
+}
+
+// S3SSEType returns the server-side encryption of the chunks of a user written
+// to S3, SSE-S3 or SSE-KMS, or an empty string to use -s3.sse.type.
+func (o *Overrides) S3SSEType(userID string) string {
+	return o.overridesManager.GetLimits(userID).(*Limits).S3SSEType
+}
+
+// S3SSEKMSKeyID returns the ID of the KMS key encrypting the chunks of a user
+// with SSE-KMS, or an empty string for the AWS managed key.
+func (o *Overrides) S3SSEKMSKeyID(userID string) string {
+	return o.overridesManager.GetLimits(userID).(*Limits).S3SSEKMSKeyID
+}
+
+// ObjectStoreBucketName returns the bucket of the chunks of a user in S3 or
+// GCS, empty for them to be in the buckets of the config.
+func (o *Overrides) ObjectStoreBucketName(userID string) string {
+	return o.overridesManager.GetLimits(userID).(*Limits).ObjectStoreBucketName
+}
+
+// ChunkEncryptionKeyID returns the ID of the master key encrypting the data
+// keys of the chunks of a user, empty for their chunks not to be encrypted.
+func (o *Overrides) ChunkEncryptionKeyID(userID string) string {
+	return o.overridesManager.GetLimits(userID).(*Limits).ChunkEncryptionKeyID
+}
+
+// RetentionPeriod returns how long the chunks of a user are kept before they
+// are deleted, or 0 to use the table manager's retention period.
+func (o *Overrides) RetentionPeriod(userID string) time.Duration {
+	return o.overridesManager.GetLimits(userID).(*Limits).RetentionPeriod
 }
 
-// MaxSeriesPerMetric returns the maximum number of series allowed per metric.
-func (o *Overrides) MaxSeriesPerMetric(userID string) int {
-	return o.overridesManager.GetLimits(userID).(*Limits).MaxSeriesPerMetric
+// MaxChunkAge returns the maximum age of the chunks of a user before they are
+// flushed, or 0 to use the ingester's default.
+func (o *Overrides) MaxChunkAge(userID string) time.Duration {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxChunkAge
+}
+
+// EphemeralSeriesSelectors returns the selectors of the series of a user which
+// are kept in memory only.
+func (o *Overrides) EphemeralSeriesSelectors(userID string) []string {
+	return o.overridesManager.GetLimits(userID).(*Limits).EphemeralSeriesSelectors
+}
+
+// EphemeralSeriesRetention returns how long the chunks of the ephemeral series
+// of a user are kept in memory.
+func (o *Overrides) EphemeralSeriesRetention(userID string) time.Duration {
+	return o.overridesManager.GetLimits(userID).(*Limits).EphemeralSeriesRetention
+}
+
+// MaxFetchedChunksPerQuery returns the maximum number of chunks a single query
+// of a user can fetch.
+func (o *Overrides) MaxFetchedChunksPerQuery(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxFetchedChunksPerQuery
+}
+
+// MaxFetchedSeriesPerQuery returns the maximum number of series a single query
+// of a user can fetch.
+func (o *Overrides) MaxFetchedSeriesPerQuery(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxFetchedSeriesPerQuery
+}
+
+// CompactorBlocksRetentionPeriod returns how long the blocks of a user are kept
+// before the compactor deletes them, or 0 to keep them forever.
+func (o *Overrides) CompactorBlocksRetentionPeriod(userID string) time.Duration {
+	return o.overridesManager.GetLimits(userID).(*Limits).CompactorBlocksRetentionPeriod
+}
+
+// CompactorBlockUploadEnabled returns whether a user can upload blocks through
+// the compactor.
+func (o *Overrides) CompactorBlockUploadEnabled(userID string) bool {
+	return o.overridesManager.GetLimits(userID).(*Limits).CompactorBlockUploadEnabled
+}
+
+// CompactorBlockUploadMaxFileSize returns the maximum size of a file of the
+// blocks uploaded by a user, or 0 if unlimited.
+func (o *Overrides) CompactorBlockUploadMaxFileSize(userID string) int64 {
+	return o.overridesManager.GetLimits(userID).(*Limits).CompactorBlockUploadMaxFileSize
+}
+
+// CompactorBlockUploadMaxBlockSize returns the maximum size of the blocks
+// uploaded by a user, or 0 if unlimited.
+func (o *Overrides) CompactorBlockUploadMaxBlockSize(userID string) int64 {
+	return o.overridesManager.GetLimits(userID).(*Limits).CompactorBlockUploadMaxBlockSize
+}
+
+// CompactorBlockRanges returns the time ranges of the compacted blocks of a
+// user, or nil to use the compactor ones.
+func (o *Overrides) CompactorBlockRanges(userID string) []time.Duration {
+	return o.overridesManager.GetLimits(userID).(*Limits).CompactorBlockRanges
+}
+
+// CompactorTenantCompactionConcurrency returns the number of compaction jobs
+// of a user run concurrently.
+func (o *Overrides) CompactorTenantCompactionConcurrency(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).CompactorTenantCompactionConcurrency
+}
+
+// MaxFetchedChunkBytesPerQuery returns the maximum size of the chunk data a
+// single query of a user can fetch.
+func (o *Overrides) MaxFetchedChunkBytesPerQuery(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxFetchedChunkBytesPerQuery
+}
+
+// FederationAllowedTenants returns the tenants whose data can be queried
+// together with the data of a user.
+func (o *Overrides) FederationAllowedTenants(userID string) []string {
+	return o.overridesManager.GetLimits(userID).(*Limits).FederationAllowedTenants
+}
+
+// RemoteReadURLs returns the remote read endpoints queried for the data of a
+// user.
+func (o *Overrides) RemoteReadURLs(userID string) []string {
+	return o.overridesManager.GetLimits(userID).(*Limits).RemoteReadURLs
+}
+
+// QueryPartialResults returns whether the queries of a user succeed, with
+// warnings, when a minority of the ingesters fail.
+func (o *Overrides) QueryPartialResults(userID string) bool {
+	return o.overridesManager.GetLimits(userID).(*Limits).QueryPartialResults
+}
+
+// ReadConsistency returns the read consistency of the queries of a user
+// without the read consistency header.
+func (o *Overrides) ReadConsistency(userID string) string {
+	return o.overridesManager.GetLimits(userID).(*Limits).ReadConsistency
+}
+
+// BlockedQueries returns the queries of a user rejected by the queriers.
+func (o *Overrides) BlockedQueries(userID string) []BlockedQuery {
+	return o.overridesManager.GetLimits(userID).(*Limits).BlockedQueries
+}
+
+// MaxLocalSeriesPerUser returns the maximum number of series a user is allowed to store in a single ingester.
+func (o *Overrides) MaxLocalSeriesPerUser(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxLocalSeriesPerUser
+}
+
+// MaxLocalSeriesPerMetric returns the maximum number of series allowed per metric in a single ingester.
+func (o *Overrides) MaxLocalSeriesPerMetric(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxLocalSeriesPerMetric
+}
+
+// MaxGlobalSeriesPerUser returns the maximum number of series a user is allowed to store across the cluster.
+func (o *Overrides) MaxGlobalSeriesPerUser(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxGlobalSeriesPerUser
+}
+
+// MaxGlobalSeriesPerMetric returns the maximum number of series allowed per metric across the cluster.
+func (o *Overrides) MaxGlobalSeriesPerMetric(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxGlobalSeriesPerMetric
 }
 
 // MaxChunksPerQuery returns the maximum number of chunks allowed per query.
@@ -215,12 +614,60 @@ func (o *Overrides) MaxQueryLength(userID string) time.Duration {
 	return o.overridesManager.GetLimits(userID).(*Limits).MaxQueryLength
 }
 
+// MaxQueryLookback returns how far back in time the data of a user can be
+// queried.
+func (o *Overrides) MaxQueryLookback(userID string) time.Duration {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxQueryLookback
+}
+
+// QueryEngineTimeout returns the timeout of the queries of a user; 0 to use
+// the timeout of the querier.
+func (o *Overrides) QueryEngineTimeout(userID string) time.Duration {
+	return o.overridesManager.GetLimits(userID).(*Limits).QueryEngineTimeout
+}
+
+// QueryEngineMaxSamples returns the maximum number of samples a query of a
+// user can load into memory; 0 to use the limit of the querier.
+func (o *Overrides) QueryEngineMaxSamples(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).QueryEngineMaxSamples
+}
+
 // MaxQueryParallelism returns the limit to the number of sub-queries the
 // frontend will process in parallel.
 func (o *Overrides) MaxQueryParallelism(userID string) int {
 	return o.overridesManager.GetLimits(userID).(*Limits).MaxQueryParallelism
 }
 
+// ResultsCacheTTL returns how long the query results of a user are served from
+// the results cache; 0 for as long as they are cached.
+func (o *Overrides) ResultsCacheTTL(userID string) time.Duration {
+	return o.overridesManager.GetLimits(userID).(*Limits).ResultsCacheTTL
+}
+
+// QueryWeight returns the weight of a user when picking the next request of the
+// queue of the frontend between the users.
+func (o *Overrides) QueryWeight(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).QueryWeight
+}
+
+// MaxOutstandingPerTenant returns the maximum number of queued requests of a
+// user in the queue of the frontend; 0 for the frontend's limit.
+func (o *Overrides) MaxOutstandingPerTenant(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxOutstanding
+}
+
+// MaxResponseSize returns the maximum size of the response of a query of a
+// user returned by the frontend.
+func (o *Overrides) MaxResponseSize(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).MaxResponseSize
+}
+
+// AutoStepMaxPoints returns the number of points per series of the range
+// queries of a user above which the frontend raises their step.
+func (o *Overrides) AutoStepMaxPoints(userID string) int {
+	return o.overridesManager.GetLimits(userID).(*Limits).AutoStepMaxPoints
+}
+
 // EnforceMetricName whether to enforce the presence of a metric name.
 func (o *Overrides) EnforceMetricName(userID string) bool {
 	return o.overridesManager.GetLimits(userID).(*Limits).EnforceMetricName
@@ -241,26 +688,67 @@ func (o *Overrides) MinChunkLength(userID string) int {
 // it doesn't know its definition to initialize it.
 // We could have used yamlv3.Node for this but there is no way to enforce strict decoding due to a bug in it
 // TODO: Use yamlv3.Node to move this to OverridesManager after https://github.com/go-yaml/yaml/issues/460 is fixed
-func loadOverrides(filename string) (map[string]interface{}, error) {
+// The multi KV config of the file, if any, is returned too.
+func loadOverrides(filename string) (map[string]interface{}, *kvconfig.MultiRuntimeConfig, error) {
 	f, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer f.Close()
 
 	var overrides struct {
-		Overrides map[string]*Limits `yaml:"overrides"`
+		Overrides     map[string]*Limits           `yaml:"overrides"`
+		MultiKVConfig *kvconfig.MultiRuntimeConfig `yaml:"multi_kv_config"`
 	}
 
 	decoder := yaml.NewDecoder(f)
 	decoder.SetStrict(true)
 	if err := decoder.Decode(&overrides); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	overridesAsInterface := map[string]interface{}{}
 	for userID := range overrides.Overrides {
+		if enc := overrides.Overrides[userID].ChunkEncoding; enc != "" {
+			var e encoding.Encoding
+			if err := e.Set(enc); err != nil {
+				return nil, nil, fmt.Errorf("invalid chunk_encoding for user %s: %v", userID, err)
+			}
+		}
+		for _, selector := range overrides.Overrides[userID].EphemeralSeriesSelectors {
+			if _, err := promql.ParseMetricSelector(selector); err != nil {
+				return nil, nil, fmt.Errorf("invalid ephemeral_series_selectors for user %s: %v", userID, err)
+			}
+		}
+		for _, u := range overrides.Overrides[userID].RemoteReadURLs {
+			if _, err := url.Parse(u); err != nil {
+				return nil, nil, fmt.Errorf("invalid remote_read_urls for user %s: %v", userID, err)
+			}
+		}
+		if err := overrides.Overrides[userID].Validate(); err != nil {
+			return nil, nil, fmt.Errorf("invalid limits for user %s: %v", userID, err)
+		}
+		if overrides.Overrides[userID].RetentionPeriod < 0 {
+			return nil, nil, fmt.Errorf("invalid retention_period for user %s: %s, must not be negative", userID, overrides.Overrides[userID].RetentionPeriod)
+		}
+		if overrides.Overrides[userID].CompactorBlocksRetentionPeriod < 0 {
+			return nil, nil, fmt.Errorf("invalid compactor_blocks_retention_period for user %s: %s, must not be negative", userID, overrides.Overrides[userID].CompactorBlocksRetentionPeriod)
+		}
+		if ranges := overrides.Overrides[userID].CompactorBlockRanges; len(ranges) > 0 {
+			if ranges[0] <= 0 {
+				return nil, nil, fmt.Errorf("invalid compactor_block_ranges for user %s: %s, must be positive", userID, ranges[0])
+			}
+			for i := 1; i < len(ranges); i++ {
+				if ranges[i]%ranges[i-1] != 0 {
+					return nil, nil, fmt.Errorf("invalid compactor_block_ranges for user %s: %s isn't a multiple of %s", userID, ranges[i], ranges[i-1])
+				}
+			}
+		}
+		if overrides.Overrides[userID].CompactorTenantCompactionConcurrency < 1 {
+			return nil, nil, fmt.Errorf("invalid compactor_tenant_compaction_concurrency for user %s: %d, must be at least 1", userID, overrides.Overrides[userID].CompactorTenantCompactionConcurrency)
+		}
 		overridesAsInterface[userID] = overrides.Overrides[userID]
 	}
 
-	return overridesAsInterface, nil
+	return overridesAsInterface, overrides.MultiKVConfig, nil
 }
@@ -8,6 +8,9 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/kvconfig"
 )
 
 type TestLimits struct {
@@ -139,3 +142,78 @@ func TestOverridesManager_GetLimits(t *testing.T) {
 	// Cleaning up
 	overridesManager.Stop()
 }
+
+func TestOverrides_MultiKVConfigs(t *testing.T) {
+	var limits Limits
+	flagext.DefaultValues(&limits)
+	overrides, err := NewOverrides(limits)
+	require.NoError(t, err)
+
+	tempFile, err := ioutil.TempFile("", "test-validation")
+	require.NoError(t, err)
+	defer func() {
+		// Clean up
+		require.NoError(t, tempFile.Close())
+		require.NoError(t, os.Remove(tempFile.Name()))
+	}()
+	overrides.overridesManager.cfg.OverridesLoadPath = tempFile.Name()
+
+	writeFile := func(content string) {
+		require.NoError(t, ioutil.WriteFile(tempFile.Name(), []byte(content), 0600))
+		require.NoError(t, overrides.overridesManager.loadOverrides())
+	}
+
+	// The listeners get the current config, then its changes.
+	configs := overrides.MultiKVConfigs()
+	require.Equal(t, kvconfig.MultiRuntimeConfig{}, <-configs)
+
+	writeFile(`multi_kv_config:
+  primary: memberlist
+  mirror_enabled: true
+overrides:
+  user1:
+    ingestion_rate: 10`)
+	mirroring := true
+	require.Equal(t, kvconfig.MultiRuntimeConfig{PrimaryStore: "memberlist", Mirroring: &mirroring}, <-configs)
+	require.Equal(t, float64(10), overrides.IngestionRate("user1"))
+
+	// Reloading the same config doesn't notify the listeners.
+	writeFile(`multi_kv_config:
+  primary: memberlist
+  mirror_enabled: true`)
+	select {
+	case cfg := <-configs:
+		t.Fatalf("unexpected config %v", cfg)
+	default:
+	}
+
+	// Removing the config reverts to the flags.
+	writeFile(`overrides: {}`)
+	require.Equal(t, kvconfig.MultiRuntimeConfig{}, <-configs)
+
+	// The listeners are closed once stopped.
+	overrides.Stop()
+	_, ok := <-configs
+	require.False(t, ok)
+}
+
+func TestBlockedQueryUnmarshalYAML(t *testing.T) {
+	var blocked []BlockedQuery
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- pattern: "sum(rate(foo[5m]))"
+- pattern: ".*bar.*"
+  regex: true
+`), &blocked))
+	require.Len(t, blocked, 2)
+	require.Nil(t, blocked[0].re)
+
+	// The regex is compiled once, when the limits are loaded.
+	re, err := blocked[1].Regexp()
+	require.NoError(t, err)
+	require.Same(t, blocked[1].re, re)
+	require.True(t, re.MatchString("count(bar)"))
+	require.False(t, re.MatchString("count(foo)\nbar"))
+
+	err = yaml.Unmarshal([]byte(`[{pattern: "(", regex: true}]`), &blocked)
+	require.Error(t, err)
+}
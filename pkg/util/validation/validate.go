@@ -36,6 +36,10 @@ const (
 	// RateLimited is one of the values for the reason to discard samples.
 	// Declared here to avoid duplication in ingester and distributor.
 	RateLimited = "rate_limited"
+
+	// Limits reported in LimitWarnings.
+	SeriesLimitWarning        = "series"
+	IngestionRateLimitWarning = "ingestion_rate"
 )
 
 // DiscardedSamples is a metric of the number of discarded samples, by reason.
@@ -47,8 +51,19 @@ var DiscardedSamples = prometheus.NewCounterVec(
 	[]string{discardReasonLabel, "user"},
 )
 
+// LimitWarnings is a metric of the number of pushes which crossed the warning
+// threshold of a limit, by limit.
+var LimitWarnings = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "cortex_limit_warnings_total",
+		Help: "The total number of push requests which crossed the warning threshold of a limit.",
+	},
+	[]string{"limit", "user"},
+)
+
 func init() {
 	prometheus.MustRegister(DiscardedSamples)
+	prometheus.MustRegister(LimitWarnings)
 }
 
 // SampleValidationConfig helps with getting required config to validate sample.
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"context"
+	"sync"
+)
+
+type warningsKey int
+
+// Warnings collects the warnings of a request from the components it goes
+// through, e.g. about the partial results of a query. It is safe for
+// concurrent use, and a nil *Warnings discards the warnings.
+type Warnings struct {
+	mtx      sync.Mutex
+	warnings []string
+}
+
+// WithWarnings returns a context collecting the warnings of a request.
+func WithWarnings(ctx context.Context) (context.Context, *Warnings) {
+	w := &Warnings{}
+	return context.WithValue(ctx, warningsKey(0), w), w
+}
+
+// WarningsFromContext returns the warnings of a request; nil if they are not
+// collected.
+func WarningsFromContext(ctx context.Context) *Warnings {
+	w, _ := ctx.Value(warningsKey(0)).(*Warnings)
+	return w
+}
+
+// Add a warning.
+func (w *Warnings) Add(warning string) {
+	if w == nil {
+		return
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.warnings = append(w.warnings, warning)
+}
+
+// Take returns the warnings added since the last call.
+func (w *Warnings) Take() []string {
+	if w == nil {
+		return nil
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	warnings := w.warnings
+	w.warnings = nil
+	return warnings
+}